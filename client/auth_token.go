@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/share"
+	"golang.org/x/sync/singleflight"
+)
+
+type authOverrideKey struct{}
+
+// WithAuth returns a context whose calls send token instead of the token of XClient.Auth or Option.AuthFunc,
+// for example the credential of the end user which a proxy forwards. XClients keep it when they retry or fail over.
+// It is not set in the metadata of ctx, so other calls sharing the metadata are not affected.
+func WithAuth(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, authOverrideKey{}, token)
+}
+
+// authTokens caches tokens of Option.AuthFunc by methods, and collapses concurrent fetches of tokens
+// of the same method, so expired tokens don't cause storms of requests to identity providers.
+type authTokens struct {
+	fn func(ctx context.Context, servicePath, serviceMethod string) (string, time.Duration, error)
+
+	mu     sync.Mutex
+	tokens map[string]authToken
+	group  singleflight.Group
+}
+
+type authToken struct {
+	token   string
+	fetched time.Time
+	expires time.Time
+}
+
+func newAuthTokens(fn func(ctx context.Context, servicePath, serviceMethod string) (string, time.Duration, error)) *authTokens {
+	return &authTokens{fn: fn, tokens: make(map[string]authToken)}
+}
+
+// get returns the cached token of serviceMethod of servicePath, or fetches it if it is not cached or has expired.
+func (a *authTokens) get(ctx context.Context, servicePath, serviceMethod string) (string, error) {
+	key := servicePath + "." + serviceMethod
+	now := time.Now()
+	a.mu.Lock()
+	t, ok := a.tokens[key]
+	a.mu.Unlock()
+	if ok && now.Before(t.expires) {
+		return t.token, nil
+	}
+
+	v, err, _ := a.group.Do(key, func() (interface{}, error) {
+		fetched := time.Now()
+		token, ttl, err := a.fn(ctx, servicePath, serviceMethod)
+		if err != nil {
+			return "", err
+		}
+		if ttl > 0 {
+			a.mu.Lock()
+			a.tokens[key] = authToken{token: token, fetched: fetched, expires: fetched.Add(ttl)}
+			a.mu.Unlock()
+		}
+		return token, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// invalidate drops the cached token of serviceMethod of servicePath if it was fetched before since,
+// the time when a call rejected by servers was started, so the token is fetched again.
+// Tokens fetched later are kept, so concurrent calls rejected with the same token refresh it once.
+func (a *authTokens) invalidate(servicePath, serviceMethod string, since time.Time) {
+	key := servicePath + "." + serviceMethod
+	a.mu.Lock()
+	if t, ok := a.tokens[key]; ok && t.fetched.Before(since) {
+		delete(a.tokens, key)
+	}
+	a.mu.Unlock()
+}
+
+// authTokens returns the tokens of Option.AuthFunc of the client.
+func (client *Client) authTokens() *authTokens {
+	client.tokensOnce.Do(func() {
+		client.tokens = newAuthTokens(client.option.AuthFunc)
+	})
+	return client.tokens
+}
+
+// authToken returns the token of the call of serviceMethod of servicePath with metadata meta, which is the token of WithAuth,
+// or the token of Option.AuthFunc unless meta has a token such as the one set by XClient. ok is false if there is none.
+func (client *Client) authToken(ctx context.Context, servicePath, serviceMethod string, meta map[string]string) (token string, ok bool, err error) {
+	if token, ok := ctx.Value(authOverrideKey{}).(string); ok {
+		return token, true, nil
+	}
+	if client.option.AuthFunc == nil {
+		return "", false, nil
+	}
+	if _, ok := meta[share.AuthKey]; ok {
+		return "", false, nil
+	}
+	token, err = client.authTokens().get(ctx, servicePath, serviceMethod)
+	if err != nil {
+		return "", false, markError(err, ErrAuthToken)
+	}
+	return token, true, nil
+}
+
+// setAuthToken sets the token of the call in its metadata, which may belong to the caller, so it is copied.
+func (client *Client) setAuthToken(ctx context.Context, call *Call) error {
+	token, ok, err := client.authToken(ctx, call.ServicePath, call.ServiceMethod, call.Metadata)
+	if !ok {
+		return err
+	}
+	meta := make(map[string]string, len(call.Metadata)+1)
+	for k, v := range call.Metadata {
+		meta[k] = v
+	}
+	meta[share.AuthKey] = token
+	call.Metadata = meta
+	return nil
+}
+
+// setAuth sets the token of WithAuth, the token of Option.AuthFunc, or the token of Auth if AuthFunc is not set,
+// in metadata of ctx for calls of serviceMethod of servicePath. Metadata of ctx is copied, since it may be shared by other calls.
+func (c *xClient) setAuth(ctx context.Context, servicePath, serviceMethod string) (context.Context, error) {
+	auth, overridden := ctx.Value(authOverrideKey{}).(string)
+	if !overridden {
+		auth = c.auth
+		if c.tokens != nil {
+			token, err := c.tokens.get(ctx, servicePath, serviceMethod)
+			if err != nil {
+				return ctx, markError(err, ErrAuthToken)
+			}
+			auth = token
+		}
+	}
+	if auth == "" {
+		return ctx, nil
+	}
+
+	metadata, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	m := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		m[k] = v
+	}
+	m[share.AuthKey] = auth
+	return context.WithValue(ctx, share.ReqMetaDataKey, m), nil
+}
+
+// retryAuth drops the cached token of serviceMethod of servicePath if the call of ctx started at start has failed with err,
+// an Unauthenticated error of servers, and tells whether the call should be retried by Option.RetryOnAuthFailure.
+// Calls with tokens of WithAuth are not retried, since their tokens don't change.
+func (c *xClient) retryAuth(ctx context.Context, servicePath, serviceMethod string, start time.Time, err error) bool {
+	if c.tokens == nil || rerrors.CodeOf(err) != rerrors.Unauthenticated {
+		return false
+	}
+	if _, overridden := ctx.Value(authOverrideKey{}).(string); overridden {
+		return false
+	}
+	c.tokens.invalidate(servicePath, serviceMethod, start)
+	return c.getOption().RetryOnAuthFailure
+}
+
+// closeUnauthenticated closes client if its call has failed with err, an Unauthenticated error of the server,
+// since servers close connections of such calls, so calls retried with new tokens don't use the connection.
+func (c *xClient) closeUnauthenticated(client RPCClient, err error) {
+	if c.tokens != nil && rerrors.CodeOf(err) == rerrors.Unauthenticated {
+		client.Close()
+	}
+}
+
+// callAuth calls c.call, and calls it again once by Option.RetryOnAuthFailure if servers reject the token.
+func (c *xClient) callAuth(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	start := time.Now()
+	err := c.call(ctx, serviceMethod, args, reply)
+	if c.retryAuth(ctx, c.servicePath, serviceMethod, start, err) {
+		err = c.call(ctx, serviceMethod, args, reply)
+	}
+	return err
+}
@@ -0,0 +1,253 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// tokenProvider issues tokens "token-1", "token-2"... which servers of startAuthServer accept if they are the latest.
+type tokenProvider struct {
+	fetches int32
+	delay   time.Duration
+	err     error
+}
+
+func (p *tokenProvider) token(ctx context.Context, servicePath, serviceMethod string) (string, time.Duration, error) {
+	n := atomic.AddInt32(&p.fetches, 1)
+	time.Sleep(p.delay)
+	if p.err != nil {
+		return "", 0, p.err
+	}
+	return "token-" + strconv.Itoa(int(n)), time.Hour, nil
+}
+
+func startAuthServer(t *testing.T, valid *atomic.Value) string {
+	s := server.NewServer()
+	s.AuthFunc = func(ctx context.Context, req *protocol.Message, token string) error {
+		if token != valid.Load().(string) {
+			return rerrors.New(rerrors.Unauthenticated, "invalid token")
+		}
+		return nil
+	}
+	s.RegisterName("Stats", new(statsService), "")
+	return startServer(t, s)
+}
+
+func TestAuthFunc(t *testing.T) {
+	var valid atomic.Value
+	valid.Store("token-1")
+	addr := startAuthServer(t, &valid)
+
+	p := &tokenProvider{delay: 50 * time.Millisecond}
+	option := DefaultOption
+	option.AuthFunc = p.token
+	d, err := NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xclient := NewXClient("Stats", Failfast, RandomSelect, d, option)
+	defer xclient.Close()
+	xclient.Auth("static") // replaced by tokens of AuthFunc
+
+	// concurrent fetches are collapsed, and tokens are cached
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&p.fetches); n != 1 {
+		t.Fatalf("expect the token to be fetched once but got %d", n)
+	}
+
+	// rejected tokens are fetched again by the next call
+	valid.Store("token-2")
+	err = xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{})
+	if rerrors.CodeOf(err) != rerrors.Unauthenticated {
+		t.Fatalf("expect an Unauthenticated error but got %v", err)
+	}
+	if err = xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&p.fetches); n != 2 {
+		t.Fatalf("expect the token to be fetched again but got %d", n)
+	}
+}
+
+func TestAuthFuncRetryOnAuthFailure(t *testing.T) {
+	var valid atomic.Value
+	valid.Store("token-1")
+	addr := startAuthServer(t, &valid)
+
+	p := &tokenProvider{}
+	option := DefaultOption
+	option.AuthFunc = p.token
+	option.RetryOnAuthFailure = true
+	d, err := NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xclient := NewXClient("Stats", Failfast, RandomSelect, d, option)
+	defer xclient.Close()
+
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+		t.Fatal(err)
+	}
+	valid.Store("token-2")
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+		t.Fatalf("expect the call to be retried with a new token but got %v", err)
+	}
+	if reply.C != 6 {
+		t.Fatalf("expect 6 but got %d", reply.C)
+	}
+
+	// retried once only
+	valid.Store("never")
+	err = xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, reply)
+	if rerrors.CodeOf(err) != rerrors.Unauthenticated {
+		t.Fatalf("expect an Unauthenticated error but got %v", err)
+	}
+	if n := atomic.LoadInt32(&p.fetches); n != 3 {
+		t.Fatalf("expect 3 fetches but got %d", n)
+	}
+}
+
+func TestAuthFuncErrors(t *testing.T) {
+	var valid atomic.Value
+	valid.Store("token-1")
+	addr := startAuthServer(t, &valid)
+
+	errIdP := errors.New("identity provider is down")
+	p := &tokenProvider{err: errIdP}
+	option := DefaultOption
+	option.AuthFunc = p.token
+	client := NewClient(option)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	err := client.Call(context.Background(), "Stats", "Mul", &Args{A: 2, B: 3}, &Reply{})
+	if !errors.Is(err, ErrAuthToken) || !errors.Is(err, errIdP) {
+		t.Fatalf("expect ErrAuthToken but got %v", err)
+	}
+
+	// tokens of clients
+	p.err = nil
+	valid.Store("token-2")
+	if err = client.Call(context.Background(), "Stats", "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// authEchoService replies with the token of calls, 0 for "service" and n for "user-n",
+// plus 1000 if the metadata of calls has the tenant.
+type authEchoService struct{}
+
+func (authEchoService) Whoami(ctx context.Context, args *Args, reply *Reply) error {
+	meta := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	if token := meta[share.AuthKey]; token != "service" {
+		n, err := strconv.Atoi(strings.TrimPrefix(token, "user-"))
+		if err != nil {
+			return err
+		}
+		reply.C = n
+	}
+	if meta["tenant"] == "acme" {
+		reply.C += 1000
+	}
+	return nil
+}
+
+func TestWithAuth(t *testing.T) {
+	s := server.NewServer()
+	s.AuthFunc = func(ctx context.Context, req *protocol.Message, token string) error {
+		if token != "service" && !strings.HasPrefix(token, "user-") {
+			return rerrors.New(rerrors.Unauthenticated, "invalid token")
+		}
+		return nil
+	}
+	s.RegisterName("Auth", authEchoService{}, "")
+	addr := startServer(t, s)
+
+	// overrides only
+	client := NewClient(DefaultOption)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	reply := &Reply{}
+	if err := client.Call(WithAuth(context.Background(), "user-7"), "Auth", "Whoami", &Args{}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 7 {
+		t.Fatalf("expect the token of user-7 but got %d", reply.C)
+	}
+
+	d, err := NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xclient := NewXClient("Auth", Failtry, RandomSelect, d, DefaultOption)
+	defer xclient.Close()
+	xclient.Auth("service")
+
+	// defaults and overrides of concurrent calls on one connection, sharing metadata
+	meta := map[string]string{"tenant": "acme"}
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, meta)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			callCtx, expected := ctx, 1000
+			if i%2 == 1 {
+				callCtx, expected = WithAuth(ctx, "user-"+strconv.Itoa(i)), 1000+i
+			}
+			reply := &Reply{}
+			if err := xclient.Call(callCtx, "Whoami", &Args{}, reply); err != nil {
+				t.Error(err)
+				return
+			}
+			if reply.C != expected {
+				t.Errorf("expect %d but got %d", expected, reply.C)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(meta) != 1 {
+		t.Fatalf("expect the metadata of callers not to be changed but got %v", meta)
+	}
+
+	// overrides take precedence over tokens of AuthFunc too
+	option := DefaultOption
+	option.AuthFunc = func(ctx context.Context, servicePath, serviceMethod string) (string, time.Duration, error) {
+		return "service", time.Hour, nil
+	}
+	xclient = NewXClient("Auth", Failtry, RandomSelect, d, option)
+	defer xclient.Close()
+	reply = &Reply{}
+	if err := xclient.Call(WithAuth(context.Background(), "user-3"), "Whoami", &Args{}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 3 {
+		t.Fatalf("expect the token of user-3 but got %d", reply.C)
+	}
+}
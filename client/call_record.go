@@ -0,0 +1,86 @@
+package client
+
+import (
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// CallRecord is a completed call passed to CallRecordPlugin.
+// Payloads are serialized but not compressed, and they are copies which belong to the plugin.
+type CallRecord struct {
+	ServicePath   string
+	ServiceMethod string
+	SerializeType protocol.SerializeType
+	Oneway        bool
+	// Metadata and Payload are the metadata and the payload of the request.
+	Metadata map[string]string
+	Payload  []byte
+
+	// Responded reports whether a response is received, and ResMetadata and Reply are the metadata and the payload of it.
+	Responded   bool
+	ResMetadata map[string]string
+	Reply       []byte
+
+	// Start is when the call is sent, and Latency is the time until it completes with Error.
+	Start   time.Time
+	Latency time.Duration
+	Error   error
+
+	plugins PluginContainer
+}
+
+// newCallRecord returns the record of the call of req if plugins record calls, or nil.
+func newCallRecord(plugins PluginContainer, req *protocol.Message) *CallRecord {
+	recorded := false
+	for _, p := range plugins.All() {
+		if _, ok := p.(CallRecordPlugin); ok {
+			recorded = true
+			break
+		}
+	}
+	if !recorded {
+		return nil
+	}
+
+	return &CallRecord{
+		ServicePath:   req.ServicePath,
+		ServiceMethod: req.ServiceMethod,
+		SerializeType: req.SerializeType(),
+		Oneway:        req.IsOneway(),
+		Metadata:      copyMetadata(req.Metadata),
+		Payload:       append([]byte(nil), req.Payload...),
+		Start:         time.Now(),
+		plugins:       plugins,
+	}
+}
+
+// recordResponse records res, the response of call, which is freed after the call is done.
+func (call *Call) recordResponse(res *protocol.Message) {
+	if call.record == nil {
+		return
+	}
+	call.record.Responded = true
+	call.record.ResMetadata = copyMetadata(res.Metadata)
+	call.record.Reply = append([]byte(nil), res.Payload...)
+}
+
+// recordDone passes the record of the completed call to plugins.
+func (call *Call) recordDone() {
+	record := call.record
+	call.record = nil
+	record.Latency = time.Since(record.Start)
+	record.Error = call.Error
+	record.plugins.DoRecordCall(record)
+}
+
+func copyMetadata(meta map[string]string) map[string]string {
+	if meta == nil {
+		return nil
+	}
+	m := make(map[string]string, len(meta))
+	for k, v := range meta {
+		m[k] = v
+	}
+	return m
+}
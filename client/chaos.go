@@ -0,0 +1,56 @@
+package client
+
+import (
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// ChaosPlugin injects faults of share.ChaosRule into calls of the client, so that timeouts, retries
+// and circuit breakers of callers can be tested against slow, failing and lost requests.
+// Latency delays requests before they are sent, errors fail calls with *errors.Error of the errors package,
+// drops are never sent so calls time out by their contexts, and corrupted responses fail to decode.
+//
+// Errors carry share.ChaosKey in their details, and corrupted responses carry it in their metadata,
+// so injected faults can be told from real ones. Rules are updated by SetRules, faults are stopped
+// by SetEnabled or share.SetChaosEnabled, and they are reproducible if it is seeded by Seed.
+type ChaosPlugin struct {
+	*share.Chaos
+}
+
+// NewChaosPlugin creates a ChaosPlugin of rules.
+func NewChaosPlugin(rules ...share.ChaosRule) *ChaosPlugin {
+	return &ChaosPlugin{Chaos: share.NewChaos(rules...)}
+}
+
+// ClientBeforeEncode injects faults into requests.
+func (p *ChaosPlugin) ClientBeforeEncode(req *protocol.Message) error {
+	if req.IsHeartbeat() {
+		return nil
+	}
+	f := p.Inject(req.ServicePath, req.ServiceMethod, false)
+	if f.Latency > 0 {
+		time.Sleep(f.Latency)
+	}
+	if f.Drop {
+		return ErrDropRequest
+	}
+	return f.Err
+}
+
+// ClientAfterDecode corrupts payloads of responses.
+func (p *ChaosPlugin) ClientAfterDecode(res *protocol.Message) error {
+	if res.IsHeartbeat() || res.MessageType() != protocol.Response {
+		return nil
+	}
+	if !p.Inject(res.ServicePath, res.ServiceMethod, true).Corrupt {
+		return nil
+	}
+	share.CorruptPayload(res.Payload)
+	if res.Metadata == nil {
+		res.Metadata = make(map[string]string)
+	}
+	res.Metadata[share.ChaosKey] = share.ChaosCorrupt
+	return nil
+}
@@ -6,15 +6,20 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	circuit "github.com/rubyist/circuitbreaker"
+	"github.com/smallnest/rpcx/codec"
+	rerrors "github.com/smallnest/rpcx/errors"
 	"github.com/smallnest/rpcx/log"
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/share"
@@ -36,12 +41,106 @@ const (
 )
 
 // ServiceError is an error from server.
+// Errors with codes or details set by the server are CodedServiceErrors, which match ServiceError by errors.As.
 type ServiceError string
 
 func (e ServiceError) Error() string {
 	return string(e)
 }
 
+// CodedServiceError is an error from server with the code and details set by the server.
+// It unwraps to the ServiceError of its message, and matches sentinel errors of the errors package with the same code by errors.Is.
+type CodedServiceError struct {
+	Message string
+
+	code    rerrors.Code
+	details map[string]string
+	node    string
+}
+
+// NewServiceError creates a CodedServiceError with code and details.
+func NewServiceError(code rerrors.Code, msg string, details map[string]string) *CodedServiceError {
+	return &CodedServiceError{Message: msg, code: code, details: details}
+}
+
+// serviceErrorFromMetadata decodes the error encoded in metadata of a response by the server,
+// a ServiceError unless the server has set its code or details.
+func serviceErrorFromMetadata(meta map[string]string) error {
+	var e *CodedServiceError
+	if code, err := strconv.Atoi(meta[protocol.ServiceErrorCode]); err == nil {
+		e = NewServiceError(rerrors.Code(code), meta[protocol.ServiceError], nil)
+	}
+	for k, v := range meta {
+		if strings.HasPrefix(k, protocol.ServiceErrorDetailPrefix) {
+			if e == nil {
+				e = NewServiceError(rerrors.Unknown, meta[protocol.ServiceError], nil)
+			}
+			if e.details == nil {
+				e.details = make(map[string]string)
+			}
+			e.details[strings.TrimPrefix(k, protocol.ServiceErrorDetailPrefix)] = v
+		}
+	}
+	if e == nil {
+		return ServiceError(meta[protocol.ServiceError])
+	}
+	return e
+}
+
+func (e *CodedServiceError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the ServiceError of the message, so that errors.As matches ServiceError.
+func (e *CodedServiceError) Unwrap() error {
+	return ServiceError(e.Message)
+}
+
+// Code returns the code of the error, which is Unknown if the server has not set it.
+func (e *CodedServiceError) Code() int {
+	if e.code == rerrors.OK {
+		return int(rerrors.Unknown)
+	}
+	return int(e.code)
+}
+
+// Details returns the details of the error.
+func (e *CodedServiceError) Details() map[string]string {
+	return e.details
+}
+
+// Node returns the address of the server which returned the error, which is set by XClient.
+func (e *CodedServiceError) Node() string {
+	return e.node
+}
+
+// Is reports whether target is an error of the errors package with the same code.
+func (e *CodedServiceError) Is(target error) bool {
+	t, ok := target.(*rerrors.Error)
+	return ok && int(t.Code) == e.Code()
+}
+
+// isServiceError reports whether err is an error from server, a ServiceError or a CodedServiceError.
+func isServiceError(err error) bool {
+	var se ServiceError
+	return errors.As(err, &se)
+}
+
+// isFailure reports whether err means the server is failing, which counts as a failure of circuit breakers.
+// Service errors are failures only if they are Unavailable, other codes mean the server works.
+func isFailure(err error) bool {
+	if err == nil || contextCanceled(err) || errors.Is(err, ErrClientRateLimited) {
+		return false
+	}
+	if e, ok := err.(*CodedServiceError); ok {
+		return rerrors.Code(e.Code()) == rerrors.Unavailable
+	}
+	return !isServiceError(err)
+}
+
+// DefaultNegotiateTimeout is a NegotiateTimeout for servers which negotiate the protocol.
+const DefaultNegotiateTimeout = time.Second
+
 // DefaultOption is a common option configuration for client.
 var DefaultOption = Option{
 	Retries:             3,
@@ -52,6 +151,7 @@ var DefaultOption = Option{
 	BackupLatency:       10 * time.Millisecond,
 	MaxWaitForHeartbeat: 30 * time.Second,
 	TCPKeepAlivePeriod:  time.Minute,
+	UDPRetransmit:       UDPRetransmit{Retries: 2, Timeout: 200 * time.Millisecond},
 }
 
 // Breaker is a CircuitBreaker interface.
@@ -62,6 +162,9 @@ type Breaker interface {
 	Ready() bool
 }
 
+// logger is the structured logger of the "client" component.
+var logger = log.NewComponent("client")
+
 // CircuitBreaker is a default circuit breaker (RateBreaker(0.95, 100)).
 var CircuitBreaker Breaker = circuit.NewRateBreaker(0.95, 100)
 
@@ -80,6 +183,30 @@ const (
 
 type seqKey struct{}
 
+type serializeTypeKey struct{}
+
+// WithSerializeType returns a context whose calls encode args and decode replies with serialize type t
+// instead of the SerializeType of clients. XClients keep it when they retry or fail over.
+// Calls fail with an error wrapping ErrUnsupportedCodec before they are sent if t has no codec, see share.CodecOf.
+func WithSerializeType(ctx context.Context, t protocol.SerializeType) context.Context {
+	return context.WithValue(ctx, serializeTypeKey{}, t)
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose calls have priority p, by which servers WithPriorityScheduling
+// dispatch requests queued when they are saturated. Calls have protocol.PriorityNormal without it.
+func WithPriority(ctx context.Context, p protocol.Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+type jsonOptionsKey struct{}
+
+// WithJSONOptions returns a context whose calls decode JSON replies by opts instead of codec.DefaultJSONOptions.
+func WithJSONOptions(ctx context.Context, opts codec.JSONOptions) context.Context {
+	return context.WithValue(ctx, jsonOptionsKey{}, opts)
+}
+
 // RPCClient is interface that defines one client to call one server.
 type RPCClient interface {
 	Connect(network, address string) error
@@ -100,6 +227,8 @@ type RPCClient interface {
 
 // Client represents a RPC client.
 type Client struct {
+	stats clientStats // first for the alignment of its atomics
+
 	option Option
 
 	Conn net.Conn
@@ -116,6 +245,26 @@ type Client struct {
 	Plugins PluginContainer
 
 	ServerMessageChan chan<- *protocol.Message
+
+	// set when the server accepts requests compressed with new compress types such as zstd
+	compressAccepted int32
+	// set when the server reassembles chunked requests
+	chunkAccepted int32
+
+	// the protocol negotiated on connect, see Option.NegotiateTimeout
+	negotiated   bool
+	version      int
+	capabilities protocol.Capabilities
+
+	// tokens of Option.AuthFunc, created by the first call
+	tokensOnce sync.Once
+	tokens     *authTokens
+
+	// called with load reports of responses, set by XClient for LoadReceiver selectors
+	loadReported func(report protocol.LoadReport)
+
+	// the writer of Option.SendQueueSize, or nil
+	sendQueue *sendQueue
 }
 
 // NewClient returns a new Client with the option.
@@ -146,6 +295,9 @@ type Option struct {
 
 	// TLSConfig for tcp and quic
 	TLSConfig *tls.Config
+	// SessionEncryption encrypts connections by session keys exchanged on connect, for servers with
+	// server.WithSessionEncryption. Connect fails with *protocol.HandshakeError if the exchange fails.
+	SessionEncryption *protocol.SessionConfig
 	// kcp.BlockCrypt
 	Block interface{}
 	// RPCPath for http connection
@@ -162,7 +314,26 @@ type Option struct {
 	GenBreaker func() Breaker
 
 	SerializeType protocol.SerializeType
-	CompressType  protocol.CompressType
+	// CompressType compresses requests larger than 1024 bytes and is accepted for responses of servers with WithAutoCompress.
+	// Requests are compressed with protocol.Gzip instead of protocol.Zstd or protocol.Snappy until the server tells it accepts them.
+	CompressType protocol.CompressType
+	// Checksum adds CRC32C checksums of payloads to requests, so that servers detect payloads corrupted by lossy transports
+	// such as KCP without FEC. Servers of old versions ignore them. Responses of servers supporting checksums have checksums too,
+	// and calls whose responses fail their checksums fail with protocol.ErrChecksumMismatch.
+	Checksum bool
+	// ChunkThreshold splits requests larger than ChunkThreshold bytes when encoded into chunk frames of ChunkThreshold bytes
+	// once the server tells it reassembles them, so that large requests don't hold up heartbeats and other calls
+	// on the connection. Chunked responses and server messages are reassembled too. Zero disables chunking.
+	ChunkThreshold int
+	// MaxChunkedMessageSize limits the size of messages reassembled from chunk frames.
+	// Zero means protocol.DefaultMaxChunkedMessageSize.
+	MaxChunkedMessageSize int
+	// ChunkTimeout is the time to receive all chunk frames of a message. Zero means protocol.DefaultChunkTimeout.
+	ChunkTimeout time.Duration
+	// CompactMetadata encodes metadata of requests in the compact format, which saves bytes of well-known keys
+	// such as the request ID, auth and trace context, if the server negotiates it on connect, see NegotiateTimeout.
+	// Responses to such requests have compact metadata too.
+	CompactMetadata bool
 
 	// send heartbeat message to service and check responses
 	Heartbeat bool
@@ -172,6 +343,67 @@ type Option struct {
 
 	// TCPKeepAlive, if it is zero we don't set keepalive
 	TCPKeepAlivePeriod time.Duration
+
+	// WebsocketCompression negotiates permessage-deflate with ws and wss servers
+	WebsocketCompression bool
+
+	// UDPMaxDatagramSize is the max size of datagrams of requests over udp. Calls whose encoded requests are larger
+	// fail with ErrDatagramTooLarge. Zero means DefaultUDPMaxDatagramSize.
+	UDPMaxDatagramSize int
+	// UDPRetransmit is the retransmit policy of calls over udp, which can be set per call by WithUDPRetransmit.
+	UDPRetransmit UDPRetransmit
+
+	// SlowCallThreshold logs calls of XClient which take longer, with their selection traces of WithSelectionTrace.
+	// Zero disables logs of slow calls.
+	SlowCallThreshold time.Duration
+
+	// NegotiateTimeout is the time to wait for the server to answer the negotiation of the protocol on connect,
+	// which Connect waits for. Servers which don't answer in time, and servers of old versions, are treated as legacy servers,
+	// and extensions such as chunking, checksums and new compress types are not used with them.
+	// Zero, the default, disables the negotiation, then extensions are used once the server tells it supports them in responses,
+	// and compact metadata is not used. Set it, such as to DefaultNegotiateTimeout, only for servers which negotiate.
+	NegotiateTimeout time.Duration
+
+	// PoolContexts pools contexts of calls to reduce allocations. Contexts are freed after calls are sent, or after
+	// calls of XClient return, so plugins keeping ctx after they return must keep share.Detach(ctx) instead.
+	PoolContexts bool
+
+	// AuthFunc returns the token of calls of serviceMethod of servicePath, which is sent like the token of XClient.Auth
+	// and replaces it, for tokens which expire. Tokens are cached for the ttl returned with them, or fetched for every call
+	// if it is not positive, and concurrent fetches of tokens of the same method are collapsed into one.
+	// Calls fail with errors matching ErrAuthToken if tokens can't be fetched.
+	AuthFunc func(ctx context.Context, servicePath, serviceMethod string) (token string, ttl time.Duration, err error)
+	// RetryOnAuthFailure retries calls of XClient failing with Unauthenticated errors of servers once,
+	// with tokens fetched again by AuthFunc. Cached tokens are dropped on such errors anyway.
+	RetryOnAuthFailure bool
+
+	// CachedClientIdleTimeout closes clients of nodes of XClient without calls for the duration, which are connected
+	// again on the next calls to their nodes, so idle connections don't pile up. The client of the only connected node
+	// which is still discovered is kept. Zero keeps clients until their nodes are removed or they are broken.
+	CachedClientIdleTimeout time.Duration
+
+	// RateLimiter limits the rate and the concurrency of calls to each service or node, which is shared by clients
+	// of the same RateLimiter. Calls over the limits wait or fail with ErrClientRateLimited by its RateLimit.Mode.
+	RateLimiter *RateLimiter
+
+	// SendQueueSize makes requests written on the connection by a writer goroutine from a queue of SendQueueSize requests,
+	// so calls don't wait for each other behind writes blocked by slow servers or networks. Calls wait for room in the full
+	// queue until their deadlines, and those whose deadlines expire while their requests are being written fail with
+	// TimeoutErrors whose Sent is true. Heartbeats jump the queue. Zero makes calls write their requests by themselves.
+	SendQueueSize int
+	// FailFastOnFullQueue fails calls with ErrSendQueueFull at once if the queue of SendQueueSize is full.
+	FailFastOnFullQueue bool
+
+	// HopReserve is kept from the budgets of calls made with contexts of handlers whose deadlines are propagated
+	// from their callers, see share.RemainingBudget, so the deadlines of the calls are earlier than the ones of
+	// the handlers by HopReserve, which is left for the handlers to answer. Deadlines of other calls are kept.
+	HopReserve time.Duration
+	// MaxHopBudgetShare caps the budgets of such calls to the share of the remaining budgets, such as 0.5,
+	// so a single hop can't use up the budget of the chain. Zero doesn't cap them.
+	MaxHopBudgetShare float64
+	// MinHopBudget fails such calls with TimeoutErrors matching ErrBudgetExhausted without sending them,
+	// if their budgets are less than MinHopBudget, since they would time out anyway.
+	MinHopBudget time.Duration
 }
 
 // Call represents an active RPC.
@@ -185,9 +417,43 @@ type Call struct {
 	Error         error       // After completion, the error status.
 	Done          chan *Call  // Strobes when call is complete.
 	Raw           bool        // raw message or not
+
+	jsonOptions *codec.JSONOptions // options of decoding the JSON reply set by WithJSONOptions
+	response    *protocol.Message  // the response of the raw call
+	stats       *clientStats       // stats of the client which sends the call
+	start       time.Time          // when the call is sent
+	written     time.Time          // when the request is written, or zero if it is not
+	record      *CallRecord        // the record of the call for CallRecordPlugin, or nil if it is not recorded
+	release     func()             // releases the call from Option.RateLimiter, or nil
+	cancel      func()             // cancels the context of the budget of Option.HopReserve, or nil
+	active      bool               // counted in active calls of the client until it is done
+}
+
+// decodeReply decodes data, the payload of res, into the reply of call.
+func (call *Call) decodeReply(c codec.Codec, res *protocol.Message, data []byte) error {
+	if call.jsonOptions != nil {
+		return share.DecodePayloadJSON(c, res, data, call.Reply, *call.jsonOptions)
+	}
+	return share.DecodePayload(c, res, data, call.Reply)
 }
 
 func (call *Call) done() {
+	if call.stats != nil {
+		call.stats.callDone(call.Error)
+	}
+	if call.record != nil {
+		call.recordDone()
+	}
+	if call.release != nil {
+		call.release()
+	}
+	if call.cancel != nil {
+		call.cancel()
+	}
+	if call.active {
+		call.active = false
+		atomic.AddInt64(&call.stats.active, -1)
+	}
 	select {
 	case call.Done <- call:
 		// ok
@@ -198,13 +464,25 @@ func (call *Call) done() {
 }
 
 // RegisterServerMessageChan registers the channel that receives server requests.
+// Received messages belong to the receiver, which may return them to the pool by Free after handling them.
 func (client *Client) RegisterServerMessageChan(ch chan<- *protocol.Message) {
+	client.mutex.Lock()
 	client.ServerMessageChan = ch
+	client.mutex.Unlock()
 }
 
 // UnregisterServerMessageChan removes ServerMessageChan.
 func (client *Client) UnregisterServerMessageChan() {
+	client.mutex.Lock()
 	client.ServerMessageChan = nil
+	client.mutex.Unlock()
+}
+
+// serverMessageChan returns ServerMessageChan, which may be unregistered while responses are read, such as by XClient.
+func (client *Client) serverMessageChan() chan<- *protocol.Message {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.ServerMessageChan
 }
 
 // IsClosing client is closing or not.
@@ -233,9 +511,19 @@ func (client *Client) Go(ctx context.Context, servicePath, serviceMethod string,
 	if meta != nil { // copy meta in context to meta in requests
 		call.Metadata = meta.(map[string]string)
 	}
+	var budgetErr error
+	if servicePath != "" || serviceMethod != "" { // not heartbeats
+		var cancel context.CancelFunc
+		ctx, cancel, budgetErr = client.applyHopBudget(ctx)
+		call.cancel = cancel
+		call.Metadata = share.InjectPropagated(ctx, call.Metadata)
+	}
 
 	if _, ok := ctx.(*share.Context); !ok {
-		ctx = share.NewContext(ctx)
+		// calls don't keep ctx after they are sent, so the context is freed then if it is pooled
+		sctx := getContext(ctx, client.option.PoolContexts)
+		defer freeContext(sctx, client.option.PoolContexts)
+		ctx = sctx
 	}
 
 	// TODO: should implement as plugin
@@ -256,6 +544,30 @@ func (client *Client) Go(ctx context.Context, servicePath, serviceMethod string,
 		}
 	}
 	call.Done = done
+	call.stats = &client.stats
+
+	if servicePath != "" || serviceMethod != "" { // not heartbeats, which don't keep clients from being idle
+		call.active = true
+		atomic.AddInt64(&client.stats.active, 1)
+		if budgetErr != nil {
+			call.Error = budgetErr
+			call.done()
+			return call
+		}
+		if err := client.setAuthToken(ctx, call); err != nil {
+			call.Error = err
+			call.done()
+			return call
+		}
+	}
+
+	if client.option.RateLimiter != nil && (servicePath != "" || serviceMethod != "") {
+		if err := client.rateLimit(ctx, call); err != nil {
+			call.Error = err
+			call.done()
+			return call
+		}
+	}
 
 	if share.Trace {
 		log.Debugf("client.Go send request for %s.%s, args: %+v in case of client call", servicePath, serviceMethod, args)
@@ -264,6 +576,26 @@ func (client *Client) Go(ctx context.Context, servicePath, serviceMethod string,
 	return call
 }
 
+// rateLimit waits until Option.RateLimiter allows call, which is released when it is done.
+func (client *Client) rateLimit(ctx context.Context, call *Call) error {
+	l := client.option.RateLimiter
+	var remoteAddr string
+	if client.Conn != nil {
+		remoteAddr = client.RemoteAddr()
+	}
+	dest := l.destination(call.ServicePath, remoteAddr)
+	start := time.Now()
+	release, wait, err := l.acquire(ctx, dest)
+	if err != nil && err != ErrClientRateLimited {
+		err = contextError(ctx, start, time.Time{})
+	}
+	if client.Plugins != nil && (wait > 0 || err != nil) {
+		client.Plugins.DoRateLimited(dest, wait, err)
+	}
+	call.release = release
+	return err
+}
+
 func (client *Client) injectOpenTracingSpan(ctx context.Context, call *Call) {
 	var rpcxContext *share.Context
 	var ok bool
@@ -286,7 +618,7 @@ func (client *Client) injectOpenTracingSpan(ctx context.Context, call *Call) {
 		opentracing.TextMap,
 		opentracing.TextMapCarrier(meta))
 	if err != nil {
-		log.Errorf("failed to inject span: %v", err)
+		logger.Error(ctx, "failed to inject span", "error", err)
 	}
 }
 
@@ -322,9 +654,24 @@ func (client *Client) Call(ctx context.Context, servicePath, serviceMethod strin
 	return client.call(ctx, servicePath, serviceMethod, args, reply)
 }
 
+// Notify sends a oneway request, which the server handles without writing a response.
+// It returns once the request is written, so errors of the handler are not returned.
+func (client *Client) Notify(ctx context.Context, servicePath, serviceMethod string, args interface{}) error {
+	return client.call(ctx, servicePath, serviceMethod, args, nil)
+}
+
 func (client *Client) call(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}) error {
+	// the call waits for its budget, which Go doesn't apply again, and Go fails it if the budget is exhausted
+	if bctx, cancel, err := client.applyHopBudget(ctx); err == nil && cancel != nil {
+		defer cancel()
+		ctx = bctx
+	}
+
 	seq := new(uint64)
-	ctx = context.WithValue(ctx, seqKey{}, seq)
+	sctx := getContext(ctx, client.option.PoolContexts)
+	defer freeContext(sctx, client.option.PoolContexts)
+	sctx.SetValue(seqKey{}, seq)
+	ctx = sctx
 
 	if share.Trace {
 		log.Debugf("client.call for %s.%s, args: %+v in case of client call", servicePath, serviceMethod, args)
@@ -333,40 +680,87 @@ func (client *Client) call(ctx context.Context, servicePath, serviceMethod strin
 		}()
 	}
 
-	Done := client.Go(ctx, servicePath, serviceMethod, args, reply, make(chan *Call, 1)).Done
+	start := time.Now()
+	call := client.Go(ctx, servicePath, serviceMethod, args, reply, make(chan *Call, 1))
 
-	var err error
 	select {
 	case <-ctx.Done(): // cancel by context
-		client.mutex.Lock()
-		call := client.pending[*seq]
-		delete(client.pending, *seq)
-		client.mutex.Unlock()
-		if call != nil {
-			call.Error = ctx.Err()
-			call.done()
+		if client.cancelCall(*seq, call, ctx) {
+			return call.Error
 		}
+		// the response has arrived just after the deadline and is being handled, so it is the result
+		<-call.Done
+	case <-call.Done:
+	}
 
-		return ctx.Err()
-	case call := <-Done:
-		err = call.Error
-		meta := ctx.Value(share.ResMetaDataKey)
-		if meta != nil && len(call.ResMetadata) > 0 {
-			resMeta := meta.(map[string]string)
-			for k, v := range call.ResMetadata {
-				resMeta[k] = v
-			}
-
-			resMeta[share.ServerAddress] = client.Conn.RemoteAddr().String()
-		}
+	err := call.Error
+	if client.tokens != nil && rerrors.CodeOf(err) == rerrors.Unauthenticated {
+		client.tokens.invalidate(servicePath, serviceMethod, start)
 	}
+	meta := ctx.Value(share.ResMetaDataKey)
+	if meta != nil && len(call.ResMetadata) > 0 {
+		resMeta := meta.(map[string]string)
+		for k, v := range call.ResMetadata {
+			resMeta[k] = v
+		}
 
+		resMeta[share.ServerAddress] = client.Conn.RemoteAddr().String()
+	}
 	return err
 }
 
+// cancelCall fails the pending call of seq by the error of ctx, which is done.
+// It returns false if the call is not pending, because its response has been received or it has failed.
+func (client *Client) cancelCall(seq uint64, call *Call, ctx context.Context) bool {
+	client.mutex.Lock()
+	pending := client.pending[seq] == call
+	if pending {
+		delete(client.pending, seq)
+	}
+	client.mutex.Unlock()
+	if !pending {
+		return false
+	}
+	call.Error = contextError(ctx, call.start, call.written)
+	call.done()
+	return true
+}
+
 // SendRaw sends raw messages. You don't care args and replys.
+// The response is returned as its payload and metadata with its header in X- keys, see SendRawMessage.
 func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error) {
-	ctx = context.WithValue(ctx, seqKey{}, r.Seq())
+	res, err := client.SendRawMessage(ctx, r)
+	if res == nil {
+		return nil, nil, err
+	}
+	m, payload, _ := convertRes2Raw(res)
+	if err != nil {
+		m[XErrorMessage] = err.Error()
+	}
+	return m, payload, err
+}
+
+// SendRawMessage sends r, a raw request whose payload is encoded by its serialize type, and returns the raw response,
+// with the serialize type, compress type, metadata and payload of the server, so proxies can forward it without decoding.
+// The response of an error is returned with the ServiceError. Oneway requests return nil responses.
+//
+// Requests are sent with sequences of the client, so callers may send requests of any sequences concurrently, such as
+// requests of several upstream connections, and responses get the sequences of their requests back.
+// Decompressed payloads are compressed again by the compress type of r when it is sent.
+func (client *Client) SendRawMessage(ctx context.Context, r *protocol.Message) (*protocol.Message, error) {
+	bctx, cancel, budgetErr := client.applyHopBudget(ctx)
+	if budgetErr != nil {
+		client.stats.callDone(budgetErr)
+		return nil, budgetErr
+	}
+	if cancel != nil {
+		defer cancel()
+		ctx = bctx
+	}
+
+	sctx := getContext(ctx, client.option.PoolContexts)
+	defer freeContext(sctx, client.option.PoolContexts)
+	ctx = sctx
 
 	call := new(Call)
 	call.Raw = true
@@ -388,15 +782,15 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
 			rmeta[k] = v
 		}
 	}
-
-	if meta != nil { // copy meta in context to meta in requests
-		call.Metadata = rmeta
+	rmeta = share.InjectPropagated(ctx, rmeta)
+	if token, ok, err := client.authToken(ctx, r.ServicePath, r.ServiceMethod, rmeta); ok {
+		rmeta[share.AuthKey] = token
+	} else if err != nil {
+		return nil, err
 	}
-	r.Metadata = rmeta
 
-	if _, ok := ctx.(*share.Context); !ok {
-		ctx = share.NewContext(ctx)
-	}
+	call.Metadata = rmeta
+	r.Metadata = rmeta
 
 	// TODO: should implement as plugin
 	client.injectOpenTracingSpan(ctx, call)
@@ -404,18 +798,61 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
 
 	done := make(chan *Call, 10)
 	call.Done = done
+	call.stats = &client.stats
+	call.start = time.Now()
+
+	if client.option.RateLimiter != nil {
+		if err := client.rateLimit(ctx, call); err != nil {
+			return nil, err
+		}
+	}
+	call.active = true
+	atomic.AddInt64(&client.stats.active, 1)
 
-	seq := r.Seq()
 	client.mutex.Lock()
 	if client.pending == nil {
 		client.pending = make(map[uint64]*Call)
 	}
+	seq := client.seq
+	client.seq++
 	client.pending[seq] = call
 	client.mutex.Unlock()
+	sctx.SetValue(seqKey{}, seq)
 
-	data := r.EncodeSlicePointer()
-	_, err := client.Conn.Write(*data)
-	protocol.PutData(data)
+	// r is sent with the sequence of the client, and gets its own sequence back
+	rseq := r.Seq()
+	r.SetSeq(seq)
+	defer r.SetSeq(rseq)
+
+	var dropped bool
+	if client.Plugins != nil {
+		if err := client.Plugins.DoClientBeforeEncode(r); err != nil {
+			if !errors.Is(err, ErrDropRequest) {
+				client.failSeq(seq, err)
+				return nil, err
+			}
+			dropped = true
+		}
+		call.record = newCallRecord(client.Plugins, r)
+	}
+
+	if cc, ok := client.Conn.(callContextConn); ok {
+		cc.bindCallContext(seq, share.Detach(ctx)) // kept until the response
+	}
+	err := ctx.Err()
+	if dropped {
+		// the call is pending until ctx is done, like calls of requests lost by networks
+		if r.IsOneway() {
+			client.failSeq(seq, nil)
+			return nil, nil
+		}
+	} else if err == nil {
+		// the payload belongs to the caller, so it waits for the write
+		err = client.writeMessage(ctx, call, r.EncodeVectored(), nil, nil, false, true)
+	} else {
+		// the caller has given up the call, so the request is not sent
+		err = contextError(ctx, call.start, time.Time{})
+	}
 
 	if err != nil {
 		client.mutex.Lock()
@@ -426,7 +863,7 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
 			call.Error = err
 			call.done()
 		}
-		return nil, nil, err
+		return nil, err
 	}
 	if r.IsOneway() {
 		client.mutex.Lock()
@@ -436,33 +873,22 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
 		if call != nil {
 			call.done()
 		}
-		return nil, nil, nil
+		return nil, nil
 	}
 
-	var m map[string]string
-	var payload []byte
-
 	select {
 	case <-ctx.Done(): // cancel by context
-		client.mutex.Lock()
-		call := client.pending[seq]
-		delete(client.pending, seq)
-		client.mutex.Unlock()
-		if call != nil {
-			call.Error = ctx.Err()
-			call.done()
-		}
-
-		return nil, nil, ctx.Err()
-	case call := <-done:
-		err = call.Error
-		m = call.Metadata
-		if call.Reply != nil {
-			payload = call.Reply.([]byte)
+		if client.cancelCall(seq, call, ctx) {
+			return nil, call.Error
 		}
+		<-done
+	case <-done:
 	}
 
-	return m, payload, err
+	if call.response != nil {
+		call.response.SetSeq(rseq)
+	}
+	return call.response, call.Error
 }
 
 func convertRes2Raw(res *protocol.Message) (map[string]string, []byte, error) {
@@ -509,6 +935,11 @@ func urlencode(data map[string]string) string {
 }
 
 func (client *Client) send(ctx context.Context, call *Call) {
+	call.start = time.Now()
+	if opts, ok := ctx.Value(jsonOptionsKey{}).(codec.JSONOptions); ok {
+		call.jsonOptions = &opts
+	}
+
 	// Register this call.
 	client.mutex.Lock()
 	if client.shutdown || client.closing {
@@ -520,12 +951,19 @@ func (client *Client) send(ctx context.Context, call *Call) {
 
 	isHeartbeat := call.ServicePath == "" && call.ServiceMethod == ""
 	serializeType := client.option.SerializeType
+	t, overridden := ctx.Value(serializeTypeKey{}).(protocol.SerializeType)
+	if overridden {
+		serializeType = t
+	}
 	if isHeartbeat {
 		serializeType = protocol.MsgPack
 	}
-	codec := share.Codecs[serializeType]
+	codec := share.CodecOf(serializeType)
 	if codec == nil {
 		call.Error = ErrUnsupportedCodec
+		if overridden && !isHeartbeat {
+			call.Error = fmt.Errorf("%w: no codec registered for serialize type %d of WithSerializeType", ErrUnsupportedCodec, t)
+		}
 		client.mutex.Unlock()
 		call.done()
 		return
@@ -555,9 +993,10 @@ func (client *Client) send(ctx context.Context, call *Call) {
 	// heartbeat, and use default SerializeType (msgpack)
 	if isHeartbeat {
 		req.SetHeartbeat(true)
-		req.SetSerializeType(protocol.MsgPack)
-	} else {
-		req.SetSerializeType(client.option.SerializeType)
+	}
+	req.SetSerializeType(serializeType)
+	if p, ok := ctx.Value(priorityKey{}).(protocol.Priority); ok {
+		req.SetPriority(p)
 	}
 
 	if call.Metadata != nil {
@@ -567,7 +1006,7 @@ func (client *Client) send(ctx context.Context, call *Call) {
 	req.ServicePath = call.ServicePath
 	req.ServiceMethod = call.ServiceMethod
 
-	data, err := codec.Encode(call.Args)
+	data, err := share.EncodePayload(codec, req, call.Args)
 	if err != nil {
 		client.mutex.Lock()
 		delete(client.pending, seq)
@@ -576,22 +1015,68 @@ func (client *Client) send(ctx context.Context, call *Call) {
 		call.done()
 		return
 	}
-	if len(data) > 1024 && client.option.CompressType != protocol.None {
-		req.SetCompressType(client.option.CompressType)
+	// heartbeats are echoed by servers, so they don't tell which extensions are accepted
+	if ct := client.option.CompressType; ct != protocol.None && !isHeartbeat {
+		// old servers can't decompress new compress types, so requests are compressed with gzip until the server accepts them
+		downgraded := (ct == protocol.Zstd || ct == protocol.Snappy) && atomic.LoadInt32(&client.compressAccepted) == 0
+		if len(data) > 1024 && !downgraded {
+			req.SetCompressType(ct)
+		} else {
+			if len(data) > 1024 {
+				req.SetCompressType(protocol.Gzip)
+			}
+			// tell servers with auto compression that responses can be compressed
+			setRequestMetadata(req, protocol.AcceptCompress, strconv.Itoa(int(client.option.CompressType)))
+		}
+	}
+	if client.option.ChunkThreshold > 0 && atomic.LoadInt32(&client.chunkAccepted) == 0 && !client.negotiated && !isHeartbeat {
+		// tell the server that responses can be chunked, until it tells chunked requests are reassembled too
+		setRequestMetadata(req, protocol.AcceptChunk, "1")
 	}
 
 	req.Payload = data
+	if client.option.Checksum && (!client.negotiated || client.capabilities.Has(protocol.CapChecksum)) {
+		req.SetChecksum(true)
+	}
+	if client.option.CompactMetadata && client.capabilities.Has(protocol.CapCompactMetadata) && !isHeartbeat {
+		req.SetCompactMetadata(true)
+	}
 
 	if client.Plugins != nil {
-		_ = client.Plugins.DoClientBeforeEncode(req)
+		// nothing has been written, so only this call fails
+		if err = client.Plugins.DoClientBeforeEncode(req); err != nil {
+			share.ReleasePayload(codec, data)
+			if errors.Is(err, ErrDropRequest) {
+				if !req.IsOneway() {
+					// the call is pending until it is canceled, like calls of requests lost by networks
+					protocol.FreeMsg(req)
+					return
+				}
+				err = nil // oneway calls are done without being sent
+			}
+			client.failSeq(seq, err)
+			protocol.FreeMsg(req)
+			return
+		}
+		if !isHeartbeat {
+			call.record = newCallRecord(client.Plugins, req)
+		}
 	}
 
 	if share.Trace {
 		log.Debugf("client.send for %s.%s, args: %+v in case of client call", call.ServicePath, call.ServiceMethod, call.Args)
 	}
-	allData := req.EncodeSlicePointer()
-	_, err = client.Conn.Write(*allData)
-	protocol.PutData(allData)
+	if cc, ok := client.Conn.(callContextConn); ok {
+		cc.bindCallContext(seq, share.Detach(ctx)) // kept until the response
+	}
+	if err = ctx.Err(); err == nil {
+		// the payload is written without being copied, so it is released after the write
+		err = client.writeMessage(ctx, call, req.EncodeVectored(), codec, data, isHeartbeat, false)
+	} else {
+		// the caller has given up the call, so the request is not sent
+		share.ReleasePayload(codec, data)
+		err = contextError(ctx, call.start, time.Time{})
+	}
 	if share.Trace {
 		log.Debugf("client.sent for %s.%s, args: %+v in case of client call", call.ServicePath, call.ServiceMethod, call.Args)
 	}
@@ -627,21 +1112,109 @@ func (client *Client) send(ctx context.Context, call *Call) {
 	}
 }
 
+// failCall fails the pending call of the response res with err. Server messages and heartbeats are dropped.
+func (client *Client) failCall(res *protocol.Message, err error) {
+	if res.MessageType() != protocol.Response {
+		logger.Warn(context.Background(), "rpcx: dropped the message from the server",
+			"remote", client.RemoteAddr(), "servicePath", res.ServicePath, "serviceMethod", res.ServiceMethod, "seq", res.Seq(), "error", err)
+		return
+	}
+	client.failSeq(res.Seq(), err)
+}
+
+// failSeq fails the pending call of seq with err if it is still pending.
+func (client *Client) failSeq(seq uint64, err error) {
+	client.mutex.Lock()
+	call := client.pending[seq]
+	delete(client.pending, seq)
+	client.mutex.Unlock()
+	if call != nil {
+		call.Error = err
+		call.done()
+	}
+}
+
 func (client *Client) input() {
 	var err error
+	var chunks *protocol.Reassembler
+	if client.option.ChunkThreshold > 0 {
+		chunks = protocol.NewReassembler(client.option.MaxChunkedMessageSize, client.option.ChunkTimeout)
+	}
 
 	for err == nil {
-		res := protocol.NewMessage()
+		res := protocol.GetPooledMsg()
 		if client.option.IdleTimeout != 0 {
 			_ = client.Conn.SetDeadline(time.Now().Add(client.option.IdleTimeout))
 		}
 
 		err = res.Decode(client.r)
+		if chunks != nil && res.IsChunk() {
+			// chunk frames have been read entirely, so only the message fails and the connection is still usable
+			var cerr error
+			if res, cerr = chunks.Reassemble(res, err); res == nil && cerr == nil {
+				err = nil
+				continue
+			}
+			err = nil
+			if cerr != nil {
+				client.failCall(res, cerr)
+				res.Free()
+				continue
+			}
+		}
+		if errors.Is(err, protocol.ErrChecksumMismatch) {
+			// only the payload is corrupted, so the call fails and the connection is still usable
+			err = nil
+			client.failCall(res, protocol.ErrChecksumMismatch)
+			res.Free()
+			continue
+		}
+		if errors.Is(err, protocol.ErrUnsupportedCompressor) {
+			// so the response is read entirely too
+			err = nil
+			client.failCall(res, share.UnsupportedCompressType(res.CompressType()))
+			res.Free()
+			continue
+		}
 		if err != nil {
+			res.Free()
 			break
 		}
 		if client.Plugins != nil {
-			_ = client.Plugins.DoClientAfterDecode(res)
+			// the response has been read entirely, so only its call fails and the connection is still usable
+			if perr := client.Plugins.DoClientAfterDecode(res); perr != nil {
+				client.failCall(res, perr)
+				res.Free()
+				continue
+			}
+		}
+		if res.MessageType() == protocol.Response {
+			atomic.AddUint64(&client.stats.responses, 1)
+		}
+		if accepted, ok := res.Metadata[protocol.AcceptCompress]; ok {
+			want := strconv.Itoa(int(client.option.CompressType))
+			for _, t := range strings.Split(accepted, ",") {
+				if t == want {
+					atomic.StoreInt32(&client.compressAccepted, 1)
+				}
+			}
+			delete(res.Metadata, protocol.AcceptCompress)
+		}
+		if _, ok := res.Metadata[protocol.AcceptChunk]; ok {
+			atomic.StoreInt32(&client.chunkAccepted, 1)
+			delete(res.Metadata, protocol.AcceptChunk)
+		}
+		if v, ok := res.Metadata[protocol.LoadReportKey]; ok {
+			if report, ok := protocol.ParseLoadReport(v); ok && client.loadReported != nil {
+				client.loadReported(report)
+			}
+			delete(res.Metadata, protocol.LoadReportKey)
+		}
+
+		if res.MessageType() == protocol.Request && res.IsHeartbeat() { // the server checks whether this client is alive
+			client.replyHeartbeat(res)
+			res.Free()
+			continue
 		}
 
 		seq := res.Seq()
@@ -661,43 +1234,48 @@ func (client *Client) input() {
 		switch {
 		case call == nil:
 			if isServerMessage {
-				if client.ServerMessageChan != nil {
+				if client.serverMessageChan() != nil {
+					// messages sent to ServerMessageChan belong to receivers
 					client.handleServerRequest(res)
 				}
 				continue
 			}
+			if res.Metadata[protocol.ConnRejected] != "" { // the server has rejected this connection
+				err = serviceErrorFromMetadata(res.Metadata)
+			}
+			res.Free()
 		case res.MessageStatusType() == protocol.Error:
+			call.recordResponse(res)
 			// We've got an error response. Give this to the request
 			if len(res.Metadata) > 0 {
 				call.ResMetadata = res.Metadata
-				call.Error = ServiceError(res.Metadata[protocol.ServiceError])
+				call.Error = serviceErrorFromMetadata(res.Metadata)
 			}
 
 			if call.Raw {
-				call.Metadata, call.Reply, _ = convertRes2Raw(res)
-				call.Metadata[XErrorMessage] = call.Error.Error()
+				call.response = res
 			} else if len(res.Payload) > 0 {
 				data := res.Payload
-				codec := share.Codecs[res.SerializeType()]
+				codec := share.CodecOf(res.SerializeType())
 				if codec != nil {
-					_ = codec.Decode(data, call.Reply)
+					_ = call.decodeReply(codec, res, data)
 				}
 			}
+			freeResponse(res, call)
 			call.done()
 		default:
+			call.recordResponse(res)
 			if call.Raw {
-				call.Metadata, call.Reply, _ = convertRes2Raw(res)
+				call.response = res
 			} else {
 				data := res.Payload
 				if len(data) > 0 {
-					codec := share.Codecs[res.SerializeType()]
+					codec := share.CodecOf(res.SerializeType())
 					if codec == nil {
-						call.Error = ServiceError(ErrUnsupportedCodec.Error())
-					} else {
-						err = codec.Decode(data, call.Reply)
-						if err != nil {
-							call.Error = ServiceError(err.Error())
-						}
+						call.Error = share.UnsupportedSerializeType(res.SerializeType())
+					} else if derr := call.decodeReply(codec, res, data); derr != nil {
+						// only this call fails, the connection is still usable
+						call.Error = ServiceError(derr.Error())
 					}
 				}
 				if len(res.Metadata) > 0 {
@@ -706,12 +1284,13 @@ func (client *Client) input() {
 
 			}
 
+			freeResponse(res, call)
 			call.done()
 		}
 	}
 	// Terminate pending calls.
 
-	if client.ServerMessageChan != nil {
+	if client.serverMessageChan() != nil {
 		req := protocol.NewMessage()
 		req.SetMessageType(protocol.Request)
 		req.SetMessageStatusType(protocol.Error)
@@ -733,6 +1312,7 @@ func (client *Client) input() {
 		client.pluginClosed = true
 	}
 	client.Conn.Close()
+	client.stopSendQueue()
 	client.shutdown = true
 	closing := client.closing
 	if err == io.EOF {
@@ -742,36 +1322,165 @@ func (client *Client) input() {
 			err = io.ErrUnexpectedEOF
 		}
 	}
+	callErr := err
+	if err != ErrShutdown {
+		callErr = markError(err, ErrConnectionBroken)
+	}
 	for _, call := range client.pending {
-		call.Error = err
+		call.Error = callErr
 		call.done()
 	}
 
 	client.mutex.Unlock()
 
 	if err != nil && !closing {
-		log.Error("rpcx: client protocol error:", err)
+		logger.Error(context.Background(), "rpcx: client protocol error", "remote", client.RemoteAddr(), "error", err)
+	}
+}
+
+// setRequestMetadata sets key in the metadata of req to value. The metadata is copied since it may belong to the call.
+func setRequestMetadata(req *protocol.Message, key, value string) {
+	meta := make(map[string]string, len(req.Metadata)+1)
+	for k, v := range req.Metadata {
+		meta[k] = v
+	}
+	meta[key] = value
+	req.Metadata = meta
+}
+
+// write writes msg, an encoded request, in chunk frames if it is large and the server reassembles them.
+// msg is returned to the pool.
+func (client *Client) write(msg *protocol.EncodedMessage) error {
+	defer msg.Free()
+	threshold := client.option.ChunkThreshold
+	if threshold <= 0 || msg.Len() <= threshold || atomic.LoadInt32(&client.chunkAccepted) == 0 {
+		n, err := msg.WriteTo(client.Conn)
+		atomic.AddUint64(&client.stats.bytesSent, uint64(n))
+		return err
+	}
+
+	data := msg.Bytes()
+	frames := protocol.SplitChunks(*data, threshold)
+	protocol.PutData(data)
+	var err error
+	for _, frame := range frames {
+		if q := client.sendQueue; q != nil && err == nil {
+			client.writeHeartbeats(q) // written by the writer, so heartbeats jump in between frames
+		}
+		if err == nil {
+			var n int
+			n, err = client.Conn.Write(*frame)
+			atomic.AddUint64(&client.stats.bytesSent, uint64(n))
+		}
+		protocol.PutData(frame)
+	}
+	return err
+}
+
+// freeResponse frees res, the response of call, before call is done unless call may refer to it:
+// raw calls get payloads of responses, and replies decoded by codecs not known to copy data may refer to payloads.
+func freeResponse(res *protocol.Message, call *Call) {
+	if call.Raw || !codec.CopiesData(share.CodecOf(res.SerializeType())) {
+		return
+	}
+	if call.ResMetadata != nil {
+		res.Metadata = nil // the metadata belongs to the call, so it is not reused
+	}
+	res.Free()
+}
+
+// replyHeartbeat answers a heartbeat sent by the server.
+func (client *Client) replyHeartbeat(req *protocol.Message) {
+	req.SetMessageType(protocol.Response)
+	data := req.EncodeSlicePointer()
+	n, err := client.Conn.Write(*data)
+	atomic.AddUint64(&client.stats.bytesSent, uint64(n))
+	if err != nil {
+		logger.Warn(context.Background(), "failed to reply heartbeat of the server", "remote", client.RemoteAddr(), "seq", req.Seq(), "error", err)
 	}
+	protocol.PutData(data)
 }
 
 func (client *Client) handleServerRequest(msg *protocol.Message) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Errorf("ServerMessageChan may be closed so client remove it. Please add it again if you want to handle server requests. error is %v", r)
-			client.ServerMessageChan = nil
+			client.UnregisterServerMessageChan()
 		}
 	}()
 
-	serverMessageChan := client.ServerMessageChan
+	serverMessageChan := client.serverMessageChan()
 	if serverMessageChan != nil {
 		select {
 		case serverMessageChan <- msg:
 		default:
 			log.Warnf("ServerMessageChan may be full so the server request %d has been dropped", msg.Seq())
+			msg.Free()
 		}
 	}
 }
 
+// getContext gets a share.Context of the parent ctx, which is pooled if pool is set, see Option.PoolContexts.
+func getContext(ctx context.Context, pool bool) *share.Context {
+	if pool {
+		return share.GetPooledContext(ctx)
+	}
+	return share.NewContext(ctx)
+}
+
+// freeContext frees ctx if it is pooled.
+func freeContext(ctx *share.Context, pool bool) {
+	if pool {
+		share.FreeContext(ctx)
+	}
+}
+
+// negotiate negotiates the protocol with the server by a heartbeat sent on connect. Servers of old versions echo it
+// without the negotiated protocol, and they are treated as legacy servers like servers not answering it in time.
+func (client *Client) negotiate() {
+	if client.option.NegotiateTimeout <= 0 {
+		return
+	}
+	caps := protocol.CapCompress | protocol.CapChecksum | protocol.CapCompactMetadata
+	if client.option.ChunkThreshold > 0 {
+		caps |= protocol.CapChunk
+	}
+
+	resMeta := make(map[string]string)
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{
+		protocol.NegotiateKey: protocol.FormatNegotiation(protocol.ProtocolVersion, caps),
+	})
+	ctx = context.WithValue(ctx, share.ResMetaDataKey, resMeta)
+	ctx, cancel := context.WithTimeout(ctx, client.option.NegotiateTimeout)
+	defer cancel()
+
+	request := time.Now().UnixNano()
+	reply := int64(0)
+	err := client.Call(ctx, "", "", &request, &reply)
+	client.negotiated = true
+	if err != nil {
+		logger.Warn(ctx, "rpcx: failed to negotiate, treated as a legacy server", "remote", client.RemoteAddr(), "error", err)
+		return
+	}
+	version, caps, ok := protocol.ParseNegotiation(resMeta[protocol.NegotiatedKey])
+	if !ok {
+		return
+	}
+	client.version, client.capabilities = version, caps
+	if caps.Has(protocol.CapCompress) {
+		atomic.StoreInt32(&client.compressAccepted, 1)
+	}
+	if caps.Has(protocol.CapChunk) {
+		atomic.StoreInt32(&client.chunkAccepted, 1)
+	}
+}
+
+// Negotiation returns the protocol version and the capabilities negotiated with the server on connect.
+// Capabilities are empty if the server is a legacy server. ok is false if the negotiation is disabled by Option.NegotiateTimeout.
+func (client *Client) Negotiation() (version int, caps protocol.Capabilities, ok bool) {
+	return client.version, client.capabilities, client.negotiated
+}
+
 func (client *Client) heartbeat() {
 	t := time.NewTicker(client.option.HeartbeatInterval)
 
@@ -785,25 +1494,33 @@ func (client *Client) heartbeat() {
 			return
 		}
 
-		request := time.Now().UnixNano()
+		start := time.Now()
+		request := start.UnixNano()
 		reply := int64(0)
 		ctx, cancel := context.WithTimeout(context.Background(), client.option.MaxWaitForHeartbeat)
 		err := client.Call(ctx, "", "", &request, &reply)
 		abnormal := false
 		if ctx.Err() != nil {
-			log.Warnf("failed to heartbeat to %s, context err: %v", client.Conn.RemoteAddr().String(), ctx.Err())
+			logger.Warn(ctx, "failed to heartbeat", "remote", client.RemoteAddr(), "error", ctx.Err())
 			abnormal = true
+			if err == nil {
+				err = ctx.Err()
+			}
 		}
 		cancel()
 		if err != nil {
-			log.Warnf("failed to heartbeat to %s: %v", client.Conn.RemoteAddr().String(), err)
+			logger.Warn(ctx, "failed to heartbeat", "remote", client.RemoteAddr(), "error", err)
 			abnormal = true
 		}
 
 		if reply != request {
-			log.Warnf("reply %d in heartbeat to %s is different from request %d", reply, client.Conn.RemoteAddr().String(), request)
+			logger.Warn(ctx, "reply in heartbeat is different from request", "remote", client.RemoteAddr(), "reply", reply, "request", request)
 		}
 
+		client.stats.heartbeat(!abnormal)
+		if client.Plugins != nil {
+			client.Plugins.DoHeartbeat(client.RemoteAddr(), time.Since(start), err)
+		}
 		if abnormal {
 			client.Close()
 		}
@@ -832,6 +1549,7 @@ func (client *Client) Close() error {
 		client.pluginClosed = true
 		err = client.Conn.Close()
 	}
+	client.stopSendQueue()
 
 	if client.closing || client.shutdown {
 		client.mutex.Unlock()
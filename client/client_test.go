@@ -1,15 +1,25 @@
 package client
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"math/rand"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/hamba/avro/v2"
 	testutils "github.com/smallnest/rpcx/_testutils"
+	"github.com/smallnest/rpcx/codec"
+	rerrors "github.com/smallnest/rpcx/errors"
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
 )
 
 type Args struct {
@@ -40,6 +50,27 @@ func (t *Arith) ThriftMul(ctx context.Context, args *testutils.ThriftArgs_, repl
 	return nil
 }
 
+// startServer serves s on a loopback TCP port until the test finishes, and returns the address of it.
+// The port is listened on before s serves it, so clients can connect as soon as it returns.
+func startServer(t testing.TB, s *server.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveListener(t, s, ln)
+}
+
+// serveListener serves s on ln like startServer.
+func serveListener(t testing.TB, s *server.Server, ln net.Listener) string {
+	go s.ServeListener("tcp", ln)
+	t.Cleanup(func() {
+		s.Close()
+		ln.Close()
+	})
+	return ln.Addr().String()
+}
+
 func TestClient_IT(t *testing.T) {
 	server.UsePool = false
 
@@ -95,6 +126,17 @@ func TestClient_IT(t *testing.T) {
 		t.Fatalf("expect 200 but got %d", reply.C)
 	}
 
+	client.option.SerializeType = protocol.CBOR
+	reply = &Reply{}
+	err = client.Call(context.Background(), "Arith", "Mul", args, reply)
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+
+	if reply.C != 200 {
+		t.Fatalf("expect 200 but got %d", reply.C)
+	}
+
 	client.option.SerializeType = protocol.ProtoBuffer
 
 	pbArgs := &testutils.ProtoArgs{
@@ -143,6 +185,61 @@ func TestClient_IT_Concurrency(t *testing.T) {
 
 }
 
+func TestClient_SendRawMessage(t *testing.T) {
+	s := server.NewServer()
+	_ = s.RegisterName("Arith", new(Arith), "")
+	addr := startServer(t, s)
+
+	client := NewClient(DefaultOption)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// raw requests of the same sequence don't collide with each other or with calls of the client
+	var wg sync.WaitGroup
+	for i := 1; i <= 50; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			req := protocol.NewMessage()
+			req.SetSeq(1)
+			req.SetSerializeType(protocol.JSON)
+			req.ServicePath, req.ServiceMethod = "Arith", "Mul"
+			req.Payload = []byte(`{"A":` + strconv.Itoa(i) + `,"B":10}`)
+			res, err := client.SendRawMessage(context.Background(), req)
+			if err != nil {
+				t.Errorf("failed to send the raw message: %v", err)
+				return
+			}
+			if res.Seq() != 1 || res.SerializeType() != protocol.JSON || string(res.Payload) != `{"C":`+strconv.Itoa(i*10)+`}` {
+				t.Errorf("unexpected response %d %d %s of %d", res.Seq(), res.SerializeType(), res.Payload, i)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			reply := &Reply{}
+			if err := client.Call(context.Background(), "Arith", "Mul", &Args{A: i, B: 20}, reply); err != nil || reply.C != i*20 {
+				t.Errorf("unexpected reply %d of %d: %v", reply.C, i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// errors are returned with their responses
+	req := protocol.NewMessage()
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath, req.ServiceMethod = "Arith", "Div"
+	res, err := client.SendRawMessage(context.Background(), req)
+	if err == nil || res == nil || res.MessageStatusType() != protocol.Error || res.Metadata[protocol.ServiceError] == "" {
+		t.Fatalf("expect the error response but got %v: %v", res, err)
+	}
+	m, _, err := client.SendRaw(context.Background(), req)
+	if err == nil || m[XErrorMessage] != err.Error() || m[XMessageStatusType] != "Error" {
+		t.Fatalf("expect the error metadata but got %v: %v", m, err)
+	}
+}
+
 func testSendRaw(t *testing.T, client *Client, seq uint64, x, y int32, wg *sync.WaitGroup) {
 	defer wg.Done()
 	rpcxReq := protocol.GetPooledMsg()
@@ -189,3 +286,332 @@ func TestClient_Res_Reset(t *testing.T) {
 		t.Fatalf("data has been set to empty after response has been reset: %v", data)
 	}
 }
+
+func TestClientChecksumMismatch(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// a server corrupting the payload of the first response
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for i := 0; ; i++ {
+			req, err := protocol.Read(r)
+			if err != nil {
+				return
+			}
+			if !req.HasChecksum() {
+				t.Error("expect requests with checksums")
+			}
+			res := req.Clone()
+			res.SetMessageType(protocol.Response)
+			res.Payload = []byte(`{"C":200}`)
+			data := res.Encode()
+			if i == 0 {
+				data[len(data)-2] ^= 0x01
+			}
+			conn.Write(data)
+		}
+	}()
+
+	opt := DefaultOption
+	opt.SerializeType = protocol.JSON
+	opt.Checksum = true
+	opt.NegotiateTimeout = 0 // the server doesn't negotiate
+	client := NewClient(opt)
+	if err := client.Connect("tcp", ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	reply := &Reply{}
+	err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	if !errors.Is(err, protocol.ErrChecksumMismatch) {
+		t.Fatalf("expect ErrChecksumMismatch but got %v", err)
+	}
+
+	// the connection is still usable
+	if err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect 200 but got %d: %v", reply.C, err)
+	}
+}
+
+func TestClientUnsupportedEncoding(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// a server responding with an unknown serialize type, then an unknown compress type
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for i := 0; ; i++ {
+			req, err := protocol.Read(r)
+			if err != nil {
+				return
+			}
+			res := req.Clone()
+			res.SetMessageType(protocol.Response)
+			res.Payload = []byte(`{"C":200}`)
+			data := res.Encode()
+			switch i {
+			case 0:
+				data[3] = data[3]&0x0F | 13<<4
+			case 1:
+				data[2] = data[2]&^0x1C | 7<<2
+			}
+			conn.Write(data)
+		}
+	}()
+
+	opt := DefaultOption
+	opt.SerializeType = protocol.JSON
+	opt.NegotiateTimeout = 0 // the server doesn't negotiate
+	client := NewClient(opt)
+	if err := client.Connect("tcp", ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, expected := range []string{"serialize type 13", "compress type 7"} {
+		err = client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, &Reply{})
+		if !errors.Is(err, rerrors.ErrUnsupportedEncoding) || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expect an unsupported %s but got %v", expected, err)
+		}
+	}
+
+	// only the calls fail
+	reply := &Reply{}
+	if err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect 200 but got %d: %v", reply.C, err)
+	}
+}
+
+func TestClientAvro(t *testing.T) {
+	provider := codec.NewMemorySchemaProvider()
+	args := avro.MustParse(`{"type": "record", "name": "Args", "fields": [{"name": "A", "type": "int"}, {"name": "B", "type": "int"}]}`)
+	reply := avro.MustParse(`{"type": "record", "name": "Reply", "fields": [{"name": "C", "type": "int"}]}`)
+	_ = provider.Register(codec.SchemaKey{ServicePath: "Arith", ServiceMethod: "Mul"}, args)
+	_ = provider.Register(codec.SchemaKey{ServicePath: "Arith", ServiceMethod: "Mul", Response: true}, reply)
+	share.RegisterCodec(protocol.Avro, codec.NewAvroCodec(provider))
+	defer delete(share.Codecs, protocol.Avro)
+
+	s := server.NewServer()
+	_ = s.RegisterName("Arith", new(Arith), "")
+	go func() {
+		_ = s.Serve("tcp", "127.0.0.1:0")
+	}()
+	defer s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	opt := DefaultOption
+	opt.SerializeType = protocol.Avro
+	client := NewClient(opt)
+	if err := client.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	r := &Reply{}
+	if err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, r); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if r.C != 200 {
+		t.Fatalf("expect 200 but got %d", r.C)
+	}
+}
+
+// serializeTypePlugin records serialize types of requests.
+type serializeTypePlugin struct {
+	mu    sync.Mutex
+	types []protocol.SerializeType
+}
+
+func (p *serializeTypePlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if r != nil && !r.IsHeartbeat() {
+		p.mu.Lock()
+		p.types = append(p.types, r.SerializeType())
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+func TestClientWithSerializeType(t *testing.T) {
+	p := &serializeTypePlugin{}
+	s := server.NewServer()
+	s.Plugins.Add(p)
+	_ = s.RegisterName("Arith", new(Arith), "")
+	_ = s.RegisterName("PBArith", new(PBArith), "")
+	go func() {
+		_ = s.Serve("tcp", "127.0.0.1:0")
+	}()
+	defer s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	opt := DefaultOption
+	opt.SerializeType = protocol.JSON
+	client := NewClient(opt)
+	if err := client.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	pbReply := &testutils.ProtoReply{}
+	ctx := WithSerializeType(context.Background(), protocol.ProtoBuffer)
+	if err := client.Call(ctx, "PBArith", "Mul", &testutils.ProtoArgs{A: 10, B: 20}, pbReply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if pbReply.C != 200 {
+		t.Fatalf("expect 200 but got %d", pbReply.C)
+	}
+
+	// other calls use the serialize type of the client
+	reply := &Reply{}
+	if err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 6 {
+		t.Fatalf("expect 6 but got %d", reply.C)
+	}
+
+	// serialize types without codecs are rejected before requests are sent
+	ctx = WithSerializeType(context.Background(), protocol.SerializeType(100))
+	err := client.Call(ctx, "Arith", "Mul", &Args{A: 2, B: 3}, reply)
+	if !errors.Is(err, ErrUnsupportedCodec) {
+		t.Fatalf("expect ErrUnsupportedCodec but got %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.types) != 2 || p.types[0] != protocol.ProtoBuffer || p.types[1] != protocol.JSON {
+		t.Errorf("unexpected serialize types of requests: %v", p.types)
+	}
+}
+
+// TestClientServerMessagesPooled pushes messages while calls are in flight, so that messages and buffers
+// of responses and server messages are reused concurrently. Run it with the race detector.
+func TestClientServerMessagesPooled(t *testing.T) {
+	s := server.NewServer()
+	_ = s.RegisterName("Arith", new(Arith), "")
+	go func() {
+		_ = s.Serve("tcp", "127.0.0.1:0")
+	}()
+	defer s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(DefaultOption)
+	if err := client.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+	ch := make(chan *protocol.Message, 16)
+	client.RegisterServerMessageChan(ch)
+	if err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 1, B: 1}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	conns := s.ActiveClientConn()
+	if len(conns) != 1 {
+		t.Fatalf("expect 1 conn but got %d", len(conns))
+	}
+
+	const pushes = 200
+	stop, received := make(chan struct{}), make(chan int)
+	go func() {
+		n := 0
+		for {
+			select {
+			case msg := <-ch:
+				i, err := strconv.Atoi(msg.Metadata["n"])
+				if err != nil || !bytes.Equal(msg.Payload, bytes.Repeat([]byte{byte(i)}, 2000)) {
+					t.Errorf("server message %q is corrupted", msg.Metadata["n"])
+				}
+				msg.Free()
+				n++
+			case <-stop:
+				received <- n
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < pushes; i++ {
+			data := bytes.Repeat([]byte{byte(i)}, 2000)
+			if err := s.SendMessage(conns[0], "Push", "Data", map[string]string{"n": strconv.Itoa(i)}, data); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"g": strconv.Itoa(g)})
+				ctx = context.WithValue(ctx, share.ResMetaDataKey, make(map[string]string))
+				reply := &Reply{}
+				if err := client.Call(ctx, "Arith", "Mul", &Args{A: g, B: i}, reply); err != nil {
+					t.Error(err)
+					return
+				}
+				if reply.C != g*i {
+					t.Errorf("expect %d but got %d", g*i, reply.C)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	if n := <-received; n == 0 {
+		t.Error("expect server messages received")
+	}
+}
+
+func BenchmarkClient_Call(b *testing.B) {
+	s := server.NewServer()
+	_ = s.RegisterName("Arith", new(Arith), "")
+	go func() {
+		_ = s.Serve("tcp", "127.0.0.1:0")
+	}()
+	defer s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(DefaultOption)
+	if err := client.Connect("tcp", s.Address().String()); err != nil {
+		b.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	args := &Args{A: 10, B: 20}
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"k": "v"})
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		reply := &Reply{}
+		for pb.Next() {
+			if err := client.Call(ctx, "Arith", "Mul", args, reply); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -2,27 +2,49 @@ package client
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
-	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/share"
-	"golang.org/x/net/websocket"
+	"github.com/smallnest/rpcx/util"
 )
 
 type ConnFactoryFn func(c *Client, network, address string) (net.Conn, error)
 
+// DialFailureLogInterval limits logs of failures of dialing the same address to one log per interval,
+// and the number of suppressed logs is logged by the "suppressed" field.
+// Zero logs every failure, which is the default.
+var DialFailureLogInterval time.Duration
+
+var dialFailureLogs log.Sampler
+
+func logDialFailure(network, address string, err error) {
+	ok, suppressed := dialFailureLogs.Allow(network+"@"+address, DialFailureLogInterval)
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		logger.Warn(context.Background(), "failed to dial server", "network", network, "address", address, "error", err, "suppressed", suppressed)
+		return
+	}
+	logger.Warn(context.Background(), "failed to dial server", "network", network, "address", address, "error", err)
+}
+
 var ConnFactories = map[string]ConnFactoryFn{
 	"http": newDirectHTTPConn,
 	"kcp":  newDirectKCPConn,
 	"quic": newDirectQuicConn,
 	"unix": newDirectConn,
 	"memu": newMemuConn,
+	"wt":   newDirectWebTransportConn,
 }
 
 // Connect connects the server via specified network.
@@ -43,6 +65,9 @@ func (c *Client) Connect(network, address string) error {
 			conn, err = newDirectConn(c, network, address)
 		}
 	}
+	if err != nil {
+		return &DialError{Network: network, Address: address, Err: err}
+	}
 
 	if err == nil && conn != nil {
 		if tc, ok := conn.(*net.TCPConn); ok && c.option.TCPKeepAlivePeriod > 0 {
@@ -50,6 +75,16 @@ func (c *Client) Connect(network, address string) error {
 			_ = tc.SetKeepAlivePeriod(c.option.TCPKeepAlivePeriod)
 		}
 
+		if c.option.SessionEncryption != nil {
+			sc := protocol.SessionClient(conn, c.option.SessionEncryption)
+			if err := sc.Handshake(); err != nil {
+				logger.Warn(context.Background(), "failed to connect", "network", network, "address", address, "error", err)
+				conn.Close()
+				return err
+			}
+			conn = sc
+		}
+
 		if c.option.IdleTimeout != 0 {
 			_ = conn.SetDeadline(time.Now().Add(c.option.IdleTimeout))
 		}
@@ -62,11 +97,18 @@ func (c *Client) Connect(network, address string) error {
 		}
 
 		c.Conn = conn
-		c.r = bufio.NewReaderSize(conn, ReaderBuffsize)
+		c.r = bufio.NewReaderSize(countingReader{r: conn, n: &c.stats.bytesReceived}, ReaderBuffsize)
+		c.stats.connected()
 		// c.w = bufio.NewWriterSize(conn, WriterBuffsize)
 
 		// start reading and writing since connected
+		if c.option.SendQueueSize > 0 {
+			c.startSendQueue()
+		}
 		go c.input()
+		if !isUDP(network) { // udp is connectionless, see newDirectUDPConn
+			c.negotiate()
+		}
 
 		if c.option.Heartbeat && c.option.HeartbeatInterval > 0 {
 			go c.heartbeat()
@@ -94,7 +136,7 @@ func newDirectConn(c *Client, network, address string) (net.Conn, error) {
 	}
 
 	if err != nil {
-		log.Warnf("failed to dial server: %v", err)
+		logDialFailure(network, address, err)
 		return nil, err
 	}
 
@@ -105,7 +147,7 @@ var connected = "200 Connected to rpcx"
 
 func newDirectHTTPConn(c *Client, network, address string) (net.Conn, error) {
 	if c == nil {
-		return nil, errors.New("empty client")
+		return nil, ErrEmptyClient
 	}
 	path := c.option.RPCPath
 	if path == "" {
@@ -128,13 +170,13 @@ func newDirectHTTPConn(c *Client, network, address string) (net.Conn, error) {
 		conn, err = net.DialTimeout("tcp", address, c.option.ConnectTimeout)
 	}
 	if err != nil {
-		log.Errorf("failed to dial server: %v", err)
+		logDialFailure(network, address, err)
 		return nil, err
 	}
 
 	_, err = io.WriteString(conn, "CONNECT "+path+" HTTP/1.0\n\n")
 	if err != nil {
-		log.Errorf("failed to make CONNECT: %v", err)
+		logger.Error(context.Background(), "failed to make CONNECT", "address", address, "path", path, "error", err)
 		return nil, err
 	}
 
@@ -145,8 +187,8 @@ func newDirectHTTPConn(c *Client, network, address string) (net.Conn, error) {
 		return conn, nil
 	}
 	if err == nil {
-		log.Errorf("unexpected HTTP response: %v", err)
-		err = errors.New("unexpected HTTP response: " + resp.Status)
+		logger.Error(context.Background(), "unexpected HTTP response", "address", address, "path", path, "status", resp.Status)
+		err = fmt.Errorf("%w: %s", ErrUnexpectedHTTPResponse, resp.Status)
 	}
 	conn.Close()
 	return nil, &net.OpError{
@@ -159,16 +201,13 @@ func newDirectHTTPConn(c *Client, network, address string) (net.Conn, error) {
 
 func newDirectWSConn(c *Client, network, address string) (net.Conn, error) {
 	if c == nil {
-		return nil, errors.New("empty client")
+		return nil, ErrEmptyClient
 	}
 	path := c.option.RPCPath
 	if path == "" {
 		path = share.DefaultRPCPath
 	}
 
-	var conn net.Conn
-	var err error
-
 	// url := "ws://localhost:12345/ws"
 
 	var url, origin string
@@ -180,16 +219,15 @@ func newDirectWSConn(c *Client, network, address string) (net.Conn, error) {
 		origin = fmt.Sprintf("https://%s", address)
 	}
 
-	if c.option.TLSConfig != nil {
-		config, err := websocket.NewConfig(url, origin)
-		if err != nil {
-			return nil, err
-		}
-		config.TlsConfig = c.option.TLSConfig
-		conn, err = websocket.DialConfig(config)
-	} else {
-		conn, err = websocket.Dial(url, "", origin)
+	dialer := &websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		TLSClientConfig:   c.option.TLSConfig,
+		HandshakeTimeout:  c.option.ConnectTimeout,
+		EnableCompression: c.option.WebsocketCompression,
 	}
-
-	return conn, err
+	conn, _, err := dialer.Dial(url, http.Header{"Origin": {origin}})
+	if err != nil {
+		return nil, err
+	}
+	return util.NewWebsocketConn(conn), nil
 }
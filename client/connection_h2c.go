@@ -0,0 +1,301 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"golang.org/x/net/http2"
+)
+
+func init() {
+	ConnFactories["h2c"] = newDirectH2CConn
+	ConnFactories["h2"] = newDirectH2CConn
+}
+
+// h2cContentType is the Content-Type of encoded rpcx messages in bodies of streams.
+const h2cContentType = "application/rpcx"
+
+// callContextConn is implemented by connections which bind calls to their contexts,
+// so that calls are canceled on the wire when their contexts are done.
+type callContextConn interface {
+	bindCallContext(seq uint64, ctx context.Context)
+}
+
+// h2cConn sends each message as a POST stream of a HTTP/2 connection, cleartext (h2c) or TLS (h2),
+// and reads the responses of streams, so one connection of the client is balanced per call by proxies of HTTP/2.
+//
+// Messages are reassembled from the written bytes, since they can be written in pieces.
+// Streams of calls are reset when the contexts of calls are done.
+// Servers can't send messages to clients over streams, so server pushes and heartbeats of servers are not supported.
+type h2cConn struct {
+	conn net.Conn
+	cc   *http2.ClientConn
+	url  string
+
+	ctx    context.Context // canceled when the connection is closed
+	cancel context.CancelFunc
+
+	pr *io.PipeReader // responses of streams, each written by one call of Write
+	pw *io.PipeWriter
+
+	mu   sync.Mutex
+	ctxs map[uint64]context.Context // contexts of calls by seqs, which are used by their streams
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+func newDirectH2CConn(c *Client, network, address string) (net.Conn, error) {
+	if c == nil {
+		return nil, ErrEmptyClient
+	}
+	if c.option.SessionEncryption != nil {
+		return nil, fmt.Errorf("%w by %s", ErrSessionEncryptionUnsupported, network)
+	}
+	path := c.option.RPCPath
+	if path == "" {
+		path = share.DefaultRPCPath
+	}
+
+	var conn net.Conn
+	var err error
+	scheme := "http"
+	t := &http2.Transport{AllowHTTP: true}
+	dialer := &net.Dialer{Timeout: c.option.ConnectTimeout}
+	if network == "h2" {
+		scheme = "https"
+		config := &tls.Config{}
+		if c.option.TLSConfig != nil {
+			config = c.option.TLSConfig.Clone()
+		}
+		config.NextProtos = []string{http2.NextProtoTLS}
+		t.TLSClientConfig = config
+		var tlsConn *tls.Conn
+		tlsConn, err = tls.DialWithDialer(dialer, "tcp", address, config)
+		if err == nil && tlsConn.ConnectionState().NegotiatedProtocol != http2.NextProtoTLS {
+			tlsConn.Close()
+			err = markError(fmt.Errorf("rpcx: server %s doesn't support h2", address), ErrUnsupportedNetwork)
+		}
+		conn = tlsConn
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cc, err := t.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	return &h2cConn{
+		conn:   conn,
+		cc:     cc,
+		url:    scheme + "://" + address + path,
+		ctx:    ctx,
+		cancel: cancel,
+		pr:     pr,
+		pw:     pw,
+		ctxs:   make(map[uint64]context.Context),
+	}, nil
+}
+
+func (c *h2cConn) bindCallContext(seq uint64, ctx context.Context) {
+	c.mu.Lock()
+	c.ctxs[seq] = ctx
+	c.mu.Unlock()
+}
+
+// callContext returns the context of the stream of the call seq, which is done if the call or the connection is done.
+func (c *h2cConn) callContext(seq uint64) (context.Context, context.CancelFunc) {
+	c.mu.Lock()
+	callCtx := c.ctxs[seq]
+	delete(c.ctxs, seq)
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	if callCtx == nil {
+		return ctx, cancel
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-callCtx.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+	return ctx, func() {
+		close(stop)
+		cancel()
+	}
+}
+
+// Read reads responses of streams.
+func (c *h2cConn) Read(p []byte) (int, error) {
+	return c.pr.Read(p)
+}
+
+// Write reassembles messages of p and sends each of them as a stream.
+func (c *h2cConn) Write(p []byte) (int, error) {
+	if c.ctx.Err() != nil {
+		return 0, net.ErrClosed
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.wbuf = append(c.wbuf, p...)
+	const headerSize = len(protocol.Header{})
+	for len(c.wbuf) >= headerSize+4 {
+		n := headerSize + 4 + int(binary.BigEndian.Uint32(c.wbuf[headerSize:]))
+		if len(c.wbuf) < n {
+			break
+		}
+
+		msg := protocol.GetPooledMsg()
+		if err := msg.Decode(bytes.NewReader(c.wbuf[:n])); err != nil {
+			protocol.FreeMsg(msg)
+			return 0, err
+		}
+		ctx, cancel := c.callContext(msg.Seq())
+		req, err := c.newRequest(ctx, msg, append([]byte(nil), c.wbuf[:n]...))
+		if err != nil {
+			cancel()
+			protocol.FreeMsg(msg)
+			return 0, err
+		}
+		go c.roundTrip(req, msg, cancel)
+		c.wbuf = append(c.wbuf[:0], c.wbuf[n:]...)
+	}
+	return len(p), nil
+}
+
+// newRequest creates the request of the stream of msg, whose body is data.
+func (c *h2cConn) newRequest(ctx context.Context, msg *protocol.Message, data []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	h := req.Header
+	h.Set("Content-Type", h2cContentType)
+	h.Set(XMessageID, strconv.FormatUint(msg.Seq(), 10))
+	h.Set(XSerializeType, strconv.Itoa(int(msg.SerializeType())))
+	if msg.IsHeartbeat() {
+		h.Set(XHeartbeat, "true")
+	} else {
+		h.Set(XServicePath, msg.ServicePath)
+		h.Set(XServiceMethod, msg.ServiceMethod)
+	}
+	if msg.IsOneway() {
+		h.Set(XOneway, "true")
+	}
+	for k, v := range msg.Metadata {
+		if header, ok := h2cHeaderOfMetadataKey(k); ok {
+			h.Set(header, v)
+		}
+	}
+	return req, nil
+}
+
+// h2cHeaderOfMetadataKey returns the X-RPCX- header of a metadata key like the HTTP gateway of the server.
+// Reserved keys starting with "__" and keys that are not valid in headers are not mapped.
+func h2cHeaderOfMetadataKey(key string) (string, bool) {
+	if key == "" || strings.HasPrefix(key, "__") {
+		return "", false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", false
+		}
+	}
+	return http.CanonicalHeaderKey("X-RPCX-" + key), true
+}
+
+// roundTrip sends the stream of msg and passes its response to Read.
+// Failed streams are responded by error responses, so their calls fail instead of waiting.
+func (c *h2cConn) roundTrip(req *http.Request, msg *protocol.Message, cancel context.CancelFunc) {
+	defer cancel()
+	defer protocol.FreeMsg(msg)
+
+	resp, err := c.cc.RoundTrip(req)
+	if err == nil {
+		defer resp.Body.Close()
+		if msg.IsOneway() {
+			return
+		}
+		var data []byte
+		if resp.StatusCode == http.StatusOK {
+			data, err = ioutil.ReadAll(resp.Body)
+		} else {
+			body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+			err = markError(fmt.Errorf("rpcx: h2c stream failed with %s: %s", resp.Status, bytes.TrimSpace(body)), ErrUnexpectedHTTPResponse)
+		}
+		if err == nil {
+			c.pw.Write(data)
+			return
+		}
+	}
+	// calls whose streams are reset by their contexts, or by the connection, fail with the errors of their contexts
+	if msg.IsOneway() || req.Context().Err() != nil {
+		return
+	}
+
+	res := protocol.GetPooledMsg()
+	res.SetMessageType(protocol.Response)
+	res.SetSeq(msg.Seq())
+	res.SetHeartbeat(msg.IsHeartbeat())
+	res.SetMessageStatusType(protocol.Error)
+	res.ServicePath = msg.ServicePath
+	res.ServiceMethod = msg.ServiceMethod
+	res.Metadata = map[string]string{protocol.ServiceError: err.Error()}
+	c.pw.Write(res.Encode())
+	protocol.FreeMsg(res)
+}
+
+// Close closes the connection and resets its streams.
+func (c *h2cConn) Close() error {
+	c.cancel()
+	c.pw.CloseWithError(io.EOF)
+	return c.cc.Close()
+}
+
+func (c *h2cConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *h2cConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline is ignored, since calls are bounded by their contexts.
+func (c *h2cConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadDeadline is ignored, since calls are bounded by their contexts.
+func (c *h2cConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline is ignored, since calls are bounded by their contexts.
+func (c *h2cConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
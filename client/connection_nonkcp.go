@@ -8,5 +8,5 @@ import (
 )
 
 func newDirectKCPConn(c *Client, network, address string) (net.Conn, error) {
-	return nil, errors.New("kcp unsupported")
+	return nil, markError(errors.New("kcp unsupported"), ErrUnsupportedNetwork)
 }
@@ -8,5 +8,9 @@ import (
 )
 
 func newDirectQuicConn(c *Client, network, address string) (net.Conn, error) {
-	return nil, errors.New("quic unsupported")
+	return nil, markError(errors.New("quic unsupported"), ErrUnsupportedNetwork)
+}
+
+func newDirectWebTransportConn(c *Client, network, address string) (net.Conn, error) {
+	return nil, markError(errors.New("webtransport unsupported"), ErrUnsupportedNetwork)
 }
@@ -0,0 +1,351 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+)
+
+func init() {
+	ConnFactories["udp"] = newDirectUDPConn
+	ConnFactories["udp4"] = newDirectUDPConn
+	ConnFactories["udp6"] = newDirectUDPConn
+}
+
+// DefaultUDPMaxDatagramSize is the default max size of datagrams of requests over udp,
+// which fits the MTU of Ethernet, so datagrams are not fragmented.
+const DefaultUDPMaxDatagramSize = 1472
+
+var (
+	// ErrDatagramTooLarge is the error of calls over udp whose encoded requests are larger than Option.UDPMaxDatagramSize.
+	ErrDatagramTooLarge = errors.New("rpcx: message is larger than the max datagram size")
+	// ErrUDPHeartbeat is the error of heartbeats over udp, which are not supported since udp is connectionless.
+	ErrUDPHeartbeat = errors.New("rpcx: heartbeats are not supported by udp")
+)
+
+// UDPRetransmit is the retransmit policy of calls over udp.
+// Requests without responses in Timeout are sent again, at most Retries times, and then their calls fail
+// with the Unavailable code. Zero Timeout disables retransmits, then calls wait for responses until their contexts are done.
+type UDPRetransmit struct {
+	Retries int
+	Timeout time.Duration
+}
+
+type udpRetransmitKey struct{}
+
+// WithUDPRetransmit returns a context whose calls over udp are retransmitted by policy instead of Option.UDPRetransmit.
+func WithUDPRetransmit(ctx context.Context, policy UDPRetransmit) context.Context {
+	return context.WithValue(ctx, udpRetransmitKey{}, policy)
+}
+
+// udpConn sends each message as a datagram of a udp socket, and passes datagrams of responses to Read.
+// Requests are retransmitted until their responses are received, and responses are passed once by their seqs,
+// so duplicate responses of retransmitted requests are dropped.
+//
+// udp is connectionless, so heartbeats, negotiation and messages sent by servers are not supported.
+type udpConn struct {
+	conn    *net.UDPConn
+	maxSize int
+	policy  UDPRetransmit
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	pr *io.PipeReader // responses, each written by one call of Write
+	pw *io.PipeWriter
+
+	mu    sync.Mutex
+	ctxs  map[uint64]context.Context // contexts of calls by seqs
+	calls map[uint64]*udpCall        // calls waiting for their responses
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+// udpCall is a request waiting for its response.
+type udpCall struct {
+	data          []byte
+	servicePath   string
+	serviceMethod string
+	done          chan struct{} // closed when the response is received
+}
+
+func isUDP(network string) bool {
+	return network == "udp" || network == "udp4" || network == "udp6"
+}
+
+func newDirectUDPConn(c *Client, network, address string) (net.Conn, error) {
+	if c == nil {
+		return nil, ErrEmptyClient
+	}
+	if c.option.SessionEncryption != nil {
+		return nil, fmt.Errorf("%w by %s", ErrSessionEncryptionUnsupported, network)
+	}
+	if c.option.Heartbeat {
+		return nil, ErrUDPHeartbeat
+	}
+
+	raddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP(network, nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSize := c.option.UDPMaxDatagramSize
+	if maxSize <= 0 {
+		maxSize = DefaultUDPMaxDatagramSize
+	}
+	pr, pw := io.Pipe()
+	uc := &udpConn{
+		conn:    conn,
+		maxSize: maxSize,
+		policy:  c.option.UDPRetransmit,
+		closed:  make(chan struct{}),
+		pr:      pr,
+		pw:      pw,
+		ctxs:    make(map[uint64]context.Context),
+		calls:   make(map[uint64]*udpCall),
+	}
+	go uc.readLoop()
+	return uc, nil
+}
+
+func (c *udpConn) bindCallContext(seq uint64, ctx context.Context) {
+	c.mu.Lock()
+	c.ctxs[seq] = ctx
+	c.mu.Unlock()
+}
+
+// readLoop reads datagrams and passes the first response of each waiting call to Read.
+func (c *udpConn) readLoop() {
+	const headerSize = len(protocol.Header{})
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			select {
+			case <-c.closed:
+				return
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				continue
+			}
+			c.pw.CloseWithError(err)
+			return
+		}
+
+		var h protocol.Header
+		if n < headerSize+4 || copy(h[:], buf[:n]) == 0 || !h.CheckMagicNumber() || h.MessageType() != protocol.Response {
+			continue // not a response, such as messages of servers which are not supported
+		}
+		c.mu.Lock()
+		call := c.calls[h.Seq()]
+		delete(c.calls, h.Seq())
+		c.mu.Unlock()
+		if call == nil {
+			continue // a duplicate response of a retransmitted request, or the response of a call which is done
+		}
+		close(call.done)
+		c.pw.Write(buf[:n])
+	}
+}
+
+// Read reads responses.
+func (c *udpConn) Read(p []byte) (int, error) {
+	return c.pr.Read(p)
+}
+
+// Write reassembles messages of p and sends each of them as a datagram.
+// Messages larger than the max datagram size fail with ErrDatagramTooLarge and heartbeats fail with ErrUDPHeartbeat.
+func (c *udpConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.wbuf = append(c.wbuf, p...)
+	const headerSize = len(protocol.Header{})
+	for len(c.wbuf) >= headerSize+4 {
+		n := headerSize + 4 + int(binary.BigEndian.Uint32(c.wbuf[headerSize:]))
+		if len(c.wbuf) < n {
+			break
+		}
+		data := append([]byte(nil), c.wbuf[:n]...)
+		c.wbuf = append(c.wbuf[:0], c.wbuf[n:]...)
+		if err := c.send(data); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// send sends the message of data, and retransmits it until its response is received if it is not oneway.
+func (c *udpConn) send(data []byte) error {
+	var h protocol.Header
+	copy(h[:], data)
+	seq := h.Seq()
+	c.mu.Lock()
+	ctx := c.ctxs[seq]
+	delete(c.ctxs, seq)
+	c.mu.Unlock()
+
+	if h.IsHeartbeat() {
+		return ErrUDPHeartbeat
+	}
+	if len(data) > c.maxSize {
+		return fmt.Errorf("%w: %d > %d", ErrDatagramTooLarge, len(data), c.maxSize)
+	}
+	if h.IsOneway() || h.MessageType() != protocol.Request {
+		_, err := c.conn.Write(data)
+		return err
+	}
+
+	msg := protocol.GetPooledMsg()
+	defer protocol.FreeMsg(msg)
+	if err := msg.Decode(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	call := &udpCall{
+		data:          data,
+		servicePath:   msg.ServicePath,
+		serviceMethod: msg.ServiceMethod,
+		done:          make(chan struct{}),
+	}
+	c.mu.Lock()
+	c.calls[seq] = call
+	c.mu.Unlock()
+
+	if _, err := c.conn.Write(data); err != nil {
+		c.removeCall(seq, call)
+		return err
+	}
+
+	policy := c.policy
+	if ctx == nil {
+		ctx = context.Background()
+	} else if p, ok := ctx.Value(udpRetransmitKey{}).(UDPRetransmit); ok {
+		policy = p
+	}
+	go c.retransmit(ctx, seq, call, policy)
+	return nil
+}
+
+// retransmit sends the request of call again by policy until its response is received,
+// and fails the call if no response is received after the last retransmit.
+func (c *udpConn) retransmit(ctx context.Context, seq uint64, call *udpCall, policy UDPRetransmit) {
+	defer c.removeCall(seq, call)
+
+	var timeout <-chan time.Time
+	var t *time.Timer
+	if policy.Timeout > 0 {
+		t = time.NewTimer(policy.Timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for i := 0; ; i++ {
+		select {
+		case <-call.done:
+			return
+		case <-ctx.Done():
+			return
+		case <-c.closed:
+			return
+		case <-timeout:
+		}
+
+		if i == policy.Retries {
+			c.failCall(seq, call, policy)
+			return
+		}
+		if _, err := c.conn.Write(call.data); err != nil {
+			c.failCall(seq, call, policy)
+			return
+		}
+		t.Reset(policy.Timeout)
+	}
+}
+
+func (c *udpConn) removeCall(seq uint64, call *udpCall) {
+	c.mu.Lock()
+	if c.calls[seq] == call {
+		delete(c.calls, seq)
+	}
+	c.mu.Unlock()
+}
+
+// failCall passes an error response of call to Read if its response has not been received.
+func (c *udpConn) failCall(seq uint64, call *udpCall, policy UDPRetransmit) {
+	c.mu.Lock()
+	waiting := c.calls[seq] == call
+	delete(c.calls, seq)
+	c.mu.Unlock()
+	if !waiting {
+		return
+	}
+
+	res := protocol.GetPooledMsg()
+	res.SetMessageType(protocol.Response)
+	res.SetSeq(seq)
+	res.SetMessageStatusType(protocol.Error)
+	res.ServicePath = call.servicePath
+	res.ServiceMethod = call.serviceMethod
+	res.Metadata = map[string]string{
+		protocol.ServiceError:     fmt.Sprintf("rpcx: no response over udp after %d retransmits in %v", policy.Retries, policy.Timeout),
+		protocol.ServiceErrorCode: strconv.Itoa(int(rerrors.Unavailable)),
+	}
+	c.pw.Write(res.Encode())
+	protocol.FreeMsg(res)
+}
+
+// Close closes the socket. Calls waiting for responses are not retransmitted any more.
+func (c *udpConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.pw.CloseWithError(io.EOF)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *udpConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline is ignored, since calls are bounded by their contexts and retransmit policies.
+func (c *udpConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadDeadline is ignored, since calls are bounded by their contexts and retransmit policies.
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline is ignored, since calls are bounded by their contexts and retransmit policies.
+func (c *udpConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
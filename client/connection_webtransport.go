@@ -0,0 +1,120 @@
+// +build quic
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/smallnest/rpcx/share"
+	"github.com/smallnest/rpcx/util"
+)
+
+// newDirectWebTransportConn establishes a WebTransport session over HTTP/3 to Option.RPCPath of address,
+// and opens a bidirectional stream of the session as the connection.
+func newDirectWebTransportConn(c *Client, network, address string) (net.Conn, error) {
+	tlsConf := c.option.TLSConfig
+	if tlsConf == nil {
+		tlsConf = &tls.Config{InsecureSkipVerify: true}
+	}
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{util.WebTransportALPN}
+
+	quicConfig := &quic.Config{
+		KeepAlive: c.option.Heartbeat,
+	}
+
+	ctx := context.Background()
+	if c.option.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.option.ConnectTimeout)
+		defer cancel()
+	}
+	sess, err := quic.DialAddrContext(ctx, address, tlsConf, quicConfig)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := openWebTransportStream(ctx, sess, address, c.option.RPCPath)
+	if err != nil {
+		sess.CloseWithError(0, err.Error())
+		return nil, err
+	}
+	return conn, nil
+}
+
+func openWebTransportStream(ctx context.Context, sess quic.Session, address, path string) (net.Conn, error) {
+	ctl, err := sess.OpenUniStream()
+	if err != nil {
+		return nil, err
+	}
+	if err := util.WriteWebTransportSettings(ctl); err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			stream, err := sess.AcceptUniStream(sess.Context())
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, stream)
+		}
+	}()
+
+	if path == "" {
+		path = share.DefaultRPCPath
+	}
+	connect, err := sess.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := util.WriteH3Headers(connect, util.WebTransportConnect(address, path)); err != nil {
+		return nil, err
+	}
+	fields, err := util.ReadH3Headers(connect)
+	if err != nil {
+		return nil, err
+	}
+	if status := util.H3FieldValue(fields, ":status"); status != "200" {
+		return nil, markError(fmt.Errorf("rpcx: failed to establish the WebTransport session of %s: status %s", path, status), ErrUnexpectedHTTPResponse)
+	}
+
+	stream, err := sess.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	signal := util.AppendVarint(nil, util.WebTransportBidiStream)
+	signal = util.AppendVarint(signal, uint64(connect.StreamID()))
+	if _, err := stream.Write(signal); err != nil {
+		return nil, err
+	}
+	return &webTransportConn{Stream: stream, connect: connect, session: sess}, nil
+}
+
+// webTransportConn is a bidirectional stream of a WebTransport session as a connection.
+type webTransportConn struct {
+	quic.Stream
+	connect quic.Stream
+	session quic.Session
+}
+
+// Close closes the stream and the session.
+func (c *webTransportConn) Close() error {
+	c.CancelRead(0)
+	err := c.Stream.Close()
+	c.connect.Close()
+	c.session.CloseWithError(0, "")
+	return err
+}
+
+func (c *webTransportConn) LocalAddr() net.Addr {
+	return c.session.LocalAddr()
+}
+
+func (c *webTransportConn) RemoteAddr() net.Addr {
+	return c.session.RemoteAddr()
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/smallnest/rpcx/util"
+)
+
+func init() {
+	ConnFactories[util.PipeNetwork] = newDirectPipeConn
+}
+
+// newDirectPipeConn connects to the Windows named pipe of address, which is the name of the pipe such as "agent",
+// or its path such as \\.\pipe\agent. It waits for a free instance of the pipe within Option.ConnectTimeout.
+// Connections of pipes are not wrapped by Option.TLSConfig.
+func newDirectPipeConn(c *Client, network, address string) (net.Conn, error) {
+	ctx := context.Background()
+	if c.option.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.option.ConnectTimeout)
+		defer cancel()
+	}
+	conn, err := util.DialPipe(ctx, util.PipePath(address))
+	if errors.Is(err, util.ErrPipeUnsupported) {
+		return nil, markError(err, ErrUnsupportedNetwork)
+	}
+	if err != nil {
+		logDialFailure(network, address, err)
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,18 @@
+// +build !windows
+
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/smallnest/rpcx/util"
+)
+
+func TestPipeUnsupported(t *testing.T) {
+	client := NewClient(DefaultOption)
+	err := client.Connect("winpipe", "agent")
+	if !errors.Is(err, ErrUnsupportedNetwork) || !errors.Is(err, util.ErrPipeUnsupported) {
+		t.Fatalf("expect the unsupported error but got %v", err)
+	}
+}
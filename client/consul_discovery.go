@@ -29,6 +29,8 @@ type ConsulDiscovery struct {
 
 	filter ServiceDiscoveryFilter
 
+	discoveryEvents
+
 	stopCh chan struct{}
 }
 
@@ -53,7 +55,7 @@ func NewConsulDiscoveryStore(basePath string, kv store.Store) (*ConsulDiscovery,
 		basePath = basePath[:len(basePath)-1]
 	}
 
-	d := &ConsulDiscovery{basePath: basePath, kv: kv}
+	d := &ConsulDiscovery{basePath: basePath, kv: kv, discoveryEvents: newDiscoveryEvents("consul")}
 	d.stopCh = make(chan struct{})
 
 	ps, err := kv.List(basePath)
@@ -170,6 +172,7 @@ func (d *ConsulDiscovery) watch() {
 					tempDelay = max
 				}
 				log.Warnf("can not watchtree (with retry %d, sleep %v): %s: %v", retry, tempDelay, d.basePath, err)
+				d.failed(DiscoveryWatch, err, true)
 				time.Sleep(tempDelay)
 				continue
 			}
@@ -178,6 +181,7 @@ func (d *ConsulDiscovery) watch() {
 
 		if err != nil {
 			log.Errorf("can't watch %s: %v", d.basePath, err)
+			d.failed(DiscoveryWatch, err, true)
 			return
 		}
 
@@ -214,6 +218,7 @@ func (d *ConsulDiscovery) watch() {
 				d.pairsMu.Lock()
 				d.pairs = pairs
 				d.pairsMu.Unlock()
+				d.updated()
 
 				d.mu.Lock()
 				for _, ch := range d.chans {
@@ -234,6 +239,7 @@ func (d *ConsulDiscovery) watch() {
 		}
 
 		log.Warn("chan is closed and will rewatch")
+		d.failed(DiscoveryWatch, errWatchClosed, false)
 	}
 }
 
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/rpcx/log"
+)
+
+// Operations of discoveries in DiscoveryError.
+const (
+	DiscoveryWatch   = "watch"
+	DiscoveryList    = "list"
+	DiscoveryRefresh = "refresh"
+)
+
+// DiscoveryErrorInterval limits DiscoveryError events of the same operation of a discovery to one event per interval,
+// so that a flapping registry doesn't flood callbacks. Events in the interval are counted by DiscoveryError.Suppressed.
+// Non-positive interval emits all events.
+var DiscoveryErrorInterval = 10 * time.Second
+
+// errWatchClosed is the error of watches closed by registries, which are watched again.
+var errWatchClosed = errors.New("watch is closed by the registry")
+
+// DiscoveryError is an error of a discovery, such as a broken watch of the registry.
+type DiscoveryError struct {
+	// Backend is the type of the registry, such as "consul", "zookeeper", "redis", "dns" or "mdns".
+	Backend string
+	// Op is the failed operation, DiscoveryWatch, DiscoveryList or DiscoveryRefresh.
+	Op  string
+	Err error
+	// Stale reports whether cached servers are considered stale, because they can't be updated until the discovery recovers.
+	Stale bool
+	// Staleness is how long cached servers have been stale, since the last successful update.
+	Staleness time.Duration
+	// Suppressed is the number of events of Op suppressed by DiscoveryErrorInterval since the last event.
+	Suppressed int
+}
+
+func (e DiscoveryError) Error() string {
+	return fmt.Sprintf("rpcx: %s discovery failed to %s: %v", e.Backend, e.Op, e.Err)
+}
+
+func (e DiscoveryError) Unwrap() error {
+	return e.Err
+}
+
+// DiscoveryErrorNotifier is a ServiceDiscovery which reports its errors. Built-in discoveries of registries implement it.
+type DiscoveryErrorNotifier interface {
+	// OnDiscoveryError adds a callback of errors. Callbacks are called by goroutines of the discovery and must not block.
+	OnDiscoveryError(fn func(err DiscoveryError))
+	// Staleness returns how long cached servers have been stale, or zero if they are up to date.
+	Staleness() time.Duration
+}
+
+// discoveryEvents emits errors of a discovery and tracks the staleness of its servers.
+type discoveryEvents struct {
+	backend string
+
+	mu        sync.Mutex
+	handlers  []func(err DiscoveryError)
+	updatedAt time.Time
+	stale     bool
+	sampler   log.Sampler
+}
+
+func newDiscoveryEvents(backend string) discoveryEvents {
+	return discoveryEvents{backend: backend, updatedAt: time.Now()}
+}
+
+func (e *discoveryEvents) OnDiscoveryError(fn func(err DiscoveryError)) {
+	e.mu.Lock()
+	e.handlers = append(e.handlers, fn)
+	e.mu.Unlock()
+}
+
+func (e *discoveryEvents) Staleness() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.stale {
+		return 0
+	}
+	return time.Since(e.updatedAt)
+}
+
+// updated marks servers up to date.
+func (e *discoveryEvents) updated() {
+	e.mu.Lock()
+	e.updatedAt = time.Now()
+	e.stale = false
+	e.mu.Unlock()
+}
+
+// failed emits the error of op to callbacks, and marks servers stale if stale is true.
+func (e *discoveryEvents) failed(op string, err error, stale bool) {
+	e.mu.Lock()
+	if stale {
+		e.stale = true
+	}
+	event := DiscoveryError{Backend: e.backend, Op: op, Err: err, Stale: e.stale}
+	if e.stale {
+		event.Staleness = time.Since(e.updatedAt)
+	}
+	handlers := e.handlers
+	e.mu.Unlock()
+
+	if len(handlers) == 0 {
+		return
+	}
+	ok, suppressed := e.sampler.Allow(op, DiscoveryErrorInterval)
+	if !ok {
+		return
+	}
+	event.Suppressed = suppressed
+	for _, fn := range handlers {
+		fn(event)
+	}
+}
+
+// OnDiscoveryError adds a callback of errors of the discovery of the xclient, if it implements DiscoveryErrorNotifier.
+// Callbacks get errors limited by DiscoveryErrorInterval and must not block.
+func (c *xClient) OnDiscoveryError(fn func(err DiscoveryError)) {
+	c.discoveryMu.Lock()
+	c.discoveryErrorHandlers = append(c.discoveryErrorHandlers, fn)
+	c.discoveryMu.Unlock()
+}
+
+func (c *xClient) discoveryError(err DiscoveryError) {
+	c.discoveryMu.Lock()
+	handlers := c.discoveryErrorHandlers
+	c.discoveryMu.Unlock()
+
+	if err.Stale {
+		atomic.StoreInt32(&c.discoveryStale, 1)
+	}
+	if c.Plugins != nil {
+		c.Plugins.DoDiscoveryStaleness(c.servicePath, err.Staleness)
+	}
+	for _, fn := range handlers {
+		fn(err)
+	}
+}
+
+// discoveryUpdated reports the zero staleness to plugins when servers are updated after errors.
+func (c *xClient) discoveryUpdated() {
+	if atomic.CompareAndSwapInt32(&c.discoveryStale, 1, 0) && c.Plugins != nil {
+		c.Plugins.DoDiscoveryStaleness(c.servicePath, 0)
+	}
+}
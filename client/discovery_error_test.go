@@ -0,0 +1,123 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rpcxio/libkv/store"
+)
+
+func TestDiscoveryEvents(t *testing.T) {
+	e := newDiscoveryEvents("dns")
+	var events []DiscoveryError
+	e.OnDiscoveryError(func(err DiscoveryError) { events = append(events, err) })
+
+	lookupErr := errors.New("no such host")
+	for i := 0; i < 3; i++ {
+		e.failed(DiscoveryRefresh, lookupErr, true)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expect 1 event in the interval but got %d", len(events))
+	}
+	if ev := events[0]; ev.Backend != "dns" || ev.Op != DiscoveryRefresh || !errors.Is(ev, lookupErr) || !ev.Stale || ev.Staleness <= 0 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	if e.Staleness() <= 0 {
+		t.Fatal("expect stale servers")
+	}
+
+	e.failed(DiscoveryWatch, errWatchClosed, false)
+	if len(events) != 2 || events[1].Op != DiscoveryWatch || !events[1].Stale {
+		t.Fatalf("expect a watch event of stale servers but got %+v", events)
+	}
+
+	e.updated()
+	if e.Staleness() != 0 {
+		t.Fatalf("expect fresh servers but got staleness %v", e.Staleness())
+	}
+}
+
+// flakyStore is a store whose first watch fails.
+type flakyStore struct {
+	store.Store
+	start   chan struct{}
+	watches int
+}
+
+func (s *flakyStore) List(directory string) ([]*store.KVPair, error) {
+	return nil, store.ErrKeyNotFound
+}
+
+func (s *flakyStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	<-s.start
+	s.watches++
+	if s.watches == 1 {
+		return nil, errors.New("ACL not found")
+	}
+	ch := make(chan []*store.KVPair, 1)
+	ch <- []*store.KVPair{{Key: directory + "/tcp@127.0.0.1:8972"}}
+	return ch, nil
+}
+
+func (s *flakyStore) Close() {}
+
+type stalenessPlugin struct {
+	mu        sync.Mutex
+	staleness []time.Duration
+}
+
+func (p *stalenessPlugin) DiscoveryStaleness(servicePath string, staleness time.Duration) {
+	p.mu.Lock()
+	p.staleness = append(p.staleness, staleness)
+	p.mu.Unlock()
+}
+
+func (p *stalenessPlugin) get() []time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]time.Duration(nil), p.staleness...)
+}
+
+func TestXClientOnDiscoveryError(t *testing.T) {
+	kv := &flakyStore{start: make(chan struct{})}
+	d, err := NewConsulDiscoveryStore("rpcx/Arith", kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	xclient := NewXClient("Arith", Failfast, RandomSelect, d, DefaultOption)
+	defer xclient.Close()
+	p := &stalenessPlugin{}
+	xclient.GetPlugins().Add(p)
+	errs := make(chan DiscoveryError, 10)
+	xclient.OnDiscoveryError(func(err DiscoveryError) { errs <- err })
+	close(kv.start)
+
+	select {
+	case e := <-errs:
+		if e.Backend != "consul" || e.Op != DiscoveryWatch || !e.Stale || e.Err.Error() != "ACL not found" {
+			t.Fatalf("unexpected error %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect an error of the watch")
+	}
+
+	// the watch is retried, and servers are updated
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := p.get()
+		if len(s) == 2 && s[0] > 0 && s[1] == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the staleness and then zero but got %v", s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if d.Staleness() != 0 || len(d.GetServices()) != 1 {
+		t.Fatalf("expect updated servers but got %v, %v", d.Staleness(), d.GetServices())
+	}
+}
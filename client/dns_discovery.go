@@ -26,12 +26,14 @@ type DNSDiscovery struct {
 
 	filter ServiceDiscoveryFilter
 
+	discoveryEvents
+
 	stopCh chan struct{}
 }
 
 // NewPeer2PeerDiscovery returns a new Peer2PeerDiscovery.
 func NewDNSDiscovery(domain string, network string, port int, d time.Duration) (*DNSDiscovery, error) {
-	discovery := &DNSDiscovery{domain: domain, network: network, port: port, d: d}
+	discovery := &DNSDiscovery{domain: domain, network: network, port: port, d: d, discoveryEvents: newDiscoveryEvents("dns")}
 	discovery.lookup()
 	go discovery.watch()
 	return discovery, nil
@@ -86,6 +88,7 @@ func (d *DNSDiscovery) lookup() {
 	ips, err := net.LookupIP(d.domain)
 	if err != nil {
 		log.Errorf("failed to lookup %s: %v", d.domain, err)
+		d.failed(DiscoveryRefresh, err, true)
 		return
 	}
 
@@ -106,6 +109,7 @@ func (d *DNSDiscovery) lookup() {
 	d.pairsMu.Lock()
 	d.pairs = pairs
 	d.pairsMu.Unlock()
+	d.updated()
 
 	d.mu.Lock()
 	for _, ch := range d.chans {
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"errors"
+)
+
+// Errors of the client package, by which callers branch with errors.Is and errors.As instead of messages.
+// Sentinel errors are wrapped with the errors they are caused by, and messages are kept as they were.
+//
+// Errors of connections:
+//   - *DialError, matching ErrDial, for failures of connecting servers. It wraps the errors of networks,
+//     TLS and factories, such as *net.OpError, tls.RecordHeaderError, ErrUnsupportedNetwork,
+//     ErrSessionEncryptionUnsupported and ErrUnexpectedHTTPResponse.
+//   - *protocol.HandshakeError for failures of exchanging session keys.
+//   - ErrShutdown for calls of clients which are closed or whose connections are broken,
+//     and ErrConnectionBroken for pending calls of broken connections, which wraps the error of reading, such as io.ErrUnexpectedEOF.
+//
+// Errors of requests:
+//   - ErrUnsupportedCodec for serialize types without codecs.
+//   - ErrDatagramTooLarge and ErrUDPHeartbeat for udp.
+//   - ErrEmptyClient for connection factories called without clients.
+//   - ErrAuthToken for tokens which Option.AuthFunc fails to fetch, which wraps the error of AuthFunc.
+//   - ErrInvalidSignature for responses failing verification of RequestSigningPlugin, which are not retried.
+//   - ErrClientRateLimited for calls rejected by Option.RateLimiter, which are not retried.
+//   - ErrSendQueueFull for calls failed by Option.FailFastOnFullQueue, whose connections are kept.
+//   - ErrDropRequest, returned by ClientBeforeEncode plugins, drops requests without failing calls.
+//
+// Errors of deadlines:
+//   - *TimeoutError, matching context.DeadlineExceeded, and ErrTimeoutBeforeSend or ErrTimeoutAwaitingResponse,
+//     for deadlines expired on clients, and ErrBudgetExhausted for calls skipped by Option.MinHopBudget.
+//   - context.Canceled for canceled calls.
+//   - *CodedServiceError matching errors.ErrDeadlineExceeded of the errors package for deadlines expired on servers.
+//
+// Errors of servers:
+//   - ServiceError for errors returned by services, or *CodedServiceError if servers set their codes or details,
+//     which matches ServiceError by errors.As and sentinel errors of the errors package with the same code.
+//
+// Errors of XClient:
+//   - ErrXClientShutdown for calls of closed XClients.
+//   - ErrXClientNoServer if no server is discovered or selected, and ErrServerUnavailable if the selected one can't be connected.
+//   - ErrBreakerOpen for servers whose circuit breakers are open.
+//   - *DiscoveryError for failures of discoveries, which wraps the errors of registries.
+//   - *errors.MultiError of the errors package for Broadcast, Fork and Inform, which matches the errors of any server
+//     by errors.Is and errors.As, and ErrBroadcastTimeout if servers don't answer in time.
+var (
+	// ErrDial matches errors of connecting servers, which are *DialError.
+	ErrDial = errors.New("rpcx: failed to dial")
+	// ErrConnectionBroken matches errors of pending calls whose connections are broken.
+	ErrConnectionBroken = errors.New("rpcx: connection is broken")
+	// ErrUnsupportedNetwork is the error of networks which are not built in, such as kcp and quic without their build tags.
+	ErrUnsupportedNetwork = errors.New("rpcx: network is not supported")
+	// ErrSessionEncryptionUnsupported is the error of Option.SessionEncryption of networks which don't support it.
+	ErrSessionEncryptionUnsupported = errors.New("rpcx: session encryption is not supported")
+	// ErrUnexpectedHTTPResponse is the error of HTTP responses which don't establish connections or streams.
+	ErrUnexpectedHTTPResponse = errors.New("unexpected HTTP response")
+	// ErrEmptyClient is the error of connection factories called with a nil client.
+	ErrEmptyClient = errors.New("empty client")
+	// ErrAuthToken matches errors of calls whose tokens can't be fetched by Option.AuthFunc.
+	ErrAuthToken = errors.New("rpcx: failed to fetch the auth token")
+	// ErrDropRequest is returned by ClientBeforeEncode of plugins to drop requests, such as by ChaosPlugin.
+	// Dropped requests are not sent and their calls are pending until they are canceled or time out,
+	// like calls of requests lost by networks, while oneway calls are done without errors.
+	ErrDropRequest = errors.New("rpcx: request is dropped")
+	// ErrBroadcastTimeout is added to errors of Broadcast, Fork and Inform if some servers don't answer in a minute.
+	ErrBroadcastTimeout = errors.New("timeout")
+)
+
+// DialError is the error of connecting a server. Its message is the message of Err.
+type DialError struct {
+	Network string
+	Address string
+	Err     error
+}
+
+func (e *DialError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns Err.
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrDial.
+func (e *DialError) Is(target error) bool {
+	return target == ErrDial
+}
+
+// markedError is an error with the message of err, which matches sentinel and what err matches by errors.Is.
+type markedError struct {
+	err      error
+	sentinel error
+}
+
+// markError marks err as sentinel, so it matches sentinel without changing its message.
+func markError(err, sentinel error) error {
+	return &markedError{err: err, sentinel: sentinel}
+}
+
+func (e *markedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *markedError) Unwrap() error {
+	return e.err
+}
+
+func (e *markedError) Is(target error) bool {
+	return target == e.sentinel
+}
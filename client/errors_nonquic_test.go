@@ -0,0 +1,15 @@
+// +build !quic
+
+package client
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUnsupportedNetworkErrors(t *testing.T) {
+	err := NewClient(DefaultOption).Connect("quic", "127.0.0.1:8972")
+	if !errors.Is(err, ErrDial) || !errors.Is(err, ErrUnsupportedNetwork) || err.Error() != "quic unsupported" {
+		t.Fatalf("expect an unsupported network but got %v", err)
+	}
+}
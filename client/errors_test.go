@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// errors of the client package by failure modes, see the taxonomy of errors.go
+
+func TestDialErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	// errors of networks are wrapped with their messages
+	err = NewClient(DefaultOption).Connect("tcp", addr)
+	var de *DialError
+	var oe *net.OpError
+	if !errors.Is(err, ErrDial) || !errors.As(err, &de) || !errors.As(err, &oe) {
+		t.Fatalf("expect a dial error but got %#v", err)
+	}
+	if de.Network != "tcp" || de.Address != addr || err.Error() != oe.Error() {
+		t.Fatalf("unexpected dial error %v of %s@%s", err, de.Network, de.Address)
+	}
+
+	// errors of TLS handshakes
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(conn, "HTTP/1.0 400 Bad Request\r\n\r\n")
+			conn.Close()
+		}
+	}()
+	option := DefaultOption
+	option.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	err = NewClient(option).Connect("tcp", ln.Addr().String())
+	var rhe tls.RecordHeaderError
+	if !errors.Is(err, ErrDial) || !errors.As(err, &rhe) {
+		t.Fatalf("expect a TLS error but got %#v", err)
+	}
+
+	// HTTP responses which don't establish connections
+	hs := httptest.NewServer(http.NotFoundHandler())
+	defer hs.Close()
+	err = NewClient(DefaultOption).Connect("http", strings.TrimPrefix(hs.URL, "http://"))
+	if !errors.Is(err, ErrDial) || !errors.Is(err, ErrUnexpectedHTTPResponse) || !errors.As(err, &oe) {
+		t.Fatalf("expect an unexpected HTTP response but got %#v", err)
+	}
+	if !strings.Contains(err.Error(), "unexpected HTTP response: 404") {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+
+	// options which the network doesn't support
+	option = DefaultOption
+	option.SessionEncryption = &protocol.SessionConfig{}
+	err = NewClient(option).Connect("udp", addr)
+	if !errors.Is(err, ErrDial) || !errors.Is(err, ErrSessionEncryptionUnsupported) {
+		t.Fatalf("expect session encryption unsupported but got %#v", err)
+	}
+	if err.Error() != "rpcx: session encryption is not supported by udp" {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+
+	if _, err = newDirectHTTPConn(nil, "http", addr); err != ErrEmptyClient {
+		t.Fatalf("expect ErrEmptyClient but got %v", err)
+	}
+}
+
+func TestConnectionErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		// reads the request and closes the connection
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		ioutil.ReadAll(conn)
+		conn.Close()
+	}()
+
+	option := DefaultOption
+	option.NegotiateTimeout = 0
+	client := NewClient(option)
+	if err := client.Connect("tcp", ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// pending calls of broken connections
+	err = client.Call(context.Background(), "Arith", "Mul", &Args{}, &Reply{})
+	if !errors.Is(err, ErrConnectionBroken) || !errors.Is(err, io.ErrUnexpectedEOF) || err.Error() != io.ErrUnexpectedEOF.Error() {
+		t.Fatalf("expect a broken connection but got %#v", err)
+	}
+
+	// calls after connections are broken or closed
+	if err = client.Call(context.Background(), "Arith", "Mul", &Args{}, &Reply{}); !errors.Is(err, ErrShutdown) {
+		t.Fatalf("expect ErrShutdown but got %v", err)
+	}
+	client.Close()
+	if err = client.Call(context.Background(), "Arith", "Mul", &Args{}, &Reply{}); !errors.Is(err, ErrShutdown) {
+		t.Fatalf("expect ErrShutdown but got %v", err)
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	addr := startStatsServer(t)
+	client := NewClient(DefaultOption)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx := WithSerializeType(context.Background(), protocol.SerializeType(100))
+	if err := client.Call(ctx, "Stats", "Mul", &Args{}, &Reply{}); !errors.Is(err, ErrUnsupportedCodec) {
+		t.Fatalf("expect ErrUnsupportedCodec but got %v", err)
+	}
+
+	// errors of services
+	err := client.Call(context.Background(), "Stats", "Fail", &Args{}, &Reply{})
+	var se ServiceError
+	if !errors.As(err, &se) || err.Error() != "failed" {
+		t.Fatalf("expect a service error but got %#v", err)
+	}
+	err = client.Call(context.Background(), "Stats", "Missing", &Args{}, &Reply{})
+	if !errors.As(err, &se) {
+		t.Fatalf("expect a service error but got %#v", err)
+	}
+}
+
+type closedBreaker struct{}
+
+func (closedBreaker) Call(fn func() error, d time.Duration) error { return fn() }
+func (closedBreaker) Fail()                                       {}
+func (closedBreaker) Success()                                    {}
+func (closedBreaker) Ready() bool                                 { return false }
+
+func TestXClientErrors(t *testing.T) {
+	addr := startStatsServer(t)
+
+	d, err := NewMultipleServersDiscovery(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xclient := NewXClient("Stats", Failtry, RandomSelect, d, DefaultOption)
+	if err = xclient.Call(context.Background(), "Mul", &Args{}, &Reply{}); !errors.Is(err, ErrXClientNoServer) {
+		t.Fatalf("expect ErrXClientNoServer but got %v", err)
+	}
+	xclient.Close()
+
+	p2p, err := NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	option := DefaultOption
+	option.GenBreaker = func() Breaker { return closedBreaker{} }
+	xclient = NewXClient("Stats", Failfast, RandomSelect, p2p, option)
+	xclient.Call(context.Background(), "Mul", &Args{}, &Reply{}) // the breaker is created with the client
+	if err = xclient.Call(context.Background(), "Mul", &Args{}, &Reply{}); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expect ErrBreakerOpen but got %v", err)
+	}
+	xclient.Close()
+	if err = xclient.Call(context.Background(), "Mul", &Args{}, &Reply{}); !errors.Is(err, ErrXClientShutdown) {
+		t.Fatalf("expect ErrXClientShutdown but got %v", err)
+	}
+
+	// errors of broadcasts match errors of every server
+	d, err = NewMultipleServersDiscovery([]*KVPair{{Key: "tcp@" + addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xclient = NewXClient("Stats", Failfast, RandomSelect, d, DefaultOption)
+	defer xclient.Close()
+	// coded errors, such as those of missing methods, carry the servers which returned them
+	err = xclient.Broadcast(context.Background(), "Missing", &Args{}, &Reply{})
+	var me *rerrors.MultiError
+	var se *CodedServiceError
+	if !errors.As(err, &me) || !errors.As(err, &se) || se.Node() != addr {
+		t.Fatalf("expect the error of the server but got %#v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"net"
+	"os"
+
+	"github.com/juju/ratelimit"
+	"github.com/smallnest/rpcx/share"
+)
+
+// SendFileResumable sends a local file to the server like SendFile, but resumes from the offset the server has committed
+// if the connection breaks, and the server verifies the file with its SHA-256 before it succeeds.
+// It gives up after Option.Retries broken connections without progress. The server must set FileTransfer.ReceivedFileHandler.
+func (c *xClient) SendFileResumable(ctx context.Context, fileName string, rateInBytesPerSecond int64, meta map[string]string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return err
+	}
+	sum := h.Sum(nil)
+
+	args := share.FileTransferArgs{
+		FileName:  fi.Name(),
+		FileSize:  fi.Size(),
+		Meta:      meta,
+		Resumable: true,
+	}
+	reply := &share.FileTransferReply{}
+	if err = c.Call(ctx, "TransferFile", args, reply); err != nil {
+		return err
+	}
+
+	var tb *ratelimit.Bucket
+	if rateInBytesPerSecond > 0 {
+		tb = ratelimit.NewBucketWithRate(float64(rateInBytesPerSecond), rateInBytesPerSecond)
+	}
+	return c.resumeFile(ctx, func() (int64, bool, error) {
+		return c.uploadFile(ctx, reply, file, sum, tb)
+	})
+}
+
+// uploadFile sends file from the offset committed by the server, and returns the offset and whether to resume on errors.
+func (c *xClient) uploadFile(ctx context.Context, reply *share.FileTransferReply, file *os.File, sum []byte, tb *ratelimit.Bucket) (int64, bool, error) {
+	conn, err := c.dialFileTransfer(ctx, reply)
+	if err != nil {
+		return 0, true, err
+	}
+	defer conn.Close()
+
+	offset, err := share.ReadFileOffset(conn)
+	if err != nil {
+		return 0, true, err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return offset, false, err
+	}
+
+	w := bufio.NewWriterSize(conn, 2*FileTransferBufferSize)
+	buf := make([]byte, FileTransferBufferSize)
+	for {
+		if tb != nil {
+			tb.Wait(FileTransferBufferSize)
+		}
+		n, er := file.Read(buf)
+		if n > 0 {
+			if err = share.WriteFileChunk(w, buf[:n]); err != nil {
+				return offset, true, err
+			}
+		}
+		if er == io.EOF {
+			break
+		}
+		if er != nil {
+			return offset, false, er
+		}
+	}
+	if err = share.WriteFileEnd(w, sum); err != nil {
+		return offset, true, err
+	}
+	if err = w.Flush(); err != nil {
+		return offset, true, err
+	}
+	err = share.ReadFileStatus(conn)
+	return offset, !errors.Is(err, share.ErrFileTransfer) && !errors.Is(err, share.ErrFileChecksum), err
+}
+
+// DownloadFileResumable downloads a file of the server to saveTo like DownloadFile, but resumes from the bytes received
+// if the connection breaks, and verifies the file with its SHA-256 before it is renamed from saveTo+".part" to saveTo.
+// It gives up after Option.Retries broken connections without progress. The server must set FileTransfer.OpenFileHandler.
+func (c *xClient) DownloadFileResumable(ctx context.Context, requestFileName string, saveTo string, meta map[string]string) error {
+	args := share.DownloadFileArgs{
+		FileName:  requestFileName,
+		Meta:      meta,
+		Resumable: true,
+	}
+	reply := &share.FileTransferReply{}
+	if err := c.Call(ctx, "DownloadFile", args, reply); err != nil {
+		return err
+	}
+
+	part := saveTo + ".part"
+	file, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	h := sha256.New()
+	err = c.resumeFile(ctx, func() (int64, bool, error) {
+		start := offset
+		retry, err := c.downloadFile(ctx, reply, file, h, &offset)
+		return start, retry, err
+	})
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(part, saveTo)
+	}
+	if err != nil {
+		os.Remove(part)
+	}
+	return err
+}
+
+// downloadFile receives chunks from offset into file and h, and returns whether to resume on errors.
+// Only whole chunks which match their checksums are written, so offset is the size of file.
+func (c *xClient) downloadFile(ctx context.Context, reply *share.FileTransferReply, file *os.File, h hash.Hash, offset *int64) (bool, error) {
+	conn, err := c.dialFileTransfer(ctx, reply)
+	if err != nil {
+		return true, err
+	}
+	defer conn.Close()
+
+	if err = share.WriteFileOffset(conn, *offset); err != nil {
+		return true, err
+	}
+
+	r := bufio.NewReader(conn)
+	buf := make([]byte, share.MaxFileChunkSize)
+	for {
+		p, end, err := share.ReadFileFrame(r, buf)
+		if err != nil {
+			return true, err
+		}
+		if end {
+			if !bytes.Equal(h.Sum(nil), p) {
+				share.WriteFileStatus(conn, share.ErrFileChecksum)
+				return false, share.ErrFileChecksum
+			}
+			// the file is verified even if the server doesn't receive the status
+			share.WriteFileStatus(conn, nil)
+			return false, nil
+		}
+		if _, err = file.Write(p); err != nil {
+			share.WriteFileStatus(conn, err)
+			return false, err
+		}
+		h.Write(p)
+		*offset += int64(len(p))
+	}
+}
+
+// resumeFile runs transfer until it succeeds or fails with errors which can't be resumed.
+// transfer returns the offset it starts from, and it is given up after Option.Retries attempts without progress.
+func (c *xClient) resumeFile(ctx context.Context, transfer func() (offset int64, retry bool, err error)) error {
+	last := int64(-1)
+	failures := 0
+	for {
+		offset, retry, err := transfer()
+		if err == nil || !retry {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if offset > last {
+			failures = 0
+		}
+		last = offset
+		failures++
+		if failures > c.getOption().Retries {
+			return err
+		}
+	}
+}
+
+// dialFileTransfer connects the streaming port of reply with its token, and closes the connection when ctx is done.
+func (c *xClient) dialFileTransfer(ctx context.Context, reply *share.FileTransferReply) (net.Conn, error) {
+	d := net.Dialer{Timeout: c.getOption().ConnectTimeout}
+	conn, err := d.DialContext(ctx, "tcp", reply.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(reply.Token); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if ctx.Done() != nil {
+		conn = &ctxConn{Conn: conn, stop: make(chan struct{})}
+		go func(cc *ctxConn) {
+			select {
+			case <-ctx.Done():
+				cc.Conn.Close()
+			case <-cc.stop:
+			}
+		}(conn.(*ctxConn))
+	}
+	return conn, nil
+}
+
+// ctxConn is a connection which is closed when a context is done.
+type ctxConn struct {
+	net.Conn
+	stop chan struct{}
+}
+
+func (c *ctxConn) Close() error {
+	close(c.stop)
+	return c.Conn.Close()
+}
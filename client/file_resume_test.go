@@ -0,0 +1,246 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// cuttingProxy forwards connections to addr, and breaks its first connection after cut bytes in either direction.
+type cuttingProxy struct {
+	ln    net.Listener
+	addr  string
+	cut   int64
+	conns int32
+}
+
+func newCuttingProxy(t *testing.T, addr string, cut int64) *cuttingProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &cuttingProxy{ln: ln, addr: addr, cut: cut}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.forward(conn, atomic.AddInt32(&p.conns, 1) == 1)
+		}
+	}()
+	return p
+}
+
+func (p *cuttingProxy) forward(conn net.Conn, cut bool) {
+	upstream, err := net.Dial("tcp", p.addr)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	var n int64
+	pipe := func(dst, src net.Conn) {
+		defer dst.Close()
+		defer src.Close()
+		buf := make([]byte, 512)
+		for {
+			m, err := src.Read(buf)
+			if m > 0 {
+				if cut && atomic.AddInt64(&n, int64(m)) > p.cut {
+					return
+				}
+				if _, err := dst.Write(buf[:m]); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	go pipe(upstream, conn)
+	pipe(conn, upstream)
+}
+
+func randomOffset(t *testing.T, min, max int64) int64 {
+	n, err := rand.Int(rand.Reader, big.NewInt(max-min))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return min + n.Int64()
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func startFileServer(t *testing.T, ft *server.FileTransfer) (*server.Server, string) {
+	s := server.NewServer()
+	s.EnableFileTransfer(share.SendFileServiceName, ft)
+	addr := startServer(t, s)
+	// waits for the streaming port
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", ft.Addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s, addr
+}
+
+func TestResumableFileTransfer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpcx-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := make([]byte, 256*1024+123)
+	rand.Read(data)
+	source := filepath.Join(dir, "source")
+	if err = ioutil.WriteFile(source, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []byte, 1)
+	for _, upload := range []bool{true, false} {
+		ft := server.NewFileTransfer(freeAddr(t), nil, nil, 10)
+		ft.TempDir = dir
+		ft.ReceivedFileHandler = func(path string, args *share.FileTransferArgs) error {
+			b, err := ioutil.ReadFile(path)
+			received <- b
+			return err
+		}
+		ft.OpenFileHandler = func(args *share.DownloadFileArgs) (io.ReadSeekCloser, error) {
+			return os.Open(filepath.Join(dir, args.FileName))
+		}
+		// connections to the streaming port break at a random offset at first,
+		// and the proxy is advertised before the server is started since handlers read it
+		proxy := newCuttingProxy(t, ft.Addr, randomOffset(t, 1024, int64(len(data))))
+		ft.AdvertiseAddr = proxy.ln.Addr().String()
+		s, addr := startFileServer(t, ft)
+
+		d, _ := NewPeer2PeerDiscovery("tcp@"+addr, "")
+		xclient := NewXClient(share.SendFileServiceName, Failtry, RandomSelect, d, DefaultOption)
+		if upload {
+			if err = xclient.SendFileResumable(context.Background(), source, 0, nil); err != nil {
+				t.Fatalf("failed to upload: %v", err)
+			}
+			if !bytes.Equal(data, <-received) {
+				t.Fatalf("uploaded file is different from the source")
+			}
+		} else {
+			saveTo := filepath.Join(dir, "download")
+			if err = xclient.DownloadFileResumable(context.Background(), "source", saveTo, nil); err != nil {
+				t.Fatalf("failed to download: %v", err)
+			}
+			b, err := ioutil.ReadFile(saveTo)
+			if err != nil || !bytes.Equal(data, b) {
+				t.Fatalf("downloaded file is different from the source: %v", err)
+			}
+			if _, err = os.Stat(saveTo + ".part"); !os.IsNotExist(err) {
+				t.Fatalf("expect the partial file to be renamed but got %v", err)
+			}
+		}
+		if n := atomic.LoadInt32(&proxy.conns); n < 2 {
+			t.Fatalf("expect the transfer to be resumed but got %d connections", n)
+		}
+		xclient.Close()
+		proxy.ln.Close()
+		s.Close()
+	}
+}
+
+func TestResumableFileTransferVerifyAndExpire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpcx-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ft := server.NewFileTransfer(freeAddr(t), nil, nil, 10)
+	ft.TempDir = dir
+	ft.TokenTTL = 200 * time.Millisecond
+	ft.ReceivedFileHandler = func(path string, args *share.FileTransferArgs) error {
+		t.Errorf("unexpected received file of %s", args.FileName)
+		return nil
+	}
+	s, addr := startFileServer(t, ft)
+	defer s.Close()
+
+	client := NewClient(DefaultOption)
+	if err = client.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	transfer := func() (*share.FileTransferReply, net.Conn) {
+		reply := &share.FileTransferReply{}
+		args := &share.FileTransferArgs{FileName: "f", FileSize: 4, Resumable: true}
+		if err := client.Call(context.Background(), share.SendFileServiceName, "TransferFile", args, reply); err != nil {
+			t.Fatal(err)
+		}
+		conn, err := net.Dial("tcp", reply.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write(reply.Token)
+		if offset, err := share.ReadFileOffset(conn); err != nil || offset != 0 {
+			t.Fatalf("unexpected offset %d: %v", offset, err)
+		}
+		return reply, conn
+	}
+
+	// files which don't match their sums fail
+	_, conn := transfer()
+	share.WriteFileChunk(conn, []byte("data"))
+	wrong := sha256.Sum256([]byte("tada"))
+	share.WriteFileEnd(conn, wrong[:])
+	if err = share.ReadFileStatus(conn); !errors.Is(err, share.ErrFileChecksum) {
+		t.Fatalf("expect ErrFileChecksum but got %v", err)
+	}
+	conn.Close()
+
+	// partial files of expired tokens are removed
+	reply, conn := transfer()
+	share.WriteFileChunk(conn, []byte("da"))
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	offset := &share.FileOffsetReply{}
+	if err = client.Call(context.Background(), share.SendFileServiceName, "FileOffset", &share.FileOffsetArgs{Token: reply.Token}, offset); err != nil || offset.Offset != 2 {
+		t.Fatalf("expect the committed offset 2 but got %d: %v", offset.Offset, err)
+	}
+	parts, _ := filepath.Glob(filepath.Join(dir, "*.part"))
+	if len(parts) != 1 {
+		t.Fatalf("expect a partial file but got %v", parts)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	parts, _ = filepath.Glob(filepath.Join(dir, "*.part"))
+	if len(parts) != 0 {
+		t.Fatalf("expect partial files to be removed but got %v", parts)
+	}
+	err = client.Call(context.Background(), share.SendFileServiceName, "FileOffset", &share.FileOffsetArgs{Token: reply.Token}, offset)
+	if err == nil || err.Error() != server.ErrUnknownFileToken.Error() {
+		t.Fatalf("expect ErrUnknownFileToken but got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/smallnest/rpcx/share"
+)
+
+// ErrBudgetExhausted matches timeouts of calls made with contexts of handlers which are not sent,
+// because the budgets left by the deadlines propagated to the handlers are less than Option.MinHopBudget.
+var ErrBudgetExhausted = errors.New("rpcx: deadline budget is exhausted")
+
+// hopBudgetKey marks contexts of calls whose budgets are applied, so they are not applied again.
+type hopBudgetKey struct{}
+
+// applyHopBudget returns ctx with the deadline of the budget of a call made with ctx, if ctx carries a deadline
+// propagated to a handler, and the function which cancels it, or nil if there is no budget.
+// It fails with a TimeoutError matching ErrBudgetExhausted if the budget is less than Option.MinHopBudget.
+func (client *Client) applyHopBudget(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	o := &client.option
+	if o.HopReserve <= 0 && o.MinHopBudget <= 0 && o.MaxHopBudgetShare <= 0 || ctx.Value(hopBudgetKey{}) != nil {
+		return ctx, nil, nil
+	}
+	remaining, ok := share.RemainingBudget(ctx)
+	if !ok {
+		return ctx, nil, nil
+	}
+
+	budget := remaining - o.HopReserve
+	if o.MaxHopBudgetShare > 0 && o.MaxHopBudgetShare < 1 {
+		if max := time.Duration(float64(remaining) * o.MaxHopBudgetShare); budget > max {
+			budget = max
+		}
+	}
+	if budget <= 0 || budget < o.MinHopBudget {
+		return ctx, nil, &TimeoutError{BudgetExhausted: true}
+	}
+	bctx, cancel := context.WithTimeout(ctx, budget)
+	return context.WithValue(bctx, hopBudgetKey{}, true), cancel, nil
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// chainService calls Hop of the next server with the context of the handler, after sleeping.
+type chainService struct {
+	next   *Client
+	sleep  int64 // nanoseconds
+	calls  int32
+	budget int64 // the remaining budget seen by the last call, in nanoseconds
+}
+
+func (s *chainService) Hop(ctx context.Context, args *Args, reply *Reply) error {
+	atomic.AddInt32(&s.calls, 1)
+	if budget, ok := share.RemainingBudget(ctx); ok {
+		atomic.StoreInt64(&s.budget, int64(budget))
+	}
+	time.Sleep(time.Duration(atomic.LoadInt64(&s.sleep)))
+	if s.next == nil {
+		return nil
+	}
+	return s.next.Call(ctx, "Chain", "Hop", args, reply)
+}
+
+func startChainServer(t *testing.T, svc *chainService) string {
+	s := server.NewServer()
+	s.RegisterName("Chain", svc, "")
+	return startServer(t, s)
+}
+
+func connectChain(t *testing.T, option Option, addr string) *Client {
+	client := NewClient(option)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestHopBudget(t *testing.T) {
+	option := DefaultOption
+	option.HopReserve = 50 * time.Millisecond
+	option.MaxHopBudgetShare = 0.8
+	option.MinHopBudget = 100 * time.Millisecond
+
+	c := &chainService{}
+	b := &chainService{next: connectChain(t, option, startChainServer(t, c))}
+	a := &chainService{next: connectChain(t, option, startChainServer(t, b))}
+	edge := connectChain(t, option, startChainServer(t, a))
+
+	// each hop gets its share of the budget
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := edge.Call(ctx, "Chain", "Hop", &Args{}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	// the deadline of the edge is not a propagated one, so it is kept
+	if budget := time.Duration(atomic.LoadInt64(&a.budget)); budget <= 800*time.Millisecond {
+		t.Fatalf("expect the deadline of the edge to be propagated as it is, but got %v", budget)
+	}
+	if budget := time.Duration(atomic.LoadInt64(&b.budget)); budget > 800*time.Millisecond {
+		t.Fatalf("expect the budget of B to be capped by the share, but got %v", budget)
+	}
+	if budget := time.Duration(atomic.LoadInt64(&c.budget)); budget <= 0 || budget > 640*time.Millisecond {
+		t.Fatalf("expect the budget of C to be capped again, but got %v", budget)
+	}
+
+	// B uses up its budget, so the innermost call is skipped instead of timing out late
+	atomic.StoreInt64(&b.sleep, int64(400*time.Millisecond))
+	ctx, cancel = context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := edge.Call(ctx, "Chain", "Hop", &Args{}, &Reply{})
+	elapsed := time.Since(start)
+	if err == nil || !strings.Contains(err.Error(), ErrBudgetExhausted.Error()) {
+		t.Fatalf("expect the budget to be exhausted but got %v", err)
+	}
+	if elapsed >= 600*time.Millisecond {
+		t.Fatalf("expect the chain to fail before the edge deadline, but it took %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&c.calls); n != 1 {
+		t.Fatalf("expect the innermost call to be skipped, but C is called %d times", n)
+	}
+	if n := b.next.Stats().TimeoutErrors; n != 1 {
+		t.Fatalf("expect the skipped call to be counted in timeouts, but got %d", n)
+	}
+}
+
+func TestApplyHopBudget(t *testing.T) {
+	client := NewClient(Option{HopReserve: 10 * time.Millisecond, MinHopBudget: 20 * time.Millisecond})
+
+	// calls without propagated deadlines are kept
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if bctx, cancel, err := client.applyHopBudget(ctx); bctx != ctx || cancel != nil || err != nil {
+		t.Fatalf("expect no budget but got %v, %v", bctx, err)
+	}
+
+	sctx := share.NewContext(context.Background())
+	defer share.ExtractPropagated(sctx, map[string]string{share.ServerTimeout: "25"})()
+	_, _, err := client.applyHopBudget(sctx)
+	var te *TimeoutError
+	if !errors.Is(err, ErrBudgetExhausted) || !errors.Is(err, ErrTimeoutBeforeSend) || !errors.As(err, &te) || !te.BudgetExhausted {
+		t.Fatalf("expect the budget to be exhausted but got %v", err)
+	}
+	if !contextCanceled(err) || isFailure(err) {
+		t.Fatal("expect exhausted budgets not to be retried or counted as failures")
+	}
+
+	client.option.MinHopBudget = 0
+	bctx, cancel, err := client.applyHopBudget(sctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	deadline, _ := bctx.Deadline()
+	if budget := time.Until(deadline); budget > 15*time.Millisecond {
+		t.Fatalf("expect the reserve to be kept, but got the budget %v", budget)
+	}
+	// budgets are applied once
+	if again, cancel, _ := client.applyHopBudget(bctx); again != bctx || cancel != nil {
+		t.Fatal("expect the budget not to be applied again")
+	}
+}
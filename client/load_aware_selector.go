@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/valyala/fastrand"
+)
+
+// DefaultLoadHalfLife is the default LoadAwareSelector.HalfLife.
+const DefaultLoadHalfLife = 10 * time.Second
+
+// defaultLoadWeight is the weight of servers without load reports, to which weights of reports decay.
+const defaultLoadWeight = 50
+
+// LoadReceiver is implemented by selectors which balance by load reports of servers.
+// XClient feeds its selector with reports attached to responses by serverplugin.LoadReportPlugin,
+// keyed by servers of its discovery such as "tcp@127.0.0.1:8972".
+type LoadReceiver interface {
+	UpdateLoad(server string, report protocol.LoadReport)
+}
+
+// LoadAwareSelector selects servers randomly by the advisory weights of their latest load reports. It is the selector of LoadAware.
+// Weights decay exponentially to the weight of servers without reports by HalfLife, so stale reports fade,
+// but servers reporting weight 0, which are draining, are not selected for HalfLife unless all servers are.
+type LoadAwareSelector struct {
+	// HalfLife is the half-life of reports, DefaultLoadHalfLife if it is zero.
+	HalfLife time.Duration
+
+	mu      sync.RWMutex
+	servers []string
+	loads   map[string]serverLoad
+}
+
+type serverLoad struct {
+	weight float64
+	at     time.Time
+}
+
+// NewLoadAwareSelector creates a LoadAwareSelector of servers.
+func NewLoadAwareSelector(servers map[string]string) *LoadAwareSelector {
+	s := &LoadAwareSelector{loads: make(map[string]serverLoad)}
+	s.UpdateServer(servers)
+	return s
+}
+
+func (s *LoadAwareSelector) Select(ctx context.Context, servicePath, serviceMethod string, args interface{}) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.servers) == 0 {
+		return ""
+	}
+
+	now := time.Now()
+	total := 0.0
+	for _, k := range s.servers {
+		total += s.weight(k, now)
+	}
+	if total == 0 {
+		return s.servers[fastrand.Uint32n(uint32(len(s.servers)))]
+	}
+	r := float64(fastrand.Uint32()) / (1 << 32) * total
+	for _, k := range s.servers {
+		if r -= s.weight(k, now); r < 0 {
+			return k
+		}
+	}
+	return s.servers[len(s.servers)-1]
+}
+
+// SelectExplained returns the decayed weights of candidates as their scores.
+func (s *LoadAwareSelector) SelectExplained(ctx context.Context, servicePath, serviceMethod string, args interface{}) (string, SelectionExplanation) {
+	k := s.Select(ctx, servicePath, serviceMethod, args)
+
+	s.mu.RLock()
+	now := time.Now()
+	scores := make([]NodeScore, 0, len(s.servers))
+	for _, server := range s.servers {
+		scores = append(scores, NodeScore{Node: server, Score: s.weight(server, now)})
+	}
+	s.mu.RUnlock()
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Node < scores[j].Node })
+	return k, SelectionExplanation{Reason: "load aware: weighted random of " + strconv.Itoa(len(scores)), Scores: scores}
+}
+
+// weight returns the weight of the server k at now.
+func (s *LoadAwareSelector) weight(k string, now time.Time) float64 {
+	load, ok := s.loads[k]
+	if !ok {
+		return defaultLoadWeight
+	}
+	halfLife := s.HalfLife
+	if halfLife <= 0 {
+		halfLife = DefaultLoadHalfLife
+	}
+	age := now.Sub(load.at)
+	if load.weight == 0 && age < halfLife {
+		return 0
+	}
+	return defaultLoadWeight + (load.weight-defaultLoadWeight)*math.Exp2(-float64(age)/float64(halfLife))
+}
+
+// UpdateLoad records report as the latest load report of server.
+func (s *LoadAwareSelector) UpdateLoad(server string, report protocol.LoadReport) {
+	s.mu.Lock()
+	s.loads[server] = serverLoad{weight: float64(report.Weight), at: time.Now()}
+	s.mu.Unlock()
+}
+
+func (s *LoadAwareSelector) UpdateServer(servers map[string]string) {
+	ss := make([]string, 0, len(servers))
+	for k := range servers {
+		ss = append(ss, k)
+	}
+
+	s.mu.Lock()
+	s.servers = ss
+	for k := range s.loads {
+		if _, ok := servers[k]; !ok {
+			delete(s.loads, k)
+		}
+	}
+	s.mu.Unlock()
+}
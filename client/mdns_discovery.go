@@ -32,6 +32,8 @@ type MDNSDiscovery struct {
 
 	filter ServiceDiscoveryFilter
 
+	discoveryEvents
+
 	stopCh chan struct{}
 }
 
@@ -41,7 +43,7 @@ func NewMDNSDiscovery(service string, timeout time.Duration, watchInterval time.
 	if domain == "" {
 		domain = "local."
 	}
-	d := &MDNSDiscovery{service: service, Timeout: timeout, WatchInterval: watchInterval, domain: domain}
+	d := &MDNSDiscovery{service: service, Timeout: timeout, WatchInterval: watchInterval, domain: domain, discoveryEvents: newDiscoveryEvents("mdns")}
 	d.stopCh = make(chan struct{})
 
 	var err error
@@ -50,6 +52,7 @@ func NewMDNSDiscovery(service string, timeout time.Duration, watchInterval time.
 	d.pairsMu.Unlock()
 	if err != nil {
 		log.Warnf("failed to browse services: %v", err)
+		d.failed(DiscoveryList, err, true)
 	}
 	go d.watch()
 	return d, nil
@@ -110,10 +113,13 @@ func (d *MDNSDiscovery) watch() {
 			return
 		case <-t.C:
 			pairs, err := d.browse()
-			if err == nil {
+			if err != nil {
+				d.failed(DiscoveryRefresh, err, true)
+			} else {
 				d.pairsMu.Lock()
 				d.pairs = pairs
 				d.pairsMu.Unlock()
+				d.updated()
 
 				d.mu.Lock()
 				for _, ch := range d.chans {
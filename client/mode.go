@@ -30,6 +30,8 @@ const (
 	ConsistentHash
 	//Closest is selecting the closest server
 	Closest
+	// LoadAware is selecting by load reports of servers, see LoadAwareSelector
+	LoadAware
 
 	// SelectByUser is selecting by implementation of users
 	SelectByUser = 1000
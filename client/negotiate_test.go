@@ -0,0 +1,111 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// startLegacyServer starts a server of an old version, which echoes heartbeats unless silent
+// and answers requests with the metadata of requests, so that the extensions used by clients are seen.
+func startLegacyServer(t *testing.T, silent bool) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			req, err := protocol.Read(r)
+			if err != nil {
+				return
+			}
+			if req.IsHeartbeat() && silent {
+				continue
+			}
+			res := req.Clone()
+			res.SetMessageType(protocol.Response)
+			res.SetChecksum(false)
+			if !req.IsHeartbeat() {
+				if res.Metadata == nil {
+					res.Metadata = make(map[string]string)
+				}
+				if req.HasChecksum() {
+					res.Metadata["checksum"] = "1"
+				}
+				if req.IsChunk() {
+					res.Metadata["chunk"] = "1"
+				}
+				res.SetSerializeType(protocol.JSON)
+				res.Payload = []byte(`{"C":200}`)
+			}
+			conn.Write(res.Encode())
+		}
+	}()
+	return ln
+}
+
+func TestNegotiationLegacy(t *testing.T) {
+	for _, silent := range []bool{false, true} {
+		ln := startLegacyServer(t, silent)
+		defer ln.Close()
+
+		opt := DefaultOption
+		opt.SerializeType = protocol.JSON
+		opt.Checksum = true
+		opt.ChunkThreshold = 1024
+		opt.NegotiateTimeout = 100 * time.Millisecond
+		client := NewClient(opt)
+		start := time.Now()
+		if err := client.Connect("tcp", ln.Addr().String()); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); silent != (d >= opt.NegotiateTimeout) {
+			t.Errorf("expect to wait for the negotiation only if the server doesn't answer (silent: %v), but waited %v", silent, d)
+		}
+		if _, caps, ok := client.Negotiation(); !ok || caps != 0 {
+			t.Errorf("expect a legacy server but got %v", caps)
+		}
+
+		// extensions are not used with legacy servers
+		resMeta := make(map[string]string)
+		ctx := context.WithValue(context.Background(), share.ResMetaDataKey, resMeta)
+		args := map[string]string{"data": string(bytes.Repeat([]byte("a"), 4096))}
+		if err := client.Call(ctx, "Arith", "Mul", args, &Reply{}); err != nil {
+			t.Fatal(err)
+		}
+		if resMeta["checksum"] != "" || resMeta["chunk"] != "" {
+			t.Errorf("expect no extensions but got %v", resMeta)
+		}
+		client.Close()
+	}
+}
+
+func TestNegotiationDisabledByDefault(t *testing.T) {
+	ln := startLegacyServer(t, true)
+	defer ln.Close()
+
+	client := NewClient(DefaultOption)
+	start := time.Now()
+	if err := client.Connect("tcp", ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if d := time.Since(start); d >= 500*time.Millisecond {
+		t.Errorf("expect to connect without the negotiation but waited %v", d)
+	}
+	if _, _, ok := client.Negotiation(); ok {
+		t.Error("expect no negotiation by default")
+	}
+}
@@ -106,6 +106,18 @@ func (c *OneClient) Auth(auth string) {
 	c.mu.RUnlock()
 }
 
+// UpdateOption updates the option of xclients of all services by fn, and of xclients created later, see XClient.UpdateOption.
+func (c *OneClient) UpdateOption(fn func(o *Option)) {
+	c.mu.Lock()
+	authFunc := c.option.AuthFunc
+	fn(&c.option)
+	c.option.AuthFunc = authFunc
+	for _, v := range c.xclients {
+		v.UpdateOption(fn)
+	}
+	c.mu.Unlock()
+}
+
 // Go invokes the function asynchronously. It returns the Call structure representing the invocation. The done channel will signal when the call is complete by returning the same Call object. If done is nil, Go will allocate a new channel. If non-nil, done must be buffered or Go will deliberately crash.
 // It does not use FailMode.
 func (c *OneClient) Go(ctx context.Context, servicePath string, serviceMethod string, args interface{}, reply interface{}, done chan *Call) (*Call, error) {
@@ -220,6 +232,32 @@ func (c *OneClient) SendRaw(ctx context.Context, r *protocol.Message) (map[strin
 	return xclient.SendRaw(ctx, r)
 }
 
+// SendRawMessage sends r, a raw request, by the XClient of its service path, see XClient.SendRawMessage.
+func (c *OneClient) SendRawMessage(ctx context.Context, r *protocol.Message) (*protocol.Message, error) {
+	servicePath := r.ServicePath
+
+	c.mu.RLock()
+	xclient := c.xclients[servicePath]
+	c.mu.RUnlock()
+
+	if xclient == nil {
+		var err error
+		c.mu.Lock()
+		xclient = c.xclients[servicePath]
+		if xclient == nil {
+			xclient, err = c.newXClient(servicePath)
+			c.xclients[servicePath] = xclient
+		}
+		c.mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return xclient.SendRawMessage(ctx, r)
+}
+
 // Broadcast sends requests to all servers and Success only when all servers return OK.
 // FailMode and SelectMode are meanless for this method.
 // Please set timeout to avoid hanging.
@@ -313,6 +351,43 @@ func (c *OneClient) DownloadFile(ctx context.Context, requestFileName string, sa
 	return xclient.DownloadFile(ctx, requestFileName, saveTo, meta)
 }
 
+func (c *OneClient) SendFileResumable(ctx context.Context, fileName string, rateInBytesPerSecond int64, meta map[string]string) error {
+	xclient, err := c.fileTransferXClient()
+	if err != nil {
+		return err
+	}
+	return xclient.SendFileResumable(ctx, fileName, rateInBytesPerSecond, meta)
+}
+
+func (c *OneClient) DownloadFileResumable(ctx context.Context, requestFileName string, saveTo string, meta map[string]string) error {
+	xclient, err := c.fileTransferXClient()
+	if err != nil {
+		return err
+	}
+	return xclient.DownloadFileResumable(ctx, requestFileName, saveTo, meta)
+}
+
+func (c *OneClient) fileTransferXClient() (XClient, error) {
+	c.mu.RLock()
+	xclient := c.xclients[share.SendFileServiceName]
+	c.mu.RUnlock()
+
+	if xclient == nil {
+		var err error
+		c.mu.Lock()
+		xclient = c.xclients[share.SendFileServiceName]
+		if xclient == nil {
+			xclient, err = c.newXClient(share.SendFileServiceName)
+			c.xclients[share.SendFileServiceName] = xclient
+		}
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return xclient, nil
+}
+
 func (c *OneClient) Stream(ctx context.Context, meta map[string]string) (net.Conn, error) {
 	c.mu.RLock()
 	xclient := c.xclients[share.StreamServiceName]
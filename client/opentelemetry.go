@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/valyala/fastrand"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/share"
+)
+
+const openTelemetryInstrumentation = "github.com/smallnest/rpcx/client"
+
+// OpenTelemetryPlugin starts a client span for every call, named servicePath.serviceMethod,
+// and injects the trace context into metadata of requests for serverplugin.OpenTelemetryPlugin.
+// The span is a child of the span in ctx, such as the span of the service that makes the call.
+//
+// Calls are sampled by the sampler of WithSampler before spans are started, and by default calls follow
+// their parents, so calls of unsampled parents are not sampled. Unsampled calls start no spans,
+// and their trace flags in metadata tell servers not to sample them either. Calls of contexts with
+// share.ForceSampleContextKey are always sampled. The sampler of the TracerProvider should sample spans
+// of sampled parents, such as the default ParentBased(AlwaysSample()) of the SDK, to follow these decisions.
+type OpenTelemetryPlugin struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	sampler    func(ctx context.Context, servicePath, serviceMethod string) sdktrace.SamplingDecision
+}
+
+// OpenTelemetryOption is an option of OpenTelemetryPlugin.
+type OpenTelemetryOption func(p *OpenTelemetryPlugin)
+
+// WithSampler sets the sampler of calls, which decides whether calls are sampled by their services, methods
+// or values of ctx such as tenants. ctx has the span of the caller if any, so the sampler can follow its decision.
+// Spans of sdktrace.RecordOnly are recorded by the client, but servers don't sample them.
+func WithSampler(sampler func(ctx context.Context, servicePath, serviceMethod string) sdktrace.SamplingDecision) OpenTelemetryOption {
+	return func(p *OpenTelemetryPlugin) {
+		p.sampler = sampler
+	}
+}
+
+// NewOpenTelemetryPlugin creates an OpenTelemetryPlugin. The global TracerProvider is used if tp is nil,
+// and DefaultPropagator of W3C trace context and B3 is used if propagator is nil.
+func NewOpenTelemetryPlugin(tp trace.TracerProvider, propagator propagation.TextMapPropagator, opts ...OpenTelemetryOption) *OpenTelemetryPlugin {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if propagator == nil {
+		propagator = DefaultPropagator
+	}
+	p := &OpenTelemetryPlugin{
+		tracer:     tp.Tracer(openTelemetryInstrumentation),
+		propagator: propagator,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// sample returns the sampling decision of the call.
+func (p *OpenTelemetryPlugin) sample(ctx context.Context, servicePath, serviceMethod string) sdktrace.SamplingDecision {
+	if force, _ := ctx.Value(share.ForceSampleContextKey).(bool); force {
+		return sdktrace.RecordAndSample
+	}
+	if p.sampler != nil {
+		return p.sampler(ctx, servicePath, serviceMethod)
+	}
+	if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+		if !span.IsRecording() {
+			return sdktrace.Drop
+		}
+	} else if sc := trace.RemoteSpanContextFromContext(ctx); sc.IsValid() && !sc.IsSampled() {
+		return sdktrace.Drop
+	}
+	return sdktrace.RecordAndSample
+}
+
+// PreCall starts the span and injects it into metadata of the request.
+func (p *OpenTelemetryPlugin) PreCall(ctx context.Context, servicePath, serviceMethod string, args interface{}) error {
+	rpcxContext, ok := ctx.(*share.Context)
+	if !ok {
+		return nil
+	}
+
+	decision := p.sample(ctx, servicePath, serviceMethod)
+	if decision == sdktrace.Drop {
+		sc := trace.SpanContextFromContext(ctx)
+		if !sc.IsValid() {
+			sc = trace.RemoteSpanContextFromContext(ctx)
+		}
+		if !sc.IsValid() {
+			sc = randomSpanContext()
+		}
+		p.inject(rpcxContext, withPropagatedSpan(ctx, sc.WithTraceFlags(sc.TraceFlags()&^trace.FlagsSampled)))
+		return nil
+	}
+
+	spanCtx, span := p.tracer.Start(ctx, servicePath+"."+serviceMethod,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("rpcx"),
+			semconv.RPCServiceKey.String(servicePath),
+			semconv.RPCMethodKey.String(serviceMethod),
+		))
+	rpcxContext.SetValue(share.OpenTelemetrySpanClientKey, span)
+
+	// trace flags of recording spans tell servers the decision
+	sc := span.SpanContext()
+	switch {
+	case decision == sdktrace.RecordOnly || !span.IsRecording():
+		sc = sc.WithTraceFlags(sc.TraceFlags() &^ trace.FlagsSampled)
+	default:
+		sc = sc.WithTraceFlags(sc.TraceFlags() | trace.FlagsSampled)
+	}
+	p.inject(rpcxContext, withPropagatedSpan(spanCtx, sc))
+	return nil
+}
+
+// inject injects the span context of the span of spanCtx into metadata of the request.
+func (p *OpenTelemetryPlugin) inject(rpcxContext *share.Context, spanCtx context.Context) {
+	// metadata of ctx belongs to the caller, so it is copied
+	meta, _ := rpcxContext.Value(share.ReqMetaDataKey).(map[string]string)
+	reqMeta := make(map[string]string, len(meta)+2)
+	for k, v := range meta {
+		reqMeta[k] = v
+	}
+	p.propagator.Inject(spanCtx, share.MetadataCarrier(reqMeta))
+	rpcxContext.SetValue(share.ReqMetaDataKey, reqMeta)
+}
+
+// propagatedSpan is the span of a span context to inject, whose trace flags tell servers the sampling decision.
+type propagatedSpan struct {
+	trace.Span
+	sc trace.SpanContext
+}
+
+func (s propagatedSpan) SpanContext() trace.SpanContext {
+	return s.sc
+}
+
+func withPropagatedSpan(ctx context.Context, sc trace.SpanContext) context.Context {
+	return trace.ContextWithSpan(ctx, propagatedSpan{Span: trace.SpanFromContext(ctx), sc: sc})
+}
+
+// randomSpanContext returns a span context of a new trace, for unsampled calls without parents.
+func randomSpanContext() trace.SpanContext {
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	binary.BigEndian.PutUint64(traceID[:8], uint64(fastrand.Uint32())<<32|uint64(fastrand.Uint32()))
+	binary.BigEndian.PutUint64(traceID[8:], uint64(fastrand.Uint32())<<32|uint64(fastrand.Uint32())|1)
+	binary.BigEndian.PutUint64(spanID[:], uint64(fastrand.Uint32())<<32|uint64(fastrand.Uint32())|1)
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID})
+}
+
+// PostCall ends the span with the error of the call.
+func (p *OpenTelemetryPlugin) PostCall(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}, err error) error {
+	span, ok := ctx.Value(share.OpenTelemetrySpanClientKey).(trace.Span)
+	if !ok {
+		return nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if code := rerrors.CodeOf(err); code != rerrors.Unknown {
+			span.SetAttributes(attribute.Key("rpc.rpcx.error_code").Int(int(code)))
+		}
+	}
+	span.End()
+	return nil
+}
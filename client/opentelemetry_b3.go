@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Headers of B3 propagation of Zipkin, in lowercase as keys of metadata.
+const (
+	b3Header             = "b3"
+	b3TraceIDHeader      = "x-b3-traceid"
+	b3SpanIDHeader       = "x-b3-spanid"
+	b3ParentSpanIDHeader = "x-b3-parentspanid"
+	b3SampledHeader      = "x-b3-sampled"
+	b3FlagsHeader        = "x-b3-flags"
+)
+
+// B3Propagator propagates trace contexts in B3 headers of Zipkin, which are used by service meshes such as Istio.
+// It extracts both the single header b3 and the multiple X-B3- headers, and injects the X-B3- headers,
+// or the single header if SingleHeader is set. Trace contexts without sampling decisions are sampled.
+type B3Propagator struct {
+	SingleHeader bool
+}
+
+var _ propagation.TextMapPropagator = B3Propagator{}
+
+// DefaultPropagator is the propagator of OpenTelemetry plugins created without propagators,
+// which extracts and injects W3C trace context and B3 headers. W3C trace context wins if both are extracted.
+var DefaultPropagator = propagation.NewCompositeTextMapPropagator(B3Propagator{}, propagation.TraceContext{})
+
+// Inject sets the span context of ctx in carrier.
+func (p B3Propagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	sampled := "0"
+	if sc.IsSampled() {
+		sampled = "1"
+	}
+	if p.SingleHeader {
+		carrier.Set(b3Header, sc.TraceID().String()+"-"+sc.SpanID().String()+"-"+sampled)
+		return
+	}
+	carrier.Set(b3TraceIDHeader, sc.TraceID().String())
+	carrier.Set(b3SpanIDHeader, sc.SpanID().String())
+	carrier.Set(b3SampledHeader, sampled)
+}
+
+// Extract returns ctx with the remote span context of carrier, or ctx if carrier has no valid B3 headers.
+func (p B3Propagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	var sc trace.SpanContext
+	if h := carrier.Get(b3Header); h != "" {
+		sc = extractB3Single(h)
+	} else {
+		sampled := carrier.Get(b3SampledHeader)
+		if carrier.Get(b3FlagsHeader) == "1" {
+			sampled = "d"
+		}
+		sc = newB3SpanContext(carrier.Get(b3TraceIDHeader), carrier.Get(b3SpanIDHeader), sampled)
+	}
+	if !sc.IsValid() {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Fields returns the headers of B3.
+func (p B3Propagator) Fields() []string {
+	if p.SingleHeader {
+		return []string{b3Header}
+	}
+	return []string{b3TraceIDHeader, b3SpanIDHeader, b3ParentSpanIDHeader, b3SampledHeader, b3FlagsHeader}
+}
+
+// extractB3Single extracts the single header {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId},
+// whose sampling state and parent are optional. Headers of only sampling states have no span contexts.
+func extractB3Single(h string) trace.SpanContext {
+	parts := strings.Split(h, "-")
+	if len(parts) < 2 || len(parts) > 4 {
+		return trace.SpanContext{}
+	}
+	var sampled string
+	if len(parts) > 2 {
+		sampled = parts[2]
+	}
+	return newB3SpanContext(parts[0], parts[1], sampled)
+}
+
+// newB3SpanContext returns the span context of the hex IDs and the sampling state, "0", "1", "d" for debug,
+// or "" for deferred decisions. Trace IDs of 64 bits are padded to 128 bits.
+func newB3SpanContext(traceID, spanID, sampled string) trace.SpanContext {
+	if len(traceID) == 16 {
+		traceID = strings.Repeat("0", 16) + traceID
+	}
+	tid, err := trace.TraceIDFromHex(strings.ToLower(traceID))
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	sid, err := trace.SpanIDFromHex(strings.ToLower(spanID))
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	var flags byte
+	switch strings.ToLower(sampled) {
+	case "0", "false":
+	case "", "1", "true", "d":
+		flags = trace.FlagsSampled
+	default:
+		return trace.SpanContext{}
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid, TraceFlags: flags, Remote: true})
+}
@@ -25,6 +25,12 @@ func (s weightedICMPSelector) Select(ctx context.Context, servicePath, serviceMe
 	return w.Server
 }
 
+// SelectExplained returns weights of candidates by their ping times as their scores.
+func (s weightedICMPSelector) SelectExplained(ctx context.Context, servicePath, serviceMethod string, args interface{}) (string, SelectionExplanation) {
+	k := s.Select(ctx, servicePath, serviceMethod, args)
+	return k, explainWeighted("weighted icmp", s.servers)
+}
+
 func (s *weightedICMPSelector) UpdateServer(servers map[string]string) {
 	ss := createICMPWeighted(servers)
 	s.servers = ss
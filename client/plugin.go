@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"net"
+	"time"
 
 	"github.com/smallnest/rpcx/protocol"
 )
@@ -116,6 +117,7 @@ func (p *pluginContainer) DoClientConnectionClose(conn net.Conn) error {
 }
 
 // DoClientBeforeEncode is called when requests are encoded and sent.
+// If a plugin returns an error, the request is not sent and its call fails with the error.
 func (p *pluginContainer) DoClientBeforeEncode(req *protocol.Message) error {
 	var err error
 	for i := range p.plugins {
@@ -129,7 +131,8 @@ func (p *pluginContainer) DoClientBeforeEncode(req *protocol.Message) error {
 	return nil
 }
 
-// DoClientBeforeEncode is called when requests are encoded and sent.
+// DoClientAfterDecode is called when responses are decoded.
+// If a plugin returns an error, the call of the response fails with the error and other calls of the connection go on.
 func (p *pluginContainer) DoClientAfterDecode(req *protocol.Message) error {
 	var err error
 	for i := range p.plugins {
@@ -143,6 +146,51 @@ func (p *pluginContainer) DoClientAfterDecode(req *protocol.Message) error {
 	return nil
 }
 
+// DoRecordCall is called when calls recorded by CallRecordPlugin complete.
+func (p *pluginContainer) DoRecordCall(record *CallRecord) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(CallRecordPlugin); ok {
+			plugin.RecordCall(record)
+		}
+	}
+}
+
+// DoDiscoveryStaleness is called when the staleness of the discovery of a xclient changes.
+func (p *pluginContainer) DoDiscoveryStaleness(servicePath string, staleness time.Duration) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(DiscoveryStalenessPlugin); ok {
+			plugin.DiscoveryStaleness(servicePath, staleness)
+		}
+	}
+}
+
+// DoHeartbeat is called after a heartbeat of a client.
+func (p *pluginContainer) DoHeartbeat(remoteAddr string, rtt time.Duration, err error) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(HeartbeatPlugin); ok {
+			plugin.Heartbeat(remoteAddr, rtt, err)
+		}
+	}
+}
+
+// DoRateLimited is called after a call waits for or is rejected by Option.RateLimiter.
+func (p *pluginContainer) DoRateLimited(destination string, wait time.Duration, err error) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(RateLimitPlugin); ok {
+			plugin.RateLimited(destination, wait, err)
+		}
+	}
+}
+
+// DoReconnect is called after a xclient reconnects a node.
+func (p *pluginContainer) DoReconnect(servicePath, node string, err error) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(ReconnectPlugin); ok {
+			plugin.Reconnect(servicePath, node, err)
+		}
+	}
+}
+
 // DoWrapSelect is called when select a node.
 func (p *pluginContainer) DoWrapSelect(fn SelectFunc) SelectFunc {
 	var rt = fn
@@ -182,11 +230,16 @@ type (
 	}
 
 	// ClientBeforeEncodePlugin is invoked when the message is encoded and sent.
+	// The payload has been serialized but not compressed, and the compress type, checksum and compact metadata flags are set,
+	// so plugins may sign or transform the payload and metadata. The message is then compressed, checksummed, chunked and
+	// encrypted by session encryption or TLS before it is written.
 	ClientBeforeEncodePlugin interface {
 		ClientBeforeEncode(*protocol.Message) error
 	}
 
 	// ClientAfterDecodePlugin is invoked when the message is decoded.
+	// In the reverse order of ClientBeforeEncodePlugin, the message has been decrypted, reassembled from chunks,
+	// verified by its checksum and decompressed, and its payload is not deserialized yet.
 	ClientAfterDecodePlugin interface {
 		ClientAfterDecode(*protocol.Message) error
 	}
@@ -196,6 +249,38 @@ type (
 		WrapSelect(SelectFunc) SelectFunc
 	}
 
+	// HeartbeatPlugin is invoked after each heartbeat of Option.Heartbeat with its round trip time,
+	// and the error if it fails. Clients are closed after failed heartbeats.
+	HeartbeatPlugin interface {
+		Heartbeat(remoteAddr string, rtt time.Duration, err error)
+	}
+
+	// ReconnectPlugin is invoked when a xclient connects a node which has been connected before, such as after
+	// a failed heartbeat, with the error of the connect. It is invoked with the lock of the xclient held,
+	// so it must not call methods of the xclient.
+	ReconnectPlugin interface {
+		Reconnect(servicePath, node string, err error)
+	}
+
+	// DiscoveryStalenessPlugin is invoked with the staleness of servers of the discovery of a xclient, such as to set gauges:
+	// on errors of discoveries implementing DiscoveryErrorNotifier, and with zero when servers are updated after errors.
+	DiscoveryStalenessPlugin interface {
+		DiscoveryStaleness(servicePath string, staleness time.Duration)
+	}
+
+	// RateLimitPlugin is invoked when calls wait for the limits of Option.RateLimiter of their destinations,
+	// with the time they wait, and when they are rejected by the limits or their contexts, with their errors.
+	RateLimitPlugin interface {
+		RateLimited(destination string, wait time.Duration, err error)
+	}
+
+	// CallRecordPlugin is invoked when calls complete, with their encoded requests and responses,
+	// such as to record traffic by the replay package. It is called by goroutines reading responses,
+	// so it should not block, and record belongs to it.
+	CallRecordPlugin interface {
+		RecordCall(record *CallRecord)
+	}
+
 	//PluginContainer represents a plugin container that defines all methods to manage plugins.
 	//And it also defines all extension points.
 	PluginContainer interface {
@@ -212,7 +297,12 @@ type (
 
 		DoClientBeforeEncode(*protocol.Message) error
 		DoClientAfterDecode(*protocol.Message) error
+		DoRecordCall(record *CallRecord)
 
 		DoWrapSelect(SelectFunc) SelectFunc
+		DoDiscoveryStaleness(servicePath string, staleness time.Duration)
+		DoHeartbeat(remoteAddr string, rtt time.Duration, err error)
+		DoReconnect(servicePath, node string, err error)
+		DoRateLimited(destination string, wait time.Duration, err error)
 	}
 )
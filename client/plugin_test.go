@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+var (
+	errEncodeHook = errors.New("encode hook failed")
+	errDecodeHook = errors.New("decode hook failed")
+)
+
+// failingCodecPlugin fails requests in the encode hook, or their responses in the decode hook, by the "hook" metadata of requests.
+type failingCodecPlugin struct {
+	mu   sync.Mutex
+	seqs map[uint64]bool // requests whose responses fail
+}
+
+func (p *failingCodecPlugin) ClientBeforeEncode(req *protocol.Message) error {
+	switch req.Metadata["hook"] {
+	case "encode":
+		return errEncodeHook
+	case "decode":
+		p.mu.Lock()
+		p.seqs[req.Seq()] = true
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+func (p *failingCodecPlugin) ClientAfterDecode(res *protocol.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.seqs[res.Seq()] {
+		return errDecodeHook
+	}
+	return nil
+}
+
+func TestCodecHookErrors(t *testing.T) {
+	addr := startStatsServer(t)
+	client := NewClient(DefaultOption)
+	client.Plugins = NewPluginContainer()
+	client.Plugins.Add(&failingCodecPlugin{seqs: make(map[uint64]bool)})
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	call := func(hook string) error {
+		ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"hook": hook})
+		reply := &Reply{}
+		if err := client.Call(ctx, "Stats", "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+			return err
+		}
+		if reply.C != 6 {
+			t.Fatalf("expect 6 but got %d", reply.C)
+		}
+		return nil
+	}
+
+	if err := call("encode"); err != errEncodeHook {
+		t.Fatalf("expect the error of the encode hook but got %v", err)
+	}
+	if err := call("decode"); err != errDecodeHook {
+		t.Fatalf("expect the error of the decode hook but got %v", err)
+	}
+
+	// only the calls fail, and the connection is still usable
+	if err := call(""); err != nil {
+		t.Fatal(err)
+	}
+	if client.IsShutdown() {
+		t.Fatal("expect the connection to be usable")
+	}
+
+	r := protocol.NewMessage()
+	r.SetMessageType(protocol.Request)
+	r.SetSerializeType(protocol.MsgPack)
+	r.ServicePath, r.ServiceMethod = "Stats", "Mul"
+	r.Metadata = map[string]string{"hook": "encode"}
+	if _, _, err := client.SendRaw(context.Background(), r); err != errEncodeHook {
+		t.Fatalf("expect the error of the encode hook but got %v", err)
+	}
+	if err := call(""); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusPlugin exports heartbeats and reconnects of clients to Prometheus, labeled by addresses of nodes:
+//
+//	rpcx_client_heartbeats_total{node, result}
+//	rpcx_client_heartbeat_consecutive_failures{node}
+//	rpcx_client_heartbeat_last_success_timestamp_seconds{node}
+//	rpcx_client_reconnects_total{node, result}
+//	rpcx_client_rate_limit_wait_seconds{destination}
+//	rpcx_client_rate_limit_rejections_total{destination}
+//
+// result is "success" or "failure". The time since the last successful heartbeat is
+// time() - rpcx_client_heartbeat_last_success_timestamp_seconds in PromQL.
+// Destinations are service paths or addresses of nodes by RateLimit.Key of Option.RateLimiter.
+type PrometheusPlugin struct {
+	heartbeats          *prometheus.CounterVec
+	consecutiveFailures *prometheus.GaugeVec
+	lastHeartbeat       *prometheus.GaugeVec
+	reconnects          *prometheus.CounterVec
+	rateLimitWaits      *prometheus.HistogramVec
+	rateLimitRejections *prometheus.CounterVec
+}
+
+// NewPrometheusPlugin creates a PrometheusPlugin and registers its metrics to registerer,
+// or to prometheus.DefaultRegisterer if registerer is nil.
+func NewPrometheusPlugin(registerer prometheus.Registerer) (*PrometheusPlugin, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	p := &PrometheusPlugin{
+		heartbeats: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "rpcx", Subsystem: "client", Name: "heartbeats_total",
+			Help: "Heartbeats sent to nodes by results.",
+		}, []string{"node", "result"}),
+		consecutiveFailures: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "rpcx", Subsystem: "client", Name: "heartbeat_consecutive_failures",
+			Help: "Heartbeats failed since the last successful heartbeat of nodes.",
+		}, []string{"node"}),
+		lastHeartbeat: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "rpcx", Subsystem: "client", Name: "heartbeat_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful heartbeat of nodes.",
+		}, []string{"node"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "rpcx", Subsystem: "client", Name: "reconnects_total",
+			Help: "Reconnects of nodes by results.",
+		}, []string{"node", "result"}),
+		rateLimitWaits: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "rpcx", Subsystem: "client", Name: "rate_limit_wait_seconds",
+			Help:    "Time calls waited for rate limits of destinations.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"destination"}),
+		rateLimitRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "rpcx", Subsystem: "client", Name: "rate_limit_rejections_total",
+			Help: "Calls rejected by rate limits of destinations.",
+		}, []string{"destination"}),
+	}
+	for _, c := range []prometheus.Collector{p.heartbeats, p.consecutiveFailures, p.lastHeartbeat, p.reconnects,
+		p.rateLimitWaits, p.rateLimitRejections} {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Heartbeat implements HeartbeatPlugin.
+func (p *PrometheusPlugin) Heartbeat(remoteAddr string, rtt time.Duration, err error) {
+	if err != nil {
+		p.heartbeats.WithLabelValues(remoteAddr, "failure").Inc()
+		p.consecutiveFailures.WithLabelValues(remoteAddr).Inc()
+		return
+	}
+	p.heartbeats.WithLabelValues(remoteAddr, "success").Inc()
+	p.consecutiveFailures.WithLabelValues(remoteAddr).Set(0)
+	p.lastHeartbeat.WithLabelValues(remoteAddr).Set(float64(time.Now().UnixNano()) / 1e9)
+}
+
+// Reconnect implements ReconnectPlugin.
+func (p *PrometheusPlugin) Reconnect(servicePath, node string, err error) {
+	_, addr := splitNetworkAndAddress(node)
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	p.reconnects.WithLabelValues(addr, result).Inc()
+}
+
+// RateLimited implements RateLimitPlugin.
+func (p *PrometheusPlugin) RateLimited(destination string, wait time.Duration, err error) {
+	if err != nil {
+		p.rateLimitRejections.WithLabelValues(destination).Inc()
+		return
+	}
+	p.rateLimitWaits.WithLabelValues(destination).Observe(wait.Seconds())
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+type propagatedTenantKey struct{}
+
+type propagationService struct {
+	downstream *Client
+}
+
+// Tenant calls Echo of the same server with ctx, so propagated values are carried to the downstream call
+func (s *propagationService) Tenant(ctx context.Context, args *Args, reply *Reply) error {
+	return s.downstream.Call(ctx, "Propagation", "Echo", args, reply)
+}
+
+func (s *propagationService) Echo(ctx context.Context, args *Args, reply *Reply) error {
+	if tenant, ok := ctx.Value(propagatedTenantKey{}).(string); ok && tenant == "acme" {
+		reply.C = 1
+	}
+	if server.RequestID(ctx) == "req-1" {
+		reply.C += 10
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= time.Second {
+		reply.C += 100
+	}
+	return nil
+}
+
+func TestPropagatedContextValues(t *testing.T) {
+	share.RegisterPropagatedKey(propagatedTenantKey{}, "x-test-tenant", nil, nil)
+
+	svc := &propagationService{downstream: NewClient(DefaultOption)}
+	s := server.NewServer()
+	s.RegisterName("Propagation", svc, "")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// connected before serving, so handlers of the server see the connected downstream client
+	if err := svc.downstream.Connect("tcp", ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer svc.downstream.Close()
+	addr := serveListener(t, s, ln)
+
+	client := NewClient(DefaultOption)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ctx = context.WithValue(ctx, share.RequestIDContextKey, "req-1")
+	ctx = context.WithValue(ctx, propagatedTenantKey{}, "acme")
+	reply := &Reply{}
+	if err := client.Call(ctx, "Propagation", "Tenant", &Args{}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 111 {
+		t.Fatalf("expect the tenant, the request ID and the deadline to be propagated but got %d", reply.C)
+	}
+
+	// metadata set explicitly wins
+	ctx = context.WithValue(ctx, share.ReqMetaDataKey, map[string]string{"x-test-tenant": "other"})
+	reply = &Reply{}
+	if err := client.Call(ctx, "Propagation", "Echo", &Args{}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 110 {
+		t.Fatalf("expect the explicit tenant but got %d", reply.C)
+	}
+}
@@ -0,0 +1,239 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClientRateLimited is the error of calls rejected by the RateLimiter of their clients, which are not sent.
+// Calls waiting for their limits fail with it too if their deadlines expire before the limits allow them.
+var ErrClientRateLimited = errors.New("rpcx: call is rate limited by the client")
+
+// RateLimitMode is how calls over the limits of RateLimiter are handled.
+type RateLimitMode int
+
+const (
+	// RateLimitWait makes calls over the limits wait until the limits allow them, bounded by their contexts.
+	RateLimitWait RateLimitMode = iota
+	// RateLimitFailFast fails calls over the limits with ErrClientRateLimited at once.
+	RateLimitFailFast
+)
+
+// RateLimitKey is the destination of calls which limits of RateLimiter apply to.
+type RateLimitKey int
+
+const (
+	// RateLimitByServicePath limits calls of each service, across all of its nodes.
+	RateLimitByServicePath RateLimitKey = iota
+	// RateLimitByNode limits calls of each node by the remote address of its connection, across all services.
+	RateLimitByNode
+)
+
+// RateLimit is the limits of calls of each destination.
+type RateLimit struct {
+	// QPS is the rate of calls by a token bucket of Burst tokens. Zero doesn't limit the rate.
+	QPS float64
+	// Burst is the number of calls allowed at once by the rate, which defaults to 1.
+	Burst int
+	// Concurrency is the max number of pending calls. Zero doesn't limit the concurrency.
+	Concurrency int
+	Key         RateLimitKey
+	Mode        RateLimitMode
+}
+
+// RateLimitStats is a snapshot of the counters of calls of a destination limited by RateLimiter.
+type RateLimitStats struct {
+	// Allowed is the number of calls allowed, including the ones which waited.
+	Allowed uint64
+	// Waited is the number of calls which waited, for WaitTime in total.
+	Waited   uint64
+	WaitTime time.Duration
+	// Rejected is the number of calls failed by the limits with ErrClientRateLimited or their contexts.
+	Rejected uint64
+	// InFlight is the number of pending calls allowed.
+	InFlight int
+}
+
+// RateLimiter limits the rate and the concurrency of calls of clients to each destination, the service or the node
+// called by RateLimit.Key, so batch jobs can be good citizens of shared backends without relying on rejections of servers.
+// It is set to Option.RateLimiter, and limits are shared by all clients of the same RateLimiter, such as XClients
+// of an XClientPool. Give clients their own RateLimiters to limit them separately.
+// Limits can be changed at runtime by SetLimit. Heartbeats are not limited.
+type RateLimiter struct {
+	mu    sync.Mutex
+	limit RateLimit
+	dests map[string]*rateDestination
+}
+
+// rateDestination is the token bucket, the semaphore and the counters of a destination.
+type rateDestination struct {
+	tokens   float64
+	last     time.Time
+	inflight int
+	waiters  []chan struct{}
+
+	stats RateLimitStats
+}
+
+// NewRateLimiter creates a RateLimiter of limit.
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{limit: limit, dests: make(map[string]*rateDestination)}
+}
+
+// Limit returns the limits.
+func (l *RateLimiter) Limit() RateLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// SetLimit changes the limits of all destinations. Pending calls over a lower concurrency are not failed,
+// but later calls wait until they are done. Destinations are counted separately if Key changes.
+func (l *RateLimiter) SetLimit(limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	for _, d := range l.dests {
+		if d.tokens > l.burst() {
+			d.tokens = l.burst()
+		}
+		l.grant(d)
+	}
+}
+
+// Stats returns the counters of destinations by their service paths or remote addresses.
+func (l *RateLimiter) Stats() map[string]RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := make(map[string]RateLimitStats, len(l.dests))
+	for k, d := range l.dests {
+		s := d.stats
+		s.InFlight = d.inflight
+		stats[k] = s
+	}
+	return stats
+}
+
+// destination returns the destination of calls of servicePath to the node of remoteAddr.
+func (l *RateLimiter) destination(servicePath, remoteAddr string) string {
+	if l.Limit().Key == RateLimitByNode {
+		return remoteAddr
+	}
+	return servicePath
+}
+
+func (l *RateLimiter) burst() float64 {
+	if l.limit.Burst <= 0 {
+		return 1
+	}
+	return float64(l.limit.Burst)
+}
+
+// grant wakes waiters of d while the concurrency allows them. l.mu is held.
+func (l *RateLimiter) grant(d *rateDestination) {
+	for len(d.waiters) > 0 && (l.limit.Concurrency <= 0 || d.inflight < l.limit.Concurrency) {
+		d.inflight++
+		close(d.waiters[0])
+		d.waiters = d.waiters[1:]
+	}
+}
+
+// acquire allows a call to dest by the limits, and returns the function releasing it when the call is done,
+// with the time it waited. It fails with ErrClientRateLimited, or the error of ctx if ctx is done while waiting.
+func (l *RateLimiter) acquire(ctx context.Context, dest string) (release func(), wait time.Duration, err error) {
+	start := time.Now()
+	l.mu.Lock()
+	d := l.dests[dest]
+	if d == nil {
+		d = &rateDestination{tokens: l.burst(), last: start}
+		l.dests[dest] = d
+	}
+
+	// the token bucket, whose tokens are reserved by waiting calls
+	if l.limit.QPS > 0 {
+		d.tokens += start.Sub(d.last).Seconds() * l.limit.QPS
+		if d.tokens > l.burst() {
+			d.tokens = l.burst()
+		}
+		d.last = start
+		if d.tokens < 1 {
+			wait = time.Duration((1 - d.tokens) / l.limit.QPS * float64(time.Second))
+			if deadline, ok := ctx.Deadline(); l.limit.Mode == RateLimitFailFast || ok && start.Add(wait).After(deadline) {
+				d.stats.Rejected++
+				l.mu.Unlock()
+				return nil, 0, ErrClientRateLimited
+			}
+		}
+		d.tokens--
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			l.mu.Lock()
+			d.stats.Rejected++
+			l.mu.Unlock()
+			return nil, time.Since(start), ctx.Err()
+		}
+	}
+
+	// the semaphore
+	l.mu.Lock()
+	if l.limit.Concurrency <= 0 || d.inflight < l.limit.Concurrency && len(d.waiters) == 0 {
+		d.inflight++
+	} else if l.limit.Mode == RateLimitFailFast {
+		d.stats.Rejected++
+		l.mu.Unlock()
+		return nil, 0, ErrClientRateLimited
+	} else {
+		ch := make(chan struct{})
+		d.waiters = append(d.waiters, ch)
+		l.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			l.mu.Lock()
+			granted := true
+			for i, w := range d.waiters {
+				if w == ch {
+					d.waiters = append(d.waiters[:i], d.waiters[i+1:]...)
+					granted = false
+					break
+				}
+			}
+			if granted { // passes the slot on
+				d.inflight--
+				l.grant(d)
+			}
+			d.stats.Rejected++
+			l.mu.Unlock()
+			return nil, time.Since(start), ctx.Err()
+		}
+		l.mu.Lock()
+		wait = time.Since(start)
+	}
+	d.stats.Allowed++
+	if wait > 0 {
+		d.stats.Waited++
+		d.stats.WaitTime += wait
+	}
+	l.mu.Unlock()
+
+	var released int32
+	return func() {
+		if !atomic.CompareAndSwapInt32(&released, 0, 1) {
+			return
+		}
+		l.mu.Lock()
+		d.inflight--
+		l.grant(d)
+		l.mu.Unlock()
+	}, wait, nil
+}
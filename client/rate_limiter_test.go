@@ -0,0 +1,157 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterQPS(t *testing.T) {
+	l := NewRateLimiter(RateLimit{QPS: 100})
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		release, _, err := l.acquire(context.Background(), "Arith")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+		release() // released once
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Fatalf("expect calls to wait for the rate but they took %v", elapsed)
+	}
+	// calls delayed by the scheduler may find tokens without waiting
+	stats := l.Stats()["Arith"]
+	if stats.Allowed != 5 || stats.Waited < 3 || stats.Waited > 4 || stats.WaitTime <= 0 || stats.InFlight != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// calls whose deadlines expire before the rate allows them fail at once
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, _, err := l.acquire(ctx, "Arith"); err != ErrClientRateLimited {
+		t.Fatalf("expect ErrClientRateLimited but got %v", err)
+	}
+
+	l.SetLimit(RateLimit{QPS: 1, Burst: 2, Mode: RateLimitFailFast})
+	time.Sleep(20 * time.Millisecond)
+	if _, _, err := l.acquire(context.Background(), "Other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.acquire(context.Background(), "Other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.acquire(context.Background(), "Other"); err != ErrClientRateLimited {
+		t.Fatalf("expect ErrClientRateLimited but got %v", err)
+	}
+	if stats := l.Stats(); stats["Other"].Rejected != 1 || stats["Arith"].Rejected != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRateLimiterConcurrency(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Concurrency: 1})
+	release, _, err := l.acquire(context.Background(), "Arith")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// waiters are canceled by their contexts
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := l.acquire(ctx, "Arith"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect the deadline to expire but got %v", err)
+	}
+
+	acquired := make(chan func(), 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			r, _, err := l.acquire(context.Background(), "Arith")
+			if err != nil {
+				t.Error(err)
+			}
+			acquired <- r
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-acquired:
+		t.Fatal("expect waiters to wait for the concurrency")
+	default:
+	}
+
+	release()
+	r := <-acquired
+	if stats := l.Stats()["Arith"]; stats.InFlight != 1 || stats.Rejected != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// a higher concurrency wakes waiters
+	l.SetLimit(RateLimit{Concurrency: 2})
+	r2 := <-acquired
+	r()
+	r2()
+	if stats := l.Stats()["Arith"]; stats.InFlight != 0 || stats.Allowed != 3 || stats.Waited != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	l.SetLimit(RateLimit{Concurrency: 1, Mode: RateLimitFailFast})
+	release, _, _ = l.acquire(context.Background(), "Arith")
+	if _, _, err := l.acquire(context.Background(), "Arith"); err != ErrClientRateLimited {
+		t.Fatalf("expect ErrClientRateLimited but got %v", err)
+	}
+	release()
+}
+
+func TestXClientRateLimit(t *testing.T) {
+	addr := startStatsServer(t)
+	d, err := NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	option := DefaultOption
+	option.RateLimiter = NewRateLimiter(RateLimit{Concurrency: 2, Mode: RateLimitFailFast})
+	// XClients of the pool share the limits
+	pool := NewXClientPool(2, "Stats", Failtry, RandomSelect, d, option)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var limited int
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := pool.Get().Call(context.Background(), "Slow", &Args{}, &Reply{})
+			if errors.Is(err, ErrClientRateLimited) {
+				mu.Lock()
+				limited++
+				mu.Unlock()
+			} else if err != nil {
+				t.Error(err)
+			}
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+	if limited != 4 {
+		t.Fatalf("expect 4 calls to be rate limited but got %d", limited)
+	}
+	stats := option.RateLimiter.Stats()["Stats"]
+	if stats.Allowed != 2 || stats.Rejected != 4 || stats.InFlight != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// limits of nodes
+	option.RateLimiter.SetLimit(RateLimit{QPS: 1000, Concurrency: 1, Key: RateLimitByNode})
+	for i := 0; i < 3; i++ {
+		if err := pool.Get().Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := option.RateLimiter.Stats()[addr]; stats.Allowed != 3 {
+		t.Fatalf("unexpected stats of the node %+v", stats)
+	}
+}
@@ -30,6 +30,8 @@ type RedisDiscovery struct {
 
 	filter ServiceDiscoveryFilter
 
+	discoveryEvents
+
 	stopCh chan struct{}
 }
 
@@ -50,7 +52,7 @@ func NewRedisDiscoveryStore(basePath string, kv store.Store) (*RedisDiscovery, e
 		basePath = basePath[:len(basePath)-1]
 	}
 
-	d := &RedisDiscovery{basePath: basePath, kv: kv}
+	d := &RedisDiscovery{basePath: basePath, kv: kv, discoveryEvents: newDiscoveryEvents("redis")}
 	d.stopCh = make(chan struct{})
 
 	ps, err := kv.List(basePath)
@@ -180,6 +182,7 @@ func (d *RedisDiscovery) watch() {
 					tempDelay = max
 				}
 				log.Warnf("can not watchtree (with retry %d, sleep %v): %s: %v", retry, tempDelay, d.basePath, err)
+				d.failed(DiscoveryWatch, err, true)
 				time.Sleep(tempDelay)
 				continue
 			}
@@ -188,6 +191,7 @@ func (d *RedisDiscovery) watch() {
 
 		if err != nil {
 			log.Errorf("can't watch %s: %v", d.basePath, err)
+			d.failed(DiscoveryWatch, err, true)
 			return
 		}
 
@@ -240,6 +244,7 @@ func (d *RedisDiscovery) watch() {
 				d.pairsMu.Lock()
 				d.pairs = pairs
 				d.pairsMu.Unlock()
+				d.updated()
 
 				d.mu.Lock()
 				for _, ch := range d.chans {
@@ -261,6 +266,7 @@ func (d *RedisDiscovery) watch() {
 		}
 
 		log.Warn("chan is closed and will rewatch")
+		d.failed(DiscoveryWatch, errWatchClosed, false)
 	}
 }
 
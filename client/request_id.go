@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+
+	"github.com/smallnest/rpcx/share"
+	"github.com/smallnest/rpcx/util"
+)
+
+// RequestIDPlugin sets a request ID in metadata of requests under share.RequestIDKey.
+// The ID in metadata of ctx is used if there is one. Otherwise the ID of the request handled by a service is used
+// if ctx is the context of the service, so calls to other services carry the same ID.
+// Otherwise a ULID is generated.
+//
+// The ID is returned in response metadata if ctx has share.ResMetaDataKey,
+// even if the call fails before the server responds.
+type RequestIDPlugin struct{}
+
+// NewRequestIDPlugin creates a RequestIDPlugin.
+func NewRequestIDPlugin() *RequestIDPlugin {
+	return &RequestIDPlugin{}
+}
+
+// PreCall sets the request ID.
+func (p *RequestIDPlugin) PreCall(ctx context.Context, servicePath, serviceMethod string, args interface{}) error {
+	rpcxContext, ok := ctx.(*share.Context)
+	if !ok {
+		return nil
+	}
+
+	meta, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	if meta[share.RequestIDKey] != "" {
+		return nil
+	}
+	id, _ := ctx.Value(share.RequestIDContextKey).(string)
+	if id == "" {
+		id = util.NewULID()
+	}
+
+	// metadata of ctx belongs to the caller, so it is copied
+	reqMeta := make(map[string]string, len(meta)+1)
+	for k, v := range meta {
+		reqMeta[k] = v
+	}
+	reqMeta[share.RequestIDKey] = id
+	rpcxContext.SetValue(share.ReqMetaDataKey, reqMeta)
+	return nil
+}
+
+// PostCall returns the request ID in response metadata.
+func (p *RequestIDPlugin) PostCall(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}, err error) error {
+	resMeta, ok := ctx.Value(share.ResMetaDataKey).(map[string]string)
+	if !ok || resMeta[share.RequestIDKey] != "" {
+		return nil
+	}
+	if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok && meta[share.RequestIDKey] != "" {
+		resMeta[share.RequestIDKey] = meta[share.RequestIDKey]
+	}
+	return nil
+}
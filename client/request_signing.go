@@ -0,0 +1,146 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// RequestSigningPlugin signs requests with a shared key by share.SignRequest, so that servers
+// with serverplugin.RequestSigningPlugin can authenticate them and reject replayed requests
+// on transports without TLS such as KCP.
+// The signature covers the payload as it is sent, after it is compressed, so servers verify the bytes they read
+// rather than what they decompress them to. Calls fail instead of being sent unsigned if they can't be signed.
+//
+// If VerifyResponses is set, it verifies responses signed by serverplugin.RequestSigningPlugin too, so responses
+// forged by men in the middle are rejected. Calls of responses failing verification fail with ErrInvalidSignature,
+// and they are not retried by XClient. Heartbeats and messages of servers which are not responses are not verified.
+type RequestSigningPlugin struct {
+	// VerifyResponses verifies signatures of responses, which servers must sign.
+	VerifyResponses bool
+	// MaxSkew is the max difference between timestamps of responses and the clock of the client.
+	// It defaults to DefaultResponseSkew.
+	MaxSkew time.Duration
+
+	mu    sync.RWMutex
+	keyID string
+	key   []byte
+	// the key before the last SetKey, so responses of requests signed by it are still verified
+	prevKeyID string
+	prevKey   []byte
+}
+
+// DefaultResponseSkew is the default MaxSkew of RequestSigningPlugin.
+const DefaultResponseSkew = time.Minute
+
+// ErrInvalidSignature is the error of calls whose responses fail verification of RequestSigningPlugin.
+var ErrInvalidSignature = errors.New("rpcx: invalid response signature")
+
+// NewRequestSigningPlugin creates a RequestSigningPlugin signing requests with key of keyID.
+func NewRequestSigningPlugin(keyID string, key []byte) *RequestSigningPlugin {
+	return &RequestSigningPlugin{keyID: keyID, key: key}
+}
+
+// SetKey changes the key signing requests, for example when keys are rotated.
+// Servers should accept both keys until all clients use the new one.
+// Responses signed by the previous key are still verified, since they may answer requests signed before.
+func (p *RequestSigningPlugin) SetKey(keyID string, key []byte) {
+	p.mu.Lock()
+	if keyID != p.keyID {
+		p.prevKeyID, p.prevKey = p.keyID, p.key
+	}
+	p.keyID, p.key = keyID, key
+	p.mu.Unlock()
+}
+
+// ClientBeforeEncode sets the key ID, timestamp, nonce and signature in metadata of requests.
+func (p *RequestSigningPlugin) ClientBeforeEncode(req *protocol.Message) error {
+	if req.IsHeartbeat() {
+		return nil
+	}
+
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(b[:])
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	p.mu.RLock()
+	keyID := p.keyID
+	signature := share.SignRequest(p.key, keyID, req.ServicePath, req.ServiceMethod, timestamp, nonce, req.WirePayload())
+	p.mu.RUnlock()
+
+	// metadata may belong to the caller, so it is copied
+	meta := make(map[string]string, len(req.Metadata)+4)
+	for k, v := range req.Metadata {
+		meta[k] = v
+	}
+	meta[share.SignatureKeyIDKey] = keyID
+	meta[share.SignatureTimestampKey] = timestamp
+	meta[share.SignatureNonceKey] = nonce
+	meta[share.SignatureKey] = signature
+	req.Metadata = meta
+	return nil
+}
+
+// ClientAfterDecode verifies the signature of res if VerifyResponses is set,
+// and removes the key ID, timestamp and signature from its metadata.
+func (p *RequestSigningPlugin) ClientAfterDecode(res *protocol.Message) error {
+	if !p.VerifyResponses || res.IsHeartbeat() || res.MessageType() != protocol.Response {
+		return nil
+	}
+	err := p.verify(res, time.Now().Unix())
+	delete(res.Metadata, share.SignatureKeyIDKey)
+	delete(res.Metadata, share.SignatureTimestampKey)
+	delete(res.Metadata, share.SignatureKey)
+	return err
+}
+
+func (p *RequestSigningPlugin) verify(res *protocol.Message, now int64) error {
+	meta := res.Metadata
+	keyID, timestamp, signature := meta[share.SignatureKeyIDKey], meta[share.SignatureTimestampKey], meta[share.SignatureKey]
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("%w: response is not signed", ErrInvalidSignature)
+	}
+
+	p.mu.RLock()
+	var key []byte
+	switch keyID {
+	case p.keyID:
+		key = p.key
+	case p.prevKeyID:
+		key = p.prevKey
+	}
+	p.mu.RUnlock()
+	if key == nil {
+		return fmt.Errorf("%w: response is signed by an unknown key %q", ErrInvalidSignature, keyID)
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", ErrInvalidSignature, timestamp)
+	}
+	skew := int64(p.MaxSkew / time.Second)
+	if p.MaxSkew == 0 {
+		skew = int64(DefaultResponseSkew / time.Second)
+	}
+	if ts < now-skew || ts > now+skew {
+		return fmt.Errorf("%w: timestamp of the response is outside the skew window", ErrInvalidSignature)
+	}
+
+	expected := share.SignResponse(key, keyID, res.ServicePath, res.ServiceMethod, res.Seq(), timestamp,
+		meta[protocol.ServiceError], res.WirePayload())
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
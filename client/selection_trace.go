@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// NodeScore is the weight or the score of a candidate considered by a selector.
+type NodeScore struct {
+	Node  string
+	Score float64
+}
+
+// SelectionExplanation explains a choice of a selector.
+type SelectionExplanation struct {
+	// Reason describes how the node is chosen, such as "round robin: index 1 of 3".
+	Reason string
+	// Scores are weights or scores of candidates considered, for weighted and latency based selectors.
+	Scores []NodeScore
+}
+
+// ExplainableSelector is a Selector which explains its choices, for selection traces of WithSelectionTrace.
+// Built-in selectors implement it. SelectExplained must have the same effects as Select,
+// such as moving to the next node of round robin.
+type ExplainableSelector interface {
+	Selector
+	SelectExplained(ctx context.Context, servicePath, serviceMethod string, args interface{}) (string, SelectionExplanation)
+}
+
+// SelectionAttempt is a choice of a node for a call, or a retry of the call on the same node.
+type SelectionAttempt struct {
+	// Candidates is the number of candidates after filtering by states and groups.
+	Candidates int
+	// Selected is the chosen node, or empty if there is no server.
+	Selected string
+	// Reason and Scores explain the choice, if the selector implements ExplainableSelector.
+	Reason string
+	Scores []NodeScore
+	// Err is the error of connecting the node or of the call on it.
+	Err error
+
+	called bool
+}
+
+// SelectionTrace records choices of nodes of calls of XClient, with their failover attempts.
+type SelectionTrace struct {
+	mu       sync.Mutex
+	attempts []SelectionAttempt
+}
+
+type selectionTraceKey struct{}
+
+// selectionTraced is set once WithSelectionTrace is used, so calls skip looking up traces in contexts before.
+var selectionTraced int32
+
+// WithSelectionTrace returns a context whose calls of XClient record how their nodes are chosen.
+// The trace is got by SelectionTraceFromContext after calls, and is added to logs of failed and slow calls,
+// see Option.SlowCallThreshold. Calls share the trace if they share the context.
+func WithSelectionTrace(ctx context.Context) context.Context {
+	atomic.StoreInt32(&selectionTraced, 1)
+	return context.WithValue(ctx, selectionTraceKey{}, &SelectionTrace{})
+}
+
+// SelectionTraceFromContext returns the trace of WithSelectionTrace of ctx, or nil.
+func SelectionTraceFromContext(ctx context.Context) *SelectionTrace {
+	t, _ := ctx.Value(selectionTraceKey{}).(*SelectionTrace)
+	return t
+}
+
+// selectionTraceOf returns the trace of ctx without looking up contexts if no traces are used.
+func selectionTraceOf(ctx context.Context) *SelectionTrace {
+	if atomic.LoadInt32(&selectionTraced) == 0 {
+		return nil
+	}
+	return SelectionTraceFromContext(ctx)
+}
+
+// Attempts returns a copy of the recorded attempts in order.
+func (t *SelectionTrace) Attempts() []SelectionAttempt {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]SelectionAttempt(nil), t.attempts...)
+}
+
+// selected records a choice of the selector. The reason notes plugins which replace the choice of the selector.
+func (t *SelectionTrace) selected(candidates int, k, chosen string, explanation *SelectionExplanation) {
+	a := SelectionAttempt{Candidates: candidates, Selected: k}
+	if explanation != nil {
+		a.Reason = explanation.Reason
+		a.Scores = explanation.Scores
+		if chosen != k {
+			a.Reason += "; replaced " + strconv.Quote(chosen) + " by plugins"
+		}
+	}
+	if k == "" {
+		a.Err = ErrXClientNoServer
+	}
+
+	t.mu.Lock()
+	t.attempts = append(t.attempts, a)
+	t.mu.Unlock()
+}
+
+// failed records the error of connecting the chosen node.
+func (t *SelectionTrace) failed(err error) {
+	t.mu.Lock()
+	if n := len(t.attempts); n > 0 && t.attempts[n-1].Err == nil {
+		t.attempts[n-1].Err = err
+	}
+	t.mu.Unlock()
+}
+
+// called records the result of a call on the last chosen node. Calls of the same choice again,
+// which are retries of Failtry, are recorded as new attempts.
+func (t *SelectionTrace) called(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := len(t.attempts)
+	if n == 0 {
+		return
+	}
+	last := &t.attempts[n-1]
+	if !last.called {
+		last.called = true
+		if last.Err == nil {
+			last.Err = err
+		}
+		return
+	}
+	t.attempts = append(t.attempts, SelectionAttempt{
+		Candidates: last.Candidates,
+		Selected:   last.Selected,
+		Reason:     "retry",
+		Err:        err,
+		called:     true,
+	})
+}
+
+// String formats attempts as "#1 tcp@host:port of 3 candidates (reason) [node=score ...] err=...; #2 ...".
+func (t *SelectionTrace) String() string {
+	var sb strings.Builder
+	for i, a := range t.Attempts() {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		selected := a.Selected
+		if selected == "" {
+			selected = "none"
+		}
+		fmt.Fprintf(&sb, "#%d %s of %d candidates", i+1, selected, a.Candidates)
+		if a.Reason != "" {
+			fmt.Fprintf(&sb, " (%s)", a.Reason)
+		}
+		if len(a.Scores) > 0 {
+			sb.WriteString(" [")
+			for j, s := range a.Scores {
+				if j > 0 {
+					sb.WriteByte(' ')
+				}
+				sb.WriteString(s.Node)
+				sb.WriteByte('=')
+				sb.WriteString(strconv.FormatFloat(s.Score, 'g', -1, 64))
+			}
+			sb.WriteByte(']')
+		}
+		if a.Err != nil {
+			fmt.Fprintf(&sb, " err=%v", a.Err)
+		}
+	}
+	return sb.String()
+}
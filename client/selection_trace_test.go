@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newTraceXClient(t *testing.T, failMode FailMode, selectMode SelectMode, pairs []*KVPair) XClient {
+	d, err := NewMultipleServersDiscovery(pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xclient := NewXClient("Stats", failMode, selectMode, d, DefaultOption)
+	t.Cleanup(func() { xclient.Close() })
+	return xclient
+}
+
+func TestSelectionTraceRoundRobin(t *testing.T) {
+	k1, k2 := "tcp@"+startStatsServer(t), "tcp@"+startStatsServer(t)
+	xclient := newTraceXClient(t, Failfast, RoundRobin, []*KVPair{{Key: k1}, {Key: k2}})
+
+	if SelectionTraceFromContext(context.Background()) != nil {
+		t.Fatal("expect no trace without WithSelectionTrace")
+	}
+	ctx := WithSelectionTrace(context.Background())
+	reply := &Reply{}
+	for i := 0; i < 2; i++ {
+		if err := xclient.Call(ctx, "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trace := SelectionTraceFromContext(ctx)
+	attempts := trace.Attempts()
+	if len(attempts) != 2 {
+		t.Fatalf("expect 2 attempts but got %s", trace)
+	}
+	for i, a := range attempts {
+		if a.Candidates != 2 || a.Err != nil {
+			t.Fatalf("unexpected attempt %+v", a)
+		}
+		if want := "round robin: index " + strconv.Itoa(i) + " of 2"; a.Reason != want {
+			t.Fatalf("expect reason %q but got %q", want, a.Reason)
+		}
+	}
+	if attempts[0].Selected == attempts[1].Selected {
+		t.Fatalf("expect both nodes to be selected but got %s", trace)
+	}
+}
+
+func TestSelectionTraceWeighted(t *testing.T) {
+	k1, k2 := "tcp@"+startStatsServer(t), "tcp@"+startStatsServer(t)
+	xclient := newTraceXClient(t, Failfast, WeightedRoundRobin, []*KVPair{{Key: k1, Value: "weight=3"}, {Key: k2, Value: "weight=1"}})
+
+	selected := make(map[string]int)
+	reply := &Reply{}
+	for i := 0; i < 4; i++ {
+		ctx := WithSelectionTrace(context.Background())
+		if err := xclient.Call(ctx, "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+			t.Fatal(err)
+		}
+		attempts := SelectionTraceFromContext(ctx).Attempts()
+		if len(attempts) != 1 {
+			t.Fatalf("expect 1 attempt but got %d", len(attempts))
+		}
+		a := attempts[0]
+		if a.Candidates != 2 || a.Reason != "weighted round robin: total weight 4" {
+			t.Fatalf("unexpected attempt %+v", a)
+		}
+		scores := map[string]float64{}
+		for _, s := range a.Scores {
+			scores[s.Node] = s.Score
+		}
+		if len(scores) != 2 || scores[k1] != 3 || scores[k2] != 1 {
+			t.Fatalf("expect weights as scores but got %+v", a.Scores)
+		}
+		selected[a.Selected]++
+	}
+	if selected[k1] != 3 || selected[k2] != 1 {
+		t.Fatalf("expect choices by weights but got %v", selected)
+	}
+}
+
+func TestSelectionTraceFailover(t *testing.T) {
+	k1, k2 := "tcp@"+startStatsServer(t), "tcp@127.0.0.1:1"
+	xclient := newTraceXClient(t, Failover, RoundRobin, []*KVPair{{Key: k1}, {Key: k2}})
+
+	// one of two calls selects the unavailable node first
+	var trace *SelectionTrace
+	reply := &Reply{}
+	for i := 0; i < 2; i++ {
+		ctx := WithSelectionTrace(context.Background())
+		if err := xclient.Call(ctx, "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+			t.Fatal(err)
+		}
+		if tr := SelectionTraceFromContext(ctx); len(tr.Attempts()) > 1 {
+			trace = tr
+		}
+	}
+	if trace == nil {
+		t.Fatal("expect a call with failover")
+	}
+	attempts := trace.Attempts()
+	if len(attempts) != 2 || attempts[0].Selected != k2 || attempts[0].Err == nil || attempts[1].Selected != k1 || attempts[1].Err != nil {
+		t.Fatalf("unexpected attempts %s", trace)
+	}
+	if s := trace.String(); !strings.HasPrefix(s, "#1 "+k2+" of 2 candidates (round robin: index ") || !strings.Contains(s, "; #2 "+k1) {
+		t.Fatalf("unexpected trace %s", s)
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"time"
 
 	"github.com/edwingeng/doublejump"
-
+	"github.com/smallnest/rpcx/share"
 	"github.com/valyala/fastrand"
 )
 
@@ -34,6 +34,8 @@ func newSelector(selectMode SelectMode, servers map[string]string) Selector {
 		return newWeightedICMPSelector(servers)
 	case ConsistentHash:
 		return newConsistentHashSelector(servers)
+	case LoadAware:
+		return NewLoadAwareSelector(servers)
 	case SelectByUser:
 		return nil
 	default:
@@ -64,6 +66,10 @@ func (s randomSelector) Select(ctx context.Context, servicePath, serviceMethod s
 	return ss[i]
 }
 
+func (s randomSelector) SelectExplained(ctx context.Context, servicePath, serviceMethod string, args interface{}) (string, SelectionExplanation) {
+	return s.Select(ctx, servicePath, serviceMethod, args), SelectionExplanation{Reason: "random: 1 of " + strconv.Itoa(len(s.servers))}
+}
+
 func (s *randomSelector) UpdateServer(servers map[string]string) {
 	ss := make([]string, 0, len(servers))
 	for k := range servers {
@@ -100,6 +106,15 @@ func (s *roundRobinSelector) Select(ctx context.Context, servicePath, serviceMet
 	return ss[i]
 }
 
+func (s *roundRobinSelector) SelectExplained(ctx context.Context, servicePath, serviceMethod string, args interface{}) (string, SelectionExplanation) {
+	i := 0
+	if len(s.servers) > 0 {
+		i = s.i % len(s.servers)
+	}
+	k := s.Select(ctx, servicePath, serviceMethod, args)
+	return k, SelectionExplanation{Reason: "round robin: index " + strconv.Itoa(i) + " of " + strconv.Itoa(len(s.servers))}
+}
+
 func (s *roundRobinSelector) UpdateServer(servers map[string]string) {
 	ss := make([]string, 0, len(servers))
 	for k := range servers {
@@ -131,18 +146,35 @@ func (s *weightedRoundRobinSelector) Select(ctx context.Context, servicePath, se
 	return w.Server
 }
 
+// SelectExplained returns weights of candidates as their scores.
+func (s *weightedRoundRobinSelector) SelectExplained(ctx context.Context, servicePath, serviceMethod string, args interface{}) (string, SelectionExplanation) {
+	k := s.Select(ctx, servicePath, serviceMethod, args)
+	return k, explainWeighted("weighted round robin", s.servers)
+}
+
 func (s *weightedRoundRobinSelector) UpdateServer(servers map[string]string) {
 	ss := createWeighted(servers)
 	s.servers = ss
 }
 
+func explainWeighted(reason string, ss []*Weighted) SelectionExplanation {
+	scores := make([]NodeScore, 0, len(ss))
+	total := 0
+	for _, w := range ss {
+		scores = append(scores, NodeScore{Node: w.Server, Score: float64(w.EffectiveWeight)})
+		total += w.EffectiveWeight
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Node < scores[j].Node })
+	return SelectionExplanation{Reason: reason + ": total weight " + strconv.Itoa(total), Scores: scores}
+}
+
 func createWeighted(servers map[string]string) []*Weighted {
 	ss := make([]*Weighted, 0, len(servers))
 	for k, metadata := range servers {
 		w := &Weighted{Server: k, Weight: 1, EffectiveWeight: 1}
 
 		if v, err := url.ParseQuery(metadata); err == nil {
-			ww := v.Get("weight")
+			ww := v.Get(share.MetaWeight)
 			if ww != "" {
 				if weight, err := strconv.Atoi(ww); err == nil {
 					w.Weight = weight
@@ -201,6 +233,18 @@ func (s geoSelector) Select(ctx context.Context, servicePath, serviceMethod stri
 	return server[s.r.Intn(len(server))]
 }
 
+// SelectExplained returns distances of candidates in kilometers as their scores, and the nearest is chosen.
+func (s geoSelector) SelectExplained(ctx context.Context, servicePath, serviceMethod string, args interface{}) (string, SelectionExplanation) {
+	k := s.Select(ctx, servicePath, serviceMethod, args)
+	scores := make([]NodeScore, 0, len(s.servers))
+	for _, gs := range s.servers {
+		d := getDistanceFrom(s.Latitude, s.Longitude, gs.Latitude, gs.Longitude)
+		scores = append(scores, NodeScore{Node: gs.Server, Score: d / 1000})
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Node < scores[j].Node })
+	return k, SelectionExplanation{Reason: "geo: nearest by distance", Scores: scores}
+}
+
 func (s *geoSelector) UpdateServer(servers map[string]string) {
 	ss := createGeoServer(servers)
 	s.servers = ss
@@ -264,6 +308,11 @@ func (s consistentHashSelector) Select(ctx context.Context, servicePath, service
 	return selected
 }
 
+func (s consistentHashSelector) SelectExplained(ctx context.Context, servicePath, serviceMethod string, args interface{}) (string, SelectionExplanation) {
+	key := genKey(servicePath, serviceMethod, args)
+	return s.Select(ctx, servicePath, serviceMethod, args), SelectionExplanation{Reason: "consistent hash: key " + strconv.FormatUint(key, 10)}
+}
+
 func (s *consistentHashSelector) UpdateServer(servers map[string]string) {
 	ss := make([]string, 0, len(servers))
 	for k := range servers {
@@ -1,7 +1,11 @@
 package client
 
 import (
+	"context"
 	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
 )
 
 func Test_consistentHashSelector_Select(t *testing.T) {
@@ -21,3 +25,41 @@ func Test_consistentHashSelector_Select(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadAwareSelector(t *testing.T) {
+	servers := map[string]string{
+		"tcp@192.168.1.16:9392": "",
+		"tcp@192.168.1.16:9393": "",
+	}
+	s := NewLoadAwareSelector(servers)
+	s.HalfLife = time.Hour
+	s.UpdateLoad("tcp@192.168.1.16:9393", protocol.LoadReport{Weight: 0})
+	for i := 0; i < 1000; i++ {
+		if selected := s.Select(context.Background(), "", "", nil); selected != "tcp@192.168.1.16:9392" {
+			t.Fatalf("expected the server which is not draining but got %s", selected)
+		}
+	}
+
+	// all servers are draining
+	s.UpdateLoad("tcp@192.168.1.16:9392", protocol.LoadReport{Weight: 0})
+	if selected := s.Select(context.Background(), "", "", nil); selected == "" {
+		t.Fatal("expected a server")
+	}
+
+	// reports decay to the default weight
+	now := time.Now()
+	s.loads["tcp@192.168.1.16:9392"] = serverLoad{weight: 100, at: now.Add(-time.Hour)}
+	if w := s.weight("tcp@192.168.1.16:9392", now); w != 75 {
+		t.Errorf("expected 75 after a half-life but got %v", w)
+	}
+	s.loads["tcp@192.168.1.16:9393"] = serverLoad{weight: 0, at: now.Add(-2 * time.Hour)}
+	if w := s.weight("tcp@192.168.1.16:9393", now); w != 37.5 {
+		t.Errorf("expected 37.5 after two half-lives but got %v", w)
+	}
+
+	// loads of removed servers are pruned
+	s.UpdateServer(map[string]string{"tcp@192.168.1.16:9392": ""})
+	if _, ok := s.loads["tcp@192.168.1.16:9393"]; ok {
+		t.Error("expected the load of the removed server to be pruned")
+	}
+}
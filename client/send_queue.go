@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/rpcx/codec"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// ErrSendQueueFull is the error of calls failed by Option.FailFastOnFullQueue because the send queue
+// of the connection is full. Their requests are not sent.
+var ErrSendQueueFull = errors.New("rpcx: send queue of the connection is full")
+
+// heartbeatQueueSize is the size of the queue of heartbeats, which are written before other requests.
+const heartbeatQueueSize = 16
+
+// states of sendItems
+const (
+	sendQueued int32 = iota
+	sendWriting
+	sendCanceled
+)
+
+// sendItem is an encoded request in the send queue. Whoever changes its state from sendQueued owns it:
+// the writer writes it and reports the result by done, and the caller giving it up frees it.
+type sendItem struct {
+	msg   *protocol.EncodedMessage
+	codec codec.Codec // releases data, the payload of msg, after it is written
+	data  []byte
+	state int32
+	done  chan error
+}
+
+func (item *sendItem) free() {
+	item.msg.Free()
+	share.ReleasePayload(item.codec, item.data)
+}
+
+// sendQueue is the bounded queue of requests written on the connection by the writer goroutine, see Option.SendQueueSize.
+type sendQueue struct {
+	queue      chan *sendItem
+	heartbeats chan *sendItem
+	closeOnce  sync.Once
+	closing    chan struct{} // closed to stop the writer
+	stopped    chan struct{} // closed when the writer stops
+	writing    int64         // unix nanoseconds when the current write started, or zero
+	queued     int64         // requests in the queue which are not given up
+}
+
+// take changes the state of item from sendQueued to state, and reports whether the caller owns it.
+func (q *sendQueue) take(item *sendItem, state int32) bool {
+	if !atomic.CompareAndSwapInt32(&item.state, sendQueued, state) {
+		return false
+	}
+	atomic.AddInt64(&q.queued, -1)
+	return true
+}
+
+// startSendQueue starts the writer of the connection.
+func (client *Client) startSendQueue() {
+	q := &sendQueue{
+		queue:      make(chan *sendItem, client.option.SendQueueSize),
+		heartbeats: make(chan *sendItem, heartbeatQueueSize),
+		closing:    make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	client.sendQueue = q
+	go client.writeLoop(q)
+}
+
+// stopSendQueue stops the writer, which fails requests in the queue with ErrShutdown.
+func (client *Client) stopSendQueue() {
+	if q := client.sendQueue; q != nil {
+		q.closeOnce.Do(func() { close(q.closing) })
+	}
+}
+
+func (client *Client) writeLoop(q *sendQueue) {
+	defer close(q.stopped)
+	for {
+		var item *sendItem
+		select {
+		case item = <-q.heartbeats:
+		default:
+			select {
+			case item = <-q.heartbeats:
+			case item = <-q.queue:
+			case <-q.closing:
+				q.drain()
+				return
+			}
+		}
+		atomic.StoreInt64(&q.writing, time.Now().UnixNano())
+		client.writeItem(q, item)
+		atomic.StoreInt64(&q.writing, 0)
+	}
+}
+
+// writeItem writes item unless its caller has given it up.
+func (client *Client) writeItem(q *sendQueue, item *sendItem) {
+	if !q.take(item, sendWriting) {
+		return
+	}
+	err := client.write(item.msg)
+	share.ReleasePayload(item.codec, item.data)
+	if err == nil {
+		atomic.AddUint64(&client.stats.calls, 1)
+	}
+	item.done <- err
+}
+
+// writeHeartbeats writes queued heartbeats, between chunk frames of large requests.
+func (client *Client) writeHeartbeats(q *sendQueue) {
+	for {
+		select {
+		case item := <-q.heartbeats:
+			client.writeItem(q, item)
+		default:
+			return
+		}
+	}
+}
+
+// drain fails requests in the queue when the writer stops.
+func (q *sendQueue) drain() {
+	for {
+		var item *sendItem
+		select {
+		case item = <-q.heartbeats:
+		case item = <-q.queue:
+		default:
+			return
+		}
+		if q.take(item, sendCanceled) {
+			item.free()
+			item.done <- ErrShutdown
+		}
+	}
+}
+
+// writeMessage writes msg, the encoded request of call whose payload is data encoded by c, and counts it in the calls
+// of the client. It is queued for the writer of Option.SendQueueSize if there is one, and the error of ctx is returned
+// if ctx is done before it is written. Requests which are being written are not interrupted, and they fail
+// with TimeoutErrors whose Sent is true, unless wait is set for requests referring to payloads of their callers,
+// which are returned after they are written.
+func (client *Client) writeMessage(ctx context.Context, call *Call, msg *protocol.EncodedMessage, c codec.Codec, data []byte,
+	heartbeat, wait bool) error {
+	q := client.sendQueue
+	if q == nil {
+		err := client.write(msg)
+		call.written = time.Now()
+		share.ReleasePayload(c, data)
+		if err == nil {
+			atomic.AddUint64(&client.stats.calls, 1)
+		}
+		return err
+	}
+
+	item := &sendItem{msg: msg, codec: c, data: data, done: make(chan error, 1)}
+	ch := q.queue
+	if heartbeat {
+		ch = q.heartbeats
+	}
+	atomic.AddInt64(&q.queued, 1)
+	select {
+	case ch <- item:
+	default:
+		var err error
+		if client.option.FailFastOnFullQueue && !heartbeat {
+			err = ErrSendQueueFull
+		} else {
+			select {
+			case ch <- item:
+			case <-ctx.Done():
+				err = contextError(ctx, call.start, time.Time{})
+			case <-q.stopped:
+				err = ErrShutdown
+			}
+		}
+		if err != nil {
+			atomic.AddInt64(&q.queued, -1)
+			item.free()
+			return err
+		}
+	}
+
+	select {
+	case err := <-item.done:
+		call.written = time.Now()
+		return err
+	case <-ctx.Done():
+	case <-q.stopped:
+	}
+	if q.take(item, sendCanceled) {
+		item.free()
+		if ctx.Err() != nil {
+			return contextError(ctx, call.start, time.Time{})
+		}
+		return ErrShutdown
+	}
+	// it is being written, or failed by the writer
+	if wait || ctx.Err() == nil {
+		err := <-item.done
+		call.written = time.Now()
+		return err
+	}
+	// it is not written entirely before the deadline
+	return contextError(ctx, call.start, time.Now())
+}
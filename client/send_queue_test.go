@@ -0,0 +1,171 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// startStalledServer accepts connections but never reads them, so writes of clients block once buffers are full.
+func startStalledServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	return ln.Addr().String()
+}
+
+// stallWriter starts a call whose large request blocks the writer of client, and returns its error.
+func stallWriter(t *testing.T, client *Client, timeout time.Duration) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(WithSerializeType(context.Background(), protocol.SerializeNone), timeout)
+		defer cancel()
+		errs <- client.Call(ctx, "Arith", "Mul", make([]byte, 64<<20), &[]byte{})
+	}()
+	waitFor(t, func() bool { return client.Stats().SendStall > 0 })
+	return errs
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newQueuedClient(t *testing.T, addr string, size int, failFast bool) *Client {
+	option := DefaultOption
+	option.NegotiateTimeout = 0
+	option.SendQueueSize = size
+	option.FailFastOnFullQueue = failFast
+	client := NewClient(option)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestSendQueueDeadline(t *testing.T) {
+	client := newQueuedClient(t, startStalledServer(t), 1, false)
+	stalled := stallWriter(t, client, 300*time.Millisecond)
+
+	// calls behind the stalled write time out on time, and their requests are not sent
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := client.Call(ctx, "Arith", "Mul", &Args{A: 2, B: 3}, &Reply{})
+	var te *TimeoutError
+	if !errors.As(err, &te) || !errors.Is(err, ErrTimeoutBeforeSend) {
+		t.Fatalf("expect the timeout before the request is sent but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("expect the call to time out by its deadline but it took %v", elapsed)
+	}
+
+	// the stalled request has been sent in part
+	err = <-stalled
+	if !errors.As(err, &te) || !te.Sent || te.Written {
+		t.Fatalf("expect the timeout of the request being written but got %#v", err)
+	}
+	stats := client.Stats()
+	if stats.SendStall == 0 || stats.SendQueueDepth != 0 || stats.TimeoutErrors != 2 || stats.Calls != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSendQueueFailFast(t *testing.T) {
+	client := newQueuedClient(t, startStalledServer(t), 1, true)
+	stalled := stallWriter(t, client, time.Minute)
+
+	queued := make(chan error, 1)
+	go func() {
+		queued <- client.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, &Reply{})
+	}()
+	waitFor(t, func() bool { return client.Stats().SendQueueDepth == 1 })
+
+	start := time.Now()
+	if err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, &Reply{}); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("expect ErrSendQueueFull but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("expect the call to fail at once but it took %v", elapsed)
+	}
+	if uncoverError(ErrSendQueueFull) {
+		t.Fatal("expect connections with full queues to be kept")
+	}
+
+	// closing fails calls in the queue
+	client.Close()
+	if err := <-queued; err == nil {
+		t.Fatal("expect the queued call to fail")
+	}
+	if err := <-stalled; err == nil {
+		t.Fatal("expect the stalled call to fail")
+	}
+}
+
+func TestSendQueueHeartbeats(t *testing.T) {
+	conn, server := net.Pipe()
+	defer server.Close()
+	client := NewClient(Option{SerializeType: protocol.MsgPack, SendQueueSize: 4})
+	client.Conn = conn
+	client.startSendQueue()
+	defer client.Close()
+
+	// the first request blocks the writer until it is read
+	go client.Go(context.Background(), "Arith", "Mul", &Args{A: 1}, &Reply{}, nil)
+	waitFor(t, func() bool { return client.Stats().SendStall > 0 })
+	for i := 2; i <= 3; i++ {
+		go client.Go(context.Background(), "Arith", "Mul", &Args{A: i}, &Reply{}, nil)
+		n := i - 1
+		waitFor(t, func() bool { return client.Stats().SendQueueDepth == n })
+	}
+	go client.Go(context.Background(), "", "", &Args{}, &Reply{}, nil)
+	waitFor(t, func() bool { return client.Stats().SendQueueDepth == 3 })
+
+	r := bufio.NewReader(server)
+	var heartbeats []bool
+	for i := 0; i < 4; i++ {
+		msg := protocol.NewMessage()
+		if err := msg.Decode(r); err != nil {
+			t.Fatal(err)
+		}
+		heartbeats = append(heartbeats, msg.IsHeartbeat())
+	}
+	if !heartbeats[1] || heartbeats[0] || heartbeats[2] || heartbeats[3] {
+		t.Fatalf("expect the heartbeat to be written after the request being written, but got %v", heartbeats)
+	}
+	waitFor(t, func() bool { return client.Stats().Calls == 4 })
+}
@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceErrorFromMetadata(t *testing.T) {
+	e := serviceErrorFromMetadata(map[string]string{
+		protocol.ServiceError:                       "warming up",
+		protocol.ServiceErrorCode:                   "14",
+		protocol.ServiceErrorDetailPrefix + "retry": "1s",
+		"other": "value",
+	})
+	assert.Equal(t, "warming up", e.Error())
+	ce, ok := e.(*CodedServiceError)
+	if assert.True(t, ok) {
+		assert.Equal(t, int(rerrors.Unavailable), ce.Code())
+		assert.Equal(t, map[string]string{"retry": "1s"}, ce.Details())
+	}
+	assert.True(t, errors.Is(e, rerrors.ErrUnavailable))
+	assert.True(t, isFailure(e))
+	var se ServiceError
+	assert.True(t, errors.As(e, &se))
+	assert.Equal(t, ServiceError("warming up"), se)
+
+	// plain errors are ServiceErrors as they are
+	e = serviceErrorFromMetadata(map[string]string{protocol.ServiceError: "plain"})
+	assert.Equal(t, ServiceError("plain"), e)
+	assert.False(t, isFailure(e))
+	assert.True(t, isServiceError(e))
+
+	assert.False(t, isFailure(NewServiceError(rerrors.NotFound, "not found", nil)))
+	assert.False(t, isFailure(context.Canceled))
+	assert.True(t, isFailure(errors.New("connection reset")))
+}
@@ -0,0 +1,259 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ClientState is the state of the connection of a Client.
+type ClientState int
+
+const (
+	// ClientNotConnected means Connect has not succeeded yet.
+	ClientNotConnected ClientState = iota
+	// ClientConnected means the connection is serving calls.
+	ClientConnected
+	// ClientReconnecting means the connection is broken by errors or the server,
+	// and XClient connects the server again on the next call to it.
+	ClientReconnecting
+	// ClientClosed means the client has been closed by Close.
+	ClientClosed
+	// ClientIdle means the client of the node has been closed by Option.CachedClientIdleTimeout of XClient,
+	// which connects the node again on the next call to it.
+	ClientIdle
+)
+
+func (s ClientState) String() string {
+	switch s {
+	case ClientNotConnected:
+		return "not_connected"
+	case ClientConnected:
+		return "connected"
+	case ClientReconnecting:
+		return "reconnecting"
+	case ClientClosed:
+		return "closed"
+	case ClientIdle:
+		return "idle"
+	default:
+		return "unknown"
+	}
+}
+
+// ClientStats is a snapshot of the counters and the connection state of a Client.
+// Counters are cumulative since the client is created or ResetStats is called,
+// and counters of XClient are cumulative across reconnects of its nodes.
+type ClientStats struct {
+	State ClientState
+
+	// Calls is the number of requests written, including oneway requests and heartbeats.
+	Calls uint64
+	// Responses is the number of responses received.
+	Responses uint64
+
+	// Errors of calls by classes: TimeoutErrors of deadlines and timeouts, ShutdownErrors of ErrShutdown,
+	// ServiceErrors of errors returned by services, and OtherErrors of others such as canceled contexts and broken connections.
+	TimeoutErrors  uint64
+	ShutdownErrors uint64
+	ServiceErrors  uint64
+	OtherErrors    uint64
+
+	BytesSent     uint64
+	BytesReceived uint64
+
+	// Pending is the number of calls waiting for their responses now.
+	Pending int
+	// Reconnects is the number of connections after the first one, and ReconnectAttempts counts failed ones too.
+	// Only XClient reconnects nodes, so they are zero for clients created by NewClient.
+	Reconnects        uint64
+	ReconnectAttempts uint64
+	// IdleReaps is the number of clients of the node closed by Option.CachedClientIdleTimeout of XClient.
+	// Connects after them are counted in Reconnects.
+	IdleReaps uint64
+
+	// Heartbeats is the number of heartbeats of Option.Heartbeat sent, and HeartbeatSuccesses of them got their replies.
+	Heartbeats         uint64
+	HeartbeatSuccesses uint64
+	// HeartbeatFailures is the number of heartbeats without their replies in Option.MaxWaitForHeartbeat.
+	HeartbeatFailures uint64
+	// ConsecutiveHeartbeatFailures is the number of heartbeats failed since the last successful one.
+	// Clients are closed by failed heartbeats, so it is more than one only for nodes of XClient.
+	ConsecutiveHeartbeatFailures uint64
+	// LastHeartbeat is the time of the last successful heartbeat, or zero if there is none.
+	LastHeartbeat time.Time
+
+	// Uptime is how long the current connection has been connected, or zero if it is not connected.
+	Uptime time.Duration
+
+	// SendQueueDepth is the number of requests waiting in the queue of Option.SendQueueSize now,
+	// and SendStall is how long the current write of the connection has taken, or zero if nothing is being written.
+	SendQueueDepth int
+	SendStall      time.Duration
+}
+
+// SinceLastHeartbeat returns the time since the last successful heartbeat, or zero if there is none.
+func (s ClientStats) SinceLastHeartbeat() time.Duration {
+	if s.LastHeartbeat.IsZero() {
+		return 0
+	}
+	return time.Since(s.LastHeartbeat)
+}
+
+// add adds counters of o, which are of earlier connections of the same node, to s, for stats of XClient.
+func (s *ClientStats) add(o ClientStats) {
+	if s.HeartbeatSuccesses == 0 {
+		s.ConsecutiveHeartbeatFailures += o.ConsecutiveHeartbeatFailures
+	}
+	if o.LastHeartbeat.After(s.LastHeartbeat) {
+		s.LastHeartbeat = o.LastHeartbeat
+	}
+	s.Calls += o.Calls
+	s.Responses += o.Responses
+	s.TimeoutErrors += o.TimeoutErrors
+	s.ShutdownErrors += o.ShutdownErrors
+	s.ServiceErrors += o.ServiceErrors
+	s.OtherErrors += o.OtherErrors
+	s.BytesSent += o.BytesSent
+	s.BytesReceived += o.BytesReceived
+	s.Pending += o.Pending
+	s.Reconnects += o.Reconnects
+	s.ReconnectAttempts += o.ReconnectAttempts
+	s.IdleReaps += o.IdleReaps
+	s.Heartbeats += o.Heartbeats
+	s.HeartbeatSuccesses += o.HeartbeatSuccesses
+	s.HeartbeatFailures += o.HeartbeatFailures
+}
+
+// clientStats are counters of a Client, updated by atomics.
+type clientStats struct {
+	calls             uint64
+	responses         uint64
+	timeoutErrors     uint64
+	shutdownErrors    uint64
+	serviceErrors     uint64
+	otherErrors       uint64
+	bytesSent         uint64
+	bytesReceived     uint64
+	connects          uint64
+	heartbeats        uint64
+	heartbeatOKs      uint64
+	heartbeatFailures uint64
+	connectedAt       int64 // unix nanoseconds of the current connection
+	lastHeartbeat     int64 // unix nanoseconds of the last successful heartbeat
+	active            int64 // calls which are not done, from Go or SendRaw of the client
+}
+
+// callDone counts the error of a done call.
+func (s *clientStats) callDone(err error) {
+	if err == nil {
+		return
+	}
+	var se ServiceError
+	var ne net.Error
+	switch {
+	case errors.Is(err, ErrShutdown):
+		atomic.AddUint64(&s.shutdownErrors, 1)
+	case errors.As(err, &se):
+		atomic.AddUint64(&s.serviceErrors, 1)
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout():
+		atomic.AddUint64(&s.timeoutErrors, 1)
+	default:
+		atomic.AddUint64(&s.otherErrors, 1)
+	}
+}
+
+// heartbeat counts a heartbeat and its result.
+func (s *clientStats) heartbeat(ok bool) {
+	atomic.AddUint64(&s.heartbeats, 1)
+	if ok {
+		atomic.AddUint64(&s.heartbeatOKs, 1)
+		atomic.StoreInt64(&s.lastHeartbeat, time.Now().UnixNano())
+	} else {
+		atomic.AddUint64(&s.heartbeatFailures, 1)
+	}
+}
+
+func (s *clientStats) connected() {
+	atomic.AddUint64(&s.connects, 1)
+	atomic.StoreInt64(&s.connectedAt, time.Now().UnixNano())
+}
+
+// countingReader counts bytes read from the connection.
+type countingReader struct {
+	r io.Reader
+	n *uint64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddUint64(r.n, uint64(n))
+	return n, err
+}
+
+// Stats returns a snapshot of the counters and the connection state of the client.
+func (client *Client) Stats() ClientStats {
+	s := &client.stats
+	stats := ClientStats{
+		Calls:              atomic.LoadUint64(&s.calls),
+		Responses:          atomic.LoadUint64(&s.responses),
+		TimeoutErrors:      atomic.LoadUint64(&s.timeoutErrors),
+		ShutdownErrors:     atomic.LoadUint64(&s.shutdownErrors),
+		ServiceErrors:      atomic.LoadUint64(&s.serviceErrors),
+		OtherErrors:        atomic.LoadUint64(&s.otherErrors),
+		BytesSent:          atomic.LoadUint64(&s.bytesSent),
+		BytesReceived:      atomic.LoadUint64(&s.bytesReceived),
+		Heartbeats:         atomic.LoadUint64(&s.heartbeats),
+		HeartbeatSuccesses: atomic.LoadUint64(&s.heartbeatOKs),
+		HeartbeatFailures:  atomic.LoadUint64(&s.heartbeatFailures),
+	}
+	// failed heartbeats close the client, so there are no heartbeats after a failed one
+	if stats.HeartbeatFailures > 0 {
+		stats.ConsecutiveHeartbeatFailures = 1
+	}
+	if last := atomic.LoadInt64(&s.lastHeartbeat); last > 0 {
+		stats.LastHeartbeat = time.Unix(0, last)
+	}
+	if connects := atomic.LoadUint64(&s.connects); connects > 1 {
+		stats.Reconnects = connects - 1
+	}
+
+	client.mutex.Lock()
+	stats.Pending = len(client.pending)
+	switch {
+	case client.closing:
+		stats.State = ClientClosed
+	case client.shutdown:
+		stats.State = ClientReconnecting
+	case client.Conn != nil:
+		stats.State = ClientConnected
+	}
+	client.mutex.Unlock()
+
+	if stats.State == ClientConnected {
+		stats.Uptime = time.Since(time.Unix(0, atomic.LoadInt64(&s.connectedAt)))
+	}
+	if q := client.sendQueue; q != nil {
+		stats.SendQueueDepth = int(atomic.LoadInt64(&q.queued))
+		if start := atomic.LoadInt64(&q.writing); start > 0 {
+			stats.SendStall = time.Since(time.Unix(0, start))
+		}
+	}
+	return stats
+}
+
+// ResetStats resets the counters of the client to zero, such as between cases of tests.
+// The connection state, pending calls and the uptime are not counters, so they are not reset.
+func (client *Client) ResetStats() {
+	s := &client.stats
+	for _, p := range []*uint64{&s.calls, &s.responses, &s.timeoutErrors, &s.shutdownErrors, &s.serviceErrors,
+		&s.otherErrors, &s.bytesSent, &s.bytesReceived, &s.heartbeats, &s.heartbeatOKs, &s.heartbeatFailures} {
+		atomic.StoreUint64(p, 0)
+	}
+	if atomic.LoadUint64(&s.connects) > 0 {
+		atomic.StoreUint64(&s.connects, 1)
+	}
+}
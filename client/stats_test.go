@@ -0,0 +1,297 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/smallnest/rpcx/server"
+)
+
+type statsService struct{}
+
+func (s *statsService) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	reply.C = args.A * args.B
+	return nil
+}
+
+func (s *statsService) Fail(ctx context.Context, args *Args, reply *Reply) error {
+	return errors.New("failed")
+}
+
+func (s *statsService) Slow(ctx context.Context, args *Args, reply *Reply) error {
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+func startStatsServer(t *testing.T) string {
+	s := server.NewServer()
+	s.RegisterName("Stats", new(statsService), "")
+	return startServer(t, s)
+}
+
+func TestClientStats(t *testing.T) {
+	addr := startStatsServer(t)
+	client := NewClient(DefaultOption)
+	if s := client.Stats(); s.State != ClientNotConnected {
+		t.Fatalf("expect not connected but got %v", s.State)
+	}
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	client.ResetStats() // without the negotiation
+
+	reply := &Reply{}
+	for i := 0; i < 3; i++ {
+		if err := client.Call(context.Background(), "Stats", "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Call(context.Background(), "Stats", "Fail", &Args{}, reply); err == nil {
+		t.Fatal("expect an error")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := client.Call(ctx, "Stats", "Slow", &Args{}, reply); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect DeadlineExceeded but got %v", err)
+	}
+	if err := client.Notify(context.Background(), "Stats", "Mul", &Args{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond) // the response of Slow
+
+	s := client.Stats()
+	if s.State != ClientConnected || s.Uptime <= 0 {
+		t.Fatalf("expect a connected client but got %v, %v", s.State, s.Uptime)
+	}
+	if s.Calls != 6 || s.Responses != 5 || s.Pending != 0 {
+		t.Fatalf("expect 6 calls and 5 responses but got %+v", s)
+	}
+	if s.ServiceErrors != 1 || s.TimeoutErrors != 1 || s.ShutdownErrors != 0 || s.OtherErrors != 0 {
+		t.Fatalf("unexpected errors %+v", s)
+	}
+	if s.BytesSent == 0 || s.BytesReceived == 0 {
+		t.Fatalf("expect bytes but got %+v", s)
+	}
+
+	client.Close()
+	if err := client.Call(context.Background(), "Stats", "Mul", &Args{}, reply); err != ErrShutdown {
+		t.Fatalf("expect ErrShutdown but got %v", err)
+	}
+	s = client.Stats()
+	if s.State != ClientClosed || s.ShutdownErrors != 1 || s.Uptime != 0 {
+		t.Fatalf("expect a closed client but got %+v", s)
+	}
+
+	client.ResetStats()
+	if s = client.Stats(); s.Calls != 0 || s.BytesSent != 0 || s.ShutdownErrors != 0 {
+		t.Fatalf("expect reset counters but got %+v", s)
+	}
+}
+
+func TestXClientStats(t *testing.T) {
+	addr := startStatsServer(t)
+	d, err := NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xclient := NewXClient("Stats", Failtry, RandomSelect, d, DefaultOption)
+	defer xclient.Close()
+
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+		t.Fatal(err)
+	}
+	k := "tcp@" + addr
+	before := xclient.Stats()[k]
+	if before.State != ClientConnected || before.Calls != 1 || before.Reconnects != 0 {
+		t.Fatalf("unexpected stats %+v", before)
+	}
+
+	// the connection is broken, and counters are kept after the node is reconnected
+	xc := xclient.(*xClient)
+	xc.mu.RLock()
+	xc.cachedClient[k].Close()
+	xc.mu.RUnlock()
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+		t.Fatal(err)
+	}
+	after := xclient.Stats()[k]
+	if after.State != ClientConnected || after.Reconnects != 1 || after.Calls != before.Calls+1 || after.BytesSent <= before.BytesSent {
+		t.Fatalf("expect cumulative stats after %+v but got %+v", before, after)
+	}
+
+	xclient.Close()
+	if s := xclient.Stats()[k]; s.State != ClientClosed || s.Calls != after.Calls {
+		t.Fatalf("expect a closed node but got %+v", s)
+	}
+}
+
+// dropProxy forwards connections to a server, and discards bytes of them while dropping is set.
+type dropProxy struct {
+	ln       net.Listener
+	backend  string
+	dropping int32
+}
+
+func startDropProxy(t *testing.T, backend string) *dropProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &dropProxy{ln: ln, backend: backend}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", backend)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go p.forward(conn, upstream)
+			go p.forward(upstream, conn)
+		}
+	}()
+	return p
+}
+
+func (p *dropProxy) forward(src, dst net.Conn) {
+	defer src.Close()
+	defer dst.Close()
+	buf := make([]byte, 4096)
+	for {
+		n, err := src.Read(buf)
+		if err != nil {
+			return
+		}
+		w := io.Writer(dst)
+		if atomic.LoadInt32(&p.dropping) == 1 {
+			w = ioutil.Discard
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+type connectionHealthPlugin struct {
+	mu         sync.Mutex
+	heartbeats []error
+	reconnects []error
+}
+
+func (p *connectionHealthPlugin) Heartbeat(remoteAddr string, rtt time.Duration, err error) {
+	p.mu.Lock()
+	p.heartbeats = append(p.heartbeats, err)
+	p.mu.Unlock()
+}
+
+func (p *connectionHealthPlugin) Reconnect(servicePath, node string, err error) {
+	p.mu.Lock()
+	p.reconnects = append(p.reconnects, err)
+	p.mu.Unlock()
+}
+
+func waitStats(t *testing.T, xclient XClient, k string, ok func(s ClientStats) bool) ClientStats {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := xclient.Stats()[k]
+		if ok(s) {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected stats %+v", s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestXClientHeartbeatStats(t *testing.T) {
+	proxy := startDropProxy(t, startStatsServer(t))
+	addr := proxy.ln.Addr().String()
+	k := "tcp@" + addr
+	d, err := NewPeer2PeerDiscovery(k, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	option := DefaultOption
+	option.Heartbeat = true
+	option.HeartbeatInterval = 50 * time.Millisecond
+	option.MaxWaitForHeartbeat = 100 * time.Millisecond
+	xclient := NewXClient("Stats", Failfast, RandomSelect, d, option)
+	defer xclient.Close()
+
+	registry := prometheus.NewRegistry()
+	pp, err := NewPrometheusPlugin(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hp := &connectionHealthPlugin{}
+	xclient.GetPlugins().Add(pp)
+	xclient.GetPlugins().Add(hp)
+
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+		t.Fatal(err)
+	}
+	waitStats(t, xclient, k, func(s ClientStats) bool { return s.HeartbeatSuccesses >= 2 })
+
+	// heartbeats are dropped, and the client is closed by the failed heartbeat
+	atomic.StoreInt32(&proxy.dropping, 1)
+	failed := waitStats(t, xclient, k, func(s ClientStats) bool { return s.HeartbeatFailures == 1 })
+	if failed.ConsecutiveHeartbeatFailures != 1 || failed.Heartbeats != failed.HeartbeatSuccesses+1 || failed.LastHeartbeat.IsZero() {
+		t.Fatalf("unexpected stats of the failed heartbeat %+v", failed)
+	}
+	if v := testutil.ToFloat64(pp.consecutiveFailures.WithLabelValues(addr)); v != 1 {
+		t.Fatalf("expect 1 consecutive failure but got %v", v)
+	}
+
+	// the server recovers, and the node is reconnected by the next call
+	atomic.StoreInt32(&proxy.dropping, 0)
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+		t.Fatal(err)
+	}
+	recovered := waitStats(t, xclient, k, func(s ClientStats) bool {
+		return s.HeartbeatSuccesses > failed.HeartbeatSuccesses
+	})
+	if recovered.ConsecutiveHeartbeatFailures != 0 || recovered.HeartbeatFailures != 1 || !recovered.LastHeartbeat.After(failed.LastHeartbeat) ||
+		recovered.Reconnects != 1 || recovered.ReconnectAttempts != 1 || recovered.SinceLastHeartbeat() <= 0 {
+		t.Fatalf("unexpected stats after recovery %+v", recovered)
+	}
+
+	if v := testutil.ToFloat64(pp.heartbeats.WithLabelValues(addr, "failure")); v != 1 {
+		t.Fatalf("expect 1 failed heartbeat but got %v", v)
+	}
+	if v := testutil.ToFloat64(pp.consecutiveFailures.WithLabelValues(addr)); v != 0 {
+		t.Fatalf("expect no consecutive failures but got %v", v)
+	}
+	if v := testutil.ToFloat64(pp.reconnects.WithLabelValues(addr, "success")); v != 1 {
+		t.Fatalf("expect 1 reconnect but got %v", v)
+	}
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if len(hp.reconnects) != 1 || hp.reconnects[0] != nil {
+		t.Fatalf("expect a reconnect but got %v", hp.reconnects)
+	}
+	failures := 0
+	for _, err := range hp.heartbeats {
+		if err != nil {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Fatalf("expect 1 failed heartbeat but got %v", hp.heartbeats)
+	}
+}
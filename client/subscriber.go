@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// SubscriberBufferSize is the size of the buffer of server messages received by a Subscriber.
+// Messages are dropped by the client if it is full, like messages of ServerMessageChan.
+var SubscriberBufferSize = 1024
+
+// Subscriber subscribes a client to groups of messages that its server publishes by server.SendToGroupAck.
+// Messages are sent to the channel of the Subscriber and acknowledged once the channel receives them,
+// so servers see subscribers whose channels are not drained as slow ones. Other server messages,
+// including the error message sent when the connection is closed, are sent to the channel as they are.
+//
+// A Subscriber remembers the last message received from every group, and Reconnect subscribes a new client
+// to the groups again, so that messages kept by the server since then are sent again.
+type Subscriber struct {
+	ch chan<- *protocol.Message
+	in chan *protocol.Message
+
+	mu     sync.Mutex
+	client *Client
+	groups map[string]uint64 // IDs of the last messages received from subscribed groups
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewSubscriber creates a Subscriber which receives server messages of client, as its ServerMessageChan, and sends them to ch.
+func NewSubscriber(client *Client, ch chan<- *protocol.Message) *Subscriber {
+	s := &Subscriber{
+		ch:     ch,
+		in:     make(chan *protocol.Message, SubscriberBufferSize),
+		client: client,
+		groups: make(map[string]uint64),
+		done:   make(chan struct{}),
+	}
+	client.RegisterServerMessageChan(s.in)
+	go s.receive()
+	return s
+}
+
+// Subscribe subscribes to group.
+func (s *Subscriber) Subscribe(ctx context.Context, group string) error {
+	s.mu.Lock()
+	client := s.client
+	after, subscribed := s.groups[group]
+	if !subscribed {
+		s.groups[group] = 0
+	}
+	s.mu.Unlock()
+
+	reply := &share.SubscribeReply{}
+	err := client.Call(ctx, share.PubSubServiceName, "Subscribe", &share.SubscribeArgs{Group: group, After: after}, reply)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if !subscribed {
+			delete(s.groups, group)
+		}
+		return err
+	}
+	if after == 0 && reply.LastID > s.groups[group] {
+		// earlier messages are not sent again after reconnecting
+		s.groups[group] = reply.LastID
+	}
+	return nil
+}
+
+// Unsubscribe unsubscribes from group.
+func (s *Subscriber) Unsubscribe(ctx context.Context, group string) error {
+	s.mu.Lock()
+	client := s.client
+	delete(s.groups, group)
+	s.mu.Unlock()
+
+	return client.Call(ctx, share.PubSubServiceName, "Unsubscribe", group, &struct{}{})
+}
+
+// Reconnect moves the Subscriber to client, the new client of a server whose connection is closed,
+// and subscribes client to the subscribed groups again. Messages published after the last ones received
+// are sent again if the server keeps them, see server.PubSubConfig.
+func (s *Subscriber) Reconnect(ctx context.Context, client *Client) error {
+	s.mu.Lock()
+	old := s.client
+	s.client = client
+	groups := make(map[string]uint64, len(s.groups))
+	for group, id := range s.groups {
+		groups[group] = id
+	}
+	s.mu.Unlock()
+
+	if old != client {
+		old.UnregisterServerMessageChan()
+		client.RegisterServerMessageChan(s.in)
+	}
+	for group, after := range groups {
+		args := &share.SubscribeArgs{Group: group, After: after}
+		if err := client.Call(ctx, share.PubSubServiceName, "Subscribe", args, &share.SubscribeReply{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops receiving messages. Groups are unsubscribed when the connection of the client is closed.
+func (s *Subscriber) Close() {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.client.UnregisterServerMessageChan()
+		s.mu.Unlock()
+		close(s.done)
+	})
+}
+
+func (s *Subscriber) receive() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case msg := <-s.in:
+			group := msg.Metadata[share.PubSubGroupKey]
+			id, _ := strconv.ParseUint(msg.Metadata[share.PubSubIDKey], 10, 64)
+			select {
+			case s.ch <- msg:
+			case <-s.done:
+				return
+			}
+			if group == "" {
+				continue
+			}
+
+			s.mu.Lock()
+			client := s.client
+			if last, ok := s.groups[group]; ok && id > last {
+				s.groups[group] = id
+			}
+			s.mu.Unlock()
+			client.Notify(context.Background(), share.PubSubServiceName, "Ack", &share.PubSubAck{Group: group, ID: id})
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors of calls whose deadlines expire on the client match one of them by errors.Is,
+// so callers can tell whether the server may have handled the request.
+var (
+	// ErrTimeoutBeforeSend matches timeouts of calls whose requests were not sent, so servers have not seen them.
+	ErrTimeoutBeforeSend = errors.New("rpcx: deadline exceeded before the request was sent")
+	// ErrTimeoutAwaitingResponse matches timeouts of calls whose requests were sent but whose responses did not arrive in time.
+	ErrTimeoutAwaitingResponse = errors.New("rpcx: deadline exceeded awaiting the response")
+)
+
+// TimeoutError is the error of a call whose deadline expires on the client.
+// It matches context.DeadlineExceeded by errors.Is as ctx.Err() does, and ErrTimeoutBeforeSend or ErrTimeoutAwaitingResponse by Sent.
+//
+// Deadlines which expire on servers are returned as CodedServiceErrors with the code DeadlineExceeded instead,
+// which match errors.ErrDeadlineExceeded of the errors package but not context.DeadlineExceeded,
+// and other errors of calls, such as ErrShutdown and errors of connections, are failures of transports.
+type TimeoutError struct {
+	// Sent reports whether the request had been written to the connection when the deadline expired.
+	Sent bool
+	// Written reports whether the request was written entirely before the deadline.
+	// It is false for requests whose writes are blocked until the deadline expires.
+	Written bool
+	// Elapsed is the time from the call being sent until the deadline expired.
+	Elapsed time.Duration
+	// BudgetExhausted reports whether the call was not sent because the budget left by the deadline propagated
+	// to the handler making the call was too little, see Option.MinHopBudget. It matches ErrBudgetExhausted.
+	BudgetExhausted bool
+	// Node is the address of the server, which is set by XClient.
+	Node string
+}
+
+func (e *TimeoutError) Error() string {
+	if e.BudgetExhausted {
+		return ErrBudgetExhausted.Error()
+	}
+	msg := ErrTimeoutBeforeSend.Error()
+	if e.Sent {
+		msg = ErrTimeoutAwaitingResponse.Error()
+	}
+	if e.Node != "" {
+		msg += " of " + e.Node
+	}
+	return fmt.Sprintf("%s after %v", msg, e.Elapsed)
+}
+
+// Is reports whether target is ErrTimeoutBeforeSend, ErrTimeoutAwaitingResponse or ErrBudgetExhausted matching the error.
+func (e *TimeoutError) Is(target error) bool {
+	switch target {
+	case ErrBudgetExhausted:
+		return e.BudgetExhausted
+	case ErrTimeoutBeforeSend:
+		return !e.Sent
+	case ErrTimeoutAwaitingResponse:
+		return e.Sent
+	}
+	return false
+}
+
+// Unwrap returns context.DeadlineExceeded.
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Timeout reports true, as net.Error does for timeouts.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// contextError returns the error of a call whose ctx is done, which started at start and was written at written,
+// or zero if it was not written: a TimeoutError for an expired deadline, or ctx.Err() for others.
+func contextError(ctx context.Context, start, written time.Time) error {
+	err := ctx.Err()
+	if err != context.DeadlineExceeded {
+		return err
+	}
+	e := &TimeoutError{Sent: !written.IsZero(), Elapsed: time.Since(start)}
+	if e.Sent {
+		deadline, ok := ctx.Deadline()
+		e.Written = !ok || !written.After(deadline)
+	}
+	return e
+}
+
+// withNode sets the address of the server of client to errors of calls which carry addresses.
+func withNode(err error, client RPCClient) error {
+	if err == nil {
+		return nil
+	}
+	switch e := err.(type) {
+	case *TimeoutError:
+		if e.Node == "" {
+			e.Node = client.RemoteAddr()
+		}
+	case *CodedServiceError:
+		if e.node == "" {
+			e.node = client.RemoteAddr()
+		}
+		return e
+	}
+	return err
+}
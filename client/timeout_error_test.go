@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+type deadlineService struct {
+	calls int32
+}
+
+// Wait waits args.A milliseconds. It returns the error of ctx if the deadline expires first, unless args.B is 1,
+// since deadlines of clients are propagated to servers.
+func (s *deadlineService) Wait(ctx context.Context, args *Args, reply *Reply) error {
+	atomic.AddInt32(&s.calls, 1)
+	done := ctx.Done()
+	if args.B == 1 {
+		done = nil
+	}
+	select {
+	case <-done:
+		return ctx.Err()
+	case <-time.After(time.Duration(args.A) * time.Millisecond):
+	}
+	reply.C = args.A
+	return nil
+}
+
+func startDeadlineServer(t *testing.T) (*deadlineService, string) {
+	svc := &deadlineService{}
+	s := server.NewServer()
+	s.RegisterName("Deadline", svc, "")
+	return svc, startServer(t, s)
+}
+
+func TestTimeoutErrors(t *testing.T) {
+	svc, addr := startDeadlineServer(t)
+	client := NewClient(DefaultOption)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// the deadline expires awaiting the response
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := client.Call(ctx, "Deadline", "Wait", &Args{A: 500, B: 1}, &Reply{})
+	var te *TimeoutError
+	if !errors.As(err, &te) || !te.Sent || !te.Written || te.Elapsed < 40*time.Millisecond {
+		t.Fatalf("expect a timeout awaiting the response but got %#v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrTimeoutAwaitingResponse) || errors.Is(err, ErrTimeoutBeforeSend) {
+		t.Fatalf("unexpected matches of %v", err)
+	}
+	if errors.Is(err, rerrors.ErrDeadlineExceeded) {
+		t.Fatalf("expect a client-side timeout but got %v", err)
+	}
+
+	// the deadline expires before the request is sent, so the server does not see it
+	calls := atomic.LoadInt32(&svc.calls)
+	err = client.Call(ctx, "Deadline", "Wait", &Args{A: 1}, &Reply{})
+	if !errors.As(err, &te) || te.Sent || te.Written || !errors.Is(err, ErrTimeoutBeforeSend) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect a timeout before sending but got %#v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&svc.calls) != calls {
+		t.Fatal("expect the request not to be sent")
+	}
+
+	// the deadline expires on the server, which returns its code
+	ctx = context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.ServerTimeout: "50"})
+	err = client.Call(ctx, "Deadline", "Wait", &Args{A: 500}, &Reply{})
+	var se *CodedServiceError
+	if !errors.As(err, &se) || !errors.Is(err, rerrors.ErrDeadlineExceeded) || rerrors.Code(se.Code()) != rerrors.DeadlineExceeded {
+		t.Fatalf("expect a deadline exceeded on the server but got %#v", err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &te) {
+		t.Fatalf("expect a server-side deadline but got %v", err)
+	}
+
+	// canceled contexts are not timeouts
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err = client.Call(ctx, "Deadline", "Wait", &Args{A: 1}, &Reply{}); err != context.Canceled {
+		t.Fatalf("expect Canceled but got %v", err)
+	}
+}
+
+func TestTimeoutErrorsResponseAtDeadline(t *testing.T) {
+	_, addr := startDeadlineServer(t)
+	client := NewClient(DefaultOption)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// responses arrive around the deadline, and calls get either their replies or timeouts
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		reply := &Reply{}
+		err := client.Call(ctx, "Deadline", "Wait", &Args{A: 4}, reply)
+		cancel()
+		switch {
+		case err == nil:
+			if reply.C != 4 {
+				t.Fatalf("expect the reply but got %d", reply.C)
+			}
+		case errors.Is(err, ErrTimeoutAwaitingResponse), errors.Is(err, rerrors.ErrDeadlineExceeded):
+		default:
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if s := client.Stats(); s.Pending != 0 {
+		t.Fatalf("expect no pending calls but got %d", s.Pending)
+	}
+}
+
+func TestXClientTimeoutErrorsNode(t *testing.T) {
+	_, addr := startDeadlineServer(t)
+	d, err := NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xclient := NewXClient("Deadline", Failtry, RandomSelect, d, DefaultOption)
+	defer xclient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = xclient.Call(ctx, "Wait", &Args{A: 500, B: 1}, &Reply{})
+	var te *TimeoutError
+	if !errors.As(err, &te) || te.Node != addr {
+		t.Fatalf("expect a timeout of %s but got %v", addr, err)
+	}
+
+	// service errors carry the node too
+	ctx = context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.ServerTimeout: "20"})
+	err = xclient.Call(ctx, "Wait", &Args{A: 500}, &Reply{})
+	var se *CodedServiceError
+	if !errors.As(err, &se) || se.Node() != addr || !errors.Is(err, rerrors.ErrDeadlineExceeded) {
+		t.Fatalf("expect a deadline exceeded on %s but got %v", addr, err)
+	}
+}
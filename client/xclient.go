@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"context"
 	"errors"
-	"fmt"
 	"io"
 	"net"
 	"net/url"
@@ -13,6 +12,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/juju/ratelimit"
@@ -58,9 +58,15 @@ type XClient interface {
 	Fork(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error
 	Inform(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) ([]Receipt, error)
 	SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error)
+	SendRawMessage(ctx context.Context, r *protocol.Message) (*protocol.Message, error)
 	SendFile(ctx context.Context, fileName string, rateInBytesPerSecond int64, meta map[string]string) error
 	DownloadFile(ctx context.Context, requestFileName string, saveTo io.Writer, meta map[string]string) error
+	SendFileResumable(ctx context.Context, fileName string, rateInBytesPerSecond int64, meta map[string]string) error
+	DownloadFileResumable(ctx context.Context, requestFileName string, saveTo string, meta map[string]string) error
 	Stream(ctx context.Context, meta map[string]string) (net.Conn, error)
+	Stats() map[string]ClientStats
+	OnDiscoveryError(fn func(err DiscoveryError))
+	UpdateOption(fn func(o *Option))
 	Close() error
 }
 
@@ -100,12 +106,17 @@ type xClient struct {
 	cachedClient map[string]RPCClient
 	breakers     sync.Map
 	servicePath  string
-	option       Option
+	option       atomic.Value // *Option, replaced by UpdateOption
 
 	mu        sync.RWMutex
 	servers   map[string]string
 	discovery ServiceDiscovery
 	selector  Selector
+	// counters of closed clients of nodes, so stats of nodes are cumulative across reconnects
+	retiredStats map[string]*ClientStats
+	// when cached clients of nodes are selected last, and the channel stopping the reaper of idle ones
+	lastUsed   map[string]time.Time
+	reaperDone chan struct{}
 
 	slGroup singleflight.Group
 
@@ -113,12 +124,22 @@ type xClient struct {
 
 	// auth is a string for Authentication, for example, "Bearer mF_9.B5f-4.1JqM"
 	auth string
+	// tokens of Option.AuthFunc, which replace auth
+	tokens *authTokens
 
 	Plugins PluginContainer
 
 	ch chan []*KVPair
 
+	// callbacks of OnDiscoveryError, and whether the staleness of the discovery is reported to plugins
+	discoveryMu            sync.Mutex
+	discoveryErrorHandlers []func(err DiscoveryError)
+	discoveryStale         int32
+
 	serverMessageChan chan<- *protocol.Message
+
+	// set after UpdateOption changes SerializeType, which is set to calls of clients created before
+	serializeTypeUpdated int32
 }
 
 // NewXClient creates a XClient that supports service discovery and service governance.
@@ -129,7 +150,10 @@ func NewXClient(servicePath string, failMode FailMode, selectMode SelectMode, di
 		discovery:    discovery,
 		servicePath:  servicePath,
 		cachedClient: make(map[string]RPCClient),
-		option:       option,
+	}
+	client.option.Store(&option)
+	if option.AuthFunc != nil {
+		client.tokens = newAuthTokens(option.AuthFunc)
 	}
 
 	pairs := discovery.GetServices()
@@ -140,7 +164,7 @@ func NewXClient(servicePath string, failMode FailMode, selectMode SelectMode, di
 	for _, p := range pairs {
 		servers[p.Key] = p.Value
 	}
-	filterByStateAndGroup(client.option.Group, servers)
+	filterByStateAndGroup(option.Group, servers)
 
 	client.servers = servers
 	if selectMode != Closest && selectMode != SelectByUser {
@@ -148,12 +172,16 @@ func NewXClient(servicePath string, failMode FailMode, selectMode SelectMode, di
 	}
 
 	client.Plugins = &pluginContainer{}
+	if n, ok := discovery.(DiscoveryErrorNotifier); ok {
+		n.OnDiscoveryError(client.discoveryError)
+	}
 
 	ch := client.discovery.WatchService()
 	if ch != nil {
 		client.ch = ch
 		go client.watch(ch)
 	}
+	client.startReaper()
 
 	return client
 }
@@ -166,9 +194,12 @@ func NewBidirectionalXClient(servicePath string, failMode FailMode, selectMode S
 		discovery:         discovery,
 		servicePath:       servicePath,
 		cachedClient:      make(map[string]RPCClient),
-		option:            option,
 		serverMessageChan: serverMessageChan,
 	}
+	client.option.Store(&option)
+	if option.AuthFunc != nil {
+		client.tokens = newAuthTokens(option.AuthFunc)
+	}
 
 	pairs := discovery.GetServices()
 	sort.Slice(pairs, func(i, j int) bool {
@@ -178,19 +209,23 @@ func NewBidirectionalXClient(servicePath string, failMode FailMode, selectMode S
 	for _, p := range pairs {
 		servers[p.Key] = p.Value
 	}
-	filterByStateAndGroup(client.option.Group, servers)
+	filterByStateAndGroup(option.Group, servers)
 	client.servers = servers
 	if selectMode != Closest && selectMode != SelectByUser {
 		client.selector = newSelector(selectMode, servers)
 	}
 
 	client.Plugins = &pluginContainer{}
+	if n, ok := discovery.(DiscoveryErrorNotifier); ok {
+		n.OnDiscoveryError(client.discoveryError)
+	}
 
 	ch := client.discovery.WatchService()
 	if ch != nil {
 		client.ch = ch
 		go client.watch(ch)
 	}
+	client.startReaper()
 
 	return client
 }
@@ -219,32 +254,37 @@ func (c *xClient) Auth(auth string) {
 // watch changes of service and update cached clients.
 func (c *xClient) watch(ch chan []*KVPair) {
 	for pairs := range ch {
-		sort.Slice(pairs, func(i, j int) bool {
-			return strings.Compare(pairs[i].Key, pairs[j].Key) <= 0
-		})
-		servers := make(map[string]string, len(pairs))
-		for _, p := range pairs {
-			servers[p.Key] = p.Value
-		}
 		c.mu.Lock()
-		filterByStateAndGroup(c.option.Group, servers)
-		c.servers = servers
+		c.updateServers(pairs)
+		c.mu.Unlock()
+		c.discoveryUpdated()
+	}
+}
 
-		if c.selector != nil {
-			c.selector.UpdateServer(servers)
-		}
+// updateServers sets pairs of the discovery to servers filtered by states and Option.Group. c.mu is held.
+func (c *xClient) updateServers(pairs []*KVPair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		return strings.Compare(pairs[i].Key, pairs[j].Key) <= 0
+	})
+	servers := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		servers[p.Key] = p.Value
+	}
+	filterByStateAndGroup(c.getOption().Group, servers)
+	c.servers = servers
 
-		c.mu.Unlock()
+	if c.selector != nil {
+		c.selector.UpdateServer(servers)
 	}
 }
 
 func filterByStateAndGroup(group string, servers map[string]string) {
 	for k, v := range servers {
 		if values, err := url.ParseQuery(v); err == nil {
-			if state := values.Get("state"); state == "inactive" {
+			if state := values.Get(share.MetaState); state == "inactive" {
 				delete(servers, k)
 			}
-			if group != "" && group != values.Get("group") {
+			if group != "" && group != values.Get(share.MetaGroup) {
 				delete(servers, k)
 			}
 		}
@@ -255,15 +295,32 @@ func filterByStateAndGroup(group string, servers map[string]string) {
 func (c *xClient) selectClient(ctx context.Context, servicePath, serviceMethod string, args interface{}) (string, RPCClient, error) {
 	c.mu.Lock()
 	fn := c.selector.Select
+	trace := selectionTraceOf(ctx)
+	var chosen string
+	var explanation *SelectionExplanation
+	if es, ok := c.selector.(ExplainableSelector); ok && trace != nil {
+		fn = func(ctx context.Context, servicePath, serviceMethod string, args interface{}) string {
+			k, e := es.SelectExplained(ctx, servicePath, serviceMethod, args)
+			chosen, explanation = k, &e
+			return k
+		}
+	}
 	if c.Plugins != nil {
 		fn = c.Plugins.DoWrapSelect(fn)
 	}
 	k := fn(ctx, servicePath, serviceMethod, args)
+	candidates := len(c.servers)
 	c.mu.Unlock()
+	if trace != nil {
+		trace.selected(candidates, k, chosen, explanation)
+	}
 	if k == "" {
 		return "", nil, ErrXClientNoServer
 	}
 	client, err := c.getCachedClient(k, servicePath, serviceMethod, args)
+	if err != nil && trace != nil {
+		trace.failed(err)
+	}
 	return k, client, err
 }
 
@@ -278,7 +335,7 @@ func (c *xClient) getCachedClient(k string, servicePath, serviceMethod string, a
 	}()
 
 	if c.isShutdown {
-		return nil, errors.New("this xclient is closed")
+		return nil, markError(errors.New("this xclient is closed"), ErrXClientShutdown)
 	}
 
 	// if this client is broken
@@ -302,9 +359,12 @@ func (c *xClient) getCachedClient(k string, servicePath, serviceMethod string, a
 
 	if client == nil || client.IsShutdown() {
 		c.mu.Lock()
-		generatedClient, err, _ := c.slGroup.Do(k, func() (interface{}, error) {
+		generatedClient, err, shared := c.slGroup.Do(k, func() (interface{}, error) {
 			return c.generateClient(k, servicePath, serviceMethod)
 		})
+		if !shared {
+			c.reconnected(k, servicePath, err)
+		}
 		c.mu.Unlock()
 
 		c.slGroup.Forget(k)
@@ -334,7 +394,11 @@ func (c *xClient) setCachedClient(client RPCClient, k, servicePath, serviceMetho
 		return
 	}
 
+	if retired := c.retiredStats[k]; retired != nil {
+		retired.Reconnects++
+	}
 	c.cachedClient[k] = client
+	c.touch(k)
 }
 
 func (c *xClient) findCachedClient(k, servicePath, serviceMethod string) RPCClient {
@@ -343,7 +407,11 @@ func (c *xClient) findCachedClient(k, servicePath, serviceMethod string) RPCClie
 		return builder.FindCachedClient(k, servicePath, serviceMethod)
 	}
 
-	return c.cachedClient[k]
+	client := c.cachedClient[k]
+	if client != nil {
+		c.touch(k)
+	}
+	return client
 }
 
 func (c *xClient) deleteCachedClient(client RPCClient, k, servicePath, serviceMethod string) {
@@ -355,9 +423,72 @@ func (c *xClient) deleteCachedClient(client RPCClient, k, servicePath, serviceMe
 	}
 
 	delete(c.cachedClient, k)
+	delete(c.lastUsed, k)
 	if client != nil {
 		client.Close()
+		c.retireStats(k, client)
+	}
+}
+
+// retireStats adds counters of client, a closed client of the node k, to the retired stats of the node.
+func (c *xClient) retireStats(k string, client RPCClient) {
+	sc, ok := client.(interface{ Stats() ClientStats })
+	if !ok {
+		return
+	}
+	stats := sc.Stats()
+	stats.Pending = 0
+	if c.retiredStats == nil {
+		c.retiredStats = make(map[string]*ClientStats)
+	}
+	if retired := c.retiredStats[k]; retired != nil {
+		stats.add(*retired)
+	}
+	stats.State, stats.Uptime = ClientNotConnected, 0
+	c.retiredStats[k] = &stats
+}
+
+// reconnected counts a connect of the node k which has been connected before, and reports it to plugins.
+// err is the error of the connect, or nil if the node is reconnected.
+func (c *xClient) reconnected(k, servicePath string, err error) {
+	retired := c.retiredStats[k]
+	if retired == nil {
+		return
+	}
+	retired.ReconnectAttempts++
+	if c.Plugins != nil {
+		c.Plugins.DoReconnect(servicePath, k, err)
+	}
+}
+
+// Stats returns stats of clients of nodes by their keys, such as "tcp@127.0.0.1:8972".
+// Counters of a node are cumulative across reconnects, and the state of a node without a client is ClientReconnecting,
+// ClientIdle if its client is closed by Option.CachedClientIdleTimeout, or ClientClosed after Close. Clients of networks of CacheClientBuilder are not included.
+func (c *xClient) Stats() map[string]ClientStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := make(map[string]ClientStats, len(c.cachedClient)+len(c.retiredStats))
+	for k, retired := range c.retiredStats {
+		s := *retired
+		if s.State != ClientIdle {
+			s.State = ClientReconnecting
+		}
+		if c.isShutdown {
+			s.State = ClientClosed
+		}
+		stats[k] = s
 	}
+	for k, client := range c.cachedClient {
+		sc, ok := client.(interface{ Stats() ClientStats })
+		if !ok {
+			continue
+		}
+		s := sc.Stats()
+		s.add(stats[k])
+		stats[k] = s
+	}
+	return stats
 }
 
 func (c *xClient) removeClient(k, servicePath, serviceMethod string, client RPCClient) {
@@ -381,13 +512,19 @@ func (c *xClient) generateClient(k, servicePath, serviceMethod string) (client R
 	}
 
 	client = &Client{
-		option:  c.option,
+		option:  *c.getOption(),
 		Plugins: c.Plugins,
+		// reports are fed to the selector of the time, which may be set by SetSelector
+		loadReported: func(report protocol.LoadReport) {
+			if lr, ok := c.selector.(LoadReceiver); ok {
+				lr.UpdateLoad(k, report)
+			}
+		},
 	}
 
 	var breaker interface{}
-	if c.option.GenBreaker != nil {
-		breaker, _ = c.breakers.LoadOrStore(k, c.option.GenBreaker())
+	if c.getOption().GenBreaker != nil {
+		breaker, _ = c.breakers.LoadOrStore(k, c.getOption().GenBreaker())
 	}
 
 	err = client.Connect(network, addr)
@@ -413,10 +550,13 @@ func (c *xClient) getCachedClientWithoutLock(k, servicePath, serviceMethod strin
 	// double check
 	client = c.findCachedClient(k, servicePath, serviceMethod)
 	if client == nil || client.IsShutdown() {
-		generatedClient, err, _ := c.slGroup.Do(k, func() (interface{}, error) {
+		generatedClient, err, shared := c.slGroup.Do(k, func() (interface{}, error) {
 			return c.generateClient(k, servicePath, serviceMethod)
 		})
 		c.slGroup.Forget(k)
+		if !shared {
+			c.reconnected(k, servicePath, err)
+		}
 		if err != nil {
 			return nil, needCallPlugin, err
 		}
@@ -434,7 +574,10 @@ func (c *xClient) getCachedClientWithoutLock(k, servicePath, serviceMethod strin
 	return client, needCallPlugin, nil
 }
 
+// splitNetworkAndAddress splits the key of a server into its network and address,
+// without the registration ID of share.RegistrationKey so that all registrations of a server are served by it.
 func splitNetworkAndAddress(server string) (string, string) {
+	server, _ = share.SplitRegistrationKey(server)
 	ss := strings.SplitN(server, "@", 2)
 	if len(ss) == 1 {
 		return "tcp", server
@@ -443,20 +586,6 @@ func splitNetworkAndAddress(server string) (string, string) {
 	return ss[0], ss[1]
 }
 
-func setServerTimeout(ctx context.Context) context.Context {
-	if deadline, ok := ctx.Deadline(); ok {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.ServerTimeout] = fmt.Sprintf("%d", time.Until(deadline).Milliseconds())
-	}
-
-	return ctx
-}
-
 // Go invokes the function asynchronously. It returns the Call structure representing the invocation. The done channel will signal when the call is complete by returning the same Call object. If done is nil, Go will allocate a new channel. If non-nil, done must be buffered or Go will deliberately crash.
 // It does not use FailMode.
 func (c *xClient) Go(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, done chan *Call) (*Call, error) {
@@ -464,18 +593,11 @@ func (c *xClient) Go(ctx context.Context, serviceMethod string, args interface{}
 		return nil, ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, err := c.setAuth(ctx, c.servicePath, serviceMethod)
+	if err != nil {
+		return nil, err
 	}
 
-	ctx = setServerTimeout(ctx)
-
 	if share.Trace {
 		log.Debugf("select a client for %s.%s, args: %+v in case of xclient Go", c.servicePath, serviceMethod, args)
 	}
@@ -486,32 +608,54 @@ func (c *xClient) Go(ctx context.Context, serviceMethod string, args interface{}
 	if share.Trace {
 		log.Debugf("selected a client %s for %s.%s, args: %+v in case of xclient Go", client.RemoteAddr(), c.servicePath, serviceMethod, args)
 	}
-	return client.Go(ctx, c.servicePath, serviceMethod, args, reply, done), nil
+	return client.Go(c.withSerializeType(ctx), c.servicePath, serviceMethod, args, reply, done), nil
 }
 
 // Call invokes the named function, waits for it to complete, and returns its error status.
 // It handles errors base on FailMode.
 func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	trace := selectionTraceOf(ctx)
+	if trace == nil && c.getOption().SlowCallThreshold <= 0 {
+		return c.callAuth(ctx, serviceMethod, args, reply)
+	}
+
+	start := time.Now()
+	err := c.callAuth(ctx, serviceMethod, args, reply)
+	elapsed := time.Since(start)
+	slow := c.getOption().SlowCallThreshold > 0 && elapsed >= c.getOption().SlowCallThreshold
+	if !slow && (err == nil || trace == nil) {
+		return err
+	}
+
+	keyvals := []interface{}{"service", c.servicePath, "method", serviceMethod, "duration", elapsed}
+	if err != nil {
+		keyvals = append(keyvals, "err", err)
+	}
+	if trace != nil {
+		keyvals = append(keyvals, "selection", trace.String())
+	}
+	if slow {
+		logger.Warn(ctx, "slow call", keyvals...)
+	} else {
+		logger.Warn(ctx, "call failed", keyvals...)
+	}
+	return err
+}
+
+func (c *xClient) call(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
 	if c.isShutdown {
 		return ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, err := c.setAuth(ctx, c.servicePath, serviceMethod)
+	if err != nil {
+		return err
 	}
-	ctx = setServerTimeout(ctx)
 
 	if share.Trace {
 		log.Debugf("select a client for %s.%s, failMode: %v, args: %+v in case of xclient Call", c.servicePath, serviceMethod, c.failMode, args)
 	}
 
-	var err error
 	k, client, err := c.selectClient(ctx, c.servicePath, serviceMethod, args)
 	if err != nil {
 		if c.failMode == Failfast || contextCanceled(err) {
@@ -530,19 +674,22 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 	var e error
 	switch c.failMode {
 	case Failtry:
-		retries := c.option.Retries
+		retries := c.getOption().Retries
 		for retries >= 0 {
 			retries--
 
 			if client != nil {
 				err = c.wrapCall(ctx, client, serviceMethod, args, reply)
+				c.recordBreaker(k, err)
 				if err == nil {
 					return nil
 				}
-				if contextCanceled(err) {
+				// forged responses are not retried, or men in the middle could make storms of retries,
+				// and calls rejected by the rate limiter would be rejected again
+				if contextCanceled(err) || errors.Is(err, ErrInvalidSignature) || errors.Is(err, ErrClientRateLimited) {
 					return err
 				}
-				if _, ok := err.(ServiceError); ok {
+				if isServiceError(err) && !isFailure(err) {
 					return err
 				}
 			}
@@ -557,19 +704,22 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 		}
 		return err
 	case Failover:
-		retries := c.option.Retries
+		retries := c.getOption().Retries
 		for retries >= 0 {
 			retries--
 
 			if client != nil {
 				err = c.wrapCall(ctx, client, serviceMethod, args, reply)
+				c.recordBreaker(k, err)
 				if err == nil {
 					return nil
 				}
-				if contextCanceled(err) {
+				// forged responses are not retried, or men in the middle could make storms of retries,
+				// and calls rejected by the rate limiter would be rejected again
+				if contextCanceled(err) || errors.Is(err, ErrInvalidSignature) || errors.Is(err, ErrClientRateLimited) {
 					return err
 				}
-				if _, ok := err.(ServiceError); ok {
+				if isServiceError(err) && !isFailure(err) {
 					return err
 				}
 			}
@@ -598,12 +748,14 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 			reply2 = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
 		}
 
+		start := time.Now()
 		_, err1 := c.Go(ctx, serviceMethod, args, reply1, call1)
+		written := time.Now()
 
-		t := time.NewTimer(c.option.BackupLatency)
+		t := time.NewTimer(c.getOption().BackupLatency)
 		select {
 		case <-ctx.Done(): // cancel by context
-			err = ctx.Err()
+			err = contextError(ctx, start, written)
 			return err
 		case call := <-call1:
 			err = call.Error
@@ -625,7 +777,7 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 
 		select {
 		case <-ctx.Done(): // cancel by context
-			err = ctx.Err()
+			err = contextError(ctx, start, written)
 		case call := <-call1:
 			err = call.Error
 			if err == nil && reply != nil && reply1 != nil {
@@ -641,6 +793,7 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 		return err
 	default: // Failfast
 		err = c.wrapCall(ctx, client, serviceMethod, args, reply)
+		c.recordBreaker(k, err)
 		if err != nil {
 			if uncoverError(err) {
 				c.removeClient(k, c.servicePath, serviceMethod, client)
@@ -652,15 +805,20 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 }
 
 func uncoverError(err error) bool {
-	if _, ok := err.(ServiceError); ok {
+	if isServiceError(err) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
 		return false
 	}
 
-	if err == context.DeadlineExceeded {
+	if errors.Is(err, context.Canceled) {
 		return false
 	}
 
-	if err == context.Canceled {
+	// the connection is still usable
+	if errors.Is(err, ErrInvalidSignature) || errors.Is(err, ErrClientRateLimited) || errors.Is(err, ErrSendQueueFull) {
 		return false
 	}
 
@@ -668,11 +826,11 @@ func uncoverError(err error) bool {
 }
 
 func contextCanceled(err error) bool {
-	if err == context.DeadlineExceeded {
+	if errors.Is(err, context.DeadlineExceeded) {
 		return true
 	}
 
-	if err == context.Canceled {
+	if errors.Is(err, context.Canceled) {
 		return true
 	}
 
@@ -680,37 +838,86 @@ func contextCanceled(err error) bool {
 }
 
 func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error) {
-	if c.isShutdown {
-		return nil, nil, ErrXClientShutdown
-	}
+	result, err := c.sendRawWithAuth(ctx, r, func(ctx context.Context, client RPCClient) (rawResult, error) {
+		m, payload, err := client.SendRaw(ctx, r)
+		return rawResult{meta: m, payload: payload}, err
+	})
+	return result.meta, result.payload, err
+}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
+// SendRawMessage sends r, a raw request, to the selected server like SendRaw, with failover of the fail mode,
+// and returns the raw response of the server, see Client.SendRawMessage.
+// Clients of servers which don't send raw messages, such as gRPC bridges, return responses
+// of the metadata and payload of their SendRaw, with the serialize type of r.
+func (c *xClient) SendRawMessage(ctx context.Context, r *protocol.Message) (*protocol.Message, error) {
+	result, err := c.sendRawWithAuth(ctx, r, func(ctx context.Context, client RPCClient) (rawResult, error) {
+		if rc, ok := client.(rawMessageSender); ok {
+			res, err := rc.SendRawMessage(ctx, r)
+			return rawResult{res: res}, err
 		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+		meta, payload, err := client.SendRaw(ctx, r)
+		res := protocol.NewMessage()
+		res.SetMessageType(protocol.Response)
+		res.SetSeq(r.Seq())
+		res.SetSerializeType(r.SerializeType())
+		res.ServicePath, res.ServiceMethod = r.ServicePath, r.ServiceMethod
+		res.Metadata, res.Payload = meta, payload
+		if err != nil {
+			res.SetMessageStatusType(protocol.Error)
+		}
+		return rawResult{res: res}, err
+	})
+	return result.res, err
+}
+
+// rawMessageSender is implemented by RPCClients which send raw messages, such as Client.
+type rawMessageSender interface {
+	SendRawMessage(ctx context.Context, r *protocol.Message) (*protocol.Message, error)
+}
+
+// rawResult is the result of SendRaw or SendRawMessage.
+type rawResult struct {
+	meta    map[string]string
+	payload []byte
+	res     *protocol.Message
+}
+
+// sendRawWithAuth sends r by send to clients selected for r, and sends it again if the auth token is refreshed.
+func (c *xClient) sendRawWithAuth(ctx context.Context, r *protocol.Message,
+	send func(ctx context.Context, client RPCClient) (rawResult, error)) (rawResult, error) {
+	start := time.Now()
+	result, err := c.sendRaw(ctx, r, send)
+	if c.retryAuth(ctx, r.ServicePath, r.ServiceMethod, start, err) {
+		result, err = c.sendRaw(ctx, r, send)
+	}
+	return result, err
+}
+
+func (c *xClient) sendRaw(ctx context.Context, r *protocol.Message,
+	send func(ctx context.Context, client RPCClient) (rawResult, error)) (rawResult, error) {
+	if c.isShutdown {
+		return rawResult{}, ErrXClientShutdown
 	}
 
-	ctx = setServerTimeout(ctx)
+	ctx, err := c.setAuth(ctx, r.ServicePath, r.ServiceMethod)
+	if err != nil {
+		return rawResult{}, err
+	}
 
 	if share.Trace {
 		log.Debugf("select a client for %s.%s, failMode: %v, args: %+v in case of xclient SendRaw", r.ServicePath, r.ServiceMethod, c.failMode, r.Payload)
 	}
 
-	var err error
 	k, client, err := c.selectClient(ctx, r.ServicePath, r.ServiceMethod, r.Payload)
 	if err != nil {
 		if c.failMode == Failfast {
-			return nil, nil, err
+			return rawResult{}, err
 		}
 		if contextCanceled(err) {
-			return nil, nil, err
+			return rawResult{}, err
 		}
-		if _, ok := err.(ServiceError); ok {
-			return nil, nil, err
+		if isServiceError(err) {
+			return rawResult{}, err
 		}
 	}
 
@@ -721,19 +928,20 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 	var e error
 	switch c.failMode {
 	case Failtry:
-		retries := c.option.Retries
+		retries := c.getOption().Retries
 		for retries >= 0 {
 			retries--
 			if client != nil {
-				m, payload, err := c.wrapSendRaw(ctx, client, r)
+				result, err := c.wrapSendRaw(ctx, client, r, send)
+				c.recordBreaker(k, err)
 				if err == nil {
-					return m, payload, nil
+					return result, nil
 				}
-				if contextCanceled(err) {
-					return nil, nil, err
+				if contextCanceled(err) || errors.Is(err, ErrInvalidSignature) || errors.Is(err, ErrClientRateLimited) {
+					return rawResult{}, err
 				}
-				if _, ok := err.(ServiceError); ok {
-					return nil, nil, err
+				if isServiceError(err) && !isFailure(err) {
+					return result, err // the response of the error, such as for proxies to forward
 				}
 			}
 
@@ -746,21 +954,22 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 		if err == nil {
 			err = e
 		}
-		return nil, nil, err
+		return rawResult{}, err
 	case Failover:
-		retries := c.option.Retries
+		retries := c.getOption().Retries
 		for retries >= 0 {
 			retries--
 			if client != nil {
-				m, payload, err := c.wrapSendRaw(ctx, client, r)
+				result, err := c.wrapSendRaw(ctx, client, r, send)
+				c.recordBreaker(k, err)
 				if err == nil {
-					return m, payload, nil
+					return result, nil
 				}
-				if contextCanceled(err) {
-					return nil, nil, err
+				if contextCanceled(err) || errors.Is(err, ErrInvalidSignature) || errors.Is(err, ErrClientRateLimited) {
+					return rawResult{}, err
 				}
-				if _, ok := err.(ServiceError); ok {
-					return nil, nil, err
+				if isServiceError(err) && !isFailure(err) {
+					return result, err // the response of the error, such as for proxies to forward
 				}
 			}
 
@@ -774,21 +983,25 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 		if err == nil {
 			err = e
 		}
-		return nil, nil, err
+		return rawResult{}, err
 
 	default: // Failfast
-		m, payload, err := c.wrapSendRaw(ctx, client, r)
+		result, err := c.wrapSendRaw(ctx, client, r, send)
+		c.recordBreaker(k, err)
 		if err != nil {
 			if uncoverError(err) {
 				c.removeClient(k, r.ServicePath, r.ServiceMethod, client)
 			}
 		}
 
-		return m, payload, nil
+		return result, err
 	}
 }
 
-func (c *xClient) wrapCall(ctx context.Context, client RPCClient, serviceMethod string, args interface{}, reply interface{}) error {
+func (c *xClient) wrapCall(ctx context.Context, client RPCClient, serviceMethod string, args interface{}, reply interface{}) (err error) {
+	if trace := selectionTraceOf(ctx); trace != nil {
+		defer func() { trace.called(err) }()
+	}
 	if client == nil {
 		return ErrServerUnavailable
 	}
@@ -797,9 +1010,13 @@ func (c *xClient) wrapCall(ctx context.Context, client RPCClient, serviceMethod
 		log.Debugf("call a client for %s.%s, args: %+v in case of xclient wrapCall", c.servicePath, serviceMethod, args)
 	}
 
-	ctx = share.NewContext(ctx)
+	pool := c.getOption().PoolContexts
+	sctx := getContext(c.withSerializeType(ctx), pool)
+	defer freeContext(sctx, pool)
+	ctx = sctx
 	c.Plugins.DoPreCall(ctx, c.servicePath, serviceMethod, args)
-	err := client.Call(ctx, c.servicePath, serviceMethod, args, reply)
+	err = withNode(client.Call(ctx, c.servicePath, serviceMethod, args, reply), client)
+	c.closeUnauthenticated(client, err)
 	c.Plugins.DoPostCall(ctx, c.servicePath, serviceMethod, args, reply, err)
 
 	if share.Trace {
@@ -809,26 +1026,49 @@ func (c *xClient) wrapCall(ctx context.Context, client RPCClient, serviceMethod
 	return err
 }
 
+// recordBreaker records the result of a call in the circuit breaker of the server k.
+// Errors returned by the server only count as failures if they are Unavailable.
+func (c *xClient) recordBreaker(k string, err error) {
+	breaker, ok := c.breakers.Load(k)
+	if !ok {
+		return
+	}
+	if isFailure(err) {
+		breaker.(Breaker).Fail()
+	} else if !contextCanceled(err) && !errors.Is(err, ErrClientRateLimited) {
+		breaker.(Breaker).Success()
+	}
+}
+
 // wrapSendRaw wrap SendRaw to support client plugins
-func (c *xClient) wrapSendRaw(ctx context.Context, client RPCClient, r *protocol.Message) (map[string]string, []byte, error) {
+func (c *xClient) wrapSendRaw(ctx context.Context, client RPCClient, r *protocol.Message,
+	send func(ctx context.Context, client RPCClient) (rawResult, error)) (result rawResult, err error) {
+	if trace := selectionTraceOf(ctx); trace != nil {
+		defer func() { trace.called(err) }()
+	}
 	if client == nil {
-		return nil, nil, ErrServerUnavailable
+		return rawResult{}, ErrServerUnavailable
 	}
 
 	if share.Trace {
 		log.Debugf("call a client for %s.%s, args: %+v in case of xclient wrapSendRaw", c.servicePath, r.ServiceMethod, r.Payload)
 	}
 
-	ctx = share.NewContext(ctx)
+	pool := c.getOption().PoolContexts
+	sctx := getContext(ctx, pool)
+	defer freeContext(sctx, pool)
+	ctx = sctx
 	c.Plugins.DoPreCall(ctx, c.servicePath, r.ServiceMethod, r.Payload)
-	m, payload, err := client.SendRaw(ctx, r)
+	result, err = send(ctx, client)
+	err = withNode(err, client)
+	c.closeUnauthenticated(client, err)
 	c.Plugins.DoPostCall(ctx, c.servicePath, r.ServiceMethod, r.Payload, nil, err)
 
 	if share.Trace {
 		log.Debugf("called a client for %s.%s, args: %+v, err: %v in case of xclient wrapSendRaw", c.servicePath, r.ServiceMethod, r.Payload, err)
 	}
 
-	return m, payload, err
+	return result, err
 }
 
 // Broadcast sends requests to all servers and Success only when all servers return OK.
@@ -839,17 +1079,11 @@ func (c *xClient) Broadcast(ctx context.Context, serviceMethod string, args inte
 		return ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, authErr := c.setAuth(ctx, c.servicePath, serviceMethod)
+	if authErr != nil {
+		return authErr
 	}
 
-	ctx = setServerTimeout(ctx)
 	callPlugins := make([]RPCClient, 0, len(c.servers))
 	clients := make(map[string]RPCClient)
 	c.mu.Lock()
@@ -878,6 +1112,8 @@ func (c *xClient) Broadcast(ctx context.Context, serviceMethod string, args inte
 	err := &ex.MultiError{}
 	l := len(clients)
 	done := make(chan bool, l)
+	// calls may be done after it returns, so they don't keep a pooled ctx
+	ctx = share.Detach(ctx)
 	for k, client := range clients {
 		k := k
 		client := client
@@ -888,13 +1124,14 @@ func (c *xClient) Broadcast(ctx context.Context, serviceMethod string, args inte
 			}
 
 			e := c.wrapCall(ctx, client, serviceMethod, args, clonedReply)
-			done <- (e == nil)
 			if e != nil {
-				if uncoverError(e) {
-					c.removeClient(k, c.servicePath, serviceMethod, client)
-				}
+				// before done, so the error is returned
 				err.Append(e)
 			}
+			done <- (e == nil)
+			if e != nil && uncoverError(e) {
+				c.removeClient(k, c.servicePath, serviceMethod, client)
+			}
 
 			if e == nil && reply != nil && clonedReply != nil {
 				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
@@ -912,7 +1149,7 @@ check:
 				break check
 			}
 		case <-timeout.C:
-			err.Append(errors.New(("timeout")))
+			err.Append(ErrBroadcastTimeout)
 			break check
 		}
 	}
@@ -931,17 +1168,11 @@ func (c *xClient) Fork(ctx context.Context, serviceMethod string, args interface
 		return ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, authErr := c.setAuth(ctx, c.servicePath, serviceMethod)
+	if authErr != nil {
+		return authErr
 	}
 
-	ctx = setServerTimeout(ctx)
 	callPlugins := make([]RPCClient, 0, len(c.servers))
 	clients := make(map[string]RPCClient)
 	c.mu.Lock()
@@ -970,6 +1201,8 @@ func (c *xClient) Fork(ctx context.Context, serviceMethod string, args interface
 	err := &ex.MultiError{}
 	l := len(clients)
 	done := make(chan bool, l)
+	// calls may be done after it returns, so they don't keep a pooled ctx
+	ctx = share.Detach(ctx)
 	for k, client := range clients {
 		k := k
 		client := client
@@ -983,13 +1216,14 @@ func (c *xClient) Fork(ctx context.Context, serviceMethod string, args interface
 			if e == nil && reply != nil && clonedReply != nil {
 				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
 			}
-			done <- (e == nil)
 			if e != nil {
-				if uncoverError(e) {
-					c.removeClient(k, c.servicePath, serviceMethod, client)
-				}
+				// before done, so the error is returned
 				err.Append(e)
 			}
+			done <- (e == nil)
+			if e != nil && uncoverError(e) {
+				c.removeClient(k, c.servicePath, serviceMethod, client)
+			}
 		}()
 	}
 
@@ -1007,7 +1241,7 @@ check:
 			}
 
 		case <-timeout.C:
-			err.Append(errors.New(("timeout")))
+			err.Append(ErrBroadcastTimeout)
 			break check
 		}
 	}
@@ -1028,17 +1262,11 @@ func (c *xClient) Inform(ctx context.Context, serviceMethod string, args interfa
 		return nil, ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, authErr := c.setAuth(ctx, c.servicePath, serviceMethod)
+	if authErr != nil {
+		return nil, authErr
 	}
 
-	ctx = setServerTimeout(ctx)
 	callPlugins := make([]RPCClient, 0, len(c.servers))
 	clients := make(map[string]RPCClient)
 	c.mu.Lock()
@@ -1070,6 +1298,8 @@ func (c *xClient) Inform(ctx context.Context, serviceMethod string, args interfa
 	err := &ex.MultiError{}
 	l := len(clients)
 	done := make(chan bool, l)
+	// calls may be done after it returns, so they don't keep a pooled ctx
+	ctx = share.Detach(ctx)
 	for k, client := range clients {
 		k := k
 		client := client
@@ -1080,13 +1310,14 @@ func (c *xClient) Inform(ctx context.Context, serviceMethod string, args interfa
 			}
 
 			e := c.wrapCall(ctx, client, serviceMethod, args, clonedReply)
-			done <- (e == nil)
 			if e != nil {
-				if uncoverError(e) {
-					c.removeClient(k, c.servicePath, serviceMethod, client)
-				}
+				// before done, so the error is returned
 				err.Append(e)
 			}
+			done <- (e == nil)
+			if e != nil && uncoverError(e) {
+				c.removeClient(k, c.servicePath, serviceMethod, client)
+			}
 			if e == nil && reply != nil && clonedReply != nil {
 				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
 			}
@@ -1117,7 +1348,7 @@ check:
 				break check
 			}
 		case <-timeout.C:
-			err.Append(errors.New(("timeout")))
+			err.Append(ErrBroadcastTimeout)
 			break check
 		}
 	}
@@ -1151,15 +1382,13 @@ func (c *xClient) SendFile(ctx context.Context, fileName string, rateInBytesPerS
 		Meta:     meta,
 	}
 
-	ctx = setServerTimeout(ctx)
-
 	reply := &share.FileTransferReply{}
 	err = c.Call(ctx, "TransferFile", args, reply)
 	if err != nil {
 		return err
 	}
 
-	conn, err := net.DialTimeout("tcp", reply.Addr, c.option.ConnectTimeout)
+	conn, err := net.DialTimeout("tcp", reply.Addr, c.getOption().ConnectTimeout)
 	if err != nil {
 		return err
 	}
@@ -1212,7 +1441,6 @@ loop:
 }
 
 func (c *xClient) DownloadFile(ctx context.Context, requestFileName string, saveTo io.Writer, meta map[string]string) error {
-	ctx = setServerTimeout(ctx)
 
 	args := share.DownloadFileArgs{
 		FileName: requestFileName,
@@ -1225,7 +1453,7 @@ func (c *xClient) DownloadFile(ctx context.Context, requestFileName string, save
 		return err
 	}
 
-	conn, err := net.DialTimeout("tcp", reply.Addr, c.option.ConnectTimeout)
+	conn, err := net.DialTimeout("tcp", reply.Addr, c.getOption().ConnectTimeout)
 	if err != nil {
 		return err
 	}
@@ -1269,11 +1497,16 @@ func (c *xClient) Close() error {
 	var errs []error
 	c.mu.Lock()
 	c.isShutdown = true
+	if c.reaperDone != nil {
+		close(c.reaperDone)
+		c.reaperDone = nil
+	}
 	for k, v := range c.cachedClient {
 		e := v.Close()
 		if e != nil {
 			errs = append(errs, e)
 		}
+		c.retireStats(k, v)
 
 		delete(c.cachedClient, k)
 
@@ -1300,15 +1533,13 @@ func (c *xClient) Stream(ctx context.Context, meta map[string]string) (net.Conn,
 		Meta: meta,
 	}
 
-	ctx = setServerTimeout(ctx)
-
 	reply := &share.StreamServiceReply{}
 	err := c.Call(ctx, "Stream", args, reply)
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := net.DialTimeout("tcp", reply.Addr, c.option.ConnectTimeout)
+	conn, err := net.DialTimeout("tcp", reply.Addr, c.getOption().ConnectTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// optionDrainTimeout bounds how long clients replaced by UpdateOption wait for their in-flight calls before they are closed.
+const optionDrainTimeout = time.Minute
+
+// liveOptions are fields of Option which UpdateOption applies to cached clients without connecting their nodes again.
+// They are used by XClient itself, or set to calls by it, while other fields are used by clients of nodes and their connections.
+var liveOptions = map[string]bool{
+	"Group":                   true,
+	"Retries":                 true,
+	"BackupLatency":           true,
+	"GenBreaker":              true,
+	"SerializeType":           true,
+	"SlowCallThreshold":       true,
+	"RetryOnAuthFailure":      true,
+	"CachedClientIdleTimeout": true,
+}
+
+// getOption returns the option, which must not be changed.
+func (c *xClient) getOption() *Option {
+	return c.option.Load().(*Option)
+}
+
+// UpdateOption updates the option by fn, which changes a copy of the current option, and applies it atomically
+// to later calls. It is safe to call while calls are flowing, and calling it again with the same change does nothing.
+//
+// Changes of Group, Retries, BackupLatency, SlowCallThreshold, RetryOnAuthFailure, CachedClientIdleTimeout
+// and SerializeType apply to cached clients, GenBreaker creates breakers of nodes without ones,
+// and Group filters servers of the discovery again. Changes of other fields, such as TLSConfig, ConnectTimeout
+// and IdleTimeout, are used by clients of nodes, so cached clients are replaced: later calls go to clients connected
+// with the new option, while in-flight calls finish on the old ones, which are closed after them.
+// AuthFunc is not updated, since tokens are cached by it.
+func (c *xClient) UpdateOption(fn func(o *Option)) {
+	c.mu.Lock()
+	old := c.getOption()
+	option := *old
+	fn(&option)
+	option.AuthFunc = old.AuthFunc
+
+	changed, reconnect := diffOption(*old, option)
+	if len(changed) == 0 || c.isShutdown {
+		c.mu.Unlock()
+		return
+	}
+	c.option.Store(&option)
+
+	for _, name := range changed {
+		switch name {
+		case "SerializeType":
+			atomic.StoreInt32(&c.serializeTypeUpdated, 1)
+		case "Group":
+			c.updateServers(c.discovery.GetServices())
+		case "CachedClientIdleTimeout":
+			if c.reaperDone != nil {
+				close(c.reaperDone)
+				c.reaperDone = nil
+			}
+			c.startReaper()
+		}
+	}
+
+	var replaced map[string]RPCClient
+	if reconnect {
+		replaced = c.cachedClient
+		c.cachedClient = make(map[string]RPCClient, len(replaced))
+		c.lastUsed = nil
+	}
+	c.mu.Unlock()
+
+	for k, client := range replaced {
+		go c.drainClient(k, client)
+	}
+}
+
+// drainClient closes client of the node k, replaced by UpdateOption, after its in-flight calls are done.
+func (c *xClient) drainClient(k string, client RPCClient) {
+	if cl, ok := client.(*Client); ok {
+		deadline := time.Now().Add(optionDrainTimeout)
+		for atomic.LoadInt64(&cl.stats.active) > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	client.UnregisterServerMessageChan()
+	client.Close()
+
+	c.mu.Lock()
+	c.retireStats(k, client)
+	c.mu.Unlock()
+}
+
+// withSerializeType sets Option.SerializeType changed by UpdateOption to calls of ctx,
+// unless it is set by WithSerializeType, since clients created before use the old one.
+func (c *xClient) withSerializeType(ctx context.Context) context.Context {
+	if atomic.LoadInt32(&c.serializeTypeUpdated) == 0 {
+		return ctx
+	}
+	if _, ok := ctx.Value(serializeTypeKey{}).(protocol.SerializeType); ok {
+		return ctx
+	}
+	return WithSerializeType(ctx, c.getOption().SerializeType)
+}
+
+// diffOption returns names of fields of Option changed from old to option,
+// and whether clients must connect again for them.
+func diffOption(old, option Option) (changed []string, reconnect bool) {
+	vo, vn := reflect.ValueOf(old), reflect.ValueOf(option)
+	t := vo.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if valueEqual(vo.Field(i), vn.Field(i)) {
+			continue
+		}
+		name := t.Field(i).Name
+		changed = append(changed, name)
+		if !liveOptions[name] {
+			reconnect = true
+		}
+	}
+	return changed, reconnect
+}
+
+// valueEqual compares references such as pointers and functions by their addresses, and others by their values.
+func valueEqual(a, b reflect.Value) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Func, reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan, reflect.UnsafePointer:
+		return a.Pointer() == b.Pointer() && (a.Kind() != reflect.Slice || a.Len() == b.Len())
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return valueEqual(a.Elem(), b.Elem())
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !valueEqual(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if !valueEqual(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() == b.Float()
+	case reflect.String:
+		return a.String() == b.String()
+	}
+	return a.CanInterface() && reflect.DeepEqual(a.Interface(), b.Interface())
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+)
+
+func TestDiffOption(t *testing.T) {
+	old := DefaultOption
+	option := old
+	if changed, _ := diffOption(old, option); len(changed) != 0 {
+		t.Fatalf("expect no changes but got %v", changed)
+	}
+
+	option.Retries = 5
+	option.SerializeType = protocol.JSON
+	option.GenBreaker = func() Breaker { return closedBreaker{} }
+	changed, reconnect := diffOption(old, option)
+	if !reflect.DeepEqual(changed, []string{"Retries", "GenBreaker", "SerializeType"}) || reconnect {
+		t.Fatalf("unexpected changes %v, %v", changed, reconnect)
+	}
+
+	option.TLSConfig = &tls.Config{}
+	option.IdleTimeout = time.Second
+	changed, reconnect = diffOption(old, option)
+	if len(changed) != 5 || !reconnect {
+		t.Fatalf("unexpected changes %v, %v", changed, reconnect)
+	}
+	// configs are compared by their addresses
+	if changed, _ = diffOption(option, option); len(changed) != 0 {
+		t.Fatalf("expect no changes but got %v", changed)
+	}
+}
+
+func startTLSStatsServer(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	s := server.NewServer(server.WithTLSConfig(config))
+	s.RegisterName("Stats", new(statsService), "")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveListener(t, s, tls.NewListener(ln, config))
+}
+
+// countingTLSConfig returns a config which counts its handshakes in n.
+func countingTLSConfig(n *int32) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyConnection: func(tls.ConnectionState) error {
+			atomic.AddInt32(n, 1)
+			return nil
+		},
+	}
+}
+
+func TestXClientUpdateOption(t *testing.T) {
+	addr := startTLSStatsServer(t)
+	d, err := NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handshakesA, handshakesB int32
+	configA, configB := countingTLSConfig(&handshakesA), countingTLSConfig(&handshakesB)
+	option := DefaultOption
+	option.TLSConfig = configA
+	xclient := NewXClient("Stats", Failfast, RandomSelect, d, option)
+	defer xclient.Close()
+
+	// calls under load don't fail by updates
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var calls, failed int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				reply := &Reply{}
+				if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, reply); err != nil || reply.C != 6 {
+					t.Errorf("call failed: %v", err)
+					atomic.AddInt32(&failed, 1)
+				}
+				atomic.AddInt32(&calls, 1)
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		time.Sleep(20 * time.Millisecond)
+		config := configA
+		if i%2 == 0 {
+			config = configB
+		}
+		xclient.UpdateOption(func(o *Option) {
+			o.TLSConfig = config
+			o.IdleTimeout = time.Duration(i+1) * time.Minute
+		})
+		if i == 5 {
+			xclient.UpdateOption(func(o *Option) { o.SerializeType = protocol.JSON })
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	if failed > 0 || calls == 0 {
+		t.Fatalf("%d of %d calls failed", failed, calls)
+	}
+	if atomic.LoadInt32(&handshakesA) < 5 || atomic.LoadInt32(&handshakesB) < 5 {
+		t.Fatalf("expect clients to connect again with the new configs, but got %d and %d handshakes", handshakesA, handshakesB)
+	}
+
+	// updates of the same change connect once
+	atomic.StoreInt32(&handshakesB, 0)
+	update := func(o *Option) {
+		o.TLSConfig = configB
+		o.IdleTimeout = time.Hour
+	}
+	var uwg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		uwg.Add(1)
+		go func() {
+			defer uwg.Done()
+			xclient.UpdateOption(update)
+		}()
+	}
+	uwg.Wait()
+	xclient.UpdateOption(update)
+	for i := 0; i < 3; i++ {
+		if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&handshakesB); n != 1 {
+		t.Fatalf("expect 1 handshake but got %d", n)
+	}
+
+	// options of XClient don't connect again
+	xclient.UpdateOption(func(o *Option) { o.Retries = 10 })
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&handshakesB); n != 1 {
+		t.Fatalf("expect no more handshakes but got %d", n)
+	}
+
+	// drained clients are closed and their stats are kept
+	time.Sleep(50 * time.Millisecond)
+	stats := xclient.Stats()["tcp@"+addr]
+	if stats.Calls < uint64(calls) || stats.State != ClientConnected {
+		t.Fatalf("unexpected stats %+v of %d calls", stats, calls)
+	}
+}
@@ -75,6 +75,15 @@ func (c *XClientPool) Auth(auth string) {
 	c.mu.RUnlock()
 }
 
+// UpdateOption updates the option of all xclients by fn, see XClient.UpdateOption.
+func (c *XClientPool) UpdateOption(fn func(o *Option)) {
+	c.mu.RLock()
+	for _, v := range c.xclients {
+		v.UpdateOption(fn)
+	}
+	c.mu.RUnlock()
+}
+
 // Get returns a xclient.
 // It does not remove this xclient from its cache so you don't need to put it back.
 // Don't close this xclient because maybe other goroutines are using this xclient.
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// touch records that the cached client of the node k is selected now, so it is not reaped until it is idle
+// for Option.CachedClientIdleTimeout. Calls selecting the client enter it soon after, and then they are counted
+// in its active calls until they are done, so the client isn't closed under them. c.mu is held.
+func (c *xClient) touch(k string) {
+	if c.getOption().CachedClientIdleTimeout <= 0 {
+		return
+	}
+	if c.lastUsed == nil {
+		c.lastUsed = make(map[string]time.Time)
+	}
+	c.lastUsed[k] = time.Now()
+}
+
+// startReaper starts the goroutine closing idle cached clients by Option.CachedClientIdleTimeout, stopped by Close.
+func (c *xClient) startReaper() {
+	timeout := c.getOption().CachedClientIdleTimeout
+	if timeout <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	c.reaperDone = done
+	go func() {
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				c.reapIdleClients(now)
+			}
+		}
+	}()
+}
+
+// reapIdleClients closes cached clients which are not selected since Option.CachedClientIdleTimeout before now,
+// and have no active calls. Stats of their nodes are retired, and breakers and selectors of nodes are kept,
+// so they are cumulative when the nodes are connected again. It returns the number of closed clients.
+func (c *xClient) reapIdleClients(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isShutdown {
+		return 0
+	}
+
+	var reaped int
+	for k, client := range c.cachedClient {
+		if now.Sub(c.lastUsed[k]) < c.getOption().CachedClientIdleTimeout {
+			continue
+		}
+		if cl, ok := client.(*Client); ok && atomic.LoadInt64(&cl.stats.active) > 0 {
+			continue
+		}
+		if _, ok := c.servers[k]; ok && len(c.cachedClient) == 1 {
+			continue
+		}
+
+		delete(c.cachedClient, k)
+		delete(c.lastUsed, k)
+		client.UnregisterServerMessageChan()
+		client.Close()
+		c.retireStats(k, client)
+		if retired := c.retiredStats[k]; retired != nil {
+			retired.IdleReaps++
+			retired.State = ClientIdle
+		}
+		reaped++
+	}
+	return reaped
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newReaperXClient(t *testing.T, timeout time.Duration, addrs ...string) *xClient {
+	var pairs []*KVPair
+	for _, addr := range addrs {
+		pairs = append(pairs, &KVPair{Key: "tcp@" + addr})
+	}
+	d, err := NewMultipleServersDiscovery(pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option := DefaultOption
+	option.CachedClientIdleTimeout = timeout
+	option.Retries = 10
+	xclient := NewXClient("Stats", Failtry, RoundRobin, d, option).(*xClient)
+	t.Cleanup(func() { xclient.Close() })
+	return xclient
+}
+
+func TestXClientIdleReaper(t *testing.T) {
+	addr1, addr2 := startStatsServer(t), startStatsServer(t)
+	xclient := newReaperXClient(t, time.Hour, addr1, addr2)
+	for i := 0; i < 2; i++ {
+		if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := xclient.reapIdleClients(time.Now()); n != 0 {
+		t.Fatalf("expect no idle clients but %d are reaped", n)
+	}
+
+	before := xclient.Stats()
+	// the client of the only connected node is kept
+	if n := xclient.reapIdleClients(time.Now().Add(2 * time.Hour)); n != 1 {
+		t.Fatalf("expect 1 idle client to be reaped but got %d", n)
+	}
+	if n := xclient.reapIdleClients(time.Now().Add(2 * time.Hour)); n != 0 {
+		t.Fatalf("expect the last client to be kept but %d are reaped", n)
+	}
+
+	var reaped string
+	for k, s := range xclient.Stats() {
+		switch s.State {
+		case ClientIdle:
+			reaped = k
+			if s.IdleReaps != 1 || s.Calls != before[k].Calls {
+				t.Fatalf("unexpected stats of the reaped node %+v", s)
+			}
+		case ClientConnected:
+		default:
+			t.Fatalf("unexpected state %v of %s", s.State, k)
+		}
+	}
+	if reaped == "" {
+		t.Fatal("expect a reaped node")
+	}
+
+	// reaped nodes are connected again on selection, with cumulative stats
+	for i := 0; i < 2; i++ {
+		if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := xclient.Stats()[reaped]
+	if s.State != ClientConnected || s.Calls <= before[reaped].Calls || s.IdleReaps != 1 || s.Reconnects != 1 {
+		t.Fatalf("unexpected stats of the reconnected node %+v", s)
+	}
+
+	// clients with active calls are not reaped
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- xclient.Call(context.Background(), "Slow", &Args{}, &Reply{}) }()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := xclient.reapIdleClients(time.Now().Add(2 * time.Hour)); n != 0 {
+		t.Fatalf("expect clients with active calls to be kept but %d are reaped", n)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestXClientIdleReaperWithCalls(t *testing.T) {
+	addr1, addr2 := startStatsServer(t), startStatsServer(t)
+	xclient := newReaperXClient(t, time.Millisecond, addr1, addr2)
+
+	var wg sync.WaitGroup
+	var failed int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+					atomic.AddInt32(&failed, 1)
+				}
+				if j%10 == 0 {
+					time.Sleep(5 * time.Millisecond) // idle for the reaper
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if failed > 0 {
+		t.Fatalf("%d calls failed", failed)
+	}
+
+	// the reaper runs by itself
+	time.Sleep(20 * time.Millisecond)
+	var reaps uint64
+	for _, s := range xclient.Stats() {
+		reaps += s.IdleReaps
+	}
+	if reaps == 0 {
+		t.Fatal("expect idle clients to be reaped")
+	}
+}
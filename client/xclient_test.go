@@ -3,12 +3,14 @@ package client
 import (
 	"context"
 	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"fmt"
 
 	testutils "github.com/smallnest/rpcx/_testutils"
+	rerrors "github.com/smallnest/rpcx/errors"
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/server"
 	"github.com/smallnest/rpcx/share"
@@ -109,6 +111,23 @@ func TestXClient_filterByStateAndGroup(t *testing.T) {
 	}
 }
 
+func TestXClient_registrations(t *testing.T) {
+	servers := map[string]string{
+		"tcp@127.0.0.1:8972":      "group=blue",
+		"tcp@127.0.0.1:8972#gold": "group=gold&registration=gold",
+	}
+	filterByStateAndGroup("gold", servers)
+	if len(servers) != 1 || servers["tcp@127.0.0.1:8972#gold"] == "" {
+		t.Errorf("expect only the gold registration but got %v", servers)
+	}
+
+	// all registrations of a server are dialed at its address
+	network, addr := splitNetworkAndAddress("tcp@127.0.0.1:8972#gold")
+	if network != "tcp" || addr != "127.0.0.1:8972" {
+		t.Errorf("expect tcp 127.0.0.1:8972 but got %s %s", network, addr)
+	}
+}
+
 func TestUncoverError(t *testing.T) {
 	var e error = ServiceError("error")
 	if uncoverError(e) {
@@ -128,3 +147,105 @@ func TestUncoverError(t *testing.T) {
 		t.Fatalf("expect true but get false")
 	}
 }
+
+// flakyPBArith is unavailable for the first call.
+type flakyPBArith struct {
+	calls int32
+}
+
+func (t *flakyPBArith) Mul(ctx context.Context, args *testutils.ProtoArgs, reply *testutils.ProtoReply) error {
+	if atomic.AddInt32(&t.calls, 1) == 1 {
+		return rerrors.New(rerrors.Unavailable, "try again")
+	}
+	reply.C = args.A * args.B
+	return nil
+}
+
+func TestXClient_WithSerializeType(t *testing.T) {
+	p := &serializeTypePlugin{}
+	s := server.NewServer()
+	s.Plugins.Add(p)
+	s.RegisterName("PBArith", new(flakyPBArith), "")
+	addr := startServer(t, s)
+
+	opt := DefaultOption
+	opt.SerializeType = protocol.JSON
+	opt.Retries = 2
+	d, err := NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	xclient := NewXClient("PBArith", Failtry, RandomSelect, d, opt)
+	defer xclient.Close()
+
+	reply := &testutils.ProtoReply{}
+	ctx := WithSerializeType(context.Background(), protocol.ProtoBuffer)
+	if err := xclient.Call(ctx, "Mul", &testutils.ProtoArgs{A: 10, B: 20}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 200 {
+		t.Fatalf("expect 200 but got %d", reply.C)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.types) != 2 || p.types[0] != protocol.ProtoBuffer || p.types[1] != protocol.ProtoBuffer {
+		t.Errorf("expect the retry to keep the serialize type but got %v", p.types)
+	}
+}
+
+type slowStatsService struct {
+	statsService
+}
+
+func (s *slowStatsService) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	time.Sleep(50 * time.Millisecond)
+	return s.statsService.Mul(ctx, args, reply)
+}
+
+// ctxValuePlugin records values of contexts of calls after they are done.
+type ctxValuePlugin struct {
+	values chan interface{}
+}
+
+func (p *ctxValuePlugin) PostCall(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}, err error) error {
+	p.values <- ctx.Value(share.ReqMetaDataKey)
+	return nil
+}
+
+func TestXClient_ForkPooledContext(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Stats", new(slowStatsService), "")
+	addr1 := startStatsServer(t)
+	addr2 := startServer(t, s)
+
+	d, err := NewMultipleServersDiscovery([]*KVPair{{Key: "tcp@" + addr1}, {Key: "tcp@" + addr2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := DefaultOption
+	opt.PoolContexts = true
+	xclient := NewXClient("Stats", Failfast, RandomSelect, d, opt)
+	defer xclient.Close()
+	p := &ctxValuePlugin{values: make(chan interface{}, 2)}
+	pc := NewPluginContainer()
+	pc.Add(p)
+	xclient.SetPlugins(pc)
+
+	// like the pooled context of a request of a server, which is freed when its handler returns
+	ctx := share.GetPooledContext(context.Background())
+	meta := map[string]string{"key": "value"}
+	ctx.SetValue(share.ReqMetaDataKey, meta)
+	// without the reply, which successful calls of Fork set at the same time
+	if err := xclient.Fork(ctx, "Mul", &Args{A: 2, B: 3}, nil); err != nil {
+		t.Fatal(err)
+	}
+	share.FreeContext(ctx)
+
+	// the slow call is done after Fork returns
+	for i := 0; i < 2; i++ {
+		if v, ok := (<-p.values).(map[string]string); !ok || v["key"] != "value" {
+			t.Fatalf("expect the metadata in contexts of calls but got %v", v)
+		}
+	}
+}
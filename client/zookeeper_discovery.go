@@ -30,6 +30,8 @@ type ZookeeperDiscovery struct {
 
 	filter ServiceDiscoveryFilter
 
+	discoveryEvents
+
 	stopCh chan struct{}
 }
 
@@ -57,7 +59,7 @@ func NewZookeeperDiscoveryWithStore(basePath string, kv store.Store) (*Zookeeper
 	if basePath[0] == '/' {
 		basePath = basePath[1:]
 	}
-	d := &ZookeeperDiscovery{basePath: basePath, kv: kv}
+	d := &ZookeeperDiscovery{basePath: basePath, kv: kv, discoveryEvents: newDiscoveryEvents("zookeeper")}
 	d.stopCh = make(chan struct{})
 
 	ps, err := kv.List(basePath)
@@ -172,6 +174,7 @@ func (d *ZookeeperDiscovery) watch() {
 					tempDelay = max
 				}
 				log.Warnf("can not watchtree (with retry %d, sleep %v): %s: %v", retry, tempDelay, d.basePath, err)
+				d.failed(DiscoveryWatch, err, true)
 				time.Sleep(tempDelay)
 				continue
 			}
@@ -180,6 +183,7 @@ func (d *ZookeeperDiscovery) watch() {
 
 		if err != nil {
 			log.Errorf("can't watch %s: %v", d.basePath, err)
+			d.failed(DiscoveryWatch, err, true)
 			return
 		}
 
@@ -214,6 +218,7 @@ func (d *ZookeeperDiscovery) watch() {
 				d.pairsMu.Lock()
 				d.pairs = pairs
 				d.pairsMu.Unlock()
+				d.updated()
 
 				d.mu.Lock()
 				for _, ch := range d.chans {
@@ -234,6 +239,7 @@ func (d *ZookeeperDiscovery) watch() {
 		}
 
 		log.Warn("chan is closed and will rewatch")
+		d.failed(DiscoveryWatch, errWatchClosed, false)
 	}
 }
 
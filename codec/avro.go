@@ -0,0 +1,529 @@
+package codec
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/hamba/avro/v2"
+)
+
+// AvroFingerprintKey is the metadata key of the fingerprint of the schema that has written the Avro payload.
+const AvroFingerprintKey = "__rpcx_avro_fingerprint__"
+
+// ErrAvroMessageRequired is returned by Encode and Decode of AvroCodec, whose schemas are resolved by messages.
+var ErrAvroMessageRequired = errors.New("codec: avro payloads must be encoded and decoded with their messages")
+
+// SchemaKey identifies payloads of a method. Response is false for args and true for replies.
+type SchemaKey struct {
+	ServicePath   string
+	ServiceMethod string
+	Response      bool
+}
+
+// SchemaProvider provides schemas of AvroCodec, for example from a schema registry, embedded schemas or generated code.
+type SchemaProvider interface {
+	// Schema returns the schema of payloads of key, which writes payloads and reads them into Go values.
+	Schema(key SchemaKey) (avro.Schema, error)
+	// SchemaByFingerprint returns the schema with the fingerprint by AvroFingerprint,
+	// which is the schema of a peer that has written a payload.
+	SchemaByFingerprint(fingerprint string) (avro.Schema, error)
+}
+
+// AvroFingerprint returns the CRC-64-AVRO fingerprint of the canonical form of schema in hex.
+func AvroFingerprint(schema avro.Schema) (string, error) {
+	fp, err := schema.FingerprintUsing(avro.CRC64Avro)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(fp), nil
+}
+
+// AvroCodec uses Avro marshaler and unmarshaler with schemas of SchemaProvider by methods of messages.
+// The fingerprint of the writer schema is set in metadata by AvroFingerprintKey, so that a reader with another schema
+// of the method resolves payloads by the schema evolution rules of Avro: fields are matched by names and aliases of
+// reader fields, fields the writer doesn't have get their defaults, fields the reader doesn't have are skipped,
+// and numbers are promoted.
+//
+// Fields of Go structs are matched by avro tags, or by their names if they have no avro tags.
+type AvroCodec struct {
+	provider   SchemaProvider
+	compatible sync.Map // [2]string{reader, writer} -> error
+}
+
+// NewAvroCodec creates an AvroCodec with schemas of provider.
+func NewAvroCodec(provider SchemaProvider) *AvroCodec {
+	return &AvroCodec{provider: provider}
+}
+
+// Encode returns ErrAvroMessageRequired.
+func (c *AvroCodec) Encode(i interface{}) ([]byte, error) {
+	return nil, ErrAvroMessageRequired
+}
+
+// Decode returns ErrAvroMessageRequired.
+func (c *AvroCodec) Decode(data []byte, i interface{}) error {
+	return ErrAvroMessageRequired
+}
+
+// EncodeMessage encodes i by the schema of the method of m and sets its fingerprint in metadata of m.
+func (c *AvroCodec) EncodeMessage(m *MessageInfo, i interface{}) ([]byte, error) {
+	schema, err := c.provider.Schema(schemaKeyOf(m))
+	if err != nil {
+		return nil, err
+	}
+	fp, err := AvroFingerprint(schema)
+	if err != nil {
+		return nil, err
+	}
+	data, err := avro.Marshal(schema, i)
+	if err != nil {
+		return nil, err
+	}
+
+	// metadata may belong to the caller, so it is copied
+	meta := make(map[string]string, len(m.Metadata)+1)
+	for k, v := range m.Metadata {
+		meta[k] = v
+	}
+	meta[AvroFingerprintKey] = fp
+	m.Metadata = meta
+	return data, nil
+}
+
+// DecodeMessage decodes data into i by the schema of the method of m,
+// resolving data written by another schema with the fingerprint in metadata of m.
+func (c *AvroCodec) DecodeMessage(m *MessageInfo, data []byte, i interface{}) error {
+	reader, err := c.provider.Schema(schemaKeyOf(m))
+	if err != nil {
+		return err
+	}
+	readerFP, err := AvroFingerprint(reader)
+	if err != nil {
+		return err
+	}
+	writerFP := m.Metadata[AvroFingerprintKey]
+	if writerFP == "" || writerFP == readerFP {
+		return avro.Unmarshal(reader, data, i)
+	}
+
+	writer, err := c.provider.SchemaByFingerprint(writerFP)
+	if err != nil {
+		return err
+	}
+	if err := c.checkCompatible(reader, writer, readerFP, writerFP); err != nil {
+		return err
+	}
+
+	var v interface{}
+	if err := avro.Unmarshal(writer, data, &v); err != nil {
+		return err
+	}
+	if v, err = resolveAvro(writer, reader, v); err != nil {
+		return err
+	}
+	if data, err = avro.Marshal(reader, v); err != nil {
+		return err
+	}
+	return avro.Unmarshal(reader, data, i)
+}
+
+func (c *AvroCodec) checkCompatible(reader, writer avro.Schema, readerFP, writerFP string) error {
+	k := [2]string{readerFP, writerFP}
+	if err, ok := c.compatible.Load(k); ok {
+		if err == nil {
+			return nil
+		}
+		return err.(error)
+	}
+	err := avroCompatible(writer, reader, make(map[[2]string]bool))
+	if err != nil {
+		err = fmt.Errorf("codec: avro schema %s can't read payloads of schema %s: %w", readerFP, writerFP, err)
+	}
+	c.compatible.Store(k, err)
+	return err
+}
+
+func schemaKeyOf(m *MessageInfo) SchemaKey {
+	return SchemaKey{ServicePath: m.ServicePath, ServiceMethod: m.ServiceMethod, Response: m.Response}
+}
+
+// MemorySchemaProvider is a SchemaProvider keeping schemas in memory, for example for tests and embedded schemas.
+type MemorySchemaProvider struct {
+	mu           sync.RWMutex
+	schemas      map[SchemaKey]avro.Schema
+	fingerprints map[string]avro.Schema
+}
+
+// NewMemorySchemaProvider creates an empty MemorySchemaProvider.
+func NewMemorySchemaProvider() *MemorySchemaProvider {
+	return &MemorySchemaProvider{
+		schemas:      make(map[SchemaKey]avro.Schema),
+		fingerprints: make(map[string]avro.Schema),
+	}
+}
+
+// Register sets the schema of key. Replaced schemas are still found by fingerprints.
+func (p *MemorySchemaProvider) Register(key SchemaKey, schema avro.Schema) error {
+	if err := p.Add(schema); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.schemas[key] = schema
+	p.mu.Unlock()
+	return nil
+}
+
+// Add adds a schema found by its fingerprint, for example a schema of peers.
+func (p *MemorySchemaProvider) Add(schema avro.Schema) error {
+	fp, err := AvroFingerprint(schema)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.fingerprints[fp] = schema
+	p.mu.Unlock()
+	return nil
+}
+
+// Schema returns the schema of key.
+func (p *MemorySchemaProvider) Schema(key SchemaKey) (avro.Schema, error) {
+	p.mu.RLock()
+	schema := p.schemas[key]
+	p.mu.RUnlock()
+	if schema == nil {
+		return nil, fmt.Errorf("codec: no avro schema for %s.%s (response %t)", key.ServicePath, key.ServiceMethod, key.Response)
+	}
+	return schema, nil
+}
+
+// SchemaByFingerprint returns the schema with fingerprint.
+func (p *MemorySchemaProvider) SchemaByFingerprint(fingerprint string) (avro.Schema, error) {
+	p.mu.RLock()
+	schema := p.fingerprints[fingerprint]
+	p.mu.RUnlock()
+	if schema == nil {
+		return nil, fmt.Errorf("codec: no avro schema with fingerprint %s", fingerprint)
+	}
+	return schema, nil
+}
+
+// avroCompatible returns an error if the reader schema can't read payloads of the writer schema.
+// Branches of unions of the writer are checked when payloads are read. Records in seen are being checked.
+func avroCompatible(writer, reader avro.Schema, seen map[[2]string]bool) error {
+	if _, ok := writer.(*avro.UnionSchema); ok {
+		return nil
+	}
+	if ru, ok := reader.(*avro.UnionSchema); ok {
+		for _, b := range ru.Types() {
+			if avroMatch(writer, b, true) && avroCompatible(writer, b, seen) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("union %s has no branch for %s", reader, avroTypeName(writer))
+	}
+	if !avroMatch(writer, reader, true) {
+		return fmt.Errorf("%s can't be read as %s", avroTypeName(writer), avroTypeName(reader))
+	}
+
+	switch r := reader.(type) {
+	case *avro.RecordSchema:
+		w := writer.(*avro.RecordSchema)
+		k := [2]string{w.FullName(), r.FullName()}
+		if seen[k] { // recursive records
+			return nil
+		}
+		seen[k] = true
+		for _, f := range r.Fields() {
+			wf := writerField(w, f)
+			if wf == nil {
+				if !f.HasDefault() {
+					return fmt.Errorf("record %s has no field %s, which has no default", w.FullName(), f.Name())
+				}
+				continue
+			}
+			if err := avroCompatible(wf.Type(), f.Type(), seen); err != nil {
+				return err
+			}
+		}
+	case *avro.ArraySchema:
+		return avroCompatible(writer.(*avro.ArraySchema).Items(), r.Items(), seen)
+	case *avro.MapSchema:
+		return avroCompatible(writer.(*avro.MapSchema).Values(), r.Values(), seen)
+	case *avro.FixedSchema:
+		if w := writer.(*avro.FixedSchema); w.Size() != r.Size() {
+			return fmt.Errorf("fixed %s of %d bytes can't be read as %d bytes", w.FullName(), w.Size(), r.Size())
+		}
+	}
+	return nil
+}
+
+// resolveAvro converts v decoded by the writer schema into a value the reader schema can encode.
+func resolveAvro(writer, reader avro.Schema, v interface{}) (interface{}, error) {
+	if wu, ok := writer.(*avro.UnionSchema); ok {
+		if v == nil {
+			return resolveAvro(avro.NewPrimitiveSchema(avro.Null, nil), reader, nil)
+		}
+		if branches, ok := v.(map[string]interface{}); ok && len(branches) == 1 {
+			for name, bv := range branches {
+				for _, b := range wu.Types() {
+					if avroTypeName(b) == name {
+						return resolveAvro(b, reader, bv)
+					}
+				}
+			}
+		}
+		return nil, fmt.Errorf("codec: invalid avro union value %v", v)
+	}
+
+	if ru, ok := reader.(*avro.UnionSchema); ok {
+		// the first branch of the same type, or else the first one the type is promoted to
+		for _, promote := range []bool{false, true} {
+			for _, b := range ru.Types() {
+				if !avroMatch(writer, b, promote) {
+					continue
+				}
+				bv, err := resolveAvro(writer, b, v)
+				if err != nil || b.Type() == avro.Null {
+					return nil, err
+				}
+				return map[string]interface{}{avroTypeName(b): bv}, nil
+			}
+		}
+		return nil, fmt.Errorf("codec: avro union %s has no branch for %s", reader, avroTypeName(writer))
+	}
+
+	switch r := reader.(type) {
+	case *avro.RecordSchema:
+		w, ok := writer.(*avro.RecordSchema)
+		fields, ok2 := v.(map[string]interface{})
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("codec: can't read %s as avro record %s", avroTypeName(writer), r.FullName())
+		}
+		out := make(map[string]interface{}, len(r.Fields()))
+		for _, f := range r.Fields() {
+			wf := writerField(w, f)
+			if wf == nil {
+				if !f.HasDefault() {
+					return nil, fmt.Errorf("codec: avro record %s has no field %s or default", w.FullName(), f.Name())
+				}
+				dv, err := coerceAvro(f.Type(), f.Default())
+				if err != nil {
+					return nil, err
+				}
+				out[f.Name()] = dv
+				continue
+			}
+			fv, err := resolveAvro(wf.Type(), f.Type(), fields[wf.Name()])
+			if err != nil {
+				return nil, err
+			}
+			out[f.Name()] = fv
+		}
+		return out, nil
+	case *avro.ArraySchema:
+		w, ok := writer.(*avro.ArraySchema)
+		items, ok2 := v.([]interface{})
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("codec: can't read %s as avro array", avroTypeName(writer))
+		}
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if out[i], err = resolveAvro(w.Items(), r.Items(), item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case *avro.MapSchema:
+		w, ok := writer.(*avro.MapSchema)
+		values, ok2 := v.(map[string]interface{})
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("codec: can't read %s as avro map", avroTypeName(writer))
+		}
+		out := make(map[string]interface{}, len(values))
+		for k, value := range values {
+			var err error
+			if out[k], err = resolveAvro(w.Values(), r.Values(), value); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return coerceAvro(reader, v)
+}
+
+// coerceAvro converts v of a primitive, enum or fixed type, or a default value, into the Go type schema encodes.
+func coerceAvro(schema avro.Schema, v interface{}) (interface{}, error) {
+	switch s := schema.(type) {
+	case *avro.UnionSchema: // defaults of unions are of their first types
+		first := s.Types()[0]
+		if first.Type() == avro.Null {
+			return nil, nil
+		}
+		fv, err := coerceAvro(first, v)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{avroTypeName(first): fv}, nil
+	case *avro.RecordSchema:
+		fields, _ := v.(map[string]interface{})
+		out := make(map[string]interface{}, len(s.Fields()))
+		for _, f := range s.Fields() {
+			fv, ok := fields[f.Name()]
+			if !ok {
+				fv = f.Default()
+			}
+			var err error
+			if out[f.Name()], err = coerceAvro(f.Type(), fv); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case *avro.ArraySchema:
+		items, _ := v.([]interface{})
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if out[i], err = coerceAvro(s.Items(), item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case *avro.MapSchema:
+		values, _ := v.(map[string]interface{})
+		out := make(map[string]interface{}, len(values))
+		for k, value := range values {
+			var err error
+			if out[k], err = coerceAvro(s.Values(), value); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case *avro.EnumSchema:
+		symbol, _ := v.(string)
+		for _, sym := range s.Symbols() {
+			if sym == symbol {
+				return symbol, nil
+			}
+		}
+		return nil, fmt.Errorf("codec: avro enum %s has no symbol %q", s.FullName(), symbol)
+	case *avro.FixedSchema:
+		b := avroBytes(v)
+		if len(b) != s.Size() {
+			return nil, fmt.Errorf("codec: %d bytes can't be avro fixed %s", len(b), s.FullName())
+		}
+		fixed := reflect.New(reflect.ArrayOf(s.Size(), reflect.TypeOf(byte(0)))).Elem()
+		reflect.Copy(fixed, reflect.ValueOf(b))
+		return fixed.Interface(), nil
+	}
+
+	var t reflect.Type
+	switch schema.Type() {
+	case avro.Int:
+		t = reflect.TypeOf(int(0))
+	case avro.Long:
+		t = reflect.TypeOf(int64(0))
+	case avro.Float:
+		t = reflect.TypeOf(float32(0))
+	case avro.Double:
+		t = reflect.TypeOf(float64(0))
+	case avro.String:
+		if b, ok := v.([]byte); ok {
+			return string(b), nil
+		}
+		return v, nil
+	case avro.Bytes:
+		return avroBytes(v), nil
+	default:
+		return v, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return rv.Convert(t).Interface(), nil
+	}
+	// values of logical types such as time.Time
+	return v, nil
+}
+
+func avroBytes(v interface{}) []byte {
+	switch b := v.(type) {
+	case []byte:
+		return b
+	case string:
+		return []byte(b)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Array {
+		b := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(b), rv)
+		return b
+	}
+	return nil
+}
+
+// writerField returns the field of the writer record matching the reader field by its name or aliases.
+func writerField(writer *avro.RecordSchema, f *avro.Field) *avro.Field {
+	for _, wf := range writer.Fields() {
+		if wf.Name() == f.Name() {
+			return wf
+		}
+	}
+	for _, alias := range f.Aliases() {
+		for _, wf := range writer.Fields() {
+			if wf.Name() == alias {
+				return wf
+			}
+		}
+	}
+	return nil
+}
+
+// avroMatch reports whether payloads of the writer schema can be read by the reader schema,
+// which is of the same type or, if promote is true, a type the writer type is promoted to.
+func avroMatch(writer, reader avro.Schema, promote bool) bool {
+	wt, rt := writer.Type(), reader.Type()
+	if wt == rt {
+		wn, ok1 := writer.(avro.NamedSchema)
+		rn, ok2 := reader.(avro.NamedSchema)
+		if !ok1 || !ok2 || wn.FullName() == rn.FullName() {
+			return true
+		}
+		if a, ok := reader.(interface{ Aliases() []string }); ok {
+			for _, alias := range a.Aliases() {
+				if alias == wn.FullName() {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if !promote {
+		return false
+	}
+	switch wt {
+	case avro.Int:
+		return rt == avro.Long || rt == avro.Float || rt == avro.Double
+	case avro.Long:
+		return rt == avro.Float || rt == avro.Double
+	case avro.Float:
+		return rt == avro.Double
+	case avro.String:
+		return rt == avro.Bytes
+	case avro.Bytes:
+		return rt == avro.String
+	}
+	return false
+}
+
+// avroTypeName returns the name of schema in generic values of unions.
+func avroTypeName(schema avro.Schema) string {
+	if n, ok := schema.(avro.NamedSchema); ok {
+		return n.FullName()
+	}
+	return string(schema.Type())
+}
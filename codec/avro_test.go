@@ -0,0 +1,255 @@
+package codec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hamba/avro/v2"
+)
+
+const (
+	userV1 = `{"type": "record", "name": "User", "fields": [
+		{"name": "id", "type": "int"},
+		{"name": "name", "type": "string"},
+		{"name": "nickname", "type": ["null", "string"], "default": null}
+	]}`
+	// v2 removes nickname and adds optional fields
+	userV2 = `{"type": "record", "name": "User", "fields": [
+		{"name": "id", "type": "int"},
+		{"name": "name", "type": "string"},
+		{"name": "email", "type": ["null", "string"], "default": null},
+		{"name": "tags", "type": {"type": "array", "items": "string"}, "default": ["new"]},
+		{"name": "score", "type": "double", "default": 1}
+	]}`
+	// v3 promotes id to long and renames fields with aliases
+	userV3 = `{"type": "record", "name": "User", "fields": [
+		{"name": "ID", "type": "long", "aliases": ["id"]},
+		{"name": "DisplayName", "type": "string", "aliases": ["name"]}
+	]}`
+	// v4 adds a required field
+	userV4 = `{"type": "record", "name": "User", "fields": [
+		{"name": "id", "type": "int"},
+		{"name": "region", "type": "string"}
+	]}`
+)
+
+type UserV1 struct {
+	ID       int     `avro:"id"`
+	Name     string  `avro:"name"`
+	Nickname *string `avro:"nickname"`
+}
+
+type UserV2 struct {
+	ID    int      `avro:"id"`
+	Name  string   `avro:"name"`
+	Email *string  `avro:"email"`
+	Tags  []string `avro:"tags"`
+	Score float64  `avro:"score"`
+}
+
+// UserV3 has no avro tags, so its fields are matched by their names.
+type UserV3 struct {
+	ID          int64
+	DisplayName string
+}
+
+var userKey = SchemaKey{ServicePath: "Users", ServiceMethod: "Get", Response: true}
+
+// newAvroPeer creates the codec of a peer with schema, which knows schemas of other peers by the registry.
+func newAvroPeer(t *testing.T, registry []avro.Schema, schema avro.Schema) *AvroCodec {
+	p := NewMemorySchemaProvider()
+	for _, s := range registry {
+		if err := p.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Register(userKey, schema); err != nil {
+		t.Fatal(err)
+	}
+	return NewAvroCodec(p)
+}
+
+func newUserMessage() *MessageInfo {
+	return &MessageInfo{ServicePath: userKey.ServicePath, ServiceMethod: userKey.ServiceMethod, Response: true}
+}
+
+func TestAvroCodec(t *testing.T) {
+	v1, v2, v3, v4 := avro.MustParse(userV1), avro.MustParse(userV2), avro.MustParse(userV3), avro.MustParse(userV4)
+	registry := []avro.Schema{v1, v2, v3, v4}
+	c1, c2, c3, c4 := newAvroPeer(t, registry, v1), newAvroPeer(t, registry, v2), newAvroPeer(t, registry, v3), newAvroPeer(t, registry, v4)
+	nickname, email := "ann", "ann@example.com"
+
+	// the same schema
+	m1 := newUserMessage()
+	data1, err := c1.EncodeMessage(m1, &UserV1{ID: 1, Name: "Ann", Nickname: &nickname})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, _ := AvroFingerprint(v1)
+	if m1.Metadata[AvroFingerprintKey] != fp {
+		t.Fatalf("expect fingerprint %s but got %v", fp, m1.Metadata)
+	}
+	var u1 UserV1
+	if err := c1.DecodeMessage(m1, data1, &u1); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(u1, UserV1{ID: 1, Name: "Ann", Nickname: &nickname}) {
+		t.Errorf("unexpected %+v", u1)
+	}
+
+	// v2 reads v1: the removed field is skipped and added fields get their defaults
+	var u2 UserV2
+	if err := c2.DecodeMessage(m1, data1, &u2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(u2, UserV2{ID: 1, Name: "Ann", Tags: []string{"new"}, Score: 1}) {
+		t.Errorf("unexpected %+v", u2)
+	}
+
+	// v1 reads v2
+	m2 := newUserMessage()
+	m2.Metadata = map[string]string{"k": "v"}
+	meta := m2.Metadata
+	data2, err := c2.EncodeMessage(m2, &UserV2{ID: 2, Name: "Bob", Email: &email, Tags: []string{"a"}, Score: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := meta[AvroFingerprintKey]; ok || m2.Metadata["k"] != "v" {
+		t.Errorf("expect metadata of callers unchanged but got %v", meta)
+	}
+	u1 = UserV1{}
+	if err := c1.DecodeMessage(m2, data2, &u1); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(u1, UserV1{ID: 2, Name: "Bob"}) {
+		t.Errorf("unexpected %+v", u1)
+	}
+
+	// v3 reads v1: the promoted field and the renamed field
+	var u3 UserV3
+	if err := c3.DecodeMessage(m1, data1, &u3); err != nil {
+		t.Fatal(err)
+	}
+	if u3.ID != 1 || u3.DisplayName != "Ann" {
+		t.Errorf("unexpected %+v", u3)
+	}
+
+	// v4 can't read v1 without the required field
+	var u4 struct {
+		ID     int    `avro:"id"`
+		Region string `avro:"region"`
+	}
+	if err := c4.DecodeMessage(m1, data1, &u4); err == nil {
+		t.Error("expect an error for the required field the writer doesn't have")
+	}
+
+	// unknown writer schemas
+	m1.Metadata[AvroFingerprintKey] = "0000000000000000"
+	if err := c2.DecodeMessage(m1, data1, &u2); err == nil {
+		t.Error("expect an error for an unknown schema")
+	}
+
+	if _, err := c1.Encode(&u1); err != ErrAvroMessageRequired {
+		t.Errorf("expect ErrAvroMessageRequired but got %v", err)
+	}
+}
+
+func TestAvroCodecNested(t *testing.T) {
+	writer := avro.MustParse(`{"type": "record", "name": "Device", "fields": [
+		{"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["SENSOR", "SWITCH"]}},
+		{"name": "mac", "type": {"type": "fixed", "name": "MAC", "size": 6}},
+		{"name": "location", "type": ["null", {"type": "record", "name": "Location", "fields": [
+			{"name": "room", "type": "string"}
+		]}]},
+		{"name": "readings", "type": {"type": "array", "items": {"type": "record", "name": "Reading", "fields": [
+			{"name": "value", "type": "float"}
+		]}}},
+		{"name": "labels", "type": {"type": "map", "values": "string"}}
+	]}`)
+	reader := avro.MustParse(`{"type": "record", "name": "Device", "fields": [
+		{"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["SENSOR", "SWITCH", "HUB"]}},
+		{"name": "mac", "type": {"type": "fixed", "name": "MAC", "size": 6}},
+		{"name": "location", "type": ["null", {"type": "record", "name": "Location", "fields": [
+			{"name": "room", "type": "string"},
+			{"name": "floor", "type": "int", "default": 1}
+		]}]},
+		{"name": "readings", "type": {"type": "array", "items": {"type": "record", "name": "Reading", "fields": [
+			{"name": "value", "type": "double"},
+			{"name": "unit", "type": "string", "default": "C"}
+		]}}},
+		{"name": "labels", "type": {"type": "map", "values": "bytes"}}
+	]}`)
+
+	type Location struct {
+		Room  string `avro:"room"`
+		Floor int    `avro:"floor"`
+	}
+	type Reading struct {
+		Value float64 `avro:"value"`
+		Unit  string  `avro:"unit"`
+	}
+	type Device struct {
+		Kind     string            `avro:"kind"`
+		MAC      [6]byte           `avro:"mac"`
+		Location *Location         `avro:"location"`
+		Readings []Reading         `avro:"readings"`
+		Labels   map[string][]byte `avro:"labels"`
+	}
+
+	registry := []avro.Schema{writer, reader}
+	w, r := newAvroPeer(t, registry, writer), newAvroPeer(t, registry, reader)
+	m := newUserMessage()
+	data, err := w.EncodeMessage(m, map[string]interface{}{
+		"kind":     "SWITCH",
+		"mac":      [6]byte{1, 2, 3, 4, 5, 6},
+		"location": map[string]interface{}{"Location": map[string]interface{}{"room": "kitchen"}},
+		"readings": []interface{}{map[string]interface{}{"value": float32(1.5)}},
+		"labels":   map[string]interface{}{"k": "v"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d Device
+	if err := r.DecodeMessage(m, data, &d); err != nil {
+		t.Fatal(err)
+	}
+	expected := Device{
+		Kind:     "SWITCH",
+		MAC:      [6]byte{1, 2, 3, 4, 5, 6},
+		Location: &Location{Room: "kitchen", Floor: 1},
+		Readings: []Reading{{Value: 1.5, Unit: "C"}},
+		Labels:   map[string][]byte{"k": []byte("v")},
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Errorf("expect %+v but got %+v", expected, d)
+	}
+
+	// the writer can't read symbols it doesn't have
+	m = newUserMessage()
+	data, err = r.EncodeMessage(m, &Device{Kind: "HUB", Readings: []Reading{}, Labels: map[string][]byte{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.DecodeMessage(m, data, &d); err == nil {
+		t.Error("expect an error for an unknown symbol")
+	}
+}
+
+func TestAvroCompatibleRecursive(t *testing.T) {
+	writer := avro.MustParse(`{"type": "record", "name": "Node", "fields": [
+		{"name": "value", "type": "int"},
+		{"name": "next", "type": ["null", "Node"]}
+	]}`)
+	reader := avro.MustParse(`{"type": "record", "name": "Node", "fields": [
+		{"name": "value", "type": "long"},
+		{"name": "next", "type": ["null", "Node"]},
+		{"name": "label", "type": "string", "default": ""}
+	]}`)
+	if err := avroCompatible(writer, reader, make(map[[2]string]bool)); err != nil {
+		t.Errorf("expect compatible schemas but got %v", err)
+	}
+	if err := avroCompatible(reader, writer, make(map[[2]string]bool)); err == nil {
+		t.Error("expect long can't be read as int")
+	}
+}
@@ -4,14 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/apache/thrift/lib/go/thrift"
-	"github.com/tinylib/msgp/msgp"
+	"github.com/fxamacker/cbor/v2"
 	proto "github.com/gogo/protobuf/proto"
+	flatbuffers "github.com/google/flatbuffers/go"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/tinylib/msgp/msgp"
 	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
 	pb "google.golang.org/protobuf/proto"
 )
 
@@ -21,6 +27,45 @@ type Codec interface {
 	Decode(data []byte, i interface{}) error
 }
 
+// MessageInfo describes the message of a payload for MessageCodec.
+type MessageInfo struct {
+	ServicePath   string
+	ServiceMethod string
+	// Response is true for payloads of responses.
+	Response bool
+	// Metadata is the metadata of the message, which EncodeMessage may replace.
+	Metadata map[string]string
+}
+
+// MessageCodec is a Codec that needs the messages of payloads, for example to resolve schemas by methods.
+// Clients and servers encode and decode payloads of MessageCodecs by EncodeMessage and DecodeMessage.
+type MessageCodec interface {
+	Codec
+	// EncodeMessage encodes i as the payload of m.
+	EncodeMessage(m *MessageInfo, i interface{}) ([]byte, error)
+	// DecodeMessage decodes data, the payload of m, into i.
+	DecodeMessage(m *MessageInfo, data []byte, i interface{}) error
+}
+
+// CopiesData reports whether c is a codec of rpcx that copies data into objects it decodes,
+// so that data can be reused after Decode returns.
+func CopiesData(c Codec) bool {
+	switch c.(type) {
+	case JSONCodec, PBCodec, MsgpackCodec, ThriftCodec, CBORCodec,
+		*JSONCodec, *PBCodec, *MsgpackCodec, *ThriftCodec, *CBORCodec:
+		return true
+	}
+	return false
+}
+
+// PayloadReleaser is a Codec encoding payloads into pooled buffers.
+// Clients return payloads of requests by ReleasePayload after the requests are written.
+type PayloadReleaser interface {
+	Codec
+	// ReleasePayload returns data, which Encode returned, to the pool.
+	ReleasePayload(data []byte)
+}
+
 // ByteCodec uses raw slice pf bytes and don't encode/decode.
 type ByteCodec struct{}
 
@@ -42,26 +87,112 @@ func (c ByteCodec) Decode(data []byte, i interface{}) error {
 	return nil
 }
 
+// JSONOptions are options of decoding JSON payloads.
+type JSONOptions struct {
+	// UseNumber decodes numbers into interface{} values as json.Number instead of float64,
+	// so that large integers such as int64 IDs keep their precision.
+	UseNumber bool
+	// DisallowUnknownFields rejects objects with fields which are not in the structs they are decoded into
+	// by an InvalidArgument error with the "field" detail.
+	DisallowUnknownFields bool
+}
+
+// DefaultJSONOptions are options of JSONCodec.Decode. Servers use other options for services by WithJSONOptions
+// and clients for calls by WithJSONOptions.
+var DefaultJSONOptions = JSONOptions{UseNumber: true}
+
+// JSONDecoder is implemented by codecs which decode JSON by options, such as JSONCodec.
+type JSONDecoder interface {
+	DecodeJSON(data []byte, i interface{}, opts JSONOptions) error
+}
+
 // JSONCodec uses json marshaler and unmarshaler.
+// json.RawMessage and json.Marshaler pass through without being decoded and encoded again,
+// so their JSON is neither validated nor compacted.
 type JSONCodec struct{}
 
 // Encode encodes an object into slice of bytes.
 func (c JSONCodec) Encode(i interface{}) ([]byte, error) {
+	switch v := i.(type) {
+	case json.RawMessage:
+		return rawJSON(v), nil
+	case *json.RawMessage:
+		if v != nil {
+			return rawJSON(*v), nil
+		}
+	case json.Marshaler:
+		if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr || !rv.IsNil() {
+			return v.MarshalJSON()
+		}
+	}
 	return json.Marshal(i)
 }
 
-// Decode decodes an object from slice of bytes.
+func rawJSON(data json.RawMessage) []byte {
+	if len(data) == 0 {
+		return []byte("null")
+	}
+	return data
+}
+
+// Decode decodes an object from slice of bytes by DefaultJSONOptions.
 func (c JSONCodec) Decode(data []byte, i interface{}) error {
+	return c.DecodeJSON(data, i, DefaultJSONOptions)
+}
+
+// DecodeJSON decodes an object from slice of bytes by opts. json.RawMessage gets a copy of data.
+func (c JSONCodec) DecodeJSON(data []byte, i interface{}, opts JSONOptions) error {
+	if raw, ok := i.(*json.RawMessage); ok {
+		*raw = append((*raw)[:0], data...)
+		return nil
+	}
+
 	d := json.NewDecoder(bytes.NewBuffer(data))
-	d.UseNumber()
-	return d.Decode(i)
+	if opts.UseNumber {
+		d.UseNumber()
+	}
+	if opts.DisallowUnknownFields {
+		d.DisallowUnknownFields()
+	}
+	err := d.Decode(i)
+	if err != nil && opts.DisallowUnknownFields {
+		const prefix = "json: unknown field "
+		if msg := err.Error(); strings.HasPrefix(msg, prefix) {
+			field, _ := strconv.Unquote(strings.TrimPrefix(msg, prefix))
+			return rerrors.Errorf(rerrors.InvalidArgument, "rpcx: unknown field %q", field).WithDetail("field", field)
+		}
+	}
+	return err
+}
+
+// vtMarshaler is implemented by messages generated by vtprotobuf.
+type vtMarshaler interface {
+	SizeVT() int
+	MarshalToSizedBufferVT(data []byte) (int, error)
+}
+
+// vtUnmarshaler is implemented by messages generated by vtprotobuf.
+type vtUnmarshaler interface {
+	UnmarshalVT(data []byte) error
 }
 
 // PBCodec uses protobuf marshaler and unmarshaler.
+// Messages generated by vtprotobuf are marshaled and unmarshaled by their generated methods without reflection.
 type PBCodec struct{}
 
 // Encode encodes an object into slice of bytes.
 func (c PBCodec) Encode(i interface{}) ([]byte, error) {
+	if m, ok := i.(vtMarshaler); ok {
+		// sized by SizeVT so that the buffer is not grown
+		size := m.SizeVT()
+		data := make([]byte, size)
+		n, err := m.MarshalToSizedBufferVT(data)
+		if err != nil {
+			return nil, err
+		}
+		return data[size-n:], nil
+	}
+
 	if m, ok := i.(proto.Marshaler); ok {
 		return m.Marshal()
 	}
@@ -75,6 +206,14 @@ func (c PBCodec) Encode(i interface{}) ([]byte, error) {
 
 // Decode decodes an object from slice of bytes.
 func (c PBCodec) Decode(data []byte, i interface{}) error {
+	if m, ok := i.(vtUnmarshaler); ok {
+		// UnmarshalVT merges data into messages, while pb.Unmarshal resets them first
+		if r, ok := i.(pb.Message); ok {
+			pb.Reset(r)
+		}
+		return m.UnmarshalVT(data)
+	}
+
 	if m, ok := i.(proto.Unmarshaler); ok {
 		return m.Unmarshal(data)
 	}
@@ -86,19 +225,119 @@ func (c PBCodec) Decode(data []byte, i interface{}) error {
 	return fmt.Errorf("%T is not a proto.Unmarshaler  or pb.Message", i)
 }
 
+// maxPooledMsgpackBuffer is the capacity of the largest buffer MsgpackCodec keeps for reuse.
+const maxPooledMsgpackBuffer = 4 << 20
+
+// msgpackBuffer is the writer of pooled encoders, which appends to b.
+type msgpackBuffer struct {
+	b []byte
+}
+
+func (w *msgpackBuffer) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+func (w *msgpackBuffer) WriteByte(c byte) error {
+	w.b = append(w.b, c)
+	return nil
+}
+
+type msgpackEncoder struct {
+	buf msgpackBuffer
+	enc *msgpack.Encoder
+}
+
+type msgpackDecoder struct {
+	r   bytes.Reader
+	dec *msgpack.Decoder
+}
+
+var (
+	msgpackEncoders = sync.Pool{
+		New: func() interface{} {
+			e := &msgpackEncoder{}
+			e.enc = msgpack.NewEncoder(&e.buf)
+			return e
+		},
+	}
+	msgpackDecoders = sync.Pool{
+		New: func() interface{} {
+			d := &msgpackDecoder{}
+			d.dec = msgpack.NewDecoder(&d.r)
+			return d
+		},
+	}
+)
+
+// isNil reports whether i is a nil pointer, map or slice, which msgpack encodes as nil instead of calling its methods.
+func isNil(i interface{}) bool {
+	v := reflect.ValueOf(i)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Chan, reflect.Func:
+		return v.IsNil()
+	}
+	return false
+}
+
 // MsgpackCodec uses messagepack marshaler and unmarshaler.
+//
+// Encoders and decoders are pooled, and payloads are encoded into pooled buffers,
+// which can be returned by ReleasePayload. Objects implementing msgpack.CustomEncoder
+// and msgpack.CustomDecoder are encoded and decoded by their methods without reflection.
 type MsgpackCodec struct{}
 
 // Encode encodes an object into slice of bytes.
 func (c MsgpackCodec) Encode(i interface{}) ([]byte, error) {
+	e := msgpackEncoders.Get().(*msgpackEncoder)
+	data, err := e.encode(i)
+	// not deferred, so that encoders are not reused if objects panic while they are encoded
+	msgpackEncoders.Put(e)
+	return data, err
+}
+
+// encode encodes i into the buffer of e and hands the buffer over to the caller.
+func (e *msgpackEncoder) encode(i interface{}) ([]byte, error) {
+	buf := e.buf.b[:0]
+	e.buf.b = nil
+
 	if m, ok := i.(msgp.Marshaler); ok {
-		return m.MarshalMsg(nil)
+		data, err := m.MarshalMsg(buf)
+		if err != nil {
+			e.buf.b = buf
+			return nil, err
+		}
+		return data, nil
+	}
+
+	e.buf.b = buf
+	e.enc.Reset(&e.buf) // custom encoders may have changed the options
+	var err error
+	if m, ok := i.(msgpack.CustomEncoder); ok && !isNil(i) {
+		err = m.EncodeMsgpack(e.enc)
+	} else {
+		// enc.UseJSONTag(true)
+		err = e.enc.Encode(i)
+	}
+	data := e.buf.b
+	if err != nil {
+		e.buf.b = data[:0]
+		return nil, err
+	}
+	e.buf.b = nil
+	return data, nil
+}
+
+// ReleasePayload returns data encoded by Encode to the pool. data must not be used after it is released.
+func (c MsgpackCodec) ReleasePayload(data []byte) {
+	if cap(data) == 0 || cap(data) > maxPooledMsgpackBuffer {
+		return
+	}
+	e := msgpackEncoders.Get().(*msgpackEncoder)
+	if cap(e.buf.b) < cap(data) {
+		e.buf.b = data[:0]
 	}
-	var buf bytes.Buffer
-	enc := msgpack.NewEncoder(&buf)
-	// enc.UseJSONTag(true)
-	err := enc.Encode(i)
-	return buf.Bytes(), err
+	msgpackEncoders.Put(e)
 }
 
 // Decode decodes an object from slice of bytes.
@@ -107,35 +346,171 @@ func (c MsgpackCodec) Decode(data []byte, i interface{}) error {
 		_, err := m.UnmarshalMsg(data)
 		return err
 	}
-	dec := msgpack.NewDecoder(bytes.NewReader(data))
-	// dec.UseJSONTag(true)
-	err := dec.Decode(i)
+
+	d := msgpackDecoders.Get().(*msgpackDecoder)
+	d.r.Reset(data)
+	d.dec.Reset(&d.r)
+	var err error
+	// nil is decoded by the decoder, which sets i to its zero value
+	if m, ok := i.(msgpack.CustomDecoder); ok && len(data) > 0 && data[0] != msgpcode.Nil && !isNil(i) {
+		err = m.DecodeMsgpack(d.dec)
+	} else {
+		// dec.UseJSONTag(true)
+		err = d.dec.Decode(i)
+	}
+	d.r.Reset(nil) // don't keep data in the pool
+	msgpackDecoders.Put(d)
 	return err
 }
 
-type ThriftCodec struct{}
+// ThriftCodec encodes and decodes types implementing thrift.TStruct, such as structs generated by the thrift compiler,
+// by the thrift binary protocol, or the compact protocol if Compact. Serializers and their buffers are pooled.
+type ThriftCodec struct {
+	// Compact uses the thrift compact protocol instead of the binary protocol. Peers must use the same protocol,
+	// so it is set by registering &ThriftCodec{Compact: true} for protocol.Thrift on both sides.
+	Compact bool
+}
+
+var (
+	thriftBinarySerializers    = thrift.NewTSerializerPoolSizeFactory(1024, thrift.NewTBinaryProtocolFactoryDefault())
+	thriftCompactSerializers   = thrift.NewTSerializerPoolSizeFactory(1024, thrift.NewTCompactProtocolFactory())
+	thriftBinaryDeserializers  = thrift.NewTDeserializerPoolSizeFactory(1024, thrift.NewTBinaryProtocolFactoryDefault())
+	thriftCompactDeserializers = thrift.NewTDeserializerPoolSizeFactory(1024, thrift.NewTCompactProtocolFactory())
+)
 
+// Encode encodes i, which must implement thrift.TStruct.
 func (c ThriftCodec) Encode(i interface{}) ([]byte, error) {
-	b := thrift.NewTMemoryBufferLen(1024)
-	p := thrift.NewTBinaryProtocolFactoryDefault().GetProtocol(b)
-	t := &thrift.TSerializer{
-		Transport: b,
-		Protocol:  p,
+	msg, ok := i.(thrift.TStruct)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a thrift.TStruct", i)
 	}
-	t.Transport.Close()
-	if msg, ok := i.(thrift.TStruct); ok {
-		return t.Write(context.Background(), msg)
+	if rv := reflect.ValueOf(msg); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, fmt.Errorf("nil %T can't be encoded by thrift", i)
 	}
-	return nil, errors.New("type assertion failed")
+	if c.Compact {
+		return thriftCompactSerializers.Write(context.Background(), msg)
+	}
+	return thriftBinarySerializers.Write(context.Background(), msg)
 }
 
+// Decode decodes data into i, which must implement thrift.TStruct.
 func (c ThriftCodec) Decode(data []byte, i interface{}) error {
-	t := thrift.NewTMemoryBufferLen(1024)
-	p := thrift.NewTBinaryProtocolFactoryDefault().GetProtocol(t)
-	d := &thrift.TDeserializer{
-		Transport: t,
-		Protocol:  p,
-	}
-	d.Transport.Close()
-	return d.Read(context.Background(), i.(thrift.TStruct), data)
+	msg, ok := i.(thrift.TStruct)
+	if !ok {
+		return fmt.Errorf("%T is not a thrift.TStruct", i)
+	}
+	if c.Compact {
+		return thriftCompactDeserializers.Read(context.Background(), msg, data)
+	}
+	return thriftBinaryDeserializers.Read(context.Background(), msg, data)
+}
+
+var (
+	// times are encoded as RFC 3339 strings of tag 0 to keep nanoseconds and time zones
+	cborEncMode, _    = cbor.EncOptions{Time: cbor.TimeRFC3339Nano, TimeTag: cbor.EncTagRequired}.EncMode()
+	cborDetEncMode, _ = cborDetEncOptions().EncMode()
+	cborDecMode, _    = cbor.DecOptions{}.DecMode()
+)
+
+func cborDetEncOptions() cbor.EncOptions {
+	opts := cbor.CoreDetEncOptions()
+	opts.Time, opts.TimeTag = cbor.TimeRFC3339Nano, cbor.EncTagRequired
+	return opts
+}
+
+// CBORCodec uses CBOR marshaler and unmarshaler. Fields are named by cbor tags, or json tags if they have no cbor tags.
+// []byte are encoded as byte strings and strings as text strings, which must be valid UTF-8.
+// Big integers of math/big are encoded as integers if they fit in 64 bits, or as bignums otherwise.
+//
+// Deterministic encodes by the core deterministic encoding requirements of RFC 8949,
+// so that equal values have equal encodings, for example to sign or hash them.
+type CBORCodec struct {
+	Deterministic bool
+}
+
+// Encode encodes an object into slice of bytes.
+func (c CBORCodec) Encode(i interface{}) ([]byte, error) {
+	if c.Deterministic {
+		return cborDetEncMode.Marshal(i)
+	}
+	return cborEncMode.Marshal(i)
+}
+
+// Decode decodes an object from slice of bytes.
+func (c CBORCodec) Decode(data []byte, i interface{}) error {
+	return cborDecMode.Unmarshal(data, i)
+}
+
+// FlatBuffersPacker is implemented by values packing themselves into FlatBuffers,
+// such as types generated by flatc with --gen-object-api.
+type FlatBuffersPacker interface {
+	// Pack builds the value into b and returns the offset of the root table.
+	Pack(b *flatbuffers.Builder) flatbuffers.UOffsetT
+}
+
+var flatBuffersBuilders = sync.Pool{
+	New: func() interface{} {
+		return flatbuffers.NewBuilder(1024)
+	},
+}
+
+// FlatBuffersCodec encodes and decodes FlatBuffers.
+//
+// Values to encode are FlatBuffersPackers, which are packed by pooled builders, *flatbuffers.Builder whose buffers are finished,
+// root tables generated by flatc, whose buffers are passed through, and []byte.
+//
+// Tables generated by flatc and other flatbuffers.FlatBuffer are decoded without copying: they refer to the payload of the message,
+// so that handlers read fields directly from the received buffer. Args of handlers are only valid until handlers return,
+// since the server reuses the buffers of requests, so handlers copy the fields they keep. Args of handlers calling Detach
+// are copied by the server when the handlers return, so they stay valid. Replies of clients are always valid.
+type FlatBuffersCodec struct{}
+
+// Encode encodes an object into slice of bytes.
+func (c FlatBuffersCodec) Encode(i interface{}) ([]byte, error) {
+	switch v := i.(type) {
+	case FlatBuffersPacker:
+		b := flatBuffersBuilders.Get().(*flatbuffers.Builder)
+		b.Reset()
+		b.Finish(v.Pack(b))
+		// the builder is reused, so its bytes are copied
+		data := append([]byte(nil), b.FinishedBytes()...)
+		flatBuffersBuilders.Put(b)
+		return data, nil
+	case *flatbuffers.Builder:
+		return v.FinishedBytes(), nil
+	case flatbuffers.FlatBuffer:
+		return v.Table().Bytes, nil
+	case []byte:
+		return v, nil
+	case *[]byte:
+		return *v, nil
+	}
+	return nil, fmt.Errorf("%T is not a FlatBuffersPacker, *flatbuffers.Builder, flatbuffers.FlatBuffer or []byte", i)
+}
+
+// Decode decodes an object from slice of bytes. flatbuffers.FlatBuffer refer to data.
+func (c FlatBuffersCodec) Decode(data []byte, i interface{}) error {
+	switch v := i.(type) {
+	case flatbuffers.FlatBuffer:
+		if len(data) < flatbuffers.SizeUOffsetT {
+			return fmt.Errorf("codec: flatbuffers of %d bytes are too short", len(data))
+		}
+		flatbuffers.GetRootAs(data, 0, v)
+		return nil
+	case *[]byte:
+		*v = data
+		return nil
+	}
+	return fmt.Errorf("%T is not a flatbuffers.FlatBuffer or *[]byte", i)
+}
+
+// CopyFlatBuffer makes i refer to a copy of its buffer if it is a flatbuffers.FlatBuffer,
+// so that it is still valid after the buffer is reused.
+func CopyFlatBuffer(i interface{}) {
+	if v, ok := i.(flatbuffers.FlatBuffer); ok {
+		t := v.Table()
+		if t.Bytes != nil {
+			v.Init(append([]byte(nil), t.Bytes...), t.Pos)
+		}
+	}
 }
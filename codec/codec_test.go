@@ -1,8 +1,13 @@
 package codec
 
 import (
+	"encoding/hex"
+	"math/big"
+	"reflect"
 	"testing"
+	"time"
 
+	flatbuffers "github.com/google/flatbuffers/go"
 	"github.com/smallnest/rpcx/codec/testdata"
 )
 
@@ -120,3 +125,278 @@ func BenchmarkThriftCodec_Decode(b *testing.B) {
 		_ = serializer.Decode(bytes, &result)
 	}
 }
+
+func BenchmarkCBORCodec_Encode(b *testing.B) {
+	var raw = make([]byte, 0, 1024)
+	serializer := CBORCodec{}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		raw, _ = serializer.Encode(group)
+	}
+	b.ReportMetric(float64(len(raw)), "bytes")
+}
+
+func BenchmarkCBORCodec_Decode(b *testing.B) {
+	serializer := CBORCodec{}
+	bytes, _ := serializer.Encode(group)
+	result := ColorGroup{}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = serializer.Decode(bytes, &result)
+	}
+}
+
+type cborDevice struct {
+	ID       int                    `cbor:"1,keyasint"`
+	Name     string                 `json:"name"`
+	Firmware []byte                 `cbor:"fw"`
+	Booted   time.Time              `cbor:"booted"`
+	Counter  *big.Int               `cbor:"counter"`
+	Sensors  map[int]map[string]int `cbor:"sensors"`
+	Extra    map[interface{}]interface{}
+	Ignored  string `cbor:"-"`
+}
+
+func TestCBORCodec(t *testing.T) {
+	counter, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	device := cborDevice{
+		ID:       7,
+		Name:     "thermometer",
+		Firmware: []byte{0xde, 0xad, 0xbe, 0xef},
+		Booted:   time.Date(2021, 3, 4, 5, 6, 7, 89, time.FixedZone("", 8*3600)),
+		Counter:  counter,
+		Sensors:  map[int]map[string]int{1: {"celsius": 21}, -2: {"humidity": 40}},
+		Extra:    map[interface{}]interface{}{uint64(1): "one", "two": []byte{2}, true: []interface{}{uint64(3), "3"}},
+		Ignored:  "ignored",
+	}
+
+	for _, c := range []CBORCodec{{}, {Deterministic: true}} {
+		data, err := c.Encode(&device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got cborDevice
+		if err := c.Decode(data, &got); err != nil {
+			t.Fatal(err)
+		}
+
+		if !got.Booted.Equal(device.Booted) || got.Booted.Format(time.RFC3339Nano) != device.Booted.Format(time.RFC3339Nano) {
+			t.Errorf("expect %v but got %v", device.Booted, got.Booted)
+		}
+		if got.Counter.Cmp(device.Counter) != 0 {
+			t.Errorf("expect %v but got %v", device.Counter, got.Counter)
+		}
+		got.Booted, got.Counter = device.Booted, device.Counter
+		expected := device
+		expected.Ignored = ""
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("deterministic %t: expect %+v but got %+v", c.Deterministic, expected, got)
+		}
+
+		var m map[interface{}]interface{}
+		if err := c.Decode(data, &m); err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []interface{}{uint64(1), "name", "fw", "booted", "counter", "sensors", "Extra"} {
+			if _, ok := m[k]; !ok {
+				t.Errorf("expect key %v in %v", k, m)
+			}
+		}
+	}
+}
+
+func TestCBORCodecStrings(t *testing.T) {
+	c := CBORCodec{}
+	data, err := c.Encode([]interface{}{[]byte("ab"), "ab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// byte strings are of major type 2 and text strings of major type 3
+	if expected := "82426162626162"; hex.EncodeToString(data) != expected {
+		t.Fatalf("expect %s but got %x", expected, data)
+	}
+
+	var v []interface{}
+	if err := c.Decode(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v[0].([]byte); !ok {
+		t.Errorf("expect []byte but got %T", v[0])
+	}
+	if _, ok := v[1].(string); !ok {
+		t.Errorf("expect string but got %T", v[1])
+	}
+
+	// text strings must be valid UTF-8
+	var s string
+	if err := c.Decode([]byte{0x62, 0xc3, 0x28}, &s); err == nil {
+		t.Errorf("expect error for invalid UTF-8 but got %q", s)
+	}
+}
+
+// TestCBORCodecVectors checks examples of Appendix A of RFC 8949,
+// so that other implementations can interoperate.
+func TestCBORCodecVectors(t *testing.T) {
+	bigNum, _ := new(big.Int).SetString("18446744073709551616", 10)
+	negBigNum, _ := new(big.Int).SetString("-18446744073709551617", 10)
+	vectors := []struct {
+		value interface{}
+		hex   string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000000, "1a000f4240"},
+		{uint64(18446744073709551615), "1bffffffffffffffff"},
+		{bigNum, "c249010000000000000000"},
+		{negBigNum, "c349010000000000000000"},
+		{-1000, "3903e7"},
+		{0.0, "f90000"},
+		{1.5, "f93e00"},
+		{1.1, "fb3ff199999999999a"},
+		{100000.0, "fa47c35000"},
+		{false, "f4"},
+		{nil, "f6"},
+		{time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC), "c074323031332d30332d32315432303a30343a30305a"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"IETF", "6449455446"},
+		{"ü", "62c3bc"},
+		{[]interface{}{1, []int{2, 3}, []int{4, 5}}, "8301820203820405"},
+		{map[int]int{1: 2, 3: 4}, "a201020304"},
+		{map[string]interface{}{"a": 1, "b": []int{2, 3}}, "a26161016162820203"},
+		{map[string]string{"e": "E", "a": "A", "d": "D", "c": "C", "b": "B"}, "a56161614161626142616361436164614461656145"},
+	}
+
+	c := CBORCodec{Deterministic: true}
+	for _, v := range vectors {
+		data, err := c.Encode(v.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(data) != v.hex {
+			t.Errorf("expect %s for %v but got %x", v.hex, v.value, data)
+		}
+	}
+
+	// epoch-based times
+	data, _ := hex.DecodeString("c11a514b67b0")
+	var tm time.Time
+	if err := c.Decode(data, &tm); err != nil || !tm.Equal(time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)) {
+		t.Errorf("expect 2013-03-21T20:04:00Z but got %v: %v", tm, err)
+	}
+	// indefinite-length strings
+	data, _ = hex.DecodeString("7f657374726561646d696e67ff")
+	var s string
+	if err := c.Decode(data, &s); err != nil || s != "streaming" {
+		t.Errorf("expect streaming but got %q: %v", s, err)
+	}
+}
+
+func BenchmarkFlatBuffersCodec_Encode(b *testing.B) {
+	var raw = make([]byte, 0, 1024)
+	serializer := FlatBuffersCodec{}
+	group := testdata.FlatColorGroupT{
+		Id:     1,
+		Name:   "Reds",
+		Colors: []string{"Crimson", "Red", "Ruby", "Maroon"},
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		raw, _ = serializer.Encode(&group)
+	}
+	b.ReportMetric(float64(len(raw)), "bytes")
+}
+
+// BenchmarkPBCodec_DecodeRead decodes protobuf and reads the fields, to be compared with BenchmarkFlatBuffersCodec_DecodeRead.
+func BenchmarkPBCodec_DecodeRead(b *testing.B) {
+	serializer := PBCodec{}
+	bytes, _ := serializer.Encode(&testdata.ProtoColorGroup{
+		Id:     1,
+		Name:   "Reds",
+		Colors: []string{"Crimson", "Red", "Ruby", "Maroon"},
+	})
+	n := 0
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result := testdata.ProtoColorGroup{}
+		_ = serializer.Decode(bytes, &result)
+		n += int(result.Id) + len(result.Name)
+		for _, c := range result.Colors {
+			n += len(c)
+		}
+	}
+}
+
+func BenchmarkFlatBuffersCodec_DecodeRead(b *testing.B) {
+	serializer := FlatBuffersCodec{}
+	bytes, _ := serializer.Encode(&testdata.FlatColorGroupT{
+		Id:     1,
+		Name:   "Reds",
+		Colors: []string{"Crimson", "Red", "Ruby", "Maroon"},
+	})
+	n := 0
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result := testdata.FlatColorGroup{}
+		_ = serializer.Decode(bytes, &result)
+		n += int(result.Id()) + len(result.Name())
+		for j := 0; j < result.ColorsLength(); j++ {
+			n += len(result.Colors(j))
+		}
+	}
+}
+
+func TestFlatBuffersCodec(t *testing.T) {
+	c := FlatBuffersCodec{}
+	group := &testdata.FlatColorGroupT{Id: 1, Name: "Reds", Colors: []string{"Crimson", "Red"}}
+	data, err := c.Encode(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tables refer to the payload
+	var table testdata.FlatColorGroup
+	if err := c.Decode(data, &table); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(table.UnPack(), group) {
+		t.Fatalf("expect %+v but got %+v", group, table.UnPack())
+	}
+	if &table.Table().Bytes[0] != &data[0] {
+		t.Error("expect the table refers to the payload")
+	}
+
+	// pass-through of tables, builders and bytes
+	passed, err := c.Encode(&table)
+	if err != nil || &passed[0] != &data[0] {
+		t.Errorf("expect the payload passed through but got %v", err)
+	}
+	b := flatbuffers.NewBuilder(0)
+	b.Finish(group.Pack(b))
+	if built, err := c.Encode(b); err != nil || !reflect.DeepEqual(built, data) {
+		t.Errorf("expect %x but got %x: %v", data, built, err)
+	}
+	if raw, err := c.Encode(data); err != nil || &raw[0] != &data[0] {
+		t.Errorf("expect bytes passed through but got %v", err)
+	}
+
+	// copies don't refer to the payload
+	CopyFlatBuffer(&table)
+	for i := range data {
+		data[i] = 0
+	}
+	if !reflect.DeepEqual(table.UnPack(), group) {
+		t.Errorf("expect %+v but got %+v", group, table.UnPack())
+	}
+
+	if _, err := c.Encode(group.Colors); err == nil {
+		t.Error("expect an error for []string")
+	}
+	if err := c.Decode([]byte{1}, &table); err == nil {
+		t.Error("expect an error for short payloads")
+	}
+}
@@ -0,0 +1,104 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+)
+
+func TestJSONCodecNumbers(t *testing.T) {
+	c := JSONCodec{}
+	data := []byte(`{"id":9007199254740993}`)
+
+	// int64 IDs keep their precision in interface{} values by default
+	var v map[string]interface{}
+	if err := c.Decode(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := v["id"].(json.Number); !ok || n.String() != "9007199254740993" {
+		t.Fatalf("unexpected id %#v", v["id"])
+	}
+	if got, err := c.Encode(v); err != nil || !bytes.Equal(data, got) {
+		t.Fatalf("expect %s but got %s: %v", data, got, err)
+	}
+
+	// and are float64 without UseNumber
+	if err := c.DecodeJSON(data, &v, JSONOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := v["id"].(float64); !ok || f != 9007199254740992 {
+		t.Fatalf("unexpected id %#v", v["id"])
+	}
+}
+
+type marshalerTime struct {
+	t time.Time
+}
+
+func (m *marshalerTime) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + m.t.Format("2006-01-02") + `"`), nil
+}
+
+func TestJSONCodecRaw(t *testing.T) {
+	c := JSONCodec{}
+	raw := json.RawMessage(`{"b": [1, 2],  "a": "<x>"}`)
+
+	// raw messages are neither validated nor compacted
+	for _, v := range []interface{}{raw, &raw} {
+		if got, err := c.Encode(v); err != nil || !bytes.Equal(raw, got) {
+			t.Errorf("expect %s but got %s: %v", raw, got, err)
+		}
+	}
+	if got, err := c.Encode((*json.RawMessage)(nil)); err != nil || string(got) != "null" {
+		t.Errorf("expect null but got %s: %v", got, err)
+	}
+
+	// nor decoded, but copied
+	data := append([]byte(nil), raw...)
+	var decoded json.RawMessage
+	if err := c.Decode(data, &decoded); err != nil || !bytes.Equal(raw, decoded) {
+		t.Fatalf("expect %s but got %s: %v", raw, decoded, err)
+	}
+	data[0] = '['
+	if decoded[0] != '{' {
+		t.Error("expect a copy of the payload")
+	}
+
+	// so are marshalers
+	m := &marshalerTime{t: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)}
+	if got, err := c.Encode(m); err != nil || string(got) != `"2021-03-01"` {
+		t.Errorf("unexpected %s: %v", got, err)
+	}
+	if got, err := c.Encode((*marshalerTime)(nil)); err != nil || string(got) != "null" {
+		t.Errorf("expect null but got %s: %v", got, err)
+	}
+}
+
+func TestJSONCodecUnknownFields(t *testing.T) {
+	c := JSONCodec{}
+	data := []byte(`{"id":1,"name":"Reds","shade":"dark"}`)
+
+	var g ColorGroup
+	if err := c.Decode(data, &g); err != nil || g.Name != "Reds" {
+		t.Fatalf("expect unknown fields to be ignored by default but got %v", err)
+	}
+
+	err := c.DecodeJSON(data, &g, JSONOptions{DisallowUnknownFields: true})
+	if !errors.Is(err, rerrors.ErrInvalidArgument) {
+		t.Fatalf("expect an InvalidArgument error but got %v", err)
+	}
+	if err.Error() != `rpcx: unknown field "shade"` || rerrors.DetailsOf(err)["field"] != "shade" {
+		t.Errorf("unexpected error %v with details %v", err, rerrors.DetailsOf(err))
+	}
+
+	// other errors are kept
+	err = c.DecodeJSON([]byte(`{"id":"1"}`), &g, JSONOptions{DisallowUnknownFields: true})
+	var te *json.UnmarshalTypeError
+	if !errors.As(err, &te) {
+		t.Errorf("expect an UnmarshalTypeError but got %v", err)
+	}
+}
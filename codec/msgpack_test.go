@@ -0,0 +1,226 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type msgpackPayload struct {
+	ID     int64             `msgpack:"id"`
+	Name   string            `msgpack:"name"`
+	Tags   []string          `msgpack:"tags"`
+	Labels map[string]string `msgpack:"labels"`
+	Data   []byte            `msgpack:"data"`
+}
+
+func newMsgpackPayload(size int) *msgpackPayload {
+	return &msgpackPayload{
+		ID:     42,
+		Name:   "payload",
+		Tags:   []string{"a", "b", "c"},
+		Labels: map[string]string{"region": "eu"},
+		Data:   bytes.Repeat([]byte{'x'}, size),
+	}
+}
+
+// point encodes itself without reflection.
+type point struct {
+	X, Y int64
+}
+
+func (p *point) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if err := enc.EncodeArrayLen(2); err != nil {
+		return err
+	}
+	if err := enc.EncodeInt(p.X); err != nil {
+		return err
+	}
+	return enc.EncodeInt(p.Y)
+}
+
+func (p *point) DecodeMsgpack(dec *msgpack.Decoder) error {
+	if _, err := dec.DecodeArrayLen(); err != nil {
+		return err
+	}
+	var err error
+	if p.X, err = dec.DecodeInt64(); err != nil {
+		return err
+	}
+	p.Y, err = dec.DecodeInt64()
+	return err
+}
+
+func benchmarkMsgpackEncode(b *testing.B, size int) {
+	serializer := MsgpackCodec{}
+	v := newMsgpackPayload(size)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, _ := serializer.Encode(v)
+		b.SetBytes(int64(len(data)))
+		serializer.ReleasePayload(data)
+	}
+}
+
+func benchmarkMsgpackDecode(b *testing.B, size int) {
+	serializer := MsgpackCodec{}
+	data, _ := serializer.Encode(newMsgpackPayload(size))
+	data = append([]byte(nil), data...)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var v msgpackPayload
+		_ = serializer.Decode(data, &v)
+	}
+}
+
+func BenchmarkMsgpackCodec_EncodeSmall(b *testing.B) { benchmarkMsgpackEncode(b, 137) }
+func BenchmarkMsgpackCodec_EncodeLarge(b *testing.B) { benchmarkMsgpackEncode(b, 1<<20) }
+func BenchmarkMsgpackCodec_DecodeSmall(b *testing.B) { benchmarkMsgpackDecode(b, 137) }
+func BenchmarkMsgpackCodec_DecodeLarge(b *testing.B) { benchmarkMsgpackDecode(b, 1<<20) }
+
+// failedPoint writes a part of a point and fails, like an encoding cancelled by its caller.
+type failedPoint struct {
+	panic bool
+}
+
+func (p *failedPoint) EncodeMsgpack(enc *msgpack.Encoder) error {
+	enc.SetSortMapKeys(true)
+	_ = enc.EncodeArrayLen(2)
+	if p.panic {
+		panic("canceled")
+	}
+	return errors.New("canceled")
+}
+
+// msgpackGolden are payloads encoded by msgpack.NewEncoder, which MsgpackCodec must not change.
+var msgpackGolden = []struct {
+	v    interface{}
+	data string
+}{
+	{nil, "c0"},
+	{true, "c3"},
+	{int64(-1), "d3ffffffffffffffff"},
+	{uint8(200), "ccc8"},
+	{3.5, "cb400c000000000000"},
+	{"rpcx", "a472706378"},
+	{[]byte{1, 2}, "c4020102"},
+	{group, "83a2496401a44e616d65a452656473a6436f6c6f727394a74372696d736f6ea3526564a452756279a64d61726f6f6e"},
+	{&group, "83a2496401a44e616d65a452656473a6436f6c6f727394a74372696d736f6ea3526564a452756279a64d61726f6f6e"},
+	{newMsgpackPayload(4), "85a26964d3000000000000002aa46e616d65a77061796c6f6164a47461677393a161a162a163a66c6162656c7381a6726567696f6ea26575a464617461c40478787878"},
+	{map[string]interface{}{"a": 1}, "81a16101"},
+	{[]interface{}{"x", int8(-3), nil}, "93a178d0fdc0"},
+	{time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC), "d7ff000000185fefe2a5"},
+	{&point{X: 1, Y: -300}, "9201d1fed4"},
+	{(*point)(nil), "c0"},
+}
+
+func TestMsgpackGolden(t *testing.T) {
+	c := MsgpackCodec{}
+	for i := 0; i < 3; i++ { // encoders and buffers are reused
+		for _, g := range msgpackGolden {
+			data, err := c.Encode(g.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(data) != g.data {
+				t.Errorf("%#v: expect %s but got %x", g.v, g.data, data)
+			}
+			var old bytes.Buffer
+			if err := msgpack.NewEncoder(&old).Encode(g.v); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, old.Bytes()) {
+				t.Errorf("%#v: expect %x as msgpack.Encoder but got %x", g.v, old.Bytes(), data)
+			}
+			c.ReleasePayload(data)
+		}
+	}
+}
+
+func TestMsgpackCodecCustom(t *testing.T) {
+	c := MsgpackCodec{}
+	data, err := c.Encode(&point{X: 3, Y: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p point
+	if err := c.Decode(data, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p != (point{X: 3, Y: 4}) {
+		t.Errorf("unexpected %+v", p)
+	}
+
+	// nil is decoded as msgpack.Decoder does
+	p = point{X: 1}
+	if err := c.Decode([]byte{0xc0}, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p != (point{}) {
+		t.Errorf("expect zero point but got %+v", p)
+	}
+	if err := c.Decode(nil, &p); err == nil {
+		t.Error("expect an error for empty data")
+	}
+	if err := c.Decode(data, (*point)(nil)); err == nil {
+		t.Error("expect an error for nil pointers")
+	}
+}
+
+func TestMsgpackCodecPool(t *testing.T) {
+	c := MsgpackCodec{}
+
+	// payloads not released yet are not overwritten
+	v := newMsgpackPayload(64)
+	first, _ := c.Encode(v)
+	kept := append([]byte(nil), first...)
+	for i := 0; i < 10; i++ {
+		data, _ := c.Encode(&point{X: int64(i)})
+		c.ReleasePayload(data)
+	}
+	if !bytes.Equal(first, kept) {
+		t.Fatal("payload is overwritten before it is released")
+	}
+	c.ReleasePayload(first)
+
+	// encodings failed halfway don't leak partial data or options into later encodings
+	for _, panics := range []bool{false, true} {
+		func() {
+			defer func() { _ = recover() }()
+			if _, err := c.Encode(&failedPoint{panic: panics}); err == nil {
+				t.Error("expect an error")
+			}
+		}()
+		for _, g := range msgpackGolden {
+			data, err := c.Encode(g.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(data) != g.data {
+				t.Errorf("%#v: expect %s but got %x", g.v, g.data, data)
+			}
+		}
+	}
+
+	// decoded values don't refer to data in the pool
+	data, _ := c.Encode(v)
+	var decoded msgpackPayload
+	if err := c.Decode(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	c.ReleasePayload(data)
+	c.Encode(bytes.Repeat([]byte{'y'}, 256))
+	if !reflect.DeepEqual(&decoded, v) {
+		t.Errorf("expect %+v but got %+v", v, decoded)
+	}
+}
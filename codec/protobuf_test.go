@@ -0,0 +1,142 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/smallnest/rpcx/codec/testdata"
+	pb "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// reflectMessage hides methods generated by vtprotobuf, so that PBCodec marshals it as plain messages.
+type reflectMessage struct {
+	pb.Message
+}
+
+// newBigMessage returns a message with all 100 fields set.
+func newBigMessage() *testdata.ProtoBigMessage {
+	m := &testdata.ProtoBigMessage{
+		Tags:   []string{"a", "bb", "ccc"},
+		Values: []int64{-1, 0, 1 << 40},
+		Scores: []float64{0.5, 1.5},
+		Group:  &testdata.ProtoBigItem{Id: 1, Name: "Reds", Colors: []string{"Crimson", "Red"}},
+		Groups: []*testdata.ProtoBigItem{{Id: 2, Name: "Blues"}, {Id: 3, Colors: []string{"Navy"}}},
+		Blob:   bytes.Repeat([]byte{0xab}, 256),
+	}
+	r := m.ProtoReflect()
+	fields := r.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.IsList() || fd.Message() != nil || fd.Number() == 100 {
+			continue
+		}
+		n := int64(fd.Number())
+		var v protoreflect.Value
+		switch fd.Kind() {
+		case protoreflect.Int32Kind, protoreflect.Sint32Kind:
+			v = protoreflect.ValueOfInt32(int32(-n * 1000))
+		case protoreflect.Int64Kind:
+			v = protoreflect.ValueOfInt64(n << 33)
+		case protoreflect.Uint64Kind:
+			v = protoreflect.ValueOfUint64(uint64(n) << 40)
+		case protoreflect.Fixed32Kind:
+			v = protoreflect.ValueOfUint32(uint32(n) * 7)
+		case protoreflect.BoolKind:
+			v = protoreflect.ValueOfBool(true)
+		case protoreflect.DoubleKind:
+			v = protoreflect.ValueOfFloat64(float64(n) / 3)
+		case protoreflect.FloatKind:
+			v = protoreflect.ValueOfFloat32(float32(n) / 7)
+		case protoreflect.StringKind:
+			v = protoreflect.ValueOfString(string(fd.Name()) + " value")
+		case protoreflect.BytesKind:
+			v = protoreflect.ValueOfBytes([]byte(fd.Name()))
+		}
+		r.Set(fd, v)
+	}
+	return m
+}
+
+func TestPBCodecVT(t *testing.T) {
+	c := PBCodec{}
+	m := newBigMessage()
+
+	data, err := c.Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := c.Encode(reflectMessage{m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, plain) {
+		t.Fatal("messages marshaled by vtprotobuf are different from the plain codec")
+	}
+
+	// each codec decodes what the other encodes
+	var vt testdata.ProtoBigMessage
+	if err := c.Decode(plain, &vt); err != nil {
+		t.Fatal(err)
+	}
+	if !pb.Equal(&vt, m) {
+		t.Error("vtprotobuf decoded a different message")
+	}
+	re := reflectMessage{&testdata.ProtoBigMessage{}}
+	if err := c.Decode(data, re); err != nil {
+		t.Fatal(err)
+	}
+	if !pb.Equal(re.Message, m) {
+		t.Error("the plain codec decoded a different message")
+	}
+
+	// messages are reset before they are decoded, as proto.Unmarshal does
+	if err := c.Decode(data, &vt); err != nil {
+		t.Fatal(err)
+	}
+	if !pb.Equal(&vt, m) {
+		t.Errorf("expect %d tags but got %d", len(m.Tags), len(vt.Tags))
+	}
+
+	data, err = c.Encode(&testdata.ProtoBigMessage{})
+	if err != nil || len(data) != 0 {
+		t.Errorf("expect empty data for empty messages but got %x, %v", data, err)
+	}
+}
+
+func BenchmarkPBCodec_EncodeBig(b *testing.B) {
+	benchmarkPBEncode(b, newBigMessage())
+}
+
+func BenchmarkPBCodec_EncodeBigPlain(b *testing.B) {
+	benchmarkPBEncode(b, reflectMessage{newBigMessage()})
+}
+
+func BenchmarkPBCodec_DecodeBig(b *testing.B) {
+	benchmarkPBDecode(b, func() interface{} { return &testdata.ProtoBigMessage{} })
+}
+
+func BenchmarkPBCodec_DecodeBigPlain(b *testing.B) {
+	benchmarkPBDecode(b, func() interface{} { return reflectMessage{&testdata.ProtoBigMessage{}} })
+}
+
+func benchmarkPBEncode(b *testing.B, m interface{}) {
+	serializer := PBCodec{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, _ := serializer.Encode(m)
+		b.SetBytes(int64(len(data)))
+	}
+}
+
+func benchmarkPBDecode(b *testing.B, newMessage func() interface{}) {
+	serializer := PBCodec{}
+	data, _ := serializer.Encode(newBigMessage())
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = serializer.Decode(data, newMessage())
+	}
+}
@@ -1,8 +1,12 @@
 # generate .go files from IDL
 protoc --go_out=./ ./protobuf.proto
+PB_BIG=paths=source_relative,Mprotobuf_big.proto=github.com/smallnest/rpcx/codec/testdata
+protoc --go_out=./ --go_opt=$PB_BIG --go-vtproto_out=./ --go-vtproto_opt=$PB_BIG,features=marshal+unmarshal+size ./protobuf_big.proto
 
 thrift -r -out ../ --gen go ./thrift_colorgroup.thrift
 
+flatc --go --gen-object-api -o ../ ./flatbuffers_colorgroup.fbs
+
 # # run benchmarks
 # go test -bench=. -run=none
 
@@ -0,0 +1,1132 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        v3.12.4
+// source: protobuf_big.proto
+
+package testdata
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// ProtoBigMessage has 100 fields to benchmark codecs with large messages.
+type ProtoBigMessage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	F1     int32           `protobuf:"varint,1,opt,name=f1,proto3" json:"f1,omitempty"`
+	F2     int64           `protobuf:"varint,2,opt,name=f2,proto3" json:"f2,omitempty"`
+	F3     uint64          `protobuf:"varint,3,opt,name=f3,proto3" json:"f3,omitempty"`
+	F4     int32           `protobuf:"zigzag32,4,opt,name=f4,proto3" json:"f4,omitempty"`
+	F5     bool            `protobuf:"varint,5,opt,name=f5,proto3" json:"f5,omitempty"`
+	F6     float64         `protobuf:"fixed64,6,opt,name=f6,proto3" json:"f6,omitempty"`
+	F7     string          `protobuf:"bytes,7,opt,name=f7,proto3" json:"f7,omitempty"`
+	F8     []byte          `protobuf:"bytes,8,opt,name=f8,proto3" json:"f8,omitempty"`
+	F9     uint32          `protobuf:"fixed32,9,opt,name=f9,proto3" json:"f9,omitempty"`
+	F10    float32         `protobuf:"fixed32,10,opt,name=f10,proto3" json:"f10,omitempty"`
+	F11    int32           `protobuf:"varint,11,opt,name=f11,proto3" json:"f11,omitempty"`
+	F12    int64           `protobuf:"varint,12,opt,name=f12,proto3" json:"f12,omitempty"`
+	F13    uint64          `protobuf:"varint,13,opt,name=f13,proto3" json:"f13,omitempty"`
+	F14    int32           `protobuf:"zigzag32,14,opt,name=f14,proto3" json:"f14,omitempty"`
+	F15    bool            `protobuf:"varint,15,opt,name=f15,proto3" json:"f15,omitempty"`
+	F16    float64         `protobuf:"fixed64,16,opt,name=f16,proto3" json:"f16,omitempty"`
+	F17    string          `protobuf:"bytes,17,opt,name=f17,proto3" json:"f17,omitempty"`
+	F18    []byte          `protobuf:"bytes,18,opt,name=f18,proto3" json:"f18,omitempty"`
+	F19    uint32          `protobuf:"fixed32,19,opt,name=f19,proto3" json:"f19,omitempty"`
+	F20    float32         `protobuf:"fixed32,20,opt,name=f20,proto3" json:"f20,omitempty"`
+	F21    int32           `protobuf:"varint,21,opt,name=f21,proto3" json:"f21,omitempty"`
+	F22    int64           `protobuf:"varint,22,opt,name=f22,proto3" json:"f22,omitempty"`
+	F23    uint64          `protobuf:"varint,23,opt,name=f23,proto3" json:"f23,omitempty"`
+	F24    int32           `protobuf:"zigzag32,24,opt,name=f24,proto3" json:"f24,omitempty"`
+	F25    bool            `protobuf:"varint,25,opt,name=f25,proto3" json:"f25,omitempty"`
+	F26    float64         `protobuf:"fixed64,26,opt,name=f26,proto3" json:"f26,omitempty"`
+	F27    string          `protobuf:"bytes,27,opt,name=f27,proto3" json:"f27,omitempty"`
+	F28    []byte          `protobuf:"bytes,28,opt,name=f28,proto3" json:"f28,omitempty"`
+	F29    uint32          `protobuf:"fixed32,29,opt,name=f29,proto3" json:"f29,omitempty"`
+	F30    float32         `protobuf:"fixed32,30,opt,name=f30,proto3" json:"f30,omitempty"`
+	F31    int32           `protobuf:"varint,31,opt,name=f31,proto3" json:"f31,omitempty"`
+	F32    int64           `protobuf:"varint,32,opt,name=f32,proto3" json:"f32,omitempty"`
+	F33    uint64          `protobuf:"varint,33,opt,name=f33,proto3" json:"f33,omitempty"`
+	F34    int32           `protobuf:"zigzag32,34,opt,name=f34,proto3" json:"f34,omitempty"`
+	F35    bool            `protobuf:"varint,35,opt,name=f35,proto3" json:"f35,omitempty"`
+	F36    float64         `protobuf:"fixed64,36,opt,name=f36,proto3" json:"f36,omitempty"`
+	F37    string          `protobuf:"bytes,37,opt,name=f37,proto3" json:"f37,omitempty"`
+	F38    []byte          `protobuf:"bytes,38,opt,name=f38,proto3" json:"f38,omitempty"`
+	F39    uint32          `protobuf:"fixed32,39,opt,name=f39,proto3" json:"f39,omitempty"`
+	F40    float32         `protobuf:"fixed32,40,opt,name=f40,proto3" json:"f40,omitempty"`
+	F41    int32           `protobuf:"varint,41,opt,name=f41,proto3" json:"f41,omitempty"`
+	F42    int64           `protobuf:"varint,42,opt,name=f42,proto3" json:"f42,omitempty"`
+	F43    uint64          `protobuf:"varint,43,opt,name=f43,proto3" json:"f43,omitempty"`
+	F44    int32           `protobuf:"zigzag32,44,opt,name=f44,proto3" json:"f44,omitempty"`
+	F45    bool            `protobuf:"varint,45,opt,name=f45,proto3" json:"f45,omitempty"`
+	F46    float64         `protobuf:"fixed64,46,opt,name=f46,proto3" json:"f46,omitempty"`
+	F47    string          `protobuf:"bytes,47,opt,name=f47,proto3" json:"f47,omitempty"`
+	F48    []byte          `protobuf:"bytes,48,opt,name=f48,proto3" json:"f48,omitempty"`
+	F49    uint32          `protobuf:"fixed32,49,opt,name=f49,proto3" json:"f49,omitempty"`
+	F50    float32         `protobuf:"fixed32,50,opt,name=f50,proto3" json:"f50,omitempty"`
+	F51    int32           `protobuf:"varint,51,opt,name=f51,proto3" json:"f51,omitempty"`
+	F52    int64           `protobuf:"varint,52,opt,name=f52,proto3" json:"f52,omitempty"`
+	F53    uint64          `protobuf:"varint,53,opt,name=f53,proto3" json:"f53,omitempty"`
+	F54    int32           `protobuf:"zigzag32,54,opt,name=f54,proto3" json:"f54,omitempty"`
+	F55    bool            `protobuf:"varint,55,opt,name=f55,proto3" json:"f55,omitempty"`
+	F56    float64         `protobuf:"fixed64,56,opt,name=f56,proto3" json:"f56,omitempty"`
+	F57    string          `protobuf:"bytes,57,opt,name=f57,proto3" json:"f57,omitempty"`
+	F58    []byte          `protobuf:"bytes,58,opt,name=f58,proto3" json:"f58,omitempty"`
+	F59    uint32          `protobuf:"fixed32,59,opt,name=f59,proto3" json:"f59,omitempty"`
+	F60    float32         `protobuf:"fixed32,60,opt,name=f60,proto3" json:"f60,omitempty"`
+	F61    int32           `protobuf:"varint,61,opt,name=f61,proto3" json:"f61,omitempty"`
+	F62    int64           `protobuf:"varint,62,opt,name=f62,proto3" json:"f62,omitempty"`
+	F63    uint64          `protobuf:"varint,63,opt,name=f63,proto3" json:"f63,omitempty"`
+	F64    int32           `protobuf:"zigzag32,64,opt,name=f64,proto3" json:"f64,omitempty"`
+	F65    bool            `protobuf:"varint,65,opt,name=f65,proto3" json:"f65,omitempty"`
+	F66    float64         `protobuf:"fixed64,66,opt,name=f66,proto3" json:"f66,omitempty"`
+	F67    string          `protobuf:"bytes,67,opt,name=f67,proto3" json:"f67,omitempty"`
+	F68    []byte          `protobuf:"bytes,68,opt,name=f68,proto3" json:"f68,omitempty"`
+	F69    uint32          `protobuf:"fixed32,69,opt,name=f69,proto3" json:"f69,omitempty"`
+	F70    float32         `protobuf:"fixed32,70,opt,name=f70,proto3" json:"f70,omitempty"`
+	F71    int32           `protobuf:"varint,71,opt,name=f71,proto3" json:"f71,omitempty"`
+	F72    int64           `protobuf:"varint,72,opt,name=f72,proto3" json:"f72,omitempty"`
+	F73    uint64          `protobuf:"varint,73,opt,name=f73,proto3" json:"f73,omitempty"`
+	F74    int32           `protobuf:"zigzag32,74,opt,name=f74,proto3" json:"f74,omitempty"`
+	F75    bool            `protobuf:"varint,75,opt,name=f75,proto3" json:"f75,omitempty"`
+	F76    float64         `protobuf:"fixed64,76,opt,name=f76,proto3" json:"f76,omitempty"`
+	F77    string          `protobuf:"bytes,77,opt,name=f77,proto3" json:"f77,omitempty"`
+	F78    []byte          `protobuf:"bytes,78,opt,name=f78,proto3" json:"f78,omitempty"`
+	F79    uint32          `protobuf:"fixed32,79,opt,name=f79,proto3" json:"f79,omitempty"`
+	F80    float32         `protobuf:"fixed32,80,opt,name=f80,proto3" json:"f80,omitempty"`
+	F81    int32           `protobuf:"varint,81,opt,name=f81,proto3" json:"f81,omitempty"`
+	F82    int64           `protobuf:"varint,82,opt,name=f82,proto3" json:"f82,omitempty"`
+	F83    uint64          `protobuf:"varint,83,opt,name=f83,proto3" json:"f83,omitempty"`
+	F84    int32           `protobuf:"zigzag32,84,opt,name=f84,proto3" json:"f84,omitempty"`
+	F85    bool            `protobuf:"varint,85,opt,name=f85,proto3" json:"f85,omitempty"`
+	F86    float64         `protobuf:"fixed64,86,opt,name=f86,proto3" json:"f86,omitempty"`
+	F87    string          `protobuf:"bytes,87,opt,name=f87,proto3" json:"f87,omitempty"`
+	F88    []byte          `protobuf:"bytes,88,opt,name=f88,proto3" json:"f88,omitempty"`
+	F89    uint32          `protobuf:"fixed32,89,opt,name=f89,proto3" json:"f89,omitempty"`
+	F90    float32         `protobuf:"fixed32,90,opt,name=f90,proto3" json:"f90,omitempty"`
+	F91    int32           `protobuf:"varint,91,opt,name=f91,proto3" json:"f91,omitempty"`
+	F92    int64           `protobuf:"varint,92,opt,name=f92,proto3" json:"f92,omitempty"`
+	F93    uint64          `protobuf:"varint,93,opt,name=f93,proto3" json:"f93,omitempty"`
+	F94    int32           `protobuf:"zigzag32,94,opt,name=f94,proto3" json:"f94,omitempty"`
+	Tags   []string        `protobuf:"bytes,95,rep,name=tags,proto3" json:"tags,omitempty"`
+	Values []int64         `protobuf:"varint,96,rep,packed,name=values,proto3" json:"values,omitempty"`
+	Scores []float64       `protobuf:"fixed64,97,rep,packed,name=scores,proto3" json:"scores,omitempty"`
+	Group  *ProtoBigItem   `protobuf:"bytes,98,opt,name=group,proto3" json:"group,omitempty"`
+	Groups []*ProtoBigItem `protobuf:"bytes,99,rep,name=groups,proto3" json:"groups,omitempty"`
+	Blob   []byte          `protobuf:"bytes,100,opt,name=blob,proto3" json:"blob,omitempty"`
+}
+
+func (x *ProtoBigMessage) Reset() {
+	*x = ProtoBigMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_protobuf_big_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ProtoBigMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProtoBigMessage) ProtoMessage() {}
+
+func (x *ProtoBigMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_protobuf_big_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProtoBigMessage.ProtoReflect.Descriptor instead.
+func (*ProtoBigMessage) Descriptor() ([]byte, []int) {
+	return file_protobuf_big_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ProtoBigMessage) GetF1() int32 {
+	if x != nil {
+		return x.F1
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF2() int64 {
+	if x != nil {
+		return x.F2
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF3() uint64 {
+	if x != nil {
+		return x.F3
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF4() int32 {
+	if x != nil {
+		return x.F4
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF5() bool {
+	if x != nil {
+		return x.F5
+	}
+	return false
+}
+
+func (x *ProtoBigMessage) GetF6() float64 {
+	if x != nil {
+		return x.F6
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF7() string {
+	if x != nil {
+		return x.F7
+	}
+	return ""
+}
+
+func (x *ProtoBigMessage) GetF8() []byte {
+	if x != nil {
+		return x.F8
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetF9() uint32 {
+	if x != nil {
+		return x.F9
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF10() float32 {
+	if x != nil {
+		return x.F10
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF11() int32 {
+	if x != nil {
+		return x.F11
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF12() int64 {
+	if x != nil {
+		return x.F12
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF13() uint64 {
+	if x != nil {
+		return x.F13
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF14() int32 {
+	if x != nil {
+		return x.F14
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF15() bool {
+	if x != nil {
+		return x.F15
+	}
+	return false
+}
+
+func (x *ProtoBigMessage) GetF16() float64 {
+	if x != nil {
+		return x.F16
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF17() string {
+	if x != nil {
+		return x.F17
+	}
+	return ""
+}
+
+func (x *ProtoBigMessage) GetF18() []byte {
+	if x != nil {
+		return x.F18
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetF19() uint32 {
+	if x != nil {
+		return x.F19
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF20() float32 {
+	if x != nil {
+		return x.F20
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF21() int32 {
+	if x != nil {
+		return x.F21
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF22() int64 {
+	if x != nil {
+		return x.F22
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF23() uint64 {
+	if x != nil {
+		return x.F23
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF24() int32 {
+	if x != nil {
+		return x.F24
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF25() bool {
+	if x != nil {
+		return x.F25
+	}
+	return false
+}
+
+func (x *ProtoBigMessage) GetF26() float64 {
+	if x != nil {
+		return x.F26
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF27() string {
+	if x != nil {
+		return x.F27
+	}
+	return ""
+}
+
+func (x *ProtoBigMessage) GetF28() []byte {
+	if x != nil {
+		return x.F28
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetF29() uint32 {
+	if x != nil {
+		return x.F29
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF30() float32 {
+	if x != nil {
+		return x.F30
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF31() int32 {
+	if x != nil {
+		return x.F31
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF32() int64 {
+	if x != nil {
+		return x.F32
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF33() uint64 {
+	if x != nil {
+		return x.F33
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF34() int32 {
+	if x != nil {
+		return x.F34
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF35() bool {
+	if x != nil {
+		return x.F35
+	}
+	return false
+}
+
+func (x *ProtoBigMessage) GetF36() float64 {
+	if x != nil {
+		return x.F36
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF37() string {
+	if x != nil {
+		return x.F37
+	}
+	return ""
+}
+
+func (x *ProtoBigMessage) GetF38() []byte {
+	if x != nil {
+		return x.F38
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetF39() uint32 {
+	if x != nil {
+		return x.F39
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF40() float32 {
+	if x != nil {
+		return x.F40
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF41() int32 {
+	if x != nil {
+		return x.F41
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF42() int64 {
+	if x != nil {
+		return x.F42
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF43() uint64 {
+	if x != nil {
+		return x.F43
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF44() int32 {
+	if x != nil {
+		return x.F44
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF45() bool {
+	if x != nil {
+		return x.F45
+	}
+	return false
+}
+
+func (x *ProtoBigMessage) GetF46() float64 {
+	if x != nil {
+		return x.F46
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF47() string {
+	if x != nil {
+		return x.F47
+	}
+	return ""
+}
+
+func (x *ProtoBigMessage) GetF48() []byte {
+	if x != nil {
+		return x.F48
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetF49() uint32 {
+	if x != nil {
+		return x.F49
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF50() float32 {
+	if x != nil {
+		return x.F50
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF51() int32 {
+	if x != nil {
+		return x.F51
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF52() int64 {
+	if x != nil {
+		return x.F52
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF53() uint64 {
+	if x != nil {
+		return x.F53
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF54() int32 {
+	if x != nil {
+		return x.F54
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF55() bool {
+	if x != nil {
+		return x.F55
+	}
+	return false
+}
+
+func (x *ProtoBigMessage) GetF56() float64 {
+	if x != nil {
+		return x.F56
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF57() string {
+	if x != nil {
+		return x.F57
+	}
+	return ""
+}
+
+func (x *ProtoBigMessage) GetF58() []byte {
+	if x != nil {
+		return x.F58
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetF59() uint32 {
+	if x != nil {
+		return x.F59
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF60() float32 {
+	if x != nil {
+		return x.F60
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF61() int32 {
+	if x != nil {
+		return x.F61
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF62() int64 {
+	if x != nil {
+		return x.F62
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF63() uint64 {
+	if x != nil {
+		return x.F63
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF64() int32 {
+	if x != nil {
+		return x.F64
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF65() bool {
+	if x != nil {
+		return x.F65
+	}
+	return false
+}
+
+func (x *ProtoBigMessage) GetF66() float64 {
+	if x != nil {
+		return x.F66
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF67() string {
+	if x != nil {
+		return x.F67
+	}
+	return ""
+}
+
+func (x *ProtoBigMessage) GetF68() []byte {
+	if x != nil {
+		return x.F68
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetF69() uint32 {
+	if x != nil {
+		return x.F69
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF70() float32 {
+	if x != nil {
+		return x.F70
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF71() int32 {
+	if x != nil {
+		return x.F71
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF72() int64 {
+	if x != nil {
+		return x.F72
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF73() uint64 {
+	if x != nil {
+		return x.F73
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF74() int32 {
+	if x != nil {
+		return x.F74
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF75() bool {
+	if x != nil {
+		return x.F75
+	}
+	return false
+}
+
+func (x *ProtoBigMessage) GetF76() float64 {
+	if x != nil {
+		return x.F76
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF77() string {
+	if x != nil {
+		return x.F77
+	}
+	return ""
+}
+
+func (x *ProtoBigMessage) GetF78() []byte {
+	if x != nil {
+		return x.F78
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetF79() uint32 {
+	if x != nil {
+		return x.F79
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF80() float32 {
+	if x != nil {
+		return x.F80
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF81() int32 {
+	if x != nil {
+		return x.F81
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF82() int64 {
+	if x != nil {
+		return x.F82
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF83() uint64 {
+	if x != nil {
+		return x.F83
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF84() int32 {
+	if x != nil {
+		return x.F84
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF85() bool {
+	if x != nil {
+		return x.F85
+	}
+	return false
+}
+
+func (x *ProtoBigMessage) GetF86() float64 {
+	if x != nil {
+		return x.F86
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF87() string {
+	if x != nil {
+		return x.F87
+	}
+	return ""
+}
+
+func (x *ProtoBigMessage) GetF88() []byte {
+	if x != nil {
+		return x.F88
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetF89() uint32 {
+	if x != nil {
+		return x.F89
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF90() float32 {
+	if x != nil {
+		return x.F90
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF91() int32 {
+	if x != nil {
+		return x.F91
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF92() int64 {
+	if x != nil {
+		return x.F92
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF93() uint64 {
+	if x != nil {
+		return x.F93
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetF94() int32 {
+	if x != nil {
+		return x.F94
+	}
+	return 0
+}
+
+func (x *ProtoBigMessage) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetValues() []int64 {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetScores() []float64 {
+	if x != nil {
+		return x.Scores
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetGroup() *ProtoBigItem {
+	if x != nil {
+		return x.Group
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetGroups() []*ProtoBigItem {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+func (x *ProtoBigMessage) GetBlob() []byte {
+	if x != nil {
+		return x.Blob
+	}
+	return nil
+}
+
+type ProtoBigItem struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id     int32    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name   string   `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Colors []string `protobuf:"bytes,3,rep,name=colors,proto3" json:"colors,omitempty"`
+}
+
+func (x *ProtoBigItem) Reset() {
+	*x = ProtoBigItem{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_protobuf_big_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ProtoBigItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProtoBigItem) ProtoMessage() {}
+
+func (x *ProtoBigItem) ProtoReflect() protoreflect.Message {
+	mi := &file_protobuf_big_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProtoBigItem.ProtoReflect.Descriptor instead.
+func (*ProtoBigItem) Descriptor() ([]byte, []int) {
+	return file_protobuf_big_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ProtoBigItem) GetId() int32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *ProtoBigItem) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ProtoBigItem) GetColors() []string {
+	if x != nil {
+		return x.Colors
+	}
+	return nil
+}
+
+var File_protobuf_big_proto protoreflect.FileDescriptor
+
+var file_protobuf_big_proto_rawDesc = []byte{
+	0x0a, 0x12, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x5f, 0x62, 0x69, 0x67, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x12, 0x08, 0x74, 0x65, 0x73, 0x74, 0x64, 0x61, 0x74, 0x61, 0x22, 0xd1,
+	0x0e, 0x0a, 0x0f, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x42, 0x69, 0x67, 0x4d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x66, 0x31, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x02,
+	0x66, 0x31, 0x12, 0x0e, 0x0a, 0x02, 0x66, 0x32, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02,
+	0x66, 0x32, 0x12, 0x0e, 0x0a, 0x02, 0x66, 0x33, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x02,
+	0x66, 0x33, 0x12, 0x0e, 0x0a, 0x02, 0x66, 0x34, 0x18, 0x04, 0x20, 0x01, 0x28, 0x11, 0x52, 0x02,
+	0x66, 0x34, 0x12, 0x0e, 0x0a, 0x02, 0x66, 0x35, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x02,
+	0x66, 0x35, 0x12, 0x0e, 0x0a, 0x02, 0x66, 0x36, 0x18, 0x06, 0x20, 0x01, 0x28, 0x01, 0x52, 0x02,
+	0x66, 0x36, 0x12, 0x0e, 0x0a, 0x02, 0x66, 0x37, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02,
+	0x66, 0x37, 0x12, 0x0e, 0x0a, 0x02, 0x66, 0x38, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x02,
+	0x66, 0x38, 0x12, 0x0e, 0x0a, 0x02, 0x66, 0x39, 0x18, 0x09, 0x20, 0x01, 0x28, 0x07, 0x52, 0x02,
+	0x66, 0x39, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x31, 0x30, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x02, 0x52,
+	0x03, 0x66, 0x31, 0x30, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x31, 0x31, 0x18, 0x0b, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x03, 0x66, 0x31, 0x31, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x31, 0x32, 0x18, 0x0c, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x03, 0x66, 0x31, 0x32, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x31, 0x33, 0x18,
+	0x0d, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x66, 0x31, 0x33, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x31,
+	0x34, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x11, 0x52, 0x03, 0x66, 0x31, 0x34, 0x12, 0x10, 0x0a, 0x03,
+	0x66, 0x31, 0x35, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x66, 0x31, 0x35, 0x12, 0x10,
+	0x0a, 0x03, 0x66, 0x31, 0x36, 0x18, 0x10, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x66, 0x31, 0x36,
+	0x12, 0x10, 0x0a, 0x03, 0x66, 0x31, 0x37, 0x18, 0x11, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x66,
+	0x31, 0x37, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x31, 0x38, 0x18, 0x12, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x03, 0x66, 0x31, 0x38, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x31, 0x39, 0x18, 0x13, 0x20, 0x01, 0x28,
+	0x07, 0x52, 0x03, 0x66, 0x31, 0x39, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x32, 0x30, 0x18, 0x14, 0x20,
+	0x01, 0x28, 0x02, 0x52, 0x03, 0x66, 0x32, 0x30, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x32, 0x31, 0x18,
+	0x15, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x66, 0x32, 0x31, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x32,
+	0x32, 0x18, 0x16, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x66, 0x32, 0x32, 0x12, 0x10, 0x0a, 0x03,
+	0x66, 0x32, 0x33, 0x18, 0x17, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x66, 0x32, 0x33, 0x12, 0x10,
+	0x0a, 0x03, 0x66, 0x32, 0x34, 0x18, 0x18, 0x20, 0x01, 0x28, 0x11, 0x52, 0x03, 0x66, 0x32, 0x34,
+	0x12, 0x10, 0x0a, 0x03, 0x66, 0x32, 0x35, 0x18, 0x19, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x66,
+	0x32, 0x35, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x32, 0x36, 0x18, 0x1a, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x03, 0x66, 0x32, 0x36, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x32, 0x37, 0x18, 0x1b, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x03, 0x66, 0x32, 0x37, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x32, 0x38, 0x18, 0x1c, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x03, 0x66, 0x32, 0x38, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x32, 0x39, 0x18,
+	0x1d, 0x20, 0x01, 0x28, 0x07, 0x52, 0x03, 0x66, 0x32, 0x39, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x33,
+	0x30, 0x18, 0x1e, 0x20, 0x01, 0x28, 0x02, 0x52, 0x03, 0x66, 0x33, 0x30, 0x12, 0x10, 0x0a, 0x03,
+	0x66, 0x33, 0x31, 0x18, 0x1f, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x66, 0x33, 0x31, 0x12, 0x10,
+	0x0a, 0x03, 0x66, 0x33, 0x32, 0x18, 0x20, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x66, 0x33, 0x32,
+	0x12, 0x10, 0x0a, 0x03, 0x66, 0x33, 0x33, 0x18, 0x21, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x66,
+	0x33, 0x33, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x33, 0x34, 0x18, 0x22, 0x20, 0x01, 0x28, 0x11, 0x52,
+	0x03, 0x66, 0x33, 0x34, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x33, 0x35, 0x18, 0x23, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x03, 0x66, 0x33, 0x35, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x33, 0x36, 0x18, 0x24, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x03, 0x66, 0x33, 0x36, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x33, 0x37, 0x18,
+	0x25, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x66, 0x33, 0x37, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x33,
+	0x38, 0x18, 0x26, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x66, 0x33, 0x38, 0x12, 0x10, 0x0a, 0x03,
+	0x66, 0x33, 0x39, 0x18, 0x27, 0x20, 0x01, 0x28, 0x07, 0x52, 0x03, 0x66, 0x33, 0x39, 0x12, 0x10,
+	0x0a, 0x03, 0x66, 0x34, 0x30, 0x18, 0x28, 0x20, 0x01, 0x28, 0x02, 0x52, 0x03, 0x66, 0x34, 0x30,
+	0x12, 0x10, 0x0a, 0x03, 0x66, 0x34, 0x31, 0x18, 0x29, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x66,
+	0x34, 0x31, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x34, 0x32, 0x18, 0x2a, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x03, 0x66, 0x34, 0x32, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x34, 0x33, 0x18, 0x2b, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x03, 0x66, 0x34, 0x33, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x34, 0x34, 0x18, 0x2c, 0x20,
+	0x01, 0x28, 0x11, 0x52, 0x03, 0x66, 0x34, 0x34, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x34, 0x35, 0x18,
+	0x2d, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x66, 0x34, 0x35, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x34,
+	0x36, 0x18, 0x2e, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x66, 0x34, 0x36, 0x12, 0x10, 0x0a, 0x03,
+	0x66, 0x34, 0x37, 0x18, 0x2f, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x66, 0x34, 0x37, 0x12, 0x10,
+	0x0a, 0x03, 0x66, 0x34, 0x38, 0x18, 0x30, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x66, 0x34, 0x38,
+	0x12, 0x10, 0x0a, 0x03, 0x66, 0x34, 0x39, 0x18, 0x31, 0x20, 0x01, 0x28, 0x07, 0x52, 0x03, 0x66,
+	0x34, 0x39, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x35, 0x30, 0x18, 0x32, 0x20, 0x01, 0x28, 0x02, 0x52,
+	0x03, 0x66, 0x35, 0x30, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x35, 0x31, 0x18, 0x33, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x03, 0x66, 0x35, 0x31, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x35, 0x32, 0x18, 0x34, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x03, 0x66, 0x35, 0x32, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x35, 0x33, 0x18,
+	0x35, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x66, 0x35, 0x33, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x35,
+	0x34, 0x18, 0x36, 0x20, 0x01, 0x28, 0x11, 0x52, 0x03, 0x66, 0x35, 0x34, 0x12, 0x10, 0x0a, 0x03,
+	0x66, 0x35, 0x35, 0x18, 0x37, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x66, 0x35, 0x35, 0x12, 0x10,
+	0x0a, 0x03, 0x66, 0x35, 0x36, 0x18, 0x38, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x66, 0x35, 0x36,
+	0x12, 0x10, 0x0a, 0x03, 0x66, 0x35, 0x37, 0x18, 0x39, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x66,
+	0x35, 0x37, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x35, 0x38, 0x18, 0x3a, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x03, 0x66, 0x35, 0x38, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x35, 0x39, 0x18, 0x3b, 0x20, 0x01, 0x28,
+	0x07, 0x52, 0x03, 0x66, 0x35, 0x39, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x36, 0x30, 0x18, 0x3c, 0x20,
+	0x01, 0x28, 0x02, 0x52, 0x03, 0x66, 0x36, 0x30, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x36, 0x31, 0x18,
+	0x3d, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x66, 0x36, 0x31, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x36,
+	0x32, 0x18, 0x3e, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x66, 0x36, 0x32, 0x12, 0x10, 0x0a, 0x03,
+	0x66, 0x36, 0x33, 0x18, 0x3f, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x66, 0x36, 0x33, 0x12, 0x10,
+	0x0a, 0x03, 0x66, 0x36, 0x34, 0x18, 0x40, 0x20, 0x01, 0x28, 0x11, 0x52, 0x03, 0x66, 0x36, 0x34,
+	0x12, 0x10, 0x0a, 0x03, 0x66, 0x36, 0x35, 0x18, 0x41, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x66,
+	0x36, 0x35, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x36, 0x36, 0x18, 0x42, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x03, 0x66, 0x36, 0x36, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x36, 0x37, 0x18, 0x43, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x03, 0x66, 0x36, 0x37, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x36, 0x38, 0x18, 0x44, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x03, 0x66, 0x36, 0x38, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x36, 0x39, 0x18,
+	0x45, 0x20, 0x01, 0x28, 0x07, 0x52, 0x03, 0x66, 0x36, 0x39, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x37,
+	0x30, 0x18, 0x46, 0x20, 0x01, 0x28, 0x02, 0x52, 0x03, 0x66, 0x37, 0x30, 0x12, 0x10, 0x0a, 0x03,
+	0x66, 0x37, 0x31, 0x18, 0x47, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x66, 0x37, 0x31, 0x12, 0x10,
+	0x0a, 0x03, 0x66, 0x37, 0x32, 0x18, 0x48, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x66, 0x37, 0x32,
+	0x12, 0x10, 0x0a, 0x03, 0x66, 0x37, 0x33, 0x18, 0x49, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x66,
+	0x37, 0x33, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x37, 0x34, 0x18, 0x4a, 0x20, 0x01, 0x28, 0x11, 0x52,
+	0x03, 0x66, 0x37, 0x34, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x37, 0x35, 0x18, 0x4b, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x03, 0x66, 0x37, 0x35, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x37, 0x36, 0x18, 0x4c, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x03, 0x66, 0x37, 0x36, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x37, 0x37, 0x18,
+	0x4d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x66, 0x37, 0x37, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x37,
+	0x38, 0x18, 0x4e, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x66, 0x37, 0x38, 0x12, 0x10, 0x0a, 0x03,
+	0x66, 0x37, 0x39, 0x18, 0x4f, 0x20, 0x01, 0x28, 0x07, 0x52, 0x03, 0x66, 0x37, 0x39, 0x12, 0x10,
+	0x0a, 0x03, 0x66, 0x38, 0x30, 0x18, 0x50, 0x20, 0x01, 0x28, 0x02, 0x52, 0x03, 0x66, 0x38, 0x30,
+	0x12, 0x10, 0x0a, 0x03, 0x66, 0x38, 0x31, 0x18, 0x51, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x66,
+	0x38, 0x31, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x38, 0x32, 0x18, 0x52, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x03, 0x66, 0x38, 0x32, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x38, 0x33, 0x18, 0x53, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x03, 0x66, 0x38, 0x33, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x38, 0x34, 0x18, 0x54, 0x20,
+	0x01, 0x28, 0x11, 0x52, 0x03, 0x66, 0x38, 0x34, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x38, 0x35, 0x18,
+	0x55, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x66, 0x38, 0x35, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x38,
+	0x36, 0x18, 0x56, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x66, 0x38, 0x36, 0x12, 0x10, 0x0a, 0x03,
+	0x66, 0x38, 0x37, 0x18, 0x57, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x66, 0x38, 0x37, 0x12, 0x10,
+	0x0a, 0x03, 0x66, 0x38, 0x38, 0x18, 0x58, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x66, 0x38, 0x38,
+	0x12, 0x10, 0x0a, 0x03, 0x66, 0x38, 0x39, 0x18, 0x59, 0x20, 0x01, 0x28, 0x07, 0x52, 0x03, 0x66,
+	0x38, 0x39, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x39, 0x30, 0x18, 0x5a, 0x20, 0x01, 0x28, 0x02, 0x52,
+	0x03, 0x66, 0x39, 0x30, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x39, 0x31, 0x18, 0x5b, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x03, 0x66, 0x39, 0x31, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x39, 0x32, 0x18, 0x5c, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x03, 0x66, 0x39, 0x32, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x39, 0x33, 0x18,
+	0x5d, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x66, 0x39, 0x33, 0x12, 0x10, 0x0a, 0x03, 0x66, 0x39,
+	0x34, 0x18, 0x5e, 0x20, 0x01, 0x28, 0x11, 0x52, 0x03, 0x66, 0x39, 0x34, 0x12, 0x12, 0x0a, 0x04,
+	0x74, 0x61, 0x67, 0x73, 0x18, 0x5f, 0x20, 0x03, 0x28, 0x09, 0x52, 0x04, 0x74, 0x61, 0x67, 0x73,
+	0x12, 0x16, 0x0a, 0x06, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x18, 0x60, 0x20, 0x03, 0x28, 0x03,
+	0x52, 0x06, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x63, 0x6f, 0x72,
+	0x65, 0x73, 0x18, 0x61, 0x20, 0x03, 0x28, 0x01, 0x52, 0x06, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x73,
+	0x12, 0x2c, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x62, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x16, 0x2e, 0x74, 0x65, 0x73, 0x74, 0x64, 0x61, 0x74, 0x61, 0x2e, 0x50, 0x72, 0x6f, 0x74, 0x6f,
+	0x42, 0x69, 0x67, 0x49, 0x74, 0x65, 0x6d, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x2e,
+	0x0a, 0x06, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x73, 0x18, 0x63, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x16,
+	0x2e, 0x74, 0x65, 0x73, 0x74, 0x64, 0x61, 0x74, 0x61, 0x2e, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x42,
+	0x69, 0x67, 0x49, 0x74, 0x65, 0x6d, 0x52, 0x06, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x73, 0x12, 0x12,
+	0x0a, 0x04, 0x62, 0x6c, 0x6f, 0x62, 0x18, 0x64, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x62, 0x6c,
+	0x6f, 0x62, 0x22, 0x4a, 0x0a, 0x0c, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x42, 0x69, 0x67, 0x49, 0x74,
+	0x65, 0x6d, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x02,
+	0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x6f, 0x6c, 0x6f, 0x72, 0x73,
+	0x18, 0x03, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x63, 0x6f, 0x6c, 0x6f, 0x72, 0x73, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_protobuf_big_proto_rawDescOnce sync.Once
+	file_protobuf_big_proto_rawDescData = file_protobuf_big_proto_rawDesc
+)
+
+func file_protobuf_big_proto_rawDescGZIP() []byte {
+	file_protobuf_big_proto_rawDescOnce.Do(func() {
+		file_protobuf_big_proto_rawDescData = protoimpl.X.CompressGZIP(file_protobuf_big_proto_rawDescData)
+	})
+	return file_protobuf_big_proto_rawDescData
+}
+
+var file_protobuf_big_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_protobuf_big_proto_goTypes = []interface{}{
+	(*ProtoBigMessage)(nil), // 0: testdata.ProtoBigMessage
+	(*ProtoBigItem)(nil),    // 1: testdata.ProtoBigItem
+}
+var file_protobuf_big_proto_depIdxs = []int32{
+	1, // 0: testdata.ProtoBigMessage.group:type_name -> testdata.ProtoBigItem
+	1, // 1: testdata.ProtoBigMessage.groups:type_name -> testdata.ProtoBigItem
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_protobuf_big_proto_init() }
+func file_protobuf_big_proto_init() {
+	if File_protobuf_big_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_protobuf_big_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ProtoBigMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_protobuf_big_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ProtoBigItem); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_protobuf_big_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_protobuf_big_proto_goTypes,
+		DependencyIndexes: file_protobuf_big_proto_depIdxs,
+		MessageInfos:      file_protobuf_big_proto_msgTypes,
+	}.Build()
+	File_protobuf_big_proto = out.File
+	file_protobuf_big_proto_rawDesc = nil
+	file_protobuf_big_proto_goTypes = nil
+	file_protobuf_big_proto_depIdxs = nil
+}
@@ -0,0 +1,101 @@
+package codec
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/smallnest/rpcx/codec/testdata"
+)
+
+func newThriftGroup() *testdata.ThriftColorGroup {
+	return &testdata.ThriftColorGroup{ID: 1, Name: "Reds", Colors: []string{"Crimson", "Red"}}
+}
+
+func TestThriftCodec(t *testing.T) {
+	// bytes of the official serializers
+	binary := thrift.NewTSerializer()
+	memory := thrift.NewTMemoryBuffer()
+	compact := &thrift.TSerializer{Transport: memory, Protocol: thrift.NewTCompactProtocol(memory)}
+
+	for _, tt := range []struct {
+		codec    ThriftCodec
+		official *thrift.TSerializer
+	}{
+		{ThriftCodec{}, binary},
+		{ThriftCodec{Compact: true}, compact},
+	} {
+		expected, err := tt.official.Write(context.Background(), newThriftGroup())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := tt.codec.Encode(newThriftGroup())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, data) {
+			t.Errorf("expect %x but got %x with compact %t", expected, data, tt.codec.Compact)
+		}
+
+		got := testdata.NewThriftColorGroup()
+		if err := tt.codec.Decode(data, got); err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equals(newThriftGroup()) {
+			t.Errorf("expect %v but got %v", newThriftGroup(), got)
+		}
+	}
+
+	// the binary protocol of i32 id, string name and list<string> colors
+	data, _ := ThriftCodec{}.Encode(newThriftGroup())
+	expected := "080001" + "00000001" + // field 1, i32
+		"0b0002" + "00000004" + "52656473" + // field 2, string
+		"0f0003" + "0b" + "00000002" + "00000007" + "4372696d736f6e" + "00000003" + "526564" + // field 3, list<string>
+		"00" // stop
+	if h := hex.EncodeToString(data); h != expected {
+		t.Errorf("unexpected binary %s", h)
+	}
+}
+
+func TestThriftCodecErrors(t *testing.T) {
+	c := ThriftCodec{}
+	if _, err := c.Encode(&group); err == nil || !strings.Contains(err.Error(), "*codec.ColorGroup is not a thrift.TStruct") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := c.Encode((*testdata.ThriftColorGroup)(nil)); err == nil {
+		t.Error("expect an error for nil structs")
+	}
+	if err := c.Decode([]byte{0}, &group); err == nil || !strings.Contains(err.Error(), "is not a thrift.TStruct") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := c.Decode([]byte{0x08, 0x00}, testdata.NewThriftColorGroup()); err == nil {
+		t.Error("expect an error for truncated data")
+	}
+}
+
+func TestThriftCodecConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(compact bool) {
+			defer wg.Done()
+			c := ThriftCodec{Compact: compact}
+			for j := 0; j < 100; j++ {
+				data, err := c.Encode(newThriftGroup())
+				got := testdata.NewThriftColorGroup()
+				if err == nil {
+					err = c.Decode(data, got)
+				}
+				if err != nil || !got.Equals(newThriftGroup()) {
+					t.Errorf("unexpected %v: %v", got, err)
+					return
+				}
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,149 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code is the code of a rpcx error. The values are compatible with gRPC codes.
+type Code int
+
+const (
+	// OK means no error.
+	OK Code = 0
+	// Canceled means the call was canceled by the caller.
+	Canceled Code = 1
+	// Unknown is an error without a code.
+	Unknown Code = 2
+	// InvalidArgument means the request is malformed, for example it fails validation.
+	InvalidArgument Code = 3
+	// DeadlineExceeded means the deadline expired before the call completed.
+	DeadlineExceeded Code = 4
+	// NotFound means the requested service, method or entity was not found.
+	NotFound Code = 5
+	// PermissionDenied means the caller is not allowed to execute the call.
+	PermissionDenied Code = 7
+	// ResourceExhausted means a limit has been reached, for example a rate limit.
+	ResourceExhausted Code = 8
+	// Unimplemented means the operation is not supported.
+	Unimplemented Code = 12
+	// Internal means an internal error, for example a panic in the handler.
+	Internal Code = 13
+	// Unavailable means the service is currently unavailable.
+	Unavailable Code = 14
+	// DataLoss means data is lost or corrupted, for example the payload fails its checksum.
+	DataLoss Code = 15
+	// Unauthenticated means the request has no valid credentials.
+	Unauthenticated Code = 16
+)
+
+var codeNames = map[Code]string{
+	OK:                "OK",
+	Canceled:          "Canceled",
+	Unknown:           "Unknown",
+	InvalidArgument:   "InvalidArgument",
+	DeadlineExceeded:  "DeadlineExceeded",
+	NotFound:          "NotFound",
+	PermissionDenied:  "PermissionDenied",
+	ResourceExhausted: "ResourceExhausted",
+	Unimplemented:     "Unimplemented",
+	Internal:          "Internal",
+	Unavailable:       "Unavailable",
+	DataLoss:          "DataLoss",
+	Unauthenticated:   "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Code(%d)", int(c))
+}
+
+// CodedError is implemented by errors that carry a code and details to clients.
+// Handlers can return their own error types implementing it instead of Error.
+type CodedError interface {
+	error
+	Code() int
+	Details() map[string]string
+}
+
+// Error is an error with a code and optional details, such as the field that fails validation.
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]string
+}
+
+// Sentinel errors match any error with the same code by errors.Is, including errors returned by servers:
+//
+//	if errors.Is(err, errors.ErrNotFound) { ... }
+var (
+	ErrNotFound         = New(NotFound, "not found")
+	ErrInvalidArgument  = New(InvalidArgument, "invalid argument")
+	ErrUnavailable      = New(Unavailable, "unavailable")
+	ErrDeadlineExceeded = New(DeadlineExceeded, "deadline exceeded")
+	ErrInternal         = New(Internal, "internal error")
+	ErrRateLimited      = New(ResourceExhausted, "rate limited")
+	// ErrUnsupportedEncoding matches errors of messages whose serialize type or compress type is not registered.
+	ErrUnsupportedEncoding = New(Unimplemented, "unsupported encoding")
+)
+
+// Error returns the message of the error.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is an Error with the same code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithDetail sets a detail of the error and returns it.
+func (e *Error) WithDetail(key, value string) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]string)
+	}
+	e.Details[key] = value
+	return e
+}
+
+// New creates an Error with the code and message.
+func New(code Code, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// Errorf creates an Error with the code and formatted message.
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// CodeOf returns the code of err. It returns OK if err is nil and Unknown if err has no code.
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	var ce CodedError
+	if errors.As(err, &ce) {
+		return Code(ce.Code())
+	}
+	return Unknown
+}
+
+// DetailsOf returns the details of err, or nil if it has none.
+func DetailsOf(err error) map[string]string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Details
+	}
+	var ce CodedError
+	if errors.As(err, &ce) {
+		return ce.Details()
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package errors
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 )
@@ -13,9 +14,35 @@ type MultiError struct {
 
 // Error returns the message of the actual error
 func (e *MultiError) Error() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	return fmt.Sprintf("%v", e.Errors)
 }
 
+// Is reports whether any of the errors matches target, so errors.Is works on errors of broadcasts.
+func (e *MultiError) Is(target error) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target, so errors.As works on errors of broadcasts.
+func (e *MultiError) As(target interface{}) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *MultiError) Append(err error) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
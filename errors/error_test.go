@@ -31,4 +31,45 @@ func TestMultiError_Error(t *testing.T) {
 	multiErrors.Errors = append(multiErrors.Errors, errors.New("fatal"))
 
 	assert.Equal(t, "[invalid fatal]",multiErrors.Error(), "Test Error()")
-}
\ No newline at end of file
+}
+func TestCodeOf(t *testing.T) {
+	assert.Equal(t, OK, CodeOf(nil))
+	assert.Equal(t, Unknown, CodeOf(errors.New("plain")))
+
+	err := Errorf(InvalidArgument, "bad %s", "arg")
+	assert.Equal(t, "bad arg", err.Error())
+	assert.Equal(t, InvalidArgument, CodeOf(fmt.Errorf("wrapped: %w", err)))
+	assert.Equal(t, "InvalidArgument", InvalidArgument.String())
+}
+
+type validationError struct{ field string }
+
+func (e validationError) Error() string              { return "invalid " + e.field }
+func (e validationError) Code() int                  { return int(InvalidArgument) }
+func (e validationError) Details() map[string]string { return map[string]string{"field": e.field} }
+
+func TestCodedError(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", validationError{field: "name"})
+	assert.Equal(t, InvalidArgument, CodeOf(err))
+	assert.Equal(t, map[string]string{"field": "name"}, DetailsOf(err))
+	assert.Nil(t, DetailsOf(errors.New("plain")))
+
+	e := New(NotFound, "user not found").WithDetail("user", "42")
+	assert.Equal(t, map[string]string{"user": "42"}, DetailsOf(e))
+	assert.True(t, errors.Is(fmt.Errorf("wrapped: %w", e), ErrNotFound))
+	assert.False(t, errors.Is(e, ErrUnavailable))
+	assert.True(t, errors.Is(New(ResourceExhausted, "too many requests"), ErrRateLimited))
+}
+
+func TestMultiErrorIs(t *testing.T) {
+	plain := errors.New("plain")
+	err := NewMultiError([]error{plain, fmt.Errorf("wrapped: %w", New(NotFound, "user not found"))})
+	assert.True(t, errors.Is(err, plain))
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrUnavailable))
+
+	var e *Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, NotFound, e.Code)
+	assert.False(t, errors.As(NewMultiError([]error{plain}), &e))
+}
@@ -0,0 +1,361 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+)
+
+// Source is the source of values bound to args.
+type Source int
+
+const (
+	// FromPath binds a path variable, such as id of "/v1/users/{id}".
+	FromPath Source = iota
+	// FromQuery binds a query parameter.
+	FromQuery
+	// FromHeader binds a header.
+	FromHeader
+	// FromBody binds the JSON body.
+	FromBody
+)
+
+var sourceNames = map[Source]string{
+	FromPath:   "path variable",
+	FromQuery:  "query parameter",
+	FromHeader: "header",
+	FromBody:   "body",
+}
+
+func (s Source) String() string {
+	if name, ok := sourceNames[s]; ok {
+		return name
+	}
+	return "Source(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Binding binds a value of requests to a field of args, which is the JSON name of the field.
+type Binding struct {
+	Source Source
+	// Name is the name of the path variable, query parameter or header. It is ignored for FromBody.
+	Name string
+	// Field is the field of args set to the value. It is Name if it is empty.
+	// For FromBody, the body is the args if Field is empty, or else the value of Field.
+	Field string
+}
+
+// Path binds the path variable name to field.
+func Path(name, field string) Binding {
+	return Binding{Source: FromPath, Name: name, Field: field}
+}
+
+// Query binds the query parameter name to field.
+func Query(name, field string) Binding {
+	return Binding{Source: FromQuery, Name: name, Field: field}
+}
+
+// Header binds the header name to field.
+func Header(name, field string) Binding {
+	return Binding{Source: FromHeader, Name: name, Field: field}
+}
+
+// Body binds the JSON body to field, or to the args if field is empty.
+func Body(field string) Binding {
+	return Binding{Source: FromBody, Field: field}
+}
+
+func (b Binding) field() string {
+	if b.Field == "" {
+		return b.Name
+	}
+	return b.Field
+}
+
+// values returns the values of b in r, or nil if r has no values of b.
+func (b Binding) values(r *http.Request, params map[string]string) []string {
+	switch b.Source {
+	case FromPath:
+		if v, ok := params[b.Name]; ok {
+			return []string{v}
+		}
+	case FromQuery:
+		return r.URL.Query()[b.Name]
+	case FromHeader:
+		return r.Header.Values(b.Name)
+	}
+	return nil
+}
+
+// binder binds requests to JSON payloads of args.
+type binder struct {
+	bindings []Binding
+	// args is the type of args for typed routes, whose values are converted to the kinds of their fields.
+	args reflect.Type
+	// converters convert values of bindings of typed routes.
+	converters []converter
+}
+
+// converter converts bound values to the kind of a field.
+type converter func(values []string) (interface{}, error)
+
+// newBinder creates a binder of bindings for the pattern.
+// Path variables are bound to the fields of their names if there are no bindings of them.
+func newBinder(segments []segment, bindings []Binding) (*binder, error) {
+	b := &binder{bindings: append([]Binding(nil), bindings...)}
+	bodies := 0
+	for _, bd := range bindings {
+		switch bd.Source {
+		case FromPath:
+			if !hasVariable(segments, bd.Name) {
+				return nil, fmt.Errorf("gateway: pattern has no variable %q", bd.Name)
+			}
+		case FromQuery, FromHeader:
+			if bd.Name == "" {
+				return nil, fmt.Errorf("gateway: %s binding has no name", bd.Source)
+			}
+		case FromBody:
+			bodies++
+		default:
+			return nil, fmt.Errorf("gateway: unknown binding source %d", bd.Source)
+		}
+	}
+	if bodies > 1 {
+		return nil, fmt.Errorf("gateway: body is bound more than once")
+	}
+
+	for _, s := range segments {
+		if s.variable && !b.binds(FromPath, s.value) {
+			b.bindings = append(b.bindings, Path(s.value, ""))
+		}
+	}
+	return b, nil
+}
+
+func hasVariable(segments []segment, name string) bool {
+	for _, s := range segments {
+		if s.variable && s.value == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *binder) binds(source Source, name string) bool {
+	for _, bd := range b.bindings {
+		if bd.Source == source && bd.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newTypedBinder creates a binder of args, whose fields are bound by their tags:
+//
+//	type GetUserArgs struct {
+//		ID      int64    `json:"id" path:"id"`
+//		Fields  []string `json:"fields" query:"fields"`
+//		TraceID string   `json:"trace_id" header:"X-Trace-Id"`
+//		Name    string   `json:"name"` // from the JSON body
+//	}
+//
+// Fields without these tags are decoded from the JSON body, and path tags of variables which are not in the pattern are ignored.
+func newTypedBinder(segments []segment, args interface{}) (*binder, error) {
+	typ := reflect.TypeOf(args)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("gateway: args %T is not a struct", args)
+	}
+
+	var bindings []Binding
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+		for _, tag := range []struct {
+			key    string
+			source Source
+		}{{"path", FromPath}, {"query", FromQuery}, {"header", FromHeader}} {
+			name, ok := f.Tag.Lookup(tag.key)
+			if !ok || name == "" || name == "-" {
+				continue
+			}
+			if tag.source == FromPath && !hasVariable(segments, name) { // args can be shared by routes
+				continue
+			}
+			bindings = append(bindings, Binding{Source: tag.source, Name: name, Field: jsonName(f)})
+		}
+	}
+
+	b, err := newBinder(segments, bindings)
+	if err != nil {
+		return nil, err
+	}
+	b.args = typ
+	for _, bd := range b.bindings {
+		var conv converter
+		if bd.Source != FromBody {
+			f, ok := fieldOfJSONName(typ, bd.field())
+			if !ok {
+				return nil, fmt.Errorf("gateway: args %s has no field %q", typ, bd.field())
+			}
+			if conv = converterOf(f.Type); conv == nil {
+				return nil, fmt.Errorf("gateway: field %s of args %s can't be bound from %s", f.Name, typ, bd.Source)
+			}
+		}
+		b.converters = append(b.converters, conv)
+	}
+	return b, nil
+}
+
+// jsonName returns the JSON name of f.
+func jsonName(f reflect.StructField) string {
+	if tag := f.Tag.Get("json"); tag != "" {
+		if name := strings.Split(tag, ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func fieldOfJSONName(typ reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		if f := typ.Field(i); f.PkgPath == "" && jsonName(f) == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// converterOf returns the converter of values to typ, or nil if values can't be converted to typ.
+// Slices get all the values, and other types get the first value.
+func converterOf(typ reflect.Type) converter {
+	if typ.Kind() == reflect.Ptr {
+		return converterOf(typ.Elem())
+	}
+	if typ.Kind() == reflect.Slice && typ.Elem().Kind() != reflect.Uint8 {
+		conv := converterOf(typ.Elem())
+		if conv == nil {
+			return nil
+		}
+		return func(values []string) (interface{}, error) {
+			vs := make([]interface{}, 0, len(values))
+			for _, v := range values {
+				cv, err := conv([]string{v})
+				if err != nil {
+					return nil, err
+				}
+				vs = append(vs, cv)
+			}
+			return vs, nil
+		}
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		return func(values []string) (interface{}, error) { return values[0], nil }
+	case reflect.Bool:
+		return func(values []string) (interface{}, error) { return strconv.ParseBool(values[0]) }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(values []string) (interface{}, error) { return strconv.ParseInt(values[0], 10, typ.Bits()) }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(values []string) (interface{}, error) { return strconv.ParseUint(values[0], 10, typ.Bits()) }
+	case reflect.Float32, reflect.Float64:
+		return func(values []string) (interface{}, error) { return strconv.ParseFloat(values[0], typ.Bits()) }
+	}
+	return nil
+}
+
+// bind returns the JSON payload of args of r. Bound values override fields of the body.
+// Values are strings for untyped routes, and typed routes convert them to the kinds of their fields.
+func (b *binder) bind(r *http.Request, params map[string]string, maxBodySize int64) ([]byte, error) {
+	body, err := readBody(r, maxBodySize)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	bodyBound := false
+	for _, bd := range b.bindings {
+		if bd.Source == FromBody && bd.Field != "" {
+			bodyBound = true
+			if len(body) > 0 {
+				fields[bd.Field] = json.RawMessage(body)
+			}
+		}
+	}
+	if !bodyBound && len(body) > 0 {
+		if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+			return nil, rerrors.New(rerrors.InvalidArgument, "gateway: body is not a JSON object")
+		}
+	}
+
+	for i, bd := range b.bindings {
+		if bd.Source == FromBody {
+			continue
+		}
+		values := bd.values(r, params)
+		if len(values) == 0 {
+			continue
+		}
+
+		var v interface{} = values[0]
+		if b.args != nil {
+			if v, err = b.converters[i](values); err != nil {
+				return nil, rerrors.New(rerrors.InvalidArgument, fmt.Sprintf("gateway: invalid %s %s: %v", bd.Source, bd.Name, err))
+			}
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		fields[bd.field()] = data
+	}
+
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if b.args != nil { // reject args which can't be decoded before calling services
+		if err := json.Unmarshal(payload, reflect.New(b.args).Interface()); err != nil {
+			return nil, rerrors.New(rerrors.InvalidArgument, "gateway: invalid args: "+err.Error())
+		}
+	}
+	return payload, nil
+}
+
+// meta returns the values of bindings of r as metadata of streams.
+func (b *binder) meta(r *http.Request, params map[string]string) map[string]string {
+	meta := make(map[string]string)
+	for _, bd := range b.bindings {
+		if values := bd.values(r, params); len(values) > 0 {
+			meta[bd.field()] = values[0]
+		}
+	}
+	return meta
+}
+
+// readBody reads the body of r, which is limited to maxBodySize.
+func readBody(r *http.Request, maxBodySize int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBodySize {
+		return nil, errBodyTooLarge
+	}
+	return bytes.TrimSpace(data), nil
+}
@@ -0,0 +1,440 @@
+// Package gateway serves services of rpcx as REST APIs by declarative routes.
+//
+// Routes map methods and patterns of requests, such as GET "/v1/users/{id}", to service methods.
+// Path variables, query parameters, headers and the JSON body of requests are bound to the args,
+// and replies are written as JSON. Errors are written as JSON objects with the HTTP statuses of their codes,
+// such as 404 for NotFound:
+//
+//	{"code":5,"message":"user 1 is not found","details":{"id":"1"}}
+//
+// Services are invoked in process by NewServerInvoker, or on remote servers by XClientInvoker.
+// The OpenAPI spec of the routes is served at OpenAPIPath for services whose types are registered to the gateway.
+//
+//	g := gateway.New(gateway.NewServerInvoker(s))
+//	g.Route(http.MethodGet, "/v1/users/{id}", "UserService", "Get", gateway.Query("fields", ""))
+//	g.RouteArgs(http.MethodPost, "/v1/users", "UserService", "Create", CreateUserArgs{})
+//	http.ListenAndServe(":8080", g)
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// DefaultMaxBodySize is the default max size of bodies of requests and lines of streams.
+const DefaultMaxBodySize = 4 << 20
+
+var errBodyTooLarge = rerrors.New(rerrors.ResourceExhausted, "gateway: body is too large")
+
+// StreamFormat is the format of streaming responses.
+type StreamFormat int
+
+const (
+	// StreamAuto writes SSE if requests accept text/event-stream, or else NDJSON.
+	StreamAuto StreamFormat = iota
+	// NDJSON writes newline-delimited JSON values (application/x-ndjson).
+	NDJSON
+	// SSE writes server-sent events (text/event-stream), whose data are JSON values.
+	SSE
+)
+
+// Middleware wraps handlers of routes, for example to authenticate requests or to log them.
+// The route of requests is found by RouteOf.
+type Middleware func(next http.Handler) http.Handler
+
+// RouteInfo describes the route of a request.
+type RouteInfo struct {
+	Method        string
+	Pattern       string
+	ServicePath   string
+	ServiceMethod string
+	// Params are the path variables of the request.
+	Params map[string]string
+}
+
+type routeKey struct{}
+
+// RouteOf returns the route of r in middlewares, or nil if r has no route.
+func RouteOf(r *http.Request) *RouteInfo {
+	info, _ := r.Context().Value(routeKey{}).(*RouteInfo)
+	return info
+}
+
+// OptionFn configures options of the gateway.
+type OptionFn func(*Gateway)
+
+// WithCORS handles CORS requests by options. Preflight requests are answered without calling services.
+func WithCORS(options *server.CORSOptions) OptionFn {
+	return func(g *Gateway) {
+		g.cors = options.Handler(http.HandlerFunc(g.serve))
+	}
+}
+
+// WithMaxBodySize sets the max size of bodies of requests and lines of streams. It is DefaultMaxBodySize by default.
+func WithMaxBodySize(n int64) OptionFn {
+	return func(g *Gateway) {
+		g.maxBodySize = n
+	}
+}
+
+// WithHeaders copies headers in the allowlist of requests to metadata of calls under their names in lowercase,
+// and back to headers of responses, like server.WithGatewayHeaders, so request IDs and trace contexts added
+// by ingresses reach services. share.MeshHeaders are copied if no headers are given.
+func WithHeaders(headers ...string) OptionFn {
+	if len(headers) == 0 {
+		headers = share.MeshHeaders
+	}
+	return func(g *Gateway) {
+		g.headers = nil
+		for _, h := range headers {
+			g.headers = append(g.headers, http.CanonicalHeaderKey(h))
+		}
+		share.PropagateHeaders(headers...)
+	}
+}
+
+// Gateway is an http.Handler serving services by routes.
+type Gateway struct {
+	invoker     Invoker
+	cors        http.Handler
+	maxBodySize int64
+	headers     []string // headers copied to metadata, see WithHeaders
+	title       string
+	version     string
+
+	mu          sync.RWMutex
+	router      router
+	middlewares []Middleware
+	services    map[string]map[string]methodType // types of service methods for OpenAPI specs
+}
+
+// New creates a gateway invoking services by invoker.
+func New(invoker Invoker, opts ...OptionFn) *Gateway {
+	g := &Gateway{
+		invoker:     invoker,
+		maxBodySize: DefaultMaxBodySize,
+		title:       "rpcx gateway",
+		version:     "1.0.0",
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Use adds middlewares wrapping handlers of all the routes. The first middleware is the outermost.
+func (g *Gateway) Use(middlewares ...Middleware) {
+	g.mu.Lock()
+	g.middlewares = append(g.middlewares, middlewares...)
+	g.mu.Unlock()
+}
+
+// Route maps requests of method and pattern to serviceMethod of servicePath.
+// Variables of pattern are enclosed in braces, such as "{id}" of "/v1/users/{id}", and match a whole segment.
+// Static segments win over variables, so "/v1/users/me" is preferred to "/v1/users/{id}".
+//
+// The JSON object of the body is the args, and values of bindings are set to the fields of the args as strings.
+// Path variables without bindings are bound to the fields of their names.
+// Use RouteArgs to convert values to the types of fields.
+//
+// It returns an error if pattern or bindings are invalid, or if the route conflicts with another route,
+// which matches the same requests.
+func (g *Gateway) Route(method, pattern, servicePath, serviceMethod string, bindings ...Binding) error {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		return err
+	}
+	b, err := newBinder(segments, bindings)
+	if err != nil {
+		return err
+	}
+	return g.addRoute(method, pattern, servicePath, serviceMethod, segments, b, false, g.unaryHandler(b))
+}
+
+// RouteArgs maps requests of method and pattern to serviceMethod of servicePath like Route,
+// and binds values to fields of args by their tags of path, query and header:
+//
+//	type GetUserArgs struct {
+//		ID     int64    `json:"id" path:"id"`
+//		Fields []string `json:"fields" query:"fields"`
+//	}
+//
+// Values are converted to the types of fields, and requests with invalid values fail with the InvalidArgument code.
+// Other fields are decoded from the JSON body.
+func (g *Gateway) RouteArgs(method, pattern, servicePath, serviceMethod string, args interface{}) error {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		return err
+	}
+	b, err := newTypedBinder(segments, args)
+	if err != nil {
+		return err
+	}
+	return g.addRoute(method, pattern, servicePath, serviceMethod, segments, b, false, g.unaryHandler(b))
+}
+
+// Stream maps requests of method and pattern to the stream service of servicePath, which is registered by
+// Server.EnableStreamService, and writes the stream as NDJSON or SSE by format.
+// servicePath is share.StreamServiceName if it is empty.
+//
+// Values of bindings are set to the metadata of share.StreamServiceArgs, and each line written by the
+// StreamHandler of the service is a JSON value of the response, which is flushed after it is written.
+func (g *Gateway) Stream(method, pattern, servicePath string, format StreamFormat, bindings ...Binding) error {
+	if servicePath == "" {
+		servicePath = share.StreamServiceName
+	}
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		return err
+	}
+	for _, bd := range bindings {
+		if bd.Source == FromBody {
+			return errors.New("gateway: body can't be bound to streams")
+		}
+	}
+	b, err := newBinder(segments, bindings)
+	if err != nil {
+		return err
+	}
+	return g.addRoute(method, pattern, servicePath, "Stream", segments, b, true, g.streamHandler(b, format))
+}
+
+func (g *Gateway) addRoute(method, pattern, servicePath, serviceMethod string, segments []segment, b *binder, stream bool, h http.Handler) error {
+	r := &route{
+		info: RouteInfo{
+			Method:        strings.ToUpper(method),
+			Pattern:       pattern,
+			ServicePath:   servicePath,
+			ServiceMethod: serviceMethod,
+		},
+		segments: segments,
+		binder:   b,
+		stream:   stream,
+		handler:  h,
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.router.add(r)
+}
+
+// ServeHTTP serves r by its route.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if g.cors != nil {
+		g.cors.ServeHTTP(w, r)
+		return
+	}
+	g.serve(w, r)
+}
+
+func (g *Gateway) serve(w http.ResponseWriter, r *http.Request) {
+	g.mu.RLock()
+	rt, params, allowed := g.router.find(r.Method, r.URL.EscapedPath())
+	middlewares := g.middlewares
+	g.mu.RUnlock()
+
+	if rt == nil {
+		if r.URL.Path == OpenAPIPath && r.Method == http.MethodGet {
+			g.serveOpenAPI(w, r)
+			return
+		}
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, errors.New("gateway: method "+r.Method+" is not allowed"), http.StatusMethodNotAllowed)
+			return
+		}
+		writeError(w, rerrors.New(rerrors.NotFound, "gateway: no route of "+r.URL.Path), http.StatusNotFound)
+		return
+	}
+
+	info := rt.info
+	info.Params = params
+	r = r.WithContext(context.WithValue(r.Context(), routeKey{}, &info))
+
+	h := rt.handler
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	h.ServeHTTP(w, r)
+}
+
+// requestContext returns the context of calls of r, whose metadata contains the metadata of X-RPCX-Meta,
+// the auth in the Authorization header and the headers of WithHeaders, which are set to headers of w.
+func (g *Gateway) requestContext(w http.ResponseWriter, r *http.Request) (context.Context, map[string]string) {
+	meta := make(map[string]string)
+	if v := r.Header.Get(server.XMeta); v != "" {
+		if values, err := url.ParseQuery(v); err == nil {
+			for k := range values {
+				meta[k] = values.Get(k)
+			}
+		}
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		meta[share.AuthKey] = auth
+	}
+	headerMeta := server.MetadataOfHeaders(r.Header, g.headers)
+	for k, v := range headerMeta {
+		if _, ok := meta[k]; !ok {
+			meta[k] = v
+		}
+	}
+	server.SetMetadataHeaders(w.Header(), headerMeta, g.headers)
+
+	resMeta := make(map[string]string)
+	ctx := context.WithValue(r.Context(), server.RemoteConnContextKey, r.RemoteAddr)
+	ctx = context.WithValue(ctx, share.ReqMetaDataKey, meta)
+	ctx = context.WithValue(ctx, share.ResMetaDataKey, resMeta)
+	return ctx, resMeta
+}
+
+func (g *Gateway) unaryHandler(b *binder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := RouteOf(r)
+		payload, err := b.bind(r, info.Params, g.maxBodySize)
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		ctx, resMeta := g.requestContext(w, r)
+		var reply json.RawMessage
+		err = g.invoker.Call(ctx, info.ServicePath, info.ServiceMethod, json.RawMessage(payload), &reply)
+		g.setMetaHeader(w, resMeta)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if len(reply) == 0 {
+			reply = json.RawMessage("null")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(reply)
+	})
+}
+
+func (g *Gateway) streamHandler(b *binder, format StreamFormat) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := RouteOf(r)
+		ctx, resMeta := g.requestContext(w, r)
+		conn, err := g.invoker.Stream(ctx, info.ServicePath, b.meta(r, info.Params))
+		g.setMetaHeader(w, resMeta)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+
+		// close the stream when the request is done, which stops the scanner
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-r.Context().Done():
+				conn.Close()
+			case <-done:
+			}
+		}()
+
+		f := format
+		if f == StreamAuto {
+			f = NDJSON
+			if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+				f = SSE
+			}
+		}
+		wh := w.Header()
+		if f == SSE {
+			wh.Set("Content-Type", "text/event-stream")
+		} else {
+			wh.Set("Content-Type", "application/x-ndjson")
+		}
+		wh.Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 0, 4096), int(g.maxBodySize))
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			if f == SSE {
+				_, err = w.Write([]byte("data: " + string(line) + "\n\n"))
+			} else {
+				_, err = w.Write([]byte(string(line) + "\n"))
+			}
+			if err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err := scanner.Err(); err != nil && r.Context().Err() == nil {
+			log.Warnf("gateway: failed to read the stream of %s: %v", info.Pattern, err)
+		}
+	})
+}
+
+// setMetaHeader sets the metadata of the response to X-RPCX-Meta like the HTTP gateway of the server,
+// and to the headers of WithHeaders.
+func (g *Gateway) setMetaHeader(w http.ResponseWriter, resMeta map[string]string) {
+	server.SetMetadataHeaders(w.Header(), resMeta, g.headers)
+	meta := url.Values{}
+	for k, v := range resMeta {
+		if k != share.ServerAddress {
+			meta.Set(k, v)
+		}
+	}
+	if len(meta) > 0 {
+		w.Header().Set(server.XMeta, meta.Encode())
+	}
+}
+
+// Error is the JSON object of errors written by the gateway.
+type Error struct {
+	Code    rerrors.Code      `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// writeError writes err with the HTTP status of its code, or status for errors without codes.
+func writeError(w http.ResponseWriter, err error, status int) {
+	e := Error{
+		Code:    rerrors.CodeOf(err),
+		Message: err.Error(),
+		Details: rerrors.DetailsOf(err),
+	}
+	switch {
+	case err == errBodyTooLarge:
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, context.DeadlineExceeded):
+		e.Code, status = rerrors.DeadlineExceeded, http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		e.Code, status = rerrors.Canceled, 499 // the client closed the request
+	default:
+		status = server.HTTPStatusOf(err, status)
+	}
+
+	data, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,340 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+type GetUserArgs struct {
+	ID      int64    `json:"id" path:"id"`
+	Fields  []string `json:"fields" query:"fields"`
+	Verbose bool     `json:"verbose" query:"verbose"`
+	TraceID string   `json:"trace_id" header:"X-Trace-Id"`
+}
+
+type User struct {
+	ID     int64    `json:"id"`
+	Name   string   `json:"name"`
+	Fields []string `json:"fields,omitempty"`
+	Trace  string   `json:"trace,omitempty"`
+	Auth   string   `json:"auth,omitempty"`
+}
+
+type UpdateUserArgs struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserService struct{}
+
+func (*UserService) Get(ctx context.Context, args *GetUserArgs, reply *User) error {
+	if args.ID == 404 {
+		return rerrors.New(rerrors.NotFound, fmt.Sprintf("user %d is not found", args.ID)).WithDetail("id", "404")
+	}
+	meta := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	ctx.Value(share.ResMetaDataKey).(map[string]string)["served-by"] = "rpcx"
+	*reply = User{ID: args.ID, Name: "user", Fields: args.Fields, Trace: args.TraceID, Auth: meta[share.AuthKey]}
+	return nil
+}
+
+func (*UserService) Me(ctx context.Context, args *GetUserArgs, reply *User) error {
+	*reply = User{ID: 1, Name: "me"}
+	return nil
+}
+
+func (*UserService) Update(ctx context.Context, args *UpdateUserArgs, reply *UpdateUserArgs) error {
+	if args.Name == "" {
+		return rerrors.New(rerrors.InvalidArgument, "name is required")
+	}
+	*reply = *args
+	return nil
+}
+
+func (*UserService) Slow(ctx context.Context, args *UpdateUserArgs, reply *UpdateUserArgs) error {
+	<-ctx.Done()
+	return rerrors.New(rerrors.DeadlineExceeded, ctx.Err().Error())
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startServer starts a server of UserService and a stream service writing three events of the topic in the stream metadata.
+func startServer(t *testing.T) *server.Server {
+	s := server.NewServer()
+	s.RegisterName("UserService", new(UserService), "")
+	s.AuthFunc = func(ctx context.Context, req *protocol.Message, token string) error {
+		if token == "bad" {
+			return rerrors.New(rerrors.Unauthenticated, "invalid token")
+		}
+		return nil
+	}
+
+	ss := server.NewStreamService(freeAddr(t), func(conn net.Conn, args *share.StreamServiceArgs) {
+		defer conn.Close()
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(conn, "{\"topic\":%q,\"seq\":%d}\n", args.Meta["topic"], i)
+		}
+	}, nil, 100)
+	s.EnableStreamService(share.StreamServiceName, ss)
+
+	go s.Serve("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { s.Close() })
+	time.Sleep(100 * time.Millisecond)
+	return s
+}
+
+func newGateway(t *testing.T, invoker Invoker, opts ...OptionFn) *httptest.Server {
+	g := New(invoker, opts...)
+	assert.NoError(t, g.RouteArgs(http.MethodGet, "/v1/users/{id}", "UserService", "Get", GetUserArgs{}))
+	assert.NoError(t, g.RouteArgs(http.MethodGet, "/v1/users/me", "UserService", "Me", GetUserArgs{}))
+	assert.NoError(t, g.Route(http.MethodPut, "/v1/users/{id}", "UserService", "Update"))
+	assert.NoError(t, g.Route(http.MethodPost, "/v1/users/{user}/rename", "UserService", "Update",
+		Path("user", "id"), Query("name", "")))
+	assert.NoError(t, g.Route(http.MethodPost, "/v1/slow", "UserService", "Slow"))
+	assert.NoError(t, g.Stream(http.MethodGet, "/v1/topics/{topic}/events", "", StreamAuto))
+
+	hs := httptest.NewServer(g)
+	t.Cleanup(hs.Close)
+	return hs
+}
+
+func do(t *testing.T, method, u, body string, header http.Header) (*http.Response, string) {
+	req, err := http.NewRequest(method, u, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, strings.TrimSpace(string(data))
+}
+
+func testGateway(t *testing.T, hs *httptest.Server) {
+	t.Run("bind", func(t *testing.T) {
+		resp, body := do(t, http.MethodGet, hs.URL+"/v1/users/42?fields=name&fields=email", "", http.Header{
+			"X-Trace-Id":    {"trace-1"},
+			"Authorization": {"token"},
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		assert.JSONEq(t, `{"id":42,"name":"user","fields":["name","email"],"trace":"trace-1","auth":"token"}`, body)
+		meta, _ := url.ParseQuery(resp.Header.Get(server.XMeta))
+		assert.Equal(t, "rpcx", meta.Get("served-by"))
+	})
+
+	t.Run("static segments win", func(t *testing.T) {
+		_, body := do(t, http.MethodGet, hs.URL+"/v1/users/me", "", nil)
+		assert.JSONEq(t, `{"id":1,"name":"me"}`, body)
+	})
+
+	t.Run("body", func(t *testing.T) {
+		_, body := do(t, http.MethodPut, hs.URL+"/v1/users/7", `{"name":"tom","id":"overridden"}`, nil)
+		assert.JSONEq(t, `{"id":"7","name":"tom"}`, body)
+
+		_, body = do(t, http.MethodPost, hs.URL+"/v1/users/a%2Fb/rename?name=jerry", "", nil)
+		assert.JSONEq(t, `{"id":"a/b","name":"jerry"}`, body)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		resp, body := do(t, http.MethodGet, hs.URL+"/v1/users/404", "", nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.JSONEq(t, `{"code":5,"message":"user 404 is not found","details":{"id":"404"}}`, body)
+
+		resp, body = do(t, http.MethodGet, hs.URL+"/v1/users/abc", "", nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Contains(t, body, `"code":3`)
+
+		resp, _ = do(t, http.MethodGet, hs.URL+"/v1/users/1?verbose=maybe", "", nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = do(t, http.MethodPut, hs.URL+"/v1/users/7", `[1]`, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, body = do(t, http.MethodPut, hs.URL+"/v1/users/7", `{}`, nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Contains(t, body, "name is required")
+
+		resp, _ = do(t, http.MethodGet, hs.URL+"/v1/users/1", "", http.Header{"Authorization": {"bad"}})
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp, _ = do(t, http.MethodGet, hs.URL+"/v2/users", "", nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, _ = do(t, http.MethodDelete, hs.URL+"/v1/users/1", "", nil)
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+		assert.Equal(t, "GET, PUT", resp.Header.Get("Allow"))
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		resp, _ := do(t, http.MethodPost, hs.URL+"/v1/slow", `{}`, http.Header{
+			server.XMeta: {url.Values{share.ServerTimeout: {"100"}}.Encode()},
+		})
+		assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		resp, body := do(t, http.MethodGet, hs.URL+"/v1/topics/news/events", "", nil)
+		assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+		assert.Equal(t, `{"topic":"news","seq":0}`+"\n"+`{"topic":"news","seq":1}`+"\n"+`{"topic":"news","seq":2}`, body)
+	})
+
+	t.Run("sse", func(t *testing.T) {
+		resp, body := do(t, http.MethodGet, hs.URL+"/v1/topics/news/events", "", http.Header{"Accept": {"text/event-stream"}})
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		var events []string
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				events = append(events, strings.TrimPrefix(line, "data: "))
+			}
+		}
+		assert.Equal(t, []string{`{"topic":"news","seq":0}`, `{"topic":"news","seq":1}`, `{"topic":"news","seq":2}`}, events)
+	})
+}
+
+func TestServerInvoker(t *testing.T) {
+	s := startServer(t)
+	testGateway(t, newGateway(t, NewServerInvoker(s)))
+}
+
+func TestXClientInvoker(t *testing.T) {
+	s := startServer(t)
+	addr := s.Address().String()
+	invoker := NewXClientInvoker(func(servicePath string) (client.XClient, error) {
+		d, err := client.NewPeer2PeerDiscovery("tcp@"+addr, "")
+		if err != nil {
+			return nil, err
+		}
+		option := client.DefaultOption
+		option.SerializeType = protocol.JSON
+		return client.NewXClient(servicePath, client.Failtry, client.RandomSelect, d, option), nil
+	})
+	defer invoker.Close()
+	testGateway(t, newGateway(t, invoker))
+}
+
+func TestMiddlewareAndCORS(t *testing.T) {
+	s := startServer(t)
+	g := New(NewServerInvoker(s), WithCORS(server.AllowAllCORSOptions()))
+	assert.NoError(t, g.RouteArgs(http.MethodGet, "/v1/users/{id}", "UserService", "Get", GetUserArgs{}))
+
+	var routes []string
+	g.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info := RouteOf(r)
+			routes = append(routes, info.Method+" "+info.Pattern+" "+info.ServicePath+"."+info.ServiceMethod+" "+info.Params["id"])
+			if r.Header.Get("Authorization") == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	hs := httptest.NewServer(g)
+	defer hs.Close()
+
+	resp, _ := do(t, http.MethodGet, hs.URL+"/v1/users/1", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, body := do(t, http.MethodGet, hs.URL+"/v1/users/1", "", http.Header{
+		"Authorization": {"token"},
+		"Origin":        {"http://example.com"},
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	var u User
+	assert.NoError(t, json.Unmarshal([]byte(body), &u))
+	assert.Equal(t, "token", u.Auth)
+	assert.Equal(t, []string{"GET /v1/users/{id} UserService.Get 1", "GET /v1/users/{id} UserService.Get 1"}, routes)
+
+	// preflight requests are handled before routes
+	resp, _ = do(t, http.MethodOptions, hs.URL+"/v1/users/1", "", http.Header{
+		"Origin":                        {"http://example.com"},
+		"Access-Control-Request-Method": {http.MethodGet},
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, routes, 2)
+}
+
+func TestRouteErrors(t *testing.T) {
+	g := New(nil)
+	assert.NoError(t, g.Route(http.MethodGet, "/v1/users/{id}", "UserService", "Get"))
+	assert.NoError(t, g.Route(http.MethodGet, "/v1/users/me", "UserService", "Me"))
+	assert.NoError(t, g.Route(http.MethodPost, "/v1/users/{id}", "UserService", "Update"))
+
+	err := g.Route(http.MethodGet, "/v1/users/{name}", "UserService", "Find")
+	assert.EqualError(t, err, "gateway: route GET /v1/users/{name} conflicts with GET /v1/users/{id}")
+	assert.Error(t, g.Route("get", "/v1/users/me", "UserService", "Me"))
+
+	for _, pattern := range []string{"v1/users", "/v1/{}", "/v1/{id", "/v1/x{id}", "/v1/{id}/{id}"} {
+		assert.Error(t, g.Route(http.MethodGet, pattern, "UserService", "Get"), pattern)
+	}
+	assert.Error(t, g.Route(http.MethodGet, "/v2/{id}", "UserService", "Get", Path("name", "")))
+	assert.Error(t, g.Route(http.MethodGet, "/v2/{id}", "UserService", "Get", Body("a"), Body("b")))
+	assert.Error(t, g.Route(http.MethodGet, "/v2/{id}", "UserService", "Get", Query("", "a")))
+	assert.Error(t, g.RouteArgs(http.MethodGet, "/v2/{name}", "UserService", "Get", GetUserArgs{}))
+	assert.Error(t, g.RouteArgs(http.MethodGet, "/v2/{id}", "UserService", "Get", 1))
+	assert.Error(t, g.Stream(http.MethodGet, "/v2/{id}", "", NDJSON, Body("")))
+}
+
+type headerService struct{}
+
+func (headerService) Get(ctx context.Context, args *UpdateUserArgs, reply *UpdateUserArgs) error {
+	*reply = UpdateUserArgs{ID: server.RequestID(ctx), Name: share.HeaderValue(ctx, "traceparent")}
+	ctx.Value(share.ResMetaDataKey).(map[string]string)["tracestate"] = "rpcx=1"
+	return nil
+}
+
+func TestGatewayHeaders(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Headers", headerService{}, "")
+	g := New(NewServerInvoker(s), WithHeaders())
+	assert.NoError(t, g.Route(http.MethodGet, "/v1/headers", "Headers", "Get"))
+	hs := httptest.NewServer(g)
+	defer hs.Close()
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	resp, body := do(t, http.MethodGet, hs.URL+"/v1/headers", "", http.Header{
+		"X-Request-Id": {"req-1"},
+		"Traceparent":  {traceparent},
+		"X-Other":      {"other"},
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.JSONEq(t, `{"id":"req-1","name":"`+traceparent+`"}`, body)
+	// headers of requests are echoed, and headers in metadata of responses are set
+	assert.Equal(t, "req-1", resp.Header.Get("X-Request-Id"))
+	assert.Equal(t, traceparent, resp.Header.Get("Traceparent"))
+	assert.Equal(t, "rpcx=1", resp.Header.Get("Tracestate"))
+	assert.Empty(t, resp.Header.Get("X-Other"))
+}
@@ -0,0 +1,157 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/codec"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// Invoker invokes services for the gateway. args are JSON payloads of json.RawMessage,
+// and replies are *json.RawMessage set to the JSON payloads of replies.
+//
+// Metadata of requests is set by share.ReqMetaDataKey in ctx, and the metadata of responses
+// can be set to the map of share.ResMetaDataKey in ctx.
+type Invoker interface {
+	// Call invokes serviceMethod of servicePath.
+	Call(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}) error
+	// Stream opens a stream of the stream service of servicePath like XClient.Stream.
+	Stream(ctx context.Context, servicePath string, meta map[string]string) (net.Conn, error)
+}
+
+// serverInvoker invokes services of a server in process.
+type serverInvoker struct {
+	s *server.Server
+}
+
+// NewServerInvoker returns an Invoker calling services of s in process by s.HandleRequest,
+// so requests run through plugins and authentication of s like requests of clients.
+func NewServerInvoker(s *server.Server) Invoker {
+	return &serverInvoker{s: s}
+}
+
+func (i *serverInvoker) Call(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}) error {
+	var cc codec.JSONCodec
+	data, err := cc.Encode(args)
+	if err != nil {
+		return err
+	}
+
+	req := protocol.GetPooledMsg()
+	defer protocol.FreeMsg(req)
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = servicePath
+	req.ServiceMethod = serviceMethod
+	req.Payload = data
+	req.Metadata = make(map[string]string)
+	if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		for k, v := range meta {
+			req.Metadata[k] = v
+		}
+	}
+
+	res, err := i.s.HandleRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer protocol.FreeMsg(res)
+
+	if resMeta, ok := ctx.Value(share.ResMetaDataKey).(map[string]string); ok {
+		for k, v := range res.Metadata {
+			resMeta[k] = v
+		}
+	}
+	return cc.Decode(res.Payload, reply)
+}
+
+func (i *serverInvoker) Stream(ctx context.Context, servicePath string, meta map[string]string) (net.Conn, error) {
+	args := &share.StreamServiceArgs{Meta: meta}
+	reply := &share.StreamServiceReply{}
+	if err := i.Call(ctx, servicePath, "Stream", args, reply); err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", reply.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(reply.Token); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// XClientInvoker invokes services of remote servers by XClients, which are created for service paths by New.
+// XClients must use the JSON serialize type, such as:
+//
+//	invoker := gateway.NewXClientInvoker(func(servicePath string) (client.XClient, error) {
+//		option := client.DefaultOption
+//		option.SerializeType = protocol.JSON
+//		return client.NewXClient(servicePath, client.Failtry, client.RandomSelect, d, option), nil
+//	})
+type XClientInvoker struct {
+	New func(servicePath string) (client.XClient, error)
+
+	mu      sync.Mutex
+	clients map[string]client.XClient
+}
+
+// NewXClientInvoker creates a XClientInvoker creating XClients by newXClient.
+func NewXClientInvoker(newXClient func(servicePath string) (client.XClient, error)) *XClientInvoker {
+	return &XClientInvoker{New: newXClient, clients: make(map[string]client.XClient)}
+}
+
+// xclient returns the XClient of servicePath, which is created at the first call of servicePath.
+func (i *XClientInvoker) xclient(servicePath string) (client.XClient, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if xc, ok := i.clients[servicePath]; ok {
+		return xc, nil
+	}
+	xc, err := i.New(servicePath)
+	if err != nil {
+		return nil, err
+	}
+	i.clients[servicePath] = xc
+	return xc, nil
+}
+
+// Call invokes serviceMethod by the XClient of servicePath.
+func (i *XClientInvoker) Call(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}) error {
+	xc, err := i.xclient(servicePath)
+	if err != nil {
+		return err
+	}
+	return xc.Call(ctx, serviceMethod, args, reply)
+}
+
+// Stream opens a stream by the XClient of servicePath.
+func (i *XClientInvoker) Stream(ctx context.Context, servicePath string, meta map[string]string) (net.Conn, error) {
+	xc, err := i.xclient(servicePath)
+	if err != nil {
+		return nil, err
+	}
+	return xc.Stream(ctx, meta)
+}
+
+// Close closes the created XClients.
+func (i *XClientInvoker) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var err error
+	for servicePath, xc := range i.clients {
+		if e := xc.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(i.clients, servicePath)
+	}
+	return err
+}
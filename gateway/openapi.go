@@ -0,0 +1,584 @@
+package gateway
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpenAPIPath is the path of the OpenAPI spec served by the gateway.
+const OpenAPIPath = "/openapi.json"
+
+// Describer is implemented by services which describe their methods in OpenAPI specs.
+type Describer interface {
+	// Describe returns the summary and the description of method.
+	Describe(method string) (summary, description string)
+}
+
+// methodType is the types of the args and the reply of a service method.
+type methodType struct {
+	args, reply reflect.Type
+	describer   Describer
+}
+
+var (
+	typeOfContext       = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfError         = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfTime          = reflect.TypeOf(time.Time{})
+	typeOfRaw           = reflect.TypeOf(json.RawMessage(nil))
+	typeOfMarshaler     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	typeOfTextMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// WithOpenAPIInfo sets the title and the version of the API in OpenAPI specs.
+func WithOpenAPIInfo(title, version string) OptionFn {
+	return func(g *Gateway) {
+		g.title, g.version = title, version
+	}
+}
+
+// Register records the types of methods of the service for OpenAPI specs.
+// The gateway can be added to the plugins of a server before services are registered,
+// or services of remote servers can be registered to the gateway directly.
+func (g *Gateway) Register(name string, rcvr interface{}, metadata string) error {
+	typ := reflect.TypeOf(rcvr)
+	describer, _ := rcvr.(Describer)
+	methods := make(map[string]methodType)
+	for i := 0; i < typ.NumMethod(); i++ {
+		m := typ.Method(i)
+		// the receiver, the context, args and reply
+		if mt := m.Type; mt.NumIn() == 4 && mt.In(1) == typeOfContext && mt.NumOut() == 1 && mt.Out(0) == typeOfError {
+			methods[m.Name] = methodType{args: mt.In(2), reply: mt.In(3), describer: describer}
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.services == nil {
+		g.services = make(map[string]map[string]methodType)
+	}
+	if g.services[name] == nil {
+		g.services[name] = make(map[string]methodType)
+	}
+	for mname, mt := range methods {
+		g.services[name][mname] = mt
+	}
+	return nil
+}
+
+// RegisterFunction records the types of the function of the service for OpenAPI specs.
+func (g *Gateway) RegisterFunction(serviceName, fname string, fn interface{}, metadata string) error {
+	ft := reflect.TypeOf(fn)
+	if ft.Kind() != reflect.Func || ft.NumIn() != 3 || ft.In(0) != typeOfContext {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.services == nil {
+		g.services = make(map[string]map[string]methodType)
+	}
+	if g.services[serviceName] == nil {
+		g.services[serviceName] = make(map[string]methodType)
+	}
+	g.services[serviceName][fname] = methodType{args: ft.In(1), reply: ft.In(2)}
+	return nil
+}
+
+// Unregister forgets the types of the service.
+func (g *Gateway) Unregister(name string) error {
+	g.mu.Lock()
+	delete(g.services, name)
+	g.mu.Unlock()
+	return nil
+}
+
+// Describe sets the summary and the description of the operation of the route of method and pattern,
+// which override the description of the service.
+func (g *Gateway) Describe(method, pattern, summary, description string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, r := range g.router.routes {
+		if r.info.Method == strings.ToUpper(method) && r.info.Pattern == pattern {
+			r.summary, r.description = summary, description
+			return nil
+		}
+	}
+	return fmt.Errorf("gateway: no route %s %s", method, pattern)
+}
+
+// OpenAPISpec returns the OpenAPI 3 spec of the routes in JSON, which is also served at OpenAPIPath.
+// Schemas are generated from the types of args and replies by their fields and json tags.
+// Fields are required unless they are pointers or omitempty, and fields with the tag binding:"required" are required.
+//
+// The spec is the same for the same routes and services, so it can be committed and diffed.
+func (g *Gateway) OpenAPISpec() ([]byte, error) {
+	g.mu.RLock()
+	routes := append([]*route(nil), g.router.routes...)
+	services := make(map[string]map[string]methodType, len(g.services))
+	for name, methods := range g.services {
+		services[name] = methods
+	}
+	g.mu.RUnlock()
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].info.Pattern != routes[j].info.Pattern {
+			return routes[i].info.Pattern < routes[j].info.Pattern
+		}
+		return routes[i].info.Method < routes[j].info.Method
+	})
+
+	gen := newSchemaGenerator()
+	errorSchema := gen.schemaOf(reflect.TypeOf(Error{}))
+	spec := &openAPI{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: g.title, Version: g.version},
+		Paths:   make(map[string]map[string]*operation),
+	}
+
+	ids := make(map[string]bool)
+	for _, r := range routes {
+		mt := services[r.info.ServicePath][r.info.ServiceMethod]
+		op := gen.operation(r, mt, errorSchema)
+		op.OperationID = uniqueID(ids, r.info.ServicePath+"."+r.info.ServiceMethod)
+
+		item := spec.Paths[r.info.Pattern]
+		if item == nil {
+			item = make(map[string]*operation)
+			spec.Paths[r.info.Pattern] = item
+		}
+		item[strings.ToLower(r.info.Method)] = op
+	}
+	spec.Components.Schemas = gen.schemas
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+func uniqueID(ids map[string]bool, id string) string {
+	unique := id
+	for i := 2; ids[unique]; i++ {
+		unique = fmt.Sprintf("%s_%d", id, i)
+	}
+	ids[unique] = true
+	return unique
+}
+
+func (g *Gateway) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	data, err := g.OpenAPISpec()
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+type openAPI struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       openAPIInfo                      `json:"info"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas,omitempty"`
+	} `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*parameter         `json:"parameters,omitempty"`
+	RequestBody *requestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*response `json:"responses"`
+}
+
+type parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*mediaType `json:"content"`
+}
+
+type response struct {
+	Description string                `json:"description"`
+	Content     map[string]*mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+}
+
+func jsonContent(s *schema) map[string]*mediaType {
+	return map[string]*mediaType{"application/json": {Schema: s}}
+}
+
+// operation returns the operation of r, whose args and reply are of mt if the service method is registered.
+func (gen *schemaGenerator) operation(r *route, mt methodType, errorSchema *schema) *operation {
+	op := &operation{
+		Tags:      []string{r.info.ServicePath},
+		Summary:   r.summary,
+		Responses: map[string]*response{"default": {Description: "error", Content: jsonContent(errorSchema)}},
+	}
+	if op.Summary == "" && r.description == "" && mt.describer != nil {
+		op.Summary, op.Description = mt.describer.Describe(r.info.ServiceMethod)
+	} else {
+		op.Description = r.description
+	}
+
+	if r.stream {
+		for _, bd := range r.binder.bindings {
+			op.Parameters = append(op.Parameters, gen.parameter(bd, nil, false))
+		}
+		op.Responses["200"] = &response{
+			Description: "stream of JSON values",
+			Content: map[string]*mediaType{
+				"application/x-ndjson": {Schema: &schema{}},
+				"text/event-stream":    {Schema: &schema{Type: "string"}},
+			},
+		}
+		return op
+	}
+
+	args := r.binder.args
+	if args == nil && mt.args != nil {
+		args = indirect(mt.args)
+	}
+	var fields map[string]reflect.StructField
+	if args != nil && args.Kind() == reflect.Struct {
+		fields = jsonFields(args)
+	}
+
+	bound := make(map[string]bool)
+	var bodyField string
+	hasBody := false
+	for _, bd := range r.binder.bindings {
+		if bd.Source == FromBody {
+			bodyField, hasBody = bd.Field, true
+			continue
+		}
+		f, ok := fields[bd.field()]
+		op.Parameters = append(op.Parameters, gen.parameter(bd, &f, ok && r.binder.args != nil))
+		bound[bd.field()] = true
+	}
+
+	if r.info.Method != http.MethodGet && r.info.Method != http.MethodHead && r.info.Method != http.MethodDelete || hasBody {
+		op.RequestBody = gen.requestBody(args, fields, bound, bodyField)
+	}
+
+	replySchema := &schema{}
+	if mt.reply != nil {
+		replySchema = gen.schemaOf(mt.reply)
+	}
+	op.Responses["200"] = &response{Description: "reply", Content: jsonContent(replySchema)}
+	return op
+}
+
+// parameter returns the parameter of bd. It is a string unless it is bound to f of a typed route.
+// Path parameters are required, and other parameters are required if they have the tag binding:"required".
+func (gen *schemaGenerator) parameter(bd Binding, f *reflect.StructField, typed bool) *parameter {
+	p := &parameter{Name: bd.Name, Schema: &schema{Type: "string"}}
+	switch bd.Source {
+	case FromPath:
+		p.In, p.Required = "path", true
+	case FromQuery:
+		p.In = "query"
+	case FromHeader:
+		p.In = "header"
+	}
+	if typed {
+		p.Schema = gen.schemaOf(f.Type)
+		p.Required = p.Required || bindingRequired(*f)
+	}
+	return p
+}
+
+// requestBody returns the body of args without the bound fields, or the schema of bodyField if the body is bound to it.
+func (gen *schemaGenerator) requestBody(args reflect.Type, fields map[string]reflect.StructField, bound map[string]bool, bodyField string) *requestBody {
+	body := &requestBody{}
+	switch {
+	case bodyField != "":
+		s := &schema{}
+		if f, ok := fields[bodyField]; ok {
+			s = gen.schemaOf(f.Type)
+			body.Required = requiredField(f)
+		}
+		body.Content = jsonContent(s)
+	case args == nil:
+		body.Content = jsonContent(&schema{Type: "object"})
+	case len(bound) == 0 || fields == nil:
+		body.Content = jsonContent(gen.schemaOf(args))
+	default:
+		s := &schema{Type: "object", Properties: make(map[string]*schema)}
+		for _, name := range sortedFieldNames(args) {
+			if bound[name] {
+				continue
+			}
+			f := fields[name]
+			s.Properties[name] = gen.schemaOf(f.Type)
+			if requiredField(f) {
+				s.Required = append(s.Required, name)
+			}
+		}
+		if len(s.Properties) == 0 {
+			return nil
+		}
+		body.Content = jsonContent(s)
+	}
+	return body
+}
+
+// schemaGenerator generates schemas of types. Named struct types are generated as components,
+// which are referred to by their names, so recursive types are referred to instead of being expanded.
+type schemaGenerator struct {
+	schemas map[string]*schema
+	names   map[reflect.Type]string
+	types   map[string]reflect.Type
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{
+		schemas: make(map[string]*schema),
+		names:   make(map[reflect.Type]string),
+		types:   make(map[string]reflect.Type),
+	}
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func (gen *schemaGenerator) schemaOf(t reflect.Type) *schema {
+	t = indirect(t)
+	switch {
+	case t == typeOfTime:
+		return &schema{Type: "string", Format: "date-time"}
+	case t == typeOfRaw, t.Implements(typeOfMarshaler), reflect.PtrTo(t).Implements(typeOfMarshaler):
+		return &schema{}
+	case t.Implements(typeOfTextMarshaler), reflect.PtrTo(t).Implements(typeOfTextMarshaler):
+		return &schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		zero := 0.0
+		return &schema{Type: "integer", Format: "int32", Minimum: &zero}
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		zero := 0.0
+		return &schema{Type: "integer", Format: "int64", Minimum: &zero}
+	case reflect.Float32:
+		return &schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: gen.schemaOf(t.Elem()), Nullable: true}
+	case reflect.Array:
+		n := t.Len()
+		return &schema{Type: "array", Items: gen.schemaOf(t.Elem()), MinItems: &n, MaxItems: &n}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: gen.schemaOf(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return gen.structSchema(t)
+		}
+		return gen.ref(t)
+	}
+	return &schema{} // interfaces and any values
+}
+
+// ref returns the reference of the component of the named struct t, which is generated at the first reference.
+func (gen *schemaGenerator) ref(t reflect.Type) *schema {
+	if name, ok := gen.names[t]; ok {
+		return &schema{Ref: "#/components/schemas/" + name}
+	}
+
+	name := componentName(t.String())
+	if other, ok := gen.types[name]; ok && other != t {
+		name = componentName(t.PkgPath() + "." + t.Name())
+		for i := 2; gen.types[name] != nil; i++ {
+			name = fmt.Sprintf("%s_%d", componentName(t.PkgPath()+"."+t.Name()), i)
+		}
+	}
+	gen.names[t] = name
+	gen.types[name] = t
+
+	// the component is added before its fields, so recursive references find it
+	s := &schema{}
+	gen.schemas[name] = s
+	*s = *gen.structSchema(t)
+	return &schema{Ref: "#/components/schemas/" + name}
+}
+
+// componentName replaces characters which are not allowed in names of components, such as brackets of generic types.
+func componentName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func (gen *schemaGenerator) structSchema(t reflect.Type) *schema {
+	s := &schema{Type: "object", Properties: make(map[string]*schema)}
+	fields := jsonFields(t)
+	for _, name := range sortedFieldNames(t) {
+		f := fields[name]
+		fs := gen.schemaOf(f.Type)
+		if quoted(f) {
+			fs = &schema{Type: "string"}
+		}
+		s.Properties[name] = fs
+		if requiredField(f) {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// jsonFields returns the exported fields of the struct t by their JSON names.
+// Fields of embedded structs are promoted unless they are hidden by shallower fields.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	return promotedFields(t, map[reflect.Type]bool{t: true})
+}
+
+func promotedFields(t reflect.Type, inPath map[reflect.Type]bool) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	var embedded []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && strings.Split(tag, ",")[0] == "" {
+			if ft := indirect(f.Type); ft.Kind() == reflect.Struct {
+				embedded = append(embedded, f)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		fields[jsonName(f)] = f
+	}
+	for _, ef := range embedded {
+		et := indirect(ef.Type)
+		if inPath[et] {
+			continue
+		}
+		inPath[et] = true
+		for name, f := range promotedFields(et, inPath) {
+			if _, ok := fields[name]; !ok {
+				f.Index = append(append([]int(nil), ef.Index...), f.Index...)
+				fields[name] = f
+			}
+		}
+		delete(inPath, et)
+	}
+	return fields
+}
+
+// sortedFieldNames returns the JSON names of fields of t in the order of the fields.
+func sortedFieldNames(t reflect.Type) []string {
+	fields := jsonFields(t)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := fields[names[i]].Index, fields[names[j]].Index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// requiredField returns true if f is required, which is not a pointer or omitempty, or has the tag binding:"required".
+func requiredField(f reflect.StructField) bool {
+	if bindingRequired(f) {
+		return true
+	}
+	if f.Type.Kind() == reflect.Ptr {
+		return false
+	}
+	for _, opt := range strings.Split(f.Tag.Get("json"), ",")[1:] {
+		if opt == "omitempty" {
+			return false
+		}
+	}
+	return true
+}
+
+func bindingRequired(f reflect.StructField) bool {
+	for _, opt := range strings.Split(f.Tag.Get("binding"), ",") {
+		if opt == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+// quoted returns true if f is encoded as a string by the string option of its json tag.
+func quoted(f reflect.StructField) bool {
+	for _, opt := range strings.Split(f.Tag.Get("json"), ",")[1:] {
+		if opt == "string" {
+			switch indirect(f.Type).Kind() {
+			case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+				reflect.Float32, reflect.Float64, reflect.String:
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,347 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type Node struct {
+	Name     string  `json:"name"`
+	Parent   *Node   `json:"parent,omitempty"`
+	Children []*Node `json:"children"`
+}
+
+type Audit struct {
+	CreatedAt time.Time `json:"created_at"`
+	Creator   string    `json:"creator,omitempty"`
+}
+
+type Document struct {
+	Audit
+	ID      int64             `json:"id,string"`
+	Title   string            `json:"title" binding:"required"`
+	Body    *string           `json:"body"`
+	Data    []byte            `json:"data,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Extra   json.RawMessage   `json:"extra,omitempty"`
+	Any     interface{}       `json:"any,omitempty"`
+	Point   [2]float64        `json:"point"`
+	Tree    Node              `json:"tree"`
+	Ignored string            `json:"-"`
+	hidden  string
+}
+
+type CreateDocumentArgs struct {
+	Space string   `json:"space" path:"space"`
+	Tags  []string `json:"tags,omitempty" query:"tag"`
+	Doc   Document `json:"doc"`
+}
+
+type DocumentService struct{}
+
+func (*DocumentService) Create(ctx context.Context, args *CreateDocumentArgs, reply *Document) error {
+	return nil
+}
+
+func (*DocumentService) Get(ctx context.Context, args *GetUserArgs, reply *Document) error {
+	return nil
+}
+
+func (*DocumentService) Tree(ctx context.Context, args *Node, reply *Node) error {
+	return nil
+}
+
+func (*DocumentService) Describe(method string) (string, string) {
+	if method == "Create" {
+		return "Create a document", "Creates a document in the space."
+	}
+	return "", ""
+}
+
+func newDocumentGateway(t *testing.T, reverse bool) *Gateway {
+	g := New(nil, WithOpenAPIInfo("documents", "1.2.3"))
+	routes := []func() error{
+		func() error {
+			return g.RouteArgs(http.MethodPost, "/v1/spaces/{space}/documents", "DocumentService", "Create", CreateDocumentArgs{})
+		},
+		func() error {
+			return g.RouteArgs(http.MethodGet, "/v1/documents/{id}", "DocumentService", "Get", GetUserArgs{})
+		},
+		func() error { return g.Route(http.MethodPut, "/v1/trees/{name}", "DocumentService", "Tree") },
+		func() error {
+			return g.Route(http.MethodPost, "/v1/trees", "DocumentService", "Tree", Body("children"))
+		},
+		func() error { return g.Route(http.MethodPost, "/v1/unknown", "UnknownService", "Do") },
+		func() error { return g.Stream(http.MethodGet, "/v1/events/{topic}", "", SSE, Query("since", "")) },
+	}
+	if reverse {
+		for i, j := 0, len(routes)-1; i < j; i, j = i+1, j-1 {
+			routes[i], routes[j] = routes[j], routes[i]
+		}
+	}
+	assert.NoError(t, g.Register("DocumentService", new(DocumentService), ""))
+	for _, route := range routes {
+		assert.NoError(t, route())
+	}
+	assert.NoError(t, g.Describe(http.MethodGet, "/v1/documents/{id}", "Get a document", ""))
+	assert.Error(t, g.Describe(http.MethodGet, "/v1/none", "", ""))
+	return g
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	g := newDocumentGateway(t, false)
+	data, err := g.OpenAPISpec()
+	assert.NoError(t, err)
+	validateOpenAPI(t, data)
+
+	// the spec is the same for the same routes in any order
+	other, err := newDocumentGateway(t, true).OpenAPISpec()
+	assert.NoError(t, err)
+	assert.Equal(t, string(data), string(other))
+
+	var spec struct {
+		Info struct {
+			Title, Version string
+		}
+		Paths      map[string]map[string]json.RawMessage
+		Components struct {
+			Schemas map[string]json.RawMessage
+		}
+	}
+	assert.NoError(t, json.Unmarshal(data, &spec))
+	assert.Equal(t, "documents", spec.Info.Title)
+	assert.Equal(t, "1.2.3", spec.Info.Version)
+
+	assert.JSONEq(t, `{
+		"type": "object",
+		"properties": {
+			"created_at": {"type": "string", "format": "date-time"},
+			"creator": {"type": "string"},
+			"id": {"type": "string"},
+			"title": {"type": "string"},
+			"body": {"type": "string"},
+			"data": {"type": "string", "format": "byte"},
+			"labels": {"type": "object", "additionalProperties": {"type": "string"}, "nullable": true},
+			"extra": {},
+			"any": {},
+			"point": {"type": "array", "items": {"type": "number", "format": "double"}, "minItems": 2, "maxItems": 2},
+			"tree": {"$ref": "#/components/schemas/gateway.Node"}
+		},
+		"required": ["created_at", "id", "title", "point", "tree"]
+	}`, string(spec.Components.Schemas["gateway.Document"]))
+	assert.JSONEq(t, `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"parent": {"$ref": "#/components/schemas/gateway.Node"},
+			"children": {"type": "array", "items": {"$ref": "#/components/schemas/gateway.Node"}, "nullable": true}
+		},
+		"required": ["name", "children"]
+	}`, string(spec.Components.Schemas["gateway.Node"]))
+
+	assert.JSONEq(t, `{
+		"operationId": "DocumentService.Create",
+		"summary": "Create a document",
+		"description": "Creates a document in the space.",
+		"tags": ["DocumentService"],
+		"parameters": [
+			{"name": "space", "in": "path", "required": true, "schema": {"type": "string"}},
+			{"name": "tag", "in": "query", "schema": {"type": "array", "items": {"type": "string"}, "nullable": true}}
+		],
+		"requestBody": {
+			"content": {"application/json": {"schema": {
+				"type": "object",
+				"properties": {"doc": {"$ref": "#/components/schemas/gateway.Document"}},
+				"required": ["doc"]
+			}}}
+		},
+		"responses": {
+			"200": {"description": "reply", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/gateway.Document"}}}},
+			"default": {"description": "error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/gateway.Error"}}}}
+		}
+	}`, string(spec.Paths["/v1/spaces/{space}/documents"]["post"]))
+
+	compact := func(data json.RawMessage) string {
+		var buf bytes.Buffer
+		assert.NoError(t, json.Compact(&buf, data))
+		return buf.String()
+	}
+	get := compact(spec.Paths["/v1/documents/{id}"]["get"])
+	assert.Contains(t, get, `"summary":"Get a document"`)
+	assert.Contains(t, get, `{"name":"id","in":"path","required":true,"schema":{"type":"integer","format":"int64"}}`)
+	assert.Contains(t, get, `{"name":"X-Trace-Id","in":"header","schema":{"type":"string"}}`)
+	assert.NotContains(t, get, "requestBody")
+
+	assert.Contains(t, compact(spec.Paths["/v1/trees"]["post"]),
+		`"requestBody":{"required":true,"content":{"application/json":{"schema":{"type":"array","nullable":true,"items":{"$ref":"#/components/schemas/gateway.Node"}}}}}`)
+	put := compact(spec.Paths["/v1/trees/{name}"]["put"])
+	assert.Contains(t, put, `"operationId":"DocumentService.Tree_2"`)
+	// the bound name is a parameter instead of a property of the body
+	assert.Contains(t, put, `"required":["children"]`)
+	assert.NotContains(t, put, `"name":{"type":"string"}`)
+	assert.Contains(t, compact(spec.Paths["/v1/unknown"]["post"]), `"requestBody":{"content":{"application/json":{"schema":{"type":"object"}}}}`)
+	assert.Contains(t, compact(spec.Paths["/v1/events/{topic}"]["get"]),
+		`"parameters":[{"name":"since","in":"query","schema":{"type":"string"}},{"name":"topic","in":"path","required":true,"schema":{"type":"string"}}]`)
+
+	hs := httptest.NewServer(g)
+	defer hs.Close()
+	resp, body := do(t, http.MethodGet, hs.URL+OpenAPIPath, "", nil)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, strings.TrimSpace(string(data)), body)
+}
+
+func TestOpenAPISpecOfServer(t *testing.T) {
+	s := server.NewServer()
+	g := New(NewServerInvoker(s))
+	s.Plugins.Add(g)
+	s.RegisterName("DocumentService", new(DocumentService), "")
+	s.RegisterFunction("Functions", Tree, "")
+	assert.NoError(t, g.Route(http.MethodPut, "/v1/trees/{name}", "Functions", "Tree"))
+
+	data, err := g.OpenAPISpec()
+	assert.NoError(t, err)
+	validateOpenAPI(t, data)
+	assert.Contains(t, string(data), `"$ref": "#/components/schemas/gateway.Node"`)
+
+	s.UnregisterAll()
+	data, err = g.OpenAPISpec()
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "gateway.Node")
+}
+
+func Tree(ctx context.Context, args *Node, reply *Node) error {
+	return nil
+}
+
+var (
+	componentNamePattern = regexp.MustCompile(`^[a-zA-Z0-9\.\-_]+$`)
+	versionPattern       = regexp.MustCompile(`^3\.0\.\d+$`)
+	templatePattern      = regexp.MustCompile(`\{([^}]+)\}`)
+)
+
+var schemaKeywords = map[string]bool{
+	"$ref": true, "type": true, "format": true, "nullable": true, "minimum": true, "minItems": true, "maxItems": true,
+	"items": true, "properties": true, "required": true, "additionalProperties": true, "description": true,
+}
+
+// validateOpenAPI validates data by the rules of the OpenAPI 3.0 schema for the objects generated by the gateway.
+func validateOpenAPI(t *testing.T, data []byte) {
+	t.Helper()
+	var spec map[string]interface{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Regexp(t, versionPattern, spec["openapi"])
+	info := spec["info"].(map[string]interface{})
+	assert.NotEmpty(t, info["title"])
+	assert.NotEmpty(t, info["version"])
+
+	schemas, _ := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for name, s := range schemas {
+		assert.Regexp(t, componentNamePattern, name)
+		validateSchema(t, schemas, s)
+	}
+
+	ids := make(map[string]bool)
+	for path, item := range spec["paths"].(map[string]interface{}) {
+		assert.True(t, strings.HasPrefix(path, "/"), path)
+		var vars []string
+		for _, m := range templatePattern.FindAllStringSubmatch(path, -1) {
+			vars = append(vars, m[1])
+		}
+
+		for method, v := range item.(map[string]interface{}) {
+			assert.Contains(t, []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}, method)
+			op := v.(map[string]interface{})
+			id := op["operationId"].(string)
+			assert.False(t, ids[id], "duplicated operationId %s", id)
+			ids[id] = true
+
+			var pathParams []string
+			params, _ := op["parameters"].([]interface{})
+			for _, p := range params {
+				p := p.(map[string]interface{})
+				assert.NotEmpty(t, p["name"])
+				assert.Contains(t, []string{"path", "query", "header", "cookie"}, p["in"])
+				if p["in"] == "path" {
+					assert.Equal(t, true, p["required"], "path parameters must be required")
+					pathParams = append(pathParams, p["name"].(string))
+				}
+				validateSchema(t, schemas, p["schema"])
+			}
+			assert.ElementsMatch(t, vars, pathParams, "path parameters of %s %s", method, path)
+
+			if body, ok := op["requestBody"].(map[string]interface{}); ok {
+				validateContent(t, schemas, body["content"])
+			}
+			responses := op["responses"].(map[string]interface{})
+			assert.NotEmpty(t, responses)
+			for code, r := range responses {
+				assert.Regexp(t, `^(default|[1-5]\d\d)$`, code)
+				r := r.(map[string]interface{})
+				assert.NotEmpty(t, r["description"])
+				if content, ok := r["content"]; ok {
+					validateContent(t, schemas, content)
+				}
+			}
+		}
+	}
+}
+
+func validateContent(t *testing.T, schemas map[string]interface{}, content interface{}) {
+	t.Helper()
+	media := content.(map[string]interface{})
+	assert.NotEmpty(t, media)
+	for _, m := range media {
+		validateSchema(t, schemas, m.(map[string]interface{})["schema"])
+	}
+}
+
+func validateSchema(t *testing.T, schemas map[string]interface{}, v interface{}) {
+	t.Helper()
+	s, ok := v.(map[string]interface{})
+	if !assert.True(t, ok, "schema must be an object") {
+		return
+	}
+	for k := range s {
+		assert.True(t, schemaKeywords[k], "unknown keyword %s", k)
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		assert.Len(t, s, 1, "$ref must not have siblings")
+		assert.Contains(t, schemas, strings.TrimPrefix(ref, "#/components/schemas/"))
+		return
+	}
+	if typ, ok := s["type"]; ok {
+		assert.Contains(t, []string{"array", "boolean", "integer", "number", "object", "string"}, typ)
+		if typ == "array" {
+			assert.Contains(t, s, "items")
+		}
+	}
+	if items, ok := s["items"]; ok {
+		validateSchema(t, schemas, items)
+	}
+	if ap, ok := s["additionalProperties"]; ok {
+		validateSchema(t, schemas, ap)
+	}
+	props, _ := s["properties"].(map[string]interface{})
+	for _, p := range props {
+		validateSchema(t, schemas, p)
+	}
+	if required, ok := s["required"].([]interface{}); ok {
+		assert.NotEmpty(t, required)
+		for _, r := range required {
+			assert.Contains(t, props, r)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// segment is a segment of a route pattern, which is a static segment or a variable like "{id}".
+type segment struct {
+	value    string
+	variable bool
+}
+
+// route maps requests of a method and a pattern to a service method.
+type route struct {
+	info     RouteInfo
+	segments []segment
+	binder   *binder
+	stream   bool
+	handler  http.Handler
+
+	// summary and description of the operation in OpenAPI specs
+	summary     string
+	description string
+}
+
+// parsePattern parses the segments of pattern, such as "/v1/users/{id}".
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("gateway: pattern %q must begin with /", pattern)
+	}
+
+	var segments []segment
+	vars := make(map[string]bool)
+	for _, s := range strings.Split(pattern[1:], "/") {
+		if !strings.ContainsAny(s, "{}") {
+			segments = append(segments, segment{value: s})
+			continue
+		}
+		if len(s) < 3 || s[0] != '{' || s[len(s)-1] != '}' || strings.ContainsAny(s[1:len(s)-1], "{}") {
+			return nil, fmt.Errorf("gateway: invalid segment %q of pattern %q", s, pattern)
+		}
+		name := s[1 : len(s)-1]
+		if vars[name] {
+			return nil, fmt.Errorf("gateway: duplicated variable %q of pattern %q", name, pattern)
+		}
+		vars[name] = true
+		segments = append(segments, segment{value: name, variable: true})
+	}
+	return segments, nil
+}
+
+// conflicts returns true if r and other match the same requests, which have the same method, and
+// the same static segments and variables at the same positions.
+func (r *route) conflicts(other *route) bool {
+	if r.info.Method != other.info.Method || len(r.segments) != len(other.segments) {
+		return false
+	}
+	for i, s := range r.segments {
+		o := other.segments[i]
+		if s.variable != o.variable || (!s.variable && s.value != o.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// match returns the path variables if r matches the path segments.
+func (r *route) match(parts []string) (map[string]string, bool) {
+	if len(parts) != len(r.segments) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, s := range r.segments {
+		if !s.variable {
+			if parts[i] != s.value {
+				return nil, false
+			}
+			continue
+		}
+		if parts[i] == "" {
+			return nil, false
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[s.value] = parts[i]
+	}
+	return params, true
+}
+
+// moreSpecific returns true if r is preferred to other for the same path,
+// that is r has a static segment at the first position where they differ.
+func (r *route) moreSpecific(other *route) bool {
+	for i, s := range r.segments {
+		if s.variable != other.segments[i].variable {
+			return !s.variable
+		}
+	}
+	return false
+}
+
+// router finds routes of requests. Static segments win over variables.
+type router struct {
+	routes []*route
+}
+
+// add adds r, or returns an error if it conflicts with an added route.
+func (rt *router) add(r *route) error {
+	for _, other := range rt.routes {
+		if r.conflicts(other) {
+			return fmt.Errorf("gateway: route %s %s conflicts with %s %s", r.info.Method, r.info.Pattern,
+				other.info.Method, other.info.Pattern)
+		}
+	}
+	rt.routes = append(rt.routes, r)
+	return nil
+}
+
+// find returns the route of method and the escaped path with its path variables,
+// and the allowed methods of path if no route of method matches it.
+// Segments are unescaped after the path is split, so variables can contain escaped slashes.
+func (rt *router) find(method, path string) (*route, map[string]string, []string) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, nil, nil
+	}
+	parts := strings.Split(path[1:], "/")
+	for i, p := range parts {
+		s, err := url.PathUnescape(p)
+		if err != nil {
+			return nil, nil, nil
+		}
+		parts[i] = s
+	}
+
+	var (
+		found   *route
+		params  map[string]string
+		allowed []string
+	)
+	for _, r := range rt.routes {
+		ps, ok := r.match(parts)
+		if !ok {
+			continue
+		}
+		if r.info.Method != method {
+			allowed = appendMethod(allowed, r.info.Method)
+			continue
+		}
+		if found == nil || r.moreSpecific(found) {
+			found, params = r, ps
+		}
+	}
+	return found, params, allowed
+}
+
+func appendMethod(methods []string, method string) []string {
+	for _, m := range methods {
+		if m == method {
+			return methods
+		}
+	}
+	return append(methods, method)
+}
@@ -4,38 +4,47 @@ go 1.16
 
 require (
 	github.com/ChimeraCoder/gojson v1.1.0
-	github.com/akutz/memconn v0.1.0 // indirect
+	github.com/akutz/memconn v0.1.0
 	github.com/apache/thrift v0.14.0
 	github.com/cenk/backoff v2.2.1+incompatible // indirect
 	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
 	github.com/edwingeng/doublejump v0.0.0-20200219153503-7cfc0ed6e836
 	github.com/facebookgo/clock v0.0.0-20150410010913-600d898af40a // indirect
 	github.com/fatih/color v1.10.0
+	github.com/fxamacker/cbor/v2 v2.5.0
 	github.com/go-ping/ping v0.0.0-20201115131931-3300c582a663
+	github.com/go-redis/redis/v8 v8.8.2
 	github.com/gogo/protobuf v1.3.1
 	github.com/golang/protobuf v1.5.2
-	github.com/golang/snappy v0.0.2
+	github.com/golang/snappy v0.0.4
+	github.com/google/flatbuffers v2.0.8+incompatible
+	github.com/gorilla/websocket v1.5.0
 	github.com/grandcat/zeroconf v0.0.0-20180329153754-df75bb3ccae1
+	github.com/hamba/avro/v2 v2.4.0
+	github.com/hashicorp/consul/api v1.8.1
+	github.com/hashicorp/consul/sdk v0.7.0
 	github.com/hashicorp/go-multierror v1.1.0
 	github.com/hashicorp/golang-lru v0.5.4
 	github.com/influxdata/influxdb1-client v0.0.0-20200827194710-b269163b24ab
-	github.com/json-iterator/go v1.1.10
+	github.com/json-iterator/go v1.1.12
 	github.com/juju/ratelimit v1.0.1
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/kavu/go_reuseport v1.5.0
+	github.com/klauspost/compress v1.15.9
 	github.com/klauspost/reedsolomon v1.9.10 // indirect
 	github.com/kr/pretty v0.2.0
 	github.com/lucas-clemente/quic-go v0.23.0
 	github.com/opentracing/opentracing-go v1.1.1-0.20190913142402-a7454ce5950e
 	github.com/peterbourgon/g2s v0.0.0-20140925154142-ec76db4c1ac1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/client_golang v1.4.0
 	github.com/rcrowley/go-metrics v0.0.0-20200313005456-10cdbea86bc0
 	github.com/rpcxio/libkv v0.5.1-0.20210420120011-1fceaedca8a5
 	github.com/rs/cors v1.7.0
 	github.com/rubyist/circuitbreaker v2.2.1+incompatible
 	github.com/smallnest/quick v0.0.0-20200505103731-c8c83f9c76d3
 	github.com/soheilhy/cmux v0.1.4
-	github.com/stretchr/testify v1.7.0
+	github.com/stretchr/testify v1.7.1
 	github.com/templexxx/cpufeat v0.0.0-20180724012125-cef66df7f161 // indirect
 	github.com/templexxx/xor v0.0.0-20191217153810-f85b25db303b // indirect
 	github.com/tinylib/msgp v1.1.6
@@ -46,8 +55,16 @@ require (
 	github.com/xtaci/kcp-go v5.4.20+incompatible
 	github.com/xtaci/lossyconn v0.0.0-20200209145036-adba10fffc37 // indirect
 	go.opencensus.io v0.22.2
+	go.opentelemetry.io/otel v0.19.0
+	go.opentelemetry.io/otel/oteltest v0.19.0
+	go.opentelemetry.io/otel/sdk v0.19.0
+	go.opentelemetry.io/otel/trace v0.19.0
+	golang.org/x/crypto v0.0.0-20201012173705-84dcc777aaee
 	golang.org/x/net v0.0.0-20210428140749-89ef3d95e781
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
-	google.golang.org/grpc/examples v0.0.0-20210823233914-c361e9ea1646 // indirect
+	golang.org/x/sys v0.0.0-20210510120138-977fb7262007
+	google.golang.org/genproto v0.0.0-20200806141610-86f49bd18e98
+	google.golang.org/grpc v1.36.0
+	google.golang.org/grpc/examples v0.0.0-20210823233914-c361e9ea1646
 	google.golang.org/protobuf v1.26.0
 )
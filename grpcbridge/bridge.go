@@ -0,0 +1,142 @@
+// Package grpcbridge bridges rpcx and gRPC for unary calls of protobuf messages.
+//
+// ServerPlugin serves services of an rpcx server over gRPC on the listener of the server,
+// and Client calls gRPC servers for rpcx clients such as XClient.
+//
+// Metadata is mapped to gRPC metadata with lowercase keys, and the auth of rpcx to the authorization header.
+// Error codes of rpcx, which are compatible with gRPC codes, are mapped to gRPC status codes,
+// and their details to the metadata of a google.rpc.ErrorInfo detail.
+// Deadlines are propagated by the timeouts of gRPC, and trace contexts, such as traceparent of the W3C trace context,
+// are propagated as metadata.
+package grpcbridge
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/share"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain is the domain of ErrorInfo details of rpcx errors.
+const errorDomain = "rpcx"
+
+// authorization is the gRPC metadata key of the auth of rpcx.
+const authorization = "authorization"
+
+// ErrStreamingUnsupported is returned for streaming calls, which are not bridged.
+var ErrStreamingUnsupported = status.Error(codes.Unimplemented, "rpcx: streaming is not supported by the gRPC bridge")
+
+// toGRPCMetadata converts rpcx metadata to gRPC metadata. Keys which are not valid gRPC metadata keys are ignored.
+func toGRPCMetadata(meta map[string]string) metadata.MD {
+	md := make(metadata.MD, len(meta))
+	for k, v := range meta {
+		switch k {
+		case share.AuthKey:
+			k = authorization
+		case share.ServerTimeout: // the deadline is sent as the timeout of gRPC
+			continue
+		}
+		k = strings.ToLower(k)
+		if !validMetadataKey(k) || (!strings.HasSuffix(k, "-bin") && !printable(v)) {
+			continue
+		}
+		md[k] = []string{v}
+	}
+	return md
+}
+
+// fromGRPCMetadata converts gRPC metadata to rpcx metadata, ignoring reserved headers of gRPC.
+func fromGRPCMetadata(md metadata.MD) map[string]string {
+	meta := make(map[string]string, len(md))
+	for k, v := range md {
+		if len(v) == 0 || reservedMetadataKey(k) {
+			continue
+		}
+		if k == authorization {
+			k = share.AuthKey
+		}
+		meta[k] = v[0]
+	}
+	return meta
+}
+
+func reservedMetadataKey(k string) bool {
+	switch k {
+	case "content-type", "user-agent", "te":
+		return true
+	}
+	return strings.HasPrefix(k, ":") || strings.HasPrefix(k, "grpc-")
+}
+
+func validMetadataKey(k string) bool {
+	if k == "" || reservedMetadataKey(k) {
+		return false
+	}
+	for i := 0; i < len(k); i++ {
+		c := k[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func printable(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if v[i] < 0x20 || v[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// statusError converts an error of rpcx to a gRPC status error with the same code and details.
+func statusError(err error) error {
+	code := codes.Code(rerrors.CodeOf(err))
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	}
+	st := status.New(code, err.Error())
+	if details := rerrors.DetailsOf(err); len(details) > 0 {
+		if ds, derr := st.WithDetails(&errdetails.ErrorInfo{
+			Reason:   rerrors.Code(code).String(),
+			Domain:   errorDomain,
+			Metadata: details,
+		}); derr == nil {
+			st = ds
+		}
+	}
+	return st.Err()
+}
+
+// serviceError converts a gRPC status error to a client.CodedServiceError with the same code and details,
+// so that it is handled like errors of rpcx servers. Other errors are returned as they are.
+func serviceError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() == codes.OK {
+		return err
+	}
+
+	var details map[string]string
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			for k, v := range info.Metadata {
+				if details == nil {
+					details = make(map[string]string)
+				}
+				details[k] = v
+			}
+		}
+	}
+	return client.NewServiceError(rerrors.Code(st.Code()), st.Message(), details)
+}
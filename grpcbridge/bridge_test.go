@@ -0,0 +1,298 @@
+package grpcbridge
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/serverplugin"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/oteltest"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Greeter is an rpcx service of the helloworld example of gRPC.
+type Greeter struct{}
+
+func (*Greeter) SayHello(ctx context.Context, args *pb.HelloRequest, reply *pb.HelloReply) error {
+	meta := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	switch args.Name {
+	case "missing":
+		return rerrors.New(rerrors.NotFound, "no such user").WithDetail("name", args.Name)
+	case "deadline":
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return errors.New("no deadline")
+		}
+		reply.Message = time.Until(deadline).Round(time.Second).String()
+		return nil
+	case "slow":
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	ctx.Value(share.ResMetaDataKey).(map[string]string)["served-by"] = "rpcx"
+	reply.Message = "Hello " + args.Name + " of " + meta["tenant"] + meta["traceparent"]
+	return nil
+}
+
+func startBridgeServer(t *testing.T, desc *grpc.ServiceDesc, plugins ...server.Plugin) (*server.Server, string) {
+	s := server.NewServer()
+	p := NewServerPlugin(s)
+	s.Plugins.Add(p)
+	for _, plugin := range plugins {
+		s.Plugins.Add(plugin)
+	}
+	s.RegisterName("Greeter", new(Greeter), "")
+	if err := p.Serve("Greeter", desc); err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve("tcp", "127.0.0.1:0")
+	t.Cleanup(func() {
+		s.Close()
+		p.GRPCServer().Stop()
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, ErrServerStarted, p.Serve("Other", nil))
+	return s, s.Address().String()
+}
+
+func dialGRPC(t *testing.T, addr string) *grpc.ClientConn {
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// streamingDesc is the desc of Greeter with a streaming method.
+var streamingDesc = grpc.ServiceDesc{
+	ServiceName: pb.Greeter_ServiceDesc.ServiceName,
+	HandlerType: pb.Greeter_ServiceDesc.HandlerType,
+	Methods:     pb.Greeter_ServiceDesc.Methods,
+	Streams:     []grpc.StreamDesc{{StreamName: "Chat", ServerStreams: true, ClientStreams: true}},
+	Metadata:    pb.Greeter_ServiceDesc.Metadata,
+}
+
+func TestServerPlugin(t *testing.T) {
+	s, addr := startBridgeServer(t, &streamingDesc)
+	s.AuthFunc = func(ctx context.Context, req *protocol.Message, token string) error {
+		if token != "secret" {
+			return rerrors.New(rerrors.Unauthenticated, "bad token")
+		}
+		return nil
+	}
+	greeter := pb.NewGreeterClient(dialGRPC(t, addr))
+
+	// metadata and trace contexts are propagated in both directions
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "secret", "tenant", "acme",
+		"traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	var header metadata.MD
+	reply, err := greeter.SayHello(ctx, &pb.HelloRequest{Name: "bob"}, grpc.Header(&header))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Hello bob of acme00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", reply.Message)
+		assert.Equal(t, []string{"rpcx"}, header.Get("served-by"))
+	}
+
+	// deadlines are propagated
+	dctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if reply, err := greeter.SayHello(dctx, &pb.HelloRequest{Name: "deadline"}); assert.NoError(t, err) {
+		assert.Equal(t, "5s", reply.Message)
+	}
+	dctx, cancel = context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = greeter.SayHello(dctx, &pb.HelloRequest{Name: "slow"})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	// errors are mapped to status with codes and details
+	_, err = greeter.SayHello(ctx, &pb.HelloRequest{Name: "missing"})
+	st := status.Convert(err)
+	assert.Equal(t, codes.NotFound, st.Code())
+	assert.Equal(t, "no such user", st.Message())
+	if assert.Len(t, st.Details(), 1) {
+		info := st.Details()[0].(*errdetails.ErrorInfo)
+		assert.Equal(t, "rpcx", info.Domain)
+		assert.Equal(t, map[string]string{"name": "missing"}, info.Metadata)
+	}
+	_, err = greeter.SayHello(metadata.AppendToOutgoingContext(context.Background(), "authorization", "wrong"), &pb.HelloRequest{Name: "bob"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// streaming is not supported
+	stream, err := dialGRPC(t, addr).NewStream(ctx, &streamingDesc.Streams[0], "/helloworld.Greeter/Chat")
+	if assert.NoError(t, err) {
+		err = stream.RecvMsg(&pb.HelloReply{})
+		assert.Equal(t, codes.Unimplemented, status.Code(err))
+	}
+
+	// and the listener is still shared with rpcx clients
+	c := client.NewClient(client.Option{SerializeType: protocol.ProtoBuffer, ConnectTimeout: time.Second})
+	if err := c.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	rctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.AuthKey: "secret", "tenant": "acme"})
+	rpcxReply := &pb.HelloReply{}
+	if assert.NoError(t, c.Call(rctx, "Greeter", "SayHello", &pb.HelloRequest{Name: "alice"}, rpcxReply)) {
+		assert.Equal(t, "Hello alice of acme", rpcxReply.Message)
+	}
+}
+
+func TestServerPluginGeneratedDesc(t *testing.T) {
+	_, addr := startBridgeServer(t, nil)
+	conn := dialGRPC(t, addr)
+
+	// the gRPC service is named by the service path
+	reply := &pb.HelloReply{}
+	if assert.NoError(t, conn.Invoke(context.Background(), "/Greeter/SayHello", &pb.HelloRequest{Name: "bob"}, reply)) {
+		assert.Equal(t, "Hello bob of ", reply.Message)
+	}
+	err := conn.Invoke(context.Background(), "/Greeter/Missing", &pb.HelloRequest{}, reply)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+// grpcGreeter is a gRPC service implemented by grpc-go.
+type grpcGreeter struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (*grpcGreeter) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	switch in.Name {
+	case "missing":
+		st, _ := status.New(codes.NotFound, "no such user").WithDetails(&errdetails.ErrorInfo{
+			Reason:   "NotFound",
+			Domain:   "example.com",
+			Metadata: map[string]string{"name": in.Name},
+		})
+		return nil, st.Err()
+	case "deadline":
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return nil, status.Error(codes.FailedPrecondition, "no deadline")
+		}
+		return &pb.HelloReply{Message: time.Until(deadline).Round(time.Second).String()}, nil
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("served-by", "grpc"))
+	grpc.SetTrailer(ctx, metadata.Pairs("cost", "1"))
+	return &pb.HelloReply{Message: "Hello " + in.Name + " of " + first(md, "tenant") + " by " + first(md, "authorization")}, nil
+}
+
+func first(md metadata.MD, k string) string {
+	if v := md.Get(k); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func startGRPCServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterGreeterServer(s, new(grpcGreeter))
+	go s.Serve(ln)
+	t.Cleanup(s.Stop)
+	return ln.Addr().String()
+}
+
+func TestClient(t *testing.T) {
+	addr := startGRPCServer(t)
+	client.RegisterCacheClientBuilder(Network, NewClientBuilder(grpc.WithInsecure()))
+
+	d, _ := client.NewPeer2PeerDiscovery(Network+"@"+addr, "")
+	xc := client.NewXClient(pb.Greeter_ServiceDesc.ServiceName, client.Failfast, client.RandomSelect, d, client.DefaultOption)
+	defer xc.Close()
+	xc.Auth("token")
+
+	// metadata is mapped to gRPC metadata and back
+	resMeta := make(map[string]string)
+	ctx := context.WithValue(context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"tenant": "acme"}),
+		share.ResMetaDataKey, resMeta)
+	reply := &pb.HelloReply{}
+	if assert.NoError(t, xc.Call(ctx, "SayHello", &pb.HelloRequest{Name: "bob"}, reply)) {
+		assert.Equal(t, "Hello bob of acme by token", reply.Message)
+		assert.Equal(t, "grpc", resMeta["served-by"])
+		assert.Equal(t, "1", resMeta["cost"])
+		assert.Equal(t, addr, resMeta[share.ServerAddress])
+	}
+
+	// deadlines are propagated
+	dctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if assert.NoError(t, xc.Call(dctx, "SayHello", &pb.HelloRequest{Name: "deadline"}, reply)) {
+		assert.Equal(t, "5s", reply.Message)
+	}
+
+	// status is mapped to errors with codes and details
+	err := xc.Call(context.Background(), "SayHello", &pb.HelloRequest{Name: "missing"}, reply)
+	assert.True(t, errors.Is(err, rerrors.ErrNotFound), "unexpected error %v", err)
+	assert.Equal(t, "no such user", err.Error())
+	assert.Equal(t, map[string]string{"name": "missing"}, rerrors.DetailsOf(err))
+	err = xc.Call(context.Background(), "Missing", &pb.HelloRequest{}, reply)
+	assert.Equal(t, rerrors.Unimplemented, rerrors.CodeOf(err))
+
+	// and calls are asynchronous by Go
+	c := NewClient(grpc.WithInsecure())
+	if err := c.Connect(Network, addr); err != nil {
+		t.Fatal(err)
+	}
+	ctx = context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"tenant": "acme"})
+	call := c.Go(ctx, pb.Greeter_ServiceDesc.ServiceName, "SayHello", &pb.HelloRequest{Name: "alice"}, &pb.HelloReply{}, nil)
+	<-call.Done
+	if assert.NoError(t, call.Error) {
+		assert.Equal(t, "Hello alice of acme by ", call.Reply.(*pb.HelloReply).Message)
+		assert.Equal(t, "grpc", call.ResMetadata["served-by"])
+	}
+	assert.NoError(t, c.Close())
+	assert.True(t, c.IsClosing())
+	assert.Equal(t, client.ErrShutdown, c.Call(ctx, pb.Greeter_ServiceDesc.ServiceName, "SayHello", &pb.HelloRequest{}, reply))
+}
+
+func TestBridgeTracing(t *testing.T) {
+	// rpcx clients call rpcx servers through gRPC with the trace context
+	sr := new(oteltest.SpanRecorder)
+	tp := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr))
+	_, addr := startBridgeServer(t, &pb.Greeter_ServiceDesc, serverplugin.NewOpenTelemetryPlugin(tp, nil))
+
+	client.RegisterCacheClientBuilder(Network, NewClientBuilder(grpc.WithInsecure()))
+	d, _ := client.NewPeer2PeerDiscovery(Network+"@"+addr, "")
+	xc := client.NewXClient(pb.Greeter_ServiceDesc.ServiceName, client.Failfast, client.RandomSelect, d, client.DefaultOption)
+	defer xc.Close()
+	plugins := client.NewPluginContainer()
+	plugins.Add(client.NewOpenTelemetryPlugin(tp, nil))
+	xc.SetPlugins(plugins)
+
+	if err := xc.Call(context.Background(), "SayHello", &pb.HelloRequest{Name: "bob"}, &pb.HelloReply{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	var clientSpan, serverSpan *oteltest.Span
+	for _, span := range sr.Completed() {
+		switch span.Name() {
+		case pb.Greeter_ServiceDesc.ServiceName + ".SayHello":
+			clientSpan = span
+		case "Greeter.SayHello":
+			serverSpan = span
+		}
+	}
+	if assert.NotNil(t, clientSpan) && assert.NotNil(t, serverSpan) {
+		assert.Equal(t, clientSpan.SpanContext().TraceID(), serverSpan.SpanContext().TraceID())
+		assert.Equal(t, clientSpan.SpanContext().SpanID(), serverSpan.ParentSpanID())
+	}
+}
@@ -0,0 +1,263 @@
+package grpcbridge
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+)
+
+// Network is the network of gRPC servers for XClient, whose addresses are like "grpc@127.0.0.1:8972".
+const Network = "grpc"
+
+// ErrRawMessageUnsupported is returned by SendRaw for messages which are not uncompressed protobuf messages.
+var ErrRawMessageUnsupported = errors.New("rpcx: only uncompressed protobuf messages can be sent to gRPC servers")
+
+// Client is a client.RPCClient calling unary methods of gRPC servers, so that rpcx clients can call gRPC servers.
+// args and replies are protobuf messages.
+//
+// Metadata in ctx is sent as gRPC metadata, and headers and trailers of responses are set to the response metadata in ctx.
+// Errors of gRPC servers are returned as client.CodedServiceError with the codes of their status.
+type Client struct {
+	// MethodName returns the full gRPC method name of servicePath and serviceMethod.
+	// It is "/servicePath/serviceMethod" if MethodName is nil, so servicePath is the full name of the gRPC service,
+	// such as "helloworld.Greeter".
+	MethodName func(servicePath, serviceMethod string) string
+
+	opts []grpc.DialOption
+
+	mu      sync.Mutex
+	conn    *grpc.ClientConn
+	address string
+	closing bool
+}
+
+// NewClient creates a Client with options of gRPC connections, which must contain the transport credentials
+// or grpc.WithInsecure.
+func NewClient(opts ...grpc.DialOption) *Client {
+	return &Client{opts: opts}
+}
+
+// Connect connects the gRPC server of address. The network is ignored.
+func (c *Client) Connect(network, address string) error {
+	opts := append([]grpc.DialOption{grpc.WithDefaultCallOptions(grpc.ForceCodec(pbCodec{}))}, c.opts...)
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.conn = conn
+	c.address = address
+	c.closing = false
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) getConn() *grpc.ClientConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return nil
+	}
+	return c.conn
+}
+
+func (c *Client) methodName(servicePath, serviceMethod string) string {
+	if c.MethodName != nil {
+		return c.MethodName(servicePath, serviceMethod)
+	}
+	return "/" + servicePath + "/" + serviceMethod
+}
+
+// Call invokes the gRPC method of servicePath and serviceMethod and waits for it to complete.
+func (c *Client) Call(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}) error {
+	conn := c.getConn()
+	if conn == nil {
+		return client.ErrShutdown
+	}
+
+	meta, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	md := toGRPCMetadata(meta)
+	if outgoing, ok := metadata.FromOutgoingContext(ctx); ok {
+		md = metadata.Join(outgoing, md)
+	}
+
+	var header, trailer metadata.MD
+	err := conn.Invoke(metadata.NewOutgoingContext(ctx, md), c.methodName(servicePath, serviceMethod), args, reply,
+		grpc.Header(&header), grpc.Trailer(&trailer))
+	if resMeta, ok := ctx.Value(share.ResMetaDataKey).(map[string]string); ok {
+		for _, md := range []metadata.MD{header, trailer} {
+			for k, v := range fromGRPCMetadata(md) {
+				resMeta[k] = v
+			}
+		}
+		resMeta[share.ServerAddress] = c.RemoteAddr()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return serviceError(err)
+	}
+	return nil
+}
+
+// Go invokes the gRPC method asynchronously. The done channel will signal when the call is complete.
+// If done is nil, Go will allocate a new channel. If non-nil, done must be buffered.
+func (c *Client) Go(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}, done chan *client.Call) *client.Call {
+	if done == nil {
+		done = make(chan *client.Call, 10) // buffered.
+	} else if cap(done) == 0 {
+		log.Panic("rpc: done channel is unbuffered")
+	}
+	call := &client.Call{
+		ServicePath:   servicePath,
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+		ResMetadata:   make(map[string]string),
+	}
+	call.Metadata, _ = ctx.Value(share.ReqMetaDataKey).(map[string]string)
+
+	go func() {
+		call.Error = c.Call(context.WithValue(ctx, share.ResMetaDataKey, call.ResMetadata), servicePath, serviceMethod, args, reply)
+		select {
+		case call.Done <- call:
+		default:
+			log.Debug("rpc: discarding Call reply due to insufficient Done chan capacity")
+		}
+	}()
+	return call
+}
+
+// SendRaw sends the payload of r, which must be an uncompressed protobuf message, and returns the metadata and payload of the response.
+func (c *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error) {
+	if r.SerializeType() != protocol.ProtoBuffer || r.CompressType() != protocol.None {
+		return nil, nil, ErrRawMessageUnsupported
+	}
+
+	meta := make(map[string]string)
+	if m, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		for k, v := range m {
+			meta[k] = v
+		}
+	}
+	for k, v := range r.Metadata {
+		meta[k] = v
+	}
+	resMeta := make(map[string]string)
+	ctx = context.WithValue(context.WithValue(ctx, share.ReqMetaDataKey, meta), share.ResMetaDataKey, resMeta)
+
+	reply := &rawMessage{}
+	err := c.Call(ctx, r.ServicePath, r.ServiceMethod, &rawMessage{data: r.Payload}, reply)
+	return resMeta, reply.data, err
+}
+
+// Close closes the gRPC connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing || c.conn == nil {
+		return client.ErrShutdown
+	}
+	c.closing = true
+	return c.conn.Close()
+}
+
+// RemoteAddr returns the address of the gRPC server.
+func (c *Client) RemoteAddr() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.address
+}
+
+// RegisterServerMessageChan does nothing since gRPC servers don't send messages to clients.
+func (c *Client) RegisterServerMessageChan(ch chan<- *protocol.Message) {}
+
+// UnregisterServerMessageChan does nothing since gRPC servers don't send messages to clients.
+func (c *Client) UnregisterServerMessageChan() {}
+
+// IsClosing returns true if the client is closed.
+func (c *Client) IsClosing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closing
+}
+
+// IsShutdown returns true if the gRPC connection is shut down.
+func (c *Client) IsShutdown() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn == nil || c.conn.GetState() == connectivity.Shutdown
+}
+
+// GetConn returns nil since connections are managed by gRPC.
+func (c *Client) GetConn() net.Conn {
+	return nil
+}
+
+// ClientBuilder builds Clients of the network "grpc" for XClient, so that XClient calls gRPC servers of
+// addresses like "grpc@127.0.0.1:8972" found by discoveries:
+//
+//	client.RegisterCacheClientBuilder(grpcbridge.Network, grpcbridge.NewClientBuilder(grpc.WithInsecure()))
+type ClientBuilder struct {
+	// MethodName is the MethodName of built Clients.
+	MethodName func(servicePath, serviceMethod string) string
+
+	opts []grpc.DialOption
+
+	mu      sync.RWMutex
+	clients map[string]client.RPCClient
+}
+
+// NewClientBuilder creates a ClientBuilder with options of gRPC connections.
+func NewClientBuilder(opts ...grpc.DialOption) *ClientBuilder {
+	return &ClientBuilder{opts: opts, clients: make(map[string]client.RPCClient)}
+}
+
+// SetCachedClient caches the client of k.
+func (b *ClientBuilder) SetCachedClient(c client.RPCClient, k, servicePath, serviceMethod string) {
+	b.mu.Lock()
+	b.clients[k] = c
+	b.mu.Unlock()
+}
+
+// FindCachedClient finds the cached client of k.
+func (b *ClientBuilder) FindCachedClient(k, servicePath, serviceMethod string) client.RPCClient {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.clients[k]
+}
+
+// DeleteCachedClient deletes the cached client of k.
+func (b *ClientBuilder) DeleteCachedClient(c client.RPCClient, k, servicePath, serviceMethod string) {
+	b.mu.Lock()
+	if b.clients[k] == c {
+		delete(b.clients, k)
+	}
+	b.mu.Unlock()
+}
+
+// GenerateClient creates and connects a Client of k, such as "grpc@127.0.0.1:8972".
+func (b *ClientBuilder) GenerateClient(k, servicePath, serviceMethod string) (client.RPCClient, error) {
+	address := k
+	if i := len(Network) + 1; len(k) > i && k[:i] == Network+"@" {
+		address = k[i:]
+	}
+	c := NewClient(b.opts...)
+	c.MethodName = b.MethodName
+	if err := c.Connect(Network, address); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,39 @@
+package grpcbridge
+
+import (
+	"github.com/smallnest/rpcx/codec"
+)
+
+// rawMessage is a protobuf message encoded already, which is passed through by the codec.
+type rawMessage struct {
+	data []byte
+}
+
+// pbCodec is the gRPC codec of the bridge. It encodes messages by codec.PBCodec, the protobuf codec of rpcx,
+// and passes through raw messages so that payloads are delegated between gRPC and rpcx without being decoded.
+type pbCodec struct{}
+
+func (pbCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(*rawMessage); ok {
+		return m.data, nil
+	}
+	return codec.PBCodec{}.Encode(v)
+}
+
+func (pbCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(*rawMessage); ok {
+		m.data = append([]byte(nil), data...)
+		return nil
+	}
+	return codec.PBCodec{}.Decode(data, v)
+}
+
+// Name returns the content subtype of the codec, which is the one of the default codec of gRPC.
+func (pbCodec) Name() string {
+	return "proto"
+}
+
+// String implements grpc.Codec for grpc.CustomCodec.
+func (pbCodec) String() string {
+	return "proto"
+}
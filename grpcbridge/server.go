@@ -0,0 +1,214 @@
+package grpcbridge
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ErrServerStarted is returned by ServerPlugin.Serve after the server is started,
+// since gRPC services can't be registered after gRPC servers are serving.
+var ErrServerStarted = errors.New("rpcx: gRPC services must be served before the server is started")
+
+var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+
+// ServerPlugin serves services of an rpcx server over gRPC on the listener of the server.
+// gRPC connections are matched by their content type like connections of the HTTP gateway,
+// so services are served over gRPC on tcp listeners of the server.
+//
+// Requests are handled by the services registered to the server with the protobuf codec,
+// and run through plugins and authentication of the server like other requests.
+// Payloads are passed through without being decoded by the bridge, so interceptors of the gRPC server
+// get encoded messages instead of the arguments of services.
+//
+//	p := grpcbridge.NewServerPlugin(s)
+//	s.Plugins.Add(p)
+//	s.RegisterName("Greeter", new(Greeter), "")
+//	p.Serve("Greeter", &helloworld.Greeter_ServiceDesc)
+type ServerPlugin struct {
+	s    *server.Server
+	grpc *grpc.Server
+
+	mu       sync.Mutex
+	started  bool
+	methods  map[string][]string // method names of registered services
+	paths    []string
+	services map[string]*grpc.ServiceDesc
+}
+
+// NewServerPlugin creates a ServerPlugin of s with options of the gRPC server, which is stopped gracefully by Shutdown of s.
+// The codec of the gRPC server is replaced by the codec of the bridge.
+func NewServerPlugin(s *server.Server, opts ...grpc.ServerOption) *ServerPlugin {
+	p := &ServerPlugin{
+		s:        s,
+		grpc:     grpc.NewServer(append(opts, grpc.CustomCodec(pbCodec{}))...),
+		methods:  make(map[string][]string),
+		services: make(map[string]*grpc.ServiceDesc),
+	}
+	s.RegisterOnShutdown(func(*server.Server) {
+		p.grpc.GracefulStop()
+	})
+	return p
+}
+
+// GRPCServer returns the gRPC server, so that native gRPC services can be registered to it before the server is started.
+func (p *ServerPlugin) GRPCServer() *grpc.Server {
+	return p.grpc
+}
+
+// Serve serves the service registered as servicePath over gRPC as the gRPC service of desc,
+// which is usually generated by protoc-gen-go-grpc, such as helloworld.Greeter_ServiceDesc.
+// Methods of desc are handled by the methods of the same names of the service instead of the handlers of desc,
+// and streams of desc fail with ErrStreamingUnsupported.
+//
+// If desc is nil, the gRPC service is named servicePath and has all the methods and functions of the service,
+// which must be registered after the plugin is added to the server.
+func (p *ServerPlugin) Serve(servicePath string, desc *grpc.ServiceDesc) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started {
+		return ErrServerStarted
+	}
+	if _, ok := p.services[servicePath]; !ok {
+		p.paths = append(p.paths, servicePath)
+	}
+	p.services[servicePath] = desc
+	return nil
+}
+
+// Register records the methods of services for generated gRPC services.
+func (p *ServerPlugin) Register(name string, rcvr interface{}, metadata string) error {
+	typ := reflect.TypeOf(rcvr)
+	var methods []string
+	for i := 0; i < typ.NumMethod(); i++ {
+		mtype := typ.Method(i).Type
+		// the receiver, the context, args and reply
+		if mtype.NumIn() == 4 && mtype.NumOut() == 1 && mtype.Out(0) == typeOfError {
+			methods = append(methods, typ.Method(i).Name)
+		}
+	}
+
+	p.mu.Lock()
+	p.methods[name] = append(p.methods[name], methods...)
+	p.mu.Unlock()
+	return nil
+}
+
+// RegisterFunction records functions of services for generated gRPC services.
+func (p *ServerPlugin) RegisterFunction(serviceName, fname string, fn interface{}, metadata string) error {
+	p.mu.Lock()
+	p.methods[serviceName] = append(p.methods[serviceName], fname)
+	p.mu.Unlock()
+	return nil
+}
+
+// Unregister forgets the methods of the service. Its gRPC service is still served,
+// and calls fail with the NotFound code like calls of rpcx clients.
+func (p *ServerPlugin) Unregister(name string) error {
+	p.mu.Lock()
+	delete(p.methods, name)
+	p.mu.Unlock()
+	return nil
+}
+
+// MuxMatch registers the gRPC services and serves gRPC connections matched by their content type.
+func (p *ServerPlugin) MuxMatch(m cmux.CMux) {
+	p.mu.Lock()
+	if !p.started {
+		p.started = true
+		for _, path := range p.paths {
+			p.grpc.RegisterService(p.serviceDesc(path, p.services[path]), nil)
+		}
+	}
+	p.mu.Unlock()
+
+	// gRPC clients wait for the settings of servers before sending headers
+	ln := m.MatchWithWriters(cmux.HTTP2MatchHeaderFieldPrefixSendSettings("content-type", "application/grpc"))
+	go p.grpc.Serve(ln)
+}
+
+// serviceDesc returns the ServiceDesc of the bridged service, whose handlers delegate to the service of servicePath.
+func (p *ServerPlugin) serviceDesc(servicePath string, desc *grpc.ServiceDesc) *grpc.ServiceDesc {
+	sd := &grpc.ServiceDesc{
+		ServiceName: servicePath,
+		HandlerType: (*interface{})(nil),
+	}
+	if desc == nil {
+		methods := append([]string(nil), p.methods[servicePath]...)
+		sort.Strings(methods)
+		for _, name := range methods {
+			sd.Methods = append(sd.Methods, grpc.MethodDesc{MethodName: name, Handler: p.unaryHandler(sd.ServiceName, servicePath, name)})
+		}
+		return sd
+	}
+
+	sd.ServiceName = desc.ServiceName
+	sd.Metadata = desc.Metadata
+	for _, md := range desc.Methods {
+		sd.Methods = append(sd.Methods, grpc.MethodDesc{MethodName: md.MethodName, Handler: p.unaryHandler(sd.ServiceName, servicePath, md.MethodName)})
+	}
+	for _, st := range desc.Streams {
+		sd.Streams = append(sd.Streams, grpc.StreamDesc{
+			StreamName:    st.StreamName,
+			Handler:       func(interface{}, grpc.ServerStream) error { return ErrStreamingUnsupported },
+			ServerStreams: st.ServerStreams,
+			ClientStreams: st.ClientStreams,
+		})
+	}
+	return sd
+}
+
+func (p *ServerPlugin) unaryHandler(serviceName, servicePath, method string) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/" + serviceName + "/" + method}
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := &rawMessage{}
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return p.call(ctx, servicePath, method, req.(*rawMessage))
+		}
+		if interceptor == nil {
+			return handler(ctx, in)
+		}
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// call handles the gRPC request by the service of the server.
+func (p *ServerPlugin) call(ctx context.Context, servicePath, method string, in *rawMessage) (*rawMessage, error) {
+	req := protocol.GetPooledMsg()
+	defer protocol.FreeMsg(req)
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.ProtoBuffer)
+	req.ServicePath = servicePath
+	req.ServiceMethod = method
+	req.Payload = in.data
+	md, _ := metadata.FromIncomingContext(ctx)
+	req.Metadata = fromGRPCMetadata(md)
+
+	var remote string
+	if pr, ok := peer.FromContext(ctx); ok {
+		remote = pr.Addr.String()
+	}
+	res, err := p.s.HandleRequest(share.WithValue(ctx, server.RemoteConnContextKey, remote), req)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	defer protocol.FreeMsg(res)
+
+	if len(res.Metadata) > 0 {
+		grpc.SetHeader(ctx, toGRPCMetadata(res.Metadata))
+	}
+	return &rawMessage{data: append([]byte(nil), res.Payload...)}, nil
+}
@@ -62,6 +62,22 @@ func (l *defaultLogger) Panicf(format string, v ...interface{}) {
 	l.Logger.Panicf(format, v...)
 }
 
+// output writes msg at the level, and depth is the calldepth of log.Logger.Output.
+func (l *defaultLogger) output(depth int, level Level, msg string) {
+	var lvl string
+	switch {
+	case level >= LevelError:
+		lvl = color.RedString("ERROR")
+	case level >= LevelWarn:
+		lvl = color.YellowString("WARN ")
+	case level >= LevelInfo:
+		lvl = color.GreenString("INFO ")
+	default:
+		lvl = "DEBUG"
+	}
+	_ = l.Output(depth, header(lvl, msg))
+}
+
 func header(lvl, msg string) string {
 	return fmt.Sprintf("%s: %s", lvl, msg)
 }
@@ -11,6 +11,7 @@ const (
 
 var l Logger = &defaultLogger{log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)}
 
+// Logger is the printf-style logger of rpcx. Loggers which implement StructuredLogger too get structured logs as they are.
 type Logger interface {
 	Debug(v ...interface{})
 	Debugf(format string, v ...interface{})
@@ -40,30 +41,54 @@ func SetDummyLogger() {
 }
 
 func Debug(v ...interface{}) {
+	if !Enabled("", LevelDebug) {
+		return
+	}
 	l.Debug(v...)
 }
 func Debugf(format string, v ...interface{}) {
+	if !Enabled("", LevelDebug) {
+		return
+	}
 	l.Debugf(format, v...)
 }
 
 func Info(v ...interface{}) {
+	if !Enabled("", LevelInfo) {
+		return
+	}
 	l.Info(v...)
 }
 func Infof(format string, v ...interface{}) {
+	if !Enabled("", LevelInfo) {
+		return
+	}
 	l.Infof(format, v...)
 }
 
 func Warn(v ...interface{}) {
+	if !Enabled("", LevelWarn) {
+		return
+	}
 	l.Warn(v...)
 }
 func Warnf(format string, v ...interface{}) {
+	if !Enabled("", LevelWarn) {
+		return
+	}
 	l.Warnf(format, v...)
 }
 
 func Error(v ...interface{}) {
+	if !Enabled("", LevelError) {
+		return
+	}
 	l.Error(v...)
 }
 func Errorf(format string, v ...interface{}) {
+	if !Enabled("", LevelError) {
+		return
+	}
 	l.Errorf(format, v...)
 }
 
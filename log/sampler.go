@@ -0,0 +1,55 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// Sampler limits repeated logs of the same key to one log per interval, such as logs of failures
+// of dialing an address, which are repeated by retries and reconnects. The zero Sampler is ready to use.
+type Sampler struct {
+	mu   sync.Mutex
+	logs map[string]*sampledLog
+}
+
+type sampledLog struct {
+	last       time.Time
+	suppressed int
+}
+
+// Allow reports whether a log of key should be emitted, which is the first log of key in interval,
+// and returns the number of logs of key suppressed since the last emitted one, to be logged as a field.
+// Non-positive interval allows all logs.
+func (s *Sampler) Allow(key string, interval time.Duration) (ok bool, suppressed int) {
+	if interval <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.logs == nil {
+		s.logs = make(map[string]*sampledLog)
+	}
+	sl := s.logs[key]
+	if sl == nil {
+		sl = &sampledLog{}
+		s.logs[key] = sl
+	} else if now.Sub(sl.last) < interval {
+		sl.suppressed++
+		return false, 0
+	}
+	suppressed = sl.suppressed
+	sl.last, sl.suppressed = now, 0
+
+	// forget keys which are not logged in a while, such as addresses of removed servers
+	if len(s.logs) > 1024 {
+		for k, v := range s.logs {
+			if now.Sub(v.last) > interval && v.suppressed == 0 {
+				delete(s.logs, k)
+			}
+		}
+	}
+	return true, suppressed
+}
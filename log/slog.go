@@ -0,0 +1,86 @@
+// +build go1.21
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"time"
+)
+
+// SetHandler routes all logs of rpcx to h of log/slog, so the standard-library slog backends,
+// and backends of zap or zerolog by their slog handlers, plug in directly.
+// Key/value pairs of structured logs are attributes of records, and logs of the printf-style functions
+// are records of their formatted messages. Levels of SetLevel and SetComponentLevel apply before h.
+func SetHandler(h slog.Handler) {
+	SetLogger(&slogLogger{h: h})
+}
+
+// slogLogger is a Logger and a StructuredLogger of a slog handler.
+type slogLogger struct {
+	h slog.Handler
+}
+
+// Log is called by output of Log or the methods of Component, whose callers are the sources.
+func (s *slogLogger) Log(ctx context.Context, level Level, msg string, keyvals ...interface{}) {
+	s.log(ctx, 6, level, msg, keyvals)
+}
+
+// log handles a record whose source is the caller skipped by skip of runtime.Callers.
+func (s *slogLogger) log(ctx context.Context, skip int, level Level, msg string, keyvals []interface{}) {
+	if !s.h.Enabled(ctx, slog.Level(level)) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(skip, pcs[:])
+	r := slog.NewRecord(time.Now(), slog.Level(level), msg, pcs[0])
+	r.Add(keyvals...)
+	_ = s.h.Handle(ctx, r)
+}
+
+// print logs msg of the printf-style functions of the package, whose callers are the sources.
+func (s *slogLogger) print(level Level, msg string) {
+	s.log(context.Background(), 5, level, msg, nil)
+}
+
+func (s *slogLogger) Debug(v ...interface{}) { s.print(LevelDebug, fmt.Sprint(v...)) }
+func (s *slogLogger) Debugf(format string, v ...interface{}) {
+	s.print(LevelDebug, fmt.Sprintf(format, v...))
+}
+func (s *slogLogger) Info(v ...interface{}) { s.print(LevelInfo, fmt.Sprint(v...)) }
+func (s *slogLogger) Infof(format string, v ...interface{}) {
+	s.print(LevelInfo, fmt.Sprintf(format, v...))
+}
+func (s *slogLogger) Warn(v ...interface{}) { s.print(LevelWarn, fmt.Sprint(v...)) }
+func (s *slogLogger) Warnf(format string, v ...interface{}) {
+	s.print(LevelWarn, fmt.Sprintf(format, v...))
+}
+func (s *slogLogger) Error(v ...interface{}) { s.print(LevelError, fmt.Sprint(v...)) }
+func (s *slogLogger) Errorf(format string, v ...interface{}) {
+	s.print(LevelError, fmt.Sprintf(format, v...))
+}
+
+func (s *slogLogger) Fatal(v ...interface{}) {
+	s.print(LevelError+4, fmt.Sprint(v...))
+	os.Exit(1)
+}
+
+func (s *slogLogger) Fatalf(format string, v ...interface{}) {
+	s.print(LevelError+4, fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+func (s *slogLogger) Panic(v ...interface{}) {
+	msg := fmt.Sprint(v...)
+	s.print(LevelError+4, msg)
+	panic(msg)
+}
+
+func (s *slogLogger) Panicf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	s.print(LevelError+4, msg)
+	panic(msg)
+}
@@ -0,0 +1,38 @@
+// +build go1.21
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetHandler(t *testing.T) {
+	old := l
+	defer SetLogger(old)
+
+	var buf bytes.Buffer
+	SetHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{AddSource: true, Level: slog.LevelInfo}))
+
+	NewComponent("server").Warn(context.Background(), "rpcx: bad frame", "remote", "127.0.0.1:8972", "seq", uint64(7))
+	Infof("shutdown %s", "begin")
+	Debug("dropped by the level of the handler")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expect 2 records but got %q", buf.String())
+	}
+	for _, s := range []string{`"level":"WARN"`, `"msg":"rpcx: bad frame"`, `"component":"server"`, `"remote":"127.0.0.1:8972"`, `"seq":7`, `slog_test.go"`} {
+		if !strings.Contains(lines[0], s) {
+			t.Errorf("expect %s in %s", s, lines[0])
+		}
+	}
+	for _, s := range []string{`"level":"INFO"`, `"msg":"shutdown begin"`, `slog_test.go"`} {
+		if !strings.Contains(lines[1], s) {
+			t.Errorf("expect %s in %s", s, lines[1])
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Level is the level of logs. Levels have the same values as levels of log/slog.
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (level Level) String() string {
+	switch level {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "LEVEL(" + strconv.Itoa(int(level)) + ")"
+	}
+}
+
+// StructuredLogger is a logger of messages with key/value pairs, such as "remote", addr, "seq", seq.
+// Loggers set by SetLogger which implement it get structured logs as they are,
+// and structured logs are formatted as texts of "msg key=value ..." for other loggers.
+type StructuredLogger interface {
+	Log(ctx context.Context, level Level, msg string, keyvals ...interface{})
+}
+
+var (
+	minLevel        int32    = int32(LevelDebug)
+	componentLevels sync.Map // component => Level
+)
+
+// SetLevel sets the min level of logs, which is LevelDebug by default.
+func SetLevel(level Level) {
+	atomic.StoreInt32(&minLevel, int32(level))
+}
+
+// SetComponentLevel sets the min level of logs of the component, such as "client" or "server", instead of the level of SetLevel.
+func SetComponentLevel(component string, level Level) {
+	componentLevels.Store(component, level)
+}
+
+// Enabled reports whether logs of the component at the level are emitted. The component of logs without components is "".
+func Enabled(component string, level Level) bool {
+	if component != "" {
+		if l, ok := componentLevels.Load(component); ok {
+			return level >= l.(Level)
+		}
+	}
+	return level >= Level(atomic.LoadInt32(&minLevel))
+}
+
+type fieldsKey struct{}
+
+// WithFields returns a context with key/value pairs, which are added to structured logs of the context,
+// such as fields of a call.
+func WithFields(ctx context.Context, keyvals ...interface{}) context.Context {
+	fields := append(append([]interface{}(nil), Fields(ctx)...), keyvals...)
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// Fields returns key/value pairs of WithFields of ctx.
+func Fields(ctx context.Context) []interface{} {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	return fields
+}
+
+// std is the structured logger of Log, without components.
+var std = &Component{}
+
+// Log logs msg with key/value pairs at the level.
+func Log(ctx context.Context, level Level, msg string, keyvals ...interface{}) {
+	std.log(ctx, level, msg, keyvals)
+}
+
+// Component is a structured logger of a component, whose logs have the "component" key
+// and are filtered by the level of SetComponentLevel.
+type Component struct {
+	name string
+}
+
+// NewComponent returns the logger of the component.
+func NewComponent(name string) *Component {
+	return &Component{name: name}
+}
+
+func (c *Component) Debug(ctx context.Context, msg string, keyvals ...interface{}) {
+	c.log(ctx, LevelDebug, msg, keyvals)
+}
+
+func (c *Component) Info(ctx context.Context, msg string, keyvals ...interface{}) {
+	c.log(ctx, LevelInfo, msg, keyvals)
+}
+
+func (c *Component) Warn(ctx context.Context, msg string, keyvals ...interface{}) {
+	c.log(ctx, LevelWarn, msg, keyvals)
+}
+
+func (c *Component) Error(ctx context.Context, msg string, keyvals ...interface{}) {
+	c.log(ctx, LevelError, msg, keyvals)
+}
+
+// Enabled reports whether logs of the component at the level are emitted, to skip building expensive fields.
+func (c *Component) Enabled(level Level) bool {
+	return Enabled(c.name, level)
+}
+
+func (c *Component) log(ctx context.Context, level Level, msg string, keyvals []interface{}) {
+	if !Enabled(c.name, level) {
+		return
+	}
+	fields := Fields(ctx)
+	kvs := make([]interface{}, 0, 2+len(fields)+len(keyvals))
+	if c.name != "" {
+		kvs = append(kvs, "component", c.name)
+	}
+	kvs = append(kvs, fields...)
+	output(ctx, level, msg, append(kvs, keyvals...))
+}
+
+func output(ctx context.Context, level Level, msg string, keyvals []interface{}) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if sl, ok := l.(StructuredLogger); ok {
+		sl.Log(ctx, level, msg, keyvals...)
+		return
+	}
+
+	text := formatText(msg, keyvals)
+	if dl, ok := l.(*defaultLogger); ok {
+		// the caller of Log or the methods of Component
+		dl.output(calldepth+2, level, text)
+		return
+	}
+	switch {
+	case level >= LevelError:
+		l.Error(text)
+	case level >= LevelWarn:
+		l.Warn(text)
+	case level >= LevelInfo:
+		l.Info(text)
+	default:
+		l.Debug(text)
+	}
+}
+
+// formatText formats msg and key/value pairs as "msg key=value ...", and values with spaces are quoted.
+// The "component" key is omitted, so texts look like logs before structured logs.
+func formatText(msg string, keyvals []interface{}) string {
+	if len(keyvals) == 0 {
+		return msg
+	}
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		var v interface{} = "!MISSING"
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		if key == "component" {
+			continue
+		}
+		s := fmt.Sprint(v)
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = strconv.Quote(s)
+		}
+		sb.WriteByte(' ')
+		sb.WriteString(key)
+		sb.WriteByte('=')
+		sb.WriteString(s)
+	}
+	return sb.String()
+}
@@ -0,0 +1,117 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func captureDefault(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	old := l
+	SetLogger(&defaultLogger{log.New(&buf, "", log.Lshortfile)})
+	t.Cleanup(func() {
+		SetLogger(old)
+		SetLevel(LevelDebug)
+	})
+	return &buf
+}
+
+func TestStructuredDefaultOutput(t *testing.T) {
+	buf := captureDefault(t)
+
+	Warnf("rpcx: failed to read request: %v", "EOF")
+	c := NewComponent("server")
+	c.Warn(context.Background(), "rpcx: failed to read request", "remote", "127.0.0.1:8972", "error", errors.New("unexpected EOF"))
+	Log(WithFields(context.Background(), "seq", 1), LevelInfo, "closed")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expect 3 lines but got %q", buf.String())
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "structured_test.go:") {
+			t.Errorf("expect the file of the caller but got %q", line)
+		}
+	}
+	if !strings.HasSuffix(lines[0], "WARN : rpcx: failed to read request: EOF") {
+		t.Errorf("unexpected printf log %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], `WARN : rpcx: failed to read request remote=127.0.0.1:8972 error="unexpected EOF"`) {
+		t.Errorf("unexpected structured log %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], "INFO : closed seq=1") {
+		t.Errorf("unexpected structured log %q", lines[2])
+	}
+}
+
+type recordLogger struct {
+	dummyLogger
+	msgs    []string
+	keyvals [][]interface{}
+}
+
+func (r *recordLogger) Log(ctx context.Context, level Level, msg string, keyvals ...interface{}) {
+	r.msgs = append(r.msgs, level.String()+" "+msg)
+	r.keyvals = append(r.keyvals, keyvals)
+}
+
+func TestStructuredLevels(t *testing.T) {
+	old := l
+	r := &recordLogger{}
+	SetLogger(r)
+	defer func() {
+		SetLogger(old)
+		SetLevel(LevelDebug)
+		componentLevels.Delete("client")
+	}()
+
+	c := NewComponent("client")
+	ctx := WithFields(context.Background(), "servicePath", "Arith")
+	c.Debug(ctx, "debug", "seq", 1)
+	SetLevel(LevelWarn)
+	c.Info(ctx, "info")
+	c.Warn(ctx, "warn")
+	SetComponentLevel("client", LevelError)
+	c.Warn(ctx, "warn")
+	c.Error(ctx, "error")
+	if c.Enabled(LevelWarn) || !c.Enabled(LevelError) {
+		t.Fatal("unexpected levels of the component")
+	}
+
+	want := []string{"DEBUG debug", "WARN warn", "ERROR error"}
+	if strings.Join(r.msgs, ",") != strings.Join(want, ",") {
+		t.Fatalf("expect %v but got %v", want, r.msgs)
+	}
+	kv := r.keyvals[0]
+	if len(kv) != 6 || kv[0] != "component" || kv[1] != "client" || kv[2] != "servicePath" || kv[4] != "seq" {
+		t.Fatalf("unexpected keyvals %v", kv)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	var s Sampler
+	if ok, _ := s.Allow("a", 0); !ok {
+		t.Fatal("expect all logs without an interval")
+	}
+
+	if ok, n := s.Allow("a", 50*time.Millisecond); !ok || n != 0 {
+		t.Fatalf("expect the first log but got %v, %d", ok, n)
+	}
+	for i := 0; i < 3; i++ {
+		if ok, _ := s.Allow("a", 50*time.Millisecond); ok {
+			t.Fatal("expect suppressed logs")
+		}
+	}
+	if ok, _ := s.Allow("b", 50*time.Millisecond); !ok {
+		t.Fatal("expect the first log of another key")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if ok, n := s.Allow("a", 50*time.Millisecond); !ok || n != 3 {
+		t.Fatalf("expect a log after the interval with 3 suppressed but got %v, %d", ok, n)
+	}
+}
@@ -0,0 +1,202 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ChunkKey is set in metadata of chunk frames as "index/total", such as "0/3".
+	// Chunk frames of a message have the seq and message type of the message and carry parts of the encoded message as payloads.
+	ChunkKey = "__rpcx_chunk__"
+	// AcceptChunk is set in metadata of requests by clients which reassemble chunk frames,
+	// and in responses by servers which reassemble them too, so that peers of old versions never get chunk frames.
+	AcceptChunk = "__rpcx_accept_chunk__"
+)
+
+const (
+	// DefaultMaxChunkedMessageSize is the default max size of messages reassembled from chunk frames.
+	DefaultMaxChunkedMessageSize = 64 << 20
+	// DefaultChunkTimeout is the default time to receive all chunk frames of a message.
+	DefaultChunkTimeout = 30 * time.Second
+	// MaxPendingChunkedMessages is the max number of messages being reassembled from chunk frames of a connection.
+	MaxPendingChunkedMessages = 1024
+)
+
+// IsChunk returns whether m is a chunk frame of a larger message.
+func (m *Message) IsChunk() bool {
+	return m.Metadata[ChunkKey] != ""
+}
+
+// SplitChunks splits data, an encoded message, into chunk frames whose payloads are at most size bytes.
+// Frames are written one by one so that other messages on the connection, such as heartbeats,
+// are written between them. Frames are returned to the pool by PutData after they are written.
+func SplitChunks(data []byte, size int) []*[]byte {
+	if size <= 0 || len(data) < 12 {
+		return nil
+	}
+	total := (len(data) + size - 1) / size
+
+	var header Header
+	copy(header[:], data[:12])
+	header.SetCompressType(None)
+	header.SetSerializeType(SerializeNone)
+	header.SetHeartbeat(false)
+
+	frames := make([]*[]byte, 0, total)
+	chunk := Message{Header: &header, Metadata: make(map[string]string, 1)}
+	for i := 0; i < total; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk.Metadata[ChunkKey] = strconv.Itoa(i) + "/" + strconv.Itoa(total)
+		chunk.Payload = data[i*size : end]
+		frames = append(frames, chunk.EncodeSlicePointer())
+	}
+	return frames
+}
+
+type chunkID struct {
+	messageType MessageType
+	seq         uint64
+}
+
+type partialMessage struct {
+	data    []byte
+	next    int
+	total   int
+	started time.Time
+}
+
+// Reassembler reassembles messages from chunk frames read from a connection.
+// Chunk frames of different messages may be interleaved. It is not safe for concurrent use,
+// so each connection has its own Reassembler.
+type Reassembler struct {
+	maxSize int
+	timeout time.Duration
+	pending map[chunkID]*partialMessage
+	size    int // bytes of all pending messages
+}
+
+// NewReassembler creates a Reassembler of messages up to maxSize bytes, whose chunk frames are all received in timeout.
+// maxSize also limits the bytes of all messages being reassembled, so interleaved messages can't hold more,
+// and at most MaxPendingChunkedMessages messages are reassembled at the same time.
+// Messages not received in time are dropped. Zero values mean DefaultMaxChunkedMessageSize and DefaultChunkTimeout.
+func NewReassembler(maxSize int, timeout time.Duration) *Reassembler {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxChunkedMessageSize
+	}
+	if timeout <= 0 {
+		timeout = DefaultChunkTimeout
+	}
+	return &Reassembler{
+		maxSize: maxSize,
+		timeout: timeout,
+		pending: make(map[chunkID]*partialMessage),
+	}
+}
+
+// Reassemble takes the chunk frame read with err. It returns the reassembled message, got by GetPooledMsg,
+// when the last chunk frame of the message is taken, or nil and a nil error if more frames are expected.
+// The chunk frame is freed, unless it fails and is returned with the error, and then its message is dropped.
+// If the reassembled message fails to be decoded, it is returned with the error as Decode does.
+// Frames of messages dropped because of errors or the timeout are ignored.
+func (r *Reassembler) Reassemble(chunk *Message, err error) (*Message, error) {
+	if err != nil {
+		r.discard(chunk)
+		return chunk, err
+	}
+	msg, err := r.add(chunk)
+	if msg == nil && err != nil {
+		return chunk, err
+	}
+	chunk.Free()
+	return msg, err
+}
+
+// add adds the chunk frame m, which is not retained, and returns the reassembled message if m is the last frame.
+func (r *Reassembler) add(m *Message) (*Message, error) {
+	now := time.Now()
+	r.expire(now)
+
+	index, total, ok := parseChunk(m.Metadata[ChunkKey])
+	if !ok {
+		return nil, fmt.Errorf("%w: bad chunk %q", ErrMessageMalformed, m.Metadata[ChunkKey])
+	}
+	id := chunkID{m.MessageType(), m.Seq()}
+	p := r.pending[id]
+	if index == 0 {
+		r.drop(id)
+		if len(r.pending) >= MaxPendingChunkedMessages {
+			return nil, fmt.Errorf("%w: more than %d chunked messages are being reassembled", ErrMessageTooLong, MaxPendingChunkedMessages)
+		}
+		p = &partialMessage{total: total, started: now}
+		r.pending[id] = p
+	} else if p == nil {
+		return nil, nil
+	}
+	if index != p.next || total != p.total {
+		r.drop(id)
+		return nil, fmt.Errorf("%w: chunk %d/%d of message %d out of order", ErrMessageMalformed, index, total, id.seq)
+	}
+	if len(p.data)+len(m.Payload) > r.maxSize {
+		r.drop(id)
+		return nil, fmt.Errorf("%w: chunked message exceeds the limit of %d bytes", ErrMessageTooLong, r.maxSize)
+	}
+	if r.size+len(m.Payload) > r.maxSize {
+		r.drop(id)
+		return nil, fmt.Errorf("%w: chunked messages being reassembled exceed the limit of %d bytes", ErrMessageTooLong, r.maxSize)
+	}
+	p.data = append(p.data, m.Payload...)
+	r.size += len(m.Payload)
+	p.next++
+	if p.next < p.total {
+		return nil, nil
+	}
+
+	r.drop(id)
+	msg := GetPooledMsg()
+	return msg, msg.DecodeLimit(bytes.NewReader(p.data), r.maxSize)
+}
+
+// discard drops the message which the chunk frame m belongs to.
+func (r *Reassembler) discard(m *Message) {
+	r.drop(chunkID{m.MessageType(), m.Seq()})
+}
+
+// drop drops the pending message id.
+func (r *Reassembler) drop(id chunkID) {
+	if p := r.pending[id]; p != nil {
+		r.size -= len(p.data)
+		delete(r.pending, id)
+	}
+}
+
+// expire drops messages which have not been received in time.
+func (r *Reassembler) expire(now time.Time) {
+	for id, p := range r.pending {
+		if now.Sub(p.started) > r.timeout {
+			r.drop(id)
+		}
+	}
+}
+
+func parseChunk(s string) (index, total int, ok bool) {
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return 0, 0, false
+	}
+	index, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, 0, false
+	}
+	total, err = strconv.Atoi(s[i+1:])
+	if err != nil || index < 0 || index >= total {
+		return 0, 0, false
+	}
+	return index, total, true
+}
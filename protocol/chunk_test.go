@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func newChunkedMessage(seq uint64, size int) *Message {
+	m := NewMessage()
+	m.SetMessageType(Request)
+	m.SetSerializeType(MsgPack)
+	m.SetCompressType(Gzip)
+	m.SetChecksum(true)
+	m.SetSeq(seq)
+	m.ServicePath = "Arith"
+	m.ServiceMethod = "Upload"
+	m.Metadata = map[string]string{"k": "v"}
+	m.Payload = make([]byte, size)
+	rand.New(rand.NewSource(int64(seq))).Read(m.Payload)
+	return m
+}
+
+// readFrames decodes frames and reassembles chunked messages by r.
+func readFrames(t *testing.T, r *Reassembler, frames [][]byte) (msgs []*Message, errs []error) {
+	for _, frame := range frames {
+		m := GetPooledMsg()
+		err := m.Decode(bytes.NewReader(frame))
+		if m.IsChunk() {
+			if m, err = r.Reassemble(m, err); m == nil && err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, errs
+}
+
+func splitChunks(m *Message, size int) [][]byte {
+	var frames [][]byte
+	for _, f := range SplitChunks(m.Encode(), size) {
+		frames = append(frames, append([]byte(nil), *f...))
+		PutData(f)
+	}
+	return frames
+}
+
+func TestChunks(t *testing.T) {
+	big, other := newChunkedMessage(1, 100*1024), newChunkedMessage(2, 10*1024)
+	bigFrames, otherFrames := splitChunks(big, 4096), splitChunks(other, 4096)
+	if len(bigFrames) != 26 || len(otherFrames) != 3 {
+		t.Fatalf("expect 26 and 3 chunks but got %d and %d", len(bigFrames), len(otherFrames))
+	}
+
+	// chunks of messages are interleaved with each other and with heartbeats
+	heartbeat := NewMessage()
+	heartbeat.SetHeartbeat(true)
+	heartbeat.SetSeq(1)
+	frames := [][]byte{bigFrames[0], otherFrames[0], heartbeat.Encode()}
+	frames = append(frames, bigFrames[1:10]...)
+	frames = append(frames, otherFrames[1:]...)
+	frames = append(frames, bigFrames[10:]...)
+
+	msgs, errs := readFrames(t, NewReassembler(0, 0), frames)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(msgs) != 3 || !msgs[0].IsHeartbeat() {
+		t.Fatalf("expect a heartbeat and 2 messages but got %d", len(msgs))
+	}
+	for i, want := range []*Message{other, big} {
+		m := msgs[i+1]
+		if m.Seq() != want.Seq() || m.ServiceMethod != "Upload" || m.SerializeType() != MsgPack || m.Metadata["k"] != "v" ||
+			!bytes.Equal(m.Payload, want.Payload) {
+			t.Errorf("message %d is not reassembled", want.Seq())
+		}
+	}
+
+	// small messages are sent as they are
+	if n := len(SplitChunks(heartbeat.Encode(), 4096)); n != 1 {
+		t.Errorf("expect 1 chunk but got %d", n)
+	}
+}
+
+func TestReassemblerLimits(t *testing.T) {
+	frames := splitChunks(newChunkedMessage(1, 20*1024), 4096)
+
+	// too large messages fail, and the rest of their chunks are ignored
+	r := NewReassembler(8192, 0)
+	msgs, errs := readFrames(t, r, frames)
+	if len(msgs) != 0 || len(errs) != 1 || !errors.Is(errs[0], ErrMessageTooLong) {
+		t.Errorf("expect ErrMessageTooLong but got %v", errs)
+	}
+
+	// so are interleaved messages, which are not finished, of more bytes in total
+	r = NewReassembler(16*1024, 0)
+	var interleaved [][]byte
+	for seq := uint64(1); seq <= 8; seq++ {
+		interleaved = append(interleaved, splitChunks(newChunkedMessage(seq, 20*1024), 4096)[0])
+	}
+	_, errs = readFrames(t, r, interleaved)
+	if len(errs) != 4 || !errors.Is(errs[0], ErrMessageTooLong) || len(r.pending) != 4 || r.size != 4*4096 {
+		t.Errorf("expect ErrMessageTooLong after 4 pending messages but got %v, %d pending of %d bytes", errs, len(r.pending), r.size)
+	}
+
+	// and too many unfinished messages
+	r = NewReassembler(0, 0)
+	interleaved = interleaved[:0]
+	for seq := uint64(1); seq <= MaxPendingChunkedMessages+1; seq++ {
+		interleaved = append(interleaved, splitChunks(newChunkedMessage(seq, 1024), 512)[0])
+	}
+	_, errs = readFrames(t, r, interleaved)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrMessageTooLong) || len(r.pending) != MaxPendingChunkedMessages {
+		t.Errorf("expect ErrMessageTooLong after %d pending messages but got %v", MaxPendingChunkedMessages, errs)
+	}
+
+	// so are messages with missing chunks
+	r = NewReassembler(0, 0)
+	_, errs = readFrames(t, r, append([][]byte{frames[0]}, frames[2:]...))
+	if len(errs) != 1 || !errors.Is(errs[0], ErrMessageMalformed) {
+		t.Errorf("expect ErrMessageMalformed but got %v", errs)
+	}
+
+	// and messages not received in time
+	r = NewReassembler(0, 20*time.Millisecond)
+	readFrames(t, r, frames[:2])
+	time.Sleep(40 * time.Millisecond)
+	msgs, errs = readFrames(t, r, frames[2:])
+	if len(msgs) != 0 || len(errs) != 0 || len(r.pending) != 0 {
+		t.Errorf("expect the message dropped but got %d messages, %v", len(msgs), errs)
+	}
+
+	// corrupted chunks fail their messages
+	corrupted := splitChunks(newChunkedMessage(2, 20*1024), 4096)
+	corrupted[1][len(corrupted[1])-1] ^= 0xff
+	r = NewReassembler(0, 0)
+	msgs, errs = readFrames(t, r, corrupted)
+	if len(msgs) != 0 || len(errs) != 1 || !errors.Is(errs[0], ErrChecksumMismatch) || len(r.pending) != 0 {
+		t.Errorf("expect ErrChecksumMismatch but got %v", errs)
+	}
+}
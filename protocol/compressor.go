@@ -2,9 +2,13 @@ package protocol
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"sync"
 
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 	"github.com/smallnest/rpcx/util"
 )
 
@@ -14,6 +18,13 @@ type Compressor interface {
 	Unzip([]byte) ([]byte, error)
 }
 
+// LimitedUnzipper is implemented by compressors that can stop decompressing at a limit,
+// so that small payloads can't be decompressed into huge ones.
+// UnzipLimit returns an error wrapping ErrMessageTooLong if the decompressed data is longer than limit.
+type LimitedUnzipper interface {
+	UnzipLimit(data []byte, limit int) ([]byte, error)
+}
+
 // GzipCompressor implements gzip compressor.
 type GzipCompressor struct {
 }
@@ -26,6 +37,14 @@ func (c GzipCompressor) Unzip(data []byte) ([]byte, error) {
 	return util.Unzip(data)
 }
 
+func (c GzipCompressor) UnzipLimit(data []byte, limit int) ([]byte, error) {
+	data, err := util.UnzipLimit(data, limit)
+	if err == util.ErrUnzipTooLarge {
+		return nil, unzipTooLong(limit)
+	}
+	return data, err
+}
+
 type RawDataCompressor struct {
 }
 
@@ -37,6 +56,13 @@ func (c RawDataCompressor) Unzip(data []byte) ([]byte, error) {
 	return data, nil
 }
 
+func (c RawDataCompressor) UnzipLimit(data []byte, limit int) ([]byte, error) {
+	if len(data) > limit {
+		return nil, unzipTooLong(limit)
+	}
+	return data, nil
+}
+
 // SnappyCompressor implements snappy compressor
 type SnappyCompressor struct {
 }
@@ -74,3 +100,156 @@ func (c *SnappyCompressor) Unzip(data []byte) ([]byte, error) {
 
 	return out, err
 }
+
+func (c *SnappyCompressor) UnzipLimit(data []byte, limit int) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	reader := io.LimitReader(snappy.NewReader(bytes.NewReader(data)), int64(limit)+1)
+	out, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, unzipTooLong(limit)
+	}
+	return out, nil
+}
+
+// SnappyBlockCompressor implements snappy compressor of the block format.
+// Unlike SnappyCompressor, payloads are encoded and decoded in single blocks without the buffers of streams.
+type SnappyBlockCompressor struct {
+}
+
+func (c *SnappyBlockCompressor) Zip(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (c *SnappyBlockCompressor) Unzip(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	if _, err := snappyDecodedLen(data); err != nil {
+		return nil, err
+	}
+	return snappy.Decode(nil, data)
+}
+
+func (c *SnappyBlockCompressor) UnzipLimit(data []byte, limit int) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	n, err := snappyDecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, unzipTooLong(limit)
+	}
+	return snappy.Decode(nil, data)
+}
+
+// snappyDecodedLen returns the decoded length in the header of the snappy block.
+// Blocks claiming more than they can decode to are corrupted, so they don't allocate huge buffers.
+func snappyDecodedLen(data []byte) (int, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return 0, err
+	}
+	// every 3 bytes of copies decode to at most 64 bytes
+	if n > len(data)*22 {
+		return 0, snappy.ErrCorrupt
+	}
+	return n, nil
+}
+
+// DefaultZstdLevel is the zstd level of the built-in zstd compressor.
+const DefaultZstdLevel = 1
+
+// ZstdCompressor implements zstd compressor.
+// Encoders and decoders are expensive to create, so they are pooled.
+type ZstdCompressor struct {
+	level    zstd.EncoderLevel
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+// NewZstdCompressor creates a ZstdCompressor with the zstd level, such as 1 to 3 for fast compression.
+// It can replace the built-in one in Compressors of both clients and servers:
+//
+//	protocol.Compressors[protocol.Zstd] = protocol.NewZstdCompressor(3)
+func NewZstdCompressor(level int) *ZstdCompressor {
+	return &ZstdCompressor{level: zstd.EncoderLevelFromZstd(level)}
+}
+
+func (c *ZstdCompressor) encoder() (*zstd.Encoder, error) {
+	if e, ok := c.encoders.Get().(*zstd.Encoder); ok {
+		return e, nil
+	}
+	return zstd.NewWriter(nil, zstd.WithEncoderLevel(c.level), zstd.WithEncoderConcurrency(1))
+}
+
+func (c *ZstdCompressor) decoder() (*zstd.Decoder, error) {
+	if d, ok := c.decoders.Get().(*zstd.Decoder); ok {
+		return d, nil
+	}
+	// decoders of concurrency 1 decode in the calling goroutine, so pooled ones don't hold goroutines
+	return zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+}
+
+func (c *ZstdCompressor) Zip(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	e, err := c.encoder()
+	if err != nil {
+		return nil, err
+	}
+	out := e.EncodeAll(data, make([]byte, 0, len(data)/2))
+	c.encoders.Put(e)
+	return out, nil
+}
+
+func (c *ZstdCompressor) Unzip(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	d, err := c.decoder()
+	if err != nil {
+		return nil, err
+	}
+	out, err := d.DecodeAll(data, nil)
+	c.decoders.Put(d)
+	return out, err
+}
+
+func (c *ZstdCompressor) UnzipLimit(data []byte, limit int) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	d, err := c.decoder()
+	if err != nil {
+		return nil, err
+	}
+	if err = d.Reset(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	out, err := ioutil.ReadAll(io.LimitReader(d, int64(limit)+1))
+	d.Reset(nil)
+	c.decoders.Put(d)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, unzipTooLong(limit)
+	}
+	return out, nil
+}
+
+func unzipTooLong(limit int) error {
+	return fmt.Errorf("%w: decompressed payload exceeds the limit of %d bytes", ErrMessageTooLong, limit)
+}
@@ -1,10 +1,15 @@
 package protocol
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
 	"reflect"
 	"testing"
 
 	"github.com/smallnest/rpcx/codec"
+	codectestdata "github.com/smallnest/rpcx/codec/testdata"
 	"github.com/smallnest/rpcx/protocol/testdata"
 )
 
@@ -109,3 +114,205 @@ func BenchmarkSnappyCompressor_Unzip(b *testing.B) {
 	}
 	b.ReportMetric(float64(len(raw)), "bytes")
 }
+
+// newBenchmarkJSON returns a JSON payload of a list of benchmark messages, which is about 10KB.
+func newBenchmarkJSON() []byte {
+	msgs := make([]*testdata.BenchmarkMessage, 20)
+	for i := range msgs {
+		msgs[i] = newBenchmarkMessage()
+	}
+	raw, _ := codec.JSONCodec{}.Encode(msgs)
+	return raw
+}
+
+func TestZstdCompressor(t *testing.T) {
+	raw := newBenchmarkJSON()
+	for _, level := range []int{1, 3} {
+		compressor := NewZstdCompressor(level)
+		zipped, err := compressor.Zip(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(zipped) >= len(raw)/5 {
+			t.Errorf("level %d: expect the payload compressed but got %d of %d bytes", level, len(zipped), len(raw))
+		}
+		// pooled encoders and decoders are reused
+		for i := 0; i < 3; i++ {
+			data, err := compressor.Unzip(zipped)
+			if err != nil || !bytes.Equal(data, raw) {
+				t.Fatalf("level %d: failed to unzip: %v", level, err)
+			}
+			data, err = compressor.UnzipLimit(zipped, len(raw))
+			if err != nil || !bytes.Equal(data, raw) {
+				t.Fatalf("level %d: failed to unzip with the limit: %v", level, err)
+			}
+		}
+	}
+
+	if _, err := NewZstdCompressor(1).Unzip([]byte("not zstd")); err == nil {
+		t.Error("expect an error for invalid data")
+	}
+}
+
+func BenchmarkZstdCompressor_Zip(b *testing.B) {
+	compressor := NewZstdCompressor(DefaultZstdLevel)
+	serializer := codec.PBCodec{}
+	raw, _ := serializer.Encode(newBenchmarkMessage())
+	zipped := make([]byte, 1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		zipped, _ = compressor.Zip(raw)
+	}
+	b.ReportMetric(float64(len(zipped)), "bytes")
+}
+
+func BenchmarkZstdCompressor_Unzip(b *testing.B) {
+	compressor := NewZstdCompressor(DefaultZstdLevel)
+	serializer := codec.PBCodec{}
+	raw, _ := serializer.Encode(newBenchmarkMessage())
+	zipped, _ := compressor.Zip(raw)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		raw, _ = compressor.Unzip(zipped)
+	}
+	b.ReportMetric(float64(len(raw)), "bytes")
+}
+
+func BenchmarkGzipCompressor_ZipJSON(b *testing.B) {
+	compressor := GzipCompressor{}
+	raw := newBenchmarkJSON()
+	var zipped []byte
+	b.SetBytes(int64(len(raw)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		zipped, _ = compressor.Zip(raw)
+	}
+	b.ReportMetric(float64(len(zipped)), "bytes")
+}
+
+func BenchmarkZstdCompressor_ZipJSON(b *testing.B) {
+	for _, level := range []int{1, 3} {
+		b.Run(fmt.Sprintf("level%d", level), func(b *testing.B) {
+			compressor := NewZstdCompressor(level)
+			raw := newBenchmarkJSON()
+			var zipped []byte
+			b.SetBytes(int64(len(raw)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				zipped, _ = compressor.Zip(raw)
+			}
+			b.ReportMetric(float64(len(zipped)), "bytes")
+		})
+	}
+}
+
+func BenchmarkGzipCompressor_UnzipJSON(b *testing.B) {
+	compressor := GzipCompressor{}
+	raw := newBenchmarkJSON()
+	zipped, _ := compressor.Zip(raw)
+	b.SetBytes(int64(len(raw)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compressor.Unzip(zipped)
+	}
+}
+
+func BenchmarkZstdCompressor_UnzipJSON(b *testing.B) {
+	compressor := NewZstdCompressor(DefaultZstdLevel)
+	raw := newBenchmarkJSON()
+	zipped, _ := compressor.Zip(raw)
+	b.SetBytes(int64(len(raw)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compressor.Unzip(zipped)
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	pb, _ := codec.PBCodec{}.Encode(newBenchmarkMessage())
+	msgpack, _ := codec.MsgpackCodec{}.Encode(newBenchmarkMessage())
+	thrift, _ := codec.ThriftCodec{}.Encode(&codectestdata.ThriftColorGroup{ID: 1, Name: "Reds", Colors: []string{"Crimson", "Red", "Ruby", "Maroon"}})
+	payloads := map[SerializeType][]byte{
+		SerializeNone: bytes.Repeat([]byte("raw bytes "), 200),
+		JSON:          newBenchmarkJSON(),
+		ProtoBuffer:   pb,
+		MsgPack:       msgpack,
+		Thrift:        thrift,
+	}
+
+	for st, payload := range payloads {
+		for _, ct := range []CompressType{None, Gzip, Zstd, Snappy} {
+			req := NewMessage()
+			req.SetSerializeType(st)
+			req.SetCompressType(ct)
+			req.ServicePath = "Arith"
+			req.ServiceMethod = "Mul"
+			req.Payload = payload
+
+			res, err := Read(bytes.NewReader(req.Encode()))
+			if err != nil {
+				t.Fatalf("serialize type %d, compress type %d: %v", st, ct, err)
+			}
+			if res.CompressType() != ct || !bytes.Equal(res.Payload, payload) {
+				t.Errorf("serialize type %d, compress type %d: payload is changed", st, ct)
+			}
+		}
+	}
+}
+
+func TestCorruptedPayload(t *testing.T) {
+	raw := newBenchmarkJSON()
+	rnd := rand.New(rand.NewSource(1))
+	for _, ct := range []CompressType{Gzip, Zstd, Snappy} {
+		zipped, _ := Compressors[ct].Zip(raw)
+		payloads := [][]byte{zipped[:len(zipped)/2], []byte("not compressed"), {0xff, 0xff, 0xff, 0xff, 0xff}}
+		for i := 0; i < 100; i++ {
+			b := make([]byte, rnd.Intn(64)+1)
+			rnd.Read(b)
+			payloads = append(payloads, b)
+		}
+
+		for i, payload := range payloads {
+			// the payload is not compressed by Encode
+			req := NewMessage()
+			req.Payload = payload
+			data := req.Encode()
+			data[2] |= byte(ct) << 2
+
+			// random payloads may be valid
+			err := NewMessage().Decode(bytes.NewReader(data))
+			if (err != nil || i < 3) && !errors.Is(err, ErrCorruptedPayload) {
+				t.Errorf("compress type %d, payload %d: expect ErrCorruptedPayload but got %v", ct, i, err)
+			}
+			// or the decompressed length exceeds the limit
+			err = NewMessage().DecodeLimit(bytes.NewReader(data), 1<<20)
+			if err != nil && !errors.Is(err, ErrCorruptedPayload) && !errors.Is(err, ErrMessageTooLong) {
+				t.Errorf("compress type %d, payload %d: expect ErrCorruptedPayload with the limit but got %v", ct, i, err)
+			}
+		}
+	}
+}
+
+func BenchmarkSnappyBlockCompressor_ZipJSON(b *testing.B) {
+	compressor := SnappyBlockCompressor{}
+	raw := newBenchmarkJSON()
+	var zipped []byte
+	b.SetBytes(int64(len(raw)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		zipped, _ = compressor.Zip(raw)
+	}
+	b.ReportMetric(float64(len(zipped)), "bytes")
+}
+
+func BenchmarkSnappyBlockCompressor_UnzipJSON(b *testing.B) {
+	compressor := SnappyBlockCompressor{}
+	raw := newBenchmarkJSON()
+	zipped, _ := compressor.Zip(raw)
+	b.SetBytes(int64(len(raw)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compressor.Unzip(zipped)
+	}
+}
@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// EncodedMessage is a message encoded without copying its payload. The header, the service path,
+// the service method and metadata are encoded into a pooled buffer, and the payload is written after them
+// by the same writev to TCP and unix connections. Servers write responses so only if their AsyncWrite is false,
+// since responses queued for asynchronous writers are copied.
+type EncodedMessage struct {
+	head    *[]byte
+	payload []byte
+	bufs    [2][]byte
+	vec     net.Buffers
+}
+
+var encodedPool = sync.Pool{
+	New: func() interface{} {
+		return &EncodedMessage{}
+	},
+}
+
+// EncodeVectored encodes m like EncodeSlicePointer but refers to its payload instead of copying it,
+// so the payload must not be changed or released until the encoded message is written.
+// The encoded message is returned to the pool by Free.
+func (m Message) EncodeVectored() *EncodedMessage {
+	e := encodedPool.Get().(*EncodedMessage)
+	e.payload = m.wirePayload()
+	e.head = m.encodeHead(e.payload, 0)
+	return e
+}
+
+// Len returns the length of the encoded message.
+func (e *EncodedMessage) Len() int {
+	return len(*e.head) + len(e.payload)
+}
+
+// PayloadLen returns the length of the payload, which is compressed if the message is compressed.
+func (e *EncodedMessage) PayloadLen() int {
+	return len(e.payload)
+}
+
+// WriteTo writes the encoded message to w by one write, so that messages written by concurrent goroutines
+// are not interleaved. The payload is written by writev after the rest of the message to TCP and unix connections,
+// whose writev is atomic, and other writers, such as TLS connections, get a copy of the message in a pooled buffer.
+func (e *EncodedMessage) WriteTo(w io.Writer) (int64, error) {
+	switch w.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		e.bufs[0], e.bufs[1] = *e.head, e.payload
+		e.vec = e.bufs[:]
+		n, err := e.vec.WriteTo(w)
+		e.bufs, e.vec = [2][]byte{}, nil
+		return n, err
+	}
+
+	data := e.Bytes()
+	n, err := w.Write(*data)
+	PutData(data)
+	return int64(n), err
+}
+
+// Bytes copies the encoded message into a pooled buffer, which is returned to the pool by PutData.
+func (e *EncodedMessage) Bytes() *[]byte {
+	data := bufferPool.Get(e.Len())
+	n := copy(*data, *e.head)
+	copy((*data)[n:], e.payload)
+	return data
+}
+
+// Free returns e to the pool. e must not be used after it is freed.
+func (e *EncodedMessage) Free() {
+	PutData(e.head)
+	e.head, e.payload = nil, nil
+	encodedPool.Put(e)
+}
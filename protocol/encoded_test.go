@@ -0,0 +1,205 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newPayloadMessage(seq uint64, payload []byte) *Message {
+	m := NewMessage()
+	m.SetSeq(seq)
+	m.ServicePath = "Blob"
+	m.ServiceMethod = "Echo"
+	m.Metadata = map[string]string{"seq": strconv.FormatUint(seq, 10)}
+	m.Payload = payload
+	return m
+}
+
+func TestEncodeVectored(t *testing.T) {
+	for _, compress := range []CompressType{None, Gzip} {
+		m := newPayloadMessage(1, bytes.Repeat([]byte("0123456789"), 1000))
+		m.SetCompressType(compress)
+		m.SetChecksum(true)
+		expected := m.Encode()
+
+		e := m.EncodeVectored()
+		if e.Len() != len(expected) {
+			t.Errorf("expect %d bytes but got %d", len(expected), e.Len())
+		}
+		data := e.Bytes()
+		if !bytes.Equal(expected, *data) {
+			t.Errorf("the vectored message compressed by %d is different from the encoded one", compress)
+		}
+		PutData(data)
+		var buf bytes.Buffer
+		if n, err := e.WriteTo(&buf); err != nil || n != int64(len(expected)) || !bytes.Equal(expected, buf.Bytes()) {
+			t.Errorf("unexpected write of %d bytes: %v", n, err)
+		}
+		e.Free()
+	}
+}
+
+func selfSignedConfig(t testing.TB) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rpcx"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// connPair returns both ends of a connection of network, which is tcp, unix or tls.
+func connPair(t testing.TB, network string) (client, server net.Conn) {
+	var ln net.Listener
+	var err error
+	switch network {
+	case "unix":
+		dir, derr := ioutil.TempDir("", "rpcx")
+		if derr != nil {
+			t.Fatal(derr)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		ln, err = net.Listen("unix", filepath.Join(dir, "rpcx.sock"))
+	case "tls":
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", selfSignedConfig(t))
+	default:
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		if tc, ok := conn.(*tls.Conn); ok {
+			tc.Handshake()
+		}
+		accepted <- conn
+	}()
+	if network == "tls" {
+		client, err = tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	} else {
+		client, err = net.Dial(ln.Addr().Network(), ln.Addr().String())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = <-accepted
+	if server == nil {
+		t.Fatal("failed to accept the connection")
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestEncodedMessageConcurrentWrites(t *testing.T) {
+	const writers, messages = 8, 16
+	payload := func(seq uint64) []byte {
+		return bytes.Repeat([]byte{byte(seq)}, 1<<10+int(seq)*4093)
+	}
+
+	for _, network := range []string{"tcp", "unix", "tls"} {
+		t.Run(network, func(t *testing.T) {
+			client, server := connPair(t, network)
+
+			// messages written by concurrent writers are not interleaved
+			var wg sync.WaitGroup
+			errCh := make(chan error, writers*messages)
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < messages; j++ {
+						seq := uint64(i*messages + j)
+						e := newPayloadMessage(seq, payload(seq)).EncodeVectored()
+						if _, err := e.WriteTo(client); err != nil {
+							errCh <- err
+						}
+						e.Free()
+					}
+				}(i)
+			}
+
+			seen := make(map[uint64]bool)
+			for len(seen) < writers*messages {
+				m, err := Read(server)
+				if err != nil {
+					t.Fatal(err)
+				}
+				seq := m.Seq()
+				if seen[seq] || m.Metadata["seq"] != strconv.FormatUint(seq, 10) || !bytes.Equal(payload(seq), m.Payload) {
+					t.Fatalf("unexpected message %d of %d bytes", seq, len(m.Payload))
+				}
+				seen[seq] = true
+			}
+			wg.Wait()
+			close(errCh)
+			for err := range errCh {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func benchmarkWrite(b *testing.B, network string, size int, vectored bool) {
+	client, server := connPair(b, network)
+	go io.Copy(ioutil.Discard, server)
+
+	m := newPayloadMessage(1, make([]byte, size))
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if vectored {
+			e := m.EncodeVectored()
+			_, err := e.WriteTo(client)
+			e.Free()
+			if err != nil {
+				b.Fatal(err)
+			}
+			continue
+		}
+		data := m.EncodeSlicePointer()
+		_, err := client.Write(*data)
+		PutData(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWrite_Copy_4KB(b *testing.B)      { benchmarkWrite(b, "tcp", 4<<10, false) }
+func BenchmarkWrite_Vectored_4KB(b *testing.B)  { benchmarkWrite(b, "tcp", 4<<10, true) }
+func BenchmarkWrite_Copy_1MB(b *testing.B)      { benchmarkWrite(b, "tcp", 1<<20, false) }
+func BenchmarkWrite_Vectored_1MB(b *testing.B)  { benchmarkWrite(b, "tcp", 1<<20, true) }
+func BenchmarkWriteTLS_Copy_1MB(b *testing.B)   { benchmarkWrite(b, "tls", 1<<20, false) }
+func BenchmarkWriteTLS_Staged_1MB(b *testing.B) { benchmarkWrite(b, "tls", 1<<20, true) }
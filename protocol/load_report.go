@@ -0,0 +1,54 @@
+package protocol
+
+import (
+	"strconv"
+	"strings"
+)
+
+// LoadReportKey contains the load report of the server in sampled responses, see LoadReport.
+const LoadReportKey = "__rpcx_load__"
+
+// LoadReport is the load of a server right now, which servers attach to responses so that clients can balance by it.
+// It is encoded as "inflight,queue,cpu,weight" in decimal, such as "12,3,45,70".
+type LoadReport struct {
+	InFlight   int // in-flight requests, including queued ones
+	QueueDepth int // requests queued for workers
+	CPU        int // estimated CPU utilization in percents, 0 if it is unknown
+	Weight     int // advisory weight from 0 to 100, which is 0 if the server is draining
+}
+
+func (r LoadReport) String() string {
+	b := make([]byte, 0, 24)
+	b = strconv.AppendInt(b, int64(r.InFlight), 10)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(r.QueueDepth), 10)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(r.CPU), 10)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(r.Weight), 10)
+	return string(b)
+}
+
+// ParseLoadReport parses a load report encoded by LoadReport.String.
+func ParseLoadReport(s string) (LoadReport, bool) {
+	var fields [4]int
+	for i := range fields {
+		v := s
+		if i < len(fields)-1 {
+			j := strings.IndexByte(s, ',')
+			if j < 0 {
+				return LoadReport{}, false
+			}
+			v, s = s[:j], s[j+1:]
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return LoadReport{}, false
+		}
+		fields[i] = n
+	}
+	if fields[3] > 100 {
+		return LoadReport{}, false
+	}
+	return LoadReport{InFlight: fields[0], QueueDepth: fields[1], CPU: fields[2], Weight: fields[3]}, true
+}
@@ -2,20 +2,28 @@ package protocol
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"strconv"
 
+	rerrors "github.com/smallnest/rpcx/errors"
 	"github.com/smallnest/rpcx/util"
 	"github.com/valyala/bytebufferpool"
 )
 
-var bufferPool = util.NewLimitedPool(512, 4096)
+// bufferPool pools buffers which messages are encoded into. Buffers of large messages are pooled too,
+// so that they are reused by the next messages instead of being allocated for each message.
+var bufferPool = util.NewLimitedPool(512, 4<<20)
 
 // Compressors are compressors supported by rpcx. You can add customized compressor in Compressors.
 var Compressors = map[CompressType]Compressor{
-	None: &RawDataCompressor{},
-	Gzip: &GzipCompressor{},
+	None:   &RawDataCompressor{},
+	Gzip:   &GzipCompressor{},
+	Zstd:   NewZstdCompressor(DefaultZstdLevel),
+	Snappy: &SnappyBlockCompressor{},
 }
 
 // MaxMessageLength is the max length of a message.
@@ -36,13 +44,39 @@ var (
 	ErrMetaKVMissing = errors.New("wrong metadata lines. some keys or values are missing")
 	// ErrMessageTooLong message is too long
 	ErrMessageTooLong = errors.New("message is too long")
+	// ErrMagicNumber means the data is not a rpcx message.
+	ErrMagicNumber = errors.New("wrong magic number")
+	// ErrMessageMalformed means lengths in the message don't match its total length.
+	ErrMessageMalformed = errors.New("message is malformed")
 
 	ErrUnsupportedCompressor = errors.New("unsupported compressor")
+	// ErrCorruptedPayload means the payload can't be decompressed with the compress type of the message.
+	ErrCorruptedPayload = errors.New("payload is corrupted")
+	// ErrChecksumMismatch means the payload of a message with a checksum doesn't match the checksum.
+	// Other parts of the message have been decoded when it is returned.
+	ErrChecksumMismatch = rerrors.New(rerrors.DataLoss, "payload checksum mismatch")
 )
 
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
 const (
 	// ServiceError contains error info of service invocation
 	ServiceError = "__rpcx_error__"
+	// ServiceErrorCode contains the code of the error if it has one, see errors.Code
+	ServiceErrorCode = "__rpcx_error_code__"
+	// ServiceErrorDetailPrefix is the prefix of keys that contain details of the error
+	ServiceErrorDetailPrefix = "__rpcx_error_detail_"
+	// ConnRejected contains the reason why the server rejected the connection
+	ConnRejected = "__rpcx_conn_rejected__"
+	// AcceptCompress contains compress types that the client can decompress, separated by commas, such as "1".
+	// In responses it contains new compress types the client accepts which the server can decompress too, such as "2,3"
+	AcceptCompress = "__rpcx_accept_compress__"
+	// PayloadCompressed is set in metadata of responses whose payloads are already compressed, such as images,
+	// so that servers don't compress them again
+	PayloadCompressed = "__rpcx_payload_compressed__"
+	// ChecksumKey contains the CRC32C (Castagnoli) of the payload on the wire, which is compressed if the message is,
+	// as 8 hex digits. It is set in messages whose headers have the checksum flag
+	ChecksumKey = "__rpcx_checksum__"
 )
 
 // MessageType is message type of requests and responses.
@@ -66,13 +100,21 @@ const (
 )
 
 // CompressType defines decompression type.
+// It is stored in bits 2 to 4 of the third byte of the header, so its wire values are 0 to 7.
+// Values not defined here can be used by customized compressors.
 type CompressType byte
 
 const (
-	// None does not compress.
+	// None does not compress. Its wire value is 0.
 	None CompressType = iota
-	// Gzip uses gzip compression.
+	// Gzip uses gzip compression. Its wire value is 1.
 	Gzip
+	// Zstd uses zstd compression, whose payloads are zstd frames. Its wire value is 2.
+	// Peers of old versions can't decompress it, so clients compress requests with it only after servers accept it.
+	Zstd
+	// Snappy uses the snappy block format without the framing of snappy streams. Its wire value is 3.
+	// It costs less CPU than Zstd but compresses less. Like Zstd, clients use it only after servers accept it.
+	Snappy
 )
 
 // SerializeType defines serialization type of payload.
@@ -90,8 +132,38 @@ const (
 	// Thrift
 	// Thrift for payload
 	Thrift
+	// CBOR for payload, see RFC 8949.
+	CBOR
+	// Avro for payload. Its codec needs schemas, so it is registered by share.RegisterCodec with codec.NewAvroCodec.
+	Avro
+	// FlatBuffers for payload, which handlers read without copying, see codec.FlatBuffersCodec.
+	FlatBuffers
+)
+
+// Priority is the priority of requests, by which servers WithPriorityScheduling dispatch queued requests.
+// It is stored in bits 3-2 of the fourth byte of the header, which are ignored by peers of old versions.
+type Priority byte
+
+const (
+	// PriorityNormal is the priority of requests of clients which don't set priorities. Its wire value is 0.
+	PriorityNormal Priority = iota
+	// PriorityLow is for batch and background requests. Its wire value is 1.
+	PriorityLow
+	// PriorityHigh is for latency-sensitive requests. Its wire value is 2.
+	PriorityHigh
+	// PriorityCritical is the highest priority, such as for control requests. Its wire value is 3.
+	PriorityCritical
 )
 
+var priorityNames = [...]string{"normal", "low", "high", "critical"}
+
+func (p Priority) String() string {
+	if int(p) < len(priorityNames) {
+		return priorityNames[p]
+	}
+	return "Priority(" + strconv.Itoa(int(p)) + ")"
+}
+
 // Message is the generic type of Request and Response.
 type Message struct {
 	*Header
@@ -100,6 +172,13 @@ type Message struct {
 	Metadata      map[string]string
 	Payload       []byte
 	data          []byte
+
+	buf         *[]byte           // the pooled buffer of data
+	wire        []byte            // the payload on the wire, compressed from wireOf
+	wireOf      []byte            // the payload wire is compressed from
+	decodedMeta map[string]string // Metadata decoded by Decode
+	spareMeta   map[string]string // the cleared map for the next decoding
+	refs        int32             // retains
 }
 
 // NewMessage creates an empty message.
@@ -115,6 +194,12 @@ func NewMessage() *Message {
 // Header is the first part of Message and has fixed size.
 // Format:
 //
+//	byte 0: magic number 0x08
+//	byte 1: version
+//	byte 2: bit 7 message type, bit 6 heartbeat, bit 5 oneway, bits 4-2 compress type, bits 1-0 status type
+//	byte 3: bits 7-4 serialize type, bits 3-2 priority, bit 1 compact metadata (see HasCompactMetadata),
+//	        bit 0 checksum, which is ignored by peers of old versions
+//	bytes 4-11: sequence number in big endian
 type Header [12]byte
 
 // CheckMagicNumber checks whether header starts rpcx magic number.
@@ -201,6 +286,30 @@ func (h *Header) SetSerializeType(st SerializeType) {
 	h[3] = (h[3] &^ 0xF0) | (byte(st) << 4)
 }
 
+// HasChecksum returns whether the message carries the checksum of its payload in metadata.
+func (h Header) HasChecksum() bool {
+	return h[3]&0x01 == 0x01
+}
+
+// SetChecksum sets whether the checksum of the payload is added to metadata when the message is encoded.
+func (h *Header) SetChecksum(checksum bool) {
+	if checksum {
+		h[3] = h[3] | 0x01
+	} else {
+		h[3] = h[3] &^ 0x01
+	}
+}
+
+// Priority returns the priority of the request.
+func (h Header) Priority() Priority {
+	return Priority((h[3] & 0x0C) >> 2)
+}
+
+// SetPriority sets the priority of the request.
+func (h *Header) SetPriority(p Priority) {
+	h[3] = (h[3] &^ 0x0C) | (byte(p&0x03) << 2)
+}
+
 // Seq returns sequence number of messages.
 func (h Header) Seq() uint64 {
 	return binary.BigEndian.Uint64(h[4:])
@@ -213,10 +322,9 @@ func (h *Header) SetSeq(seq uint64) {
 
 // Clone clones from an message.
 func (m Message) Clone() *Message {
-	header := *m.Header
 	c := GetPooledMsg()
-	header.SetCompressType(None)
-	c.Header = &header
+	*c.Header = *m.Header
+	c.SetCompressType(None)
 	c.ServicePath = m.ServicePath
 	c.ServiceMethod = m.ServiceMethod
 	return c
@@ -230,35 +338,67 @@ func (m Message) Encode() []byte {
 
 // EncodeSlicePointer encodes messages as a byte slice poiter we we can use pool to improve.
 func (m Message) EncodeSlicePointer() *[]byte {
+	payload := m.wirePayload()
+	data := m.encodeHead(payload, len(payload))
+	copy((*data)[len(*data)-len(payload):], payload)
+	return data
+}
+
+// WirePayload returns the payload as it is written to connections, compressed by the compress type of m.
+// For decoded messages it is the payload as it was read, before it was decompressed.
+// The compressed payload is kept until Payload is changed, so it is not compressed again when m is encoded.
+func (m *Message) WirePayload() []byte {
+	payload := m.wirePayload()
+	if m.CompressType() != None {
+		m.wire, m.wireOf = payload, m.Payload
+	}
+	return payload
+}
+
+// wirePayload returns the payload compressed by the compress type of m.
+// The compress type is reset to None if the payload can't be compressed.
+func (m Message) wirePayload() []byte {
+	if m.CompressType() == None {
+		return m.Payload
+	}
+	if m.wire != nil && sameBytes(m.wireOf, m.Payload) {
+		return m.wire
+	}
+	compressor := Compressors[m.CompressType()]
+	if compressor == nil {
+		m.SetCompressType(None)
+		return m.Payload
+	}
+	payload, err := compressor.Zip(m.Payload)
+	if err != nil {
+		m.SetCompressType(None)
+		return m.Payload
+	}
+	return payload
+}
+
+// sameBytes returns whether a and b are the same bytes of memory.
+func sameBytes(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// encodeHead encodes m up to the length of payload into a pooled buffer,
+// which has room for reserved bytes of the payload after it.
+func (m Message) encodeHead(payload []byte, reserved int) *[]byte {
 	bb := bytebufferpool.Get()
-	encodeMetadata(m.Metadata, bb)
+	m.encodeMetadataTo(bb, payload)
 	meta := bb.Bytes()
 
 	spL := len(m.ServicePath)
 	smL := len(m.ServiceMethod)
 
-	var err error
-	payload := m.Payload
-	if m.CompressType() != None {
-		compressor := Compressors[m.CompressType()]
-		if compressor == nil {
-			m.SetCompressType(None)
-		} else {
-			payload, err = compressor.Zip(m.Payload)
-			if err != nil {
-				m.SetCompressType(None)
-				payload = m.Payload
-			}
-		}
-	}
-
 	totalL := (4 + spL) + (4 + smL) + (4 + len(meta)) + (4 + len(payload))
 
 	// header + dataLen + spLen + sp + smLen + sm + metaL + meta + payloadLen + payload
 	metaStart := 12 + 4 + (4 + spL) + (4 + smL)
 
 	payLoadStart := metaStart + (4 + len(meta))
-	l := 12 + 4 + totalL
+	l := payLoadStart + 4 + reserved
 
 	data := bufferPool.Get(l)
 	copy(*data, m.Header[:])
@@ -278,7 +418,6 @@ func (m Message) EncodeSlicePointer() *[]byte {
 	bytebufferpool.Put(bb)
 
 	binary.BigEndian.PutUint32((*data)[payLoadStart:payLoadStart+4], uint32(len(payload)))
-	copy((*data)[payLoadStart+4:], payload)
 
 	return data
 }
@@ -296,13 +435,6 @@ func (m Message) WriteTo(w io.Writer) (int64, error) {
 		return n, err
 	}
 
-	bb := bytebufferpool.Get()
-	encodeMetadata(m.Metadata, bb)
-	meta := bb.Bytes()
-
-	spL := len(m.ServicePath)
-	smL := len(m.ServiceMethod)
-
 	payload := m.Payload
 	if m.CompressType() != None {
 		compressor := Compressors[m.CompressType()]
@@ -315,6 +447,13 @@ func (m Message) WriteTo(w io.Writer) (int64, error) {
 		}
 	}
 
+	bb := bytebufferpool.Get()
+	m.encodeMetadataTo(bb, payload)
+	meta := bb.Bytes()
+
+	spL := len(m.ServicePath)
+	smL := len(m.ServiceMethod)
+
 	totalL := (4 + spL) + (4 + smL) + (4 + len(meta)) + (4 + len(payload))
 	err = binary.Write(w, binary.BigEndian, uint32(totalL))
 	if err != nil {
@@ -361,6 +500,21 @@ func (m Message) WriteTo(w io.Writer) (int64, error) {
 	return int64(nn), err
 }
 
+// encodeMetadataTo encodes Metadata, and the checksum of payload if the message has the checksum flag, into bb.
+func (m Message) encodeMetadataTo(bb *bytebufferpool.ByteBuffer, payload []byte) {
+	if m.HasCompactMetadata() {
+		encodeCompactMetadata(m.Metadata, bb)
+		if m.HasChecksum() {
+			encodeCompactMetadataKV(ChecksumKey, payloadChecksum(payload), bb)
+		}
+		return
+	}
+	encodeMetadata(m.Metadata, bb)
+	if m.HasChecksum() {
+		encodeMetadataKV(ChecksumKey, payloadChecksum(payload), bb)
+	}
+}
+
 // len,string,len,string,......
 func encodeMetadata(m map[string]string, bb *bytebufferpool.ByteBuffer) {
 	if len(m) == 0 {
@@ -377,16 +531,38 @@ func encodeMetadata(m map[string]string, bb *bytebufferpool.ByteBuffer) {
 	}
 }
 
-func decodeMetadata(l uint32, data []byte) (map[string]string, error) {
-	m := make(map[string]string, 10)
+// encodeMetadataKV appends a key and its value to encoded metadata.
+// It overrides the same key in the metadata, since later keys win when metadata are decoded.
+func encodeMetadataKV(k, v string, bb *bytebufferpool.ByteBuffer) {
+	var d [4]byte
+	binary.BigEndian.PutUint32(d[:], uint32(len(k)))
+	bb.Write(d[:])
+	bb.WriteString(k)
+	binary.BigEndian.PutUint32(d[:], uint32(len(v)))
+	bb.Write(d[:])
+	bb.WriteString(v)
+}
+
+// payloadChecksum returns the CRC32C of payload in hex.
+func payloadChecksum(payload []byte) string {
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(payload, castagnoliTable))
+	return hex.EncodeToString(sum[:])
+}
+
+// decodeMetadata decodes metadata into m.
+func decodeMetadata(m map[string]string, l uint32, data []byte) error {
 	n := uint32(0)
 	for n < l {
 		// parse one key and value
 		// key
+		if uint64(n)+4 > uint64(l) {
+			return ErrMetaKVMissing
+		}
 		sl := binary.BigEndian.Uint32(data[n : n+4])
 		n = n + 4
-		if n+sl > l-4 {
-			return m, ErrMetaKVMissing
+		if uint64(n)+uint64(sl)+4 > uint64(l) {
+			return ErrMetaKVMissing
 		}
 		k := string(data[n : n+sl])
 		n = n + sl
@@ -394,15 +570,15 @@ func decodeMetadata(l uint32, data []byte) (map[string]string, error) {
 		// value
 		sl = binary.BigEndian.Uint32(data[n : n+4])
 		n = n + 4
-		if n+sl > l {
-			return m, ErrMetaKVMissing
+		if uint64(n)+uint64(sl) > uint64(l) {
+			return ErrMetaKVMissing
 		}
 		v := string(data[n : n+sl])
 		n = n + sl
 		m[k] = v
 	}
 
-	return m, nil
+	return nil
 }
 
 // Read reads a message from r.
@@ -416,16 +592,23 @@ func Read(r io.Reader) (*Message, error) {
 }
 
 // Decode decodes a message from reader.
+// The length of the message is limited by MaxMessageLength.
 func (m *Message) Decode(r io.Reader) error {
-	// validate rest length for each step?
+	return m.DecodeLimit(r, MaxMessageLength)
+}
 
+// DecodeLimit decodes a message from reader.
+// If maxLength is positive, messages and decompressed payloads longer than maxLength
+// are rejected with an error wrapping ErrMessageTooLong before they are allocated.
+// The header of the message has been decoded when such an error is returned.
+func (m *Message) DecodeLimit(r io.Reader, maxLength int) error {
 	// parse header
 	_, err := io.ReadFull(r, m.Header[:1])
 	if err != nil {
 		return err
 	}
 	if !m.Header.CheckMagicNumber() {
-		return fmt.Errorf("wrong magic number: %v", m.Header[0])
+		return fmt.Errorf("%w: %v", ErrMagicNumber, m.Header[0])
 	}
 
 	_, err = io.ReadFull(r, m.Header[1:])
@@ -443,15 +626,15 @@ func (m *Message) Decode(r io.Reader) error {
 	l := binary.BigEndian.Uint32(*lenData)
 	poolUint32Data.Put(lenData)
 
-	if MaxMessageLength > 0 && int(l) > MaxMessageLength {
-		return ErrMessageTooLong
+	if maxLength > 0 && uint64(l) > uint64(maxLength) {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrMessageTooLong, l, maxLength)
 	}
 
 	totalL := int(l)
 	if cap(m.data) >= totalL { // reuse data
 		m.data = m.data[:totalL]
 	} else {
-		m.data = make([]byte, totalL)
+		m.allocData(totalL)
 	}
 	data := m.data
 	_, err = io.ReadFull(r, data)
@@ -460,27 +643,52 @@ func (m *Message) Decode(r io.Reader) error {
 	}
 
 	n := 0
+	// next returns the end of the next field whose length is encoded at n
+	next := func() (int, bool) {
+		if n+4 > len(data) {
+			return 0, false
+		}
+		l = binary.BigEndian.Uint32(data[n : n+4])
+		n = n + 4
+		if uint64(l) > uint64(len(data)-n) {
+			return 0, false
+		}
+		return n + int(l), true
+	}
+
 	// parse servicePath
-	l = binary.BigEndian.Uint32(data[n:4])
-	n = n + 4
-	nEnd := n + int(l)
+	nEnd, ok := next()
+	if !ok {
+		return ErrMessageMalformed
+	}
 	m.ServicePath = util.SliceByteToString(data[n:nEnd])
 	n = nEnd
 
 	// parse serviceMethod
-	l = binary.BigEndian.Uint32(data[n : n+4])
-	n = n + 4
-	nEnd = n + int(l)
+	if nEnd, ok = next(); !ok {
+		return ErrMessageMalformed
+	}
 	m.ServiceMethod = util.SliceByteToString(data[n:nEnd])
 	n = nEnd
 
 	// parse meta
-	l = binary.BigEndian.Uint32(data[n : n+4])
-	n = n + 4
-	nEnd = n + int(l)
+	if nEnd, ok = next(); !ok {
+		return ErrMessageMalformed
+	}
 
 	if l > 0 {
-		m.Metadata, err = decodeMetadata(l, data[n:nEnd])
+		m.recycleMetadata()
+		meta := m.spareMeta
+		if meta == nil {
+			meta = make(map[string]string, 10)
+		}
+		m.spareMeta = nil
+		m.Metadata, m.decodedMeta = meta, meta
+		if m.HasCompactMetadata() {
+			err = decodeCompactMetadata(meta, data[n:nEnd])
+		} else {
+			err = decodeMetadata(meta, l, data[n:nEnd])
+		}
 		if err != nil {
 			return err
 		}
@@ -488,20 +696,42 @@ func (m *Message) Decode(r io.Reader) error {
 	n = nEnd
 
 	// parse payload
-	l = binary.BigEndian.Uint32(data[n : n+4])
-	_ = l
+	if n+4 > len(data) {
+		return ErrMessageMalformed
+	}
 	n = n + 4
 	m.Payload = data[n:]
+	m.wire, m.wireOf = nil, nil
+
+	// verified before decompression, so corrupted payloads are not decompressed
+	if m.HasChecksum() {
+		sum := m.Metadata[ChecksumKey]
+		delete(m.Metadata, ChecksumKey)
+		if sum != payloadChecksum(m.Payload) {
+			return ErrChecksumMismatch
+		}
+	}
 
 	if m.CompressType() != None {
 		compressor := Compressors[m.CompressType()]
 		if compressor == nil {
 			return ErrUnsupportedCompressor
 		}
-		m.Payload, err = compressor.Unzip(m.Payload)
+		if lu, ok := compressor.(LimitedUnzipper); ok && maxLength > 0 {
+			m.Payload, err = lu.UnzipLimit(m.Payload, maxLength)
+		} else {
+			m.Payload, err = compressor.Unzip(m.Payload)
+		}
 		if err != nil {
+			if !errors.Is(err, ErrMessageTooLong) {
+				err = fmt.Errorf("%w: %v", ErrCorruptedPayload, err)
+			}
 			return err
 		}
+		m.wire, m.wireOf = data[n:], m.Payload
+		if maxLength > 0 && len(m.Payload) > maxLength {
+			return fmt.Errorf("%w: decompressed payload exceeds the limit of %d bytes", ErrMessageTooLong, maxLength)
+		}
 	}
 
 	return err
@@ -510,8 +740,10 @@ func (m *Message) Decode(r io.Reader) error {
 // Reset clean data of this message but keep allocated data
 func (m *Message) Reset() {
 	resetHeader(m.Header)
+	m.recycleMetadata()
 	m.Metadata = nil
 	m.Payload = []byte{}
+	m.wire, m.wireOf = nil, nil
 	m.data = m.data[:0]
 	m.ServicePath = ""
 	m.ServiceMethod = ""
@@ -523,5 +755,6 @@ var (
 )
 
 func resetHeader(h *Header) {
+	h[0] = magicNumber // a failed decoding may leave another byte
 	copy(h[1:], zeroHeader)
 }
@@ -2,6 +2,10 @@ package protocol
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"strings"
 	"testing"
 )
 
@@ -58,3 +62,184 @@ func TestMessage(t *testing.T) {
 		t.Errorf("got wrong payload: %v", string(res.Payload))
 	}
 }
+
+func TestDecodeLimit(t *testing.T) {
+	req := NewMessage()
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Add"
+	req.Payload = bytes.Repeat([]byte("a"), 1000)
+	data := req.Encode()
+
+	// the declared size is checked before the message is read
+	m := NewMessage()
+	err := m.DecodeLimit(bytes.NewReader(data[:16]), 100)
+	if !errors.Is(err, ErrMessageTooLong) {
+		t.Fatalf("expect ErrMessageTooLong but got %v", err)
+	}
+	if !strings.Contains(err.Error(), "limit of 100 bytes") {
+		t.Errorf("expect the limit in the error but got %v", err)
+	}
+
+	if err := NewMessage().DecodeLimit(bytes.NewReader(data), len(data)); err != nil {
+		t.Errorf("expect no error but got %v", err)
+	}
+
+	// the decompressed size is limited too
+	const snappy CompressType = 7
+	Compressors[snappy] = &SnappyCompressor{}
+	defer delete(Compressors, snappy)
+	for _, ct := range []CompressType{Gzip, snappy, Zstd, Snappy} {
+		req.SetCompressType(ct)
+		req.Payload = bytes.Repeat([]byte("a"), 1<<20)
+		data = req.Encode()
+		if len(data) > 1<<16 {
+			t.Fatalf("expect compressed message but got %d bytes", len(data))
+		}
+		err = NewMessage().DecodeLimit(bytes.NewReader(data), 1<<16)
+		if !errors.Is(err, ErrMessageTooLong) {
+			t.Errorf("compress type %d: expect ErrMessageTooLong but got %v", ct, err)
+		}
+	}
+}
+
+func TestDecodeBadFrames(t *testing.T) {
+	req := NewMessage()
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Add"
+	req.Metadata = map[string]string{"k": "v", "key": "value"}
+	req.Payload = []byte(`{"A":1,"B":2}`)
+	data := req.Encode()
+
+	if err := NewMessage().Decode(bytes.NewReader([]byte{0x01, 0x02})); !errors.Is(err, ErrMagicNumber) {
+		t.Errorf("expect ErrMagicNumber but got %v", err)
+	}
+
+	// truncated frames
+	for i := 0; i < len(data); i++ {
+		if err := NewMessage().Decode(bytes.NewReader(data[:i])); err == nil {
+			t.Errorf("expect error for a frame truncated at %d", i)
+		}
+	}
+
+	// random corruptions must not panic
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		b := append([]byte{}, data...)
+		for j := 0; j < 1+rnd.Intn(4); j++ {
+			b[1+rnd.Intn(len(b)-1)] = byte(rnd.Intn(256))
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("panic decoding %x: %v", b, r)
+				}
+			}()
+			NewMessage().DecodeLimit(bytes.NewReader(b), 1<<20)
+		}()
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	for _, ct := range []CompressType{None, Gzip, Zstd, Snappy} {
+		req := NewMessage()
+		req.SetCompressType(ct)
+		req.SetChecksum(true)
+		req.ServicePath = "Arith"
+		req.ServiceMethod = "Add"
+		req.Metadata = map[string]string{"k": "v"}
+		req.Payload = bytes.Repeat([]byte(`{"A":1,"B":2}`), 100)
+		data := req.Encode()
+
+		res := NewMessage()
+		if err := res.Decode(bytes.NewReader(data)); err != nil {
+			t.Fatalf("compress type %d: %v", ct, err)
+		}
+		if !res.HasChecksum() || !bytes.Equal(res.Payload, req.Payload) {
+			t.Fatalf("compress type %d: got checksum %t and payload %q", ct, res.HasChecksum(), res.Payload)
+		}
+		if _, ok := res.Metadata[ChecksumKey]; ok || res.Metadata["k"] != "v" {
+			t.Errorf("compress type %d: unexpected metadata %v", ct, res.Metadata)
+		}
+
+		// skips the header, service path, service method and metadata to the payload,
+		// every single-bit flip of which is detected
+		start := 16
+		for j := 0; j < 3; j++ {
+			start += 4 + int(binary.BigEndian.Uint32(data[start:]))
+		}
+		start += 4
+		for i := start * 8; i < len(data)*8; i++ {
+			b := append([]byte{}, data...)
+			b[i/8] ^= 1 << (i % 8)
+			if err := NewMessage().Decode(bytes.NewReader(b)); !errors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("compress type %d: expect ErrChecksumMismatch for flipped bit %d but got %v", ct, i, err)
+			}
+		}
+	}
+}
+
+func TestWirePayload(t *testing.T) {
+	req := NewMessage()
+	req.SetCompressType(Gzip)
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Add"
+	req.Payload = bytes.Repeat([]byte(`{"A":1,"B":2}`), 100)
+	wire := req.WirePayload()
+	if bytes.Equal(wire, req.Payload) {
+		t.Fatal("expect the wire payload to be compressed")
+	}
+	data := req.Encode()
+	if !bytes.HasSuffix(data, wire) {
+		t.Fatal("expect the kept wire payload to be encoded")
+	}
+
+	res := NewMessage()
+	if err := res.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res.Payload, req.Payload) || !bytes.Equal(res.WirePayload(), wire) {
+		t.Fatalf("expect the payload read before decompression but got %q", res.WirePayload())
+	}
+
+	// a changed payload is compressed again
+	req.Payload = []byte(`{"A":1,"B":3}`)
+	res.Reset()
+	if err := res.Decode(bytes.NewReader(req.Encode())); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Payload) != `{"A":1,"B":3}` {
+		t.Errorf("expect the changed payload but got %q", res.Payload)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	for _, p := range []Priority{PriorityNormal, PriorityLow, PriorityHigh, PriorityCritical} {
+		req := NewMessage()
+		req.SetSerializeType(FlatBuffers)
+		req.SetCompactMetadata(true)
+		req.SetChecksum(true)
+		req.SetPriority(p)
+
+		res, err := Read(bytes.NewReader(req.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Priority() != p || res.SerializeType() != FlatBuffers || !res.HasCompactMetadata() || !res.HasChecksum() {
+			t.Errorf("unexpected header %v of priority %s", res.Header, p)
+		}
+	}
+	if s := Priority(4).String(); s != "Priority(4)" {
+		t.Errorf("unexpected name %s", s)
+	}
+}
+
+func TestResetAfterWrongMagicNumber(t *testing.T) {
+	m := NewMessage()
+	if err := m.Decode(bytes.NewReader([]byte("hello"))); !errors.Is(err, ErrMagicNumber) {
+		t.Fatalf("expect ErrMagicNumber but got %v", err)
+	}
+	m.Reset()
+	if !m.CheckMagicNumber() {
+		t.Fatalf("magic number is not reset: %v", m.Header[0])
+	}
+}
@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/valyala/bytebufferpool"
+)
+
+// compactKeys is the static dictionary of compact metadata. Key i is encoded as the tag i+1.
+// Peers decode tags by the same dictionary, so it must never be changed: new keys are encoded
+// as strings, or added with a new capability.
+var compactKeys = []string{
+	// share
+	"__AUTH",
+	"__ServerTimeout",
+	"x-rpcx-request-id",
+	"opencensus_span_request_key",
+	"__rpcx_sign_key_id__",
+	"__rpcx_sign_timestamp__",
+	"__rpcx_sign_nonce__",
+	"__rpcx_signature__",
+	// W3C trace context and baggage
+	"traceparent",
+	"tracestate",
+	"baggage",
+	"uber-trace-id",
+	// protocol
+	ServiceError,
+	ServiceErrorCode,
+	ConnRejected,
+	AcceptCompress,
+	PayloadCompressed,
+	ChecksumKey,
+	ChunkKey,
+	AcceptChunk,
+}
+
+var compactTags = func() map[string]byte {
+	tags := make(map[string]byte, len(compactKeys))
+	for i, k := range compactKeys {
+		tags[k] = byte(i + 1)
+	}
+	return tags
+}()
+
+// HasCompactMetadata returns whether metadata of the message are encoded in the compact format,
+// in which well-known keys, such as the request ID, auth and trace context, are encoded as one-byte tags
+// and lengths are varints. Peers of old versions can't decode it, so it is used only with peers
+// which negotiate CapCompactMetadata.
+func (h Header) HasCompactMetadata() bool {
+	return h[3]&0x02 == 0x02
+}
+
+// SetCompactMetadata sets whether metadata of the message are encoded in the compact format.
+func (h *Header) SetCompactMetadata(compact bool) {
+	if compact {
+		h[3] = h[3] | 0x02
+	} else {
+		h[3] = h[3] &^ 0x02
+	}
+}
+
+// encodeCompactMetadata encodes m in the compact format:
+// a tag byte followed by the varint length and the value for each key,
+// and the varint length and the key follow the zero tag for keys not in the dictionary.
+func encodeCompactMetadata(m map[string]string, bb *bytebufferpool.ByteBuffer) {
+	for k, v := range m {
+		encodeCompactMetadataKV(k, v, bb)
+	}
+}
+
+// encodeCompactMetadataKV appends a key and its value to compact metadata.
+func encodeCompactMetadataKV(k, v string, bb *bytebufferpool.ByteBuffer) {
+	var scratch [1 + binary.MaxVarintLen64]byte
+	if tag, ok := compactTags[k]; ok {
+		scratch[0] = tag
+		bb.Write(scratch[:1+binary.PutUvarint(scratch[1:], uint64(len(v)))])
+	} else {
+		bb.Write(scratch[:1+binary.PutUvarint(scratch[1:], uint64(len(k)))])
+		bb.WriteString(k)
+		bb.Write(scratch[1 : 1+binary.PutUvarint(scratch[1:], uint64(len(v)))])
+	}
+	bb.WriteString(v)
+}
+
+// decodeCompactMetadata decodes compact metadata into m. Keys in the dictionary are not allocated.
+func decodeCompactMetadata(m map[string]string, data []byte) error {
+	n := 0
+	for n < len(data) {
+		tag := data[n]
+		n++
+		var k string
+		if tag == 0 {
+			kEnd, ok := nextUvarintField(data, &n)
+			if !ok {
+				return ErrMetaKVMissing
+			}
+			k = string(data[n:kEnd])
+			n = kEnd
+		} else if int(tag) <= len(compactKeys) {
+			k = compactKeys[tag-1]
+		} else {
+			return fmt.Errorf("%w: unknown metadata tag %d", ErrMessageMalformed, tag)
+		}
+
+		vEnd, ok := nextUvarintField(data, &n)
+		if !ok {
+			return ErrMetaKVMissing
+		}
+		m[k] = string(data[n:vEnd])
+		n = vEnd
+	}
+	return nil
+}
+
+// nextUvarintField reads the varint length at *n, which is moved to the start of the field,
+// and returns the end of the field.
+func nextUvarintField(data []byte, n *int) (int, bool) {
+	l, w := binary.Uvarint(data[*n:])
+	if w <= 0 {
+		return 0, false
+	}
+	*n += w
+	if l > uint64(len(data)-*n) {
+		return 0, false
+	}
+	return *n + int(l), true
+}
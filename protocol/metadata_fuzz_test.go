@@ -0,0 +1,62 @@
+//go:build go1.18
+// +build go1.18
+
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/valyala/bytebufferpool"
+)
+
+func FuzzDecodeCompactMetadata(f *testing.F) {
+	bb := bytebufferpool.Get()
+	encodeCompactMetadata(typicalMetadata, bb)
+	f.Add(append([]byte(nil), bb.B...))
+	bytebufferpool.Put(bb)
+	f.Add([]byte{0, 1, 'k', 1, 'v'})
+	f.Add([]byte{1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := make(map[string]string)
+		if err := decodeCompactMetadata(m, data); err != nil {
+			return
+		}
+
+		// decoded metadata are encoded and decoded to the same metadata
+		bb := bytebufferpool.Get()
+		defer bytebufferpool.Put(bb)
+		encodeCompactMetadata(m, bb)
+		got := make(map[string]string)
+		if err := decodeCompactMetadata(got, bb.B); err != nil {
+			t.Fatalf("failed to decode encoded %v: %v", m, err)
+		}
+		if !reflect.DeepEqual(m, got) {
+			t.Fatalf("expect %v but got %v", m, got)
+		}
+	})
+}
+
+func FuzzCompactMetadataMessage(f *testing.F) {
+	f.Add("tenant", "acme", false)
+	f.Add("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true)
+
+	f.Fuzz(func(t *testing.T, k, v string, checksum bool) {
+		meta := map[string]string{"__AUTH": "token", k: v}
+		m := newMetadataMessage(true, meta)
+		m.SetChecksum(checksum)
+
+		got := NewMessage()
+		if err := got.Decode(bytes.NewReader(m.Encode())); err != nil {
+			t.Fatal(err)
+		}
+		if checksum {
+			delete(meta, ChecksumKey) // overridden by the checksum
+		}
+		if !reflect.DeepEqual(meta, got.Metadata) {
+			t.Fatalf("expect %v but got %v", meta, got.Metadata)
+		}
+	})
+}
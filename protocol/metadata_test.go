@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// typicalMetadata is metadata of requests with tracing, auth, the request ID, a tenant and a deadline.
+var typicalMetadata = map[string]string{
+	"traceparent":       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	"tracestate":        "rojo=00f067aa0ba902b7",
+	"x-rpcx-request-id": "c22a6393efe2affa",
+	"__AUTH":            "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJzdWIiOiIxMjM0NTY3ODkwIn0",
+	"__ServerTimeout":   "500",
+	"tenant":            "acme",
+}
+
+func newMetadataMessage(compact bool, meta map[string]string) *Message {
+	m := NewMessage()
+	m.SetCompactMetadata(compact)
+	m.SetSeq(1)
+	m.ServicePath = "Arith"
+	m.ServiceMethod = "Mul"
+	m.Metadata = meta
+	m.Payload = []byte(`{"A":10,"B":20}`)
+	return m
+}
+
+func TestCompactMetadata(t *testing.T) {
+	legacy := newMetadataMessage(false, typicalMetadata).Encode()
+	compact := newMetadataMessage(true, typicalMetadata).Encode()
+	if len(compact) > len(legacy)-90 {
+		t.Errorf("expect compact metadata to save at least 90 bytes but got %d and %d bytes", len(compact), len(legacy))
+	}
+
+	// checksums are added to compact metadata too
+	m := newMetadataMessage(true, typicalMetadata)
+	m.SetChecksum(true)
+	compact = m.Encode()
+
+	got := NewMessage()
+	if err := got.Decode(bytes.NewReader(compact)); err != nil {
+		t.Fatal(err)
+	}
+	if !got.HasCompactMetadata() || got.ServiceMethod != "Mul" || string(got.Payload) != `{"A":10,"B":20}` {
+		t.Errorf("unexpected message %+v", got)
+	}
+	if !reflect.DeepEqual(typicalMetadata, got.Metadata) {
+		t.Errorf("expect %v but got %v", typicalMetadata, got.Metadata)
+	}
+
+	// responses cloned from requests have compact metadata too
+	if !got.Clone().HasCompactMetadata() {
+		t.Error("expect compact metadata in the clone")
+	}
+
+	// and they are decoded into reused maps with empty values
+	if err := got.Decode(bytes.NewReader(newMetadataMessage(true, map[string]string{"__AUTH": "", "": "v"}).Encode())); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Metadata) != 2 || got.Metadata["__AUTH"] != "" || got.Metadata[""] != "v" {
+		t.Errorf("unexpected metadata %v", got.Metadata)
+	}
+}
+
+func TestCompactMetadataMalformed(t *testing.T) {
+	for _, data := range [][]byte{
+		{0},                   // missing key length
+		{0, 5, 'k'},           // short key
+		{0, 1, 'k'},           // missing value length
+		{1, 3, 'v'},           // short value
+		{1, 0xff, 0xff, 0xff}, // bad varint
+	} {
+		if err := decodeCompactMetadata(make(map[string]string), data); !errors.Is(err, ErrMetaKVMissing) {
+			t.Errorf("expect ErrMetaKVMissing for %v but got %v", data, err)
+		}
+	}
+	if err := decodeCompactMetadata(make(map[string]string), []byte{255, 0}); !errors.Is(err, ErrMessageMalformed) {
+		t.Errorf("expect ErrMessageMalformed for unknown tags but got %v", err)
+	}
+	if len(compactKeys) >= 255 {
+		t.Errorf("too many keys in the dictionary: %d", len(compactKeys))
+	}
+}
+
+func benchmarkMetadata(b *testing.B, compact bool) {
+	data := newMetadataMessage(compact, typicalMetadata).Encode()
+	r := bytes.NewReader(data)
+	b.ReportAllocs()
+	b.ReportMetric(float64(len(data)), "bytes/msg")
+	for i := 0; i < b.N; i++ {
+		m := GetPooledMsg()
+		m.Metadata = typicalMetadata
+		PutData(m.EncodeSlicePointer())
+		m.Metadata = nil
+		r.Reset(data)
+		if err := m.Decode(r); err != nil {
+			b.Fatal(err)
+		}
+		m.Free()
+	}
+}
+
+func BenchmarkMetadata_Legacy(b *testing.B) {
+	benchmarkMetadata(b, false)
+}
+
+func BenchmarkMetadata_Compact(b *testing.B) {
+	benchmarkMetadata(b, true)
+}
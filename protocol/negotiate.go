@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProtocolVersion is the version of the protocol negotiated by clients and servers.
+// It is increased when extensions change the protocol incompatibly.
+const ProtocolVersion = 1
+
+const (
+	// NegotiateKey is set in metadata of the heartbeat sent by clients on connect as "version:capabilities",
+	// such as "1:7", to negotiate the protocol with the server. Capabilities are in hex.
+	NegotiateKey = "__rpcx_negotiate__"
+	// NegotiatedKey is set in metadata of the reply to the negotiation heartbeat by servers as the negotiated
+	// "version:capabilities". Servers of old versions echo the heartbeat without it.
+	NegotiatedKey = "__rpcx_negotiated__"
+)
+
+// Capabilities is the set of protocol extensions supported by a peer, or negotiated by both peers of a connection.
+type Capabilities uint32
+
+const (
+	// CapCompress means compress types newer than Gzip, Zstd and Snappy, are decompressed.
+	CapCompress Capabilities = 1 << iota
+	// CapChunk means chunk frames are reassembled, see SplitChunks.
+	CapChunk
+	// CapChecksum means checksums of payloads are verified, see Header.SetChecksum.
+	CapChecksum
+	// CapCompactMetadata means compact metadata are decoded, see Header.SetCompactMetadata.
+	CapCompactMetadata
+)
+
+var capabilityNames = []string{"compress", "chunk", "checksum", "compact_metadata"}
+
+// Has returns whether c has all capabilities of flags.
+func (c Capabilities) Has(flags Capabilities) bool {
+	return c&flags == flags
+}
+
+// Names returns the names of capabilities in c. Unknown capabilities of newer versions are named by their bits in hex.
+func (c Capabilities) Names() []string {
+	names := []string{}
+	for i := 0; i < 32; i++ {
+		if c&(1<<i) == 0 {
+			continue
+		}
+		if i < len(capabilityNames) {
+			names = append(names, capabilityNames[i])
+		} else {
+			names = append(names, fmt.Sprintf("%#x", uint32(1)<<i))
+		}
+	}
+	return names
+}
+
+func (c Capabilities) String() string {
+	return strings.Join(c.Names(), ",")
+}
+
+// MarshalJSON encodes c as the list of its names.
+func (c Capabilities) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Names())
+}
+
+// FormatNegotiation formats the value of NegotiateKey and NegotiatedKey.
+func FormatNegotiation(version int, caps Capabilities) string {
+	return strconv.Itoa(version) + ":" + strconv.FormatUint(uint64(caps), 16)
+}
+
+// ParseNegotiation parses the value of NegotiateKey and NegotiatedKey.
+func ParseNegotiation(s string) (version int, caps Capabilities, ok bool) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return 0, 0, false
+	}
+	version, err := strconv.Atoi(s[:i])
+	if err != nil || version < 0 {
+		return 0, 0, false
+	}
+	c, err := strconv.ParseUint(s[i+1:], 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return version, Capabilities(c), true
+}
+
+// Negotiate returns the version and capabilities negotiated by peers of version local and remote with localCaps and remoteCaps:
+// the lower version, and the capabilities supported by both.
+func Negotiate(local, remote int, localCaps, remoteCaps Capabilities) (int, Capabilities) {
+	if remote < local {
+		local = remote
+	}
+	return local, localCaps & remoteCaps
+}
@@ -0,0 +1,33 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNegotiation(t *testing.T) {
+	offer := FormatNegotiation(2, CapChunk|CapChecksum|1<<8)
+	if offer != "2:106" {
+		t.Errorf("expect 2:106 but got %s", offer)
+	}
+	version, caps, ok := ParseNegotiation(offer)
+	if !ok || version != 2 || caps != CapChunk|CapChecksum|1<<8 {
+		t.Fatalf("failed to parse %s: %d %v %v", offer, version, caps, ok)
+	}
+	for _, bad := range []string{"", "1", "x:1", "1:x", "-1:1", "1:100000000"} {
+		if _, _, ok := ParseNegotiation(bad); ok {
+			t.Errorf("expect %q to be rejected", bad)
+		}
+	}
+
+	// unknown capabilities of newer versions are dropped
+	version, caps = Negotiate(ProtocolVersion, version, CapCompress|CapChunk|CapChecksum, caps)
+	if version != ProtocolVersion || caps != CapChunk|CapChecksum {
+		t.Errorf("expect version %d with chunk,checksum but got %d with %v", ProtocolVersion, version, caps)
+	}
+
+	data, _ := json.Marshal(CapCompress | 1<<8)
+	if string(data) != `["compress","0x100"]` {
+		t.Errorf("unexpected JSON %s", data)
+	}
+}
@@ -1,7 +1,24 @@
 package protocol
 
-import "sync"
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
 
+	"github.com/smallnest/rpcx/util"
+)
+
+// Messages are pooled to reduce allocations. A message got by GetPooledMsg belongs to its getter,
+// who returns it to the pool by Free when it has been handled. Free also returns the buffer that messages
+// are decoded into to the pool of its size class, and the metadata map decoded by Decode is cleared and
+// reused by the next decoding.
+//
+// So a freed message, and everything it refers to, including ServicePath, ServiceMethod, Payload and
+// the decoded Metadata, must not be used after Free. Code keeping a message after its owner frees it,
+// for example plugins handling messages in other goroutines, calls Retain before the owner frees it and
+// Free when it does not use the message any more; the message is returned to the pool by the last Free.
+// Code keeping only a part of a message copies it, or takes the decoded metadata by KeepMetadata, or by setting
+// Metadata to another map, so that it is not reused.
 var msgPool = sync.Pool{
 	New: func() interface{} {
 		header := Header([12]byte{})
@@ -13,6 +30,13 @@ var msgPool = sync.Pool{
 	},
 }
 
+// minPooledData is the size of the smallest buffer of the pool. Smaller buffers are kept by messages.
+const minPooledData = 1024
+
+// dataPool pools buffers which messages are decoded into by size classes.
+// Buffers larger than the largest size class are left to the GC.
+var dataPool = util.NewLimitedPool(minPooledData, 4<<20)
+
 // GetPooledMsg gets a pooled message.
 func GetPooledMsg() *Message {
 	return msgPool.Get().(*Message)
@@ -20,10 +44,62 @@ func GetPooledMsg() *Message {
 
 // FreeMsg puts a msg into the pool.
 func FreeMsg(msg *Message) {
-	if msg != nil && cap(msg.data) < 1024 {
-		msg.Reset()
-		msgPool.Put(msg)
+	msg.Free()
+}
+
+// Retain keeps m from being returned to the pool by the next Free.
+// Each call of Retain needs another call of Free to return m to the pool.
+func (m *Message) Retain() {
+	atomic.AddInt32(&m.refs, 1)
+}
+
+// Free returns m to the pool unless it is retained. m must not be used after it is freed.
+func (m *Message) Free() {
+	if m == nil || atomic.AddInt32(&m.refs, -1) >= 0 {
+		return
+	}
+	atomic.StoreInt32(&m.refs, 0)
+	m.Reset()
+	if m.buf != nil {
+		dataPool.Put(m.buf)
+		m.buf, m.data = nil, nil
+	}
+	msgPool.Put(m)
+}
+
+// allocData sets data to a buffer of n bytes, from the pool if it is large.
+func (m *Message) allocData(n int) {
+	if m.buf != nil {
+		dataPool.Put(m.buf)
+		m.buf = nil
+	}
+	if n < minPooledData {
+		m.data = make([]byte, n)
+		return
+	}
+	m.buf = dataPool.Get(n)
+	m.data = *m.buf
+}
+
+// recycleMetadata clears the metadata decoded last time for the next decoding, if Metadata is still that map.
+func (m *Message) recycleMetadata() {
+	if m.decodedMeta != nil && sameMap(m.decodedMeta, m.Metadata) {
+		for k := range m.decodedMeta {
+			delete(m.decodedMeta, k)
+		}
+		m.spareMeta = m.decodedMeta
 	}
+	m.decodedMeta = nil
+}
+
+// KeepMetadata keeps the decoded Metadata of m from being cleared and reused by the next decoding, for code handing it
+// to others who may keep it after m is freed, so the map belongs to them. The next decoding allocates another map.
+func (m *Message) KeepMetadata() {
+	m.decodedMeta = nil
+}
+
+func sameMap(a, b map[string]string) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
 }
 
 var poolUint32Data = sync.Pool{
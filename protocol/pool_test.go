@@ -0,0 +1,165 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func encodeMessage(metadata map[string]string, payload []byte) []byte {
+	m := NewMessage()
+	m.SetMessageType(Request)
+	m.SetSerializeType(JSON)
+	m.ServicePath = "Arith"
+	m.ServiceMethod = "Mul"
+	m.Metadata = metadata
+	m.Payload = payload
+	return m.Encode()
+}
+
+func encodedMessage(size int) []byte {
+	return encodeMessage(map[string]string{"a": "1", "b": "2", "c": "3"}, bytes.Repeat([]byte{'x'}, size))
+}
+
+func decodePooled(t testing.TB, data []byte) *Message {
+	m := GetPooledMsg()
+	if err := m.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMessageRetain(t *testing.T) {
+	for _, size := range []int{16, 2000, 64 * 1024} {
+		m := decodePooled(t, encodedMessage(size))
+		m.Retain()
+		m.Free() // by the owner
+
+		other := bytes.Repeat([]byte{'y'}, size)
+		for i := 0; i < 100; i++ {
+			FreeMsg(decodePooled(t, encodeMessage(map[string]string{"a": "9"}, other)))
+		}
+		if m.ServicePath != "Arith" || !bytes.Equal(m.Payload, bytes.Repeat([]byte{'x'}, size)) {
+			t.Fatalf("retained message of %d bytes is reused", size)
+		}
+		if !reflect.DeepEqual(m.Metadata, map[string]string{"a": "1", "b": "2", "c": "3"}) {
+			t.Fatalf("metadata of the retained message is reused: %v", m.Metadata)
+		}
+		m.Free()
+	}
+}
+
+func TestMessageMetadataReuse(t *testing.T) {
+	m := decodePooled(t, encodedMessage(10))
+	defer m.Free()
+	decoded := m.Metadata
+
+	// the decoded map is cleared and reused
+	if err := m.Decode(bytes.NewReader(encodeMessage(map[string]string{"d": "4"}, nil))); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Metadata, map[string]string{"d": "4"}) {
+		t.Fatalf("unexpected metadata %v", m.Metadata)
+	}
+	if !sameMap(decoded, m.Metadata) {
+		t.Error("expect the metadata map reused")
+	}
+
+	// maps taken from messages are not reused
+	taken := m.Metadata
+	m.Metadata = nil
+	if err := m.Decode(bytes.NewReader(encodedMessage(10))); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(taken, map[string]string{"d": "4"}) {
+		t.Errorf("taken metadata is changed to %v", taken)
+	}
+	if sameMap(taken, m.Metadata) {
+		t.Error("taken metadata is reused")
+	}
+
+	// and kept maps
+	kept := m.Metadata
+	m.KeepMetadata()
+	if err := m.Decode(bytes.NewReader(encodeMessage(map[string]string{"f": "6"}, nil))); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(kept, map[string]string{"a": "1", "b": "2", "c": "3"}) {
+		t.Errorf("kept metadata is changed to %v", kept)
+	}
+	if sameMap(kept, m.Metadata) {
+		t.Error("kept metadata is reused")
+	}
+
+	// so are maps set by users
+	own := map[string]string{"e": "5"}
+	m.Metadata = own
+	if err := m.Decode(bytes.NewReader(encodeMessage(nil, nil))); err != nil {
+		t.Fatal(err)
+	}
+	m.Reset()
+	if !reflect.DeepEqual(own, map[string]string{"e": "5"}) {
+		t.Errorf("metadata set by users is changed to %v", own)
+	}
+}
+
+// TestMessagePoolConcurrent decodes, retains and frees messages in many goroutines, for the race detector.
+func TestMessagePoolConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	retained := make(chan *Message, 64)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			payload := bytes.Repeat([]byte{byte('a' + g)}, 100+g*1000)
+			data := encodeMessage(map[string]string{"g": string(rune('a' + g))}, payload)
+			for i := 0; i < 200; i++ {
+				m := GetPooledMsg()
+				if err := m.Decode(bytes.NewReader(data)); err != nil {
+					t.Error(err)
+					return
+				}
+				if i%4 == 0 {
+					m.Retain()
+					retained <- m
+				}
+				FreeMsg(m)
+			}
+		}(g)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range retained {
+			g := m.Metadata["g"][0]
+			if len(m.Payload) != 100+int(g-'a')*1000 || bytes.IndexFunc(m.Payload, func(r rune) bool { return r != rune(g) }) >= 0 {
+				t.Errorf("retained message %q is reused", g)
+			}
+			m.Free()
+		}
+	}()
+	wg.Wait()
+	close(retained)
+	<-done
+}
+
+func benchmarkReadFree(b *testing.B, size int) {
+	data := encodedMessage(size)
+	r := bytes.NewReader(data)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		m := GetPooledMsg()
+		if err := m.Decode(r); err != nil {
+			b.Fatal(err)
+		}
+		FreeMsg(m)
+	}
+}
+
+func BenchmarkMessage_ReadFreeSmall(b *testing.B) { benchmarkReadFree(b, 200) }
+func BenchmarkMessage_ReadFreeLarge(b *testing.B) { benchmarkReadFree(b, 64*1024) }
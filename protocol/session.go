@@ -0,0 +1,564 @@
+package protocol
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// SessionKeyExchangeKey is the metadata key of the heartbeat which exchanges session keys on connect.
+// Its value is "mode:key" in requests, and "key:confirmation" in responses, where keys are X25519 public keys.
+const SessionKeyExchangeKey = "__rpcx_session_key__"
+
+const (
+	// DefaultRekeyMessages is the number of messages written with a session key before it is updated.
+	DefaultRekeyMessages = 1 << 20
+	// DefaultRekeyInterval is how long a session key is used before it is updated.
+	DefaultRekeyInterval = 10 * time.Minute
+	// DefaultSessionHandshakeTimeout is the time to exchange session keys.
+	DefaultSessionHandshakeTimeout = 10 * time.Second
+)
+
+var (
+	// ErrSessionRecord is returned by reads of session connections getting records which fail authentication,
+	// such as records tampered with, replayed, reordered or reflected by attackers. Connections can't be read after it.
+	ErrSessionRecord = errors.New("rpcx: session record authentication failed")
+	// ErrSessionNotEstablished is returned by reads and writes of session connections before their handshakes.
+	ErrSessionNotEstablished = errors.New("rpcx: session keys are not established")
+)
+
+// HandshakeError is the error of session handshakes, which fails Connect of clients.
+type HandshakeError struct {
+	Err error
+}
+
+func (e *HandshakeError) Error() string {
+	return "rpcx: session handshake failed: " + e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// SessionConfig configures the encryption of connections by session keys, which are exchanged by X25519
+// on connect and used to encrypt the traffic of each direction by ChaCha20-Poly1305.
+// The keys of past sessions can't be recovered from static keys, unlike static shared keys.
+//
+// Clients authenticate servers by the static key of the server, by TLS or by both:
+// the session keys are derived from the static key and the TLS keying material of the connection too.
+type SessionConfig struct {
+	// PrivateKey is the static X25519 private key of the server, see GenerateSessionKey. Servers only.
+	PrivateKey []byte
+	// PublicKey is the static X25519 public key of the server, by which clients authenticate the server.
+	// Clients without it authenticate servers only by TLS. Clients only.
+	PublicKey []byte
+
+	// RekeyMessages is the number of messages written with a session key before it is updated.
+	// Zero means DefaultRekeyMessages.
+	RekeyMessages uint64
+	// RekeyInterval is how long a session key is used before it is updated. Zero means DefaultRekeyInterval.
+	RekeyInterval time.Duration
+	// HandshakeTimeout is the time to exchange session keys. Zero means DefaultSessionHandshakeTimeout.
+	HandshakeTimeout time.Duration
+}
+
+// GenerateSessionKey generates a static X25519 key pair of servers for SessionConfig.
+func GenerateSessionKey() (privateKey, publicKey []byte, err error) {
+	privateKey = make([]byte, curve25519.ScalarSize)
+	if _, err = rand.Read(privateKey); err != nil {
+		return nil, nil, err
+	}
+	publicKey, err = curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privateKey, publicKey, nil
+}
+
+// how servers are authenticated by session handshakes
+const (
+	sessionAuthStatic = 1 << iota
+	sessionAuthTLS
+)
+
+const (
+	// labels of the key schedule
+	sessionLabel     = "rpcx session v1"
+	sessionKeysInfo  = "rpcx session keys"
+	sessionRekeyInfo = "rpcx session rekey"
+	sessionTLSLabel  = "EXPORTER-rpcx-session"
+	maxHandshakeSize = 4096
+	maxRecordPayload = 64 << 10
+	recordHeaderLen  = 5
+	recordKeyUpdate  = 0x01
+)
+
+var sessionEncoding = base64.RawURLEncoding
+
+// SessionConn is a connection encrypted by session keys. Messages are written in records:
+//
+//	| flags (1 byte) | length (4 bytes) | ciphertext and tag (length bytes) |
+//
+// and sealed with the nonce of the counter of records of the direction, so that records tampered with,
+// replayed, reordered or reflected fail authentication. The flag 0x01 marks the first record
+// with the updated key, which is derived from the previous one.
+type SessionConn struct {
+	net.Conn
+	config   *SessionConfig
+	isClient bool
+	r        *bufio.Reader
+
+	inMu  sync.Mutex
+	in    halfConn
+	inErr error
+	rawIn []byte // the record being read
+	plain []byte // decrypted bytes not read yet
+
+	outMu  sync.Mutex
+	out    halfConn
+	outErr error
+	rawOut []byte
+}
+
+// halfConn is the state of a direction of a session.
+type halfConn struct {
+	key      []byte
+	aead     cipher.AEAD
+	seq      uint64    // counter of records sealed by aead
+	messages uint64    // messages written with the key
+	since    time.Time // when the key was derived
+}
+
+// SessionClient returns the client side of the session connection on conn, whose Handshake must be called before it is used.
+func SessionClient(conn net.Conn, config *SessionConfig) *SessionConn {
+	return &SessionConn{Conn: conn, config: config, isClient: true, r: bufio.NewReader(conn)}
+}
+
+// SessionServer returns the server side of the session connection on conn, whose Handshake must be called before it is used.
+func SessionServer(conn net.Conn, config *SessionConfig) *SessionConn {
+	return &SessionConn{Conn: conn, config: config, r: bufio.NewReader(conn)}
+}
+
+// NetConn returns the underlying connection.
+func (c *SessionConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Handshake exchanges session keys by a heartbeat with SessionKeyExchangeKey. Failures are *HandshakeError.
+func (c *SessionConn) Handshake() error {
+	timeout := c.config.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultSessionHandshakeTimeout
+	}
+	c.Conn.SetDeadline(time.Now().Add(timeout))
+	defer c.Conn.SetDeadline(time.Time{})
+
+	var err error
+	if c.isClient {
+		err = c.clientHandshake()
+	} else {
+		err = c.serverHandshake()
+	}
+	if err != nil {
+		return &HandshakeError{Err: err}
+	}
+	return nil
+}
+
+func (c *SessionConn) clientHandshake() error {
+	var mode byte
+	if len(c.config.PublicKey) != 0 {
+		if len(c.config.PublicKey) != curve25519.PointSize {
+			return fmt.Errorf("bad static public key of %d bytes", len(c.config.PublicKey))
+		}
+		mode |= sessionAuthStatic
+	}
+	if tlsConnOf(c.Conn) != nil {
+		mode |= sessionAuthTLS
+	}
+	if mode == 0 {
+		return errors.New("the server is authenticated by neither the static public key nor TLS")
+	}
+
+	private, public, err := GenerateSessionKey()
+	if err != nil {
+		return err
+	}
+	offer := strconv.Itoa(int(mode)) + ":" + sessionEncoding.EncodeToString(public)
+	req := NewMessage()
+	req.SetHeartbeat(true)
+	req.Metadata = map[string]string{SessionKeyExchangeKey: offer}
+	if _, err := c.Conn.Write(req.Encode()); err != nil {
+		return err
+	}
+
+	res := NewMessage()
+	if err := res.DecodeLimit(c.r, maxHandshakeSize); err != nil {
+		return err
+	}
+	if reason := res.Metadata[ConnRejected]; reason != "" {
+		return fmt.Errorf("connection rejected: %s", reason)
+	}
+	if res.MessageType() != Response || !res.IsHeartbeat() {
+		return errors.New("unexpected message")
+	}
+	if e := res.Metadata[ServiceError]; e != "" {
+		return fmt.Errorf("refused by the server: %s", e)
+	}
+	answer := res.Metadata[SessionKeyExchangeKey]
+	if answer == "" || answer == offer { // echoed by servers without session encryption
+		return errors.New("the server doesn't support session encryption")
+	}
+	i := strings.IndexByte(answer, ':')
+	if i < 0 {
+		return errors.New("bad key exchange")
+	}
+	peer, err1 := sessionEncoding.DecodeString(answer[:i])
+	confirmation, err2 := sessionEncoding.DecodeString(answer[i+1:])
+	if err1 != nil || err2 != nil || len(peer) != curve25519.PointSize {
+		return errors.New("bad key exchange")
+	}
+
+	secrets, err := c.sharedSecrets(mode, private, peer, c.config.PublicKey)
+	if err != nil {
+		return err
+	}
+	keys := deriveSessionKeys(mode, public, peer, c.config.PublicKey, secrets)
+	if !hmac.Equal(confirmation, keys.confirmation) {
+		return errors.New("the server isn't authenticated")
+	}
+	return c.establish(keys.serverKey, keys.clientKey)
+}
+
+func (c *SessionConn) serverHandshake() error {
+	req := NewMessage()
+	if err := req.DecodeLimit(c.r, maxHandshakeSize); err != nil {
+		return err
+	}
+	err := c.answerHandshake(req)
+	if err != nil {
+		// tell the client why, so that it fails with the reason
+		res := NewMessage()
+		res.SetMessageType(Response)
+		res.SetHeartbeat(true)
+		res.SetMessageStatusType(Error)
+		res.Metadata = map[string]string{ServiceError: err.Error()}
+		c.Conn.Write(res.Encode())
+	}
+	return err
+}
+
+func (c *SessionConn) answerHandshake(req *Message) error {
+	offer := req.Metadata[SessionKeyExchangeKey]
+	if req.MessageType() != Request || !req.IsHeartbeat() || offer == "" {
+		return errors.New("session keys are not exchanged")
+	}
+	i := strings.IndexByte(offer, ':')
+	if i < 0 {
+		return errors.New("bad key exchange")
+	}
+	m, err1 := strconv.ParseUint(offer[:i], 10, 8)
+	peer, err2 := sessionEncoding.DecodeString(offer[i+1:])
+	if err1 != nil || err2 != nil || len(peer) != curve25519.PointSize {
+		return errors.New("bad key exchange")
+	}
+	mode := byte(m)
+	if mode == 0 || mode&^(sessionAuthStatic|sessionAuthTLS) != 0 {
+		return fmt.Errorf("unsupported authentication %d", mode)
+	}
+
+	var static []byte
+	if mode&sessionAuthStatic != 0 {
+		if len(c.config.PrivateKey) != curve25519.ScalarSize {
+			return errors.New("the server has no static key")
+		}
+		var err error
+		if static, err = curve25519.X25519(c.config.PrivateKey, curve25519.Basepoint); err != nil {
+			return err
+		}
+	}
+	if mode&sessionAuthTLS != 0 && tlsConnOf(c.Conn) == nil {
+		return errors.New("not a TLS connection")
+	}
+
+	private, public, err := GenerateSessionKey()
+	if err != nil {
+		return err
+	}
+	secrets, err := c.sharedSecrets(mode, private, peer, nil)
+	if err != nil {
+		return err
+	}
+	keys := deriveSessionKeys(mode, peer, public, static, secrets)
+
+	res := req.Clone()
+	res.SetMessageType(Response)
+	res.Metadata = map[string]string{
+		SessionKeyExchangeKey: sessionEncoding.EncodeToString(public) + ":" + sessionEncoding.EncodeToString(keys.confirmation),
+	}
+	if _, err := c.Conn.Write(res.Encode()); err != nil {
+		return err
+	}
+	return c.establish(keys.clientKey, keys.serverKey)
+}
+
+// sharedSecrets returns the secrets between the ephemeral key private and the ephemeral key peer of the peer.
+// They are the X25519 of the ephemeral keys, the X25519 of the static key of the server and the ephemeral key of the client
+// and the TLS keying material if the server is authenticated by them. The static key is static of clients
+// and the private key of servers.
+func (c *SessionConn) sharedSecrets(mode byte, private, peer, static []byte) ([]byte, error) {
+	secret, err := curve25519.X25519(private, peer)
+	if err != nil { // low order points
+		return nil, err
+	}
+	if mode&sessionAuthStatic != 0 {
+		var s []byte
+		if c.isClient {
+			s, err = curve25519.X25519(private, static)
+		} else {
+			s, err = curve25519.X25519(c.config.PrivateKey, peer)
+		}
+		if err != nil {
+			return nil, err
+		}
+		secret = append(secret, s...)
+	}
+	if mode&sessionAuthTLS != 0 {
+		tlsConn := tlsConnOf(c.Conn)
+		if tlsConn == nil {
+			return nil, errors.New("not a TLS connection")
+		}
+		state := tlsConn.ConnectionState()
+		ekm, err := state.ExportKeyingMaterial(sessionTLSLabel, nil, 32)
+		if err != nil {
+			return nil, err
+		}
+		secret = append(secret, ekm...)
+	}
+	return secret, nil
+}
+
+type sessionKeys struct {
+	clientKey, serverKey []byte // keys of records written by clients and servers
+	confirmation         []byte // proves that the server has derived the keys
+}
+
+// deriveSessionKeys derives session keys by HKDF-SHA256 of the secrets, salted with the transcript of the handshake.
+func deriveSessionKeys(mode byte, clientPublic, serverPublic, staticPublic, secrets []byte) sessionKeys {
+	h := sha256.New()
+	h.Write([]byte(sessionLabel))
+	h.Write([]byte{mode})
+	h.Write(clientPublic)
+	h.Write(serverPublic)
+	h.Write(staticPublic)
+	transcript := h.Sum(nil)
+
+	kdf := hkdf.New(sha256.New, secrets, transcript, []byte(sessionKeysInfo))
+	material := make([]byte, 3*chacha20poly1305.KeySize)
+	io.ReadFull(kdf, material)
+	mac := hmac.New(sha256.New, material[2*chacha20poly1305.KeySize:])
+	mac.Write(transcript)
+	return sessionKeys{
+		clientKey:    material[:chacha20poly1305.KeySize],
+		serverKey:    material[chacha20poly1305.KeySize : 2*chacha20poly1305.KeySize],
+		confirmation: mac.Sum(nil),
+	}
+}
+
+func (c *SessionConn) establish(inKey, outKey []byte) error {
+	c.inMu.Lock()
+	err := c.in.init(inKey)
+	c.inMu.Unlock()
+	if err != nil {
+		return err
+	}
+	c.outMu.Lock()
+	err = c.out.init(outKey)
+	c.outMu.Unlock()
+	return err
+}
+
+func (hc *halfConn) init(key []byte) error {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return err
+	}
+	hc.key, hc.aead, hc.seq, hc.messages, hc.since = key, aead, 0, 0, time.Now()
+	return nil
+}
+
+// rekey derives the next key from the current one, which is erased.
+func (hc *halfConn) rekey() error {
+	key := make([]byte, chacha20poly1305.KeySize)
+	io.ReadFull(hkdf.Expand(sha256.New, hc.key, []byte(sessionRekeyInfo)), key)
+	for i := range hc.key {
+		hc.key[i] = 0
+	}
+	return hc.init(key)
+}
+
+func (hc *halfConn) nonce() []byte {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.BigEndian.PutUint64(nonce[4:], hc.seq)
+	hc.seq++
+	return nonce[:]
+}
+
+// Read reads decrypted bytes.
+func (c *SessionConn) Read(b []byte) (int, error) {
+	c.inMu.Lock()
+	defer c.inMu.Unlock()
+	if c.in.aead == nil {
+		return 0, ErrSessionNotEstablished
+	}
+	for len(c.plain) == 0 {
+		if c.inErr != nil {
+			return 0, c.inErr
+		}
+		if err := c.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+// readRecord reads and decrypts the next record. It is resumed after errors of the connection such as timeouts.
+func (c *SessionConn) readRecord() error {
+	if err := c.fill(recordHeaderLen); err != nil {
+		return err
+	}
+	flags := c.rawIn[0]
+	n := int(binary.BigEndian.Uint32(c.rawIn[1:recordHeaderLen]))
+	if flags&^recordKeyUpdate != 0 || n < c.in.aead.Overhead() || n > maxRecordPayload+c.in.aead.Overhead() {
+		c.inErr = ErrSessionRecord
+		return c.inErr
+	}
+	if err := c.fill(recordHeaderLen + n); err != nil {
+		return err
+	}
+
+	if flags&recordKeyUpdate != 0 {
+		if err := c.in.rekey(); err != nil {
+			c.inErr = err
+			return err
+		}
+	}
+	header, ciphertext := c.rawIn[:recordHeaderLen], c.rawIn[recordHeaderLen:]
+	plain, err := c.in.aead.Open(ciphertext[:0], c.in.nonce(), ciphertext, header)
+	if err != nil {
+		c.inErr = ErrSessionRecord
+		return c.inErr
+	}
+	c.plain = plain
+	c.rawIn = c.rawIn[:0]
+	return nil
+}
+
+// fill reads the record being read until it has n bytes.
+func (c *SessionConn) fill(n int) error {
+	if cap(c.rawIn) < n {
+		raw := make([]byte, len(c.rawIn), n)
+		copy(raw, c.rawIn)
+		c.rawIn = raw
+	}
+	for len(c.rawIn) < n {
+		m, err := c.r.Read(c.rawIn[len(c.rawIn):n])
+		c.rawIn = c.rawIn[:len(c.rawIn)+m]
+		if err != nil {
+			if err == io.EOF && len(c.rawIn) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Write encrypts b in records, updating the key first if it has been used for RekeyMessages messages or RekeyInterval.
+func (c *SessionConn) Write(b []byte) (int, error) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.out.aead == nil {
+		return 0, ErrSessionNotEstablished
+	}
+	if c.outErr != nil {
+		return 0, c.outErr
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	var flags byte
+	if c.rekeyDue() {
+		if err := c.out.rekey(); err != nil {
+			c.outErr = err
+			return 0, err
+		}
+		flags = recordKeyUpdate
+	}
+	c.out.messages++
+
+	n := 0
+	for len(b) > 0 {
+		size := len(b)
+		if size > maxRecordPayload {
+			size = maxRecordPayload
+		}
+		raw := append(c.rawOut[:0], flags, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(raw[1:], uint32(size+c.out.aead.Overhead()))
+		raw = c.out.aead.Seal(raw, c.out.nonce(), b[:size], raw[:recordHeaderLen])
+		c.rawOut = raw
+		if _, err := c.Conn.Write(raw); err != nil {
+			c.outErr = err // the counter of the peer is out of sync after partial records
+			return n, err
+		}
+		n += size
+		b = b[size:]
+		flags = 0
+	}
+	return n, nil
+}
+
+func (c *SessionConn) rekeyDue() bool {
+	messages, interval := c.config.RekeyMessages, c.config.RekeyInterval
+	if messages == 0 {
+		messages = DefaultRekeyMessages
+	}
+	if interval == 0 {
+		interval = DefaultRekeyInterval
+	}
+	return c.out.messages >= messages || time.Since(c.out.since) >= interval
+}
+
+// tlsConnOf returns the TLS connection under conn, or nil if conn is not a TLS connection.
+func tlsConnOf(conn net.Conn) *tls.Conn {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			return c
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil
+		}
+	}
+}
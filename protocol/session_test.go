@@ -0,0 +1,328 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// interceptedConn is the end of a connection of a malicious peer in the middle,
+// which records the raw writes of the session and holds them instead of forwarding them when hold.
+type interceptedConn struct {
+	net.Conn
+	mu     sync.Mutex
+	hold   bool
+	writes [][]byte
+}
+
+func (c *interceptedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.writes = append(c.writes, append([]byte(nil), b...))
+	hold := c.hold
+	c.mu.Unlock()
+	if hold {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *interceptedConn) setHold(hold bool) {
+	c.mu.Lock()
+	c.hold = hold
+	c.mu.Unlock()
+}
+
+// last returns the last n raw writes.
+func (c *interceptedConn) last(n int) [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writes[len(c.writes)-n:]
+}
+
+// inject writes b to the peer under the session.
+func (c *interceptedConn) inject(b []byte) {
+	go c.Conn.Write(b)
+}
+
+func newSessionPair(t *testing.T, clientConfig, serverConfig *SessionConfig) (client, server *SessionConn, cr, sr *interceptedConn) {
+	c, s := net.Pipe()
+	cr, sr = &interceptedConn{Conn: c}, &interceptedConn{Conn: s}
+	client, server = SessionClient(cr, clientConfig), SessionServer(sr, serverConfig)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Handshake()
+	}()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server, cr, sr
+}
+
+func newSessionKeys(t *testing.T) (client, server *SessionConfig) {
+	private, public, err := GenerateSessionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SessionConfig{PublicKey: public}, &SessionConfig{PrivateKey: private}
+}
+
+// transfer writes data by w and reads it by r.
+func transfer(t *testing.T, w, r *SessionConn, data []byte) {
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := w.Write(data)
+		errCh <- err
+	}()
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, got) {
+		t.Fatalf("expect %d bytes but got different ones", len(data))
+	}
+}
+
+func TestSession(t *testing.T) {
+	clientConfig, serverConfig := newSessionKeys(t)
+	clientConfig.RekeyMessages = 2
+	serverConfig.RekeyInterval = time.Nanosecond
+	client, server, cr, sr := newSessionPair(t, clientConfig, serverConfig)
+
+	// messages are encrypted
+	transfer(t, client, server, []byte("hello"))
+	if record := cr.last(1)[0]; bytes.Contains(record, []byte("hello")) || len(record) != recordHeaderLen+5+16 {
+		t.Errorf("unexpected record %x", record)
+	}
+
+	// large messages are split into records
+	large := bytes.Repeat([]byte("0123456789abcdef"), maxRecordPayload/8+1)
+	transfer(t, server, client, large)
+	if records := len(sr.writes) - 1; records != 3 {
+		t.Errorf("expect 3 records but got %d", records)
+	}
+
+	// keys are updated by the number of messages and the interval
+	for _, m := range []string{"2", "3", "4", "5"} {
+		transfer(t, client, server, []byte(m))
+	}
+	var flags []byte
+	for _, record := range cr.last(5) {
+		flags = append(flags, record[0])
+	}
+	if !bytes.Equal([]byte{0, 0, recordKeyUpdate, 0, recordKeyUpdate}, flags) {
+		t.Errorf("unexpected flags of records %v", flags)
+	}
+	transfer(t, server, client, []byte("world"))
+	if record := sr.last(1)[0]; record[0] != recordKeyUpdate {
+		t.Errorf("expect the key to be updated by the interval but got flags %d", record[0])
+	}
+}
+
+func TestSessionAttacks(t *testing.T) {
+	clientConfig, serverConfig := newSessionKeys(t)
+	clientConfig.RekeyMessages = 2
+
+	expectRejected := func(t *testing.T, conn *SessionConn) {
+		t.Helper()
+		if _, err := conn.Read(make([]byte, 16)); !errors.Is(err, ErrSessionRecord) {
+			t.Errorf("expect ErrSessionRecord but got %v", err)
+		}
+		// and the session is broken
+		if _, err := conn.Read(make([]byte, 16)); !errors.Is(err, ErrSessionRecord) {
+			t.Errorf("expect ErrSessionRecord but got %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		attack func(t *testing.T, client, server *SessionConn, cr, sr *interceptedConn) *SessionConn
+	}{
+		{"replay", func(t *testing.T, client, server *SessionConn, cr, sr *interceptedConn) *SessionConn {
+			transfer(t, client, server, []byte("transfer 100"))
+			cr.inject(cr.last(1)[0])
+			return server
+		}},
+		{"replay after rekey", func(t *testing.T, client, server *SessionConn, cr, sr *interceptedConn) *SessionConn {
+			transfer(t, client, server, []byte("transfer 100"))
+			transfer(t, client, server, []byte("transfer 200"))
+			transfer(t, client, server, []byte("rekeyed"))
+			cr.inject(cr.last(3)[0])
+			return server
+		}},
+		{"reflection to the server", func(t *testing.T, client, server *SessionConn, cr, sr *interceptedConn) *SessionConn {
+			transfer(t, server, client, []byte("ok"))
+			cr.inject(sr.last(1)[0])
+			return server
+		}},
+		{"reflection to the client", func(t *testing.T, client, server *SessionConn, cr, sr *interceptedConn) *SessionConn {
+			transfer(t, client, server, []byte("transfer 100"))
+			sr.inject(cr.last(1)[0])
+			return client
+		}},
+		{"reorder", func(t *testing.T, client, server *SessionConn, cr, sr *interceptedConn) *SessionConn {
+			cr.setHold(true)
+			client.Write([]byte("first"))
+			client.Write([]byte("second"))
+			records := cr.last(2)
+			cr.inject(append(append([]byte(nil), records[1]...), records[0]...))
+			return server
+		}},
+		{"drop", func(t *testing.T, client, server *SessionConn, cr, sr *interceptedConn) *SessionConn {
+			cr.setHold(true)
+			client.Write([]byte("first"))
+			client.Write([]byte("second"))
+			cr.inject(cr.last(1)[0])
+			return server
+		}},
+		{"tamper", func(t *testing.T, client, server *SessionConn, cr, sr *interceptedConn) *SessionConn {
+			cr.setHold(true)
+			client.Write([]byte("transfer 100"))
+			record := append([]byte(nil), cr.last(1)[0]...)
+			record[recordHeaderLen+9] ^= 0x01
+			cr.inject(record)
+			return server
+		}},
+		{"fake key update", func(t *testing.T, client, server *SessionConn, cr, sr *interceptedConn) *SessionConn {
+			cr.setHold(true)
+			client.Write([]byte("transfer 100"))
+			record := append([]byte(nil), cr.last(1)[0]...)
+			record[0] = recordKeyUpdate
+			cr.inject(record)
+			return server
+		}},
+		{"huge record", func(t *testing.T, client, server *SessionConn, cr, sr *interceptedConn) *SessionConn {
+			cr.inject([]byte{0, 0xff, 0xff, 0xff, 0xff})
+			return server
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server, cr, sr := newSessionPair(t, clientConfig, serverConfig)
+			expectRejected(t, tt.attack(t, client, server, cr, sr))
+		})
+	}
+
+	// records of other sessions are rejected too
+	client, server, cr, _ := newSessionPair(t, clientConfig, serverConfig)
+	transfer(t, client, server, []byte("transfer 100"))
+	_, other, otherCr, _ := newSessionPair(t, clientConfig, serverConfig)
+	otherCr.inject(cr.last(1)[0])
+	expectRejected(t, other)
+}
+
+func TestSessionHandshakeErrors(t *testing.T) {
+	clientConfig, serverConfig := newSessionKeys(t)
+	_, attackerPublic, _ := GenerateSessionKey()
+
+	handshake := func(clientConfig, serverConfig *SessionConfig, server func(conn net.Conn)) (clientErr, serverErr error) {
+		c, s := net.Pipe()
+		defer c.Close()
+		defer s.Close()
+		errCh := make(chan error, 1)
+		go func() {
+			if server != nil {
+				server(s)
+				errCh <- nil
+				return
+			}
+			errCh <- SessionServer(s, serverConfig).Handshake()
+		}()
+		clientErr = SessionClient(c, clientConfig).Handshake()
+		c.Close()
+		return clientErr, <-errCh
+	}
+
+	tests := []struct {
+		name         string
+		clientConfig *SessionConfig
+		serverConfig *SessionConfig
+		server       func(conn net.Conn)
+		clientErr    string
+		serverErr    string
+	}{
+		{name: "impersonated server", clientConfig: &SessionConfig{PublicKey: attackerPublic}, serverConfig: serverConfig,
+			clientErr: "the server isn't authenticated"},
+		{name: "no authentication", clientConfig: &SessionConfig{},
+			server:    func(conn net.Conn) {},
+			clientErr: "neither the static public key nor TLS"},
+		{name: "server without the static key", clientConfig: clientConfig, serverConfig: &SessionConfig{},
+			clientErr: "refused by the server: the server has no static key", serverErr: "the server has no static key"},
+		{name: "server without session encryption", clientConfig: clientConfig,
+			server: func(conn net.Conn) {
+				req, err := Read(conn)
+				if err == nil {
+					req.SetMessageType(Response)
+					conn.Write(req.Encode())
+				}
+			},
+			clientErr: "the server doesn't support session encryption"},
+		{name: "rejected connection", clientConfig: clientConfig,
+			server: func(conn net.Conn) {
+				Read(conn)
+				res := NewMessage()
+				res.SetMessageType(Response)
+				res.Metadata = map[string]string{ConnRejected: "max_connections"}
+				conn.Write(res.Encode())
+			},
+			clientErr: "connection rejected: max_connections"},
+		{name: "bad key", clientConfig: clientConfig,
+			server: func(conn net.Conn) {
+				req, err := Read(conn)
+				if err == nil {
+					req.SetMessageType(Response)
+					req.Metadata[SessionKeyExchangeKey] = sessionEncoding.EncodeToString(make([]byte, 32)) + ":"
+					conn.Write(req.Encode())
+				}
+			},
+			clientErr: "bad input point"},
+		{name: "timeout", clientConfig: &SessionConfig{PublicKey: clientConfig.PublicKey, HandshakeTimeout: 50 * time.Millisecond},
+			server: func(conn net.Conn) {
+				Read(conn)
+			},
+			clientErr: "timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientErr, serverErr := handshake(tt.clientConfig, tt.serverConfig, tt.server)
+			var he *HandshakeError
+			if !errors.As(clientErr, &he) || !strings.Contains(clientErr.Error(), tt.clientErr) {
+				t.Errorf("expect a handshake error with %q but got %v", tt.clientErr, clientErr)
+			}
+			if tt.serverErr != "" && (serverErr == nil || !strings.Contains(serverErr.Error(), tt.serverErr)) {
+				t.Errorf("expect a server error with %q but got %v", tt.serverErr, serverErr)
+			}
+		})
+	}
+
+	// clients which don't exchange keys are rejected by servers, and sessions can't be used before their handshakes
+	c, s := net.Pipe()
+	defer c.Close()
+	go func() {
+		m := NewMessage()
+		m.ServicePath, m.ServiceMethod = "Arith", "Mul"
+		c.Write(m.Encode())
+		io.Copy(io.Discard, c)
+	}()
+	server := SessionServer(s, serverConfig)
+	if _, err := server.Write([]byte("x")); err != ErrSessionNotEstablished {
+		t.Errorf("expect ErrSessionNotEstablished but got %v", err)
+	}
+	if err := server.Handshake(); err == nil || !strings.Contains(err.Error(), "session keys are not exchanged") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package redisstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// ErrClientClosed is returned by calls of closed clients.
+var ErrClientClosed = errors.New("rpcx: redis stream client is closed")
+
+// Option contains options of clients.
+type Option struct {
+	// Prefix is the prefix of keys, which must be the same as the prefix of servers.
+	Prefix string
+	// SerializeType is the serialize type of requests.
+	SerializeType protocol.SerializeType
+	// ClientID is the ID of the reply stream of the client, which is random if it is empty.
+	// Clients must not share IDs.
+	ClientID string
+	// ReplyBlock is the max time of blocking reads of the reply stream.
+	ReplyBlock time.Duration
+	// RequestMaxLen trims request streams to about RequestMaxLen entries if it is positive.
+	RequestMaxLen int64
+}
+
+// DefaultOption is the default option of clients.
+var DefaultOption = Option{
+	Prefix:        DefaultPrefix,
+	SerializeType: protocol.MsgPack,
+	ReplyBlock:    time.Second,
+}
+
+// Client calls services of servers over Redis Streams.
+type Client struct {
+	redis   Redis
+	option  Option
+	replyTo string
+
+	seq uint64
+
+	mu      sync.Mutex
+	pending map[string]chan *protocol.Message
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewClient creates a client, whose responses are read from its reply stream until it is closed.
+func NewClient(r Redis, option Option) *Client {
+	if option.ClientID == "" {
+		option.ClientID = randomID()
+	}
+	if option.ReplyBlock <= 0 {
+		option.ReplyBlock = DefaultOption.ReplyBlock
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		redis:   r,
+		option:  option,
+		replyTo: option.Prefix + "reply:" + option.ClientID,
+		pending: make(map[string]chan *protocol.Message),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go c.readReplies()
+	return c
+}
+
+// randomID returns a random hex ID.
+func randomID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// readReplies reads responses of the reply stream and delivers them to calls by their correlation IDs.
+func (c *Client) readReplies() {
+	defer close(c.done)
+	id := "0"
+	for {
+		entries, err := c.redis.XRead(c.ctx, c.replyTo, id, 100, c.option.ReplyBlock)
+		if c.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Warnf("rpcx: failed to read replies of %s: %v", c.replyTo, err)
+			select {
+			case <-time.After(c.option.ReplyBlock):
+			case <-c.ctx.Done():
+				return
+			}
+			continue
+		}
+		for _, e := range entries {
+			id = e.ID
+			res := protocol.NewMessage()
+			if err := res.Decode(bytes.NewReader(e.Data)); err != nil {
+				log.Warnf("rpcx: dropped the invalid reply %s of %s: %v", e.ID, c.replyTo, err)
+				continue
+			}
+			correlationID := res.Metadata[CorrelationIDKey]
+			c.mu.Lock()
+			ch := c.pending[correlationID]
+			delete(c.pending, correlationID)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- res
+			}
+		}
+	}
+}
+
+// Call calls servicePath.serviceMethod with args and waits for the reply until ctx is done.
+// The deadline of ctx is sent to servers, which drop requests whose deadlines are exceeded.
+// Metadata of share.ReqMetaDataKey of ctx is sent, which can have IdempotencyKey, and metadata of the response
+// is set to the map of share.ResMetaDataKey of ctx.
+func (c *Client) Call(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}) error {
+	correlationID := c.option.ClientID + "-" + strconv.FormatUint(atomic.AddUint64(&c.seq, 1), 10)
+	ch := make(chan *protocol.Message, 1)
+	c.mu.Lock()
+	c.pending[correlationID] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, correlationID)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(ctx, servicePath, serviceMethod, args, correlationID, false); err != nil {
+		return err
+	}
+
+	var res *protocol.Message
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ctx.Done():
+		return ErrClientClosed
+	}
+	if res.MessageStatusType() == protocol.Error && ctx.Err() != nil {
+		return ctx.Err() // the server timed out the call by the same deadline
+	}
+
+	if meta, ok := ctx.Value(share.ResMetaDataKey).(map[string]string); ok {
+		for k, v := range res.Metadata {
+			if k != CorrelationIDKey {
+				meta[k] = v
+			}
+		}
+	}
+	if res.MessageStatusType() == protocol.Error {
+		return serviceErrorOf(res.Metadata)
+	}
+	if reply == nil || len(res.Payload) == 0 {
+		return nil
+	}
+	codec := share.CodecOf(res.SerializeType())
+	if codec == nil {
+		return fmt.Errorf("rpcx: can not find codec for %d", res.SerializeType())
+	}
+	return share.DecodePayload(codec, res, res.Payload, reply)
+}
+
+// Notify sends a oneway request of servicePath.serviceMethod with args, which is not replied.
+func (c *Client) Notify(ctx context.Context, servicePath, serviceMethod string, args interface{}) error {
+	return c.send(ctx, servicePath, serviceMethod, args, "", true)
+}
+
+func (c *Client) send(ctx context.Context, servicePath, serviceMethod string, args interface{}, correlationID string, oneway bool) error {
+	if c.ctx.Err() != nil {
+		return ErrClientClosed
+	}
+	codec := share.CodecOf(c.option.SerializeType)
+	if codec == nil {
+		return fmt.Errorf("rpcx: can not find codec for %d", c.option.SerializeType)
+	}
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(c.option.SerializeType)
+	req.SetOneway(oneway)
+	req.SetSeq(atomic.AddUint64(&c.seq, 1))
+	req.ServicePath = servicePath
+	req.ServiceMethod = serviceMethod
+	req.Metadata = make(map[string]string)
+	if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		for k, v := range meta {
+			req.Metadata[k] = v
+		}
+	}
+	if !oneway {
+		req.Metadata[ReplyToKey] = c.replyTo
+		req.Metadata[CorrelationIDKey] = correlationID
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		// rounded up, so servers don't time out calls before clients
+		ms := (deadline.UnixNano() + int64(time.Millisecond) - 1) / int64(time.Millisecond)
+		req.Metadata[DeadlineKey] = strconv.FormatInt(ms, 10)
+	}
+
+	data, err := share.EncodePayload(codec, req, args)
+	if err != nil {
+		return err
+	}
+	req.Payload = data
+
+	_, err = c.redis.XAdd(ctx, requestStream(c.option.Prefix, servicePath), c.option.RequestMaxLen, req.Encode())
+	return err
+}
+
+// serviceErrorOf returns the error of metadata of a failed response,
+// a client.ServiceError unless the server has set its code or details like the client does.
+func serviceErrorOf(meta map[string]string) error {
+	code, coded := rerrors.Unknown, false
+	if n, err := strconv.Atoi(meta[protocol.ServiceErrorCode]); err == nil {
+		code, coded = rerrors.Code(n), true
+	}
+	var details map[string]string
+	for k, v := range meta {
+		if strings.HasPrefix(k, protocol.ServiceErrorDetailPrefix) {
+			if details == nil {
+				details = make(map[string]string)
+			}
+			details[strings.TrimPrefix(k, protocol.ServiceErrorDetailPrefix)] = v
+		}
+	}
+	if !coded && details == nil {
+		return client.ServiceError(meta[protocol.ServiceError])
+	}
+	return client.NewServiceError(code, meta[protocol.ServiceError], details)
+}
+
+// Close stops reading replies and deletes the reply stream. Calls waiting for replies return ErrClientClosed.
+func (c *Client) Close() error {
+	c.cancel()
+	<-c.done
+	return c.redis.Del(context.Background(), c.replyTo)
+}
@@ -0,0 +1,163 @@
+package redisstream
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Entry is an entry of a stream, whose data is an encoded rpcx message.
+type Entry struct {
+	Stream string
+	ID     string
+	Data   []byte
+}
+
+// Redis is the redis commands used by the transport. NewGoRedis adapts clients of go-redis,
+// and other implementations can be used by tests or other redis clients.
+//
+// Blocking reads return no entries and no error if there are no entries before block.
+type Redis interface {
+	// XAdd adds data to stream, which is trimmed to about maxLen entries if maxLen > 0.
+	XAdd(ctx context.Context, stream string, maxLen int64, data []byte) (string, error)
+	// XRead reads at most count entries of stream after id, blocking at most block.
+	XRead(ctx context.Context, stream, id string, count int64, block time.Duration) ([]Entry, error)
+	// XGroupCreate creates the consumer group of stream, which is created if it doesn't exist. Existing groups are kept.
+	XGroupCreate(ctx context.Context, stream, group string) error
+	// XReadGroup reads at most count new entries of streams for consumer of group, blocking at most block.
+	XReadGroup(ctx context.Context, group, consumer string, streams []string, count int64, block time.Duration) ([]Entry, error)
+	// XAck acknowledges entries of stream of group.
+	XAck(ctx context.Context, stream, group string, ids ...string) error
+	// XClaim claims at most count pending entries of stream of group for consumer, which have been idle for minIdle.
+	XClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]Entry, error)
+	// Expire sets the TTL of key.
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+	// Del deletes keys.
+	Del(ctx context.Context, keys ...string) error
+	// SetNX sets key to value with ttl if key doesn't exist, and reports whether it is set.
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// Set sets key to value with ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Get gets the value of key, and reports whether key exists.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+}
+
+// dataField is the field of encoded messages in entries.
+const dataField = "m"
+
+type goRedis struct {
+	c redis.UniversalClient
+}
+
+// NewGoRedis adapts a client of go-redis, such as *redis.Client or *redis.ClusterClient.
+// Streams of a service and the reply streams of clients must be in the same node of clusters,
+// so use hash tags in Prefix, such as "{rpcx}:".
+func NewGoRedis(c redis.UniversalClient) Redis {
+	return &goRedis{c: c}
+}
+
+func (r *goRedis) XAdd(ctx context.Context, stream string, maxLen int64, data []byte) (string, error) {
+	return r.c.XAdd(ctx, &redis.XAddArgs{
+		Stream:       stream,
+		MaxLenApprox: maxLen,
+		Values:       map[string]interface{}{dataField: data},
+	}).Result()
+}
+
+func (r *goRedis) XRead(ctx context.Context, stream, id string, count int64, block time.Duration) ([]Entry, error) {
+	streams, err := r.c.XRead(ctx, &redis.XReadArgs{Streams: []string{stream, id}, Count: count, Block: block}).Result()
+	return entriesOf(streams, err)
+}
+
+func (r *goRedis) XGroupCreate(ctx context.Context, stream, group string) error {
+	err := r.c.XGroupCreateMkStream(ctx, stream, group, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+func (r *goRedis) XReadGroup(ctx context.Context, group, consumer string, streams []string, count int64, block time.Duration) ([]Entry, error) {
+	args := append([]string(nil), streams...)
+	for range streams {
+		args = append(args, ">")
+	}
+	result, err := r.c.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  args,
+		Count:    count,
+		Block:    block,
+	}).Result()
+	return entriesOf(result, err)
+}
+
+func (r *goRedis) XAck(ctx context.Context, stream, group string, ids ...string) error {
+	return r.c.XAck(ctx, stream, group, ids...).Err()
+}
+
+func (r *goRedis) XClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]Entry, error) {
+	pending, err := r.c.XPendingExt(ctx, &redis.XPendingExtArgs{Stream: stream, Group: group, Start: "-", End: "+", Count: count}).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, p := range pending {
+		if p.Idle >= minIdle {
+			ids = append(ids, p.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	msgs, err := r.c.XClaim(ctx, &redis.XClaimArgs{Stream: stream, Group: group, Consumer: consumer, MinIdle: minIdle, Messages: ids}).Result()
+	return entriesOf([]redis.XStream{{Stream: stream, Messages: msgs}}, err)
+}
+
+func (r *goRedis) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return r.c.Expire(ctx, key, ttl).Err()
+}
+
+func (r *goRedis) Del(ctx context.Context, keys ...string) error {
+	return r.c.Del(ctx, keys...).Err()
+}
+
+func (r *goRedis) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return r.c.SetNX(ctx, key, value, ttl).Result()
+}
+
+func (r *goRedis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.c.Set(ctx, key, value, ttl).Err()
+}
+
+func (r *goRedis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	data, err := r.c.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	return data, err == nil, err
+}
+
+// entriesOf returns the entries of streams. Blocking reads without entries return redis.Nil, which is not an error.
+func entriesOf(streams []redis.XStream, err error) ([]Entry, error) {
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []Entry
+	for _, s := range streams {
+		for _, m := range s.Messages {
+			data, _ := m.Values[dataField].(string)
+			entries = append(entries, Entry{Stream: s.Stream, ID: m.ID, Data: []byte(data)})
+		}
+	}
+	return entries, nil
+}
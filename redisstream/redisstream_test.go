@@ -0,0 +1,457 @@
+package redisstream
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRedis is an in-memory Redis of one node with the semantics of consumer groups used by the transport.
+type fakeRedis struct {
+	mu      sync.Mutex
+	seq     int64
+	streams map[string]*fakeStream
+	values  map[string]fakeValue
+}
+
+type fakeStream struct {
+	entries []Entry
+	groups  map[string]*fakeGroup
+	expire  time.Time
+}
+
+type fakeGroup struct {
+	last    string // ID of the last delivered entry
+	pending map[string]*fakePending
+}
+
+type fakePending struct {
+	consumer  string
+	delivered time.Time
+}
+
+type fakeValue struct {
+	data   []byte
+	expire time.Time
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{streams: make(map[string]*fakeStream), values: make(map[string]fakeValue)}
+}
+
+func idLess(a, b string) bool {
+	x, _ := strconv.ParseInt(a, 10, 64)
+	y, _ := strconv.ParseInt(b, 10, 64)
+	return x < y
+}
+
+func (r *fakeRedis) stream(name string) *fakeStream {
+	s := r.streams[name]
+	if s != nil && !s.expire.IsZero() && time.Now().After(s.expire) {
+		s = nil
+	}
+	if s == nil {
+		s = &fakeStream{groups: make(map[string]*fakeGroup)}
+		r.streams[name] = s
+	}
+	return s
+}
+
+// poll calls f until it returns entries or block is exceeded.
+func (r *fakeRedis) poll(ctx context.Context, block time.Duration, f func() []Entry) ([]Entry, error) {
+	deadline := time.Now().Add(block)
+	for {
+		r.mu.Lock()
+		entries := f()
+		r.mu.Unlock()
+		if len(entries) > 0 || time.Now().After(deadline) {
+			return entries, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func (r *fakeRedis) XAdd(ctx context.Context, stream string, maxLen int64, data []byte) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	id := strconv.FormatInt(r.seq, 10)
+	s := r.stream(stream)
+	s.entries = append(s.entries, Entry{Stream: stream, ID: id, Data: data})
+	if maxLen > 0 && int64(len(s.entries)) > maxLen {
+		s.entries = s.entries[int64(len(s.entries))-maxLen:]
+	}
+	return id, nil
+}
+
+func (r *fakeRedis) XRead(ctx context.Context, stream, id string, count int64, block time.Duration) ([]Entry, error) {
+	return r.poll(ctx, block, func() []Entry {
+		var entries []Entry
+		for _, e := range r.stream(stream).entries {
+			if idLess(id, e.ID) && int64(len(entries)) < count {
+				entries = append(entries, e)
+			}
+		}
+		return entries
+	})
+}
+
+func (r *fakeRedis) XGroupCreate(ctx context.Context, stream, group string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stream(stream)
+	if s.groups[group] == nil {
+		s.groups[group] = &fakeGroup{last: "0", pending: make(map[string]*fakePending)}
+	}
+	return nil
+}
+
+func (r *fakeRedis) XReadGroup(ctx context.Context, group, consumer string, streams []string, count int64, block time.Duration) ([]Entry, error) {
+	return r.poll(ctx, block, func() []Entry {
+		var entries []Entry
+		for _, name := range streams {
+			s := r.stream(name)
+			g := s.groups[group]
+			if g == nil {
+				continue
+			}
+			for _, e := range s.entries {
+				if idLess(g.last, e.ID) && int64(len(entries)) < count {
+					g.last = e.ID
+					g.pending[e.ID] = &fakePending{consumer: consumer, delivered: time.Now()}
+					entries = append(entries, e)
+				}
+			}
+		}
+		return entries
+	})
+}
+
+func (r *fakeRedis) XAck(ctx context.Context, stream, group string, ids ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if g := r.stream(stream).groups[group]; g != nil {
+		for _, id := range ids {
+			delete(g.pending, id)
+		}
+	}
+	return nil
+}
+
+func (r *fakeRedis) XClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]Entry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stream(stream)
+	g := s.groups[group]
+	if g == nil {
+		return nil, nil
+	}
+	var entries []Entry
+	for _, e := range s.entries {
+		p := g.pending[e.ID]
+		if p != nil && time.Since(p.delivered) >= minIdle && int64(len(entries)) < count {
+			p.consumer = consumer
+			p.delivered = time.Now()
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (r *fakeRedis) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s := r.streams[key]; s != nil {
+		s.expire = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (r *fakeRedis) Del(ctx context.Context, keys ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		delete(r.streams, key)
+		delete(r.values, key)
+	}
+	return nil
+}
+
+func (r *fakeRedis) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v, ok := r.values[key]; ok && time.Now().Before(v.expire) {
+		return false, nil
+	}
+	r.values[key] = fakeValue{data: value, expire: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (r *fakeRedis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[key] = fakeValue{data: value, expire: time.Now().Add(ttl)}
+	return nil
+}
+
+func (r *fakeRedis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, ok := r.values[key]
+	if !ok || time.Now().After(v.expire) {
+		return nil, false, nil
+	}
+	return v.data, true, nil
+}
+
+// pending returns the number of pending entries of stream of group.
+func (r *fakeRedis) pending(stream, group string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if g := r.stream(stream).groups[group]; g != nil {
+		return len(g.pending)
+	}
+	return 0
+}
+
+type Args struct {
+	A int
+	B int
+}
+
+type Reply struct {
+	C int
+}
+
+type Arith struct {
+	calls int32
+}
+
+func (t *Arith) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	atomic.AddInt32(&t.calls, 1)
+	if meta, ok := ctx.Value(share.ResMetaDataKey).(map[string]string); ok {
+		meta["reply-to"] = ctx.Value(server.RemoteConnContextKey).(string)
+	}
+	reply.C = args.A * args.B
+	return nil
+}
+
+func (t *Arith) Fail(ctx context.Context, args *Args, reply *Reply) error {
+	return server.Errorf(rerrors.InvalidArgument, "bad args %d", args.A).WithDetail("field", "A")
+}
+
+func (t *Arith) Wait(ctx context.Context, args *Args, reply *Reply) error {
+	atomic.AddInt32(&t.calls, 1)
+	select {
+	case <-time.After(time.Duration(args.A) * time.Millisecond):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func startServer(t *testing.T, r Redis, opts ...OptionFn) (*Server, *Arith) {
+	s := server.NewServer()
+	arith := new(Arith)
+	if err := s.RegisterName("Arith", arith, ""); err != nil {
+		t.Fatal(err)
+	}
+	rs := NewServer(s, r, opts...)
+	rs.block = 50 * time.Millisecond
+	served := make(chan error, 1)
+	go func() { served <- rs.Serve("Arith") }()
+	t.Cleanup(func() {
+		rs.Close()
+		assert.Equal(t, server.ErrServerClosed, <-served)
+	})
+	return rs, arith
+}
+
+func newTestClient(t *testing.T, r Redis) *Client {
+	option := DefaultOption
+	option.ReplyBlock = 50 * time.Millisecond
+	c := NewClient(r, option)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCall(t *testing.T) {
+	r := newFakeRedis()
+	startServer(t, r)
+	c := newTestClient(t, r)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reply := &Reply{}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if assert.NoError(t, c.Call(ctx, "Arith", "Mul", &Args{A: i, B: 3}, reply)) {
+				assert.Equal(t, i*3, reply.C)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	meta := make(map[string]string)
+	ctx := context.WithValue(context.Background(), share.ResMetaDataKey, meta)
+	assert.NoError(t, c.Call(ctx, "Arith", "Mul", &Args{A: 2, B: 3}, &Reply{}))
+	assert.Equal(t, c.replyTo, meta["reply-to"])
+	assert.NotContains(t, meta, CorrelationIDKey)
+
+	// requests are acknowledged after they are replied
+	assert.Equal(t, 0, r.pending(requestStream(DefaultPrefix, "Arith"), DefaultGroup))
+}
+
+func TestCallError(t *testing.T) {
+	r := newFakeRedis()
+	startServer(t, r)
+	c := newTestClient(t, r)
+
+	err := c.Call(context.Background(), "Arith", "Fail", &Args{A: 7}, &Reply{})
+	var se *client.CodedServiceError
+	if assert.True(t, errors.As(err, &se), "%v", err) {
+		assert.Equal(t, "bad args 7", se.Message)
+		assert.Equal(t, int(rerrors.InvalidArgument), se.Code())
+		assert.Equal(t, "A", se.Details()["field"])
+	}
+
+	err = c.Call(context.Background(), "Arith", "Missing", &Args{}, &Reply{})
+	assert.Error(t, err)
+}
+
+func TestCallTimeout(t *testing.T) {
+	r := newFakeRedis()
+	_, arith := startServer(t, r)
+	c := newTestClient(t, r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := c.Call(ctx, "Arith", "Wait", &Args{A: 1000}, &Reply{})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// requests whose deadlines are exceeded are dropped without calling services
+	atomic.StoreInt32(&arith.calls, 0)
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	assert.NoError(t, c.send(ctx, "Arith", "Wait", &Args{A: 1}, "expired", false))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&arith.calls))
+}
+
+func TestClaimPending(t *testing.T) {
+	r := newFakeRedis()
+	stream := requestStream(DefaultPrefix, "Arith")
+	c := newTestClient(t, r)
+
+	// a consumer reads the request and fails before it replies
+	assert.NoError(t, r.XGroupCreate(context.Background(), stream, DefaultGroup))
+	done := make(chan error, 1)
+	reply := &Reply{}
+	go func() { done <- c.Call(context.Background(), "Arith", "Mul", &Args{A: 4, B: 5}, reply) }()
+	for {
+		entries, _ := r.XReadGroup(context.Background(), DefaultGroup, "failed", []string{stream}, 1, 10*time.Millisecond)
+		if len(entries) > 0 {
+			break
+		}
+	}
+	assert.Equal(t, 1, r.pending(stream, DefaultGroup))
+
+	startServer(t, r, WithClaimIdle(100*time.Millisecond))
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+		assert.Equal(t, 20, reply.C)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pending request is not claimed")
+	}
+	assert.Equal(t, 0, r.pending(stream, DefaultGroup))
+}
+
+func TestIdempotency(t *testing.T) {
+	r := newFakeRedis()
+	_, arith := startServer(t, r, WithIdempotency(time.Minute))
+	c := newTestClient(t, r)
+
+	meta := map[string]string{IdempotencyKey: "order-1"}
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, meta)
+	for i := 0; i < 3; i++ {
+		reply := &Reply{}
+		assert.NoError(t, c.Call(ctx, "Arith", "Mul", &Args{A: 3, B: 3}, reply))
+		assert.Equal(t, 9, reply.C)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&arith.calls))
+
+	meta[IdempotencyKey] = "order-2"
+	assert.NoError(t, c.Call(ctx, "Arith", "Mul", &Args{A: 3, B: 3}, &Reply{}))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&arith.calls))
+	assert.Equal(t, 0, r.pending(requestStream(DefaultPrefix, "Arith"), DefaultGroup))
+}
+
+func TestNotify(t *testing.T) {
+	r := newFakeRedis()
+	_, arith := startServer(t, r)
+	c := newTestClient(t, r)
+
+	assert.NoError(t, c.Notify(context.Background(), "Arith", "Mul", &Args{A: 1, B: 2}))
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&arith.calls) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&arith.calls))
+	time.Sleep(100 * time.Millisecond)
+	r.mu.Lock()
+	replies := len(r.stream(c.replyTo).entries)
+	r.mu.Unlock()
+	assert.Equal(t, 0, replies)
+
+	c.Close()
+	assert.Equal(t, ErrClientClosed, c.Call(context.Background(), "Arith", "Mul", &Args{}, &Reply{}))
+}
+
+// TestGoRedis tests the transport with the redis server of RPCX_REDIS_ADDR.
+func TestGoRedis(t *testing.T) {
+	addr := os.Getenv("RPCX_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("RPCX_REDIS_ADDR is not set")
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	defer rdb.Close()
+	r := NewGoRedis(rdb)
+	prefix := "rpcx-test-" + randomID() + ":"
+	defer rdb.Del(context.Background(), requestStream(prefix, "Arith"))
+
+	_, arith := startServer(t, r, WithPrefix(prefix), WithIdempotency(time.Minute))
+	option := DefaultOption
+	option.Prefix = prefix
+	c := NewClient(r, option)
+	defer c.Close()
+
+	reply := &Reply{}
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{IdempotencyKey: "k"})
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, c.Call(ctx, "Arith", "Mul", &Args{A: 6, B: 7}, reply))
+		assert.Equal(t, 42, reply.C)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&arith.calls))
+	err := c.Call(context.Background(), "Arith", "Fail", &Args{A: 1}, reply)
+	assert.True(t, errors.Is(err, rerrors.ErrInvalidArgument), "%v", err)
+}
@@ -0,0 +1,372 @@
+// Package redisstream is a transport of rpcx over Redis Streams, for producers and consumers
+// which are connected only through Redis.
+//
+// Clients add encoded requests to the request stream of each service path, whose metadata has the
+// reply stream of the client and the correlation ID of the call. Servers consume request streams by
+// a consumer group, handle requests by the services of a server.Server and add responses to reply
+// streams, which are read by clients with blocking reads:
+//
+//	rs := redisstream.NewServer(s, redisstream.NewGoRedis(rdb), redisstream.WithIdempotency(time.Hour))
+//	go rs.Serve("Arith")
+//
+//	c := redisstream.NewClient(redisstream.NewGoRedis(rdb), redisstream.DefaultOption)
+//	err := c.Call(ctx, "Arith", "Mul", args, reply)
+//
+// Requests are acknowledged after their responses are added, and pending requests of consumers which have
+// been idle for a while are claimed by other consumers, so requests are delivered at least once.
+// WithIdempotency handles each request once by its idempotency key.
+package redisstream
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+const (
+	// ReplyToKey is the metadata key of the reply stream of a request. Requests without it are oneway.
+	ReplyToKey = "__rpcx_reply_to"
+	// CorrelationIDKey is the metadata key of the ID of a call, which is in the request and the response.
+	CorrelationIDKey = "__rpcx_correlation_id"
+	// DeadlineKey is the metadata key of the deadline of a call in unix milliseconds.
+	// Requests whose deadlines are exceeded before they are handled are dropped.
+	DeadlineKey = "__rpcx_deadline"
+	// IdempotencyKey is the metadata key of the idempotency key of a request, see WithIdempotency.
+	IdempotencyKey = "__rpcx_idempotency_key"
+
+	// DefaultPrefix is the default prefix of keys.
+	DefaultPrefix = "rpcx:"
+	// DefaultGroup is the default consumer group of servers.
+	DefaultGroup = "rpcx"
+)
+
+// requestStream returns the request stream of servicePath.
+func requestStream(prefix, servicePath string) string {
+	return prefix + "req:" + servicePath
+}
+
+// OptionFn configures options of servers.
+type OptionFn func(*Server)
+
+// WithPrefix sets the prefix of keys, which is DefaultPrefix by default. Clients must use the same prefix.
+func WithPrefix(prefix string) OptionFn {
+	return func(s *Server) {
+		s.prefix = prefix
+	}
+}
+
+// WithGroup sets the consumer group, which is DefaultGroup by default. Servers of a group share requests.
+func WithGroup(group string) OptionFn {
+	return func(s *Server) {
+		s.group = group
+	}
+}
+
+// WithConsumer sets the name of the consumer in the group, which must be unique in the group.
+// It is random by default, so the pending requests of a restarted server are claimed after WithClaimIdle.
+func WithConsumer(consumer string) OptionFn {
+	return func(s *Server) {
+		s.consumer = consumer
+	}
+}
+
+// WithConcurrency sets the max number of requests handled at the same time, which is 100 by default.
+func WithConcurrency(n int) OptionFn {
+	return func(s *Server) {
+		s.concurrency = n
+	}
+}
+
+// WithClaimIdle claims pending requests of other consumers which have been idle for d, so requests of
+// failed servers are handled again. It is 30 seconds by default, and claiming is disabled if d <= 0.
+func WithClaimIdle(d time.Duration) OptionFn {
+	return func(s *Server) {
+		s.claimIdle = d
+	}
+}
+
+// WithReplyTTL sets the TTL of reply streams, which is refreshed by each response, so reply streams of
+// clients which are gone are deleted. It is one minute by default.
+func WithReplyTTL(ttl time.Duration) OptionFn {
+	return func(s *Server) {
+		s.replyTTL = ttl
+	}
+}
+
+// WithIdempotency handles each request once in ttl by its idempotency key, which is IdempotencyKey of
+// the metadata, or the correlation ID of the call. Responses are kept for ttl, and requests delivered again,
+// such as requests claimed from failed servers, get the kept responses without calling services.
+func WithIdempotency(ttl time.Duration) OptionFn {
+	return func(s *Server) {
+		s.idempotencyTTL = ttl
+	}
+}
+
+// Server serves requests of request streams by the services of a server.Server.
+type Server struct {
+	server *server.Server
+	redis  Redis
+
+	prefix         string
+	group          string
+	consumer       string
+	concurrency    int
+	claimIdle      time.Duration
+	replyTTL       time.Duration
+	idempotencyTTL time.Duration
+	count          int64
+	block          time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewServer creates a server of requests of redis, which are handled by the services of s.
+func NewServer(s *server.Server, r Redis, opts ...OptionFn) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	rs := &Server{
+		server:      s,
+		redis:       r,
+		prefix:      DefaultPrefix,
+		group:       DefaultGroup,
+		consumer:    "consumer-" + randomID(),
+		concurrency: 100,
+		claimIdle:   30 * time.Second,
+		replyTTL:    time.Minute,
+		count:       10,
+		block:       time.Second,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	for _, opt := range opts {
+		opt(rs)
+	}
+	if rs.concurrency <= 0 {
+		rs.concurrency = 1
+	}
+	return rs
+}
+
+// Serve serves requests of servicePaths until Close is called.
+func (s *Server) Serve(servicePaths ...string) error {
+	streams := make([]string, 0, len(servicePaths))
+	for _, sp := range servicePaths {
+		stream := requestStream(s.prefix, sp)
+		if err := s.redis.XGroupCreate(s.ctx, stream, s.group); err != nil {
+			return err
+		}
+		streams = append(streams, stream)
+	}
+
+	sem := make(chan struct{}, s.concurrency)
+	handle := func(entries []Entry) {
+		for _, e := range entries {
+			select {
+			case sem <- struct{}{}:
+			case <-s.ctx.Done():
+				return
+			}
+			s.wg.Add(1)
+			go func(e Entry) {
+				defer func() {
+					<-sem
+					s.wg.Done()
+				}()
+				s.handleEntry(e)
+			}(e)
+		}
+	}
+
+	if s.claimIdle > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.claim(streams, handle)
+		}()
+	}
+
+	for {
+		entries, err := s.redis.XReadGroup(s.ctx, s.group, s.consumer, streams, s.count, s.block)
+		if s.ctx.Err() != nil {
+			return server.ErrServerClosed
+		}
+		if err != nil {
+			log.Warnf("rpcx: failed to read requests of redis: %v", err)
+			s.sleep(s.block)
+			continue
+		}
+		handle(entries)
+	}
+}
+
+// claim claims pending requests which have been idle for claimIdle periodically.
+func (s *Server) claim(streams []string, handle func([]Entry)) {
+	ticker := time.NewTicker(s.claimIdle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return
+		}
+		for _, stream := range streams {
+			entries, err := s.redis.XClaim(s.ctx, stream, s.group, s.consumer, s.claimIdle, s.count*10)
+			if err != nil {
+				if s.ctx.Err() == nil {
+					log.Warnf("rpcx: failed to claim pending requests of %s: %v", stream, err)
+				}
+				continue
+			}
+			handle(entries)
+		}
+	}
+}
+
+func (s *Server) sleep(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-s.ctx.Done():
+	}
+}
+
+// handleEntry handles the request of e, and acknowledges it unless it is being handled by another consumer.
+func (s *Server) handleEntry(e Entry) {
+	req := protocol.GetPooledMsg()
+	defer protocol.FreeMsg(req)
+	if err := req.Decode(bytes.NewReader(e.Data)); err != nil || req.MessageType() != protocol.Request {
+		log.Warnf("rpcx: dropped the invalid request %s of %s: %v", e.ID, e.Stream, err)
+		s.ack(e)
+		return
+	}
+
+	ctx := context.Background()
+	if ms, err := strconv.ParseInt(req.Metadata[DeadlineKey], 10, 64); err == nil {
+		deadline := time.Unix(0, ms*int64(time.Millisecond))
+		if time.Now().After(deadline) {
+			log.Warnf("rpcx: dropped the request %s of %s whose deadline is exceeded", e.ID, e.Stream)
+			s.ack(e)
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	replyTo := req.Metadata[ReplyToKey]
+	correlationID := req.Metadata[CorrelationIDKey]
+	var idempotencyKey string
+	if s.idempotencyTTL > 0 {
+		key := req.Metadata[IdempotencyKey]
+		if key == "" {
+			key = correlationID
+		}
+		if key != "" {
+			idempotencyKey = s.prefix + "idem:" + req.ServicePath + ":" + key
+			if handled := s.replayResponse(ctx, e, idempotencyKey, replyTo, correlationID); handled {
+				return
+			}
+		}
+	}
+
+	ctx = share.WithValue(ctx, server.RemoteConnContextKey, replyTo) // notice: It is a string, different with TCP (net.Conn)
+	res, err := s.server.HandleRequest(ctx, req)
+	if err != nil {
+		res = server.NewErrorResponse(req, err)
+	}
+	defer protocol.FreeMsg(res)
+
+	var data []byte
+	if replyTo != "" && !req.IsOneway() {
+		if res.Metadata == nil {
+			res.Metadata = make(map[string]string)
+		}
+		res.Metadata[CorrelationIDKey] = correlationID
+		data = res.Encode()
+	}
+	if idempotencyKey != "" {
+		if err := s.redis.Set(s.ctx, idempotencyKey, append([]byte{1}, data...), s.idempotencyTTL); err != nil {
+			log.Warnf("rpcx: failed to keep the response of %s: %v", idempotencyKey, err)
+		}
+	}
+	if data != nil && !s.reply(replyTo, data) {
+		return // the request is handled again after it is claimed
+	}
+	s.ack(e)
+}
+
+// replayResponse handles requests which have been handled by their idempotency keys, and reports whether e is handled.
+// Values of keys are empty while requests are being handled, or else the responses prefixed by 1.
+// Empty values expire after claimIdle, so requests of failed servers are handled after they are claimed.
+// Kept responses are replied with the correlation ID of e, since requests of a key can be sent by different calls.
+func (s *Server) replayResponse(ctx context.Context, e Entry, key, replyTo, correlationID string) bool {
+	ttl := s.idempotencyTTL
+	if s.claimIdle > 0 && s.claimIdle < ttl {
+		ttl = s.claimIdle
+	}
+	ok, err := s.redis.SetNX(ctx, key, nil, ttl)
+	if err != nil {
+		log.Warnf("rpcx: failed to check the idempotency key %s: %v", key, err)
+		return false
+	}
+	if ok {
+		return false
+	}
+
+	data, exists, err := s.redis.Get(ctx, key)
+	if err != nil || !exists || len(data) == 0 {
+		// being handled by another consumer, so it is claimed again if the consumer fails
+		return true
+	}
+	if len(data) > 1 && replyTo != "" {
+		res := protocol.GetPooledMsg()
+		defer protocol.FreeMsg(res)
+		if err := res.Decode(bytes.NewReader(data[1:])); err != nil {
+			log.Warnf("rpcx: failed to decode the kept response of %s: %v", key, err)
+			return false
+		}
+		if res.Metadata == nil {
+			res.Metadata = make(map[string]string)
+		}
+		res.Metadata[CorrelationIDKey] = correlationID
+		if !s.reply(replyTo, res.Encode()) {
+			return true
+		}
+	}
+	s.ack(e)
+	return true
+}
+
+// reply adds the encoded response to the reply stream, whose TTL is refreshed.
+func (s *Server) reply(replyTo string, data []byte) bool {
+	if _, err := s.redis.XAdd(s.ctx, replyTo, 1000, data); err != nil {
+		log.Warnf("rpcx: failed to reply to %s: %v", replyTo, err)
+		return false
+	}
+	if s.replyTTL > 0 {
+		if err := s.redis.Expire(s.ctx, replyTo, s.replyTTL); err != nil {
+			log.Warnf("rpcx: failed to set the TTL of %s: %v", replyTo, err)
+		}
+	}
+	return true
+}
+
+func (s *Server) ack(e Entry) {
+	if err := s.redis.XAck(s.ctx, e.Stream, s.group, e.ID); err != nil {
+		log.Warnf("rpcx: failed to acknowledge the request %s of %s: %v", e.ID, e.Stream, err)
+	}
+}
+
+// Close stops serving and waits for the requests being handled.
+func (s *Server) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
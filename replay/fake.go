@@ -0,0 +1,65 @@
+package replay
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/smallnest/rpcx/server"
+)
+
+// ErrNoRecord is the error of requests of fake servers which are not recorded.
+var ErrNoRecord = errors.New("replay: no recorded response")
+
+// fakeResponses are the recorded responses of a request, returned one by one, and the last one repeats.
+type fakeResponses struct {
+	records []*Record
+	next    int
+}
+
+// NewFakeServer creates a server answering requests with the responses of records, such as for tests of clients
+// without real servers. Requests are answered by the records of the same service, method and payload,
+// and identical requests are answered by their records in order of times of calls, with the last one repeated.
+// Records which are truncated or have no responses are not answered, and other requests fail with ErrNoRecord.
+func NewFakeServer(records []*Record, options ...server.OptionFn) *server.Server {
+	sorted := make([]*Record, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	var mu sync.Mutex
+	responses := make(map[string]*fakeResponses)
+	handler := func(ctx *server.Context) error {
+		mu.Lock()
+		var record *Record
+		if rs := responses[fakeKey(ctx.ServicePath(), ctx.ServiceMethod(), ctx.Payload())]; rs != nil {
+			record = rs.records[rs.next]
+			if rs.next < len(rs.records)-1 {
+				rs.next++
+			}
+		}
+		mu.Unlock()
+
+		if record == nil {
+			return ctx.WriteError(ErrNoRecord)
+		}
+		return ctx.WriteRaw(record.Response, record.ResMetadata)
+	}
+
+	s := server.NewServer(options...)
+	for _, r := range sorted {
+		if r.Truncated || !r.Responded {
+			continue
+		}
+		k := fakeKey(r.ServicePath, r.ServiceMethod, r.Request)
+		if responses[k] == nil {
+			responses[k] = &fakeResponses{}
+		}
+		responses[k].records = append(responses[k].records, r)
+		s.AddHandler(r.ServicePath, r.ServiceMethod, handler)
+	}
+	return s
+}
+
+func fakeKey(servicePath, serviceMethod string, payload []byte) string {
+	return servicePath + "." + serviceMethod + "\x00" + string(payload)
+}
@@ -0,0 +1,160 @@
+// Package replay records calls of clients and replays them, such as to capture traffic in staging
+// and replay it in CI against new builds of servers, or to answer clients in tests by fake servers.
+//
+// Calls are recorded by Recorder, a client plugin, in files of JSON lines. The first line of a file is the header
+// with the version of the format, and every other line is a Record. Files may be appended to by later recorders.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// Version is the version of the format of records.
+const Version = 1
+
+var (
+	// ErrUnsupportedVersion is the error of files of newer versions of the format.
+	ErrUnsupportedVersion = errors.New("replay: unsupported version")
+	// ErrInvalidFormat is the error of files which are not records.
+	ErrInvalidFormat = errors.New("replay: invalid format")
+)
+
+// headerPrefix is the prefix of header lines.
+var headerPrefix = []byte(`{"rpcx_replay_version":`)
+
+type header struct {
+	Version int `json:"rpcx_replay_version"`
+}
+
+// Record is a recorded call. Payloads are serialized but not compressed.
+type Record struct {
+	// Seq is the sequence of the record in the recorder, by which records are written.
+	Seq uint64 `json:"seq"`
+	// Time is when the call is sent.
+	Time          time.Time              `json:"time"`
+	ServicePath   string                 `json:"service_path"`
+	ServiceMethod string                 `json:"service_method"`
+	SerializeType protocol.SerializeType `json:"serialize_type"`
+	Oneway        bool                   `json:"oneway,omitempty"`
+	Metadata      map[string]string      `json:"metadata,omitempty"`
+	Request       []byte                 `json:"request,omitempty"`
+
+	// Responded reports whether a response is received, and calls without responses have errors of clients such as timeouts.
+	Responded   bool              `json:"responded"`
+	ResMetadata map[string]string `json:"res_metadata,omitempty"`
+	Response    []byte            `json:"response,omitempty"`
+	Latency     time.Duration     `json:"latency"`
+	Error       string            `json:"error,omitempty"`
+
+	// Truncated reports whether the request or the response is omitted since it is larger than Recorder.MaxPayloadSize.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Writer writes records.
+type Writer struct {
+	w      *bufio.Writer
+	header bool
+}
+
+// NewWriter creates a Writer writing records to w, which is buffered, so it must be flushed.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Write writes r, and the header before the first record.
+func (w *Writer) Write(r *Record) error {
+	if !w.header {
+		if err := writeLine(w.w, header{Version: Version}); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	return writeLine(w.w, r)
+}
+
+func writeLine(w *bufio.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
+
+// Flush writes buffered records.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Reader reads records.
+type Reader struct {
+	r      *bufio.Reader
+	header bool
+	line   int
+}
+
+// NewReader creates a Reader reading records from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next record, or io.EOF if there is none.
+func (r *Reader) Read() (*Record, error) {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err == nil {
+				continue
+			}
+			return nil, err
+		}
+		r.line++
+
+		if bytes.HasPrefix(line, headerPrefix) {
+			var h header
+			if jerr := json.Unmarshal(line, &h); jerr != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidFormat, r.line, jerr)
+			}
+			if h.Version > Version {
+				return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, h.Version)
+			}
+			r.header = true
+			continue
+		}
+		if !r.header {
+			return nil, fmt.Errorf("%w: no header", ErrInvalidFormat)
+		}
+
+		record := &Record{}
+		if jerr := json.Unmarshal(line, record); jerr != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidFormat, r.line, jerr)
+		}
+		return record, nil
+	}
+}
+
+// ReadAll reads all records of r.
+func ReadAll(r io.Reader) ([]*Record, error) {
+	reader := NewReader(r)
+	var records []*Record
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
@@ -0,0 +1,106 @@
+package replay
+
+import (
+	"io"
+	"sync"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/share"
+)
+
+// Redacted replaces values of redacted metadata in records.
+const Redacted = "<redacted>"
+
+// DefaultMaxPayloadSize is the default Recorder.MaxPayloadSize.
+const DefaultMaxPayloadSize = 1 << 20
+
+// DefaultRedactedKeys are the keys of metadata redacted by default, which are tokens and signatures of requests.
+var DefaultRedactedKeys = []string{share.AuthKey, share.SignatureKey, share.SignatureNonceKey}
+
+// Recorder is a client plugin which records calls of clients, except heartbeats, to a writer such as an append-only file.
+// Calls are recorded when they complete, so the writer should be fast, since it is written by goroutines reading responses.
+// Records are numbered in the order they are written, and they are written one by one, so recorders are safe for concurrent calls.
+// Failures of writing fail no calls, and they are returned by Err.
+type Recorder struct {
+	// Redact are the keys of metadata whose values are replaced by Redacted, DefaultRedactedKeys if it is nil.
+	Redact []string
+	// MaxPayloadSize is the max size of payloads of recorded requests and responses, DefaultMaxPayloadSize if it is zero,
+	// or unlimited if it is negative. Payloads which are larger are omitted, and their records are Truncated.
+	MaxPayloadSize int
+
+	mu  sync.Mutex
+	w   *Writer
+	seq uint64
+	err error
+}
+
+// NewRecorder creates a Recorder writing records to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: NewWriter(w)}
+}
+
+// RecordCall writes the record of a completed call.
+func (r *Recorder) RecordCall(call *client.CallRecord) {
+	record := &Record{
+		Time:          call.Start,
+		ServicePath:   call.ServicePath,
+		ServiceMethod: call.ServiceMethod,
+		SerializeType: call.SerializeType,
+		Oneway:        call.Oneway,
+		Metadata:      r.redact(call.Metadata),
+		Request:       call.Payload,
+		Responded:     call.Responded,
+		ResMetadata:   r.redact(call.ResMetadata),
+		Response:      call.Reply,
+		Latency:       call.Latency,
+	}
+	if call.Error != nil {
+		record.Error = call.Error.Error()
+	}
+	max := r.MaxPayloadSize
+	if max == 0 {
+		max = DefaultMaxPayloadSize
+	}
+	if max > 0 && (len(record.Request) > max || len(record.Response) > max) {
+		record.Request, record.Response = nil, nil
+		record.Truncated = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	record.Seq = r.seq
+	if err := r.w.Write(record); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *Recorder) redact(meta map[string]string) map[string]string {
+	keys := r.Redact
+	if keys == nil {
+		keys = DefaultRedactedKeys
+	}
+	for _, k := range keys {
+		if _, ok := meta[k]; ok {
+			meta[k] = Redacted
+		}
+	}
+	return meta
+}
+
+// Flush writes buffered records, and returns the first error of writing records if any.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.w.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// Err returns the first error of writing records.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
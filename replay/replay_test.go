@@ -0,0 +1,236 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+type Args struct {
+	A    int
+	B    int
+	Data []byte
+}
+
+type Reply struct {
+	C    int
+	Time int64
+}
+
+// Arith V2 sets the time of replies
+type Arith struct {
+	v2 bool
+}
+
+func (a *Arith) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	reply.C = args.A * args.B
+	if a.v2 {
+		reply.Time = time.Now().UnixNano()
+	}
+	return nil
+}
+
+func (a *Arith) Fail(ctx context.Context, args *Args, reply *Reply) error {
+	return errors.New("failed")
+}
+
+func startServer(t *testing.T, s *server.Server) string {
+	go s.Serve("tcp", "127.0.0.1:0")
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s.Address().String()
+}
+
+func startArith(t *testing.T, arith *Arith) string {
+	s := server.NewServer()
+	s.RegisterName("Arith", arith, "")
+	return startServer(t, s)
+}
+
+func connect(t *testing.T, addr string, plugins ...client.Plugin) *client.Client {
+	c := client.NewClient(client.DefaultOption)
+	if len(plugins) > 0 {
+		pc := client.NewPluginContainer()
+		for _, p := range plugins {
+			pc.Add(p)
+		}
+		c.Plugins = pc
+	}
+	if err := c.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// record records calls of Arith, and returns the file of records
+func record(t *testing.T) []byte {
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	recorder.MaxPayloadSize = 100
+	c := connect(t, startArith(t, &Arith{}), recorder)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply := &Reply{}
+			if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: i, B: 2}, reply); err != nil || reply.C != i*2 {
+				t.Errorf("unexpected reply %v: %v", reply, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.AuthKey: "secret", "tenant": "acme"})
+	if err := c.Call(ctx, "Arith", "Fail", &Args{}, &Reply{}); err == nil || err.Error() != "failed" {
+		t.Fatalf("expect failed but got %v", err)
+	}
+	if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 1, B: 1, Data: make([]byte, 200)}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRecorder(t *testing.T) {
+	data := record(t)
+	if !bytes.HasPrefix(data, []byte(`{"rpcx_replay_version":1}`+"\n")) {
+		t.Fatalf("expect the header but got %q", data[:40])
+	}
+
+	// files may be appended to
+	records, err := ReadAll(bytes.NewReader(append(data, data...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 24 {
+		t.Fatalf("expect 24 records but got %d", len(records))
+	}
+	records = records[:12]
+
+	seqs := make(map[uint64]bool)
+	for _, r := range records {
+		seqs[r.Seq] = true
+		if r.ServicePath != "Arith" || r.SerializeType != protocol.MsgPack || !r.Responded || r.Latency <= 0 || r.Time.IsZero() {
+			t.Fatalf("unexpected record %+v", r)
+		}
+		switch {
+		case r.ServiceMethod == "Fail":
+			if r.Error != "failed" || r.Metadata[share.AuthKey] != Redacted || r.Metadata["tenant"] != "acme" {
+				t.Fatalf("unexpected record of Fail %+v", r)
+			}
+		case r.Truncated:
+			if r.Request != nil || r.Response != nil {
+				t.Fatalf("expect payloads of the truncated record to be omitted but got %+v", r)
+			}
+		case len(r.Request) == 0 || len(r.Response) == 0:
+			t.Fatalf("unexpected record without payloads %+v", r)
+		}
+	}
+	if len(seqs) != 12 || !seqs[1] || !seqs[12] {
+		t.Fatalf("unexpected sequences %v", seqs)
+	}
+
+	if _, err = ReadAll(strings.NewReader(`{"rpcx_replay_version":2}` + "\n")); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expect ErrUnsupportedVersion but got %v", err)
+	}
+	if _, err = ReadAll(strings.NewReader(`{"seq":1}` + "\n")); !errors.Is(err, ErrInvalidFormat) {
+		t.Fatalf("expect ErrInvalidFormat but got %v", err)
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	records, err := ReadAll(bytes.NewReader(record(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := connect(t, startArith(t, &Arith{v2: true}))
+	replayer := &Replayer{Target: c, Concurrency: 4}
+	results := replayer.Replay(context.Background(), records)
+	var matched, mismatched, skipped int
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+			skipped++
+		case r.Matched():
+			matched++
+		default:
+			mismatched++
+			if len(r.Diffs) != 1 || !strings.HasPrefix(r.Diffs[0], "Time: ") {
+				t.Fatalf("unexpected diffs %v", r.Diffs)
+			}
+		}
+	}
+	if skipped != 1 || matched != 1 || mismatched != 10 {
+		t.Fatalf("expect the time of all replies to differ, but got %d matched, %d mismatched and %d skipped", matched, mismatched, skipped)
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Record.Time.Before(results[i-1].Record.Time) {
+			t.Fatal("expect results in order of times of calls")
+		}
+	}
+
+	replayer.Ignore = []string{"Time"}
+	for _, r := range replayer.Replay(context.Background(), records) {
+		if !r.Matched() {
+			t.Fatalf("expect %+v to match ignoring times", r)
+		}
+	}
+}
+
+func TestFakeServer(t *testing.T) {
+	records, err := ReadAll(bytes.NewReader(record(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// identical requests are answered in order
+	second := *records[0]
+	second.Time = second.Time.Add(time.Hour)
+	second.Response, err = share.Codecs[protocol.MsgPack].Encode(&Reply{C: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records = append(records, &second)
+	args := &Args{}
+	if err = share.Codecs[protocol.MsgPack].Decode(records[0].Request, args); err != nil {
+		t.Fatal(err)
+	}
+
+	c := connect(t, startServer(t, NewFakeServer(records)))
+
+	var replies []int
+	for i := 0; i < 3; i++ {
+		reply := &Reply{}
+		if err := c.Call(context.Background(), "Arith", "Mul", args, reply); err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, reply.C)
+	}
+	if replies[0] != args.A*args.B || replies[1] != 42 || replies[2] != 42 {
+		t.Fatalf("unexpected replies %v of %+v", replies, args)
+	}
+
+	if err = c.Call(context.Background(), "Arith", "Fail", &Args{}, &Reply{}); err == nil || err.Error() != "failed" {
+		t.Fatalf("expect the recorded error but got %v", err)
+	}
+	if err = c.Call(context.Background(), "Arith", "Mul", &Args{A: 100, B: 100}, &Reply{}); err == nil || err.Error() != ErrNoRecord.Error() {
+		t.Fatalf("expect ErrNoRecord but got %v", err)
+	}
+}
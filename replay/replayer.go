@@ -0,0 +1,234 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// Target is the server which records are replayed against, such as a client.XClient or a *client.Client.
+// Its sequences of raw messages should not be used by others.
+type Target interface {
+	SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error)
+}
+
+// Replayer replays records against a server and compares responses of the server with recorded ones.
+//
+// Replies are compared by fields if they are decoded by codecs of their serialize types into generic values,
+// such as JSON and MessagePack, so fields can be ignored, and they are compared by bytes otherwise.
+// Errors are compared by messages, and metadata of responses is not compared.
+type Replayer struct {
+	// Target is the server to replay records against.
+	Target Target
+	// Ignore are the paths of fields ignored in replies, such as "UpdatedAt" or "Items.ID", whose names
+	// are separated by dots. Fields of elements of lists are ignored by the paths of lists.
+	Ignore []string
+	// Concurrency is the number of records replayed concurrently. Records are replayed one by one
+	// in order of their times if it is zero or 1.
+	Concurrency int
+	// Timeout is the timeout of each replayed request if it is not zero.
+	Timeout time.Duration
+
+	seq uint64
+}
+
+// Result is the result of a replayed record.
+type Result struct {
+	Record *Record
+	// Skipped reports whether the record is not replayed, since it is Truncated, or there is no recorded response to compare.
+	Skipped bool
+
+	// Response and Error are the payload and the error message of the response of the server.
+	Response []byte
+	Error    string
+	Latency  time.Duration
+	// Diffs are the differences between the response and the recorded one.
+	Diffs []string
+}
+
+// Matched reports whether the response matches the recorded one.
+func (r *Result) Matched() bool {
+	return len(r.Diffs) == 0
+}
+
+// Replay replays records and returns their results in order of records sorted by times of calls.
+func (rp *Replayer) Replay(ctx context.Context, records []*Record) []*Result {
+	sorted := make([]*Record, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	results := make([]*Result, len(sorted))
+	concurrency := rp.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var next int64 = -1
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(sorted) {
+					return
+				}
+				results[i] = rp.replay(ctx, sorted[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (rp *Replayer) replay(ctx context.Context, record *Record) *Result {
+	result := &Result{Record: record}
+	if record.Truncated || (!record.Responded && !record.Oneway) {
+		result.Skipped = true
+		return result
+	}
+
+	if rp.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rp.Timeout)
+		defer cancel()
+	}
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSeq(atomic.AddUint64(&rp.seq, 1))
+	req.SetSerializeType(record.SerializeType)
+	req.SetOneway(record.Oneway)
+	req.ServicePath = record.ServicePath
+	req.ServiceMethod = record.ServiceMethod
+	req.Metadata = make(map[string]string, len(record.Metadata))
+	for k, v := range record.Metadata {
+		// redacted values are set by the target, and deadlines are of the replay
+		if v != Redacted && k != share.ServerTimeout {
+			req.Metadata[k] = v
+		}
+	}
+	req.Payload = record.Request
+
+	start := time.Now()
+	_, payload, err := rp.Target.SendRaw(ctx, req)
+	result.Latency = time.Since(start)
+	result.Response = payload
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if record.Oneway {
+		if err != nil {
+			result.Diffs = []string{fmt.Sprintf("error: %q", result.Error)}
+		}
+		return result
+	}
+
+	if result.Error != record.Error {
+		result.Diffs = append(result.Diffs, fmt.Sprintf("error: %q != %q", result.Error, record.Error))
+	}
+	if record.Error == "" && err == nil {
+		result.Diffs = append(result.Diffs, rp.compare(record.SerializeType, payload, record.Response)...)
+	}
+	return result
+}
+
+// compare returns the differences of got from want, the payloads of replies of serialize type st.
+func (rp *Replayer) compare(st protocol.SerializeType, got, want []byte) []string {
+	if codec := share.CodecOf(st); codec != nil && len(got) > 0 && len(want) > 0 {
+		var g, w interface{}
+		if codec.Decode(got, &g) == nil && codec.Decode(want, &w) == nil {
+			for _, path := range rp.Ignore {
+				names := strings.Split(path, ".")
+				g, w = ignoreField(g, names), ignoreField(w, names)
+			}
+			var diffs []string
+			diffValues("", g, w, &diffs)
+			return diffs
+		}
+	}
+
+	if string(got) != string(want) {
+		return []string{fmt.Sprintf("reply: %d bytes differ from %d bytes", len(got), len(want))}
+	}
+	return nil
+}
+
+// ignoreField deletes the field of names in v, a generic value decoded by codecs.
+func ignoreField(v interface{}, names []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(names) == 1 {
+			delete(v, names[0])
+		} else if f, ok := v[names[0]]; ok {
+			v[names[0]] = ignoreField(f, names[1:])
+		}
+	case map[interface{}]interface{}:
+		if len(names) == 1 {
+			delete(v, names[0])
+		} else if f, ok := v[names[0]]; ok {
+			v[names[0]] = ignoreField(f, names[1:])
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = ignoreField(v[i], names)
+		}
+	}
+	return v
+}
+
+// diffValues appends the differences of got from want at path to diffs.
+func diffValues(path string, got, want interface{}, diffs *[]string) {
+	field := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+
+	switch w := want.(type) {
+	case map[string]interface{}:
+		if g, ok := got.(map[string]interface{}); ok {
+			for _, k := range unionKeys(g, w) {
+				diffValues(field(k), g[k], w[k], diffs)
+			}
+			return
+		}
+	case []interface{}:
+		if g, ok := got.([]interface{}); ok && len(g) == len(w) {
+			for i := range w {
+				diffValues(fmt.Sprintf("%s[%d]", path, i), g[i], w[i], diffs)
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		if path == "" {
+			path = "reply"
+		}
+		*diffs = append(*diffs, fmt.Sprintf("%s: %v != %v", path, got, want))
+	}
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,133 @@
+// Package rpcxtest provides an in-process harness of servers and clients connected over net.Pipe for tests of services,
+// without ports or the global state of the memu network.
+//
+//	h := rpcxtest.New(t)
+//	h.Register("Arith", new(Arith))
+//	err := h.Client().Call(ctx, "Arith", "Mul", args, reply)
+//
+// Servers and clients of harnesses are closed when tests finish.
+package rpcxtest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+)
+
+// DefaultTimeout is the default Harness.Timeout.
+const DefaultTimeout = 5 * time.Second
+
+// ClientOption changes options of clients of harnesses.
+type ClientOption func(option *client.Option)
+
+// Harness is a server with clients connected to it over net.Pipe. Connections support deadlines, and every client has its own.
+type Harness struct {
+	// Server is the server of the harness, to which plugins can be added.
+	Server *server.Server
+	// Timeout is the timeout of contexts of Context, DefaultTimeout if it is zero.
+	Timeout time.Duration
+
+	t  testing.TB
+	ln *pipeListener
+
+	mu      sync.Mutex
+	closers []func() error
+}
+
+// New creates a harness whose server is created with options and started. It is closed by t.Cleanup.
+func New(t testing.TB, options ...server.OptionFn) *Harness {
+	t.Helper()
+	h := &Harness{Server: server.NewServer(options...), t: t, ln: newPipeListener()}
+	go h.Server.ServeListener(Network, h.ln)
+	for h.Server.Address() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	t.Cleanup(h.close)
+	return h
+}
+
+// Address returns the address of the server in Network, such as the address of client.NewPeer2PeerDiscovery by Network+"@"+address.
+func (h *Harness) Address() string {
+	return string(h.ln.addr)
+}
+
+// Register registers rcvr as the service name, and fails the test if it can't be registered.
+func (h *Harness) Register(name string, rcvr interface{}) {
+	h.t.Helper()
+	if err := h.Server.RegisterName(name, rcvr, ""); err != nil {
+		h.t.Fatalf("rpcxtest: failed to register %s: %v", name, err)
+	}
+}
+
+// Option returns the options of clients of the harness, which are client.DefaultOption with short timeouts, changed by opts.
+func (h *Harness) Option(opts ...ClientOption) client.Option {
+	option := client.DefaultOption
+	option.ConnectTimeout = 100 * time.Millisecond
+	option.NegotiateTimeout = 100 * time.Millisecond
+	for _, opt := range opts {
+		opt(&option)
+	}
+	return option
+}
+
+// Client returns a new client connected to the server, and fails the test if it can't connect.
+func (h *Harness) Client(opts ...ClientOption) *client.Client {
+	h.t.Helper()
+	c := client.NewClient(h.Option(opts...))
+	if err := c.Connect(Network, h.Address()); err != nil {
+		h.t.Fatalf("rpcxtest: failed to connect: %v", err)
+	}
+	h.onClose(c.Close)
+	return c
+}
+
+// XClient returns a new XClient of servicePath of the server. Its clients connect the server when they are used.
+func (h *Harness) XClient(servicePath string, failMode client.FailMode, selectMode client.SelectMode, opts ...ClientOption) client.XClient {
+	h.t.Helper()
+	d, err := client.NewPeer2PeerDiscovery(Network+"@"+h.Address(), "")
+	if err != nil {
+		h.t.Fatalf("rpcxtest: failed to create the discovery: %v", err)
+	}
+	xclient := client.NewXClient(servicePath, failMode, selectMode, d, h.Option(opts...))
+	h.onClose(xclient.Close)
+	return xclient
+}
+
+// Context returns a context with Timeout, which is canceled when the test finishes.
+func (h *Harness) Context() context.Context {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	h.t.Cleanup(cancel)
+	return ctx
+}
+
+// BreakConnections closes all connections between clients and the server, as if networks fail.
+// Pending calls fail, and XClients reconnect the server for later calls.
+func (h *Harness) BreakConnections() {
+	h.ln.breakConns()
+}
+
+func (h *Harness) onClose(fn func() error) {
+	h.mu.Lock()
+	h.closers = append(h.closers, fn)
+	h.mu.Unlock()
+}
+
+func (h *Harness) close() {
+	h.mu.Lock()
+	closers := h.closers
+	h.closers = nil
+	h.mu.Unlock()
+	for _, fn := range closers {
+		fn()
+	}
+	h.Server.Close()
+	h.ln.Close()
+}
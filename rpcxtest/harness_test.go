@@ -0,0 +1,162 @@
+package rpcxtest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+)
+
+type Args struct {
+	A int
+	B int
+}
+
+type Reply struct {
+	C int
+}
+
+var errOverflow = errors.New("overflow")
+
+type Arith struct {
+	delay time.Duration
+}
+
+func (a *Arith) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	if args.A > 1000 {
+		return errOverflow
+	}
+	reply.C = args.A * args.B
+	return nil
+}
+
+func (a *Arith) Slow(ctx context.Context, args *Args, reply *Reply) error {
+	select {
+	case <-time.After(a.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	reply.C = args.A * args.B
+	return nil
+}
+
+type countingPlugin struct {
+	requests int32
+}
+
+func (p *countingPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	atomic.AddInt32(&p.requests, 1)
+	return nil
+}
+
+func TestHarness(t *testing.T) {
+	h := New(t)
+	h.Register("Arith", &Arith{})
+	plugin := &countingPlugin{}
+	h.Server.Plugins.Add(plugin)
+
+	reply := &Reply{}
+	if err := h.Client().Call(h.Context(), "Arith", "Mul", &Args{A: 2, B: 3}, reply); err != nil || reply.C != 6 {
+		t.Fatalf("unexpected reply %d: %v", reply.C, err)
+	}
+
+	xclient := h.XClient("Arith", client.Failtry, client.RandomSelect)
+	reply = &Reply{}
+	if err := xclient.Call(h.Context(), "Mul", &Args{A: 4, B: 5}, reply); err != nil || reply.C != 20 {
+		t.Fatalf("unexpected reply %d: %v", reply.C, err)
+	}
+	if n := atomic.LoadInt32(&plugin.requests); n < 2 {
+		t.Fatalf("expect plugins to read 2 requests but got %d", n)
+	}
+
+	// options of clients
+	c := h.Client(func(option *client.Option) { option.SerializeType = protocol.JSON })
+	if err := c.Call(h.Context(), "Arith", "Mul", &Args{A: 2, B: 2}, reply); err != nil || reply.C != 4 {
+		t.Fatalf("unexpected reply %d: %v", reply.C, err)
+	}
+}
+
+func TestHarnessServerErrors(t *testing.T) {
+	h := New(t)
+	h.Register("Arith", &Arith{})
+
+	err := h.Client().Call(h.Context(), "Arith", "Mul", &Args{A: 1001, B: 1}, &Reply{})
+	var se client.ServiceError
+	if !errors.As(err, &se) || err.Error() != errOverflow.Error() {
+		t.Fatalf("expect the error of the service but got %#v", err)
+	}
+	err = h.Client().Call(h.Context(), "Arith", "Div", &Args{}, &Reply{})
+	if !errors.As(err, &se) {
+		t.Fatalf("expect the error of the missing method but got %#v", err)
+	}
+}
+
+func TestHarnessSlowHandlers(t *testing.T) {
+	h := New(t)
+	h.Register("Arith", &Arith{delay: 200 * time.Millisecond})
+
+	// deadlines
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := h.Client().Call(ctx, "Arith", "Slow", &Args{}, &Reply{})
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, rerrors.ErrDeadlineExceeded) {
+		t.Fatalf("expect the deadline to be exceeded but got %v", err)
+	}
+
+	// concurrent connections
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		c := h.Client()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply := &Reply{}
+			if err := c.Call(h.Context(), "Arith", "Slow", &Args{A: 2, B: 3}, reply); err != nil || reply.C != 6 {
+				t.Errorf("unexpected reply %d: %v", reply.C, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expect slow calls of connections to be handled concurrently, but they took %v", elapsed)
+	}
+}
+
+func TestHarnessBreakConnections(t *testing.T) {
+	h := New(t)
+	h.Register("Arith", &Arith{delay: time.Second})
+
+	c := h.Client()
+	call := c.Go(h.Context(), "Arith", "Slow", &Args{}, &Reply{}, nil)
+	time.Sleep(50 * time.Millisecond)
+	h.BreakConnections()
+	select {
+	case <-call.Done:
+		if !errors.Is(call.Error, client.ErrConnectionBroken) && !errors.Is(call.Error, client.ErrShutdown) {
+			t.Fatalf("expect the pending call to fail but got %v", call.Error)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the pending call is not done")
+	}
+	if err := c.Call(h.Context(), "Arith", "Mul", &Args{}, &Reply{}); !errors.Is(err, client.ErrShutdown) {
+		t.Fatalf("expect ErrShutdown but got %v", err)
+	}
+
+	// xclients reconnect
+	xclient := h.XClient("Arith", client.Failtry, client.RandomSelect)
+	if err := xclient.Call(h.Context(), "Mul", &Args{A: 1, B: 1}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	h.BreakConnections()
+	reply := &Reply{}
+	if err := xclient.Call(h.Context(), "Mul", &Args{A: 2, B: 3}, reply); err != nil || reply.C != 6 {
+		t.Fatalf("unexpected reply %d after reconnecting: %v", reply.C, err)
+	}
+}
@@ -0,0 +1,143 @@
+package rpcxtest
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/smallnest/rpcx/client"
+)
+
+// Network is the network of harnesses, by which clients dial servers over net.Pipe.
+const Network = "pipe"
+
+var errListenerClosed = errors.New("rpcxtest: listener is closed")
+
+var (
+	listenersMu sync.Mutex
+	listeners   = make(map[string]*pipeListener)
+	listenerSeq uint64
+)
+
+func init() {
+	client.ConnFactories[Network] = func(c *client.Client, network, address string) (net.Conn, error) {
+		listenersMu.Lock()
+		ln := listeners[address]
+		listenersMu.Unlock()
+		if ln == nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Addr: pipeAddr(address), Err: errors.New("no harness")}
+		}
+		return ln.dial()
+	}
+}
+
+// pipeAddr is the address of pipes.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return Network }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn is an end of a pipe with its addresses, so connections of servers have distinct remote addresses.
+type pipeConn struct {
+	net.Conn
+	local, remote net.Addr
+	ln            *pipeListener
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *pipeConn) Close() error {
+	c.ln.remove(c)
+	return c.Conn.Close()
+}
+
+// pipeListener is a listener of connections dialed over net.Pipe.
+type pipeListener struct {
+	addr  pipeAddr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	seq   uint64
+
+	mu   sync.Mutex
+	live map[*pipeConn]struct{}
+}
+
+func newPipeListener() *pipeListener {
+	ln := &pipeListener{
+		addr:  pipeAddr("rpcxtest-" + strconv.FormatUint(atomic.AddUint64(&listenerSeq, 1), 10)),
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+		live:  make(map[*pipeConn]struct{}),
+	}
+	listenersMu.Lock()
+	listeners[string(ln.addr)] = ln
+	listenersMu.Unlock()
+	return ln
+}
+
+func (ln *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.conns:
+		return conn, nil
+	case <-ln.done:
+		return nil, errListenerClosed
+	}
+}
+
+func (ln *pipeListener) Close() error {
+	ln.once.Do(func() {
+		close(ln.done)
+		listenersMu.Lock()
+		delete(listeners, string(ln.addr))
+		listenersMu.Unlock()
+	})
+	return nil
+}
+
+func (ln *pipeListener) Addr() net.Addr {
+	return ln.addr
+}
+
+// dial connects the listener by a pipe, whose ends are the connection of the client and the server.
+func (ln *pipeListener) dial() (net.Conn, error) {
+	c, s := net.Pipe()
+	caddr := pipeAddr("client-" + strconv.FormatUint(atomic.AddUint64(&ln.seq, 1), 10))
+	cc := &pipeConn{Conn: c, local: caddr, remote: ln.addr, ln: ln}
+	sc := &pipeConn{Conn: s, local: ln.addr, remote: caddr, ln: ln}
+	ln.mu.Lock()
+	ln.live[cc], ln.live[sc] = struct{}{}, struct{}{}
+	ln.mu.Unlock()
+
+	select {
+	case ln.conns <- sc:
+		return cc, nil
+	case <-ln.done:
+		cc.Close()
+		sc.Close()
+		return nil, &net.OpError{Op: "dial", Net: Network, Addr: ln.addr, Err: errListenerClosed}
+	}
+}
+
+func (ln *pipeListener) remove(c *pipeConn) {
+	ln.mu.Lock()
+	delete(ln.live, c)
+	ln.mu.Unlock()
+}
+
+// breakConns closes all connections of the listener.
+func (ln *pipeListener) breakConns() {
+	ln.mu.Lock()
+	conns := make([]*pipeConn, 0, len(ln.live))
+	for c := range ln.live {
+		conns = append(conns, c)
+	}
+	ln.mu.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+}
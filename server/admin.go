@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// AdminHandler returns the http.Handler of the admin API which inspects and adjusts the server at runtime.
+// It is not served by default. Mount it on an internal address, for example:
+//
+//	http.Handle("/rpcx/", http.StripPrefix("/rpcx", s.AdminHandler()))
+//
+// Built-in endpoints:
+//
+//	GET /limits   returns connection limits and the number of connections
+//	PUT /limits   changes connection limits, fields that are absent are not changed
+//	GET /workers  returns the size and the queue of the worker pool
+//	PUT /workers  changes the size of the worker pool
+//	GET /settings returns RuntimeSettings
+//	PATCH /settings changes RuntimeSettings by ApplySettings, fields that are absent are not changed
+//	GET /stats    returns Stats
+//	GET /conns    returns Connections, with the protocol negotiated by their clients
+//	GET /requests returns InflightRequests
+//	POST /requests/cancel cancels the request of {"conn": 1, "seq": 2} by CancelRequest
+func (s *Server) AdminHandler() http.Handler {
+	return s.admin()
+}
+
+// HandleAdmin registers the handler of pattern in the admin API.
+func (s *Server) HandleAdmin(pattern string, handler http.Handler) {
+	s.admin().Handle(pattern, handler)
+}
+
+func (s *Server) admin() *http.ServeMux {
+	s.adminOnce.Do(func() {
+		s.adminMux = http.NewServeMux()
+		s.adminMux.HandleFunc("/limits", s.handleAdminLimits)
+		s.adminMux.HandleFunc("/workers", s.handleAdminWorkers)
+		s.adminMux.HandleFunc("/settings", s.handleAdminSettings)
+		s.adminMux.HandleFunc("/stats", s.handleAdminStats)
+		s.adminMux.HandleFunc("/conns", s.handleAdminConns)
+		s.adminMux.HandleFunc("/requests", s.handleAdminRequests)
+		s.adminMux.HandleFunc("/requests/cancel", s.handleAdminCancelRequest)
+	})
+	return s.adminMux
+}
+
+type adminLimits struct {
+	MaxConnections      *int `json:"max_connections,omitempty"`
+	MaxConnectionsPerIP *int `json:"max_connections_per_ip,omitempty"`
+	Connections         int  `json:"connections"`
+}
+
+func (s *Server) handleAdminLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var limits adminLimits
+		if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if limits.MaxConnections != nil {
+			s.SetMaxConnections(*limits.MaxConnections)
+		}
+		if limits.MaxConnectionsPerIP != nil {
+			s.SetMaxConnectionsPerIP(*limits.MaxConnectionsPerIP)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxConns, maxConnsPerIP := s.ConnLimits()
+	s.mu.RLock()
+	conns := len(s.activeConn)
+	s.mu.RUnlock()
+
+	writeAdminJSON(w, adminLimits{
+		MaxConnections:      &maxConns,
+		MaxConnectionsPerIP: &maxConnsPerIP,
+		Connections:         conns,
+	})
+}
+
+type adminWorkers struct {
+	Size       int `json:"size"`
+	Queued     int `json:"queued"`
+	QueueDepth int `json:"queue_depth"`
+}
+
+func (s *Server) handleAdminWorkers(w http.ResponseWriter, r *http.Request) {
+	if s.workerPool == nil {
+		http.Error(w, "worker pool is not enabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var workers adminWorkers
+		if err := json.NewDecoder(r.Body).Decode(&workers); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if workers.Size < 1 {
+			http.Error(w, "size must be positive", http.StatusBadRequest)
+			return
+		}
+		s.SetWorkerPoolSize(workers.Size)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	size, queued, depth := s.workerPool.stats()
+	writeAdminJSON(w, adminWorkers{Size: size, Queued: queued, QueueDepth: depth})
+}
+
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeAdminJSON(w, s.Stats())
+}
+
+func (s *Server) handleAdminConns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	conns := s.Connections()
+	if conns == nil {
+		conns = []ConnectionInfo{}
+	}
+	writeAdminJSON(w, conns)
+}
+
+func (s *Server) handleAdminRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	requests := s.InflightRequests()
+	if requests == nil {
+		requests = []InflightRequest{}
+	}
+	writeAdminJSON(w, requests)
+}
+
+type adminCancelRequest struct {
+	ConnID uint64 `json:"conn"`
+	Seq    uint64 `json:"seq"`
+}
+
+func (s *Server) handleAdminCancelRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req adminCancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.CancelRequest(req.ConnID, req.Seq); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/rpcx/codec"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// errDetached is returned by handleRequest for requests detached from their handlers.
+var errDetached = errors.New("rpcx: request is detached")
+
+// ErrAsyncReplied is returned by AsyncReply if the request has been completed.
+var ErrAsyncReplied = errors.New("rpcx: detached request has been completed")
+
+// AsyncReply completes a request detached by Detach.
+type AsyncReply struct {
+	s *Server
+	r *inflightRequest
+
+	// set when the handler returns
+	service    *service
+	args       interface{}
+	postCall   bool
+	handlerErr error
+
+	// set when the request is handed over by the server
+	ctx         *share.Context
+	req, res    *protocol.Message
+	resMetadata map[string]string
+	release     func()
+	timer       *time.Timer
+
+	// set when the request is completed
+	reply interface{}
+	err   error
+
+	completed int32
+	// pending is 2 until the request is both handed over and completed
+	pending int32
+}
+
+// Detach takes over the response of the service call of ctx, so that the handler can return at once
+// without holding a goroutine or a worker of the pool, and complete the call later
+// by Reply or Error from any goroutine. For example, a long-polling method:
+//
+//	func (w *Watcher) Wait(ctx context.Context, args *Args, reply *Event) error {
+//		async := server.Detach(ctx)
+//		w.notify(args.Key, func(e *Event) { async.Reply(e) })
+//		return nil
+//	}
+//
+// Detach must be called by the handler before it returns. The server does not reuse args and reply of detached
+// handlers. Detached requests are in flight until they are completed, so Shutdown waits for them,
+// and those with deadlines from clients are completed with a DeadlineExceeded error when the deadline expires.
+// Response plugins, such as tracing and metrics, run when they are completed.
+// It returns nil if ctx is not the context of a service call, for example of handlers added by AddHandler.
+func Detach(ctx context.Context) *AsyncReply {
+	r, _ := ctx.Value(inflightContextKey).(*inflightRequest)
+	if r == nil {
+		return nil
+	}
+	if a := r.asyncReply(); a != nil {
+		return a
+	}
+	if r.args != nil {
+		codec.CopyFlatBuffer(r.args)
+	}
+	a := &AsyncReply{r: r, pending: 2}
+	r.async.Store(a)
+	return a
+}
+
+// Reply completes the request with reply, which is encoded and written on the connection of the request.
+// It returns ErrAsyncReplied if the request has been completed.
+func (a *AsyncReply) Reply(reply interface{}) error {
+	return a.complete(reply, nil)
+}
+
+// Error completes the request with err.
+// It returns ErrAsyncReplied if the request has been completed.
+func (a *AsyncReply) Error(err error) error {
+	if err == nil {
+		err = errors.New("rpcx: detached request failed with a nil error")
+	}
+	return a.complete(nil, err)
+}
+
+func (a *AsyncReply) complete(reply interface{}, err error) error {
+	if !atomic.CompareAndSwapInt32(&a.completed, 0, 1) {
+		return ErrAsyncReplied
+	}
+	a.reply, a.err = reply, err
+	a.arrive()
+	return nil
+}
+
+// detachedCall records the service and args of the detached handler and the error it returns.
+// The call of the service ends when the request is completed.
+func (a *AsyncReply) detachedCall(svc *service, args interface{}, postCall bool, err error) {
+	a.service, a.args, a.postCall, a.handlerErr = svc, args, postCall, err
+}
+
+// handoff passes the ownership of req and res to a, which calls release when it completes.
+func (a *AsyncReply) handoff(s *Server, ctx *share.Context, req, res *protocol.Message, resMetadata map[string]string, release func()) {
+	a.s, a.ctx, a.req, a.res, a.resMetadata, a.release = s, ctx, req, res, resMetadata, release
+
+	switch {
+	case a.handlerErr != nil:
+		a.Error(a.handlerErr)
+	case a.r.isResponded(): // canceled before it is detached
+		a.Error(ErrRequestCanceled)
+	default:
+		if deadline, ok := ctx.Deadline(); ok {
+			a.timer = time.AfterFunc(time.Until(deadline), func() {
+				a.Error(rerrors.ErrDeadlineExceeded)
+			})
+		}
+	}
+	a.arrive()
+}
+
+func (a *AsyncReply) arrive() {
+	if atomic.AddInt32(&a.pending, -1) == 0 {
+		a.s.completeDetached(a)
+	}
+}
+
+// completeDetached encodes the reply of a detached request and writes it.
+func (s *Server) completeDetached(a *AsyncReply) {
+	defer a.release()
+	defer a.service.end()
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+
+	req, res, err := a.req, a.res, a.err
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok && strings.Contains(e.Error(), "send on closed channel") {
+				log.Warnf("rpcx: dropped the response of detached request %s.%s because the connection is closed",
+					a.r.ServicePath, a.r.ServiceMethod)
+				s.Plugins.DoPostWriteResponse(a.ctx, req, res, e)
+				return
+			}
+			log.Errorf("rpcx: failed to complete detached request %s.%s: %v", a.r.ServicePath, a.r.ServiceMethod, r)
+		}
+	}()
+
+	reply := a.reply
+	if err == nil && a.postCall {
+		reply, err = s.Plugins.DoPostCall(a.ctx, req.ServicePath, req.ServiceMethod, a.args, reply)
+	}
+	if err == nil && !req.IsOneway() {
+		var data []byte
+		if data, err = share.EncodePayload(share.CodecOf(req.SerializeType()), res, reply); err == nil {
+			res.Payload = data
+		}
+	}
+	if err != nil {
+		handleError(res, err)
+	}
+
+	s.writeResponse(a.ctx, a.r.conn, a.r.writeCh, req, res, err, a.resMetadata, a.r)
+}
+
+// retainArgs records args which refer to the payload of req, whose buffer is reused after the request completes,
+// so that Detach copies them before the handler hands them over to other goroutines.
+func retainArgs(ctx context.Context, req *protocol.Message, args interface{}) {
+	if req.SerializeType() != protocol.FlatBuffers {
+		return
+	}
+	if r, ok := ctx.Value(inflightContextKey).(*inflightRequest); ok {
+		r.args = args
+	}
+}
+
+// detachedRequest returns the AsyncReply of the service call of ctx if its handler has called Detach.
+func detachedRequest(ctx context.Context) *AsyncReply {
+	if r, ok := ctx.Value(inflightContextKey).(*inflightRequest); ok {
+		return r.asyncReply()
+	}
+	return nil
+}
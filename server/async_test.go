@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/codec/testdata"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+type longPollService struct {
+	mu      sync.Mutex
+	waiters []*AsyncReply
+}
+
+// Wait completes when Notify is called.
+func (s *longPollService) Wait(ctx context.Context, args *Args, reply *Reply) error {
+	async := Detach(ctx)
+	s.mu.Lock()
+	s.waiters = append(s.waiters, async)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *longPollService) waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.waiters)
+}
+
+func (s *longPollService) notify(c int) {
+	s.mu.Lock()
+	waiters := s.waiters
+	s.waiters = nil
+	s.mu.Unlock()
+	for _, async := range waiters {
+		go async.Reply(&Reply{C: c})
+	}
+}
+
+func TestDetach(t *testing.T) {
+	if Detach(context.Background()) != nil {
+		t.Error("expect nil out of service calls")
+	}
+
+	svc := &longPollService{}
+	// a single worker serves all waiters
+	s := NewServer(WithWorkerPool(1, 10))
+	s.RegisterName("LongPoll", svc, "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	const n = 5
+	results := make(chan error, n)
+	replies := make([]*Reply, n)
+	for i := 0; i < n; i++ {
+		replies[i] = &Reply{}
+		go func(reply *Reply) {
+			results <- c.Call(context.Background(), "LongPoll", "Wait", &Args{}, reply)
+		}(replies[i])
+	}
+	for i := 0; i < 50 && svc.waiting() < n; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if svc.waiting() != n {
+		t.Fatalf("expect %d waiters but got %d", n, svc.waiting())
+	}
+
+	// detached requests are still in flight
+	assert.Len(t, s.InflightRequests(), n)
+	assert.EqualValues(t, n, s.Stats().InFlight)
+	assert.EqualValues(t, n, s.Stats().ServicesInFlight["LongPoll"])
+
+	svc.notify(42)
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-results:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("expect detached requests to be completed")
+		}
+	}
+	for _, reply := range replies {
+		assert.Equal(t, 42, reply.C)
+	}
+	// released after responses are written
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, s.InflightRequests())
+	assert.EqualValues(t, 0, s.Stats().InFlight)
+	assert.EqualValues(t, 0, s.Stats().ServicesInFlight["LongPoll"])
+}
+
+func TestDetachDeadline(t *testing.T) {
+	svc := &longPollService{}
+	s := NewServer()
+	s.RegisterName("LongPoll", svc, "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.ServerTimeout: "100"})
+	start := time.Now()
+	err := c.Call(ctx, "LongPoll", "Wait", &Args{}, &Reply{})
+	assert.True(t, errors.Is(err, rerrors.ErrDeadlineExceeded), "unexpected error: %v", err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// the request is completed only once
+	svc.mu.Lock()
+	async := svc.waiters[0]
+	svc.mu.Unlock()
+	assert.Equal(t, ErrAsyncReplied, async.Reply(&Reply{}))
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, s.InflightRequests())
+}
+
+// payloadPlugin records the payload of the last request.
+type payloadPlugin struct {
+	mu      sync.Mutex
+	payload *byte
+}
+
+func (p *payloadPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if r != nil && len(r.Payload) > 0 {
+		p.mu.Lock()
+		p.payload = &r.Payload[0]
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+func (p *payloadPlugin) last() *byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.payload
+}
+
+type flatService struct {
+	payloads *payloadPlugin
+
+	mu       sync.Mutex
+	zeroCopy bool
+	held     *testdata.FlatColorGroup
+	heldCopy bool
+	async    *AsyncReply
+}
+
+// Echo replies args, and records whether args refer to the payload.
+func (s *flatService) Echo(ctx context.Context, args *testdata.FlatColorGroup, reply *testdata.FlatColorGroupT) error {
+	s.mu.Lock()
+	s.zeroCopy = &args.Table().Bytes[0] == s.payloads.last()
+	s.mu.Unlock()
+	args.UnPackTo(reply)
+	return nil
+}
+
+// Hold detaches the request and keeps args.
+func (s *flatService) Hold(ctx context.Context, args *testdata.FlatColorGroup, reply *testdata.FlatColorGroupT) error {
+	s.mu.Lock()
+	s.held, s.async = args, Detach(ctx)
+	s.mu.Unlock()
+	return nil
+}
+
+func TestDetachFlatBuffers(t *testing.T) {
+	svc := &flatService{payloads: &payloadPlugin{}}
+	s := NewServer()
+	s.Plugins.Add(svc.payloads)
+	s.RegisterName("Flat", svc, "")
+	startServer(t, s, "tcp")
+
+	opt := client.DefaultOption
+	opt.SerializeType = protocol.FlatBuffers
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	echo := func(name string) {
+		reply := &testdata.FlatColorGroup{}
+		args := &testdata.FlatColorGroupT{Id: 1, Name: name, Colors: []string{"Red"}}
+		if assert.NoError(t, c.Call(context.Background(), "Flat", "Echo", args, reply)) {
+			assert.Equal(t, args, reply.UnPack())
+		}
+	}
+
+	// args of handlers refer to payloads of requests
+	echo("Reds")
+	svc.mu.Lock()
+	assert.True(t, svc.zeroCopy)
+	svc.mu.Unlock()
+
+	// args of detached handlers are copied
+	held := &testdata.FlatColorGroupT{Id: 2, Name: "Blues", Colors: []string{"Navy", "Azure"}}
+	result := make(chan error, 1)
+	reply := &testdata.FlatColorGroup{}
+	go func() {
+		result <- c.Call(context.Background(), "Flat", "Hold", held, reply)
+	}()
+	var async *AsyncReply
+	for i := 0; i < 50 && async == nil; i++ {
+		time.Sleep(20 * time.Millisecond)
+		svc.mu.Lock()
+		async = svc.async
+		svc.mu.Unlock()
+	}
+	if async == nil {
+		t.Fatal("expect the request detached")
+	}
+	time.Sleep(50 * time.Millisecond) // the handler has returned
+	assert.False(t, &svc.held.Table().Bytes[0] == svc.payloads.last())
+
+	assert.NoError(t, async.Reply(svc.held.UnPack()))
+	assert.NoError(t, <-result)
+	assert.Equal(t, held, reply.UnPack())
+
+	// still valid after buffers of requests are reused
+	for i := 0; i < 10; i++ {
+		echo("Greens")
+	}
+	assert.Equal(t, held, svc.held.UnPack())
+}
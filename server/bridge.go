@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// HandleRequest handles req by the registered services like requests of the HTTP gateway,
+// so that bridges of other protocols, such as grpcbridge, can serve the services.
+// Request plugins and authentication are applied, and the deadline of ctx and the ServerTimeout in metadata are kept.
+// The remote address of the caller can be set by RemoteConnContextKey in ctx.
+//
+// It returns the response, whose metadata contains the metadata set by the handler,
+// or the error of the request. The response must be freed by protocol.FreeMsg.
+func (s *Server) HandleRequest(ctx context.Context, req *protocol.Message) (*protocol.Message, error) {
+	sctx, ok := ctx.(*share.Context)
+	if !ok {
+		sctx = share.NewContext(ctx)
+	}
+	if req.Metadata == nil {
+		req.Metadata = make(map[string]string)
+	}
+
+	if err := s.Plugins.DoPreReadRequest(sctx); err != nil {
+		return nil, err
+	}
+	if err := s.Plugins.DoPostReadRequest(sctx, req, nil); err != nil {
+		return nil, err
+	}
+
+	sctx.SetValue(StartRequestContextKey, time.Now().UnixNano())
+	if err := s.auth(sctx, req); err != nil {
+		s.Plugins.DoPreWriteResponse(sctx, req, nil, err)
+		s.Plugins.DoPostWriteResponse(sctx, req, nil, err)
+		return nil, err
+	}
+
+	resMetadata := make(map[string]string)
+	newCtx := share.WithLocalValue(share.WithLocalValue(sctx, share.ReqMetaDataKey, s.requestMetadata(req)),
+		share.ResMetaDataKey, resMetadata)
+	if cancel := share.ExtractPropagated(newCtx, req.Metadata); cancel != nil {
+		defer cancel()
+	}
+	s.Plugins.DoPreHandleRequest(newCtx, req)
+
+	res, err := s.handleRequest(newCtx, req)
+	s.releaseReply(newCtx)
+	if err != nil {
+		s.Plugins.DoPreWriteResponse(newCtx, req, nil, err)
+		s.Plugins.DoPostWriteResponse(newCtx, req, nil, err)
+		protocol.FreeMsg(res)
+		return nil, err
+	}
+
+	if len(resMetadata) > 0 { // copy meta in context to response
+		if res.Metadata == nil {
+			res.Metadata = resMetadata
+		} else {
+			for k, v := range resMetadata {
+				res.Metadata[k] = v
+			}
+		}
+	}
+	s.Plugins.DoPreWriteResponse(newCtx, req, res, nil)
+	s.Plugins.DoPostWriteResponse(newCtx, req, res, nil)
+	return res, nil
+}
+
+// NewErrorResponse creates the response of req which sends err to the caller with its code and details,
+// like responses of failed requests of connections. It must be freed by protocol.FreeMsg.
+func NewErrorResponse(req *protocol.Message, err error) *protocol.Message {
+	res := req.Clone()
+	res.SetMessageType(protocol.Response)
+	handleError(res, err)
+	return res
+}
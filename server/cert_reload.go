@@ -0,0 +1,119 @@
+package server
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/log"
+)
+
+// WithGetCertificate sets the function that returns the certificate for every TLS handshake,
+// so certificates can be changed without restarting the server.
+// It is used by tcp, unix, wss and quic listeners, together with the TLS config set by WithTLSConfig if there is one.
+func WithGetCertificate(fn func(*tls.ClientHelloInfo) (*tls.Certificate, error)) OptionFn {
+	return func(s *Server) {
+		s.getCertificate = fn
+	}
+}
+
+// WithCertReload serves the certificate and key in certFile and keyFile, which are checked every checkInterval
+// and reloaded when they are modified, for example when they are renewed by an ACME client.
+// New handshakes use the new certificate and existing connections are not affected.
+// If the new files can't be parsed, the old certificate is kept and the error is logged.
+func WithCertReload(certFile, keyFile string, checkInterval time.Duration) OptionFn {
+	return func(s *Server) {
+		r := &certReloader{certFile: certFile, keyFile: keyFile}
+		if err := r.reload(); err != nil {
+			log.Errorf("rpcx: failed to load certificate %s: %v", certFile, err)
+		}
+		s.getCertificate = r.getCertificate
+		go r.watch(checkInterval, s.doneChan)
+	}
+}
+
+// applyGetCertificate sets getCertificate in the TLS config of the server.
+func (s *Server) applyGetCertificate() {
+	if s.getCertificate == nil {
+		return
+	}
+	if s.tlsConfig == nil {
+		s.tlsConfig = &tls.Config{}
+	} else {
+		s.tlsConfig = s.tlsConfig.Clone()
+	}
+	// GetCertificate is not called for clients without SNI if there are Certificates
+	s.tlsConfig.Certificates = nil
+	s.tlsConfig.GetCertificate = s.getCertificate
+}
+
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime [2]time.Time
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// modified returns the modification times of the files if either of them is changed.
+func (r *certReloader) modified() ([2]time.Time, bool) {
+	var modTime [2]time.Time
+	for i, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return modTime, false
+		}
+		modTime[i] = fi.ModTime()
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return modTime, modTime != r.modTime
+}
+
+func (r *certReloader) reload() error {
+	modTime, _ := r.modified()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// files that fail to parse are not retried until they are modified again
+	r.modTime = modTime
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	return nil
+}
+
+func (r *certReloader) watch(interval time.Duration, done <-chan struct{}) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if _, ok := r.modified(); !ok {
+			continue
+		}
+		if err := r.reload(); err != nil {
+			log.Errorf("rpcx: failed to reload certificate %s, keep using the old one: %v", r.certFile, err)
+			continue
+		}
+		log.Infof("rpcx: reloaded certificate %s", r.certFile)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSelfSignedCert(t *testing.T, certFile, keyFile string, serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	os.Chtimes(certFile, modTime, modTime)
+	os.Chtimes(keyFile, modTime, modTime)
+}
+
+func TestWithCertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	now := time.Now()
+	writeSelfSignedCert(t, certFile, keyFile, 1, now.Add(-time.Minute))
+
+	s := NewServer(WithCertReload(certFile, keyFile, 20*time.Millisecond))
+	s.RegisterName("Arith", new(Arith), "")
+	addr := startServer(t, s, "tcp")
+
+	serial := func() int64 {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	assert.Equal(t, int64(1), serial())
+
+	opt := client.DefaultOption
+	opt.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	c := client.NewClient(opt)
+	assert.NoError(t, c.Connect("tcp", addr))
+	defer c.Close()
+
+	// invalid files keep the old certificate
+	os.WriteFile(certFile, []byte("invalid"), 0600)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int64(1), serial())
+
+	writeSelfSignedCert(t, certFile, keyFile, 2, now)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int64(2), serial())
+
+	// existing connections are not affected
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, reply))
+	assert.Equal(t, 6, reply.C)
+	assert.Equal(t, int64(1), c.GetConn().(*tls.Conn).ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// WithChecksum adds CRC32C checksums of payloads to responses and messages sent by SendMessage,
+// so that clients detect payloads corrupted by lossy transports such as KCP without FEC.
+// Clients of old versions ignore them. Responses to requests with checksums have checksums without this option.
+//
+// Requests with checksums are always verified. Those failing verification get protocol.ErrChecksumMismatch
+// without closing their connections, and they are counted in Stats.
+func WithChecksum() OptionFn {
+	return func(s *Server) {
+		s.checksum = true
+	}
+}
+
+// setChecksum adds the checksum to m written on conn, unless the client has negotiated the protocol without checksums.
+func (s *Server) setChecksum(conn net.Conn, m *protocol.Message) {
+	if !s.checksum {
+		return
+	}
+	if n := s.Negotiation(conn); n != nil && !n.Capabilities.Has(protocol.CapChecksum) {
+		return
+	}
+	m.SetChecksum(true)
+}
+
+// isChecksumMismatch returns whether the request read by readRequest fails its checksum,
+// which fails the request only because the framing of the connection is intact.
+func (s *Server) isChecksumMismatch(conn net.Conn, req *protocol.Message, err error) bool {
+	if req == nil || !errors.Is(err, protocol.ErrChecksumMismatch) {
+		return false
+	}
+	atomic.AddUint64(&s.stats.checksumMismatches, 1)
+	log.Warnf("rpcx: checksum mismatch of request %s.%s (seq %d) from %s",
+		req.ServicePath, req.ServiceMethod, req.Seq(), conn.RemoteAddr().String())
+	return true
+}
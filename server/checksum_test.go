@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksumMismatch(t *testing.T) {
+	s := NewServer(WithChecksum())
+	s.RegisterName("Arith", new(Arith), "")
+	startServer(t, s, "tcp")
+
+	conn := dialHeartbeat(t, s.Address().String())
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	protocol.Read(r) // heartbeat
+
+	call := func(corrupted bool) *protocol.Message {
+		req := protocol.NewMessage()
+		req.SetSerializeType(protocol.JSON)
+		req.SetChecksum(true)
+		req.ServicePath = "Arith"
+		req.ServiceMethod = "Mul"
+		req.Payload = []byte(`{"A":10,"B":20}`)
+		data := req.Encode()
+		if corrupted {
+			data[len(data)-3] ^= 0x04 // the payload is at the end
+		}
+		conn.Write(data)
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		res, err := protocol.Read(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := call(true)
+	assert.Equal(t, protocol.Error, res.MessageStatusType())
+	assert.Equal(t, protocol.ErrChecksumMismatch.Error(), res.Metadata[protocol.ServiceError])
+	assert.Equal(t, "15", res.Metadata[protocol.ServiceErrorCode])
+	assert.Equal(t, uint64(1), s.Stats().ChecksumMismatches)
+
+	// the connection is still usable
+	res = call(false)
+	assert.Equal(t, protocol.Normal, res.MessageStatusType(), res.Metadata)
+	assert.True(t, res.HasChecksum())
+	assert.Equal(t, `{"C":200}`, string(res.Payload))
+	assert.Equal(t, uint64(1), s.Stats().ChecksumMismatches)
+
+	// rpcx clients verify responses
+	opt := client.DefaultOption
+	opt.Checksum = true
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply))
+	assert.Equal(t, 200, reply.C)
+}
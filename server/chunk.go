@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+)
+
+type chunkOptions struct {
+	threshold int
+	maxSize   int
+	timeout   time.Duration
+}
+
+// WithChunking splits responses and messages sent to clients which are larger than threshold bytes when encoded
+// into chunk frames of threshold bytes, and reassembles chunked requests of up to maxSize bytes whose chunk frames
+// are all received in timeout. Reassembled requests are limited by maxSize instead of WithMaxMessageSize,
+// which still limits chunk frames, and so are requests being reassembled on a connection in total.
+// Connections exceeding the limits are closed. Chunk frames of a message are interleaved with other messages on the connection,
+// so large messages don't hold up heartbeats and small responses.
+//
+// Clients with Option.ChunkThreshold tell the server they reassemble chunk frames, and the server tells them it does too,
+// so clients and servers of old versions still get and send messages unchunked.
+// Zero maxSize and timeout mean protocol.DefaultMaxChunkedMessageSize and protocol.DefaultChunkTimeout.
+func WithChunking(threshold, maxSize int, timeout time.Duration) OptionFn {
+	return func(s *Server) {
+		s.chunking = chunkOptions{threshold: threshold, maxSize: maxSize, timeout: timeout}
+	}
+}
+
+// newReassembler returns the Reassembler of chunk frames of a connection, or nil if chunking is disabled.
+func (s *Server) newReassembler() *protocol.Reassembler {
+	if s.chunking.threshold <= 0 {
+		return nil
+	}
+	return protocol.NewReassembler(s.chunking.maxSize, s.chunking.timeout)
+}
+
+// acceptChunks records that the client sending req reassembles chunk frames and tells it the server does too by res.
+func (s *Server) acceptChunks(conn net.Conn, req, res *protocol.Message) {
+	if s.chunking.threshold <= 0 || req.Metadata[protocol.AcceptChunk] == "" {
+		return
+	}
+	s.mu.RLock()
+	info := s.activeConn[conn]
+	s.mu.RUnlock()
+	if info != nil {
+		atomic.StoreInt32(&info.acceptChunk, 1)
+	}
+	if res.Metadata == nil {
+		res.Metadata = make(map[string]string)
+	}
+	res.Metadata[protocol.AcceptChunk] = "1"
+}
+
+// shouldChunk returns whether the message of n bytes is written on conn in chunk frames.
+func (s *Server) shouldChunk(conn net.Conn, n int) bool {
+	if s.chunking.threshold <= 0 || n <= s.chunking.threshold {
+		return false
+	}
+	s.mu.RLock()
+	info := s.activeConn[conn]
+	s.mu.RUnlock()
+	return info != nil && atomic.LoadInt32(&info.acceptChunk) == 1
+}
+
+// writeData writes data, an encoded message, by writeCh, or on conn if writeCh is nil.
+// It is written in chunk frames if it is large and the client accepts them. data is returned to the pool.
+func (s *Server) writeData(conn net.Conn, writeCh chan *[]byte, data *[]byte) error {
+	if !s.shouldChunk(conn, len(*data)) {
+		return s.writeFrame(conn, writeCh, data)
+	}
+
+	frames := protocol.SplitChunks(*data, s.chunking.threshold)
+	protocol.PutData(data)
+	var err error
+	for _, frame := range frames {
+		if err == nil {
+			err = s.writeFrame(conn, writeCh, frame)
+		} else {
+			protocol.PutData(frame)
+		}
+	}
+	return err
+}
+
+// writeMessage writes the encoded response msg like writeData and returns msg to the pool.
+// Responses written on conn directly, which are those of servers whose AsyncWrite is false, are written in place
+// by vectored writes, while those queued for the asynchronous writer or chunked are copied, since their payloads
+// are released after writeMessage.
+func (s *Server) writeMessage(conn net.Conn, writeCh chan *[]byte, msg *protocol.EncodedMessage) error {
+	if writeCh != nil || s.shouldChunk(conn, msg.Len()) {
+		data := msg.Bytes()
+		msg.Free()
+		return s.writeData(conn, writeCh, data)
+	}
+	err := s.writeEncoded(conn, msg)
+	msg.Free()
+	return err
+}
+
+func (s *Server) writeFrame(conn net.Conn, writeCh chan *[]byte, data *[]byte) error {
+	if writeCh != nil {
+		writeCh <- data
+		return nil
+	}
+	err := s.writeConn(conn, *data)
+	protocol.PutData(data)
+	return err
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+type Blob struct {
+	Data []byte
+}
+
+type BlobService struct{}
+
+func (s *BlobService) Echo(ctx context.Context, args *Blob, reply *Blob) error {
+	reply.Data = args.Data
+	return nil
+}
+
+func startChunkServer(t *testing.T, options ...OptionFn) *Server {
+	s := NewServer(options...)
+	s.RegisterName("Blob", new(BlobService), "")
+	s.RegisterName("Arith", new(Arith), "")
+	startServer(t, s, "tcp")
+	return s
+}
+
+func newChunkClient(t *testing.T, s *Server, threshold int) client.RPCClient {
+	opt := client.DefaultOption
+	opt.ChunkThreshold = threshold
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func blob(n int) []byte {
+	return bytes.Repeat([]byte("0123456789abcdef"), n/16)
+}
+
+func TestChunking(t *testing.T) {
+	// requests larger than the max message size are read only if they are chunked
+	s := startChunkServer(t, WithChunking(1024, 1<<20, time.Second), WithMaxMessageSize(4096))
+	defer s.Close()
+	c := newChunkClient(t, s, 1024)
+	defer c.Close()
+	ch := make(chan *protocol.Message, 1)
+	c.RegisterServerMessageChan(ch)
+
+	// the first call tells the server that the client reassembles chunks
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply))
+	assert.Equal(t, 200, reply.C)
+
+	// large calls are interleaved with small calls
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			reply := &Blob{}
+			data := blob(256 * 1024)
+			if assert.NoError(t, c.Call(context.Background(), "Blob", "Echo", &Blob{Data: data}, reply)) {
+				assert.Equal(t, data, reply.Data)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				reply := &Reply{}
+				if assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: i, B: j}, reply)) {
+					assert.Equal(t, i*j, reply.C)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// so are messages sent to clients
+	data := blob(64 * 1024)
+	conns := s.ActiveClientConn()
+	if assert.Len(t, conns, 1) {
+		assert.NoError(t, s.SendMessage(conns[0], "Blob", "Push", nil, data))
+		select {
+		case msg := <-ch:
+			assert.Equal(t, "Push", msg.ServiceMethod)
+			assert.Equal(t, data, msg.Payload)
+		case <-time.After(time.Second):
+			t.Error("expect the message")
+		}
+	}
+
+	// reassembled requests are limited too
+	err := c.Call(context.Background(), "Blob", "Echo", &Blob{Data: blob(2 << 20)}, &Blob{})
+	assert.Error(t, err)
+}
+
+func TestChunkingResponses(t *testing.T) {
+	s := startChunkServer(t, WithChunking(1024, 0, 0))
+	defer s.Close()
+
+	conn := dialHeartbeat(t, s.Address().String())
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	protocol.Read(r) // heartbeat
+
+	data := blob(8 * 1024)
+	payload, _ := json.Marshal(&Blob{Data: data})
+	req := protocol.NewMessage()
+	req.SetSerializeType(protocol.JSON)
+	req.SetSeq(1)
+	req.ServicePath = "Blob"
+	req.ServiceMethod = "Echo"
+	req.Metadata = map[string]string{protocol.AcceptChunk: "1"}
+	req.Payload = payload
+	conn.Write(req.Encode())
+
+	chunks := protocol.NewReassembler(0, 0)
+	frames := 0
+	var res *protocol.Message
+	for res == nil {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		frame, err := protocol.Read(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !frame.IsChunk() {
+			t.Fatalf("expect chunks but got %+v", frame)
+		}
+		frames++
+		if res, err = chunks.Reassemble(frame, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.True(t, frames > 8, "expect the response in chunks of 1024 bytes but got %d", frames)
+	assert.Equal(t, "1", res.Metadata[protocol.AcceptChunk])
+	var reply Blob
+	assert.NoError(t, json.Unmarshal(res.Payload, &reply))
+	assert.Equal(t, data, reply.Data)
+}
+
+func TestChunkingFallback(t *testing.T) {
+	data := blob(64 * 1024)
+
+	// clients of old versions get responses unchunked
+	s := startChunkServer(t, WithChunking(1024, 0, 0))
+	c := newChunkClient(t, s, 0)
+	for i := 0; i < 2; i++ {
+		reply := &Blob{}
+		if assert.NoError(t, c.Call(context.Background(), "Blob", "Echo", &Blob{Data: data}, reply)) {
+			assert.Equal(t, data, reply.Data)
+		}
+	}
+	c.Close()
+	s.Close()
+
+	// and servers without chunking get requests unchunked
+	s = startChunkServer(t)
+	defer s.Close()
+	c = newChunkClient(t, s, 1024)
+	defer c.Close()
+	for i := 0; i < 2; i++ {
+		reply := &Blob{}
+		if assert.NoError(t, c.Call(context.Background(), "Blob", "Echo", &Blob{Data: data}, reply)) {
+			assert.Equal(t, data, reply.Data)
+		}
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	peer, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := NewServer()
+	res := protocol.NewMessage()
+	res.SetMessageType(protocol.Response)
+	res.ServicePath, res.ServiceMethod = "Blob", "Echo"
+	res.Payload = blob(64 * 1024)
+	want := res.Encode()
+	payload := append([]byte(nil), res.Payload...)
+	release := func() { // like replies returned to their pools after writeMessage
+		copy(res.Payload, make([]byte, len(res.Payload)))
+	}
+
+	// responses queued for the asynchronous writer are copied
+	writeCh := make(chan *[]byte, 1)
+	assert.NoError(t, s.writeMessage(conn, writeCh, res.EncodeVectored()))
+	release()
+	data := <-writeCh
+	assert.Equal(t, want, *data)
+	protocol.PutData(data)
+
+	// and those of servers whose AsyncWrite is false are written in place before writeMessage returns
+	copy(res.Payload, payload)
+	done := make(chan []byte)
+	go func() {
+		got := make([]byte, len(want))
+		io.ReadFull(peer, got)
+		done <- got
+	}()
+	assert.NoError(t, s.writeMessage(conn, nil, res.EncodeVectored()))
+	release()
+	assert.Equal(t, want, <-done)
+}
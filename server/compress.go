@@ -0,0 +1,99 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/smallnest/rpcx/protocol"
+)
+
+type autoCompressOptions struct {
+	enabled   bool
+	minSize   int
+	preferred protocol.CompressType
+}
+
+// WithAutoCompress compresses responses of services with preferred if their payloads are at least minSize bytes
+// and the client can decompress preferred, which means the request is compressed with preferred
+// or preferred is in protocol.AcceptCompress of the request metadata.
+// Responses with protocol.PayloadCompressed in metadata are not compressed.
+// Bytes saved are reported in Stats.
+//
+// By default responses are compressed with the compress type of requests if they are larger than 1024 bytes.
+func WithAutoCompress(minSize int, preferred protocol.CompressType) OptionFn {
+	return func(s *Server) {
+		s.autoCompress = autoCompressOptions{enabled: true, minSize: minSize, preferred: preferred}
+	}
+}
+
+// setResponseCompressType sets the compress type of res, which is the response of req.
+func (s *Server) setResponseCompressType(req, res *protocol.Message) {
+	acceptNewCompressTypes(req, res)
+
+	if res.MessageStatusType() == protocol.Normal && s.isRawService(req.ServicePath) {
+		return // compressed by the server which the raw handler has forwarded req to
+	}
+
+	if !s.autoCompress.enabled {
+		if len(res.Payload) > 1024 && req.CompressType() != protocol.None {
+			res.SetCompressType(req.CompressType())
+		}
+		return
+	}
+
+	res.SetCompressType(protocol.None)
+	if _, ok := res.Metadata[protocol.PayloadCompressed]; ok {
+		delete(res.Metadata, protocol.PayloadCompressed)
+		return
+	}
+	opt := s.autoCompress
+	if opt.preferred == protocol.None || len(res.Payload) < opt.minSize || !acceptsCompressType(req, opt.preferred) {
+		return
+	}
+	res.SetCompressType(opt.preferred)
+}
+
+// acceptsCompressType returns whether the client sending req can decompress ct.
+func acceptsCompressType(req *protocol.Message, ct protocol.CompressType) bool {
+	if req.CompressType() == ct {
+		return true
+	}
+	accepted := req.Metadata[protocol.AcceptCompress]
+	if accepted == "" {
+		return false
+	}
+	want := strconv.Itoa(int(ct))
+	for _, t := range strings.Split(accepted, ",") {
+		if strings.TrimSpace(t) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptNewCompressTypes tells clients which new compress types this server can decompress too,
+// among those they accept, so that they compress requests with them instead of gzip, which old servers can decompress.
+func acceptNewCompressTypes(req, res *protocol.Message) {
+	var accepted []string
+	for _, ct := range []protocol.CompressType{protocol.Zstd, protocol.Snappy} {
+		if req.CompressType() != ct && protocol.Compressors[ct] != nil && acceptsCompressType(req, ct) {
+			accepted = append(accepted, strconv.Itoa(int(ct)))
+		}
+	}
+	if len(accepted) == 0 {
+		return
+	}
+	if res.Metadata == nil {
+		res.Metadata = make(map[string]string)
+	}
+	res.Metadata[protocol.AcceptCompress] = strings.Join(accepted, ",")
+}
+
+// observeCompression counts bytes saved by compressing res, whose encoded payload has n bytes.
+func (s *Server) observeCompression(res *protocol.Message, n int) {
+	if res.CompressType() == protocol.None {
+		return
+	}
+	atomic.AddInt64(&s.stats.compressSaved, int64(len(res.Payload)-n))
+}
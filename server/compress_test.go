@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+type CompressReply struct {
+	Data string
+}
+
+type compressService struct{}
+
+// Get returns A bytes, which are marked as compressed if B is 1.
+func (*compressService) Get(ctx context.Context, args *Args, reply *CompressReply) error {
+	reply.Data = strings.Repeat("a", args.A)
+	if args.B == 1 {
+		ctx.Value(share.ResMetaDataKey).(map[string]string)[protocol.PayloadCompressed] = "true"
+	}
+	return nil
+}
+
+func TestAutoCompress(t *testing.T) {
+	s := NewServer(WithAutoCompress(512, protocol.Gzip))
+	s.RegisterName("Compress", new(compressService), "")
+	addr := startServer(t, s, "tcp")
+
+	conn := dialHeartbeat(t, addr)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	protocol.Read(r) // heartbeat
+
+	call := func(payload string, ct protocol.CompressType, meta map[string]string) *protocol.Message {
+		req := protocol.NewMessage()
+		req.SetSerializeType(protocol.JSON)
+		req.SetCompressType(ct)
+		req.ServicePath = "Compress"
+		req.ServiceMethod = "Get"
+		req.Metadata = meta
+		req.Payload = []byte(payload)
+		conn.Write(req.Encode())
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		res, err := protocol.Read(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, protocol.Normal, res.MessageStatusType(), res.Metadata)
+		return res
+	}
+	accept := map[string]string{protocol.AcceptCompress: "1"}
+
+	res := call(`{"A":4096}`, protocol.None, accept)
+	assert.Equal(t, protocol.Gzip, res.CompressType())
+	assert.Len(t, res.Payload, 4096+len(`{"Data":""}`))
+	saved := s.Stats().CompressBytesSaved
+	assert.True(t, saved > 3000, "saved %d bytes", saved)
+
+	// compressed requests imply the client supports the compress type
+	res = call(`{"A":4096}`, protocol.Gzip, nil)
+	assert.Equal(t, protocol.Gzip, res.CompressType())
+	assert.True(t, s.Stats().CompressBytesSaved > saved)
+
+	// small payloads
+	res = call(`{"A":10}`, protocol.Gzip, accept)
+	assert.Equal(t, protocol.None, res.CompressType())
+
+	// clients not supporting the compress type
+	res = call(`{"A":4096}`, protocol.None, map[string]string{protocol.AcceptCompress: "2,3"})
+	assert.Equal(t, protocol.None, res.CompressType())
+	res = call(`{"A":4096}`, protocol.None, nil)
+	assert.Equal(t, protocol.None, res.CompressType())
+
+	// payloads that are already compressed
+	res = call(`{"A":4096,"B":1}`, protocol.None, accept)
+	assert.Equal(t, protocol.None, res.CompressType())
+	assert.NotContains(t, res.Metadata, protocol.PayloadCompressed)
+
+	// rpcx clients accept responses compressed with their compress type
+	saved = s.Stats().CompressBytesSaved
+	opt := client.DefaultOption
+	opt.CompressType = protocol.Gzip
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	reply := &CompressReply{}
+	assert.NoError(t, c.Call(context.Background(), "Compress", "Get", &Args{A: 4096}, reply))
+	assert.Len(t, reply.Data, 4096)
+	assert.True(t, s.Stats().CompressBytesSaved > saved)
+}
+
+// Echo returns args.
+func (*compressService) Echo(ctx context.Context, args *CompressReply, reply *CompressReply) error {
+	reply.Data = args.Data
+	return nil
+}
+
+// compressTypePlugin records compress types of requests and responses.
+type compressTypePlugin struct {
+	mu       sync.Mutex
+	reqTypes []protocol.CompressType
+	resTypes []protocol.CompressType
+}
+
+func (p *compressTypePlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if r != nil && !r.IsHeartbeat() {
+		p.mu.Lock()
+		p.reqTypes = append(p.reqTypes, r.CompressType())
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+func (p *compressTypePlugin) PostWriteResponse(ctx context.Context, req *protocol.Message, res *protocol.Message, err error) error {
+	if res != nil {
+		p.mu.Lock()
+		p.resTypes = append(p.resTypes, res.CompressType())
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+func TestAutoCompressNewTypes(t *testing.T) {
+	for _, ct := range []protocol.CompressType{protocol.Zstd, protocol.Snappy} {
+		for _, old := range []bool{false, true} {
+			testAutoCompressNewType(t, ct, old)
+		}
+	}
+}
+
+func testAutoCompressNewType(t *testing.T, ct protocol.CompressType, old bool) {
+	p := &compressTypePlugin{}
+	s := NewServer(WithAutoCompress(512, ct))
+	if old {
+		// old servers don't know new compress types and compress responses with the compress type of requests
+		compressor := protocol.Compressors[ct]
+		delete(protocol.Compressors, ct)
+		defer func() { protocol.Compressors[ct] = compressor }()
+		s = NewServer()
+	}
+	s.Plugins.Add(p)
+	s.RegisterName("Compress", new(compressService), "")
+	startServer(t, s, "tcp")
+
+	opt := client.DefaultOption
+	opt.CompressType = ct
+	opt.NegotiateTimeout = 0 // compress types are accepted by responses, see TestNegotiation for the negotiation
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	data := strings.Repeat("a", 4096)
+	for i := 0; i < 3; i++ {
+		resMeta := make(map[string]string)
+		ctx := context.WithValue(context.Background(), share.ResMetaDataKey, resMeta)
+		reply := &CompressReply{}
+		assert.NoError(t, c.Call(ctx, "Compress", "Echo", &CompressReply{Data: data}, reply))
+		assert.Equal(t, data, reply.Data)
+		assert.NotContains(t, resMeta, protocol.AcceptCompress)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if old {
+		// downgraded to gzip, which old servers can decompress
+		assert.Equal(t, []protocol.CompressType{protocol.Gzip, protocol.Gzip, protocol.Gzip}, p.reqTypes, "compress type %d", ct)
+		assert.Equal(t, []protocol.CompressType{protocol.Gzip, protocol.Gzip, protocol.Gzip}, p.resTypes, "compress type %d", ct)
+	} else {
+		// upgraded after the server accepts the compress type
+		assert.Equal(t, []protocol.CompressType{protocol.Gzip, ct, ct}, p.reqTypes, "compress type %d", ct)
+		assert.Equal(t, []protocol.CompressType{ct, ct, ct}, p.resTypes, "compress type %d", ct)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// RejectReasonConnectionBusy is the reason passed to RequestRejectedPlugin when the connection of the request
+// has too many in-flight requests.
+const RejectReasonConnectionBusy = "connection_busy"
+
+// ErrConnectionBusy is returned to clients whose connections have too many in-flight requests.
+// It has the Unavailable code like ErrServerBusy so clients can retry it.
+var ErrConnectionBusy = rerrors.New(rerrors.Unavailable, "rpcx: connection has too many in-flight requests")
+
+// WithMaxInflightPerConnection limits in-flight requests of each connection to n, so that a client pipelining
+// many requests over a connection can't starve other clients before the limit of the server is hit.
+// Requests beyond the limit are rejected with ErrConnectionBusy, unless they are queued by WithInflightQueuePerConnection.
+// The limit is enforced when requests are read, before they are queued for WithWorkerPool, so with WithPriorityScheduling
+// it shares the server among clients. Heartbeats are not limited. Zero means no limit.
+func WithMaxInflightPerConnection(n int) OptionFn {
+	return func(s *Server) {
+		s.runtime().MaxInflightPerConnection = n
+	}
+}
+
+// WithInflightQueuePerConnection queues up to depth requests of each connection beyond WithMaxInflightPerConnection
+// instead of rejecting them. They are dispatched in the order they are read when in-flight requests of the connection
+// complete, and requests beyond the queue are rejected with ErrConnectionBusy.
+func WithInflightQueuePerConnection(depth int) OptionFn {
+	return func(s *Server) {
+		s.runtime().InflightQueuePerConnection = depth
+	}
+}
+
+// admit tells whether a request of the connection can be dispatched by the limit of in-flight requests now,
+// and counts it in flight if it can. Otherwise dispatch is queued by the depth of the queue, or the request is rejected.
+func (info *connInfo) admit(limit, depth int, dispatch func()) (admitted, queued bool) {
+	if info == nil || limit <= 0 {
+		info.addInflight(1)
+		return true, false
+	}
+
+	info.admitMu.Lock()
+	defer info.admitMu.Unlock()
+	// counted before inflight is checked, so that done completing the last in-flight request meanwhile dispatches it
+	atomic.AddInt32(&info.queued, 1)
+	if atomic.LoadInt32(&info.inflight) < int32(limit) {
+		atomic.AddInt32(&info.inflight, 1)
+		atomic.AddInt32(&info.queued, -1)
+		return true, false
+	}
+	if len(info.waiting) < depth {
+		info.waiting = append(info.waiting, dispatch)
+		return false, true
+	}
+	atomic.AddInt32(&info.queued, -1)
+	atomic.AddUint64(&info.rejected, 1)
+	return false, false
+}
+
+// done counts a request of the connection out of flight, and dispatches queued requests while the connection
+// has fewer in-flight requests than limit, which may be changed by ApplySettings after they are queued.
+func (info *connInfo) done(limit int) {
+	if info == nil {
+		return
+	}
+	atomic.AddInt32(&info.inflight, -1)
+	if atomic.LoadInt32(&info.queued) == 0 {
+		return
+	}
+
+	info.admitMu.Lock()
+	var next []func()
+	for len(info.waiting) > 0 && (limit <= 0 || atomic.LoadInt32(&info.inflight) < int32(limit)) {
+		next = append(next, info.waiting[0])
+		info.waiting[0] = nil
+		info.waiting = info.waiting[1:]
+		atomic.AddInt32(&info.inflight, 1)
+		atomic.AddInt32(&info.queued, -1)
+	}
+	info.admitMu.Unlock()
+	for _, dispatch := range next {
+		dispatch()
+	}
+}
+
+// queuedRequests returns the number of requests queued by WithInflightQueuePerConnection.
+func (info *connInfo) queuedRequests() int {
+	info.admitMu.Lock()
+	defer info.admitMu.Unlock()
+	return len(info.waiting)
+}
+
+// rejectConnectionBusy rejects req, whose connection has too many in-flight requests. It frees req.
+func (s *Server) rejectConnectionBusy(ctx context.Context, conn net.Conn, writeCh chan *[]byte, req *protocol.Message) {
+	atomic.AddInt32(&s.handlerMsgNum, -1)
+	s.stats.shed(RejectReasonConnectionBusy)
+	s.Plugins.DoRequestRejected(ctx, req, RejectReasonConnectionBusy, ErrConnectionBusy)
+	s.writeErrorResponse(ctx, conn, writeCh, req, ErrConnectionBusy)
+	protocol.FreeMsg(req)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxInflightPerConnection(t *testing.T) {
+	s := NewServer(WithWorkerPool(4, 1000), WithMaxInflightPerConnection(2))
+	s.RegisterName("Sleep", new(sleepService), "")
+	addr := startServer(t, s, "tcp")
+
+	flooding := client.NewClient(client.DefaultOption)
+	if err := flooding.Connect("tcp", addr); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer flooding.Close()
+	trickling := client.NewClient(client.DefaultOption)
+	if err := trickling.Connect("tcp", addr); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer trickling.Close()
+
+	// without the limit, 100 calls of 50ms in 4 workers would delay other clients by a second
+	var calls []*client.Call
+	for i := 0; i < 100; i++ {
+		calls = append(calls, flooding.Go(context.Background(), "Sleep", "Sleep", &Args{A: 50}, &Reply{}, nil))
+	}
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		err := trickling.Call(context.Background(), "Sleep", "Sleep", &Args{A: 10}, &Reply{})
+		assert.NoError(t, err)
+		assert.True(t, time.Since(start) < 300*time.Millisecond, "latency of the trickling client: %v", time.Since(start))
+	}
+
+	var rejected int
+	for _, call := range calls {
+		<-call.Done
+		if call.Error != nil {
+			rejected++
+			assert.True(t, errors.Is(call.Error, rerrors.ErrUnavailable), "unexpected error: %v", call.Error)
+			assert.Equal(t, ErrConnectionBusy.Error(), call.Error.Error())
+		}
+	}
+	assert.True(t, rejected >= 90, "rejected %d calls", rejected)
+	assert.Equal(t, uint64(rejected), s.Stats().Shed[RejectReasonConnectionBusy])
+
+	var infos []ConnectionInfo
+	for _, info := range s.Connections() {
+		if info.Rejected > 0 {
+			infos = append(infos, info)
+		}
+	}
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, uint64(rejected), infos[0].Rejected)
+		assert.Equal(t, int32(0), infos[0].InFlight)
+	}
+}
+
+func TestInflightQueuePerConnection(t *testing.T) {
+	gate := &gateService{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s := NewServer(WithMaxInflightPerConnection(1), WithInflightQueuePerConnection(1))
+	s.RegisterName("Gate", gate, "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	// the first call is in flight, the second is queued and the third is rejected
+	call1 := c.Go(context.Background(), "Gate", "Wait", &Args{A: 1}, &Reply{}, nil)
+	<-gate.started
+	call2 := c.Go(context.Background(), "Gate", "Wait", &Args{A: 2}, &Reply{}, nil)
+	time.Sleep(100 * time.Millisecond)
+	err := c.Call(context.Background(), "Gate", "Wait", &Args{A: 3}, &Reply{})
+	assert.True(t, errors.Is(err, rerrors.ErrUnavailable), "unexpected error: %v", err)
+
+	infos := s.Connections()
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, int32(1), infos[0].InFlight)
+		assert.Equal(t, 1, infos[0].Queued)
+		assert.Equal(t, uint64(1), infos[0].Rejected)
+	}
+	select {
+	case <-gate.started:
+		t.Fatal("queued call is handled beyond the limit")
+	default:
+	}
+
+	// heartbeats are not limited
+	request, reply := time.Now().UnixNano(), int64(0)
+	assert.NoError(t, c.Call(context.Background(), "", "", &request, &reply))
+	assert.Equal(t, request, reply)
+
+	close(gate.release)
+	for i, call := range []*client.Call{call1, call2} {
+		select {
+		case <-call.Done:
+			assert.NoError(t, call.Error)
+			assert.Equal(t, i+1, call.Reply.(*Reply).C)
+		case <-time.After(time.Second):
+			t.Fatalf("queued call is not handled")
+		}
+	}
+}
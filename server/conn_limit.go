@@ -0,0 +1,251 @@
+package server
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+)
+
+const (
+	// RejectReasonMaxConnections is the reason passed to ConnRejectedPlugin when the server has too many connections.
+	RejectReasonMaxConnections = "max_connections"
+	// RejectReasonMaxConnectionsPerIP is the reason passed to ConnRejectedPlugin when the source IP has too many connections.
+	RejectReasonMaxConnectionsPerIP = "max_connections_per_ip"
+	// RejectReasonMaxPendingConnections is the reason passed to ConnRejectedPlugin when too many connections
+	// are waiting for their first messages, see WithMaxPendingConnections.
+	RejectReasonMaxPendingConnections = "max_pending_connections"
+)
+
+// connInfo contains the state of an active connection.
+type connInfo struct {
+	id          uint64      // identifies the connection in the admin API
+	ip          string      // key in connsPerIP, empty if the connection has no IP
+	session     interface{} // key in sessionConns, nil if the connection doesn't share a session
+	closeReason string
+
+	lastActivity int64        // unix nano of the last traffic from the client
+	pingedAt     int64        // unix nano of the unanswered heartbeat sent by the reaper, zero if none
+	inflight     int32        // number of requests being handled
+	acceptChunk  int32        // set when the client reassembles chunk frames
+	negotiation  atomic.Value // *Negotiation set when the client negotiates the protocol
+
+	requestsMu sync.Mutex
+	requests   map[uint64]*inflightRequest // in-flight service calls by seq
+
+	admitMu  sync.Mutex // serializes changes of inflight with waiting by WithMaxInflightPerConnection
+	waiting  []func()   // dispatches of requests queued by WithInflightQueuePerConnection
+	queued   int32      // requests being queued or in waiting, read by done without admitMu
+	rejected uint64     // requests rejected by WithMaxInflightPerConnection
+}
+
+// ConnectionInfo describes an active connection.
+type ConnectionInfo struct {
+	// ID identifies the connection in the server, as ConnID of InflightRequest
+	ID           uint64    `json:"id"`
+	RemoteAddr   string    `json:"remote_addr"`
+	LastActivity time.Time `json:"last_activity"`
+	InFlight     int32     `json:"in_flight"`
+	// Queued and Rejected are requests queued and rejected by WithMaxInflightPerConnection
+	Queued   int    `json:"queued"`
+	Rejected uint64 `json:"rejected"`
+	// Negotiation is nil if the client has not negotiated the protocol
+	Negotiation *Negotiation `json:"negotiation"`
+}
+
+// sessionConn is implemented by connections sharing a session with other connections, such as streams of a QUIC session.
+// Per-IP connection limits count sessions instead of such connections.
+type sessionConn interface {
+	connSession() interface{}
+}
+
+// WithMaxConnections limits the number of connections. Zero means no limit.
+func WithMaxConnections(n int) OptionFn {
+	return func(s *Server) {
+		s.runtime().MaxConnections = n
+	}
+}
+
+// WithMaxConnectionsPerIP limits the number of connections from the same source IP. Zero means no limit.
+// Streams of a QUIC session are counted as one connection.
+// The source IP is read from RemoteAddr of connections returned by PostConnAcceptPlugins,
+// so plugins that parse PROXY protocol headers can provide the real client address.
+func WithMaxConnectionsPerIP(n int) OptionFn {
+	return func(s *Server) {
+		s.runtime().MaxConnectionsPerIP = n
+	}
+}
+
+// WithConnRejectFrame sets whether an error frame is written to connections rejected by connection limits
+// before they are closed, so that clients can log why. It is enabled by default.
+func WithConnRejectFrame(enabled bool) OptionFn {
+	return func(s *Server) {
+		s.disableRejectFrame = !enabled
+	}
+}
+
+// SetMaxConnections changes the limit of connections at runtime, see ApplySettings. Existing connections are not closed.
+// Negative values mean no limit.
+func (s *Server) SetMaxConnections(n int) {
+	if n < 0 {
+		n = 0
+	}
+	s.UpdateSettings(func(settings *RuntimeSettings) { settings.MaxConnections = n })
+}
+
+// SetMaxConnectionsPerIP changes the limit of connections per source IP at runtime, see ApplySettings.
+// Existing connections are not closed. Negative values mean no limit.
+func (s *Server) SetMaxConnectionsPerIP(n int) {
+	if n < 0 {
+		n = 0
+	}
+	s.UpdateSettings(func(settings *RuntimeSettings) { settings.MaxConnectionsPerIP = n })
+}
+
+// ConnLimits returns the limits of connections in total and per source IP.
+func (s *Server) ConnLimits() (maxConns, maxConnsPerIP int) {
+	settings := s.runtime()
+	return settings.MaxConnections, settings.MaxConnectionsPerIP
+}
+
+// Connections returns active connections ordered by ID.
+func (s *Server) Connections() []ConnectionInfo {
+	var conns []ConnectionInfo
+	s.mu.RLock()
+	for conn, info := range s.activeConn {
+		conns = append(conns, ConnectionInfo{
+			ID:           info.id,
+			RemoteAddr:   conn.RemoteAddr().String(),
+			LastActivity: time.Unix(0, atomic.LoadInt64(&info.lastActivity)),
+			InFlight:     atomic.LoadInt32(&info.inflight),
+			Queued:       info.queuedRequests(),
+			Rejected:     atomic.LoadUint64(&info.rejected),
+			Negotiation:  info.negotiated(),
+		})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	return conns
+}
+
+// addConn adds conn to active connections.
+// It returns a reject reason if conn exceeds connection limits and conn is not added.
+func (s *Server) addConn(conn net.Conn) string {
+	ip := connIP(conn)
+	var session interface{}
+	if sc, ok := netConn(conn).(sessionConn); ok {
+		session = sc.connSession()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	newSession := session == nil || s.sessionConns[session] == 0
+	settings := s.runtime()
+	if settings.MaxConnections > 0 && len(s.activeConn) >= settings.MaxConnections {
+		return RejectReasonMaxConnections
+	}
+	if settings.MaxConnectionsPerIP > 0 && ip != "" && newSession && s.connsPerIP[ip] >= settings.MaxConnectionsPerIP {
+		return RejectReasonMaxConnectionsPerIP
+	}
+
+	s.nextConnID++
+	s.activeConn[conn] = &connInfo{id: s.nextConnID, ip: ip, session: session, lastActivity: time.Now().UnixNano()}
+	if session != nil {
+		if s.sessionConns == nil {
+			s.sessionConns = make(map[interface{}]int)
+		}
+		s.sessionConns[session]++
+	}
+	if ip != "" && newSession {
+		s.connsPerIP[ip]++
+	}
+	return ""
+}
+
+// removeConnLocked removes conn from active connections and returns its state. s.mu must be held.
+// It returns nil if conn has been removed.
+func (s *Server) removeConnLocked(conn net.Conn) *connInfo {
+	info, ok := s.activeConn[conn]
+	if !ok {
+		return nil
+	}
+	delete(s.activeConn, conn)
+	s.removeHealthWatcher(conn)
+	s.removeSubscriber(conn)
+	if info.session != nil {
+		if s.sessionConns[info.session] > 1 {
+			// the session is still counted in connsPerIP
+			s.sessionConns[info.session]--
+			return info
+		}
+		delete(s.sessionConns, info.session)
+	}
+	if info.ip != "" {
+		if s.connsPerIP[info.ip] <= 1 {
+			delete(s.connsPerIP, info.ip)
+		} else {
+			s.connsPerIP[info.ip]--
+		}
+	}
+	return info
+}
+
+// rejectConn closes a connection rejected by connection limits.
+func (s *Server) rejectConn(conn net.Conn, reason string) {
+	log.Warnf("rpcx: rejected conn %s: %s", conn.RemoteAddr().String(), reason)
+	s.stats.rejectConn(reason)
+	s.Plugins.DoConnRejected(conn, reason)
+
+	if !s.disableRejectFrame {
+		res := protocol.NewMessage()
+		res.SetMessageType(protocol.Response)
+		res.SetMessageStatusType(protocol.Error)
+		res.Metadata = map[string]string{
+			protocol.ServiceError:     "rpcx: connection rejected: " + reason,
+			protocol.ServiceErrorCode: strconv.Itoa(int(rerrors.ResourceExhausted)),
+			protocol.ConnRejected:     reason,
+		}
+		// in plaintext, since clients of session connections are still exchanging keys
+		raw := netConn(conn)
+		raw.SetWriteDeadline(time.Now().Add(time.Second))
+		raw.Write(res.Encode())
+	}
+	conn.Close()
+}
+
+// dropConn closes conn, which is rejected for reason before it is accepted, so no reject frame is written.
+func (s *Server) dropConn(conn net.Conn, reason string, err error) {
+	log.Warnf("rpcx: rejected conn %s: %v", conn.RemoteAddr().String(), err)
+	s.stats.rejectConn(reason)
+	s.Plugins.DoConnRejected(conn, reason)
+	conn.Close()
+}
+
+// connIP returns the normalized source IP of conn, or an empty string if it has none.
+func connIP(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	if i := strings.IndexByte(host, '%'); i >= 0 { // IPv6 zone
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
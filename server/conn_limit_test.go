@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+type connRejectedRecorder struct {
+	reasons chan string
+}
+
+func (r *connRejectedRecorder) HandleConnRejected(conn net.Conn, reason string) {
+	r.reasons <- reason
+}
+
+func TestMaxConnectionsPerIP(t *testing.T) {
+	recorder := &connRejectedRecorder{reasons: make(chan string, 10)}
+	s := NewServer(WithMaxConnectionsPerIP(1))
+	s.Plugins.Add(recorder)
+	s.RegisterName("Arith", new(Arith), "")
+	addr := startServer(t, s, "tcp")
+
+	c1 := client.NewClient(client.DefaultOption)
+	err := c1.Connect("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	reply := &Reply{}
+	err = c1.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	assert.NoError(t, err)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	// the gateway needs the first bytes to match the rpcx protocol
+	req := protocol.NewMessage()
+	req.SetHeartbeat(true)
+	conn.Write(req.Encode())
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	res, err := protocol.Read(bufio.NewReader(conn))
+	conn.Close()
+	if assert.NoError(t, err) {
+		assert.Equal(t, protocol.Error, res.MessageStatusType())
+		assert.Equal(t, RejectReasonMaxConnectionsPerIP, res.Metadata[protocol.ConnRejected])
+	}
+	select {
+	case reason := <-recorder.reasons:
+		assert.Equal(t, RejectReasonMaxConnectionsPerIP, reason)
+	case <-time.After(time.Second):
+		t.Errorf("ConnRejectedPlugin is not called")
+	}
+
+	// the per-IP table is cleaned up after the connection is closed
+	c1.Close()
+	time.Sleep(200 * time.Millisecond)
+	s.mu.RLock()
+	assert.Empty(t, s.connsPerIP)
+	s.mu.RUnlock()
+
+	c2 := client.NewClient(client.DefaultOption)
+	err = c2.Connect("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c2.Close()
+	err = c2.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	assert.NoError(t, err)
+}
+
+func TestAdminLimits(t *testing.T) {
+	s := NewServer(WithMaxConnections(100))
+	h := s.AdminHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/limits", strings.NewReader(`{"max_connections_per_ip":5}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"max_connections":100,"max_connections_per_ip":5,"connections":0}`, w.Body.String())
+
+	maxConns, maxConnsPerIP := s.ConnLimits()
+	assert.Equal(t, 100, maxConns)
+	assert.Equal(t, 5, maxConnsPerIP)
+}
+
+func TestConnIP(t *testing.T) {
+	cases := map[string]string{
+		"127.0.0.1:1234":         "127.0.0.1",
+		"[::1]:1234":             "::1",
+		"[fe80::1%eth0]:1234":    "fe80::1",
+		"[::ffff:10.0.0.1]:1234": "10.0.0.1",
+		"[2001:DB8::0:1]:1234":   "2001:db8::1",
+		"/tmp/rpcx.sock":         "",
+	}
+	for addr, want := range cases {
+		assert.Equal(t, want, connIP(&addrConn{addr: addr}), addr)
+	}
+}
+
+type addrConn struct {
+	net.Conn
+	addr string
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return stringAddr(c.addr)
+}
+
+type stringAddr string
+
+func (a stringAddr) Network() string { return "tcp" }
+func (a stringAddr) String() string  { return string(a) }
+
+type fakeSessionConn struct {
+	*addrConn
+	session *int
+}
+
+func (c *fakeSessionConn) connSession() interface{} {
+	return c.session
+}
+
+func TestMaxConnectionsPerIPSessions(t *testing.T) {
+	s := NewServer(WithMaxConnectionsPerIP(1))
+	session1, session2 := new(int), new(int)
+	stream1 := &fakeSessionConn{&addrConn{addr: "10.0.0.1:1234"}, session1}
+	stream2 := &fakeSessionConn{&addrConn{addr: "10.0.0.1:1234"}, session1}
+
+	// streams of a session are counted as one connection
+	assert.Empty(t, s.addConn(stream1))
+	assert.Empty(t, s.addConn(stream2))
+	assert.Equal(t, RejectReasonMaxConnectionsPerIP, s.addConn(&fakeSessionConn{&addrConn{addr: "10.0.0.1:1235"}, session2}))
+	assert.Equal(t, 1, s.connsPerIP["10.0.0.1"])
+
+	s.mu.Lock()
+	s.removeConnLocked(stream1)
+	assert.Equal(t, 1, s.connsPerIP["10.0.0.1"])
+	s.removeConnLocked(stream2)
+	assert.Empty(t, s.connsPerIP)
+	assert.Empty(t, s.sessionConns)
+	s.mu.Unlock()
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// CloseReasonIdleReaped means the connection was closed by the reaper of idle client connections.
+const CloseReasonIdleReaped = "idle_reaped"
+
+// WithClientIdleTimeout closes connections without any traffic from clients for timeout,
+// for example connections of clients that vanished behind NAT without closing them.
+// Connections with in-flight requests are never closed.
+//
+// If pingGrace is zero, idle connections are closed at once.
+// Otherwise a heartbeat is sent to the client first and the connection is closed
+// only if no traffic arrives within pingGrace. Clients older than this feature don't reply to it,
+// but their own heartbeats keep connections alive.
+func WithClientIdleTimeout(timeout, pingGrace time.Duration) OptionFn {
+	return func(s *Server) {
+		s.clientIdleTimeout = timeout
+		s.clientPingGrace = pingGrace
+	}
+}
+
+// touch records traffic of the connection.
+func (info *connInfo) touch() {
+	if info == nil {
+		return
+	}
+	atomic.StoreInt64(&info.lastActivity, time.Now().UnixNano())
+	atomic.StoreInt64(&info.pingedAt, 0)
+}
+
+// addInflight changes the number of in-flight requests of the connection.
+func (info *connInfo) addInflight(delta int32) {
+	if info != nil {
+		atomic.AddInt32(&info.inflight, delta)
+	}
+}
+
+// reapIdleConns closes idle client connections until the server is closed.
+func (s *Server) reapIdleConns() {
+	interval := s.clientIdleTimeout / 4
+	if s.clientPingGrace > 0 && s.clientPingGrace/2 < interval {
+		interval = s.clientPingGrace / 2
+	}
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.doneChan:
+			return
+		case <-ticker.C:
+			s.reapIdleConnsOnce(time.Now())
+		}
+	}
+}
+
+func (s *Server) reapIdleConnsOnce(now time.Time) {
+	var idle, ping []net.Conn
+
+	s.mu.RLock()
+	for conn, info := range s.activeConn {
+		if atomic.LoadInt32(&info.inflight) > 0 {
+			continue
+		}
+		if now.Sub(time.Unix(0, atomic.LoadInt64(&info.lastActivity))) < s.clientIdleTimeout {
+			continue
+		}
+		if s.clientPingGrace == 0 {
+			idle = append(idle, conn)
+			continue
+		}
+		pingedAt := atomic.LoadInt64(&info.pingedAt)
+		if pingedAt == 0 {
+			atomic.StoreInt64(&info.pingedAt, now.UnixNano())
+			ping = append(ping, conn)
+		} else if now.Sub(time.Unix(0, pingedAt)) >= s.clientPingGrace {
+			idle = append(idle, conn)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, conn := range ping {
+		go s.pingConn(conn)
+	}
+	for _, conn := range idle {
+		log.Infof("rpcx: reaped idle connection %s", conn.RemoteAddr().String())
+		s.setCloseReason(conn, CloseReasonIdleReaped)
+		conn.Close()
+	}
+}
+
+// pingConn sends a heartbeat to the client. The client replies with a heartbeat response.
+func (s *Server) pingConn(conn net.Conn) {
+	req := protocol.GetPooledMsg()
+	req.SetMessageType(protocol.Request)
+	req.SetHeartbeat(true)
+	req.SetSeq(atomic.AddUint64(&s.seq, 1))
+
+	data := req.EncodeSlicePointer()
+	s.writeConn(conn, *data)
+	protocol.PutData(data)
+	protocol.FreeMsg(req)
+}
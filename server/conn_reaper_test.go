@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// dialHeartbeat dials the server and sends a heartbeat so that the connection is accepted.
+func dialHeartbeat(t *testing.T, addr string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	hb := protocol.NewMessage()
+	hb.SetHeartbeat(true)
+	conn.Write(hb.Encode())
+	return conn
+}
+
+func TestClientIdleTimeout(t *testing.T) {
+	s, recorder := startTimeoutServer(t, WithClientIdleTimeout(100*time.Millisecond, 0))
+	defer s.Close()
+
+	conn := dialHeartbeat(t, s.Address().String())
+	defer conn.Close()
+
+	recorder.wait(t, CloseReasonIdleReaped, 2*time.Second)
+	s.mu.RLock()
+	assert.Empty(t, s.activeConn)
+	assert.Empty(t, s.connsPerIP)
+	s.mu.RUnlock()
+}
+
+func TestClientIdleTimeout_InFlight(t *testing.T) {
+	gate := &gateService{started: make(chan struct{}, 1), release: make(chan struct{})}
+	s, recorder := startTimeoutServer(t, WithClientIdleTimeout(100*time.Millisecond, 0))
+	defer s.Close()
+	s.RegisterName("Gate", gate, "")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	call := c.Go(context.Background(), "Gate", "Wait", &Args{A: 1}, &Reply{}, nil)
+	<-gate.started
+
+	// connections with in-flight requests are not reaped
+	select {
+	case reason := <-recorder.reasons:
+		t.Fatalf("expect conn not closed but closed by %s", reason)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	close(gate.release)
+	<-call.Done
+	assert.NoError(t, call.Error)
+	recorder.wait(t, CloseReasonIdleReaped, 2*time.Second)
+}
+
+func TestClientIdleTimeout_Ping(t *testing.T) {
+	s, recorder := startTimeoutServer(t, WithClientIdleTimeout(100*time.Millisecond, 100*time.Millisecond))
+	defer s.Close()
+
+	// clients reply heartbeats of the server
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply))
+
+	// raw connections don't
+	conn := dialHeartbeat(t, s.Address().String())
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	res, err := protocol.Read(r) // reply of our heartbeat
+	if assert.NoError(t, err) {
+		assert.Equal(t, protocol.Response, res.MessageType())
+	}
+	res, err = protocol.Read(r)
+	if assert.NoError(t, err) {
+		assert.Equal(t, protocol.Request, res.MessageType())
+		assert.True(t, res.IsHeartbeat())
+	}
+
+	recorder.wait(t, CloseReasonIdleReaped, time.Second)
+
+	time.Sleep(500 * time.Millisecond)
+	s.mu.RLock()
+	assert.Len(t, s.activeConn, 1)
+	s.mu.RUnlock()
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply))
+	assert.Equal(t, 200, reply.C)
+}
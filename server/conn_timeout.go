@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/soheilhy/cmux"
+)
+
+// Reasons passed to PostConnCloseReasonPlugin.
+const (
+	// CloseReasonClientClosed means the client closed the connection.
+	CloseReasonClientClosed = "client_closed"
+	// CloseReasonIdleTimeout means no message started within the idle timeout.
+	CloseReasonIdleTimeout = "idle_timeout"
+	// CloseReasonReadTimeout means a message was not read completely within the read timeout.
+	CloseReasonReadTimeout = "read_timeout"
+	// CloseReasonWriteTimeout means a response or a pushed message was not written within the write timeout.
+	CloseReasonWriteTimeout = "write_timeout"
+	// CloseReasonReadError means reading or decoding a message failed.
+	CloseReasonReadError = "read_error"
+	// CloseReasonWriteError means writing failed.
+	CloseReasonWriteError = "write_error"
+	// CloseReasonTLSHandshake means the TLS handshake failed.
+	CloseReasonTLSHandshake = "tls_handshake_failed"
+	// CloseReasonTLSHandshakeTimeout means the TLS handshake was not completed in time, see WithTLSHandshakeTimeout.
+	CloseReasonTLSHandshakeTimeout = "tls_handshake_timeout"
+	// CloseReasonFirstRequestTimeout means the first message was not read in time, see WithFirstRequestTimeout.
+	CloseReasonFirstRequestTimeout = "first_request_timeout"
+	// CloseReasonSessionHandshake means the client failed to exchange session keys, see WithSessionEncryption.
+	CloseReasonSessionHandshake = "session_handshake_failed"
+	// CloseReasonAuthFailed means AuthFunc rejected a request.
+	CloseReasonAuthFailed = "auth_failed"
+	// CloseReasonPanic means serving the connection panicked.
+	CloseReasonPanic = "panic"
+	// CloseReasonServerClosed means the server is closed or shut down.
+	CloseReasonServerClosed = "server_closed"
+)
+
+// WithIdleTimeout sets the max time to wait for the next message on a connection.
+// Heartbeats are messages too so they keep connections alive.
+// If it is not set, the read timeout is also used as the idle timeout.
+func WithIdleTimeout(idleTimeout time.Duration) OptionFn {
+	return func(s *Server) {
+		s.runtime().IdleTimeout = idleTimeout
+	}
+}
+
+// waitRequest waits for the first byte of the next message within the idle timeout
+// and then sets the read deadline for reading the whole message.
+func (s *Server) waitRequest(conn net.Conn, r interface{ Peek(int) ([]byte, error) }) error {
+	settings := s.runtime()
+	idle := settings.IdleTimeout
+	if idle == 0 {
+		idle = settings.ReadTimeout
+	}
+	if idle != 0 {
+		conn.SetReadDeadline(time.Now().Add(idle))
+	}
+
+	if _, err := r.Peek(1); err != nil {
+		return err
+	}
+
+	if settings.ReadTimeout != 0 {
+		conn.SetReadDeadline(time.Now().Add(settings.ReadTimeout))
+	} else if idle != 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+	return nil
+}
+
+// readCloseReason logs the read error and returns the reason to close the connection.
+func readCloseReason(conn net.Conn, err error, timeoutReason string) string {
+	if err == io.EOF {
+		logger.Info(context.Background(), "client has closed this connection", "remote", conn.RemoteAddr().String())
+		return CloseReasonClientClosed
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		logger.Info(context.Background(), "rpcx: connection is closed", "remote", conn.RemoteAddr().String(), "reason", timeoutReason)
+		return timeoutReason
+	}
+	if strings.Contains(err.Error(), "use of closed network connection") {
+		logger.Info(context.Background(), "rpcx: connection is closed", "remote", conn.RemoteAddr().String())
+		return CloseReasonServerClosed
+	}
+	logger.Warn(context.Background(), "rpcx: failed to read request", "remote", conn.RemoteAddr().String(), "error", err)
+	return CloseReasonReadError
+}
+
+// writeConn writes data to conn within the write timeout.
+// If the write fails, conn is closed so that the read loop exits.
+func (s *Server) writeConn(conn net.Conn, data []byte) error {
+	if d := s.runtime().WriteTimeout; d != 0 {
+		conn.SetWriteDeadline(time.Now().Add(d))
+	}
+	_, err := conn.Write(data)
+	return s.checkWrite(conn, err)
+}
+
+// writeEncoded writes the encoded message msg on conn like writeConn. Connections sniffed by the gateway
+// are written on their underlying connections, so that messages are written to TCP connections by writev.
+func (s *Server) writeEncoded(conn net.Conn, msg *protocol.EncodedMessage) error {
+	if d := s.runtime().WriteTimeout; d != 0 {
+		conn.SetWriteDeadline(time.Now().Add(d))
+	}
+	var w io.Writer = conn
+	switch conn.(type) {
+	case *cmux.MuxConn, *pendingConn:
+		w = netConn(conn)
+	}
+	_, err := msg.WriteTo(w)
+	return s.checkWrite(conn, err)
+}
+
+// checkWrite closes conn if err, the error of a write on it, is not nil.
+func (s *Server) checkWrite(conn net.Conn, err error) error {
+	if err != nil {
+		reason := CloseReasonWriteError
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			reason = CloseReasonWriteTimeout
+		}
+		logger.Warn(context.Background(), "rpcx: failed to write", "remote", conn.RemoteAddr().String(), "error", err)
+		s.setCloseReason(conn, reason)
+		conn.Close()
+	}
+	return err
+}
+
+// setCloseReason records why conn is going to be closed. The first reason wins.
+func (s *Server) setCloseReason(conn net.Conn, reason string) {
+	s.mu.Lock()
+	if info := s.activeConn[conn]; info != nil && info.closeReason == "" {
+		info.closeReason = reason
+	}
+	s.mu.Unlock()
+}
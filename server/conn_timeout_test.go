@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+type closeReasonRecorder struct {
+	reasons chan string
+}
+
+func (r *closeReasonRecorder) HandleConnCloseReason(conn net.Conn, reason string) {
+	r.reasons <- reason
+}
+
+func (r *closeReasonRecorder) wait(t *testing.T, want string, timeout time.Duration) {
+	select {
+	case reason := <-r.reasons:
+		assert.Equal(t, want, reason)
+	case <-time.After(timeout):
+		t.Errorf("expect conn closed with %s", want)
+	}
+}
+
+func startTimeoutServer(t *testing.T, options ...OptionFn) (*Server, *closeReasonRecorder) {
+	recorder := &closeReasonRecorder{reasons: make(chan string, 10)}
+	s := NewServer(options...)
+	s.Plugins.Add(recorder)
+	s.RegisterName("Arith", new(Arith), "")
+	startServer(t, s, "tcp")
+	return s, recorder
+}
+
+func heartbeatData() []byte {
+	req := protocol.NewMessage()
+	req.SetHeartbeat(true)
+	return req.Encode()
+}
+
+func TestReadTimeoutOnPartialMessage(t *testing.T) {
+	s, recorder := startTimeoutServer(t, WithReadTimeout(200*time.Millisecond), WithIdleTimeout(5*time.Second))
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	// a complete heartbeat and then half of a header
+	conn.Write(heartbeatData())
+	conn.Write(heartbeatData()[:6])
+
+	r := bufio.NewReader(conn)
+	_, err = protocol.Read(r)
+	assert.NoError(t, err)
+
+	recorder.wait(t, CloseReasonReadTimeout, 2*time.Second)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s, recorder := startTimeoutServer(t, WithIdleTimeout(300*time.Millisecond))
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// heartbeats keep the connection alive
+	for i := 0; i < 6; i++ {
+		conn.Write(heartbeatData())
+		_, err = protocol.Read(r)
+		if !assert.NoError(t, err) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	recorder.wait(t, CloseReasonIdleTimeout, 2*time.Second)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	s, recorder := startTimeoutServer(t, WithWriteTimeout(200*time.Millisecond))
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write(heartbeatData())
+	time.Sleep(100 * time.Millisecond)
+
+	conns := s.ActiveClientConn()
+	if len(conns) != 1 {
+		t.Fatalf("expect 1 conn but got %d", len(conns))
+	}
+
+	// the client never reads so the socket buffers fill up
+	data := make([]byte, 4<<20)
+	for i := 0; i < 64 && err == nil; i++ {
+		err = s.SendMessage(conns[0], "Arith", "Push", nil, data)
+	}
+	assert.Error(t, err)
+	recorder.wait(t, CloseReasonWriteTimeout, 2*time.Second)
+}
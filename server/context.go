@@ -1,7 +1,8 @@
 package server
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
 	"net"
 
 	"github.com/smallnest/rpcx/protocol"
@@ -68,12 +69,12 @@ func (ctx *Context) ServiceMethod() string {
 func (ctx *Context) Bind(v interface{}) error {
 	req := ctx.req
 	if v != nil {
-		codec := share.Codecs[req.SerializeType()]
+		codec := share.CodecOf(req.SerializeType())
 		if codec == nil {
-			return fmt.Errorf("can not find codec for %d", req.SerializeType())
+			return share.UnsupportedSerializeType(req.SerializeType())
 		}
 
-		err := codec.Decode(req.Payload, v)
+		err := share.DecodePayload(codec, req, req.Payload, v)
 		if err != nil {
 			return err
 		}
@@ -88,16 +89,16 @@ func (ctx *Context) Write(v interface{}) error {
 		return nil
 	}
 
-	codec := share.Codecs[req.SerializeType()]
+	codec := share.CodecOf(req.SerializeType())
 	if codec == nil {
-		return fmt.Errorf("can not find codec for %d", req.SerializeType())
+		return share.UnsupportedSerializeType(req.SerializeType())
 	}
 
 	res := req.Clone()
 	res.SetMessageType(protocol.Response)
 
 	if v != nil {
-		data, err := codec.Encode(v)
+		data, err := share.EncodePayload(codec, res, v)
 		if err != nil {
 			return err
 		}
@@ -126,7 +127,45 @@ func (ctx *Context) Write(v interface{}) error {
 
 	var err error
 	if ctx.writeCh != nil {
-		ctx.writeCh <- respData
+		err = ctx.writeAsync(respData)
+	} else {
+		_, err = ctx.conn.Write(*respData)
+		protocol.PutData(respData)
+	}
+
+	return err
+}
+
+// WriteRaw writes payload, which is serialized already, with metadata as the response, such as responses recorded by the replay package.
+// The response is an error response if metadata has protocol.ServiceError.
+func (ctx *Context) WriteRaw(payload []byte, metadata map[string]string) error {
+	req := ctx.req
+
+	if req.IsOneway() { // no need to send response
+		return nil
+	}
+
+	res := req.Clone()
+	res.SetMessageType(protocol.Response)
+	res.Payload = payload
+	if len(metadata) > 0 {
+		res.Metadata = make(map[string]string, len(metadata))
+		for k, v := range metadata {
+			res.Metadata[k] = v
+		}
+	}
+	if res.Metadata[protocol.ServiceError] != "" {
+		res.SetMessageStatusType(protocol.Error)
+	}
+
+	if len(res.Payload) > 1024 && req.CompressType() != protocol.None {
+		res.SetCompressType(req.CompressType())
+	}
+	respData := res.EncodeSlicePointer()
+
+	var err error
+	if ctx.writeCh != nil {
+		err = ctx.writeAsync(respData)
 	} else {
 		_, err = ctx.conn.Write(*respData)
 		protocol.PutData(respData)
@@ -142,9 +181,9 @@ func (ctx *Context) WriteError(err error) error {
 		return nil
 	}
 
-	codec := share.Codecs[req.SerializeType()]
+	codec := share.CodecOf(req.SerializeType())
 	if codec == nil {
-		return fmt.Errorf("can not find codec for %d", req.SerializeType())
+		return share.UnsupportedSerializeType(req.SerializeType())
 	}
 
 	res := req.Clone()
@@ -166,7 +205,10 @@ func (ctx *Context) WriteError(err error) error {
 	}
 
 	res.SetMessageStatusType(protocol.Error)
-	res.Metadata[protocol.ServiceError] = err.Error()
+	if res.Metadata == nil {
+		res.Metadata = make(map[string]string)
+	}
+	setErrorMetadata(res.Metadata, err)
 
 	respData := res.EncodeSlicePointer()
 	ctx.conn.Write(*respData)
@@ -174,3 +216,49 @@ func (ctx *Context) WriteError(err error) error {
 
 	return nil
 }
+
+// RequestID returns the ID of the request handled with ctx.
+// It is set by the request ID plugin, or read from metadata of the request if the plugin is not used.
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(share.RequestIDContextKey).(string); ok {
+		return id
+	}
+	if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		return meta[share.RequestIDKey]
+	}
+	return ""
+}
+
+// CallerIdentity returns the identity of the caller of the request handled with ctx.
+// It is the identity set in ctx by share.IdentityContextKey, for example by AuthFunc,
+// the peer certificate of the TLS connection, whose organizational units are its roles,
+// or the Windows account of the client of a named pipe, see PipeClientIdentity.
+// It returns nil if there is none.
+func CallerIdentity(ctx context.Context) *share.Identity {
+	if id, ok := ctx.Value(share.IdentityContextKey).(*share.Identity); ok && id != nil {
+		return id
+	}
+	conn, _ := ctx.Value(RemoteConnContextKey).(net.Conn)
+	if tlsConn, ok := netConn(conn).(*tls.Conn); ok {
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			return &share.Identity{Name: certs[0].Subject.CommonName, Roles: certs[0].Subject.OrganizationalUnit}
+		}
+	}
+	if id, ok := PipeClientIdentity(ctx); ok && id.User != "" {
+		return &share.Identity{Name: id.User}
+	}
+	return nil
+}
+
+// writeAsync queues data for the writer of the connection. The queue is closed once handlers of the connection
+// return after it is closed, so writing from goroutines of handlers afterwards fails with net.ErrClosed.
+func (ctx *Context) writeAsync(data *[]byte) (err error) {
+	defer func() {
+		if recover() != nil {
+			protocol.PutData(data)
+			err = net.ErrClosed
+		}
+	}()
+	ctx.writeCh <- data
+	return nil
+}
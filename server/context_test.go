@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+func selfSignedCert(t *testing.T, subject pkix.Name) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+type identityService struct {
+	identity chan *share.Identity
+}
+
+func (s *identityService) Who(ctx context.Context, args *Args, reply *Reply) error {
+	s.identity <- CallerIdentity(ctx)
+	return nil
+}
+
+func TestCallerIdentity(t *testing.T) {
+	assert.Nil(t, CallerIdentity(context.Background()))
+	id := &share.Identity{Name: "alice"}
+	assert.Equal(t, id, CallerIdentity(share.WithValue(context.Background(), share.IdentityContextKey, id)))
+
+	// from the peer certificate of mutual TLS
+	svc := &identityService{identity: make(chan *share.Identity, 1)}
+	s := NewServer(WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t, pkix.Name{CommonName: "server"})},
+		ClientAuth:   tls.RequireAnyClientCert,
+	}))
+	s.RegisterName("Identity", svc, "")
+	startServer(t, s, "tcp")
+
+	opt := client.DefaultOption
+	opt.TLSConfig = &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{selfSignedCert(t, pkix.Name{CommonName: "bob", OrganizationalUnit: []string{"admin", "ops"}})},
+	}
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	assert.NoError(t, c.Call(context.Background(), "Identity", "Who", &Args{}, &Reply{}))
+	got := <-svc.identity
+	if assert.NotNil(t, got) {
+		assert.Equal(t, "bob", got.Name)
+		assert.ElementsMatch(t, []string{"admin", "ops"}, got.Roles)
+	}
+}
@@ -1,10 +1,14 @@
 package server
 
 import (
+	"compress/gzip"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/share"
@@ -22,8 +26,74 @@ const (
 	XServiceMethod     = "X-RPCX-ServiceMethod"
 	XMeta              = "X-RPCX-Meta"
 	XErrorMessage      = "X-RPCX-ErrorMessage"
+	XErrorCode         = "X-RPCX-ErrorCode"
+
+	// XMetaPrefix is the prefix of headers that are mapped to metadata, such as X-RPCX-Tenant for the key tenant.
+	XMetaPrefix = "X-RPCX-"
 )
 
+// reservedHeaders are X-RPCX- headers that are not metadata.
+var reservedHeaders = map[string]bool{
+	http.CanonicalHeaderKey(XVersion):           true,
+	http.CanonicalHeaderKey(XMessageType):       true,
+	http.CanonicalHeaderKey(XHeartbeat):         true,
+	http.CanonicalHeaderKey(XOneway):            true,
+	http.CanonicalHeaderKey(XMessageStatusType): true,
+	http.CanonicalHeaderKey(XSerializeType):     true,
+	http.CanonicalHeaderKey(XMessageID):         true,
+	http.CanonicalHeaderKey(XServicePath):       true,
+	http.CanonicalHeaderKey(XServiceMethod):     true,
+	http.CanonicalHeaderKey(XMeta):              true,
+	http.CanonicalHeaderKey(XErrorMessage):      true,
+	http.CanonicalHeaderKey(XErrorCode):         true,
+}
+
+// SerializeTypeOfContentType returns the serialize type of the media type of Content-Type,
+// whose codec is registered with the media type by share.RegisterCodecWithName.
+func SerializeTypeOfContentType(contentType string) (protocol.SerializeType, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return 0, false
+	}
+	return share.SerializeTypeOfName(mediaType)
+}
+
+// ContentTypeOfSerializeType returns the Content-Type of payloads of the serialize type,
+// application/octet-stream if its codec has no name.
+func ContentTypeOfSerializeType(st protocol.SerializeType) string {
+	if name := share.NameOfSerializeType(st); name != "" {
+		return name
+	}
+	return "application/octet-stream"
+}
+
+// metadataKeyOfHeader returns the metadata key of an X-RPCX- header.
+func metadataKeyOfHeader(header string) (string, bool) {
+	header = http.CanonicalHeaderKey(header)
+	if reservedHeaders[header] || len(header) <= len(XMetaPrefix) || !strings.EqualFold(header[:len(XMetaPrefix)], XMetaPrefix) {
+		return "", false
+	}
+	return strings.ToLower(header[len(XMetaPrefix):]), true
+}
+
+// headerOfMetadataKey returns the X-RPCX- header of a metadata key.
+// Reserved keys starting with "__" and keys that are not valid in headers are not mapped.
+func headerOfMetadataKey(key string) (string, bool) {
+	if key == "" || strings.HasPrefix(key, "__") {
+		return "", false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", false
+		}
+	}
+	header := http.CanonicalHeaderKey(XMetaPrefix + key)
+	if reservedHeaders[header] {
+		return "", false
+	}
+	return header, true
+}
+
 // HTTPRequest2RpcxRequest converts a http request to a rpcx request.
 func HTTPRequest2RpcxRequest(r *http.Request) (*protocol.Message, error) {
 	req := protocol.GetPooledMsg()
@@ -56,6 +126,9 @@ func HTTPRequest2RpcxRequest(r *http.Request) (*protocol.Message, error) {
 			return nil, err
 		}
 		req.SetSerializeType(protocol.SerializeType(rst))
+	} else if rst, ok := SerializeTypeOfContentType(h.Get("Content-Type")); ok {
+		req.SetSerializeType(rst)
+		h.Set(XSerializeType, strconv.Itoa(int(rst)))
 	}
 
 	meta := h.Get(XMeta)
@@ -73,6 +146,16 @@ func HTTPRequest2RpcxRequest(r *http.Request) (*protocol.Message, error) {
 		req.Metadata = mm
 	}
 
+	// X-RPCX-Tenant: a is the metadata tenant=a
+	for k, v := range h {
+		if key, ok := metadataKeyOfHeader(k); ok && len(v) > 0 {
+			if req.Metadata == nil {
+				req.Metadata = make(map[string]string)
+			}
+			req.Metadata[key] = v[0]
+		}
+	}
+
 	auth := h.Get("Authorization")
 	if auth != "" {
 		if req.Metadata == nil {
@@ -85,7 +168,7 @@ func HTTPRequest2RpcxRequest(r *http.Request) (*protocol.Message, error) {
 
 	req.ServiceMethod = h.Get(XServiceMethod)
 
-	payload, err := ioutil.ReadAll(r.Body)
+	payload, err := readHTTPBody(r)
 	if err != nil {
 		return nil, err
 	}
@@ -95,6 +178,28 @@ func HTTPRequest2RpcxRequest(r *http.Request) (*protocol.Message, error) {
 	return req, nil
 }
 
+// readHTTPBody reads the body of r, which is decompressed while it is read if it is gzipped.
+// Bodies with a known length are read into a buffer of that length.
+func readHTTPBody(r *http.Request) ([]byte, error) {
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return ioutil.ReadAll(zr)
+	}
+
+	if r.ContentLength > 0 {
+		payload := make([]byte, r.ContentLength)
+		if _, err := io.ReadFull(r.Body, payload); err != nil {
+			return nil, err
+		}
+		return payload, nil
+	}
+	return ioutil.ReadAll(r.Body)
+}
+
 // func RpcxResponse2HttpResponse(res *protocol.Message) (url.Values, []byte, error) {
 // 	m := make(url.Values)
 // 	m.Set(XVersion, strconv.Itoa(int(res.Version())))
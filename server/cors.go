@@ -0,0 +1,307 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/smallnest/rpcx/log"
+)
+
+// corsPolicy is the compiled policy of CORSOptions.
+type corsPolicy struct {
+	options *CORSOptions
+
+	allOrigins bool
+	origins    map[string]bool
+	wildcards  []corsWildcard // origins with a wildcard
+
+	methods map[string]bool
+
+	allHeaders     bool
+	headers        map[string]bool
+	headerPrefixes []string // canonical prefixes of headers such as "X-Rpcx-" of "X-RPCX-*"
+
+	exposed         []string
+	exposedPrefixes []string
+}
+
+// corsWildcard matches origins such as "https://*.example.com".
+type corsWildcard struct {
+	prefix, suffix string
+}
+
+func (w corsWildcard) match(origin string) bool {
+	return len(origin) >= len(w.prefix)+len(w.suffix) && strings.HasPrefix(origin, w.prefix) && strings.HasSuffix(origin, w.suffix)
+}
+
+func newCORSPolicy(o *CORSOptions) *corsPolicy {
+	p := &corsPolicy{
+		options: o,
+		origins: make(map[string]bool),
+		methods: make(map[string]bool),
+		headers: make(map[string]bool),
+	}
+
+	if len(o.AllowedOrigins) == 0 && o.AllowOriginFunc == nil && o.AllowOriginRequestFunc == nil {
+		p.allOrigins = true
+	}
+	for _, origin := range o.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		if origin == "*" {
+			p.allOrigins = true
+		} else if i := strings.IndexByte(origin, '*'); i >= 0 {
+			p.wildcards = append(p.wildcards, corsWildcard{origin[:i], origin[i+1:]})
+		} else {
+			p.origins[origin] = true
+		}
+	}
+
+	methods := o.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodPost, http.MethodHead}
+	}
+	for _, m := range methods {
+		p.methods[strings.ToUpper(m)] = true
+	}
+
+	headers := o.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Accept", "Content-Type", "X-Requested-With"}
+	}
+	headers = append(headers, "Origin") // some browsers always request it
+	for _, h := range headers {
+		switch {
+		case h == "*":
+			p.allHeaders = true
+		case strings.HasSuffix(h, "*"):
+			p.headerPrefixes = append(p.headerPrefixes, http.CanonicalHeaderKey(strings.TrimSuffix(h, "*")))
+		default:
+			p.headers[http.CanonicalHeaderKey(h)] = true
+		}
+	}
+
+	for _, h := range o.ExposedHeaders {
+		if strings.HasSuffix(h, "*") && h != "*" {
+			p.exposedPrefixes = append(p.exposedPrefixes, http.CanonicalHeaderKey(strings.TrimSuffix(h, "*")))
+		} else {
+			p.exposed = append(p.exposed, http.CanonicalHeaderKey(h))
+		}
+	}
+	return p
+}
+
+func (p *corsPolicy) logf(format string, args ...interface{}) {
+	if p.options.Debug {
+		log.Debugf("rpcx: cors: "+format, args...)
+	}
+}
+
+// allowsOrigin reports whether requests of origin are allowed.
+func (p *corsPolicy) allowsOrigin(r *http.Request, origin string) bool {
+	if p.options.AllowOriginRequestFunc != nil {
+		return p.options.AllowOriginRequestFunc(r, origin)
+	}
+	if p.options.AllowOriginFunc != nil {
+		return p.options.AllowOriginFunc(origin)
+	}
+	if p.allOrigins {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if w.match(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *corsPolicy) allowsMethod(method string) bool {
+	method = strings.ToUpper(method)
+	return method == http.MethodOptions || p.methods[method]
+}
+
+func (p *corsPolicy) allowsHeader(header string) bool {
+	if p.allHeaders {
+		return true
+	}
+	header = http.CanonicalHeaderKey(header)
+	if p.headers[header] {
+		return true
+	}
+	for _, prefix := range p.headerPrefixes {
+		if strings.HasPrefix(header, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// setAllowOrigin allows origin to read responses. Origins are echoed unless all origins are allowed without credentials,
+// since browsers reject "*" of requests with credentials.
+func (p *corsPolicy) setAllowOrigin(h http.Header, origin string) {
+	if p.allOrigins && !p.options.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.options.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// handlePreflight sets headers of the preflight request r, and reports whether it is allowed.
+func (p *corsPolicy) handlePreflight(w http.ResponseWriter, r *http.Request) bool {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || !p.allowsOrigin(r, origin) {
+		p.logf("preflight of origin %q is not allowed", origin)
+		return false
+	}
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !p.allowsMethod(method) {
+		p.logf("preflight of method %q is not allowed", method)
+		return false
+	}
+	var headers []string
+	for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, header := range strings.Split(v, ",") {
+			if header = strings.TrimSpace(header); header == "" {
+				continue
+			}
+			if !p.allowsHeader(header) {
+				p.logf("preflight of header %q is not allowed", header)
+				return false
+			}
+			headers = append(headers, header)
+		}
+	}
+
+	p.setAllowOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", strings.ToUpper(method))
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if p.options.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(p.options.MaxAge))
+	}
+	return true
+}
+
+// handleActualRequest sets headers of the actual request r, and reports whether it is allowed.
+func (p *corsPolicy) handleActualRequest(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" || !p.allowsOrigin(r, origin) || !p.allowsMethod(r.Method) {
+		p.logf("request %s of origin %q is not allowed", r.Method, origin)
+		return false
+	}
+	p.setAllowOrigin(w.Header(), origin)
+	return true
+}
+
+// handler applies the policy to requests of next. Preflight requests are answered without calling next,
+// unless OptionsPassthrough is set.
+func (p *corsPolicy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			p.handlePreflight(w, r)
+			if p.options.OptionsPassthrough {
+				next.ServeHTTP(w, r)
+			} else {
+				w.WriteHeader(http.StatusOK)
+			}
+			return
+		}
+
+		if p.handleActualRequest(w, r) && (len(p.exposed) > 0 || len(p.exposedPrefixes) > 0) {
+			w = &corsResponseWriter{ResponseWriter: w, policy: p}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsResponseWriter exposes headers of the response when it is written,
+// so headers of prefixes, such as the X-RPCX- headers of metadata, are exposed.
+type corsResponseWriter struct {
+	http.ResponseWriter
+	policy      *corsPolicy
+	wroteHeader bool
+}
+
+func (w *corsResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		exposed := append([]string(nil), w.policy.exposed...)
+		for header := range w.Header() {
+			for _, prefix := range w.policy.exposedPrefixes {
+				if strings.HasPrefix(http.CanonicalHeaderKey(header), prefix) {
+					exposed = append(exposed, header)
+					break
+				}
+			}
+		}
+		if len(exposed) > 0 {
+			sort.Strings(exposed)
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *corsResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *corsResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Handler returns a handler applying the CORS options to requests of h.
+// Preflight requests are answered by the options without calling h, unless OptionsPassthrough is set.
+func (o *CORSOptions) Handler(h http.Handler) http.Handler {
+	return newCORSPolicy(o).handler(h)
+}
+
+// websocketOriginChecker returns the origin check of websocket upgrades.
+// Without CORS options any origin is allowed like before. With them, requests without Origin, such as those of
+// non-browser clients, and requests of the same origin, such as those of rpcx clients, are allowed,
+// and other origins must be allowed by the options.
+func (s *Server) websocketOriginChecker() func(r *http.Request) bool {
+	if s.corsOptions == nil {
+		return func(r *http.Request) bool { return true }
+	}
+	p := newCORSPolicy(s.corsOptions)
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		if p.allowsOrigin(r, origin) {
+			return true
+		}
+		log.Warnf("rpcx: websocket origin %q of %s is not allowed", origin, r.RemoteAddr)
+		return false
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+type corsService struct {
+	calls int32
+}
+
+func (s *corsService) Echo(ctx context.Context, args *Args, reply *Reply) error {
+	atomic.AddInt32(&s.calls, 1)
+	ctx.Value(share.ResMetaDataKey).(map[string]string)["served-by"] = "cors-test"
+	reply.C = args.A
+	return nil
+}
+
+func corsTestOptions() *CORSOptions {
+	return &CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{http.MethodPost},
+		AllowedHeaders:   []string{"Content-Type", "X-RPCX-*"},
+		ExposedHeaders:   []string{"X-RPCX-*"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+}
+
+func corsRequest(t *testing.T, method, url string, header http.Header) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(`{"A":3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestGatewayCORS(t *testing.T) {
+	s := NewServer()
+	svc := new(corsService)
+	s.RegisterName("CORS", svc, "")
+	s.SetCORS(corsTestOptions())
+	startServer(t, s, "tcp")
+	url := "http://" + s.Address().String() + "/CORS"
+
+	// preflight
+	res := corsRequest(t, http.MethodOptions, url, http.Header{
+		"Origin":                         {"https://a.example.org"},
+		"Access-Control-Request-Method":  {http.MethodPost},
+		"Access-Control-Request-Headers": {"content-type, x-rpcx-servicemethod, x-rpcx-tenant"},
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "https://a.example.org", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "POST", res.Header.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type, x-rpcx-servicemethod, x-rpcx-tenant", res.Header.Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "true", res.Header.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "600", res.Header.Get("Access-Control-Max-Age"))
+
+	// preflight of headers which are not allowed
+	res = corsRequest(t, http.MethodOptions, url, http.Header{
+		"Origin":                         {"https://app.example.com"},
+		"Access-Control-Request-Method":  {http.MethodPost},
+		"Access-Control-Request-Headers": {"x-secret"},
+	})
+	assert.Equal(t, "", res.Header.Get("Access-Control-Allow-Origin"))
+
+	// simple requests expose headers of metadata
+	res = corsRequest(t, http.MethodPost, url, http.Header{
+		"Origin":          {"https://app.example.com"},
+		"Content-Type":    {"application/json"},
+		XServiceMethod:    {"Echo"},
+		"X-Rpcx-Tenant":   {"acme"},
+		"X-Unrelated-Key": {"v"},
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "https://app.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", res.Header.Get("Access-Control-Allow-Credentials"))
+	exposed := res.Header.Get("Access-Control-Expose-Headers")
+	assert.Contains(t, exposed, "X-Rpcx-Served-By")
+	assert.Contains(t, exposed, "X-Rpcx-Meta")
+	assert.Equal(t, "cors-test", res.Header.Get("X-Rpcx-Served-By"))
+
+	// disallowed origins get no CORS headers
+	res = corsRequest(t, http.MethodOptions, url, http.Header{
+		"Origin":                        {"https://evil.example.com"},
+		"Access-Control-Request-Method": {http.MethodPost},
+	})
+	assert.Equal(t, "", res.Header.Get("Access-Control-Allow-Origin"))
+	res = corsRequest(t, http.MethodPost, url, http.Header{
+		"Origin":       {"https://evil.example.com"},
+		"Content-Type": {"application/json"},
+		XServiceMethod: {"Echo"},
+	})
+	assert.Equal(t, "", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", res.Header.Get("Access-Control-Expose-Headers"))
+
+	// preflight requests don't call services
+	assert.Equal(t, int32(2), atomic.LoadInt32(&svc.calls))
+}
+
+func TestCORSOriginFunc(t *testing.T) {
+	options := &CORSOptions{AllowOriginFunc: func(origin string) bool { return strings.HasSuffix(origin, ".test") }}
+	h := options.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for origin, allowed := range map[string]bool{"http://a.test": true, "http://a.example": false} {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Origin", origin)
+		w := &headerRecorder{header: make(http.Header)}
+		h.ServeHTTP(w, req)
+		if allowed {
+			assert.Equal(t, origin, w.header.Get("Access-Control-Allow-Origin"))
+		} else {
+			assert.Equal(t, "", w.header.Get("Access-Control-Allow-Origin"))
+		}
+	}
+
+	// all origins without credentials are "*"
+	h = AllowAllCORSOptions().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "http://a.example")
+	w := &headerRecorder{header: make(http.Header)}
+	h.ServeHTTP(w, req)
+	assert.Equal(t, "*", w.header.Get("Access-Control-Allow-Origin"))
+}
+
+type headerRecorder struct {
+	header http.Header
+	status int
+}
+
+func (w *headerRecorder) Header() http.Header         { return w.header }
+func (w *headerRecorder) Write(p []byte) (int, error) { return len(p), nil }
+func (w *headerRecorder) WriteHeader(status int)      { w.status = status }
+
+func TestWebsocketOrigin(t *testing.T) {
+	s := NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	s.SetCORS(corsTestOptions())
+	addr := startServer(t, s, "ws")
+	url := "ws://" + addr + share.DefaultRPCPath
+
+	_, res, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example.com"}})
+	assert.Error(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://app.example.com"}})
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+
+	// rpcx clients send the origin of the server
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("ws", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, reply))
+	assert.Equal(t, 6, reply.C)
+}
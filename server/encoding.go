@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// unsupportedCompressType returns the error of the request read by readRequest if its compress type is not registered,
+// which fails the request only because the framing of the connection is intact. It returns nil for other errors.
+func (s *Server) unsupportedCompressType(conn net.Conn, req *protocol.Message, err error) error {
+	if req == nil || !errors.Is(err, protocol.ErrUnsupportedCompressor) {
+		return nil
+	}
+	return s.unsupportedEncoding(conn, req, share.UnsupportedCompressType(req.CompressType()))
+}
+
+// unsupportedSerializeType returns the error of req whose serialize type has no codec.
+func (s *Server) unsupportedSerializeType(ctx context.Context, req *protocol.Message) error {
+	conn, _ := ctx.Value(RemoteConnContextKey).(net.Conn)
+	return s.unsupportedEncoding(conn, req, share.UnsupportedSerializeType(req.SerializeType()))
+}
+
+// SetServiceSerializePolicy restricts requests of the service servicePath to the serialize types allowed,
+// or removes the restriction if allowed is empty. Requests of other serialize types fail before their payloads
+// are decoded, with share.DisallowedSerializeType which matches errors.ErrUnsupportedEncoding, and are counted
+// as requests of unsupported encodings in Stats. It applies to raw handlers and functions of the service too.
+//
+// The first allowed type is the default serialize type of the service for gateway requests which have
+// neither X-RPCX-SerializeType nor a registered Content-Type, and no Accept header of an allowed serialize type.
+// Policies can be set while the server serves requests.
+func (s *Server) SetServiceSerializePolicy(servicePath string, allowed []protocol.SerializeType) {
+	s.serializePoliciesMu.Lock()
+	defer s.serializePoliciesMu.Unlock()
+	old, _ := s.serializePolicies.Load().(map[string][]protocol.SerializeType)
+	policies := make(map[string][]protocol.SerializeType, len(old)+1)
+	for path, types := range old {
+		policies[path] = types
+	}
+	if len(allowed) == 0 {
+		delete(policies, servicePath)
+	} else {
+		policies[servicePath] = append([]protocol.SerializeType(nil), allowed...)
+	}
+	s.serializePolicies.Store(policies)
+}
+
+// serializePolicy returns the serialize types allowed for servicePath, or nil if all are allowed.
+func (s *Server) serializePolicy(servicePath string) []protocol.SerializeType {
+	policies, _ := s.serializePolicies.Load().(map[string][]protocol.SerializeType)
+	return policies[servicePath]
+}
+
+// disallowedSerializeType returns the error of req if its serialize type is not allowed for its service.
+func (s *Server) disallowedSerializeType(ctx context.Context, req *protocol.Message) error {
+	allowed := s.serializePolicy(req.ServicePath)
+	if allowed == nil || containsSerializeType(allowed, req.SerializeType()) {
+		return nil
+	}
+	conn, _ := ctx.Value(RemoteConnContextKey).(net.Conn)
+	return s.unsupportedEncoding(conn, req, share.DisallowedSerializeType(req.SerializeType(), allowed))
+}
+
+// acceptedSerializeType returns the serialize type of responses of a gateway request of servicePath
+// which names no serialize type: the first media type of accept whose codec is registered and allowed,
+// or else the default serialize type of the service if it has a policy.
+func (s *Server) acceptedSerializeType(servicePath string, accept []string) (protocol.SerializeType, bool) {
+	allowed := s.serializePolicy(servicePath)
+	for _, values := range accept {
+		for _, value := range strings.Split(values, ",") {
+			mediaType, params, err := mime.ParseMediaType(value)
+			if err != nil || params["q"] == "0" {
+				continue
+			}
+			st, ok := share.SerializeTypeOfName(mediaType)
+			if ok && (allowed == nil || containsSerializeType(allowed, st)) {
+				return st, true
+			}
+		}
+	}
+	if allowed == nil {
+		return 0, false
+	}
+	return allowed[0], true
+}
+
+func containsSerializeType(types []protocol.SerializeType, t protocol.SerializeType) bool {
+	for _, st := range types {
+		if st == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) unsupportedEncoding(conn net.Conn, req *protocol.Message, err error) error {
+	atomic.AddUint64(&s.stats.unsupportedEncodings, 1)
+	remote := ""
+	if conn != nil {
+		remote = conn.RemoteAddr().String()
+	}
+	log.Warnf("rpcx: request %s.%s (seq %d) from %s fails: %v", req.ServicePath, req.ServiceMethod, req.Seq(), remote, err)
+	return err
+}
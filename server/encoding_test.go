@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"testing"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsupportedEncoding(t *testing.T) {
+	s := NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	startServer(t, s, "tcp")
+
+	conn := dialHeartbeat(t, s.Address().String())
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	protocol.Read(r) // heartbeat
+
+	call := func(serializeType protocol.SerializeType, compressType protocol.CompressType) *protocol.Message {
+		req := protocol.NewMessage()
+		req.SetSerializeType(serializeType)
+		req.ServicePath = "Arith"
+		req.ServiceMethod = "Mul"
+		req.Payload = []byte(`{"A":10,"B":20}`)
+		data := req.Encode()
+		data[2] = data[2]&^0x1C | byte(compressType)<<2 // not compressed by Encode
+		conn.Write(data)
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		res, err := protocol.Read(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := call(13, protocol.None)
+	assert.Equal(t, protocol.Error, res.MessageStatusType())
+	assert.Equal(t, "12", res.Metadata[protocol.ServiceErrorCode])
+	assert.Equal(t, share.UnsupportedSerializeType(13).Error(), res.Metadata[protocol.ServiceError])
+	assert.Contains(t, res.Metadata[protocol.ServiceError], "unsupported serialize type 13, supported: 0,1,2,3,4,5")
+	assert.Equal(t, "13", res.Metadata[protocol.ServiceErrorDetailPrefix+"serialize_type"])
+
+	res = call(protocol.JSON, 7)
+	assert.Equal(t, protocol.Error, res.MessageStatusType())
+	assert.Equal(t, "12", res.Metadata[protocol.ServiceErrorCode])
+	assert.Contains(t, res.Metadata[protocol.ServiceError], "unsupported compress type 7, supported: 0,1,2,3")
+	assert.Equal(t, "0,1,2,3", res.Metadata[protocol.ServiceErrorDetailPrefix+"supported_compress_types"])
+	assert.Equal(t, uint64(2), s.Stats().UnsupportedEncodings)
+
+	// the connection is still usable
+	res = call(protocol.JSON, protocol.None)
+	assert.Equal(t, protocol.Normal, res.MessageStatusType(), res.Metadata)
+	assert.Equal(t, `{"C":200}`, string(res.Payload))
+
+	assert.True(t, errors.Is(share.UnsupportedSerializeType(13), rerrors.ErrUnsupportedEncoding))
+}
+
+func TestServiceSerializePolicy(t *testing.T) {
+	s := NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	s.SetServiceSerializePolicy("Arith", []protocol.SerializeType{protocol.MsgPack, protocol.ProtoBuffer})
+	startServer(t, s, "tcp")
+
+	conn := dialHeartbeat(t, s.Address().String())
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	protocol.Read(r) // heartbeat
+
+	call := func() *protocol.Message {
+		req := protocol.NewMessage()
+		req.SetSerializeType(protocol.JSON)
+		req.ServicePath = "Arith"
+		req.ServiceMethod = "Mul"
+		req.Payload = []byte(`{"A":10,"B":20}`)
+		conn.Write(req.Encode())
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		res, err := protocol.Read(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := call()
+	assert.Equal(t, protocol.Error, res.MessageStatusType())
+	assert.Equal(t, "12", res.Metadata[protocol.ServiceErrorCode])
+	assert.Contains(t, res.Metadata[protocol.ServiceError], "unsupported serialize type 1, supported: 2,3")
+	assert.Equal(t, "1", res.Metadata[protocol.ServiceErrorDetailPrefix+"serialize_type"])
+	assert.Equal(t, "2,3", res.Metadata[protocol.ServiceErrorDetailPrefix+"supported_serialize_types"])
+	assert.Equal(t, uint64(1), s.Stats().UnsupportedEncodings)
+	assert.True(t, errors.Is(share.DisallowedSerializeType(protocol.JSON, nil), rerrors.ErrUnsupportedEncoding))
+
+	// policies are removed while serving
+	s.SetServiceSerializePolicy("Arith", nil)
+	res = call()
+	assert.Equal(t, protocol.Normal, res.MessageStatusType(), res.Metadata)
+	assert.Equal(t, `{"C":200}`, string(res.Payload))
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type errorService struct {
+	unavailable bool
+	calls       int32
+}
+
+func (s *errorService) Get(ctx context.Context, args *Args, reply *Reply) error {
+	atomic.AddInt32(&s.calls, 1)
+	if s.unavailable {
+		return Errorf(rerrors.Unavailable, "warming up")
+	}
+	switch args.A {
+	case 1:
+		return Errorf(rerrors.NotFound, "user %d not found", args.B).WithDetail("user", "42")
+	case 2:
+		return errors.New("plain error")
+	}
+	reply.C = args.A
+	return nil
+}
+
+func startErrorServer(t *testing.T, svc *errorService) *Server {
+	s := NewServer()
+	s.RegisterName("Err", svc, "")
+	startServer(t, s, "tcp")
+	return s
+}
+
+func TestTypedError(t *testing.T) {
+	svc := &errorService{}
+	s := startErrorServer(t, svc)
+	defer s.Close()
+
+	c := client.NewClient(client.DefaultOption)
+	assert.NoError(t, c.Connect("tcp", s.Address().String()))
+	defer c.Close()
+
+	err := c.Call(context.Background(), "Err", "Get", &Args{A: 1, B: 42}, &Reply{})
+	assert.Equal(t, "user 42 not found", err.Error())
+	assert.True(t, errors.Is(err, rerrors.ErrNotFound))
+	assert.False(t, errors.Is(err, rerrors.ErrInternal))
+	var se *client.CodedServiceError
+	if assert.True(t, errors.As(err, &se)) {
+		assert.Equal(t, int(rerrors.NotFound), se.Code())
+		assert.Equal(t, map[string]string{"user": "42"}, se.Details())
+	}
+
+	// plain errors work as before
+	err = c.Call(context.Background(), "Err", "Get", &Args{A: 2}, &Reply{})
+	assert.Equal(t, client.ServiceError("plain error"), err)
+	assert.True(t, err == client.ServiceError("plain error"))
+}
+
+func TestTypedErrorFailover(t *testing.T) {
+	down, up := &errorService{unavailable: true}, &errorService{}
+	s1, s2 := startErrorServer(t, down), startErrorServer(t, up)
+	defer s1.Close()
+	defer s2.Close()
+
+	d, _ := client.NewMultipleServersDiscovery([]*client.KVPair{
+		{Key: "tcp@" + s1.Address().String()},
+		{Key: "tcp@" + s2.Address().String()},
+	})
+	opt := client.DefaultOption
+	opt.GenBreaker = func() client.Breaker { return client.NewConsecCircuitBreaker(2, time.Minute) }
+	xc := client.NewXClient("Err", client.Failover, client.RoundRobin, d, opt)
+	defer xc.Close()
+
+	// unavailable servers are failed over
+	for i := 0; i < 4; i++ {
+		reply := &Reply{}
+		assert.NoError(t, xc.Call(context.Background(), "Get", &Args{A: 3}, reply))
+		assert.Equal(t, 3, reply.C)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&down.calls), "the breaker opens after 2 failures")
+
+	// other codes are returned without retries and don't open the breaker
+	calls := atomic.LoadInt32(&up.calls)
+	for i := 0; i < 3; i++ {
+		err := xc.Call(context.Background(), "Get", &Args{A: 1}, &Reply{})
+		assert.True(t, errors.Is(err, rerrors.ErrNotFound))
+	}
+	assert.Equal(t, calls+3, atomic.LoadInt32(&up.calls))
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/rpcx/share"
+)
+
+// DefaultFileTokenTTL is the default FileTransfer.TokenTTL.
+const DefaultFileTokenTTL = 10 * time.Minute
+
+var (
+	// ErrResumeNotSupported is the error of resumable transfers which the FileTransfer doesn't handle.
+	ErrResumeNotSupported = errors.New("rpcx: resumable file transfers are not supported")
+	// ErrUnknownFileToken is the error of tokens which are not, or no longer, tokens of resumable uploads.
+	ErrUnknownFileToken = errors.New("rpcx: unknown token of the file transfer")
+)
+
+// ReceivedFileHandler handles a file of a resumable upload, which is verified and saved at path.
+// The file is removed after it returns, unless it has been moved. Errors fail the upload.
+type ReceivedFileHandler func(path string, args *share.FileTransferArgs) error
+
+// OpenFileHandler opens the file of a resumable download, which is sent from the offset the client has received.
+type OpenFileHandler func(args *share.DownloadFileArgs) (io.ReadSeekCloser, error)
+
+// resumableTransfer is a transfer by a token of Resumable args, which is kept until it completes or its token expires.
+type resumableTransfer struct {
+	token    string
+	upload   *share.FileTransferArgs
+	download *share.DownloadFileArgs
+
+	lock   chan struct{} // held by the connection handling the transfer
+	offset int64         // committed bytes of the upload
+	file   *os.File      // partial file of the upload
+
+	mu     sync.Mutex
+	conn   net.Conn // the latest connection of the transfer
+	active time.Time
+	done   bool // completed or expired
+}
+
+// FileOffset returns the offset committed by the resumable upload of args.Token, from which it resumes.
+func (s *FileTransferService) FileOffset(ctx context.Context, args *share.FileOffsetArgs, reply *share.FileOffsetReply) error {
+	t := s.FileTransfer.transfer(string(args.Token))
+	if t == nil || t.upload == nil {
+		return ErrUnknownFileToken
+	}
+	reply.Offset = atomic.LoadInt64(&t.offset)
+	return nil
+}
+
+func (s *FileTransfer) tokenTTL() time.Duration {
+	if s.TokenTTL > 0 {
+		return s.TokenTTL
+	}
+	return DefaultFileTokenTTL
+}
+
+func (s *FileTransfer) addTransfer(t *resumableTransfer) {
+	t.lock = make(chan struct{}, 1)
+	t.active = time.Now()
+	s.transfersMu.Lock()
+	s.transfers[t.token] = t
+	s.transfersMu.Unlock()
+}
+
+func (s *FileTransfer) transfer(token string) *resumableTransfer {
+	s.transfersMu.Lock()
+	defer s.transfersMu.Unlock()
+	return s.transfers[token]
+}
+
+// removeTransfer removes t and its partial file.
+func (s *FileTransfer) removeTransfer(t *resumableTransfer) {
+	s.transfersMu.Lock()
+	delete(s.transfers, t.token)
+	s.transfersMu.Unlock()
+
+	t.mu.Lock()
+	t.done = true
+	f := t.file
+	t.file = nil
+	t.mu.Unlock()
+	if f != nil {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// expireTransfers removes transfers without connections for TokenTTL.
+func (s *FileTransfer) expireTransfers() {
+	ttl := s.tokenTTL()
+	ticker := time.NewTicker(ttl/2 + time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		var expired []*resumableTransfer
+		s.transfersMu.Lock()
+		for _, t := range s.transfers {
+			t.mu.Lock()
+			if !t.done && t.conn == nil && time.Since(t.active) > ttl {
+				t.done = true
+				expired = append(expired, t)
+			}
+			t.mu.Unlock()
+		}
+		s.transfersMu.Unlock()
+		for _, t := range expired {
+			s.removeTransfer(t)
+		}
+	}
+}
+
+// acquire makes conn the connection of t. The previous connection is closed, since a resumed transfer
+// may be connected before its broken connection is noticed, and conn waits for its handler to return.
+func (t *resumableTransfer) acquire(conn net.Conn) bool {
+	t.mu.Lock()
+	if t.conn != nil {
+		t.conn.Close()
+	}
+	t.conn = conn
+	t.mu.Unlock()
+
+	t.lock <- struct{}{}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		<-t.lock
+		return false
+	}
+	return true
+}
+
+func (t *resumableTransfer) release(conn net.Conn) {
+	t.mu.Lock()
+	if t.conn == conn {
+		t.conn = nil
+	}
+	t.active = time.Now()
+	t.mu.Unlock()
+	<-t.lock
+}
+
+func (s *FileTransfer) handleResumable(conn net.Conn, t *resumableTransfer) {
+	defer conn.Close()
+	if !t.acquire(conn) {
+		return
+	}
+	defer t.release(conn)
+
+	var err error
+	if t.upload != nil {
+		err = s.receiveFile(conn, t)
+	} else {
+		err = s.sendFile(conn, t)
+	}
+	if err != nil {
+		logger.Debug(context.Background(), "resumable file transfer is interrupted", "remote", conn.RemoteAddr(), "error", err)
+	}
+}
+
+// receiveFile receives the upload of t from its committed offset.
+func (s *FileTransfer) receiveFile(conn net.Conn, t *resumableTransfer) error {
+	ttl := s.tokenTTL()
+	conn.SetDeadline(time.Now().Add(ttl))
+	if t.file == nil {
+		dir := s.TempDir
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		f, err := os.OpenFile(filepath.Join(dir, "rpcx-upload-"+hex.EncodeToString([]byte(t.token))+".part"), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o600)
+		if err != nil {
+			s.removeTransfer(t)
+			return err
+		}
+		t.mu.Lock()
+		t.file = f
+		t.mu.Unlock()
+	}
+
+	// the committed bytes are hashed again by every connection, which also moves the file to the offset
+	h := sha256.New()
+	offset := atomic.LoadInt64(&t.offset)
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(h, t.file, offset); err != nil {
+		return err
+	}
+	if err := share.WriteFileOffset(conn, offset); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	buf := make([]byte, share.MaxFileChunkSize)
+	for {
+		conn.SetDeadline(time.Now().Add(ttl))
+		p, end, err := share.ReadFileFrame(r, buf)
+		if err != nil {
+			// bytes of broken chunks are not committed, and are sent again by the resumed transfer
+			return err
+		}
+		if end {
+			return s.completeFile(conn, t, h, p)
+		}
+		if _, err := t.file.Write(p); err != nil {
+			s.removeTransfer(t)
+			return share.WriteFileStatus(conn, err)
+		}
+		h.Write(p)
+		atomic.AddInt64(&t.offset, int64(len(p)))
+	}
+}
+
+// completeFile verifies the upload of t by its size and sum, and hands it to ReceivedFileHandler.
+func (s *FileTransfer) completeFile(conn net.Conn, t *resumableTransfer, h hash.Hash, sum []byte) error {
+	var err error
+	if atomic.LoadInt64(&t.offset) != t.upload.FileSize || !bytes.Equal(h.Sum(nil), sum) {
+		err = share.ErrFileChecksum
+	}
+
+	t.mu.Lock()
+	f := t.file
+	t.file = nil
+	t.mu.Unlock()
+	path := f.Name()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = s.ReceivedFileHandler(path, t.upload)
+	}
+	os.Remove(path)
+	s.removeTransfer(t)
+
+	conn.SetDeadline(time.Now().Add(s.tokenTTL()))
+	return share.WriteFileStatus(conn, err)
+}
+
+// sendFile sends the download of t from the offset the client has received.
+func (s *FileTransfer) sendFile(conn net.Conn, t *resumableTransfer) error {
+	ttl := s.tokenTTL()
+	conn.SetDeadline(time.Now().Add(ttl))
+	offset, err := share.ReadFileOffset(conn)
+	if err != nil {
+		return err
+	}
+	f, err := s.OpenFileHandler(t.download)
+	if err != nil {
+		s.removeTransfer(t)
+		return err
+	}
+	defer f.Close()
+
+	// the bytes the client has are hashed for the sum of the whole file
+	h := sha256.New()
+	if _, err = io.CopyN(h, f, offset); err != nil {
+		s.removeTransfer(t)
+		return err
+	}
+
+	w := bufio.NewWriterSize(conn, 64*1024)
+	buf := make([]byte, 32*1024)
+	for {
+		n, er := f.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			conn.SetDeadline(time.Now().Add(ttl))
+			if err = share.WriteFileChunk(w, buf[:n]); err != nil {
+				return err
+			}
+		}
+		if er == io.EOF {
+			break
+		}
+		if er != nil {
+			s.removeTransfer(t)
+			return er
+		}
+	}
+	if err = share.WriteFileEnd(w, h.Sum(nil)); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	// the transfer is kept if the connection breaks before the client answers
+	conn.SetDeadline(time.Now().Add(ttl))
+	err = share.ReadFileStatus(conn)
+	if err == nil || errors.Is(err, share.ErrFileTransfer) || errors.Is(err, share.ErrFileChecksum) {
+		s.removeTransfer(t)
+	}
+	return err
+}
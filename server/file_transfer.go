@@ -33,13 +33,27 @@ type downloadTokenInfo struct {
 // It registers a file transfer service and listens a on the given port.
 // Clients will invokes this service to get the token and send the token and the file to this port.
 type FileTransfer struct {
-	Addr                string
-	AdvertiseAddr       string
+	Addr          string
+	AdvertiseAddr string
+
+	// ReceivedFileHandler handles files of resumable uploads. Resumable uploads are not supported if it is nil.
+	ReceivedFileHandler ReceivedFileHandler
+	// OpenFileHandler opens files of resumable downloads. Resumable downloads are not supported if it is nil.
+	OpenFileHandler OpenFileHandler
+	// TempDir is the directory of partial files of resumable uploads, os.TempDir() if it is empty.
+	TempDir string
+	// TokenTTL is how long tokens of resumable transfers are kept without connections, DefaultFileTokenTTL if it is zero.
+	// Partial files of expired uploads are removed.
+	TokenTTL time.Duration
+
 	handler             FileTransferHandler
 	downloadFileHandler DownloadFileHandler
 	cachedTokens        *lru.Cache
 	service             *FileTransferService
 
+	transfersMu sync.Mutex
+	transfers   map[string]*resumableTransfer
+
 	startOnce sync.Once
 
 	done chan struct{}
@@ -58,6 +72,7 @@ func NewFileTransfer(addr string, handler FileTransferHandler, downloadFileHandl
 		handler:             handler,
 		downloadFileHandler: downloadFileHandler,
 		cachedTokens:        cachedTokens,
+		transfers:           make(map[string]*resumableTransfer),
 	}
 
 	fi.service = &FileTransferService{
@@ -91,6 +106,13 @@ func (s *FileTransferService) TransferFile(ctx context.Context, args *share.File
 		reply.Addr = s.FileTransfer.AdvertiseAddr
 	}
 
+	if args.Resumable {
+		if s.FileTransfer.ReceivedFileHandler == nil {
+			return ErrResumeNotSupported
+		}
+		s.FileTransfer.addTransfer(&resumableTransfer{token: string(token), upload: args})
+		return nil
+	}
 	s.FileTransfer.cachedTokens.Add(string(token), &tokenInfo{token, args})
 	return nil
 }
@@ -111,6 +133,13 @@ func (s *FileTransferService) DownloadFile(ctx context.Context, args *share.Down
 		reply.Addr = s.FileTransfer.AdvertiseAddr
 	}
 
+	if args.Resumable {
+		if s.FileTransfer.OpenFileHandler == nil {
+			return ErrResumeNotSupported
+		}
+		s.FileTransfer.addTransfer(&resumableTransfer{token: string(token), download: args})
+		return nil
+	}
 	s.FileTransfer.cachedTokens.Add(string(token), &downloadTokenInfo{token, args})
 	return nil
 }
@@ -118,6 +147,7 @@ func (s *FileTransferService) DownloadFile(ctx context.Context, args *share.Down
 func (s *FileTransfer) Start() error {
 	s.startOnce.Do(func() {
 		go s.start()
+		go s.expireTransfers()
 	})
 
 	return nil
@@ -172,6 +202,10 @@ func (s *FileTransfer) start() error {
 			}
 
 			tokenStr := string(token)
+			if t := s.transfer(tokenStr); t != nil {
+				go s.handleResumable(conn, t)
+				continue
+			}
 			info, ok := s.cachedTokens.Get(tokenStr)
 			if !ok {
 				conn.Close()
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soheilhy/cmux"
+)
+
+var errTooManyPendingConns = errors.New("too many connections are waiting for their first requests")
+
+// WithFirstRequestTimeout closes connections which don't deliver complete and valid first messages,
+// such as requests, heartbeats or negotiations, within d after they are accepted. It bounds clients which open
+// connections and write them slowly or never, including the sniffing of the gateway, the TLS handshake
+// and the exchange of session keys. Closes are counted in Stats.ConnsTimedOut.
+//
+// The PROXY protocol header of WithProxyProtocol is read before connections are accepted, within its HeaderTimeout
+// and at most half of d. The TLS handshake has its own budget, see WithTLSHandshakeTimeout.
+// It applies to connections served by rpcx and the gateway, but not to the listeners of http, ws, wss and h2c networks.
+func WithFirstRequestTimeout(d time.Duration) OptionFn {
+	return func(s *Server) {
+		s.runtime().FirstRequestTimeout = d
+	}
+}
+
+// WithTLSHandshakeTimeout bounds the TLS handshake of connections by d within the window of WithFirstRequestTimeout.
+// If it is not set, the TLS handshake may take half of the window of WithFirstRequestTimeout.
+func WithTLSHandshakeTimeout(d time.Duration) OptionFn {
+	return func(s *Server) {
+		s.runtime().TLSHandshakeTimeout = d
+	}
+}
+
+// WithMaxPendingConnections limits the number of connections which have not delivered their first messages,
+// including the ones whose PROXY protocol headers are being read, so that slow clients can't exhaust the memory
+// of the server within the window of WithFirstRequestTimeout. Connections over the limit are closed as soon
+// as they are accepted without reject frames, and counted in Stats.ConnsRejected. Zero means no limit.
+func WithMaxPendingConnections(n int) OptionFn {
+	return func(s *Server) {
+		s.runtime().MaxPendingConnections = n
+	}
+}
+
+// beginPending counts a pending connection. It returns false if there are max pending connections already.
+func (s *Server) beginPending(max int) bool {
+	if n := atomic.AddInt64(&s.stats.pendingConns, 1); max > 0 && n > int64(max) {
+		atomic.AddInt64(&s.stats.pendingConns, -1)
+		return false
+	}
+	return true
+}
+
+func (s *Server) endPending() {
+	atomic.AddInt64(&s.stats.pendingConns, -1)
+}
+
+// pendingConn returns conn which is pending until its first message is read, if first messages are bounded.
+// It returns nil if conn is rejected by WithMaxPendingConnections.
+func (s *Server) pendingConn(conn net.Conn) net.Conn {
+	settings := s.runtime()
+	if settings.FirstRequestTimeout == 0 && settings.TLSHandshakeTimeout == 0 && settings.MaxPendingConnections == 0 {
+		return conn
+	}
+	if !s.beginPending(settings.MaxPendingConnections) {
+		s.dropConn(conn, RejectReasonMaxPendingConnections, errTooManyPendingConns)
+		return nil
+	}
+
+	now := time.Now()
+	pc := &pendingConn{Conn: conn, s: s, state: pendingRequest}
+	if d := settings.FirstRequestTimeout; d != 0 {
+		pc.window = now.Add(d)
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		pc.tls, pc.state = tlsConn, pendingTLS
+		if d := settings.TLSHandshakeTimeout; d != 0 {
+			pc.tlsDeadline = now.Add(d)
+		} else if d := settings.FirstRequestTimeout; d != 0 {
+			pc.tlsDeadline = now.Add(d / 2)
+		}
+	}
+	conn.SetReadDeadline(pc.limitLocked())
+	return pc
+}
+
+// pendingOf returns the pending connection under conn, or nil if there is none.
+func pendingOf(conn net.Conn) *pendingConn {
+	for {
+		switch c := conn.(type) {
+		case *pendingConn:
+			return c
+		case *cmux.MuxConn:
+			conn = c.Conn
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil
+		}
+	}
+}
+
+// States of pending connections.
+const (
+	pendingTLS     int32 = iota // the TLS handshake is not completed
+	pendingRequest              // the first message is not read
+	pendingDone                 // the first message is read, or the connection is closed
+)
+
+// pendingConn is a connection waiting for its first message. Read deadlines set on it are limited
+// by the deadline of its current phase, so reads of the gateway, TLS and session handshakes and requests time out by it.
+// Its methods can be called on nil, which means a connection which is not pending.
+type pendingConn struct {
+	net.Conn
+	s   *Server
+	tls *tls.Conn // Conn if it is a TLS connection
+
+	mu           sync.Mutex
+	state        int32     // changed with mu held, and read atomically by Read
+	window       time.Time // the deadline of the first message, zero if there is none
+	tlsDeadline  time.Time // the deadline of the TLS handshake, zero if there is none
+	readDeadline time.Time // the read deadline set by readers
+}
+
+// NetConn returns the underlying connection.
+func (c *pendingConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *pendingConn) Read(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.state) == pendingTLS { // so that the handshake is bounded by its own deadline
+		if err := c.tls.Handshake(); err != nil {
+			return 0, err
+		}
+		c.handshaken()
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *pendingConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	return c.SetReadDeadline(t)
+}
+
+func (c *pendingConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(earliest(t, c.limitLocked()))
+}
+
+func (c *pendingConn) Close() error {
+	c.mu.Lock()
+	if c.state != pendingDone {
+		if reason := c.expiredLocked(); reason != "" {
+			c.s.stats.timeOutConn(reason)
+		}
+		atomic.StoreInt32(&c.state, pendingDone)
+		c.s.endPending()
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// handshaken ends the TLS handshake phase of c.
+func (c *pendingConn) handshaken() {
+	c.advance(pendingRequest)
+}
+
+// done ends the pending state of c once its first message is read.
+func (c *pendingConn) done() {
+	c.advance(pendingDone)
+}
+
+func (c *pendingConn) advance(state int32) {
+	if c == nil || atomic.LoadInt32(&c.state) >= state {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state >= state {
+		return
+	}
+	atomic.StoreInt32(&c.state, state)
+	c.Conn.SetReadDeadline(earliest(c.readDeadline, c.limitLocked()))
+	if state == pendingDone {
+		c.s.endPending()
+	}
+}
+
+// timeoutReason returns the reason to close c for a timeout, which is reason unless the phase of c has expired.
+func (c *pendingConn) timeoutReason(reason string) string {
+	if c == nil {
+		return reason
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r := c.expiredLocked(); r != "" {
+		return r
+	}
+	return reason
+}
+
+// expiredLocked returns the reason to close c if the deadline of its phase has passed, or an empty string.
+func (c *pendingConn) expiredLocked() string {
+	now := time.Now()
+	if c.state == pendingTLS && !c.tlsDeadline.IsZero() && !now.Before(c.tlsDeadline) &&
+		(c.window.IsZero() || c.tlsDeadline.Before(c.window)) {
+		return CloseReasonTLSHandshakeTimeout
+	}
+	if c.state != pendingDone && !c.window.IsZero() && !now.Before(c.window) {
+		return CloseReasonFirstRequestTimeout
+	}
+	return ""
+}
+
+// limitLocked returns the deadline of the current phase of c, or zero if there is none.
+func (c *pendingConn) limitLocked() time.Time {
+	switch c.state {
+	case pendingTLS:
+		return earliest(c.tlsDeadline, c.window)
+	case pendingRequest:
+		return c.window
+	}
+	return time.Time{}
+}
+
+// earliest returns the earlier of deadlines a and b, where zero means no deadline.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || !b.IsZero() && b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// pendingListener accepts connections of ln as pending connections, so the sniffing of the gateway is bounded too.
+type pendingListener struct {
+	net.Listener
+	s *Server
+}
+
+func (l *pendingListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if conn = l.s.pendingConn(conn); conn != nil {
+			return conn, nil
+		}
+	}
+}
+
+// sniffedListener accepts connections that the gateway sniffs for other protocols than rpcx, such as HTTP,
+// which are not pending once they are sniffed.
+type sniffedListener struct {
+	net.Listener
+}
+
+func (l sniffedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		pendingOf(conn).done()
+	}
+	return conn, err
+}
+
+// sniffedMux is the cmux of the gateway for CMuxPlugins, whose connections are sniffed ones.
+type sniffedMux struct {
+	cmux.CMux
+}
+
+func (m sniffedMux) Match(matchers ...cmux.Matcher) net.Listener {
+	return sniffedListener{m.CMux.Match(matchers...)}
+}
+
+func (m sniffedMux) MatchWithWriters(matchers ...cmux.MatchWriter) net.Listener {
+	return sniffedListener{m.CMux.MatchWithWriters(matchers...)}
+}
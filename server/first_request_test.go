@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitClosed waits for the server to close conn.
+func waitClosed(t *testing.T, conn net.Conn, timeout time.Duration) time.Duration {
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(timeout))
+	_, err := conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Errorf("expect conn closed within %v", timeout)
+	}
+	return time.Since(start)
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestFirstRequestTimeout(t *testing.T) {
+	s, recorder := startTimeoutServer(t, WithFirstRequestTimeout(300*time.Millisecond), WithIdleTimeout(5*time.Second))
+	defer s.Close()
+	addr := s.Address().String()
+
+	// silent connections are closed while the gateway sniffs them
+	silent := dial(t, addr)
+	assert.Less(t, int64(waitClosed(t, silent, 2*time.Second)), int64(time.Second))
+
+	// slow writers are closed even if they keep writing
+	slow := dial(t, addr)
+	go func() {
+		for _, b := range heartbeatData() {
+			time.Sleep(50 * time.Millisecond)
+			if _, err := slow.Write([]byte{b}); err != nil {
+				return
+			}
+		}
+	}()
+	recorder.wait(t, CloseReasonFirstRequestTimeout, 2*time.Second)
+	waitClosed(t, slow, time.Second)
+
+	// connections which have sent their first messages in time are served past the window
+	conn := dial(t, addr)
+	r := bufio.NewReader(conn)
+	conn.Write(heartbeatData())
+	_, err := protocol.Read(r)
+	assert.NoError(t, err)
+	time.Sleep(400 * time.Millisecond)
+	conn.Write(heartbeatData())
+	_, err = protocol.Read(r)
+	assert.NoError(t, err)
+
+	stats := s.Stats()
+	assert.Equal(t, uint64(2), stats.ConnsTimedOut[CloseReasonFirstRequestTimeout])
+	assert.Equal(t, uint64(0), stats.ConnsTimedOut[CloseReasonTLSHandshakeTimeout])
+	assert.Equal(t, int64(0), stats.PendingConnections)
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	config := &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t, pkix.Name{CommonName: "server"})}}
+	s := NewServer(WithTLSConfig(config), WithFirstRequestTimeout(time.Second), WithTLSHandshakeTimeout(200*time.Millisecond))
+	s.RegisterName("Arith", new(Arith), "")
+	addr := startServer(t, s, "tcp")
+
+	// the handshake of the gateway times out before the window of the first request
+	silent := dial(t, addr)
+	slow := dial(t, addr)
+	slow.Write([]byte{0x16, 0x03, 0x01}) // a part of the record header of ClientHello
+	assert.Less(t, int64(waitClosed(t, silent, 2*time.Second)), int64(800*time.Millisecond))
+	waitClosed(t, slow, time.Second)
+
+	opt := client.DefaultOption
+	opt.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	time.Sleep(300 * time.Millisecond) // past the deadline of the handshake
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, reply))
+	time.Sleep(time.Second) // and past the window of the first request
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, reply))
+	assert.Equal(t, 6, reply.C)
+
+	// so does the handshake of connections served without the gateway
+	recorder := &closeReasonRecorder{reasons: make(chan string, 10)}
+	s2 := NewServer(WithFirstRequestTimeout(time.Second), WithTLSHandshakeTimeout(200*time.Millisecond))
+	s2.Plugins.Add(recorder)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s2.ServeListener("tls", tls.NewListener(ln, config))
+	defer s2.Close()
+	time.Sleep(100 * time.Millisecond)
+	dial(t, ln.Addr().String())
+	recorder.wait(t, CloseReasonTLSHandshakeTimeout, 2*time.Second)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint64(2), s.Stats().ConnsTimedOut[CloseReasonTLSHandshakeTimeout])
+	assert.Equal(t, uint64(1), s2.Stats().ConnsTimedOut[CloseReasonTLSHandshakeTimeout])
+	assert.Equal(t, int64(0), s.Stats().PendingConnections)
+}
+
+func TestFirstRequestTimeoutProxyProtocol(t *testing.T) {
+	var s *Server
+	addr := startProxyServer(t, WithProxyProtocol(ProxyProtocolPolicy{Mode: ProxyProtocolRequired, HeaderTimeout: 5 * time.Second}),
+		WithFirstRequestTimeout(400*time.Millisecond), func(srv *Server) { s = srv })
+
+	// the header is read within half of the window
+	slow := dial(t, addr)
+	slow.Write([]byte("PROXY TCP4 192.0.2.1 "))
+	assert.Less(t, int64(waitClosed(t, slow, 2*time.Second)), int64(400*time.Millisecond))
+	assert.Equal(t, uint64(1), s.Stats().ConnsRejected[RejectReasonProxyHeaderTimeout])
+
+	// and the window starts after the header
+	silent := dial(t, addr)
+	silent.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"))
+	waitClosed(t, silent, 2*time.Second)
+	assert.Equal(t, uint64(1), s.Stats().ConnsTimedOut[CloseReasonFirstRequestTimeout])
+
+	c, err := connectProxied(t, addr, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := remoteAddr(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:56324", remote)
+}
+
+func TestMaxPendingConnections(t *testing.T) {
+	s, _ := startTimeoutServer(t, WithMaxPendingConnections(2))
+	defer s.Close()
+	addr := s.Address().String()
+
+	first, second := dial(t, addr), dial(t, addr)
+	for i := 0; i < 50 && s.Stats().PendingConnections < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(2), s.Stats().PendingConnections)
+
+	// connections over the limit are closed at once
+	third := dial(t, addr)
+	waitClosed(t, third, time.Second)
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", addr); err == nil {
+		assert.Error(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, &Reply{}))
+		c.Close()
+	}
+	assert.Equal(t, uint64(2), s.Stats().ConnsRejected[RejectReasonMaxPendingConnections])
+
+	// connections are not pending once their first messages are read
+	first.Write(heartbeatData())
+	_, err := protocol.Read(bufio.NewReader(first))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), s.Stats().PendingConnections)
+	c = client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, reply))
+	assert.Equal(t, 6, reply.C)
+	c.Close()
+
+	// and closed connections are not pending
+	second.Close()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(0), s.Stats().PendingConnections)
+
+	// limits are runtime settings
+	settings := s.Settings()
+	assert.NoError(t, json.Unmarshal([]byte(`{"max_pending_connections":0,"first_request_timeout":"200ms"}`), &settings))
+	assert.NoError(t, s.ApplySettings(settings))
+	for i := 0; i < 3; i++ {
+		dial(t, addr)
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int64(3), s.Stats().PendingConnections)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int64(0), s.Stats().PendingConnections)
+	assert.Equal(t, uint64(3), s.Stats().ConnsTimedOut[CloseReasonFirstRequestTimeout])
+}
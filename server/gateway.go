@@ -1,17 +1,19 @@
 package server
 
 import (
+	"compress/gzip"
 	"context"
 	"errors"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
-	"github.com/rs/cors"
+	rerrors "github.com/smallnest/rpcx/errors"
 	"github.com/smallnest/rpcx/log"
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/share"
@@ -24,22 +26,23 @@ func (s *Server) startGateway(network string, ln net.Listener) net.Listener {
 		return ln
 	}
 
-	m := cmux.New(ln)
+	// connections are pending while they are sniffed, and ones of other protocols are not pending once they are sniffed
+	m := cmux.New(&pendingListener{Listener: ln, s: s})
 
 	rpcxLn := m.Match(rpcxPrefixByteMatcher())
 
 	// mux Plugins
 	if s.Plugins != nil {
-		s.Plugins.MuxMatch(m)
+		s.Plugins.MuxMatch(sniffedMux{m})
 	}
 
 	if !s.DisableJSONRPC {
-		jsonrpc2Ln := m.Match(cmux.HTTP1HeaderField("X-JSONRPC-2.0", "true"))
+		jsonrpc2Ln := sniffedListener{m.Match(cmux.HTTP1HeaderField("X-JSONRPC-2.0", "true"))}
 		go s.startJSONRPC2(jsonrpc2Ln)
 	}
 
 	if !s.DisableHTTPGateway {
-		httpLn := m.Match(cmux.HTTP1Fast())
+		httpLn := sniffedListener{m.Match(cmux.HTTP1Fast())}
 		go s.startHTTP1APIGateway(httpLn)
 	}
 
@@ -63,16 +66,25 @@ func (s *Server) startHTTP1APIGateway(ln net.Listener) {
 	router.GET("/*servicePath", s.handleGatewayRequest)
 	router.PUT("/*servicePath", s.handleGatewayRequest)
 
+	var handler http.Handler = router
+	if path := s.jsonrpc.path; path != "" {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == path && r.Method == http.MethodPost {
+				s.jsonrpcHandler(w, r)
+				return
+			}
+			router.ServeHTTP(w, r)
+		})
+	}
+
 	if s.corsOptions != nil {
-		opt := cors.Options(*s.corsOptions)
-		c := cors.New(opt)
-		mux := c.Handler(router)
+		mux := s.corsOptions.Handler(handler)
 		s.mu.Lock()
 		s.gatewayHTTPServer = &http.Server{Handler: mux}
 		s.mu.Unlock()
 	} else {
 		s.mu.Lock()
-		s.gatewayHTTPServer = &http.Server{Handler: router}
+		s.gatewayHTTPServer = &http.Server{Handler: handler}
 		s.mu.Unlock()
 	}
 
@@ -99,57 +111,60 @@ func (s *Server) handleGatewayRequest(w http.ResponseWriter, r *http.Request, pa
 	ctx := share.WithValue(r.Context(), RemoteConnContextKey, r.RemoteAddr) // notice: It is a string, different with TCP (net.Conn)
 	err := s.Plugins.DoPreReadRequest(ctx)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeGatewayError(w, r, nil, err, http.StatusInternalServerError)
 		return
 	}
 
+	headerMeta := MetadataOfHeaders(r.Header, s.gatewayHeaders)
+	SetMetadataHeaders(w.Header(), headerMeta, s.gatewayHeaders)
+
 	if r.Header.Get(XServicePath) == "" {
 		servicePath := params.ByName("servicePath")
 		servicePath = strings.TrimPrefix(servicePath, "/")
 		r.Header.Set(XServicePath, servicePath)
 	}
-	servicePath := r.Header.Get(XServicePath)
-	wh := w.Header()
+
+	if maxSize := s.runtime().MaxMessageSize; maxSize > 0 {
+		if r.ContentLength > int64(maxSize) {
+			writeGatewayError(w, r, nil, errGatewayBodyTooLarge, 0)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, int64(maxSize))
+	}
 	req, err := HTTPRequest2RpcxRequest(r)
 	defer protocol.FreeMsg(req)
-
-	// set headers
-	wh.Set(XVersion, r.Header.Get(XVersion))
-	wh.Set(XMessageID, r.Header.Get(XMessageID))
-
-	if err == nil && servicePath == "" {
-		err = errors.New("empty servicepath")
-	} else {
-		wh.Set(XServicePath, servicePath)
+	if err != nil {
+		status := http.StatusBadRequest
+		if strings.Contains(err.Error(), "request body too large") {
+			err = errGatewayBodyTooLarge
+		}
+		writeGatewayError(w, r, nil, err, status)
+		return
 	}
-
-	if err == nil && r.Header.Get(XServiceMethod) == "" {
-		err = errors.New("empty servicemethod")
-	} else {
-		wh.Set(XServiceMethod, r.Header.Get(XServiceMethod))
+	req.Metadata = mergeMetadata(req.Metadata, headerMeta)
+	if r.Header.Get(XSerializeType) == "" {
+		if st, ok := s.acceptedSerializeType(req.ServicePath, r.Header.Values("Accept")); ok {
+			req.SetSerializeType(st)
+			r.Header.Set(XSerializeType, strconv.Itoa(int(st)))
+		}
 	}
 
-	if err == nil && r.Header.Get(XSerializeType) == "" {
+	switch {
+	case req.ServicePath == "":
+		err = errors.New("empty servicepath")
+	case req.ServiceMethod == "":
+		err = errors.New("empty servicemethod")
+	case r.Header.Get(XSerializeType) == "":
 		err = errors.New("empty serialized type")
-	} else {
-		wh.Set(XSerializeType, r.Header.Get(XSerializeType))
 	}
-
 	if err != nil {
-		rh := r.Header
-		for k, v := range rh {
-			if strings.HasPrefix(k, "X-RPCX-") && len(v) > 0 {
-				wh.Set(k, v[0])
-			}
-		}
-
-		wh.Set(XMessageStatusType, "Error")
-		wh.Set(XErrorMessage, err.Error())
+		writeGatewayError(w, r, nil, rerrors.New(rerrors.InvalidArgument, err.Error()), http.StatusBadRequest)
 		return
 	}
+
 	err = s.Plugins.DoPostReadRequest(ctx, req, nil)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeGatewayError(w, r, nil, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -157,54 +172,158 @@ func (s *Server) handleGatewayRequest(w http.ResponseWriter, r *http.Request, pa
 	err = s.auth(ctx, req)
 	if err != nil {
 		s.Plugins.DoPreWriteResponse(ctx, req, nil, err)
-		wh.Set(XMessageStatusType, "Error")
-		wh.Set(XErrorMessage, err.Error())
-		w.WriteHeader(401)
+		writeGatewayError(w, r, nil, err, http.StatusUnauthorized)
 		s.Plugins.DoPostWriteResponse(ctx, req, req.Clone(), err)
 		return
 	}
 
 	resMetadata := make(map[string]string)
-	newCtx := share.WithLocalValue(share.WithLocalValue(ctx, share.ReqMetaDataKey, req.Metadata),
+	newCtx := share.WithLocalValue(share.WithLocalValue(ctx, share.ReqMetaDataKey, s.requestMetadata(req)),
 		share.ResMetaDataKey, resMetadata)
+	if cancel := share.ExtractPropagated(newCtx, req.Metadata); cancel != nil {
+		defer cancel()
+	}
+	s.Plugins.DoPreHandleRequest(newCtx, req)
 
 	res, err := s.handleRequest(newCtx, req)
 	defer protocol.FreeMsg(res)
+	defer s.releaseReply(newCtx)
+
+	if len(resMetadata) > 0 { // copy meta in context to request
+		meta := res.Metadata
+		if meta == nil {
+			res.Metadata = resMetadata
+		} else {
+			for k, v := range resMetadata {
+				meta[k] = v
+			}
+		}
+	}
 
 	if err != nil {
 		// call DoPreWriteResponse
-		s.Plugins.DoPreWriteResponse(ctx, req, nil, err)
+		s.Plugins.DoPreWriteResponse(newCtx, req, nil, err)
 		if s.HandleServiceError != nil {
 			s.HandleServiceError(err)
 		} else {
 			log.Warnf("rpcx:  gateway request: %v", err)
 		}
-		wh.Set(XMessageStatusType, "Error")
-		wh.Set(XErrorMessage, err.Error())
-		w.WriteHeader(500)
+		writeGatewayError(w, r, res, err, http.StatusInternalServerError)
 		// call DoPostWriteResponse
-		s.Plugins.DoPostWriteResponse(ctx, req, req.Clone(), err)
+		s.Plugins.DoPostWriteResponse(newCtx, req, req.Clone(), err)
 		return
 	}
 
 	// will set res to call
 	s.Plugins.DoPreWriteResponse(newCtx, req, res, nil)
-	if len(resMetadata) > 0 { // copy meta in context to request
-		meta := res.Metadata
-		if meta == nil {
-			res.Metadata = resMetadata
-		} else {
-			for k, v := range resMetadata {
-				meta[k] = v
-			}
-		}
+	setGatewayHeaders(w, r, res)
+	SetMetadataHeaders(w.Header(), res.Metadata, s.gatewayHeaders)
+	err = writeGatewayBody(w, r, res)
+	s.Plugins.DoPostWriteResponse(newCtx, req, res, err)
+}
+
+var errGatewayBodyTooLarge = rerrors.New(rerrors.ResourceExhausted, "rpcx: message is too large")
+
+// HTTPStatusOf returns the HTTP status of errors with code, such as 404 for NotFound, or status for errors without codes,
+// so that other HTTP gateways render errors like the HTTP gateway of the server.
+func HTTPStatusOf(err error, status int) int {
+	return gatewayStatus(err, status)
+}
+
+// gatewayStatus returns the HTTP status of errors with code, or status for errors without codes.
+func gatewayStatus(err error, status int) int {
+	if err == errGatewayBodyTooLarge {
+		return http.StatusRequestEntityTooLarge
+	}
+	switch rerrors.CodeOf(err) {
+	case rerrors.InvalidArgument:
+		return http.StatusBadRequest
+	case rerrors.Unauthenticated:
+		return http.StatusUnauthorized
+	case rerrors.PermissionDenied:
+		return http.StatusForbidden
+	case rerrors.NotFound:
+		return http.StatusNotFound
+	case rerrors.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case rerrors.Unimplemented:
+		return http.StatusNotImplemented
+	case rerrors.Unavailable:
+		return http.StatusServiceUnavailable
+	case rerrors.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case rerrors.Internal:
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// setGatewayHeaders sets headers of the message and maps its metadata to X-RPCX- headers.
+func setGatewayHeaders(w http.ResponseWriter, r *http.Request, res *protocol.Message) {
+	wh := w.Header()
+	wh.Set(XVersion, r.Header.Get(XVersion))
+	wh.Set(XMessageID, r.Header.Get(XMessageID))
+	wh.Set(XServicePath, r.Header.Get(XServicePath))
+	wh.Set(XServiceMethod, r.Header.Get(XServiceMethod))
+	wh.Set(XSerializeType, r.Header.Get(XSerializeType))
+	if res == nil {
+		return
 	}
 
 	meta := url.Values{}
 	for k, v := range res.Metadata {
 		meta.Add(k, v)
+		if header, ok := headerOfMetadataKey(k); ok {
+			wh.Set(header, v)
+		}
 	}
 	wh.Set(XMeta, meta.Encode())
-	w.Write(res.Payload)
-	s.Plugins.DoPostWriteResponse(newCtx, req, res, err)
+}
+
+// writeGatewayError writes err with the HTTP status of its code.
+// res can be nil if the request fails before it is handled.
+func writeGatewayError(w http.ResponseWriter, r *http.Request, res *protocol.Message, err error, status int) {
+	setGatewayHeaders(w, r, res)
+	wh := w.Header()
+	wh.Set(XMessageStatusType, "Error")
+	wh.Set(XErrorMessage, err.Error())
+	if code := rerrors.CodeOf(err); code != rerrors.Unknown {
+		wh.Set(XErrorCode, strconv.Itoa(int(code)))
+	}
+	w.WriteHeader(gatewayStatus(err, status))
+}
+
+// writeGatewayBody writes the payload of res, which is gzipped if it is large and the client accepts gzip.
+func writeGatewayBody(w http.ResponseWriter, r *http.Request, res *protocol.Message) error {
+	wh := w.Header()
+	var st protocol.SerializeType
+	if v, err := strconv.Atoi(r.Header.Get(XSerializeType)); err == nil {
+		st = protocol.SerializeType(v)
+	}
+	wh.Set("Content-Type", ContentTypeOfSerializeType(st))
+
+	if len(res.Payload) <= 1024 || !acceptsGzip(r) {
+		wh.Set("Content-Length", strconv.Itoa(len(res.Payload)))
+		_, err := w.Write(res.Payload)
+		return err
+	}
+
+	wh.Set("Content-Encoding", "gzip")
+	wh.Add("Vary", "Accept-Encoding")
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(res.Payload); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]), "gzip") {
+				return true
+			}
+		}
+	}
+	return false
 }
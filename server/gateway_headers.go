@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/smallnest/rpcx/share"
+)
+
+// WithGatewayHeaders copies headers in the allowlist of requests of the HTTP gateway, and of upgrade requests of
+// websocket connections of WithWebsocketJSON, to metadata of requests under their names in lowercase,
+// and back to headers of responses, so request IDs and trace contexts added by ingresses, such as share.MeshHeaders,
+// don't die at the gateway. share.MeshHeaders are copied if no headers are given.
+//
+// The value of share.RequestIDHeader is also the request ID of share.RequestIDKey, unless the request has one.
+// Metadata set by X-RPCX- headers wins. The headers are registered by share.PropagateHeaders, so calls made by
+// services with their contexts send them again, and trace contexts in them are picked up by OpenTelemetry plugins.
+func WithGatewayHeaders(headers ...string) OptionFn {
+	if len(headers) == 0 {
+		headers = share.MeshHeaders
+	}
+	return func(s *Server) {
+		s.gatewayHeaders = nil
+		for _, h := range headers {
+			s.gatewayHeaders = append(s.gatewayHeaders, http.CanonicalHeaderKey(h))
+		}
+		share.PropagateHeaders(headers...)
+	}
+}
+
+// MetadataOfHeaders returns the values of headers of h under their names in lowercase, with the value of
+// share.RequestIDHeader as the request ID of share.RequestIDKey, or nil if h has none of them.
+// It is used by gateways like the HTTP gateway of WithGatewayHeaders.
+func MetadataOfHeaders(h http.Header, headers []string) map[string]string {
+	var meta map[string]string
+	for _, header := range headers {
+		v := h.Get(header)
+		if v == "" {
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]string, len(headers))
+		}
+		key := strings.ToLower(header)
+		meta[key] = v
+		if key == share.RequestIDHeader {
+			meta[share.RequestIDKey] = v
+		}
+	}
+	return meta
+}
+
+// SetMetadataHeaders sets headers of h to their values in meta, such as the ones of MetadataOfHeaders
+// or metadata of responses.
+func SetMetadataHeaders(h http.Header, meta map[string]string, headers []string) {
+	for _, header := range headers {
+		if v := meta[strings.ToLower(header)]; v != "" {
+			h.Set(header, v)
+		}
+	}
+}
+
+// mergeMetadata sets values of from in meta unless they are set, and returns meta.
+func mergeMetadata(meta, from map[string]string) map[string]string {
+	if len(from) == 0 {
+		return meta
+	}
+	if meta == nil {
+		meta = make(map[string]string, len(from))
+	}
+	for k, v := range from {
+		if _, ok := meta[k]; !ok {
+			meta[k] = v
+		}
+	}
+	return meta
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/smallnest/rpcx/codec"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+type gatewayService struct{}
+
+type GatewayReply struct {
+	Tenant string
+	Data   string
+}
+
+// Echo returns the tenant in metadata and sets it in response metadata.
+func (*gatewayService) Echo(ctx context.Context, args *Args, reply *GatewayReply) error {
+	reply.Tenant = ctx.Value(share.ReqMetaDataKey).(map[string]string)["tenant"]
+	reply.Data = strings.Repeat("x", args.A)
+	ctx.Value(share.ResMetaDataKey).(map[string]string)["served-by"] = "gateway-test"
+	return nil
+}
+
+func (*gatewayService) Fail(ctx context.Context, args *Args, reply *GatewayReply) error {
+	switch args.A {
+	case 1:
+		return rerrors.ErrNotFound
+	case 2:
+		return rerrors.ErrInvalidArgument
+	case 3:
+		return rerrors.ErrRateLimited
+	case 4:
+		return rerrors.ErrUnavailable
+	}
+	return errors.New("plain")
+}
+
+func startGatewayServer(t *testing.T, opts ...OptionFn) string {
+	s := NewServer(opts...)
+	s.RegisterName("Gateway", new(gatewayService), "")
+	startServer(t, s, "tcp")
+	return "http://" + s.Address().String()
+}
+
+func postGateway(t *testing.T, c *http.Client, url, method, contentType string, body []byte, header http.Header) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, url+"/Gateway", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set(XServiceMethod, method)
+	req.Header.Set("Content-Type", contentType)
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestGatewayMetadataHeaders(t *testing.T) {
+	url := startGatewayServer(t)
+
+	res := postGateway(t, http.DefaultClient, url, "Echo", "application/json", []byte(`{"A":3}`),
+		http.Header{"X-Rpcx-Tenant": {"acme"}})
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"Tenant":"acme","Data":"xxx"}`, string(body))
+	assert.Equal(t, "gateway-test", res.Header.Get("X-RPCX-Served-By"))
+
+	// msgpack is selected by Content-Type
+	cc := &codec.MsgpackCodec{}
+	data, _ := cc.Encode(&Args{A: 1})
+	res = postGateway(t, http.DefaultClient, url, "Echo", "application/x-msgpack", data, nil)
+	defer res.Body.Close()
+	body, _ = ioutil.ReadAll(res.Body)
+	var reply GatewayReply
+	assert.NoError(t, cc.Decode(body, &reply))
+	assert.Equal(t, "x", reply.Data)
+	assert.Equal(t, "application/x-msgpack", res.Header.Get("Content-Type"))
+}
+
+func TestGatewaySerializePolicy(t *testing.T) {
+	url := startGatewayServer(t, func(s *Server) {
+		s.SetServiceSerializePolicy("Gateway", []protocol.SerializeType{protocol.MsgPack, protocol.JSON})
+	})
+	cc := &codec.MsgpackCodec{}
+
+	// requests which name no serialize type are encoded by the Accept header
+	res := postGateway(t, http.DefaultClient, url, "Echo", "", []byte(`{"A":2}`), http.Header{"Accept": {"text/html, application/json;q=0.9"}})
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.Equal(t, http.StatusOK, res.StatusCode, string(body))
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"Tenant":"","Data":"xx"}`, string(body))
+
+	// or the default serialize type of the service
+	data, _ := cc.Encode(&Args{A: 1})
+	res = postGateway(t, http.DefaultClient, url, "Echo", "", data, http.Header{"Accept": {"*/*"}})
+	defer res.Body.Close()
+	body, _ = ioutil.ReadAll(res.Body)
+	var reply GatewayReply
+	assert.NoError(t, cc.Decode(body, &reply))
+	assert.Equal(t, "x", reply.Data)
+	assert.Equal(t, "application/x-msgpack", res.Header.Get("Content-Type"))
+
+	// named codecs are mapped from Content-Type
+	assert.NoError(t, share.RegisterCodecWithName(protocol.JSON, "application/vnd.rpcx+json", &codec.JSONCodec{}))
+	res = postGateway(t, http.DefaultClient, url, "Echo", "application/vnd.rpcx+json; charset=utf-8", []byte(`{"A":1}`), nil)
+	defer res.Body.Close()
+	body, _ = ioutil.ReadAll(res.Body)
+	assert.Equal(t, http.StatusOK, res.StatusCode, string(body))
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+
+	// and serialize types which are not allowed are unsupported
+	res = postGateway(t, http.DefaultClient, url, "Echo", "application/cbor", []byte{0xa0}, nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotImplemented, res.StatusCode)
+	assert.Equal(t, "12", res.Header.Get(XErrorCode))
+	assert.Contains(t, res.Header.Get(XErrorMessage), "unsupported serialize type 5, supported: 1,3")
+}
+
+func TestGatewayErrorStatus(t *testing.T) {
+	url := startGatewayServer(t)
+
+	cases := []struct {
+		method string
+		body   string
+		status int
+		code   string
+	}{
+		{"Fail", `{"A":1}`, http.StatusNotFound, "5"},
+		{"Fail", `{"A":2}`, http.StatusBadRequest, "3"},
+		{"Fail", `{"A":3}`, http.StatusTooManyRequests, "8"},
+		{"Fail", `{"A":4}`, http.StatusServiceUnavailable, "14"},
+		{"Fail", `{"A":5}`, http.StatusInternalServerError, ""},
+		{"Missing", `{}`, http.StatusNotFound, "5"},
+		{"", `{}`, http.StatusBadRequest, "3"},
+	}
+	for _, c := range cases {
+		res := postGateway(t, http.DefaultClient, url, c.method, "application/json", []byte(c.body), nil)
+		res.Body.Close()
+		assert.Equal(t, c.status, res.StatusCode, "%s %s", c.method, c.body)
+		assert.Equal(t, c.code, res.Header.Get(XErrorCode), "%s %s", c.method, c.body)
+		assert.Equal(t, "Error", res.Header.Get(XMessageStatusType))
+		assert.NotEmpty(t, res.Header.Get(XErrorMessage))
+	}
+}
+
+func TestGatewayGzip(t *testing.T) {
+	url := startGatewayServer(t, WithMaxMessageSize(1<<20))
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(`{"A":100000}`))
+	zw.Close()
+
+	c := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	res := postGateway(t, c, url, "Echo", "application/json", buf.Bytes(),
+		http.Header{"Content-Encoding": {"gzip"}, "Accept-Encoding": {"gzip"}})
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(zr)
+	assert.Len(t, body, 100000+len(`{"Tenant":"","Data":""}`))
+
+	// not gzipped if the client doesn't accept it
+	res = postGateway(t, c, url, "Echo", "application/json", []byte(`{"A":2000}`), nil)
+	res.Body.Close()
+	assert.Empty(t, res.Header.Get("Content-Encoding"))
+
+	// large bodies are rejected
+	res = postGateway(t, c, url, "Echo", "application/json", bytes.Repeat([]byte(" "), 2<<20), nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/smallnest/rpcx/log"
+)
+
+const (
+	// EnvInheritedListeners describes listeners inherited from the parent process in a graceful restart.
+	// It is a JSON array of {"network", "address"} and the file descriptor of the i-th listener is 3+i.
+	EnvInheritedListeners = "RPCX_INHERITED_LISTENERS"
+	// EnvRestartReadyFD is the file descriptor which the child process writes to when it is serving.
+	EnvRestartReadyFD = "RPCX_RESTART_READY_FD"
+)
+
+// restartListener is a listener that can be handed to the child process in a graceful restart.
+type restartListener struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+
+	// ln is the listener whose file descriptor is passed to the child.
+	// It is nil for networks that don't support it.
+	ln net.Listener
+}
+
+// inheritedListener is a listener of the parent process, available to the first Serve or AddListener
+// with the same network and address.
+type inheritedListener struct {
+	restartListener
+	file *os.File
+}
+
+var (
+	inheritOnce sync.Once
+	inheritMu   sync.Mutex
+	inherited   []*inheritedListener
+
+	readyOnce sync.Once
+)
+
+func loadInheritedListeners() {
+	data := os.Getenv(EnvInheritedListeners)
+	if data == "" {
+		return
+	}
+	var lns []restartListener
+	if err := json.Unmarshal([]byte(data), &lns); err != nil {
+		log.Errorf("rpcx: invalid %s: %v", EnvInheritedListeners, err)
+		return
+	}
+	for i, ln := range lns {
+		inherited = append(inherited, &inheritedListener{
+			restartListener: ln,
+			file:            os.NewFile(uintptr(3+i), ln.Network+":"+ln.Address),
+		})
+	}
+}
+
+// inheritListener returns the listener inherited from the parent process for network and address,
+// or nil if there is none.
+func inheritListener(network, address string) (net.Listener, error) {
+	inheritOnce.Do(loadInheritedListeners)
+
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	for i, l := range inherited {
+		if l.Network != network || l.Address != address {
+			continue
+		}
+		inherited = append(inherited[:i], inherited[i+1:]...)
+
+		ln, err := net.FileListener(l.file)
+		l.file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("rpcx: failed to inherit listener %s %s: %w", network, address, err)
+		}
+		log.Infof("rpcx: inherited listener %s %s", network, address)
+		return ln, nil
+	}
+	return nil, nil
+}
+
+// listen uses the listener inherited from the parent process or creates a new one,
+// and remembers it for the next graceful restart.
+func (s *Server) listen(network, address string) (net.Listener, error) {
+	ln, err := inheritListener(network, address)
+	if ln == nil && err == nil {
+		ln, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.restartLns = append(s.restartLns, restartListener{Network: network, Address: address, ln: ln})
+	s.mu.Unlock()
+	return s.proxyListener(ln), nil
+}
+
+// checkRestartable remembers listeners that can't be handed to the child process.
+// Listeners of reuseport are not handed over because the child can listen on the same port.
+func (s *Server) checkRestartable(network, address string) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix", "http", "ws", "wss", "reuseport":
+		return
+	}
+	s.mu.Lock()
+	s.restartLns = append(s.restartLns, restartListener{Network: network, Address: address})
+	s.mu.Unlock()
+}
+
+// notifyRestartReady tells the parent process that this process is serving, so the parent can shut down.
+func notifyRestartReady() {
+	readyOnce.Do(func() {
+		fd, err := strconv.Atoi(os.Getenv(EnvRestartReadyFD))
+		if err != nil {
+			return
+		}
+		f := os.NewFile(uintptr(fd), "rpcx-restart-ready")
+		if _, err := f.Write([]byte{1}); err != nil {
+			log.Warnf("rpcx: failed to notify the parent process: %v", err)
+		}
+		f.Close()
+	})
+}
+
+// StartGracefulRestart restarts the server without refusing any connection.
+// It starts a new process with the same arguments and passes the file descriptors of tcp and unix listeners to it,
+// so the new process accepts connections on the same sockets as soon as it calls Serve or AddListener
+// with the same network and address. After the new process starts serving,
+// functions registered by RegisterOnRestart are called and the server shuts down gracefully.
+// The caller is expected to exit after that.
+//
+// Listeners of reuseport are not passed because the new process listens on the same port with SO_REUSEPORT.
+// Other networks such as kcp and quic are not supported.
+//
+// If the new process doesn't serve before ctx is done, it is killed and this server keeps serving.
+func (s *Server) StartGracefulRestart(ctx context.Context) error {
+	s.mu.RLock()
+	lns := make([]restartListener, 0, len(s.restartLns))
+	for _, l := range s.restartLns {
+		if l.ln == nil {
+			s.mu.RUnlock()
+			return fmt.Errorf("rpcx: graceful restart is not supported for %s listeners", l.Network)
+		}
+		lns = append(lns, l)
+	}
+	s.mu.RUnlock()
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range lns {
+		f, err := listenerFile(l.ln)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	desc, _ := json.Marshal(lns)
+	argv0, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		readyW.Close()
+		return err
+	}
+	cmd := exec.Command(argv0, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(restartEnviron(), EnvInheritedListeners+"="+string(desc),
+		EnvRestartReadyFD+"="+strconv.Itoa(3+len(files)))
+	cmd.ExtraFiles = append(files, readyW)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+	log.Infof("rpcx: started a new rpcx server: %d", cmd.Process.Pid)
+
+	// the pipe is closed without data if the child exits before serving
+	ready := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := readyR.Read(b[:])
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("rpcx: new rpcx server %d is not serving: %w", cmd.Process.Pid, err)
+	}
+	go cmd.Wait()
+
+	s.mu.Lock()
+	for _, l := range lns {
+		// the socket file is used by the child
+		if ul, ok := l.ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	onRestart := append([]func(s *Server){}, s.onRestart...)
+	s.mu.Unlock()
+	for _, f := range onRestart {
+		f(s)
+	}
+
+	return s.Shutdown(ctx)
+}
+
+// restartEnviron returns the environment of this process without variables of a previous restart.
+func restartEnviron() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, EnvInheritedListeners+"=") || strings.HasPrefix(kv, EnvRestartReadyFD+"=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
@@ -0,0 +1,131 @@
+// +build !windows
+
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/stretchr/testify/assert"
+)
+
+const restartTestSock = "RPCX_TEST_RESTART_SOCK"
+
+type pidService struct{}
+
+func (pidService) Get(ctx context.Context, args *Args, reply *Reply) error {
+	reply.C = os.Getpid()
+	return nil
+}
+
+func startRestartServer(t *testing.T, sock string) *Server {
+	s := NewServer()
+	s.RegisterName("Pid", new(pidService), "")
+	if err := s.AddListener("unix", sock); err != nil {
+		t.Fatalf("failed to add unix listener: %v", err)
+	}
+	go s.Serve("tcp", "127.0.0.1:0")
+	return s
+}
+
+// TestGracefulRestartChild is the new server started by TestGracefulRestart.
+func TestGracefulRestartChild(t *testing.T) {
+	sock := os.Getenv(restartTestSock)
+	if sock == "" || os.Getenv(EnvInheritedListeners) == "" {
+		t.Skip("only run by TestGracefulRestart")
+	}
+	s := startRestartServer(t, sock)
+	defer s.Close()
+
+	// serve until the test is done
+	stop := filepath.Join(filepath.Dir(sock), "stop")
+	for i := 0; i < 200; i++ {
+		if _, err := os.Stat(stop); err == nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestGracefulRestart(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "rpcx.sock")
+	s := startRestartServer(t, sock)
+	defer s.Close()
+	time.Sleep(100 * time.Millisecond)
+	tcpAddr := s.Address().String()
+
+	var mu sync.Mutex
+	pids := make(map[int]int)
+	var errs []error
+	call := func(network, address string) {
+		c := client.NewClient(client.DefaultOption)
+		err := c.Connect(network, address)
+		if err != nil {
+			// connections must not be refused during restarts
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+			return
+		}
+		defer c.Close()
+		reply := &Reply{}
+		if err := c.Call(context.Background(), "Pid", "Get", &Args{}, reply); err == nil {
+			mu.Lock()
+			pids[reply.C]++
+			mu.Unlock()
+		}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, addr := range [][2]string{{"tcp", tcpAddr}, {"unix", sock}} {
+		addr := addr
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					call(addr[0], addr[1])
+				}
+			}
+		}()
+	}
+
+	// the child runs TestGracefulRestartChild only and inherits the listeners
+	os.Setenv(restartTestSock, sock)
+	defer os.Unsetenv(restartTestSock)
+	args, stdout, stderr := os.Args, os.Stdout, os.Stderr
+	os.Args = []string{os.Args[0], "-test.run=^TestGracefulRestartChild$"}
+	devNull, _ := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	os.Stdout, os.Stderr = devNull, devNull
+
+	time.Sleep(200 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := s.StartGracefulRestart(ctx)
+	os.Args, os.Stdout, os.Stderr = args, stdout, stderr
+	devNull.Close()
+	assert.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+	close(done)
+	wg.Wait()
+	os.WriteFile(filepath.Join(filepath.Dir(sock), "stop"), nil, 0644)
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Empty(t, errs, "no connection is refused")
+	assert.True(t, pids[os.Getpid()] > 0, "calls served by the old server")
+	delete(pids, os.Getpid())
+	delete(pids, 0)
+	assert.Len(t, pids, 1, "calls served by the new server")
+}
@@ -0,0 +1,11 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+func listenerFile(ln net.Listener) (*os.File, error) {
+	return nil, errors.New("rpcx: graceful restart is not supported on windows")
+}
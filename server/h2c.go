@@ -0,0 +1,158 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// H2CContentType is the Content-Type of encoded rpcx messages in bodies of h2c and h2 streams.
+const H2CContentType = "application/rpcx"
+
+func init() {
+	makeListeners["h2c"] = func(s *Server, address string) (net.Listener, error) {
+		return s.listen("tcp", address) // h2c is cleartext even if the server has a TLS config
+	}
+	makeListeners["h2"] = h2MakeListener
+}
+
+// h2MakeListener makes a TLS listener of the server TLS config, which negotiates h2 by ALPN.
+func h2MakeListener(s *Server, address string) (net.Listener, error) {
+	if s.tlsConfig == nil {
+		return nil, errors.New("must set tlsconfig for h2")
+	}
+	ln, err := s.listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	config := s.tlsConfig.Clone()
+	if !hasNextProto(config.NextProtos, http2.NextProtoTLS) {
+		config.NextProtos = append([]string{http2.NextProtoTLS}, config.NextProtos...)
+	}
+	return tls.NewListener(ln, config), nil
+}
+
+func hasNextProto(protos []string, proto string) bool {
+	for _, p := range protos {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
+
+// serveByH2C serves calls of HTTP/2 streams, which are cleartext (h2c) or TLS (h2) HTTP/2 connections.
+// Each call is a POST stream to rpcPath, whose body is the encoded request and whose response body is the encoded response,
+// so proxies of HTTP/2, such as Envoy, balance calls instead of connections.
+//
+// Headers of streams tell proxies about calls: X-RPCX-ServicePath, X-RPCX-ServiceMethod, X-RPCX-SerializeType
+// and X-RPCX-MessageID, and metadata as X-RPCX-<key>. The encoded messages are the source of truth of calls.
+// Streams reset by clients, such as those of calls whose deadlines are exceeded, cancel the contexts of their calls.
+//
+// Calls are handled by HandleRequest, so handlers added by AddHandler, streams and messages sent by SendMessage
+// are not supported, and connections of streams are not in ActiveClientConn.
+// Streams cost more than tcp connections, which is measured by BenchmarkTransportH2C and BenchmarkTransportTCP.
+func (s *Server) serveByH2C(ln net.Listener, rpcPath string, cleartext bool) {
+	s.setListener(ln)
+
+	if rpcPath == "" {
+		rpcPath = share.DefaultRPCPath
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpcPath, s.h2cHandler())
+
+	h2s := &http2.Server{}
+	srv := &http.Server{Handler: mux}
+	if cleartext {
+		srv.Handler = h2c.NewHandler(mux, h2s)
+	} else if err := http2.ConfigureServer(srv, h2s); err != nil {
+		log.Errorf("rpcx: failed to configure h2: %v", err)
+		return
+	}
+
+	srv.Serve(ln)
+}
+
+// h2cHandler handles the call of each stream.
+func (s *Server) h2cHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "rpcx: calls of h2c must be POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := protocol.GetPooledMsg()
+		defer protocol.FreeMsg(req)
+		if err := req.DecodeLimit(r.Body, s.messageSizeLimit()); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, protocol.ErrMessageTooLong) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			log.Warnf("rpcx: failed to read h2c request from %s: %v", r.RemoteAddr, err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if req.MessageType() != protocol.Request {
+			http.Error(w, "rpcx: h2c message is not a request", http.StatusBadRequest)
+			return
+		}
+
+		ctx := share.WithValue(r.Context(), RemoteConnContextKey, r.RemoteAddr) // notice: It is a string, different with TCP (net.Conn)
+		if req.IsHeartbeat() {
+			s.Plugins.DoHeartbeatRequest(ctx, req)
+			req.SetMessageType(protocol.Response)
+			writeH2CResponse(w, req)
+			return
+		}
+
+		res, err := s.HandleRequest(ctx, req)
+		if req.IsOneway() {
+			protocol.FreeMsg(res)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err != nil {
+			res = NewErrorResponse(req, err)
+		}
+		writeH2CResponse(w, res)
+		protocol.FreeMsg(res)
+	})
+}
+
+// writeH2CResponse writes the encoded response, whose metadata is also mapped to X-RPCX- headers.
+// The service path and method are copied, since they refer to the buffer of the pooled request,
+// which is reused before http2 encodes the headers.
+func writeH2CResponse(w http.ResponseWriter, res *protocol.Message) {
+	wh := w.Header()
+	wh.Set("Content-Type", H2CContentType)
+	wh.Set(XMessageID, strconv.FormatUint(res.Seq(), 10))
+	wh.Set(XServicePath, string([]byte(res.ServicePath)))
+	wh.Set(XServiceMethod, string([]byte(res.ServiceMethod)))
+	if res.MessageStatusType() == protocol.Error {
+		wh.Set(XMessageStatusType, "Error")
+		if code := res.Metadata[protocol.ServiceErrorCode]; code != "" {
+			wh.Set(XErrorCode, code)
+		}
+	}
+	for k, v := range res.Metadata {
+		if header, ok := headerOfMetadataKey(k); ok {
+			wh.Set(header, v)
+		}
+	}
+
+	data := res.EncodeSlicePointer()
+	defer protocol.PutData(data)
+	wh.Set("Content-Length", strconv.Itoa(len(*data)))
+	if _, err := w.Write(*data); err != nil {
+		log.Warnf("rpcx: failed to write h2c response: %v", err)
+	}
+}
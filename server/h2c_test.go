@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+)
+
+type h2cService struct {
+	canceled chan struct{}
+}
+
+func (s *h2cService) Meta(ctx context.Context, args *Args, reply *Reply) error {
+	reqMeta := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	ctx.Value(share.ResMetaDataKey).(map[string]string)["tenant"] = reqMeta["tenant"]
+	reply.C = args.A + args.B
+	return nil
+}
+
+func (s *h2cService) Fail(ctx context.Context, args *Args, reply *Reply) error {
+	return rerrors.New(rerrors.PermissionDenied, "denied")
+}
+
+func (s *h2cService) Wait(ctx context.Context, args *Args, reply *Reply) error {
+	<-ctx.Done()
+	close(s.canceled)
+	return ctx.Err()
+}
+
+func startH2CServer(t testing.TB, network string, opts ...OptionFn) (*Server, *h2cService) {
+	s := NewServer(opts...)
+	svc := &h2cService{canceled: make(chan struct{})}
+	s.RegisterName("Arith", new(Arith), "")
+	s.RegisterName("H2C", svc, "")
+	startServer(t, s, network)
+	return s, svc
+}
+
+func testH2CClient(t *testing.T, network, addr string, opt client.Option) {
+	c := client.NewClient(opt)
+	if err := c.Connect(network, addr); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply))
+	assert.Equal(t, 200, reply.C)
+
+	resMeta := make(map[string]string)
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"tenant": "a"})
+	ctx = context.WithValue(ctx, share.ResMetaDataKey, resMeta)
+	assert.NoError(t, c.Call(ctx, "H2C", "Meta", &Args{A: 1, B: 2}, reply))
+	assert.Equal(t, 3, reply.C)
+	assert.Equal(t, "a", resMeta["tenant"])
+
+	err := c.Call(context.Background(), "H2C", "Fail", &Args{}, reply)
+	assert.Equal(t, rerrors.PermissionDenied, rerrors.CodeOf(err))
+
+	// concurrent calls are streams of the connection
+	done := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		i := i
+		go func() {
+			reply := &Reply{}
+			err := c.Call(context.Background(), "Arith", "Mul", &Args{A: i, B: i}, reply)
+			if err == nil && reply.C != i*i {
+				err = rerrors.New(rerrors.Internal, "wrong reply")
+			}
+			done <- err
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, <-done)
+	}
+}
+
+func TestH2C(t *testing.T) {
+	s, svc := startH2CServer(t, "h2c")
+	defer s.Close()
+	addr := s.Address().String()
+
+	opt := client.DefaultOption
+	opt.Heartbeat = true
+	opt.HeartbeatInterval = 50 * time.Millisecond
+	testH2CClient(t, "h2c", addr, opt)
+
+	// streams of calls are reset when their deadlines are exceeded
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("h2c", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := c.Call(ctx, "H2C", "Wait", &Args{}, &Reply{})
+	// the deadline is propagated to the server too, which may answer before the stream is reset
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, rerrors.ErrDeadlineExceeded) {
+		t.Fatalf("expect a deadline exceeded but got %v", err)
+	}
+	select {
+	case <-svc.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the call of the reset stream is not canceled")
+	}
+
+	// oneway calls
+	assert.NoError(t, c.Notify(context.Background(), "Arith", "Mul", &Args{A: 1, B: 2}))
+}
+
+func TestH2CHeaders(t *testing.T) {
+	s, _ := startH2CServer(t, "h2c")
+	defer s.Close()
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.SetSeq(7)
+	req.ServicePath = "H2C"
+	req.ServiceMethod = "Meta"
+	req.Metadata = map[string]string{"tenant": "b"}
+	req.Payload = []byte(`{"A":1,"B":2}`)
+
+	tr := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+	defer tr.CloseIdleConnections()
+	hc := &http.Client{Transport: tr}
+	url := "http://" + s.Address().String() + share.DefaultRPCPath
+	resp, err := hc.Post(url, H2CContentType, bytes.NewReader(req.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "7", resp.Header.Get(XMessageID))
+	assert.Equal(t, "H2C", resp.Header.Get(XServicePath))
+	assert.Equal(t, "Meta", resp.Header.Get(XServiceMethod))
+	assert.Equal(t, "b", resp.Header.Get("X-RPCX-Tenant"))
+
+	res, err := protocol.Read(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(7), res.Seq())
+	assert.Equal(t, `{"C":3}`, string(res.Payload))
+
+	// bodies must be rpcx messages
+	resp, err = hc.Post(url, H2CContentType, bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestH2(t *testing.T) {
+	cert := selfSignedCert(t, pkix.Name{CommonName: "h2"})
+	s, _ := startH2CServer(t, "h2", WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	defer s.Close()
+
+	opt := client.DefaultOption
+	opt.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	testH2CClient(t, "h2", s.Address().String(), opt)
+}
+
+func benchmarkTransport(b *testing.B, network string) {
+	s, _ := startH2CServer(b, network)
+	defer s.Close()
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect(network, s.Address().String()); err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		reply := &Reply{}
+		for pb.Next() {
+			if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkTransportTCP(b *testing.B) {
+	benchmarkTransport(b, "tcp")
+}
+
+func BenchmarkTransportH2C(b *testing.B) {
+	benchmarkTransport(b, "h2c")
+}
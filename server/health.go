@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/smallnest/rpcx/share"
+)
+
+// HealthStatus is the health status of the server or a service.
+type HealthStatus = share.HealthStatus
+
+// Health statuses reported by the health checking service.
+const (
+	HealthUnknown    = share.HealthUnknown
+	HealthServing    = share.HealthServing
+	HealthNotServing = share.HealthNotServing
+)
+
+// HealthService is a built-in service that reports health statuses for load balancers and probers.
+// It is registered as share.HealthServiceName unless the server is created WithHealthService(false).
+type HealthService struct {
+	s *Server
+}
+
+type healthState struct {
+	mu       sync.Mutex
+	server   HealthStatus // empty means serving
+	services map[string]HealthStatus
+	// watchers records the last status sent to connections for watched services
+	watchers map[net.Conn]map[string]HealthStatus
+}
+
+// WithHealthService enables or disables the built-in health checking service. It is enabled by default.
+func WithHealthService(enabled bool) OptionFn {
+	return func(s *Server) {
+		s.disableHealth = !enabled
+	}
+}
+
+func (s *Server) registerHealthService() {
+	_, err := s.register(&HealthService{s: s}, share.HealthServiceName, true, "")
+	if err != nil {
+		return
+	}
+	s.serviceMapMu.Lock()
+	s.serviceMap[share.HealthServiceName].builtin = true
+	s.serviceMapMu.Unlock()
+}
+
+// SetHealthStatus sets the health status of service, for example NOT_SERVING while its cache warms up.
+// An empty service sets the status of the server, which is the status of all services if it is not SERVING.
+// Shutdown sets the status of the server to NOT_SERVING.
+// Registered services without a status are SERVING and other services are UNKNOWN.
+func (s *Server) SetHealthStatus(service string, status HealthStatus) {
+	s.health.mu.Lock()
+	if service == "" {
+		s.health.server = status
+	} else {
+		if s.health.services == nil {
+			s.health.services = make(map[string]HealthStatus)
+		}
+		s.health.services[service] = status
+	}
+	s.health.mu.Unlock()
+
+	s.notifyHealthWatchers()
+}
+
+// CheckHealth returns the health status of service, or of the server if service is empty.
+func (s *Server) CheckHealth(service string) HealthStatus {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	return s.healthStatusLocked(service)
+}
+
+func (s *Server) healthStatusLocked(service string) HealthStatus {
+	server := s.health.server
+	if server == "" {
+		server = HealthServing
+	}
+	if service == "" || server != HealthServing {
+		return server
+	}
+	if status, ok := s.health.services[service]; ok {
+		return status
+	}
+
+	s.serviceMapMu.RLock()
+	svc := s.serviceMap[service]
+	s.serviceMapMu.RUnlock()
+	if svc != nil && !svc.builtin {
+		return HealthServing
+	}
+	return HealthUnknown
+}
+
+// notifyHealthWatchers pushes changed statuses to watchers.
+func (s *Server) notifyHealthWatchers() {
+	type change struct {
+		conn  net.Conn
+		reply share.HealthCheckReply
+	}
+	var changes []change
+
+	s.health.mu.Lock()
+	for conn, services := range s.health.watchers {
+		for service, last := range services {
+			if status := s.healthStatusLocked(service); status != last {
+				services[service] = status
+				changes = append(changes, change{conn: conn, reply: share.HealthCheckReply{Service: service, Status: status}})
+			}
+		}
+	}
+	s.health.mu.Unlock()
+
+	for _, c := range changes {
+		data, _ := json.Marshal(&c.reply)
+		if err := s.SendMessage(c.conn, share.HealthServiceName, "Watch", nil, data); err != nil {
+			s.removeHealthWatcher(c.conn)
+		}
+	}
+}
+
+func (s *Server) removeHealthWatcher(conn net.Conn) {
+	s.health.mu.Lock()
+	delete(s.health.watchers, conn)
+	s.health.mu.Unlock()
+}
+
+// Check returns the health status of service, or of the server if service is empty.
+func (h *HealthService) Check(ctx context.Context, service string, reply *share.HealthCheckReply) error {
+	reply.Service = service
+	reply.Status = h.s.CheckHealth(service)
+	return nil
+}
+
+// Watch returns the health status of service like Check, and pushes changes of the status to the connection
+// until it is closed. Changes are sent to share.HealthServiceName.Watch as a HealthCheckReply in JSON,
+// and can be received by clients from ServerMessageChan.
+func (h *HealthService) Watch(ctx context.Context, service string, reply *share.HealthCheckReply) error {
+	conn, ok := ctx.Value(RemoteConnContextKey).(net.Conn)
+	if !ok {
+		return errors.New("rpcx: health can only be watched by connections")
+	}
+
+	s := h.s
+	s.health.mu.Lock()
+	status := s.healthStatusLocked(service)
+	if s.health.watchers == nil {
+		s.health.watchers = make(map[net.Conn]map[string]HealthStatus)
+	}
+	if s.health.watchers[conn] == nil {
+		s.health.watchers[conn] = make(map[string]HealthStatus)
+	}
+	s.health.watchers[conn][service] = status
+	s.health.mu.Unlock()
+
+	reply.Service = service
+	reply.Status = status
+	return nil
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthService(t *testing.T) {
+	s := NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	startServer(t, s, "tcp")
+
+	ch := make(chan *protocol.Message, 10)
+	c := client.NewClient(client.DefaultOption)
+	c.RegisterServerMessageChan(ch)
+	assert.NoError(t, c.Connect("tcp", s.Address().String()))
+	defer c.Close()
+
+	check := func(method, service string) share.HealthStatus {
+		reply := &share.HealthCheckReply{}
+		assert.NoError(t, c.Call(context.Background(), share.HealthServiceName, method, service, reply))
+		return reply.Status
+	}
+	assert.Equal(t, HealthServing, check("Check", ""))
+	assert.Equal(t, HealthServing, check("Check", "Arith"))
+	assert.Equal(t, HealthUnknown, check("Check", "Missing"))
+	assert.Equal(t, HealthUnknown, check("Check", share.HealthServiceName))
+
+	assert.Equal(t, HealthServing, check("Watch", "Arith"))
+	pushed := func() share.HealthCheckReply {
+		var reply share.HealthCheckReply
+		select {
+		case msg := <-ch:
+			assert.Equal(t, share.HealthServiceName, msg.ServicePath)
+			assert.NoError(t, json.Unmarshal(msg.Payload, &reply))
+		case <-time.After(time.Second):
+			t.Error("expect a status change")
+		}
+		return reply
+	}
+
+	s.SetHealthStatus("Arith", HealthNotServing)
+	assert.Equal(t, HealthNotServing, check("Check", "Arith"))
+	assert.Equal(t, share.HealthCheckReply{Service: "Arith", Status: HealthNotServing}, pushed())
+
+	// not pushed without changes
+	s.SetHealthStatus("Arith", HealthNotServing)
+	s.SetHealthStatus("Arith", HealthServing)
+	assert.Equal(t, share.HealthCheckReply{Service: "Arith", Status: HealthServing}, pushed())
+
+	// draining
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go s.Shutdown(ctx)
+	assert.Equal(t, share.HealthCheckReply{Service: "Arith", Status: HealthNotServing}, pushed())
+	assert.Equal(t, HealthNotServing, s.CheckHealth(""))
+}
+
+func TestWithHealthService(t *testing.T) {
+	s := NewServer(WithHealthService(false))
+	s.serviceMapMu.RLock()
+	_, ok := s.serviceMap[share.HealthServiceName]
+	s.serviceMapMu.RUnlock()
+	assert.False(t, ok)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// inflightContextKey is the key of the inflightRequest in the context of a service call.
+var inflightContextKey = &contextKey{"inflight-request"}
+
+// ErrRequestCanceled is sent to clients of requests canceled by CancelRequest.
+var ErrRequestCanceled = rerrors.New(rerrors.Canceled, "rpcx: request canceled by the server")
+
+// InflightRequest is a request whose service is being called.
+type InflightRequest struct {
+	// ConnID identifies the connection of the request in the server
+	ConnID        uint64    `json:"conn"`
+	Seq           uint64    `json:"seq"`
+	ServicePath   string    `json:"service_path"`
+	ServiceMethod string    `json:"service_method"`
+	RemoteAddr    string    `json:"remote_addr"`
+	RequestID     string    `json:"request_id,omitempty"`
+	Start         time.Time `json:"start"`
+	// Elapsed is in nanoseconds in JSON
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+type inflightRequest struct {
+	InflightRequest
+	header    protocol.Header
+	conn      net.Conn
+	writeCh   chan *[]byte
+	cancel    context.CancelFunc
+	responded int32
+	async     atomic.Value // *AsyncReply set by Detach
+	args      interface{}  // args referring to the payload, copied by Detach
+}
+
+// claim reports whether the caller is the first to respond to the request.
+func (r *inflightRequest) claim() bool {
+	return atomic.CompareAndSwapInt32(&r.responded, 0, 1)
+}
+
+func (r *inflightRequest) isResponded() bool {
+	return atomic.LoadInt32(&r.responded) != 0
+}
+
+// asyncReply returns the AsyncReply of the request, or nil if it is not detached.
+func (r *inflightRequest) asyncReply() *AsyncReply {
+	a, _ := r.async.Load().(*AsyncReply)
+	return a
+}
+
+// trackRequest adds req to in-flight requests of the connection and makes ctx cancelable by CancelRequest.
+// Requests of connections that are not tracked are not listed.
+func (s *Server) trackRequest(ctx *share.Context, info *connInfo, conn net.Conn, writeCh chan *[]byte, req *protocol.Message) *inflightRequest {
+	newCtx, cancel := context.WithCancel(ctx.Context)
+	ctx.Context = newCtx
+	r := &inflightRequest{
+		InflightRequest: InflightRequest{
+			Seq:           req.Seq(),
+			ServicePath:   req.ServicePath,
+			ServiceMethod: req.ServiceMethod,
+			RequestID:     req.Metadata[share.RequestIDKey],
+			Start:         time.Now(),
+		},
+		header:  *req.Header,
+		conn:    conn,
+		writeCh: writeCh,
+		cancel:  cancel,
+	}
+	share.WithLocalValue(ctx, inflightContextKey, r)
+	if info == nil {
+		return r
+	}
+
+	r.ConnID = info.id
+	info.requestsMu.Lock()
+	if info.requests == nil {
+		info.requests = make(map[uint64]*inflightRequest)
+	}
+	info.requests[r.Seq] = r
+	info.requestsMu.Unlock()
+	return r
+}
+
+// untrackRequest removes r from in-flight requests of the connection.
+func (s *Server) untrackRequest(info *connInfo, r *inflightRequest) {
+	if info != nil {
+		info.requestsMu.Lock()
+		// a misbehaving client may reuse the seq of an in-flight request
+		if info.requests[r.Seq] == r {
+			delete(info.requests, r.Seq)
+		}
+		info.requestsMu.Unlock()
+	}
+	r.cancel()
+}
+
+// InflightRequests returns requests whose services are being called, the oldest first.
+// Requests of handlers added by AddHandler are not included.
+func (s *Server) InflightRequests() []InflightRequest {
+	var requests []InflightRequest
+	now := time.Now()
+
+	s.mu.RLock()
+	for conn, info := range s.activeConn {
+		remoteAddr := conn.RemoteAddr().String()
+		info.requestsMu.Lock()
+		for _, r := range info.requests {
+			ir := r.InflightRequest
+			ir.RemoteAddr = remoteAddr
+			ir.Elapsed = now.Sub(ir.Start)
+			requests = append(requests, ir)
+		}
+		info.requestsMu.Unlock()
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Start.Before(requests[j].Start) })
+	return requests
+}
+
+// CancelRequest cancels the context of the in-flight request seq of connection connID
+// and responds to the client with ErrRequestCanceled at once.
+// The response of the service is discarded when it returns.
+func (s *Server) CancelRequest(connID, seq uint64) error {
+	var info *connInfo
+	s.mu.RLock()
+	for _, ci := range s.activeConn {
+		if ci.id == connID {
+			info = ci
+			break
+		}
+	}
+	s.mu.RUnlock()
+	if info == nil {
+		return rerrors.Errorf(rerrors.NotFound, "rpcx: connection %d is not found", connID)
+	}
+
+	info.requestsMu.Lock()
+	r := info.requests[seq]
+	info.requestsMu.Unlock()
+	if r == nil {
+		return rerrors.Errorf(rerrors.NotFound, "rpcx: request %d of connection %d is not in flight", seq, connID)
+	}
+
+	r.cancel()
+	if a := r.asyncReply(); a != nil { // detached requests are completed by their AsyncReply
+		a.Error(ErrRequestCanceled)
+		return nil
+	}
+	if r.claim() && !r.header.IsOneway() {
+		s.writeCanceledResponse(r)
+	}
+	return nil
+}
+
+// writeCanceledResponse writes ErrRequestCanceled as the response of r.
+func (s *Server) writeCanceledResponse(r *inflightRequest) {
+	res := protocol.NewMessage()
+	*res.Header = r.header
+	res.SetMessageType(protocol.Response)
+	res.SetCompressType(protocol.None)
+	res.ServicePath = r.ServicePath
+	res.ServiceMethod = r.ServiceMethod
+	handleError(res, ErrRequestCanceled)
+	data := res.EncodeSlicePointer()
+
+	if r.writeCh == nil {
+		s.writeConn(r.conn, *data)
+		protocol.PutData(data)
+		return
+	}
+
+	// the writeCh is closed if the connection is closed
+	defer func() { recover() }()
+	select {
+	case r.writeCh <- data:
+	case <-s.doneChan:
+	}
+}
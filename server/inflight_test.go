@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+type stuckService struct {
+	release chan struct{}
+	ctxErr  chan error
+}
+
+// Stuck ignores its context until it is released.
+func (s *stuckService) Stuck(ctx context.Context, args *Args, reply *Reply) error {
+	<-s.release
+	s.ctxErr <- ctx.Err()
+	return nil
+}
+
+func (s *stuckService) Panic(ctx context.Context, args *Args, reply *Reply) error {
+	panic("boom")
+}
+
+func TestCancelInflightRequest(t *testing.T) {
+	svc := &stuckService{release: make(chan struct{}), ctxErr: make(chan error, 1)}
+	s := NewServer()
+	s.RegisterName("Stuck", svc, "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// panics and errors are cleaned up
+	assert.Error(t, c.Call(context.Background(), "Stuck", "Panic", &Args{}, &Reply{}))
+	assert.Error(t, c.Call(context.Background(), "Stuck", "Unknown", &Args{}, &Reply{}))
+	assert.Empty(t, s.InflightRequests())
+
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.RequestIDKey: "req-1"})
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Call(ctx, "Stuck", "Stuck", &Args{}, &Reply{})
+	}()
+
+	// find the stuck request by the admin API
+	var requests []InflightRequest
+	for i := 0; i < 50 && len(requests) == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		w := httptest.NewRecorder()
+		s.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/requests", nil))
+		json.Unmarshal(w.Body.Bytes(), &requests)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expect a stuck request but got %v", requests)
+	}
+	r := requests[0]
+	assert.Equal(t, "Stuck", r.ServicePath)
+	assert.Equal(t, "Stuck", r.ServiceMethod)
+	assert.Equal(t, "req-1", r.RequestID)
+	assert.NotEmpty(t, r.RemoteAddr)
+	assert.True(t, r.Elapsed > 0)
+
+	w := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/requests/cancel",
+		strings.NewReader(`{"conn":999,"seq":1}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]uint64{"conn": r.ConnID, "seq": r.Seq})
+	s.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/requests/cancel", strings.NewReader(string(body))))
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	// the client receives the cancellation while the handler is still stuck
+	select {
+	case err := <-done:
+		assert.True(t, errors.Is(err, ErrRequestCanceled), "unexpected error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("expect the call to be canceled")
+	}
+
+	close(svc.release)
+	assert.Equal(t, context.Canceled, <-svc.ctxErr)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, s.InflightRequests())
+
+	// the connection still works
+	assert.NoError(t, c.Call(context.Background(), "Stuck", "Stuck", &Args{}, &Reply{}))
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"github.com/smallnest/rpcx/codec"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// WithJSONOptions decodes JSON arguments of the service by opts instead of codec.DefaultJSONOptions,
+// for example to reject unknown fields of its arguments by DisallowUnknownFields.
+func WithJSONOptions(servicePath string, opts codec.JSONOptions) OptionFn {
+	return func(s *Server) {
+		if s.jsonOptions == nil {
+			s.jsonOptions = make(map[string]codec.JSONOptions)
+		}
+		s.jsonOptions[servicePath] = opts
+	}
+}
+
+// decodeArgs decodes the payload of req by c into argv.
+// Errors without codes, such as syntax errors, are returned as InvalidArgument errors.
+func (s *Server) decodeArgs(c codec.Codec, req *protocol.Message, argv interface{}) error {
+	var err error
+	if opts, ok := s.jsonOptions[req.ServicePath]; ok {
+		err = share.DecodePayloadJSON(c, req, req.Payload, argv, opts)
+	} else {
+		err = share.DecodePayload(c, req, req.Payload, argv)
+	}
+	if err != nil && rerrors.CodeOf(err) == rerrors.Unknown {
+		return rerrors.New(rerrors.InvalidArgument, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/codec"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+type rawService struct{}
+
+func (s *rawService) Echo(ctx context.Context, args *json.RawMessage, reply *json.RawMessage) error {
+	*reply = append((*reply)[:0], *args...)
+	return nil
+}
+
+func TestJSONOptions(t *testing.T) {
+	s := NewServer(WithJSONOptions("Arith", codec.JSONOptions{DisallowUnknownFields: true}))
+	s.RegisterName("Arith", new(Arith), "")
+	s.RegisterName("Raw", new(rawService), "")
+	startServer(t, s, "tcp")
+
+	opt := client.DefaultOption
+	opt.SerializeType = protocol.JSON
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// raw messages pass through
+	args := json.RawMessage(`{"id": 9007199254740993, "b":[1,2]}`)
+	var raw json.RawMessage
+	if assert.NoError(t, c.Call(context.Background(), "Raw", "Echo", &args, &raw)) {
+		assert.Equal(t, string(args), string(raw))
+	}
+
+	// int64 IDs keep their precision unless calls decode numbers as float64
+	var reply map[string]interface{}
+	if assert.NoError(t, c.Call(context.Background(), "Raw", "Echo", &args, &reply)) {
+		assert.Equal(t, json.Number("9007199254740993"), reply["id"])
+	}
+	ctx := client.WithJSONOptions(context.Background(), codec.JSONOptions{})
+	if assert.NoError(t, c.Call(ctx, "Raw", "Echo", &args, &reply)) {
+		assert.Equal(t, float64(9007199254740992), reply["id"])
+	}
+
+	// unknown fields are rejected for the service
+	err := c.Call(context.Background(), "Arith", "Mul", map[string]int{"A": 10, "B": 20, "D": 1}, &Reply{})
+	assert.True(t, errors.Is(err, rerrors.ErrInvalidArgument), "unexpected error %v", err)
+	assert.Equal(t, `rpcx: unknown field "D"`, err.Error())
+	assert.Equal(t, map[string]string{"field": "D"}, rerrors.DetailsOf(err))
+	product := &Reply{}
+	if assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", map[string]int{"A": 10, "B": 20}, product)) {
+		assert.Equal(t, 200, product.C)
+	}
+
+	// and objects of replies with unknown fields can be rejected by calls too
+	ctx = client.WithJSONOptions(context.Background(), codec.JSONOptions{DisallowUnknownFields: true})
+	err = c.Call(ctx, "Raw", "Echo", &args, &Reply{})
+	assert.Contains(t, err.Error(), `unknown field "id"`)
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"io/ioutil"
@@ -8,44 +9,164 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 
-	"github.com/rs/cors"
+	rerrors "github.com/smallnest/rpcx/errors"
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/share"
 )
 
+// DefaultJSONRPCBatchConcurrency is the max number of requests of a JSON-RPC batch handled concurrently by default.
+const DefaultJSONRPCBatchConcurrency = 8
+
+type jsonrpcOptions struct {
+	path             string
+	batchConcurrency int
+}
+
+// WithJSONRPCEndpoint serves JSON-RPC 2.0 requests posted to path of the HTTP gateway, such as "/jsonrpc".
+// The method "Service.Method" calls the method of the registered service with params decoded by the JSON codec,
+// and notifications are handled as oneway requests.
+// At most batchConcurrency requests of a batch are handled concurrently, DefaultJSONRPCBatchConcurrency if it is not positive.
+func WithJSONRPCEndpoint(path string, batchConcurrency int) OptionFn {
+	return func(s *Server) {
+		s.jsonrpc.path = path
+		s.jsonrpc.batchConcurrency = batchConcurrency
+	}
+}
+
 func (s *Server) jsonrpcHandler(w http.ResponseWriter, r *http.Request) {
+	if maxSize := s.runtime().MaxMessageSize; maxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(maxSize))
+	}
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "request body too large") {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	var req = &jsonrpcRequest{}
+	data = bytes.TrimSpace(data)
+	if !json.Valid(data) {
+		writeResponse(w, &jsonrpcRespone{Error: &JSONRPCError{Code: CodeParseJSONRPCError, Message: "Parse error"}})
+		return
+	}
 
-	err = json.Unmarshal(data, req)
-	if err != nil {
-		var res = &jsonrpcRespone{}
-		res.Error = &JSONRPCError{
-			Code:    CodeParseJSONRPCError,
-			Message: err.Error(),
+	if data[0] != '[' {
+		req, rerr := parseJSONRPCRequest(data)
+		if rerr != nil {
+			writeResponse(w, &jsonrpcRespone{Error: rerr})
+			return
 		}
+		if req.IsNotify() {
+			go s.handleJSONRPCRequest(s.jsonrpcContext(r), req, r.Header)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeResponse(w, s.handleJSONRPCRequest(s.jsonrpcContext(r), req, r.Header))
+		return
+	}
 
-		writeResponse(w, res)
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil || len(batch) == 0 {
+		writeResponse(w, &jsonrpcRespone{Error: &JSONRPCError{Code: CodeInvalidjsonrpcRequest, Message: "Invalid Request"}})
 		return
 	}
-	conn := r.Context().Value(HttpConnContextKey).(net.Conn)
+	responses := s.handleJSONRPCBatch(r, batch)
+	if len(responses) == 0 { // all are notifications
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeResponse(w, responses)
+}
+
+// jsonrpcContext returns the context of a JSON-RPC request of r.
+// The remote conn is the connection of r if it is served by startJSONRPC2, or the remote address of r like the gateway.
+func (s *Server) jsonrpcContext(r *http.Request) *share.Context {
+	if conn, ok := r.Context().Value(HttpConnContextKey).(net.Conn); ok {
+		return share.WithValue(r.Context(), RemoteConnContextKey, conn)
+	}
+	return share.WithValue(r.Context(), RemoteConnContextKey, r.RemoteAddr)
+}
 
-	ctx := share.WithValue(r.Context(), RemoteConnContextKey, conn)
+// handleJSONRPCBatch handles requests of a batch concurrently and returns their responses in the order of the requests.
+// Notifications and their errors have no responses.
+func (s *Server) handleJSONRPCBatch(r *http.Request, batch []json.RawMessage) []*jsonrpcRespone {
+	concurrency := s.jsonrpc.batchConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultJSONRPCBatchConcurrency
+	}
+	results := make([]*jsonrpcRespone, len(batch))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, data := range batch {
+		req, rerr := parseJSONRPCRequest(data)
+		if rerr != nil {
+			results[i] = &jsonrpcRespone{Error: rerr}
+			continue
+		}
 
-	if req.ID != nil {
-		res := s.handleJSONRPCRequest(ctx, req, r.Header)
-		writeResponse(w, res)
-		return
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, req *jsonrpcRequest) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res := s.handleJSONRPCRequest(s.jsonrpcContext(r), req, r.Header)
+			if !req.IsNotify() {
+				results[i] = res
+			}
+		}(i, req)
+	}
+	wg.Wait()
+
+	responses := results[:0]
+	for _, res := range results {
+		if res != nil {
+			responses = append(responses, res)
+		}
+	}
+	return responses
+}
+
+// parseJSONRPCRequest parses a request object, and returns the Invalid Request error if it is not a valid one.
+func parseJSONRPCRequest(data []byte) (*jsonrpcRequest, *JSONRPCError) {
+	var fields struct {
+		Version json.RawMessage `json:"jsonrpc"`
+		Method  json.RawMessage `json:"method"`
+		Params  json.RawMessage `json:"params"`
+		ID      json.RawMessage `json:"id"`
+	}
+	invalid := &JSONRPCError{Code: CodeInvalidjsonrpcRequest, Message: "Invalid Request"}
+	if len(data) == 0 || data[0] != '{' || json.Unmarshal(data, &fields) != nil {
+		return nil, invalid
 	}
 
-	// notification
-	go s.handleJSONRPCRequest(ctx, req, r.Header)
+	req := &jsonrpcRequest{}
+	if string(fields.Version) != `"2.0"` || json.Unmarshal(fields.Method, &req.Method) != nil || req.Method == "" {
+		return nil, invalid
+	}
+	switch {
+	case len(fields.Params) == 0 || string(fields.Params) == "null":
+	case fields.Params[0] == '{' || fields.Params[0] == '[':
+		req.Params = &fields.Params
+	default: // params must be structured values
+		return nil, invalid
+	}
+	if fields.ID != nil { // it is a notification without the id member
+		req.hasID = true
+		if string(fields.ID) != "null" {
+			req.ID = &ID{}
+			if fields.ID[0] == '{' || fields.ID[0] == '[' || req.ID.UnmarshalJSON(fields.ID) != nil {
+				return nil, invalid
+			}
+		}
+	}
+	return req, nil
 }
 
 func (s *Server) handleJSONRPCRequest(ctx context.Context, r *jsonrpcRequest, header http.Header) *jsonrpcRespone {
@@ -54,28 +175,33 @@ func (s *Server) handleJSONRPCRequest(ctx context.Context, r *jsonrpcRequest, he
 	var res = &jsonrpcRespone{}
 	res.ID = r.ID
 
+	lastDot := strings.LastIndex(r.Method, ".")
+	if lastDot <= 0 || !s.hasMethod(r.Method[:lastDot], r.Method[lastDot+1:]) {
+		res.Error = &JSONRPCError{
+			Code:    CodeMethodNotFound,
+			Message: "Method not found",
+		}
+		return res
+	}
+
 	req := protocol.GetPooledMsg()
+	defer protocol.FreeMsg(req)
 	if req.Metadata == nil {
 		req.Metadata = make(map[string]string)
 	}
 
-	if r.ID == nil {
+	if r.IsNotify() {
 		req.SetOneway(true)
 	}
 	req.SetMessageType(protocol.Request)
 	req.SetSerializeType(protocol.JSON)
-
-	lastDot := strings.LastIndex(r.Method, ".")
-	if lastDot <= 0 {
-		res.Error = &JSONRPCError{
-			Code:    CodeMethodNotFound,
-			Message: "must contains servicepath and method",
-		}
-		return res
-	}
 	req.ServicePath = r.Method[:lastDot]
 	req.ServiceMethod = r.Method[lastDot+1:]
-	req.Payload = *r.Params
+	if r.Params != nil {
+		req.Payload = *r.Params
+	} else {
+		req.Payload = []byte("null")
+	}
 
 	// meta
 	meta := header.Get(XMeta)
@@ -95,53 +221,70 @@ func (s *Server) handleJSONRPCRequest(ctx context.Context, r *jsonrpcRequest, he
 
 	err := s.Plugins.DoPostReadRequest(ctx, req, nil)
 	if err != nil {
-		res.Error = &JSONRPCError{
-			Code:    CodeInternalJSONRPCError,
-			Message: err.Error(),
-		}
+		res.Error = jsonrpcError(err)
 		return res
 	}
 
 	err = s.auth(ctx, req)
 	if err != nil {
 		s.Plugins.DoPreWriteResponse(ctx, req, nil, err)
-		res.Error = &JSONRPCError{
-			Code:    CodeInternalJSONRPCError,
-			Message: err.Error(),
-		}
+		res.Error = jsonrpcError(err)
 		s.Plugins.DoPostWriteResponse(ctx, req, req.Clone(), err)
 		return res
 	}
 
 	resp, err := s.handleRequest(ctx, req)
-	if r.ID == nil {
+	defer s.releaseReply(ctx)
+	if resp != nil {
+		defer protocol.FreeMsg(resp)
+	}
+	if r.IsNotify() {
 		return nil
 	}
 
 	s.Plugins.DoPreWriteResponse(ctx, req, nil, err)
 	if err != nil {
-		res.Error = &JSONRPCError{
-			Code:    CodeInternalJSONRPCError,
-			Message: err.Error(),
-		}
+		res.Error = jsonrpcError(err)
 		s.Plugins.DoPostWriteResponse(ctx, req, req.Clone(), err)
 		return res
 	}
 
-	result := json.RawMessage(resp.Payload)
+	result := json.RawMessage(append([]byte(nil), resp.Payload...))
+	if len(result) == 0 {
+		result = json.RawMessage("null")
+	}
 	res.Result = &result
 	s.Plugins.DoPostWriteResponse(ctx, req, req.Clone(), err)
 	return res
 }
 
-func writeResponse(w http.ResponseWriter, res *jsonrpcRespone) {
+// jsonrpcError converts err to a JSON-RPC error. Invalid arguments are Invalid params errors and others are Internal errors,
+// and the code and details of rpcx errors are in the data of errors.
+func jsonrpcError(err error) *JSONRPCError {
+	e := &JSONRPCError{Code: CodeInternalJSONRPCError, Message: err.Error()}
+	code := rerrors.CodeOf(err)
+	if code == rerrors.InvalidArgument {
+		e.Code = CodeInvalidParams
+	}
+	if code != rerrors.Unknown {
+		data, _ := json.Marshal(struct {
+			Code    int               `json:"code"`
+			Details map[string]string `json:"details,omitempty"`
+		}{int(code), rerrors.DetailsOf(err)})
+		raw := json.RawMessage(data)
+		e.Data = &raw
+	}
+	return e
+}
+
+func writeResponse(w http.ResponseWriter, res interface{}) {
 	data, err := json.Marshal(res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Context-Type", "application/json")
+	w.Header().Set("Content-Type", "application/json")
 	w.Write(data)
 }
 
@@ -167,16 +310,19 @@ type CORSOptions struct {
 	// AllowedHeaders is list of non simple headers the client is allowed to use with
 	// cross-domain requests.
 	// If the special "*" value is present in the list, all headers will be allowed.
+	// A header ending with "*" allows headers of the prefix, such as "X-RPCX-*" for headers of metadata.
 	// Default value is [] but "Origin" is always appended to the list.
 	AllowedHeaders []string
 	// ExposedHeaders indicates which headers are safe to expose to the API of a CORS
-	// API specification
+	// API specification. A header ending with "*", such as "X-RPCX-*", exposes the headers
+	// of the prefix in each response.
 	ExposedHeaders []string
 	// MaxAge indicates how long (in seconds) the results of a preflight request
 	// can be cached
 	MaxAge int
 	// AllowCredentials indicates whether the request can include user credentials like
 	// cookies, HTTP authentication or client side SSL certificates.
+	// Origins are echoed instead of "*" if it is set.
 	AllowCredentials bool
 	// OptionsPassthrough instructs preflight to let other potential next handlers to
 	// process the OPTIONS method. Turn this on if your application handles OPTIONS.
@@ -198,11 +344,13 @@ func AllowAllCORSOptions() *CORSOptions {
 			http.MethodDelete,
 		},
 		AllowedHeaders:   []string{"*"},
+		ExposedHeaders:   []string{XMetaPrefix + "*"},
 		AllowCredentials: false,
 	}
 }
 
-// SetCORS sets CORS options.
+// SetCORS sets CORS options of the HTTP gateway and jsonrpc 2.0, whose preflight requests are answered without calling services.
+// Origins of websocket upgrades are checked by the options too.
 // for example:
 //
 //    cors.Options{
@@ -224,10 +372,7 @@ func (s *Server) startJSONRPC2(ln net.Listener) {
 	}}
 
 	if s.corsOptions != nil {
-		opt := cors.Options(*s.corsOptions)
-		c := cors.New(opt)
-		mux := c.Handler(newServer)
-		srv.Handler = mux
+		srv.Handler = s.corsOptions.Handler(newServer)
 
 		go srv.Serve(ln)
 	} else {
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// specService implements the methods of examples of the JSON-RPC 2.0 specification.
+type specService struct {
+	notified chan string
+	running  int32
+	max      int32
+}
+
+type SubtractArgs struct {
+	Minuend    int `json:"minuend"`
+	Subtrahend int `json:"subtrahend"`
+}
+
+// UnmarshalJSON accepts both positional and named params.
+func (a *SubtractArgs) UnmarshalJSON(data []byte) error {
+	var params []int
+	if err := json.Unmarshal(data, &params); err == nil {
+		if len(params) != 2 {
+			return errors.New("expect 2 params")
+		}
+		a.Minuend, a.Subtrahend = params[0], params[1]
+		return nil
+	}
+	type named SubtractArgs
+	return json.Unmarshal(data, (*named)(a))
+}
+
+func (*specService) Subtract(ctx context.Context, args *SubtractArgs, reply *int) error {
+	*reply = args.Minuend - args.Subtrahend
+	return nil
+}
+
+func (*specService) Sum(ctx context.Context, args *[]int, reply *int) error {
+	for _, n := range *args {
+		*reply += n
+	}
+	return nil
+}
+
+func (s *specService) Update(ctx context.Context, args *[]int, reply *struct{}) error {
+	s.notified <- "update"
+	return nil
+}
+
+func (s *specService) NotifyHello(ctx context.Context, args *[]int, reply *struct{}) error {
+	s.notified <- "notify_hello"
+	return nil
+}
+
+func (*specService) GetData(ctx context.Context, args *struct{}, reply *[]interface{}) error {
+	*reply = []interface{}{"hello", 5}
+	return nil
+}
+
+func (s *specService) Slow(ctx context.Context, args *[]int, reply *int) error {
+	n := atomic.AddInt32(&s.running, 1)
+	defer atomic.AddInt32(&s.running, -1)
+	for {
+		max := atomic.LoadInt32(&s.max)
+		if n <= max || atomic.CompareAndSwapInt32(&s.max, max, n) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	return nil
+}
+
+func startJSONRPCServer(t *testing.T, opts ...OptionFn) (string, *specService) {
+	svc := &specService{notified: make(chan string, 16)}
+	s := NewServer(opts...)
+	s.RegisterName("Spec", svc, "")
+	s.RegisterName("Gateway", new(gatewayService), "")
+	startServer(t, s, "tcp")
+	return "http://" + s.Address().String(), svc
+}
+
+func postJSONRPC(t *testing.T, url, body string) (int, string) {
+	res, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(data)
+}
+
+func TestJSONRPCSpecExamples(t *testing.T) {
+	addr, svc := startJSONRPCServer(t, WithJSONRPCEndpoint("/jsonrpc", 0))
+
+	tests := []struct {
+		name     string
+		request  string
+		response string // empty if there is no response
+	}{
+		{"positional parameters",
+			`{"jsonrpc": "2.0", "method": "Spec.Subtract", "params": [42, 23], "id": 1}`,
+			`{"jsonrpc": "2.0", "result": 19, "id": 1}`},
+		{"positional parameters in reverse order",
+			`{"jsonrpc": "2.0", "method": "Spec.Subtract", "params": [23, 42], "id": 2}`,
+			`{"jsonrpc": "2.0", "result": -19, "id": 2}`},
+		{"named parameters",
+			`{"jsonrpc": "2.0", "method": "Spec.Subtract", "params": {"subtrahend": 23, "minuend": 42}, "id": 3}`,
+			`{"jsonrpc": "2.0", "result": 19, "id": 3}`},
+		{"named parameters in another order",
+			`{"jsonrpc": "2.0", "method": "Spec.Subtract", "params": {"minuend": 42, "subtrahend": 23}, "id": "4"}`,
+			`{"jsonrpc": "2.0", "result": 19, "id": "4"}`},
+		{"notification",
+			`{"jsonrpc": "2.0", "method": "Spec.Update", "params": [1,2,3,4,5]}`,
+			``},
+		{"notification of a non-existent method",
+			`{"jsonrpc": "2.0", "method": "foobar"}`,
+			``},
+		{"non-existent method",
+			`{"jsonrpc": "2.0", "method": "foobar", "id": "1"}`,
+			`{"jsonrpc": "2.0", "error": {"code": -32601, "message": "Method not found"}, "id": "1"}`},
+		{"invalid JSON",
+			`{"jsonrpc": "2.0", "method": "foobar, "params": "bar", "baz]`,
+			`{"jsonrpc": "2.0", "error": {"code": -32700, "message": "Parse error"}, "id": null}`},
+		{"invalid request object",
+			`{"jsonrpc": "2.0", "method": 1, "params": "bar"}`,
+			`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`},
+		{"invalid version",
+			`{"jsonrpc": "1.0", "method": "Spec.Subtract", "params": [42, 23], "id": 1}`,
+			`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`},
+		{"batch with invalid JSON",
+			`[
+  {"jsonrpc": "2.0", "method": "Spec.Sum", "params": [1,2,4], "id": "1"},
+  {"jsonrpc": "2.0", "method"
+]`,
+			`{"jsonrpc": "2.0", "error": {"code": -32700, "message": "Parse error"}, "id": null}`},
+		{"empty array",
+			`[]`,
+			`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`},
+		{"invalid batch but not empty",
+			`[1]`,
+			`[{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}]`},
+		{"invalid batch",
+			`[1,2,3]`,
+			`[
+  {"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null},
+  {"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null},
+  {"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}
+]`},
+		{"batch",
+			`[
+  {"jsonrpc": "2.0", "method": "Spec.Sum", "params": [1,2,4], "id": "1"},
+  {"jsonrpc": "2.0", "method": "Spec.NotifyHello", "params": [7]},
+  {"jsonrpc": "2.0", "method": "Spec.Subtract", "params": [42,23], "id": "2"},
+  {"foo": "boo"},
+  {"jsonrpc": "2.0", "method": "foo.get", "params": {"name": "myself"}, "id": "5"},
+  {"jsonrpc": "2.0", "method": "Spec.GetData", "id": "9"}
+]`,
+			`[
+  {"jsonrpc": "2.0", "result": 7, "id": "1"},
+  {"jsonrpc": "2.0", "result": 19, "id": "2"},
+  {"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null},
+  {"jsonrpc": "2.0", "error": {"code": -32601, "message": "Method not found"}, "id": "5"},
+  {"jsonrpc": "2.0", "result": ["hello", 5], "id": "9"}
+]`},
+		{"batch of notifications",
+			`[
+  {"jsonrpc": "2.0", "method": "Spec.NotifyHello", "params": [1,2,4]},
+  {"jsonrpc": "2.0", "method": "Spec.NotifyHello", "params": [7]}
+]`,
+			``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := postJSONRPC(t, addr+"/jsonrpc", tt.request)
+			if tt.response == "" {
+				assert.Equal(t, http.StatusNoContent, status)
+				assert.Empty(t, body)
+				return
+			}
+			assert.Equal(t, http.StatusOK, status)
+			assert.JSONEq(t, tt.response, body)
+		})
+	}
+
+	// notifications are handled
+	notified := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		select {
+		case got := <-svc.notified:
+			notified[got]++
+		case <-time.After(time.Second):
+			t.Fatalf("notifications are not handled: %v", notified)
+		}
+	}
+	assert.Equal(t, map[string]int{"update": 1, "notify_hello": 3}, notified)
+}
+
+func TestJSONRPCErrors(t *testing.T) {
+	addr, _ := startJSONRPCServer(t, WithJSONRPCEndpoint("/jsonrpc", 0))
+
+	// params which can't be decoded are invalid params
+	_, body := postJSONRPC(t, addr+"/jsonrpc", `{"jsonrpc": "2.0", "method": "Spec.Subtract", "params": [1], "id": 1}`)
+	assert.JSONEq(t, `{"jsonrpc": "2.0", "error": {"code": -32602, "message": "expect 2 params", "data": {"code": 3}}, "id": 1}`, body)
+	_, body = postJSONRPC(t, addr+"/jsonrpc", `{"jsonrpc": "2.0", "method": "Spec.Subtract", "params": "bar", "id": 1}`)
+	assert.JSONEq(t, `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`, body)
+
+	// and errors of handlers are internal errors with their codes
+	_, body = postJSONRPC(t, addr+"/jsonrpc", `{"jsonrpc": "2.0", "method": "Gateway.Fail", "params": {"A": 2}, "id": 1}`)
+	assert.JSONEq(t, `{"jsonrpc": "2.0", "error": {"code": -32602, "message": "invalid argument", "data": {"code": 3}}, "id": 1}`, body)
+	_, body = postJSONRPC(t, addr+"/jsonrpc", `{"jsonrpc": "2.0", "method": "Gateway.Fail", "params": {"A": 1}, "id": 1}`)
+	assert.JSONEq(t, `{"jsonrpc": "2.0", "error": {"code": -32603, "message": "not found", "data": {"code": 5}}, "id": 1}`, body)
+	_, body = postJSONRPC(t, addr+"/jsonrpc", `{"jsonrpc": "2.0", "method": "Gateway.Fail", "params": {"A": 0}, "id": 1}`)
+	assert.JSONEq(t, `{"jsonrpc": "2.0", "error": {"code": -32603, "message": "plain"}, "id": 1}`, body)
+
+	// other paths are still served by the gateway
+	req, _ := http.NewRequest(http.MethodPost, addr+"/Gateway", strings.NewReader(`{"A": 1}`))
+	req.Header.Set(XServiceMethod, "Echo")
+	req.Header.Set(XSerializeType, "1")
+	res, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestJSONRPCBatchConcurrency(t *testing.T) {
+	addr, svc := startJSONRPCServer(t, WithJSONRPCEndpoint("/jsonrpc", 2))
+
+	var batch []string
+	var expected []string
+	for i := 0; i < 8; i++ {
+		batch = append(batch, `{"jsonrpc": "2.0", "method": "Spec.Slow", "params": [], "id": `+string(rune('0'+i))+`}`)
+		expected = append(expected, `{"jsonrpc": "2.0", "result": 0, "id": `+string(rune('0'+i))+`}`)
+	}
+	_, body := postJSONRPC(t, addr+"/jsonrpc", "["+strings.Join(batch, ",")+"]")
+	// responses are in the order of requests
+	assert.JSONEq(t, "["+strings.Join(expected, ",")+"]", body)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&svc.max))
+}
@@ -42,6 +42,8 @@ type jsonrpcRequest struct {
 	// Will be either a string or a number. If not set, the jsonrpcRequest is a notify,
 	// and no response is possible.
 	ID *ID `json:"id,omitempty"`
+	// hasID is true if the request has the id member, which may be null.
+	hasID bool
 }
 
 // jsonrpcRespone is a reply to a jsonrpcRequest.
@@ -56,7 +58,8 @@ type jsonrpcRespone struct {
 	// Error is a structured error response if the call fails.
 	Error *JSONRPCError `json:"error,omitempty"`
 	// ID must be set and is the identifier of the jsonrpcRequest this is a response to.
-	ID *ID `json:"id,omitempty"`
+	// It is null if the id of the request can't be detected.
+	ID *ID `json:"id"`
 }
 
 // JSONRPCError represents a structured error in a jsonrpcRespone.
@@ -66,7 +69,7 @@ type JSONRPCError struct {
 	// Message is a short description of the error.
 	Message string `json:"message"`
 	// Data is optional structured data containing additional information about the error.
-	Data *json.RawMessage `json:"data"`
+	Data *json.RawMessage `json:"data,omitempty"`
 }
 
 // VersionTag is a special 0 sized struct that encodes as the jsonrpc version
@@ -85,7 +88,7 @@ type ID struct {
 
 // IsNotify returns true if this request is a notification.
 func (r *jsonrpcRequest) IsNotify() bool {
-	return r.ID == nil
+	return !r.hasID
 }
 
 func (err *JSONRPCError) JSONRPCError() string {
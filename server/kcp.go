@@ -4,6 +4,7 @@ package server
 
 import (
 	"errors"
+	"fmt"
 	"net"
 
 	kcp "github.com/xtaci/kcp-go"
@@ -13,12 +14,117 @@ func init() {
 	makeListeners["kcp"] = kcpMakeListener
 }
 
+// KCPConfig is the config of KCP listeners and their connections.
+// See https://github.com/skywind3000/kcp/blob/master/README.en.md#protocol-configuration for details.
+type KCPConfig struct {
+	// NoDelay, Interval, Resend and NoCongestion are the arguments of kcp.UDPSession.SetNoDelay.
+	// NoDelay is 0 or 1, Interval is the internal update interval in milliseconds,
+	// Resend is the number of duplicated ACKs to trigger fast retransmission and 0 disables it,
+	// NoCongestion is 1 to disable the congestion control.
+	NoDelay      int
+	Interval     int
+	Resend       int
+	NoCongestion int
+
+	// SndWnd and RcvWnd are window sizes in packets
+	SndWnd int
+	RcvWnd int
+	// MTU is the max size of packets not including the UDP header, which is at most 1500
+	MTU int
+
+	// DataShards and ParityShards are FEC shards, FEC is disabled if both of them are zero
+	DataShards   int
+	ParityShards int
+
+	// ReadBuffer and WriteBuffer are sizes of socket buffers of the listener, the OS defaults are used if they are zero
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+// DefaultKCPConfig is the default config of KCP listeners.
+var DefaultKCPConfig = KCPConfig{
+	Interval:     100,
+	SndWnd:       32,
+	RcvWnd:       32,
+	MTU:          1400,
+	DataShards:   10,
+	ParityShards: 3,
+}
+
+// Validate checks values of the config.
+func (c *KCPConfig) Validate() error {
+	switch {
+	case c.NoDelay != 0 && c.NoDelay != 1:
+		return fmt.Errorf("KCP NoDelay must be 0 or 1, got %d", c.NoDelay)
+	case c.Interval < 10 || c.Interval > 5000:
+		return fmt.Errorf("KCP Interval must be in [10, 5000] milliseconds, got %d", c.Interval)
+	case c.Resend < 0:
+		return fmt.Errorf("KCP Resend must not be negative, got %d", c.Resend)
+	case c.NoCongestion != 0 && c.NoCongestion != 1:
+		return fmt.Errorf("KCP NoCongestion must be 0 or 1, got %d", c.NoCongestion)
+	case c.SndWnd <= 0 || c.RcvWnd <= 0:
+		return fmt.Errorf("KCP window sizes must be positive, got %d and %d", c.SndWnd, c.RcvWnd)
+	case c.MTU < 50 || c.MTU > 1500:
+		return fmt.Errorf("KCP MTU must be in [50, 1500], got %d", c.MTU)
+	case c.DataShards < 0 || c.ParityShards < 0 || c.DataShards+c.ParityShards > 256:
+		return fmt.Errorf("KCP FEC shards must not be negative and at most 256 in total, got %d and %d", c.DataShards, c.ParityShards)
+	case (c.DataShards == 0) != (c.ParityShards == 0):
+		return fmt.Errorf("KCP DataShards and ParityShards must be both zero or both positive, got %d and %d", c.DataShards, c.ParityShards)
+	case c.ReadBuffer < 0 || c.WriteBuffer < 0:
+		return fmt.Errorf("KCP buffer sizes must not be negative, got %d and %d", c.ReadBuffer, c.WriteBuffer)
+	}
+	return nil
+}
+
 func kcpMakeListener(s *Server, address string) (ln net.Listener, err error) {
 	if s.options == nil || s.options["BlockCrypt"] == nil {
 		return nil, errors.New("KCP BlockCrypt must be configured in server.Options")
 	}
 
-	return kcp.ListenWithOptions(address, s.options["BlockCrypt"].(kcp.BlockCrypt), 10, 3)
+	config := DefaultKCPConfig
+	if c, ok := s.options["KCPConfig"].(KCPConfig); ok {
+		config = c
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	l, err := kcp.ListenWithOptions(address, s.options["BlockCrypt"].(kcp.BlockCrypt), config.DataShards, config.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	if config.ReadBuffer > 0 {
+		if err := l.SetReadBuffer(config.ReadBuffer); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if config.WriteBuffer > 0 {
+		if err := l.SetWriteBuffer(config.WriteBuffer); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+
+	return &kcpListener{Listener: l, config: config}, nil
+}
+
+// kcpListener applies the config to accepted connections.
+type kcpListener struct {
+	*kcp.Listener
+	config KCPConfig
+}
+
+func (l *kcpListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptKCP()
+	if err != nil {
+		return nil, err
+	}
+	c := l.config
+	conn.SetNoDelay(c.NoDelay, c.Interval, c.Resend, c.NoCongestion)
+	conn.SetWindowSize(c.SndWnd, c.RcvWnd)
+	conn.SetMtu(c.MTU)
+	return conn, nil
 }
 
 // WithBlockCrypt sets kcp.BlockCrypt.
@@ -27,3 +133,17 @@ func WithBlockCrypt(bc kcp.BlockCrypt) OptionFn {
 		s.options["BlockCrypt"] = bc
 	}
 }
+
+// WithKCPConfig sets the config of KCP listeners, which is validated when serving kcp.
+// Copy DefaultKCPConfig and change the fields to tune KCP for your links.
+func WithKCPConfig(c KCPConfig) OptionFn {
+	return func(s *Server) {
+		s.options["KCPConfig"] = c
+	}
+}
+
+// KCPStats returns a copy of the statistics of all KCP connections of the process, such as retransmitted and lost segments.
+// kcp-go only keeps the statistics globally, they are not available by connection.
+func KCPStats() *kcp.Snmp {
+	return kcp.DefaultSnmp.Copy()
+}
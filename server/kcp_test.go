@@ -0,0 +1,165 @@
+// +build kcp
+
+package server
+
+import (
+	"context"
+	"crypto/sha1"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/stretchr/testify/assert"
+	kcp "github.com/xtaci/kcp-go"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+type KCPBlobReply struct {
+	Data []byte
+}
+
+type kcpBlob struct{}
+
+func (*kcpBlob) Get(ctx context.Context, args *Args, reply *KCPBlobReply) error {
+	reply.Data = make([]byte, args.A)
+	return nil
+}
+
+// lossyProxy relays UDP packets between a client and a server with delay and loss.
+type lossyProxy struct {
+	conn   *net.UDPConn
+	server *net.UDPAddr
+	loss   float64
+	delay  time.Duration
+
+	mu     sync.Mutex
+	client *net.UDPAddr
+	rand   *rand.Rand
+}
+
+func newLossyProxy(t *testing.T, server string, loss float64, delay time.Duration) *lossyProxy {
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &lossyProxy{conn: conn, server: serverAddr, loss: loss, delay: delay, rand: rand.New(rand.NewSource(1))}
+	go p.relay()
+	t.Cleanup(func() { conn.Close() })
+	return p
+}
+
+func (p *lossyProxy) relay() {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		p.mu.Lock()
+		to := p.server
+		if from.String() == p.server.String() {
+			to = p.client
+		} else {
+			p.client = from
+		}
+		drop := p.rand.Float64() < p.loss
+		p.mu.Unlock()
+		if drop || to == nil {
+			continue
+		}
+
+		data := append([]byte(nil), buf[:n]...)
+		time.AfterFunc(p.delay, func() { p.conn.WriteToUDP(data, to) })
+	}
+}
+
+func TestKCPConfigValidate(t *testing.T) {
+	assert.NoError(t, (&DefaultKCPConfig).Validate())
+
+	invalid := []func(c *KCPConfig){
+		func(c *KCPConfig) { c.NoDelay = 2 },
+		func(c *KCPConfig) { c.Interval = 0 },
+		func(c *KCPConfig) { c.Resend = -1 },
+		func(c *KCPConfig) { c.SndWnd = 0 },
+		func(c *KCPConfig) { c.MTU = 9000 },
+		func(c *KCPConfig) { c.DataShards, c.ParityShards = 200, 100 },
+		func(c *KCPConfig) { c.DataShards, c.ParityShards = 10, 0 },
+		func(c *KCPConfig) { c.ReadBuffer = -1 },
+	}
+	for i, fn := range invalid {
+		c := DefaultKCPConfig
+		fn(&c)
+		assert.Error(t, c.Validate(), "case %d", i)
+	}
+
+	// Serve returns the error instead of panicking in kcp-go
+	bc, _ := kcp.NewNoneBlockCrypt(nil)
+	c := DefaultKCPConfig
+	c.MTU = 10
+	s := NewServer(WithBlockCrypt(bc), WithKCPConfig(c))
+	assert.Error(t, s.Serve("kcp", "127.0.0.1:0"))
+}
+
+func TestKCPConfigLossyLink(t *testing.T) {
+	pass := pbkdf2.Key([]byte("rpcx-kcp"), []byte("rpcx-kcp-salt"), 4096, 32, sha1.New)
+	bc, _ := kcp.NewAESBlockCrypt(pass)
+
+	tuned := KCPConfig{
+		NoDelay:      1,
+		Interval:     10,
+		Resend:       2,
+		NoCongestion: 1,
+		SndWnd:       1024,
+		RcvWnd:       1024,
+		MTU:          1400,
+		DataShards:   10,
+		ParityShards: 3,
+	}
+
+	factory := client.ConnFactories["kcp"]
+	defer func() { client.ConnFactories["kcp"] = factory }()
+
+	call := func(config KCPConfig) error {
+		s := NewServer(WithBlockCrypt(bc), WithKCPConfig(config))
+		s.RegisterName("Blob", new(kcpBlob), "")
+		startServer(t, s, "kcp")
+
+		// 100ms delay in each direction and 20% loss
+		proxy := newLossyProxy(t, s.Address().String(), 0.2, 100*time.Millisecond)
+
+		client.ConnFactories["kcp"] = func(c *client.Client, network, address string) (net.Conn, error) {
+			conn, err := kcp.DialWithOptions(address, bc, config.DataShards, config.ParityShards)
+			if err != nil {
+				return nil, err
+			}
+			conn.SetNoDelay(config.NoDelay, config.Interval, config.Resend, config.NoCongestion)
+			conn.SetWindowSize(config.SndWnd, config.RcvWnd)
+			conn.SetMtu(config.MTU)
+			return conn, nil
+		}
+
+		opt := client.DefaultOption
+		opt.Block = bc
+		c := client.NewClient(opt)
+		if err := c.Connect("kcp", proxy.conn.LocalAddr().String()); err != nil {
+			return err
+		}
+		defer c.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		reply := &KCPBlobReply{}
+		return c.Call(ctx, "Blob", "Get", &Args{A: 1 << 18}, reply)
+	}
+
+	assert.NoError(t, call(tuned))
+	assert.Error(t, call(DefaultKCPConfig), "the default config is expected to time out on the lossy link")
+}
@@ -5,10 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"net"
+
+	"github.com/smallnest/rpcx/log"
 )
 
 var makeListeners = make(map[string]MakeListener)
 
+// tlsMakeListeners make listeners with a given TLS config instead of the config of the server.
+// They are used by listeners added with their own TLS config.
+var tlsMakeListeners = make(map[string]func(s *Server, address string, config *tls.Config) (net.Listener, error))
+
 func init() {
 	makeListeners["tcp"] = tcpMakeListener("tcp")
 	makeListeners["tcp4"] = tcpMakeListener("tcp4")
@@ -16,6 +22,17 @@ func init() {
 	makeListeners["http"] = tcpMakeListener("tcp")
 	makeListeners["ws"] = tcpMakeListener("tcp")
 	makeListeners["wss"] = tcpMakeListener("tcp")
+
+	for _, network := range []string{"tcp", "tcp4", "tcp6"} {
+		network := network
+		tlsMakeListeners[network] = func(s *Server, address string, config *tls.Config) (net.Listener, error) {
+			ln, err := s.listen(network, address)
+			if err != nil {
+				return nil, err
+			}
+			return tls.NewListener(ln, config), nil
+		}
+	}
 }
 
 // RegisterMakeListener registers a MakeListener for network.
@@ -38,17 +55,119 @@ func (s *Server) makeListener(network, address string) (ln net.Listener, err err
 		return nil, errors.New("must set tlsconfig for wss")
 	}
 
-	return ml(s, address)
+	ln, err = ml(s, address)
+	if err == nil {
+		s.checkRestartable(network, address)
+	}
+	return ln, err
 }
 
 func tcpMakeListener(network string) MakeListener {
 	return func(s *Server, address string) (ln net.Listener, err error) {
-		if s.tlsConfig == nil {
-			ln, err = net.Listen(network, address)
-		} else {
-			ln, err = tls.Listen(network, address, s.tlsConfig)
+		ln, err = s.listen(network, address)
+		if err == nil && s.tlsConfig != nil {
+			ln = tls.NewListener(ln, s.tlsConfig)
 		}
 
 		return ln, err
 	}
 }
+
+// ListenerOption configures a listener added by AddListener.
+type ListenerOption func(*listenerOptions)
+
+type listenerOptions struct {
+	tlsConfig *tls.Config
+}
+
+// WithListenerTLSConfig sets the TLS config of the listener instead of using the TLS config of the server.
+// It is supported by tcp, tcp4, tcp6, unix and quic.
+func WithListenerTLSConfig(config *tls.Config) ListenerOption {
+	return func(o *listenerOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// AddListener listens on another network and address and serves it in the background,
+// so one server can serve tcp, unix and quic clients at the same time.
+// It can be called before or after Serve. All listeners share services, plugins and connection limits,
+// and Close and Shutdown close all of them.
+//
+// Registry plugins advertise their ServiceAddress only, so set it to the address that clients should discover,
+// usually the tcp one.
+func (s *Server) AddListener(network, address string, opts ...ListenerOption) error {
+	var o listenerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	switch network {
+	case "http", "ws", "wss", "h2c", "h2", "udp", "udp4", "udp6":
+		return fmt.Errorf("rpcx: can not add a listener for %s, use Serve instead", network)
+	}
+
+	var ln net.Listener
+	var err error
+	if o.tlsConfig != nil {
+		ml := tlsMakeListeners[network]
+		if ml == nil {
+			return fmt.Errorf("rpcx: listener TLS config is not supported for %s", network)
+		}
+		ln, err = ml(s, address, o.tlsConfig)
+		if err == nil {
+			s.checkRestartable(network, address)
+		}
+	} else {
+		ln, err = s.makeListener(network, address)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	select {
+	case <-s.doneChan:
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	default:
+	}
+	if s.isShutdown() {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.extraLns = append(s.extraLns, ln)
+	s.mu.Unlock()
+	notifyRestartReady()
+
+	go func() {
+		if err := s.acceptLoop(ln); err != nil && err != ErrServerClosed {
+			log.Errorf("rpcx: failed to serve %s %s: %v", network, address, err)
+		}
+	}()
+	return nil
+}
+
+// Addresses returns the addresses of all listeners, starting with the one of Serve.
+func (s *Server) Addresses() []net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var addrs []net.Addr
+	if s.ln != nil {
+		addrs = append(addrs, s.ln.Addr())
+	}
+	for _, ln := range s.extraLns {
+		addrs = append(addrs, ln.Addr())
+	}
+	return addrs
+}
+
+// closeExtraListenersLocked closes listeners added by AddListener. s.mu must be held.
+func (s *Server) closeExtraListenersLocked() {
+	for _, ln := range s.extraLns {
+		ln.Close()
+	}
+	s.extraLns = nil
+}
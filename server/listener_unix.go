@@ -3,6 +3,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"net"
 
 	reuseport "github.com/kavu/go_reuseport"
@@ -11,6 +12,13 @@ import (
 func init() {
 	makeListeners["reuseport"] = reuseportMakeListener
 	makeListeners["unix"] = unixMakeListener
+	tlsMakeListeners["unix"] = func(s *Server, address string, config *tls.Config) (net.Listener, error) {
+		ln, err := s.listen("unix", address)
+		if err != nil {
+			return nil, err
+		}
+		return tls.NewListener(ln, config), nil
+	}
 }
 
 func reuseportMakeListener(s *Server, address string) (ln net.Listener, err error) {
@@ -21,13 +29,13 @@ func reuseportMakeListener(s *Server, address string) (ln net.Listener, err erro
 		network = "tcp6"
 	}
 
-	return reuseport.NewReusablePortListener(network, address)
-}
-
-func unixMakeListener(s *Server, address string) (ln net.Listener, err error) {
-	laddr, err := net.ResolveUnixAddr("unix", address)
+	ln, err = reuseport.NewReusablePortListener(network, address)
 	if err != nil {
 		return nil, err
 	}
-	return net.ListenUnix("unix", laddr)
+	return s.proxyListener(ln), nil
+}
+
+func unixMakeListener(s *Server, address string) (ln net.Listener, err error) {
+	return s.listen("unix", address)
 }
@@ -0,0 +1,98 @@
+// +build !windows
+
+package server
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+)
+
+func TestAddListener(t *testing.T) {
+	s := NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+
+	sock := filepath.Join(t.TempDir(), "rpcx.sock")
+	if err := s.AddListener("unix", sock); err != nil {
+		t.Fatalf("failed to add unix listener: %v", err)
+	}
+	if err := s.AddListener("http", "127.0.0.1:0"); err == nil {
+		t.Errorf("expect error for adding a http listener")
+	}
+
+	var addr net.Addr
+	for i := 0; i < 50 && addr == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		addr = s.Address()
+	}
+	if addr == nil {
+		t.Fatal("server is not serving")
+	}
+
+	if addrs := s.Addresses(); len(addrs) != 2 || addrs[1].String() != sock {
+		t.Fatalf("expect tcp and unix addresses but got %v", addrs)
+	}
+
+	tcpClient := client.NewClient(client.DefaultOption)
+	if err := tcpClient.Connect("tcp", addr.String()); err != nil {
+		t.Fatalf("failed to connect by tcp: %v", err)
+	}
+	defer tcpClient.Close()
+
+	unixClient := client.NewClient(client.DefaultOption)
+	if err := unixClient.Connect("unix", sock); err != nil {
+		t.Fatalf("failed to connect by unix: %v", err)
+	}
+	defer unixClient.Close()
+
+	for _, c := range []*client.Client{tcpClient, unixClient} {
+		reply := &Reply{}
+		if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply); err != nil {
+			t.Fatalf("failed to call: %v", err)
+		}
+		if reply.C != 200 {
+			t.Errorf("expect 200 but got %d", reply.C)
+		}
+	}
+
+	s.mu.RLock()
+	n := len(s.activeConn)
+	s.mu.RUnlock()
+	if n != 2 {
+		t.Fatalf("expect 2 active conns but got %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shutdown: %v", err)
+	}
+
+	s.mu.RLock()
+	n = len(s.activeConn)
+	s.mu.RUnlock()
+	if n != 0 {
+		t.Errorf("expect no active conns after shutdown but got %d", n)
+	}
+
+	for _, network := range []string{"tcp", "unix"} {
+		target := addr.String()
+		if network == "unix" {
+			target = sock
+		}
+		if conn, err := net.DialTimeout(network, target, time.Second); err == nil {
+			conn.Close()
+			t.Errorf("expect %s listener closed after shutdown", network)
+		}
+	}
+
+	if err := s.AddListener("unix", filepath.Join(t.TempDir(), "late.sock")); err != ErrServerClosed {
+		t.Errorf("expect ErrServerClosed but got %v", err)
+	}
+}
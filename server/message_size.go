@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"net"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+)
+
+const (
+	// CloseReasonMessageTooLarge means a message or its decompressed payload exceeds the max message size.
+	CloseReasonMessageTooLarge = "message_too_large"
+	// CloseReasonBadFrame means the data read is not a valid rpcx message.
+	CloseReasonBadFrame = "bad_frame"
+)
+
+// WithMaxMessageSize limits the size of messages and decompressed payloads read by the server.
+// The declared size is checked before the message is allocated. If a message is too large,
+// an error response with the ResourceExhausted code is sent and the connection is closed
+// because the rest of the message is not read.
+// If it is not set, protocol.MaxMessageLength is used.
+func WithMaxMessageSize(n int) OptionFn {
+	return func(s *Server) {
+		s.runtime().MaxMessageSize = n
+	}
+}
+
+func (s *Server) messageSizeLimit() int {
+	if n := s.runtime().MaxMessageSize; n > 0 {
+		return n
+	}
+	return protocol.MaxMessageLength
+}
+
+// frameCloseReason returns the reason to close the connection if err means the framing of messages can't be trusted.
+func frameCloseReason(err error) string {
+	switch {
+	case errors.Is(err, protocol.ErrMessageTooLong):
+		return CloseReasonMessageTooLarge
+	case errors.Is(err, protocol.ErrMagicNumber), errors.Is(err, protocol.ErrMessageMalformed):
+		return CloseReasonBadFrame
+	}
+	return ""
+}
+
+// writeMessageTooLarge responds to the message, whose header has been read, before the connection is closed.
+func (s *Server) writeMessageTooLarge(conn net.Conn, req *protocol.Message, err error) {
+	log.Warnf("rpcx: message from %s is too large: %v", conn.RemoteAddr().String(), err)
+	if req == nil || req.IsOneway() || req.IsHeartbeat() {
+		return
+	}
+
+	res := protocol.NewMessage()
+	*res.Header = *req.Header
+	res.SetMessageType(protocol.Response)
+	res.SetCompressType(protocol.None)
+	handleError(res, rerrors.Errorf(rerrors.ResourceExhausted, "rpcx: %v", err))
+	s.writeConn(conn, res.Encode())
+}
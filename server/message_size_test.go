@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxMessageSize(t *testing.T) {
+	s, recorder := startTimeoutServer(t, WithMaxMessageSize(1024))
+	defer s.Close()
+
+	conn := dialHeartbeat(t, s.Address().String())
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := protocol.Read(r); err != nil { // reply of the heartbeat
+		t.Fatalf("failed to read heartbeat: %v", err)
+	}
+
+	req := protocol.NewMessage()
+	req.SetSeq(10)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Mul"
+	req.Payload = bytes.Repeat([]byte(" "), 2048)
+	conn.Write(req.Encode())
+
+	res, err := protocol.Read(r)
+	if assert.NoError(t, err) {
+		assert.Equal(t, protocol.Response, res.MessageType())
+		assert.Equal(t, uint64(10), res.Seq())
+		assert.Equal(t, protocol.Error, res.MessageStatusType())
+		assert.True(t, strings.Contains(res.Metadata[protocol.ServiceError], "exceeds the limit of 1024"), res.Metadata[protocol.ServiceError])
+		assert.Equal(t, "8", res.Metadata[protocol.ServiceErrorCode])
+	}
+	recorder.wait(t, CloseReasonMessageTooLarge, time.Second)
+}
+
+func TestBadFrame(t *testing.T) {
+	s, recorder := startTimeoutServer(t)
+	defer s.Close()
+
+	conn := dialHeartbeat(t, s.Address().String())
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+
+	recorder.wait(t, CloseReasonBadFrame, time.Second)
+}
+
+func TestBadFrameAfterHeartbeats(t *testing.T) {
+	s, recorder := startTimeoutServer(t)
+	defer s.Close()
+
+	// heartbeats are answered by the async writer while the connection is closed for the bad frame
+	for i := 0; i < 10; i++ {
+		conn := dial(t, s.Address().String())
+		var data []byte
+		for j := 0; j < 10; j++ {
+			data = append(data, heartbeatData()...)
+		}
+		conn.Write(append(data, "GET / HTTP/1.1\r\n\r\n"...))
+		recorder.wait(t, CloseReasonBadFrame, time.Second)
+	}
+}
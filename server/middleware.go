@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+)
+
+// CallHandler invokes a service method or function with decoded args and reply.
+// Metadata of the request and the response can be accessed by share.ReqMetaDataKey and share.ResMetaDataKey in ctx.
+type CallHandler func(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}) error
+
+// Middleware wraps a CallHandler. It can inspect or replace args, fill reply,
+// or return an error without calling next to short-circuit the call.
+type Middleware func(next CallHandler) CallHandler
+
+// Use adds middlewares around invocations of services and functions.
+// The first added middleware is the outermost one.
+// Middlewares run inside plugins: after PreCall plugins and before PostCall plugins.
+// Use is not safe for concurrent use with serving requests, so it must be called before Serve.
+func (s *Server) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// invoke calls h through the middlewares.
+func (s *Server) invoke(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}, h CallHandler) error {
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		h = s.middlewares[i](h)
+	}
+	return h(ctx, servicePath, serviceMethod, args, reply)
+}
+
+// callValue returns the value of args passed to a method or function whose argument type is argType.
+func callValue(argType reflect.Type, args interface{}) reflect.Value {
+	if argType.Kind() != reflect.Ptr {
+		return reflect.ValueOf(args).Elem()
+	}
+	return reflect.ValueOf(args)
+}
+
+// TimingMiddleware returns a middleware that reports the duration and the error code of every call.
+// Context errors are classified as Canceled and DeadlineExceeded, and other errors without a code as Unknown.
+func TimingMiddleware(report func(servicePath, serviceMethod string, d time.Duration, code rerrors.Code)) Middleware {
+	return func(next CallHandler) CallHandler {
+		return func(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}) error {
+			start := time.Now()
+			err := next(ctx, servicePath, serviceMethod, args, reply)
+			report(servicePath, serviceMethod, time.Since(start), classifyError(err))
+			return err
+		}
+	}
+}
+
+// classifyError returns the code of err, mapping context errors to their codes.
+func classifyError(err error) rerrors.Code {
+	code := rerrors.CodeOf(err)
+	if code != rerrors.Unknown {
+		return code
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return rerrors.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return rerrors.DeadlineExceeded
+	}
+	return code
+}
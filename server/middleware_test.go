@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+type orderPlugin struct {
+	order *[]string
+}
+
+func (p *orderPlugin) PreCall(ctx context.Context, serviceName, methodName string, args interface{}) (interface{}, error) {
+	*p.order = append(*p.order, "plugin:pre")
+	return args, nil
+}
+
+func (p *orderPlugin) PostCall(ctx context.Context, serviceName, methodName string, args, reply interface{}) (interface{}, error) {
+	*p.order = append(*p.order, "plugin:post")
+	return reply, nil
+}
+
+func newMiddlewareRequest(servicePath, serviceMethod string, args *Args, meta map[string]string) (*protocol.Message, context.Context) {
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = servicePath
+	req.ServiceMethod = serviceMethod
+	req.Metadata = meta
+	req.Payload, _ = json.Marshal(args)
+
+	ctx := share.WithValue(context.Background(), share.ReqMetaDataKey, meta)
+	return req, ctx
+}
+
+func TestMiddleware(t *testing.T) {
+	var order []string
+	s := NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	s.RegisterFunction("Fn", Mul, "")
+	s.Plugins.Add(&orderPlugin{order: &order})
+
+	named := func(name string) Middleware {
+		return func(next CallHandler) CallHandler {
+			return func(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}) error {
+				order = append(order, name+":before")
+				err := next(ctx, servicePath, serviceMethod, args, reply)
+				order = append(order, name+":after")
+				return err
+			}
+		}
+	}
+	tenant := func(next CallHandler) CallHandler {
+		return func(ctx context.Context, servicePath, serviceMethod string, args, reply interface{}) error {
+			meta, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+			if meta["tenant"] == "" {
+				return rerrors.New(rerrors.PermissionDenied, "no tenant")
+			}
+			args.(*Args).B++ // middlewares can change args
+			return next(ctx, servicePath, serviceMethod, args, reply)
+		}
+	}
+	s.Use(named("outer"), named("inner"), tenant)
+
+	req, ctx := newMiddlewareRequest("Arith", "Mul", &Args{A: 10, B: 20}, map[string]string{"tenant": "t1"})
+	res, err := s.handleRequest(ctx, req)
+	if err != nil {
+		t.Fatalf("failed to handle request: %v", err)
+	}
+	var reply Reply
+	json.Unmarshal(res.Payload, &reply)
+	if reply.C != 210 {
+		t.Errorf("expect 210 but got %d", reply.C)
+	}
+
+	want := []string{"plugin:pre", "outer:before", "inner:before", "inner:after", "outer:after", "plugin:post"}
+	if len(order) != len(want) {
+		t.Fatalf("expect %v but got %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expect %v but got %v", want, order)
+		}
+	}
+
+	// short-circuit without a tenant
+	req, ctx = newMiddlewareRequest("Arith", "Mul", &Args{A: 10, B: 20}, map[string]string{})
+	res, err = s.handleRequest(ctx, req)
+	if rerrors.CodeOf(err) != rerrors.PermissionDenied {
+		t.Fatalf("expect PermissionDenied but got %v", err)
+	}
+	if res.Metadata[protocol.ServiceError] != "no tenant" {
+		t.Errorf("expect error in response but got %v", res.Metadata)
+	}
+
+	// functions are wrapped too
+	req, ctx = newMiddlewareRequest("Fn", "Mul", &Args{A: 2, B: 3}, map[string]string{"tenant": "t1"})
+	res, err = s.handleRequest(ctx, req)
+	if err != nil {
+		t.Fatalf("failed to handle request for function: %v", err)
+	}
+	json.Unmarshal(res.Payload, &reply)
+	if reply.C != 8 {
+		t.Errorf("expect 8 but got %d", reply.C)
+	}
+}
+
+func TestTimingMiddleware(t *testing.T) {
+	type result struct {
+		method string
+		code   rerrors.Code
+	}
+	var results []result
+
+	s := NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	s.RegisterFunctionName("Fn", "Fail", func(ctx context.Context, args *Args, reply *Reply) error {
+		if args.A == 0 {
+			return context.DeadlineExceeded
+		}
+		return rerrors.New(rerrors.Unavailable, "unavailable")
+	}, "")
+	s.Use(TimingMiddleware(func(servicePath, serviceMethod string, d time.Duration, code rerrors.Code) {
+		if d < 0 {
+			t.Errorf("expect non-negative duration but got %v", d)
+		}
+		results = append(results, result{servicePath + "." + serviceMethod, code})
+	}))
+
+	for _, args := range []struct {
+		sp, sm string
+		a      int
+	}{{"Arith", "Mul", 1}, {"Fn", "Fail", 0}, {"Fn", "Fail", 1}} {
+		req, ctx := newMiddlewareRequest(args.sp, args.sm, &Args{A: args.a}, nil)
+		s.handleRequest(ctx, req)
+	}
+
+	want := []result{{"Arith.Mul", rerrors.OK}, {"Fn.Fail", rerrors.DeadlineExceeded}, {"Fn.Fail", rerrors.Unavailable}}
+	if len(results) != len(want) {
+		t.Fatalf("expect %v but got %v", want, results)
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("expect %v but got %v", want[i], results[i])
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// Negotiation is the protocol negotiated by a client on connect.
+type Negotiation struct {
+	Version int `json:"version"`
+	// Capabilities are the protocol extensions supported by both the client and the server.
+	Capabilities protocol.Capabilities `json:"capabilities"`
+}
+
+// Negotiation returns the protocol negotiated by the client of conn, or nil if it has not negotiated,
+// such as clients of old versions. Extensions are still used with such clients if they ask for them,
+// for example by protocol.AcceptChunk in metadata of requests.
+func (s *Server) Negotiation(conn net.Conn) *Negotiation {
+	s.mu.RLock()
+	info := s.activeConn[conn]
+	s.mu.RUnlock()
+	return info.negotiated()
+}
+
+// capabilities returns the protocol extensions supported by the server.
+func (s *Server) capabilities() protocol.Capabilities {
+	caps := protocol.CapChecksum | protocol.CapCompactMetadata
+	if protocol.Compressors[protocol.Zstd] != nil && protocol.Compressors[protocol.Snappy] != nil {
+		caps |= protocol.CapCompress
+	}
+	if s.chunking.threshold > 0 {
+		caps |= protocol.CapChunk
+	}
+	return caps
+}
+
+// negotiate answers the negotiation heartbeat req sent by the client of conn on connect, and records the negotiated
+// protocol of conn. Offers which can't be parsed are answered without protocol.NegotiatedKey, like old servers do.
+func (s *Server) negotiate(conn net.Conn, info *connInfo, req *protocol.Message) {
+	offer := req.Metadata[protocol.NegotiateKey]
+	delete(req.Metadata, protocol.NegotiateKey)
+	version, caps, ok := protocol.ParseNegotiation(offer)
+	if !ok {
+		log.Warnf("rpcx: bad negotiation %q from %s", offer, conn.RemoteAddr().String())
+		return
+	}
+
+	version, caps = protocol.Negotiate(protocol.ProtocolVersion, version, s.capabilities(), caps)
+	n := &Negotiation{Version: version, Capabilities: caps}
+	if info != nil {
+		info.negotiation.Store(n)
+		if caps.Has(protocol.CapChunk) {
+			atomic.StoreInt32(&info.acceptChunk, 1)
+		}
+	}
+	req.Metadata[protocol.NegotiatedKey] = protocol.FormatNegotiation(version, caps)
+	s.Plugins.DoConnNegotiated(conn, *n)
+}
+
+// negotiated returns the protocol negotiated by the client, or nil if it has not negotiated.
+func (info *connInfo) negotiated() *Negotiation {
+	if info == nil {
+		return nil
+	}
+	n, _ := info.negotiation.Load().(*Negotiation)
+	return n
+}
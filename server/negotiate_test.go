@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+type negotiationRecorder struct {
+	compressTypePlugin
+	negotiations []Negotiation
+}
+
+func (p *negotiationRecorder) HandleConnNegotiated(conn net.Conn, negotiation Negotiation) {
+	p.mu.Lock()
+	p.negotiations = append(p.negotiations, negotiation)
+	p.mu.Unlock()
+}
+
+func TestNegotiation(t *testing.T) {
+	p := &negotiationRecorder{}
+	s := NewServer(WithChunking(1024, 1<<20, time.Second), WithMaxMessageSize(4096), WithChecksum())
+	s.Plugins.Add(p)
+	s.RegisterName("Blob", new(BlobService), "")
+	startServer(t, s, "tcp")
+
+	opt := client.DefaultOption
+	opt.NegotiateTimeout = client.DefaultNegotiateTimeout
+	opt.ChunkThreshold = 1024
+	opt.CompressType = protocol.Zstd
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	all := protocol.CapCompress | protocol.CapChunk | protocol.CapChecksum | protocol.CapCompactMetadata
+	version, caps, ok := c.Negotiation()
+	assert.True(t, ok)
+	assert.Equal(t, protocol.ProtocolVersion, version)
+	assert.Equal(t, all, caps)
+
+	// extensions are used from the first request, which is too large unless it is chunked
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+	reply := &Blob{}
+	if assert.NoError(t, c.Call(context.Background(), "Blob", "Echo", &Blob{Data: data}, reply)) {
+		assert.Equal(t, data, reply.Data)
+	}
+
+	p.mu.Lock()
+	assert.Equal(t, []protocol.CompressType{protocol.Zstd}, p.reqTypes)
+	assert.Equal(t, []Negotiation{{Version: protocol.ProtocolVersion, Capabilities: all}}, p.negotiations)
+	p.mu.Unlock()
+
+	conns := s.Connections()
+	if assert.Len(t, conns, 1) && assert.NotNil(t, conns[0].Negotiation) {
+		assert.Equal(t, all, conns[0].Negotiation.Capabilities)
+	}
+	w := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/conns", nil))
+	assert.Contains(t, w.Body.String(), `"negotiation":{"version":1,"capabilities":["compress","chunk","checksum","compact_metadata"]}`)
+}
+
+func TestNegotiationIntersection(t *testing.T) {
+	s := startChunkServer(t, WithChecksum())
+	defer s.Close()
+
+	// neither the client nor the server chunks
+	opt := client.DefaultOption
+	opt.NegotiateTimeout = client.DefaultNegotiateTimeout
+	opt.ChunkThreshold = 1024
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, caps, _ := c.Negotiation()
+	assert.Equal(t, protocol.CapCompress|protocol.CapChecksum|protocol.CapCompactMetadata, caps)
+
+	data := blob(64 * 1024)
+	reply := &Blob{}
+	if assert.NoError(t, c.Call(context.Background(), "Blob", "Echo", &Blob{Data: data}, reply)) {
+		assert.Equal(t, data, reply.Data)
+	}
+
+	// clients of old versions don't negotiate
+	opt.NegotiateTimeout = 0
+	old := client.NewClient(opt)
+	if err := old.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	assert.NoError(t, old.Call(context.Background(), "Arith", "Mul", &Args{A: 1, B: 2}, &Reply{}))
+
+	var negotiated, legacy int
+	for _, conn := range s.Connections() {
+		if conn.Negotiation != nil {
+			negotiated++
+		} else {
+			legacy++
+		}
+	}
+	assert.Equal(t, 1, negotiated)
+	assert.Equal(t, 1, legacy)
+	w := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/conns", nil))
+	assert.Equal(t, 1, strings.Count(w.Body.String(), `"negotiation":null`))
+}
+
+type metadataService struct{}
+
+func (s *metadataService) Echo(ctx context.Context, args *Args, reply *Reply) error {
+	resMeta := ctx.Value(share.ResMetaDataKey).(map[string]string)
+	for k, v := range ctx.Value(share.ReqMetaDataKey).(map[string]string) {
+		resMeta[k] = v
+	}
+	return nil
+}
+
+// compactMetadataPlugin records whether metadata of requests and responses are compact.
+type compactMetadataPlugin struct {
+	mu      sync.Mutex
+	reqs    []bool
+	resps   []bool
+	reqMeta map[string]string
+}
+
+func (p *compactMetadataPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if r != nil && !r.IsHeartbeat() {
+		p.mu.Lock()
+		p.reqs = append(p.reqs, r.HasCompactMetadata())
+		p.reqMeta = make(map[string]string)
+		for k, v := range r.Metadata {
+			p.reqMeta[k] = v
+		}
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+func (p *compactMetadataPlugin) PostWriteResponse(ctx context.Context, req *protocol.Message, res *protocol.Message, err error) error {
+	if res != nil && !res.IsHeartbeat() {
+		p.mu.Lock()
+		p.resps = append(p.resps, res.HasCompactMetadata())
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+func TestCompactMetadata(t *testing.T) {
+	p := &compactMetadataPlugin{}
+	s := NewServer(WithChecksum())
+	s.Plugins.Add(p)
+	s.RegisterName("Metadata", new(metadataService), "")
+	startServer(t, s, "tcp")
+
+	reqMeta := map[string]string{
+		share.RequestIDKey: "c22a6393efe2affa",
+		"traceparent":      "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"tenant":           "acme",
+	}
+	call := func(opt client.Option) map[string]string {
+		c := client.NewClient(opt)
+		if err := c.Connect("tcp", s.Address().String()); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		resMeta := make(map[string]string)
+		ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, reqMeta)
+		ctx = context.WithValue(ctx, share.ResMetaDataKey, resMeta)
+		assert.NoError(t, c.Call(ctx, "Metadata", "Echo", &Args{A: 1, B: 2}, &Reply{}))
+		delete(resMeta, share.ServerAddress) // set by the client
+		return resMeta
+	}
+
+	// compact metadata are used only if they are negotiated
+	opt := client.DefaultOption
+	opt.NegotiateTimeout = client.DefaultNegotiateTimeout
+	opt.CompactMetadata = true
+	opt.Checksum = true
+	assert.Equal(t, reqMeta, call(opt))
+	opt.NegotiateTimeout = 0
+	assert.Equal(t, reqMeta, call(opt))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	assert.Equal(t, []bool{true, false}, p.reqs)
+	assert.Equal(t, []bool{true, false}, p.resps)
+	assert.Equal(t, reqMeta, p.reqMeta)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+type onewayRecorder struct {
+	mu     sync.Mutex
+	errs   map[string]error
+	writes int32
+	done   chan struct{}
+}
+
+func (p *onewayRecorder) OnewayCompleted(ctx context.Context, req *protocol.Message, err error) {
+	p.mu.Lock()
+	p.errs[req.ServicePath+"."+req.ServiceMethod] = err
+	p.mu.Unlock()
+	p.done <- struct{}{}
+}
+
+func (p *onewayRecorder) PostWriteResponse(ctx context.Context, req *protocol.Message, res *protocol.Message, err error) error {
+	if req.IsOneway() {
+		atomic.AddInt32(&p.writes, 1)
+	}
+	return nil
+}
+
+func (p *onewayRecorder) wait(t *testing.T, name string) error {
+	select {
+	case <-p.done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s is not completed", name)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.errs[name]
+}
+
+func startOnewayServer(t *testing.T) (*Server, *onewayRecorder, *errorService) {
+	recorder := &onewayRecorder{errs: make(map[string]error), done: make(chan struct{}, 10)}
+	svc := &errorService{}
+	s := NewServer()
+	s.Plugins.Add(recorder)
+	s.AuthFunc = func(ctx context.Context, req *protocol.Message, token string) error {
+		if token == "bad" {
+			return errors.New("invalid token")
+		}
+		return nil
+	}
+	s.RegisterName("Err", svc, "")
+	s.RegisterName("Arith", new(Arith), "")
+	s.RegisterName("PanicService", new(PanicService), "")
+	startServer(t, s, "tcp")
+	return s, recorder, svc
+}
+
+func TestOnewayNotify(t *testing.T) {
+	s, recorder, svc := startOnewayServer(t)
+	defer s.Close()
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	assert.NoError(t, c.Notify(context.Background(), "Err", "Get", &Args{A: 3}))
+	assert.NoError(t, recorder.wait(t, "Err.Get"))
+
+	// errors and panics of handlers are counted but don't break the connection
+	assert.NoError(t, c.Notify(context.Background(), "Err", "Get", &Args{A: 2}))
+	assert.EqualError(t, recorder.wait(t, "Err.Get"), "plain error")
+	assert.NoError(t, c.Notify(context.Background(), "PanicService", "Panic", &Args{}))
+	assert.Error(t, recorder.wait(t, "PanicService.Panic"))
+	assert.EqualValues(t, 2, s.Stats().OnewayErrors)
+
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply))
+	assert.Equal(t, 200, reply.C)
+
+	// rejected requests are not handled, and failed auth closes the connection as usual
+	assert.NoError(t, c.Notify(context.Background(), "Err", "Missing", &Args{}))
+	assert.Error(t, recorder.wait(t, "Err.Missing"))
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.AuthKey: "bad"})
+	assert.NoError(t, c.Notify(ctx, "Err", "Get", &Args{A: 3}))
+	assert.EqualError(t, recorder.wait(t, "Err.Get"), "invalid token")
+
+	assert.EqualValues(t, 2, atomic.LoadInt32(&svc.calls))
+	assert.EqualValues(t, 4, s.Stats().OnewayErrors)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&recorder.writes))
+}
+
+func TestOnewayNoResponse(t *testing.T) {
+	s, recorder, _ := startOnewayServer(t)
+	defer s.Close()
+
+	conn := dialHeartbeat(t, s.Address().String())
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	protocol.Read(r) // heartbeat
+
+	for seq, method := range []string{"Get", "Missing"} {
+		req := protocol.NewMessage()
+		req.SetSerializeType(protocol.JSON)
+		req.SetOneway(true)
+		req.SetSeq(uint64(seq))
+		req.ServicePath = "Err"
+		req.ServiceMethod = method
+		req.Payload = []byte(`{"A":2}`)
+		conn.Write(req.Encode())
+		recorder.wait(t, "Err."+method)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err := r.ReadByte()
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expect no bytes after oneway requests but got %v", err)
+	}
+}
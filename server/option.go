@@ -1,8 +1,12 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
 )
 
 // OptionFn configures options of server.
@@ -24,16 +28,68 @@ func WithTLSConfig(cfg *tls.Config) OptionFn {
 	}
 }
 
-// WithReadTimeout sets readTimeout.
+// WithReadTimeout sets readTimeout, the max time to read a message after its first byte arrives.
+// The deadline is renewed for every message. It is also the idle timeout if WithIdleTimeout is not set.
 func WithReadTimeout(readTimeout time.Duration) OptionFn {
 	return func(s *Server) {
-		s.readTimeout = readTimeout
+		s.runtime().ReadTimeout = readTimeout
 	}
 }
 
-// WithWriteTimeout sets writeTimeout.
+// WithWriteTimeout sets writeTimeout, the max time to write a response or a message pushed by SendMessage.
+// The deadline is renewed for every write and the connection is closed if a write times out.
 func WithWriteTimeout(writeTimeout time.Duration) OptionFn {
 	return func(s *Server) {
-		s.writeTimeout = writeTimeout
+		s.runtime().WriteTimeout = writeTimeout
+	}
+}
+
+// WithContextPool pools contexts of requests to reduce allocations. Contexts are freed after responses are written
+// and plugins of requests are called, so handlers and plugins keeping ctx after they return, for example for goroutines
+// they start, must keep share.Detach(ctx) instead.
+func WithContextPool() OptionFn {
+	return func(s *Server) {
+		s.poolContexts = true
+	}
+}
+
+// getContext gets a context of a request, which is pooled if WithContextPool is set.
+func (s *Server) getContext() *share.Context {
+	if s.poolContexts {
+		return share.GetPooledContext(context.Background())
+	}
+	return share.NewContext(context.Background())
+}
+
+// freeContext frees ctx of a request if it is pooled.
+func (s *Server) freeContext(ctx *share.Context) {
+	if s.poolContexts {
+		share.FreeContext(ctx)
+	}
+}
+
+// WithMetadataRecycling reuses metadata maps of requests for later requests of the connection after requests are completed.
+// Handlers and plugins must not keep the metadata of share.ReqMetaDataKey after they return, or they copy it.
+// By default, the metadata belongs to the handlers, and the maps are not reused.
+func WithMetadataRecycling() OptionFn {
+	return func(s *Server) {
+		s.recycleMetadata = true
+	}
+}
+
+// requestMetadata returns the metadata of req handed to handlers, which is kept from being reused
+// unless WithMetadataRecycling is set.
+func (s *Server) requestMetadata(req *protocol.Message) map[string]string {
+	if !s.recycleMetadata {
+		req.KeepMetadata()
+	}
+	return req.Metadata
+}
+
+// WithValidator sets a global validator for decoded arguments that implement neither Validator nor ContextValidator.
+// It can be used to wire struct-tag based validators.
+func WithValidator(fn func(ctx context.Context, args interface{}) error) OptionFn {
+	return func(s *Server) {
+		s.validator = fn
 	}
 }
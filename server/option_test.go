@@ -20,10 +20,10 @@ func TestOption(t *testing.T) {
 
 	o = WithReadTimeout(time.Second)
 	o(server)
-	assert.Equal(t, time.Second, server.readTimeout)
+	assert.Equal(t, time.Second, server.Settings().ReadTimeout)
 
 	o = WithWriteTimeout(time.Second)
 	o(server)
-	assert.Equal(t, time.Second, server.writeTimeout)
+	assert.Equal(t, time.Second, server.Settings().WriteTimeout)
 }
 
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// maxPanicStackSize is the max size of stacks passed to PanicPlugin.
+const maxPanicStackSize = 8 << 10
+
+// panicError is returned when a panic is recovered while serving a request.
+type panicError struct {
+	err *rerrors.Error
+}
+
+func (e *panicError) Error() string {
+	return e.err.Error()
+}
+
+func (e *panicError) Unwrap() error {
+	return e.err
+}
+
+func isPanicError(err error) bool {
+	_, ok := err.(*panicError)
+	return ok
+}
+
+// handlePanic logs the recovered panic, invokes PanicPlugin and returns an Internal error for the client.
+// The panic value is only contained in the error if ExposePanicDetails is set.
+func (s *Server) handlePanic(ctx context.Context, servicePath, serviceMethod string, recovered interface{}, stack []byte) error {
+	stack = trimStack(stack)
+	id := fmt.Sprintf("%016x", rand.Uint64())
+
+	log.Errorf("rpcx: panic serving %s.%s, id: %s: %v\n%s", servicePath, serviceMethod, id, recovered, stack)
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("rpcx: panic in HandlePanic plugin: %v", r)
+			}
+		}()
+		s.Plugins.DoHandlePanic(ctx, servicePath, serviceMethod, recovered, stack)
+	}()
+
+	if s.ExposePanicDetails {
+		return &panicError{err: rerrors.Errorf(rerrors.Internal, "rpcx: panic: %v, id: %s", recovered, id)}
+	}
+	return &panicError{err: rerrors.Errorf(rerrors.Internal, "rpcx: internal error, id: %s", id)}
+}
+
+// safeCall invokes fn and converts a panic into an error returned by handlePanic.
+func (s *Server) safeCall(ctx context.Context, req *protocol.Message, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = s.handlePanic(ctx, req.ServicePath, req.ServiceMethod, r, debug.Stack())
+		}
+	}()
+	return fn()
+}
+
+// trimStack removes frames of the recovery itself so that the stack starts where the panic happened.
+func trimStack(stack []byte) []byte {
+	if i := bytes.Index(stack, []byte("\npanic(")); i >= 0 {
+		rest := stack[i+1:]
+		// skip the panic frame: function line and file line
+		for n := 0; n < 2; n++ {
+			j := bytes.IndexByte(rest, '\n')
+			if j < 0 {
+				rest = rest[len(rest):]
+				break
+			}
+			rest = rest[j+1:]
+		}
+		if k := bytes.IndexByte(stack, '\n'); k >= 0 {
+			stack = append(append([]byte{}, stack[:k+1]...), rest...)
+		}
+	}
+	if len(stack) > maxPanicStackSize {
+		stack = stack[:maxPanicStackSize]
+	}
+	return stack
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+type PanicService int
+
+func (t *PanicService) Panic(ctx context.Context, args *Args, reply *Reply) error {
+	panic("secret panic value")
+}
+
+type panicRecorder struct {
+	count int32
+
+	mu    sync.Mutex
+	stack []byte
+}
+
+func (p *panicRecorder) HandlePanic(ctx context.Context, servicePath, serviceMethod string, recovered interface{}, stack []byte) {
+	p.mu.Lock()
+	p.stack = stack
+	p.mu.Unlock()
+	atomic.AddInt32(&p.count, 1)
+}
+
+func (p *panicRecorder) lastStack() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return string(p.stack)
+}
+
+type panicPostReadPlugin struct{}
+
+func (p *panicPostReadPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if r.ServiceMethod == "PanicInPlugin" {
+		panic("panic in plugin")
+	}
+	return nil
+}
+
+func TestPanicRecovery(t *testing.T) {
+	recorder := &panicRecorder{}
+	s := NewServer()
+	s.Plugins.Add(recorder)
+	s.Plugins.Add(&panicPostReadPlugin{})
+	s.RegisterName("Arith", new(Arith), "")
+	s.RegisterName("PanicService", new(PanicService), "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	err := c.Connect("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	reply := &Reply{}
+	err = c.Call(context.Background(), "PanicService", "Panic", &Args{A: 1, B: 2}, reply)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "rpcx: internal error, id: ")
+		assert.NotContains(t, err.Error(), "secret")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&recorder.count))
+	assert.True(t, strings.Contains(recorder.lastStack(), "(*PanicService).Panic"), "stack should contain the panicking method")
+
+	err = c.Call(context.Background(), "Arith", "PanicInPlugin", &Args{A: 1, B: 2}, reply)
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&recorder.count))
+
+	// the connection is still usable
+	err = c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, reply.C)
+
+	// and exposed by servers with ExposePanicDetails
+	s2 := NewServer()
+	s2.ExposePanicDetails = true
+	s2.RegisterName("PanicService", new(PanicService), "")
+	go s2.Serve("tcp", "127.0.0.1:0")
+	defer s2.Close()
+	time.Sleep(100 * time.Millisecond)
+	c2 := client.NewClient(client.DefaultOption)
+	if err := c2.Connect("tcp", s2.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c2.Close()
+	err = c2.Call(context.Background(), "PanicService", "Panic", &Args{A: 1, B: 2}, reply)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "secret panic value")
+	}
+}
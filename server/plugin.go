@@ -19,9 +19,14 @@ type PluginContainer interface {
 	DoRegister(name string, rcvr interface{}, metadata string) error
 	DoRegisterFunction(serviceName, fname string, fn interface{}, metadata string) error
 	DoUnregister(name string) error
+	DoUpdateMetadata(name, metadata string) error
+	DoUnregisterRegistration(name, registration string, rcvr interface{}, metadata []string) error
 
 	DoPostConnAccept(net.Conn) (net.Conn, bool)
 	DoPostConnClose(net.Conn) bool
+	DoPostConnCloseReason(conn net.Conn, reason string)
+	DoConnRejected(conn net.Conn, reason string)
+	DoConnNegotiated(conn net.Conn, negotiation Negotiation)
 
 	DoPreReadRequest(ctx context.Context) error
 	DoPostReadRequest(ctx context.Context, r *protocol.Message, e error) error
@@ -31,6 +36,7 @@ type PluginContainer interface {
 	DoPostCall(ctx context.Context, serviceName, methodName string, args, reply interface{}) (interface{}, error)
 
 	DoPreWriteResponse(context.Context, *protocol.Message, *protocol.Message, error) error
+	DoPreEncodeResponse(ctx context.Context, req, res *protocol.Message) error
 	DoPostWriteResponse(context.Context, *protocol.Message, *protocol.Message, error) error
 
 	DoPreWriteRequest(ctx context.Context) error
@@ -38,6 +44,11 @@ type PluginContainer interface {
 
 	DoHeartbeatRequest(ctx context.Context, req *protocol.Message) error
 
+	DoRequestRejected(ctx context.Context, req *protocol.Message, reason string, err error)
+	DoOnewayCompleted(ctx context.Context, req *protocol.Message, err error)
+
+	DoHandlePanic(ctx context.Context, servicePath, serviceMethod string, recovered interface{}, stack []byte)
+
 	MuxMatch(m cmux.CMux)
 }
 
@@ -56,6 +67,18 @@ type (
 		RegisterFunction(serviceName, fname string, fn interface{}, metadata string) error
 	}
 
+	// UpdateMetadataPlugin updates metadata of registered services in place,
+	// so that discovery sees an update rather than a remove and an add.
+	UpdateMetadataPlugin interface {
+		UpdateMetadata(name, metadata string) error
+	}
+
+	// UnregisterRegistrationPlugin unregisters a registration of a service registered more than once,
+	// see share.MetaRegistration, and keeps the other registrations.
+	UnregisterRegistrationPlugin interface {
+		UnregisterRegistration(name, registration string) error
+	}
+
 	// PostConnAcceptPlugin represents connection accept plugin.
 	// if returns false, it means subsequent IPostConnAcceptPlugins should not continue to handle this conn
 	// and this conn has been closed.
@@ -68,12 +91,31 @@ type (
 		HandleConnClose(net.Conn) bool
 	}
 
+	// PostConnCloseReasonPlugin represents client connection close plugin which also gets why the connection is closed,
+	// for example CloseReasonIdleTimeout.
+	PostConnCloseReasonPlugin interface {
+		HandleConnCloseReason(conn net.Conn, reason string)
+	}
+
+	// ConnRejectedPlugin is notified when a connection is rejected by connection limits.
+	ConnRejectedPlugin interface {
+		HandleConnRejected(conn net.Conn, reason string)
+	}
+
+	// ConnNegotiatedPlugin is notified when the client of a connection negotiates the protocol on connect.
+	// Clients of old versions don't negotiate.
+	ConnNegotiatedPlugin interface {
+		HandleConnNegotiated(conn net.Conn, negotiation Negotiation)
+	}
+
 	// PreReadRequestPlugin represents .
 	PreReadRequestPlugin interface {
 		PreReadRequest(ctx context.Context) error
 	}
 
 	// PostReadRequestPlugin represents .
+	// Requests have been decrypted, reassembled from chunks, verified by checksums and decompressed,
+	// so it is the counterpart of PreEncodeResponsePlugin to verify or transform requests before they are handled.
 	PostReadRequestPlugin interface {
 		PostReadRequest(ctx context.Context, r *protocol.Message, e error) error
 	}
@@ -96,6 +138,14 @@ type (
 		PreWriteResponse(context.Context, *protocol.Message, *protocol.Message, error) error
 	}
 
+	// PreEncodeResponsePlugin is invoked just before responses are encoded, after the metadata of handlers is merged and
+	// the compress type, checksum and chunk flags are set, so plugins may sign or transform the payload and metadata
+	// as they are sent. Responses are then compressed, checksummed, chunked and encrypted by session encryption or TLS.
+	// If it returns an error, the error is sent as the response instead.
+	PreEncodeResponsePlugin interface {
+		PreEncodeResponse(ctx context.Context, req, res *protocol.Message) error
+	}
+
 	// PostWriteResponsePlugin represents .
 	PostWriteResponsePlugin interface {
 		PostWriteResponse(context.Context, *protocol.Message, *protocol.Message, error) error
@@ -116,6 +166,24 @@ type (
 		HeartbeatRequest(ctx context.Context, req *protocol.Message) error
 	}
 
+	// RequestRejectedPlugin is notified when a request is rejected before its handler is invoked.
+	// reason describes why, for example RejectReasonValidation.
+	RequestRejectedPlugin interface {
+		RequestRejected(ctx context.Context, req *protocol.Message, reason string, err error)
+	}
+
+	// OnewayCompletedPlugin is notified when a oneway request has been handled, with the error of the handler if it fails.
+	// No response is written for oneway requests, so PreWriteResponse and PostWriteResponse plugins are not invoked for them.
+	OnewayCompletedPlugin interface {
+		OnewayCompleted(ctx context.Context, req *protocol.Message, err error)
+	}
+
+	// PanicPlugin is notified when a panic is recovered while serving a request,
+	// for example to report it to an error tracker. stack starts at the frame that panicked.
+	PanicPlugin interface {
+		HandlePanic(ctx context.Context, servicePath, serviceMethod string, recovered interface{}, stack []byte)
+	}
+
 	CMuxPlugin interface {
 		MuxMatch(m cmux.CMux)
 	}
@@ -205,6 +273,53 @@ func (p *pluginContainer) DoUnregister(name string) error {
 	return nil
 }
 
+// DoUpdateMetadata invokes UpdateMetadataPlugin.
+func (p *pluginContainer) DoUpdateMetadata(name, metadata string) error {
+	var es []error
+	for _, rp := range p.plugins {
+		if plugin, ok := rp.(UpdateMetadataPlugin); ok {
+			err := plugin.UpdateMetadata(name, metadata)
+			if err != nil {
+				es = append(es, err)
+			}
+		}
+	}
+
+	if len(es) > 0 {
+		return errors.NewMultiError(es)
+	}
+	return nil
+}
+
+// DoUnregisterRegistration invokes UnregisterRegistrationPlugin. RegisterPlugins that don't implement it
+// unregister the service and register it again with metadata of its other registrations.
+func (p *pluginContainer) DoUnregisterRegistration(name, registration string, rcvr interface{}, metadata []string) error {
+	var es []error
+	for _, rp := range p.plugins {
+		if plugin, ok := rp.(UnregisterRegistrationPlugin); ok {
+			if err := plugin.UnregisterRegistration(name, registration); err != nil {
+				es = append(es, err)
+			}
+			continue
+		}
+		if plugin, ok := rp.(RegisterPlugin); ok {
+			if err := plugin.Unregister(name); err != nil {
+				es = append(es, err)
+			}
+			for _, md := range metadata {
+				if err := plugin.Register(name, rcvr, md); err != nil {
+					es = append(es, err)
+				}
+			}
+		}
+	}
+
+	if len(es) > 0 {
+		return errors.NewMultiError(es)
+	}
+	return nil
+}
+
 // DoPostConnAccept handles accepted conn
 func (p *pluginContainer) DoPostConnAccept(conn net.Conn) (net.Conn, bool) {
 	var flag bool
@@ -234,6 +349,33 @@ func (p *pluginContainer) DoPostConnClose(conn net.Conn) bool {
 	return true
 }
 
+// DoPostConnCloseReason handles closed conn with the reason
+func (p *pluginContainer) DoPostConnCloseReason(conn net.Conn, reason string) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(PostConnCloseReasonPlugin); ok {
+			plugin.HandleConnCloseReason(conn, reason)
+		}
+	}
+}
+
+// DoConnRejected handles rejected conn
+func (p *pluginContainer) DoConnRejected(conn net.Conn, reason string) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(ConnRejectedPlugin); ok {
+			plugin.HandleConnRejected(conn, reason)
+		}
+	}
+}
+
+// DoConnNegotiated invokes ConnNegotiatedPlugin.
+func (p *pluginContainer) DoConnNegotiated(conn net.Conn, negotiation Negotiation) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(ConnNegotiatedPlugin); ok {
+			plugin.HandleConnNegotiated(conn, negotiation)
+		}
+	}
+}
+
 // DoPreReadRequest invokes PreReadRequest plugin.
 func (p *pluginContainer) DoPreReadRequest(ctx context.Context) error {
 	for i := range p.plugins {
@@ -320,6 +462,19 @@ func (p *pluginContainer) DoPreWriteResponse(ctx context.Context, req *protocol.
 	return nil
 }
 
+// DoPreEncodeResponse invokes PreEncodeResponse plugin.
+func (p *pluginContainer) DoPreEncodeResponse(ctx context.Context, req, res *protocol.Message) error {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(PreEncodeResponsePlugin); ok {
+			if err := plugin.PreEncodeResponse(ctx, req, res); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // DoPostWriteResponse invokes PostWriteResponse plugin.
 func (p *pluginContainer) DoPostWriteResponse(ctx context.Context, req *protocol.Message, resp *protocol.Message, e error) error {
 	for i := range p.plugins {
@@ -376,6 +531,33 @@ func (p *pluginContainer) DoHeartbeatRequest(ctx context.Context, r *protocol.Me
 	return nil
 }
 
+// DoRequestRejected invokes RequestRejected plugin.
+func (p *pluginContainer) DoRequestRejected(ctx context.Context, r *protocol.Message, reason string, err error) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(RequestRejectedPlugin); ok {
+			plugin.RequestRejected(ctx, r, reason, err)
+		}
+	}
+}
+
+// DoOnewayCompleted invokes OnewayCompleted plugin.
+func (p *pluginContainer) DoOnewayCompleted(ctx context.Context, r *protocol.Message, err error) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(OnewayCompletedPlugin); ok {
+			plugin.OnewayCompleted(ctx, r, err)
+		}
+	}
+}
+
+// DoHandlePanic invokes HandlePanic plugin.
+func (p *pluginContainer) DoHandlePanic(ctx context.Context, servicePath, serviceMethod string, recovered interface{}, stack []byte) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(PanicPlugin); ok {
+			plugin.HandlePanic(ctx, servicePath, serviceMethod, recovered, stack)
+		}
+	}
+}
+
 // MuxMatch adds cmux Match.
 func (p *pluginContainer) MuxMatch(m cmux.CMux) {
 	for i := range p.plugins {
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"net"
 	"sync"
 	"testing"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/smallnest/rpcx/client"
 	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
 )
 
 type HeartbeatHandler struct{}
@@ -51,7 +53,8 @@ func TestPluginHeartbeat(t *testing.T) {
 		// PeerDiscovery
 		d, err := client.NewPeer2PeerDiscovery("tcp@127.0.0.1:9001", "")
 		if err != nil {
-			t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+			t.Errorf("failed to NewPeer2PeerDiscovery: %v", err)
+			return
 		}
 
 		c := client.NewXClient("Arith", client.Failtry, client.RoundRobin, d, opts)
@@ -73,3 +76,57 @@ func TestPluginHeartbeat(t *testing.T) {
 	}()
 	wg.Wait()
 }
+
+// preEncodePlugin marks responses in metadata, or fails them by the "hook" metadata of requests.
+type preEncodePlugin struct{}
+
+func (preEncodePlugin) PreEncodeResponse(ctx context.Context, req, res *protocol.Message) error {
+	if req.Metadata["hook"] == "fail" {
+		return errors.New("encode hook failed")
+	}
+	if res.Metadata == nil {
+		res.Metadata = make(map[string]string)
+	}
+	res.Metadata["encoded"] = "1"
+	return nil
+}
+
+func TestPluginPreEncodeResponse(t *testing.T) {
+	s := NewServer()
+	s.Plugins.Add(preEncodePlugin{})
+	s.RegisterName("Arith", new(Arith), "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	call := func(hook string) (map[string]string, error) {
+		ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"hook": hook})
+		ctx = context.WithValue(ctx, share.ResMetaDataKey, make(map[string]string))
+		reply := &Reply{}
+		err := c.Call(ctx, "Arith", "Mul", &Args{A: 2, B: 3}, reply)
+		if err == nil && reply.C != 6 {
+			t.Fatalf("expect 6 but got %d", reply.C)
+		}
+		return ctx.Value(share.ResMetaDataKey).(map[string]string), err
+	}
+
+	meta, err := call("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta["encoded"] != "1" {
+		t.Fatalf("expect the response to be marked but got %v", meta)
+	}
+
+	// the error of the hook is sent instead of the response, and the connection is still usable
+	if _, err = call("fail"); err == nil || err.Error() != "encode hook failed" {
+		t.Fatalf("expect the error of the hook but got %v", err)
+	}
+	if _, err = call(""); err != nil {
+		t.Fatal(err)
+	}
+}
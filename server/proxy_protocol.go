@@ -0,0 +1,456 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/log"
+)
+
+// RejectReasonProxyHeader is the reason passed to ConnRejectedPlugin, and counted in Stats.ConnsRejected,
+// when the PROXY protocol header of a connection is malformed, or absent while it is required.
+const RejectReasonProxyHeader = "proxy_header"
+
+// RejectReasonProxyHeaderTimeout is the reason passed to ConnRejectedPlugin, and counted in Stats.ConnsRejected,
+// when the PROXY protocol header of a connection is required and it is not read in time.
+const RejectReasonProxyHeaderTimeout = "proxy_header_timeout"
+
+// DefaultProxyHeaderTimeout is the default time to wait for PROXY protocol headers, see ProxyProtocolPolicy.
+const DefaultProxyHeaderTimeout = time.Second
+
+// ProxyProtocolMode tells whether PROXY protocol headers are required.
+type ProxyProtocolMode int
+
+const (
+	// ProxyProtocolOptional accepts connections with or without PROXY protocol headers.
+	ProxyProtocolOptional ProxyProtocolMode = iota
+	// ProxyProtocolRequired rejects connections of trusted proxies without PROXY protocol headers.
+	ProxyProtocolRequired
+)
+
+// ProxyProtocolPolicy configures WithProxyProtocol.
+type ProxyProtocolPolicy struct {
+	Mode ProxyProtocolMode
+	// TrustedProxies are CIDRs or IPs of the proxies whose headers are read. Headers of other sources are not read,
+	// so their connections fail as bad frames, and clients can't spoof their addresses by sending headers.
+	// Empty means all sources are trusted. Connections without IPs, such as unix ones, are always trusted.
+	TrustedProxies []string
+	// HeaderTimeout bounds how long to wait for headers, DefaultProxyHeaderTimeout if zero. If nothing is read
+	// in time, connections are served without headers in the optional mode, and rejected in the required mode.
+	// It is at most half of the window of WithFirstRequestTimeout.
+	HeaderTimeout time.Duration
+}
+
+// ProxyHeader is the PROXY protocol header of a connection.
+type ProxyHeader struct {
+	// Version is 1 or 2.
+	Version int
+	// Source and Destination are the addresses of the client and the proxy which it connects to.
+	// They are nil for headers of the LOCAL command or the UNKNOWN protocol, such as health checks of proxies,
+	// whose connections are served with the addresses of the proxy.
+	Source      net.Addr
+	Destination net.Addr
+	// TLVs are the type-length-value fields of version 2.
+	TLVs []ProxyTLV
+}
+
+// ProxyTLV is a type-length-value field of version 2 of the PROXY protocol.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// WithProxyProtocol reads PROXY protocol headers, sent by proxies such as HAProxy or NLB before connections
+// are relayed, on tcp, unix, reuseport and the listeners of the gateway, websocket and h2c.
+// Connections report the addresses of their clients by RemoteAddr and the addresses of proxies by LocalAddr,
+// so per-IP connection limits, Connections, logs and plugins see real clients, see RemoteAddrFromContext.
+// Headers are read before TLS handshakes and before connections are accepted, without blocking other connections.
+func WithProxyProtocol(policy ProxyProtocolPolicy) OptionFn {
+	return func(s *Server) {
+		p := &proxyProtocol{mode: policy.Mode, timeout: policy.HeaderTimeout}
+		if p.timeout <= 0 {
+			p.timeout = DefaultProxyHeaderTimeout
+		}
+		for _, cidr := range policy.TrustedProxies {
+			if !strings.Contains(cidr, "/") {
+				if ip := net.ParseIP(cidr); ip != nil {
+					bits := 8 * len(ip.To16())
+					if ip.To4() != nil {
+						ip, bits = ip.To4(), 32
+					}
+					p.trusted = append(p.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+					continue
+				}
+			}
+			_, ipnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				log.Errorf("rpcx: invalid trusted proxy %s is ignored: %v", cidr, err)
+				continue
+			}
+			p.trusted = append(p.trusted, ipnet)
+		}
+		s.proxyProtocol = p
+	}
+}
+
+// RemoteAddrFromContext returns the address of the client of the request handled with ctx, which is the source
+// of the PROXY protocol header of its connection if there is one, or the source of its datagram over udp.
+func RemoteAddrFromContext(ctx context.Context) net.Addr {
+	switch v := ctx.Value(RemoteConnContextKey).(type) {
+	case net.Conn:
+		return v.RemoteAddr()
+	case net.Addr:
+		return v
+	}
+	return nil
+}
+
+// ProxyHeaderFromContext returns the PROXY protocol header of the connection of the request handled with ctx.
+// It is nil if there is none, or if the connection is a TLS one, which hides the connection under it.
+func ProxyHeaderFromContext(ctx context.Context) *ProxyHeader {
+	conn, _ := ctx.Value(RemoteConnContextKey).(net.Conn)
+	if pc, ok := netConn(conn).(*proxyConn); ok {
+		return pc.header
+	}
+	return nil
+}
+
+type proxyProtocol struct {
+	mode    ProxyProtocolMode
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+func (p *proxyProtocol) trusts(addr net.Addr) bool {
+	if len(p.trusted) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return true
+	}
+	for _, ipnet := range p.trusted {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyListener wraps ln to read PROXY protocol headers if WithProxyProtocol is set.
+func (s *Server) proxyListener(ln net.Listener) net.Listener {
+	if s.proxyProtocol == nil {
+		return ln
+	}
+	l := &proxyListener{Listener: ln, s: s, accepted: make(chan acceptedConn), closed: make(chan struct{})}
+	go l.serve()
+	return l
+}
+
+type acceptedConn struct {
+	conn net.Conn
+	err  error
+}
+
+// proxyListener accepts connections of ln, and returns them after their headers are read in their own goroutines.
+type proxyListener struct {
+	net.Listener
+	s         *Server
+	accepted  chan acceptedConn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *proxyListener) serve() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.accepted <- acceptedConn{err: err}:
+			case <-l.closed:
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		go l.readHeader(conn)
+	}
+}
+
+func (l *proxyListener) readHeader(conn net.Conn) {
+	p := l.s.proxyProtocol
+	if !p.trusts(conn.RemoteAddr()) {
+		l.deliver(conn)
+		return
+	}
+
+	settings := l.s.runtime()
+	if !l.s.beginPending(settings.MaxPendingConnections) {
+		l.s.dropConn(conn, RejectReasonMaxPendingConnections, errTooManyPendingConns)
+		return
+	}
+	timeout := p.timeout
+	if d := settings.FirstRequestTimeout / 2; d != 0 && d < timeout {
+		timeout = d
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	pc, err := readProxyHeader(conn, p.mode == ProxyProtocolRequired)
+	conn.SetReadDeadline(time.Time{})
+	l.s.endPending()
+	if err != nil {
+		reason := RejectReasonProxyHeader
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			reason = RejectReasonProxyHeaderTimeout
+		}
+		l.s.dropConn(conn, reason, err)
+		return
+	}
+	l.deliver(pc)
+}
+
+func (l *proxyListener) deliver(conn net.Conn) {
+	select {
+	case l.accepted <- acceptedConn{conn: conn}:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case a := <-l.accepted:
+		return a.conn, a.err
+	case <-l.closed:
+		return nil, &net.OpError{Op: "accept", Net: l.Addr().Network(), Addr: l.Addr(), Err: net.ErrClosed}
+	}
+}
+
+func (l *proxyListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+const (
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLength = 107
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyConn is a connection relayed by a proxy, whose addresses are the ones of its PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader // bytes read after the header, nil once they are read
+	header *ProxyHeader
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if c.r != nil {
+		if c.r.Buffered() > 0 {
+			return c.r.Read(b)
+		}
+		c.r = nil
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.header != nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.header != nil && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader reads the PROXY protocol header of conn. Connections without headers are returned
+// with the bytes read unless required. Errors are timeouts of required headers, or malformed headers.
+func readProxyHeader(conn net.Conn, required bool) (*proxyConn, error) {
+	r := bufio.NewReaderSize(conn, 256)
+	pc := &proxyConn{Conn: conn, r: r}
+
+	b, err := r.Peek(1)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && !required {
+			return pc, nil
+		}
+		return nil, fmt.Errorf("failed to read the PROXY protocol header: %w", err)
+	}
+	var signature []byte
+	switch b[0] {
+	case proxyV1Prefix[0]:
+		signature = []byte(proxyV1Prefix)
+	case proxyV2Signature[0]:
+		signature = proxyV2Signature
+	}
+	if signature != nil {
+		// it is a header only if all of the signature matches, for example "POST" of the gateway is not
+		b, err = r.Peek(len(signature))
+		if err != nil && !(len(b) > 0 && !bytes.HasPrefix(signature, b)) {
+			return nil, fmt.Errorf("failed to read the PROXY protocol header: %w", err)
+		}
+		if !bytes.Equal(b, signature) {
+			signature = nil
+		}
+	}
+	if signature == nil {
+		if required {
+			return nil, errors.New("PROXY protocol header is required")
+		}
+		return pc, nil
+	}
+
+	if signature[0] == proxyV1Prefix[0] {
+		pc.header, err = readProxyV1(r)
+	} else {
+		pc.header, err = readProxyV2(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed PROXY protocol header: %w", err)
+	}
+	return pc, nil
+}
+
+// readProxyV1 reads the header of version 1, such as "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (*ProxyHeader, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("header of version 1 is too long")
+	}
+
+	header := &ProxyHeader{Version: 1}
+	fields := strings.Split(string(line[len(proxyV1Prefix):len(line)-2]), " ")
+	switch fields[0] {
+	case "UNKNOWN":
+		return header, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", fields[0])
+	}
+	if len(fields) != 5 {
+		return nil, errors.New("invalid addresses of version 1")
+	}
+	src, err := proxyV1Addr(fields[1], fields[3], fields[0] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := proxyV1Addr(fields[2], fields[4], fields[0] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	header.Source, header.Destination = src, dst
+	return header, nil
+}
+
+func proxyV1Addr(host, port string, ipv4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != ipv4 {
+		return nil, fmt.Errorf("invalid address %q", host)
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(n)}, nil
+}
+
+// readProxyV2 reads the binary header of version 2.
+func readProxyV2(r *bufio.Reader) (*ProxyHeader, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", fixed[12]>>4)
+	}
+	command, family, protocol := fixed[12]&0xf, fixed[13]>>4, fixed[13]&0xf
+	data := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	header := &ProxyHeader{Version: 2}
+	switch command {
+	case 0: // LOCAL
+		return header, nil
+	case 1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported command %d", command)
+	}
+
+	var size int
+	switch family {
+	case 0: // UNSPEC
+	case 1: // INET
+		size = 12
+	case 2: // INET6
+		size = 36
+	case 3: // UNIX
+		size = 216
+	default:
+		return nil, fmt.Errorf("unsupported address family %d", family)
+	}
+	if len(data) < size {
+		return nil, errors.New("addresses of version 2 are truncated")
+	}
+	addrs, tlvs := data[:size], data[size:]
+	switch family {
+	case 1, 2:
+		n := (size - 4) / 2
+		src := &net.TCPAddr{IP: net.IP(addrs[:n]), Port: int(binary.BigEndian.Uint16(addrs[2*n:]))}
+		dst := &net.TCPAddr{IP: net.IP(addrs[n : 2*n]), Port: int(binary.BigEndian.Uint16(addrs[2*n+2:]))}
+		if protocol == 2 { // DGRAM
+			header.Source, header.Destination = &net.UDPAddr{IP: src.IP, Port: src.Port}, &net.UDPAddr{IP: dst.IP, Port: dst.Port}
+		} else {
+			header.Source, header.Destination = src, dst
+		}
+	case 3:
+		header.Source = &net.UnixAddr{Name: string(bytes.TrimRight(addrs[:108], "\x00")), Net: "unix"}
+		header.Destination = &net.UnixAddr{Name: string(bytes.TrimRight(addrs[108:], "\x00")), Net: "unix"}
+	}
+
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, errors.New("TLV of version 2 is truncated")
+		}
+		n := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+n {
+			return nil, errors.New("TLV of version 2 is truncated")
+		}
+		header.TLVs = append(header.TLVs, ProxyTLV{Type: tlvs[0], Value: tlvs[3 : 3+n]})
+		tlvs = tlvs[3+n:]
+	}
+	return header, nil
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/stretchr/testify/assert"
+)
+
+type addrService struct{}
+
+func (addrService) Remote(ctx context.Context, args *Args, reply *string) error {
+	*reply = RemoteAddrFromContext(ctx).String()
+	return nil
+}
+
+func (addrService) TLVs(ctx context.Context, args *Args, reply *[]string) error {
+	if header := ProxyHeaderFromContext(ctx); header != nil {
+		*reply = append(*reply, header.Destination.String())
+		for _, tlv := range header.TLVs {
+			*reply = append(*reply, fmt.Sprintf("%d=%s", tlv.Type, tlv.Value))
+		}
+	}
+	return nil
+}
+
+func startProxyServer(t *testing.T, options ...OptionFn) string {
+	s := NewServer(options...)
+	s.RegisterName("Addr", addrService{}, "")
+	startServer(t, s, "tcp")
+	return s.Address().String()
+}
+
+// connectProxied connects to addr by a connection which sends header first, like a proxy.
+func connectProxied(t *testing.T, addr string, header []byte) (*client.Client, error) {
+	network := fmt.Sprintf("proxied-%d", time.Now().UnixNano())
+	client.ConnFactories[network] = func(c *client.Client, network, address string) (net.Conn, error) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		_, err = conn.Write(header)
+		return conn, err
+	}
+	t.Cleanup(func() { delete(client.ConnFactories, network) })
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect(network, addr); err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, nil
+}
+
+func remoteAddr(c *client.Client) (string, error) {
+	var reply string
+	err := c.Call(context.Background(), "Addr", "Remote", &Args{}, &reply)
+	return reply, err
+}
+
+func proxyV2Header(src, dst *net.TCPAddr, tlvs ...ProxyTLV) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x21, 0x11, 0, 0)
+	header = append(header, src.IP.To4()...)
+	header = append(header, dst.IP.To4()...)
+	header = append(header, byte(src.Port>>8), byte(src.Port), byte(dst.Port>>8), byte(dst.Port))
+	for _, tlv := range tlvs {
+		header = append(header, tlv.Type, byte(len(tlv.Value)>>8), byte(len(tlv.Value)))
+		header = append(header, tlv.Value...)
+	}
+	binary.BigEndian.PutUint16(header[14:], uint16(len(header)-16))
+	return header
+}
+
+func TestProxyProtocolV1(t *testing.T) {
+	var s *Server
+	addr := startProxyServer(t, WithProxyProtocol(ProxyProtocolPolicy{}), WithMaxConnectionsPerIP(1), func(srv *Server) { s = srv })
+
+	c, err := connectProxied(t, addr, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := remoteAddr(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:56324", remote)
+	if conns := s.Connections(); assert.Len(t, conns, 1) {
+		assert.Equal(t, "192.0.2.1:56324", conns[0].RemoteAddr)
+	}
+
+	// the per-IP limit counts real clients
+	c2, err := connectProxied(t, addr, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56325 443\r\n"))
+	if err == nil {
+		_, err = remoteAddr(c2)
+	}
+	assert.Error(t, err)
+	c3, err := connectProxied(t, addr, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1000 443\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err = remoteAddr(c3)
+	assert.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:1000", remote)
+
+	// clients without headers are served in the optional mode
+	plain := client.NewClient(client.DefaultOption)
+	if err := plain.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	remote, err = remoteAddr(plain)
+	assert.NoError(t, err)
+	assert.Contains(t, remote, "127.0.0.1:")
+}
+
+func TestProxyProtocolV2(t *testing.T) {
+	addr := startProxyServer(t, WithProxyProtocol(ProxyProtocolPolicy{Mode: ProxyProtocolRequired}))
+
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8972}
+	header := proxyV2Header(src, dst, ProxyTLV{Type: 0x01, Value: []byte("h2")}, ProxyTLV{Type: 0xe0, Value: []byte("tenant-a")})
+	c, err := connectProxied(t, addr, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := remoteAddr(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.7:1234", remote)
+
+	var tlvs []string
+	assert.NoError(t, c.Call(context.Background(), "Addr", "TLVs", &Args{}, &tlvs))
+	assert.Equal(t, []string{"10.0.0.1:8972", "1=h2", "224=tenant-a"}, tlvs)
+
+	// LOCAL headers of health checks keep the addresses of proxies
+	local := append(append([]byte{}, proxyV2Signature...), 0x20, 0x00, 0, 0)
+	c, err = connectProxied(t, addr, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err = remoteAddr(c)
+	assert.NoError(t, err)
+	assert.Contains(t, remote, "127.0.0.1:")
+}
+
+func TestProxyProtocolAbsent(t *testing.T) {
+	var s *Server
+	addr := startProxyServer(t, WithProxyProtocol(ProxyProtocolPolicy{Mode: ProxyProtocolRequired, HeaderTimeout: 50 * time.Millisecond}),
+		func(srv *Server) { s = srv })
+
+	plain := client.NewClient(client.DefaultOption)
+	err := plain.Connect("tcp", addr)
+	if err == nil {
+		defer plain.Close()
+		_, err = remoteAddr(plain)
+	}
+	assert.Error(t, err)
+
+	// silent connections are rejected when the header times out
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Equal(t, uint64(1), s.Stats().ConnsRejected[RejectReasonProxyHeader])
+	assert.Equal(t, uint64(1), s.Stats().ConnsRejected[RejectReasonProxyHeaderTimeout])
+
+	// but served without headers in the optional mode
+	addr = startProxyServer(t, WithProxyProtocol(ProxyProtocolPolicy{HeaderTimeout: 50 * time.Millisecond}))
+	client.ConnFactories["proxy-silent"] = func(c *client.Client, network, address string) (net.Conn, error) {
+		conn, err := net.Dial("tcp", address)
+		time.Sleep(150 * time.Millisecond)
+		return conn, err
+	}
+	defer delete(client.ConnFactories, "proxy-silent")
+	silent := client.NewClient(client.DefaultOption)
+	if err := silent.Connect("proxy-silent", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	remote, err := remoteAddr(silent)
+	assert.NoError(t, err)
+	assert.Contains(t, remote, "127.0.0.1:")
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	addr := startProxyServer(t, WithProxyProtocol(ProxyProtocolPolicy{Mode: ProxyProtocolRequired, TrustedProxies: []string{"10.1.2.3", "192.168.0.0/16"}}))
+
+	// headers of untrusted sources are not read, so they fail as bad frames and can't spoof addresses
+	c, err := connectProxied(t, addr, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"))
+	if err == nil {
+		_, err = remoteAddr(c)
+	}
+	assert.Error(t, err)
+
+	plain := client.NewClient(client.DefaultOption)
+	if err := plain.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	remote, err := remoteAddr(plain)
+	assert.NoError(t, err)
+	assert.Contains(t, remote, "127.0.0.1:")
+}
+
+func TestProxyProtocolMalformed(t *testing.T) {
+	var s *Server
+	addr := startProxyServer(t, WithProxyProtocol(ProxyProtocolPolicy{}), func(srv *Server) { s = srv })
+
+	for _, header := range [][]byte{
+		[]byte("PROXY TCP4 bogus\r\n"),
+		[]byte("PROXY TCP4 192.0.2.1 198.51.100.1 99999 443\r\n"),
+		[]byte("PROXY TCP4 2001:db8::1 198.51.100.1 1 443\r\n"),
+		append(append([]byte{}, proxyV2Signature...), 0x21, 0x11, 0, 4, 1, 2, 3, 4),
+	} {
+		c, err := connectProxied(t, addr, header)
+		if err == nil {
+			_, err = remoteAddr(c)
+		}
+		assert.Error(t, err, "header %q", header)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint64(4), s.Stats().ConnsRejected[RejectReasonProxyHeader])
+	assert.Len(t, s.Connections(), 0)
+}
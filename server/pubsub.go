@@ -0,0 +1,350 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/share"
+)
+
+// DefaultPubSubMaxPending is the default PubSubConfig.MaxPending.
+const DefaultPubSubMaxPending = 256
+
+// CloseReasonSlowSubscriber means the connection of a slow subscriber is closed by SlowSubscriberDisconnect.
+const CloseReasonSlowSubscriber = "slow_subscriber"
+
+// SlowSubscriberPolicy is what SendToGroupAck does with subscribers which have not acknowledged
+// PubSubConfig.MaxPending messages of a group.
+type SlowSubscriberPolicy int
+
+const (
+	// SlowSubscriberSkip drops messages of slow subscribers until they acknowledge the pending ones.
+	SlowSubscriberSkip SlowSubscriberPolicy = iota
+	// SlowSubscriberDisconnect closes the connections of slow subscribers.
+	SlowSubscriberDisconnect
+)
+
+func (p SlowSubscriberPolicy) String() string {
+	if p == SlowSubscriberDisconnect {
+		return "disconnect"
+	}
+	return "skip"
+}
+
+// PubSubConfig configures the groups of SendToGroupAck.
+type PubSubConfig struct {
+	// MaxPending is the maximum number of messages of a group that a subscriber has not acknowledged,
+	// DefaultPubSubMaxPending if it is zero. Subscribers with more messages pending are slow.
+	MaxPending int
+	// SlowPolicy is applied to slow subscribers.
+	SlowPolicy SlowSubscriberPolicy
+	// Retention is the number of the latest messages of each group that are kept to be sent again to subscribers
+	// which subscribe again after reconnecting, see share.SubscribeArgs. Zero means messages are not kept.
+	Retention int
+	// RetentionWindow is how long kept messages are sent again, or forever if it is zero.
+	RetentionWindow time.Duration
+	// OnSlowSubscriber is invoked with the policy applied when the subscriber of conn becomes slow in group.
+	OnSlowSubscriber func(conn net.Conn, group string, policy SlowSubscriberPolicy)
+}
+
+// PushOptions are options of messages of SendToGroupAck.
+type PushOptions struct {
+	// Metadata is sent with the message.
+	Metadata map[string]string
+}
+
+// PubSubGroupStats are the metrics of a group of SendToGroupAck.
+type PubSubGroupStats struct {
+	Subscribers int    `json:"subscribers"`
+	Published   uint64 `json:"published"`
+	Delivered   uint64 `json:"delivered"` // acknowledged by subscribers
+	Dropped     uint64 `json:"dropped"`   // not sent to subscribers since they are slow or their connections fail
+	Evicted     uint64 `json:"evicted"`   // slow subscribers disconnected by SlowSubscriberDisconnect
+}
+
+type pubSubState struct {
+	mu     sync.Mutex
+	config PubSubConfig
+	groups map[string]*pubSubGroup
+}
+
+type pubSubGroup struct {
+	lastID      uint64
+	subscribers map[net.Conn]*subscriber
+	retained    []pushMessage // ring buffer of the latest messages, the oldest one at head
+	head        int
+	stats       PubSubGroupStats
+}
+
+type subscriber struct {
+	pending []uint64 // IDs of messages not acknowledged, in the order they are sent
+	slow    bool
+}
+
+type pushMessage struct {
+	id       uint64
+	method   string
+	metadata map[string]string
+	payload  []byte
+	at       time.Time
+}
+
+// WithPubSub configures the groups of SendToGroupAck. Clients subscribe to groups by the built-in service
+// share.PubSubServiceName, such as by client.Subscriber.
+func WithPubSub(config PubSubConfig) OptionFn {
+	return func(s *Server) {
+		s.pubsub.config = config
+	}
+}
+
+// PubSubService is the built-in service of subscriptions of connections to groups of SendToGroupAck.
+// It is registered as share.PubSubServiceName.
+type PubSubService struct {
+	s *Server
+}
+
+func (s *Server) registerPubSubService() {
+	_, err := s.register(&PubSubService{s: s}, share.PubSubServiceName, true, "")
+	if err != nil {
+		return
+	}
+	s.serviceMapMu.Lock()
+	s.serviceMap[share.PubSubServiceName].builtin = true
+	s.serviceMapMu.Unlock()
+}
+
+// SendToGroupAck publishes a message to the subscribers of group. It is sent as a oneway request like SendMessage,
+// whose service path is group and service method is method, with the group and the ID of the message
+// in share.PubSubGroupKey and share.PubSubIDKey of its metadata. Subscribers acknowledge messages they receive,
+// and the ones which have not acknowledged MaxPending messages of PubSubConfig are handled by its SlowPolicy.
+// It returns the ID of the message. Messages that fail to be written are counted as dropped in Stats.PubSub.
+func (s *Server) SendToGroupAck(ctx context.Context, group, method string, payload []byte, opts *PushOptions) (uint64, error) {
+	if group == "" {
+		return 0, errors.New("rpcx: no group to publish to")
+	}
+	msg := pushMessage{method: method, payload: payload, at: time.Now()}
+	if opts != nil {
+		msg.metadata = opts.Metadata
+	}
+
+	ps := &s.pubsub
+	ps.mu.Lock()
+	g := ps.groupLocked(group)
+	g.lastID++
+	msg.id = g.lastID
+	g.stats.Published++
+	if n := ps.config.Retention; n > 0 {
+		g.retainLocked(msg, n)
+	}
+
+	var targets, slow, evicted []net.Conn
+	for conn, sub := range g.subscribers {
+		if len(sub.pending) < ps.maxPending() {
+			sub.pending = append(sub.pending, msg.id)
+			targets = append(targets, conn)
+			continue
+		}
+		g.stats.Dropped++
+		if ps.config.SlowPolicy == SlowSubscriberDisconnect {
+			delete(g.subscribers, conn)
+			g.stats.Evicted++
+			evicted = append(evicted, conn)
+		} else if !sub.slow {
+			sub.slow = true
+			slow = append(slow, conn)
+		}
+	}
+	onSlow := ps.config.OnSlowSubscriber
+	ps.mu.Unlock()
+
+	for _, conn := range evicted {
+		s.setCloseReason(conn, CloseReasonSlowSubscriber)
+		conn.Close()
+	}
+	if onSlow != nil {
+		for _, conn := range slow {
+			onSlow(conn, group, SlowSubscriberSkip)
+		}
+		for _, conn := range evicted {
+			onSlow(conn, group, SlowSubscriberDisconnect)
+		}
+	}
+
+	for _, conn := range targets {
+		s.push(ctx, conn, group, msg)
+	}
+	return msg.id, nil
+}
+
+// push sends msg of group to conn, and counts it as dropped if it fails to be written.
+func (s *Server) push(ctx context.Context, conn net.Conn, group string, msg pushMessage) {
+	metadata := make(map[string]string, len(msg.metadata)+2)
+	for k, v := range msg.metadata {
+		metadata[k] = v
+	}
+	metadata[share.PubSubGroupKey] = group
+	metadata[share.PubSubIDKey] = strconv.FormatUint(msg.id, 10)
+	if err := s.SendMessageContext(ctx, conn, group, msg.method, metadata, msg.payload); err == nil {
+		return
+	}
+
+	ps := &s.pubsub
+	ps.mu.Lock()
+	g := ps.groups[group]
+	g.stats.Dropped++
+	if sub := g.subscribers[conn]; sub != nil {
+		for i, id := range sub.pending {
+			if id == msg.id {
+				sub.pending = append(sub.pending[:i], sub.pending[i+1:]...)
+				break
+			}
+		}
+	}
+	ps.mu.Unlock()
+}
+
+func (ps *pubSubState) maxPending() int {
+	if ps.config.MaxPending > 0 {
+		return ps.config.MaxPending
+	}
+	return DefaultPubSubMaxPending
+}
+
+func (ps *pubSubState) groupLocked(group string) *pubSubGroup {
+	g := ps.groups[group]
+	if g == nil {
+		if ps.groups == nil {
+			ps.groups = make(map[string]*pubSubGroup)
+		}
+		g = &pubSubGroup{subscribers: make(map[net.Conn]*subscriber)}
+		ps.groups[group] = g
+	}
+	return g
+}
+
+// retainLocked keeps msg, replacing the oldest message if n messages are kept.
+func (g *pubSubGroup) retainLocked(msg pushMessage, n int) {
+	if len(g.retained) < n {
+		g.retained = append(g.retained, msg)
+		return
+	}
+	g.retained[g.head] = msg
+	g.head = (g.head + 1) % len(g.retained)
+}
+
+// retainedAfterLocked returns the kept messages whose IDs are greater than after, and which are kept at most window.
+func (g *pubSubGroup) retainedAfterLocked(after uint64, window time.Duration) []pushMessage {
+	var msgs []pushMessage
+	now := time.Now()
+	for i := range g.retained {
+		msg := g.retained[(g.head+i)%len(g.retained)]
+		if msg.id > after && (window == 0 || now.Sub(msg.at) <= window) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// removeSubscriber removes the subscriptions of conn, which is closed.
+func (s *Server) removeSubscriber(conn net.Conn) {
+	s.pubsub.mu.Lock()
+	for _, g := range s.pubsub.groups {
+		delete(g.subscribers, conn)
+	}
+	s.pubsub.mu.Unlock()
+}
+
+// pubSubStats returns the metrics of all groups.
+func (s *Server) pubSubStats() map[string]PubSubGroupStats {
+	s.pubsub.mu.Lock()
+	defer s.pubsub.mu.Unlock()
+	if len(s.pubsub.groups) == 0 {
+		return nil
+	}
+	stats := make(map[string]PubSubGroupStats, len(s.pubsub.groups))
+	for group, g := range s.pubsub.groups {
+		gs := g.stats
+		gs.Subscribers = len(g.subscribers)
+		stats[group] = gs
+	}
+	return stats
+}
+
+// Subscribe subscribes the connection to args.Group. Kept messages published after args.After are sent again
+// before the reply, as long as the connection is not slow.
+func (p *PubSubService) Subscribe(ctx context.Context, args *share.SubscribeArgs, reply *share.SubscribeReply) error {
+	conn, ok := ctx.Value(RemoteConnContextKey).(net.Conn)
+	if !ok {
+		return errors.New("rpcx: groups can only be subscribed by connections")
+	}
+	if args.Group == "" {
+		return errors.New("rpcx: no group to subscribe to")
+	}
+
+	ps := &p.s.pubsub
+	ps.mu.Lock()
+	g := ps.groupLocked(args.Group)
+	sub := g.subscribers[conn]
+	if sub == nil {
+		sub = &subscriber{}
+		g.subscribers[conn] = sub
+	}
+	var msgs []pushMessage
+	if args.After > 0 {
+		for _, msg := range g.retainedAfterLocked(args.After, ps.config.RetentionWindow) {
+			if len(sub.pending) >= ps.maxPending() {
+				g.stats.Dropped++
+				continue
+			}
+			sub.pending = append(sub.pending, msg.id)
+			msgs = append(msgs, msg)
+		}
+	}
+	reply.LastID = g.lastID
+	ps.mu.Unlock()
+
+	for _, msg := range msgs {
+		p.s.push(ctx, conn, args.Group, msg)
+	}
+	return nil
+}
+
+// Unsubscribe unsubscribes the connection from group.
+func (p *PubSubService) Unsubscribe(ctx context.Context, group string, reply *struct{}) error {
+	conn, _ := ctx.Value(RemoteConnContextKey).(net.Conn)
+	ps := &p.s.pubsub
+	ps.mu.Lock()
+	if g := ps.groups[group]; g != nil {
+		delete(g.subscribers, conn)
+	}
+	ps.mu.Unlock()
+	return nil
+}
+
+// Ack acknowledges messages of the group sent to the connection up to args.ID. Subscribers which are slow
+// are not slow any more once they have less than MaxPending messages pending.
+func (p *PubSubService) Ack(ctx context.Context, args *share.PubSubAck, reply *struct{}) error {
+	conn, _ := ctx.Value(RemoteConnContextKey).(net.Conn)
+	ps := &p.s.pubsub
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	g := ps.groups[args.Group]
+	if g == nil || g.subscribers[conn] == nil {
+		return nil
+	}
+	sub := g.subscribers[conn]
+	n := 0
+	for n < len(sub.pending) && sub.pending[n] <= args.ID {
+		n++
+	}
+	sub.pending = sub.pending[n:]
+	g.stats.Delivered += uint64(n)
+	if sub.slow && len(sub.pending) < ps.maxPending() {
+		sub.slow = false
+	}
+	return nil
+}
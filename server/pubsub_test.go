@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+func startPubSubServer(t *testing.T, config PubSubConfig) *Server {
+	s := NewServer(WithPubSub(config))
+	startServer(t, s, "tcp")
+	return s
+}
+
+func connectPubSub(t *testing.T, s *Server) *client.Client {
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// receivePushes receives n messages of group from ch, skipping other messages.
+func receivePushes(t *testing.T, ch <-chan *protocol.Message, n int) []*protocol.Message {
+	var msgs []*protocol.Message
+	for len(msgs) < n {
+		select {
+		case msg := <-ch:
+			if msg.Metadata[share.PubSubGroupKey] != "" {
+				msgs = append(msgs, msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expect %d messages but got %d", n, len(msgs))
+		}
+	}
+	return msgs
+}
+
+// waitGroupStats waits for the stats of group to satisfy ok.
+func waitGroupStats(t *testing.T, s *Server, group string, ok func(PubSubGroupStats) bool) PubSubGroupStats {
+	for i := 0; i < 100; i++ {
+		if stats := s.Stats().PubSub[group]; ok(stats) {
+			return stats
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := s.Stats().PubSub[group]
+	t.Errorf("unexpected stats of %s: %+v", group, stats)
+	return stats
+}
+
+func TestSendToGroupAck(t *testing.T) {
+	slowConns := make(chan SlowSubscriberPolicy, 10)
+	s := startPubSubServer(t, PubSubConfig{
+		MaxPending: 2,
+		OnSlowSubscriber: func(conn net.Conn, group string, policy SlowSubscriberPolicy) {
+			slowConns <- policy
+		},
+	})
+	defer s.Close()
+
+	fastCh := make(chan *protocol.Message, 100)
+	fast := client.NewSubscriber(connectPubSub(t, s), fastCh)
+	defer fast.Close()
+	slowCh := make(chan *protocol.Message) // not drained
+	slow := client.NewSubscriber(connectPubSub(t, s), slowCh)
+	defer slow.Close()
+	assert.NoError(t, fast.Subscribe(context.Background(), "news"))
+	assert.NoError(t, slow.Subscribe(context.Background(), "news"))
+
+	var msgs []*protocol.Message
+	for i := 1; i <= 5; i++ {
+		id, err := s.SendToGroupAck(context.Background(), "news", "Publish", []byte(strconv.Itoa(i)), &PushOptions{Metadata: map[string]string{"k": "v"}})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(i), id)
+		msgs = append(msgs, receivePushes(t, fastCh, 1)...)
+		waitGroupStats(t, s, "news", func(st PubSubGroupStats) bool { return st.Delivered == uint64(i) })
+	}
+	assert.Equal(t, "news", msgs[0].ServicePath)
+	assert.Equal(t, "Publish", msgs[0].ServiceMethod)
+	assert.Equal(t, "v", msgs[0].Metadata["k"])
+	assert.Equal(t, "1", msgs[0].Metadata[share.PubSubIDKey])
+	assert.Equal(t, "5", string(msgs[4].Payload))
+
+	// the slow subscriber has 2 pending messages, and the others are skipped
+	stats := s.Stats().PubSub["news"]
+	assert.Equal(t, PubSubGroupStats{Subscribers: 2, Published: 5, Delivered: 5, Dropped: 3}, stats)
+	assert.Equal(t, SlowSubscriberSkip, <-slowConns)
+	assert.Len(t, slowConns, 0)
+
+	// it receives messages again once it catches up
+	receivePushes(t, slowCh, 2)
+	waitGroupStats(t, s, "news", func(st PubSubGroupStats) bool { return st.Delivered == 7 })
+	s.SendToGroupAck(context.Background(), "news", "Publish", []byte("6"), nil)
+	assert.Equal(t, "6", string(receivePushes(t, slowCh, 1)[0].Payload))
+
+	assert.NoError(t, slow.Unsubscribe(context.Background(), "news"))
+	assert.Equal(t, 1, s.Stats().PubSub["news"].Subscribers)
+}
+
+func TestSendToGroupAckDisconnect(t *testing.T) {
+	slowConns := make(chan SlowSubscriberPolicy, 10)
+	s := startPubSubServer(t, PubSubConfig{
+		MaxPending: 1,
+		SlowPolicy: SlowSubscriberDisconnect,
+		OnSlowSubscriber: func(conn net.Conn, group string, policy SlowSubscriberPolicy) {
+			slowConns <- policy
+		},
+	})
+	defer s.Close()
+
+	// a client which doesn't acknowledge messages
+	c := connectPubSub(t, s)
+	c.RegisterServerMessageChan(make(chan *protocol.Message, 10))
+	assert.NoError(t, c.Call(context.Background(), share.PubSubServiceName, "Subscribe", &share.SubscribeArgs{Group: "news"}, &share.SubscribeReply{}))
+
+	s.SendToGroupAck(context.Background(), "news", "Publish", []byte("1"), nil)
+	s.SendToGroupAck(context.Background(), "news", "Publish", []byte("2"), nil)
+	assert.Equal(t, SlowSubscriberDisconnect, <-slowConns)
+	for i := 0; i < 100 && !c.IsShutdown(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, c.IsShutdown())
+	assert.Equal(t, PubSubGroupStats{Published: 2, Dropped: 1, Evicted: 1}, s.Stats().PubSub["news"])
+}
+
+func TestSubscriberReconnect(t *testing.T) {
+	s := startPubSubServer(t, PubSubConfig{Retention: 2})
+	defer s.Close()
+
+	s.SendToGroupAck(context.Background(), "news", "Publish", []byte("0"), nil)
+	ch := make(chan *protocol.Message, 100)
+	c := connectPubSub(t, s)
+	sub := client.NewSubscriber(c, ch)
+	defer sub.Close()
+	assert.NoError(t, sub.Subscribe(context.Background(), "news"))
+	s.SendToGroupAck(context.Background(), "news", "Publish", []byte("1"), nil)
+	assert.Equal(t, "1", string(receivePushes(t, ch, 1)[0].Payload))
+
+	// messages published while the client reconnects are sent again, as long as they are kept
+	c.Close()
+	for i := 2; i <= 4; i++ {
+		s.SendToGroupAck(context.Background(), "news", "Publish", []byte(strconv.Itoa(i)), nil)
+	}
+	assert.NoError(t, sub.Reconnect(context.Background(), connectPubSub(t, s)))
+	msgs := receivePushes(t, ch, 2)
+	assert.Equal(t, "3", string(msgs[0].Payload))
+	assert.Equal(t, "4", string(msgs[1].Payload))
+
+	s.SendToGroupAck(context.Background(), "news", "Publish", []byte("5"), nil)
+	assert.Equal(t, "5", string(receivePushes(t, ch, 1)[0].Payload))
+	assert.Len(t, ch, 0)
+}
@@ -3,14 +3,32 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 
-	"github.com/smallnest/quick"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/util"
 )
 
 func init() {
 	makeListeners["quic"] = quicMakeListener
+	tlsMakeListeners["quic"] = func(s *Server, address string, config *tls.Config) (net.Listener, error) {
+		return quicListen(s, address, config)
+	}
+}
+
+// WithQUICConfig sets the config of QUIC listeners,
+// such as MaxIncomingStreams to limit streams per session, MaxIdleTimeout and KeepAlive.
+func WithQUICConfig(c *quic.Config) OptionFn {
+	return func(s *Server) {
+		s.options["QUICConfig"] = c
+	}
 }
 
 func quicMakeListener(s *Server, address string) (ln net.Listener, err error) {
@@ -18,9 +36,256 @@ func quicMakeListener(s *Server, address string) (ln net.Listener, err error) {
 		return nil, errors.New("TLSConfig must be configured in server.Options")
 	}
 
-	if len(s.tlsConfig.NextProtos) == 0 {
-		s.tlsConfig.NextProtos = []string{"rpcx"}
+	return quicListen(s, address, s.tlsConfig)
+}
+
+func quicListen(s *Server, address string, config *tls.Config) (net.Listener, error) {
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"rpcx"}
+	}
+	wtPath, wt := s.options["WebTransportPath"].(string)
+	if wt {
+		config = webTransportTLSConfig(config)
+	}
+	quicConfig, _ := s.options["QUICConfig"].(*quic.Config)
+
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
 	}
+	ln, err := quic.Listen(conn, config, quicConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	l := &quicListener{
+		ln:     ln,
+		conn:   conn,
+		conns:  make(chan net.Conn),
+		done:   make(chan struct{}),
+		wt:     wt,
+		wtPath: wtPath,
+	}
+	go l.acceptSessions()
+	return l, nil
+}
+
+// quicListener accepts every bidirectional stream of QUIC sessions as a connection.
+// Sessions of HTTP/3 are served as WebTransport sessions if WebTransport is enabled.
+type quicListener struct {
+	ln   quic.Listener
+	conn *net.UDPConn
+
+	wt     bool
+	wtPath string
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+func (l *quicListener) acceptSessions() {
+	for {
+		sess, err := l.ln.Accept(context.Background())
+		if err != nil {
+			l.close(err)
+			return
+		}
+		qs := &quicSession{Session: sess, streams: make(map[*quicStreamConn]struct{})}
+		if l.wt && sess.ConnectionState().TLS.NegotiatedProtocol == util.WebTransportALPN {
+			qs.webTransport = true
+			go l.acceptWebTransport(qs)
+			continue
+		}
+		go l.acceptStreams(qs)
+	}
+}
+
+func (l *quicListener) acceptStreams(sess *quicSession) {
+	defer sess.closeStreams()
+
+	for {
+		stream, err := sess.AcceptStream(sess.Context())
+		if err != nil {
+			log.Debugf("rpcx: QUIC session %s is closed: %v", sess.RemoteAddr().String(), err)
+			return
+		}
+
+		if !l.serveStream(sess, stream) {
+			return
+		}
+	}
+}
+
+// serveStream passes stream to Accept as a connection. It returns false if the session or the listener is closed.
+func (l *quicListener) serveStream(sess *quicSession, stream quic.Stream) bool {
+	conn := &quicStreamConn{Stream: stream, session: sess}
+	if !sess.addStream(conn) {
+		conn.Close()
+		return false
+	}
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		conn.Close()
+		return false
+	}
+}
+
+func (l *quicListener) close(err error) {
+	l.closeOnce.Do(func() {
+		l.err = err
+		close(l.done)
+	})
+}
+
+// Accept waits for and returns the next stream.
+func (l *quicListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// Close closes the listener and all sessions.
+func (l *quicListener) Close() error {
+	l.close(errors.New("quic: listener closed"))
+	err := l.ln.Close()
+	l.conn.Close()
+	return err
+}
 
-	return quick.Listen("udp", address, s.tlsConfig, nil)
+// Addr returns the listener's network address.
+func (l *quicListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// quicSession tracks streams and traffic of a QUIC session.
+type quicSession struct {
+	quic.Session
+	webTransport bool // a WebTransport session over HTTP/3
+
+	bytesRead    uint64
+	bytesWritten uint64
+
+	mu      sync.Mutex
+	streams map[*quicStreamConn]struct{}
+	closed  bool
+}
+
+func (sess *quicSession) addStream(conn *quicStreamConn) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.closed {
+		return false
+	}
+	sess.streams[conn] = struct{}{}
+	return true
+}
+
+func (sess *quicSession) removeStream(conn *quicStreamConn) {
+	sess.mu.Lock()
+	delete(sess.streams, conn)
+	sess.mu.Unlock()
+}
+
+// closeStreams closes all streams after the session is closed,
+// so that their connections are torn down even if they are blocked.
+func (sess *quicSession) closeStreams() {
+	sess.mu.Lock()
+	sess.closed = true
+	streams := make([]*quicStreamConn, 0, len(sess.streams))
+	for conn := range sess.streams {
+		streams = append(streams, conn)
+	}
+	sess.mu.Unlock()
+
+	for _, conn := range streams {
+		conn.Close()
+	}
+}
+
+// quicStreamConn is a stream of a QUIC session as a connection.
+type quicStreamConn struct {
+	quic.Stream
+	session *quicSession
+}
+
+func (c *quicStreamConn) Read(b []byte) (int, error) {
+	n, err := c.Stream.Read(b)
+	atomic.AddUint64(&c.session.bytesRead, uint64(n))
+	if err != nil && c.session.Context().Err() != nil {
+		// the session is closed by the client or because of the idle timeout
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (c *quicStreamConn) Write(b []byte) (int, error) {
+	n, err := c.Stream.Write(b)
+	atomic.AddUint64(&c.session.bytesWritten, uint64(n))
+	return n, err
+}
+
+// Close closes both directions of the stream.
+func (c *quicStreamConn) Close() error {
+	c.session.removeStream(c)
+	c.CancelRead(0)
+	return c.Stream.Close()
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr {
+	return c.session.LocalAddr()
+}
+
+func (c *quicStreamConn) RemoteAddr() net.Addr {
+	return c.session.RemoteAddr()
+}
+
+func (c *quicStreamConn) connSession() interface{} {
+	return c.session
+}
+
+// QUICSessionStats contains the stats of a QUIC session.
+type QUICSessionStats struct {
+	RemoteAddr string
+	// WebTransport reports whether the session is a WebTransport session over HTTP/3
+	WebTransport bool
+	// Streams is the number of streams served as connections
+	Streams      int
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// QUICSessions returns the stats of QUIC sessions with active streams.
+func (s *Server) QUICSessions() []QUICSessionStats {
+	s.mu.RLock()
+	streams := make(map[*quicSession]int)
+	for conn := range s.activeConn {
+		if c, ok := conn.(*quicStreamConn); ok {
+			streams[c.session]++
+		}
+	}
+	s.mu.RUnlock()
+
+	stats := make([]QUICSessionStats, 0, len(streams))
+	for sess, n := range streams {
+		stats = append(stats, QUICSessionStats{
+			RemoteAddr:   sess.RemoteAddr().String(),
+			WebTransport: sess.webTransport,
+			Streams:      n,
+			BytesRead:    atomic.LoadUint64(&sess.bytesRead),
+			BytesWritten: atomic.LoadUint64(&sess.bytesWritten),
+		})
+	}
+	return stats
 }
@@ -0,0 +1,96 @@
+// +build quic
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQUICSessionStreams(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile, 1, time.Now())
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const streams = 20
+	recorder := &closeReasonRecorder{reasons: make(chan string, streams)}
+	s := NewServer(WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		WithQUICConfig(&quic.Config{MaxIncomingStreams: streams}),
+		WithMaxConnectionsPerIP(1))
+	s.Plugins.Add(recorder)
+	s.RegisterName("Arith", new(Arith), "")
+	startServer(t, s, "quic")
+
+	sess, err := quic.DialAddr(s.Address().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"rpcx"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// streams of the session are counted as one connection of the IP
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stream, err := sess.OpenStreamSync(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			for j := 0; j < 3; j++ {
+				req := protocol.NewMessage()
+				req.SetSerializeType(protocol.JSON)
+				req.SetSeq(uint64(j))
+				req.ServicePath = "Arith"
+				req.ServiceMethod = "Mul"
+				req.Payload = []byte(`{"A":10,"B":20}`)
+				stream.Write(req.Encode())
+
+				stream.SetReadDeadline(time.Now().Add(2 * time.Second))
+				res, err := protocol.Read(stream)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				assert.Equal(t, protocol.Normal, res.MessageStatusType(), "stream %d: %v", i, res.Metadata)
+				assert.JSONEq(t, `{"C":200}`, string(res.Payload))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := s.QUICSessions()
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, streams, stats[0].Streams)
+		assert.NotZero(t, stats[0].BytesRead)
+		assert.NotZero(t, stats[0].BytesWritten)
+	}
+	s.mu.RLock()
+	assert.Len(t, s.activeConn, streams)
+	assert.Equal(t, 1, s.connsPerIP["127.0.0.1"])
+	s.mu.RUnlock()
+
+	// all streams are cleaned up after the session is killed
+	sess.CloseWithError(0, "")
+	for i := 0; i < streams; i++ {
+		recorder.wait(t, CloseReasonClientClosed, 2*time.Second)
+	}
+	s.mu.RLock()
+	assert.Empty(t, s.activeConn)
+	assert.Empty(t, s.connsPerIP)
+	assert.Empty(t, s.sessionConns)
+	s.mu.RUnlock()
+}
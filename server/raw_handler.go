@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// RawHandler handles raw requests of a service, registered by RegisterRawHandler.
+// It returns the response, whose serialize type, compress type, status, metadata and payload are written to the client as they are,
+// or an error which is written like errors of services, in which case the response is ignored.
+// The sequence, service path and service method of responses are the ones of their requests.
+// req and its payload must not be used after the handler returns, and nil responses are written without payloads.
+type RawHandler func(ctx context.Context, req *protocol.Message) (*protocol.Message, error)
+
+// RegisterRawHandler registers fn to handle requests of all methods of servicePath without decoding them,
+// such as for proxies which forward requests to other servers by client.XClient's SendRawMessage.
+// It is published in registries like services, and unregistered by Unregister.
+// Middlewares, validators and PreCall and PostCall plugins are not invoked since arguments and replies are not decoded.
+func (s *Server) RegisterRawHandler(servicePath string, fn RawHandler) error {
+	if servicePath == "" || fn == nil {
+		return errors.New("rpcx.RegisterRawHandler: no service path or handler")
+	}
+
+	s.serviceMapMu.Lock()
+	if svc := s.serviceMap[servicePath]; svc != nil {
+		s.serviceMapMu.Unlock()
+		return errors.New("rpcx.RegisterRawHandler: service " + servicePath + " is registered")
+	}
+	s.serviceMap[servicePath] = &service{name: servicePath, raw: fn, registrations: map[string]string{"": ""}}
+	s.serviceMapMu.Unlock()
+
+	return s.Plugins.DoRegister(servicePath, fn, "")
+}
+
+// handleRawRequest handles req by fn, the raw handler of its service. res is the response to return.
+func (s *Server) handleRawRequest(ctx context.Context, fn RawHandler, req, res *protocol.Message) (*protocol.Message, error) {
+	reply, err := fn(ctx, req)
+	if err != nil {
+		return handleError(res, err)
+	}
+	if reply == nil || req.IsOneway() {
+		return res, nil
+	}
+
+	res.SetSerializeType(reply.SerializeType())
+	res.SetCompressType(reply.CompressType())
+	res.SetMessageStatusType(reply.MessageStatusType())
+	res.Metadata = reply.Metadata
+	res.Payload = reply.Payload
+	return res, nil
+}
+
+// isRawService returns whether servicePath is handled by a raw handler, whose responses keep their compress types.
+func (s *Server) isRawService(servicePath string) bool {
+	s.serviceMapMu.RLock()
+	defer s.serviceMapMu.RUnlock()
+	svc := s.serviceMap[servicePath]
+	return svc != nil && svc.raw != nil
+}
+
+// SendRawMessage sends msg, a raw message such as one that a proxy has received from another server, to the client of conn.
+// Its serialize type, compress type, metadata and payload are kept, while it is sent as a oneway request
+// with a sequence of the server, like messages of SendMessage. msg is not modified.
+func (s *Server) SendRawMessage(ctx context.Context, conn net.Conn, msg *protocol.Message) error {
+	req := protocol.GetPooledMsg()
+	req.SetSerializeType(msg.SerializeType())
+	req.SetCompressType(msg.CompressType())
+	req.ServicePath = msg.ServicePath
+	req.ServiceMethod = msg.ServiceMethod
+	req.Metadata = msg.Metadata
+	req.Payload = msg.Payload
+	return s.sendRequest(ctx, conn, req)
+}
+
+// sendRequest sends req, a message to the client of conn, as a oneway request with a sequence of the server, and frees it.
+func (s *Server) sendRequest(ctx context.Context, conn net.Conn, req *protocol.Message) error {
+	ctx = share.WithValue(ctx, StartSendRequestContextKey, time.Now().UnixNano())
+	s.Plugins.DoPreWriteRequest(ctx)
+
+	req.SetMessageType(protocol.Request)
+	req.SetSeq(atomic.AddUint64(&s.seq, 1))
+	req.SetOneway(true)
+	s.setChecksum(conn, req)
+	if n := s.Negotiation(conn); n != nil && n.Capabilities.Has(protocol.CapCompactMetadata) {
+		req.SetCompactMetadata(true)
+	}
+
+	err := s.writeMessage(conn, nil, req.EncodeVectored())
+
+	s.Plugins.DoPostWriteRequest(ctx, req, err)
+	protocol.FreeMsg(req)
+	return err
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawHandler(t *testing.T) {
+	s := NewServer()
+	payload := bytes.Repeat([]byte("rpcx"), 1024)
+	err := s.RegisterRawHandler("Raw", func(ctx context.Context, req *protocol.Message) (*protocol.Message, error) {
+		if req.ServiceMethod == "Fail" {
+			return nil, errors.New("raw failure")
+		}
+		if req.ServiceMethod == "Push" {
+			push := protocol.NewMessage()
+			push.SetSerializeType(protocol.JSON)
+			push.ServicePath, push.ServiceMethod = "Raw", "Pushed"
+			push.Metadata = map[string]string{"event": "1"}
+			push.Payload = req.Payload
+			if err := s.SendRawMessage(ctx, ctx.Value(RemoteConnContextKey).(net.Conn), push); err != nil {
+				return nil, err
+			}
+		}
+		res := protocol.NewMessage()
+		res.SetSerializeType(protocol.MsgPack)
+		res.SetCompressType(protocol.Gzip)
+		res.Metadata = map[string]string{"method": req.ServiceMethod, "trace": req.Metadata["trace"]}
+		res.Payload = payload
+		return res, nil
+	})
+	assert.NoError(t, err)
+	assert.Error(t, s.RegisterRawHandler("Raw", func(ctx context.Context, req *protocol.Message) (*protocol.Message, error) { return nil, nil }))
+	startServer(t, s, "tcp")
+
+	ch := make(chan *protocol.Message, 1)
+	c := client.NewClient(client.DefaultOption)
+	c.RegisterServerMessageChan(ch)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	req := protocol.NewMessage()
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath, req.ServiceMethod = "Raw", "Push"
+	req.Metadata = map[string]string{"trace": "abc"}
+	req.Payload = []byte(`{"A":1}`)
+	res, err := c.SendRawMessage(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the response of the handler is written as it is
+	assert.Equal(t, protocol.MsgPack, res.SerializeType())
+	assert.Equal(t, protocol.Gzip, res.CompressType())
+	assert.Equal(t, "Push", res.Metadata["method"])
+	assert.Equal(t, "abc", res.Metadata["trace"])
+	assert.Equal(t, payload, res.Payload)
+
+	select {
+	case msg := <-ch:
+		assert.Equal(t, protocol.JSON, msg.SerializeType())
+		assert.Equal(t, "Pushed", msg.ServiceMethod)
+		assert.Equal(t, "1", msg.Metadata["event"])
+		assert.Equal(t, `{"A":1}`, string(msg.Payload))
+	case <-time.After(time.Second):
+		t.Fatal("the raw message is not pushed")
+	}
+
+	req.ServiceMethod = "Fail"
+	_, err = c.SendRawMessage(context.Background(), req)
+	assert.EqualError(t, err, "raw failure")
+
+	assert.NoError(t, s.Unregister("Raw"))
+	req.ServiceMethod = "Push"
+	_, err = c.SendRawMessage(context.Background(), req)
+	assert.Error(t, err)
+}
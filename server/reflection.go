@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/smallnest/rpcx/share"
+)
+
+// ReflectionService is a built-in service that describes services registered in the server.
+// It is registered as share.ReflectionServiceName unless the server is created WithReflection(false).
+type ReflectionService struct {
+	s *Server
+}
+
+// WithReflection enables or disables the built-in reflection service. It is enabled by default.
+func WithReflection(enabled bool) OptionFn {
+	return func(s *Server) {
+		s.disableReflection = !enabled
+	}
+}
+
+func (s *Server) registerReflectionService() {
+	_, err := s.register(&ReflectionService{s: s}, share.ReflectionServiceName, true, "")
+	if err != nil {
+		return
+	}
+	s.serviceMapMu.Lock()
+	s.serviceMap[share.ReflectionServiceName].builtin = true
+	s.serviceMapMu.Unlock()
+}
+
+// ListServices returns names, metadata and methods of all registered services.
+func (r *ReflectionService) ListServices(ctx context.Context, args string, reply *share.ListServicesReply) error {
+	r.s.serviceMapMu.RLock()
+	defer r.s.serviceMapMu.RUnlock()
+
+	reply.Services = make([]share.ServiceDesc, 0, len(r.s.serviceMap))
+	for name, svc := range r.s.serviceMap {
+		if svc.builtin {
+			continue
+		}
+		desc := share.ServiceDesc{Name: name}
+		if metadata := svc.metadataList(); len(metadata) > 0 {
+			desc.Metadata = metadata[0] // of the registration without an ID if there is one
+		}
+		for mname := range svc.method {
+			desc.Methods = append(desc.Methods, mname)
+		}
+		for fname := range svc.function {
+			desc.Methods = append(desc.Methods, fname)
+		}
+		sort.Strings(desc.Methods)
+		reply.Services = append(reply.Services, desc)
+	}
+	sort.Slice(reply.Services, func(i, j int) bool {
+		return reply.Services[i].Name < reply.Services[j].Name
+	})
+
+	return nil
+}
+
+// ListMethods returns methods of the service and names of their argument and reply types.
+func (r *ReflectionService) ListMethods(ctx context.Context, service string, reply *share.ListMethodsReply) error {
+	r.s.serviceMapMu.RLock()
+	defer r.s.serviceMapMu.RUnlock()
+
+	svc := r.s.serviceMap[service]
+	if svc == nil || svc.builtin {
+		return fmt.Errorf("rpcx: can't find service %s", service)
+	}
+
+	reply.Service = service
+	for name, m := range svc.method {
+		reply.Methods = append(reply.Methods, share.MethodDesc{
+			Name:      name,
+			ArgType:   typeName(m.ArgType),
+			ReplyType: typeName(m.ReplyType),
+		})
+	}
+	for name, f := range svc.function {
+		reply.Methods = append(reply.Methods, share.MethodDesc{
+			Name:      name,
+			ArgType:   typeName(f.ArgType),
+			ReplyType: typeName(f.ReplyType),
+		})
+	}
+	sort.Slice(reply.Methods, func(i, j int) bool {
+		return reply.Methods[i].Name < reply.Methods[j].Name
+	})
+
+	return nil
+}
+
+// DescribeType returns the description of an argument or reply type, or of a type nested in them.
+// typeName can be the qualified name returned by ListMethods (for example "server.Args") or the bare name.
+func (r *ReflectionService) DescribeType(ctx context.Context, name string, reply *share.TypeDesc) error {
+	t := r.findType(name)
+	if t == nil {
+		return fmt.Errorf("rpcx: can't find type %s", name)
+	}
+
+	*reply = *describeType(t, make(map[reflect.Type]bool))
+	return nil
+}
+
+func (r *ReflectionService) findType(name string) reflect.Type {
+	r.s.serviceMapMu.RLock()
+	var roots []reflect.Type
+	for _, svc := range r.s.serviceMap {
+		if svc.builtin {
+			continue
+		}
+		for _, m := range svc.method {
+			roots = append(roots, m.ArgType, m.ReplyType)
+		}
+		for _, f := range svc.function {
+			roots = append(roots, f.ArgType, f.ReplyType)
+		}
+	}
+	r.s.serviceMapMu.RUnlock()
+
+	visited := make(map[reflect.Type]bool)
+	for _, t := range roots {
+		if found := lookupType(t, name, visited); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// lookupType walks t and the types nested in it and returns the first one matching name.
+func lookupType(t reflect.Type, name string, visited map[reflect.Type]bool) reflect.Type {
+	t = indirectType(t)
+	if visited[t] {
+		return nil
+	}
+	visited[t] = true
+
+	if t.Name() != "" && (t.String() == name || t.Name() == name) {
+		return t
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if found := lookupType(f.Type, name, visited); found != nil {
+				return found
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		return lookupType(t.Elem(), name, visited)
+	case reflect.Map:
+		if found := lookupType(t.Key(), name, visited); found != nil {
+			return found
+		}
+		return lookupType(t.Elem(), name, visited)
+	}
+
+	return nil
+}
+
+// describeType builds the description of t. inPath contains struct types being described
+// in the current path so that recursive types are described by a Ref.
+func describeType(t reflect.Type, inPath map[reflect.Type]bool) *share.TypeDesc {
+	t = indirectType(t)
+	desc := &share.TypeDesc{Name: t.String(), Kind: t.Kind().String()}
+	if t.Name() == "" {
+		desc.Name = ""
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if inPath[t] {
+			desc.Ref = t.String()
+			return desc
+		}
+		inPath[t] = true
+		defer delete(inPath, t)
+
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" { // unexported
+				continue
+			}
+			jsonName, omitEmpty, skip := parseTag(f.Tag.Get("json"))
+			if skip {
+				continue
+			}
+			msgpackName, _, _ := parseTag(f.Tag.Get("msgpack"))
+			desc.Fields = append(desc.Fields, share.FieldDesc{
+				Name:        f.Name,
+				JSONName:    jsonName,
+				MsgpackName: msgpackName,
+				OmitEmpty:   omitEmpty,
+				Type:        describeType(f.Type, inPath),
+			})
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			desc.Kind = "bytes"
+			break
+		}
+		desc.Elem = describeType(t.Elem(), inPath)
+	case reflect.Map:
+		desc.Key = describeType(t.Key(), inPath)
+		desc.Elem = describeType(t.Elem(), inPath)
+	}
+
+	return desc
+}
+
+func parseTag(tag string) (name string, omitEmpty bool, skip bool) {
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return parts[0], omitEmpty, false
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func typeName(t reflect.Type) string {
+	return indirectType(t).String()
+}
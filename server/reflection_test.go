@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+type TreeNode struct {
+	Value    int         `json:"value" msgpack:"v"`
+	Label    string      `json:"label,omitempty"`
+	Children []*TreeNode `json:"children"`
+	Attrs    map[string]string
+	Ignored  string `json:"-"`
+	internal int
+}
+
+type Tree int
+
+func (t *Tree) Sum(ctx context.Context, args *TreeNode, reply *Reply) error {
+	reply.C = args.Value
+	for _, c := range args.Children {
+		var r Reply
+		t.Sum(ctx, c, &r)
+		reply.C += r.C
+	}
+	return nil
+}
+
+func TestReflectionService(t *testing.T) {
+	s := NewServer()
+	s.RegisterName("Arith", new(Arith), "group=test")
+	s.RegisterName("Tree", new(Tree), "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	err := c.Connect("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	var services share.ListServicesReply
+	err = c.Call(context.Background(), share.ReflectionServiceName, "ListServices", "", &services)
+	assert.NoError(t, err)
+	printJSON(t, services)
+	if assert.Len(t, services.Services, 2) {
+		assert.Equal(t, "Arith", services.Services[0].Name)
+		assert.Equal(t, "group=test", services.Services[0].Metadata)
+		assert.Equal(t, []string{"ConsumingOperation", "Mul", "ThriftMul"}, services.Services[0].Methods)
+	}
+
+	var methods share.ListMethodsReply
+	err = c.Call(context.Background(), share.ReflectionServiceName, "ListMethods", "Tree", &methods)
+	assert.NoError(t, err)
+	printJSON(t, methods)
+	assert.Equal(t, []share.MethodDesc{{Name: "Sum", ArgType: "server.TreeNode", ReplyType: "server.Reply"}}, methods.Methods)
+
+	err = c.Call(context.Background(), share.ReflectionServiceName, "ListMethods", "Unknown", &methods)
+	assert.Error(t, err)
+
+	var typ share.TypeDesc
+	err = c.Call(context.Background(), share.ReflectionServiceName, "DescribeType", "server.TreeNode", &typ)
+	assert.NoError(t, err)
+	printJSON(t, typ)
+	assert.Equal(t, "struct", typ.Kind)
+	if assert.Len(t, typ.Fields, 4) {
+		assert.Equal(t, "v", typ.Fields[0].MsgpackName)
+		assert.True(t, typ.Fields[1].OmitEmpty)
+		assert.Equal(t, "server.TreeNode", typ.Fields[2].Type.Elem.Ref)
+		assert.Equal(t, "map", typ.Fields[3].Type.Kind)
+	}
+}
+
+func printJSON(t *testing.T, v interface{}) {
+	data, _ := json.MarshalIndent(v, "", "  ")
+	t.Log(string(data))
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/smallnest/rpcx/share"
+)
+
+// RegisterReplyPool registers pool as the pool of replies of the type of reply, for example (*pb.Reply)(nil).
+// Replies of methods and functions with the type are got from pool, and returned to it after their responses are written.
+// Replies are reset by their Reset method, if they have one, before they are returned.
+//
+// Services must not retain references to replies, or to anything replies refer to, after they return,
+// because the replies are reused by other requests. Replies of detached requests are not returned to pool.
+func (s *Server) RegisterReplyPool(reply interface{}, pool *sync.Pool) {
+	s.replyPools.Store(reflect.TypeOf(reply), pool)
+}
+
+// pooledReply is a reply which is returned to its pool after the response is written.
+type pooledReply struct {
+	pool  *sync.Pool
+	reply interface{}
+}
+
+type pooledReplyKey struct{}
+
+func (r *pooledReply) put() {
+	if o, ok := r.reply.(Reset); ok {
+		o.Reset()
+	}
+	r.pool.Put(r.reply)
+}
+
+// getReply gets a reply of type t from the registered pool, or from reflectTypePools.
+func (s *Server) getReply(t reflect.Type) interface{} {
+	pool, ok := s.replyPools.Load(t)
+	if !ok {
+		return reflectTypePools.Get(t)
+	}
+	if reply := pool.(*sync.Pool).Get(); reply != nil && reflect.TypeOf(reply) == t {
+		return reply
+	}
+	return reflectTypePools.New(t)
+}
+
+// putReply returns reply, which has been encoded, to its pool. Replies of registered pools are kept in ctx
+// until releaseReply is called after the response is written, since payloads of some codecs refer to replies.
+func (s *Server) putReply(ctx context.Context, t reflect.Type, reply interface{}) {
+	pool, ok := s.replyPools.Load(t)
+	if !ok {
+		reflectTypePools.Put(t, reply)
+		return
+	}
+	r := &pooledReply{pool: pool.(*sync.Pool), reply: reply}
+	if sctx, ok := ctx.(*share.Context); ok {
+		sctx.SetValue(pooledReplyKey{}, r)
+		return
+	}
+	r.put()
+}
+
+// releaseReply returns the reply kept in ctx by putReply to its pool.
+func (s *Server) releaseReply(ctx context.Context) {
+	sctx, ok := ctx.(*share.Context)
+	if !ok {
+		return
+	}
+	if r, ok := sctx.Value(pooledReplyKey{}).(*pooledReply); ok {
+		sctx.DeleteKey(pooledReplyKey{})
+		r.put()
+	}
+}
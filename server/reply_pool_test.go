@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+type PooledReply struct {
+	C     int
+	Extra string
+}
+
+func (r *PooledReply) Reset() {
+	*r = PooledReply{}
+}
+
+type pooledReplyService struct {
+	mu   sync.Mutex
+	last *PooledReply
+}
+
+// Mul sets Extra only if A is 1, so that replies not reset would leak it into other responses.
+func (s *pooledReplyService) Mul(ctx context.Context, args *Args, reply *PooledReply) error {
+	reply.C = args.A * args.B
+	if args.A == 1 {
+		reply.Extra = "extra"
+	}
+	s.mu.Lock()
+	s.last = reply
+	s.mu.Unlock()
+	return nil
+}
+
+// replyCheckPlugin checks replies are not returned to the pool before responses are written.
+type replyCheckPlugin struct {
+	s     *pooledReplyService
+	reset int32
+}
+
+func (p *replyCheckPlugin) PostWriteResponse(ctx context.Context, req *protocol.Message, res *protocol.Message, err error) error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+	if p.s.last != nil && p.s.last.C == 0 {
+		atomic.AddInt32(&p.reset, 1)
+	}
+	return nil
+}
+
+func TestRegisterReplyPool(t *testing.T) {
+	var news int32
+	pool := &sync.Pool{New: func() interface{} {
+		atomic.AddInt32(&news, 1)
+		return &PooledReply{}
+	}}
+
+	service := &pooledReplyService{}
+	plugin := &replyCheckPlugin{s: service}
+	s := NewServer()
+	s.RegisterReplyPool((*PooledReply)(nil), pool)
+	s.Plugins.Add(plugin)
+	s.RegisterName("Pooled", service, "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 1; i <= 20; i++ {
+		reply := &PooledReply{}
+		assert.NoError(t, c.Call(context.Background(), "Pooled", "Mul", &Args{A: i, B: 2}, reply))
+		assert.Equal(t, PooledReply{C: i * 2, Extra: map[bool]string{true: "extra"}[i == 1]}, *reply)
+	}
+	assert.True(t, atomic.LoadInt32(&news) < 20, "replies are not reused: %d allocated", news)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&plugin.reset), "replies are reset before responses are written")
+}
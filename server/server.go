@@ -5,30 +5,38 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
-	"fmt"
 	"io"
 	"net"
 	"net/http"
-	"os"
-	"os/exec"
 	"reflect"
 	"regexp"
 	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/smallnest/rpcx/codec"
+	rerrors "github.com/smallnest/rpcx/errors"
 	"github.com/smallnest/rpcx/log"
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/share"
+	"github.com/smallnest/rpcx/util"
 	"golang.org/x/net/websocket"
 )
 
+// logger is the structured logger of the "server" component.
+var logger = log.NewComponent("server")
+
 // ErrServerClosed is returned by the Server's Serve, ListenAndServe after a call to Shutdown or Close.
 var ErrServerClosed = errors.New("http: Server closed")
 
+// ErrDropResponse is returned by PreCall of plugins, such as serverplugin.ChaosPlugin, to drop requests.
+// Responses of dropped requests are not written, so their clients time out like requests lost by networks.
+var ErrDropResponse = errors.New("rpcx: response is dropped")
+
 const (
 	// ReaderBuffsize is used for bufio reader.
 	ReaderBuffsize = 1024
@@ -67,43 +75,100 @@ type Handler func(ctx *Context) error
 // Server is rpcx server that use TCP or UDP.
 type Server struct {
 	ln                 net.Listener
-	readTimeout        time.Duration
-	writeTimeout       time.Duration
+	extraLns           []net.Listener // listeners added by AddListener
+	restartLns         []restartListener
 	gatewayHTTPServer  *http.Server
 	DisableHTTPGateway bool // should disable http invoke or not.
 	DisableJSONRPC     bool // should disable json rpc or not.
-	AsyncWrite         bool // set true if your server only serves few clients
+	// AsyncWrite writes responses by a goroutine of each connection, set true if your server only serves few clients.
+	// Queued responses are copied, so responses are written by writev without copying their payloads only if it is false.
+	AsyncWrite bool
 
 	serviceMapMu sync.RWMutex
 	serviceMap   map[string]*service
+	replyPools   sync.Map // reflect.Type -> *sync.Pool registered by RegisterReplyPool
 
 	router map[string]Handler
 
 	mu         sync.RWMutex
-	activeConn map[net.Conn]struct{}
-	doneChan   chan struct{}
-	seq        uint64
-
-	inShutdown int32
-	onShutdown []func(s *Server)
-	onRestart  []func(s *Server)
+	activeConn map[net.Conn]*connInfo
+	connsPerIP map[string]int
+	// sessionConns counts active connections of sessions shared by multiple connections
+	sessionConns map[interface{}]int
+	nextConnID   uint64
+
+	disableRejectFrame bool
+	doneChan           chan struct{}
+	listening          chan struct{} // closed once the listener is set
+	listenOnce         sync.Once
+	seq                uint64
+
+	inShutdown    int32
+	shutdownHooks []ShutdownHook
+	onRestart     []func(s *Server)
 
 	// TLSConfig for creating tls tcp connection.
-	tlsConfig *tls.Config
+	tlsConfig      *tls.Config
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 	// BlockCrypt for kcp.BlockCrypt
 	options map[string]interface{}
 
 	// CORS options
 	corsOptions *CORSOptions
 
+	adminOnce sync.Once
+	adminMux  *http.ServeMux
+
 	Plugins PluginContainer
 
 	// AuthFunc can be used to auth.
+	// token is the token of the call, which is the one of client.WithAuth if the call overrides the token of its client,
+	// so AuthFunc verifying tokens such as JWTs validates whichever token arrives, and identities set by it are of that token.
 	AuthFunc func(ctx context.Context, req *protocol.Message, token string) error
 
 	handlerMsgNum int32
 
 	HandleServiceError func(error)
+
+	// ExposePanicDetails sends the panic value of recovered panics to clients.
+	// By default clients only get an Internal error with an id that can be found in logs.
+	ExposePanicDetails bool
+
+	disableReflection bool
+	disableHealth     bool
+	health            healthState
+	pubsub            pubSubState
+
+	validator func(ctx context.Context, args interface{}) error
+
+	middlewares []Middleware
+	workerPool  *workerPool
+	stats       serverStats
+
+	clientIdleTimeout time.Duration
+	clientPingGrace   time.Duration
+
+	udpMaxDatagramSize int
+	slowRequest        slowRequestOptions
+	websocket          websocketOptions
+	autoCompress       autoCompressOptions
+	checksum           bool
+	chunking           chunkOptions
+	session            *protocol.SessionConfig
+	priorityAging      time.Duration // zero if requests are not prioritized, see WithPriorityScheduling
+	jsonOptions        map[string]codec.JSONOptions
+	jsonrpc            jsonrpcOptions
+	gatewayHeaders     []string // canonical headers copied to metadata, see WithGatewayHeaders
+	pipeConfig         util.PipeConfig
+	proxyProtocol      *proxyProtocol // nil unless WithProxyProtocol is set
+	poolContexts       bool           // see WithContextPool
+	recycleMetadata    bool           // see WithMetadataRecycling
+
+	settingsMu sync.Mutex   // serializes ApplySettings
+	settings   atomic.Value // *RuntimeSettings
+
+	serializePoliciesMu sync.Mutex   // serializes SetServiceSerializePolicy
+	serializePolicies   atomic.Value // map[string][]protocol.SerializeType of SetServiceSerializePolicy
 }
 
 // NewServer returns a server.
@@ -111,23 +176,51 @@ func NewServer(options ...OptionFn) *Server {
 	s := &Server{
 		Plugins:    &pluginContainer{},
 		options:    make(map[string]interface{}),
-		activeConn: make(map[net.Conn]struct{}),
+		activeConn: make(map[net.Conn]*connInfo),
+		connsPerIP: make(map[string]int),
 		doneChan:   make(chan struct{}),
+		listening:  make(chan struct{}),
 		serviceMap: make(map[string]*service),
 		router:     make(map[string]Handler),
 		AsyncWrite: true,
 	}
+	s.settings.Store(&RuntimeSettings{})
 
 	for _, op := range options {
 		op(s)
 	}
+	s.applyGetCertificate()
+	if s.workerPool != nil && s.priorityAging > 0 {
+		s.workerPool.prioritize(s.priorityAging)
+	}
 
 	if s.options["TCPKeepAlivePeriod"] == nil {
 		s.options["TCPKeepAlivePeriod"] = 3 * time.Minute
 	}
+
+	if !s.disableReflection {
+		s.registerReflectionService()
+	}
+	if !s.disableHealth {
+		s.registerHealthService()
+	}
+	s.registerPubSubService()
+	if s.clientIdleTimeout > 0 {
+		go s.reapIdleConns()
+	}
+	s.shutdownHooks = append(s.shutdownHooks, registryShutdownHook())
 	return s
 }
 
+// setListener sets ln as the listener of s, whose address is returned by Address, and tells that s is serving.
+func (s *Server) setListener(ln net.Listener) {
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+	s.listenOnce.Do(func() { close(s.listening) })
+	notifyRestartReady()
+}
+
 // Address returns listened address.
 func (s *Server) Address() net.Addr {
 	s.mu.RLock()
@@ -157,32 +250,23 @@ func (s *Server) ActiveClientConn() []net.Conn {
 // The client is designated by the conn.
 // conn can be gotten from context in services:
 //
-//   ctx.Value(RemoteConnContextKey)
+//	ctx.Value(RemoteConnContextKey)
 //
 // servicePath, serviceMethod, metadata can be set to zero values.
 func (s *Server) SendMessage(conn net.Conn, servicePath, serviceMethod string, metadata map[string]string, data []byte) error {
-	ctx := share.WithValue(context.Background(), StartSendRequestContextKey, time.Now().UnixNano())
-	s.Plugins.DoPreWriteRequest(ctx)
+	return s.SendMessageContext(context.Background(), conn, servicePath, serviceMethod, metadata, data)
+}
 
+// SendMessageContext is like SendMessage but plugins get ctx when the request is written,
+// so services can pass their context to relate requests to the requests they are handling, for example in traces.
+func (s *Server) SendMessageContext(ctx context.Context, conn net.Conn, servicePath, serviceMethod string, metadata map[string]string, data []byte) error {
 	req := protocol.GetPooledMsg()
-	req.SetMessageType(protocol.Request)
-
-	seq := atomic.AddUint64(&s.seq, 1)
-	req.SetSeq(seq)
-	req.SetOneway(true)
 	req.SetSerializeType(protocol.SerializeNone)
 	req.ServicePath = servicePath
 	req.ServiceMethod = serviceMethod
 	req.Metadata = metadata
 	req.Payload = data
-
-	b := req.EncodeSlicePointer()
-	_, err := conn.Write(*b)
-	protocol.PutData(b)
-
-	s.Plugins.DoPostWriteRequest(ctx, req, err)
-	protocol.FreeMsg(req)
-	return err
+	return s.sendRequest(ctx, conn, req)
 }
 
 func (s *Server) getDoneChan() <-chan struct{} {
@@ -208,6 +292,15 @@ func (s *Server) Serve(network, address string) (err error) {
 		return nil
 	}
 
+	if network == "h2c" || network == "h2" {
+		s.serveByH2C(ln, "", network == "h2c")
+		return nil
+	}
+
+	if uln, ok := ln.(*udpListener); ok {
+		return s.serveByUDP(uln)
+	}
+
 	// try to start gateway
 	ln = s.startGateway(network, ln)
 
@@ -232,11 +325,14 @@ func (s *Server) ServeListener(network string, ln net.Listener) (err error) {
 // creating a new service goroutine for each.
 // The service goroutines read requests and then call services to reply to them.
 func (s *Server) serveListener(ln net.Listener) error {
-	var tempDelay time.Duration
+	s.setListener(ln)
 
-	s.mu.Lock()
-	s.ln = ln
-	s.mu.Unlock()
+	return s.acceptLoop(ln)
+}
+
+// acceptLoop accepts connections on ln until ln is closed.
+func (s *Server) acceptLoop(ln net.Listener) error {
+	var tempDelay time.Duration
 
 	for {
 		conn, e := ln.Accept()
@@ -258,7 +354,7 @@ func (s *Server) serveListener(ln net.Listener) error {
 					tempDelay = max
 				}
 
-				log.Errorf("rpcx: Accept error: %v; retrying in %v", e, tempDelay)
+				logger.Error(context.Background(), "rpcx: Accept error", "error", e, "retryIn", tempDelay)
 				time.Sleep(tempDelay)
 				continue
 			}
@@ -284,10 +380,17 @@ func (s *Server) serveListener(ln net.Listener) error {
 			conn.Close()
 			continue
 		}
+		if pendingOf(conn) == nil { // not sniffed by the gateway
+			if conn = s.pendingConn(conn); conn == nil {
+				continue
+			}
+		}
+		conn = s.sessionConn(conn)
 
-		s.mu.Lock()
-		s.activeConn[conn] = struct{}{}
-		s.mu.Unlock()
+		if reason := s.addConn(conn); reason != "" {
+			go s.rejectConn(conn, reason)
+			continue
+		}
 
 		if share.Trace {
 			log.Debugf("server accepted an conn: %v", conn.RemoteAddr().String())
@@ -300,7 +403,7 @@ func (s *Server) serveListener(ln net.Listener) error {
 // serveByHTTP serves by HTTP.
 // if rpcPath is an empty string, use share.DefaultRPCPath.
 func (s *Server) serveByHTTP(ln net.Listener, rpcPath string) {
-	s.ln = ln
+	s.setListener(ln)
 
 	if rpcPath == "" {
 		rpcPath = share.DefaultRPCPath
@@ -313,13 +416,13 @@ func (s *Server) serveByHTTP(ln net.Listener, rpcPath string) {
 }
 
 func (s *Server) serveByWS(ln net.Listener, rpcPath string) {
-	s.ln = ln
+	s.setListener(ln)
 
 	if rpcPath == "" {
 		rpcPath = share.DefaultRPCPath
 	}
 	mux := http.NewServeMux()
-	mux.Handle(rpcPath, websocket.Handler(s.ServeWS))
+	mux.Handle(rpcPath, s.websocketHandler())
 	srv := &http.Server{Handler: mux}
 
 	srv.Serve(ln)
@@ -327,12 +430,14 @@ func (s *Server) serveByWS(ln net.Listener, rpcPath string) {
 
 func (s *Server) serveConn(conn net.Conn) {
 	if s.isShutdown() {
-		s.closeConn(conn)
+		s.closeConn(conn, CloseReasonServerClosed)
 		return
 	}
 
+	closeReason := CloseReasonServerClosed
 	defer func() {
 		if err := recover(); err != nil {
+			closeReason = CloseReasonPanic
 			const size = 64 << 10
 			buf := make([]byte, size)
 			ss := runtime.Stack(buf, false)
@@ -347,28 +452,52 @@ func (s *Server) serveConn(conn net.Conn) {
 			log.Debugf("server closed conn: %v", conn.RemoteAddr().String())
 		}
 
-		s.closeConn(conn)
+		if s.isShutdown() {
+			closeReason = CloseReasonServerClosed
+		}
+		s.closeConn(conn, closeReason)
 	}()
 
-	if tlsConn, ok := conn.(*tls.Conn); ok {
-		if d := s.readTimeout; d != 0 {
+	// the TLS handshake, the session handshake and the first message are bounded by WithFirstRequestTimeout
+	pending := pendingOf(conn)
+	if tlsConn, ok := netConn(conn).(*tls.Conn); ok {
+		settings := s.runtime()
+		if d := settings.ReadTimeout; d != 0 {
 			conn.SetReadDeadline(time.Now().Add(d))
 		}
-		if d := s.writeTimeout; d != 0 {
+		if d := settings.WriteTimeout; d != 0 {
 			conn.SetWriteDeadline(time.Now().Add(d))
 		}
 		if err := tlsConn.Handshake(); err != nil {
-			log.Errorf("rpcx: TLS handshake error from %s: %v", conn.RemoteAddr(), err)
+			logger.Error(context.Background(), "rpcx: TLS handshake error", "remote", conn.RemoteAddr().String(), "error", err)
+			closeReason = pending.timeoutReason(CloseReasonTLSHandshake)
 			return
 		}
+		pending.handshaken()
 	}
+	if !s.handshakeSession(conn) {
+		closeReason = pending.timeoutReason(CloseReasonSessionHandshake)
+		return
+	}
+
+	s.mu.RLock()
+	info := s.activeConn[conn]
+	s.mu.RUnlock()
 
 	r := bufio.NewReaderSize(conn, ReaderBuffsize)
+	chunks := s.newReassembler()
 
+	// tasks of requests may write by writeCh after the connection is closed, so it is closed once they are done
+	var tasks sync.WaitGroup
 	var writeCh chan *[]byte
 	if s.AsyncWrite {
 		writeCh = make(chan *[]byte, WriteChanSize)
-		defer close(writeCh)
+		defer func() {
+			go func() {
+				tasks.Wait()
+				close(writeCh)
+			}()
+		}()
 		go s.serveAsyncWrite(conn, writeCh)
 	}
 
@@ -377,89 +506,156 @@ func (s *Server) serveConn(conn net.Conn) {
 			return
 		}
 
-		t0 := time.Now()
-		if s.readTimeout != 0 {
-			conn.SetReadDeadline(t0.Add(s.readTimeout))
+		// wait for the next message within the idle timeout and read it within the read timeout
+		if err := s.waitRequest(conn, r); err != nil {
+			closeReason = readCloseReason(conn, err, pending.timeoutReason(CloseReasonIdleTimeout))
+			return
 		}
+		info.touch()
 
-		ctx := share.WithValue(context.Background(), RemoteConnContextKey, conn)
+		// the context of the request is freed when the request is completed if it is pooled
+		ctx := s.getContext()
+		ctx.SetValue(RemoteConnContextKey, conn)
 
-		req, err := s.readRequest(ctx, r)
-		if err != nil {
+		req, err := s.readRequest(ctx, r, chunks)
+		if req == nil && err == nil { // more chunk frames of the request are expected
+			s.freeContext(ctx)
+			continue
+		}
+		if err == nil {
+			pending.done()
+		}
+		// a checksum mismatch or an unknown compress type only fails this request
+		requestFailed := s.isChecksumMismatch(conn, req, err)
+		if uerr := s.unsupportedCompressType(conn, req, err); uerr != nil {
+			err, requestFailed = uerr, true
+		}
+		if requestFailed && req.MessageType() == protocol.Response {
 			protocol.FreeMsg(req)
-
-			if err == io.EOF {
-				log.Infof("client has closed this connection: %s", conn.RemoteAddr().String())
-			} else if strings.Contains(err.Error(), "use of closed network connection") {
-				log.Infof("rpcx: connection %s is closed", conn.RemoteAddr().String())
-			} else {
-				log.Warnf("rpcx: failed to read request: %v", err)
+			s.freeContext(ctx)
+			continue
+		}
+		// so does a panic in plugins
+		if err != nil && !isPanicError(err) && !requestFailed {
+			if req == nil && rerrors.CodeOf(err) == rerrors.ResourceExhausted { // rejected by PreReadRequest plugins
+				s.stats.accept()
+				s.stats.shed(RejectReasonRateLimit)
 			}
+			if reason := frameCloseReason(err); reason != "" {
+				if reason == CloseReasonMessageTooLarge {
+					s.writeMessageTooLarge(conn, req, err)
+				} else {
+					logger.Warn(context.Background(), "rpcx: bad frame", "remote", conn.RemoteAddr().String(), "error", err)
+				}
+				protocol.FreeMsg(req)
+				closeReason = reason
+				return
+			}
+			protocol.FreeMsg(req)
+			closeReason = readCloseReason(conn, err, pending.timeoutReason(CloseReasonReadTimeout))
 			return
 		}
 
-		if s.writeTimeout != 0 {
-			conn.SetWriteDeadline(t0.Add(s.writeTimeout))
-		}
-
 		if share.Trace {
 			log.Debugf("server received an request %+v from conn: %v", req, conn.RemoteAddr().String())
 		}
 
+		if err == nil && req.MessageType() == protocol.Response { // replies to heartbeats of the reaper
+			protocol.FreeMsg(req)
+			s.freeContext(ctx)
+			continue
+		}
+
+		counted := !req.IsHeartbeat()
+		if counted {
+			s.stats.accept()
+		}
+
 		ctx = share.WithLocalValue(ctx, StartRequestContextKey, time.Now().UnixNano())
 		closeConn := false
-		if !req.IsHeartbeat() {
-			err = s.auth(ctx, req)
-			closeConn = err != nil
+		if err == nil && !req.IsHeartbeat() {
+			err = s.safeCall(ctx, req, func() error {
+				return s.auth(ctx, req)
+			})
+			closeConn = err != nil && !isPanicError(err)
 		}
 
 		if err != nil {
-			if !req.IsOneway() {
-				res := req.Clone()
-				res.SetMessageType(protocol.Response)
-				if len(res.Payload) > 1024 && req.CompressType() != protocol.None {
-					res.SetCompressType(req.CompressType())
-				}
-				handleError(res, err)
-				s.Plugins.DoPreWriteResponse(ctx, req, res, err)
-				data := res.EncodeSlicePointer()
-				if s.AsyncWrite {
-					writeCh <- data
-				} else {
-					conn.Write(*data)
-					protocol.PutData(data)
-				}
-				s.Plugins.DoPostWriteResponse(ctx, req, res, err)
-				protocol.FreeMsg(res)
+			if closeConn {
+				// written before the connection is closed so that the client gets the error
+				s.writeErrorResponse(ctx, conn, nil, req, err)
 			} else {
-				s.Plugins.DoPreWriteResponse(ctx, req, nil, err)
+				s.writeErrorResponse(ctx, conn, writeCh, req, err)
 			}
 			protocol.FreeMsg(req)
+			if counted {
+				s.stats.complete()
+			}
 			// auth failed, closed the connection
 			if closeConn {
-				log.Infof("auth failed for conn %s: %v", conn.RemoteAddr().String(), err)
+				logger.Info(ctx, "auth failed", "remote", conn.RemoteAddr().String(), "servicePath", req.ServicePath,
+					"serviceMethod", req.ServiceMethod, "seq", req.Seq(), "error", err)
+				closeReason = CloseReasonAuthFailed
 				return
 			}
+			s.freeContext(ctx)
 			continue
 		}
-		go func() {
+
+		// counted before dispatching so that Shutdown waits for queued requests too
+		atomic.AddInt32(&s.handlerMsgNum, 1)
+		tasks.Add(1)
+		task := func() {
+			// detached requests are released when they are completed by their AsyncReply
+			detached := false
+			release := func() {
+				defer tasks.Done()
+				atomic.AddInt32(&s.handlerMsgNum, -1)
+				info.done(s.runtime().MaxInflightPerConnection)
+				if counted {
+					s.stats.complete()
+				}
+				s.freeContext(ctx)
+			}
+			defer func() {
+				if !detached {
+					release()
+				}
+			}()
+			servicePath, serviceMethod := req.ServicePath, req.ServiceMethod
+			responded := req.IsOneway()
+			var res *protocol.Message
+			var inflight *inflightRequest
 			defer func() {
 				if r := recover(); r != nil {
-					// maybe panic because the writeCh is closed.
+					if e, ok := r.(error); ok && strings.Contains(e.Error(), "send on closed channel") {
+						// the writeCh is closed because the connection is closed.
+						if res != nil {
+							s.Plugins.DoPostWriteResponse(ctx, req, res, e)
+						}
+						return
+					}
+					err := s.handlePanic(ctx, servicePath, serviceMethod, r, debug.Stack())
+					if !responded && (inflight == nil || inflight.claim()) {
+						s.writePanicResponse(ctx, conn, writeCh, req, err)
+					} else if req.IsOneway() {
+						s.completeOneway(ctx, req, err)
+					}
 				}
 			}()
 
-			atomic.AddInt32(&s.handlerMsgNum, 1)
-			defer atomic.AddInt32(&s.handlerMsgNum, -1)
-
 			if req.IsHeartbeat() {
+				if req.Metadata[protocol.NegotiateKey] != "" {
+					s.negotiate(conn, info, req)
+				}
 				s.Plugins.DoHeartbeatRequest(ctx, req)
 				req.SetMessageType(protocol.Response)
 				data := req.EncodeSlicePointer()
+				responded = true
 				if s.AsyncWrite {
 					writeCh <- data
 				} else {
-					conn.Write(*data)
+					s.writeConn(conn, *data)
 					protocol.PutData(data)
 				}
 				protocol.FreeMsg(req)
@@ -467,12 +663,16 @@ func (s *Server) serveConn(conn net.Conn) {
 			}
 
 			resMetadata := make(map[string]string)
-			ctx = share.WithLocalValue(share.WithLocalValue(ctx, share.ReqMetaDataKey, req.Metadata),
+			ctx = share.WithLocalValue(share.WithLocalValue(ctx, share.ReqMetaDataKey, s.requestMetadata(req)),
 				share.ResMetaDataKey, resMetadata)
 
-			cancelFunc := parseServerTimeout(ctx, req)
+			cancelFunc := share.ExtractPropagated(ctx, req.Metadata)
 			if cancelFunc != nil {
-				defer cancelFunc()
+				defer func() {
+					if !detached {
+						cancelFunc()
+					}
+				}()
 			}
 
 			s.Plugins.DoPreHandleRequest(ctx, req)
@@ -483,125 +683,295 @@ func (s *Server) serveConn(conn net.Conn) {
 
 			// first use handler
 			if handler, ok := s.router[req.ServicePath+"."+req.ServiceMethod]; ok {
+				responded = true // handlers write responses by themselves
 				sctx := NewContext(ctx, conn, req, writeCh)
 				err := handler(sctx)
 				if err != nil {
-					log.Errorf("[handler internal error]: servicepath: %s, servicemethod, err: %v", req.ServicePath, req.ServiceMethod, err)
+					logger.Error(ctx, "[handler internal error]", "remote", conn.RemoteAddr().String(), "servicePath", req.ServicePath,
+						"serviceMethod", req.ServiceMethod, "seq", req.Seq(), "error", err)
+				}
+				if req.IsOneway() {
+					s.completeOneway(ctx, req, err)
+					s.observeSlowRequest(ctx, conn, req, err)
+					return
+				}
+				// the response is written by the handler, plugins get one without the payload
+				res := req.Clone()
+				res.SetMessageType(protocol.Response)
+				if err != nil {
+					handleError(res, err)
 				}
+				s.Plugins.DoPostWriteResponse(ctx, req, res, err)
+				protocol.FreeMsg(res)
+				s.observeSlowRequest(ctx, conn, req, err)
 
 				return
 			}
 
-			//
-			res, err := s.handleRequest(ctx, req)
-			if err != nil {
-				if s.HandleServiceError != nil {
-					s.HandleServiceError(err)
-				} else {
-					log.Warnf("rpcx: failed to handle request: %v", err)
+			inflight = s.trackRequest(ctx, info, conn, writeCh, req)
+			defer func() {
+				if !detached {
+					s.untrackRequest(info, inflight)
 				}
-			}
+			}()
 
-			s.Plugins.DoPreWriteResponse(ctx, req, res, err)
-			if !req.IsOneway() {
-				if len(resMetadata) > 0 { // copy meta in context to request
-					meta := res.Metadata
-					if meta == nil {
-						res.Metadata = resMetadata
-					} else {
-						for k, v := range resMetadata {
-							if meta[k] == "" {
-								meta[k] = v
-							}
-						}
+			var err error
+			res, err = s.handleRequest(ctx, req)
+			if err == errDetached {
+				detached = true
+				inflight.asyncReply().handoff(s, ctx, req, res, resMetadata, func() {
+					s.untrackRequest(info, inflight)
+					if cancelFunc != nil {
+						cancelFunc()
 					}
-				}
+					release()
+				})
+				return
+			}
+			s.writeResponse(ctx, conn, writeCh, req, res, err, resMetadata, inflight)
+		}
 
-				if len(res.Payload) > 1024 && req.CompressType() != protocol.None {
-					res.SetCompressType(req.CompressType())
-				}
-				data := res.EncodeSlicePointer()
-				if s.AsyncWrite {
-					writeCh <- data
-				} else {
-					conn.Write(*data)
-					protocol.PutData(data)
-				}
+		if req.IsHeartbeat() {
+			info.addInflight(1)
+			go task()
+			continue
+		}
 
+		dispatch := func() {
+			if s.workerPool == nil {
+				go task()
+				return
 			}
-			s.Plugins.DoPostWriteResponse(ctx, req, res, err)
 
-			if share.Trace {
-				log.Debugf("server write response %+v for an request %+v from conn: %v", res, req, conn.RemoteAddr().String())
+			queued := time.Now()
+			if s.workerPool.submit(func() {
+				s.stats.observeQueueWait(time.Since(queued))
+				task()
+			}, requestPriority(req)) {
+				s.stats.observeQueueDepth(len(s.workerPool.queue))
+			} else {
+				atomic.AddInt32(&s.handlerMsgNum, -1)
+				info.done(s.runtime().MaxInflightPerConnection)
+				s.stats.shed(RejectReasonBusy)
+				s.Plugins.DoRequestRejected(ctx, req, RejectReasonBusy, ErrServerBusy)
+				s.writeErrorResponse(ctx, conn, writeCh, req, ErrServerBusy)
+				protocol.FreeMsg(req)
+				s.freeContext(ctx)
+				tasks.Done()
 			}
-
-			protocol.FreeMsg(req)
-			protocol.FreeMsg(res)
-		}()
+		}
+		// requests beyond WithMaxInflightPerConnection are queued or rejected before the worker pool
+		settings := s.runtime()
+		if admitted, queued := info.admit(settings.MaxInflightPerConnection, settings.InflightQueuePerConnection, dispatch); admitted {
+			dispatch()
+		} else if !queued {
+			s.rejectConnectionBusy(ctx, conn, writeCh, req)
+			s.freeContext(ctx)
+			tasks.Done()
+		}
 	}
 }
 
-func (s *Server) serveAsyncWrite(conn net.Conn, writeCh chan *[]byte) {
-	for {
-		select {
-		case <-s.doneChan:
-			return
-		case data := <-writeCh:
-			if data == nil {
-				return
+// writeResponse writes res, the response of req handled with err, unless the response has been written
+// by others such as CancelRequest. Oneway requests are completed without responses. It frees req and res.
+func (s *Server) writeResponse(ctx *share.Context, conn net.Conn, writeCh chan *[]byte, req, res *protocol.Message, err error,
+	resMetadata map[string]string, inflight *inflightRequest) {
+	if errors.Is(err, ErrDropResponse) && !req.IsOneway() {
+		s.observeSlowRequest(ctx, conn, req, err)
+		s.releaseReply(ctx)
+		protocol.FreeMsg(req)
+		protocol.FreeMsg(res)
+		return
+	}
+	if err != nil {
+		if s.HandleServiceError != nil {
+			s.HandleServiceError(err)
+		} else {
+			logger.Warn(ctx, "rpcx: failed to handle request", "remote", conn.RemoteAddr().String(), "servicePath", req.ServicePath,
+				"serviceMethod", req.ServiceMethod, "seq", req.Seq(), "error", err)
+		}
+	}
+
+	if req.IsOneway() {
+		s.completeOneway(ctx, req, err)
+		s.observeSlowRequest(ctx, conn, req, err)
+		s.releaseReply(ctx)
+		protocol.FreeMsg(req)
+		protocol.FreeMsg(res)
+		return
+	}
+
+	// CancelRequest has responded to canceled requests
+	if inflight.isResponded() && err == nil {
+		err = ErrRequestCanceled
+	}
+
+	s.Plugins.DoPreWriteResponse(ctx, req, res, err)
+	if inflight.claim() {
+		if len(resMetadata) > 0 { // copy meta in context to request
+			meta := res.Metadata
+			if meta == nil {
+				res.Metadata = resMetadata
+			} else {
+				for k, v := range resMetadata {
+					if meta[k] == "" {
+						meta[k] = v
+					}
+				}
 			}
-			conn.Write(*data)
-			protocol.PutData(data)
 		}
+
+		s.acceptChunks(conn, req, res)
+		s.setResponseCompressType(req, res)
+		s.setChecksum(conn, res)
+		if perr := s.preEncodeResponse(ctx, req, res); perr != nil && err == nil {
+			err = perr
+		}
+		msg := res.EncodeVectored()
+		s.observeCompression(res, msg.PayloadLen())
+		if !s.AsyncWrite {
+			writeCh = nil
+		}
+		if werr := s.writeMessage(conn, writeCh, msg); werr != nil && err == nil {
+			err = werr
+		}
+
+	}
+	s.Plugins.DoPostWriteResponse(ctx, req, res, err)
+	s.observeSlowRequest(ctx, conn, req, err)
+
+	if share.Trace {
+		log.Debugf("server write response %+v for an request %+v from conn: %v", res, req, conn.RemoteAddr().String())
+	}
+
+	s.releaseReply(ctx)
+	protocol.FreeMsg(req)
+	protocol.FreeMsg(res)
+}
+
+// completeOneway completes the oneway request req handled with err. Errors are counted since clients don't get them.
+func (s *Server) completeOneway(ctx context.Context, req *protocol.Message, err error) {
+	if err != nil {
+		atomic.AddUint64(&s.stats.onewayErrors, 1)
 	}
+	s.Plugins.DoOnewayCompleted(ctx, req, err)
 }
 
-func parseServerTimeout(ctx *share.Context, req *protocol.Message) context.CancelFunc {
-	if req == nil || req.Metadata == nil {
-		return nil
+// writeErrorResponse writes err as the response of req if req is not oneway.
+// It is written on conn directly if writeCh is nil.
+func (s *Server) writeErrorResponse(ctx context.Context, conn net.Conn, writeCh chan *[]byte, req *protocol.Message, err error) {
+	if req.IsOneway() {
+		logger.Warn(ctx, "rpcx: dropped oneway request", "remote", conn.RemoteAddr().String(), "servicePath", req.ServicePath,
+			"serviceMethod", req.ServiceMethod, "seq", req.Seq(), "error", err)
+		s.completeOneway(ctx, req, err)
+		return
 	}
 
-	st := req.Metadata[share.ServerTimeout]
-	if st == "" {
-		return nil
+	res := req.Clone()
+	res.SetMessageType(protocol.Response)
+	handleError(res, err)
+	s.setResponseCompressType(req, res)
+	s.setChecksum(conn, res)
+	s.Plugins.DoPreWriteResponse(ctx, req, res, err)
+	s.preEncodeResponse(ctx, req, res)
+	data := res.EncodeSlicePointer()
+	if writeCh != nil {
+		writeCh <- data
+	} else {
+		s.writeConn(conn, *data)
+		protocol.PutData(data)
 	}
+	s.Plugins.DoPostWriteResponse(ctx, req, res, err)
+	protocol.FreeMsg(res)
+}
 
-	timeout, err := strconv.ParseInt(st, 10, 64)
+// preEncodeResponse invokes PreEncodeResponse plugins on res, the response of req, just before it is encoded.
+// If a plugin fails, res is replaced with the error response of its error, which is sent without plugins, and the error is returned.
+func (s *Server) preEncodeResponse(ctx context.Context, req, res *protocol.Message) error {
+	err := s.Plugins.DoPreEncodeResponse(ctx, req, res)
 	if err != nil {
-		return nil
+		logger.Warn(ctx, "rpcx: failed to encode response", "servicePath", req.ServicePath,
+			"serviceMethod", req.ServiceMethod, "seq", req.Seq(), "error", err)
+		res.Payload = nil
+		res.SetCompressType(protocol.None)
+		handleError(res, err)
+	}
+	return err
+}
+
+// writePanicResponse writes an error response for a request whose handling panicked.
+func (s *Server) writePanicResponse(ctx context.Context, conn net.Conn, writeCh chan *[]byte, req *protocol.Message, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("rpcx: failed to write response for panic: %v", r)
+		}
+	}()
+
+	res := req.Clone()
+	res.SetMessageType(protocol.Response)
+	handleError(res, err)
+	s.preEncodeResponse(ctx, req, res)
+	data := res.EncodeSlicePointer()
+	if s.AsyncWrite {
+		writeCh <- data
+	} else {
+		s.writeConn(conn, *data)
+		protocol.PutData(data)
 	}
+	protocol.FreeMsg(res)
+}
 
-	newCtx, cancel := context.WithTimeout(ctx.Context, time.Duration(timeout)*time.Millisecond)
-	ctx.Context = newCtx
-	return cancel
+// serveAsyncWrite writes messages queued in writeCh on conn until writeCh is closed.
+// Messages queued after the connection is closed fail to be written and are discarded.
+func (s *Server) serveAsyncWrite(conn net.Conn, writeCh chan *[]byte) {
+	for data := range writeCh {
+		s.writeConn(conn, *data)
+		protocol.PutData(data)
+	}
 }
 
 func (s *Server) isShutdown() bool {
 	return atomic.LoadInt32(&s.inShutdown) == 1
 }
 
-func (s *Server) closeConn(conn net.Conn) {
+func (s *Server) closeConn(conn net.Conn, reason string) {
 	s.mu.Lock()
-	delete(s.activeConn, conn)
+	info := s.removeConnLocked(conn)
 	s.mu.Unlock()
 
 	conn.Close()
 
+	if info == nil { // closed by Close or Shutdown
+		return
+	}
+	if info.closeReason != "" {
+		reason = info.closeReason
+	}
 	s.Plugins.DoPostConnClose(conn)
+	s.Plugins.DoPostConnCloseReason(conn, reason)
 }
 
-func (s *Server) readRequest(ctx context.Context, r io.Reader) (req *protocol.Message, err error) {
+// readRequest reads a request from r, reassembling chunked requests by chunks if it is not nil.
+// It returns a nil request and a nil error if the request read is a chunk frame and more are expected.
+func (s *Server) readRequest(ctx context.Context, r io.Reader, chunks *protocol.Reassembler) (req *protocol.Message, err error) {
 	err = s.Plugins.DoPreReadRequest(ctx)
 	if err != nil {
 		return nil, err
 	}
 	// pool req?
 	req = protocol.GetPooledMsg()
-	err = req.Decode(r)
+	err = req.DecodeLimit(r, s.messageSizeLimit())
 	if err == io.EOF {
 		return req, err
 	}
-	perr := s.Plugins.DoPostReadRequest(ctx, req, err)
+	if chunks != nil && req.IsChunk() {
+		if req, err = chunks.Reassemble(req, err); req == nil && err == nil {
+			return nil, nil
+		}
+	}
+	perr := s.safeCall(ctx, req, func() error {
+		return s.Plugins.DoPostReadRequest(ctx, req, err)
+	})
 	if err == nil {
 		err = perr
 	}
@@ -624,6 +994,13 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
 	res = req.Clone()
 
 	res.SetMessageType(protocol.Response)
+
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = handleError(res, s.handlePanic(ctx, serviceName, methodName, r, debug.Stack()))
+		}
+	}()
+
 	s.serviceMapMu.RLock()
 	service := s.serviceMap[serviceName]
 
@@ -631,48 +1008,84 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
 		log.Debugf("server get service %+v for an request %+v", service, req)
 	}
 
+	var mtype *methodType
+	var isFunction bool
+	var raw RawHandler
+	if service != nil {
+		raw = service.raw
+		mtype = service.method[methodName]
+		isFunction = mtype == nil && service.function[methodName] != nil
+		if !isFunction { // functions are counted by handleRequestForFunction
+			service.begin()
+			defer func() {
+				if err != errDetached { // ended when the detached request is completed
+					service.end()
+				}
+			}()
+		}
+	}
 	s.serviceMapMu.RUnlock()
 	if service == nil {
-		err = errors.New("rpcx: can't find service " + serviceName)
+		err = rerrors.New(rerrors.NotFound, "rpcx: can't find service "+serviceName)
+		return handleError(res, err)
+	}
+	if err = s.disallowedSerializeType(ctx, req); err != nil {
 		return handleError(res, err)
 	}
-	mtype := service.method[methodName]
+	if raw != nil {
+		return s.handleRawRequest(ctx, raw, req, res)
+	}
 	if mtype == nil {
-		if service.function[methodName] != nil { // check raw functions
+		if isFunction { // check raw functions
 			return s.handleRequestForFunction(ctx, req)
 		}
-		err = errors.New("rpcx: can't find method " + methodName)
+		err = rerrors.New(rerrors.NotFound, "rpcx: can't find method "+methodName)
 		return handleError(res, err)
 	}
 
 	// get a argv object from object pool
 	argv := reflectTypePools.Get(mtype.ArgType)
 
-	codec := share.Codecs[req.SerializeType()]
+	codec := share.CodecOf(req.SerializeType())
 	if codec == nil {
-		err = fmt.Errorf("can not find codec for %d", req.SerializeType())
+		return handleError(res, s.unsupportedSerializeType(ctx, req))
+	}
+
+	err = s.decodeArgs(codec, req, argv)
+	if err != nil {
 		return handleError(res, err)
 	}
 
-	err = codec.Decode(req.Payload, argv)
+	err = s.validate(ctx, req, argv)
 	if err != nil {
+		reflectTypePools.Put(mtype.ArgType, argv)
 		return handleError(res, err)
 	}
 
 	// and get a reply object from object pool
-	replyv := reflectTypePools.Get(mtype.ReplyType)
+	replyv := s.getReply(mtype.ReplyType)
 
 	argv, err = s.Plugins.DoPreCall(ctx, serviceName, methodName, argv)
 	if err != nil {
 		// return reply to object pool
-		reflectTypePools.Put(mtype.ReplyType, replyv)
+		s.putReply(ctx, mtype.ReplyType, replyv)
 		return handleError(res, err)
 	}
 
-	if mtype.ArgType.Kind() != reflect.Ptr {
-		err = service.call(ctx, mtype, reflect.ValueOf(argv).Elem(), reflect.ValueOf(replyv))
+	retainArgs(ctx, req, argv)
+	if len(s.middlewares) == 0 {
+		err = service.call(ctx, mtype, callValue(mtype.ArgType, argv), reflect.ValueOf(replyv))
 	} else {
-		err = service.call(ctx, mtype, reflect.ValueOf(argv), reflect.ValueOf(replyv))
+		err = s.invoke(ctx, serviceName, methodName, argv, replyv,
+			func(ctx context.Context, _, _ string, args, reply interface{}) error {
+				return service.call(ctx, mtype, callValue(mtype.ArgType, args), reflect.ValueOf(reply))
+			})
+	}
+
+	if a := detachedRequest(ctx); a != nil {
+		// args and reply are not returned to pools since the handler may still use them
+		a.detachedCall(service, argv, true, err)
+		return res, errDetached
 	}
 
 	if err == nil {
@@ -683,28 +1096,30 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
 	reflectTypePools.Put(mtype.ArgType, argv)
 
 	if err != nil {
-		if replyv != nil {
-			data, err := codec.Encode(replyv)
+		if replyv != nil && !req.IsOneway() {
+			data, err := share.EncodePayload(codec, res, replyv)
 			// return reply to object pool
-			reflectTypePools.Put(mtype.ReplyType, replyv)
+			s.putReply(ctx, mtype.ReplyType, replyv)
 			if err != nil {
 				return handleError(res, err)
 			}
 			res.Payload = data
+		} else if replyv != nil {
+			s.putReply(ctx, mtype.ReplyType, replyv)
 		}
 		return handleError(res, err)
 	}
 
 	if !req.IsOneway() {
-		data, err := codec.Encode(replyv)
+		data, err := share.EncodePayload(codec, res, replyv)
 		// return reply to object pool
-		reflectTypePools.Put(mtype.ReplyType, replyv)
+		s.putReply(ctx, mtype.ReplyType, replyv)
 		if err != nil {
 			return handleError(res, err)
 		}
 		res.Payload = data
 	} else if replyv != nil {
-		reflectTypePools.Put(mtype.ReplyType, replyv)
+		s.putReply(ctx, mtype.ReplyType, replyv)
 	}
 
 	if share.Trace {
@@ -723,54 +1138,77 @@ func (s *Server) handleRequestForFunction(ctx context.Context, req *protocol.Mes
 	methodName := req.ServiceMethod
 	s.serviceMapMu.RLock()
 	service := s.serviceMap[serviceName]
+	var mtype *functionType
+	if service != nil {
+		service.begin()
+		defer func() {
+			if err != errDetached {
+				service.end()
+			}
+		}()
+		mtype = service.function[methodName]
+	}
 	s.serviceMapMu.RUnlock()
 	if service == nil {
-		err = errors.New("rpcx: can't find service  for func raw function")
+		err = rerrors.New(rerrors.NotFound, "rpcx: can't find service  for func raw function")
 		return handleError(res, err)
 	}
-	mtype := service.function[methodName]
 	if mtype == nil {
-		err = errors.New("rpcx: can't find method " + methodName)
+		err = rerrors.New(rerrors.NotFound, "rpcx: can't find method "+methodName)
 		return handleError(res, err)
 	}
 
 	argv := reflectTypePools.Get(mtype.ArgType)
 
-	codec := share.Codecs[req.SerializeType()]
+	codec := share.CodecOf(req.SerializeType())
 	if codec == nil {
-		err = fmt.Errorf("can not find codec for %d", req.SerializeType())
+		return handleError(res, s.unsupportedSerializeType(ctx, req))
+	}
+
+	err = s.decodeArgs(codec, req, argv)
+	if err != nil {
 		return handleError(res, err)
 	}
 
-	err = codec.Decode(req.Payload, argv)
+	err = s.validate(ctx, req, argv)
 	if err != nil {
+		reflectTypePools.Put(mtype.ArgType, argv)
 		return handleError(res, err)
 	}
 
-	replyv := reflectTypePools.Get(mtype.ReplyType)
+	replyv := s.getReply(mtype.ReplyType)
 
-	if mtype.ArgType.Kind() != reflect.Ptr {
-		err = service.callForFunction(ctx, mtype, reflect.ValueOf(argv).Elem(), reflect.ValueOf(replyv))
+	retainArgs(ctx, req, argv)
+	if len(s.middlewares) == 0 {
+		err = service.callForFunction(ctx, mtype, callValue(mtype.ArgType, argv), reflect.ValueOf(replyv))
 	} else {
-		err = service.callForFunction(ctx, mtype, reflect.ValueOf(argv), reflect.ValueOf(replyv))
+		err = s.invoke(ctx, serviceName, methodName, argv, replyv,
+			func(ctx context.Context, _, _ string, args, reply interface{}) error {
+				return service.callForFunction(ctx, mtype, callValue(mtype.ArgType, args), reflect.ValueOf(reply))
+			})
+	}
+
+	if a := detachedRequest(ctx); a != nil {
+		a.detachedCall(service, argv, false, err)
+		return res, errDetached
 	}
 
 	reflectTypePools.Put(mtype.ArgType, argv)
 
 	if err != nil {
-		reflectTypePools.Put(mtype.ReplyType, replyv)
+		s.putReply(ctx, mtype.ReplyType, replyv)
 		return handleError(res, err)
 	}
 
 	if !req.IsOneway() {
-		data, err := codec.Encode(replyv)
-		reflectTypePools.Put(mtype.ReplyType, replyv)
+		data, err := share.EncodePayload(codec, res, replyv)
+		s.putReply(ctx, mtype.ReplyType, replyv)
 		if err != nil {
 			return handleError(res, err)
 		}
 		res.Payload = data
 	} else if replyv != nil {
-		reflectTypePools.Put(mtype.ReplyType, replyv)
+		s.putReply(ctx, mtype.ReplyType, replyv)
 	}
 
 	return res, nil
@@ -781,10 +1219,30 @@ func handleError(res *protocol.Message, err error) (*protocol.Message, error) {
 	if res.Metadata == nil {
 		res.Metadata = make(map[string]string)
 	}
-	res.Metadata[protocol.ServiceError] = err.Error()
+	setErrorMetadata(res.Metadata, err)
 	return res, err
 }
 
+// setErrorMetadata encodes err into reserved keys of the response metadata.
+// The code and details are only set for errors that have them, so plain errors are sent as before.
+func setErrorMetadata(meta map[string]string, err error) {
+	meta[protocol.ServiceError] = err.Error()
+	// errors of contexts are sent with their codes, so clients can tell deadlines expired on servers
+	if code := classifyError(err); code != rerrors.Unknown {
+		meta[protocol.ServiceErrorCode] = strconv.Itoa(int(code))
+	}
+	for k, v := range rerrors.DetailsOf(err) {
+		meta[protocol.ServiceErrorDetailPrefix+k] = v
+	}
+}
+
+// Errorf creates an error with code that is sent to clients with its code,
+// so clients can decide to retry, fail over or surface the error by the code instead of the message.
+// Details can be added by WithDetail.
+func Errorf(code rerrors.Code, format string, a ...interface{}) *rerrors.Error {
+	return rerrors.Errorf(code, format, a...)
+}
+
 // Can connect to RPC service using HTTP CONNECT to rpcPath.
 var connected = "200 Connected to rpcx"
 
@@ -803,20 +1261,26 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
 
-	s.mu.Lock()
-	s.activeConn[conn] = struct{}{}
-	s.mu.Unlock()
+	conn = s.sessionConn(conn)
+	if reason := s.addConn(conn); reason != "" {
+		s.rejectConn(conn, reason)
+		return
+	}
 
 	s.serveConn(conn)
 }
 
+// ServeWS serves a connection of golang.org/x/net/websocket, for servers mounting websocket.Handler(s.ServeWS) themselves.
+// Serve with ws and wss uses its own handler, which supports WithWebsocketCompression and WithWebsocketMaxMessageSize.
 func (s *Server) ServeWS(conn *websocket.Conn) {
-	s.mu.Lock()
-	s.activeConn[conn] = struct{}{}
-	s.mu.Unlock()
-
 	conn.PayloadType = websocket.BinaryFrame
-	s.serveConn(conn)
+	sc := s.sessionConn(conn)
+	if reason := s.addConn(sc); reason != "" {
+		s.rejectConn(sc, reason)
+		return
+	}
+
+	s.serveConn(sc)
 }
 
 // Close immediately closes all active net.Listeners.
@@ -828,10 +1292,12 @@ func (s *Server) Close() error {
 	if s.ln != nil {
 		err = s.ln.Close()
 	}
+	s.closeExtraListenersLocked()
 	for c := range s.activeConn {
 		c.Close()
-		delete(s.activeConn, c)
+		s.removeConnLocked(c)
 		s.Plugins.DoPostConnClose(c)
+		s.Plugins.DoPostConnCloseReason(c, CloseReasonServerClosed)
 	}
 	s.closeDoneChanLocked()
 	return err
@@ -839,10 +1305,16 @@ func (s *Server) Close() error {
 
 // RegisterOnShutdown registers a function to call on Shutdown.
 // This can be used to gracefully shutdown connections.
+// It is a pre-drain hook with priority 0 that runs after the registry hook. Use AddShutdownHook for more control.
 func (s *Server) RegisterOnShutdown(f func(s *Server)) {
-	s.mu.Lock()
-	s.onShutdown = append(s.onShutdown, f)
-	s.mu.Unlock()
+	s.AddShutdownHook(ShutdownHook{
+		Name:  "on-shutdown",
+		Phase: ShutdownPreDrain,
+		Fn: func(ctx context.Context, s *Server) error {
+			f(s)
+			return nil
+		},
+	})
 }
 
 // RegisterOnRestart registers a function to call on Restart.
@@ -855,19 +1327,28 @@ func (s *Server) RegisterOnRestart(f func(s *Server)) {
 var shutdownPollInterval = 1000 * time.Millisecond
 
 // Shutdown gracefully shuts down the server without interrupting any
-// active connections. Shutdown works by first closing the
+// active connections. Shutdown works by first running pre-drain hooks and closing the
 // listener, then closing all idle connections, and then waiting
 // indefinitely for connections to return to idle and then shut down.
+// Post-drain hooks run before connections are closed and post-close hooks at last.
 // If the provided context expires before the shutdown is complete,
-// Shutdown returns the context's error, otherwise it returns any
-// error returned from closing the Server's underlying Listener.
+// Shutdown returns the context's error. Errors of hooks are returned
+// together with it in a MultiError. The health status of the server is NOT_SERVING
+// since Shutdown is called.
 func (s *Server) Shutdown(ctx context.Context) error {
 	var err error
+	var hookErrs []error
 	if atomic.CompareAndSwapInt32(&s.inShutdown, 0, 1) {
 		log.Info("shutdown begin")
+		s.SetHealthStatus("", HealthNotServing)
+
+		hookErrs = append(hookErrs, s.runShutdownHooks(ctx, ShutdownPreDrain)...)
 
 		s.mu.Lock()
-		s.ln.Close()
+		if s.ln != nil {
+			s.ln.Close()
+		}
+		s.closeExtraListenersLocked()
 		for conn := range s.activeConn {
 			if tcpConn, ok := conn.(*net.TCPConn); ok {
 				tcpConn.CloseRead()
@@ -891,6 +1372,8 @@ func (s *Server) Shutdown(ctx context.Context) error {
 			}
 		}
 
+		hookErrs = append(hookErrs, s.runShutdownHooks(ctx, ShutdownPostDrain)...)
+
 		if s.gatewayHTTPServer != nil {
 			if err := s.closeHTTP1APIGateway(ctx); err != nil {
 				log.Warnf("failed to close gateway: %v", err)
@@ -902,54 +1385,24 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		s.mu.Lock()
 		for conn := range s.activeConn {
 			conn.Close()
-			delete(s.activeConn, conn)
+			s.removeConnLocked(conn)
 			s.Plugins.DoPostConnClose(conn)
+			s.Plugins.DoPostConnCloseReason(conn, CloseReasonServerClosed)
 		}
 		s.closeDoneChanLocked()
 		s.mu.Unlock()
 
+		hookErrs = append(hookErrs, s.runShutdownHooks(ctx, ShutdownPostClose)...)
+
 		log.Info("shutdown end")
 
 	}
-	return err
+	return shutdownError(hookErrs, err)
 }
 
-// Restart restarts this server gracefully.
-// It starts a new rpcx server with the same port with SO_REUSEPORT socket option,
-// and shutdown this rpcx server gracefully.
+// Restart restarts this server gracefully by StartGracefulRestart.
 func (s *Server) Restart(ctx context.Context) error {
-	pid, err := s.startProcess()
-	if err != nil {
-		return err
-	}
-	log.Infof("restart a new rpcx server: %d", pid)
-
-	// TODO: is it necessary?
-	time.Sleep(3 * time.Second)
-	return s.Shutdown(ctx)
-}
-
-func (s *Server) startProcess() (int, error) {
-	argv0, err := exec.LookPath(os.Args[0])
-	if err != nil {
-		return 0, err
-	}
-
-	// Pass on the environment and replace the old count key with the new one.
-	var env []string
-	env = append(env, os.Environ()...)
-
-	originalWD, _ := os.Getwd()
-	allFiles := []*os.File{os.Stdin, os.Stdout, os.Stderr}
-	process, err := os.StartProcess(argv0, os.Args, &os.ProcAttr{
-		Dir:   originalWD,
-		Env:   env,
-		Files: allFiles,
-	})
-	if err != nil {
-		return 0, err
-	}
-	return process.Pid, nil
+	return s.StartGracefulRestart(ctx)
 }
 
 func (s *Server) checkProcessMsg() bool {
@@ -4,12 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	testutils "github.com/smallnest/rpcx/_testutils"
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/share"
 	"github.com/stretchr/testify/assert"
@@ -42,6 +48,21 @@ func (t *Arith) ConsumingOperation(ctx context.Context, args *testutils.ThriftAr
 	return nil
 }
 
+// startServer serves s on a loopback port of network until the test finishes, and returns the address of it.
+// It returns once s is listening, so s.Address() is set.
+func startServer(t testing.TB, s *Server, network string) string {
+	t.Helper()
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(network, "127.0.0.1:0") }()
+	t.Cleanup(func() { s.Close() })
+	select {
+	case <-s.listening:
+	case err := <-served:
+		t.Fatalf("failed to serve %s: %v", network, err)
+	}
+	return s.Address().String()
+}
+
 func TestShutdownHook(t *testing.T) {
 	s := NewServer()
 	var cancel1 context.CancelFunc
@@ -178,3 +199,234 @@ func TestHandler(t *testing.T) {
 
 	assert.Equal(t, "{\"C\":200}", string(resp.Payload))
 }
+
+type PositiveArgs struct {
+	A int
+	B int
+}
+
+func (a *PositiveArgs) Validate() error {
+	if a.A <= 0 || a.B <= 0 {
+		return errors.New("A and B must be positive")
+	}
+	return nil
+}
+
+type Positive struct {
+	called int32
+}
+
+func (t *Positive) Mul(ctx context.Context, args *PositiveArgs, reply *Reply) error {
+	atomic.AddInt32(&t.called, 1)
+	reply.C = args.A * args.B
+	return nil
+}
+
+type rejectedRecorder struct {
+	reasons []string
+}
+
+func (r *rejectedRecorder) RequestRejected(ctx context.Context, req *protocol.Message, reason string, err error) {
+	r.reasons = append(r.reasons, reason)
+}
+
+func TestHandleRequestValidation(t *testing.T) {
+	newReq := func(servicePath string, args interface{}) *protocol.Message {
+		req := protocol.NewMessage()
+		req.SetMessageType(protocol.Request)
+		req.SetSerializeType(protocol.JSON)
+		req.ServicePath = servicePath
+		req.ServiceMethod = "Mul"
+		req.Payload, _ = json.Marshal(args)
+		return req
+	}
+
+	svc := &Positive{}
+	recorder := &rejectedRecorder{}
+	server := NewServer(WithValidator(func(ctx context.Context, args interface{}) error {
+		a, ok := args.(*Args)
+		if ok && a.B == 0 {
+			return errors.New("B must not be zero")
+		}
+		if ok && a.B > 100 {
+			return Errorf(rerrors.PermissionDenied, "B is not allowed").WithDetail("field", "B")
+		}
+		return nil
+	}))
+	server.Plugins.Add(recorder)
+	server.RegisterName("Positive", svc, "")
+	server.RegisterName("Arith", new(Arith), "")
+
+	res, err := server.handleRequest(context.Background(), newReq("Positive", &PositiveArgs{A: -1, B: 2}))
+	assert.Error(t, err)
+	assert.Equal(t, rerrors.InvalidArgument, rerrors.CodeOf(err))
+	assert.Equal(t, "A and B must be positive", res.Metadata[protocol.ServiceError])
+	assert.Equal(t, "3", res.Metadata[protocol.ServiceErrorCode])
+	assert.Equal(t, int32(0), atomic.LoadInt32(&svc.called))
+
+	_, err = server.handleRequest(context.Background(), newReq("Positive", &PositiveArgs{A: 1, B: 2}))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&svc.called))
+
+	_, err = server.handleRequest(context.Background(), newReq("Arith", &Args{A: 1}))
+	assert.Equal(t, rerrors.InvalidArgument, rerrors.CodeOf(err))
+
+	// codes and details of validators are kept
+	res, err = server.handleRequest(context.Background(), newReq("Arith", &Args{A: 1, B: 101}))
+	assert.Equal(t, rerrors.PermissionDenied, rerrors.CodeOf(err))
+	assert.Equal(t, map[string]string{"field": "B"}, rerrors.DetailsOf(err))
+	assert.Equal(t, "B", res.Metadata[protocol.ServiceErrorDetailPrefix+"field"])
+
+	assert.Equal(t, []string{RejectReasonValidation, RejectReasonValidation, RejectReasonValidation}, recorder.reasons)
+}
+
+var ctxValueKey = &contextKey{"test-value"}
+
+// ctxService sets values in contexts of requests, and keeps them in goroutines after it returns by share.Detach.
+type ctxService struct {
+	wg     sync.WaitGroup
+	leaked int32
+	lost   int32
+}
+
+func (s *ctxService) Keep(ctx context.Context, args *Args, reply *Reply) error {
+	if ctx.Value(ctxValueKey) != nil { // set by another request
+		atomic.AddInt32(&s.leaked, 1)
+	}
+	a := args.A // args may be reused after it returns
+	ctx.(*share.Context).SetValue(ctxValueKey, a)
+	detached := share.Detach(ctx)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		time.Sleep(time.Millisecond)
+		if detached.Value(ctxValueKey) != a || detached.Value(RemoteConnContextKey) == nil {
+			atomic.AddInt32(&s.lost, 1)
+		}
+	}()
+	reply.C = args.A * args.B
+	return nil
+}
+
+// ctxCheckPlugin checks contexts are not freed before plugins of responses are called.
+type ctxCheckPlugin struct {
+	freed int32
+}
+
+func (p *ctxCheckPlugin) PostWriteResponse(ctx context.Context, req *protocol.Message, res *protocol.Message, err error) error {
+	if ctx.Value(RemoteConnContextKey) == nil || ctx.Value(ctxValueKey) == nil {
+		atomic.AddInt32(&p.freed, 1)
+	}
+	return nil
+}
+
+func TestPooledRequestContext(t *testing.T) {
+	service := &ctxService{}
+	plugin := &ctxCheckPlugin{}
+	s := NewServer(WithContextPool())
+	s.Plugins.Add(plugin)
+	s.RegisterName("Ctx", service, "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 1; j <= 50; j++ {
+				reply := &Reply{}
+				assert.NoError(t, c.Call(context.Background(), "Ctx", "Keep", &Args{A: i*100 + j, B: 2}, reply))
+				assert.Equal(t, (i*100+j)*2, reply.C)
+			}
+		}(i)
+	}
+	wg.Wait()
+	service.wg.Wait()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&service.leaked), "values are leaked into other requests")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&service.lost), "values of detached contexts are lost")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&plugin.freed), "contexts are freed before plugins are called")
+}
+
+// metaKeeper keeps the metadata of requests after it returns.
+type metaKeeper struct {
+	mu    sync.Mutex
+	metas []map[string]string
+}
+
+func (s *metaKeeper) Keep(ctx context.Context, args *Args, reply *Reply) error {
+	s.mu.Lock()
+	s.metas = append(s.metas, ctx.Value(share.ReqMetaDataKey).(map[string]string))
+	s.mu.Unlock()
+	return nil
+}
+
+func TestKeptRequestMetadata(t *testing.T) {
+	service := &metaKeeper{}
+	s := NewServer()
+	s.RegisterName("Meta", service, "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// requests of the connection are decoded into the same message
+	for i := 0; i < 10; i++ {
+		ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"i": strconv.Itoa(i)})
+		assert.NoError(t, c.Call(ctx, "Meta", "Keep", &Args{A: i}, &Reply{}))
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	assert.Len(t, service.metas, 10)
+	for i, meta := range service.metas {
+		assert.Equal(t, strconv.Itoa(i), meta["i"], "metadata kept by handlers are reused")
+	}
+}
+
+type slowArith struct{ handled chan struct{} }
+
+func (t *slowArith) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	time.Sleep(100 * time.Millisecond)
+	reply.C = args.A * args.B
+	t.handled <- struct{}{}
+	return nil
+}
+
+func TestCloseConnWithInflightRequests(t *testing.T) {
+	s := NewServer()
+	sa := &slowArith{handled: make(chan struct{}, 10)}
+	s.RegisterName("Arith", sa, "")
+	startServer(t, s, "tcp")
+
+	// responses of requests in flight are written after the connection is closed
+	conn := dialHeartbeat(t, s.Address().String())
+	for i := 0; i < 5; i++ {
+		req := protocol.NewMessage()
+		req.SetSerializeType(protocol.JSON)
+		req.SetSeq(uint64(i + 1))
+		req.ServicePath = "Arith"
+		req.ServiceMethod = "Mul"
+		req.Payload = []byte(`{"A":10,"B":20}`)
+		conn.Write(req.Encode())
+	}
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	for i := 0; i < 5; i++ {
+		select {
+		case <-sa.handled:
+		case <-time.After(time.Second):
+			t.Fatal("requests are not handled")
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&s.handlerMsgNum))
+}
@@ -6,13 +6,16 @@ import (
 	"fmt"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"unicode"
 	"unicode/utf8"
 
 	rerrors "github.com/smallnest/rpcx/errors"
 	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/share"
 )
 
 // Precompute the reflect type for error. Can't use error directly
@@ -43,6 +46,26 @@ type service struct {
 	typ      reflect.Type             // type of the receiver
 	method   map[string]*methodType   // registered methods
 	function map[string]*functionType // registered functions
+	builtin  bool                     // registered by rpcx itself, not by users
+	raw      RawHandler               // handles all methods without decoding by RegisterRawHandler
+
+	// metadata of the registrations by their IDs, the empty ID for the registration without one, see share.MetaRegistration
+	registrations map[string]string
+
+	inflight      sync.WaitGroup // in-flight calls, added while serviceMapMu is held
+	inflightCount int64          // number of in-flight calls for stats
+}
+
+// begin counts an in-flight call. serviceMapMu must be held.
+func (s *service) begin() {
+	s.inflight.Add(1)
+	atomic.AddInt64(&s.inflightCount, 1)
+}
+
+// end finishes an in-flight call.
+func (s *service) end() {
+	atomic.AddInt64(&s.inflightCount, -1)
+	s.inflight.Done()
 }
 
 func isExported(name string) bool {
@@ -70,7 +93,7 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 // The client accesses each method using a string of the form "Type.Method",
 // where Type is the receiver's concrete type.
 func (s *Server) Register(rcvr interface{}, metadata string) error {
-	sname, err := s.register(rcvr, "", false)
+	sname, err := s.register(rcvr, "", false, metadata)
 	if err != nil {
 		return err
 	}
@@ -79,8 +102,13 @@ func (s *Server) Register(rcvr interface{}, metadata string) error {
 
 // RegisterName is like Register but uses the provided name for the type
 // instead of the receiver's concrete type.
+//
+// A service can be registered more than once with different metadata, such as in multiple groups,
+// if share.MetaRegistration of the metadata is the ID of the registration. Registrations are stored in registries
+// under their own keys and updated and unregistered by their IDs, while calls of all of them are served
+// by the same receiver, which the registrations of a service must share.
 func (s *Server) RegisterName(name string, rcvr interface{}, metadata string) error {
-	_, err := s.register(rcvr, name, true)
+	_, err := s.register(rcvr, name, true, metadata)
 	if err != nil {
 		return err
 	}
@@ -90,13 +118,42 @@ func (s *Server) RegisterName(name string, rcvr interface{}, metadata string) er
 	return s.Plugins.DoRegister(name, rcvr, metadata)
 }
 
+// RegisterNameWithMeta is like RegisterName but takes structured metadata,
+// which is encoded by share.EncodeMetadata. Use well-known keys such as share.MetaWeight if applicable.
+func (s *Server) RegisterNameWithMeta(name string, rcvr interface{}, meta map[string]string) error {
+	return s.RegisterName(name, rcvr, share.EncodeMetadata(meta))
+}
+
+// UpdateServiceMetadata replaces metadata of the registered service
+// and updates it in registries that implement UpdateMetadataPlugin without unregistering the service.
+// The registration to update is the one of share.MetaRegistration of meta.
+func (s *Server) UpdateServiceMetadata(name string, meta map[string]string) error {
+	metadata := share.EncodeMetadata(meta)
+
+	s.serviceMapMu.Lock()
+	service := s.serviceMap[name]
+	if service == nil || service.builtin {
+		s.serviceMapMu.Unlock()
+		return errors.New("rpcx: can't find service " + name)
+	}
+	id := meta[share.MetaRegistration]
+	if _, ok := service.registrations[id]; !ok {
+		s.serviceMapMu.Unlock()
+		return errors.New("rpcx: can't find registration " + id + " of service " + name)
+	}
+	service.registrations[id] = metadata
+	s.serviceMapMu.Unlock()
+
+	return s.Plugins.DoUpdateMetadata(name, metadata)
+}
+
 // RegisterFunction publishes a function that satisfy the following conditions:
 //	- three arguments, the first is of context.Context, both of exported type for three arguments
 //	- the third argument is a pointer
 //	- one return value, of type error
 // The client accesses function using a string of the form "servicePath.Method".
 func (s *Server) RegisterFunction(servicePath string, fn interface{}, metadata string) error {
-	fname, err := s.registerFunction(servicePath, fn, "", false)
+	fname, err := s.registerFunction(servicePath, fn, "", false, metadata)
 	if err != nil {
 		return err
 	}
@@ -107,7 +164,7 @@ func (s *Server) RegisterFunction(servicePath string, fn interface{}, metadata s
 // RegisterFunctionName is like RegisterFunction but uses the provided name for the function
 // instead of the function's concrete type.
 func (s *Server) RegisterFunctionName(servicePath string, name string, fn interface{}, metadata string) error {
-	_, err := s.registerFunction(servicePath, fn, name, true)
+	_, err := s.registerFunction(servicePath, fn, name, true, metadata)
 	if err != nil {
 		return err
 	}
@@ -115,7 +172,7 @@ func (s *Server) RegisterFunctionName(servicePath string, name string, fn interf
 	return s.Plugins.DoRegisterFunction(servicePath, name, fn, metadata)
 }
 
-func (s *Server) register(rcvr interface{}, name string, useName bool) (string, error) {
+func (s *Server) register(rcvr interface{}, name string, useName bool, metadata string) (string, error) {
 	s.serviceMapMu.Lock()
 	defer s.serviceMapMu.Unlock()
 
@@ -137,6 +194,20 @@ func (s *Server) register(rcvr interface{}, name string, useName bool) (string,
 		return sname, errors.New(errorStr)
 	}
 	service.name = sname
+	id := share.RegistrationOf(metadata)
+	service.registrations = map[string]string{id: metadata}
+	if existing := s.serviceMap[sname]; existing != nil && len(existing.registrations) > 0 {
+		if _, ok := existing.registrations[id]; !ok || len(existing.registrations) > 1 {
+			// other registrations of the service are kept, which share the receiver
+			if !existing.sameReceiver(service) {
+				errorStr := "rpcx.Register: service " + sname + " is registered with another receiver"
+				log.Error(errorStr)
+				return sname, errors.New(errorStr)
+			}
+			existing.registrations[id] = metadata
+			return sname, nil
+		}
+	}
 
 	// Install the methods
 	service.method = suitableMethods(service.typ, true)
@@ -158,7 +229,7 @@ func (s *Server) register(rcvr interface{}, name string, useName bool) (string,
 	return sname, nil
 }
 
-func (s *Server) registerFunction(servicePath string, fn interface{}, name string, useName bool) (string, error) {
+func (s *Server) registerFunction(servicePath string, fn interface{}, name string, useName bool, metadata string) (string, error) {
 	s.serviceMapMu.Lock()
 	defer s.serviceMapMu.Unlock()
 
@@ -168,6 +239,10 @@ func (s *Server) registerFunction(servicePath string, fn interface{}, name strin
 		ss.name = servicePath
 		ss.function = make(map[string]*functionType)
 	}
+	if ss.registrations == nil {
+		ss.registrations = make(map[string]string)
+	}
+	ss.registrations[share.RegistrationOf(metadata)] = metadata
 
 	f, ok := fn.(reflect.Value)
 	if !ok {
@@ -310,11 +385,126 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 	return methods
 }
 
-// UnregisterAll unregisters all services.
+// Unregister removes the service from the server and from registries of RegisterPlugins.
+// It can be called at any time. Requests for the service that arrive later get a NotFound error,
+// while in-flight calls are not interrupted.
+func (s *Server) Unregister(serviceName string) error {
+	_, err := s.unregister(serviceName)
+	return err
+}
+
+// UnregisterAndWait is like Unregister but also waits for in-flight calls of the service to finish
+// or ctx to be done.
+func (s *Server) UnregisterAndWait(ctx context.Context, serviceName string) error {
+	svc, err := s.unregister(serviceName)
+	if svc == nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		svc.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// UnregisterRegistration unregisters the registration id of the service from registries, see share.MetaRegistration.
+// The service is still served for its other registrations, which are kept in registries, or unregistered like Unregister
+// if it is the last registration. The empty id is the registration without an ID.
+// Registry plugins that don't implement UnregisterRegistrationPlugin unregister the service and register the other registrations again.
+func (s *Server) UnregisterRegistration(serviceName, id string) error {
+	s.serviceMapMu.Lock()
+	svc := s.serviceMap[serviceName]
+	if svc == nil || svc.builtin {
+		s.serviceMapMu.Unlock()
+		return rerrors.New(rerrors.NotFound, "rpcx: can't find service "+serviceName)
+	}
+	if _, ok := svc.registrations[id]; !ok {
+		s.serviceMapMu.Unlock()
+		return rerrors.New(rerrors.NotFound, "rpcx: can't find registration "+id+" of service "+serviceName)
+	}
+	if len(svc.registrations) == 1 {
+		s.serviceMapMu.Unlock()
+		_, err := s.unregister(serviceName)
+		return err
+	}
+	delete(svc.registrations, id)
+	var rcvr interface{}
+	if svc.rcvr.IsValid() {
+		rcvr = svc.rcvr.Interface()
+	}
+	metadata := svc.metadataList()
+	s.serviceMapMu.Unlock()
+
+	return s.Plugins.DoUnregisterRegistration(serviceName, id, rcvr, metadata)
+}
+
+// metadataList returns metadata of the registrations of s sorted by their IDs. serviceMapMu must be held.
+func (s *service) metadataList() []string {
+	ids := make([]string, 0, len(s.registrations))
+	for id := range s.registrations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	metadata := make([]string, len(ids))
+	for i, id := range ids {
+		metadata[i] = s.registrations[id]
+	}
+	return metadata
+}
+
+// sameReceiver reports whether s and other are registered with the same receiver.
+func (s *service) sameReceiver(other *service) bool {
+	if !s.rcvr.IsValid() || s.typ != other.typ {
+		return false
+	}
+	return !s.typ.Comparable() || s.rcvr.Interface() == other.rcvr.Interface()
+}
+
+// unregister removes the service from the router and then from registries.
+// It returns the removed service or nil if the service is not found.
+func (s *Server) unregister(serviceName string) (*service, error) {
+	s.serviceMapMu.Lock()
+	svc := s.serviceMap[serviceName]
+	if svc == nil || svc.builtin {
+		s.serviceMapMu.Unlock()
+		return nil, rerrors.New(rerrors.NotFound, "rpcx: can't find service "+serviceName)
+	}
+	delete(s.serviceMap, serviceName)
+	s.serviceMapMu.Unlock()
+
+	return svc, s.Plugins.DoUnregister(serviceName)
+}
+
+// hasMethod reports whether the method or function of the service is registered.
+func (s *Server) hasMethod(serviceName, methodName string) bool {
+	s.serviceMapMu.RLock()
+	defer s.serviceMapMu.RUnlock()
+	svc := s.serviceMap[serviceName]
+	return svc != nil && (svc.raw != nil || svc.method[methodName] != nil || svc.function[methodName] != nil)
+}
+
+// UnregisterAll unregisters all services from registries.
+// Services are still served so that in-flight clients are not broken.
 // You can call this method when you want to shutdown/upgrade this node.
 func (s *Server) UnregisterAll() error {
+	s.serviceMapMu.RLock()
+	var names []string
+	for k, svc := range s.serviceMap {
+		if !svc.builtin {
+			names = append(names, k)
+		}
+	}
+	s.serviceMapMu.RUnlock()
+
 	var es []error
-	for k := range s.serviceMap {
+	for _, k := range names {
 		err := s.Plugins.DoUnregister(k)
 		if err != nil {
 			es = append(es, err)
@@ -327,19 +517,8 @@ func (s *Server) UnregisterAll() error {
 	return nil
 }
 
+// call invokes the method. Panics are recovered by the server in handleRequest.
 func (s *service) call(ctx context.Context, mtype *methodType, argv, replyv reflect.Value) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			buf := make([]byte, 4096)
-			n := runtime.Stack(buf, false)
-			buf = buf[:n]
-
-			err = fmt.Errorf("[service internal error]: %v, method: %s, argv: %+v, stack: %s",
-				r, mtype.method.Name, argv.Interface(), buf)
-			log.Error(err)
-		}
-	}()
-
 	function := mtype.method.Func
 	// Invoke the method, providing a new value for the reply.
 	returnValues := function.Call([]reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv})
@@ -352,20 +531,8 @@ func (s *service) call(ctx context.Context, mtype *methodType, argv, replyv refl
 	return nil
 }
 
+// callForFunction invokes the function. Panics are recovered by the server in handleRequest.
 func (s *service) callForFunction(ctx context.Context, ft *functionType, argv, replyv reflect.Value) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			buf := make([]byte, 4096)
-			n := runtime.Stack(buf, false)
-			buf = buf[:n]
-
-			// log.Errorf("failed to invoke service: %v, stacks: %s", r, string(debug.Stack()))
-			err = fmt.Errorf("[service internal error]: %v, function: %s, argv: %+v, stack: %s",
-				r, runtime.FuncForPC(ft.fn.Pointer()), argv.Interface(), buf)
-			log.Error(err)
-		}
-	}()
-
 	// Invoke the function, providing a new value for the reply.
 	returnValues := ft.fn.Call([]reflect.Value{reflect.ValueOf(ctx), argv, replyv})
 	// The return value for the method is an error.
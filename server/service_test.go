@@ -3,8 +3,14 @@ package server
 import (
 	"context"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -32,4 +38,195 @@ func Mul(ctx context.Context, args *Args, reply *Reply) error {
 func Test_isExportedOrBuiltinType(t *testing.T) {
 	typeOfMul := reflect.TypeOf(Mul)
 	assert.Equal(t, true, isExportedOrBuiltinType(typeOfMul))
-}
\ No newline at end of file
+}
+type registryRecorder struct {
+	mu       sync.Mutex
+	services map[string]bool
+}
+
+func (r *registryRecorder) Register(name string, rcvr interface{}, metadata string) error {
+	r.mu.Lock()
+	r.services[name] = true
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *registryRecorder) Unregister(name string) error {
+	r.mu.Lock()
+	delete(r.services, name)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *registryRecorder) registered(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.services[name]
+}
+
+func TestUnregister(t *testing.T) {
+	recorder := &registryRecorder{services: make(map[string]bool)}
+	s := NewServer()
+	s.Plugins.Add(recorder)
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	var stop int32
+	var calls, notFound int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				reply := &Reply{}
+				err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+				switch {
+				case err == nil:
+					if reply.C != 200 {
+						t.Errorf("expect 200 but got %d", reply.C)
+					}
+					atomic.AddInt32(&calls, 1)
+				case strings.Contains(err.Error(), "can't find service"):
+					atomic.AddInt32(&notFound, 1)
+				default:
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := s.RegisterName("Arith", new(Arith), ""); err != nil {
+			t.Fatalf("failed to register: %v", err)
+		}
+		if !recorder.registered("Arith") {
+			t.Fatalf("expect Arith registered in registry")
+		}
+		reply := &Reply{}
+		if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, reply); err != nil {
+			t.Fatalf("failed to call registered service: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		if err := s.Unregister("Arith"); err != nil {
+			t.Fatalf("failed to unregister: %v", err)
+		}
+		if recorder.registered("Arith") {
+			t.Fatalf("expect Arith unregistered from registry")
+		}
+		err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, &Reply{})
+		if err == nil || !strings.Contains(err.Error(), "can't find service") {
+			t.Fatalf("expect service not found but got %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+
+	if atomic.LoadInt32(&calls) == 0 || atomic.LoadInt32(&notFound) == 0 {
+		t.Errorf("expect both successful calls and not found errors, got %d and %d", calls, notFound)
+	}
+	if err := s.Unregister("Arith"); err == nil {
+		t.Errorf("expect error for unregistering an unknown service")
+	}
+}
+
+type blockingService struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingService) Wait(ctx context.Context, args *Args, reply *Reply) error {
+	close(b.started)
+	<-b.release
+	return nil
+}
+
+func TestUnregisterAndWait(t *testing.T) {
+	svc := &blockingService{started: make(chan struct{}), release: make(chan struct{})}
+	s := NewServer()
+	s.RegisterName("Blocking", svc, "")
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = "Blocking"
+	req.ServiceMethod = "Wait"
+	req.Payload = []byte(`{}`)
+	done := make(chan struct{})
+	go func() {
+		s.handleRequest(context.Background(), req)
+		close(done)
+	}()
+	<-svc.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.UnregisterAndWait(ctx, "Blocking"); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded while a call is in flight but got %v", err)
+	}
+
+	close(svc.release)
+	<-done
+	if err := s.UnregisterAndWait(context.Background(), "Blocking"); err == nil {
+		t.Errorf("expect error for unregistering a removed service")
+	}
+}
+
+// metadataRecorder is a RegisterPlugin which doesn't implement UnregisterRegistrationPlugin.
+type metadataRecorder struct {
+	metadata []string
+}
+
+func (r *metadataRecorder) Register(name string, rcvr interface{}, metadata string) error {
+	r.metadata = append(r.metadata, metadata)
+	return nil
+}
+
+func (r *metadataRecorder) Unregister(name string) error {
+	r.metadata = nil
+	return nil
+}
+
+func TestRegisterServiceTwice(t *testing.T) {
+	recorder := &metadataRecorder{}
+	s := NewServer()
+	s.Plugins.Add(recorder)
+
+	arith := new(Arith)
+	assert.NoError(t, s.RegisterName("Arith", arith, "group=blue"))
+	assert.NoError(t, s.RegisterName("Arith", arith, "group=gold&registration=gold"))
+	assert.Error(t, s.RegisterName("Arith", new(Arith), "group=green&registration=green"))
+	assert.Equal(t, []string{"group=blue", "group=gold&registration=gold"}, recorder.metadata)
+
+	// both registrations are served by the receiver
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Mul"
+	req.Payload = []byte(`{"A":2,"B":3}`)
+	res, err := s.handleRequest(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"C":6}`, string(res.Payload))
+
+	assert.Error(t, s.UpdateServiceMetadata("Arith", map[string]string{"registration": "green"}))
+	assert.Error(t, s.UnregisterRegistration("Arith", "green"))
+
+	// plugins without UnregisterRegistration register the other registrations again
+	assert.NoError(t, s.UnregisterRegistration("Arith", "gold"))
+	assert.Equal(t, []string{"group=blue"}, recorder.metadata)
+	assert.True(t, s.hasMethod("Arith", "Mul"))
+
+	assert.NoError(t, s.UnregisterRegistration("Arith", ""))
+	assert.Empty(t, recorder.metadata)
+	assert.False(t, s.hasMethod("Arith", "Mul"))
+}
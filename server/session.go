@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/soheilhy/cmux"
+)
+
+// WithSessionEncryption requires clients to encrypt connections by session keys exchanged on connect,
+// see protocol.SessionConfig. Clients authenticate the server by the public key of config.PrivateKey,
+// or by TLS without it. Connections of clients which don't exchange session keys are closed.
+func WithSessionEncryption(config *protocol.SessionConfig) OptionFn {
+	return func(s *Server) {
+		s.session = config
+	}
+}
+
+// sessionConn returns the session connection on conn if session encryption is enabled, or conn otherwise.
+func (s *Server) sessionConn(conn net.Conn) net.Conn {
+	if s.session == nil {
+		return conn
+	}
+	if mc, ok := conn.(*cmux.MuxConn); ok { // so that the handshake finds TLS connections of servers with the gateway
+		conn = muxConn{mc}
+	}
+	return protocol.SessionServer(conn, s.session)
+}
+
+// handshakeSession exchanges session keys with the client of conn if it is a session connection.
+func (s *Server) handshakeSession(conn net.Conn) bool {
+	sc, ok := conn.(*protocol.SessionConn)
+	if !ok {
+		return true
+	}
+	if err := sc.Handshake(); err != nil {
+		log.Warnf("rpcx: %v from %s", err, conn.RemoteAddr().String())
+		return false
+	}
+	return true
+}
+
+// muxConn is a connection sniffed by the gateway, whose underlying connection is found by NetConn.
+type muxConn struct {
+	*cmux.MuxConn
+}
+
+func (c muxConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// netConn returns the connection under conn encrypted by session keys, sniffed by the gateway or pending.
+func netConn(conn net.Conn) net.Conn {
+	if sc, ok := conn.(*protocol.SessionConn); ok {
+		conn = sc.NetConn()
+	}
+	switch c := conn.(type) {
+	case muxConn:
+		conn = c.Conn
+	case *cmux.MuxConn:
+		conn = c.Conn
+	}
+	if pc, ok := conn.(*pendingConn); ok {
+		return pc.Conn
+	}
+	return conn
+}
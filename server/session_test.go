@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionEncryption(t *testing.T) {
+	private, public, err := protocol.GenerateSessionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := startChunkServer(t, WithSessionEncryption(&protocol.SessionConfig{PrivateKey: private}),
+		WithChunking(1024, 1<<20, time.Second))
+	defer s.Close()
+
+	opt := client.DefaultOption
+	opt.NegotiateTimeout = client.DefaultNegotiateTimeout
+	opt.ChunkThreshold = 1024
+	opt.SessionEncryption = &protocol.SessionConfig{PublicKey: public, RekeyMessages: 2}
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// extensions are negotiated and used in sessions
+	_, caps, ok := c.Negotiation()
+	assert.True(t, ok)
+	assert.True(t, caps.Has(protocol.CapChunk))
+	for i := 0; i < 3; i++ {
+		reply := &Reply{}
+		if assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: i}, reply)) {
+			assert.Equal(t, 10*i, reply.C)
+		}
+	}
+	data := blob(64 * 1024)
+	reply := &Blob{}
+	if assert.NoError(t, c.Call(context.Background(), "Blob", "Echo", &Blob{Data: data}, reply)) {
+		assert.Equal(t, data, reply.Data)
+	}
+	ch := make(chan *protocol.Message, 1)
+	c.RegisterServerMessageChan(ch)
+	conns := s.ActiveClientConn()
+	if assert.Len(t, conns, 1) {
+		assert.NoError(t, s.SendMessage(conns[0], "Push", "Notify", nil, []byte("pushed")))
+		select {
+		case m := <-ch:
+			assert.Equal(t, "pushed", string(m.Payload))
+		case <-time.After(time.Second):
+			t.Error("the pushed message is not received")
+		}
+	}
+
+	// servers are authenticated by their static keys
+	_, other, _ := protocol.GenerateSessionKey()
+	opt.SessionEncryption = &protocol.SessionConfig{PublicKey: other}
+	err = client.NewClient(opt).Connect("tcp", s.Address().String())
+	var he *protocol.HandshakeError
+	assert.True(t, errors.As(err, &he), "unexpected error %v", err)
+
+	// clients without session encryption are closed
+	opt.SessionEncryption = nil
+	plain := client.NewClient(opt)
+	if err := plain.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	assert.Error(t, plain.Call(context.Background(), "Arith", "Mul", &Args{A: 1, B: 2}, &Reply{}))
+
+	// and clients with session encryption fail to connect servers without it
+	old := startChunkServer(t)
+	defer old.Close()
+	opt.SessionEncryption = &protocol.SessionConfig{PublicKey: public}
+	err = client.NewClient(opt).Connect("tcp", old.Address().String())
+	if assert.True(t, errors.As(err, &he), "unexpected error %v", err) {
+		assert.Contains(t, err.Error(), "the server doesn't support session encryption")
+	}
+}
+
+func TestSessionEncryptionTLS(t *testing.T) {
+	// servers are authenticated by TLS without static keys, and the identities of clients are still found
+	svc := &identityService{identity: make(chan *share.Identity, 1)}
+	s := NewServer(WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t, pkix.Name{CommonName: "server"})},
+		ClientAuth:   tls.RequireAnyClientCert,
+	}), WithSessionEncryption(&protocol.SessionConfig{}))
+	s.RegisterName("Identity", svc, "")
+	startServer(t, s, "tcp")
+
+	opt := client.DefaultOption
+	opt.TLSConfig = &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{selfSignedCert(t, pkix.Name{CommonName: "bob"})},
+	}
+	opt.SessionEncryption = &protocol.SessionConfig{}
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	assert.NoError(t, c.Call(context.Background(), "Identity", "Who", &Args{}, &Reply{}))
+	if got := <-svc.identity; assert.NotNil(t, got) {
+		assert.Equal(t, "bob", got.Name)
+	}
+
+	// but static keys are required without TLS
+	private, public, _ := protocol.GenerateSessionKey()
+	plain := NewServer(WithSessionEncryption(&protocol.SessionConfig{PrivateKey: private}))
+	plain.RegisterName("Identity", svc, "")
+	go plain.Serve("tcp", "127.0.0.1:0")
+	defer plain.Close()
+	time.Sleep(100 * time.Millisecond)
+	opt.TLSConfig = nil
+	err := client.NewClient(opt).Connect("tcp", plain.Address().String())
+	var he *protocol.HandshakeError
+	if assert.True(t, errors.As(err, &he), "unexpected error %v", err) {
+		assert.Contains(t, err.Error(), "neither the static public key nor TLS")
+	}
+	opt.SessionEncryption.PublicKey = public
+	c = client.NewClient(opt)
+	if assert.NoError(t, c.Connect("tcp", plain.Address().String())) {
+		assert.NoError(t, c.Call(context.Background(), "Identity", "Who", &Args{}, &Reply{}))
+		<-svc.identity
+		c.Close()
+	}
+}
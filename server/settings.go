@@ -0,0 +1,314 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// RuntimeSettings are operational settings of the server which can be changed at runtime by ApplySettings,
+// without restarting it. Each of them is set at construction by its option:
+//
+//	ReadTimeout, WriteTimeout    WithReadTimeout, WithWriteTimeout
+//	IdleTimeout                  WithIdleTimeout
+//	MaxConnections(PerIP)        WithMaxConnections, WithMaxConnectionsPerIP
+//	FirstRequestTimeout          WithFirstRequestTimeout
+//	TLSHandshakeTimeout          WithTLSHandshakeTimeout
+//	MaxPendingConnections        WithMaxPendingConnections
+//	MaxInflightPerConnection     WithMaxInflightPerConnection
+//	InflightQueuePerConnection   WithInflightQueuePerConnection
+//	WorkerPoolSize               WithWorkerPool
+//	MaxMessageSize               WithMaxMessageSize
+//	SlowRequestThreshold         WithSlowRequestThreshold
+//	SlowRequestSampleRate        WithSlowRequestSampleRate
+//
+// Other options are fixed at construction, since listeners, connections or goroutines are set up by them.
+// They include listener addresses, the TLS config and WithGetCertificate (WithCertReload reloads certificates by itself),
+// whether the worker pool is used and its queue depth, WithPriorityScheduling, WithClientIdleTimeout, sessions,
+// checksums, chunking, compression, websocket and JSON-RPC options, AsyncWrite and plugins.
+//
+// In JSON, durations are strings such as "5s" or numbers of nanoseconds.
+type RuntimeSettings struct {
+	ReadTimeout                time.Duration
+	WriteTimeout               time.Duration
+	IdleTimeout                time.Duration
+	MaxConnections             int
+	MaxConnectionsPerIP        int
+	FirstRequestTimeout        time.Duration
+	TLSHandshakeTimeout        time.Duration
+	MaxPendingConnections      int
+	MaxInflightPerConnection   int
+	InflightQueuePerConnection int
+	// WorkerPoolSize is zero if the server does not use a worker pool, and must be positive if it does.
+	WorkerPoolSize        int
+	MaxMessageSize        int
+	SlowRequestThreshold  time.Duration
+	SlowRequestSampleRate float64
+}
+
+// runtime returns the current settings, which must not be changed once the server is constructed.
+// They are read without locks, so requests see either the old or the new settings of ApplySettings.
+func (s *Server) runtime() *RuntimeSettings {
+	return s.settings.Load().(*RuntimeSettings)
+}
+
+// Settings returns the current runtime settings.
+func (s *Server) Settings() RuntimeSettings {
+	return *s.runtime()
+}
+
+// ApplySettings validates settings and replaces the runtime settings of the server by them atomically,
+// so the next request is handled by them. If they are invalid, nothing is changed. Changes are logged.
+// Fields are replaced as a whole, so start from Settings to change some of them.
+//
+// Existing connections are not closed by lower connection limits, requests in flight are not rejected by lower
+// in-flight limits, and timeouts apply to the next read or write of connections. FirstRequestTimeout,
+// TLSHandshakeTimeout and MaxPendingConnections apply to connections accepted after they are changed.
+func (s *Server) ApplySettings(settings RuntimeSettings) error {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	return s.applySettings(settings)
+}
+
+// UpdateSettings applies the settings changed by fn from the current ones, like ApplySettings.
+func (s *Server) UpdateSettings(fn func(settings *RuntimeSettings)) error {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	settings := *s.runtime()
+	fn(&settings)
+	return s.applySettings(settings)
+}
+
+func (s *Server) applySettings(settings RuntimeSettings) error {
+	if err := s.validateSettings(&settings); err != nil {
+		return err
+	}
+	old := s.runtime()
+	changes := diffSettings(old, &settings)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	s.settings.Store(&settings)
+	if settings.WorkerPoolSize != old.WorkerPoolSize {
+		s.workerPool.resize(settings.WorkerPoolSize)
+	}
+	logger.Info(context.Background(), "rpcx: runtime settings applied", "changes", strings.Join(changes, ", "))
+	return nil
+}
+
+func (s *Server) validateSettings(settings *RuntimeSettings) error {
+	v := reflect.ValueOf(settings).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if (f.Kind() == reflect.Int || f.Kind() == reflect.Int64) && f.Int() < 0 ||
+			f.Kind() == reflect.Float64 && f.Float() < 0 {
+			return fmt.Errorf("rpcx: %s must not be negative", v.Type().Field(i).Name)
+		}
+	}
+	if settings.SlowRequestSampleRate > 1 {
+		return errors.New("rpcx: SlowRequestSampleRate must not be greater than 1")
+	}
+	if s.workerPool == nil && settings.WorkerPoolSize != 0 {
+		return errors.New("rpcx: WorkerPoolSize can't be set since the worker pool is not enabled")
+	}
+	if s.workerPool != nil && settings.WorkerPoolSize == 0 {
+		return errors.New("rpcx: WorkerPoolSize must be positive")
+	}
+	return nil
+}
+
+// diffSettings describes fields changed from old to settings.
+func diffSettings(old, settings *RuntimeSettings) []string {
+	vo, vn := reflect.ValueOf(old).Elem(), reflect.ValueOf(settings).Elem()
+	var changes []string
+	for i := 0; i < vo.NumField(); i++ {
+		a, b := vo.Field(i).Interface(), vn.Field(i).Interface()
+		if a != b {
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", vo.Type().Field(i).Name, a, b))
+		}
+	}
+	return changes
+}
+
+type settingsJSON struct {
+	ReadTimeout                jsonDuration `json:"read_timeout"`
+	WriteTimeout               jsonDuration `json:"write_timeout"`
+	IdleTimeout                jsonDuration `json:"idle_timeout"`
+	MaxConnections             int          `json:"max_connections"`
+	MaxConnectionsPerIP        int          `json:"max_connections_per_ip"`
+	FirstRequestTimeout        jsonDuration `json:"first_request_timeout"`
+	TLSHandshakeTimeout        jsonDuration `json:"tls_handshake_timeout"`
+	MaxPendingConnections      int          `json:"max_pending_connections"`
+	MaxInflightPerConnection   int          `json:"max_inflight_per_connection"`
+	InflightQueuePerConnection int          `json:"inflight_queue_per_connection"`
+	WorkerPoolSize             int          `json:"worker_pool_size"`
+	MaxMessageSize             int          `json:"max_message_size"`
+	SlowRequestThreshold       jsonDuration `json:"slow_request_threshold"`
+	SlowRequestSampleRate      float64      `json:"slow_request_sample_rate"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (settings RuntimeSettings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(settingsJSON{
+		ReadTimeout:                jsonDuration(settings.ReadTimeout),
+		WriteTimeout:               jsonDuration(settings.WriteTimeout),
+		IdleTimeout:                jsonDuration(settings.IdleTimeout),
+		MaxConnections:             settings.MaxConnections,
+		MaxConnectionsPerIP:        settings.MaxConnectionsPerIP,
+		FirstRequestTimeout:        jsonDuration(settings.FirstRequestTimeout),
+		TLSHandshakeTimeout:        jsonDuration(settings.TLSHandshakeTimeout),
+		MaxPendingConnections:      settings.MaxPendingConnections,
+		MaxInflightPerConnection:   settings.MaxInflightPerConnection,
+		InflightQueuePerConnection: settings.InflightQueuePerConnection,
+		WorkerPoolSize:             settings.WorkerPoolSize,
+		MaxMessageSize:             settings.MaxMessageSize,
+		SlowRequestThreshold:       jsonDuration(settings.SlowRequestThreshold),
+		SlowRequestSampleRate:      settings.SlowRequestSampleRate,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Fields absent in data are not changed, so data can be decoded
+// into the current settings to change some of them, and unknown fields are errors.
+func (settings *RuntimeSettings) UnmarshalJSON(data []byte) error {
+	var v settingsJSON
+	b, _ := settings.MarshalJSON()
+	json.Unmarshal(b, &v)
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&v); err != nil {
+		return err
+	}
+	*settings = RuntimeSettings{
+		ReadTimeout:                time.Duration(v.ReadTimeout),
+		WriteTimeout:               time.Duration(v.WriteTimeout),
+		IdleTimeout:                time.Duration(v.IdleTimeout),
+		MaxConnections:             v.MaxConnections,
+		MaxConnectionsPerIP:        v.MaxConnectionsPerIP,
+		FirstRequestTimeout:        time.Duration(v.FirstRequestTimeout),
+		TLSHandshakeTimeout:        time.Duration(v.TLSHandshakeTimeout),
+		MaxPendingConnections:      v.MaxPendingConnections,
+		MaxInflightPerConnection:   v.MaxInflightPerConnection,
+		InflightQueuePerConnection: v.InflightQueuePerConnection,
+		WorkerPoolSize:             v.WorkerPoolSize,
+		MaxMessageSize:             v.MaxMessageSize,
+		SlowRequestThreshold:       time.Duration(v.SlowRequestThreshold),
+		SlowRequestSampleRate:      v.SlowRequestSampleRate,
+	}
+	return nil
+}
+
+// jsonDuration is a duration in JSON as a string such as "5s", or a number of nanoseconds.
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("rpcx: invalid duration %s", data)
+		}
+		*d = jsonDuration(n)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(v)
+	return nil
+}
+
+// applySettingsJSON decodes data into the current settings and applies them.
+func (s *Server) applySettingsJSON(data []byte) error {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	settings := *s.runtime()
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	return s.applySettings(settings)
+}
+
+// WatchSettingsFile applies the settings in the JSON file of path, which is checked every checkInterval
+// and applied again when it is modified, until the server is closed. Fields absent in the file keep their values.
+// The error of the first load is returned, and later errors are logged with the settings kept unchanged.
+func (s *Server) WatchSettingsFile(path string, checkInterval time.Duration) error {
+	var modTime time.Time
+	if fi, err := os.Stat(path); err == nil {
+		modTime = fi.ModTime()
+	}
+	err := s.applySettingsFile(path)
+	if checkInterval <= 0 {
+		checkInterval = time.Minute
+	}
+	go s.watchSettingsFile(path, modTime, checkInterval)
+	return err
+}
+
+func (s *Server) applySettingsFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := s.applySettingsJSON(data); err != nil {
+		return fmt.Errorf("rpcx: invalid settings in %s: %w", path, err)
+	}
+	return nil
+}
+
+func (s *Server) watchSettingsFile(path string, modTime time.Time, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.doneChan:
+			return
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil || fi.ModTime().Equal(modTime) {
+			continue
+		}
+		// files that fail to apply are not retried until they are modified again
+		modTime = fi.ModTime()
+		if err := s.applySettingsFile(path); err != nil {
+			logger.Error(context.Background(), "rpcx: failed to apply settings, keep using the old ones", "file", path, "error", err)
+		}
+	}
+}
+
+// handleAdminSettings returns the runtime settings, and PATCH changes the fields in the JSON body.
+func (s *Server) handleAdminSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var body bytes.Buffer
+		if _, err := body.ReadFrom(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.applySettingsJSON(body.Bytes()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeAdminJSON(w, s.Settings())
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySettings(t *testing.T) {
+	gate := &gateService{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s := NewServer(WithMaxInflightPerConnection(1), WithReadTimeout(time.Minute))
+	s.RegisterName("Gate", gate, "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	call1 := c.Go(context.Background(), "Gate", "Wait", &Args{A: 1}, &Reply{}, nil)
+	<-gate.started
+	err := c.Call(context.Background(), "Gate", "Wait", &Args{A: 2}, &Reply{})
+	assert.True(t, errors.Is(err, rerrors.ErrUnavailable), "unexpected error: %v", err)
+
+	// the very next request is admitted by the new limit
+	settings := s.Settings()
+	assert.Equal(t, time.Minute, settings.ReadTimeout)
+	settings.MaxInflightPerConnection = 2
+	assert.NoError(t, s.ApplySettings(settings))
+	call2 := c.Go(context.Background(), "Gate", "Wait", &Args{A: 2}, &Reply{}, nil)
+	select {
+	case <-gate.started:
+	case <-time.After(time.Second):
+		t.Fatal("request is not admitted by the new limit")
+	}
+
+	assert.NoError(t, s.UpdateSettings(func(settings *RuntimeSettings) { settings.MaxInflightPerConnection = 1 }))
+	err = c.Call(context.Background(), "Gate", "Wait", &Args{A: 3}, &Reply{})
+	assert.True(t, errors.Is(err, rerrors.ErrUnavailable), "unexpected error: %v", err)
+
+	// invalid settings change nothing
+	settings = s.Settings()
+	settings.MaxConnections = 10
+	settings.WriteTimeout = -time.Second
+	assert.Error(t, s.ApplySettings(settings))
+	settings.WriteTimeout = 0
+	settings.WorkerPoolSize = 4
+	assert.Error(t, s.ApplySettings(settings))
+	assert.Equal(t, 0, s.Settings().MaxConnections)
+
+	close(gate.release)
+	for _, call := range []*client.Call{call1, call2} {
+		<-call.Done
+		assert.NoError(t, call.Error)
+	}
+}
+
+func TestApplySettingsQueued(t *testing.T) {
+	gate := &gateService{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s := NewServer(WithMaxInflightPerConnection(1), WithInflightQueuePerConnection(2))
+	s.RegisterName("Gate", gate, "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	calls := []*client.Call{c.Go(context.Background(), "Gate", "Wait", &Args{A: 1}, &Reply{}, nil)}
+	<-gate.started
+	for i := 2; i <= 3; i++ {
+		calls = append(calls, c.Go(context.Background(), "Gate", "Wait", &Args{A: i}, &Reply{}, nil))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// requests queued by the old limit are dispatched when the in-flight request completes
+	assert.NoError(t, s.UpdateSettings(func(settings *RuntimeSettings) { settings.MaxInflightPerConnection = 0 }))
+	gate.release <- struct{}{}
+	<-gate.started
+	<-gate.started
+	close(gate.release)
+	for _, call := range calls {
+		<-call.Done
+		assert.NoError(t, call.Error)
+	}
+}
+
+func TestWorkerPoolSettings(t *testing.T) {
+	s := NewServer(WithWorkerPool(2, 10))
+	defer s.Close()
+	assert.Equal(t, 2, s.Settings().WorkerPoolSize)
+
+	s.SetWorkerPoolSize(4)
+	assert.Equal(t, 4, s.Settings().WorkerPoolSize)
+	size, _, _ := s.workerPool.stats()
+	assert.Equal(t, 4, size)
+
+	assert.Error(t, s.UpdateSettings(func(settings *RuntimeSettings) { settings.WorkerPoolSize = 0 }))
+	assert.Equal(t, 4, s.Settings().WorkerPoolSize)
+}
+
+func TestAdminSettings(t *testing.T) {
+	s := NewServer(WithSlowRequestThreshold(time.Second, func(SlowRequest) {}))
+	defer s.Close()
+	ts := httptest.NewServer(s.AdminHandler())
+	defer ts.Close()
+
+	patch := func(body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPatch, ts.URL+"/settings", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := patch(`{"read_timeout": "5s", "max_inflight_per_connection": 3, "slow_request_sample_rate": 0.5}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	settings := s.Settings()
+	assert.Equal(t, 5*time.Second, settings.ReadTimeout)
+	assert.Equal(t, 3, settings.MaxInflightPerConnection)
+	assert.Equal(t, 0.5, settings.SlowRequestSampleRate)
+	assert.Equal(t, time.Second, settings.SlowRequestThreshold)
+
+	assert.Equal(t, http.StatusBadRequest, patch(`{"max_connection": 3}`).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, patch(`{"slow_request_sample_rate": 2}`).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, patch(`{"idle_timeout": "forever"}`).StatusCode)
+	assert.Equal(t, settings, s.Settings())
+
+	resp, err := http.Get(ts.URL + "/settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got RuntimeSettings
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	assert.Equal(t, settings, got)
+}
+
+func TestWatchSettingsFile(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	path := filepath.Join(t.TempDir(), "settings.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"max_connections": 10}`), 0o644))
+	assert.NoError(t, s.WatchSettingsFile(path, 10*time.Millisecond))
+	assert.Equal(t, 10, s.Settings().MaxConnections)
+
+	write := func(data string) {
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+		modTime := time.Now().Add(time.Second)
+		os.Chtimes(path, modTime, modTime)
+	}
+	write(`{"max_connections": 20, "write_timeout": 1000000}`)
+	deadline := time.Now().Add(time.Second)
+	for s.Settings().MaxConnections != 20 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, 20, s.Settings().MaxConnections)
+	assert.Equal(t, time.Millisecond, s.Settings().WriteTimeout)
+
+	// invalid files are not applied
+	write(`{"max_connections": -1}`)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 20, s.Settings().MaxConnections)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/log"
+)
+
+// ShutdownPhase is the phase of Shutdown in which a hook runs.
+type ShutdownPhase int
+
+const (
+	// ShutdownPreDrain hooks run before listeners are closed, while requests are still served.
+	ShutdownPreDrain ShutdownPhase = iota
+	// ShutdownPostDrain hooks run after in-flight requests finish or the shutdown context is done,
+	// before connections are closed.
+	ShutdownPostDrain
+	// ShutdownPostClose hooks run after all connections are closed.
+	ShutdownPostClose
+)
+
+// ShutdownHookRegistry is the name of the built-in pre-drain hook with priority 0
+// which unregisters all services from registries.
+const ShutdownHookRegistry = "registry"
+
+// ShutdownHook is a function called by Shutdown.
+type ShutdownHook struct {
+	Name  string
+	Phase ShutdownPhase
+	// Priority orders hooks of the same phase. Hooks with lower priorities run first
+	// and hooks with the same priority run in the order they were added.
+	Priority int
+	// Parallel means the hook can run concurrently with adjacent parallel hooks of the same phase and priority.
+	Parallel bool
+	// Timeout limits the hook besides the deadline of Shutdown. Zero means no extra limit.
+	Timeout time.Duration
+	// Fn is called with a context derived from the context of Shutdown.
+	// Returned errors and panics are collected and returned by Shutdown.
+	Fn func(ctx context.Context, s *Server) error
+}
+
+// AddShutdownHook adds a hook called by Shutdown.
+func (s *Server) AddShutdownHook(hook ShutdownHook) {
+	s.mu.Lock()
+	s.shutdownHooks = append(s.shutdownHooks, hook)
+	s.mu.Unlock()
+}
+
+// runShutdownHooks runs hooks of phase and returns their errors.
+func (s *Server) runShutdownHooks(ctx context.Context, phase ShutdownPhase) []error {
+	s.mu.RLock()
+	var hooks []ShutdownHook
+	for _, h := range s.shutdownHooks {
+		if h.Phase == phase {
+			hooks = append(hooks, h)
+		}
+	}
+	s.mu.RUnlock()
+	sort.SliceStable(hooks, func(i, j int) bool { return hooks[i].Priority < hooks[j].Priority })
+
+	var errs []error
+	for i := 0; i < len(hooks); {
+		// a group is one hook or adjacent parallel hooks with the same priority
+		j := i + 1
+		if hooks[i].Parallel {
+			for j < len(hooks) && hooks[j].Parallel && hooks[j].Priority == hooks[i].Priority {
+				j++
+			}
+		}
+
+		groupErrs := make([]error, j-i)
+		var wg sync.WaitGroup
+		for k := i; k < j; k++ {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				groupErrs[k-i] = s.runShutdownHook(ctx, hooks[k])
+			}(k)
+		}
+		wg.Wait()
+
+		for _, err := range groupErrs {
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		i = j
+	}
+	return errs
+}
+
+func (s *Server) runShutdownHook(ctx context.Context, hook ShutdownHook) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("rpcx: shutdown hook %s panicked: %v", hook.Name, r)
+		}
+		if err != nil {
+			log.Warn(err)
+		}
+	}()
+
+	if hook.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hook.Timeout)
+		defer cancel()
+	}
+	if err = hook.Fn(ctx, s); err != nil {
+		err = fmt.Errorf("rpcx: shutdown hook %s: %w", hook.Name, err)
+	}
+	return err
+}
+
+// registryShutdownHook unregisters services so that clients stop discovering the server before it drains.
+func registryShutdownHook() ShutdownHook {
+	return ShutdownHook{
+		Name:  ShutdownHookRegistry,
+		Phase: ShutdownPreDrain,
+		Fn: func(ctx context.Context, s *Server) error {
+			return s.UnregisterAll()
+		},
+	}
+}
+
+// shutdownError returns the error of Shutdown from hook errors and the error of waiting for requests.
+func shutdownError(errs []error, err error) error {
+	if len(errs) == 0 {
+		return err
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return rerrors.NewMultiError(errs)
+}
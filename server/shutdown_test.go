@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type orderRegistry struct {
+	registryRecorder
+	record func(string)
+}
+
+func (r *orderRegistry) Unregister(name string) error {
+	r.record("registry")
+	return r.registryRecorder.Unregister(name)
+}
+
+func TestShutdownHooks(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) {
+		mu.Lock()
+		order = append(order, name)
+		mu.Unlock()
+	}
+	hook := func(name string, phase ShutdownPhase, priority int) ShutdownHook {
+		return ShutdownHook{Name: name, Phase: phase, Priority: priority, Fn: func(ctx context.Context, s *Server) error {
+			record(name)
+			return nil
+		}}
+	}
+
+	s := NewServer()
+	s.Plugins.Add(&orderRegistry{registryRecorder: registryRecorder{services: make(map[string]bool)}, record: record})
+	s.RegisterName("Arith", new(Arith), "")
+
+	s.AddShutdownHook(hook("post-close", ShutdownPostClose, 0))
+	s.AddShutdownHook(hook("post-drain", ShutdownPostDrain, 0))
+	s.AddShutdownHook(hook("after-registry", ShutdownPreDrain, 1))
+	s.AddShutdownHook(hook("before-registry", ShutdownPreDrain, -1))
+	s.RegisterOnShutdown(func(s *Server) { record("on-shutdown") })
+
+	// parallel hooks of the same priority run concurrently
+	var started sync.WaitGroup
+	started.Add(2)
+	for _, name := range []string{"parallel-1", "parallel-2"} {
+		name := name
+		s.AddShutdownHook(ShutdownHook{Name: name, Phase: ShutdownPostDrain, Priority: 1, Parallel: true,
+			Fn: func(ctx context.Context, s *Server) error {
+				started.Done()
+				started.Wait() // deadlock if hooks run sequentially
+				return nil
+			}})
+	}
+
+	s.AddShutdownHook(ShutdownHook{Name: "panic", Phase: ShutdownPostDrain, Priority: 2, Fn: func(ctx context.Context, s *Server) error {
+		panic("boom")
+	}})
+	s.AddShutdownHook(ShutdownHook{Name: "fail", Phase: ShutdownPostClose, Priority: 1, Fn: func(ctx context.Context, s *Server) error {
+		return errors.New("flush failed")
+	}})
+	s.AddShutdownHook(ShutdownHook{Name: "timeout", Phase: ShutdownPostClose, Priority: 2, Timeout: 10 * time.Millisecond,
+		Fn: func(ctx context.Context, s *Server) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}})
+
+	startServer(t, s, "tcp")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.Shutdown(ctx)
+
+	assert.Equal(t, []string{"before-registry", "registry", "on-shutdown", "after-registry", "post-drain", "post-close"}, order)
+
+	var me *rerrors.MultiError
+	if assert.True(t, errors.As(err, &me), "expect MultiError but got %v", err) && assert.Len(t, me.Errors, 3) {
+		assert.True(t, strings.Contains(me.Errors[0].Error(), "panic"), me.Errors[0].Error())
+		assert.True(t, strings.Contains(me.Errors[1].Error(), "flush failed"), me.Errors[1].Error())
+		assert.True(t, errors.Is(me.Errors[2], context.DeadlineExceeded), me.Errors[2].Error())
+	}
+}
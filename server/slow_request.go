@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/valyala/fastrand"
+)
+
+// SlowRequest describes a request that took longer than the slow request threshold.
+type SlowRequest struct {
+	ServicePath   string
+	ServiceMethod string
+	// Latency is measured from just after the request is read to just after the response is written,
+	// so it includes serialization. With AsyncWrite the response is written when it is queued to the writer.
+	Latency time.Duration
+	// RequestSize is the size of the request payload in bytes.
+	RequestSize int
+	// Metadata contains metadata of the request selected by WithSlowRequestMetadata.
+	Metadata   map[string]string
+	RemoteAddr string
+	// Payload is a copy of the first bytes of the request payload if WithSlowRequestPayload is set.
+	Payload []byte
+	Error   error
+}
+
+type slowRequestOptions struct {
+	logger       func(SlowRequest)
+	maxPayload   int
+	metadataKeys []string
+}
+
+// WithSlowRequestThreshold calls logger for requests that take longer than d.
+func WithSlowRequestThreshold(d time.Duration, logger func(SlowRequest)) OptionFn {
+	return func(s *Server) {
+		s.runtime().SlowRequestThreshold = d
+		s.slowRequest.logger = logger
+	}
+}
+
+// WithSlowRequestSampleRate only reports the given fraction of slow requests,
+// so logs are not flooded when everything is slow. The default rate is 1.
+func WithSlowRequestSampleRate(rate float64) OptionFn {
+	return func(s *Server) {
+		s.runtime().SlowRequestSampleRate = rate
+	}
+}
+
+// WithSlowRequestPayload includes at most maxBytes of the serialized request payload in slow requests,
+// for example to replay them later.
+func WithSlowRequestPayload(maxBytes int) OptionFn {
+	return func(s *Server) {
+		s.slowRequest.maxPayload = maxBytes
+	}
+}
+
+// WithSlowRequestMetadata includes metadata of keys in slow requests.
+func WithSlowRequestMetadata(keys ...string) OptionFn {
+	return func(s *Server) {
+		s.slowRequest.metadataKeys = keys
+	}
+}
+
+// observeSlowRequest reports req if it is slow. It is called after the response is written.
+func (s *Server) observeSlowRequest(ctx context.Context, conn net.Conn, req *protocol.Message, err error) {
+	o := &s.slowRequest
+	if o.logger == nil {
+		return
+	}
+	start, ok := ctx.Value(StartRequestContextKey).(int64)
+	if !ok {
+		return
+	}
+	latency := time.Since(time.Unix(0, start))
+	settings := s.runtime()
+	if latency < settings.SlowRequestThreshold {
+		return
+	}
+	if rate := settings.SlowRequestSampleRate; rate > 0 && rate < 1 && float64(fastrand.Uint32n(1<<24)) >= rate*(1<<24) {
+		return
+	}
+
+	sr := SlowRequest{
+		ServicePath:   req.ServicePath,
+		ServiceMethod: req.ServiceMethod,
+		Latency:       latency,
+		RequestSize:   len(req.Payload),
+		RemoteAddr:    conn.RemoteAddr().String(),
+		Error:         err,
+	}
+	for _, key := range o.metadataKeys {
+		if v, ok := req.Metadata[key]; ok {
+			if sr.Metadata == nil {
+				sr.Metadata = make(map[string]string, len(o.metadataKeys))
+			}
+			sr.Metadata[key] = v
+		}
+	}
+	if o.maxPayload > 0 {
+		// req is reused after the response is written, so the payload is copied
+		n := len(req.Payload)
+		if n > o.maxPayload {
+			n = o.maxPayload
+		}
+		sr.Payload = append([]byte(nil), req.Payload[:n]...)
+	}
+	o.logger(sr)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+func sleepFn(ctx context.Context, args *Args, reply *Reply) error {
+	time.Sleep(time.Duration(args.A) * time.Millisecond)
+	return nil
+}
+
+func TestSlowRequest(t *testing.T) {
+	reports := make(chan SlowRequest, 10)
+	s := NewServer(
+		WithSlowRequestThreshold(50*time.Millisecond, func(sr SlowRequest) { reports <- sr }),
+		WithSlowRequestPayload(8),
+		WithSlowRequestMetadata("tenant", "missing"),
+	)
+	s.RegisterFunctionName("Slow", "Sleep", sleepFn, "")
+	startServer(t, s, "tcp")
+
+	opt := client.DefaultOption
+	opt.SerializeType = protocol.JSON // so the payload can be checked
+	c := client.NewClient(opt)
+	assert.NoError(t, c.Connect("tcp", s.Address().String()))
+	defer c.Close()
+
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"tenant": "a", "other": "b"})
+	assert.NoError(t, c.Call(ctx, "Slow", "Sleep", &Args{A: 0}, &Reply{}))
+	assert.NoError(t, c.Call(ctx, "Slow", "Sleep", &Args{A: 100}, &Reply{}))
+
+	select {
+	case sr := <-reports:
+		payload, _ := json.Marshal(&Args{A: 100})
+		assert.Equal(t, "Slow", sr.ServicePath)
+		assert.Equal(t, "Sleep", sr.ServiceMethod)
+		assert.True(t, sr.Latency >= 100*time.Millisecond, sr.Latency.String())
+		assert.Equal(t, len(payload), sr.RequestSize)
+		assert.Equal(t, payload[:8], sr.Payload)
+		assert.Equal(t, map[string]string{"tenant": "a"}, sr.Metadata)
+		assert.Equal(t, c.Conn.LocalAddr().String(), sr.RemoteAddr)
+		assert.NoError(t, sr.Error)
+	case <-time.After(time.Second):
+		t.Fatal("expect a slow request")
+	}
+	select {
+	case sr := <-reports:
+		t.Errorf("expect only one slow request but got %+v", sr)
+	default:
+	}
+}
+
+func TestSlowRequest_SampleRate(t *testing.T) {
+	var n int32
+	s := NewServer(
+		WithSlowRequestThreshold(0, func(sr SlowRequest) { atomic.AddInt32(&n, 1) }),
+		WithSlowRequestSampleRate(0.000001),
+	)
+	s.RegisterFunctionName("Slow", "Sleep", sleepFn, "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	assert.NoError(t, c.Connect("tcp", s.Address().String()))
+	defer c.Close()
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, c.Call(context.Background(), "Slow", "Sleep", &Args{}, &Reply{}))
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&n))
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RejectReasonRateLimit counts requests rejected by PreReadRequestPlugins with a ResourceExhausted error,
+// for example by serverplugin.ReqRateLimitingPlugin.
+const RejectReasonRateLimit = "rate_limit"
+
+// queueWaitBounds are upper bounds of the buckets of the queue wait histogram.
+var queueWaitBounds = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// Stats is a snapshot of the load of the server.
+//
+// Every accepted request is either shed, completed or in flight,
+// so Accepted is about the sum of Completed, InFlight and all counts in Shed.
+// Heartbeats are not counted.
+type Stats struct {
+	Accepted  uint64 `json:"accepted"`
+	Completed uint64 `json:"completed"`
+	InFlight  int64  `json:"in_flight"` // including queued requests
+	// Shed counts requests rejected by limiters, by reason such as RejectReasonBusy and RejectReasonRateLimit.
+	Shed map[string]uint64 `json:"shed"`
+	// ConnsRejected counts connections rejected by connection limits or PROXY protocol headers,
+	// by reason such as RejectReasonMaxConnections, RejectReasonMaxConnectionsPerIP and RejectReasonProxyHeader.
+	ConnsRejected map[string]uint64 `json:"conns_rejected"`
+	// ConnsTimedOut counts connections closed by CloseReasonTLSHandshakeTimeout and CloseReasonFirstRequestTimeout,
+	// including the ones closed by the gateway while they are sniffed.
+	ConnsTimedOut map[string]uint64 `json:"conns_timed_out"`
+	Connections   int               `json:"connections"`
+	// PendingConnections is the number of connections waiting for their first messages, see WithFirstRequestTimeout.
+	// It is zero unless WithFirstRequestTimeout, WithTLSHandshakeTimeout or WithMaxPendingConnections is used.
+	PendingConnections int64 `json:"pending_connections"`
+
+	// WorkerPoolSize, QueueDepth, MaxQueueDepth and QueueWait are zero if WithWorkerPool is not used.
+	WorkerPoolSize int               `json:"worker_pool_size"`
+	QueueDepth     int               `json:"queue_depth"`
+	MaxQueueDepth  int               `json:"max_queue_depth"`
+	QueueWait      []HistogramBucket `json:"queue_wait,omitempty"`
+	// QueueDepthByPriority is the number of queued requests of each priority, such as "high",
+	// if WithPriorityScheduling is used.
+	QueueDepthByPriority map[string]int `json:"queue_depth_by_priority,omitempty"`
+
+	// CompressBytesSaved is the number of bytes saved by compressing responses, see WithAutoCompress.
+	CompressBytesSaved int64 `json:"compress_bytes_saved"`
+	// ChecksumMismatches is the number of requests whose payloads fail their checksums, see WithChecksum.
+	ChecksumMismatches uint64 `json:"checksum_mismatches"`
+	// UnsupportedEncodings is the number of requests whose serialize types or compress types are not registered.
+	UnsupportedEncodings uint64 `json:"unsupported_encodings"`
+	// OnewayErrors is the number of oneway requests which fail. Their errors are not sent to clients.
+	OnewayErrors uint64 `json:"oneway_errors"`
+
+	// ServicesInFlight is the number of in-flight calls of every service.
+	ServicesInFlight map[string]int64 `json:"services_in_flight"`
+	// PubSub are the metrics of every group of SendToGroupAck.
+	PubSub map[string]PubSubGroupStats `json:"pub_sub,omitempty"`
+}
+
+// HistogramBucket counts observations that are not larger than UpperBound.
+// Counts are not cumulative. UpperBound of the last bucket is zero, which means no bound.
+type HistogramBucket struct {
+	UpperBound time.Duration `json:"upper_bound"`
+	Count      uint64        `json:"count"`
+}
+
+// serverStats contains counters updated in the dispatch path.
+type serverStats struct {
+	accepted  uint64
+	completed uint64
+	inFlight  int64
+
+	shedBusy           uint64
+	shedRateLimit      uint64
+	shedConnectionBusy uint64
+
+	connsRejectedMax          uint64
+	connsRejectedPerIP        uint64
+	connsRejectedProxy        uint64
+	connsRejectedProxyTimeout uint64
+	connsRejectedPending      uint64
+
+	pendingConns         int64
+	connsTimedOutTLS     uint64
+	connsTimedOutRequest uint64
+
+	maxQueueDepth int64
+	queueWait     [6]uint64 // len(queueWaitBounds) + 1
+
+	compressSaved        int64
+	checksumMismatches   uint64
+	unsupportedEncodings uint64
+	onewayErrors         uint64
+}
+
+func (st *serverStats) accept() {
+	atomic.AddUint64(&st.accepted, 1)
+	atomic.AddInt64(&st.inFlight, 1)
+}
+
+func (st *serverStats) complete() {
+	atomic.AddInt64(&st.inFlight, -1)
+	atomic.AddUint64(&st.completed, 1)
+}
+
+// shed counts an accepted request rejected by a limiter.
+func (st *serverStats) shed(reason string) {
+	atomic.AddInt64(&st.inFlight, -1)
+	switch reason {
+	case RejectReasonBusy:
+		atomic.AddUint64(&st.shedBusy, 1)
+	case RejectReasonRateLimit:
+		atomic.AddUint64(&st.shedRateLimit, 1)
+	case RejectReasonConnectionBusy:
+		atomic.AddUint64(&st.shedConnectionBusy, 1)
+	}
+}
+
+func (st *serverStats) rejectConn(reason string) {
+	switch reason {
+	case RejectReasonMaxConnections:
+		atomic.AddUint64(&st.connsRejectedMax, 1)
+	case RejectReasonMaxConnectionsPerIP:
+		atomic.AddUint64(&st.connsRejectedPerIP, 1)
+	case RejectReasonProxyHeader:
+		atomic.AddUint64(&st.connsRejectedProxy, 1)
+	case RejectReasonProxyHeaderTimeout:
+		atomic.AddUint64(&st.connsRejectedProxyTimeout, 1)
+	case RejectReasonMaxPendingConnections:
+		atomic.AddUint64(&st.connsRejectedPending, 1)
+	}
+}
+
+func (st *serverStats) timeOutConn(reason string) {
+	switch reason {
+	case CloseReasonTLSHandshakeTimeout:
+		atomic.AddUint64(&st.connsTimedOutTLS, 1)
+	case CloseReasonFirstRequestTimeout:
+		atomic.AddUint64(&st.connsTimedOutRequest, 1)
+	}
+}
+
+func (st *serverStats) observeQueueDepth(depth int) {
+	for {
+		max := atomic.LoadInt64(&st.maxQueueDepth)
+		if int64(depth) <= max || atomic.CompareAndSwapInt64(&st.maxQueueDepth, max, int64(depth)) {
+			return
+		}
+	}
+}
+
+func (st *serverStats) observeQueueWait(d time.Duration) {
+	i := 0
+	for i < len(queueWaitBounds) && d > queueWaitBounds[i] {
+		i++
+	}
+	atomic.AddUint64(&st.queueWait[i], 1)
+}
+
+// Load is the load of the server right now, see Server.Load.
+type Load struct {
+	InFlight int64 // including queued requests
+	// QueueDepth, WorkerPoolSize and QueueSize are zero if WithWorkerPool is not used.
+	QueueDepth     int
+	WorkerPoolSize int
+	QueueSize      int
+}
+
+// Load returns the load of the server. Unlike Stats, it is cheap enough to be got for every request.
+func (s *Server) Load() Load {
+	load := Load{InFlight: atomic.LoadInt64(&s.stats.inFlight)}
+	if s.workerPool != nil {
+		load.WorkerPoolSize, load.QueueDepth, load.QueueSize = s.workerPool.stats()
+	}
+	return load
+}
+
+// Stats returns a snapshot of the load of the server.
+func (s *Server) Stats() Stats {
+	st := &s.stats
+	stats := Stats{
+		Accepted:  atomic.LoadUint64(&st.accepted),
+		Completed: atomic.LoadUint64(&st.completed),
+		InFlight:  atomic.LoadInt64(&st.inFlight),
+		Shed: map[string]uint64{
+			RejectReasonBusy:           atomic.LoadUint64(&st.shedBusy),
+			RejectReasonRateLimit:      atomic.LoadUint64(&st.shedRateLimit),
+			RejectReasonConnectionBusy: atomic.LoadUint64(&st.shedConnectionBusy),
+		},
+		ConnsRejected: map[string]uint64{
+			RejectReasonMaxConnections:        atomic.LoadUint64(&st.connsRejectedMax),
+			RejectReasonMaxConnectionsPerIP:   atomic.LoadUint64(&st.connsRejectedPerIP),
+			RejectReasonProxyHeader:           atomic.LoadUint64(&st.connsRejectedProxy),
+			RejectReasonProxyHeaderTimeout:    atomic.LoadUint64(&st.connsRejectedProxyTimeout),
+			RejectReasonMaxPendingConnections: atomic.LoadUint64(&st.connsRejectedPending),
+		},
+		ConnsTimedOut: map[string]uint64{
+			CloseReasonTLSHandshakeTimeout: atomic.LoadUint64(&st.connsTimedOutTLS),
+			CloseReasonFirstRequestTimeout: atomic.LoadUint64(&st.connsTimedOutRequest),
+		},
+		PendingConnections:   atomic.LoadInt64(&st.pendingConns),
+		CompressBytesSaved:   atomic.LoadInt64(&st.compressSaved),
+		ChecksumMismatches:   atomic.LoadUint64(&st.checksumMismatches),
+		UnsupportedEncodings: atomic.LoadUint64(&st.unsupportedEncodings),
+		OnewayErrors:         atomic.LoadUint64(&st.onewayErrors),
+		ServicesInFlight:     make(map[string]int64),
+	}
+
+	s.mu.RLock()
+	stats.Connections = len(s.activeConn)
+	s.mu.RUnlock()
+
+	if s.workerPool != nil {
+		stats.WorkerPoolSize, stats.QueueDepth, _ = s.workerPool.stats()
+		stats.QueueDepthByPriority = s.workerPool.bandDepths()
+		stats.MaxQueueDepth = int(atomic.LoadInt64(&st.maxQueueDepth))
+		stats.QueueWait = make([]HistogramBucket, len(st.queueWait))
+		for i := range st.queueWait {
+			stats.QueueWait[i].Count = atomic.LoadUint64(&st.queueWait[i])
+			if i < len(queueWaitBounds) {
+				stats.QueueWait[i].UpperBound = queueWaitBounds[i]
+			}
+		}
+	}
+
+	s.serviceMapMu.RLock()
+	for name, svc := range s.serviceMap {
+		stats.ServicesInFlight[name] = atomic.LoadInt64(&svc.inflightCount)
+	}
+	s.serviceMapMu.RUnlock()
+
+	stats.PubSub = s.pubSubStats()
+	return stats
+}
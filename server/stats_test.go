@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	gate := &gateService{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s := NewServer(WithWorkerPool(1, 1))
+	s.RegisterName("Gate", gate, "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	call1 := c.Go(context.Background(), "Gate", "Wait", &Args{A: 1}, &Reply{}, nil)
+	<-gate.started
+	call2 := c.Go(context.Background(), "Gate", "Wait", &Args{A: 2}, &Reply{}, nil)
+	time.Sleep(100 * time.Millisecond)
+	c.Call(context.Background(), "Gate", "Wait", &Args{A: 3}, &Reply{}) // shed
+
+	st := s.Stats()
+	assert.Equal(t, uint64(3), st.Accepted)
+	assert.Equal(t, uint64(0), st.Completed)
+	assert.Equal(t, int64(2), st.InFlight)
+	assert.Equal(t, uint64(1), st.Shed[RejectReasonBusy])
+	assert.Equal(t, int64(1), st.ServicesInFlight["Gate"]) // the queued call is not started
+	assert.Equal(t, 1, st.QueueDepth)
+	assert.Equal(t, 1, st.MaxQueueDepth)
+	assert.Equal(t, 1, st.Connections)
+
+	close(gate.release)
+	<-call1.Done
+	<-call2.Done
+	time.Sleep(50 * time.Millisecond)
+
+	st = s.Stats()
+	assert.Equal(t, uint64(2), st.Completed)
+	assert.Equal(t, int64(0), st.InFlight)
+	assert.Equal(t, int64(0), st.ServicesInFlight["Gate"])
+	assert.Equal(t, st.Accepted, st.Completed+uint64(st.InFlight)+st.Shed[RejectReasonBusy]+st.Shed[RejectReasonRateLimit])
+
+	var waits uint64
+	for _, b := range st.QueueWait {
+		waits += b.Count
+	}
+	assert.Equal(t, uint64(2), waits)
+	assert.Equal(t, time.Duration(0), st.QueueWait[len(st.QueueWait)-1].UpperBound)
+
+	w := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var got Stats
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got)) {
+		assert.Equal(t, st.Accepted, got.Accepted)
+		assert.Equal(t, st.Shed, got.Shed)
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// DefaultUDPMaxDatagramSize is the default max size of datagrams of responses over udp,
+// the max payload of IPv4 UDP datagrams.
+const DefaultUDPMaxDatagramSize = 65507
+
+// ErrUDPHeartbeat is the error of heartbeats over udp, which are not supported since udp is connectionless.
+var ErrUDPHeartbeat = errors.New("rpcx: heartbeats are not supported by udp")
+
+func init() {
+	makeListeners["udp"] = udpMakeListener("udp")
+	makeListeners["udp4"] = udpMakeListener("udp4")
+	makeListeners["udp6"] = udpMakeListener("udp6")
+}
+
+// WithUDPMaxDatagramSize limits the size of datagrams of responses over udp, which is DefaultUDPMaxDatagramSize by default.
+// Requests whose responses are larger get error responses with the ResourceExhausted code instead.
+func WithUDPMaxDatagramSize(n int) OptionFn {
+	return func(s *Server) {
+		s.udpMaxDatagramSize = n
+	}
+}
+
+func udpMakeListener(network string) MakeListener {
+	return func(s *Server, address string) (net.Listener, error) {
+		pc, err := net.ListenPacket(network, address)
+		if err != nil {
+			return nil, err
+		}
+		return &udpListener{PacketConn: pc, done: make(chan struct{})}, nil
+	}
+}
+
+// udpListener is the listener of udp, so the server closes it and gets its address like listeners of connections.
+// It accepts no connections, since datagrams are read by serveByUDP.
+type udpListener struct {
+	net.PacketConn
+	done chan struct{}
+	once sync.Once
+}
+
+func (ln *udpListener) Accept() (net.Conn, error) {
+	<-ln.done
+	return nil, net.ErrClosed
+}
+
+func (ln *udpListener) Close() error {
+	ln.once.Do(func() { close(ln.done) })
+	return ln.PacketConn.Close()
+}
+
+func (ln *udpListener) Addr() net.Addr {
+	return ln.LocalAddr()
+}
+
+// serveByUDP serves requests of datagrams, each of which is an encoded request, until ln is closed.
+// Responses are sent in single datagrams to the sources of requests, by the best effort, and oneway requests are not responded.
+// Clients retransmit requests whose responses are lost, so services over udp should be idempotent.
+//
+// Requests are queued by WithWorkerPool like requests of connections, and Shutdown waits for them to be handled,
+// though their responses are lost if the listener is closed by then.
+// udp is connectionless, so the source address of a request is a net.Addr by RemoteConnContextKey, see RemoteAddrFromContext,
+// and heartbeats, messages sent by SendMessage, handlers added by AddHandler and streams are not supported.
+// Heartbeats are responded by error responses of ErrUDPHeartbeat.
+func (s *Server) serveByUDP(ln *udpListener) error {
+	s.setListener(ln)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := ln.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-ln.done:
+				return ErrServerClosed
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+
+		// decoded requests have their own copies of the datagram, so buf is read again
+		req := protocol.GetPooledMsg()
+		if err := req.DecodeLimit(bytes.NewReader(buf[:n]), s.messageSizeLimit()); err != nil {
+			log.Warnf("rpcx: failed to decode the datagram of %s: %v", addr, err)
+			protocol.FreeMsg(req)
+			continue
+		}
+		if req.MessageType() != protocol.Request {
+			protocol.FreeMsg(req)
+			continue
+		}
+		s.dispatchDatagram(ln, addr, req)
+	}
+}
+
+// dispatchDatagram handles req sent by addr by a goroutine or by the worker pool.
+func (s *Server) dispatchDatagram(ln *udpListener, addr net.Addr, req *protocol.Message) {
+	ctx := share.WithValue(context.Background(), RemoteConnContextKey, addr)
+
+	counted := !req.IsHeartbeat()
+	if counted {
+		s.stats.accept()
+	}
+
+	// counted before dispatching so that Shutdown waits for queued requests too
+	atomic.AddInt32(&s.handlerMsgNum, 1)
+	task := func() {
+		s.serveDatagram(ctx, ln, addr, req)
+		protocol.FreeMsg(req)
+		atomic.AddInt32(&s.handlerMsgNum, -1)
+		if counted {
+			s.stats.complete()
+		}
+	}
+	if s.workerPool == nil || !counted {
+		go task()
+		return
+	}
+
+	queued := time.Now()
+	if s.workerPool.submit(func() {
+		s.stats.observeQueueWait(time.Since(queued))
+		task()
+	}, requestPriority(req)) {
+		s.stats.observeQueueDepth(len(s.workerPool.queue))
+		return
+	}
+	atomic.AddInt32(&s.handlerMsgNum, -1)
+	s.stats.shed(RejectReasonBusy)
+	s.Plugins.DoRequestRejected(ctx, req, RejectReasonBusy, ErrServerBusy)
+	if !req.IsOneway() {
+		res := NewErrorResponse(req, ErrServerBusy)
+		s.sendDatagram(ln, addr, req, res)
+		protocol.FreeMsg(res)
+	}
+	protocol.FreeMsg(req)
+}
+
+// serveDatagram handles req sent by addr and sends its response.
+func (s *Server) serveDatagram(ctx context.Context, ln *udpListener, addr net.Addr, req *protocol.Message) {
+	var res *protocol.Message
+	if req.IsHeartbeat() {
+		res = NewErrorResponse(req, ErrUDPHeartbeat)
+	} else {
+		var err error
+		res, err = s.HandleRequest(ctx, req)
+		if req.IsOneway() {
+			protocol.FreeMsg(res)
+			return
+		}
+		if err != nil {
+			res = NewErrorResponse(req, err)
+		}
+	}
+	s.sendDatagram(ln, addr, req, res)
+	protocol.FreeMsg(res)
+}
+
+// sendDatagram sends res, the response of req, to addr in a datagram.
+func (s *Server) sendDatagram(ln *udpListener, addr net.Addr, req, res *protocol.Message) {
+	out := res.Encode()
+	max := s.udpMaxDatagramSize
+	if max <= 0 {
+		max = DefaultUDPMaxDatagramSize
+	}
+	if len(out) > max {
+		log.Warnf("rpcx: the response of %s.%s to %s is %d bytes, larger than the datagram size %d", req.ServicePath, req.ServiceMethod, addr, len(out), max)
+		eres := NewErrorResponse(req, rerrors.Errorf(rerrors.ResourceExhausted, "rpcx: the response is %d bytes, larger than the datagram size %d", len(out), max))
+		out = eres.Encode()
+		protocol.FreeMsg(eres)
+	}
+	if _, err := ln.WriteTo(out, addr); err != nil {
+		log.Warnf("rpcx: failed to send the response to %s: %v", addr, err)
+	}
+}
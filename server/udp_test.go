@@ -0,0 +1,319 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+type udpService struct {
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *udpService) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	atomic.AddInt32(&s.calls, 1)
+	ctx.Value(share.ResMetaDataKey).(map[string]string)["remote"] = RemoteAddrFromContext(ctx).String()
+	reply.C = args.A * args.B
+	return nil
+}
+
+func (s *udpService) Fail(ctx context.Context, args *Args, reply *Reply) error {
+	return Errorf(rerrors.InvalidArgument, "bad args")
+}
+
+func (s *udpService) Block(ctx context.Context, args *Args, reply *Reply) error {
+	s.started <- struct{}{}
+	<-s.release
+	return nil
+}
+
+func (s *udpService) Large(ctx context.Context, args *Args, reply *[]byte) error {
+	*reply = make([]byte, args.A)
+	return nil
+}
+
+func startUDPServer(t *testing.T, opts ...OptionFn) (*Server, *udpService) {
+	s := NewServer(opts...)
+	svc := &udpService{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s.RegisterName("UDP", svc, "")
+	served := make(chan error, 1)
+	go func() { served <- s.Serve("udp", "127.0.0.1:0") }()
+	select {
+	case <-s.listening:
+	case err := <-served:
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+		assert.Equal(t, ErrServerClosed, <-served)
+	})
+	return s, svc
+}
+
+func udpClient(t *testing.T, address string, retransmit client.UDPRetransmit) *client.Client {
+	option := client.DefaultOption
+	option.UDPRetransmit = retransmit
+	c := client.NewClient(option)
+	if err := c.Connect("udp", address); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// lossyRelay relays datagrams between one client and the server, and drops or duplicates them by its functions.
+type lossyRelay struct {
+	conn   *net.UDPConn // of the client
+	server *net.UDPConn // to the server
+
+	mu       sync.Mutex
+	client   *net.UDPAddr
+	requests int
+	replies  int
+
+	dropRequest func(i int) bool // drops the i-th request
+	dropReply   func(i int) bool // drops the i-th response
+	dupReply    bool             // sends each response twice
+}
+
+func newLossyRelay(t *testing.T, serverAddr string) *lossyRelay {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raddr, _ := net.ResolveUDPAddr("udp", serverAddr)
+	server, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &lossyRelay{conn: conn, server: server}
+	r.configure(func(*lossyRelay) {})
+	t.Cleanup(func() {
+		conn.Close()
+		server.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			r.client = addr
+			i := r.requests
+			r.requests++
+			drop := r.dropRequest(i)
+			r.mu.Unlock()
+			if !drop {
+				server.Write(buf[:n])
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := server.Read(buf)
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			i := r.replies
+			r.replies++
+			drop, dup := r.dropReply(i), r.dupReply
+			addr := r.client
+			r.mu.Unlock()
+			if !drop {
+				conn.WriteToUDP(buf[:n], addr)
+				if dup {
+					conn.WriteToUDP(buf[:n], addr)
+				}
+			}
+		}
+	}()
+	return r
+}
+
+// configure resets the relay and configures it by f.
+func (r *lossyRelay) configure(f func(r *lossyRelay)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests, r.replies = 0, 0
+	r.dropRequest = func(int) bool { return false }
+	r.dropReply = func(int) bool { return false }
+	r.dupReply = false
+	f(r)
+}
+
+func TestUDP(t *testing.T) {
+	s, svc := startUDPServer(t, WithUDPMaxDatagramSize(1024))
+	addr := s.Address().String()
+	c := udpClient(t, addr, client.UDPRetransmit{Retries: 2, Timeout: time.Second})
+
+	meta := make(map[string]string)
+	ctx := context.WithValue(context.Background(), share.ResMetaDataKey, meta)
+	reply := &Reply{}
+	assert.NoError(t, c.Call(ctx, "UDP", "Mul", &Args{A: 3, B: 4}, reply))
+	assert.Equal(t, 12, reply.C)
+	assert.Equal(t, c.Conn.LocalAddr().String(), meta["remote"])
+
+	err := c.Call(context.Background(), "UDP", "Fail", &Args{}, reply)
+	assert.True(t, errors.Is(err, rerrors.ErrInvalidArgument), "%v", err)
+
+	// oneway requests are not responded
+	assert.NoError(t, c.Notify(context.Background(), "UDP", "Mul", &Args{A: 1, B: 1}))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&svc.calls))
+
+	// requests larger than the datagram size fail before they are sent
+	var data []byte
+	err = c.Call(context.Background(), "UDP", strings.Repeat("M", 2000), &Args{A: 1}, &data)
+	assert.True(t, errors.Is(err, client.ErrDatagramTooLarge), "%v", err)
+
+	// responses larger than the datagram size of the server are errors
+	err = c.Call(context.Background(), "UDP", "Large", &Args{A: 2000}, &data)
+	var se *client.CodedServiceError
+	if assert.True(t, errors.As(err, &se), "%v", err) {
+		assert.Equal(t, int(rerrors.ResourceExhausted), se.Code())
+	}
+
+	// heartbeats are not supported
+	request, hb := time.Now().UnixNano(), int64(0)
+	err = c.Call(context.Background(), "", "", &request, &hb)
+	assert.True(t, errors.Is(err, client.ErrUDPHeartbeat), "%v", err)
+	option := client.DefaultOption
+	option.Heartbeat = true
+	assert.ErrorIs(t, client.NewClient(option).Connect("udp", addr), client.ErrUDPHeartbeat)
+
+	// the server is still serving after invalid datagrams
+	conn, err := net.Dial("udp", addr)
+	if assert.NoError(t, err) {
+		conn.Write([]byte("not a message"))
+		conn.Close()
+	}
+	assert.NoError(t, c.Call(context.Background(), "UDP", "Mul", &Args{A: 5, B: 5}, reply))
+	assert.Equal(t, 25, reply.C)
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	s, svc := startUDPServer(t)
+	relay := newLossyRelay(t, s.Address().String())
+	c := udpClient(t, relay.conn.LocalAddr().String(), client.UDPRetransmit{Retries: 3, Timeout: 50 * time.Millisecond})
+
+	// lost requests are retransmitted
+	relay.configure(func(r *lossyRelay) { r.dropRequest = func(i int) bool { return i < 2 } })
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "UDP", "Mul", &Args{A: 2, B: 3}, reply))
+	assert.Equal(t, 6, reply.C)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&svc.calls))
+
+	// requests of lost responses are retransmitted, and handled again
+	relay.configure(func(r *lossyRelay) { r.dropReply = func(i int) bool { return i < 2 } })
+	assert.NoError(t, c.Call(context.Background(), "UDP", "Mul", &Args{A: 3, B: 3}, reply))
+	assert.Equal(t, 9, reply.C)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&svc.calls))
+
+	// calls fail after the last retransmit
+	relay.configure(func(r *lossyRelay) { r.dropRequest = func(i int) bool { return true } })
+	start := time.Now()
+	err := c.Call(context.Background(), "UDP", "Mul", &Args{A: 1, B: 1}, reply)
+	var se *client.CodedServiceError
+	if assert.True(t, errors.As(err, &se), "%v", err) {
+		assert.Equal(t, int(rerrors.Unavailable), se.Code())
+	}
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	relay.mu.Lock()
+	assert.Equal(t, 4, relay.requests)
+	relay.mu.Unlock()
+
+	// the policy of a call overrides the policy of the client
+	relay.configure(func(r *lossyRelay) { r.dropRequest = func(i int) bool { return true } })
+	ctx := client.WithUDPRetransmit(context.Background(), client.UDPRetransmit{Retries: 0, Timeout: 30 * time.Millisecond})
+	assert.Error(t, c.Call(ctx, "UDP", "Mul", &Args{A: 1, B: 1}, reply))
+	relay.mu.Lock()
+	assert.Equal(t, 1, relay.requests)
+	relay.mu.Unlock()
+
+	// calls without retransmits wait until their contexts are done
+	ctx, cancel := context.WithTimeout(client.WithUDPRetransmit(context.Background(), client.UDPRetransmit{}), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.Call(ctx, "UDP", "Mul", &Args{A: 1, B: 1}, reply), context.DeadlineExceeded)
+}
+
+func TestUDPDuplicateResponses(t *testing.T) {
+	s, svc := startUDPServer(t)
+	relay := newLossyRelay(t, s.Address().String())
+	c := udpClient(t, relay.conn.LocalAddr().String(), client.UDPRetransmit{Retries: 5, Timeout: 20 * time.Millisecond})
+	relay.configure(func(r *lossyRelay) { r.dupReply = true })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reply := &Reply{}
+			if assert.NoError(t, c.Call(context.Background(), "UDP", "Mul", &Args{A: i, B: 2}, reply)) {
+				assert.Equal(t, i*2, reply.C)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(20), atomic.LoadInt32(&svc.calls))
+
+	// retransmitted requests are handled twice, but their calls get one response
+	relay.configure(func(r *lossyRelay) {
+		r.dupReply = true
+		r.dropReply = func(i int) bool { return i == 0 }
+	})
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "UDP", "Mul", &Args{A: 7, B: 7}, reply))
+	assert.Equal(t, 49, reply.C)
+	assert.NoError(t, c.Call(context.Background(), "UDP", "Mul", &Args{A: 8, B: 8}, reply))
+	assert.Equal(t, 64, reply.C)
+	assert.Equal(t, int32(23), atomic.LoadInt32(&svc.calls))
+}
+
+func TestUDPWorkerPool(t *testing.T) {
+	s, svc := startUDPServer(t, WithWorkerPool(1, 1))
+	c := udpClient(t, s.Address().String(), client.UDPRetransmit{Retries: 0, Timeout: time.Second})
+
+	// one request is handled and one is queued, so the third one is rejected
+	assert.NoError(t, c.Notify(context.Background(), "UDP", "Block", &Args{}))
+	<-svc.started
+	assert.NoError(t, c.Notify(context.Background(), "UDP", "Block", &Args{}))
+	time.Sleep(50 * time.Millisecond)
+	err := c.Call(context.Background(), "UDP", "Mul", &Args{A: 1, B: 1}, &Reply{})
+	assert.True(t, errors.Is(err, rerrors.ErrUnavailable) && strings.Contains(err.Error(), "busy"), "%v", err)
+	assert.Equal(t, uint64(1), s.Stats().Shed[RejectReasonBusy])
+
+	// Shutdown waits for the handled and queued requests
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("expect Shutdown to wait for requests but got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(svc.release)
+	<-svc.started
+	assert.NoError(t, <-shutdown)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&s.handlerMsgNum))
+}
+
+func TestUDPAddListener(t *testing.T) {
+	s := NewServer()
+	assert.Error(t, s.AddListener("udp", "127.0.0.1:0"))
+}
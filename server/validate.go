@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// RejectReasonValidation is the reason passed to RequestRejectedPlugin when arguments fail validation.
+const RejectReasonValidation = "validation"
+
+// Validator is implemented by arguments that can validate themselves.
+type Validator interface {
+	Validate() error
+}
+
+// ContextValidator is implemented by arguments that validate themselves with the request context.
+type ContextValidator interface {
+	ValidateContext(ctx context.Context) error
+}
+
+// validate validates decoded arguments before the handler is invoked.
+// Errors of validators with codes are returned as they are, and other errors are returned as InvalidArgument errors.
+func (s *Server) validate(ctx context.Context, req *protocol.Message, argv interface{}) error {
+	var err error
+	switch v := argv.(type) {
+	case ContextValidator:
+		err = v.ValidateContext(ctx)
+	case Validator:
+		err = v.Validate()
+	default:
+		if s.validator != nil {
+			err = s.validator(ctx, argv)
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	if rerrors.CodeOf(err) == rerrors.Unknown {
+		err = rerrors.New(rerrors.InvalidArgument, err.Error())
+	}
+	s.Plugins.DoRequestRejected(ctx, req, RejectReasonValidation, err)
+	return err
+}
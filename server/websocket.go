@@ -0,0 +1,88 @@
+package server
+
+import (
+	"compress/flate"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/util"
+)
+
+type websocketOptions struct {
+	compression      bool
+	compressionLevel int
+	maxMessageSize   int64
+	json             bool
+}
+
+// WithWebsocketCompression negotiates permessage-deflate with ws and wss clients that support it,
+// and compresses messages to them with level of compress/flate.
+// Clients without compression are still served.
+//
+// The compression context is not taken over between messages, so the memory of compression is bounded
+// by the message being compressed instead of growing with the number of connections.
+// The window is the 32KB window of compress/flate.
+func WithWebsocketCompression(level int) OptionFn {
+	return func(s *Server) {
+		s.websocket.compression = true
+		s.websocket.compressionLevel = level
+	}
+}
+
+// WithWebsocketMaxMessageSize sets the max size of websocket messages from clients.
+// Connections sending larger messages are closed with the close code 1009 (message too big).
+func WithWebsocketMaxMessageSize(n int64) OptionFn {
+	return func(s *Server) {
+		s.websocket.maxMessageSize = n
+	}
+}
+
+// websocketHandler upgrades requests to websocket connections and serves them.
+func (s *Server) websocketHandler() http.Handler {
+	upgrader := &websocket.Upgrader{
+		EnableCompression: s.websocket.compression,
+		CheckOrigin:       s.websocketOriginChecker(),
+		Subprotocols:      []string{WebsocketBinarySubprotocol},
+	}
+	if s.websocket.json {
+		upgrader.Subprotocols = append(upgrader.Subprotocols, WebsocketJSONSubprotocol)
+	}
+	level := s.websocket.compressionLevel
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		level = flate.DefaultCompression
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var header http.Header
+		headerMeta := MetadataOfHeaders(r.Header, s.gatewayHeaders)
+		if headerMeta != nil {
+			header = make(http.Header)
+			SetMetadataHeaders(header, headerMeta, s.gatewayHeaders)
+		}
+		wsConn, err := upgrader.Upgrade(w, r, header)
+		if err != nil {
+			log.Warnf("rpcx: failed to upgrade websocket connection from %s: %v", r.RemoteAddr, err)
+			return
+		}
+		if s.websocket.maxMessageSize > 0 {
+			wsConn.SetReadLimit(s.websocket.maxMessageSize)
+		}
+		if s.websocket.compression {
+			wsConn.SetCompressionLevel(level)
+		}
+
+		var conn net.Conn
+		if wsConn.Subprotocol() == WebsocketJSONSubprotocol {
+			conn = newWebsocketJSONConn(wsConn, headerMeta) // JSON clients don't support sessions
+		} else {
+			conn = s.sessionConn(util.NewWebsocketConn(wsConn))
+		}
+		if reason := s.addConn(conn); reason != "" {
+			s.rejectConn(conn, reason)
+			return
+		}
+		s.serveConn(conn)
+	})
+}
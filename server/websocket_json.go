@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/util"
+)
+
+const (
+	// WebsocketJSONSubprotocol is the websocket subprotocol of JSON frames, which is enabled by WithWebsocketJSON.
+	WebsocketJSONSubprotocol = "json"
+	// WebsocketBinarySubprotocol is the websocket subprotocol of binary rpcx messages,
+	// which is also used by clients without subprotocols.
+	WebsocketBinarySubprotocol = "rpcx"
+)
+
+// WithWebsocketJSON serves websocket clients of the subprotocol "json", such as JavaScript in browsers,
+// which send text frames of JSON objects instead of binary rpcx messages.
+// Clients of the binary subprotocol, or without subprotocols, are still served on the same endpoint.
+//
+// Requests are JSON objects, whose payloads are the JSON args of services:
+//
+//	{"seq":1,"service":"Arith","method":"Mul","meta":{"k":"v"},"payload":{"A":10,"B":20}}
+//
+// "oneway":true sends a request without a response, and {"seq":2,"heartbeat":true} is a heartbeat,
+// which is answered by the same frame. Responses have the seq of their requests, and meta of the
+// response metadata of services:
+//
+//	{"seq":1,"service":"Arith","method":"Mul","payload":{"C":200}}
+//	{"seq":1,"service":"Arith","method":"Mul","error":{"code":5,"message":"rpcx: can't find service Arith","details":{"k":"v"}}}
+//
+// Messages sent by Server.SendMessage are frames with "push":true. Their payloads are in "payload"
+// if they are JSON, or else in "data" as base64.
+//
+// Websocket pings of clients are heartbeats answered by pongs, and heartbeats of the server,
+// such as those of WithClientIdleTimeout, are sent as pings, whose pongs are their responses.
+func WithWebsocketJSON() OptionFn {
+	return func(s *Server) {
+		s.websocket.json = true
+	}
+}
+
+// WebsocketFrame is the JSON frame of the websocket subprotocol "json".
+type WebsocketFrame struct {
+	Seq       uint64               `json:"seq"`
+	Service   string               `json:"service,omitempty"`
+	Method    string               `json:"method,omitempty"`
+	Meta      map[string]string    `json:"meta,omitempty"`
+	Payload   json.RawMessage      `json:"payload,omitempty"`
+	Data      []byte               `json:"data,omitempty"`
+	Oneway    bool                 `json:"oneway,omitempty"`
+	Heartbeat bool                 `json:"heartbeat,omitempty"`
+	Push      bool                 `json:"push,omitempty"`
+	Error     *WebsocketFrameError `json:"error,omitempty"`
+}
+
+// WebsocketFrameError is the error of a response frame.
+type WebsocketFrameError struct {
+	Code    rerrors.Code      `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// websocketJSONConn translates JSON frames of a websocket connection to rpcx messages and back,
+// so that it is served like other connections. Messages written by the server are reassembled
+// from the written bytes, since they can be written in pieces.
+type websocketJSONConn struct {
+	*util.WebsocketConn
+
+	in      chan []byte       // encoded messages of frames
+	meta    map[string]string // metadata of headers of the upgrade request, see WithGatewayHeaders
+	readErr error
+	cur     []byte
+	done    chan struct{}
+	once    sync.Once
+
+	wmu  sync.Mutex
+	wbuf []byte
+
+	mu      sync.Mutex
+	pingSeq uint64
+	pings   map[uint64][]byte // pongs of pings of the client by the seqs of their heartbeats
+}
+
+// pingSeqBase is the first seq of heartbeats of pings, which are not likely to be used by clients.
+const pingSeqBase = 1 << 63
+
+func newWebsocketJSONConn(conn *websocket.Conn, meta map[string]string) *websocketJSONConn {
+	c := &websocketJSONConn{
+		WebsocketConn: util.NewWebsocketConn(conn),
+		in:            make(chan []byte, 1),
+		meta:          meta,
+		done:          make(chan struct{}),
+		pingSeq:       pingSeqBase,
+		pings:         make(map[uint64][]byte),
+	}
+	conn.SetPingHandler(c.handlePing)
+	conn.SetPongHandler(c.handlePong)
+	go c.readFrames()
+	return c
+}
+
+// readFrames reads frames and translates them to messages, which are read by Read.
+func (c *websocketJSONConn) readFrames() {
+	defer close(c.in)
+	for {
+		mt, data, err := c.ReadMessage()
+		if err != nil {
+			c.readErr = err
+			return
+		}
+		if mt != websocket.TextMessage {
+			c.writeClose(websocket.CloseUnsupportedData, "rpcx: frames of the json subprotocol must be text")
+			c.readErr = errors.New("rpcx: binary frame of the json subprotocol")
+			return
+		}
+
+		var frame WebsocketFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			c.writeFrame(&WebsocketFrame{Error: &WebsocketFrameError{Code: rerrors.InvalidArgument, Message: "rpcx: invalid frame: " + err.Error()}})
+			continue
+		}
+		if !frame.Heartbeat && (frame.Service == "" || frame.Method == "") {
+			c.writeFrame(&WebsocketFrame{Seq: frame.Seq, Error: &WebsocketFrameError{Code: rerrors.InvalidArgument, Message: "rpcx: service and method are required"}})
+			continue
+		}
+		if !frame.Heartbeat {
+			frame.Meta = mergeMetadata(frame.Meta, c.meta)
+		}
+		if !c.send(encodeFrame(&frame)) {
+			return
+		}
+	}
+}
+
+// send sends the encoded message to Read, or returns false if the connection is closed.
+func (c *websocketJSONConn) send(data []byte) bool {
+	select {
+	case c.in <- data:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// encodeFrame encodes the request of frame.
+func encodeFrame(frame *WebsocketFrame) []byte {
+	req := protocol.GetPooledMsg()
+	defer protocol.FreeMsg(req)
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.SetSeq(frame.Seq)
+	req.SetHeartbeat(frame.Heartbeat)
+	req.SetOneway(frame.Oneway)
+	req.ServicePath = frame.Service
+	req.ServiceMethod = frame.Method
+	req.Metadata = frame.Meta
+	req.Payload = frame.Payload
+	if len(req.Payload) == 0 && !frame.Heartbeat {
+		req.Payload = []byte("null")
+	}
+	return req.Encode()
+}
+
+// handlePing sends a heartbeat for the ping, whose response is sent as the pong.
+func (c *websocketJSONConn) handlePing(appData string) error {
+	c.mu.Lock()
+	c.pingSeq++
+	seq := c.pingSeq
+	c.pings[seq] = []byte(appData)
+	c.mu.Unlock()
+
+	c.send(encodeFrame(&WebsocketFrame{Seq: seq, Heartbeat: true}))
+	return nil
+}
+
+// handlePong sends the response of the heartbeat of the server, whose seq is the data of the ping.
+func (c *websocketJSONConn) handlePong(appData string) error {
+	if len(appData) != 8 {
+		return nil
+	}
+	res := protocol.GetPooledMsg()
+	res.SetMessageType(protocol.Response)
+	res.SetHeartbeat(true)
+	res.SetSeq(binary.BigEndian.Uint64([]byte(appData)))
+	data := res.Encode()
+	protocol.FreeMsg(res)
+
+	c.send(data)
+	return nil
+}
+
+// Read reads messages of frames.
+func (c *websocketJSONConn) Read(p []byte) (int, error) {
+	if len(c.cur) == 0 {
+		data, ok := <-c.in
+		if !ok {
+			return 0, c.readErr
+		}
+		c.cur = data
+	}
+	n := copy(p, c.cur)
+	c.cur = c.cur[n:]
+	return n, nil
+}
+
+// Write reassembles messages of p and sends them as frames.
+func (c *websocketJSONConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.wbuf = append(c.wbuf, p...)
+	const headerSize = len(protocol.Header{})
+	for len(c.wbuf) >= headerSize+4 {
+		n := headerSize + 4 + int(binary.BigEndian.Uint32(c.wbuf[headerSize:]))
+		if len(c.wbuf) < n {
+			break
+		}
+
+		msg := protocol.GetPooledMsg()
+		err := msg.Decode(bytes.NewReader(c.wbuf[:n]))
+		if err == nil {
+			err = c.writeMessage(msg)
+		}
+		protocol.FreeMsg(msg)
+		c.wbuf = append(c.wbuf[:0], c.wbuf[n:]...)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// writeMessage sends msg as a frame, or as a ping or a pong if it is a heartbeat.
+func (c *websocketJSONConn) writeMessage(msg *protocol.Message) error {
+	deadline := time.Now().Add(10 * time.Second)
+	if msg.IsHeartbeat() {
+		if msg.MessageType() == protocol.Request { // heartbeat of the server
+			seq := make([]byte, 8)
+			binary.BigEndian.PutUint64(seq, msg.Seq())
+			return c.WriteControl(websocket.PingMessage, seq, deadline)
+		}
+
+		c.mu.Lock()
+		pong, ok := c.pings[msg.Seq()]
+		delete(c.pings, msg.Seq())
+		c.mu.Unlock()
+		if ok {
+			return c.WriteControl(websocket.PongMessage, pong, deadline)
+		}
+		return c.writeFrame(&WebsocketFrame{Seq: msg.Seq(), Heartbeat: true})
+	}
+
+	frame := &WebsocketFrame{
+		Seq:     msg.Seq(),
+		Service: msg.ServicePath,
+		Method:  msg.ServiceMethod,
+		Push:    msg.MessageType() == protocol.Request,
+	}
+	isError := msg.MessageStatusType() == protocol.Error
+	if isError {
+		frame.Error = &WebsocketFrameError{Code: rerrors.Unknown, Message: msg.Metadata[protocol.ServiceError]}
+	}
+	for k, v := range msg.Metadata {
+		switch {
+		case !isError:
+		case k == protocol.ServiceError:
+			continue
+		case k == protocol.ServiceErrorCode:
+			if code, err := strconv.Atoi(v); err == nil {
+				frame.Error.Code = rerrors.Code(code)
+			}
+			continue
+		case strings.HasPrefix(k, protocol.ServiceErrorDetailPrefix):
+			if frame.Error.Details == nil {
+				frame.Error.Details = make(map[string]string)
+			}
+			frame.Error.Details[strings.TrimPrefix(k, protocol.ServiceErrorDetailPrefix)] = v
+			continue
+		}
+		if frame.Meta == nil {
+			frame.Meta = make(map[string]string)
+		}
+		frame.Meta[k] = v
+	}
+	if !isError && len(msg.Payload) > 0 {
+		if json.Valid(msg.Payload) {
+			frame.Payload = append(json.RawMessage(nil), msg.Payload...)
+		} else {
+			frame.Data = append([]byte(nil), msg.Payload...)
+		}
+	}
+	return c.writeFrame(frame)
+}
+
+// Close closes the connection.
+func (c *websocketJSONConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.WebsocketConn.Close()
+}
+
+func (c *websocketJSONConn) writeFrame(frame *WebsocketFrame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return c.WriteText(data)
+}
+
+func (c *websocketJSONConn) writeClose(code int, text string) {
+	c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+}
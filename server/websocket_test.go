@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+	xwebsocket "golang.org/x/net/websocket"
+)
+
+func startWebsocketServer(t *testing.T, opts ...OptionFn) string {
+	s := NewServer(opts...)
+	s.RegisterName("Arith", new(Arith), "")
+	startServer(t, s, "ws")
+	return s.Address().String()
+}
+
+func TestWebsocketCompression(t *testing.T) {
+	addr := startWebsocketServer(t, WithWebsocketCompression(flate.BestSpeed))
+
+	for _, compression := range []bool{true, false} {
+		opt := client.DefaultOption
+		opt.WebsocketCompression = compression
+		c := client.NewClient(opt)
+		if err := c.Connect("ws", addr); err != nil {
+			t.Fatal(err)
+		}
+
+		reply := &Reply{}
+		assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply), "compression: %v", compression)
+		assert.Equal(t, 200, reply.C)
+		c.Close()
+	}
+
+	dialer := &websocket.Dialer{EnableCompression: true}
+	conn, res, err := dialer.Dial("ws://"+addr+share.DefaultRPCPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	assert.True(t, strings.Contains(res.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate"))
+}
+
+func TestWebsocketXNetClient(t *testing.T) {
+	addr := startWebsocketServer(t)
+
+	conn, err := xwebsocket.Dial("ws://"+addr+share.DefaultRPCPath, "", "http://"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	req := protocol.NewMessage()
+	req.SetSerializeType(protocol.JSON)
+	req.SetSeq(1)
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Mul"
+	req.Payload = []byte(`{"A":10,"B":20}`)
+	_, err = conn.Write(req.Encode())
+	assert.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	res, err := protocol.Read(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(1), res.Seq())
+	assert.JSONEq(t, `{"C":200}`, string(res.Payload))
+}
+
+func TestWebsocketMaxMessageSize(t *testing.T) {
+	addr := startWebsocketServer(t, WithWebsocketMaxMessageSize(1024))
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+share.DefaultRPCPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, bytes.Repeat([]byte{0}, 2048)))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	closeErr, ok := err.(*websocket.CloseError)
+	if !ok {
+		t.Fatalf("expect close error but got %v", err)
+	}
+	assert.Equal(t, websocket.CloseMessageTooBig, closeErr.Code)
+}
+
+func TestWebsocketJSON(t *testing.T) {
+	s := NewServer(WithWebsocketJSON(), WithClientIdleTimeout(200*time.Millisecond, 200*time.Millisecond))
+	s.RegisterName("Arith", new(Arith), "")
+	addr := startServer(t, s, "ws")
+
+	dialer := &websocket.Dialer{Subprotocols: []string{WebsocketJSONSubprotocol}}
+	conn, _, err := dialer.Dial("ws://"+addr+share.DefaultRPCPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assert.Equal(t, WebsocketJSONSubprotocol, conn.Subprotocol())
+
+	pongs := make(chan string, 1)
+	conn.SetPongHandler(func(data string) error {
+		pongs <- data
+		return nil
+	})
+	var pings int32
+	conn.SetPingHandler(func(data string) error {
+		atomic.AddInt32(&pings, 1)
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	frames := make(chan map[string]interface{}, 10)
+	go func() {
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				close(frames)
+				return
+			}
+			assert.Equal(t, websocket.TextMessage, mt)
+			var frame map[string]interface{}
+			assert.NoError(t, json.Unmarshal(data, &frame))
+			frames <- frame
+		}
+	}()
+	call := func(frame string) map[string]interface{} {
+		t.Helper()
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+		select {
+		case f := <-frames:
+			return f
+		case <-time.After(time.Second):
+			t.Fatalf("no response of %s", frame)
+			return nil
+		}
+	}
+
+	res := call(`{"seq":1,"service":"Arith","method":"Mul","meta":{"k":"v"},"payload":{"A":10,"B":20}}`)
+	assert.Equal(t, map[string]interface{}{
+		"seq": 1.0, "service": "Arith", "method": "Mul", "payload": map[string]interface{}{"C": 200.0},
+	}, res)
+
+	res = call(`{"seq":2,"service":"Arith","method":"Unknown","payload":{}}`)
+	assert.Equal(t, 2.0, res["seq"])
+	assert.Equal(t, map[string]interface{}{"code": 5.0, "message": "rpcx: can't find method Unknown"}, res["error"])
+	assert.NotContains(t, res, "meta")
+
+	res = call(`{"seq":3,"heartbeat":true}`)
+	assert.Equal(t, map[string]interface{}{"seq": 3.0, "heartbeat": true}, res)
+
+	res = call(`{"seq":4,"service":"Arith"}`)
+	assert.Equal(t, 4.0, res["seq"])
+	assert.Equal(t, 3.0, res["error"].(map[string]interface{})["code"])
+	res = call(`not json`)
+	assert.Equal(t, 3.0, res["error"].(map[string]interface{})["code"])
+
+	// oneway requests have no responses
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"seq":5,"service":"Arith","method":"Mul","oneway":true,"payload":{"A":1,"B":2}}`)))
+
+	// pings of the client are answered by pongs
+	assert.NoError(t, conn.WriteControl(websocket.PingMessage, []byte("hi"), time.Now().Add(time.Second)))
+	select {
+	case data := <-pongs:
+		assert.Equal(t, "hi", data)
+	case <-time.After(time.Second):
+		t.Fatal("no pong")
+	}
+
+	// pushes of the server
+	conns := s.ActiveClientConn()
+	if assert.Len(t, conns, 1) {
+		assert.NoError(t, s.SendMessage(conns[0], "Chat", "Message", map[string]string{"from": "server"}, []byte(`{"text":"hello"}`)))
+		assert.NoError(t, s.SendMessage(conns[0], "Chat", "Raw", nil, []byte{0xff, 0x00}))
+	}
+	push := <-frames
+	assert.Equal(t, true, push["push"])
+	assert.Equal(t, "Chat", push["service"])
+	assert.Equal(t, map[string]interface{}{"text": "hello"}, push["payload"])
+	push = <-frames
+	assert.Equal(t, "/wA=", push["data"])
+
+	// heartbeats of the server are pings, whose pongs keep the connection alive
+	time.Sleep(600 * time.Millisecond)
+	assert.True(t, atomic.LoadInt32(&pings) > 0)
+	res = call(`{"seq":6,"service":"Arith","method":"Mul","payload":{"A":2,"B":3}}`)
+	assert.Equal(t, map[string]interface{}{"C": 6.0}, res["payload"])
+
+	// binary clients are served on the same endpoint
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("ws", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply))
+	assert.Equal(t, 200, reply.C)
+
+	// binary frames are not allowed by the json subprotocol
+	assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte{1}))
+	for range frames {
+	}
+}
+
+type headerService struct{}
+
+func (headerService) Get(ctx context.Context, args *Args, reply *Reply) error {
+	ctx.Value(share.ResMetaDataKey).(map[string]string)["id"] = RequestID(ctx) + "," + share.HeaderValue(ctx, "x-b3-traceid")
+	return nil
+}
+
+func TestWebsocketJSONGatewayHeaders(t *testing.T) {
+	s := NewServer(WithWebsocketJSON(), WithGatewayHeaders("X-Request-Id", "X-B3-TraceId"))
+	s.RegisterName("Headers", headerService{}, "")
+	startServer(t, s, "ws")
+
+	dialer := &websocket.Dialer{Subprotocols: []string{WebsocketJSONSubprotocol}}
+	header := http.Header{"X-Request-Id": {"req-1"}, "X-B3-Traceid": {"463ac35c9f6413ad48485a3953bb6124"}}
+	conn, res, err := dialer.Dial("ws://"+s.Address().String()+share.DefaultRPCPath, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assert.Equal(t, "req-1", res.Header.Get("X-Request-Id"))
+
+	// headers of the upgrade request are metadata of every request, unless frames set them
+	for _, c := range []struct{ frame, id string }{
+		{`{"seq":1,"service":"Headers","method":"Get","payload":{}}`, "req-1,463ac35c9f6413ad48485a3953bb6124"},
+		{`{"seq":2,"service":"Headers","method":"Get","meta":{"x-request-id":"req-2"},"payload":{}}`, "req-1,463ac35c9f6413ad48485a3953bb6124"},
+		{`{"seq":3,"service":"Headers","method":"Get","meta":{"x-rpcx-request-id":"req-3"},"payload":{}}`, "req-3,463ac35c9f6413ad48485a3953bb6124"},
+	} {
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(c.frame)))
+		var frame WebsocketFrame
+		assert.NoError(t, conn.ReadJSON(&frame))
+		assert.Nil(t, frame.Error)
+		assert.Equal(t, c.id, frame.Meta["id"], c.frame)
+	}
+}
+
+func TestWebsocketJSONDisabled(t *testing.T) {
+	addr := startWebsocketServer(t)
+
+	dialer := &websocket.Dialer{Subprotocols: []string{WebsocketJSONSubprotocol}}
+	conn, _, err := dialer.Dial("ws://"+addr+share.DefaultRPCPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assert.Equal(t, "", conn.Subprotocol())
+}
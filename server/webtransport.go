@@ -0,0 +1,184 @@
+// +build quic
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/share"
+	"github.com/smallnest/rpcx/util"
+)
+
+const (
+	// webTransportStreamTimeout is how long streams which arrive before their sessions are established wait for them.
+	webTransportStreamTimeout = 5 * time.Second
+
+	h3StreamCreationError = 0x103
+	h3RequestRejected     = 0x10b
+)
+
+// WithWebTransport serves WebTransport sessions over HTTP/3 on the QUIC listener, so browsers and edge runtimes
+// can call services by the WebTransport API. Sessions are established by the extended CONNECT to path,
+// which is share.DefaultRPCPath if empty, and each bidirectional stream of a session is a connection,
+// like streams of QUIC sessions. Sessions of the "rpcx" ALPN are still served on the same port.
+//
+// The TLS config, the QUIC config of WithQUICConfig and QUICSessions apply to WebTransport sessions too.
+// Datagrams of WebTransport are not supported. See _documents/webtransport.md for the mapping of frames.
+func WithWebTransport(path string) OptionFn {
+	return func(s *Server) {
+		if path == "" {
+			path = share.DefaultRPCPath
+		}
+		s.options["WebTransportPath"] = path
+	}
+}
+
+// webTransportTLSConfig returns a copy of config which negotiates HTTP/3 too.
+func webTransportTLSConfig(config *tls.Config) *tls.Config {
+	for _, p := range config.NextProtos {
+		if p == util.WebTransportALPN {
+			return config
+		}
+	}
+	config = config.Clone()
+	config.NextProtos = append(config.NextProtos, util.WebTransportALPN)
+	return config
+}
+
+// webTransportSession is the state of the WebTransport session of a QUIC session, which has one session at most.
+type webTransportSession struct {
+	once  sync.Once
+	ready chan struct{} // closed when the session is established
+	id    uint64        // the ID of the CONNECT stream
+}
+
+func (wt *webTransportSession) establish(id uint64) bool {
+	established := false
+	wt.once.Do(func() {
+		wt.id = id
+		established = true
+		close(wt.ready)
+	})
+	return established
+}
+
+// acceptWebTransport serves a QUIC session of HTTP/3. Control streams are opened and read as HTTP/3 requires,
+// the CONNECT stream establishes the WebTransport session, and its bidirectional streams are passed to Accept.
+// The QUIC session is closed when the CONNECT stream is closed.
+func (l *quicListener) acceptWebTransport(sess *quicSession) {
+	defer sess.closeStreams()
+
+	ctl, err := sess.OpenUniStream()
+	if err != nil {
+		log.Debugf("rpcx: failed to open the control stream of %s: %v", sess.RemoteAddr().String(), err)
+		return
+	}
+	if err := util.WriteWebTransportSettings(ctl); err != nil {
+		log.Debugf("rpcx: failed to send settings to %s: %v", sess.RemoteAddr().String(), err)
+		return
+	}
+	go discardUniStreams(sess)
+
+	wt := &webTransportSession{ready: make(chan struct{})}
+	for {
+		stream, err := sess.AcceptStream(sess.Context())
+		if err != nil {
+			log.Debugf("rpcx: WebTransport session %s is closed: %v", sess.RemoteAddr().String(), err)
+			return
+		}
+		go l.serveWebTransportStream(sess, wt, stream)
+	}
+}
+
+// discardUniStreams discards unidirectional streams, which are control streams, QPACK streams without
+// the dynamic table, and unidirectional WebTransport streams that are not supported.
+func discardUniStreams(sess *quicSession) {
+	for {
+		stream, err := sess.AcceptUniStream(sess.Context())
+		if err != nil {
+			return
+		}
+		go io.Copy(ioutil.Discard, stream)
+	}
+}
+
+// serveWebTransportStream serves a bidirectional stream by its first varint,
+// which is the type of a HEADERS frame of the CONNECT stream, or the signal of WebTransport streams.
+func (l *quicListener) serveWebTransportStream(sess *quicSession, wt *webTransportSession, stream quic.Stream) {
+	typ, err := util.ReadVarint(stream)
+	if err != nil {
+		rejectStream(stream, h3StreamCreationError)
+		return
+	}
+
+	switch typ {
+	case util.H3FrameHeaders:
+		l.serveWebTransportConnect(sess, wt, stream)
+	case util.WebTransportBidiStream:
+		id, err := util.ReadVarint(stream)
+		if err != nil {
+			rejectStream(stream, h3StreamCreationError)
+			return
+		}
+		select {
+		case <-wt.ready:
+		case <-sess.Context().Done():
+			rejectStream(stream, h3RequestRejected)
+			return
+		case <-time.After(webTransportStreamTimeout):
+		}
+		select {
+		case <-wt.ready:
+			if wt.id == id {
+				l.serveStream(sess, stream)
+				return
+			}
+		default:
+		}
+		rejectStream(stream, h3RequestRejected)
+	default:
+		rejectStream(stream, h3StreamCreationError)
+	}
+}
+
+// serveWebTransportConnect responds the extended CONNECT of stream, whose frame type has been read,
+// and closes the QUIC session after the CONNECT stream of the established session is closed.
+func (l *quicListener) serveWebTransportConnect(sess *quicSession, wt *webTransportSession, stream quic.Stream) {
+	r := io.MultiReader(bytes.NewReader(util.AppendVarint(nil, util.H3FrameHeaders)), stream)
+	fields, err := util.ReadH3Headers(r)
+	if err != nil {
+		log.Warnf("rpcx: invalid WebTransport request of %s: %v", sess.RemoteAddr().String(), err)
+		rejectStream(stream, h3RequestRejected)
+		return
+	}
+
+	status := "200"
+	switch {
+	case util.H3FieldValue(fields, ":method") != "CONNECT" || util.H3FieldValue(fields, ":protocol") != "webtransport":
+		status = "400"
+	case !util.IsWebTransportConnect(fields, l.wtPath):
+		status = "404"
+	case !wt.establish(uint64(stream.StreamID())):
+		status = "429" // one session per QUIC session
+	}
+	if err := util.WriteWebTransportResponse(stream, status); err != nil || status != "200" {
+		stream.Close()
+		return
+	}
+
+	// capsules of the CONNECT stream are not used, and the session ends when the stream is closed
+	io.Copy(ioutil.Discard, stream)
+	sess.CloseWithError(0, "webtransport session closed")
+}
+
+func rejectStream(stream quic.Stream, code quic.StreamErrorCode) {
+	stream.CancelRead(code)
+	stream.CancelWrite(code)
+}
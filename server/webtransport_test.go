@@ -0,0 +1,72 @@
+// +build quic
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebTransport(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile, 1, time.Now())
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}), WithWebTransport("/rpcx"))
+	s.RegisterName("Arith", new(Arith), "")
+	addr := startServer(t, s, "quic")
+
+	option := client.DefaultOption
+	option.RPCPath = "/rpcx"
+	wt := client.NewClient(option)
+	if err := wt.Connect("wt", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer wt.Close()
+	for i := 1; i <= 3; i++ {
+		reply := &Reply{}
+		assert.NoError(t, wt.Call(context.Background(), "Arith", "Mul", &Args{A: i, B: 20}, reply))
+		assert.Equal(t, i*20, reply.C)
+	}
+
+	// QUIC sessions of rpcx are still served on the same port
+	q := client.NewClient(client.DefaultOption)
+	if err := q.Connect("quic", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	reply := &Reply{}
+	assert.NoError(t, q.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, reply))
+	assert.Equal(t, 6, reply.C)
+
+	stats := s.QUICSessions()
+	if assert.Len(t, stats, 2) {
+		assert.NotEqual(t, stats[0].WebTransport, stats[1].WebTransport)
+		for _, st := range stats {
+			assert.Equal(t, 1, st.Streams)
+			assert.NotZero(t, st.BytesRead)
+		}
+	}
+
+	// sessions of other paths are not found
+	option.RPCPath = "/other"
+	err = client.NewClient(option).Connect("wt", addr)
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "status 404"), err.Error())
+	}
+
+	// streams are closed with their sessions
+	wt.Close()
+	assert.Eventually(t, func() bool { return len(s.QUICSessions()) == 1 }, 2*time.Second, 20*time.Millisecond)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/smallnest/rpcx/util"
+)
+
+func init() {
+	makeListeners[util.PipeNetwork] = pipeMakeListener
+}
+
+// WithPipeConfig configures listeners of the winpipe network, which serves Windows named pipes.
+// Their addresses are names of pipes such as "agent", which is \\.\pipe\agent, or paths of pipes.
+// Connections of pipes are not wrapped by the TLS config of the server, and winpipe listeners fail on other platforms.
+func WithPipeConfig(config util.PipeConfig) OptionFn {
+	return func(s *Server) {
+		s.pipeConfig = config
+	}
+}
+
+// WithPipeSecurityDescriptor sets the SDDL of the security descriptor of Windows named pipes,
+// which selects who may connect, see util.PipeConfig.
+func WithPipeSecurityDescriptor(sddl string) OptionFn {
+	return func(s *Server) {
+		s.pipeConfig.SecurityDescriptor = sddl
+	}
+}
+
+func pipeMakeListener(s *Server, address string) (net.Listener, error) {
+	return util.ListenPipe(util.PipePath(address), &s.pipeConfig)
+}
+
+// PipeClientIdentity returns the Windows identity of the client of the request handled with ctx,
+// if it is connected by a Windows named pipe.
+func PipeClientIdentity(ctx context.Context) (util.PipeIdentity, bool) {
+	conn, _ := ctx.Value(RemoteConnContextKey).(net.Conn)
+	pc, ok := netConn(conn).(interface {
+		PipeIdentity() (util.PipeIdentity, error)
+	})
+	if !ok {
+		return util.PipeIdentity{}, false
+	}
+	id, err := pc.PipeIdentity()
+	if err != nil {
+		logger.Warn(ctx, "rpcx: failed to get the identity of the pipe client", "error", err)
+		return util.PipeIdentity{}, false
+	}
+	return id, true
+}
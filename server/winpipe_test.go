@@ -0,0 +1,18 @@
+// +build !windows
+
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/smallnest/rpcx/util"
+)
+
+func TestPipeUnsupported(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	if err := s.Serve("winpipe", "agent"); !errors.Is(err, util.ErrPipeUnsupported) {
+		t.Fatalf("expect ErrPipeUnsupported but got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+// +build windows
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/stretchr/testify/assert"
+)
+
+type pipeIdentityService struct{}
+
+func (pipeIdentityService) Whoami(ctx context.Context, args *Args, reply *string) error {
+	id, ok := PipeClientIdentity(ctx)
+	if !ok {
+		return fmt.Errorf("no pipe identity")
+	}
+	*reply = id.SID
+	return nil
+}
+
+func startPipeServer(t *testing.T, options ...OptionFn) (*Server, string) {
+	name := fmt.Sprintf("rpcx-test-%d", time.Now().UnixNano())
+	s := NewServer(options...)
+	s.RegisterName("Arith", new(Arith), "")
+	s.RegisterName("Identity", pipeIdentityService{}, "")
+	go s.Serve("winpipe", name)
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s, name
+}
+
+func TestPipeServer(t *testing.T) {
+	s, name := startPipeServer(t, WithIdleTimeout(500*time.Millisecond))
+	defer s.Close()
+	assert.Equal(t, `\\.\pipe\`+name, s.Address().String())
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("winpipe", name); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	reply := &Reply{}
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply))
+	assert.Equal(t, 200, reply.C)
+
+	var sid string
+	assert.NoError(t, c.Call(context.Background(), "Identity", "Whoami", &Args{}, &sid))
+	assert.NotEmpty(t, sid)
+
+	// idle connections are closed by the emulated read deadline
+	time.Sleep(time.Second)
+	assert.Error(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, reply))
+}
+
+func TestPipeConcurrentClients(t *testing.T) {
+	s, name := startPipeServer(t)
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := client.NewClient(client.DefaultOption)
+			if err := c.Connect("winpipe", name); err != nil {
+				t.Error(err)
+				return
+			}
+			defer c.Close()
+			for j := 0; j < 50; j++ {
+				reply := &Reply{}
+				if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: i, B: j}, reply); err != nil || reply.C != i*j {
+					t.Errorf("unexpected reply %d: %v", reply.C, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestPipeShutdown(t *testing.T) {
+	s, name := startPipeServer(t)
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("winpipe", name); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	assert.NoError(t, c.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, &Reply{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+
+	// the pipe is removed with its listener
+	option := client.DefaultOption
+	option.ConnectTimeout = 100 * time.Millisecond
+	assert.Error(t, client.NewClient(option).Connect("winpipe", name))
+}
+
+func TestPipeSecurityDescriptor(t *testing.T) {
+	// nobody may connect
+	s, name := startPipeServer(t, WithPipeSecurityDescriptor("D:P"))
+	defer s.Close()
+	assert.Error(t, client.NewClient(client.DefaultOption).Connect("winpipe", name))
+}
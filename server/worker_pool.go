@@ -0,0 +1,239 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// RejectReasonBusy is the reason passed to RequestRejectedPlugin when the queue of the worker pool is full.
+const RejectReasonBusy = "busy"
+
+// ErrServerBusy is returned to clients when the queue of the worker pool is full.
+// It has the Unavailable code so clients can retry it, preferably on another server.
+var ErrServerBusy = rerrors.New(rerrors.Unavailable, "rpcx: server is busy")
+
+// WithWorkerPool handles requests by a fixed number of worker goroutines instead of a goroutine per request.
+// Requests are still read by a goroutine per connection and then queued for workers.
+// If the queue of queueDepth requests is full, ErrServerBusy is returned immediately.
+// Heartbeats bypass the queue.
+//
+// Like the goroutine per request mode, requests of a connection are handled concurrently,
+// so responses may be written in a different order than requests were read.
+// The size can be changed at runtime by SetWorkerPoolSize or the admin API.
+func WithWorkerPool(size int, queueDepth int) OptionFn {
+	return func(s *Server) {
+		if size < 1 {
+			size = 1
+		}
+		s.workerPool = newWorkerPool(size, queueDepth, s.doneChan)
+		s.runtime().WorkerPoolSize = size
+	}
+}
+
+// DefaultPriorityAging is how long a queued request waits before it is promoted to the next higher priority
+// by WithPriorityScheduling.
+const DefaultPriorityAging = 500 * time.Millisecond
+
+// WithPriorityScheduling dispatches requests queued by WithWorkerPool by their priorities, see protocol.Priority,
+// instead of in the order they are queued. Requests of the same priority are dispatched in the order they are queued,
+// and requests queued for aging are promoted to the next higher priority so that they are not starved.
+// Zero means DefaultPriorityAging. Health checks have the highest priority, and heartbeats bypass the queue.
+//
+// Requests are reordered only if they are queued because all workers are busy.
+// It does nothing without WithWorkerPool.
+func WithPriorityScheduling(aging time.Duration) OptionFn {
+	return func(s *Server) {
+		if aging <= 0 {
+			aging = DefaultPriorityAging
+		}
+		s.priorityAging = aging
+	}
+}
+
+// SetWorkerPoolSize changes the number of workers at runtime, see ApplySettings.
+// Extra workers exit after finishing their current requests.
+// It does nothing if the server does not use a worker pool.
+func (s *Server) SetWorkerPoolSize(size int) {
+	if s.workerPool == nil {
+		return
+	}
+	if size < 1 {
+		size = 1
+	}
+	s.UpdateSettings(func(settings *RuntimeSettings) { settings.WorkerPoolSize = size })
+}
+
+// workerPool runs queued tasks by a fixed number of goroutines.
+type workerPool struct {
+	queue chan func()
+	quit  chan struct{}
+	done  <-chan struct{}
+
+	mu   sync.Mutex
+	size int // target number of workers
+
+	// bands of tasks by priority, the first one being dispatched first, if tasks are prioritized.
+	// Then queue has a dispatch of the most urgent task for each task in bands.
+	bands [priorityBands][]queuedTask
+	aging time.Duration
+}
+
+// priorityBands is the number of priorities, see priorityBand.
+const priorityBands = 4
+
+type queuedTask struct {
+	task   func()
+	queued time.Time
+}
+
+func newWorkerPool(size, queueDepth int, done <-chan struct{}) *workerPool {
+	p := &workerPool{
+		queue: make(chan func(), queueDepth),
+		quit:  make(chan struct{}),
+		done:  done,
+	}
+	p.resize(size)
+	return p
+}
+
+// prioritize dispatches tasks by their priorities, promoting tasks queued for aging. It is called before tasks are submitted.
+func (p *workerPool) prioritize(aging time.Duration) {
+	p.aging = aging
+}
+
+// submit queues task of priority. It returns false if the queue is full.
+func (p *workerPool) submit(task func(), priority protocol.Priority) bool {
+	if p.aging == 0 {
+		select {
+		case p.queue <- task:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// the dispatch is queued with the task so that workers pick it up only after the task is in its band
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case p.queue <- p.dispatch:
+		band := priorityBand(priority)
+		p.bands[band] = append(p.bands[band], queuedTask{task: task, queued: time.Now()})
+		return true
+	default:
+		return false
+	}
+}
+
+// dispatch runs the most urgent task, which is the first task of the band with the highest priority after aging,
+// or the one queued first of such bands.
+func (p *workerPool) dispatch() {
+	now := time.Now()
+	p.mu.Lock()
+	best, bestBand := -1, 0
+	for i := range p.bands {
+		if len(p.bands[i]) == 0 {
+			continue
+		}
+		band := i - int(now.Sub(p.bands[i][0].queued)/p.aging)
+		if band < 0 {
+			band = 0
+		}
+		if best < 0 || band < bestBand || (band == bestBand && p.bands[i][0].queued.Before(p.bands[best][0].queued)) {
+			best, bestBand = i, band
+		}
+	}
+	if best < 0 { // not possible, there is a dispatch for each task
+		p.mu.Unlock()
+		return
+	}
+	t := p.bands[best][0]
+	p.bands[best][0] = queuedTask{}
+	p.bands[best] = p.bands[best][1:]
+	p.mu.Unlock()
+
+	t.task()
+}
+
+// priorityBand returns the band of tasks of priority, 0 being dispatched first.
+func priorityBand(priority protocol.Priority) int {
+	switch priority {
+	case protocol.PriorityCritical:
+		return 0
+	case protocol.PriorityHigh:
+		return 1
+	case protocol.PriorityLow:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// requestPriority returns the priority of req in the queue of the worker pool.
+func requestPriority(req *protocol.Message) protocol.Priority {
+	if req.ServicePath == share.HealthServiceName {
+		return protocol.PriorityCritical
+	}
+	return req.Priority()
+}
+
+// bandDepths returns the number of queued tasks of each priority, or nil if tasks are not prioritized.
+func (p *workerPool) bandDepths() map[string]int {
+	if p.aging == 0 {
+		return nil
+	}
+	depths := make(map[string]int, priorityBands)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, priority := range []protocol.Priority{protocol.PriorityCritical, protocol.PriorityHigh, protocol.PriorityNormal, protocol.PriorityLow} {
+		depths[priority.String()] = len(p.bands[priorityBand(priority)])
+	}
+	return depths
+}
+
+func (p *workerPool) resize(size int) {
+	if size < 1 {
+		size = 1
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for ; p.size < size; p.size++ {
+		go p.work()
+	}
+	for ; p.size > size; p.size-- {
+		// busy workers pick it up when they finish their tasks
+		go func() {
+			select {
+			case p.quit <- struct{}{}:
+			case <-p.done:
+			}
+		}()
+	}
+}
+
+func (p *workerPool) work() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.quit:
+			return
+		case task := <-p.queue:
+			task()
+		}
+	}
+}
+
+// stats returns the target number of workers, the number of queued tasks and the queue depth.
+func (p *workerPool) stats() (size, queued, depth int) {
+	p.mu.Lock()
+	size = p.size
+	p.mu.Unlock()
+	return size, len(p.queue), cap(p.queue)
+}
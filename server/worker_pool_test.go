@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+type gateService struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g *gateService) Wait(ctx context.Context, args *Args, reply *Reply) error {
+	g.started <- struct{}{}
+	<-g.release
+	reply.C = args.A
+	return nil
+}
+
+func TestWorkerPool(t *testing.T) {
+	gate := &gateService{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s := NewServer(WithWorkerPool(1, 1))
+	s.RegisterName("Gate", gate, "")
+	addr := startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", addr); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	// the only worker is blocked by the first call and the second call fills the queue
+	call1 := c.Go(context.Background(), "Gate", "Wait", &Args{A: 1}, &Reply{}, nil)
+	<-gate.started
+	call2 := c.Go(context.Background(), "Gate", "Wait", &Args{A: 2}, &Reply{}, nil)
+	time.Sleep(100 * time.Millisecond)
+
+	err := c.Call(context.Background(), "Gate", "Wait", &Args{A: 3}, &Reply{})
+	if err == nil || err.Error() != ErrServerBusy.Error() {
+		t.Fatalf("expect busy error but got %v", err)
+	}
+
+	// heartbeats bypass the queue
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	hb := protocol.NewMessage()
+	hb.SetHeartbeat(true)
+	conn.Write(hb.Encode())
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	res, err := protocol.Read(bufio.NewReader(conn))
+	if assert.NoError(t, err) {
+		assert.True(t, res.IsHeartbeat())
+	}
+
+	close(gate.release)
+	for _, call := range []*client.Call{call1, call2} {
+		select {
+		case <-call.Done:
+			assert.NoError(t, call.Error)
+		case <-time.After(time.Second):
+			t.Fatalf("queued call is not handled")
+		}
+	}
+
+	h := s.AdminHandler()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/workers", strings.NewReader(`{"size":4}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"size":4,"queued":0,"queue_depth":1}`, w.Body.String())
+}
+
+// benchConns is the number of connections of the burst benchmarks.
+// Raise it, for example to 50000, together with the open files limit to reproduce a large burst.
+const benchConns = 200
+
+func benchmarkBurst(b *testing.B, options ...OptionFn) {
+	s := NewServer(options...)
+	s.RegisterName("Arith", new(Arith), "")
+	startServer(b, s, "tcp")
+
+	clients := make([]*client.Client, benchConns)
+	for i := range clients {
+		clients[i] = client.NewClient(client.DefaultOption)
+		if err := clients[i].Connect("tcp", s.Address().String()); err != nil {
+			b.Fatalf("failed to connect: %v", err)
+		}
+		defer clients[i].Close()
+	}
+	// warm up connections so that the burst measures requests only
+	for _, c := range clients {
+		if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 1, B: 2}, &Reply{}); err != nil {
+			b.Fatalf("failed to call: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	latencies := make([]time.Duration, 0, b.N)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var wg sync.WaitGroup
+	requests := make(chan struct{}, b.N)
+	for i := 0; i < b.N; i++ {
+		requests <- struct{}{}
+	}
+	close(requests)
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *client.Client) {
+			defer wg.Done()
+			for range requests {
+				start := time.Now()
+				c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, &Reply{})
+				d := time.Since(start)
+				mu.Lock()
+				latencies = append(latencies, d)
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	b.StopTimer()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-us")
+}
+
+func BenchmarkBurst_GoroutinePerRequest(b *testing.B) {
+	benchmarkBurst(b)
+}
+
+func BenchmarkBurst_WorkerPool(b *testing.B) {
+	benchmarkBurst(b, WithWorkerPool(64, 100000))
+}
+
+func TestPriorityScheduling(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	p := newWorkerPool(1, 10, done)
+	p.prioritize(time.Hour)
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func() {
+		return func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	assert.True(t, p.submit(func() { close(started); <-release }, protocol.PriorityLow))
+	<-started
+
+	// the only worker is busy, so requests are queued and dispatched by their priorities
+	for _, task := range []struct {
+		name     string
+		priority protocol.Priority
+	}{
+		{"low1", protocol.PriorityLow}, {"normal1", protocol.PriorityNormal}, {"high1", protocol.PriorityHigh},
+		{"critical1", protocol.PriorityCritical}, {"low2", protocol.PriorityLow}, {"high2", protocol.PriorityHigh},
+	} {
+		assert.True(t, p.submit(record(task.name), task.priority))
+	}
+	assert.Equal(t, map[string]int{"critical": 1, "high": 2, "normal": 1, "low": 2}, p.bandDepths())
+	wait := func() {
+		for i := 0; i < 100 && len(p.queue) > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	wait()
+	mu.Lock()
+	assert.Equal(t, []string{"critical1", "high1", "high2", "normal1", "low1", "low2"}, order)
+	order = nil
+	mu.Unlock()
+
+	// requests are promoted by aging so that they are not starved
+	p = newWorkerPool(1, 10, done)
+	p.prioritize(20 * time.Millisecond)
+	release, started = make(chan struct{}), make(chan struct{})
+	assert.True(t, p.submit(func() { close(started); <-release }, protocol.PriorityLow))
+	<-started
+	assert.True(t, p.submit(record("low"), protocol.PriorityLow))
+	time.Sleep(70 * time.Millisecond)
+	assert.True(t, p.submit(record("critical"), protocol.PriorityCritical))
+	assert.True(t, p.submit(record("normal"), protocol.PriorityNormal))
+	close(release)
+	wait()
+	mu.Lock()
+	assert.Equal(t, []string{"low", "critical", "normal"}, order)
+	mu.Unlock()
+}
+
+type sleepService struct{}
+
+func (s *sleepService) Sleep(ctx context.Context, args *Args, reply *Reply) error {
+	time.Sleep(time.Duration(args.A) * time.Millisecond)
+	return nil
+}
+
+func TestPrioritySchedulingLatency(t *testing.T) {
+	s := NewServer(WithWorkerPool(2, 200), WithPriorityScheduling(0))
+	s.RegisterName("Sleep", new(sleepService), "")
+	startServer(t, s, "tcp")
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	high := client.WithPriority(context.Background(), protocol.PriorityHigh)
+	start := time.Now()
+	assert.NoError(t, c.Call(high, "Sleep", "Sleep", &Args{A: 20}, &Reply{}))
+	baseline := time.Since(start)
+
+	// the pool is saturated by low priority calls, which take 1s
+	low := client.WithPriority(context.Background(), protocol.PriorityLow)
+	calls := make([]*client.Call, 100)
+	for i := range calls {
+		calls[i] = c.Go(low, "Sleep", "Sleep", &Args{A: 20}, &Reply{}, nil)
+	}
+	for i := 0; i < 100 && s.Stats().QueueDepth < 50; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := s.Stats()
+	assert.True(t, stats.QueueDepthByPriority["low"] >= 50, "unexpected stats %v", stats.QueueDepthByPriority)
+
+	// but high priority calls wait only for a worker
+	start = time.Now()
+	assert.NoError(t, c.Call(high, "Sleep", "Sleep", &Args{A: 20}, &Reply{}))
+	latency := time.Since(start)
+	assert.True(t, latency < baseline+100*time.Millisecond, "latency %v of the baseline %v", latency, baseline)
+	for _, call := range calls {
+		<-call.Done
+		assert.NoError(t, call.Error)
+	}
+}
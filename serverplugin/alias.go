@@ -2,33 +2,49 @@ package serverplugin
 
 import (
 	"context"
+	"strings"
+	"sync"
 
 	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
 )
 
-var aliasAppliedKey = "__aliasAppliedKey"
+// RewrittenFromKey is the response metadata key that records the original servicePath.serviceMethod of a rewritten request.
+const RewrittenFromKey = "x-rewritten-from"
+
+type rewrittenFromContextKey struct{}
 
 type aliasPair struct {
 	servicePath, serviceMethod string
 }
 
-//AliasPlugin can be used to set aliases for services
+type rewriteRule struct {
+	from, to string
+	wildcard bool // from and to end with "*"
+}
+
+// AliasPlugin can be used to set aliases for services.
+// It rewrites servicePath and serviceMethod of requests before they are routed.
+// Rules can be exact ("Arith.Mul" -> "Math.Mul") or wildcard ("Arith.*" -> "Math.*")
+// and can be updated at runtime.
 type AliasPlugin struct {
-	Aliases          map[string]*aliasPair
+	// AfterAuth rewrites requests after AuthFunc and PostReadRequest plugins.
+	// By default requests are rewritten in PostReadRequest, so that AuthFunc and
+	// plugins added after this plugin see the new servicePath and serviceMethod.
+	AfterAuth bool
+
+	// Aliases are the aliases set by Alias, by aliasServicePath.aliasServiceMethod, which are exact rules too.
+	//
+	// Deprecated: use AddRule and RemoveRule.
+	Aliases map[string]*aliasPair
+	// ReseverseAliases are the aliases set by Alias, by servicePath.serviceMethod.
+	//
+	// Deprecated: responses are restored without them.
 	ReseverseAliases map[string]*aliasPair
-}
 
-// Alias sets a alias for the serviceMethod.
-// For example Alias("anewpath&method", "Arith.mul")
-func (p *AliasPlugin) Alias(aliasServicePath, aliasServiceMethod string, servicePath, serviceMethod string) {
-	p.Aliases[aliasServicePath+"."+aliasServiceMethod] = &aliasPair{
-		servicePath:   servicePath,
-		serviceMethod: serviceMethod,
-	}
-	p.ReseverseAliases[servicePath+"."+serviceMethod] = &aliasPair{
-		servicePath:   aliasServicePath,
-		serviceMethod: aliasServiceMethod,
-	}
+	mu    sync.RWMutex
+	exact map[string]string
+	wild  []rewriteRule
 }
 
 // NewAliasPlugin creates a new NewAliasPlugin
@@ -36,47 +52,175 @@ func NewAliasPlugin() *AliasPlugin {
 	return &AliasPlugin{
 		Aliases:          make(map[string]*aliasPair),
 		ReseverseAliases: make(map[string]*aliasPair),
+		exact:            make(map[string]string),
 	}
 }
 
-// PostReadRequest converts the alias of this service.
-func (p *AliasPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
-	var sp = r.ServicePath
-	var sm = r.ServiceMethod
+// Alias sets a alias for the serviceMethod.
+// For example Alias("anewpath", "mul", "Arith", "Mul")
+func (p *AliasPlugin) Alias(aliasServicePath, aliasServiceMethod string, servicePath, serviceMethod string) {
+	p.AddRule(aliasServicePath+"."+aliasServiceMethod, servicePath+"."+serviceMethod)
 
-	k := sp + "." + sm
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if p.Aliases != nil {
-		if pm := p.Aliases[k]; pm != nil {
-			r.ServicePath = pm.servicePath
-			r.ServiceMethod = pm.serviceMethod
-			if r.Metadata == nil {
-				r.Metadata = make(map[string]string)
+		p.Aliases[aliasServicePath+"."+aliasServiceMethod] = &aliasPair{servicePath: servicePath, serviceMethod: serviceMethod}
+	}
+	if p.ReseverseAliases != nil {
+		p.ReseverseAliases[servicePath+"."+serviceMethod] = &aliasPair{servicePath: aliasServicePath, serviceMethod: aliasServiceMethod}
+	}
+}
+
+// AddRule adds or replaces a rewrite rule. from and to are in format of servicePath.serviceMethod.
+// If from ends with "*", it matches all methods with this prefix and the matched suffix replaces the trailing "*" of to.
+// For example AddRule("Arith.*", "Math.*") rewrites Arith.Mul to Math.Mul.
+func (p *AliasPlugin) AddRule(from, to string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !strings.HasSuffix(from, "*") {
+		p.exact[from] = to
+		return
+	}
+
+	rule := rewriteRule{from: strings.TrimSuffix(from, "*"), to: strings.TrimSuffix(to, "*"), wildcard: strings.HasSuffix(to, "*")}
+	for i, r := range p.wild {
+		if r.from == rule.from {
+			p.wild[i] = rule
+			return
+		}
+	}
+	p.wild = append(p.wild, rule)
+	// longest prefix first
+	for i := len(p.wild) - 1; i > 0 && len(p.wild[i].from) > len(p.wild[i-1].from); i-- {
+		p.wild[i], p.wild[i-1] = p.wild[i-1], p.wild[i]
+	}
+}
+
+// RemoveRule removes the rule added by AddRule or Alias.
+func (p *AliasPlugin) RemoveRule(from string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !strings.HasSuffix(from, "*") {
+		delete(p.exact, from)
+		if pm := p.Aliases[from]; pm != nil {
+			delete(p.Aliases, from)
+			delete(p.ReseverseAliases, pm.servicePath+"."+pm.serviceMethod)
+		}
+		return
+	}
+
+	prefix := strings.TrimSuffix(from, "*")
+	for i, r := range p.wild {
+		if r.from == prefix {
+			p.wild = append(p.wild[:i], p.wild[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetRules replaces all rules.
+func (p *AliasPlugin) SetRules(rules map[string]string) {
+	p.mu.Lock()
+	p.exact = make(map[string]string)
+	p.wild = nil
+	for k := range p.Aliases {
+		delete(p.Aliases, k)
+	}
+	for k := range p.ReseverseAliases {
+		delete(p.ReseverseAliases, k)
+	}
+	p.mu.Unlock()
+
+	for from, to := range rules {
+		p.AddRule(from, to)
+	}
+}
+
+// Rewrite returns the new servicePath and serviceMethod. ok is false if no rule matches.
+// Exact rules, including entries of Aliases, take precedence over wildcard rules
+// and longer wildcard prefixes take precedence over shorter ones.
+func (p *AliasPlugin) Rewrite(servicePath, serviceMethod string) (newServicePath, newServiceMethod string, ok bool) {
+	k := servicePath + "." + serviceMethod
+
+	p.mu.RLock()
+	to, ok := p.exact[k]
+	if pm := p.Aliases[k]; !ok && pm != nil {
+		to, ok = pm.servicePath+"."+pm.serviceMethod, true
+	}
+	if !ok {
+		for _, r := range p.wild {
+			if strings.HasPrefix(k, r.from) {
+				to = r.to
+				if r.wildcard {
+					to += k[len(r.from):]
+				}
+				ok = true
+				break
 			}
-			r.Metadata[aliasAppliedKey] = "true"
 		}
 	}
+	p.mu.RUnlock()
+
+	if !ok {
+		return servicePath, serviceMethod, false
+	}
+
+	i := strings.LastIndex(to, ".")
+	if i < 0 {
+		return servicePath, serviceMethod, false
+	}
+	return to[:i], to[i+1:], true
+}
+
+func (p *AliasPlugin) rewrite(ctx context.Context, r *protocol.Message) {
+	if r == nil || r.IsHeartbeat() {
+		return
+	}
+	sp, sm, ok := p.Rewrite(r.ServicePath, r.ServiceMethod)
+	if !ok {
+		return
+	}
+	if sctx, ok := ctx.(*share.Context); ok {
+		sctx.SetValue(rewrittenFromContextKey{}, [2]string{r.ServicePath, r.ServiceMethod})
+	}
+	r.ServicePath = sp
+	r.ServiceMethod = sm
+}
+
+// PostReadRequest converts the alias of this service.
+func (p *AliasPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if e == nil && !p.AfterAuth {
+		p.rewrite(ctx, r)
+	}
 	return nil
 }
 
-// PreWriteResponse restore servicePath and serviceMethod.
-func (p *AliasPlugin) PreWriteResponse(ctx context.Context, r *protocol.Message, res *protocol.Message) error {
-	if r.Metadata[aliasAppliedKey] != "true" {
+// PreHandleRequest converts the alias of this service if AfterAuth is set.
+func (p *AliasPlugin) PreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	if p.AfterAuth {
+		p.rewrite(ctx, r)
+	}
+	return nil
+}
+
+// PreWriteResponse restores servicePath and serviceMethod and records the original name in RewrittenFromKey.
+func (p *AliasPlugin) PreWriteResponse(ctx context.Context, r *protocol.Message, res *protocol.Message, err error) error {
+	from, ok := ctx.Value(rewrittenFromContextKey{}).([2]string)
+	if !ok {
 		return nil
 	}
-	var sp = r.ServicePath
-	var sm = r.ServiceMethod
 
-	k := sp + "." + sm
-	if p.ReseverseAliases != nil {
-		if pm := p.ReseverseAliases[k]; pm != nil {
-			r.ServicePath = pm.servicePath
-			r.ServiceMethod = pm.serviceMethod
-			delete(r.Metadata, aliasAppliedKey)
-			if res != nil {
-				res.ServicePath = pm.servicePath
-				res.ServiceMethod = pm.serviceMethod
-			}
+	r.ServicePath = from[0]
+	r.ServiceMethod = from[1]
+	if res != nil {
+		res.ServicePath = from[0]
+		res.ServiceMethod = from[1]
+		if res.Metadata == nil {
+			res.Metadata = make(map[string]string)
 		}
+		res.Metadata[RewrittenFromKey] = from[0] + "." + from[1]
 	}
 	return nil
 }
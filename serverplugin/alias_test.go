@@ -0,0 +1,120 @@
+package serverplugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+func TestAliasPlugin_Rewrite(t *testing.T) {
+	p := NewAliasPlugin()
+	p.AddRule("Arith.*", "Math.*")
+	p.AddRule("Arith.Old*", "Legacy.New*")
+	p.Alias("Arith", "Add", "Calc", "Plus")
+
+	cases := []struct {
+		sp, sm         string
+		wantSp, wantSm string
+		ok             bool
+	}{
+		{"Arith", "Mul", "Math", "Mul", true},
+		{"Arith", "Add", "Calc", "Plus", true},
+		{"Arith", "OldDiv", "Legacy", "NewDiv", true},
+		{"Other", "Mul", "Other", "Mul", false},
+	}
+	for _, c := range cases {
+		sp, sm, ok := p.Rewrite(c.sp, c.sm)
+		if sp != c.wantSp || sm != c.wantSm || ok != c.ok {
+			t.Errorf("Rewrite(%s, %s) = %s, %s, %t; want %s, %s, %t", c.sp, c.sm, sp, sm, ok, c.wantSp, c.wantSm, c.ok)
+		}
+	}
+
+	p.RemoveRule("Arith.*")
+	if _, _, ok := p.Rewrite("Arith", "Mul"); ok {
+		t.Errorf("expect rule Arith.* removed")
+	}
+
+	p.SetRules(map[string]string{"A.B": "C.D"})
+	if _, _, ok := p.Rewrite("Arith", "Add"); ok {
+		t.Errorf("expect rules replaced")
+	}
+	if sp, sm, _ := p.Rewrite("A", "B"); sp != "C" || sm != "D" {
+		t.Errorf("expect A.B rewritten to C.D but got %s.%s", sp, sm)
+	}
+}
+
+func TestAliasPlugin_Aliases(t *testing.T) {
+	p := NewAliasPlugin()
+	p.Alias("Arith", "Add", "Calc", "Plus")
+	if pm := p.Aliases["Arith.Add"]; pm == nil || pm.servicePath != "Calc" || pm.serviceMethod != "Plus" {
+		t.Errorf("expect the alias of Arith.Add in Aliases but got %+v", pm)
+	}
+	if pm := p.ReseverseAliases["Calc.Plus"]; pm == nil || pm.servicePath != "Arith" || pm.serviceMethod != "Add" {
+		t.Errorf("expect the alias of Calc.Plus in ReseverseAliases but got %+v", pm)
+	}
+
+	// entries of Aliases are exact rules
+	p.Aliases["Arith.Sub"] = &aliasPair{servicePath: "Calc", serviceMethod: "Minus"}
+	if sp, sm, ok := p.Rewrite("Arith", "Sub"); !ok || sp != "Calc" || sm != "Minus" {
+		t.Errorf("expect Arith.Sub rewritten to Calc.Minus but got %s.%s", sp, sm)
+	}
+
+	p.RemoveRule("Arith.Add")
+	if _, _, ok := p.Rewrite("Arith", "Add"); ok || len(p.ReseverseAliases) != 0 {
+		t.Errorf("expect the alias of Arith.Add removed")
+	}
+}
+
+func TestAliasPlugin(t *testing.T) {
+	for _, afterAuth := range []bool{false, true} {
+		s := server.NewServer()
+		p := NewAliasPlugin()
+		p.AfterAuth = afterAuth
+		p.AddRule("Arith.*", "Math.*")
+		s.Plugins.Add(p)
+
+		var authPath string
+		s.AuthFunc = func(ctx context.Context, req *protocol.Message, token string) error {
+			authPath = req.ServicePath
+			return nil
+		}
+		s.RegisterName("Math", new(Arith), "")
+		go s.Serve("tcp", "127.0.0.1:0")
+		time.Sleep(500 * time.Millisecond)
+
+		c := client.NewClient(client.DefaultOption)
+		if err := c.Connect("tcp", s.Address().String()); err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+
+		resMeta := make(map[string]string)
+		ctx := context.WithValue(context.Background(), share.ResMetaDataKey, resMeta)
+		reply := &Reply{}
+		err := c.Call(ctx, "Arith", "Mul", &Args{A: 10, B: 20}, reply)
+		if err != nil {
+			t.Fatalf("failed to call: %v", err)
+		}
+		if reply.C != 200 {
+			t.Errorf("expect 200 but got %d", reply.C)
+		}
+		if resMeta[RewrittenFromKey] != "Arith.Mul" {
+			t.Errorf("expect %s is Arith.Mul but got %q", RewrittenFromKey, resMeta[RewrittenFromKey])
+		}
+
+		wantAuthPath := "Math"
+		if afterAuth {
+			wantAuthPath = "Arith"
+		}
+		if authPath != wantAuthPath {
+			t.Errorf("afterAuth=%t: expect auth sees %s but got %s", afterAuth, wantAuthPath, authPath)
+		}
+
+		c.Close()
+		s.Close()
+	}
+}
@@ -0,0 +1,208 @@
+package serverplugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+var (
+	// ErrPermissionDenied is returned to callers which are not allowed to call the method.
+	ErrPermissionDenied = rerrors.New(rerrors.PermissionDenied, "rpcx: permission denied")
+	// ErrNoIdentity is returned to callers without identities if anonymous callers are not allowed to call the method.
+	ErrNoIdentity = rerrors.New(rerrors.Unauthenticated, "rpcx: caller has no identity")
+)
+
+// AuthorizationPolicy maps identities and roles to the methods they may call.
+//
+// Patterns are servicePath.serviceMethod, or end with "*" to match all methods with the prefix, such as "Arith.*" and "*".
+// Patterns starting with "!" deny the methods they match. If several patterns of an identity and its roles match a method,
+// exact patterns take precedence over wildcard patterns, longer prefixes take precedence over shorter ones,
+// and denials take precedence over allowances of the same pattern. Methods no pattern matches are denied.
+type AuthorizationPolicy struct {
+	// Identities maps names of identities to their patterns.
+	Identities map[string][]string `json:"identities,omitempty"`
+	// Roles maps roles to patterns of identities with the roles.
+	Roles map[string][]string `json:"roles,omitempty"`
+	// Anonymous are patterns of callers without identities, for example "Health.*".
+	Anonymous []string `json:"anonymous,omitempty"`
+}
+
+// PolicyFunc decides whether identity may call servicePath.serviceMethod. identity is nil for callers without identities.
+// It returns nil to allow the call, or an error returned to the caller to deny it.
+type PolicyFunc func(ctx context.Context, identity *share.Identity, servicePath, serviceMethod string) error
+
+type authzRule struct {
+	prefix   string
+	wildcard bool
+	deny     bool
+}
+
+// score returns how specific r matches k, or -1 if r does not match k.
+func (r authzRule) score(k string) int {
+	if !r.wildcard {
+		if k == r.prefix {
+			return len(k) + 1
+		}
+		return -1
+	}
+	if strings.HasPrefix(k, r.prefix) {
+		return len(r.prefix)
+	}
+	return -1
+}
+
+func compileAuthzRules(patterns []string) ([]authzRule, error) {
+	rules := make([]authzRule, 0, len(patterns))
+	for _, pattern := range patterns {
+		r := authzRule{prefix: pattern}
+		if strings.HasPrefix(r.prefix, "!") {
+			r.deny, r.prefix = true, r.prefix[1:]
+		}
+		if strings.HasSuffix(r.prefix, "*") {
+			r.wildcard, r.prefix = true, strings.TrimSuffix(r.prefix, "*")
+		}
+		if strings.Contains(r.prefix, "*") || (!r.wildcard && !strings.Contains(r.prefix, ".")) {
+			return nil, fmt.Errorf("rpcx: invalid authorization pattern %q", pattern)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+type authzPolicy struct {
+	policy     AuthorizationPolicy
+	identities map[string][]authzRule
+	roles      map[string][]authzRule
+	anonymous  []authzRule
+}
+
+func compileAuthzPolicy(policy AuthorizationPolicy) (*authzPolicy, error) {
+	p := &authzPolicy{policy: policy, identities: make(map[string][]authzRule), roles: make(map[string][]authzRule)}
+	var err error
+	for name, patterns := range policy.Identities {
+		if p.identities[name], err = compileAuthzRules(patterns); err != nil {
+			return nil, err
+		}
+	}
+	for role, patterns := range policy.Roles {
+		if p.roles[role], err = compileAuthzRules(patterns); err != nil {
+			return nil, err
+		}
+	}
+	if p.anonymous, err = compileAuthzRules(policy.Anonymous); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// allowed reports whether the most specific rule of rules matching k allows it.
+func allowed(k string, rules ...[]authzRule) bool {
+	best, allow := -1, false
+	for _, rs := range rules {
+		for _, r := range rs {
+			score := r.score(k)
+			if score > best || (score == best && score >= 0 && r.deny) {
+				best, allow = score, !r.deny
+			}
+		}
+	}
+	return allow
+}
+
+func (p *authzPolicy) authorize(identity *share.Identity, k string) error {
+	if identity == nil {
+		if allowed(k, p.anonymous) {
+			return nil
+		}
+		return ErrNoIdentity
+	}
+
+	rules := [][]authzRule{p.identities[identity.Name]}
+	for _, role := range identity.Roles {
+		rules = append(rules, p.roles[role])
+	}
+	if allowed(k, rules...) {
+		return nil
+	}
+	return ErrPermissionDenied
+}
+
+// AuthorizationPlugin decides which callers may call which methods before services are called.
+// Callers are identified by server.CallerIdentity, so that identities can be set by AuthFunc from JWT claims
+// or come from peer certificates of mutual TLS. Denied requests get ErrPermissionDenied, or ErrNoIdentity
+// for callers without identities, and they are logged with the identity and the method.
+// Methods of handlers added by AddHandler are not authorized.
+//
+// The policy can be replaced at runtime by SetPolicy, for example when a config file changes.
+type AuthorizationPlugin struct {
+	// PolicyFunc decides instead of the policy if it is not nil. It should be set before the server serves.
+	PolicyFunc PolicyFunc
+
+	policy atomic.Value // *authzPolicy
+}
+
+// NewAuthorizationPlugin creates an AuthorizationPlugin with policy.
+func NewAuthorizationPlugin(policy AuthorizationPolicy) (*AuthorizationPlugin, error) {
+	p := &AuthorizationPlugin{}
+	if err := p.SetPolicy(policy); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetPolicy replaces the policy atomically, so that every request is authorized either by the old policy or by the new one.
+// The old policy is kept if policy has invalid patterns.
+func (p *AuthorizationPlugin) SetPolicy(policy AuthorizationPolicy) error {
+	compiled, err := compileAuthzPolicy(policy)
+	if err != nil {
+		return err
+	}
+	p.policy.Store(compiled)
+	return nil
+}
+
+// Policy returns the current policy.
+func (p *AuthorizationPlugin) Policy() AuthorizationPolicy {
+	if compiled, ok := p.policy.Load().(*authzPolicy); ok {
+		return compiled.policy
+	}
+	return AuthorizationPolicy{}
+}
+
+// Authorize returns nil if the caller of ctx may call servicePath.serviceMethod.
+func (p *AuthorizationPlugin) Authorize(ctx context.Context, servicePath, serviceMethod string) error {
+	identity := server.CallerIdentity(ctx)
+
+	var err error
+	if p.PolicyFunc != nil {
+		err = p.PolicyFunc(ctx, identity, servicePath, serviceMethod)
+	} else if compiled, ok := p.policy.Load().(*authzPolicy); ok {
+		err = compiled.authorize(identity, servicePath+"."+serviceMethod)
+	} else {
+		err = ErrPermissionDenied
+	}
+
+	if err != nil {
+		name := "anonymous caller"
+		if identity != nil {
+			name = fmt.Sprintf("%q (roles %v)", identity.Name, identity.Roles)
+		}
+		log.Warnf("rpcx: denied %s to call %s.%s: %v", name, servicePath, serviceMethod, err)
+	}
+	return err
+}
+
+// PreCall authorizes requests before services are called.
+func (p *AuthorizationPlugin) PreCall(ctx context.Context, serviceName, methodName string, args interface{}) (interface{}, error) {
+	if err := p.Authorize(ctx, serviceName, methodName); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
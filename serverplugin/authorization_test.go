@@ -0,0 +1,161 @@
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+func identityContext(name string, roles ...string) context.Context {
+	return share.WithValue(context.Background(), share.IdentityContextKey, &share.Identity{Name: name, Roles: roles})
+}
+
+func TestAuthorizationPluginPrecedence(t *testing.T) {
+	p, err := NewAuthorizationPlugin(AuthorizationPolicy{
+		Identities: map[string][]string{
+			"alice": {"Arith.*", "!Arith.Div"},
+			"bob":   {"*", "!Arith.*", "Arith.Mul"},
+		},
+		Roles: map[string][]string{
+			"reader": {"Store.Get*"},
+			"banned": {"!Store.Get"},
+		},
+		Anonymous: []string{"Health.*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ctx                        context.Context
+		servicePath, serviceMethod string
+		err                        error
+	}{
+		{identityContext("alice"), "Arith", "Mul", nil},
+		{identityContext("alice"), "Arith", "Div", ErrPermissionDenied},
+		{identityContext("alice"), "Store", "Get", ErrPermissionDenied},
+		{identityContext("bob"), "Store", "Get", nil},
+		{identityContext("bob"), "Arith", "Add", ErrPermissionDenied},
+		{identityContext("bob"), "Arith", "Mul", nil},
+		// roles
+		{identityContext("carol", "reader"), "Store", "GetAll", nil},
+		{identityContext("carol", "reader"), "Store", "Put", ErrPermissionDenied},
+		{identityContext("carol", "reader", "banned"), "Store", "Get", ErrPermissionDenied},
+		{identityContext("carol", "reader", "banned"), "Store", "GetAll", nil},
+		// missing identity
+		{context.Background(), "Health", "Check", nil},
+		{context.Background(), "Arith", "Mul", ErrNoIdentity},
+	}
+	for _, c := range cases {
+		if err := p.Authorize(c.ctx, c.servicePath, c.serviceMethod); err != c.err {
+			t.Errorf("%v calling %s.%s: expect %v but got %v", server.CallerIdentity(c.ctx), c.servicePath, c.serviceMethod, c.err, err)
+		}
+	}
+
+	if err := p.SetPolicy(AuthorizationPolicy{Anonymous: []string{"Arith"}}); err == nil {
+		t.Error("expect an error for an invalid pattern")
+	}
+	if len(p.Policy().Identities) != 2 {
+		t.Error("expect the old policy is kept")
+	}
+
+	p.PolicyFunc = func(ctx context.Context, identity *share.Identity, servicePath, serviceMethod string) error {
+		if identity != nil && identity.Name == "root" {
+			return nil
+		}
+		return errors.New("only root")
+	}
+	if err := p.Authorize(identityContext("root"), "Any", "Method"); err != nil {
+		t.Error(err)
+	}
+	if err := p.Authorize(identityContext("alice"), "Arith", "Mul"); err == nil {
+		t.Error("expect PolicyFunc decides instead of the policy")
+	}
+}
+
+func TestAuthorizationPluginHotSwap(t *testing.T) {
+	p, err := NewAuthorizationPlugin(AuthorizationPolicy{Identities: map[string][]string{"alice": {"Arith.*"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.NewServer()
+	s.AuthFunc = func(ctx context.Context, req *protocol.Message, token string) error {
+		if token != "" {
+			ctx.(*share.Context).SetValue(share.IdentityContextKey, &share.Identity{Name: token})
+		}
+		return nil
+	}
+	s.Plugins.Add(p)
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := client.NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xc := client.NewXClient("Arith", client.Failfast, client.RandomSelect, d, client.DefaultOption)
+	defer xc.Close()
+	xc.Auth("alice")
+
+	if err := xc.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var stop int32
+	var allowedCalls, deniedCalls, unexpected int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				err := xc.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{})
+				switch {
+				case err == nil:
+					atomic.AddInt64(&allowedCalls, 1)
+				case errors.Is(err, rerrors.New(rerrors.PermissionDenied, "")):
+					atomic.AddInt64(&deniedCalls, 1)
+				default:
+					atomic.AddInt64(&unexpected, 1)
+				}
+			}
+		}()
+	}
+
+	policies := []AuthorizationPolicy{
+		{Identities: map[string][]string{"alice": {"!Arith.Mul", "Arith.*"}}},
+		{Identities: map[string][]string{"alice": {"Arith.*"}}},
+	}
+	for i := 0; i < 20; i++ {
+		if err := p.SetPolicy(policies[i%2]); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := p.SetPolicy(policies[0]); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+
+	if allowedCalls == 0 || deniedCalls == 0 || unexpected != 0 {
+		t.Errorf("expect calls allowed and denied by swapped policies but got %d allowed, %d denied and %d unexpected",
+			allowedCalls, deniedCalls, unexpected)
+	}
+	err = xc.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{})
+	if !errors.Is(err, rerrors.New(rerrors.PermissionDenied, "")) {
+		t.Errorf("expect PermissionDenied by the last policy but got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package serverplugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// chaosContextKey keeps the kinds of faults injected into requests in their contexts, to tag their responses.
+type chaosContextKey struct{}
+
+// ChaosPlugin injects faults of share.ChaosRule into requests of services, so that clients can be tested
+// against slow, failing and lost requests in staging. Latency delays requests before their services are called,
+// errors fail them instead of calling their services, dropped requests are never answered so calls time out,
+// and corrupted responses fail to decode on clients. Faults of requests are injected into handlers of services,
+// and their responses are corrupted by PreEncodeResponse.
+//
+// Responses of faulty requests carry share.ChaosKey in their metadata, and errors in their details too,
+// so injected faults can be told from real ones. Rules are updated by SetRules, faults are stopped
+// by SetEnabled or share.SetChaosEnabled, and they are reproducible if it is seeded by Seed.
+type ChaosPlugin struct {
+	*share.Chaos
+}
+
+// NewChaosPlugin creates a ChaosPlugin of rules.
+func NewChaosPlugin(rules ...share.ChaosRule) *ChaosPlugin {
+	return &ChaosPlugin{Chaos: share.NewChaos(rules...)}
+}
+
+// PreCall injects faults into requests. Latency is cut short if the request is canceled or its deadline expires.
+func (p *ChaosPlugin) PreCall(ctx context.Context, serviceName, methodName string, args interface{}) (interface{}, error) {
+	f := p.Inject(serviceName, methodName, false)
+	kinds := f.Kinds()
+	if kinds == "" {
+		return args, nil
+	}
+	if rpcxContext, ok := ctx.(*share.Context); ok {
+		rpcxContext.SetValue(chaosContextKey{}, kinds)
+	}
+
+	if f.Latency > 0 {
+		t := time.NewTimer(f.Latency)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+	if f.Drop {
+		return args, server.ErrDropResponse
+	}
+	return args, f.Err
+}
+
+// PreEncodeResponse corrupts payloads of responses, and tags responses of faulty requests.
+func (p *ChaosPlugin) PreEncodeResponse(ctx context.Context, req, res *protocol.Message) error {
+	if res.IsHeartbeat() {
+		return nil
+	}
+	kinds, _ := ctx.Value(chaosContextKey{}).(string)
+	if len(res.Payload) > 0 && p.Inject(res.ServicePath, res.ServiceMethod, true).Corrupt {
+		share.CorruptPayload(res.Payload)
+		if kinds != "" {
+			kinds += ","
+		}
+		kinds += share.ChaosCorrupt
+	}
+	if kinds == "" {
+		return nil
+	}
+
+	if res.Metadata == nil {
+		res.Metadata = make(map[string]string)
+	}
+	res.Metadata[share.ChaosKey] = kinds
+	return nil
+}
@@ -0,0 +1,134 @@
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/rpcxtest"
+	"github.com/smallnest/rpcx/share"
+)
+
+func chaosCall(ctx context.Context, c *client.Client) (map[string]string, error) {
+	resMeta := make(map[string]string)
+	ctx = context.WithValue(ctx, share.ResMetaDataKey, resMeta)
+	reply := &Reply{}
+	err := c.Call(ctx, "Arith", "Mul", &Args{A: 2, B: 3}, reply)
+	if err == nil && reply.C != 6 {
+		err = errors.New("unexpected reply")
+	}
+	return resMeta, err
+}
+
+func TestChaosPlugin(t *testing.T) {
+	p := NewChaosPlugin()
+	h := rpcxtest.New(t)
+	h.Server.Plugins.Add(p)
+	h.Register("Arith", new(Arith))
+	c := h.Client()
+
+	meta, err := chaosCall(h.Context(), c)
+	if err != nil || meta[share.ChaosKey] != "" {
+		t.Fatalf("expect no faults but got %v, %v", meta, err)
+	}
+
+	p.SetRules(share.ChaosRule{ServicePath: "Arith", ServiceMethod: "M*", Percentage: 100, Latency: 50 * time.Millisecond})
+	start := time.Now()
+	meta, err = chaosCall(h.Context(), c)
+	if err != nil || time.Since(start) < 50*time.Millisecond || meta[share.ChaosKey] != share.ChaosLatency {
+		t.Fatalf("expect the latency but got %v, %v in %v", meta, err, time.Since(start))
+	}
+
+	p.SetRules(share.ChaosRule{Percentage: 100, ErrorCode: rerrors.Unavailable})
+	meta, err = chaosCall(h.Context(), c)
+	if rerrors.CodeOf(err) != rerrors.Unavailable || rerrors.DetailsOf(err)[share.ChaosKey] != share.ChaosError ||
+		meta[share.ChaosKey] != share.ChaosError {
+		t.Fatalf("expect the injected error but got %v, %v", meta, err)
+	}
+
+	p.SetRules(share.ChaosRule{Percentage: 100, Drop: true})
+	ctx, cancel := context.WithTimeout(h.Context(), 100*time.Millisecond)
+	_, err = chaosCall(ctx, c)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect the dropped request to time out but got %v", err)
+	}
+
+	p.SetRules(share.ChaosRule{ServiceMethod: "Mul", Percentage: 100, Corrupt: true})
+	if _, err = chaosCall(h.Context(), c); err == nil {
+		t.Fatal("expect the corrupted response to fail")
+	}
+
+	// kill switches
+	p.SetEnabled(false)
+	if _, err = chaosCall(h.Context(), c); err != nil {
+		t.Fatal(err)
+	}
+	p.SetEnabled(true)
+	share.SetChaosEnabled(false)
+	_, err = chaosCall(h.Context(), c)
+	share.SetChaosEnabled(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := p.Stats()
+	if stats[share.ChaosLatency] != 1 || stats[share.ChaosError] != 1 || stats[share.ChaosDrop] != 1 || stats[share.ChaosCorrupt] != 1 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+}
+
+func TestClientChaosPlugin(t *testing.T) {
+	h := rpcxtest.New(t)
+	h.Register("Arith", new(Arith))
+	c := h.Client()
+	p := client.NewChaosPlugin()
+	p.Seed(1)
+	c.Plugins = client.NewPluginContainer()
+	c.Plugins.Add(p)
+
+	p.SetRules(share.ChaosRule{Percentage: 100, Latency: 50 * time.Millisecond})
+	start := time.Now()
+	if _, err := chaosCall(h.Context(), c); err != nil || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("expect the latency but got %v in %v", err, time.Since(start))
+	}
+
+	p.SetRules(share.ChaosRule{Percentage: 100, ErrorCode: rerrors.ResourceExhausted})
+	_, err := chaosCall(h.Context(), c)
+	if rerrors.CodeOf(err) != rerrors.ResourceExhausted || rerrors.DetailsOf(err)[share.ChaosKey] != share.ChaosError {
+		t.Fatalf("expect the injected error but got %v", err)
+	}
+
+	p.SetRules(share.ChaosRule{Percentage: 100, Drop: true})
+	ctx, cancel := context.WithTimeout(h.Context(), 100*time.Millisecond)
+	_, err = chaosCall(ctx, c)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect the dropped request to time out but got %v", err)
+	}
+	// dropped oneway calls are done
+	call := <-c.Go(h.Context(), "Arith", "Mul", &Args{A: 2, B: 3}, nil, nil).Done
+	if call.Error != nil {
+		t.Fatal(call.Error)
+	}
+
+	p.SetRules(share.ChaosRule{Percentage: 100, Corrupt: true})
+	if _, err = chaosCall(h.Context(), c); err == nil {
+		t.Fatal("expect the corrupted response to fail")
+	}
+
+	// half of the requests fail
+	p.SetRules(share.ChaosRule{Percentage: 50, ErrorCode: rerrors.Unavailable})
+	var failed int
+	for i := 0; i < 100; i++ {
+		if _, err = chaosCall(h.Context(), c); err != nil {
+			failed++
+		}
+	}
+	if failed < 30 || failed > 70 {
+		t.Fatalf("expect half of the requests to fail but %d failed", failed)
+	}
+}
@@ -5,16 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"net/url"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/hashicorp/consul/api"
 	metrics "github.com/rcrowley/go-metrics"
 	"github.com/rpcxio/libkv"
 	"github.com/rpcxio/libkv/store"
 	"github.com/rpcxio/libkv/store/consul"
 	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/share"
 )
 
 func init() {
@@ -33,11 +34,18 @@ type ConsulRegisterPlugin struct {
 	// Registered services
 	Services       []string
 	metasLock      sync.RWMutex
-	metas          map[string]string
+	metas          registrations
 	UpdateInterval time.Duration
+	// OnReRegister is invoked when a lost registration is re-created by the refresh loop.
+	// err is not nil if re-registering failed and will be retried with backoff.
+	OnReRegister func(servicePath string, err error)
+	// TTLCheck registers the server as a service of the first Consul agent with a TTL check if it is not nil,
+	// which is updated every UpdateInterval.
+	TTLCheck *ConsulTTLCheck
 
-	Options *store.Config
-	kv      store.Store
+	Options    *store.Config
+	kv         store.Store
+	ttlChecker *consulTTLChecker
 
 	dying chan struct{}
 	done  chan struct{}
@@ -71,57 +79,73 @@ func (p *ConsulRegisterPlugin) Start() error {
 		return err
 	}
 
+	if p.TTLCheck != nil && p.ttlChecker == nil {
+		checker, err := newConsulTTLChecker(p.consulConfig(), *p.TTLCheck, p.ServiceAddress, p.UpdateInterval)
+		if err != nil {
+			log.Errorf("cannot create consul check: %v", err)
+			return err
+		}
+		if err := checker.register(); err != nil {
+			log.Errorf("cannot register consul check: %v", err)
+			return err
+		}
+		p.ttlChecker = checker
+	}
+
 	if p.UpdateInterval > 0 {
 		go func() {
-			ticker := time.NewTicker(p.UpdateInterval)
-
-			defer ticker.Stop()
 			defer p.kv.Close()
 
-			// refresh service TTL
-			for {
-				select {
-				case <-p.dying:
-					close(p.done)
-					return
-				case <-ticker.C:
-					extra := make(map[string]string)
-					if p.Metrics != nil {
-						extra["calls"] = fmt.Sprintf("%.2f", metrics.GetOrRegisterMeter("calls", p.Metrics).RateMean())
-						extra["connections"] = fmt.Sprintf("%.2f", metrics.GetOrRegisterMeter("connections", p.Metrics).RateMean())
-					}
-
-					//set this same metrics for all services at this server
-					for _, name := range p.Services {
-						nodePath := fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
-						kvPaire, err := p.kv.Get(nodePath)
-						if err != nil {
-							log.Warnf("can't get data of node: %s, will re-create, because of %v", nodePath, err.Error())
-
-							p.metasLock.RLock()
-							meta := p.metas[name]
-							p.metasLock.RUnlock()
-
-							err = p.kv.Put(nodePath, []byte(meta), &store.WriteOptions{TTL: p.UpdateInterval * 2})
-							if err != nil {
-								log.Errorf("cannot re-create consul path %s: %v", nodePath, err)
-							}
-						} else {
-							v, _ := url.ParseQuery(string(kvPaire.Value))
-							for key, value := range extra {
-								v.Set(key, value)
-							}
-							_ = p.kv.Put(nodePath, []byte(v.Encode()), &store.WriteOptions{TTL: p.UpdateInterval * 2})
-						}
-					}
-				}
-			}
+			// refresh service TTL and re-register lost services
+			refreshLoop(p.UpdateInterval, p.dying, p.refresh)
+			close(p.done)
 		}()
 	}
 
 	return nil
 }
 
+func (p *ConsulRegisterPlugin) refresh() error {
+	extra := make(map[string]string)
+	if p.Metrics != nil {
+		extra["calls"] = fmt.Sprintf("%.2f", metrics.GetOrRegisterMeter("calls", p.Metrics).RateMean())
+		extra["connections"] = fmt.Sprintf("%.2f", metrics.GetOrRegisterMeter("connections", p.Metrics).RateMean())
+	}
+
+	//set this same metrics for all services at this server
+	p.metasLock.RLock()
+	nodes := p.metas.nodes(p.BasePath, p.ServiceAddress, p.Services)
+	p.metasLock.RUnlock()
+
+	err := refreshNodes(p.kv, "consul", nodes, extra, p.UpdateInterval*2, p.isRegistered, p.OnReRegister)
+	if p.ttlChecker != nil {
+		if checkErr := p.ttlChecker.update(); checkErr != nil && err == nil {
+			err = checkErr
+		}
+	}
+	return err
+}
+
+// consulConfig returns the config of the Consul client of the TTL check.
+func (p *ConsulRegisterPlugin) consulConfig() *api.Config {
+	config := api.DefaultConfig()
+	if len(p.ConsulServers) > 0 {
+		config.Address = p.ConsulServers[0]
+	}
+	if p.Options != nil && p.Options.TLS != nil {
+		config.Scheme = "https"
+		config.Transport.TLSClientConfig = p.Options.TLS
+	}
+	return config
+}
+
+// isRegistered returns whether the registration of node is still registered by this plugin.
+func (p *ConsulRegisterPlugin) isRegistered(node registryNode) bool {
+	p.metasLock.RLock()
+	defer p.metasLock.RUnlock()
+	return p.metas.has(node.service, node.registration)
+}
+
 // Stop unregister all services.
 func (p *ConsulRegisterPlugin) Stop() error {
 	if p.kv == nil {
@@ -137,8 +161,13 @@ func (p *ConsulRegisterPlugin) Stop() error {
 		p.BasePath = p.BasePath[1:]
 	}
 
+	p.metasLock.RLock()
+	var paths []string
 	for _, name := range p.Services {
-		nodePath := fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
+		paths = append(paths, p.metas.paths(p.BasePath, name, p.ServiceAddress)...)
+	}
+	p.metasLock.RUnlock()
+	for _, nodePath := range paths {
 		exist, err := p.kv.Exists(nodePath)
 		if err != nil {
 			log.Errorf("cannot delete path %s: %v", nodePath, err)
@@ -152,6 +181,13 @@ func (p *ConsulRegisterPlugin) Stop() error {
 
 	close(p.dying)
 	<-p.done
+
+	if p.ttlChecker != nil {
+		if err := p.ttlChecker.deregister(); err != nil {
+			log.Errorf("cannot deregister consul service %s: %v", p.ttlChecker.check.ServiceID, err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -172,7 +208,8 @@ func (p *ConsulRegisterPlugin) PreCall(_ context.Context, _, _ string, args inte
 }
 
 // Register handles registering event.
-// this service is registered at BASE/serviceName/thisIpAddress node
+// this service is registered at BASE/serviceName/thisIpAddress node,
+// or BASE/serviceName/thisIpAddress#registration for registrations with IDs, see share.MetaRegistration
 func (p *ConsulRegisterPlugin) Register(name string, rcvr interface{}, metadata string) (err error) {
 	if strings.TrimSpace(name) == "" {
 		err = errors.New("Register service `name` can't be empty")
@@ -205,24 +242,47 @@ func (p *ConsulRegisterPlugin) Register(name string, rcvr interface{}, metadata
 		return err
 	}
 
-	nodePath = fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
+	nodePath = registrationPath(p.BasePath, name, p.ServiceAddress, share.RegistrationOf(metadata))
 	err = p.kv.Put(nodePath, []byte(metadata), &store.WriteOptions{TTL: p.UpdateInterval * 2})
 	if err != nil {
 		log.Errorf("cannot create consul path %s: %v", nodePath, err)
 		return err
 	}
 
-	p.Services = append(p.Services, name)
-
 	p.metasLock.Lock()
 	if p.metas == nil {
-		p.metas = make(map[string]string)
+		p.metas = make(registrations)
+	}
+	if p.metas.put(name, metadata) {
+		p.Services = append(p.Services, name)
 	}
-	p.metas[name] = metadata
 	p.metasLock.Unlock()
 	return
 }
 
+// UpdateMetadata updates metadata of the registered service in place without unregistering it.
+func (p *ConsulRegisterPlugin) UpdateMetadata(name, metadata string) error {
+	id := share.RegistrationOf(metadata)
+	p.metasLock.RLock()
+	ok := p.metas.has(name, id)
+	p.metasLock.RUnlock()
+	if !ok || p.kv == nil {
+		return fmt.Errorf("service %s is not registered", name)
+	}
+
+	nodePath := registrationPath(p.BasePath, name, p.ServiceAddress, id)
+	err := p.kv.Put(nodePath, []byte(metadata), &store.WriteOptions{TTL: p.UpdateInterval * 2})
+	if err != nil {
+		log.Errorf("cannot update consul path %s: %v", nodePath, err)
+		return err
+	}
+
+	p.metasLock.Lock()
+	p.metas.put(name, metadata)
+	p.metasLock.Unlock()
+	return nil
+}
+
 func (p *ConsulRegisterPlugin) RegisterFunction(serviceName, fname string, fn interface{}, metadata string) error {
 	return p.Register(serviceName, fn, metadata)
 }
@@ -263,27 +323,50 @@ func (p *ConsulRegisterPlugin) Unregister(name string) (err error) {
 		return err
 	}
 
-	nodePath = fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
-
-	err = p.kv.Delete(nodePath)
-	if err != nil {
-		log.Errorf("cannot create consul path %s: %v", nodePath, err)
-		return err
+	p.metasLock.RLock()
+	paths := p.metas.paths(p.BasePath, name, p.ServiceAddress)
+	p.metasLock.RUnlock()
+	for _, nodePath = range paths {
+		err = p.kv.Delete(nodePath)
+		if err != nil {
+			log.Errorf("cannot create consul path %s: %v", nodePath, err)
+			return err
+		}
 	}
 
-	var services = make([]string, 0, len(p.Services)-1)
+	p.metasLock.Lock()
+	var services = make([]string, 0, len(p.Services))
 	for _, s := range p.Services {
 		if s != name {
 			services = append(services, s)
 		}
 	}
 	p.Services = services
+	delete(p.metas, name)
+	p.metasLock.Unlock()
+	return
+}
+
+// UnregisterRegistration unregisters the registration of a service registered more than once,
+// and keeps its other registrations.
+func (p *ConsulRegisterPlugin) UnregisterRegistration(name, registration string) error {
+	p.metasLock.RLock()
+	ok := p.metas.has(name, registration)
+	p.metasLock.RUnlock()
+	if !ok || p.kv == nil {
+		return fmt.Errorf("registration %s of service %s is not registered", registration, name)
+	}
+
+	nodePath := registrationPath(p.BasePath, name, p.ServiceAddress, registration)
+	if err := p.kv.Delete(nodePath); err != nil {
+		log.Errorf("cannot delete consul path %s: %v", nodePath, err)
+		return err
+	}
 
 	p.metasLock.Lock()
-	if p.metas == nil {
-		p.metas = make(map[string]string)
+	if p.metas.remove(name, registration) {
+		p.Services = withoutService(p.Services, name)
 	}
-	delete(p.metas, name)
 	p.metasLock.Unlock()
-	return
+	return nil
 }
@@ -0,0 +1,186 @@
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/server"
+)
+
+// ConsulTTLCheck configures a TTL check, with which ConsulRegisterPlugin registers the server
+// as a service of the Consul agent. The plugin updates the check to passing every UpdateInterval,
+// so Consul needs no network access to the server to check it.
+type ConsulTTLCheck struct {
+	// ServiceID defaults to "rpcx-" followed by the address of the server
+	ServiceID string
+	// ServiceName defaults to "rpcx"
+	ServiceName string
+	// CheckID defaults to "service:" followed by ServiceID
+	CheckID string
+	// TTL must be longer than UpdateInterval of the plugin. It defaults to three update intervals.
+	TTL time.Duration
+	// InitialStatus is the status until the first update, api.HealthPassing by default.
+	InitialStatus string
+	// DeregisterCriticalServiceAfter removes the service from the catalog after the check has been critical for it,
+	// for example because the server crashed. Zero means never. Consul rounds it up to one minute.
+	DeregisterCriticalServiceAfter time.Duration
+}
+
+// consulTTLChecker registers the service with the TTL check and updates it.
+type consulTTLChecker struct {
+	agent   *api.Agent
+	check   ConsulTTLCheck
+	address string
+
+	mu         sync.Mutex
+	draining   bool
+	failing    bool
+	registered bool
+}
+
+func newConsulTTLChecker(config *api.Config, check ConsulTTLCheck, serviceAddress string, updateInterval time.Duration) (*consulTTLChecker, error) {
+	if updateInterval <= 0 {
+		return nil, errors.New("rpcx: TTL check of consul needs a positive UpdateInterval")
+	}
+	if check.TTL == 0 {
+		check.TTL = 3 * updateInterval
+	}
+	if check.TTL <= updateInterval {
+		return nil, fmt.Errorf("rpcx: TTL %v of the consul check must be longer than UpdateInterval %v", check.TTL, updateInterval)
+	}
+	switch check.InitialStatus {
+	case "":
+		check.InitialStatus = api.HealthPassing
+	case api.HealthPassing, api.HealthWarning, api.HealthCritical:
+	default:
+		return nil, fmt.Errorf("rpcx: invalid initial status %q of the consul check", check.InitialStatus)
+	}
+	if check.ServiceID == "" {
+		check.ServiceID = "rpcx-" + serviceAddress
+	}
+	if check.ServiceName == "" {
+		check.ServiceName = "rpcx"
+	}
+	if check.CheckID == "" {
+		check.CheckID = "service:" + check.ServiceID
+	}
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &consulTTLChecker{agent: client.Agent(), check: check, address: serviceAddress}, nil
+}
+
+// register registers the service with the check in its initial status.
+func (c *consulTTLChecker) register() error {
+	reg := &api.AgentServiceRegistration{
+		ID:   c.check.ServiceID,
+		Name: c.check.ServiceName,
+		Check: &api.AgentServiceCheck{
+			CheckID: c.check.CheckID,
+			Name:    "rpcx TTL check",
+			TTL:     c.check.TTL.String(),
+			Status:  c.check.InitialStatus,
+		},
+	}
+	if c.check.DeregisterCriticalServiceAfter > 0 {
+		reg.Check.DeregisterCriticalServiceAfter = c.check.DeregisterCriticalServiceAfter.String()
+	}
+
+	// tcp@127.0.0.1:8972
+	addr := c.address
+	if i := strings.Index(addr, "@"); i >= 0 {
+		reg.Meta = map[string]string{"network": addr[:i]}
+		addr = addr[i+1:]
+	}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		reg.Address = host
+		reg.Port, _ = strconv.Atoi(port)
+	}
+
+	if err := c.agent.ServiceRegister(reg); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.registered = true
+	c.mu.Unlock()
+	return nil
+}
+
+// update updates the check to passing, or warning if the server is draining.
+// The service is registered again if the last update failed, for example because the agent restarted.
+// Failures are logged once until an update succeeds.
+func (c *consulTTLChecker) update() error {
+	c.mu.Lock()
+	registered := c.registered
+	status, output := api.HealthPassing, "rpcx server is serving"
+	if c.draining {
+		status, output = api.HealthWarning, "rpcx server is draining"
+	}
+	c.mu.Unlock()
+
+	var err error
+	if !registered {
+		err = c.register()
+	}
+	if err == nil {
+		err = c.agent.UpdateTTL(c.check.CheckID, output, status)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.registered = false
+		if !c.failing {
+			log.Errorf("cannot update consul check %s, retrying: %v", c.check.CheckID, err)
+		}
+	} else if c.failing {
+		log.Infof("consul check %s is updated again", c.check.CheckID)
+	}
+	c.failing = err != nil
+	return err
+}
+
+// drain marks the check warning.
+func (c *consulTTLChecker) drain() error {
+	c.mu.Lock()
+	c.draining = true
+	c.mu.Unlock()
+	return c.update()
+}
+
+func (c *consulTTLChecker) deregister() error {
+	return c.agent.ServiceDeregister(c.check.ServiceID)
+}
+
+// Drain marks the TTL check of the server warning, so that Consul users stop routing to the server while it drains.
+// It does nothing without TTLCheck. DrainHook calls it when the server shuts down.
+func (p *ConsulRegisterPlugin) Drain() error {
+	if p.ttlChecker == nil {
+		return nil
+	}
+	return p.ttlChecker.drain()
+}
+
+// DrainHook returns a pre-drain shutdown hook which calls Drain before services are unregistered:
+//
+//	s.AddShutdownHook(p.DrainHook())
+func (p *ConsulRegisterPlugin) DrainHook() server.ShutdownHook {
+	return server.ShutdownHook{
+		Name:     "consul-drain",
+		Phase:    server.ShutdownPreDrain,
+		Priority: -1,
+		Fn: func(ctx context.Context, s *server.Server) error {
+			return p.Drain()
+		},
+	}
+}
@@ -0,0 +1,160 @@
+package serverplugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/smallnest/rpcx/server"
+)
+
+// fakeConsulAgent serves the agent API used by the TTL check.
+type fakeConsulAgent struct {
+	mu         sync.Mutex
+	down       bool
+	registered []api.AgentServiceRegistration
+	statuses   []string
+}
+
+func (a *fakeConsulAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.down {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case r.URL.Path == "/v1/agent/service/register":
+		var reg api.AgentServiceRegistration
+		json.NewDecoder(r.Body).Decode(&reg)
+		a.registered = append(a.registered, reg)
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/check/update/"):
+		var update struct{ Status string }
+		json.NewDecoder(r.Body).Decode(&update)
+		a.statuses = append(a.statuses, update.Status)
+	}
+}
+
+func TestConsulTTLChecker(t *testing.T) {
+	if _, err := newConsulTTLChecker(api.DefaultConfig(), ConsulTTLCheck{TTL: time.Second}, "tcp@127.0.0.1:8972", time.Second); err == nil {
+		t.Error("expect error for TTL not longer than the update interval")
+	}
+
+	agent := &fakeConsulAgent{}
+	ts := httptest.NewServer(agent)
+	defer ts.Close()
+
+	config := api.DefaultConfig()
+	config.Address = strings.TrimPrefix(ts.URL, "http://")
+	c, err := newConsulTTLChecker(config, ConsulTTLCheck{DeregisterCriticalServiceAfter: time.Minute}, "tcp@127.0.0.1:8972", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.register(); err != nil {
+		t.Fatal(err)
+	}
+	reg := agent.registered[0]
+	if reg.ID != "rpcx-tcp@127.0.0.1:8972" || reg.Address != "127.0.0.1" || reg.Port != 8972 || reg.Meta["network"] != "tcp" {
+		t.Errorf("unexpected registration: %+v", reg)
+	}
+	if reg.Check.CheckID != "service:rpcx-tcp@127.0.0.1:8972" || reg.Check.TTL != "3s" || reg.Check.Status != api.HealthPassing ||
+		reg.Check.DeregisterCriticalServiceAfter != "1m0s" {
+		t.Errorf("unexpected check: %+v", reg.Check)
+	}
+
+	// the agent is unreachable
+	agent.mu.Lock()
+	agent.down = true
+	agent.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		if err := c.update(); err == nil {
+			t.Fatal("expect error when the agent is down")
+		}
+		if !c.failing {
+			t.Error("expect failing state")
+		}
+	}
+
+	// the service is registered again when the agent recovers
+	agent.mu.Lock()
+	agent.down = false
+	agent.mu.Unlock()
+	if err := c.update(); err != nil {
+		t.Fatal(err)
+	}
+	if c.failing || len(agent.registered) != 2 {
+		t.Errorf("expect recovered and registered again, failing: %t, registered %d times", c.failing, len(agent.registered))
+	}
+
+	if err := c.drain(); err != nil {
+		t.Fatal(err)
+	}
+	if len(agent.statuses) != 2 || agent.statuses[0] != api.HealthPassing || agent.statuses[1] != api.HealthWarning {
+		t.Errorf("expect passing and then warning but got %v", agent.statuses)
+	}
+}
+
+func TestConsulRegistryTTLCheck(t *testing.T) {
+	ts, err := testutil.NewTestServerConfigT(t, nil)
+	if err != nil {
+		t.Skipf("cannot start consul test server: %v", err)
+	}
+	defer ts.Stop()
+
+	r := &ConsulRegisterPlugin{
+		ServiceAddress: "tcp@127.0.0.1:8972",
+		ConsulServers:  []string{ts.HTTPAddr},
+		BasePath:       "/rpcx_test",
+		UpdateInterval: 200 * time.Millisecond,
+		TTLCheck:       &ConsulTTLCheck{TTL: time.Second, InitialStatus: api.HealthCritical},
+	}
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s := server.NewServer()
+	s.Plugins.Add(r)
+	s.AddShutdownHook(r.DrainHook())
+
+	checkID := r.ttlChecker.check.CheckID
+	status := func() string {
+		checks, err := r.ttlChecker.agent.Checks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checks[checkID] == nil {
+			return ""
+		}
+		return checks[checkID].Status
+	}
+	waitStatus := func(want string, timeout time.Duration) {
+		deadline := time.Now().Add(timeout)
+		for status() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("expect check %s to be %s but got %q", checkID, want, status())
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	waitStatus(api.HealthPassing, 2*time.Second)
+	if err := r.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(api.HealthWarning, time.Second)
+
+	// the check flips to critical after updates stop, for example when the server crashes
+	close(r.dying)
+	<-r.done
+	waitStatus(api.HealthCritical, 3*time.Second)
+
+	if err := r.ttlChecker.deregister(); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus("", time.Second)
+}
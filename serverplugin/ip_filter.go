@@ -0,0 +1,299 @@
+package serverplugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+)
+
+// RuleNotAllowed is the rule that counts clients rejected because they don't match the allow list.
+const RuleNotAllowed = "not-allowed"
+
+// IPFilterPlugin allows or denies clients by IPs and CIDR ranges of IPv4 and IPv6.
+// The deny list is evaluated before the allow list, and an empty allow list allows all clients that are not denied.
+// Lists are stored in radix trees so lookups don't depend on the size of lists,
+// and they can be replaced at runtime by SetRules or the admin handler.
+//
+// By default clients are checked when connections are accepted, which is the cheapest.
+// Set PerRequest if the real address of clients is known only after the connection is accepted,
+// for example when a plugin parses PROXY protocol headers. Then every request is checked
+// and the connection is closed if it is denied.
+type IPFilterPlugin struct {
+	PerRequest bool
+
+	mu         sync.RWMutex
+	allowRules []string
+	denyRules  []string
+	allow      *ipTrie
+	deny       *ipTrie
+	rejected   map[string]*int64
+}
+
+// NewIPFilterPlugin creates an IPFilterPlugin with allow and deny lists of IPs and CIDR ranges,
+// such as "10.0.0.1", "172.17.0.0/16" or "2001:db8::/32".
+func NewIPFilterPlugin(allow, deny []string) (*IPFilterPlugin, error) {
+	p := &IPFilterPlugin{rejected: make(map[string]*int64)}
+	if err := p.SetRules(allow, deny); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetRules replaces the allow and deny lists. Lists are not changed if any rule is invalid.
+// Rejection counts of rules that still exist are kept.
+func (p *IPFilterPlugin) SetRules(allow, deny []string) error {
+	p.mu.RLock()
+	rejected := p.rejected
+	p.mu.RUnlock()
+
+	counters := make(map[string]*int64, len(deny)+1)
+	counter := func(rule string) *int64 {
+		if c := counters[rule]; c != nil {
+			return c
+		}
+		c := rejected[rule]
+		if c == nil {
+			c = new(int64)
+		}
+		counters[rule] = c
+		return c
+	}
+	counter(RuleNotAllowed)
+
+	allowTrie, err := newIPTrie(allow, func(string) *int64 { return nil })
+	if err != nil {
+		return err
+	}
+	denyTrie, err := newIPTrie(deny, counter)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.allowRules = append([]string{}, allow...)
+	p.denyRules = append([]string{}, deny...)
+	p.allow, p.deny = allowTrie, denyTrie
+	p.rejected = counters
+	p.mu.Unlock()
+	return nil
+}
+
+// Rules returns the allow and deny lists.
+func (p *IPFilterPlugin) Rules() (allow, deny []string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]string{}, p.allowRules...), append([]string{}, p.denyRules...)
+}
+
+// Rejected returns the number of rejected connections or requests by deny rules and RuleNotAllowed.
+// Rules that have not rejected any client are omitted.
+func (p *IPFilterPlugin) Rejected() map[string]int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	m := make(map[string]int64)
+	for rule, c := range p.rejected {
+		if n := atomic.LoadInt64(c); n > 0 {
+			m[rule] = n
+		}
+	}
+	return m
+}
+
+// Allowed checks ip and returns the rule that rejects it if it is not allowed.
+func (p *IPFilterPlugin) Allowed(ip net.IP) (rule string, ok bool) {
+	p.mu.RLock()
+	allow, deny, notAllowed := p.allow, p.deny, p.rejected[RuleNotAllowed]
+	p.mu.RUnlock()
+
+	if n := deny.lookup(ip); n != nil {
+		atomic.AddInt64(n.rejected, 1)
+		return n.rule, false
+	}
+	if allow.empty() || allow.lookup(ip) != nil {
+		return "", true
+	}
+	atomic.AddInt64(notAllowed, 1)
+	return RuleNotAllowed, false
+}
+
+func (p *IPFilterPlugin) allowedAddr(addr net.Addr) (string, bool) {
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil { // unix sockets and other networks without IPs
+		return "", true
+	}
+	return p.Allowed(ip)
+}
+
+// HandleConnAccept checks the client of conn.
+func (p *IPFilterPlugin) HandleConnAccept(conn net.Conn) (net.Conn, bool) {
+	if p.PerRequest {
+		return conn, true
+	}
+	_, ok := p.allowedAddr(conn.RemoteAddr())
+	return conn, ok
+}
+
+// PostReadRequest checks the client of the request if PerRequest is set.
+func (p *IPFilterPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if !p.PerRequest || e != nil {
+		return nil
+	}
+	conn, ok := ctx.Value(server.RemoteConnContextKey).(net.Conn)
+	if !ok {
+		return nil
+	}
+	if rule, ok := p.allowedAddr(conn.RemoteAddr()); !ok {
+		return rerrors.Errorf(rerrors.PermissionDenied, "rpcx: client %s is denied by %s", conn.RemoteAddr().String(), rule)
+	}
+	return nil
+}
+
+type ipFilterRules struct {
+	Allow    *[]string        `json:"allow,omitempty"`
+	Deny     *[]string        `json:"deny,omitempty"`
+	Rejected map[string]int64 `json:"rejected,omitempty"`
+}
+
+// ServeHTTP serves the lists and rejection counts, and replaces the lists by PUT.
+// Lists that are absent are not changed. It can be mounted on the admin API of the server:
+//
+//	s.HandleAdmin("/ipfilter", p)
+func (p *IPFilterPlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var rules ipFilterRules
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		allow, deny := p.Rules()
+		if rules.Allow != nil {
+			allow = *rules.Allow
+		}
+		if rules.Deny != nil {
+			deny = *rules.Deny
+		}
+		if err := p.SetRules(allow, deny); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	allow, deny := p.Rules()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ipFilterRules{Allow: &allow, Deny: &deny, Rejected: p.Rejected()})
+}
+
+// ipTrie is a binary radix tree of IP prefixes.
+type ipTrie struct {
+	v4, v6 *ipTrieNode
+	size   int
+}
+
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	rule     string // non-empty if a prefix ends here
+	rejected *int64
+}
+
+func newIPTrie(rules []string, counter func(rule string) *int64) (*ipTrie, error) {
+	t := &ipTrie{v4: &ipTrieNode{}, v6: &ipTrieNode{}}
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		ipNet, err := parseIPRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		t.insert(ipNet, rule, counter(rule))
+	}
+	return t, nil
+}
+
+func parseIPRule(rule string) (*net.IPNet, error) {
+	if strings.Contains(rule, "/") {
+		_, ipNet, err := net.ParseCIDR(rule)
+		return ipNet, err
+	}
+	ip := net.ParseIP(rule)
+	if ip == nil {
+		return nil, fmt.Errorf("rpcx: invalid IP rule %q", rule)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func (t *ipTrie) root(ip net.IP) (*ipTrieNode, net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return t.v4, ip4
+	}
+	return t.v6, ip.To16()
+}
+
+func (t *ipTrie) insert(ipNet *net.IPNet, rule string, rejected *int64) {
+	n, ip := t.v6, ipNet.IP.To16()
+	ones, bits := ipNet.Mask.Size()
+	if ip4 := ipNet.IP.To4(); ip4 != nil && (bits == 32 || ones >= 96) {
+		// IPv4-mapped IPv6 prefixes are stored as IPv4 ones
+		if bits == 128 {
+			ones -= 96
+		}
+		n, ip = t.v4, ip4
+	}
+	for i := 0; i < ones; i++ {
+		b := ip[i/8] >> (7 - uint(i%8)) & 1
+		if n.children[b] == nil {
+			n.children[b] = &ipTrieNode{}
+		}
+		n = n.children[b]
+	}
+	if n.rule == "" {
+		t.size++
+	}
+	n.rule, n.rejected = rule, rejected
+}
+
+// lookup returns the node of the longest prefix that contains ip, or nil if there is none.
+func (t *ipTrie) lookup(ip net.IP) *ipTrieNode {
+	n, ip := t.root(ip)
+	if ip == nil {
+		return nil
+	}
+	var match *ipTrieNode
+	for i := 0; n != nil; i++ {
+		if n.rule != "" {
+			match = n
+		}
+		if i == len(ip)*8 {
+			break
+		}
+		n = n.children[ip[i/8]>>(7-uint(i%8))&1]
+	}
+	return match
+}
+
+func (t *ipTrie) empty() bool {
+	return t.size == 0
+}
@@ -0,0 +1,157 @@
+package serverplugin
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+func TestIPFilterPlugin_Allowed(t *testing.T) {
+	p, err := NewIPFilterPlugin(
+		[]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"},
+		[]string{"10.1.0.0/16", "10.1.2.0/24", "2001:db8:bad::/48", "::ffff:10.9.0.0/112"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ip   string
+		rule string
+		ok   bool
+	}{
+		{"10.2.3.4", "", true},
+		{"192.168.1.1", "", true},
+		{"192.168.1.2", RuleNotAllowed, false},
+		{"10.1.3.4", "10.1.0.0/16", false},
+		{"10.1.2.3", "10.1.2.0/24", false}, // the most specific rule
+		{"10.9.1.1", "::ffff:10.9.0.0/112", false},
+		{"2001:db8::1", "", true},
+		{"2001:db8:bad::1", "2001:db8:bad::/48", false},
+		{"2001:db9::1", RuleNotAllowed, false},
+	}
+	for _, c := range cases {
+		rule, ok := p.Allowed(net.ParseIP(c.ip))
+		if rule != c.rule || ok != c.ok {
+			t.Errorf("%s: expect %q, %t but got %q, %t", c.ip, c.rule, c.ok, rule, ok)
+		}
+	}
+
+	rejected := p.Rejected()
+	if rejected[RuleNotAllowed] != 2 || rejected["10.1.2.0/24"] != 1 || len(rejected) != 5 {
+		t.Errorf("unexpected rejections: %v", rejected)
+	}
+
+	// update at runtime, counts of kept rules are kept
+	if err := p.SetRules(nil, []string{"10.1.2.0/24", "bad"}); err == nil {
+		t.Error("expect error for invalid rules")
+	}
+	if err := p.SetRules(nil, []string{"10.1.2.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Allowed(net.ParseIP("192.168.1.2")); !ok {
+		t.Error("expect all clients allowed without allow list")
+	}
+	if _, ok := p.Allowed(net.ParseIP("10.1.2.3")); ok {
+		t.Error("expect 10.1.2.3 denied")
+	}
+	rejected = p.Rejected()
+	if rejected["10.1.2.0/24"] != 2 || len(rejected) != 2 {
+		t.Errorf("unexpected rejections: %v", rejected)
+	}
+}
+
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr { return c.addr }
+
+func TestIPFilterPlugin_Conn(t *testing.T) {
+	p, _ := NewIPFilterPlugin(nil, []string{"127.0.0.0/8"})
+	denied := &addrConn{addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}}
+	allowed := &addrConn{addr: &net.TCPAddr{IP: net.ParseIP("::1"), Port: 1234}}
+	unix := &addrConn{addr: &net.UnixAddr{Name: "/tmp/rpcx.sock", Net: "unix"}}
+
+	if _, ok := p.HandleConnAccept(denied); ok {
+		t.Error("expect conn denied")
+	}
+	if _, ok := p.HandleConnAccept(allowed); !ok {
+		t.Error("expect conn allowed")
+	}
+	if _, ok := p.HandleConnAccept(unix); !ok {
+		t.Error("expect unix conn allowed")
+	}
+
+	// per request
+	p.PerRequest = true
+	if _, ok := p.HandleConnAccept(denied); !ok {
+		t.Error("expect conn checked per request")
+	}
+	ctx := share.WithValue(context.Background(), server.RemoteConnContextKey, net.Conn(denied))
+	err := p.PostReadRequest(ctx, protocol.NewMessage(), nil)
+	if rerrors.CodeOf(err) != rerrors.PermissionDenied {
+		t.Errorf("expect PermissionDenied but got %v", err)
+	}
+	ctx = share.WithValue(context.Background(), server.RemoteConnContextKey, net.Conn(allowed))
+	if err := p.PostReadRequest(ctx, protocol.NewMessage(), nil); err != nil {
+		t.Errorf("expect no error but got %v", err)
+	}
+}
+
+func TestIPFilterPlugin_ServeHTTP(t *testing.T) {
+	p, _ := NewIPFilterPlugin([]string{"10.0.0.0/8"}, nil)
+	p.Allowed(net.ParseIP("192.168.0.1"))
+
+	req := httptest.NewRequest(http.MethodPut, "/ipfilter", strings.NewReader(`{"deny":["10.1.0.0/16"]}`))
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect 200 but got %d: %s", w.Code, w.Body.String())
+	}
+	var rules struct {
+		Allow    []string
+		Deny     []string
+		Rejected map[string]int64
+	}
+	json.NewDecoder(w.Body).Decode(&rules)
+	if len(rules.Allow) != 1 || len(rules.Deny) != 1 || rules.Rejected[RuleNotAllowed] != 1 {
+		t.Errorf("unexpected rules: %+v", rules)
+	}
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/ipfilter", strings.NewReader(`{"deny":["10.1.0.0/99"]}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expect 400 but got %d", w.Code)
+	}
+}
+
+func BenchmarkIPFilterPlugin_Allowed(b *testing.B) {
+	deny := make([]string, 50000)
+	for i := range deny {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(i)<<8|0x0b000000)
+		deny[i] = ip.String() + "/24"
+	}
+	p, err := NewIPFilterPlugin(nil, deny)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ip := net.ParseIP("192.168.1.1")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Allowed(ip)
+	}
+}
@@ -0,0 +1,235 @@
+// Package k8s advertises rpcx servers by annotations of their own Pods in Kubernetes.
+//
+// It is a separate module so that only servers using it depend on client-go.
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// DefaultAnnotationPrefix is the prefix of annotations written by K8sRegisterPlugin.
+	DefaultAnnotationPrefix = "rpcx.io/"
+
+	// AnnotationServices is the annotation of registered services, separated by commas.
+	AnnotationServices = "services"
+	// AnnotationHeartbeat is the annotation of the last time the server refreshed its annotations, in RFC 3339.
+	AnnotationHeartbeat = "heartbeat"
+)
+
+// K8sRegisterPlugin annotates the Pod of the server with its services and metadata such as weight, version and the advertised port,
+// so that clients discovering servers in Kubernetes and kubectl can see them, for example:
+//
+//	rpcx.io/services: Arith,Echo
+//	rpcx.io/weight: 10
+//	rpcx.io/heartbeat: 2021-05-01T12:00:00Z
+//
+// The service account of the Pod needs get and update permissions on pods.
+type K8sRegisterPlugin struct {
+	Namespace string
+	PodName   string
+	// Meta is written as annotations with AnnotationPrefix
+	Meta map[string]string
+	// AnnotationPrefix defaults to DefaultAnnotationPrefix
+	AnnotationPrefix string
+	// UpdateInterval is the interval of refreshing the heartbeat annotation. It is not refreshed if it is zero.
+	UpdateInterval time.Duration
+	// OnRefreshError is invoked when refreshing annotations fails. It is retried in the next interval.
+	OnRefreshError func(err error)
+
+	// Client is created from the in-cluster config by Start if it is nil
+	Client kubernetes.Interface
+
+	mu       sync.Mutex
+	services map[string]struct{}
+	started  bool
+
+	dying chan struct{}
+	done  chan struct{}
+}
+
+// NewK8sRegisterPlugin creates a K8sRegisterPlugin which annotates the Pod podName in namespace.
+func NewK8sRegisterPlugin(namespace, podName string, meta map[string]string) *K8sRegisterPlugin {
+	return &K8sRegisterPlugin{
+		Namespace:      namespace,
+		PodName:        podName,
+		Meta:           meta,
+		UpdateInterval: 30 * time.Second,
+		services:       make(map[string]struct{}),
+	}
+}
+
+// Start writes the annotations and starts refreshing the heartbeat.
+// It returns an error if the service account has no permission to update the Pod.
+func (p *K8sRegisterPlugin) Start() error {
+	if p.Client == nil {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return fmt.Errorf("rpcx: failed to load the in-cluster config of Kubernetes: %w", err)
+		}
+		client, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return fmt.Errorf("rpcx: failed to create the Kubernetes client: %w", err)
+		}
+		p.Client = client
+	}
+
+	p.mu.Lock()
+	p.started = true
+	p.mu.Unlock()
+	if err := p.update(context.Background()); err != nil {
+		return err
+	}
+
+	p.dying = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.refreshLoop()
+	return nil
+}
+
+func (p *K8sRegisterPlugin) refreshLoop() {
+	defer close(p.done)
+	if p.UpdateInterval <= 0 {
+		<-p.dying
+		return
+	}
+
+	ticker := time.NewTicker(p.UpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.dying:
+			return
+		case <-ticker.C:
+			if err := p.update(context.Background()); err != nil && p.OnRefreshError != nil {
+				p.OnRefreshError(err)
+			}
+		}
+	}
+}
+
+// Stop stops refreshing and removes the annotations.
+func (p *K8sRegisterPlugin) Stop() error {
+	p.mu.Lock()
+	started := p.started
+	p.started = false
+	p.mu.Unlock()
+	if !started {
+		return nil
+	}
+
+	close(p.dying)
+	<-p.done
+	return p.patch(context.Background(), func(annotations map[string]string) {
+		prefix := p.prefix()
+		for k := range annotations {
+			if strings.HasPrefix(k, prefix) {
+				delete(annotations, k)
+			}
+		}
+	})
+}
+
+// Register adds the service to the services annotation.
+func (p *K8sRegisterPlugin) Register(name string, rcvr interface{}, metadata string) error {
+	if name == "" {
+		return errors.New("rpcx: register service with an empty name")
+	}
+	p.mu.Lock()
+	p.services[name] = struct{}{}
+	started := p.started
+	p.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+	return p.update(context.Background())
+}
+
+// RegisterFunction adds the service of the function to the services annotation.
+func (p *K8sRegisterPlugin) RegisterFunction(serviceName, fname string, fn interface{}, metadata string) error {
+	return p.Register(serviceName, fn, metadata)
+}
+
+// Unregister removes the service from the services annotation, for example when the server drains.
+func (p *K8sRegisterPlugin) Unregister(name string) error {
+	p.mu.Lock()
+	delete(p.services, name)
+	started := p.started
+	p.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+	return p.update(context.Background())
+}
+
+func (p *K8sRegisterPlugin) prefix() string {
+	if p.AnnotationPrefix == "" {
+		return DefaultAnnotationPrefix
+	}
+	return p.AnnotationPrefix
+}
+
+// update writes the services, metadata and heartbeat annotations.
+func (p *K8sRegisterPlugin) update(ctx context.Context) error {
+	p.mu.Lock()
+	services := make([]string, 0, len(p.services))
+	for name := range p.services {
+		services = append(services, name)
+	}
+	p.mu.Unlock()
+	sort.Strings(services)
+
+	prefix := p.prefix()
+	return p.patch(ctx, func(annotations map[string]string) {
+		for k, v := range p.Meta {
+			annotations[prefix+k] = v
+		}
+		if len(services) > 0 {
+			annotations[prefix+AnnotationServices] = strings.Join(services, ",")
+		} else {
+			delete(annotations, prefix+AnnotationServices)
+		}
+		annotations[prefix+AnnotationHeartbeat] = time.Now().UTC().Format(time.RFC3339)
+	})
+}
+
+// patch changes annotations of the Pod, and retries if the Pod is updated concurrently.
+func (p *K8sRegisterPlugin) patch(ctx context.Context, change func(annotations map[string]string)) error {
+	pods := p.Client.CoreV1().Pods(p.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := pods.Get(ctx, p.PodName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		change(pod.Annotations)
+		_, err = pods.Update(ctx, pod, metav1.UpdateOptions{})
+		return err
+	})
+
+	switch {
+	case err == nil:
+		return nil
+	case apierrors.IsForbidden(err):
+		return fmt.Errorf("rpcx: no permission to update pod %s/%s, grant get and update on pods to its service account: %w", p.Namespace, p.PodName, err)
+	case apierrors.IsNotFound(err):
+		return fmt.Errorf("rpcx: pod %s/%s is not found: %w", p.Namespace, p.PodName, err)
+	}
+	return fmt.Errorf("rpcx: failed to update annotations of pod %s/%s: %w", p.Namespace, p.PodName, err)
+}
@@ -0,0 +1,116 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newFakePlugin(t *testing.T) (*K8sRegisterPlugin, *fake.Clientset) {
+	client := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "server-0",
+			Annotations: map[string]string{"other": "kept"},
+		},
+	})
+	p := NewK8sRegisterPlugin("default", "server-0", map[string]string{"weight": "10", "port": "8972"})
+	p.Client = client
+	return p, client
+}
+
+func podAnnotations(t *testing.T, p *K8sRegisterPlugin) map[string]string {
+	pod, err := p.Client.CoreV1().Pods("default").Get(context.Background(), "server-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pod.Annotations
+}
+
+func TestK8sRegisterPlugin(t *testing.T) {
+	p, _ := newFakePlugin(t)
+	p.UpdateInterval = 20 * time.Millisecond
+
+	if err := p.Register("Arith", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	p.Register("Echo", nil, "")
+
+	annotations := podAnnotations(t, p)
+	if annotations["rpcx.io/services"] != "Arith,Echo" || annotations["rpcx.io/weight"] != "10" || annotations["rpcx.io/port"] != "8972" {
+		t.Errorf("unexpected annotations: %v", annotations)
+	}
+	heartbeat, err := time.Parse(time.RFC3339, annotations["rpcx.io/heartbeat"])
+	if err != nil {
+		t.Fatalf("invalid heartbeat: %v", err)
+	}
+
+	// the heartbeat is refreshed
+	time.Sleep(1100 * time.Millisecond)
+	refreshed, _ := time.Parse(time.RFC3339, podAnnotations(t, p)["rpcx.io/heartbeat"])
+	if !refreshed.After(heartbeat) {
+		t.Errorf("expect the heartbeat to be refreshed after %v but got %v", heartbeat, refreshed)
+	}
+
+	p.Unregister("Echo")
+	if s := podAnnotations(t, p)["rpcx.io/services"]; s != "Arith" {
+		t.Errorf("expect Arith but got %q", s)
+	}
+
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	annotations = podAnnotations(t, p)
+	if len(annotations) != 1 || annotations["other"] != "kept" {
+		t.Errorf("expect annotations of rpcx to be removed but got %v", annotations)
+	}
+}
+
+func TestK8sRegisterPluginConflict(t *testing.T) {
+	p, client := newFakePlugin(t)
+
+	var conflicts int32
+	client.PrependReactor("update", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if atomic.AddInt32(&conflicts, 1) <= 2 {
+			// the pod is updated by others between get and update
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, "server-0", nil)
+		}
+		return false, nil, nil
+	})
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+	if atomic.LoadInt32(&conflicts) != 3 {
+		t.Errorf("expect 2 retries but got %d updates", conflicts)
+	}
+	if podAnnotations(t, p)["rpcx.io/weight"] != "10" {
+		t.Error("expect annotations after retries")
+	}
+}
+
+func TestK8sRegisterPluginForbidden(t *testing.T) {
+	p, client := newFakePlugin(t)
+	client.PrependReactor("update", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "server-0", nil)
+	})
+
+	err := p.Start()
+	if err == nil || !apierrors.IsForbidden(err) || !strings.Contains(err.Error(), "grant get and update on pods") {
+		t.Fatalf("expect a clear RBAC error but got %v", err)
+	}
+}
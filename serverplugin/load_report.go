@@ -0,0 +1,123 @@
+package serverplugin
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/valyala/fastrand"
+)
+
+const (
+	// DefaultLoadReportSampleRate is the default LoadReportPlugin.SampleRate.
+	DefaultLoadReportSampleRate = 0.1
+	// DefaultLoadReportMaxInFlight is the default LoadReportPlugin.MaxInFlight of servers without worker pools.
+	DefaultLoadReportMaxInFlight = 1000
+)
+
+// LoadReportPlugin attaches load reports of the server to a sampled fraction of responses under protocol.LoadReportKey,
+// by which client.LoadAwareSelector balances calls. Servers whose health statuses are not SERVING, such as servers
+// which are shutting down, attach reports of weight 0 to all responses, so clients stop selecting them
+// before discoveries are updated.
+//
+// The advisory weight is 100 for idle servers and decreases with the greater of the in-flight requests
+// by MaxInFlight and the CPU utilization, but not below 1 unless the server is draining.
+type LoadReportPlugin struct {
+	// SampleRate is the fraction of responses with load reports, DefaultLoadReportSampleRate if it is zero.
+	SampleRate float64
+	// MaxInFlight is the number of in-flight requests with which the server is fully loaded. If it is zero,
+	// it is the size of the worker pool and its queue for servers WithWorkerPool, or DefaultLoadReportMaxInFlight.
+	MaxInFlight int
+
+	s *server.Server
+
+	cpuMu     sync.Mutex
+	cpuAt     time.Time
+	cpuTime   time.Duration
+	cpuLatest int
+}
+
+// NewLoadReportPlugin creates a LoadReportPlugin of s.
+func NewLoadReportPlugin(s *server.Server) *LoadReportPlugin {
+	p := &LoadReportPlugin{s: s}
+	p.cpuTime, _ = processCPUTime()
+	p.cpuAt = time.Now()
+	return p
+}
+
+// PreEncodeResponse attaches the load report to sampled responses.
+func (p *LoadReportPlugin) PreEncodeResponse(ctx context.Context, req *protocol.Message, res *protocol.Message) error {
+	if req.IsHeartbeat() || req.IsOneway() {
+		return nil
+	}
+	draining := p.s.CheckHealth(req.ServicePath) != server.HealthServing
+	if !draining {
+		rate := p.SampleRate
+		if rate == 0 {
+			rate = DefaultLoadReportSampleRate
+		}
+		if rate < 1 && float64(fastrand.Uint32n(1<<20)) >= rate*(1<<20) {
+			return nil
+		}
+	}
+
+	if res.Metadata == nil {
+		res.Metadata = make(map[string]string)
+	}
+	res.Metadata[protocol.LoadReportKey] = p.report(draining).String()
+	return nil
+}
+
+// Report returns the load report of the server right now.
+func (p *LoadReportPlugin) Report() protocol.LoadReport {
+	return p.report(p.s.CheckHealth("") != server.HealthServing)
+}
+
+func (p *LoadReportPlugin) report(draining bool) protocol.LoadReport {
+	load := p.s.Load()
+	r := protocol.LoadReport{InFlight: int(load.InFlight), QueueDepth: load.QueueDepth, CPU: p.cpu()}
+	if draining {
+		return r
+	}
+
+	max := p.MaxInFlight
+	if max <= 0 {
+		max = DefaultLoadReportMaxInFlight
+		if load.WorkerPoolSize > 0 {
+			max = load.WorkerPoolSize + load.QueueSize
+		}
+	}
+	utilization := float64(r.InFlight) / float64(max)
+	if cpu := float64(r.CPU) / 100; cpu > utilization {
+		utilization = cpu
+	}
+	r.Weight = int(100*(1-utilization) + 0.5)
+	if r.Weight < 1 {
+		r.Weight = 1
+	}
+	return r
+}
+
+// cpu estimates the CPU utilization of the process by its CPU time in the last second or more, in percents of all CPUs.
+func (p *LoadReportPlugin) cpu() int {
+	p.cpuMu.Lock()
+	defer p.cpuMu.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(p.cpuAt)
+	if elapsed < time.Second {
+		return p.cpuLatest
+	}
+	t, ok := processCPUTime()
+	if !ok {
+		return 0
+	}
+	p.cpuLatest = int(100 * float64(t-p.cpuTime) / float64(elapsed) / float64(runtime.NumCPU()))
+	if p.cpuLatest > 100 {
+		p.cpuLatest = 100
+	}
+	p.cpuAt, p.cpuTime = now, t
+	return p.cpuLatest
+}
@@ -0,0 +1,141 @@
+package serverplugin
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+type countingArith struct {
+	calls int32
+}
+
+func (a *countingArith) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	atomic.AddInt32(&a.calls, 1)
+	reply.C = args.A * args.B
+	return nil
+}
+
+func startLoadReportServer(t *testing.T, sampleRate float64) (*server.Server, *countingArith) {
+	s := server.NewServer()
+	p := NewLoadReportPlugin(s)
+	p.SampleRate = sampleRate
+	s.Plugins.Add(p)
+	arith := &countingArith{}
+	s.RegisterName("Arith", arith, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, arith
+}
+
+func TestLoadReportPlugin(t *testing.T) {
+	s, _ := startLoadReportServer(t, 1)
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	meta := make(map[string]string)
+	ctx := context.WithValue(context.Background(), share.ResMetaDataKey, meta)
+	if err := c.Call(ctx, "Arith", "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	// reports are consumed by clients
+	if _, ok := meta[protocol.LoadReportKey]; ok {
+		t.Fatalf("unexpected load report in metadata %v", meta)
+	}
+
+	p := &LoadReportPlugin{s: s, SampleRate: 1}
+	for i, sample := range []struct {
+		req  *protocol.Message
+		want bool
+	}{
+		{req: protocol.NewMessage(), want: true},
+		{req: heartbeat(), want: false},
+	} {
+		res := &protocol.Message{}
+		if err := p.PreEncodeResponse(context.Background(), sample.req, res); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := res.Metadata[protocol.LoadReportKey]; ok != sample.want {
+			t.Fatalf("unexpected report of %d: %v", i, res.Metadata)
+		}
+	}
+
+	// never sampled
+	p.SampleRate = 0.0000001
+	res := &protocol.Message{}
+	p.PreEncodeResponse(context.Background(), protocol.NewMessage(), res)
+	if len(res.Metadata) != 0 {
+		t.Fatalf("unexpected report %v", res.Metadata)
+	}
+
+	// draining servers report weight 0 in all responses
+	s.SetHealthStatus("", server.HealthNotServing)
+	p.PreEncodeResponse(context.Background(), protocol.NewMessage(), res)
+	report, ok := protocol.ParseLoadReport(res.Metadata[protocol.LoadReportKey])
+	if !ok || report.Weight != 0 {
+		t.Fatalf("expect weight 0 but got %q", res.Metadata[protocol.LoadReportKey])
+	}
+}
+
+func heartbeat() *protocol.Message {
+	m := protocol.NewMessage()
+	m.SetHeartbeat(true)
+	return m
+}
+
+func TestLoadReportWeight(t *testing.T) {
+	s, _ := startLoadReportServer(t, 1)
+	p := &LoadReportPlugin{s: s, MaxInFlight: 4}
+	if r := p.Report(); r.Weight < 1 || r.Weight > 100 || r.InFlight != 0 {
+		t.Fatalf("unexpected report of an idle server %+v", r)
+	}
+	p.cpuLatest, p.cpuAt = 80, time.Now()
+	if r := p.Report(); r.CPU != 80 || r.Weight != 20 {
+		t.Fatalf("expect the weight by CPU but got %+v", r)
+	}
+	p.cpuLatest = 100
+	if r := p.Report(); r.Weight != 1 {
+		t.Fatalf("expect the least weight of serving servers but got %+v", r)
+	}
+}
+
+func TestLoadAwareSelectorAvoidsDrainingServers(t *testing.T) {
+	s1, arith1 := startLoadReportServer(t, 1)
+	s2, arith2 := startLoadReportServer(t, 1)
+	s2.SetHealthStatus("", server.HealthNotServing)
+
+	d, err := client.NewMultipleServersDiscovery([]*client.KVPair{
+		{Key: "tcp@" + s1.Address().String()},
+		{Key: "tcp@" + s2.Address().String()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xclient := client.NewXClient("Arith", client.Failfast, client.LoadAware, d, client.DefaultOption)
+	defer xclient.Close()
+
+	for i := 0; i < 50; i++ {
+		if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the draining server is not selected since its first response
+	if n := atomic.LoadInt32(&arith2.calls); n > 1 {
+		t.Fatalf("expect the draining server not to be selected but it handled %d calls", n)
+	}
+	if n := atomic.LoadInt32(&arith1.calls); n < 49 {
+		t.Fatalf("expect the serving server to handle the calls but it handled %d", n)
+	}
+}
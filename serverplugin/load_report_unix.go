@@ -0,0 +1,17 @@
+// +build !windows
+
+package serverplugin
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime returns the user and system CPU time of the process.
+func processCPUTime() (time.Duration, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, false
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), true
+}
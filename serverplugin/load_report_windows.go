@@ -0,0 +1,8 @@
+package serverplugin
+
+import "time"
+
+// processCPUTime is not supported on windows, whose load reports have no CPU utilization.
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
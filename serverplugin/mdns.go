@@ -14,6 +14,7 @@ import (
 
 	"github.com/grandcat/zeroconf"
 	metrics "github.com/rcrowley/go-metrics"
+	"github.com/smallnest/rpcx/share"
 )
 
 type serviceMeta struct {
@@ -158,7 +159,8 @@ func (p *MDNSRegisterPlugin) PreCall(_ context.Context, _, _ string, args interf
 }
 
 // Register handles registering event.
-// this service is registered at BASE/serviceName/thisIpAddress node
+// this service is registered at BASE/serviceName/thisIpAddress node,
+// or BASE/serviceName/thisIpAddress#registration for registrations with IDs, see share.MetaRegistration
 func (p *MDNSRegisterPlugin) Register(name string, rcvr interface{}, metadata string) (err error) {
 	if strings.TrimSpace(name) == "" {
 		err = errors.New("Register service `name` can't be empty")
@@ -168,10 +170,14 @@ func (p *MDNSRegisterPlugin) Register(name string, rcvr interface{}, metadata st
 	sm := &serviceMeta{
 		Service:        name,
 		Meta:           metadata,
-		ServiceAddress: p.ServiceAddress,
+		ServiceAddress: share.RegistrationKey(p.ServiceAddress, share.RegistrationOf(metadata)),
 	}
 
-	p.Services = append(p.Services, sm)
+	if old := p.serviceMeta(name, sm.ServiceAddress); old != nil {
+		old.Meta = metadata
+	} else {
+		p.Services = append(p.Services, sm)
+	}
 
 	if p.server == nil {
 		p.initMDNS()
@@ -184,6 +190,30 @@ func (p *MDNSRegisterPlugin) Register(name string, rcvr interface{}, metadata st
 	return
 }
 
+// UpdateMetadata updates metadata of the registered service in place without unregistering it.
+func (p *MDNSRegisterPlugin) UpdateMetadata(name, metadata string) error {
+	sm := p.serviceMeta(name, share.RegistrationKey(p.ServiceAddress, share.RegistrationOf(metadata)))
+	if sm == nil || p.server == nil {
+		return fmt.Errorf("service %s is not registered", name)
+	}
+	sm.Meta = metadata
+
+	ss, _ := json.Marshal(p.Services)
+	s := url.QueryEscape(string(ss))
+	p.server.SetText([]string{s})
+	return nil
+}
+
+// serviceMeta returns the registration of the service name whose key is address, or nil if there is none.
+func (p *MDNSRegisterPlugin) serviceMeta(name, address string) *serviceMeta {
+	for _, sm := range p.Services {
+		if sm.Service == name && sm.ServiceAddress == address {
+			return sm
+		}
+	}
+	return nil
+}
+
 func (p *MDNSRegisterPlugin) RegisterFunction(serviceName, fname string, fn interface{}, metadata string) error {
 	return p.Register(serviceName, fn, metadata)
 }
@@ -217,3 +247,25 @@ func (p *MDNSRegisterPlugin) Unregister(name string) (err error) {
 
 	return
 }
+
+// UnregisterRegistration unregisters the registration of a service registered more than once,
+// and keeps its other registrations.
+func (p *MDNSRegisterPlugin) UnregisterRegistration(name, registration string) error {
+	address := share.RegistrationKey(p.ServiceAddress, registration)
+	if p.serviceMeta(name, address) == nil || p.server == nil {
+		return fmt.Errorf("registration %s of service %s is not registered", registration, name)
+	}
+
+	var services = make([]*serviceMeta, 0, len(p.Services)-1)
+	for _, meta := range p.Services {
+		if meta.Service != name || meta.ServiceAddress != address {
+			services = append(services, meta)
+		}
+	}
+	p.Services = services
+
+	ss, _ := json.Marshal(p.Services)
+	s := url.QueryEscape(string(ss))
+	p.server.SetText([]string{s})
+	return nil
+}
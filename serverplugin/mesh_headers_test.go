@@ -0,0 +1,160 @@
+package serverplugin
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// meshSeen is what a service sees in the context of a request.
+type meshSeen struct {
+	requestID string
+	header    string
+	traceID   string
+}
+
+// meshService records contexts of requests, and forwards them to the next server if there is one.
+type meshService struct {
+	next client.XClient
+
+	mu   sync.Mutex
+	seen []meshSeen
+}
+
+func (m *meshService) Hop(ctx context.Context, args *Args, reply *Reply) error {
+	m.mu.Lock()
+	m.seen = append(m.seen, meshSeen{
+		requestID: server.RequestID(ctx),
+		header:    share.HeaderValue(ctx, share.RequestIDHeader),
+		traceID:   trace.SpanContextFromContext(ctx).TraceID().String(),
+	})
+	m.mu.Unlock()
+	if m.next == nil {
+		return nil
+	}
+	return m.next.Call(ctx, "Hop", args, reply)
+}
+
+func (m *meshService) last() meshSeen {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.seen[len(m.seen)-1]
+}
+
+func startMeshServer(t *testing.T, tp trace.TracerProvider, svc *meshService, opts ...server.OptionFn) string {
+	s := server.NewServer(opts...)
+	s.Plugins.Add(NewOpenTelemetryPlugin(tp, nil))
+	s.RegisterName("Mesh", svc, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { s.Close() })
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s.Address().String()
+}
+
+func TestMeshHeadersThroughGateway(t *testing.T) {
+	tp := oteltest.NewTracerProvider()
+	b := &meshService{}
+	addrB := startMeshServer(t, tp, b)
+
+	d, _ := client.NewPeer2PeerDiscovery("tcp@"+addrB, "")
+	xc := client.NewXClient("Mesh", client.Failfast, client.RandomSelect, d, client.DefaultOption)
+	defer xc.Close()
+	plugins := client.NewPluginContainer()
+	plugins.Add(client.NewOpenTelemetryPlugin(tp, nil))
+	xc.SetPlugins(plugins)
+	a := &meshService{next: xc}
+	addrA := startMeshServer(t, tp, a, server.WithGatewayHeaders())
+
+	cases := []struct {
+		name    string
+		headers map[string]string
+		traceID string
+	}{
+		{"b3", map[string]string{
+			"X-B3-TraceId": "463ac35c9f6413ad48485a3953bb6124",
+			"X-B3-SpanId":  "a2fb4a1d1a96d312",
+			"X-B3-Sampled": "1",
+		}, "463ac35c9f6413ad48485a3953bb6124"},
+		{"b3 single", map[string]string{"B3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"}, "80f198ee56343ba864fe8b2a57d3eff7"},
+		{"w3c", map[string]string{"Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, "4bf92f3577b34da6a3ce929d0e0e4736"},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(http.MethodPost, "http://"+addrA+"/", strings.NewReader(`{"A":1,"B":2}`))
+		req.Header.Set(server.XServicePath, "Mesh")
+		req.Header.Set(server.XServiceMethod, "Hop")
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-Id", "req-"+c.name)
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d: %s", c.name, res.StatusCode, res.Header.Get(server.XErrorMessage))
+		}
+		if id := res.Header.Get("X-Request-Id"); id != "req-"+c.name {
+			t.Fatalf("%s: expect the request ID to be echoed but got %q", c.name, id)
+		}
+
+		want := meshSeen{requestID: "req-" + c.name, header: "req-" + c.name, traceID: c.traceID}
+		if seen := a.last(); seen != want {
+			t.Fatalf("%s: expect %+v in server A but got %+v", c.name, want, seen)
+		}
+		if seen := b.last(); seen != want {
+			t.Fatalf("%s: expect %+v in server B but got %+v", c.name, want, seen)
+		}
+	}
+}
+
+func TestB3Propagator(t *testing.T) {
+	tid, _ := trace.TraceIDFromHex("0000000000000000a3ce929d0e0e4736")
+	sid, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid, TraceFlags: trace.FlagsSampled})
+	ctx, span := oteltest.NewTracerProvider().Tracer("test").Start(trace.ContextWithRemoteSpanContext(context.Background(), sc), "span")
+	defer span.End()
+	sid = span.SpanContext().SpanID()
+
+	meta := share.MetadataCarrier{}
+	client.B3Propagator{}.Inject(ctx, meta)
+	if meta["x-b3-traceid"] != tid.String() || meta["x-b3-spanid"] != sid.String() || meta["x-b3-sampled"] != "1" {
+		t.Fatalf("unexpected headers %v", meta)
+	}
+	single := share.MetadataCarrier{}
+	client.B3Propagator{SingleHeader: true}.Inject(ctx, single)
+	if single["b3"] != tid.String()+"-"+sid.String()+"-1" {
+		t.Fatalf("unexpected header %v", single)
+	}
+	unsampled := share.MetadataCarrier{"b3": tid.String() + "-" + sid.String() + "-0-00f067aa0ba902b7"}
+
+	// trace IDs of 64 bits are padded, and deferred decisions are sampled
+	got := trace.RemoteSpanContextFromContext(client.B3Propagator{}.Extract(context.Background(),
+		share.MetadataCarrier{"x-b3-traceid": "a3ce929d0e0e4736", "x-b3-spanid": "00f067aa0ba902b7"}))
+	if got.TraceID() != tid || got.SpanID() != sc.SpanID() || !got.IsSampled() {
+		t.Fatalf("unexpected span context %+v", got)
+	}
+	got = trace.RemoteSpanContextFromContext(client.B3Propagator{}.Extract(context.Background(), unsampled))
+	if got.TraceID() != tid || got.SpanID() != sid || got.IsSampled() {
+		t.Fatalf("unexpected span context %+v", got)
+	}
+
+	for _, h := range []string{"1", "a3ce929d0e0e4736", "bad-00f067aa0ba902b7", tid.String() + "-" + sid.String() + "-x"} {
+		if got := trace.RemoteSpanContextFromContext(client.B3Propagator{}.Extract(context.Background(), share.MetadataCarrier{"b3": h})); got.IsValid() {
+			t.Fatalf("expect no span context of %q but got %+v", h, got)
+		}
+	}
+}
@@ -44,6 +44,18 @@ func (p *MetricsPlugin) HandleConnAccept(conn net.Conn) (net.Conn, bool) {
 	return conn, true
 }
 
+// HandleConnRejected counts connections rejected by connection limits.
+func (p *MetricsPlugin) HandleConnRejected(conn net.Conn, reason string) {
+	c := metrics.GetOrRegisterCounter(p.withPrefix("connRejected."+reason), p.Registry)
+	c.Inc(1)
+}
+
+// HandleConnCloseReason counts closed connections by the reason.
+func (p *MetricsPlugin) HandleConnCloseReason(conn net.Conn, reason string) {
+	c := metrics.GetOrRegisterCounter(p.withPrefix("connClosed."+reason), p.Registry)
+	c.Inc(1)
+}
+
 // PreReadRequest marks start time of calling service
 func (p *MetricsPlugin) PreReadRequest(ctx context.Context) error {
 	return nil
@@ -73,8 +85,30 @@ func (p *MetricsPlugin) PostWriteResponse(ctx context.Context, req *protocol.Mes
 
 	m := metrics.GetOrRegisterMeter(p.withPrefix("service."+sp+"."+sm+".Write_Qps"), p.Registry)
 	m.Mark(1)
+	p.observeCallTime(ctx, sp, sm)
+	return nil
+}
+
+// OnewayCompleted counts oneway requests, which have no responses, and their errors.
+func (p *MetricsPlugin) OnewayCompleted(ctx context.Context, req *protocol.Message, err error) {
+	sp := req.ServicePath
+	sm := req.ServiceMethod
+
+	if sp == "" {
+		return
+	}
+
+	m := metrics.GetOrRegisterMeter(p.withPrefix("service."+sp+"."+sm+".Oneway_Qps"), p.Registry)
+	m.Mark(1)
+	if err != nil {
+		c := metrics.GetOrRegisterCounter(p.withPrefix("service."+sp+"."+sm+".Oneway_Error"), p.Registry)
+		c.Inc(1)
+	}
+	p.observeCallTime(ctx, sp, sm)
+}
 
-	t := ctx.Value(server.StartRequestContextKey).(int64)
+func (p *MetricsPlugin) observeCallTime(ctx context.Context, sp, sm string) {
+	t, _ := ctx.Value(server.StartRequestContextKey).(int64)
 
 	if t > 0 {
 		t = time.Now().UnixNano() - t
@@ -85,7 +119,41 @@ func (p *MetricsPlugin) PostWriteResponse(ctx context.Context, req *protocol.Mes
 			h.Update(t)
 		}
 	}
-	return nil
+}
+
+// HandlePanic counts recovered panics.
+func (p *MetricsPlugin) HandlePanic(ctx context.Context, servicePath, serviceMethod string, recovered interface{}, stack []byte) {
+	c := metrics.GetOrRegisterCounter(p.withPrefix("service."+servicePath+"."+serviceMethod+".Panic"), p.Registry)
+	c.Inc(1)
+}
+
+// RegisterStats registers gauges of the stats of s, such as accepted, completed, in-flight and shed requests,
+// so that they are reported with other metrics.
+func (p *MetricsPlugin) RegisterStats(s *server.Server) {
+	gauges := map[string]func(st server.Stats) int64{
+		"stats.accepted":           func(st server.Stats) int64 { return int64(st.Accepted) },
+		"stats.completed":          func(st server.Stats) int64 { return int64(st.Completed) },
+		"stats.inFlight":           func(st server.Stats) int64 { return st.InFlight },
+		"stats.connections":        func(st server.Stats) int64 { return int64(st.Connections) },
+		"stats.queueDepth":         func(st server.Stats) int64 { return int64(st.QueueDepth) },
+		"stats.maxQueueDepth":      func(st server.Stats) int64 { return int64(st.MaxQueueDepth) },
+		"stats.compressBytesSaved": func(st server.Stats) int64 { return st.CompressBytesSaved },
+		"stats.onewayErrors":       func(st server.Stats) int64 { return int64(st.OnewayErrors) },
+	}
+	for _, reason := range []string{server.RejectReasonBusy, server.RejectReasonRateLimit} {
+		reason := reason
+		gauges["stats.shed."+reason] = func(st server.Stats) int64 { return int64(st.Shed[reason]) }
+	}
+	for _, reason := range []string{server.RejectReasonMaxConnections, server.RejectReasonMaxConnectionsPerIP} {
+		reason := reason
+		gauges["stats.connRejected."+reason] = func(st server.Stats) int64 { return int64(st.ConnsRejected[reason]) }
+	}
+
+	for name, fn := range gauges {
+		fn := fn
+		g := metrics.NewFunctionalGauge(func() int64 { return fn(s.Stats()) })
+		p.Registry.GetOrRegister(p.withPrefix(name), g)
+	}
 }
 
 // Log reports metrics into logs.
@@ -0,0 +1,34 @@
+// +build kcp
+
+package serverplugin
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"github.com/smallnest/rpcx/server"
+	kcp "github.com/xtaci/kcp-go"
+)
+
+// RegisterKCPStats registers gauges of server.KCPStats, such as retransmitted and lost segments,
+// to see the quality of KCP links.
+// They are accumulated for all KCP connections of the process because kcp-go doesn't keep them by connection.
+func (p *MetricsPlugin) RegisterKCPStats() {
+	gauges := map[string]func(st *kcp.Snmp) uint64{
+		"kcp.currEstab":        func(st *kcp.Snmp) uint64 { return st.CurrEstab },
+		"kcp.inSegs":           func(st *kcp.Snmp) uint64 { return st.InSegs },
+		"kcp.outSegs":          func(st *kcp.Snmp) uint64 { return st.OutSegs },
+		"kcp.retransSegs":      func(st *kcp.Snmp) uint64 { return st.RetransSegs },
+		"kcp.fastRetransSegs":  func(st *kcp.Snmp) uint64 { return st.FastRetransSegs },
+		"kcp.earlyRetransSegs": func(st *kcp.Snmp) uint64 { return st.EarlyRetransSegs },
+		"kcp.lostSegs":         func(st *kcp.Snmp) uint64 { return st.LostSegs },
+		"kcp.repeatSegs":       func(st *kcp.Snmp) uint64 { return st.RepeatSegs },
+		"kcp.fecRecovered":     func(st *kcp.Snmp) uint64 { return st.FECRecovered },
+		"kcp.fecErrs":          func(st *kcp.Snmp) uint64 { return st.FECErrs },
+		"kcp.inCsumErrors":     func(st *kcp.Snmp) uint64 { return st.InCsumErrors },
+	}
+
+	for name, fn := range gauges {
+		fn := fn
+		g := metrics.NewFunctionalGauge(func() int64 { return int64(fn(server.KCPStats())) })
+		p.Registry.GetOrRegister(p.withPrefix(name), g)
+	}
+}
@@ -62,3 +62,8 @@ func (p OpenCensusPlugin) PostWriteResponse(ctx context.Context, req *protocol.M
 	}
 	return nil
 }
+
+// OnewayCompleted ends the span of the oneway request.
+func (p OpenCensusPlugin) OnewayCompleted(ctx context.Context, req *protocol.Message, err error) {
+	p.PostWriteResponse(ctx, req, nil, err)
+}
@@ -0,0 +1,175 @@
+package serverplugin
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// RPCSystemRPCX is the value of the rpc.system attribute of spans.
+const RPCSystemRPCX = "rpcx"
+
+// Attributes of spans besides the RPC semantic conventions.
+const (
+	RPCRequestSizeKey  = attribute.Key("rpc.rpcx.request_size")
+	RPCResponseSizeKey = attribute.Key("rpc.rpcx.response_size")
+	RPCErrorCodeKey    = attribute.Key("rpc.rpcx.error_code")
+)
+
+const openTelemetryInstrumentation = "github.com/smallnest/rpcx/serverplugin"
+
+// OpenTelemetryPlugin starts a server span for every request, named servicePath.serviceMethod.
+// The trace context is extracted from metadata of requests, which is set by client.OpenTelemetryPlugin,
+// and the context of services contains the span so services can start child spans from it.
+// Requests that services push by Server.SendMessageContext with their context are recorded as child spans.
+//
+// Requests whose trace contexts are not sampled, such as calls dropped by samplers of client.OpenTelemetryPlugin,
+// start no spans, and the contexts of services have their trace contexts so that calls of services are not sampled either.
+type OpenTelemetryPlugin struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+type otelSpan struct {
+	trace.Span
+	once sync.Once
+}
+
+// end ends the span with err. Only the first call takes effect.
+func (s *otelSpan) end(err error, attrs ...attribute.KeyValue) {
+	s.once.Do(func() {
+		s.SetAttributes(attrs...)
+		if err != nil {
+			s.RecordError(err)
+			s.SetStatus(codes.Error, err.Error())
+			if code := rerrors.CodeOf(err); code != rerrors.Unknown {
+				s.SetAttributes(RPCErrorCodeKey.Int(int(code)))
+			}
+		}
+		s.End()
+	})
+}
+
+// NewOpenTelemetryPlugin creates an OpenTelemetryPlugin. The global TracerProvider is used if tp is nil,
+// and client.DefaultPropagator of W3C trace context and B3 is used if propagator is nil.
+func NewOpenTelemetryPlugin(tp trace.TracerProvider, propagator propagation.TextMapPropagator) *OpenTelemetryPlugin {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if propagator == nil {
+		propagator = client.DefaultPropagator
+	}
+	return &OpenTelemetryPlugin{
+		tracer:     tp.Tracer(openTelemetryInstrumentation),
+		propagator: propagator,
+	}
+}
+
+// PreHandleRequest starts the span and sets it in the context of the service.
+func (p *OpenTelemetryPlugin) PreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	rpcxContext, ok := ctx.(*share.Context)
+	if !ok {
+		return nil
+	}
+
+	parent := p.propagator.Extract(rpcxContext.Context, share.MetadataCarrier(r.Metadata))
+	if sc := trace.RemoteSpanContextFromContext(parent); sc.IsValid() && !sc.IsSampled() {
+		rpcxContext.Context = parent
+		return nil
+	}
+	// decoded strings share the pooled buffer of r, so they are copied for spans outliving r
+	attrs := []attribute.KeyValue{
+		semconv.RPCSystemKey.String(RPCSystemRPCX),
+		semconv.RPCServiceKey.String(string([]byte(r.ServicePath))),
+		semconv.RPCMethodKey.String(string([]byte(r.ServiceMethod))),
+		RPCRequestSizeKey.Int(len(r.Payload)),
+	}
+	if conn, ok := ctx.Value(server.RemoteConnContextKey).(net.Conn); ok {
+		attrs = append(attrs, peerAttributes(conn.RemoteAddr())...)
+	}
+	spanCtx, span := p.tracer.Start(parent, r.ServicePath+"."+r.ServiceMethod,
+		trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+
+	rpcxContext.Context = spanCtx
+	rpcxContext.SetValue(share.OpenTelemetrySpanServerKey, &otelSpan{Span: span})
+	return nil
+}
+
+// PostWriteResponse ends the span with the error of the request or of writing the response.
+func (p *OpenTelemetryPlugin) PostWriteResponse(ctx context.Context, req *protocol.Message, res *protocol.Message, err error) error {
+	if span, ok := ctx.Value(share.OpenTelemetrySpanServerKey).(*otelSpan); ok {
+		size := 0
+		if res != nil {
+			size = len(res.Payload)
+		}
+		span.end(err, RPCResponseSizeKey.Int(size))
+	}
+	return nil
+}
+
+// OnewayCompleted ends the span of the oneway request with the error of the handler.
+func (p *OpenTelemetryPlugin) OnewayCompleted(ctx context.Context, req *protocol.Message, err error) {
+	if span, ok := ctx.Value(share.OpenTelemetrySpanServerKey).(*otelSpan); ok {
+		span.end(err)
+	}
+}
+
+// HandlePanic ends the span with the panic.
+func (p *OpenTelemetryPlugin) HandlePanic(ctx context.Context, servicePath, serviceMethod string, recovered interface{}, stack []byte) {
+	if span, ok := ctx.Value(share.OpenTelemetrySpanServerKey).(*otelSpan); ok {
+		span.end(rerrors.Errorf(rerrors.Internal, "panic: %v", recovered))
+	}
+}
+
+// PostWriteRequest records requests pushed to clients as child spans of the span in ctx.
+func (p *OpenTelemetryPlugin) PostWriteRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+
+	start := time.Now()
+	if t, ok := ctx.Value(server.StartSendRequestContextKey).(int64); ok {
+		start = time.Unix(0, t)
+	}
+	_, span := p.tracer.Start(ctx, "push "+r.ServicePath+"."+r.ServiceMethod,
+		trace.WithSpanKind(trace.SpanKindProducer), trace.WithTimestamp(start),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String(RPCSystemRPCX),
+			semconv.RPCServiceKey.String(r.ServicePath),
+			semconv.RPCMethodKey.String(r.ServiceMethod),
+			RPCRequestSizeKey.Int(len(r.Payload)),
+		))
+	if e != nil {
+		span.RecordError(e)
+		span.SetStatus(codes.Error, e.Error())
+	}
+	span.End()
+	return nil
+}
+
+func peerAttributes(addr net.Addr) []attribute.KeyValue {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return []attribute.KeyValue{semconv.NetPeerNameKey.String(addr.String())}
+	}
+	attrs := []attribute.KeyValue{semconv.NetPeerIPKey.String(host)}
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, semconv.NetPeerPortKey.Int(p))
+	}
+	return attrs
+}
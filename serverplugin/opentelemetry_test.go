@@ -0,0 +1,259 @@
+package serverplugin
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+type tracedService struct {
+	s      *server.Server
+	tracer trace.Tracer
+}
+
+// Get starts a child span and pushes a message with the context of the request.
+func (t *tracedService) Get(ctx context.Context, args *Args, reply *Reply) error {
+	_, span := t.tracer.Start(ctx, "query")
+	span.End()
+
+	conn := ctx.Value(server.RemoteConnContextKey).(net.Conn)
+	t.s.SendMessageContext(ctx, conn, "Traced", "Notify", nil, []byte("hello"))
+	return nil
+}
+
+func (t *tracedService) Missing(ctx context.Context, args *Args, reply *Reply) error {
+	return server.Errorf(rerrors.NotFound, "not found")
+}
+
+func (t *tracedService) Panic(ctx context.Context, args *Args, reply *Reply) error {
+	panic("boom")
+}
+
+func completedSpans(sr *oteltest.SpanRecorder) map[string][]*oteltest.Span {
+	spans := make(map[string][]*oteltest.Span)
+	for _, span := range sr.Completed() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	return spans
+}
+
+func TestOpenTelemetryPlugin(t *testing.T) {
+	sr := new(oteltest.SpanRecorder)
+	tp := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr))
+
+	s := server.NewServer()
+	s.Plugins.Add(NewOpenTelemetryPlugin(tp, nil))
+	s.RegisterName("Traced", &tracedService{s: s, tracer: tp.Tracer("test")}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d, _ := client.NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	opt := client.DefaultOption
+	opt.Retries = 0
+	xc := client.NewXClient("Traced", client.Failfast, client.RandomSelect, d, opt)
+	defer xc.Close()
+	plugins := client.NewPluginContainer()
+	plugins.Add(client.NewOpenTelemetryPlugin(tp, nil))
+	xc.SetPlugins(plugins)
+
+	if err := xc.Call(context.Background(), "Get", &Args{}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	xc.Call(context.Background(), "Missing", &Args{}, &Reply{})
+	xc.Call(context.Background(), "Panic", &Args{}, &Reply{})
+	time.Sleep(100 * time.Millisecond)
+
+	spans := completedSpans(sr)
+	if len(spans["Traced.Get"]) != 2 || len(spans["query"]) != 1 || len(spans["push Traced.Notify"]) != 1 {
+		t.Fatalf("unexpected spans: %v", spans)
+	}
+	var clientSpan, serverSpan *oteltest.Span
+	for _, span := range spans["Traced.Get"] {
+		switch span.SpanKind() {
+		case trace.SpanKindClient:
+			clientSpan = span
+		case trace.SpanKindServer:
+			serverSpan = span
+		}
+	}
+	if clientSpan == nil || serverSpan == nil {
+		t.Fatal("expect a client span and a server span")
+	}
+	if serverSpan.SpanContext().TraceID() != clientSpan.SpanContext().TraceID() ||
+		serverSpan.ParentSpanID() != clientSpan.SpanContext().SpanID() {
+		t.Error("expect the server span is a child of the client span")
+	}
+	for _, name := range []string{"query", "push Traced.Notify"} {
+		if spans[name][0].ParentSpanID() != serverSpan.SpanContext().SpanID() {
+			t.Errorf("expect %s is a child of the server span", name)
+		}
+	}
+	attrs := serverSpan.Attributes()
+	if attrs["rpc.system"].AsString() != "rpcx" || attrs["rpc.service"].AsString() != "Traced" ||
+		attrs["rpc.method"].AsString() != "Get" || attrs["net.peer.ip"].AsString() != "127.0.0.1" {
+		t.Errorf("unexpected attributes: %v", attrs)
+	}
+
+	// errors are recorded with their codes, and spans are ended once even on panics
+	for _, name := range []string{"Traced.Missing", "Traced.Panic"} {
+		if len(spans[name]) != 2 {
+			t.Fatalf("expect 2 spans of %s but got %d", name, len(spans[name]))
+		}
+		for _, span := range spans[name] {
+			if span.StatusCode() != codes.Error {
+				t.Errorf("expect the error status of %s", name)
+			}
+		}
+	}
+	for _, span := range spans["Traced.Missing"] {
+		if span.Attributes()[RPCErrorCodeKey].AsInt64() != int64(rerrors.NotFound) {
+			t.Errorf("expect the code of the error but got %v", span.Attributes())
+		}
+	}
+	if started := len(sr.Started()); started != len(sr.Completed()) {
+		t.Errorf("expect all %d spans ended but got %d", started, len(sr.Completed()))
+	}
+}
+
+// sampledService records trace contexts of requests.
+type sampledService struct {
+	mu           sync.Mutex
+	traceparents []string
+}
+
+func (s *sampledService) record(ctx context.Context) {
+	meta, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	s.mu.Lock()
+	s.traceparents = append(s.traceparents, meta["traceparent"])
+	s.mu.Unlock()
+}
+
+func (s *sampledService) Checkout(ctx context.Context, args *Args, reply *Reply) error {
+	s.record(ctx)
+	return nil
+}
+
+func (s *sampledService) Health(ctx context.Context, args *Args, reply *Reply) error {
+	s.record(ctx)
+	return nil
+}
+
+func (s *sampledService) last() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.traceparents[len(s.traceparents)-1]
+}
+
+// countingTracerProvider counts spans started by its tracers.
+type countingTracerProvider struct {
+	trace.TracerProvider
+	starts int32
+}
+
+func (tp *countingTracerProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return countingTracer{Tracer: tp.TracerProvider.Tracer(name, opts...), starts: &tp.starts}
+}
+
+type countingTracer struct {
+	trace.Tracer
+	starts *int32
+}
+
+func (t countingTracer) Start(ctx context.Context, name string, opts ...trace.SpanOption) (context.Context, trace.Span) {
+	atomic.AddInt32(t.starts, 1)
+	return t.Tracer.Start(ctx, name, opts...)
+}
+
+func TestOpenTelemetrySampling(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	serverTP := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	clientTP := &countingTracerProvider{TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))}
+
+	s := server.NewServer()
+	s.Plugins.Add(NewOpenTelemetryPlugin(serverTP, nil))
+	service := &sampledService{}
+	s.RegisterName("Sampled", service, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d, _ := client.NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	xc := client.NewXClient("Sampled", client.Failfast, client.RandomSelect, d, client.DefaultOption)
+	defer xc.Close()
+	plugins := client.NewPluginContainer()
+	// health checks are not sampled
+	plugins.Add(client.NewOpenTelemetryPlugin(clientTP, nil, client.WithSampler(func(ctx context.Context, servicePath, serviceMethod string) sdktrace.SamplingDecision {
+		if serviceMethod == "Health" {
+			return sdktrace.Drop
+		}
+		return sdktrace.RecordAndSample
+	})))
+	xc.SetPlugins(plugins)
+
+	spansOf := func(name string) []trace.SpanKind {
+		var kinds []trace.SpanKind
+		for _, span := range exporter.GetSpans() {
+			if span.Name == name {
+				kinds = append(kinds, span.SpanKind)
+			}
+		}
+		return kinds
+	}
+
+	// sampled
+	if err := xc.Call(context.Background(), "Checkout", &Args{}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	if tp := service.last(); !strings.HasSuffix(tp, "-01") {
+		t.Fatalf("expect the sampled flag but got %q", tp)
+	}
+	if kinds := spansOf("Sampled.Checkout"); len(kinds) != 2 {
+		t.Fatalf("expect a client span and a server span but got %v", kinds)
+	}
+
+	// unsampled calls start no spans, and servers don't sample them
+	starts := atomic.LoadInt32(&clientTP.starts)
+	if err := xc.Call(context.Background(), "Health", &Args{}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	if tp := service.last(); tp == "" || !strings.HasSuffix(tp, "-00") {
+		t.Fatalf("expect a trace context without the sampled flag but got %q", tp)
+	}
+	if n := atomic.LoadInt32(&clientTP.starts); n != starts {
+		t.Fatalf("expect no spans started but got %d", n-starts)
+	}
+	if kinds := spansOf("Sampled.Health"); len(kinds) != 0 {
+		t.Fatalf("expect no spans but got %v", kinds)
+	}
+
+	// forced calls are sampled end to end
+	ctx := context.WithValue(context.Background(), share.ForceSampleContextKey, true)
+	if err := xc.Call(ctx, "Health", &Args{}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	if tp := service.last(); !strings.HasSuffix(tp, "-01") {
+		t.Fatalf("expect the sampled flag but got %q", tp)
+	}
+	kinds := spansOf("Sampled.Health")
+	if len(kinds) != 2 || kinds[0] == kinds[1] {
+		t.Fatalf("expect a client span and a server span but got %v", kinds)
+	}
+}
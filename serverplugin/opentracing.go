@@ -69,3 +69,8 @@ func (p OpenTracingPlugin) PostWriteResponse(ctx context.Context, req *protocol.
 	}
 	return nil
 }
+
+// OnewayCompleted finishes the span of the oneway request.
+func (p OpenTracingPlugin) OnewayCompleted(ctx context.Context, req *protocol.Message, err error) {
+	p.PostWriteResponse(ctx, req, nil, err)
+}
@@ -0,0 +1,175 @@
+package serverplugin
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+)
+
+// DefaultRawProxyPushQueueSize is the default RawProxy.PushQueueSize.
+const DefaultRawProxyPushQueueSize = 100
+
+// RawTarget is the target to which RawProxy forwards requests, such as a client.XClient, a client.OneClient or a client.Client.
+// It sends requests with its own sequences, and returns responses with the sequences of requests, see client.Client.SendRawMessage.
+type RawTarget interface {
+	SendRawMessage(ctx context.Context, r *protocol.Message) (*protocol.Message, error)
+}
+
+// RawDialFunc connects the target of a client connection of RawProxy. Messages that servers send to the target,
+// such as a client.Client with push registered by RegisterServerMessageChan, are relayed to the client connection.
+// ctx is the context of the first request of the connection.
+type RawDialFunc func(ctx context.Context, push chan *protocol.Message) (RawTarget, error)
+
+// RawProxy forwards requests to targets without decoding and encoding payloads, as proxies such as routers or sidecars
+// built on rpcx do. Register its Handle by server.RegisterRawHandler for the services to forward.
+// Responses keep the serialize types, compress types, status and metadata of servers, including errors.
+//
+// Clients of a proxy use their own sequences, so targets send requests with sequences of their own connections,
+// and responses get the sequences of requests back. RawProxy of NewConnRawProxy relays messages pushed by servers
+// to the client connection of the target with sequences of the proxy server, and it must be added to the plugins
+// of the proxy server so that targets are closed with their client connections.
+type RawProxy struct {
+	// PushQueueSize is the size of the push channel of each connection, DefaultRawProxyPushQueueSize if it is zero.
+	// Messages are dropped by targets if the channel is full.
+	PushQueueSize int
+
+	s      *server.Server
+	target RawTarget
+	dial   RawDialFunc
+
+	mu    sync.Mutex
+	conns map[net.Conn]*rawProxyConn
+}
+
+type rawProxyConn struct {
+	ready     chan struct{} // closed when the target is dialed
+	target    RawTarget
+	err       error
+	push      chan *protocol.Message
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (pc *rawProxyConn) close() {
+	pc.closeOnce.Do(func() { close(pc.closed) })
+}
+
+// NewRawProxy creates a RawProxy which forwards requests of all connections to target, such as a client.XClient
+// which selects servers with failover. Messages pushed by servers are not relayed since they can't be related to clients.
+func NewRawProxy(target RawTarget) *RawProxy {
+	return &RawProxy{target: target}
+}
+
+// NewConnRawProxy creates a RawProxy of s which forwards requests of each client connection to its own target,
+// dialed by dial for the first request of the connection, and relays messages pushed by servers back to the connection.
+// Targets are dialed again if the connections of their servers are closed, and targets which implement io.Closer
+// are closed with their client connections.
+func NewConnRawProxy(s *server.Server, dial RawDialFunc) *RawProxy {
+	return &RawProxy{s: s, dial: dial, conns: make(map[net.Conn]*rawProxyConn)}
+}
+
+// Handle forwards req to its target, and returns the response of the target. It is a server.RawHandler.
+func (p *RawProxy) Handle(ctx context.Context, req *protocol.Message) (*protocol.Message, error) {
+	target := p.target
+	if p.dial != nil {
+		conn, _ := ctx.Value(server.RemoteConnContextKey).(net.Conn)
+		t, err := p.connTarget(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		target = t
+	}
+
+	res, err := target.SendRawMessage(ctx, req)
+	if res != nil && res.MessageStatusType() == protocol.Error {
+		return res, nil // the error of the server is forwarded as it is
+	}
+	return res, err
+}
+
+// connTarget returns the target of conn, which is dialed if conn has none.
+func (p *RawProxy) connTarget(ctx context.Context, conn net.Conn) (RawTarget, error) {
+	p.mu.Lock()
+	pc := p.conns[conn]
+	if pc == nil {
+		size := p.PushQueueSize
+		if size <= 0 {
+			size = DefaultRawProxyPushQueueSize
+		}
+		pc = &rawProxyConn{ready: make(chan struct{}), push: make(chan *protocol.Message, size), closed: make(chan struct{})}
+		p.conns[conn] = pc
+		p.mu.Unlock()
+
+		pc.target, pc.err = p.dial(ctx, pc.push)
+		close(pc.ready)
+		if pc.err != nil {
+			p.remove(conn, pc) // dialed again by the next request
+		} else {
+			go p.relay(conn, pc)
+		}
+		return pc.target, pc.err
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-pc.ready:
+		return pc.target, pc.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// remove removes pc, the target of conn, and closes it.
+func (p *RawProxy) remove(conn net.Conn, pc *rawProxyConn) {
+	p.mu.Lock()
+	if p.conns[conn] == pc {
+		delete(p.conns, conn)
+	}
+	p.mu.Unlock()
+	pc.close()
+}
+
+// relay sends messages pushed to the target of pc to conn until conn or the connection of the target is closed.
+func (p *RawProxy) relay(conn net.Conn, pc *rawProxyConn) {
+	defer func() {
+		if c, ok := pc.target.(io.Closer); ok {
+			c.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-pc.closed:
+			return
+		case msg := <-pc.push:
+			if msg.MessageStatusType() == protocol.Error { // the connection of the target is closed
+				p.remove(conn, pc)
+				return
+			}
+			if err := p.s.SendRawMessage(context.Background(), conn, msg); err != nil {
+				log.Warnf("rpcx: failed to relay the message of %s.%s to %s: %v", msg.ServicePath, msg.ServiceMethod,
+					conn.RemoteAddr().String(), err)
+			}
+			msg.Free()
+		}
+	}
+}
+
+// HandleConnClose closes the target of conn.
+func (p *RawProxy) HandleConnClose(conn net.Conn) bool {
+	if p.dial == nil {
+		return true
+	}
+	p.mu.Lock()
+	pc := p.conns[conn]
+	p.mu.Unlock()
+	if pc != nil {
+		p.remove(conn, pc)
+	}
+	return true
+}
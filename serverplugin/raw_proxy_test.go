@@ -0,0 +1,194 @@
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+	"github.com/stretchr/testify/assert"
+)
+
+type proxyBackend struct {
+	name string
+	s    *server.Server
+}
+
+type EchoArgs struct {
+	Data string
+}
+
+// Mul multiplies args, and pushes the product to the caller.
+func (b *proxyBackend) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	if args.A < 0 {
+		return errors.New("negative")
+	}
+	reply.C = args.A * args.B
+	resMeta := ctx.Value(share.ResMetaDataKey).(map[string]string)
+	resMeta["backend"] = b.name
+	resMeta["tenant"] = ctx.Value(share.ReqMetaDataKey).(map[string]string)["tenant"]
+	conn := ctx.Value(server.RemoteConnContextKey).(net.Conn)
+	return b.s.SendMessage(conn, "Backend", "Event", map[string]string{"a": strconv.Itoa(args.A)}, []byte(strconv.Itoa(reply.C)))
+}
+
+func (b *proxyBackend) Echo(ctx context.Context, args *EchoArgs, reply *EchoArgs) error {
+	reply.Data = b.name + ":" + args.Data
+	return nil
+}
+
+func startRawProxyServer(t *testing.T, s *server.Server) string {
+	go s.Serve("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { s.Close() })
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s.Address().String()
+}
+
+func startProxyBackend(t *testing.T, name string) (*server.Server, string) {
+	s := server.NewServer()
+	s.RegisterName("Arith", &proxyBackend{name: name, s: s}, "")
+	return s, startRawProxyServer(t, s)
+}
+
+func TestConnRawProxy(t *testing.T) {
+	backend, backendAddr := startProxyBackend(t, "b1")
+
+	p := server.NewServer()
+	proxy := NewConnRawProxy(p, func(ctx context.Context, push chan *protocol.Message) (RawTarget, error) {
+		c := client.NewClient(client.DefaultOption)
+		c.RegisterServerMessageChan(push)
+		return c, c.Connect("tcp", backendAddr)
+	})
+	p.Plugins.Add(proxy)
+	assert.NoError(t, p.RegisterRawHandler("Arith", proxy.Handle))
+	proxyAddr := startRawProxyServer(t, p)
+
+	// concurrent calls of several clients get their own replies and server messages through the proxy
+	const clients, calls = 3, 30
+	var wg sync.WaitGroup
+	for i := 1; i <= clients; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			pushed := make(chan *protocol.Message, calls)
+			c := client.NewClient(client.DefaultOption)
+			c.RegisterServerMessageChan(pushed)
+			if err := c.Connect("tcp", proxyAddr); err != nil {
+				t.Error(err)
+				return
+			}
+			defer c.Close()
+
+			var cwg sync.WaitGroup
+			for a := 1; a <= calls; a++ {
+				cwg.Add(1)
+				go func(a int) {
+					defer cwg.Done()
+					resMeta := make(map[string]string)
+					ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"tenant": strconv.Itoa(id)})
+					ctx = context.WithValue(ctx, share.ResMetaDataKey, resMeta)
+					reply := &Reply{}
+					if err := c.Call(ctx, "Arith", "Mul", &Args{A: a, B: id}, reply); err != nil {
+						t.Error(err)
+						return
+					}
+					assert.Equal(t, a*id, reply.C)
+					assert.Equal(t, "b1", resMeta["backend"])
+					assert.Equal(t, strconv.Itoa(id), resMeta["tenant"])
+				}(a)
+			}
+			cwg.Wait()
+
+			seen := make(map[string]bool)
+			for len(seen) < calls {
+				select {
+				case msg := <-pushed:
+					a, _ := strconv.Atoi(msg.Metadata["a"])
+					assert.Equal(t, "Event", msg.ServiceMethod)
+					assert.Equal(t, strconv.Itoa(a*id), string(msg.Payload), "a message of another client")
+					seen[msg.Metadata["a"]] = true
+				case <-time.After(2 * time.Second):
+					t.Errorf("client %d got %d messages of %d", id, len(seen), calls)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// errors of servers are forwarded
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", proxyAddr); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Call(context.Background(), "Arith", "Mul", &Args{A: -1}, &Reply{})
+	assert.EqualError(t, err, "negative")
+
+	// targets are closed with their client connections
+	c.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(backend.Connections()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Len(t, backend.Connections(), 0)
+}
+
+func TestRawProxyFailover(t *testing.T) {
+	_, live := startProxyBackend(t, "live")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := ln.Addr().String()
+	ln.Close()
+
+	d, _ := client.NewMultipleServersDiscovery([]*client.KVPair{{Key: "tcp@" + dead}, {Key: "tcp@" + live}})
+	option := client.DefaultOption
+	option.ConnectTimeout = 100 * time.Millisecond
+	xclient := client.NewXClient("Arith", client.Failover, client.RoundRobin, d, option)
+	defer xclient.Close()
+
+	p := server.NewServer()
+	assert.NoError(t, p.RegisterRawHandler("Arith", NewRawProxy(xclient).Handle))
+	proxyAddr := startRawProxyServer(t, p)
+
+	option = client.DefaultOption
+	option.SerializeType = protocol.JSON
+	option.CompressType = protocol.Gzip
+	c := client.NewClient(option)
+	if err := c.Connect("tcp", proxyAddr); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data := strings.Repeat("x", 4096)
+	for i := 0; i < 4; i++ {
+		reply := &EchoArgs{}
+		assert.NoError(t, c.Call(context.Background(), "Arith", "Echo", &EchoArgs{Data: data}, reply))
+		assert.Equal(t, "live:"+data, reply.Data)
+	}
+
+	// serialize types and compress types of responses are the ones of the server
+	req := protocol.NewMessage()
+	req.SetSerializeType(protocol.JSON)
+	req.SetCompressType(protocol.Gzip)
+	req.ServicePath, req.ServiceMethod = "Arith", "Echo"
+	req.Payload = []byte(fmt.Sprintf(`{"Data":%q}`, data))
+	res, err := c.SendRawMessage(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, protocol.JSON, res.SerializeType())
+	assert.Equal(t, protocol.Gzip, res.CompressType())
+	assert.Equal(t, fmt.Sprintf(`{"Data":%q}`, "live:"+data), string(res.Payload))
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +14,7 @@ import (
 	"github.com/rpcxio/libkv/store"
 	"github.com/rpcxio/libkv/store/redis"
 	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/share"
 )
 
 func init() {
@@ -33,8 +33,11 @@ type RedisRegisterPlugin struct {
 	// Registered services
 	Services       []string
 	metasLock      sync.RWMutex
-	metas          map[string]string
+	metas          registrations
 	UpdateInterval time.Duration
+	// OnReRegister is invoked when a lost registration is re-created by the refresh loop.
+	// err is not nil if re-registering failed and will be retried with backoff.
+	OnReRegister func(servicePath string, err error)
 
 	Options *store.Config
 	kv      store.Store
@@ -69,55 +72,39 @@ func (p *RedisRegisterPlugin) Start() error {
 
 	if p.UpdateInterval > 0 {
 		go func() {
-			ticker := time.NewTicker(p.UpdateInterval)
-
-			defer ticker.Stop()
 			defer p.kv.Close()
 
-			// refresh service TTL
-			for {
-				select {
-				case <-p.dying:
-					close(p.done)
-					return
-				case <-ticker.C:
-					extra := make(map[string]string)
-					if p.Metrics != nil {
-						extra["calls"] = fmt.Sprintf("%.2f", metrics.GetOrRegisterMeter("calls", p.Metrics).RateMean())
-						extra["connections"] = fmt.Sprintf("%.2f", metrics.GetOrRegisterMeter("connections", p.Metrics).RateMean())
-					}
-					//set this same metrics for all services at this server
-					for _, name := range p.Services {
-						nodePath := fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
-						kvPair, err := p.kv.Get(nodePath)
-						if err != nil {
-							log.Infof("can't get data of node: %s, because of %v", nodePath, err.Error())
-
-							p.metasLock.RLock()
-							meta := p.metas[name]
-							p.metasLock.RUnlock()
-
-							err = p.kv.Put(nodePath, []byte(meta), &store.WriteOptions{TTL: p.UpdateInterval * 2})
-							if err != nil {
-								log.Errorf("cannot re-create redis path %s: %v", nodePath, err)
-							}
-
-						} else {
-							v, _ := url.ParseQuery(string(kvPair.Value))
-							for key, value := range extra {
-								v.Set(key, value)
-							}
-							p.kv.Put(nodePath, []byte(v.Encode()), &store.WriteOptions{TTL: p.UpdateInterval * 2})
-						}
-					}
-				}
-			}
+			// refresh service TTL and re-register lost services
+			refreshLoop(p.UpdateInterval, p.dying, p.refresh)
+			close(p.done)
 		}()
 	}
 
 	return nil
 }
 
+func (p *RedisRegisterPlugin) refresh() error {
+	extra := make(map[string]string)
+	if p.Metrics != nil {
+		extra["calls"] = fmt.Sprintf("%.2f", metrics.GetOrRegisterMeter("calls", p.Metrics).RateMean())
+		extra["connections"] = fmt.Sprintf("%.2f", metrics.GetOrRegisterMeter("connections", p.Metrics).RateMean())
+	}
+
+	//set this same metrics for all services at this server
+	p.metasLock.RLock()
+	nodes := p.metas.nodes(p.BasePath, p.ServiceAddress, p.Services)
+	p.metasLock.RUnlock()
+
+	return refreshNodes(p.kv, "redis", nodes, extra, p.UpdateInterval*2, p.isRegistered, p.OnReRegister)
+}
+
+// isRegistered returns whether the registration of node is still registered by this plugin.
+func (p *RedisRegisterPlugin) isRegistered(node registryNode) bool {
+	p.metasLock.RLock()
+	defer p.metasLock.RUnlock()
+	return p.metas.has(node.service, node.registration)
+}
+
 // Stop unregister all services.
 func (p *RedisRegisterPlugin) Stop() error {
 	if p.kv == nil {
@@ -129,8 +116,13 @@ func (p *RedisRegisterPlugin) Stop() error {
 		p.kv = kv
 	}
 
+	p.metasLock.RLock()
+	var paths []string
 	for _, name := range p.Services {
-		nodePath := fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
+		paths = append(paths, p.metas.paths(p.BasePath, name, p.ServiceAddress)...)
+	}
+	p.metasLock.RUnlock()
+	for _, nodePath := range paths {
 		exist, err := p.kv.Exists(nodePath)
 		if err != nil {
 			log.Errorf("cannot delete path %s: %v", nodePath, err)
@@ -165,7 +157,8 @@ func (p *RedisRegisterPlugin) PreCall(_ context.Context, _, _ string, args inter
 }
 
 // Register handles registering event.
-// this service is registered at BASE/serviceName/thisIpAddress node
+// this service is registered at BASE/serviceName/thisIpAddress node,
+// or BASE/serviceName/thisIpAddress#registration for registrations with IDs, see share.MetaRegistration
 func (p *RedisRegisterPlugin) Register(name string, rcvr interface{}, metadata string) (err error) {
 	if strings.TrimSpace(name) == "" {
 		err = errors.New("Register service `name` can't be empty")
@@ -195,24 +188,47 @@ func (p *RedisRegisterPlugin) Register(name string, rcvr interface{}, metadata s
 		return err
 	}
 
-	nodePath = fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
+	nodePath = registrationPath(p.BasePath, name, p.ServiceAddress, share.RegistrationOf(metadata))
 	err = p.kv.Put(nodePath, []byte(metadata), &store.WriteOptions{TTL: p.UpdateInterval * 2})
 	if err != nil {
 		log.Errorf("cannot create redis path %s: %v", nodePath, err)
 		return err
 	}
 
-	p.Services = append(p.Services, name)
-
 	p.metasLock.Lock()
 	if p.metas == nil {
-		p.metas = make(map[string]string)
+		p.metas = make(registrations)
+	}
+	if p.metas.put(name, metadata) {
+		p.Services = append(p.Services, name)
 	}
-	p.metas[name] = metadata
 	p.metasLock.Unlock()
 	return
 }
 
+// UpdateMetadata updates metadata of the registered service in place without unregistering it.
+func (p *RedisRegisterPlugin) UpdateMetadata(name, metadata string) error {
+	id := share.RegistrationOf(metadata)
+	p.metasLock.RLock()
+	ok := p.metas.has(name, id)
+	p.metasLock.RUnlock()
+	if !ok || p.kv == nil {
+		return fmt.Errorf("service %s is not registered", name)
+	}
+
+	nodePath := registrationPath(p.BasePath, name, p.ServiceAddress, id)
+	err := p.kv.Put(nodePath, []byte(metadata), &store.WriteOptions{TTL: p.UpdateInterval * 2})
+	if err != nil {
+		log.Errorf("cannot update redis path %s: %v", nodePath, err)
+		return err
+	}
+
+	p.metasLock.Lock()
+	p.metas.put(name, metadata)
+	p.metasLock.Unlock()
+	return nil
+}
+
 func (p *RedisRegisterPlugin) Unregister(name string) (err error) {
 	if len(p.Services) == 0 {
 		return nil
@@ -246,27 +262,50 @@ func (p *RedisRegisterPlugin) Unregister(name string) (err error) {
 		return err
 	}
 
-	nodePath = fmt.Sprintf("%s/%s/%s", p.BasePath, name, p.ServiceAddress)
-
-	err = p.kv.Delete(nodePath)
-	if err != nil {
-		log.Errorf("cannot create consul path %s: %v", nodePath, err)
-		return err
+	p.metasLock.RLock()
+	paths := p.metas.paths(p.BasePath, name, p.ServiceAddress)
+	p.metasLock.RUnlock()
+	for _, nodePath = range paths {
+		err = p.kv.Delete(nodePath)
+		if err != nil {
+			log.Errorf("cannot create consul path %s: %v", nodePath, err)
+			return err
+		}
 	}
 
-	var services = make([]string, 0, len(p.Services)-1)
+	p.metasLock.Lock()
+	var services = make([]string, 0, len(p.Services))
 	for _, s := range p.Services {
 		if s != name {
 			services = append(services, s)
 		}
 	}
 	p.Services = services
+	delete(p.metas, name)
+	p.metasLock.Unlock()
+	return
+}
+
+// UnregisterRegistration unregisters the registration of a service registered more than once,
+// and keeps its other registrations.
+func (p *RedisRegisterPlugin) UnregisterRegistration(name, registration string) error {
+	p.metasLock.RLock()
+	ok := p.metas.has(name, registration)
+	p.metasLock.RUnlock()
+	if !ok || p.kv == nil {
+		return fmt.Errorf("registration %s of service %s is not registered", registration, name)
+	}
+
+	nodePath := registrationPath(p.BasePath, name, p.ServiceAddress, registration)
+	if err := p.kv.Delete(nodePath); err != nil {
+		log.Errorf("cannot delete redis path %s: %v", nodePath, err)
+		return err
+	}
 
 	p.metasLock.Lock()
-	if p.metas == nil {
-		p.metas = make(map[string]string)
+	if p.metas.remove(name, registration) {
+		p.Services = withoutService(p.Services, name)
 	}
-	delete(p.metas, name)
 	p.metasLock.Unlock()
-	return
+	return nil
 }
@@ -0,0 +1,173 @@
+package serverplugin
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/rpcxio/libkv/store"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/share"
+)
+
+// logger is the structured logger of the "serverplugin" component.
+var logger = log.NewComponent("serverplugin")
+
+// registryRetryBackoff is the first backoff to retry refreshing after a failure.
+// It doubles after every failure until it reaches the update interval.
+var registryRetryBackoff = time.Second
+
+// registryNode is the node of a registration of a service in a libkv based registry.
+type registryNode struct {
+	service      string
+	registration string
+	path         string
+	meta         string
+}
+
+// registrations are metadata of the services registered by a libkv based registry plugin,
+// by their names and registration IDs, see share.MetaRegistration.
+type registrations map[string]map[string]string
+
+// registrationPath returns the path of the node of the registration id of the service name of the server at address.
+func registrationPath(basePath, name, address, id string) string {
+	return fmt.Sprintf("%s/%s/%s", basePath, name, share.RegistrationKey(address, id))
+}
+
+// put stores metadata of a registration of the service name, and returns whether the service is new.
+func (r registrations) put(name, metadata string) bool {
+	metas, ok := r[name]
+	if !ok {
+		metas = make(map[string]string)
+		r[name] = metas
+	}
+	metas[share.RegistrationOf(metadata)] = metadata
+	return !ok
+}
+
+// has returns whether the registration id of the service name is registered.
+func (r registrations) has(name, id string) bool {
+	_, ok := r[name][id]
+	return ok
+}
+
+// remove removes the registration id of the service name, and returns whether the service has no registrations left.
+func (r registrations) remove(name, id string) bool {
+	delete(r[name], id)
+	if len(r[name]) > 0 {
+		return false
+	}
+	delete(r, name)
+	return true
+}
+
+// paths returns paths of the nodes of the registrations of the service name,
+// or the path of the registration without an ID if none is registered.
+func (r registrations) paths(basePath, name, address string) []string {
+	if len(r[name]) == 0 {
+		return []string{registrationPath(basePath, name, address, "")}
+	}
+	paths := make([]string, 0, len(r[name]))
+	for id := range r[name] {
+		paths = append(paths, registrationPath(basePath, name, address, id))
+	}
+	return paths
+}
+
+// nodes returns the nodes of all registrations of services.
+func (r registrations) nodes(basePath, address string, services []string) []registryNode {
+	nodes := make([]registryNode, 0, len(services))
+	for _, name := range services {
+		for id, meta := range r[name] {
+			nodes = append(nodes, registryNode{
+				service:      name,
+				registration: id,
+				path:         registrationPath(basePath, name, address, id),
+				meta:         meta,
+			})
+		}
+	}
+	return nodes
+}
+
+// withoutService returns services without name.
+func withoutService(services []string, name string) []string {
+	result := make([]string, 0, len(services))
+	for _, s := range services {
+		if s != name {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// refreshLoop calls refresh every interval until dying is closed.
+// If refresh fails, it is retried with exponential backoff.
+func refreshLoop(interval time.Duration, dying <-chan struct{}, refresh func() error) {
+	var backoff time.Duration
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-dying:
+			return
+		case <-timer.C:
+			if err := refresh(); err != nil {
+				if backoff == 0 {
+					backoff = registryRetryBackoff
+				} else {
+					backoff *= 2
+				}
+				if backoff > interval {
+					backoff = interval
+				}
+				timer.Reset(backoff)
+				continue
+			}
+			backoff = 0
+			timer.Reset(interval)
+		}
+	}
+}
+
+// refreshNodes updates nodes with extra metrics and renews their TTL.
+// Nodes that have been lost, for example because their TTL or the registry session expired,
+// are re-created with their metadata and onReRegister is invoked,
+// unless they have been unregistered since nodes were collected.
+func refreshNodes(kv store.Store, registry string, nodes []registryNode, extra map[string]string, ttl time.Duration,
+	registered func(node registryNode) bool, onReRegister func(servicePath string, err error)) error {
+	var lastErr error
+	for _, node := range nodes {
+		kvPair, err := kv.Get(node.path)
+		if err == nil {
+			v, _ := url.ParseQuery(string(kvPair.Value))
+			for key, value := range extra {
+				v.Set(key, value)
+			}
+			if err = kv.Put(node.path, []byte(v.Encode()), &store.WriteOptions{TTL: ttl}); err != nil {
+				logger.Warn(context.Background(), "cannot refresh path", "registry", registry, "path", node.path, "error", err)
+				lastErr = err
+			}
+			continue
+		}
+
+		if !registered(node) {
+			continue
+		}
+
+		logger.Warn(context.Background(), "registration is lost, re-registering", "registry", registry, "servicePath", node.service, "error", err)
+		err = kv.Put(node.path, []byte(node.meta), &store.WriteOptions{TTL: ttl})
+		if err != nil {
+			logger.Error(context.Background(), "cannot re-create path", "registry", registry, "path", node.path, "error", err)
+			lastErr = fmt.Errorf("failed to re-register %s: %w", node.service, err)
+		} else {
+			logger.Info(context.Background(), "re-registered", "registry", registry, "servicePath", node.service)
+		}
+		if onReRegister != nil {
+			onReRegister(node.service, err)
+		}
+	}
+	return lastErr
+}
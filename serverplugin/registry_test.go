@@ -1,6 +1,16 @@
 package serverplugin
 
-import "context"
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rpcxio/libkv/store"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
 
 type Args struct {
 	A int
@@ -17,3 +27,268 @@ func (t *Arith) Mul(ctx context.Context, args *Args, reply *Reply) error {
 	reply.C = args.A * args.B
 	return nil
 }
+
+// memStore is an in-memory store.Store for testing registry plugins.
+type memStore struct {
+	mu       sync.Mutex
+	data     map[string][]byte
+	deletes  int
+	failPuts int // the next failPuts puts fail
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string][]byte)}
+}
+
+func (m *memStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failPuts > 0 {
+		m.failPuts--
+		return errors.New("registry is unavailable")
+	}
+	m.data[key] = value
+	return nil
+}
+
+func (m *memStore) Get(key string) (*store.KVPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.data[key]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	return &store.KVPair{Key: key, Value: v}, nil
+}
+
+func (m *memStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deletes++
+	delete(m.data, key)
+	return nil
+}
+
+func (m *memStore) Exists(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.data[key]
+	return ok, nil
+}
+
+func (m *memStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (m *memStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (m *memStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (m *memStore) List(directory string) ([]*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (m *memStore) DeleteTree(directory string) error {
+	return store.ErrCallNotSupported
+}
+
+func (m *memStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	m.Put(key, value, options)
+	return true, &store.KVPair{Key: key, Value: value}, nil
+}
+
+func (m *memStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	m.Delete(key)
+	return true, nil
+}
+
+func (m *memStore) Close() {}
+
+func TestUpdateServiceMetadata(t *testing.T) {
+	plugins := map[string]func(kv store.Store) server.Plugin{
+		"consul": func(kv store.Store) server.Plugin {
+			return &ConsulRegisterPlugin{ServiceAddress: "tcp@127.0.0.1:8972", BasePath: "rpcx_test", kv: kv}
+		},
+		"zookeeper": func(kv store.Store) server.Plugin {
+			return &ZooKeeperRegisterPlugin{ServiceAddress: "tcp@127.0.0.1:8972", BasePath: "rpcx_test", kv: kv}
+		},
+		"redis": func(kv store.Store) server.Plugin {
+			return &RedisRegisterPlugin{ServiceAddress: "tcp@127.0.0.1:8972", BasePath: "rpcx_test", kv: kv}
+		},
+	}
+
+	for name, newPlugin := range plugins {
+		kv := newMemStore()
+		s := server.NewServer()
+		s.Plugins.Add(newPlugin(kv))
+
+		err := s.RegisterNameWithMeta("Arith", new(Arith), map[string]string{share.MetaWeight: "10", share.MetaGroup: "test"})
+		if err != nil {
+			t.Fatalf("%s: failed to register: %v", name, err)
+		}
+		nodePath := "rpcx_test/Arith/tcp@127.0.0.1:8972"
+		if got := string(kv.data[nodePath]); got != "group=test&weight=10" {
+			t.Errorf("%s: expect registered metadata group=test&weight=10 but got %s", name, got)
+		}
+
+		err = s.UpdateServiceMetadata("Arith", map[string]string{share.MetaWeight: "20", share.MetaZone: "us-1"})
+		if err != nil {
+			t.Fatalf("%s: failed to update metadata: %v", name, err)
+		}
+		if got := string(kv.data[nodePath]); got != "weight=20&zone=us-1" {
+			t.Errorf("%s: expect updated metadata weight=20&zone=us-1 but got %s", name, got)
+		}
+		if kv.deletes != 0 {
+			t.Errorf("%s: expect no deletes but got %d", name, kv.deletes)
+		}
+
+		if err := s.UpdateServiceMetadata("Unknown", nil); err == nil {
+			t.Errorf("%s: expect error for unknown service", name)
+		}
+	}
+}
+
+func (m *memStore) value(key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.data[key]
+	return string(v), ok
+}
+
+// lose simulates an expired session or TTL which removes the node from the registry.
+func (m *memStore) lose(key string, failPuts int) {
+	m.mu.Lock()
+	delete(m.data, key)
+	m.failPuts = failPuts
+	m.mu.Unlock()
+}
+
+func TestReRegisterLostService(t *testing.T) {
+	registryRetryBackoff = 10 * time.Millisecond
+	defer func() { registryRetryBackoff = time.Second }()
+
+	kv := newMemStore()
+	events := make(chan error, 10)
+	p := &ZooKeeperRegisterPlugin{
+		ServiceAddress: "tcp@127.0.0.1:8972",
+		BasePath:       "rpcx_test",
+		UpdateInterval: 100 * time.Millisecond,
+		OnReRegister: func(servicePath string, err error) {
+			events <- err
+		},
+		kv: kv,
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	s := server.NewServer()
+	s.Plugins.Add(p)
+	s.RegisterName("Arith", new(Arith), "weight=10")
+	nodePath := "rpcx_test/Arith/tcp@127.0.0.1:8972"
+
+	// the session expires and the registry is unavailable for two attempts
+	kv.lose(nodePath, 2)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-events:
+			if i < 2 && err == nil {
+				t.Fatalf("expect re-registering fails at attempt %d", i)
+			}
+			if i == 2 && err != nil {
+				t.Fatalf("expect re-registering succeeds but got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect re-registering attempt %d", i)
+		}
+	}
+	// retries use backoff instead of waiting for the next update interval
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Errorf("expect recovery within 250ms but took %v", d)
+	}
+
+	if v, ok := kv.value(nodePath); !ok || v != "weight=10" {
+		t.Errorf("expect node re-created with weight=10 but got %q", v)
+	}
+}
+
+func TestRegisterServiceTwice(t *testing.T) {
+	plugins := map[string]func(kv store.Store) server.Plugin{
+		"consul": func(kv store.Store) server.Plugin {
+			return &ConsulRegisterPlugin{ServiceAddress: "tcp@127.0.0.1:8972", BasePath: "rpcx_test", kv: kv}
+		},
+		"zookeeper": func(kv store.Store) server.Plugin {
+			return &ZooKeeperRegisterPlugin{ServiceAddress: "tcp@127.0.0.1:8972", BasePath: "rpcx_test", kv: kv}
+		},
+		"redis": func(kv store.Store) server.Plugin {
+			return &RedisRegisterPlugin{ServiceAddress: "tcp@127.0.0.1:8972", BasePath: "rpcx_test", kv: kv}
+		},
+	}
+
+	for name, newPlugin := range plugins {
+		kv := newMemStore()
+		p := newPlugin(kv)
+		s := server.NewServer()
+		s.Plugins.Add(p)
+
+		arith := new(Arith)
+		if err := s.RegisterNameWithMeta("Arith", arith, map[string]string{share.MetaGroup: "blue"}); err != nil {
+			t.Fatalf("%s: failed to register: %v", name, err)
+		}
+		err := s.RegisterNameWithMeta("Arith", arith, map[string]string{share.MetaGroup: "gold", share.MetaRegistration: "gold"})
+		if err != nil {
+			t.Fatalf("%s: failed to register gold: %v", name, err)
+		}
+		nodePath := "rpcx_test/Arith/tcp@127.0.0.1:8972"
+		goldPath := nodePath + "#gold"
+		if got, _ := kv.value(nodePath); got != "group=blue" {
+			t.Errorf("%s: expect metadata group=blue but got %s", name, got)
+		}
+		if got, _ := kv.value(goldPath); got != "group=gold&registration=gold" {
+			t.Errorf("%s: expect metadata group=gold&registration=gold but got %s", name, got)
+		}
+
+		// registrations are updated and unregistered independently
+		err = s.UpdateServiceMetadata("Arith", map[string]string{share.MetaGroup: "gold", share.MetaRegistration: "gold", share.MetaWeight: "5"})
+		if err != nil {
+			t.Fatalf("%s: failed to update metadata: %v", name, err)
+		}
+		if got, _ := kv.value(goldPath); got != "group=gold&registration=gold&weight=5" {
+			t.Errorf("%s: expect updated metadata of gold but got %s", name, got)
+		}
+		if got, _ := kv.value(nodePath); got != "group=blue" {
+			t.Errorf("%s: expect metadata group=blue kept but got %s", name, got)
+		}
+		if err := s.UnregisterRegistration("Arith", "gold"); err != nil {
+			t.Fatalf("%s: failed to unregister gold: %v", name, err)
+		}
+		if _, ok := kv.value(goldPath); ok {
+			t.Errorf("%s: expect gold deleted", name)
+		}
+		if _, ok := kv.value(nodePath); !ok || kv.deletes != 1 {
+			t.Errorf("%s: expect only gold deleted but got %d deletes", name, kv.deletes)
+		}
+
+		// unregistered registrations are not re-created by refreshing
+		if err := p.(interface{ refresh() error }).refresh(); err != nil {
+			t.Errorf("%s: failed to refresh: %v", name, err)
+		}
+		if _, ok := kv.value(goldPath); ok {
+			t.Errorf("%s: expect gold not re-created", name)
+		}
+
+		if err := s.Unregister("Arith"); err != nil {
+			t.Fatalf("%s: failed to unregister: %v", name, err)
+		}
+		if _, ok := kv.value(nodePath); ok {
+			t.Errorf("%s: expect node deleted", name)
+		}
+	}
+}
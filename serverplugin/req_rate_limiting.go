@@ -2,13 +2,14 @@ package serverplugin
 
 import (
 	"context"
-	"errors"
 	"time"
 
 	"github.com/juju/ratelimit"
+	rerrors "github.com/smallnest/rpcx/errors"
 )
 
-var ErrReqReachLimit = errors.New("request reached rate limit")
+// ErrReqReachLimit has the ResourceExhausted code so that the server counts rejected requests in its stats.
+var ErrReqReachLimit = rerrors.New(rerrors.ResourceExhausted, "request reached rate limit")
 
 // ReqRateLimitingPlugin can limit requests per unit time
 type ReqRateLimitingPlugin struct {
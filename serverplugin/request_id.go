@@ -0,0 +1,101 @@
+package serverplugin
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+	"github.com/smallnest/rpcx/util"
+)
+
+// maxRequestIDLen limits request IDs from clients. Longer IDs are replaced by generated ones.
+const maxRequestIDLen = 128
+
+// RequestIDPlugin reads the request ID from metadata of requests under share.RequestIDKey,
+// or generates a ULID if there is none. The ID can be read by server.RequestID in services,
+// is propagated by calls of XClients with client.RequestIDPlugin using the context of services,
+// and is echoed back in metadata of responses including error responses.
+type RequestIDPlugin struct {
+	// AccessLog logs every request with its ID, client, duration and error.
+	AccessLog bool
+}
+
+// NewRequestIDPlugin creates a RequestIDPlugin.
+func NewRequestIDPlugin(accessLog bool) *RequestIDPlugin {
+	return &RequestIDPlugin{AccessLog: accessLog}
+}
+
+// PostReadRequest extracts or generates the request ID and puts it in ctx.
+func (p *RequestIDPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if e != nil || r == nil || r.IsHeartbeat() || r.MessageType() != protocol.Request {
+		return nil
+	}
+
+	id := r.Metadata[share.RequestIDKey]
+	if id == "" || len(id) > maxRequestIDLen {
+		id = util.NewULID()
+		if r.Metadata == nil {
+			r.Metadata = make(map[string]string)
+		}
+		r.Metadata[share.RequestIDKey] = id
+	}
+	if rpcxContext, ok := ctx.(*share.Context); ok {
+		rpcxContext.SetValue(share.RequestIDContextKey, id)
+	}
+	return nil
+}
+
+// PreWriteResponse echoes the request ID back.
+func (p *RequestIDPlugin) PreWriteResponse(ctx context.Context, req *protocol.Message, res *protocol.Message, err error) error {
+	if res == nil {
+		return nil
+	}
+	id := server.RequestID(ctx)
+	if id == "" && req != nil {
+		id = req.Metadata[share.RequestIDKey]
+	}
+	if id == "" {
+		return nil
+	}
+	if res.Metadata == nil {
+		res.Metadata = make(map[string]string)
+	}
+	res.Metadata[share.RequestIDKey] = id
+	return nil
+}
+
+// PostWriteResponse writes the access log.
+func (p *RequestIDPlugin) PostWriteResponse(ctx context.Context, req *protocol.Message, res *protocol.Message, err error) error {
+	if !p.AccessLog || req == nil {
+		return nil
+	}
+	p.accessLog(ctx, req, err)
+	return nil
+}
+
+// OnewayCompleted writes the access log of oneway requests, which have no responses.
+func (p *RequestIDPlugin) OnewayCompleted(ctx context.Context, req *protocol.Message, err error) {
+	if p.AccessLog {
+		p.accessLog(ctx, req, err)
+	}
+}
+
+func (p *RequestIDPlugin) accessLog(ctx context.Context, req *protocol.Message, err error) {
+	var addr string
+	if conn, ok := ctx.Value(server.RemoteConnContextKey).(net.Conn); ok {
+		addr = conn.RemoteAddr().String()
+	}
+	var d time.Duration
+	if start, ok := ctx.Value(server.StartRequestContextKey).(int64); ok {
+		d = time.Since(time.Unix(0, start))
+	}
+	if err != nil {
+		log.Infof("rpcx: request_id=%s %s.%s from %s in %v: %v", server.RequestID(ctx), req.ServicePath, req.ServiceMethod, addr, d, err)
+	} else {
+		log.Infof("rpcx: request_id=%s %s.%s from %s in %v", server.RequestID(ctx), req.ServicePath, req.ServiceMethod, addr, d)
+	}
+}
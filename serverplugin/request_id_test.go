@@ -0,0 +1,106 @@
+package serverplugin
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+type idService struct {
+	mu  sync.Mutex
+	ids []string
+	xc  client.XClient
+}
+
+func (s *idService) Get(ctx context.Context, args *Args, reply *Reply) error {
+	s.mu.Lock()
+	s.ids = append(s.ids, server.RequestID(ctx))
+	s.mu.Unlock()
+	return nil
+}
+
+// Relay calls Get with the context of the request.
+func (s *idService) Relay(ctx context.Context, args *Args, reply *Reply) error {
+	s.Get(ctx, args, reply)
+	return s.xc.Call(ctx, "Get", args, reply)
+}
+
+func (s *idService) last(n int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.ids[len(s.ids)-n:]...)
+}
+
+func newRequestIDClient(t *testing.T, addr string) client.XClient {
+	d, err := client.NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xc := client.NewXClient("ID", client.Failtry, client.RandomSelect, d, client.DefaultOption)
+	plugins := client.NewPluginContainer()
+	plugins.Add(client.NewRequestIDPlugin())
+	xc.SetPlugins(plugins)
+	return xc
+}
+
+func TestRequestIDPlugin(t *testing.T) {
+	s := server.NewServer()
+	s.Plugins.Add(NewRequestIDPlugin(true))
+	svc := &idService{}
+	s.RegisterName("ID", svc, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+	addr := s.Address().String()
+
+	xc := newRequestIDClient(t, addr)
+	defer xc.Close()
+	svc.xc = newRequestIDClient(t, addr)
+	defer svc.xc.Close()
+
+	// generated by the client and echoed back
+	resMeta := make(map[string]string)
+	ctx := context.WithValue(context.Background(), share.ResMetaDataKey, resMeta)
+	if err := xc.Call(ctx, "Get", &Args{}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	id := resMeta[share.RequestIDKey]
+	if len(id) != 26 || svc.last(1)[0] != id {
+		t.Errorf("expect the ULID %q is received by the server but got %v", id, svc.last(1))
+	}
+
+	// set by the caller
+	resMeta = make(map[string]string)
+	ctx = context.WithValue(context.WithValue(context.Background(), share.ResMetaDataKey, resMeta),
+		share.ReqMetaDataKey, map[string]string{share.RequestIDKey: "my-id"})
+	if err := xc.Call(ctx, "Relay", &Args{}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	if resMeta[share.RequestIDKey] != "my-id" {
+		t.Errorf("expect my-id echoed back but got %q", resMeta[share.RequestIDKey])
+	}
+	// propagated to calls of the service
+	if ids := svc.last(2); ids[0] != "my-id" || ids[1] != "my-id" {
+		t.Errorf("expect my-id propagated but got %v", ids)
+	}
+
+	// generated by the server for clients without the plugin, and returned in error responses
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	resMeta = make(map[string]string)
+	ctx = context.WithValue(context.Background(), share.ResMetaDataKey, resMeta)
+	if err := c.Call(ctx, "ID", "Missing", &Args{}, &Reply{}); err == nil {
+		t.Fatal("expect error for a missing method")
+	}
+	if len(resMeta[share.RequestIDKey]) != 26 {
+		t.Errorf("expect a generated ID in the error response but got %q", resMeta[share.RequestIDKey])
+	}
+}
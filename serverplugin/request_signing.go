@@ -0,0 +1,209 @@
+package serverplugin
+
+import (
+	"context"
+	"crypto/hmac"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	metrics "github.com/rcrowley/go-metrics"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// Errors of requests failing signature verification. They are Unauthenticated errors.
+var (
+	ErrSignatureMissing    = rerrors.New(rerrors.Unauthenticated, "rpcx: request is not signed")
+	ErrSignatureUnknownKey = rerrors.New(rerrors.Unauthenticated, "rpcx: request is signed by an unknown key")
+	ErrSignatureExpired    = rerrors.New(rerrors.Unauthenticated, "rpcx: timestamp of the signed request is outside the skew window")
+	ErrSignatureInvalid    = rerrors.New(rerrors.Unauthenticated, "rpcx: invalid request signature")
+	ErrRequestReplayed     = rerrors.New(rerrors.Unauthenticated, "rpcx: request is replayed")
+)
+
+var signatureFailureReasons = map[error]string{
+	ErrSignatureMissing:    "missing",
+	ErrSignatureUnknownKey: "unknownKey",
+	ErrSignatureExpired:    "expired",
+	ErrSignatureInvalid:    "invalid",
+	ErrRequestReplayed:     "replayed",
+}
+
+// DefaultNonceCacheSize is the default number of nonces remembered for each key.
+const DefaultNonceCacheSize = 1 << 16
+
+type signingKey struct {
+	key []byte
+
+	mu     sync.Mutex
+	nonces *lru.Cache // nonce -> unix timestamp
+	// requests signed at or before floor are rejected,
+	// since nonces of that time have been evicted before they expired
+	floor int64
+}
+
+// RequestSigningPlugin verifies requests signed by client.RequestSigningPlugin with shared keys.
+// It rejects requests whose timestamps are outside MaxSkew from the clock of the server
+// and requests whose nonces have been seen in the skew window, so signed requests can't be replayed.
+// Multiple keys can be active by their IDs to rotate keys.
+//
+// It verifies requests in Server.AuthFunc, so requests failing verification get Unauthenticated errors
+// and their connections are closed like other auth failures:
+//
+//	s.AuthFunc = p.AuthFunc(s.AuthFunc)
+//
+// Added to Server.Plugins too, it signs responses so clients verify that they are sent by servers with the keys:
+//
+//	s.Plugins.Add(p)
+type RequestSigningPlugin struct {
+	// MaxSkew is the max difference between timestamps of requests and the clock of the server.
+	MaxSkew time.Duration
+	// NonceCacheSize is the number of nonces remembered for each key, which should hold nonces of
+	// requests signed by the key in twice MaxSkew. If nonces are evicted before they expire,
+	// requests signed earlier than the evicted nonces are rejected. It defaults to DefaultNonceCacheSize.
+	NonceCacheSize int
+	// Metrics counts failures in signatureFailures and signatureFailures.<reason> if it is not nil.
+	Metrics metrics.Registry
+
+	mu       sync.RWMutex
+	keys     map[string]*signingKey
+	failures uint64
+}
+
+// NewRequestSigningPlugin creates a RequestSigningPlugin with keys by their IDs.
+func NewRequestSigningPlugin(keys map[string][]byte, maxSkew time.Duration) *RequestSigningPlugin {
+	p := &RequestSigningPlugin{MaxSkew: maxSkew, keys: make(map[string]*signingKey)}
+	for id, key := range keys {
+		p.SetKey(id, key)
+	}
+	return p
+}
+
+// SetKey adds or changes the key of keyID.
+func (p *RequestSigningPlugin) SetKey(keyID string, key []byte) {
+	size := p.NonceCacheSize
+	if size <= 0 {
+		size = DefaultNonceCacheSize
+	}
+
+	sk := &signingKey{key: key}
+	sk.nonces, _ = lru.NewWithEvict(size, func(_, ts interface{}) {
+		if t := ts.(int64); t > sk.floor && t+p.maxSkew() >= time.Now().Unix() {
+			sk.floor = t
+		}
+	})
+
+	p.mu.Lock()
+	p.keys[keyID] = sk
+	p.mu.Unlock()
+}
+
+// RemoveKey removes the key of keyID, for example after all clients sign requests with a new key.
+func (p *RequestSigningPlugin) RemoveKey(keyID string) {
+	p.mu.Lock()
+	delete(p.keys, keyID)
+	p.mu.Unlock()
+}
+
+// Failures returns the number of requests failing verification.
+func (p *RequestSigningPlugin) Failures() uint64 {
+	return atomic.LoadUint64(&p.failures)
+}
+
+// AuthFunc returns a function for Server.AuthFunc, which verifies signatures of requests
+// and then calls next if it is not nil, for example to check tokens.
+func (p *RequestSigningPlugin) AuthFunc(next func(ctx context.Context, req *protocol.Message, token string) error) func(ctx context.Context, req *protocol.Message, token string) error {
+	return func(ctx context.Context, req *protocol.Message, token string) error {
+		if err := p.Verify(req); err != nil {
+			return err
+		}
+		if next != nil {
+			return next(ctx, req, token)
+		}
+		return nil
+	}
+}
+
+// Verify verifies the signature of req. Failures are counted.
+func (p *RequestSigningPlugin) Verify(req *protocol.Message) error {
+	err := p.verify(req, time.Now().Unix())
+	if err != nil {
+		atomic.AddUint64(&p.failures, 1)
+		if p.Metrics != nil {
+			metrics.GetOrRegisterCounter("signatureFailures", p.Metrics).Inc(1)
+			metrics.GetOrRegisterCounter("signatureFailures."+signatureFailureReasons[err], p.Metrics).Inc(1)
+		}
+	}
+	return err
+}
+
+func (p *RequestSigningPlugin) verify(req *protocol.Message, now int64) error {
+	meta := req.Metadata
+	keyID, timestamp, nonce, signature := meta[share.SignatureKeyIDKey], meta[share.SignatureTimestampKey],
+		meta[share.SignatureNonceKey], meta[share.SignatureKey]
+	if timestamp == "" || nonce == "" || signature == "" {
+		return ErrSignatureMissing
+	}
+
+	p.mu.RLock()
+	sk := p.keys[keyID]
+	p.mu.RUnlock()
+	if sk == nil {
+		return ErrSignatureUnknownKey
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	if skew := p.maxSkew(); ts < now-skew || ts > now+skew {
+		return ErrSignatureExpired
+	}
+
+	expected := share.SignRequest(sk.key, keyID, req.ServicePath, req.ServiceMethod, timestamp, nonce, req.WirePayload())
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrSignatureInvalid
+	}
+
+	// nonces are checked after signatures so that forged requests can't fill the cache
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	if ts <= sk.floor || sk.nonces.Contains(nonce) {
+		return ErrRequestReplayed
+	}
+	sk.nonces.Add(nonce, ts)
+	return nil
+}
+
+// PreEncodeResponse signs res, the response of req, with the key of req by share.SignResponse, so responses are signed
+// with the keys their clients use during rotations. Responses of requests with unknown keys and heartbeats are not signed.
+func (p *RequestSigningPlugin) PreEncodeResponse(ctx context.Context, req, res *protocol.Message) error {
+	if res.IsHeartbeat() {
+		return nil
+	}
+	keyID := req.Metadata[share.SignatureKeyIDKey]
+	p.mu.RLock()
+	sk := p.keys[keyID]
+	p.mu.RUnlock()
+	if sk == nil {
+		return nil
+	}
+
+	if res.Metadata == nil {
+		res.Metadata = make(map[string]string)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	res.Metadata[share.SignatureKeyIDKey] = keyID
+	res.Metadata[share.SignatureTimestampKey] = timestamp
+	res.Metadata[share.SignatureKey] = share.SignResponse(sk.key, keyID, res.ServicePath, res.ServiceMethod, res.Seq(),
+		timestamp, res.Metadata[protocol.ServiceError], res.WirePayload())
+	return nil
+}
+
+// maxSkew returns MaxSkew in seconds.
+func (p *RequestSigningPlugin) maxSkew() int64 {
+	return int64(p.MaxSkew / time.Second)
+}
@@ -0,0 +1,411 @@
+package serverplugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+func signedRequest(t *testing.T, cp *client.RequestSigningPlugin) *protocol.Message {
+	req := protocol.NewMessage()
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Mul"
+	req.Payload = []byte(`{"A":10,"B":20}`)
+	if err := cp.ClientBeforeEncode(req); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestRequestSigningPlugin(t *testing.T) {
+	p := NewRequestSigningPlugin(map[string][]byte{"k1": []byte("secret1")}, time.Minute)
+	p.Metrics = metrics.NewRegistry()
+	s := server.NewServer()
+	s.AuthFunc = p.AuthFunc(nil)
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+	addr := s.Address().String()
+
+	c := client.NewClient(client.DefaultOption)
+	plugins := client.NewPluginContainer()
+	plugins.Add(client.NewRequestSigningPlugin("k1", []byte("secret1")))
+	c.Plugins = plugins
+	if err := c.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	reply := &Reply{}
+	if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.C != 200 {
+		t.Errorf("expect 200 but got %d", reply.C)
+	}
+
+	// unsigned requests are rejected
+	unsigned := client.NewClient(client.DefaultOption)
+	if err := unsigned.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer unsigned.Close()
+	err := unsigned.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, &Reply{})
+	if !errors.Is(err, rerrors.New(rerrors.Unauthenticated, "")) {
+		t.Errorf("expect an Unauthenticated error but got %v", err)
+	}
+	if p.Failures() != 1 || metrics.GetOrRegisterCounter("signatureFailures.missing", p.Metrics).Count() != 1 {
+		t.Errorf("expect the failure counted but got %d", p.Failures())
+	}
+}
+
+func TestRequestSigningPluginVerify(t *testing.T) {
+	p := NewRequestSigningPlugin(map[string][]byte{"k1": []byte("secret1")}, time.Minute)
+	cp := client.NewRequestSigningPlugin("k1", []byte("secret1"))
+
+	req := signedRequest(t, cp)
+	if err := p.Verify(req); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify(req); err != ErrRequestReplayed {
+		t.Errorf("expect replayed but got %v", err)
+	}
+
+	req = signedRequest(t, cp)
+	req.Payload = []byte(`{"A":10,"B":30}`)
+	if err := p.Verify(req); err != ErrSignatureInvalid {
+		t.Errorf("expect invalid signature for a tampered payload but got %v", err)
+	}
+
+	// compressed payloads are signed as they are on the wire
+	req = protocol.NewMessage()
+	req.SetCompressType(protocol.Gzip)
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Mul"
+	req.Payload = []byte(`{"A":10,"B":20}`)
+	if err := cp.ClientBeforeEncode(req); err != nil {
+		t.Fatal(err)
+	}
+	decoded := protocol.NewMessage()
+	if err := decoded.Decode(bytes.NewReader(req.Encode())); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Metadata[share.SignatureKey] != share.SignRequest([]byte("secret1"), "k1", req.ServicePath, req.ServiceMethod,
+		decoded.Metadata[share.SignatureTimestampKey], decoded.Metadata[share.SignatureNonceKey], decoded.WirePayload()) {
+		t.Error("expect the signature over the compressed payload")
+	}
+	if err := p.Verify(decoded); err != nil {
+		t.Errorf("expect the compressed request to be verified but got %v", err)
+	}
+
+	req = signedRequest(t, client.NewRequestSigningPlugin("k1", []byte("wrong")))
+	if err := p.Verify(req); err != ErrSignatureInvalid {
+		t.Errorf("expect invalid signature for a wrong key but got %v", err)
+	}
+
+	// a valid signature of a stale timestamp
+	req = signedRequest(t, cp)
+	ts := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+	req.Metadata[share.SignatureTimestampKey] = ts
+	req.Metadata[share.SignatureKey] = share.SignRequest([]byte("secret1"), "k1", req.ServicePath, req.ServiceMethod,
+		ts, req.Metadata[share.SignatureNonceKey], req.Payload)
+	if err := p.Verify(req); err != ErrSignatureExpired {
+		t.Errorf("expect expired but got %v", err)
+	}
+
+	// rotation
+	cp.SetKey("k2", []byte("secret2"))
+	req = signedRequest(t, cp)
+	if err := p.Verify(req); err != ErrSignatureUnknownKey {
+		t.Errorf("expect unknown key but got %v", err)
+	}
+	p.SetKey("k2", []byte("secret2"))
+	if err := p.Verify(req); err != nil {
+		t.Error(err)
+	}
+	p.RemoveKey("k1")
+	req = signedRequest(t, client.NewRequestSigningPlugin("k1", []byte("secret1")))
+	if err := p.Verify(req); err != ErrSignatureUnknownKey {
+		t.Errorf("expect unknown key after removing it but got %v", err)
+	}
+
+	if p.Failures() != 6 {
+		t.Errorf("expect 6 failures but got %d", p.Failures())
+	}
+}
+
+func TestRequestSigningPluginNonceEviction(t *testing.T) {
+	p := &RequestSigningPlugin{MaxSkew: time.Minute, NonceCacheSize: 1, keys: make(map[string]*signingKey)}
+	p.SetKey("k1", []byte("secret1"))
+	cp := client.NewRequestSigningPlugin("k1", []byte("secret1"))
+
+	first := signedRequest(t, cp)
+	if err := p.Verify(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify(signedRequest(t, cp)); err != nil {
+		t.Fatal(err)
+	}
+	// the nonce of first is evicted, but it is still rejected
+	if err := p.Verify(first); err != ErrRequestReplayed {
+		t.Errorf("expect replayed but got %v", err)
+	}
+}
+
+// tamperingProxy forwards connections to a server, and flips the last byte of payloads of messages
+// from the server which are not heartbeats while tampering is set.
+type tamperingProxy struct {
+	ln       net.Listener
+	server   string
+	tamper   int32
+	tampered int32
+}
+
+func newTamperingProxy(t *testing.T, server string) *tamperingProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &tamperingProxy{ln: ln, server: server}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", server)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go func() {
+				io.Copy(upstream, conn)
+				upstream.Close()
+			}()
+			go func() {
+				p.forward(conn, upstream)
+				conn.Close()
+			}()
+		}
+	}()
+	return p
+}
+
+func (p *tamperingProxy) forward(w io.Writer, r io.Reader) {
+	var header [16]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[12:]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		if atomic.LoadInt32(&p.tamper) == 1 && header[2]&0x40 == 0 && len(body) > 0 {
+			body[len(body)-1] ^= 0xff
+			atomic.AddInt32(&p.tampered, 1)
+		}
+		if _, err := w.Write(append(header[:], body...)); err != nil {
+			return
+		}
+	}
+}
+
+func (p *tamperingProxy) addr() string {
+	return p.ln.Addr().String()
+}
+
+func startSigningServer(t *testing.T, p *RequestSigningPlugin, signResponses bool) string {
+	s := server.NewServer()
+	s.AuthFunc = p.AuthFunc(nil)
+	if signResponses {
+		s.Plugins.Add(p)
+	}
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { s.Close() })
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s.Address().String()
+}
+
+func TestResponseSigning(t *testing.T) {
+	p := NewRequestSigningPlugin(map[string][]byte{"k1": []byte("secret1"), "k2": []byte("secret2")}, time.Minute)
+	proxy := newTamperingProxy(t, startSigningServer(t, p, true))
+
+	cp := client.NewRequestSigningPlugin("k1", []byte("secret1"))
+	cp.VerifyResponses = true
+	c := client.NewClient(client.DefaultOption)
+	c.Plugins = client.NewPluginContainer()
+	c.Plugins.Add(cp)
+	if err := c.Connect("tcp", proxy.addr()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	call := func() error {
+		ctx := context.WithValue(context.Background(), share.ResMetaDataKey, make(map[string]string))
+		reply := &Reply{}
+		if err := c.Call(ctx, "Arith", "Mul", &Args{A: 10, B: 20}, reply); err != nil {
+			return err
+		}
+		if reply.C != 200 {
+			t.Fatalf("expect 200 but got %d", reply.C)
+		}
+		if meta := ctx.Value(share.ResMetaDataKey).(map[string]string); meta[share.SignatureKey] != "" {
+			t.Fatalf("expect the signature to be removed from metadata but got %v", meta)
+		}
+		return nil
+	}
+	if err := call(); err != nil {
+		t.Fatal(err)
+	}
+
+	// tampered responses fail their calls, and the connection is still usable
+	atomic.StoreInt32(&proxy.tamper, 1)
+	if err := call(); !errors.Is(err, client.ErrInvalidSignature) {
+		t.Fatalf("expect ErrInvalidSignature but got %v", err)
+	}
+	atomic.StoreInt32(&proxy.tamper, 0)
+	if err := call(); err != nil {
+		t.Fatal(err)
+	}
+
+	// responses are signed with the keys of requests during rotations
+	cp.SetKey("k2", []byte("secret2"))
+	if err := call(); err != nil {
+		t.Fatal(err)
+	}
+
+	// calls of forged responses are not retried
+	d, err := client.NewPeer2PeerDiscovery("tcp@"+proxy.addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	option := client.DefaultOption
+	option.Retries = 3
+	xclient := client.NewXClient("Arith", client.Failover, client.RandomSelect, d, option)
+	xclient.SetPlugins(c.Plugins)
+	defer xclient.Close()
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, &Reply{}); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&proxy.tamper, 1)
+	atomic.StoreInt32(&proxy.tampered, 0)
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, &Reply{}); !errors.Is(err, client.ErrInvalidSignature) {
+		t.Fatalf("expect ErrInvalidSignature but got %v", err)
+	}
+	if n := atomic.LoadInt32(&proxy.tampered); n != 1 {
+		t.Fatalf("expect the call not to be retried but got %d responses", n)
+	}
+}
+
+func TestResponseSigningUnsigned(t *testing.T) {
+	p := NewRequestSigningPlugin(map[string][]byte{"k1": []byte("secret1")}, time.Minute)
+	addr := startSigningServer(t, p, false)
+
+	cp := client.NewRequestSigningPlugin("k1", []byte("secret1"))
+	cp.VerifyResponses = true
+	c := client.NewClient(client.DefaultOption)
+	c.Plugins = client.NewPluginContainer()
+	c.Plugins.Add(cp)
+	if err := c.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, &Reply{}); !errors.Is(err, client.ErrInvalidSignature) {
+		t.Fatalf("expect ErrInvalidSignature of unsigned responses but got %v", err)
+	}
+}
+
+func TestResponseSigningVerify(t *testing.T) {
+	p := NewRequestSigningPlugin(map[string][]byte{"k1": []byte("secret1")}, time.Minute)
+	cp := client.NewRequestSigningPlugin("k1", []byte("secret1"))
+	cp.VerifyResponses = true
+
+	signedResponse := func() *protocol.Message {
+		req := signedRequest(t, cp)
+		req.SetSeq(7)
+		res := req.Clone()
+		res.SetMessageType(protocol.Response)
+		res.Payload = []byte(`{"C":200}`)
+		if err := p.PreEncodeResponse(context.Background(), req, res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	if err := cp.ClientAfterDecode(signedResponse()); err != nil {
+		t.Fatal(err)
+	}
+	res := signedResponse()
+	res.SetSeq(8)
+	if err := cp.ClientAfterDecode(res); !errors.Is(err, client.ErrInvalidSignature) {
+		t.Errorf("expect ErrInvalidSignature of another seq but got %v", err)
+	}
+	res = signedResponse()
+	res.Metadata[protocol.ServiceError] = "forged"
+	if err := cp.ClientAfterDecode(res); !errors.Is(err, client.ErrInvalidSignature) {
+		t.Errorf("expect ErrInvalidSignature of a forged error but got %v", err)
+	}
+
+	// a valid signature of a stale timestamp
+	res = signedResponse()
+	ts := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+	res.Metadata[share.SignatureTimestampKey] = ts
+	res.Metadata[share.SignatureKey] = share.SignResponse([]byte("secret1"), "k1", res.ServicePath, res.ServiceMethod,
+		res.Seq(), ts, "", res.Payload)
+	if err := cp.ClientAfterDecode(res); !errors.Is(err, client.ErrInvalidSignature) {
+		t.Errorf("expect ErrInvalidSignature of a stale timestamp but got %v", err)
+	}
+	cp.MaxSkew = 3 * time.Minute
+	res.Metadata[share.SignatureKeyIDKey] = "k1" // removed by verification
+	res.Metadata[share.SignatureTimestampKey] = ts
+	res.Metadata[share.SignatureKey] = share.SignResponse([]byte("secret1"), "k1", res.ServicePath, res.ServiceMethod,
+		res.Seq(), ts, "", res.Payload)
+	if err := cp.ClientAfterDecode(res); err != nil {
+		t.Errorf("expect the timestamp in MaxSkew to be accepted but got %v", err)
+	}
+
+	// heartbeats are not signed
+	hb := protocol.NewMessage()
+	hb.SetMessageType(protocol.Response)
+	hb.SetHeartbeat(true)
+	if err := cp.ClientAfterDecode(hb); err != nil {
+		t.Errorf("expect heartbeats not to be verified but got %v", err)
+	}
+
+	// responses signed by the previous key are verified, but not by keys before it
+	res = signedResponse()
+	cp.SetKey("k2", []byte("secret2"))
+	if err := cp.ClientAfterDecode(res); err != nil {
+		t.Errorf("expect the previous key to be accepted but got %v", err)
+	}
+	res = signedResponse() // signed by k2 for the request signed by k2, which p doesn't know
+	if res.Metadata[share.SignatureKey] != "" {
+		t.Errorf("expect responses of unknown keys not to be signed")
+	}
+	p.SetKey("k2", []byte("secret2"))
+	res = signedResponse()
+	cp.SetKey("k3", []byte("secret3"))
+	cp.SetKey("k4", []byte("secret4"))
+	if err := cp.ClientAfterDecode(res); !errors.Is(err, client.ErrInvalidSignature) {
+		t.Errorf("expect ErrInvalidSignature of a retired key but got %v", err)
+	}
+}
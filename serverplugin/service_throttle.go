@@ -0,0 +1,284 @@
+package serverplugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// ThrottleUnit is what a service throttle meters.
+type ThrottleUnit string
+
+const (
+	// ThrottleRequests meters the number of requests.
+	ThrottleRequests ThrottleUnit = "requests"
+	// ThrottleBytes meters decoded payload bytes of requests.
+	ThrottleBytes ThrottleUnit = "bytes"
+)
+
+// ServiceThrottle is the token bucket of a service.
+type ServiceThrottle struct {
+	Unit ThrottleUnit `json:"unit"`
+	// Rate is the number of requests or bytes per second
+	Rate float64 `json:"rate"`
+	// Burst is the capacity of the bucket. A request larger than Burst waits for a full bucket.
+	Burst int64 `json:"burst"`
+	// WarmUp is the period after the plugin is created, in which the rate ramps up linearly from a tenth of Rate
+	WarmUp time.Duration `json:"warm_up,omitempty"`
+	// MaxWait is how long excess requests can wait for tokens. They are rejected at once if it is zero.
+	MaxWait time.Duration `json:"max_wait,omitempty"`
+	// MaxQueue limits requests waiting for tokens. Zero means no limit.
+	MaxQueue int `json:"max_queue,omitempty"`
+}
+
+func (t *ServiceThrottle) validate() error {
+	switch {
+	case t.Unit != ThrottleRequests && t.Unit != ThrottleBytes:
+		return fmt.Errorf("rpcx: invalid throttle unit %q", t.Unit)
+	case t.Rate <= 0 || math.IsInf(t.Rate, 0) || math.IsNaN(t.Rate):
+		return fmt.Errorf("rpcx: throttle rate must be positive, got %v", t.Rate)
+	case t.Burst <= 0:
+		return fmt.Errorf("rpcx: throttle burst must be positive, got %d", t.Burst)
+	case t.WarmUp < 0 || t.MaxWait < 0 || t.MaxQueue < 0:
+		return fmt.Errorf("rpcx: throttle warm-up, max wait and max queue must not be negative")
+	}
+	return nil
+}
+
+// ServiceThrottleStatus is the state of the bucket of a service.
+type ServiceThrottleStatus struct {
+	ServiceThrottle
+	// CurrentRate is Rate or the rate in the warm-up period
+	CurrentRate float64 `json:"current_rate"`
+	Tokens      float64 `json:"tokens"`
+	Waiting     int     `json:"waiting"`
+	Rejected    uint64  `json:"rejected"`
+}
+
+type throttleBucket struct {
+	mu       sync.Mutex
+	config   ServiceThrottle
+	tokens   float64
+	last     time.Time
+	waiting  int
+	rejected uint64
+}
+
+// ServiceThrottlePlugin shapes the throughput of services by token buckets keyed by service paths,
+// which meter either requests or decoded payload bytes, for example to protect a shared database.
+// Unlike rate limiting of requests, a bucket is shared by all methods of a service.
+//
+// Tokens are taken before services are called, so requests of handlers added by AddHandler are not throttled.
+// Excess requests wait up to MaxWait for tokens in the goroutine or the worker handling them,
+// or they are rejected with server.ErrServerBusy, which clients can retry.
+// Buckets can be changed at runtime by SetThrottle or the admin handler.
+type ServiceThrottlePlugin struct {
+	start time.Time
+
+	mu      sync.RWMutex
+	buckets map[string]*throttleBucket
+}
+
+// NewServiceThrottlePlugin creates a ServiceThrottlePlugin with throttles keyed by service paths.
+func NewServiceThrottlePlugin(throttles map[string]ServiceThrottle) (*ServiceThrottlePlugin, error) {
+	p := &ServiceThrottlePlugin{start: time.Now(), buckets: make(map[string]*throttleBucket)}
+	for servicePath, t := range throttles {
+		if err := p.SetThrottle(servicePath, t); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// SetThrottle adds or changes the throttle of a service. Tokens of an existing bucket are kept up to the new burst.
+func (p *ServiceThrottlePlugin) SetThrottle(servicePath string, t ServiceThrottle) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b := p.buckets[servicePath]
+	if b == nil {
+		p.buckets[servicePath] = &throttleBucket{config: t, tokens: float64(t.Burst), last: time.Now()}
+		return nil
+	}
+
+	b.mu.Lock()
+	b.refill(p.start, time.Now())
+	b.config = t
+	b.tokens = math.Min(b.tokens, float64(t.Burst))
+	b.mu.Unlock()
+	return nil
+}
+
+// RemoveThrottle removes the throttle of a service.
+func (p *ServiceThrottlePlugin) RemoveThrottle(servicePath string) {
+	p.mu.Lock()
+	delete(p.buckets, servicePath)
+	p.mu.Unlock()
+}
+
+// Throttles returns the state of buckets keyed by service paths.
+func (p *ServiceThrottlePlugin) Throttles() map[string]ServiceThrottleStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := time.Now()
+	statuses := make(map[string]ServiceThrottleStatus, len(p.buckets))
+	for servicePath, b := range p.buckets {
+		b.mu.Lock()
+		b.refill(p.start, now)
+		statuses[servicePath] = ServiceThrottleStatus{
+			ServiceThrottle: b.config,
+			CurrentRate:     b.rate(p.start, now),
+			Tokens:          b.tokens,
+			Waiting:         b.waiting,
+			Rejected:        b.rejected,
+		}
+		b.mu.Unlock()
+	}
+	return statuses
+}
+
+type throttlePayloadSizeKey struct{}
+
+// PostReadRequest records the payload size of requests of throttled services.
+func (p *ServiceThrottlePlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if e != nil || r.IsHeartbeat() {
+		return nil
+	}
+	if p.bucket(r.ServicePath) == nil {
+		return nil
+	}
+	if sc, ok := ctx.(*share.Context); ok {
+		sc.SetValue(throttlePayloadSizeKey{}, len(r.Payload))
+	}
+	return nil
+}
+
+// PreCall takes tokens of the service before it is called.
+func (p *ServiceThrottlePlugin) PreCall(ctx context.Context, serviceName, methodName string, args interface{}) (interface{}, error) {
+	b := p.bucket(serviceName)
+	if b == nil {
+		return args, nil
+	}
+
+	size, _ := ctx.Value(throttlePayloadSizeKey{}).(int)
+	wait, ok := b.reserve(p.start, time.Now(), size)
+	if !ok {
+		return nil, server.ErrServerBusy
+	}
+	if wait > 0 {
+		defer func() {
+			b.mu.Lock()
+			b.waiting--
+			b.mu.Unlock()
+		}()
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return args, nil
+}
+
+func (p *ServiceThrottlePlugin) bucket(servicePath string) *throttleBucket {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.buckets[servicePath]
+}
+
+// rate returns the rate at now. b.mu must be held.
+func (b *throttleBucket) rate(start, now time.Time) float64 {
+	rate := b.config.Rate
+	if elapsed := now.Sub(start); b.config.WarmUp > 0 && elapsed < b.config.WarmUp {
+		rate *= 0.1 + 0.9*float64(elapsed)/float64(b.config.WarmUp)
+	}
+	return rate
+}
+
+// refill adds tokens since the last refill. b.mu must be held.
+func (b *throttleBucket) refill(start, now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(float64(b.config.Burst), b.tokens+b.rate(start, now)*now.Sub(b.last).Seconds())
+		b.last = now
+	}
+}
+
+// reserve takes tokens of a request with payloadSize bytes.
+// It returns how long the request must wait for the tokens, or false if the request is rejected.
+func (b *throttleBucket) reserve(start, now time.Time, payloadSize int) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(start, now)
+
+	n := 1.0
+	if b.config.Unit == ThrottleBytes {
+		n = math.Min(float64(payloadSize), float64(b.config.Burst))
+	}
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0, true
+	}
+
+	wait := time.Duration((n - b.tokens) / b.rate(start, now) * float64(time.Second))
+	if wait > b.config.MaxWait || (b.config.MaxQueue > 0 && b.waiting >= b.config.MaxQueue) {
+		b.rejected++
+		return 0, false
+	}
+	// tokens become negative so that later requests wait longer
+	b.tokens -= n
+	b.waiting++
+	return wait, true
+}
+
+// ServeHTTP serves the state of buckets, and sets throttles of services by PUT with a JSON object
+// keyed by service paths. Throttles that are null are removed. It can be mounted on the admin API of the server:
+//
+//	s.HandleAdmin("/throttles", p)
+func (p *ServiceThrottlePlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var throttles map[string]*ServiceThrottle
+		if err := json.NewDecoder(r.Body).Decode(&throttles); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for servicePath, t := range throttles {
+			if t == nil {
+				continue
+			}
+			if err := t.validate(); err != nil {
+				http.Error(w, servicePath+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		for servicePath, t := range throttles {
+			if t == nil {
+				p.RemoveThrottle(servicePath)
+			} else {
+				p.SetThrottle(servicePath, *t)
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Throttles())
+}
@@ -0,0 +1,142 @@
+package serverplugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+)
+
+func TestThrottleBucket(t *testing.T) {
+	start := time.Now()
+	b := &throttleBucket{
+		config: ServiceThrottle{Unit: ThrottleBytes, Rate: 1000, Burst: 500, MaxWait: 200 * time.Millisecond, MaxQueue: 1},
+		tokens: 500,
+		last:   start,
+	}
+
+	if wait, ok := b.reserve(start, start, 400); !ok || wait != 0 {
+		t.Fatalf("expect no wait but got %v, %t", wait, ok)
+	}
+	// 100 tokens are left, 200 bytes wait 100ms
+	if wait, ok := b.reserve(start, start, 200); !ok || wait != 100*time.Millisecond {
+		t.Fatalf("expect to wait 100ms but got %v, %t", wait, ok)
+	}
+	// the queue is full
+	if _, ok := b.reserve(start, start, 1); ok {
+		t.Fatal("expect rejected by the queue")
+	}
+	b.waiting--
+	// waiting longer than MaxWait
+	if _, ok := b.reserve(start, start, 200); ok {
+		t.Fatal("expect rejected by max wait")
+	}
+	// payloads larger than the burst wait for a full bucket
+	now := start.Add(time.Second)
+	if wait, ok := b.reserve(start, now, 10000); !ok || wait != 0 {
+		t.Fatalf("expect no wait but got %v, %t", wait, ok)
+	}
+	if b.rejected != 2 {
+		t.Errorf("expect 2 rejected but got %d", b.rejected)
+	}
+}
+
+func TestThrottleBucketWarmUp(t *testing.T) {
+	start := time.Now()
+	b := &throttleBucket{config: ServiceThrottle{Unit: ThrottleRequests, Rate: 100, Burst: 10, WarmUp: 10 * time.Second}}
+
+	if rate := b.rate(start, start); rate != 10 {
+		t.Errorf("expect a tenth of the rate at start but got %v", rate)
+	}
+	if rate := b.rate(start, start.Add(5*time.Second)); math.Abs(rate-55) > 1e-9 {
+		t.Errorf("expect 55 in the middle of warm-up but got %v", rate)
+	}
+	if rate := b.rate(start, start.Add(time.Minute)); rate != 100 {
+		t.Errorf("expect the rate after warm-up but got %v", rate)
+	}
+}
+
+func TestServiceThrottlePlugin(t *testing.T) {
+	if _, err := NewServiceThrottlePlugin(map[string]ServiceThrottle{"Arith": {Unit: ThrottleRequests, Burst: 1}}); err == nil {
+		t.Error("expect error for zero rate")
+	}
+
+	p, err := NewServiceThrottlePlugin(map[string]ServiceThrottle{
+		"Arith": {Unit: ThrottleRequests, Rate: 1, Burst: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.NewServer()
+	s.Plugins.Add(p)
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	call := func() error {
+		return c.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, &Reply{})
+	}
+	for i := 0; i < 2; i++ {
+		if err := call(); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	err = call()
+	var se client.ServiceError
+	if !errors.As(err, &se) || !strings.Contains(se.Error(), server.ErrServerBusy.Message) {
+		t.Fatalf("expect busy error but got %v", err)
+	}
+
+	// adjust at runtime by the admin handler, excess requests wait
+	s.HandleAdmin("/throttles", p)
+	w := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/throttles",
+		strings.NewReader(`{"Arith":{"unit":"requests","rate":10,"burst":1,"max_wait":1000000000}}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	var statuses map[string]ServiceThrottleStatus
+	json.Unmarshal(w.Body.Bytes(), &statuses)
+	if st := statuses["Arith"]; st.Rate != 10 || st.Rejected != 1 || st.Tokens > 1 {
+		t.Errorf("unexpected status: %+v", st)
+	}
+
+	started := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := call(); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	if d := time.Since(started); d < 200*time.Millisecond {
+		t.Errorf("expect calls to be throttled to 10 per second but they took %v", d)
+	}
+
+	// removed by null
+	w = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/throttles", strings.NewReader(`{"Arith":null}`)))
+	if w.Body.String() != "{}\n" {
+		t.Errorf("expect no throttles but got %s", w.Body.String())
+	}
+
+	// invalid throttles are rejected
+	w = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/throttles", strings.NewReader(`{"Arith":{"unit":"calls","rate":1,"burst":1}}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expect bad request but got %d", w.Code)
+	}
+}
@@ -0,0 +1,253 @@
+package serverplugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/valyala/fastrand"
+)
+
+const (
+	// DefaultShadowQueueSize is the default ShadowPlugin.QueueSize.
+	DefaultShadowQueueSize = 1000
+	// DefaultShadowWorkers is the default ShadowPlugin.Workers.
+	DefaultShadowWorkers = 8
+	// DefaultShadowTimeout is the default ShadowPlugin.Timeout.
+	DefaultShadowTimeout = time.Second
+)
+
+// shadowSeq is the sequence of mirrored requests, which are sent by targets with their own sequences.
+var shadowSeq uint64
+
+// ShadowTarget is the target to which ShadowPlugin mirrors requests, such as a client.XClient
+// of the new implementation of services.
+type ShadowTarget interface {
+	SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error)
+}
+
+// ShadowResult is the result of a mirrored request.
+type ShadowResult struct {
+	ServicePath   string
+	ServiceMethod string
+	SerializeType protocol.SerializeType
+	// Metadata and Payload are the metadata and the payload of the request.
+	Metadata map[string]string
+	Payload  []byte
+
+	// Primary and PrimaryError are the payload and the error of the response of the server.
+	Primary      []byte
+	PrimaryError string
+
+	// Shadow and ShadowError are the payload and the error of the response of the target.
+	Shadow      []byte
+	ShadowError error
+	// Latency is the latency of the target.
+	Latency time.Duration
+}
+
+// ShadowStats are the statistics of ShadowPlugin.
+type ShadowStats struct {
+	// Mirrored is the number of requests sent to the target.
+	Mirrored uint64 `json:"mirrored"`
+	// Dropped is the number of sampled requests dropped since the queue is full.
+	Dropped uint64 `json:"dropped"`
+	// Failed is the number of mirrored requests which failed to be sent or timed out, but not other errors of services.
+	Failed uint64 `json:"failed"`
+	// Mismatched is the number of mirrored requests whose responses differ from responses of the server by Compare.
+	Mismatched uint64 `json:"mismatched"`
+}
+
+// ShadowPlugin mirrors a sampled fraction of requests to a target, such as a rewritten service before it is cut over,
+// and compares responses of the target with responses of the server.
+// Requests are mirrored with ShadowKey in metadata after their responses are written,
+// by workers from a bounded queue, which drops requests if it is full, so the target never affects responses of the server.
+// Oneway requests, heartbeats and requests which are mirrored themselves are not mirrored.
+// Payloads of responses of handlers of Server.AddHandler are not compared since they are written by handlers.
+type ShadowPlugin struct {
+	// SampleRate is the fraction of requests to mirror in [0, 1].
+	SampleRate float64
+	// Exclude are patterns of "ServicePath.ServiceMethod" of requests which are not mirrored, such as mutating methods,
+	// in the syntax of path.Match, such as "Order.Create*" or "*.Delete".
+	Exclude []string
+	// Timeout is the timeout of mirrored requests, DefaultShadowTimeout if it is zero.
+	Timeout time.Duration
+	// QueueSize is the size of the queue of requests to mirror, DefaultShadowQueueSize if it is zero.
+	// It is read when the first request is mirrored.
+	QueueSize int
+	// Workers is the number of workers mirroring requests, DefaultShadowWorkers if it is zero.
+	// It is read when the first request is mirrored.
+	Workers int
+	// Compare reports whether the response of the target matches the response of the server, such as by diffs of decoded replies.
+	// It is called by workers for mirrored requests which don't fail, and payloads and errors are compared by bytes if it is nil.
+	Compare func(result *ShadowResult) bool
+
+	target ShadowTarget
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	queue     chan *ShadowResult
+	done      chan struct{}
+
+	mirrored   uint64
+	dropped    uint64
+	failed     uint64
+	mismatched uint64
+}
+
+// NewShadowPlugin creates a ShadowPlugin which mirrors sampleRate of requests to target.
+func NewShadowPlugin(target ShadowTarget, sampleRate float64) *ShadowPlugin {
+	return &ShadowPlugin{
+		SampleRate: sampleRate,
+		target:     target,
+		done:       make(chan struct{}),
+	}
+}
+
+// PostWriteResponse queues sampled requests to be mirrored.
+func (p *ShadowPlugin) PostWriteResponse(ctx context.Context, req *protocol.Message, res *protocol.Message, e error) error {
+	if req.IsHeartbeat() || req.IsOneway() || req.Metadata[share.ShadowKey] != "" {
+		return nil
+	}
+	if p.SampleRate < 1 && float64(fastrand.Uint32n(1<<20)) >= p.SampleRate*(1<<20) {
+		return nil
+	}
+	if p.excluded(req.ServicePath + "." + req.ServiceMethod) {
+		return nil
+	}
+
+	p.startOnce.Do(p.start)
+
+	// req and res are freed after plugins, and the service path and the method share the buffer of req
+	result := &ShadowResult{
+		ServicePath:   string([]byte(req.ServicePath)),
+		ServiceMethod: string([]byte(req.ServiceMethod)),
+		SerializeType: req.SerializeType(),
+		Metadata:      make(map[string]string, len(req.Metadata)+1),
+		Payload:       append([]byte(nil), req.Payload...),
+		Primary:       append([]byte(nil), res.Payload...),
+		PrimaryError:  res.Metadata[protocol.ServiceError],
+	}
+	for k, v := range req.Metadata {
+		result.Metadata[k] = v
+	}
+	delete(result.Metadata, share.ServerTimeout)
+	result.Metadata[share.ShadowKey] = "1"
+
+	select {
+	case p.queue <- result:
+	default:
+		atomic.AddUint64(&p.dropped, 1)
+	}
+	return nil
+}
+
+func (p *ShadowPlugin) excluded(name string) bool {
+	for _, pattern := range p.Exclude {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ShadowPlugin) start() {
+	size := p.QueueSize
+	if size <= 0 {
+		size = DefaultShadowQueueSize
+	}
+	workers := p.Workers
+	if workers <= 0 {
+		workers = DefaultShadowWorkers
+	}
+	p.queue = make(chan *ShadowResult, size)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+}
+
+func (p *ShadowPlugin) work() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case result := <-p.queue:
+			p.mirror(result)
+		}
+	}
+}
+
+// mirror sends the request of result to the target and compares the responses.
+func (p *ShadowPlugin) mirror(result *ShadowResult) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultShadowTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(result.SerializeType)
+	req.SetSeq(atomic.AddUint64(&shadowSeq, 1))
+	req.ServicePath = result.ServicePath
+	req.ServiceMethod = result.ServiceMethod
+	req.Metadata = make(map[string]string, len(result.Metadata))
+	for k, v := range result.Metadata {
+		req.Metadata[k] = v
+	}
+	req.Payload = result.Payload
+
+	start := time.Now()
+	_, payload, err := p.target.SendRaw(ctx, req)
+	result.Latency = time.Since(start)
+	result.Shadow, result.ShadowError = payload, err
+	atomic.AddUint64(&p.mirrored, 1)
+
+	// deadlines of the target may expire on the server of the target first
+	var se client.ServiceError
+	if err != nil && (!errors.As(err, &se) || errors.Is(err, rerrors.ErrDeadlineExceeded)) {
+		atomic.AddUint64(&p.failed, 1)
+		return
+	}
+
+	compare := p.Compare
+	if compare == nil {
+		compare = compareShadowResult
+	}
+	if !compare(result) {
+		atomic.AddUint64(&p.mismatched, 1)
+	}
+}
+
+func compareShadowResult(result *ShadowResult) bool {
+	shadowError := ""
+	if result.ShadowError != nil {
+		shadowError = result.ShadowError.Error()
+	}
+	return shadowError == result.PrimaryError && bytes.Equal(result.Shadow, result.Primary)
+}
+
+// Stats returns the statistics of the plugin.
+func (p *ShadowPlugin) Stats() ShadowStats {
+	return ShadowStats{
+		Mirrored:   atomic.LoadUint64(&p.mirrored),
+		Dropped:    atomic.LoadUint64(&p.dropped),
+		Failed:     atomic.LoadUint64(&p.failed),
+		Mismatched: atomic.LoadUint64(&p.mismatched),
+	}
+}
+
+// Close stops workers of the plugin, and requests in the queue are dropped. The target is not closed.
+func (p *ShadowPlugin) Close() error {
+	p.closeOnce.Do(func() { close(p.done) })
+	return nil
+}
@@ -0,0 +1,155 @@
+package serverplugin
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+type shadowArith struct {
+	shadow bool
+	delay  time.Duration
+	calls  int32
+}
+
+func (a *shadowArith) handle(ctx context.Context) {
+	atomic.AddInt32(&a.calls, 1)
+	meta, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	if (meta[share.ShadowKey] != "") != a.shadow {
+		panic("unexpected shadow flag")
+	}
+	time.Sleep(a.delay)
+}
+
+func (a *shadowArith) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	a.handle(ctx)
+	reply.C = args.A * args.B
+	return nil
+}
+
+// Add of the shadow is wrong
+func (a *shadowArith) Add(ctx context.Context, args *Args, reply *Reply) error {
+	a.handle(ctx)
+	reply.C = args.A + args.B
+	if a.shadow {
+		reply.C++
+	}
+	return nil
+}
+
+func (a *shadowArith) Sub(ctx context.Context, args *Args, reply *Reply) error {
+	a.handle(ctx)
+	reply.C = args.A - args.B
+	return nil
+}
+
+func startShadowServer(t *testing.T, arith *shadowArith, plugin server.Plugin) string {
+	s := server.NewServer()
+	if plugin != nil {
+		s.Plugins.Add(plugin)
+	}
+	s.RegisterName("Arith", arith, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s.Address().String()
+}
+
+func newShadowTarget(t *testing.T, addr string) client.XClient {
+	d, err := client.NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xclient := client.NewXClient("Arith", client.Failfast, client.RandomSelect, d, client.DefaultOption)
+	t.Cleanup(func() { xclient.Close() })
+	return xclient
+}
+
+func waitShadowStats(t *testing.T, p *ShadowPlugin, mirrored uint64) ShadowStats {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := p.Stats()
+		if stats.Mirrored >= mirrored || time.Now().After(deadline) {
+			return stats
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShadowPlugin(t *testing.T) {
+	mirror := &shadowArith{shadow: true}
+	p := NewShadowPlugin(newShadowTarget(t, startShadowServer(t, mirror, nil)), 1)
+	p.Exclude = []string{"*.Sub"}
+	defer p.Close()
+	var results int32
+	p.Compare = func(result *ShadowResult) bool {
+		atomic.AddInt32(&results, 1)
+		if result.Latency <= 0 || result.Metadata[share.ShadowKey] == "" {
+			t.Errorf("unexpected result %+v", result)
+		}
+		return compareShadowResult(result)
+	}
+
+	primary := &shadowArith{}
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", startShadowServer(t, primary, p)); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, method := range []string{"Mul", "Add", "Sub"} {
+		for i := 0; i < 5; i++ {
+			reply := &Reply{}
+			if err := c.Call(context.Background(), "Arith", method, &Args{A: 3, B: i}, reply); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	stats := waitShadowStats(t, p, 10)
+	if stats != (ShadowStats{Mirrored: 10, Mismatched: 5}) {
+		t.Fatalf("expect 10 mirrored requests of Mul and Add, with mismatched Add, but got %+v", stats)
+	}
+	if calls := atomic.LoadInt32(&mirror.calls); calls != 10 || atomic.LoadInt32(&results) != 10 {
+		t.Fatalf("expect 10 compared calls of the mirror but got %d of %d", results, calls)
+	}
+}
+
+func TestShadowPluginSlowMirror(t *testing.T) {
+	mirror := &shadowArith{shadow: true, delay: 200 * time.Millisecond}
+	p := NewShadowPlugin(newShadowTarget(t, startShadowServer(t, mirror, nil)), 1)
+	p.Timeout = 50 * time.Millisecond
+	p.QueueSize = 1
+	p.Workers = 1
+	defer p.Close()
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", startShadowServer(t, &shadowArith{}, p)); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 3, B: i}, &Reply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("the slow mirror slows down the server: %v", elapsed)
+	}
+
+	stats := waitShadowStats(t, p, 20-p.Stats().Dropped)
+	if stats.Dropped == 0 || stats.Failed == 0 || stats.Failed != stats.Mirrored || stats.Mismatched != 0 {
+		t.Fatalf("expect dropped and timed out requests but got %+v", stats)
+	}
+	// handlers of the mirror finish before it is closed
+	time.Sleep(mirror.delay)
+}
@@ -0,0 +1,224 @@
+package share
+
+import (
+	"math/rand"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+)
+
+// ChaosKey tags requests with faults injected by chaos plugins. It is set in metadata of their responses and
+// in details of their errors to the kinds of injected faults, such as "latency,error", so they can be told from real failures.
+const ChaosKey = "__rpcx_chaos__"
+
+// Kinds of faults injected by chaos plugins.
+const (
+	ChaosLatency = "latency"
+	ChaosError   = "error"
+	ChaosDrop    = "drop"
+	ChaosCorrupt = "corrupt"
+)
+
+// chaosDisabled is the global kill switch of chaos plugins.
+var chaosDisabled int32
+
+// SetChaosEnabled enables or disables faults of all chaos plugins of the process. They are enabled by default.
+func SetChaosEnabled(enabled bool) {
+	v := int32(1)
+	if enabled {
+		v = 0
+	}
+	atomic.StoreInt32(&chaosDisabled, v)
+}
+
+// ChaosRule is a rule of faults which chaos plugins of clients and servers inject into a percentage of matched requests.
+// Corrupt is injected into responses, and other faults into requests, by separate draws of Percentage.
+type ChaosRule struct {
+	// ServicePath and ServiceMethod match requests in the syntax of path.Match, and empty ones match all.
+	ServicePath   string
+	ServiceMethod string
+	// Percentage is the percentage of matched requests with the faults, from 0 to 100.
+	Percentage float64
+
+	// Latency is added to requests, plus a random duration up to LatencyJitter.
+	Latency       time.Duration
+	LatencyJitter time.Duration
+	// LatencyDistribution returns the latency added to requests instead of Latency if it is set, such as
+	// by r.NormFloat64 or r.ExpFloat64. r is the random source of the Chaos, which is locked while it is called.
+	LatencyDistribution func(r *rand.Rand) time.Duration
+
+	// ErrorCode fails requests with an error of ErrorCode and ErrorMessage if it is not OK.
+	ErrorCode    rerrors.Code
+	ErrorMessage string
+	// Drop drops requests, which are never sent by clients or never answered by servers, so calls time out.
+	Drop bool
+	// Corrupt corrupts payloads of responses.
+	Corrupt bool
+}
+
+// requestFaults reports whether the rule has faults injected into requests.
+func (r *ChaosRule) requestFaults() bool {
+	return r.Latency > 0 || r.LatencyDistribution != nil || r.ErrorCode != rerrors.OK || r.Drop
+}
+
+func (r *ChaosRule) match(servicePath, serviceMethod string) bool {
+	return matchPattern(r.ServicePath, servicePath) && matchPattern(r.ServiceMethod, serviceMethod)
+}
+
+func matchPattern(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// ChaosFault is the fault injected into a request or a response.
+type ChaosFault struct {
+	Latency time.Duration
+	Err     error
+	Drop    bool
+	Corrupt bool
+}
+
+// Kinds returns the kinds of the fault separated by commas, or "" if there is none.
+func (f ChaosFault) Kinds() string {
+	var kinds []string
+	if f.Latency > 0 {
+		kinds = append(kinds, ChaosLatency)
+	}
+	if f.Err != nil {
+		kinds = append(kinds, ChaosError)
+	}
+	if f.Drop {
+		kinds = append(kinds, ChaosDrop)
+	}
+	if f.Corrupt {
+		kinds = append(kinds, ChaosCorrupt)
+	}
+	return strings.Join(kinds, ",")
+}
+
+// Chaos decides faults of chaos plugins by rules, which are updated at runtime.
+// The first rule matching a request whose percentage is drawn decides its fault. Draws are reproducible if it is seeded
+// by Seed and requests are injected one by one.
+type Chaos struct {
+	mu       sync.Mutex
+	rules    []ChaosRule
+	rand     *rand.Rand
+	disabled bool
+	injected map[string]uint64
+}
+
+// NewChaos creates a Chaos of rules, which is seeded randomly.
+func NewChaos(rules ...ChaosRule) *Chaos {
+	return &Chaos{
+		rules:    rules,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		injected: make(map[string]uint64),
+	}
+}
+
+// Seed seeds the random source of draws, so faults are reproducible.
+func (c *Chaos) Seed(seed int64) {
+	c.mu.Lock()
+	c.rand.Seed(seed)
+	c.mu.Unlock()
+}
+
+// SetRules replaces the rules.
+func (c *Chaos) SetRules(rules ...ChaosRule) {
+	c.mu.Lock()
+	c.rules = rules
+	c.mu.Unlock()
+}
+
+// Rules returns the rules.
+func (c *Chaos) Rules() []ChaosRule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]ChaosRule(nil), c.rules...)
+}
+
+// SetEnabled enables or disables faults of c, which is the kill switch of its plugin. It is enabled by default.
+func (c *Chaos) SetEnabled(enabled bool) {
+	c.mu.Lock()
+	c.disabled = !enabled
+	c.mu.Unlock()
+}
+
+// Inject returns the fault injected into the request of servicePath and serviceMethod, or into its response if response is set.
+func (c *Chaos) Inject(servicePath, serviceMethod string, response bool) ChaosFault {
+	var f ChaosFault
+	if atomic.LoadInt32(&chaosDisabled) == 1 {
+		return f
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disabled {
+		return f
+	}
+	for i := range c.rules {
+		r := &c.rules[i]
+		if response && !r.Corrupt || !response && !r.requestFaults() {
+			continue
+		}
+		if !r.match(servicePath, serviceMethod) || c.rand.Float64()*100 >= r.Percentage {
+			continue
+		}
+
+		if response {
+			f.Corrupt = true
+		} else {
+			if r.LatencyDistribution != nil {
+				f.Latency = r.LatencyDistribution(c.rand)
+			} else {
+				f.Latency = r.Latency
+				if r.LatencyJitter > 0 {
+					f.Latency += time.Duration(c.rand.Int63n(int64(r.LatencyJitter)))
+				}
+			}
+			if f.Latency < 0 {
+				f.Latency = 0
+			}
+			if r.ErrorCode != rerrors.OK {
+				msg := r.ErrorMessage
+				if msg == "" {
+					msg = "rpcx: fault injected by chaos"
+				}
+				f.Err = rerrors.New(r.ErrorCode, msg).WithDetail(ChaosKey, ChaosError)
+			}
+			f.Drop = r.Drop
+		}
+		for _, kind := range strings.Split(f.Kinds(), ",") {
+			if kind != "" {
+				c.injected[kind]++
+			}
+		}
+		return f
+	}
+	return f
+}
+
+// Stats returns the numbers of injected faults by their kinds.
+func (c *Chaos) Stats() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make(map[string]uint64, len(c.injected))
+	for k, v := range c.injected {
+		stats[k] = v
+	}
+	return stats
+}
+
+// CorruptPayload corrupts payload in place by flipping its bits.
+func CorruptPayload(payload []byte) {
+	for i := range payload {
+		payload[i] ^= 0xff
+	}
+}
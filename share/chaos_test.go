@@ -0,0 +1,88 @@
+package share
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChaos(t *testing.T) {
+	c := NewChaos(
+		ChaosRule{ServicePath: "Arith", ServiceMethod: "Mul", Percentage: 100, Latency: time.Millisecond, LatencyJitter: time.Millisecond},
+		ChaosRule{ServicePath: "Arith", Percentage: 100, ErrorCode: rerrors.Unavailable},
+		ChaosRule{ServiceMethod: "Div*", Percentage: 100, Drop: true, Corrupt: true},
+	)
+
+	f := c.Inject("Arith", "Mul", false)
+	assert.True(t, f.Latency >= time.Millisecond && f.Latency < 2*time.Millisecond, f.Latency)
+	assert.Nil(t, f.Err)
+	assert.Equal(t, ChaosLatency, f.Kinds())
+
+	f = c.Inject("Arith", "Add", false)
+	assert.Equal(t, rerrors.Unavailable, rerrors.CodeOf(f.Err))
+	assert.Equal(t, ChaosError, rerrors.DetailsOf(f.Err)[ChaosKey])
+	assert.Equal(t, ChaosError, f.Kinds())
+
+	// faults of requests and responses are drawn separately
+	assert.Equal(t, ChaosFault{Drop: true}, c.Inject("Calc", "Divide", false))
+	assert.Equal(t, ChaosFault{Corrupt: true}, c.Inject("Calc", "Divide", true))
+	assert.Equal(t, ChaosFault{}, c.Inject("Arith", "Mul", true))
+	assert.Equal(t, ChaosFault{}, c.Inject("Calc", "Add", false))
+
+	assert.Equal(t, map[string]uint64{ChaosLatency: 1, ChaosError: 1, ChaosDrop: 1, ChaosCorrupt: 1}, c.Stats())
+
+	// kill switches
+	c.SetEnabled(false)
+	assert.Equal(t, ChaosFault{}, c.Inject("Arith", "Add", false))
+	c.SetEnabled(true)
+	SetChaosEnabled(false)
+	assert.Equal(t, ChaosFault{}, c.Inject("Arith", "Add", false))
+	SetChaosEnabled(true)
+	assert.NotNil(t, c.Inject("Arith", "Add", false).Err)
+
+	// rules are updated at runtime
+	c.SetRules(ChaosRule{Percentage: 100, LatencyDistribution: func(r *rand.Rand) time.Duration { return -time.Second }, ErrorCode: rerrors.Internal, ErrorMessage: "boom"})
+	assert.Len(t, c.Rules(), 1)
+	f = c.Inject("Arith", "Mul", false)
+	assert.Equal(t, time.Duration(0), f.Latency)
+	assert.EqualError(t, f.Err, "boom")
+}
+
+func TestChaosPercentage(t *testing.T) {
+	draw := func(seed int64) []bool {
+		c := NewChaos(ChaosRule{Percentage: 30, Drop: true})
+		c.Seed(seed)
+		drops := make([]bool, 1000)
+		for i := range drops {
+			drops[i] = c.Inject("Arith", "Mul", false).Drop
+		}
+		return drops
+	}
+
+	drops := draw(42)
+	var n int
+	for _, drop := range drops {
+		if drop {
+			n++
+		}
+	}
+	assert.True(t, n > 250 && n < 350, n)
+
+	// draws of the same seed are reproducible
+	assert.Equal(t, drops, draw(42))
+	assert.NotEqual(t, drops, draw(43))
+
+	c := NewChaos(ChaosRule{Percentage: 0, Drop: true})
+	for i := 0; i < 100; i++ {
+		assert.False(t, c.Inject("Arith", "Mul", false).Drop)
+	}
+}
+
+func TestCorruptPayload(t *testing.T) {
+	p := []byte{0, 1, 0xff}
+	CorruptPayload(p)
+	assert.Equal(t, []byte{0xff, 0xfe, 0}, p)
+}
@@ -14,25 +14,91 @@ import (
 // var _ context.Context = &Context{}
 
 // Context is a rpcx customized Context that can contains multiple values.
+// Its map of values is allocated when the first value is set.
 type Context struct {
 	tagsLock sync.Mutex
 	tags     map[interface{}]interface{}
 	context.Context
 }
 
+// Contexts are pooled to reduce allocations of requests if servers are created with server.WithContextPool.
+// Such servers get a pooled context for each request, and return it to the pool by FreeContext after the response
+// is written and all plugins of the request are called. So do clients of Option.PoolContexts with contexts of their calls.
+// Handlers and plugins keeping ctx after the request, for example for goroutines they start, keep share.Detach(ctx)
+// instead, which is not reused.
+var contextPool = sync.Pool{
+	New: func() interface{} {
+		return &Context{}
+	},
+}
+
+// freedContext is the parent of freed contexts, so code keeping them by mistake sees a cancelled context
+// without values instead of a nil parent.
+var freedContext = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
 func NewContext(ctx context.Context) *Context {
 	return &Context{
 		Context: ctx,
-		tags:    make(map[interface{}]interface{}),
 	}
 }
 
+// GetPooledContext gets a pooled context of the parent ctx, which belongs to its getter.
+func GetPooledContext(ctx context.Context) *Context {
+	c := contextPool.Get().(*Context)
+	c.Context = ctx
+	return c
+}
+
+// FreeContext resets c and puts it into the pool. c must not be used after it is freed.
+func FreeContext(c *Context) {
+	if c == nil {
+		return
+	}
+	c.Reset()
+	contextPool.Put(c)
+}
+
+// Reset removes the values of c and replaces its parent by a cancelled context, and keeps its map for later values.
+func (c *Context) Reset() {
+	c.tagsLock.Lock()
+	for k := range c.tags {
+		delete(c.tags, k)
+	}
+	c.Context = freedContext
+	c.tagsLock.Unlock()
+}
+
+// Detach returns a copy of ctx which has its values, for code keeping ctx after the request or the call of it,
+// since pooled contexts are reused after that. Values of Contexts which ctx is derived from directly are copied too,
+// and other contexts are returned as they are. Contexts derived from a pooled Context by the context package refer
+// to it, so code deriving ones to keep detaches the Context first.
+func Detach(ctx context.Context) context.Context {
+	c, ok := ctx.(*Context)
+	if !ok {
+		return ctx
+	}
+	tags := make(map[interface{}]interface{})
+	for ok {
+		c.tagsLock.Lock()
+		for k, v := range c.tags {
+			if _, set := tags[k]; !set {
+				tags[k] = v
+			}
+		}
+		ctx = c.Context
+		c.tagsLock.Unlock()
+		c, ok = ctx.(*Context)
+	}
+	return &Context{Context: ctx, tags: tags}
+}
+
 func (c *Context) Value(key interface{}) interface{} {
 	c.tagsLock.Lock()
 	defer c.tagsLock.Unlock()
-	if c.tags == nil {
-		c.tags = make(map[interface{}]interface{})
-	}
 
 	if v, ok := c.tags[key]; ok {
 		return v
@@ -2,6 +2,7 @@ package share
 
 import (
 	"context"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -16,7 +17,9 @@ var (
 func TestContext(t *testing.T) {
 	rpcxContext := NewContext(context.Background())
 	assert.NotNil(t, rpcxContext.Context)
-	assert.NotNil(t, rpcxContext.tags)
+	assert.Nil(t, rpcxContext.tags) // allocated by the first value
+	assert.Nil(t, rpcxContext.Value("string"))
+	assert.Nil(t, rpcxContext.tags)
 
 
 	rpcxContext.SetValue("string", TheAnswer)
@@ -64,4 +67,92 @@ func TestWithLocalValue(t *testing.T) {
 
 	assert.Equal(t, "value", ctx.tags["key"])
 	assert.Equal(t, "42", ctx.tags["MagicNumber"])
-}
\ No newline at end of file
+}
+
+func TestPooledContext(t *testing.T) {
+	parent := context.WithValue(context.Background(), "parent", "value")
+	ctx := GetPooledContext(parent)
+	ctx.SetValue("key", "value")
+	assert.Equal(t, "value", ctx.Value("key"))
+	assert.Equal(t, "value", ctx.Value("parent"))
+
+	ctx.Reset()
+	assert.Empty(t, ctx.tags)
+	assert.NotNil(t, ctx.tags) // kept for later values
+
+	// freed contexts kept by mistake are cancelled contexts without values
+	assert.Nil(t, ctx.Value("key"))
+	assert.Nil(t, ctx.Value("parent"))
+	assert.Equal(t, context.Canceled, ctx.Err())
+	select {
+	case <-ctx.Done():
+	default:
+		t.Error("expect a cancelled context")
+	}
+
+	ctx.Context = context.Background()
+	assert.Nil(t, ctx.Value("key"))
+	FreeContext(ctx)
+	FreeContext(nil)
+}
+
+func TestDetach(t *testing.T) {
+	parent := WithValue(context.Background(), "key", "parent")
+	parent.SetValue("parent", "value")
+	ctx := GetPooledContext(parent)
+	ctx.SetValue("key", "value")
+
+	detached := Detach(ctx)
+	FreeContext(ctx)
+	parent.Reset()
+	assert.Equal(t, "value", detached.Value("key"))
+	assert.Equal(t, "value", detached.Value("parent"))
+	assert.Equal(t, context.Background(), detached.(*Context).Context)
+
+	// other contexts are kept
+	plain := context.WithValue(context.Background(), "key", "value")
+	assert.Equal(t, plain, Detach(plain))
+}
+
+func TestDetachRace(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		ctx := GetPooledContext(context.Background())
+		ctx.SetValue("key", i)
+		detached := Detach(ctx)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				assert.Equal(t, i, detached.Value("key"))
+			}
+		}(i)
+		FreeContext(ctx) // reused by the next request
+	}
+	wg.Wait()
+}
+
+// benchmarkContexts sets and gets values of contexts like the server does for each request.
+func benchmarkContexts(b *testing.B, get func() *Context, free func(*Context)) {
+	meta := map[string]string{}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ctx := get()
+			ctx.SetValue("conn", meta)
+			WithLocalValue(WithLocalValue(ctx, ReqMetaDataKey, meta), ResMetaDataKey, meta)
+			if ctx.Value(ReqMetaDataKey) == nil {
+				b.Fatal("no metadata")
+			}
+			free(ctx)
+		}
+	})
+}
+
+func BenchmarkNewContext(b *testing.B) {
+	benchmarkContexts(b, func() *Context { return NewContext(context.Background()) }, func(*Context) {})
+}
+
+func BenchmarkPooledContext(b *testing.B) {
+	benchmarkContexts(b, func() *Context { return GetPooledContext(context.Background()) }, FreeContext)
+}
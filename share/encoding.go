@@ -0,0 +1,55 @@
+package share
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// UnsupportedSerializeType returns the error of messages whose serialize type t has no codec, see CodecOf.
+// It matches errors.ErrUnsupportedEncoding and names t and the supported serialize types in its message and details.
+func UnsupportedSerializeType(t protocol.SerializeType) *rerrors.Error {
+	codecs := loadRegistry().codecs
+	types := make([]int, 0, len(codecs))
+	for st := range codecs {
+		types = append(types, int(st))
+	}
+	return unsupportedEncoding("serialize type", int(t), types)
+}
+
+// DisallowedSerializeType returns the error of messages whose serialize type t is not one of the allowed types
+// of their service. Like UnsupportedSerializeType, it matches errors.ErrUnsupportedEncoding
+// and names t and the allowed serialize types in its message and details.
+func DisallowedSerializeType(t protocol.SerializeType, allowed []protocol.SerializeType) *rerrors.Error {
+	types := make([]int, len(allowed))
+	for i, st := range allowed {
+		types[i] = int(st)
+	}
+	return unsupportedEncoding("serialize type", int(t), types)
+}
+
+// UnsupportedCompressType returns the error of messages whose compress type t has no compressor in protocol.Compressors.
+// It matches errors.ErrUnsupportedEncoding and names t and the supported compress types in its message and details.
+func UnsupportedCompressType(t protocol.CompressType) *rerrors.Error {
+	types := make([]int, 0, len(protocol.Compressors))
+	for ct := range protocol.Compressors {
+		types = append(types, int(ct))
+	}
+	return unsupportedEncoding("compress type", int(t), types)
+}
+
+func unsupportedEncoding(kind string, t int, supported []int) *rerrors.Error {
+	sort.Ints(supported)
+	names := make([]string, len(supported))
+	for i, st := range supported {
+		names[i] = strconv.Itoa(st)
+	}
+	list := strings.Join(names, ",")
+	key := strings.Replace(kind, " ", "_", 1)
+	return rerrors.Errorf(rerrors.Unimplemented, "rpcx: unsupported %s %d, supported: %s", kind, t, list).
+		WithDetail(key, strconv.Itoa(t)).
+		WithDetail("supported_"+key+"s", list)
+}
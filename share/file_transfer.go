@@ -0,0 +1,148 @@
+package share
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Resumable file transfers connect the streaming port of the file transfer service with the token of the transfer,
+// and the receiver answers the offset it has committed, by which the sender resumes:
+//
+//	upload:   client -> token; server -> offset; client -> chunks from offset, end; server -> status
+//	download: client -> token, offset; server -> chunks from offset, end; client -> status
+//
+// Chunks carry CRC-32 checksums of their bytes and the end carries the SHA-256 of the whole file,
+// which the receiver verifies before reporting success. Offsets are 8 bytes in big endian.
+
+const (
+	fileFrameChunk byte = 1
+	fileFrameEnd   byte = 2
+
+	// MaxFileChunkSize is the max size of chunks of resumable file transfers.
+	MaxFileChunkSize = 1 << 20
+)
+
+var (
+	// ErrFileChecksum is the error of resumable file transfers whose chunks or files don't match their checksums.
+	ErrFileChecksum = errors.New("rpcx: checksum of the file mismatches")
+	// ErrFileTransfer is the error reported by receivers of resumable file transfers which fail.
+	ErrFileTransfer = errors.New("rpcx: file transfer failed")
+)
+
+var fileChunkTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WriteFileOffset writes offset, the bytes committed by the receiver of a resumable file transfer.
+func WriteFileOffset(w io.Writer, offset int64) error {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(offset))
+	_, err := w.Write(b[:])
+	return err
+}
+
+// ReadFileOffset reads the offset written by WriteFileOffset.
+func ReadFileOffset(r io.Reader) (int64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	offset := int64(binary.BigEndian.Uint64(b[:]))
+	if offset < 0 {
+		return 0, fmt.Errorf("rpcx: invalid file offset %d", offset)
+	}
+	return offset, nil
+}
+
+// WriteFileChunk writes p, the next bytes of a resumable file transfer, with its checksum.
+func WriteFileChunk(w io.Writer, p []byte) error {
+	var h [9]byte
+	h[0] = fileFrameChunk
+	binary.BigEndian.PutUint32(h[1:5], uint32(len(p)))
+	binary.BigEndian.PutUint32(h[5:], crc32.Checksum(p, fileChunkTable))
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(p)
+	return err
+}
+
+// WriteFileEnd ends a resumable file transfer with sum, the SHA-256 of the whole file.
+func WriteFileEnd(w io.Writer, sum []byte) error {
+	_, err := w.Write(append([]byte{fileFrameEnd}, sum...))
+	return err
+}
+
+// ReadFileFrame reads a frame written by WriteFileChunk or WriteFileEnd into buf, which is at least MaxFileChunkSize.
+// It returns the bytes of the chunk, or the SHA-256 of the file if end is true.
+// Chunks which don't match their checksums return ErrFileChecksum.
+func ReadFileFrame(r io.Reader, buf []byte) (p []byte, end bool, err error) {
+	var h [9]byte
+	if _, err = io.ReadFull(r, h[:1]); err != nil {
+		return nil, false, err
+	}
+	switch h[0] {
+	case fileFrameEnd:
+		p = buf[:sha256.Size]
+		_, err = io.ReadFull(r, p)
+		return p, true, err
+	case fileFrameChunk:
+	default:
+		return nil, false, fmt.Errorf("rpcx: invalid file frame %d", h[0])
+	}
+
+	if _, err = io.ReadFull(r, h[1:]); err != nil {
+		return nil, false, err
+	}
+	n := binary.BigEndian.Uint32(h[1:5])
+	if n > MaxFileChunkSize {
+		return nil, false, fmt.Errorf("rpcx: file chunk of %d bytes is too large", n)
+	}
+	p = buf[:n]
+	if _, err = io.ReadFull(r, p); err != nil {
+		return nil, false, err
+	}
+	if crc32.Checksum(p, fileChunkTable) != binary.BigEndian.Uint32(h[5:]) {
+		return nil, false, ErrFileChecksum
+	}
+	return p, false, nil
+}
+
+// WriteFileStatus writes the result of a resumable file transfer by its receiver, which is nil if it succeeds.
+func WriteFileStatus(w io.Writer, err error) error {
+	if err == nil {
+		_, err = w.Write([]byte{0, 0, 0})
+		return err
+	}
+	msg := err.Error()
+	if len(msg) > 0xffff {
+		msg = msg[:0xffff]
+	}
+	b := make([]byte, 3, 3+len(msg))
+	b[0] = 1
+	binary.BigEndian.PutUint16(b[1:], uint16(len(msg)))
+	_, err = w.Write(append(b, msg...))
+	return err
+}
+
+// ReadFileStatus reads the result written by WriteFileStatus.
+// Failures return errors matching ErrFileTransfer, or ErrFileChecksum if files mismatch their checksums.
+func ReadFileStatus(r io.Reader) error {
+	var b [3]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return err
+	}
+	if b[0] == 0 {
+		return nil
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(b[1:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return err
+	}
+	if string(msg) == ErrFileChecksum.Error() {
+		return ErrFileChecksum
+	}
+	return fmt.Errorf("%w: %s", ErrFileTransfer, msg)
+}
@@ -0,0 +1,77 @@
+package share
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// RequestIDHeader is the header of request IDs added by ingresses and service meshes,
+// which gateways also set as the request ID of RequestIDKey.
+const RequestIDHeader = "x-request-id"
+
+// MeshHeaders are the headers of request IDs and trace contexts of W3C and B3, added by ingresses and service meshes.
+var MeshHeaders = []string{
+	RequestIDHeader,
+	"traceparent", "tracestate",
+	"b3", "x-b3-traceid", "x-b3-spanid", "x-b3-parentspanid", "x-b3-sampled", "x-b3-flags",
+}
+
+// headerContextKey is the key of values of propagated headers in contexts.
+type headerContextKey string
+
+var (
+	headersMu  sync.Mutex
+	headerList []string // propagated headers, in lowercase
+)
+
+// PropagateHeaders registers headers, such as MeshHeaders, to be propagated across calls under metadata of
+// their names in lowercase, like keys of RegisterPropagatedKey. Servers set values of them in metadata of requests
+// in contexts of handlers, calls made with the contexts send them again, and InjectHeaders sets them to requests
+// of HTTP backends. Gateways register the headers they copy to metadata. Headers registered again are kept once.
+func PropagateHeaders(headers ...string) {
+	headersMu.Lock()
+	defer headersMu.Unlock()
+	for _, h := range headers {
+		h = strings.ToLower(h)
+		found := false
+		for _, o := range headerList {
+			found = found || o == h
+		}
+		if found {
+			continue
+		}
+		headerList = append(headerList, h)
+		key := headerContextKey(h)
+		registerPropagation(propagation{metaKey: h, inject: injectValue(key, nil), extract: extractValue(key, nil)})
+	}
+}
+
+// PropagatedHeaders returns the headers registered by PropagateHeaders, in lowercase.
+func PropagatedHeaders() []string {
+	headersMu.Lock()
+	defer headersMu.Unlock()
+	return append([]string(nil), headerList...)
+}
+
+// HeaderValue returns the value of the propagated header in ctx, or "" if there is none.
+func HeaderValue(ctx context.Context, header string) string {
+	v, _ := ctx.Value(headerContextKey(strings.ToLower(header))).(string)
+	return v
+}
+
+// WithHeaderValue returns ctx with the value of the propagated header, which is sent by calls made with it.
+func WithHeaderValue(ctx context.Context, header, value string) context.Context {
+	return context.WithValue(ctx, headerContextKey(strings.ToLower(header)), value)
+}
+
+// InjectHeaders sets values of propagated headers in ctx to h, such as headers of requests of HTTP backends
+// called by handlers. Headers set in h are kept.
+func InjectHeaders(ctx context.Context, h http.Header) {
+	for _, header := range PropagatedHeaders() {
+		if v := HeaderValue(ctx, header); v != "" && h.Get(header) == "" {
+			h.Set(header, v)
+		}
+	}
+}
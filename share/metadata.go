@@ -0,0 +1,74 @@
+package share
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Well-known keys of service metadata. Registry plugins and client selectors use the same spelling.
+const (
+	// MetaWeight is the weight used by weighted selectors.
+	MetaWeight = "weight"
+	// MetaVersion is the version of the service.
+	MetaVersion = "version"
+	// MetaZone is the zone or data center of the server.
+	MetaZone = "zone"
+	// MetaGroup is the group of the service. Clients only select servers in their group if it is set.
+	MetaGroup = "group"
+	// MetaState is the state of the server. Servers whose state is "inactive" are not selected.
+	MetaState = "state"
+	// MetaRegistration is the ID of a registration of a service which is registered more than once by a server,
+	// such as in different groups with different metadata. Each registration is stored in registries
+	// under its own key, see RegistrationKey.
+	MetaRegistration = "registration"
+)
+
+// RegistrationKey returns the key of the registration id of a service of the server at address in registries,
+// which is address followed by "#" and id. It is address for the registration without an ID.
+func RegistrationKey(address, id string) string {
+	if id == "" {
+		return address
+	}
+	return address + "#" + id
+}
+
+// SplitRegistrationKey splits a key returned by RegistrationKey into the address of the server and the registration ID.
+func SplitRegistrationKey(key string) (address, id string) {
+	if i := strings.LastIndex(key, "#"); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return key, ""
+}
+
+// RegistrationOf returns the registration ID of metadata encoded by EncodeMetadata, see MetaRegistration.
+func RegistrationOf(metadata string) string {
+	v, err := url.ParseQuery(metadata)
+	if err != nil {
+		return ""
+	}
+	return v.Get(MetaRegistration)
+}
+
+// EncodeMetadata encodes metadata of services in URL query format which is stored in registries.
+// Keys are sorted.
+func EncodeMetadata(meta map[string]string) string {
+	v := make(url.Values, len(meta))
+	for key, value := range meta {
+		v.Set(key, value)
+	}
+	return v.Encode()
+}
+
+// DecodeMetadata decodes metadata encoded by EncodeMetadata.
+// If a key has multiple values, the first one is used.
+func DecodeMetadata(metadata string) (map[string]string, error) {
+	v, err := url.ParseQuery(metadata)
+	if err != nil {
+		return nil, err
+	}
+	meta := make(map[string]string, len(v))
+	for key := range v {
+		meta[key] = v.Get(key)
+	}
+	return meta, nil
+}
@@ -0,0 +1,188 @@
+package share
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// propagation is a registered value which is propagated from contexts of calls to metadata of requests,
+// and back from metadata to contexts of handlers.
+type propagation struct {
+	metaKey string
+	// inject returns the value of ctx to send, given the value of metaKey set explicitly in metadata if set.
+	inject func(ctx context.Context, explicit string, set bool) (string, bool)
+	// extract decodes v into ctx, and returns the cancel function of the context it creates if it does.
+	extract func(ctx *Context, v string) context.CancelFunc
+}
+
+var (
+	propagationsMu sync.Mutex
+	propagations   atomic.Value // []propagation, replaced on registrations
+)
+
+func init() {
+	registerPropagation(propagation{metaKey: RequestIDKey, inject: injectValue(RequestIDContextKey, nil), extract: extractValue(RequestIDContextKey, nil)})
+	registerPropagation(propagation{metaKey: ServerTimeout, inject: injectDeadline, extract: extractDeadline})
+}
+
+// RegisterPropagatedKey registers ctxKey, a key of context values, to be propagated across calls under metaKey of metadata,
+// so values such as tenant IDs set in contexts of handlers are carried to the services they call.
+// Clients set encode(v) in metadata of requests for the value v of ctxKey of the context of a call, unless it is "",
+// and servers set decode of the metadata in contexts of handlers. Values are used as strings if encode or decode is nil.
+//
+// Metadata set explicitly in the context by ReqMetaDataKey is sent instead of the value of ctxKey,
+// and values set in contexts of handlers by server plugins are kept. metaKey registered again replaces its registration.
+// The request ID of RequestIDContextKey and the deadline, as ServerTimeout, are registered by default.
+// It is safe to register keys concurrently, such as in init functions of packages.
+func RegisterPropagatedKey(ctxKey interface{}, metaKey string, encode func(v interface{}) string, decode func(string) interface{}) {
+	registerPropagation(propagation{metaKey: metaKey, inject: injectValue(ctxKey, encode), extract: extractValue(ctxKey, decode)})
+}
+
+func registerPropagation(p propagation) {
+	propagationsMu.Lock()
+	defer propagationsMu.Unlock()
+	old, _ := propagations.Load().([]propagation)
+	ps := make([]propagation, 0, len(old)+1)
+	for _, o := range old {
+		if o.metaKey != p.metaKey {
+			ps = append(ps, o)
+		}
+	}
+	propagations.Store(append(ps, p))
+}
+
+// InjectPropagated returns meta, metadata of a request, with propagated values of ctx by registered keys.
+// meta may belong to the caller, so it is copied before values are set, and it is returned as it is if there is none.
+func InjectPropagated(ctx context.Context, meta map[string]string) map[string]string {
+	ps, _ := propagations.Load().([]propagation)
+	copied := false
+	for _, p := range ps {
+		explicit, set := meta[p.metaKey]
+		v, ok := p.inject(ctx, explicit, set)
+		if !ok {
+			continue
+		}
+		if !copied {
+			m := make(map[string]string, len(meta)+len(ps))
+			for k, v := range meta {
+				m[k] = v
+			}
+			meta, copied = m, true
+		}
+		meta[p.metaKey] = v
+	}
+	return meta
+}
+
+// ExtractPropagated sets propagated values of registered keys in meta, metadata of a request, in ctx of its handler.
+// It returns the function which cancels the context of the propagated deadline, or nil if there is none.
+func ExtractPropagated(ctx *Context, meta map[string]string) context.CancelFunc {
+	if len(meta) == 0 {
+		return nil
+	}
+	ps, _ := propagations.Load().([]propagation)
+	var cancels []context.CancelFunc
+	for _, p := range ps {
+		if v, ok := meta[p.metaKey]; ok {
+			if cancel := p.extract(ctx, v); cancel != nil {
+				cancels = append(cancels, cancel)
+			}
+		}
+	}
+	switch len(cancels) {
+	case 0:
+		return nil
+	case 1:
+		return cancels[0]
+	}
+	return func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
+func injectValue(ctxKey interface{}, encode func(v interface{}) string) func(context.Context, string, bool) (string, bool) {
+	return func(ctx context.Context, explicit string, set bool) (string, bool) {
+		if set {
+			return "", false
+		}
+		v := ctx.Value(ctxKey)
+		if v == nil {
+			return "", false
+		}
+		var s string
+		if encode != nil {
+			s = encode(v)
+		} else {
+			s = fmt.Sprint(v)
+		}
+		return s, s != ""
+	}
+}
+
+func extractValue(ctxKey interface{}, decode func(string) interface{}) func(*Context, string) context.CancelFunc {
+	return func(ctx *Context, v string) context.CancelFunc {
+		if ctx.Value(ctxKey) != nil {
+			return nil
+		}
+		if decode != nil {
+			if dv := decode(v); dv != nil {
+				ctx.SetValue(ctxKey, dv)
+			}
+			return nil
+		}
+		ctx.SetValue(ctxKey, v)
+		return nil
+	}
+}
+
+// injectDeadline sends the time until the deadline of ctx in milliseconds,
+// or the explicit timeout if it is earlier, such as the timeout of the request handled with ctx.
+func injectDeadline(ctx context.Context, explicit string, set bool) (string, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return "", false
+	}
+	timeout := time.Until(deadline).Milliseconds()
+	if set {
+		if t, err := strconv.ParseInt(explicit, 10, 64); err == nil && t <= timeout {
+			return "", false
+		}
+	}
+	return strconv.FormatInt(timeout, 10), true
+}
+
+// extractDeadline sets the deadline of the timeout in milliseconds in ctx.
+func extractDeadline(ctx *Context, v string) context.CancelFunc {
+	timeout, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil
+	}
+	newCtx, cancel := context.WithTimeout(ctx.Context, time.Duration(timeout)*time.Millisecond)
+	ctx.Context = newCtx
+	ctx.SetValue(propagatedDeadlineKey{}, true)
+	return cancel
+}
+
+// propagatedDeadlineKey marks contexts of handlers whose deadlines are propagated from their callers.
+type propagatedDeadlineKey struct{}
+
+// RemainingBudget returns the time until the deadline of ctx, which is negative if it has expired,
+// and reports whether ctx carries a deadline propagated from the caller of its handler by ServerTimeout.
+// Deadlines set on the propagated one by handlers are included, such as by context.WithTimeout of ctx.
+// Clients give calls made with ctx a share of the budget by their options, such as HopReserve of client.Option.
+func RemainingBudget(ctx context.Context) (time.Duration, bool) {
+	if ctx.Value(propagatedDeadlineKey{}) == nil {
+		return 0, false
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
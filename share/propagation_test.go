@@ -0,0 +1,157 @@
+package share
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tenantKey struct{}
+
+type tenant struct{ id int }
+
+func TestPropagatedKey(t *testing.T) {
+	RegisterPropagatedKey(tenantKey{}, "x-tenant", func(v interface{}) string {
+		return strconv.Itoa(v.(tenant).id)
+	}, func(s string) interface{} {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return nil
+		}
+		return tenant{id: id}
+	})
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, tenant{id: 42})
+	ctx = context.WithValue(ctx, RequestIDContextKey, "req-1")
+	caller := map[string]string{"a": "b"}
+	meta := InjectPropagated(ctx, caller)
+	assert.Equal(t, map[string]string{"a": "b", "x-tenant": "42", RequestIDKey: "req-1"}, meta)
+	assert.Equal(t, map[string]string{"a": "b"}, caller, "metadata of the caller is not changed")
+
+	// explicit metadata wins
+	meta = InjectPropagated(ctx, map[string]string{"x-tenant": "7"})
+	assert.Equal(t, "7", meta["x-tenant"])
+
+	// no values, no copies
+	caller = map[string]string{"a": "b"}
+	meta = InjectPropagated(context.Background(), caller)
+	caller["c"] = "d"
+	assert.Equal(t, "d", meta["c"])
+	assert.Nil(t, InjectPropagated(context.Background(), nil))
+
+	sctx := NewContext(context.Background())
+	assert.Nil(t, ExtractPropagated(sctx, map[string]string{"x-tenant": "42", RequestIDKey: "req-1"}))
+	assert.Equal(t, tenant{id: 42}, sctx.Value(tenantKey{}))
+	assert.Equal(t, "req-1", sctx.Value(RequestIDContextKey))
+
+	// values set by plugins are kept, and values which fail to decode are not set
+	sctx = NewContext(context.Background())
+	sctx.SetValue(RequestIDContextKey, "plugin")
+	ExtractPropagated(sctx, map[string]string{"x-tenant": "bad", RequestIDKey: "req-1"})
+	assert.Nil(t, sctx.Value(tenantKey{}))
+	assert.Equal(t, "plugin", sctx.Value(RequestIDContextKey))
+}
+
+func TestPropagatedDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	meta := InjectPropagated(ctx, nil)
+	timeout, err := strconv.Atoi(meta[ServerTimeout])
+	assert.NoError(t, err)
+	assert.True(t, timeout > 900 && timeout <= 1000, "%d", timeout)
+
+	// the earlier one of the deadline and the explicit timeout is sent
+	assert.Equal(t, "100", InjectPropagated(ctx, map[string]string{ServerTimeout: "100"})[ServerTimeout])
+	assert.NotEqual(t, "5000", InjectPropagated(ctx, map[string]string{ServerTimeout: "5000"})[ServerTimeout])
+
+	sctx := NewContext(context.Background())
+	cancel = ExtractPropagated(sctx, map[string]string{ServerTimeout: "100"})
+	assert.NotNil(t, cancel)
+	defer cancel()
+	deadline, ok := sctx.Deadline()
+	assert.True(t, ok)
+	assert.True(t, time.Until(deadline) <= 100*time.Millisecond)
+}
+
+func TestRemainingBudget(t *testing.T) {
+	// deadlines not propagated are not budgets
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, ok := RemainingBudget(ctx)
+	assert.False(t, ok)
+
+	sctx := NewContext(context.Background())
+	cancel = ExtractPropagated(sctx, map[string]string{ServerTimeout: "100"})
+	defer cancel()
+	budget, ok := RemainingBudget(sctx)
+	assert.True(t, ok)
+	assert.True(t, budget > 0 && budget <= 100*time.Millisecond, "%v", budget)
+
+	// deadlines of handlers are included, and the budget goes through detached and derived contexts
+	ctx, cancel = context.WithTimeout(Detach(sctx), 10*time.Millisecond)
+	defer cancel()
+	budget, ok = RemainingBudget(ctx)
+	assert.True(t, ok)
+	assert.True(t, budget <= 10*time.Millisecond, "%v", budget)
+
+	<-ctx.Done()
+	budget, _ = RemainingBudget(ctx)
+	assert.True(t, budget <= 0, "%v", budget)
+}
+
+func TestRegisterPropagatedKeyConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		i := i
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterPropagatedKey(ContextKey(fmt.Sprintf("key-%d", i)), fmt.Sprintf("x-key-%d", i), nil, nil)
+		}()
+		go func() {
+			defer wg.Done()
+			InjectPropagated(context.Background(), nil)
+		}()
+	}
+	wg.Wait()
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		ctx = context.WithValue(ctx, ContextKey(fmt.Sprintf("key-%d", i)), i)
+	}
+	meta := InjectPropagated(ctx, nil)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, strconv.Itoa(i), meta[fmt.Sprintf("x-key-%d", i)])
+	}
+}
+
+func TestPropagateHeaders(t *testing.T) {
+	PropagateHeaders("X-Request-Id", "x-request-id", "X-B3-TraceId")
+	assert.Contains(t, PropagatedHeaders(), "x-request-id")
+	n := 0
+	for _, h := range PropagatedHeaders() {
+		if h == "x-request-id" {
+			n++
+		}
+	}
+	assert.Equal(t, 1, n, "headers are registered once")
+
+	// values of headers in metadata are set in contexts of handlers and sent by their calls
+	sctx := NewContext(context.Background())
+	ExtractPropagated(sctx, map[string]string{"x-request-id": "req-1", "x-b3-traceid": "463ac35c9f6413ad"})
+	assert.Equal(t, "req-1", HeaderValue(sctx, "X-Request-Id"))
+	meta := InjectPropagated(sctx, nil)
+	assert.Equal(t, "req-1", meta["x-request-id"])
+	assert.Equal(t, "463ac35c9f6413ad", meta["x-b3-traceid"])
+
+	h := http.Header{"X-B3-Traceid": {"kept"}}
+	InjectHeaders(WithHeaderValue(sctx, "x-request-id", "req-2"), h)
+	assert.Equal(t, "req-2", h.Get("X-Request-Id"))
+	assert.Equal(t, "kept", h.Get("X-B3-TraceId"))
+}
@@ -0,0 +1,30 @@
+package share
+
+// Metadata keys of messages that servers publish to groups of subscribers by server.SendToGroupAck.
+const (
+	// PubSubGroupKey is the group of the message.
+	PubSubGroupKey = "__rpcx_pubsub_group__"
+	// PubSubIDKey is the ID of the message in its group, which is acknowledged by subscribers.
+	PubSubIDKey = "__rpcx_pubsub_id__"
+)
+
+// SubscribeArgs is the argument type of Subscribe of the pub/sub service.
+type SubscribeArgs struct {
+	Group string `json:"group"`
+	// After is the ID of the last message received from the group, whose later messages kept by the server
+	// are sent again, such as after reconnecting. Zero means no messages are sent again.
+	After uint64 `json:"after,omitempty"`
+}
+
+// SubscribeReply is the reply type of Subscribe of the pub/sub service.
+type SubscribeReply struct {
+	// LastID is the ID of the last message published to the group.
+	LastID uint64 `json:"last_id"`
+}
+
+// PubSubAck is the argument type of Ack of the pub/sub service, which acknowledges the message ID of Group
+// and all messages sent before it.
+type PubSubAck struct {
+	Group string `json:"group"`
+	ID    uint64 `json:"id"`
+}
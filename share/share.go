@@ -1,7 +1,15 @@
 package share
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
 	"github.com/smallnest/rpcx/codec"
+	"github.com/smallnest/rpcx/log"
 	"github.com/smallnest/rpcx/protocol"
 )
 
@@ -10,6 +18,7 @@ const (
 	DefaultRPCPath = "/_rpcx_"
 
 	// AuthKey is used in metadata.
+	// It is the token of XClient.Auth, Option.AuthFunc or client.WithAuth of calls.
 	AuthKey = "__AUTH"
 
 	// ServerAddress is used to get address of the server by client
@@ -18,6 +27,9 @@ const (
 	// ServerTimeout timeout value passed from client to control timeout of server
 	ServerTimeout = "__ServerTimeout"
 
+	// ShadowKey is set in metadata of requests mirrored by serverplugin.ShadowPlugin, so services can tell shadow traffic.
+	ShadowKey = "__rpcx_shadow__"
+
 	// OpentracingSpanServerKey key in service context
 	OpentracingSpanServerKey = "opentracing_span_server_key"
 	// OpentracingSpanClientKey key in client context
@@ -30,11 +42,25 @@ const (
 	// OpencensusSpanRequestKey span key in request meta
 	OpencensusSpanRequestKey = "opencensus_span_request_key"
 
+	// OpenTelemetrySpanServerKey key in service context
+	OpenTelemetrySpanServerKey = "opentelemetry_span_server_key"
+	// OpenTelemetrySpanClientKey key in client context
+	OpenTelemetrySpanClientKey = "opentelemetry_span_client_key"
+
 	// SendFileServiceName is name of the file transfer service.
 	SendFileServiceName = "_filetransfer"
 
 	// StreamServiceName is name of the stream service.
 	StreamServiceName = "_streamservice"
+
+	// ReflectionServiceName is name of the built-in reflection service.
+	ReflectionServiceName = "_rpcx_.Reflection"
+
+	// HealthServiceName is name of the built-in health checking service.
+	HealthServiceName = "_rpcx_.Health"
+
+	// PubSubServiceName is name of the built-in service of subscriptions to groups of acknowledged messages.
+	PubSubServiceName = "_rpcx_.PubSub"
 )
 
 // Trace is a flag to write a trace log or not.
@@ -42,18 +68,213 @@ const (
 // It writes trace log with logger Debug level.
 var Trace bool
 
-// Codecs are codecs supported by rpcx. You can add customized codecs in Codecs.
+// Codecs are codecs supported by rpcx. Customized codecs can be added in Codecs before messages are encoded or decoded,
+// and by RegisterCodec at any time. Codecs added in Codecs later are still found with a warning, but codecs replaced
+// in it are not, so use RegisterCodec. Codecs is kept up to date by RegisterCodec, but use CodecOf to look up codecs.
 var Codecs = map[protocol.SerializeType]codec.Codec{
 	protocol.SerializeNone: &codec.ByteCodec{},
 	protocol.JSON:          &codec.JSONCodec{},
 	protocol.ProtoBuffer:   &codec.PBCodec{},
 	protocol.MsgPack:       &codec.MsgpackCodec{},
 	protocol.Thrift:        &codec.ThriftCodec{},
+	protocol.CBOR:          &codec.CBORCodec{},
+	protocol.FlatBuffers:   &codec.FlatBuffersCodec{},
+}
+
+// ErrCodecRegistered is returned by RegisterCodec if the serialize type is registered with a codec of another type.
+var ErrCodecRegistered = errors.New("codec registered")
+
+// contentTypes are the names of built-in codecs, the first name of each serialize type being its Content-Type.
+var contentTypes = []struct {
+	name string
+	t    protocol.SerializeType
+}{
+	{"application/json", protocol.JSON},
+	{"application/x-msgpack", protocol.MsgPack},
+	{"application/msgpack", protocol.MsgPack},
+	{"application/protobuf", protocol.ProtoBuffer},
+	{"application/x-protobuf", protocol.ProtoBuffer},
+	{"application/cbor", protocol.CBOR},
+}
+
+// codecRegistry is a snapshot of registered codecs and their names. Registrations replace the snapshot by a copy,
+// so that codecs are looked up without locks while others are registered.
+type codecRegistry struct {
+	codecs map[protocol.SerializeType]codec.Codec
+	types  map[string]protocol.SerializeType // serialize types by names
+	names  map[protocol.SerializeType]string // the first names of serialize types
+}
+
+var (
+	registry     atomic.Value // *codecRegistry
+	registryOnce sync.Once
+	registryMu   sync.Mutex // serializes registrations
+)
+
+// loadRegistry returns the current snapshot, the one of Codecs and the names of built-in codecs at first.
+func loadRegistry() *codecRegistry {
+	registryOnce.Do(func() {
+		r := &codecRegistry{
+			codecs: make(map[protocol.SerializeType]codec.Codec, len(Codecs)),
+			types:  make(map[string]protocol.SerializeType, len(contentTypes)),
+			names:  make(map[protocol.SerializeType]string, len(contentTypes)),
+		}
+		for t, c := range Codecs {
+			r.codecs[t] = c
+		}
+		for _, ct := range contentTypes {
+			r.addName(ct.t, ct.name)
+		}
+		registry.Store(r)
+	})
+	return registry.Load().(*codecRegistry)
+}
+
+func (r *codecRegistry) clone() *codecRegistry {
+	c := &codecRegistry{
+		codecs: make(map[protocol.SerializeType]codec.Codec, len(r.codecs)+1),
+		types:  make(map[string]protocol.SerializeType, len(r.types)+1),
+		names:  make(map[protocol.SerializeType]string, len(r.names)+1),
+	}
+	for t, cc := range r.codecs {
+		c.codecs[t] = cc
+	}
+	for name, t := range r.types {
+		c.types[name] = t
+	}
+	for t, name := range r.names {
+		c.names[t] = name
+	}
+	return c
+}
+
+func (r *codecRegistry) addName(t protocol.SerializeType, name string) {
+	r.types[name] = t
+	if _, ok := r.names[t]; !ok {
+		r.names[t] = name
+	}
+}
+
+// CodecOf returns the codec of serialize type t, or nil if t has no codec.
+func CodecOf(t protocol.SerializeType) codec.Codec {
+	if c := loadRegistry().codecs[t]; c != nil {
+		return c
+	}
+	return adoptCodec(t)
+}
+
+// adoptCodec returns the codec of t added in Codecs directly after the snapshot was taken, and adds it to the snapshot
+// so that it is warned once.
+func adoptCodec(t protocol.SerializeType) codec.Codec {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	r := loadRegistry()
+	if c := r.codecs[t]; c != nil {
+		return c
+	}
+	c := Codecs[t]
+	if c == nil {
+		return nil
+	}
+	log.Warnf("rpcx: codec %T of serialize type %d is added in share.Codecs directly, use share.RegisterCodec instead", c, t)
+	r = r.clone()
+	r.codecs[t] = c
+	registry.Store(r)
+	return c
 }
 
 // RegisterCodec register customized codec.
-func RegisterCodec(t protocol.SerializeType, c codec.Codec) {
+// Codecs of the same type replace registered ones, so that codecs can be reconfigured,
+// but t registered with a codec of another type is not overwritten and ErrCodecRegistered is returned.
+// Codecs can be registered while messages are encoded and decoded.
+func RegisterCodec(t protocol.SerializeType, c codec.Codec) error {
+	return RegisterCodecWithName(t, "", c)
+}
+
+// RegisterCodecWithName registers codec c of serialize type t like RegisterCodec, and name as the media type of t,
+// so that the gateway maps Content-Type and Accept headers of name to t. The first name of t is the Content-Type
+// of its payloads. Names registered for other serialize types are not overwritten and ErrCodecRegistered is returned.
+func RegisterCodecWithName(t protocol.SerializeType, name string, c codec.Codec) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	r := loadRegistry()
+	if old := r.codecs[t]; old != nil && reflect.TypeOf(old) != reflect.TypeOf(c) {
+		return fmt.Errorf("%w: serialize type %d is registered with %T", ErrCodecRegistered, t, old)
+	}
+	if old, ok := r.types[name]; ok && name != "" && old != t {
+		return fmt.Errorf("%w: %s is registered for serialize type %d", ErrCodecRegistered, name, old)
+	}
+
+	r = r.clone()
+	r.codecs[t] = c
+	if name != "" {
+		r.addName(t, name)
+	}
+	registry.Store(r)
 	Codecs[t] = c
+	return nil
+}
+
+// SerializeTypeOfName returns the serialize type whose codec is registered with name, a media type such as
+// application/x-msgpack.
+func SerializeTypeOfName(name string) (protocol.SerializeType, bool) {
+	r := loadRegistry()
+	t, ok := r.types[strings.ToLower(name)]
+	if ok && CodecOf(t) == nil {
+		return 0, false
+	}
+	return t, ok
+}
+
+// NameOfSerializeType returns the first name of serialize type t, or an empty string if t has no name.
+func NameOfSerializeType(t protocol.SerializeType) string {
+	return loadRegistry().names[t]
+}
+
+// EncodePayload encodes v by c as the payload of m, by EncodeMessage if c is a codec.MessageCodec.
+func EncodePayload(c codec.Codec, m *protocol.Message, v interface{}) ([]byte, error) {
+	if mc, ok := c.(codec.MessageCodec); ok {
+		info := messageInfo(m)
+		data, err := mc.EncodeMessage(info, v)
+		m.Metadata = info.Metadata
+		return data, err
+	}
+	return c.Encode(v)
+}
+
+// DecodePayload decodes data, the payload of m, by c into v, by DecodeMessage if c is a codec.MessageCodec.
+func DecodePayload(c codec.Codec, m *protocol.Message, data []byte, v interface{}) error {
+	if mc, ok := c.(codec.MessageCodec); ok {
+		return mc.DecodeMessage(messageInfo(m), data, v)
+	}
+	return c.Decode(data, v)
+}
+
+// DecodePayloadJSON decodes like DecodePayload, but by opts if c decodes JSON by options.
+func DecodePayloadJSON(c codec.Codec, m *protocol.Message, data []byte, v interface{}, opts codec.JSONOptions) error {
+	if jd, ok := c.(codec.JSONDecoder); ok {
+		return jd.DecodeJSON(data, v, opts)
+	}
+	return DecodePayload(c, m, data, v)
+}
+
+// ReleasePayload returns data, a payload encoded by c, to c if c is a codec.PayloadReleaser.
+// data must not be used after it is released.
+func ReleasePayload(c codec.Codec, data []byte) {
+	if r, ok := c.(codec.PayloadReleaser); ok {
+		r.ReleasePayload(data)
+	}
+}
+
+func messageInfo(m *protocol.Message) *codec.MessageInfo {
+	return &codec.MessageInfo{
+		ServicePath:   m.ServicePath,
+		ServiceMethod: m.ServiceMethod,
+		Response:      m.MessageType() == protocol.Response,
+		Metadata:      m.Metadata,
+	}
 }
 
 // ContextKey defines key type in context.
@@ -65,11 +286,58 @@ var ReqMetaDataKey = ContextKey("__req_metadata")
 // ResMetaDataKey is used to set metatdata in context of responses.
 var ResMetaDataKey = ContextKey("__res_metadata")
 
+// RequestIDKey is the key of request IDs in metadata of requests and responses.
+const RequestIDKey = "x-rpcx-request-id"
+
+// RequestIDContextKey is used to set the request ID in context of requests handled by servers,
+// so that calls made by services propagate it.
+var RequestIDContextKey = ContextKey("__request_id")
+
+// IdentityContextKey is used to set the *Identity of the caller in context of requests handled by servers,
+// for example by AuthFunc after it verifies a JWT, so that authorization plugins and services can use it.
+var IdentityContextKey = ContextKey("__identity")
+
+// ForceSampleContextKey is used to force sampling of calls traced by the OpenTelemetry plugin of clients, whatever samplers decide,
+// for example context.WithValue(ctx, share.ForceSampleContextKey, true) to debug a request end to end.
+var ForceSampleContextKey = ContextKey("__force_sample")
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	// Name is the subject of a JWT or the common name of a peer certificate
+	Name  string
+	Roles []string
+}
+
+// MetadataCarrier adapts metadata of messages to the TextMapCarrier of OpenTelemetry,
+// so trace context can be injected into and extracted from metadata.
+type MetadataCarrier map[string]string
+
+// Get returns the value of key.
+func (c MetadataCarrier) Get(key string) string {
+	return c[key]
+}
+
+// Set sets the value of key.
+func (c MetadataCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys returns the keys in metadata.
+func (c MetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
 // FileTransferArgs args from clients.
 type FileTransferArgs struct {
 	FileName string            `json:"file_name,omitempty"`
 	FileSize int64             `json:"file_size,omitempty"`
 	Meta     map[string]string `json:"meta,omitempty"`
+	// Resumable requests a token of a resumable transfer, whose bytes are sent in frames of WriteFileChunk.
+	Resumable bool `json:"resumable,omitempty"`
 }
 
 // FileTransferReply response to token and addr to clients.
@@ -82,6 +350,18 @@ type FileTransferReply struct {
 type DownloadFileArgs struct {
 	FileName string            `json:"file_name,omitempty"`
 	Meta     map[string]string `json:"meta,omitempty"`
+	// Resumable requests a token of a resumable transfer, whose bytes are sent in frames of WriteFileChunk.
+	Resumable bool `json:"resumable,omitempty"`
+}
+
+// FileOffsetArgs args to query the committed offset of a resumable transfer.
+type FileOffsetArgs struct {
+	Token []byte `json:"token,omitempty"`
+}
+
+// FileOffsetReply response to the committed offset of a resumable transfer.
+type FileOffsetReply struct {
+	Offset int64 `json:"offset,omitempty"`
 }
 
 // StreamServiceArgs is the request type for stream service.
@@ -94,3 +374,67 @@ type StreamServiceReply struct {
 	Token []byte `json:"token,omitempty"`
 	Addr  string `json:"addr,omitempty"`
 }
+
+// ServiceDesc describes a registered service and is returned by the reflection service.
+type ServiceDesc struct {
+	Name     string   `json:"name"`
+	Metadata string   `json:"metadata,omitempty"`
+	Methods  []string `json:"methods,omitempty"`
+}
+
+// ListServicesReply is the reply type of ListServices of the reflection service.
+type ListServicesReply struct {
+	Services []ServiceDesc `json:"services"`
+}
+
+// MethodDesc describes a method of a registered service.
+type MethodDesc struct {
+	Name      string `json:"name"`
+	ArgType   string `json:"arg_type"`
+	ReplyType string `json:"reply_type"`
+}
+
+// ListMethodsReply is the reply type of ListMethods of the reflection service.
+type ListMethodsReply struct {
+	Service string       `json:"service"`
+	Methods []MethodDesc `json:"methods"`
+}
+
+// TypeDesc is a JSON-schema-ish description of an argument or reply type.
+// Ref is set instead of Fields when a struct type refers to itself recursively.
+type TypeDesc struct {
+	Name   string      `json:"name,omitempty"`
+	Kind   string      `json:"kind"`
+	Fields []FieldDesc `json:"fields,omitempty"`
+	Key    *TypeDesc   `json:"key,omitempty"`
+	Elem   *TypeDesc   `json:"elem,omitempty"`
+	Ref    string      `json:"ref,omitempty"`
+}
+
+// FieldDesc describes an exported field of a struct type.
+type FieldDesc struct {
+	Name        string    `json:"name"`
+	JSONName    string    `json:"json_name,omitempty"`
+	MsgpackName string    `json:"msgpack_name,omitempty"`
+	OmitEmpty   bool      `json:"omit_empty,omitempty"`
+	Type        *TypeDesc `json:"type"`
+}
+
+// HealthStatus is the health status of a server or a service.
+type HealthStatus string
+
+const (
+	// HealthUnknown means the service is not known by the server.
+	HealthUnknown HealthStatus = "UNKNOWN"
+	// HealthServing means the service can handle requests.
+	HealthServing HealthStatus = "SERVING"
+	// HealthNotServing means the service should not be sent requests, for example the server is draining.
+	HealthNotServing HealthStatus = "NOT_SERVING"
+)
+
+// HealthCheckReply is the reply type of Check and Watch of the health checking service,
+// and the payload of status changes pushed to watchers in JSON.
+type HealthCheckReply struct {
+	Service string       `json:"service,omitempty"`
+	Status  HealthStatus `json:"status"`
+}
@@ -1,6 +1,7 @@
 package share
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/smallnest/rpcx/protocol"
@@ -25,3 +26,83 @@ func TestShare(t *testing.T) {
 	RegisterCodec(protocol.SerializeType(mockCodecType), codec)
 	assert.Equal(t, registeredCodecNum + 1, len(Codecs))
 }
+
+func TestEncodeMetadata(t *testing.T) {
+	metadata := EncodeMetadata(map[string]string{MetaWeight: "10", MetaGroup: "a b"})
+	assert.Equal(t, "group=a+b&weight=10", metadata)
+
+	meta, err := DecodeMetadata(metadata)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{MetaWeight: "10", MetaGroup: "a b"}, meta)
+}
+
+func TestRegistrationKey(t *testing.T) {
+	assert.Equal(t, "tcp@127.0.0.1:8972", RegistrationKey("tcp@127.0.0.1:8972", ""))
+	key := RegistrationKey("tcp@127.0.0.1:8972", "gold")
+	assert.Equal(t, "tcp@127.0.0.1:8972#gold", key)
+
+	address, id := SplitRegistrationKey(key)
+	assert.Equal(t, "tcp@127.0.0.1:8972", address)
+	assert.Equal(t, "gold", id)
+	address, id = SplitRegistrationKey("tcp@127.0.0.1:8972")
+	assert.Equal(t, "tcp@127.0.0.1:8972", address)
+	assert.Equal(t, "", id)
+
+	assert.Equal(t, "gold", RegistrationOf(EncodeMetadata(map[string]string{MetaGroup: "a", MetaRegistration: "gold"})))
+	assert.Equal(t, "", RegistrationOf("group=a"))
+}
+
+func TestRegisterCodecConflict(t *testing.T) {
+	const mockCodecType = protocol.SerializeType(126)
+	defer delete(Codecs, mockCodecType)
+
+	assert.NoError(t, RegisterCodec(mockCodecType, MockCodec{}))
+	// codecs of the same type are replaced
+	assert.NoError(t, RegisterCodec(mockCodecType, MockCodec{}))
+
+	// but those of other types are not
+	err := RegisterCodec(mockCodecType, &MockCodec{})
+	assert.True(t, errors.Is(err, ErrCodecRegistered), err)
+	assert.IsType(t, MockCodec{}, Codecs[mockCodecType])
+}
+
+func TestCodecsAddedDirectly(t *testing.T) {
+	const registered, added = protocol.SerializeType(124), protocol.SerializeType(123)
+	defer delete(Codecs, registered)
+	defer delete(Codecs, added)
+
+	assert.NoError(t, RegisterCodec(registered, MockCodec{}))
+	assert.IsType(t, MockCodec{}, CodecOf(registered))
+
+	// codecs added in Codecs after the snapshot is taken are still found
+	Codecs[added] = &MockCodec{}
+	assert.IsType(t, &MockCodec{}, CodecOf(added))
+	assert.IsType(t, &MockCodec{}, CodecOf(added))
+	assert.Nil(t, CodecOf(protocol.SerializeType(122)))
+}
+
+func TestRegisterCodecWithName(t *testing.T) {
+	const mockCodecType = protocol.SerializeType(125)
+	defer delete(Codecs, mockCodecType)
+
+	st, ok := SerializeTypeOfName("application/x-msgpack")
+	assert.True(t, ok)
+	assert.Equal(t, protocol.MsgPack, st)
+	assert.Equal(t, "application/x-msgpack", NameOfSerializeType(protocol.MsgPack))
+	assert.Equal(t, "", NameOfSerializeType(protocol.SerializeNone))
+
+	assert.NoError(t, RegisterCodecWithName(mockCodecType, "application/vnd.mock", MockCodec{}))
+	assert.NoError(t, RegisterCodecWithName(mockCodecType, "Application/X-Mock", MockCodec{}))
+	st, ok = SerializeTypeOfName("application/x-mock")
+	assert.True(t, ok)
+	assert.Equal(t, mockCodecType, st)
+	assert.Equal(t, "application/vnd.mock", NameOfSerializeType(mockCodecType))
+	assert.IsType(t, MockCodec{}, CodecOf(mockCodecType))
+	assert.IsType(t, MockCodec{}, Codecs[mockCodecType])
+
+	// names of other serialize types are not overwritten
+	err := RegisterCodecWithName(mockCodecType, "application/json", MockCodec{})
+	assert.True(t, errors.Is(err, ErrCodecRegistered), err)
+	st, _ = SerializeTypeOfName("application/json")
+	assert.Equal(t, protocol.JSON, st)
+}
@@ -0,0 +1,51 @@
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// Metadata keys of signed requests. Signed responses have the key ID, timestamp and signature too.
+const (
+	// SignatureKeyIDKey is the ID of the key signing the request, so that keys can be rotated.
+	SignatureKeyIDKey = "__rpcx_sign_key_id__"
+	// SignatureTimestampKey is the unix time in seconds when the request is signed.
+	SignatureTimestampKey = "__rpcx_sign_timestamp__"
+	// SignatureNonceKey is a random string which is unique for every request.
+	SignatureNonceKey = "__rpcx_sign_nonce__"
+	// SignatureKey is the hex encoded HMAC-SHA256 signature of the request.
+	SignatureKey = "__rpcx_signature__"
+)
+
+// SignRequest returns the hex encoded HMAC-SHA256 over the key ID, service path, service method, timestamp, nonce
+// and the SHA-256 hash of the payload, which are separated by newlines.
+// The payload is the one on the wire, which is compressed if the request is, as protocol.Message.WirePayload returns.
+func SignRequest(key []byte, keyID, servicePath, serviceMethod, timestamp, nonce string, payload []byte) string {
+	payloadHash := sha256.Sum256(payload)
+
+	mac := hmac.New(sha256.New, key)
+	for _, s := range []string{keyID, servicePath, serviceMethod, timestamp, nonce} {
+		mac.Write([]byte(s))
+		mac.Write([]byte{'\n'})
+	}
+	mac.Write([]byte(hex.EncodeToString(payloadHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignResponse returns the hex encoded HMAC-SHA256 of a response like SignRequest, over "response", the key ID,
+// service path, service method, seq, timestamp, the error of error responses and the SHA-256 hash of the payload,
+// so signatures of requests can't be used as responses and errors can't be forged.
+// The payload is the one on the wire, which is compressed if the response is, as protocol.Message.WirePayload returns.
+func SignResponse(key []byte, keyID, servicePath, serviceMethod string, seq uint64, timestamp, serviceError string, payload []byte) string {
+	payloadHash := sha256.Sum256(payload)
+
+	mac := hmac.New(sha256.New, key)
+	for _, s := range []string{"response", keyID, servicePath, serviceMethod, strconv.FormatUint(seq, 10), timestamp, serviceError} {
+		mac.Write([]byte(s))
+		mac.Write([]byte{'\n'})
+	}
+	mac.Write([]byte(hex.EncodeToString(payloadHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,44 @@
+// protoc-gen-rpcx-go is a plugin of protoc, which generates typed rpcx clients and server registrations of protobuf services.
+//
+// It generates for every service of the .proto files, in <file>_rpcx.pb.go beside the messages generated by protoc-gen-go:
+//   - constants of the service path and method names.
+//   - XxxServer, the interface of the implementation of the service, and RegisterXxxServer which registers it in a server.
+//   - XxxClient, the typed client wrapping an XClient, with a method of every rpc which sends protobuf requests.
+//
+// Methods can be options of rpcx/options.proto, which is imported by adding this directory to the import paths of protoc:
+//
+//	rpc Wave(HelloRequest) returns (google.protobuf.Empty) {
+//	  option (rpcx.oneway) = true;
+//	}
+//	rpc SayHelloSlowly(HelloRequest) returns (HelloReply) {
+//	  option (rpcx.timeout_ms) = 100;
+//	}
+//
+// Usage:
+//
+//	go install github.com/smallnest/rpcx/tool/protoc-gen-rpcx-go
+//	protoc -I. -I$(go list -m -f '{{.Dir}}' github.com/smallnest/rpcx)/tool/protoc-gen-rpcx-go \
+//	  --go_out=paths=source_relative:. --rpcx-go_out=paths=source_relative:. helloworld.proto
+//
+// Streaming methods are not supported by rpcx, and fail the generation.
+package main
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	protogen.Options{}.Run(func(gen *protogen.Plugin) error {
+		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+		for _, f := range gen.Files {
+			if !f.Generate {
+				continue
+			}
+			if err := generateFile(gen, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/smallnest/rpcx/tool/protoc-gen-rpcx-go/rpcx"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	contextPackage  = protogen.GoImportPath("context")
+	timePackage     = protogen.GoImportPath("time")
+	clientPackage   = protogen.GoImportPath("github.com/smallnest/rpcx/client")
+	serverPackage   = protogen.GoImportPath("github.com/smallnest/rpcx/server")
+	protocolPackage = protogen.GoImportPath("github.com/smallnest/rpcx/protocol")
+)
+
+// generateFile generates <file>_rpcx.pb.go of services of file. Files without services are skipped.
+func generateFile(gen *protogen.Plugin, file *protogen.File) error {
+	if len(file.Services) == 0 {
+		return nil
+	}
+	for _, service := range file.Services {
+		for _, method := range service.Methods {
+			if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+				return fmt.Errorf("%s: streaming method %s is not supported by rpcx", file.Desc.Path(), method.Desc.FullName())
+			}
+		}
+	}
+
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+"_rpcx.pb.go", file.GoImportPath)
+	g.P("// Code generated by protoc-gen-rpcx-go. DO NOT EDIT.")
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	for _, service := range file.Services {
+		generateService(g, service)
+	}
+	return nil
+}
+
+func generateService(g *protogen.GeneratedFile, service *protogen.Service) {
+	name := service.GoName
+	ctx := g.QualifiedGoIdent(contextPackage.Ident("Context"))
+
+	g.P("// Service path and method names of ", name, ".")
+	g.P("const (")
+	g.P(name, "_ServicePath = ", fmt.Sprintf("%q", name))
+	for _, method := range service.Methods {
+		g.P(name, "_", method.GoName, "_MethodName = ", fmt.Sprintf("%q", method.GoName))
+	}
+	g.P(")")
+	g.P()
+
+	// server
+	g.P("// ", name, "Server is the implementation of the ", name, " service.")
+	if service.Comments.Leading != "" {
+		g.P("//")
+		leadingComments(g, service.Comments.Leading)
+	}
+	g.P("type ", name, "Server interface {")
+	for _, method := range service.Methods {
+		leadingComments(g, method.Comments.Leading)
+		deprecated(g, method)
+		g.P(method.GoName, "(ctx ", ctx, ", req *", g.QualifiedGoIdent(method.Input.GoIdent), ", reply *", g.QualifiedGoIdent(method.Output.GoIdent), ") error")
+	}
+	g.P("}")
+	g.P()
+
+	serviceType := unexport(name) + "Service"
+	g.P("// Register", name, "Server registers impl as the ", name, " service of s.")
+	g.P("// Requests are decoded by their serialize types, which are protobuf by ", name, "Client.")
+	g.P("func Register", name, "Server(s *", g.QualifiedGoIdent(serverPackage.Ident("Server")), ", impl ", name, "Server) error {")
+	g.P("return s.RegisterName(", name, "_ServicePath, &", serviceType, "{impl: impl}, \"\")")
+	g.P("}")
+	g.P()
+	g.P("// ", serviceType, " publishes only the methods of ", name, "Server.")
+	g.P("type ", serviceType, " struct {")
+	g.P("impl ", name, "Server")
+	g.P("}")
+	g.P()
+	for _, method := range service.Methods {
+		g.P("func (s *", serviceType, ") ", method.GoName, "(ctx ", ctx, ", req *", g.QualifiedGoIdent(method.Input.GoIdent), ", reply *", g.QualifiedGoIdent(method.Output.GoIdent), ") error {")
+		g.P("return s.impl.", method.GoName, "(ctx, req, reply)")
+		g.P("}")
+		g.P()
+	}
+
+	// client
+	xclient := g.QualifiedGoIdent(clientPackage.Ident("XClient"))
+	g.P("// ", name, "Client is the client of the ", name, " service, which sends protobuf requests.")
+	g.P("type ", name, "Client struct {")
+	g.P("xclient ", xclient)
+	g.P("}")
+	g.P()
+	g.P("// New", name, "Client creates a ", name, "Client of xclient, which is an XClient of ", name, "_ServicePath.")
+	g.P("func New", name, "Client(xclient ", xclient, ") *", name, "Client {")
+	g.P("return &", name, "Client{xclient: xclient}")
+	g.P("}")
+	g.P()
+	g.P("// XClient returns the XClient of c.")
+	g.P("func (c *", name, "Client) XClient() ", xclient, " {")
+	g.P("return c.xclient")
+	g.P("}")
+	g.P()
+	for _, method := range service.Methods {
+		generateClientMethod(g, name, method)
+	}
+}
+
+func generateClientMethod(g *protogen.GeneratedFile, service string, method *protogen.Method) {
+	options, _ := method.Desc.Options().(*descriptorpb.MethodOptions)
+	oneway, _ := proto.GetExtension(options, rpcx.E_Oneway).(bool)
+	timeout, _ := proto.GetExtension(options, rpcx.E_TimeoutMs).(uint32)
+
+	if method.Comments.Leading != "" {
+		leadingComments(g, method.Comments.Leading)
+	} else {
+		g.P("// ", method.GoName, " calls ", service, ".", method.GoName, ".")
+	}
+	if oneway {
+		g.P("// It is oneway, so it returns once the request is sent.")
+	}
+	if timeout > 0 {
+		g.P("// It times out in ", timeout, "ms unless ctx expires earlier.")
+	}
+	deprecated(g, method)
+
+	ctx := g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	req := g.QualifiedGoIdent(method.Input.GoIdent)
+	reply := g.QualifiedGoIdent(method.Output.GoIdent)
+	if oneway {
+		g.P("func (c *", service, "Client) ", method.GoName, "(ctx ", ctx, ", req *", req, ") error {")
+	} else {
+		g.P("func (c *", service, "Client) ", method.GoName, "(ctx ", ctx, ", req *", req, ") (*", reply, ", error) {")
+	}
+	if timeout > 0 {
+		g.P("ctx, cancel := ", contextPackage.Ident("WithTimeout"), "(ctx, ", timeout, "*", timePackage.Ident("Millisecond"), ")")
+		g.P("defer cancel()")
+	}
+	g.P("ctx = ", clientPackage.Ident("WithSerializeType"), "(ctx, ", protocolPackage.Ident("ProtoBuffer"), ")")
+	if oneway {
+		g.P("return c.xclient.Call(ctx, ", service, "_", method.GoName, "_MethodName, req, nil)")
+	} else {
+		g.P("reply := &", reply, "{}")
+		g.P("if err := c.xclient.Call(ctx, ", service, "_", method.GoName, "_MethodName, req, reply); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return reply, nil")
+	}
+	g.P("}")
+	g.P()
+}
+
+// leadingComments writes comments of the .proto file if they are not empty.
+func leadingComments(g *protogen.GeneratedFile, comments protogen.Comments) {
+	if comments == "" {
+		return
+	}
+	g.P(strings.TrimSuffix(comments.String(), "\n"))
+}
+
+func deprecated(g *protogen.GeneratedFile, method *protogen.Method) {
+	if options, ok := method.Desc.Options().(*descriptorpb.MethodOptions); ok && options.GetDeprecated() {
+		g.P("//")
+		g.P("// Deprecated: Do not use.")
+	}
+}
+
+func unexport(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
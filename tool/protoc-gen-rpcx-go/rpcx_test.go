@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	rerrors "github.com/smallnest/rpcx/errors"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/tool/protoc-gen-rpcx-go/testdata/helloworld"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// testdata/helloworld is generated by protoc and this plugin, from the testdata directory:
+//
+//	protoc -I. -I.. --include_imports --include_source_info --descriptor_set_out=helloworld/helloworld.protoset \
+//	  --go_out=paths=source_relative:. --rpcx-go_out=paths=source_relative:. helloworld/helloworld.proto
+var update = flag.Bool("update", false, "update the golden files of testdata")
+
+const helloworldProto = "helloworld/helloworld.proto"
+
+func generate(t *testing.T, edit func(files []*descriptorpb.FileDescriptorProto)) *pluginpb.CodeGeneratorResponse {
+	b, err := ioutil.ReadFile("testdata/helloworld/helloworld.protoset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(b, set); err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		edit(set.File)
+	}
+
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{helloworldProto},
+		Parameter:      proto.String("paths=source_relative"),
+		ProtoFile:      set.File,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range gen.Files {
+		if f.Generate {
+			if err := generateFile(gen, f); err != nil {
+				gen.Error(err)
+			}
+		}
+	}
+	return gen.Response()
+}
+
+func TestGolden(t *testing.T) {
+	res := generate(t, nil)
+	if res.Error != nil {
+		t.Fatal(res.GetError())
+	}
+	if len(res.File) != 1 || res.File[0].GetName() != "helloworld/helloworld_rpcx.pb.go" {
+		t.Fatalf("unexpected generated files %v", res.File)
+	}
+
+	golden := filepath.Join("testdata", res.File[0].GetName())
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(res.File[0].GetContent()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, []byte(res.File[0].GetContent())) {
+		t.Fatalf("generated code is different from %s, run go test -update to update it:\n%s", golden, res.File[0].GetContent())
+	}
+}
+
+func TestStreamingUnsupported(t *testing.T) {
+	res := generate(t, func(files []*descriptorpb.FileDescriptorProto) {
+		for _, f := range files {
+			if f.GetName() == helloworldProto {
+				f.Service[0].Method[0].ServerStreaming = proto.Bool(true)
+			}
+		}
+	})
+	if res.GetError() != "helloworld/helloworld.proto: streaming method helloworld.Greeter.SayHello is not supported by rpcx" {
+		t.Fatalf("unexpected error %q", res.GetError())
+	}
+}
+
+type greeter struct {
+	waved chan string
+}
+
+func (g *greeter) SayHello(ctx context.Context, req *helloworld.HelloRequest, reply *helloworld.HelloReply) error {
+	reply.Message = "hello " + req.Name
+	return nil
+}
+
+func (g *greeter) SayHelloSlowly(ctx context.Context, req *helloworld.HelloRequest, reply *helloworld.HelloReply) error {
+	select {
+	case <-time.After(time.Duration(req.DelayMs) * time.Millisecond):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return g.SayHello(ctx, req, reply)
+}
+
+func (g *greeter) Wave(ctx context.Context, req *helloworld.HelloRequest, reply *emptypb.Empty) error {
+	g.waved <- req.Name
+	return nil
+}
+
+// Extra is not a method of GreeterServer, so it is not published.
+func (g *greeter) Extra(ctx context.Context, req *helloworld.HelloRequest, reply *helloworld.HelloReply) error {
+	return nil
+}
+
+func TestGeneratedService(t *testing.T) {
+	g := &greeter{waved: make(chan string, 1)}
+	s := server.NewServer()
+	if err := helloworld.RegisterGreeterServer(s, g); err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d, err := client.NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xclient := client.NewXClient(helloworld.Greeter_ServicePath, client.Failfast, client.RandomSelect, d, client.DefaultOption)
+	defer xclient.Close()
+	c := helloworld.NewGreeterClient(xclient)
+
+	reply, err := c.SayHello(context.Background(), &helloworld.HelloRequest{Name: "rpcx"})
+	if err != nil || reply.Message != "hello rpcx" {
+		t.Fatalf("unexpected reply %v: %v", reply, err)
+	}
+
+	// the timeout of the method option
+	reply, err = c.SayHelloSlowly(context.Background(), &helloworld.HelloRequest{Name: "rpcx", DelayMs: 10})
+	if err != nil || reply.Message != "hello rpcx" {
+		t.Fatalf("unexpected reply %v: %v", reply, err)
+	}
+	// the deadline is propagated, so the server may fail first
+	_, err = c.SayHelloSlowly(context.Background(), &helloworld.HelloRequest{DelayMs: 1000})
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, rerrors.ErrDeadlineExceeded) {
+		t.Fatalf("expect the call to time out but got %v", err)
+	}
+
+	if err = c.Wave(context.Background(), &helloworld.HelloRequest{Name: "oneway"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-g.waved:
+		if name != "oneway" {
+			t.Fatalf("unexpected wave of %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("oneway request is not handled")
+	}
+
+	if err = xclient.Call(context.Background(), "Extra", &helloworld.HelloRequest{}, &helloworld.HelloReply{}); err == nil {
+		t.Fatal("expect methods out of GreeterServer not to be published")
+	}
+}
@@ -3,6 +3,8 @@ package util
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
+	"io"
 	"io/ioutil"
 	"sync"
 )
@@ -25,8 +27,21 @@ func init() {
 	}}
 }
 
+// ErrUnzipTooLarge is returned by UnzipLimit if unzipped data is longer than the limit.
+var ErrUnzipTooLarge = errors.New("unzipped data is too large")
+
 // Unzip unzips data.
 func Unzip(data []byte) ([]byte, error) {
+	return unzip(data, 0)
+}
+
+// UnzipLimit unzips data and returns ErrUnzipTooLarge if unzipped data is longer than limit.
+// It stops unzipping at the limit.
+func UnzipLimit(data []byte, limit int) ([]byte, error) {
+	return unzip(data, limit)
+}
+
+func unzip(data []byte, limit int) ([]byte, error) {
 	buf := spBuffer.Get().(*bytes.Buffer)
 	defer func() {
 		buf.Reset()
@@ -48,10 +63,17 @@ func Unzip(data []byte) ([]byte, error) {
 	}
 	defer gr.Close()
 
-	data, err = ioutil.ReadAll(gr)
+	var r io.Reader = gr
+	if limit > 0 {
+		r = io.LimitReader(gr, int64(limit)+1)
+	}
+	data, err = ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
+	if limit > 0 && len(data) > limit {
+		return nil, ErrUnzipTooLarge
+	}
 	return data, err
 }
 
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/valyala/fastrand"
+)
+
+// crockford is the Crockford's base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID: 48 bits of the unix milliseconds and 80 random bits, encoded in 26 characters.
+// ULIDs sort by the time they are created.
+func NewULID() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	b[0], b[1], b[2] = byte(ms>>40), byte(ms>>32), byte(ms>>24)
+	b[3], b[4], b[5] = byte(ms>>16), byte(ms>>8), byte(ms)
+	if _, err := rand.Read(b[6:]); err != nil {
+		binary.BigEndian.PutUint32(b[6:], fastrand.Uint32())
+		binary.BigEndian.PutUint32(b[10:], fastrand.Uint32())
+		binary.BigEndian.PutUint16(b[14:], uint16(fastrand.Uint32()))
+	}
+
+	// 128 bits are encoded from the most significant bits, the first character holds 3 bits
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var s [26]byte
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
@@ -0,0 +1,29 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewULID(t *testing.T) {
+	seen := make(map[string]bool)
+	prev := ""
+	for i := 0; i < 1000; i++ {
+		id := NewULID()
+		if len(id) != 26 || strings.Trim(id, crockford) != "" {
+			t.Fatalf("invalid ULID %q", id)
+		}
+		if id[:10] < prev {
+			t.Fatalf("expect ULIDs sorted by time but got %s after %s", id, prev)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate ULID %s", id)
+		}
+		seen[id] = true
+		prev = id[:10]
+	}
+	// the first character holds the 3 most significant bits
+	if id := NewULID(); id[0] > '7' {
+		t.Errorf("invalid first character of %s", id)
+	}
+}
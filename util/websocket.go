@@ -0,0 +1,74 @@
+package util
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebsocketConn adapts a websocket connection to net.Conn.
+// Every Write is sent as a binary message and Read reads messages as a stream of bytes.
+type WebsocketConn struct {
+	*websocket.Conn
+
+	wmu sync.Mutex
+	r   io.Reader
+}
+
+// NewWebsocketConn creates a WebsocketConn.
+func NewWebsocketConn(conn *websocket.Conn) *WebsocketConn {
+	return &WebsocketConn{Conn: conn}
+}
+
+// Read reads data of messages.
+func (c *WebsocketConn) Read(p []byte) (int, error) {
+	for {
+		if c.r == nil {
+			_, r, err := c.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			c.r = r
+		}
+
+		n, err := c.r.Read(p)
+		if err == io.EOF {
+			c.r = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Write sends p as a binary message. It is safe to call Write concurrently.
+func (c *WebsocketConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteText sends p as a text message. It is safe to call WriteText concurrently with Write.
+func (c *WebsocketConn) WriteText(p []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.WriteMessage(websocket.TextMessage, p)
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *WebsocketConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+var _ net.Conn = (*WebsocketConn)(nil)
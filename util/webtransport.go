@@ -0,0 +1,388 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// WebTransport over HTTP/3 is served by the subset of HTTP/3 which it needs: SETTINGS of control streams,
+// HEADERS of the extended CONNECT of sessions, and QPACK with the static table only, since the dynamic table
+// is disabled by SETTINGS_QPACK_MAX_TABLE_CAPACITY = 0.
+const (
+	// WebTransportALPN is the ALPN of HTTP/3, which negotiates WebTransport sessions.
+	WebTransportALPN = "h3"
+	// WebTransportBidiStream is the signal value of bidirectional WebTransport streams,
+	// followed by the session ID, which is the ID of the CONNECT stream of the session.
+	WebTransportBidiStream = 0x41
+	// WebTransportUniStream is the type of unidirectional WebTransport streams.
+	WebTransportUniStream = 0x54
+
+	// H3ControlStream is the type of unidirectional control streams of HTTP/3.
+	H3ControlStream = 0x00
+	// H3FrameData is the type of DATA frames of HTTP/3.
+	H3FrameData = 0x00
+	// H3FrameHeaders is the type of HEADERS frames of HTTP/3.
+	H3FrameHeaders = 0x01
+	// H3FrameSettings is the type of SETTINGS frames of HTTP/3.
+	H3FrameSettings = 0x04
+
+	settingQPACKMaxTableCapacity = 0x01
+	settingQPACKBlockedStreams   = 0x07
+	settingEnableConnectProtocol = 0x08
+	settingH3Datagram            = 0x33
+	settingH3DatagramDraft       = 0xffd277
+	settingEnableWebTransport    = 0x2b603742
+	settingWebTransportMaxSess   = 0xc671706a
+
+	// maxH3FrameSize limits frames read by ReadH3Frame, since only headers and settings are read.
+	maxH3FrameSize = 64 * 1024
+)
+
+var (
+	// ErrVarintOverflow is the error of integers which are too large for varints of QUIC.
+	ErrVarintOverflow = errors.New("rpcx: integer is too large for a varint")
+	// ErrQPACKDynamicTable is the error of QPACK field sections which refer to the dynamic table, which is not supported.
+	ErrQPACKDynamicTable = errors.New("rpcx: qpack dynamic table is not supported")
+	// ErrQPACKMalformed is the error of malformed QPACK field sections.
+	ErrQPACKMalformed = errors.New("rpcx: malformed qpack field section")
+	// ErrH3FrameTooLarge is the error of HTTP/3 frames larger than 64KB.
+	ErrH3FrameTooLarge = errors.New("rpcx: http3 frame is too large")
+)
+
+// H3Field is a header field of HTTP/3.
+type H3Field struct {
+	Name  string
+	Value string
+}
+
+// AppendVarint appends v as a variable-length integer of QUIC.
+func AppendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case v < 1<<30:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// ReadVarint reads a variable-length integer of QUIC. It reads bytes of r one by one,
+// so the rest of a stream is not read, such as the data of a WebTransport stream after its signal value.
+func ReadVarint(r io.Reader) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:1]); err != nil {
+		return 0, err
+	}
+	n := 1 << (b[0] >> 6)
+	if n > 1 {
+		if _, err := io.ReadFull(r, b[1:n]); err != nil {
+			return 0, err
+		}
+	}
+	v := uint64(b[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, nil
+}
+
+// WriteH3Frame writes a frame of HTTP/3.
+func WriteH3Frame(w io.Writer, typ uint64, payload []byte) error {
+	b := AppendVarint(nil, typ)
+	b = AppendVarint(b, uint64(len(payload)))
+	_, err := w.Write(append(b, payload...))
+	return err
+}
+
+// ReadH3Frame reads a frame of HTTP/3, whose payload is at most 64KB.
+func ReadH3Frame(r io.Reader) (typ uint64, payload []byte, err error) {
+	if typ, err = ReadVarint(r); err != nil {
+		return 0, nil, err
+	}
+	n, err := ReadVarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if n > maxH3FrameSize {
+		return 0, nil, ErrH3FrameTooLarge
+	}
+	payload = make([]byte, n)
+	_, err = io.ReadFull(r, payload)
+	return typ, payload, err
+}
+
+// WriteWebTransportSettings writes the type of the control stream and the SETTINGS frame, which enable
+// the extended CONNECT, WebTransport and datagrams of HTTP/3, and disable the dynamic table of QPACK.
+// Draft settings of browsers are included, and unknown settings are ignored by peers.
+func WriteWebTransportSettings(w io.Writer) error {
+	var settings []byte
+	for _, s := range [][2]uint64{
+		{settingQPACKMaxTableCapacity, 0},
+		{settingQPACKBlockedStreams, 0},
+		{settingEnableConnectProtocol, 1},
+		{settingH3Datagram, 1},
+		{settingH3DatagramDraft, 1},
+		{settingEnableWebTransport, 1},
+		{settingWebTransportMaxSess, 1},
+	} {
+		settings = AppendVarint(settings, s[0])
+		settings = AppendVarint(settings, s[1])
+	}
+	if _, err := w.Write(AppendVarint(nil, H3ControlStream)); err != nil {
+		return err
+	}
+	return WriteH3Frame(w, H3FrameSettings, settings)
+}
+
+// WriteH3Headers writes fields as a HEADERS frame.
+func WriteH3Headers(w io.Writer, fields []H3Field) error {
+	return WriteH3Frame(w, H3FrameHeaders, EncodeQPACK(fields))
+}
+
+// ReadH3Headers reads the HEADERS frame of a request or a response stream.
+func ReadH3Headers(r io.Reader) ([]H3Field, error) {
+	typ, payload, err := ReadH3Frame(r)
+	if err != nil {
+		return nil, err
+	}
+	if typ != H3FrameHeaders {
+		return nil, fmt.Errorf("rpcx: unexpected http3 frame %#x instead of headers", typ)
+	}
+	return DecodeQPACK(payload)
+}
+
+// H3FieldValue returns the value of the field name in fields.
+func H3FieldValue(fields []H3Field, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// qpackStaticTable is the static table of QPACK, RFC 9204 Appendix A.
+var qpackStaticTable = [...]H3Field{
+	{":authority", ""}, {":path", "/"}, {"age", "0"}, {"content-disposition", ""}, {"content-length", "0"},
+	{"cookie", ""}, {"date", ""}, {"etag", ""}, {"if-modified-since", ""}, {"if-none-match", ""},
+	{"last-modified", ""}, {"link", ""}, {"location", ""}, {"referer", ""}, {"set-cookie", ""},
+	{":method", "CONNECT"}, {":method", "DELETE"}, {":method", "GET"}, {":method", "HEAD"}, {":method", "OPTIONS"},
+	{":method", "POST"}, {":method", "PUT"}, {":scheme", "http"}, {":scheme", "https"}, {":status", "103"},
+	{":status", "200"}, {":status", "304"}, {":status", "404"}, {":status", "503"}, {"accept", "*/*"},
+	{"accept", "application/dns-message"}, {"accept-encoding", "gzip, deflate, br"}, {"accept-ranges", "bytes"},
+	{"access-control-allow-headers", "cache-control"}, {"access-control-allow-headers", "content-type"},
+	{"access-control-allow-origin", "*"}, {"cache-control", "max-age=0"}, {"cache-control", "max-age=2592000"},
+	{"cache-control", "max-age=604800"}, {"cache-control", "no-cache"}, {"cache-control", "no-store"},
+	{"cache-control", "public, max-age=31536000"}, {"content-encoding", "br"}, {"content-encoding", "gzip"},
+	{"content-type", "application/dns-message"}, {"content-type", "application/javascript"},
+	{"content-type", "application/json"}, {"content-type", "application/x-www-form-urlencoded"},
+	{"content-type", "image/gif"}, {"content-type", "image/jpeg"}, {"content-type", "image/png"},
+	{"content-type", "text/css"}, {"content-type", "text/html; charset=utf-8"}, {"content-type", "text/plain"},
+	{"content-type", "text/plain;charset=utf-8"}, {"range", "bytes=0-"},
+	{"strict-transport-security", "max-age=31536000"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains; preload"},
+	{"vary", "accept-encoding"}, {"vary", "origin"}, {"x-content-type-options", "nosniff"},
+	{"x-xss-protection", "1; mode=block"}, {":status", "100"}, {":status", "204"}, {":status", "206"},
+	{":status", "302"}, {":status", "400"}, {":status", "403"}, {":status", "421"}, {":status", "425"},
+	{":status", "500"}, {"accept-language", ""}, {"access-control-allow-credentials", "FALSE"},
+	{"access-control-allow-credentials", "TRUE"}, {"access-control-allow-headers", "*"},
+	{"access-control-allow-methods", "get"}, {"access-control-allow-methods", "get, post, options"},
+	{"access-control-allow-methods", "options"}, {"access-control-expose-headers", "content-length"},
+	{"access-control-request-headers", "content-type"}, {"access-control-request-method", "get"},
+	{"access-control-request-method", "post"}, {"alt-svc", "clear"}, {"authorization", ""},
+	{"content-security-policy", "script-src 'none'; object-src 'none'; base-uri 'none'"}, {"early-data", "1"},
+	{"expect-ct", ""}, {"forwarded", ""}, {"if-range", ""}, {"origin", ""}, {"purpose", "prefetch"}, {"server", ""},
+	{"timing-allow-origin", "*"}, {"upgrade-insecure-requests", "1"}, {"user-agent", ""},
+	{"x-forwarded-for", ""}, {"x-frame-options", "deny"}, {"x-frame-options", "sameorigin"},
+}
+
+// appendQPACKInt appends v as an integer with an n-bit prefix, whose high bits are flags.
+func appendQPACKInt(b []byte, flags byte, n uint, v uint64) []byte {
+	max := uint64(1)<<n - 1
+	if v < max {
+		return append(b, flags|byte(v))
+	}
+	b = append(b, flags|byte(max))
+	for v -= max; v >= 0x80; v >>= 7 {
+		b = append(b, byte(v)|0x80)
+	}
+	return append(b, byte(v))
+}
+
+// readQPACKInt reads an integer with an n-bit prefix of p, and returns the rest of p.
+func readQPACKInt(p []byte, n uint) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, nil, ErrQPACKMalformed
+	}
+	max := uint64(1)<<n - 1
+	v := uint64(p[0]) & max
+	p = p[1:]
+	if v < max {
+		return v, p, nil
+	}
+	for shift := uint(0); ; shift += 7 {
+		if len(p) == 0 || shift > 56 {
+			return 0, nil, ErrQPACKMalformed
+		}
+		b := p[0]
+		p = p[1:]
+		v += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, p, nil
+		}
+	}
+}
+
+// readQPACKString reads a string whose length has an n-bit prefix and whose Huffman flag is the bit above the prefix.
+func readQPACKString(p []byte, n uint) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", nil, ErrQPACKMalformed
+	}
+	huffman := p[0]&(1<<n) != 0
+	l, p, err := readQPACKInt(p, n)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(p)) < l {
+		return "", nil, ErrQPACKMalformed
+	}
+	s, p := p[:l], p[l:]
+	if !huffman {
+		return string(s), p, nil
+	}
+	v, err := hpack.HuffmanDecodeToString(s)
+	if err != nil {
+		return "", nil, ErrQPACKMalformed
+	}
+	return v, p, nil
+}
+
+// EncodeQPACK encodes fields as a QPACK field section without the dynamic table.
+// Fields of the static table are indexed, and other fields are literals without Huffman encoding.
+func EncodeQPACK(fields []H3Field) []byte {
+	b := []byte{0, 0} // required insert count and delta base
+	for _, f := range fields {
+		nameIndex := -1
+		index := -1
+		for i, sf := range qpackStaticTable {
+			if sf.Name == f.Name {
+				if nameIndex < 0 {
+					nameIndex = i
+				}
+				if sf.Value == f.Value {
+					index = i
+					break
+				}
+			}
+		}
+		switch {
+		case index >= 0: // indexed field line of the static table
+			b = appendQPACKInt(b, 0xc0, 6, uint64(index))
+			continue
+		case nameIndex >= 0: // literal field line with a name reference of the static table
+			b = appendQPACKInt(b, 0x50, 4, uint64(nameIndex))
+		default: // literal field line with a literal name
+			b = appendQPACKInt(b, 0x20, 3, uint64(len(f.Name)))
+			b = append(b, f.Name...)
+		}
+		b = appendQPACKInt(b, 0, 7, uint64(len(f.Value)))
+		b = append(b, f.Value...)
+	}
+	return b
+}
+
+// DecodeQPACK decodes a QPACK field section. Sections which refer to the dynamic table fail with ErrQPACKDynamicTable.
+func DecodeQPACK(p []byte) ([]H3Field, error) {
+	ric, p, err := readQPACKInt(p, 8)
+	if err != nil {
+		return nil, err
+	}
+	if ric != 0 {
+		return nil, ErrQPACKDynamicTable
+	}
+	if _, p, err = readQPACKInt(p, 7); err != nil { // delta base, which is meaningless without the dynamic table
+		return nil, err
+	}
+
+	var fields []H3Field
+	for len(p) > 0 {
+		b := p[0]
+		var f H3Field
+		switch {
+		case b&0x80 != 0: // indexed field line
+			if b&0x40 == 0 {
+				return nil, ErrQPACKDynamicTable
+			}
+			var i uint64
+			if i, p, err = readQPACKInt(p, 6); err != nil {
+				return nil, err
+			}
+			if i >= uint64(len(qpackStaticTable)) {
+				return nil, ErrQPACKMalformed
+			}
+			f = qpackStaticTable[i]
+		case b&0x40 != 0: // literal field line with a name reference
+			if b&0x10 == 0 {
+				return nil, ErrQPACKDynamicTable
+			}
+			var i uint64
+			if i, p, err = readQPACKInt(p, 4); err != nil {
+				return nil, err
+			}
+			if i >= uint64(len(qpackStaticTable)) {
+				return nil, ErrQPACKMalformed
+			}
+			f.Name = qpackStaticTable[i].Name
+			if f.Value, p, err = readQPACKString(p, 7); err != nil {
+				return nil, err
+			}
+		case b&0x20 != 0: // literal field line with a literal name
+			if f.Name, p, err = readQPACKString(p, 3); err != nil {
+				return nil, err
+			}
+			if f.Value, p, err = readQPACKString(p, 7); err != nil {
+				return nil, err
+			}
+		default: // post-base references of the dynamic table
+			return nil, ErrQPACKDynamicTable
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// IsWebTransportConnect reports whether fields are the headers of an extended CONNECT of a WebTransport session to path.
+func IsWebTransportConnect(fields []H3Field, path string) bool {
+	return H3FieldValue(fields, ":method") == "CONNECT" &&
+		H3FieldValue(fields, ":protocol") == "webtransport" &&
+		H3FieldValue(fields, ":path") == path
+}
+
+// webTransportResponse returns the headers of the response of a WebTransport CONNECT.
+func webTransportResponse(status string) []H3Field {
+	return []H3Field{{":status", status}, {"sec-webtransport-http3-draft", "draft02"}}
+}
+
+// WriteWebTransportResponse writes the response of a WebTransport CONNECT, which is accepted by status 200.
+func WriteWebTransportResponse(w io.Writer, status string) error {
+	return WriteH3Headers(w, webTransportResponse(status))
+}
+
+// WebTransportConnect returns the headers of the extended CONNECT of a WebTransport session to authority and path.
+func WebTransportConnect(authority, path string) []H3Field {
+	return []H3Field{
+		{":method", "CONNECT"},
+		{":scheme", "https"},
+		{":authority", authority},
+		{":path", path},
+		{":protocol", "webtransport"},
+		{"sec-webtransport-http3-draft02", "1"},
+	}
+}
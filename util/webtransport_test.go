@@ -0,0 +1,106 @@
+package util
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+func TestVarint(t *testing.T) {
+	for _, v := range []uint64{0, 63, 64, 16383, 16384, 1<<30 - 1, 1 << 30, 1<<62 - 1} {
+		b := AppendVarint(nil, v)
+		got, err := ReadVarint(bytes.NewReader(b))
+		if err != nil || got != v {
+			t.Fatalf("expect %d but got %d: %v", v, got, err)
+		}
+	}
+	if b := AppendVarint(nil, 0x2b603742); len(b) != 4 {
+		t.Fatalf("expect 4 bytes but got %x", b)
+	}
+}
+
+func TestQPACK(t *testing.T) {
+	fields := WebTransportConnect("example.com:8972", "/rpcx")
+	got, err := DecodeQPACK(EncodeQPACK(fields))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fields, got) {
+		t.Fatalf("expect %v but got %v", fields, got)
+	}
+
+	// :status 200 is indexed by the static table
+	b := EncodeQPACK([]H3Field{{":status", "200"}})
+	if !bytes.Equal(b, []byte{0, 0, 0xd9}) {
+		t.Fatalf("unexpected encoding %x", b)
+	}
+
+	// literals with Huffman encoding, as browsers send them
+	b = []byte{0, 0, 0x51} // :path by a name reference of the static table
+	b = appendQPACKInt(b, 0x80, 7, hpack.HuffmanEncodeLength("/rpcx"))
+	b = hpack.AppendHuffmanString(b, "/rpcx")
+	b = appendQPACKInt(b, 0x28, 3, hpack.HuffmanEncodeLength(":protocol"))
+	b = hpack.AppendHuffmanString(b, ":protocol")
+	b = appendQPACKInt(b, 0x80, 7, hpack.HuffmanEncodeLength("webtransport"))
+	b = hpack.AppendHuffmanString(b, "webtransport")
+	got, err = DecodeQPACK(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsWebTransportConnect(append(got, H3Field{":method", "CONNECT"}), "/rpcx") {
+		t.Fatalf("unexpected fields %v", got)
+	}
+
+	// references of the dynamic table are not supported
+	for _, b := range [][]byte{{1, 0}, {0, 0, 0x80}, {0, 0, 0x40, 0}, {0, 0, 0x10}} {
+		if _, err := DecodeQPACK(b); err != ErrQPACKDynamicTable {
+			t.Fatalf("expect ErrQPACKDynamicTable of %x but got %v", b, err)
+		}
+	}
+	if _, err := DecodeQPACK([]byte{0, 0, 0xff}); err != ErrQPACKMalformed {
+		t.Fatalf("expect ErrQPACKMalformed but got %v", err)
+	}
+}
+
+func TestH3Frames(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteWebTransportSettings(&buf); err != nil {
+		t.Fatal(err)
+	}
+	typ, err := ReadVarint(&buf)
+	if err != nil || typ != H3ControlStream {
+		t.Fatalf("expect the control stream but got %d: %v", typ, err)
+	}
+	typ, payload, err := ReadH3Frame(&buf)
+	if err != nil || typ != H3FrameSettings {
+		t.Fatalf("expect settings but got %d: %v", typ, err)
+	}
+	settings := make(map[uint64]uint64)
+	r := bytes.NewReader(payload)
+	for r.Len() > 0 {
+		id, _ := ReadVarint(r)
+		settings[id], _ = ReadVarint(r)
+	}
+	if settings[settingEnableConnectProtocol] != 1 || settings[settingEnableWebTransport] != 1 || settings[settingQPACKMaxTableCapacity] != 0 {
+		t.Fatalf("unexpected settings %v", settings)
+	}
+
+	if err := WriteWebTransportResponse(&buf, "404"); err != nil {
+		t.Fatal(err)
+	}
+	fields, err := ReadH3Headers(&buf)
+	if err != nil || H3FieldValue(fields, ":status") != "404" {
+		t.Fatalf("unexpected response %v: %v", fields, err)
+	}
+
+	WriteH3Frame(&buf, H3FrameData, []byte("data"))
+	if _, err := ReadH3Headers(&buf); err == nil {
+		t.Fatal("expect an error of a data frame")
+	}
+	buf.Write(AppendVarint(AppendVarint(nil, H3FrameHeaders), maxH3FrameSize+1))
+	if _, _, err := ReadH3Frame(&buf); err != ErrH3FrameTooLarge {
+		t.Fatalf("expect ErrH3FrameTooLarge but got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package util
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrPipeUnsupported is the error of ListenPipe and DialPipe on platforms other than Windows.
+var ErrPipeUnsupported = errors.New("rpcx: windows named pipes are only supported on windows")
+
+// PipeNetwork is the network of Windows named pipes.
+const PipeNetwork = "winpipe"
+
+// PipeConfig configures listeners of Windows named pipes.
+type PipeConfig struct {
+	// SecurityDescriptor is the SDDL of the pipe, which selects who may connect, for example
+	// "D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GRGW;;;IU)" for LocalSystem, administrators and interactive users.
+	// Empty means the default descriptor, with which only LocalSystem, administrators and the owner
+	// of the server can connect, since clients need to write.
+	SecurityDescriptor string
+	// AllowRemoteClients accepts clients from other machines over SMB. They are rejected by default.
+	AllowRemoteClients bool
+	// InputBufferSize and OutputBufferSize are the buffer sizes of pipes, 64KB if zero.
+	InputBufferSize  int
+	OutputBufferSize int
+}
+
+// PipeIdentity is the Windows identity of the client of a named pipe.
+type PipeIdentity struct {
+	// User is the account of the client as DOMAIN\name, or empty if it can't be looked up.
+	User      string
+	SID       string
+	ProcessID uint32
+}
+
+// PipePath returns the path of the named pipe of name, which is \\.\pipe\name unless name is a path already.
+func PipePath(name string) string {
+	if strings.HasPrefix(name, `\\`) {
+		return name
+	}
+	return `\\.\pipe\` + name
+}
+
+// pipeAddr is the address of both ends of a pipe, which is its path.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return PipeNetwork }
+func (a pipeAddr) String() string  { return string(a) }
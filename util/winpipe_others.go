@@ -0,0 +1,18 @@
+// +build !windows
+
+package util
+
+import (
+	"context"
+	"net"
+)
+
+// ListenPipe listens on the Windows named pipe of path. It returns ErrPipeUnsupported on this platform.
+func ListenPipe(path string, config *PipeConfig) (net.Listener, error) {
+	return nil, ErrPipeUnsupported
+}
+
+// DialPipe connects to the Windows named pipe of path. It returns ErrPipeUnsupported on this platform.
+func DialPipe(ctx context.Context, path string) (net.Conn, error) {
+	return nil, ErrPipeUnsupported
+}
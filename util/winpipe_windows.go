@@ -0,0 +1,467 @@
+// +build windows
+
+package util
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const defaultPipeBufferSize = 64 * 1024
+
+var (
+	modkernel32 = windows.NewLazySystemDLL("kernel32.dll")
+	modadvapi32 = windows.NewLazySystemDLL("advapi32.dll")
+
+	procDisconnectNamedPipe         = modkernel32.NewProc("DisconnectNamedPipe")
+	procGetNamedPipeClientProcessId = modkernel32.NewProc("GetNamedPipeClientProcessId")
+	procImpersonateNamedPipeClient  = modadvapi32.NewProc("ImpersonateNamedPipeClient")
+)
+
+// pipeListener accepts clients of a named pipe. An instance of the pipe is always waiting for the next client,
+// so clients don't find the pipe missing between accepts.
+type pipeListener struct {
+	path   string
+	name   *uint16
+	config PipeConfig
+	sa     *windows.SecurityAttributes
+
+	closing   windows.Handle // event set by Close
+	closeOnce sync.Once
+
+	mu     sync.Mutex // serializes Accept with Close
+	next   windows.Handle
+	closed bool
+}
+
+// ListenPipe listens on the Windows named pipe of path, such as \\.\pipe\name. It fails if the pipe exists.
+func ListenPipe(path string, config *PipeConfig) (net.Listener, error) {
+	l := &pipeListener{path: path}
+	if config != nil {
+		l.config = *config
+	}
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	l.name = name
+	if l.config.SecurityDescriptor != "" {
+		sd, err := windows.SecurityDescriptorFromString(l.config.SecurityDescriptor)
+		if err != nil {
+			return nil, &net.OpError{Op: "listen", Net: PipeNetwork, Addr: pipeAddr(path), Err: err}
+		}
+		l.sa = &windows.SecurityAttributes{SecurityDescriptor: sd}
+		l.sa.Length = uint32(unsafe.Sizeof(*l.sa))
+	}
+
+	if l.next, err = l.createInstance(true); err != nil {
+		return nil, &net.OpError{Op: "listen", Net: PipeNetwork, Addr: pipeAddr(path), Err: err}
+	}
+	if l.closing, err = windows.CreateEvent(nil, 1, 0, nil); err != nil {
+		windows.CloseHandle(l.next)
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *pipeListener) createInstance(first bool) (windows.Handle, error) {
+	flags := uint32(windows.PIPE_ACCESS_DUPLEX | windows.FILE_FLAG_OVERLAPPED)
+	if first {
+		flags |= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+	mode := uint32(windows.PIPE_TYPE_BYTE | windows.PIPE_READMODE_BYTE | windows.PIPE_WAIT)
+	if !l.config.AllowRemoteClients {
+		mode |= windows.PIPE_REJECT_REMOTE_CLIENTS
+	}
+	in, out := l.config.InputBufferSize, l.config.OutputBufferSize
+	if in <= 0 {
+		in = defaultPipeBufferSize
+	}
+	if out <= 0 {
+		out = defaultPipeBufferSize
+	}
+	return windows.CreateNamedPipe(l.name, flags, mode, windows.PIPE_UNLIMITED_INSTANCES, uint32(out), uint32(in), 0, l.sa)
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for {
+		if l.closed {
+			return nil, &net.OpError{Op: "accept", Net: PipeNetwork, Addr: pipeAddr(l.path), Err: net.ErrClosed}
+		}
+		err := l.connect(l.next)
+		if err == windows.ERROR_NO_DATA {
+			// the client has closed the pipe before it is accepted
+			procDisconnectNamedPipe.Call(uintptr(l.next))
+			continue
+		}
+		if err != nil {
+			return nil, &net.OpError{Op: "accept", Net: PipeNetwork, Addr: pipeAddr(l.path), Err: err}
+		}
+
+		h := l.next
+		if l.next, err = l.createInstance(false); err != nil {
+			l.next = h
+			procDisconnectNamedPipe.Call(uintptr(h))
+			return nil, &net.OpError{Op: "accept", Net: PipeNetwork, Addr: pipeAddr(l.path), Err: err}
+		}
+		conn, err := newPipeConn(h, l.path, true)
+		if err != nil {
+			procDisconnectNamedPipe.Call(uintptr(h))
+			windows.CloseHandle(h)
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// connect waits for a client of the instance h until the listener is closed.
+func (l *pipeListener) connect(h windows.Handle) error {
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(event)
+
+	o := &windows.Overlapped{HEvent: event}
+	switch err := windows.ConnectNamedPipe(h, o); err {
+	case nil, windows.ERROR_PIPE_CONNECTED:
+		return nil
+	case windows.ERROR_IO_PENDING:
+	default:
+		return err
+	}
+
+	r, err := windows.WaitForMultipleObjects([]windows.Handle{event, l.closing}, false, windows.INFINITE)
+	if err != nil {
+		return err
+	}
+	if r != windows.WAIT_OBJECT_0 {
+		windows.CancelIoEx(h, o)
+	}
+	var n uint32
+	if err := windows.GetOverlappedResult(h, o, &n, true); err != nil {
+		if err == windows.ERROR_OPERATION_ABORTED {
+			return net.ErrClosed
+		}
+		return err
+	}
+	return nil
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		windows.SetEvent(l.closing)
+		l.mu.Lock()
+		l.closed = true
+		windows.CloseHandle(l.next)
+		windows.CloseHandle(l.closing)
+		l.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.path)
+}
+
+// DialPipe connects to the Windows named pipe of path, such as \\.\pipe\name, waiting for a free instance
+// of the pipe until ctx is done.
+func DialPipe(ctx context.Context, path string) (net.Conn, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		// the server identifies clients, but can't act as them
+		h, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING,
+			windows.FILE_FLAG_OVERLAPPED|windows.SECURITY_SQOS_PRESENT|windows.SECURITY_IDENTIFICATION, 0)
+		if err == nil {
+			conn, err := newPipeConn(h, path, false)
+			if err != nil {
+				windows.CloseHandle(h)
+			}
+			return conn, err
+		}
+		if err != windows.ERROR_PIPE_BUSY {
+			return nil, &net.OpError{Op: "dial", Net: PipeNetwork, Addr: pipeAddr(path), Err: err}
+		}
+
+		// all instances are connected, the server creates another one soon
+		select {
+		case <-ctx.Done():
+			return nil, &net.OpError{Op: "dial", Net: PipeNetwork, Addr: pipeAddr(path), Err: ctx.Err()}
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// pipeDirection is the state of reads or writes of a pipe.
+type pipeDirection struct {
+	mu       sync.Mutex     // serializes operations, which share event
+	event    windows.Handle // manual-reset event of overlapped operations
+	wake     windows.Handle // auto-reset event set when deadline is changed
+	deadline int64          // unix nanoseconds, or zero
+}
+
+func (d *pipeDirection) init() (err error) {
+	if d.event, err = windows.CreateEvent(nil, 1, 0, nil); err != nil {
+		return err
+	}
+	if d.wake, err = windows.CreateEvent(nil, 0, 0, nil); err != nil {
+		windows.CloseHandle(d.event)
+	}
+	return err
+}
+
+func (d *pipeDirection) close() {
+	windows.CloseHandle(d.event)
+	windows.CloseHandle(d.wake)
+}
+
+// pipeConn is a connected pipe. Deadlines are emulated by canceling overlapped operations when they pass.
+type pipeConn struct {
+	h      windows.Handle
+	path   string
+	server bool
+
+	closing   windows.Handle // event set by Close
+	closeOnce sync.Once
+	closed    int32
+	handlesMu sync.RWMutex // held by Close to close events, which setDeadline sets otherwise
+
+	rd, wd pipeDirection
+
+	identityOnce sync.Once
+	identity     PipeIdentity
+	identityErr  error
+}
+
+func newPipeConn(h windows.Handle, path string, server bool) (*pipeConn, error) {
+	c := &pipeConn{h: h, path: path, server: server}
+	var err error
+	if c.closing, err = windows.CreateEvent(nil, 1, 0, nil); err != nil {
+		return nil, err
+	}
+	if err = c.rd.init(); err != nil {
+		windows.CloseHandle(c.closing)
+		return nil, err
+	}
+	if err = c.wd.init(); err != nil {
+		c.rd.close()
+		windows.CloseHandle(c.closing)
+		return nil, err
+	}
+	return c, nil
+}
+
+// do runs the overlapped operation op in d, and waits for it until the deadline of d passes or the pipe is closed.
+func (c *pipeConn) do(d *pipeDirection, op func(o *windows.Overlapped) error) (int, error) {
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return 0, net.ErrClosed
+	}
+	if deadline := atomic.LoadInt64(&d.deadline); deadline != 0 && time.Now().UnixNano() >= deadline {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	windows.ResetEvent(d.event)
+	o := &windows.Overlapped{HEvent: d.event}
+	if err := op(o); err != nil && err != windows.ERROR_IO_PENDING {
+		return 0, err
+	}
+
+	var canceled error
+	for canceled == nil {
+		timeout := uint32(windows.INFINITE)
+		if deadline := atomic.LoadInt64(&d.deadline); deadline != 0 {
+			remaining := time.Duration(deadline - time.Now().UnixNano())
+			if remaining <= 0 {
+				canceled = os.ErrDeadlineExceeded
+				break
+			}
+			ms := (remaining + time.Millisecond - 1) / time.Millisecond
+			if ms >= windows.INFINITE {
+				ms = windows.INFINITE - 1
+			}
+			timeout = uint32(ms)
+		}
+
+		r, err := windows.WaitForMultipleObjects([]windows.Handle{d.event, d.wake, c.closing}, false, timeout)
+		if err != nil {
+			canceled = err
+			break
+		}
+		switch r {
+		case windows.WAIT_OBJECT_0:
+			var n uint32
+			err := windows.GetOverlappedResult(c.h, o, &n, false)
+			return int(n), err
+		case windows.WAIT_OBJECT_0 + 2:
+			canceled = net.ErrClosed
+		}
+		// the deadline is changed or may have passed
+	}
+
+	windows.CancelIoEx(c.h, o)
+	var n uint32
+	if err := windows.GetOverlappedResult(c.h, o, &n, true); err != windows.ERROR_OPERATION_ABORTED {
+		// it is completed before it is canceled
+		return int(n), err
+	}
+	return int(n), canceled
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	c.rd.mu.Lock()
+	defer c.rd.mu.Unlock()
+
+	n, err := c.do(&c.rd, func(o *windows.Overlapped) error {
+		return windows.ReadFile(c.h, b, nil, o)
+	})
+	switch err {
+	case nil:
+		if n == 0 {
+			return 0, io.EOF
+		}
+		return n, nil
+	case windows.ERROR_BROKEN_PIPE, windows.ERROR_PIPE_NOT_CONNECTED, windows.ERROR_NO_DATA:
+		return n, io.EOF
+	}
+	return n, &net.OpError{Op: "read", Net: PipeNetwork, Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: err}
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	c.wd.mu.Lock()
+	defer c.wd.mu.Unlock()
+
+	var written int
+	for written < len(b) {
+		p := b[written:]
+		n, err := c.do(&c.wd, func(o *windows.Overlapped) error {
+			return windows.WriteFile(c.h, p, nil, o)
+		})
+		written += n
+		if err != nil {
+			return written, &net.OpError{Op: "write", Net: PipeNetwork, Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: err}
+		}
+	}
+	return written, nil
+}
+
+// Close closes the pipe, canceling pending reads and writes.
+func (c *pipeConn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.StoreInt32(&c.closed, 1)
+		windows.SetEvent(c.closing)
+		c.rd.mu.Lock()
+		c.wd.mu.Lock()
+		// the handle is closed without DisconnectNamedPipe, so the peer can read data written before
+		c.handlesMu.Lock()
+		windows.CloseHandle(c.h)
+		windows.CloseHandle(c.closing)
+		c.rd.close()
+		c.wd.close()
+		c.handlesMu.Unlock()
+		c.wd.mu.Unlock()
+		c.rd.mu.Unlock()
+	})
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return pipeAddr(c.path) }
+func (c *pipeConn) RemoteAddr() net.Addr { return pipeAddr(c.path) }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	return c.setDeadline(t, &c.rd, &c.wd)
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	return c.setDeadline(t, &c.rd)
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	return c.setDeadline(t, &c.wd)
+}
+
+// setDeadline sets the deadline of directions, and wakes their pending operations to wait for it.
+func (c *pipeConn) setDeadline(t time.Time, directions ...*pipeDirection) error {
+	var deadline int64
+	if !t.IsZero() {
+		deadline = t.UnixNano()
+	}
+	c.handlesMu.RLock()
+	defer c.handlesMu.RUnlock()
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return net.ErrClosed
+	}
+	for _, d := range directions {
+		atomic.StoreInt64(&d.deadline, deadline)
+		windows.SetEvent(d.wake)
+	}
+	return nil
+}
+
+// PipeIdentity returns the identity of the client of the pipe accepted by a listener of ListenPipe.
+func (c *pipeConn) PipeIdentity() (PipeIdentity, error) {
+	c.identityOnce.Do(func() {
+		if !c.server {
+			c.identityErr = windows.ERROR_INVALID_FUNCTION
+			return
+		}
+		c.identity, c.identityErr = pipeClientIdentity(c.h)
+	})
+	return c.identity, c.identityErr
+}
+
+// pipeClientIdentity looks up the client of the pipe h by the token of its impersonation.
+func pipeClientIdentity(h windows.Handle) (PipeIdentity, error) {
+	var id PipeIdentity
+	if r, _, err := procGetNamedPipeClientProcessId.Call(uintptr(h), uintptr(unsafe.Pointer(&id.ProcessID))); r == 0 {
+		return id, err
+	}
+
+	// the thread impersonates the client until RevertToSelf, so it is not shared with other goroutines
+	runtime.LockOSThread()
+	if r, _, err := procImpersonateNamedPipeClient.Call(uintptr(h)); r == 0 {
+		runtime.UnlockOSThread()
+		return id, err
+	}
+	var token windows.Token
+	thread, err := windows.GetCurrentThread()
+	if err == nil {
+		err = windows.OpenThreadToken(thread, windows.TOKEN_QUERY, true, &token)
+	}
+	if windows.RevertToSelf() != nil {
+		// the thread keeps impersonating, so it exits with the goroutine instead of running others
+		return id, err
+	}
+	runtime.UnlockOSThread()
+	if err != nil {
+		return id, err
+	}
+	defer token.Close()
+
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return id, err
+	}
+	id.SID = user.User.Sid.String()
+	if account, domain, _, err := user.User.Sid.LookupAccount(""); err == nil {
+		id.User = domain + `\` + account
+	}
+	return id, nil
+}